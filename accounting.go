@@ -0,0 +1,590 @@
+package libnetwork
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/libnetwork/datastore"
+	"github.com/docker/libnetwork/diagnostic"
+	"github.com/docker/libnetwork/driverapi"
+	"github.com/docker/libnetwork/internal/caller"
+	"github.com/docker/libnetwork/types"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	accountingKeyPrefix = "accounting"
+	// The accounting periods are the calendar months, in UTC
+	accountingPeriodLayout = "2006-01"
+)
+
+// The actions taken on an endpoint exceeding its quota, lifted at the start
+// of the next month
+const (
+	// QuotaActionEvent only publishes the EventQuotaExceeded event
+	QuotaActionEvent = "event"
+	// QuotaActionThrottle limits the traffic of the endpoint to the
+	// throttle rate of the quota
+	QuotaActionThrottle = "throttle"
+	// QuotaActionQuarantine drops all the traffic of the endpoint
+	QuotaActionQuarantine = "quarantine"
+)
+
+// accountingPaths2Func are the diagnostic handlers of the traffic
+// accounting of the endpoints
+var accountingPaths2Func = map[string]diagnostic.HTTPHandlerFunc{
+	"/accounting": accountingDiag,
+}
+
+// Quota caps the bytes an endpoint receives and sends in a calendar month
+type Quota struct {
+	Bytes uint64 `json:"bytes"`
+	// Action is taken once the cap is exceeded, QuotaActionEvent when empty
+	Action string `json:"action,omitempty"`
+	// ThrottleRate is the bytes per second, in each direction, the
+	// QuotaActionThrottle action limits the endpoint to
+	ThrottleRate uint64 `json:"throttle_rate,omitempty"`
+}
+
+// EndpointUsage is the traffic of an endpoint in the current month, as
+// counted on its interface in the sandbox
+type EndpointUsage struct {
+	NetworkID  string    `json:"network_id"`
+	EndpointID string    `json:"endpoint_id"`
+	Period     string    `json:"period"`
+	RxBytes    uint64    `json:"rx_bytes"`
+	TxBytes    uint64    `json:"tx_bytes"`
+	Quota      *Quota    `json:"quota,omitempty"`
+	Exceeded   bool      `json:"exceeded"`
+	Enforced   string    `json:"enforced,omitempty"`
+	Updated    time.Time `json:"updated"`
+}
+
+// Bytes returns the bytes received and sent by the endpoint in the month
+func (u *EndpointUsage) Bytes() uint64 {
+	return u.RxBytes + u.TxBytes
+}
+
+func (u *EndpointUsage) String() string {
+	quota := "none"
+	if u.Quota != nil {
+		quota = strconv.FormatUint(u.Quota.Bytes, 10)
+	}
+	s := fmt.Sprintf("nid:%s eid:%s period:%s rx:%d tx:%d quota:%s", u.NetworkID, u.EndpointID, u.Period, u.RxBytes, u.TxBytes, quota)
+	if u.Exceeded {
+		s += " exceeded"
+	}
+	if u.Enforced != "" {
+		s += " enforced:" + u.Enforced
+	}
+	return s
+}
+
+// accountingRecord is the traffic of an endpoint in the current month, kept
+// in the local datastore across the restarts. LastRx and LastTx are the
+// interface counters of the last scrape; they start over when the
+// interface is recreated.
+type accountingRecord struct {
+	EndpointUsage
+	LastRx   uint64 `json:"last_rx"`
+	LastTx   uint64 `json:"last_tx"`
+	dbIndex  uint64
+	dbExists bool
+	sync.Mutex
+}
+
+func (r *accountingRecord) Key() []string {
+	return []string{accountingKeyPrefix, r.EndpointID}
+}
+
+func (r *accountingRecord) KeyPrefix() []string {
+	return []string{accountingKeyPrefix}
+}
+
+func (r *accountingRecord) Value() []byte {
+	r.Lock()
+	defer r.Unlock()
+
+	b, err := json.Marshal(r)
+	if err != nil {
+		return nil
+	}
+	return b
+}
+
+func (r *accountingRecord) SetValue(value []byte) error {
+	r.Lock()
+	defer r.Unlock()
+
+	return json.Unmarshal(value, r)
+}
+
+func (r *accountingRecord) Index() uint64 {
+	r.Lock()
+	defer r.Unlock()
+	return r.dbIndex
+}
+
+func (r *accountingRecord) SetIndex(index uint64) {
+	r.Lock()
+	r.dbIndex = index
+	r.dbExists = true
+	r.Unlock()
+}
+
+func (r *accountingRecord) Exists() bool {
+	r.Lock()
+	defer r.Unlock()
+	return r.dbExists
+}
+
+func (r *accountingRecord) Skip() bool {
+	return false
+}
+
+func (r *accountingRecord) New() datastore.KVObject {
+	return &accountingRecord{}
+}
+
+func (r *accountingRecord) CopyTo(o datastore.KVObject) error {
+	r.Lock()
+	defer r.Unlock()
+
+	dst := o.(*accountingRecord)
+	dst.EndpointUsage = r.EndpointUsage
+	if r.Quota != nil {
+		q := *r.Quota
+		dst.Quota = &q
+	}
+	dst.LastRx = r.LastRx
+	dst.LastTx = r.LastTx
+	dst.dbIndex = r.dbIndex
+	dst.dbExists = r.dbExists
+	return nil
+}
+
+func (r *accountingRecord) DataScope() string {
+	return datastore.LocalScope
+}
+
+// usage returns a copy of the usage of the record
+func (r *accountingRecord) usage() *EndpointUsage {
+	u := r.EndpointUsage
+	if r.Quota != nil {
+		q := *r.Quota
+		u.Quota = &q
+	}
+	return &u
+}
+
+// accounting scrapes the interface counters of the endpoints for their
+// monthly usage. The records and the datastore writes are serialized by
+// the mutex.
+type accounting struct {
+	mu      sync.Mutex
+	records map[string]*accountingRecord
+	stop    chan struct{}
+}
+
+// counterDelta returns the bytes an interface counter went through since
+// the last scrape, the whole counter when it started over
+func counterDelta(last, cur uint64) uint64 {
+	if cur < last {
+		return cur
+	}
+	return cur - last
+}
+
+// validateQuota checks the quota and that the driver of the network can
+// enforce its action
+func validateQuota(n *network, q *Quota) error {
+	if q.Bytes == 0 {
+		return types.BadRequestErrorf("invalid quota: expected a number of bytes")
+	}
+	var supported bool
+	d, err := n.driver(true)
+	if err != nil {
+		return err
+	}
+	switch q.Action {
+	case "", QuotaActionEvent:
+		if q.ThrottleRate != 0 {
+			return types.BadRequestErrorf("the throttle rate of the quota requires the %s action", QuotaActionThrottle)
+		}
+		return nil
+	case QuotaActionThrottle:
+		if q.ThrottleRate == 0 {
+			return types.BadRequestErrorf("the %s action of the quota requires a throttle rate", QuotaActionThrottle)
+		}
+		_, supported = d.(driverapi.Throttler)
+	case QuotaActionQuarantine:
+		_, supported = d.(driverapi.Quarantiner)
+	default:
+		return types.BadRequestErrorf("invalid quota action %q: expected %s, %s or %s", q.Action, QuotaActionEvent, QuotaActionThrottle, QuotaActionQuarantine)
+	}
+	if !supported {
+		return types.NotImplementedErrorf("the %s driver does not support the %s action of the quotas", n.Type(), q.Action)
+	}
+	return nil
+}
+
+// startAccounting loads the accounting records from the local datastore
+// and scrapes the counters of the endpoints at the interval
+func (c *controller) startAccounting(interval time.Duration) {
+	c.accounting.records = map[string]*accountingRecord{}
+	if store := c.getStore(datastore.LocalScope); store != nil {
+		kvol, err := store.List(datastore.Key(accountingKeyPrefix), &accountingRecord{})
+		if err != nil && err != datastore.ErrKeyNotFound {
+			logrus.Warnf("Failed to load the accounting records: %v", err)
+		}
+		for _, kvo := range kvol {
+			r := kvo.(*accountingRecord)
+			c.accounting.records[r.EndpointID] = r
+		}
+	}
+	c.accounting.stop = make(chan struct{})
+	go c.runAccounting(interval, c.accounting.stop)
+}
+
+func (c *controller) runAccounting(interval time.Duration, stopCh chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			c.scrapeAccounting(now)
+		case <-stopCh:
+			return
+		}
+	}
+}
+
+func (c *controller) stopAccounting() {
+	if c.accounting.stop != nil {
+		close(c.accounting.stop)
+	}
+}
+
+// scrapeAccounting adds the traffic of the interfaces of the endpoints of
+// the sandboxes since the last scrape to their usage
+func (c *controller) scrapeAccounting(now time.Time) {
+	c.Lock()
+	sboxes := make([]*sandbox, 0, len(c.sandboxes))
+	for _, sb := range c.sandboxes {
+		sboxes = append(sboxes, sb)
+	}
+	c.Unlock()
+
+	period := now.UTC().Format(accountingPeriodLayout)
+	for _, sb := range sboxes {
+		sb.Lock()
+		osb := sb.osSbox
+		sb.Unlock()
+		if osb == nil {
+			continue
+		}
+		counters := map[string]*types.InterfaceStatistics{}
+		for _, i := range osb.Info().Interfaces() {
+			s, err := i.Statistics()
+			if err != nil {
+				logrus.Debugf("Failed to get the statistics of interface %s of sandbox %.7s: %v", i.DstName(), sb.ID(), err)
+				continue
+			}
+			counters[i.SrcName()] = s
+		}
+		for _, ep := range sb.getConnectedEndpoints() {
+			ep.Lock()
+			var srcName string
+			if ep.iface != nil {
+				srcName = ep.iface.srcName
+			}
+			ep.Unlock()
+			if s, ok := counters[srcName]; ok && srcName != "" {
+				c.account(ep, s, period, now)
+			}
+		}
+	}
+}
+
+// account adds the traffic of the counters to the usage of the endpoint,
+// starting a new month over and enforcing the quota once exceeded
+func (c *controller) account(ep *endpoint, s *types.InterfaceStatistics, period string, now time.Time) {
+	n := ep.getNetwork()
+
+	c.accounting.mu.Lock()
+	defer c.accounting.mu.Unlock()
+
+	r, ok := c.accounting.records[ep.ID()]
+	if !ok {
+		r = &accountingRecord{EndpointUsage: EndpointUsage{NetworkID: n.ID(), EndpointID: ep.ID(), Period: period}}
+		c.accounting.records[ep.ID()] = r
+	}
+	if r.Period != period {
+		if r.Enforced != "" {
+			if err := c.liftQuotaAction(n, ep.ID(), r.Enforced); err != nil {
+				logrus.Warnf("Failed to lift the %s quota action of endpoint %.7s: %v", r.Enforced, ep.ID(), err)
+			} else {
+				c.publishQuotaEvent(EventQuotaReset, n, ep, r, "")
+			}
+		}
+		r.Period = period
+		r.RxBytes, r.TxBytes = 0, 0
+		r.Exceeded = false
+		r.Enforced = ""
+	}
+	r.RxBytes += counterDelta(r.LastRx, s.RxBytes)
+	r.TxBytes += counterDelta(r.LastTx, s.TxBytes)
+	r.LastRx, r.LastTx = s.RxBytes, s.TxBytes
+	r.Updated = now
+
+	if q := r.Quota; q != nil && !r.Exceeded && r.Bytes() > q.Bytes {
+		r.Exceeded = true
+		action := q.Action
+		if action == "" {
+			action = QuotaActionEvent
+		}
+		if err := c.enforceQuotaAction(n, ep.ID(), q); err != nil {
+			logrus.Warnf("Failed to enforce the %s quota action of endpoint %.7s: %v", action, ep.ID(), err)
+		} else if action != QuotaActionEvent {
+			r.Enforced = action
+		}
+		logrus.Infof("Endpoint %.7s exceeded its quota of %d bytes with %d bytes in %s", ep.ID(), q.Bytes, r.Bytes(), r.Period)
+		c.publishQuotaEvent(EventQuotaExceeded, n, ep, r, action)
+	}
+
+	if err := c.storeAccounting(r); err != nil {
+		logrus.Warnf("Failed to store the accounting record of endpoint %.7s: %v", ep.ID(), err)
+	}
+}
+
+func (c *controller) publishQuotaEvent(t EventType, n *network, ep *endpoint, r *accountingRecord, action string) {
+	c.publish(Event{
+		Type:         t,
+		NetworkID:    n.ID(),
+		NetworkName:  n.Name(),
+		EndpointID:   ep.ID(),
+		EndpointName: ep.Name(),
+		Count:        r.Bytes(),
+		Reason:       action,
+	})
+}
+
+// enforceQuotaAction takes the action of the exceeded quota on the endpoint
+func (c *controller) enforceQuotaAction(n *network, eid string, q *Quota) error {
+	switch q.Action {
+	case QuotaActionThrottle:
+		d, err := n.driver(true)
+		if err != nil {
+			return err
+		}
+		t, ok := d.(driverapi.Throttler)
+		if !ok {
+			return types.NotImplementedErrorf("the %s driver does not support the throttling of the endpoints", n.Type())
+		}
+		return t.ThrottleEndpoint(n.ID(), eid, q.ThrottleRate)
+	case QuotaActionQuarantine:
+		return c.QuarantineEndpoint(n.ID(), eid, &Quarantine{Action: driverapi.QuarantineDrop})
+	}
+	return nil
+}
+
+// liftQuotaAction lets the traffic of the endpoint through again
+func (c *controller) liftQuotaAction(n *network, eid, action string) error {
+	switch action {
+	case QuotaActionThrottle:
+		d, err := n.driver(true)
+		if err != nil {
+			return err
+		}
+		t, ok := d.(driverapi.Throttler)
+		if !ok {
+			return nil
+		}
+		return t.ThrottleEndpoint(n.ID(), eid, 0)
+	case QuotaActionQuarantine:
+		return c.QuarantineEndpoint(n.ID(), eid, nil)
+	}
+	return nil
+}
+
+// storeAccounting writes the record to the local datastore, if any, over
+// the version in it
+func (c *controller) storeAccounting(r *accountingRecord) error {
+	store := c.getStore(datastore.LocalScope)
+	if store == nil {
+		return nil
+	}
+	for {
+		err := c.updateToStore(r)
+		if err != datastore.ErrKeyModified {
+			return err
+		}
+		tmp := &accountingRecord{}
+		if err := store.GetObject(datastore.Key(r.Key()...), tmp); err != nil && err != datastore.ErrKeyNotFound {
+			return err
+		}
+		r.SetIndex(tmp.Index())
+		if !tmp.Exists() {
+			r.Lock()
+			r.dbExists = false
+			r.Unlock()
+		}
+	}
+}
+
+// SetEndpointQuota caps the traffic of the endpoint in a calendar month, or
+// removes the cap when the quota is nil. The action of the previous quota,
+// if taken, is lifted; the new quota is checked at the next scrape.
+func (c *controller) SetEndpointQuota(networkID, endpointID string, q *Quota) error {
+	if c.accounting.stop == nil {
+		return types.ForbiddenErrorf("the traffic accounting of the endpoints is disabled")
+	}
+	nw, err := c.NetworkByID(networkID)
+	if err != nil {
+		return err
+	}
+	n := nw.(*network)
+	if _, err := n.EndpointByID(endpointID); err != nil {
+		return err
+	}
+	if q != nil {
+		if err := validateQuota(n, q); err != nil {
+			return err
+		}
+		quota := *q
+		q = &quota
+	}
+
+	c.accounting.mu.Lock()
+	defer c.accounting.mu.Unlock()
+
+	r, ok := c.accounting.records[endpointID]
+	if !ok {
+		r = &accountingRecord{EndpointUsage: EndpointUsage{
+			NetworkID:  networkID,
+			EndpointID: endpointID,
+			Period:     time.Now().UTC().Format(accountingPeriodLayout),
+		}}
+		c.accounting.records[endpointID] = r
+	}
+	if r.Enforced != "" {
+		if err := c.liftQuotaAction(n, endpointID, r.Enforced); err != nil {
+			return err
+		}
+		r.Enforced = ""
+	}
+	r.Quota = q
+	r.Exceeded = false
+	return c.storeAccounting(r)
+}
+
+// EndpointUsage returns the traffic of the endpoint in the current month
+// along with its quota
+func (c *controller) EndpointUsage(networkID, endpointID string) (*EndpointUsage, error) {
+	if c.accounting.stop == nil {
+		return nil, types.ForbiddenErrorf("the traffic accounting of the endpoints is disabled")
+	}
+	c.accounting.mu.Lock()
+	defer c.accounting.mu.Unlock()
+
+	r, ok := c.accounting.records[endpointID]
+	if !ok || r.NetworkID != networkID {
+		return nil, types.NotFoundErrorf("no traffic accounted for endpoint %s on network %s", endpointID, networkID)
+	}
+	return r.usage(), nil
+}
+
+// accountingUsage returns the usage of all the endpoints, or of the ones of
+// the network when set
+func (c *controller) accountingUsage(networkID string) []*EndpointUsage {
+	c.accounting.mu.Lock()
+	defer c.accounting.mu.Unlock()
+
+	l := make([]*EndpointUsage, 0, len(c.accounting.records))
+	for _, r := range c.accounting.records {
+		if networkID == "" || r.NetworkID == networkID {
+			l = append(l, r.usage())
+		}
+	}
+	sort.Slice(l, func(i, j int) bool { return l[i].EndpointID < l[j].EndpointID })
+	return l
+}
+
+// forgetAccounting drops the accounting record of the deleted endpoint
+func (c *controller) forgetAccounting(eid string) {
+	c.accounting.mu.Lock()
+	defer c.accounting.mu.Unlock()
+
+	r, ok := c.accounting.records[eid]
+	if !ok {
+		return
+	}
+	delete(c.accounting.records, eid)
+	if c.getStore(datastore.LocalScope) == nil {
+		return
+	}
+	if err := c.deleteFromStore(r); err != nil && err != datastore.ErrKeyNotFound {
+		logrus.Warnf("Failed to delete the accounting record of endpoint %.7s: %v", eid, err)
+	}
+}
+
+type accountingResult struct {
+	Endpoints []*EndpointUsage `json:"endpoints"`
+}
+
+func (r *accountingResult) String() string {
+	var b strings.Builder
+	for _, u := range r.Endpoints {
+		fmt.Fprintln(&b, u)
+	}
+	return b.String()
+}
+
+func accountingDiag(ctx interface{}, w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	diagnostic.DebugHTTPForm(r)
+	_, json := diagnostic.ParseHTTPFormOptions(r)
+
+	// audit logs
+	log := logrus.WithFields(logrus.Fields{"component": "diagnostic", "remoteIP": r.RemoteAddr, "method": caller.Name(0), "url": r.URL.String()})
+	log.Info("accounting")
+
+	c, ok := ctx.(*controller)
+	if !ok {
+		diagnostic.HTTPReply(w, diagnostic.FailCommand(fmt.Errorf("controller not available")), json)
+		return
+	}
+
+	const usage = "[nid=<network id> [eid=<endpoint id> bytes=<quota, 0 to remove> action=<event|throttle|quarantine> rate=<bytes per second>]]"
+	nid := r.Form.Get("nid")
+	if eid := r.Form.Get("eid"); eid != "" {
+		bytes, err := strconv.ParseUint(r.Form.Get("bytes"), 10, 64)
+		if err != nil || nid == "" {
+			diagnostic.HTTPReply(w, diagnostic.WrongCommand("accounting", usage), json)
+			return
+		}
+		var q *Quota
+		if bytes > 0 {
+			q = &Quota{Bytes: bytes, Action: r.Form.Get("action")}
+			if v := r.Form.Get("rate"); v != "" {
+				if q.ThrottleRate, err = strconv.ParseUint(v, 10, 64); err != nil {
+					diagnostic.HTTPReply(w, diagnostic.WrongCommand("accounting", usage), json)
+					return
+				}
+			}
+		}
+		if err := c.SetEndpointQuota(nid, eid, q); err != nil {
+			log.WithError(err).Error("accounting failed")
+			diagnostic.HTTPReply(w, diagnostic.FailCommand(err), json)
+			return
+		}
+	}
+	log.Info("accounting done")
+	diagnostic.HTTPReply(w, diagnostic.CommandSucceed(&accountingResult{Endpoints: c.accountingUsage(nid)}), json)
+}
@@ -0,0 +1,45 @@
+package libnetwork
+
+import (
+	"testing"
+)
+
+func TestCounterDelta(t *testing.T) {
+	if d := counterDelta(100, 250); d != 150 {
+		t.Fatalf("unexpected delta %d", d)
+	}
+	if d := counterDelta(1000, 40); d != 40 {
+		t.Fatalf("unexpected delta %d of the counter started over", d)
+	}
+}
+
+func TestAccountingRecordCopy(t *testing.T) {
+	r := &accountingRecord{EndpointUsage: EndpointUsage{
+		NetworkID:  "n1",
+		EndpointID: "ep1",
+		Period:     "2026-10",
+		RxBytes:    10,
+		TxBytes:    20,
+		Quota:      &Quota{Bytes: 25, Action: QuotaActionThrottle, ThrottleRate: 1024},
+	}}
+	dst := &accountingRecord{}
+	if err := r.CopyTo(dst); err != nil {
+		t.Fatal(err)
+	}
+	dst.Quota.Bytes = 50
+	if r.Quota.Bytes != 25 {
+		t.Fatal("the copy shares the quota of the record")
+	}
+	u := r.usage()
+	if u.Bytes() != 30 || u.Quota == r.Quota {
+		t.Fatalf("unexpected usage %v", u)
+	}
+
+	restored := &accountingRecord{}
+	if err := restored.SetValue(r.Value()); err != nil {
+		t.Fatal(err)
+	}
+	if restored.EndpointID != "ep1" || restored.Quota == nil || restored.Quota.ThrottleRate != 1024 {
+		t.Fatalf("unexpected restored record %v", restored.usage())
+	}
+}
@@ -292,6 +292,9 @@ func (c *controller) agentInit(listenAddr, bindAddrOrInterface, advertiseAddr, d
 		logrus.Debugf("Control plane MTU: %d will initialize NetworkDB with: %d",
 			c.Config().Daemon.NetworkControlPlaneMTU, netDBConf.PacketBufferSize)
 	}
+	netDBConf.BroadcastQueueLimit = c.Config().Daemon.NetworkDBQueueLimit
+	netDBConf.BroadcastQueuePolicy = c.Config().Daemon.NetworkDBQueuePolicy
+	netDBConf.SnapshotPort = c.Config().Daemon.NetworkDBSnapshotPort
 	nDB, err := networkdb.New(netDBConf)
 	if err != nil {
 		return err
@@ -931,6 +934,13 @@ func (c *controller) handleEpTableEvent(ev events.Event) {
 		return
 	}
 
+	// The service bindings of the global networks keep their last known
+	// good backends while fenced
+	if svcID != "" && c.holdServiceBinding(nid, eid, ev) {
+		logrus.Debugf("handleEpTableEvent held %s R:%v", eid, epRec)
+		return
+	}
+
 	switch ev.(type) {
 	case networkdb.CreateEvent:
 		logrus.Debugf("handleEpTableEvent ADD %s R:%v", eid, epRec)
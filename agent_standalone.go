@@ -0,0 +1,174 @@
+package libnetwork
+
+import (
+	"context"
+	"net"
+	"strconv"
+
+	networktypes "github.com/docker/docker/api/types/network"
+	"github.com/docker/libnetwork/cluster"
+	"github.com/docker/libnetwork/types"
+	"github.com/sirupsen/logrus"
+)
+
+// defaultGossipPort is the port networkdb gossips on, the join addresses
+// are dialed on it
+const defaultGossipPort = 7946
+
+// AgentConfig is the configuration of an agent started with StartAgent,
+// without a cluster manager
+type AgentConfig struct {
+	// ListenAddr is the address the gossip listens on, all the
+	// addresses when empty
+	ListenAddr string
+	// AdvertiseAddr is the address advertised to the peers
+	AdvertiseAddr string
+	// DataPathAddr is the address of the overlay data path, the
+	// advertise address when empty
+	DataPathAddr string
+	// JoinAddrs are the addresses of the peers to join
+	JoinAddrs []string
+	// Keys are the gossip and IPSec keys, three per subsystem as for
+	// SetKeys, which rotates them once the agent is started
+	Keys []*types.EncryptionKey
+}
+
+// standaloneAgent is the cluster provider of an agent started with
+// StartAgent. The agent has no manager, so the multi-host networks have
+// to be created on every node by the caller, with the same id and
+// NetworkOptionDynamic.
+type standaloneAgent struct {
+	cfg    AgentConfig
+	events chan cluster.ConfigEventType
+}
+
+// StartAgent starts the agent, networkdb, the service bindings and the
+// overlay peer exchange, without Swarm. It returns once the agent is
+// initialized.
+func (c *controller) StartAgent(cfg AgentConfig) error {
+	if cfg.AdvertiseAddr == "" {
+		return types.BadRequestErrorf("an advertise address is required to start the agent")
+	}
+	if len(cfg.Keys) == 0 {
+		return types.BadRequestErrorf("the gossip keys are required to start the agent")
+	}
+
+	c.Lock()
+	provider := c.cfg.Daemon.ClusterProvider
+	c.Unlock()
+	if provider != nil {
+		return types.ForbiddenErrorf("the agent is already managed by a cluster provider")
+	}
+
+	if err := c.SetKeys(cfg.Keys); err != nil {
+		return types.BadRequestErrorf("invalid agent keys: %v", err)
+	}
+
+	a := &standaloneAgent{
+		cfg:    cfg,
+		events: make(chan cluster.ConfigEventType, 1),
+	}
+	c.SetClusterProvider(a)
+
+	// Get the completion channels before the agent goroutine does
+	c.agentOperationStart()
+	c.Lock()
+	initDone, stopDone := c.agentInitDone, c.agentStopDone
+	c.Unlock()
+
+	a.events <- cluster.EventNetworkKeysAvailable
+	select {
+	case <-initDone:
+	case <-stopDone:
+	}
+
+	if c.getAgent() == nil {
+		c.stopStandaloneAgent(a)
+		return types.InternalErrorf("failed to initialize the agent advertising %s", cfg.AdvertiseAddr)
+	}
+
+	logrus.Infof("Started the standalone agent advertising %s", cfg.AdvertiseAddr)
+	return nil
+}
+
+// StopAgent stops the agent started with StartAgent
+func (c *controller) StopAgent() error {
+	c.Lock()
+	a, ok := c.cfg.Daemon.ClusterProvider.(*standaloneAgent)
+	c.Unlock()
+	if !ok {
+		return types.ForbiddenErrorf("the agent was not started by StartAgent")
+	}
+
+	c.stopStandaloneAgent(a)
+	logrus.Infof("Stopped the standalone agent")
+	return nil
+}
+
+func (c *controller) stopStandaloneAgent(a *standaloneAgent) {
+	c.agentOperationStart()
+	c.Lock()
+	stopDone := c.agentStopDone
+	c.Unlock()
+
+	a.events <- cluster.EventNodeLeave
+	<-stopDone
+}
+
+func (a *standaloneAgent) IsManager() bool {
+	return false
+}
+
+func (a *standaloneAgent) IsAgent() bool {
+	return true
+}
+
+func (a *standaloneAgent) GetLocalAddress() string {
+	return a.cfg.AdvertiseAddr
+}
+
+func (a *standaloneAgent) GetListenAddress() string {
+	return net.JoinHostPort(a.cfg.ListenAddr, strconv.Itoa(defaultGossipPort))
+}
+
+func (a *standaloneAgent) GetAdvertiseAddress() string {
+	return a.cfg.AdvertiseAddr
+}
+
+func (a *standaloneAgent) GetDataPathAddress() string {
+	if a.cfg.DataPathAddr != "" {
+		return a.cfg.DataPathAddr
+	}
+	return a.cfg.AdvertiseAddr
+}
+
+func (a *standaloneAgent) GetRemoteAddressList() []string {
+	addrs := make([]string, 0, len(a.cfg.JoinAddrs))
+	for _, addr := range a.cfg.JoinAddrs {
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			addr = net.JoinHostPort(addr, strconv.Itoa(defaultGossipPort))
+		}
+		addrs = append(addrs, addr)
+	}
+	return addrs
+}
+
+func (a *standaloneAgent) ListenClusterEvents() <-chan cluster.ConfigEventType {
+	return a.events
+}
+
+func (a *standaloneAgent) AttachNetwork(string, string, []string) (*networktypes.NetworkingConfig, error) {
+	return nil, types.NotImplementedErrorf("network attachments are not supported by the standalone agent")
+}
+
+func (a *standaloneAgent) DetachNetwork(string, string) error {
+	return types.NotImplementedErrorf("network attachments are not supported by the standalone agent")
+}
+
+func (a *standaloneAgent) UpdateAttachment(string, string, *networktypes.NetworkingConfig) error {
+	return types.NotImplementedErrorf("network attachments are not supported by the standalone agent")
+}
+
+func (a *standaloneAgent) WaitForDetachment(context.Context, string, string, string, string) error {
+	return types.NotImplementedErrorf("network attachments are not supported by the standalone agent")
+}
@@ -0,0 +1,97 @@
+package libnetwork
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/docker/libnetwork/types"
+)
+
+// agentKeys returns the gossip and IPSec keys of an agent
+func agentKeys() []*types.EncryptionKey {
+	var keys []*types.EncryptionKey
+	for _, subsys := range []string{subsysGossip, subsysIPSec} {
+		for i := 0; i < keyringSize; i++ {
+			keys = append(keys, &types.EncryptionKey{
+				Subsystem:   subsys,
+				Key:         []byte(fmt.Sprintf("%s-key-%d-0123456789", subsys, i))[:16],
+				LamportTime: uint64(i + 1),
+			})
+		}
+	}
+	return keys
+}
+
+func TestStandaloneAgentAddresses(t *testing.T) {
+	a := &standaloneAgent{cfg: AgentConfig{
+		AdvertiseAddr: "10.0.0.1",
+		JoinAddrs:     []string{"10.0.0.2", "10.0.0.3:8000", "fd00::4"},
+	}}
+	if a.GetListenAddress() != ":7946" || a.GetDataPathAddress() != "10.0.0.1" || a.GetLocalAddress() != "10.0.0.1" {
+		t.Fatalf("unexpected addresses %s %s %s", a.GetListenAddress(), a.GetDataPathAddress(), a.GetLocalAddress())
+	}
+	if addrs := strings.Join(a.GetRemoteAddressList(), ","); addrs != "10.0.0.2:7946,10.0.0.3:8000,[fd00::4]:7946" {
+		t.Fatalf("unexpected join addresses %s", addrs)
+	}
+	a.cfg.ListenAddr, a.cfg.DataPathAddr = "10.0.0.1", "192.168.0.1"
+	if a.GetListenAddress() != "10.0.0.1:7946" || a.GetDataPathAddress() != "192.168.0.1" {
+		t.Fatalf("unexpected addresses %s %s", a.GetListenAddress(), a.GetDataPathAddress())
+	}
+	if !a.IsAgent() || a.IsManager() {
+		t.Fatal("the standalone agent is not a plain agent")
+	}
+	if _, err := a.AttachNetwork("n1", "c1", nil); err == nil {
+		t.Fatal("network attached by the standalone agent")
+	}
+}
+
+func TestStartAgent(t *testing.T) {
+	cfgOptions, err := OptionBoltdbWithRandomDBFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	nc, err := New(cfgOptions...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nc.Stop()
+	c := nc.(*controller)
+
+	if err := c.StopAgent(); err == nil {
+		t.Fatal("stopped an agent never started")
+	}
+	for _, cfg := range []AgentConfig{
+		{Keys: agentKeys()},
+		{AdvertiseAddr: "127.0.0.1"},
+		{AdvertiseAddr: "127.0.0.1", Keys: agentKeys()[:2]},
+	} {
+		if err := c.StartAgent(cfg); err == nil {
+			t.Fatalf("agent started with %+v", cfg)
+		} else if _, ok := err.(types.BadRequestError); !ok {
+			t.Fatalf("unexpected error %v", err)
+		}
+	}
+	if c.getAgent() != nil {
+		t.Fatal("agent initialized by an invalid configuration")
+	}
+
+	if err := c.StartAgent(AgentConfig{ListenAddr: "127.0.0.1", AdvertiseAddr: "127.0.0.1", Keys: agentKeys()}); err != nil {
+		t.Fatal(err)
+	}
+	if c.getAgent() == nil || !c.isAgent() {
+		t.Fatal("the agent is not initialized")
+	}
+	if err := c.StartAgent(AgentConfig{AdvertiseAddr: "127.0.0.1", Keys: agentKeys()}); err == nil {
+		t.Fatal("agent started twice")
+	} else if _, ok := err.(types.ForbiddenError); !ok {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	if err := c.StopAgent(); err != nil {
+		t.Fatal(err)
+	}
+	if c.getAgent() != nil {
+		t.Fatal("the agent is left once stopped")
+	}
+}
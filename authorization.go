@@ -0,0 +1,77 @@
+package libnetwork
+
+import (
+	"github.com/docker/libnetwork/authz"
+	"github.com/docker/libnetwork/netlabel"
+	"github.com/docker/libnetwork/types"
+	"github.com/sirupsen/logrus"
+)
+
+// authorize asks the authorizer of the daemon, when there is one, whether
+// the operation may be carried out. A denial is a forbidden error.
+func (c *controller) authorize(req *authz.Request) error {
+	a := c.Config().Daemon.Authorizer
+	if a == nil {
+		return nil
+	}
+	if err := a.Authorize(req); err != nil {
+		logrus.Infof("Denied %s: %v", req, err)
+		return types.ForbiddenErrorf("%s denied: %v", req.Operation, err)
+	}
+	return nil
+}
+
+// authzRequest returns the request authorizing the operation on the
+// network
+func (n *network) authzRequest(op authz.Operation) *authz.Request {
+	n.Lock()
+	defer n.Unlock()
+
+	req := &authz.Request{
+		Operation:   op,
+		Tenant:      n.labels[netlabel.Tenant],
+		NetworkType: n.networkType,
+		NetworkID:   n.id,
+		NetworkName: n.name,
+		Internal:    n.internal,
+		Ingress:     n.ingress,
+	}
+	if len(n.labels) > 0 {
+		req.Labels = make(map[string]string, len(n.labels))
+		for k, v := range n.labels {
+			req.Labels[k] = v
+		}
+	}
+	for _, cfg := range append(append([]*IpamConf{}, n.ipamV4Config...), n.ipamV6Config...) {
+		if cfg.PreferredPool != "" {
+			req.Subnets = append(req.Subnets, cfg.PreferredPool)
+		}
+	}
+	return req
+}
+
+// authorize asks whether the operation may be carried out on the
+// endpoint, for the sandbox when there is one
+func (ep *endpoint) authorize(op authz.Operation, sb *sandbox) error {
+	n := ep.getNetwork()
+	if n == nil {
+		return nil
+	}
+	req := n.authzRequest(op)
+	req.Endpoint = ep.Name()
+	if sb != nil {
+		req.ContainerID = sb.ContainerID()
+	}
+	return n.getController().authorize(req)
+}
+
+// authorizeFloatingIP asks whether the floating IP may be added or removed
+// on its network
+func (c *controller) authorizeFloatingIP(op authz.Operation, cfg *FloatingIPConfig) error {
+	req := &authz.Request{Operation: op, NetworkID: cfg.NetworkID}
+	if nw, err := c.NetworkByID(cfg.NetworkID); err == nil {
+		req = nw.(*network).authzRequest(op)
+	}
+	req.FloatingIP = cfg.Name
+	return c.authorize(req)
+}
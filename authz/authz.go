@@ -0,0 +1,90 @@
+// Package authz lets the daemons embedding libnetwork authorize the
+// operations of the controller. The Authorizer is asked before each
+// operation is carried out, with the network, endpoint and sandbox it
+// applies to, and denies it by returning an error.
+package authz
+
+import "fmt"
+
+// Operation is an operation of the controller subject to authorization
+type Operation string
+
+// The operations subject to authorization
+const (
+	NetworkCreate    Operation = "network-create"
+	NetworkDelete    Operation = "network-delete"
+	EndpointCreate   Operation = "endpoint-create"
+	EndpointJoin     Operation = "endpoint-join"
+	EndpointLeave    Operation = "endpoint-leave"
+	FloatingIPAdd    Operation = "floating-ip-add"
+	FloatingIPRemove Operation = "floating-ip-remove"
+	// NetworkProtect and NetworkUnprotect set and lift the deletion
+	// protection of the network
+	NetworkProtect   Operation = "network-protect"
+	NetworkUnprotect Operation = "network-unprotect"
+	// FinalizerAdd and FinalizerRemove register and unregister a finalizer
+	// holding the network off deletion
+	FinalizerAdd    Operation = "finalizer-add"
+	FinalizerRemove Operation = "finalizer-remove"
+)
+
+// Request describes an operation to authorize. The fields which do not
+// apply to the operation are empty.
+type Request struct {
+	Operation Operation
+	// Tenant is the tenant owning the network.
+	Tenant      string
+	NetworkType string
+	NetworkID   string
+	NetworkName string
+	// Labels are the labels of the network.
+	Labels map[string]string
+	// Subnets are the subnets requested for the network created.
+	Subnets  []string
+	Internal bool
+	Ingress  bool
+	// Endpoint is the name of the endpoint created, joined or left.
+	Endpoint string
+	// ContainerID is the container of the sandbox joining or leaving.
+	ContainerID string
+	// FloatingIP is the name of the floating IP added or removed.
+	FloatingIP string
+	// Finalizer is the name of the finalizer added or removed.
+	Finalizer string
+}
+
+func (r *Request) String() string {
+	s := fmt.Sprintf("%s network:%s", r.Operation, r.NetworkName)
+	if r.Tenant != "" {
+		s += " tenant:" + r.Tenant
+	}
+	if r.Endpoint != "" {
+		s += " endpoint:" + r.Endpoint
+	}
+	if r.ContainerID != "" {
+		s += fmt.Sprintf(" container:%.12s", r.ContainerID)
+	}
+	if r.FloatingIP != "" {
+		s += " floating_ip:" + r.FloatingIP
+	}
+	if r.Finalizer != "" {
+		s += " finalizer:" + r.Finalizer
+	}
+	return s
+}
+
+// Authorizer decides whether the controller may carry an operation out.
+// The calls are made synchronously from the operation, with no lock held.
+type Authorizer interface {
+	// Authorize returns nil to allow the operation, the error denying it
+	// otherwise
+	Authorize(req *Request) error
+}
+
+// Func adapts an ordinary function to an Authorizer
+type Func func(req *Request) error
+
+// Authorize calls f(req)
+func (f Func) Authorize(req *Request) error {
+	return f(req)
+}
@@ -0,0 +1,172 @@
+// Package bgp is a minimal BGP-4 speaker. The drivers routing the traffic
+// to their endpoints use it to advertise the endpoints host routes, or an
+// aggregate covering them, to the upstream routers. It only announces IPv4
+// unicast routes and ignores the routes the peers advertise.
+package bgp
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/docker/libnetwork/types"
+)
+
+// Network options configuring the speaker of a network
+const (
+	// ASNOption is the local ASN
+	ASNOption = "bgp_asn"
+	// RouterIDOption is the BGP identifier, the next hop address by default
+	RouterIDOption = "bgp_router_id"
+	// PeersOption are the peers as in 10.0.0.1:65000,10.0.0.2:65000
+	PeersOption = "bgp_peers"
+	// NextHopOption is the next hop of the routes, the local address of
+	// each session by default
+	NextHopOption = "bgp_next_hop"
+	// AggregateOption is a prefix advertised instead of the host routes
+	// it covers, as long as one of them is advertised
+	AggregateOption = "bgp_aggregate"
+	// HoldTimeOption is the proposed hold time in seconds, DefaultHoldTime
+	// when not set
+	HoldTimeOption = "bgp_hold_time"
+)
+
+const (
+	// DefaultPort is the BGP port
+	DefaultPort = 179
+	// DefaultHoldTime is the hold time proposed to the peers, in seconds
+	DefaultHoldTime = 90
+)
+
+// PeerConfig is an upstream router the routes are advertised to
+type PeerConfig struct {
+	Address string `json:"address"`
+	ASN     uint32 `json:"asn"`
+	Port    int    `json:"port,omitempty"`
+}
+
+// Config is the configuration of the speaker of a network
+type Config struct {
+	ASN       uint32       `json:"asn"`
+	RouterID  string       `json:"router_id,omitempty"`
+	NextHop   string       `json:"next_hop,omitempty"`
+	Aggregate string       `json:"aggregate,omitempty"`
+	HoldTime  int          `json:"hold_time,omitempty"`
+	Peers     []PeerConfig `json:"peers"`
+}
+
+// IsConfigured tells if the options configure a speaker
+func IsConfigured(options map[string]string) bool {
+	for k := range options {
+		if strings.HasPrefix(k, "bgp_") {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseOptions parses the bgp options of a network
+func ParseOptions(options map[string]string) (*Config, error) {
+	c := &Config{}
+	for k, v := range options {
+		switch k {
+		case ASNOption:
+			asn, err := strconv.ParseUint(v, 10, 32)
+			if err != nil {
+				return nil, types.BadRequestErrorf("invalid %s %q: %v", k, v, err)
+			}
+			c.ASN = uint32(asn)
+		case RouterIDOption:
+			c.RouterID = v
+		case NextHopOption:
+			c.NextHop = v
+		case AggregateOption:
+			c.Aggregate = v
+		case HoldTimeOption:
+			h, err := strconv.Atoi(v)
+			if err != nil {
+				return nil, types.BadRequestErrorf("invalid %s %q: %v", k, v, err)
+			}
+			c.HoldTime = h
+		case PeersOption:
+			for _, p := range strings.Split(v, ",") {
+				peer, err := parsePeer(strings.TrimSpace(p))
+				if err != nil {
+					return nil, err
+				}
+				c.Peers = append(c.Peers, peer)
+			}
+		default:
+			if strings.HasPrefix(k, "bgp_") {
+				return nil, types.BadRequestErrorf("unknown bgp option %s", k)
+			}
+		}
+	}
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func parsePeer(val string) (PeerConfig, error) {
+	host, asn, err := net.SplitHostPort(val)
+	if err != nil {
+		return PeerConfig{}, types.BadRequestErrorf("invalid bgp peer %q: expected as in 10.0.0.1:65000", val)
+	}
+	n, err := strconv.ParseUint(asn, 10, 32)
+	if err != nil {
+		return PeerConfig{}, types.BadRequestErrorf("invalid asn of the bgp peer %q: %v", val, err)
+	}
+	return PeerConfig{Address: host, ASN: uint32(n)}, nil
+}
+
+func validIPv4(val string) bool {
+	ip := net.ParseIP(val)
+	return ip != nil && ip.To4() != nil
+}
+
+// Validate checks the configuration
+func (c *Config) Validate() error {
+	if c.ASN == 0 {
+		return types.BadRequestErrorf("the bgp asn is required")
+	}
+	if len(c.Peers) == 0 {
+		return types.BadRequestErrorf("at least one bgp peer is required")
+	}
+	for _, p := range c.Peers {
+		if !validIPv4(p.Address) {
+			return types.BadRequestErrorf("invalid bgp peer address %q", p.Address)
+		}
+		if p.ASN == 0 {
+			return types.BadRequestErrorf("the asn of the bgp peer %s is required", p.Address)
+		}
+		if p.Port < 0 || p.Port > 0xffff {
+			return types.BadRequestErrorf("invalid port %d of the bgp peer %s", p.Port, p.Address)
+		}
+	}
+	if c.RouterID != "" && !validIPv4(c.RouterID) {
+		return types.BadRequestErrorf("invalid bgp router id %q", c.RouterID)
+	}
+	if c.NextHop != "" && !validIPv4(c.NextHop) {
+		return types.BadRequestErrorf("invalid bgp next hop %q", c.NextHop)
+	}
+	if c.Aggregate != "" {
+		ip, _, err := net.ParseCIDR(c.Aggregate)
+		if err != nil || ip.To4() == nil {
+			return types.BadRequestErrorf("invalid bgp aggregate %q", c.Aggregate)
+		}
+	}
+	if c.HoldTime != 0 && (c.HoldTime < 3 || c.HoldTime > 0xffff) {
+		return types.BadRequestErrorf("invalid bgp hold time %d", c.HoldTime)
+	}
+	return nil
+}
+
+func (p PeerConfig) String() string {
+	port := p.Port
+	if port == 0 {
+		port = DefaultPort
+	}
+	return fmt.Sprintf("%s (AS%d)", net.JoinHostPort(p.Address, strconv.Itoa(port)), p.ASN)
+}
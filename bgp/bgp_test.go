@@ -0,0 +1,108 @@
+package bgp
+
+import (
+	"bytes"
+	"net"
+	"testing"
+)
+
+func TestParseOptions(t *testing.T) {
+	c, err := ParseOptions(map[string]string{
+		ASNOption:       "4200000000",
+		PeersOption:     "10.0.0.1:65001, 10.0.0.2:4200000000",
+		AggregateOption: "172.16.0.0/24",
+		HoldTimeOption:  "30",
+		"parent":        "eth0",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.ASN != 4200000000 || len(c.Peers) != 2 || c.Peers[1].ASN != 4200000000 || c.HoldTime != 30 {
+		t.Fatalf("unexpected configuration %+v", c)
+	}
+
+	for _, opts := range []map[string]string{
+		{PeersOption: "10.0.0.1:65001"},
+		{ASNOption: "65000"},
+		{ASNOption: "65000", PeersOption: "10.0.0.1"},
+		{ASNOption: "65000", PeersOption: "fd00::1:65001"},
+		{ASNOption: "65000", PeersOption: "10.0.0.1:65001", AggregateOption: "172.16.0.1"},
+		{ASNOption: "65000", PeersOption: "10.0.0.1:65001", HoldTimeOption: "2"},
+		{ASNOption: "65000", PeersOption: "10.0.0.1:65001", "bgp_password": "secret"},
+	} {
+		if _, err := ParseOptions(opts); err == nil {
+			t.Fatalf("expected an error parsing %v", opts)
+		}
+	}
+
+	if IsConfigured(map[string]string{"parent": "eth0"}) || !IsConfigured(map[string]string{ASNOption: "1"}) {
+		t.Fatal("unexpected configured state")
+	}
+}
+
+func TestOpenMessage(t *testing.T) {
+	b := encodeOpen(&openMsg{asn: 4200000000, holdTime: 90, routerID: net.ParseIP("10.0.0.3")})
+	typ, body, err := readMessage(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if typ != msgOpen {
+		t.Fatalf("unexpected message type %d", typ)
+	}
+	// The 4 octets ASN is carried as AS_TRANS
+	if body[1] != asTrans>>8 || body[2] != asTrans&0xff {
+		t.Fatalf("unexpected 2 octets asn %v", body[1:3])
+	}
+	o, err := decodeOpen(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if o.asn != 4200000000 || !o.as4 || o.holdTime != 90 || !o.routerID.Equal(net.ParseIP("10.0.0.3")) {
+		t.Fatalf("unexpected open %+v", o)
+	}
+}
+
+func TestUpdateMessage(t *testing.T) {
+	_, p1, _ := net.ParseCIDR("172.16.0.5/32")
+	_, p2, _ := net.ParseCIDR("172.16.1.0/23")
+	b := encodeUpdate(&updateMsg{
+		withdrawn: []*net.IPNet{p2},
+		nlri:      []*net.IPNet{p1},
+		localASN:  65000,
+		nextHop:   net.ParseIP("10.0.0.3"),
+	})
+	_, body, err := readMessage(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []byte{
+		0, 4, 23, 172, 16, 0, // withdrawn
+		0, 18, // attributes
+		0x40, 1, 1, 0, // origin
+		0x40, 2, 4, 2, 1, 0xfd, 0xe8, // as path
+		0x40, 3, 4, 10, 0, 0, 3, // next hop
+		32, 172, 16, 0, 5, // nlri
+	}
+	if !bytes.Equal(body, expected) {
+		t.Fatalf("unexpected update:\n%v\nexpected:\n%v", body, expected)
+	}
+
+	// A withdrawal carries no attribute
+	b = encodeUpdate(&updateMsg{withdrawn: []*net.IPNet{p1}})
+	if _, body, _ = readMessage(bytes.NewReader(b)); !bytes.Equal(body, []byte{0, 5, 32, 172, 16, 0, 5, 0, 0}) {
+		t.Fatalf("unexpected withdrawal %v", body)
+	}
+}
+
+func TestReadInvalidMessage(t *testing.T) {
+	b := encodeKeepalive()
+	b[3] = 0
+	if _, _, err := readMessage(bytes.NewReader(b)); err == nil {
+		t.Fatal("expected an error reading an invalid marker")
+	}
+	b = encodeKeepalive()
+	b[17] = 10
+	if _, _, err := readMessage(bytes.NewReader(b)); err == nil {
+		t.Fatal("expected an error reading an invalid length")
+	}
+}
@@ -0,0 +1,221 @@
+package bgp
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+)
+
+// Message types
+const (
+	msgOpen         = 1
+	msgUpdate       = 2
+	msgNotification = 3
+	msgKeepalive    = 4
+)
+
+// Path attributes
+const (
+	attrOrigin    = 1
+	attrASPath    = 2
+	attrNextHop   = 3
+	attrLocalPref = 5
+
+	attrFlagTransitive = 0x40
+	originIGP          = 0
+	asSequence         = 2
+	defaultLocalPref   = 100
+)
+
+// Notification error codes
+const (
+	errOpen          = 2
+	errHoldTimer     = 4
+	errCease         = 6
+	errCeaseShutdown = 2
+	errOpenBadPeerAS = 2
+)
+
+const (
+	headerLen  = 19
+	maxMsgLen  = 4096
+	bgpVersion = 4
+	// asTrans stands for the 4 octets ASN in the 2 octets fields
+	asTrans = 23456
+
+	capMultiprotocol = 1
+	capAS4           = 65
+	paramCapability  = 2
+)
+
+func appendHeader(b []byte, typ byte, bodyLen int) []byte {
+	for i := 0; i < 16; i++ {
+		b = append(b, 0xff)
+	}
+	b = append(b, byte((headerLen+bodyLen)>>8), byte(headerLen+bodyLen), typ)
+	return b
+}
+
+func message(typ byte, body []byte) []byte {
+	return append(appendHeader(make([]byte, 0, headerLen+len(body)), typ, len(body)), body...)
+}
+
+// openMsg is the content of an OPEN message which matters to the speaker
+type openMsg struct {
+	asn      uint32
+	holdTime uint16
+	routerID net.IP
+	as4      bool
+}
+
+func encodeOpen(o *openMsg) []byte {
+	as := uint16(asTrans)
+	if o.asn <= 0xffff {
+		as = uint16(o.asn)
+	}
+	caps := []byte{
+		capMultiprotocol, 4, 0, 1, 0, 1, // IPv4 unicast
+		capAS4, 4, 0, 0, 0, 0,
+	}
+	binary.BigEndian.PutUint32(caps[8:], o.asn)
+	params := append([]byte{paramCapability, byte(len(caps))}, caps...)
+
+	b := []byte{bgpVersion, byte(as >> 8), byte(as), byte(o.holdTime >> 8), byte(o.holdTime)}
+	b = append(b, o.routerID.To4()...)
+	b = append(b, byte(len(params)))
+	b = append(b, params...)
+	return message(msgOpen, b)
+}
+
+func decodeOpen(b []byte) (*openMsg, error) {
+	if len(b) < 10 {
+		return nil, fmt.Errorf("short open message")
+	}
+	if b[0] != bgpVersion {
+		return nil, fmt.Errorf("unsupported bgp version %d", b[0])
+	}
+	o := &openMsg{
+		asn:      uint32(binary.BigEndian.Uint16(b[1:3])),
+		holdTime: binary.BigEndian.Uint16(b[3:5]),
+		routerID: net.IP(append([]byte{}, b[5:9]...)),
+	}
+	params := b[10:]
+	if len(params) != int(b[9]) {
+		return nil, fmt.Errorf("invalid open optional parameters length")
+	}
+	for len(params) >= 2 {
+		typ, l := params[0], int(params[1])
+		if len(params) < 2+l {
+			return nil, fmt.Errorf("invalid open optional parameter length")
+		}
+		if typ == paramCapability {
+			caps := params[2 : 2+l]
+			for len(caps) >= 2 {
+				code, cl := caps[0], int(caps[1])
+				if len(caps) < 2+cl {
+					return nil, fmt.Errorf("invalid capability length")
+				}
+				if code == capAS4 && cl == 4 {
+					o.as4 = true
+					o.asn = binary.BigEndian.Uint32(caps[2:6])
+				}
+				caps = caps[2+cl:]
+			}
+		}
+		params = params[2+l:]
+	}
+	return o, nil
+}
+
+func appendPrefix(b []byte, p *net.IPNet) []byte {
+	ones, _ := p.Mask.Size()
+	b = append(b, byte(ones))
+	return append(b, p.IP.To4()[:(ones+7)/8]...)
+}
+
+// updateMsg is an UPDATE of the routes of the speaker, all of them sharing
+// the same attributes
+type updateMsg struct {
+	withdrawn []*net.IPNet
+	nlri      []*net.IPNet
+	localASN  uint32
+	nextHop   net.IP
+	as4       bool
+	ibgp      bool
+}
+
+func encodeUpdate(u *updateMsg) []byte {
+	var withdrawn []byte
+	for _, p := range u.withdrawn {
+		withdrawn = appendPrefix(withdrawn, p)
+	}
+
+	var attrs []byte
+	if len(u.nlri) > 0 {
+		attrs = append(attrs, attrFlagTransitive, attrOrigin, 1, originIGP)
+		if u.ibgp {
+			// The AS is prepended when leaving it only
+			attrs = append(attrs, attrFlagTransitive, attrASPath, 0)
+			attrs = append(attrs, attrFlagTransitive, attrLocalPref, 4, 0, 0, 0, defaultLocalPref)
+		} else if u.as4 {
+			attrs = append(attrs, attrFlagTransitive, attrASPath, 6, asSequence, 1, 0, 0, 0, 0)
+			binary.BigEndian.PutUint32(attrs[len(attrs)-4:], u.localASN)
+		} else {
+			as := uint16(asTrans)
+			if u.localASN <= 0xffff {
+				as = uint16(u.localASN)
+			}
+			attrs = append(attrs, attrFlagTransitive, attrASPath, 4, asSequence, 1, byte(as>>8), byte(as))
+		}
+		attrs = append(attrs, attrFlagTransitive, attrNextHop, 4)
+		attrs = append(attrs, u.nextHop.To4()...)
+	}
+
+	var nlri []byte
+	for _, p := range u.nlri {
+		nlri = appendPrefix(nlri, p)
+	}
+
+	b := make([]byte, 0, 4+len(withdrawn)+len(attrs)+len(nlri))
+	b = append(b, byte(len(withdrawn)>>8), byte(len(withdrawn)))
+	b = append(b, withdrawn...)
+	b = append(b, byte(len(attrs)>>8), byte(len(attrs)))
+	b = append(b, attrs...)
+	b = append(b, nlri...)
+	return message(msgUpdate, b)
+}
+
+// maxUpdatePrefixes keeps the updates of host routes, withdrawn and
+// announced, under the maximum message size
+const maxUpdatePrefixes = 350
+
+func encodeNotification(code, subcode byte) []byte {
+	return message(msgNotification, []byte{code, subcode})
+}
+
+func encodeKeepalive() []byte {
+	return message(msgKeepalive, nil)
+}
+
+// readMessage reads the next message, returning its type and body
+func readMessage(r io.Reader) (byte, []byte, error) {
+	h := make([]byte, headerLen)
+	if _, err := io.ReadFull(r, h); err != nil {
+		return 0, nil, err
+	}
+	for _, m := range h[:16] {
+		if m != 0xff {
+			return 0, nil, fmt.Errorf("invalid message marker")
+		}
+	}
+	l := int(binary.BigEndian.Uint16(h[16:18]))
+	if l < headerLen || l > maxMsgLen {
+		return 0, nil, fmt.Errorf("invalid message length %d", l)
+	}
+	body := make([]byte, l-headerLen)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, nil, err
+	}
+	return h[18], body, nil
+}
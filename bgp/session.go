@@ -0,0 +1,291 @@
+package bgp
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	connectTimeout = 5 * time.Second
+	minRetry       = time.Second
+	maxRetry       = time.Minute
+)
+
+var errStopped = errors.New("session stopped")
+
+// session is the session with a peer, connected again as long as the
+// speaker is not stopped
+type session struct {
+	speaker *Speaker
+	peer    PeerConfig
+	kick    chan struct{}
+	stopCh  chan struct{}
+	done    chan struct{}
+	log     *logrus.Entry
+}
+
+func newSession(s *Speaker, p PeerConfig) *session {
+	return &session{
+		speaker: s,
+		peer:    p,
+		kick:    make(chan struct{}, 1),
+		stopCh:  make(chan struct{}),
+		done:    make(chan struct{}),
+		log:     s.logger(p),
+	}
+}
+
+// notify tells the session the routes changed
+func (ss *session) notify() {
+	select {
+	case ss.kick <- struct{}{}:
+	default:
+	}
+}
+
+func (ss *session) stop() {
+	close(ss.stopCh)
+	<-ss.done
+}
+
+func (ss *session) run() {
+	defer close(ss.done)
+
+	retry := minRetry
+	for {
+		established, err := ss.connect()
+		if err == errStopped {
+			return
+		}
+		if established {
+			ss.log.Warnf("Session down: %v", err)
+			retry = minRetry
+		} else {
+			ss.log.Debugf("Session failed: %v", err)
+		}
+
+		select {
+		case <-ss.stopCh:
+			return
+		case <-time.After(retry):
+		}
+		if retry *= 2; retry > maxRetry {
+			retry = maxRetry
+		}
+	}
+}
+
+func (ss *session) write(conn net.Conn, b []byte) error {
+	conn.SetWriteDeadline(time.Now().Add(connectTimeout))
+	_, err := conn.Write(b)
+	return err
+}
+
+// open exchanges the OPEN messages with the peer and returns the
+// negotiated hold time and whether the peer handles the 4 octets ASNs
+func (ss *session) open(conn net.Conn, routerID net.IP) (time.Duration, bool, error) {
+	c := ss.speaker.config
+	hold := c.HoldTime
+	if hold == 0 {
+		hold = DefaultHoldTime
+	}
+
+	conn.SetReadDeadline(time.Now().Add(time.Duration(hold) * time.Second))
+	defer conn.SetReadDeadline(time.Time{})
+
+	if err := ss.write(conn, encodeOpen(&openMsg{asn: c.ASN, holdTime: uint16(hold), routerID: routerID})); err != nil {
+		return 0, false, err
+	}
+	typ, body, err := readMessage(conn)
+	if err != nil {
+		return 0, false, err
+	}
+	switch typ {
+	case msgOpen:
+	case msgNotification:
+		return 0, false, notificationError(body)
+	default:
+		return 0, false, fmt.Errorf("unexpected message %d while opening the session", typ)
+	}
+	o, err := decodeOpen(body)
+	if err != nil {
+		return 0, false, err
+	}
+	if o.asn != ss.peer.ASN {
+		ss.write(conn, encodeNotification(errOpen, errOpenBadPeerAS))
+		return 0, false, fmt.Errorf("the peer is AS%d", o.asn)
+	}
+	if o.holdTime < uint16(hold) {
+		hold = int(o.holdTime)
+	}
+
+	if err := ss.write(conn, encodeKeepalive()); err != nil {
+		return 0, false, err
+	}
+	typ, body, err = readMessage(conn)
+	if err != nil {
+		return 0, false, err
+	}
+	if typ == msgNotification {
+		return 0, false, notificationError(body)
+	}
+	if typ != msgKeepalive {
+		return 0, false, fmt.Errorf("unexpected message %d while opening the session", typ)
+	}
+	return time.Duration(hold) * time.Second, o.as4, nil
+}
+
+// connect runs a session until it fails or the speaker is stopped, it
+// tells if the session got established
+func (ss *session) connect() (bool, error) {
+	port := ss.peer.Port
+	if port == 0 {
+		port = DefaultPort
+	}
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(ss.peer.Address, strconv.Itoa(port)), connectTimeout)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+
+	c := ss.speaker.config
+	nextHop := conn.LocalAddr().(*net.TCPAddr).IP.To4()
+	if c.NextHop != "" {
+		nextHop = net.ParseIP(c.NextHop).To4()
+	}
+	routerID := nextHop
+	if c.RouterID != "" {
+		routerID = net.ParseIP(c.RouterID).To4()
+	}
+	if nextHop == nil {
+		return false, fmt.Errorf("the session is not over IPv4")
+	}
+
+	hold, as4, err := ss.open(conn, routerID)
+	if err != nil {
+		return false, err
+	}
+	ss.log.Infof("Session established")
+
+	readErr := make(chan error, 1)
+	go func() {
+		for {
+			if hold > 0 {
+				conn.SetReadDeadline(time.Now().Add(hold))
+			}
+			typ, body, err := readMessage(conn)
+			if err != nil {
+				if ne, ok := err.(net.Error); ok && ne.Timeout() {
+					ss.write(conn, encodeNotification(errHoldTimer, 0))
+					err = fmt.Errorf("hold timer expired")
+				}
+				readErr <- err
+				return
+			}
+			// The routes of the peers are of no use to the speaker
+			if typ == msgNotification {
+				readErr <- notificationError(body)
+				return
+			}
+		}
+	}()
+
+	var keepalive <-chan time.Time
+	if hold > 0 {
+		t := time.NewTicker(hold / 3)
+		defer t.Stop()
+		keepalive = t.C
+	}
+
+	u := &updateMsg{localASN: c.ASN, nextHop: nextHop, as4: as4, ibgp: ss.peer.ASN == c.ASN}
+	sent := map[string]*net.IPNet{}
+	if err := ss.sync(conn, u, sent); err != nil {
+		return true, err
+	}
+	for {
+		select {
+		case <-ss.kick:
+			if err := ss.sync(conn, u, sent); err != nil {
+				return true, err
+			}
+		case <-keepalive:
+			if err := ss.write(conn, encodeKeepalive()); err != nil {
+				return true, err
+			}
+		case err := <-readErr:
+			return true, err
+		case <-ss.stopCh:
+			// Withdraw the routes first, for the peer not to wait for
+			// the hold time to expire before routing around this node
+			ss.sync(conn, u, sent)
+			ss.write(conn, encodeNotification(errCease, errCeaseShutdown))
+			ss.log.Infof("Session closed")
+			return true, errStopped
+		}
+	}
+}
+
+// sync sends the changes between the routes sent and the routes of the
+// speaker, withdrawing all of them once stopped
+func (ss *session) sync(conn net.Conn, u *updateMsg, sent map[string]*net.IPNet) error {
+	var routes map[string]*net.IPNet
+	if !ss.stopping() {
+		routes = ss.speaker.snapshot()
+	}
+
+	var withdrawn, nlri []*net.IPNet
+	for k, r := range sent {
+		if _, ok := routes[k]; !ok {
+			withdrawn = append(withdrawn, r)
+		}
+	}
+	for k, r := range routes {
+		if _, ok := sent[k]; !ok {
+			nlri = append(nlri, r)
+		}
+	}
+
+	for len(withdrawn) > 0 || len(nlri) > 0 {
+		m := *u
+		m.withdrawn, withdrawn = split(withdrawn)
+		m.nlri, nlri = split(nlri)
+		if err := ss.write(conn, encodeUpdate(&m)); err != nil {
+			return err
+		}
+		for _, r := range m.withdrawn {
+			delete(sent, r.String())
+		}
+		for _, r := range m.nlri {
+			sent[r.String()] = r
+		}
+	}
+	return nil
+}
+
+func (ss *session) stopping() bool {
+	select {
+	case <-ss.stopCh:
+		return true
+	default:
+		return false
+	}
+}
+
+func split(l []*net.IPNet) ([]*net.IPNet, []*net.IPNet) {
+	if len(l) <= maxUpdatePrefixes {
+		return l, nil
+	}
+	return l[:maxUpdatePrefixes], l[maxUpdatePrefixes:]
+}
+
+func notificationError(body []byte) error {
+	if len(body) < 2 {
+		return fmt.Errorf("notification from the peer")
+	}
+	return fmt.Errorf("notification from the peer, code %d subcode %d", body[0], body[1])
+}
@@ -0,0 +1,135 @@
+package bgp
+
+import (
+	"net"
+	"sort"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Speaker advertises the routes of a network to the peers of its
+// configuration
+type Speaker struct {
+	config    *Config
+	aggregate *net.IPNet
+	// hosts are the advertised host routes, routes what is announced
+	// after the aggregation
+	hosts    map[string]*net.IPNet
+	routes   map[string]*net.IPNet
+	sessions []*session
+	stopped  bool
+	sync.Mutex
+}
+
+// NewSpeaker starts the sessions with the peers of the configuration
+func NewSpeaker(c *Config) (*Speaker, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	s := &Speaker{
+		config: c,
+		hosts:  map[string]*net.IPNet{},
+		routes: map[string]*net.IPNet{},
+	}
+	if c.Aggregate != "" {
+		_, s.aggregate, _ = net.ParseCIDR(c.Aggregate)
+	}
+	for _, p := range c.Peers {
+		ss := newSession(s, p)
+		s.sessions = append(s.sessions, ss)
+		go ss.run()
+	}
+	return s, nil
+}
+
+// Advertise announces the host route of the address
+func (s *Speaker) Advertise(ip net.IP) {
+	if ip = ip.To4(); ip == nil {
+		return
+	}
+	s.Lock()
+	s.hosts[ip.String()] = &net.IPNet{IP: ip, Mask: net.CIDRMask(32, 32)}
+	s.updateRoutes()
+	s.Unlock()
+}
+
+// Withdraw withdraws the host route of the address
+func (s *Speaker) Withdraw(ip net.IP) {
+	if ip = ip.To4(); ip == nil {
+		return
+	}
+	s.Lock()
+	delete(s.hosts, ip.String())
+	s.updateRoutes()
+	s.Unlock()
+}
+
+// Routes returns the routes announced to the peers
+func (s *Speaker) Routes() []*net.IPNet {
+	s.Lock()
+	defer s.Unlock()
+	return sortedRoutes(s.routes)
+}
+
+// Stop withdraws the routes from the peers and closes the sessions
+func (s *Speaker) Stop() {
+	s.Lock()
+	if s.stopped {
+		s.Unlock()
+		return
+	}
+	s.stopped = true
+	s.Unlock()
+
+	var wg sync.WaitGroup
+	for _, ss := range s.sessions {
+		wg.Add(1)
+		go func(ss *session) {
+			defer wg.Done()
+			ss.stop()
+		}(ss)
+	}
+	wg.Wait()
+}
+
+// updateRoutes aggregates the host routes and tells the sessions of the
+// changes, to be called with the lock held
+func (s *Speaker) updateRoutes() {
+	routes := map[string]*net.IPNet{}
+	for _, h := range s.hosts {
+		if s.aggregate != nil && s.aggregate.Contains(h.IP) {
+			routes[s.aggregate.String()] = s.aggregate
+			continue
+		}
+		routes[h.String()] = h
+	}
+	s.routes = routes
+	for _, ss := range s.sessions {
+		ss.notify()
+	}
+}
+
+// snapshot returns the announced routes
+func (s *Speaker) snapshot() map[string]*net.IPNet {
+	s.Lock()
+	defer s.Unlock()
+	routes := make(map[string]*net.IPNet, len(s.routes))
+	for k, r := range s.routes {
+		routes[k] = r
+	}
+	return routes
+}
+
+func sortedRoutes(routes map[string]*net.IPNet) []*net.IPNet {
+	l := make([]*net.IPNet, 0, len(routes))
+	for _, r := range routes {
+		l = append(l, r)
+	}
+	sort.Slice(l, func(i, j int) bool { return l[i].String() < l[j].String() })
+	return l
+}
+
+func (s *Speaker) logger(p PeerConfig) *logrus.Entry {
+	return logrus.WithFields(logrus.Fields{"component": "bgp", "peer": p.String()})
+}
@@ -0,0 +1,123 @@
+package bgp
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+// fakePeer accepts a session and records the updates it receives
+type fakePeer struct {
+	t       *testing.T
+	l       net.Listener
+	conn    net.Conn
+	updates chan []byte
+}
+
+func newFakePeer(t *testing.T) *fakePeer {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &fakePeer{t: t, l: l, updates: make(chan []byte, 16)}
+}
+
+func (p *fakePeer) port() int {
+	return p.l.Addr().(*net.TCPAddr).Port
+}
+
+func (p *fakePeer) serve(asn uint32) {
+	conn, err := p.l.Accept()
+	if err != nil {
+		return
+	}
+	p.conn = conn
+	conn.Write(encodeOpen(&openMsg{asn: asn, holdTime: 30, routerID: net.ParseIP("127.0.0.2")}))
+	for {
+		typ, body, err := readMessage(conn)
+		if err != nil {
+			close(p.updates)
+			return
+		}
+		switch typ {
+		case msgOpen:
+			conn.Write(encodeKeepalive())
+		case msgUpdate, msgNotification:
+			p.updates <- append([]byte{typ}, body...)
+		}
+	}
+}
+
+func (p *fakePeer) next() []byte {
+	select {
+	case u := <-p.updates:
+		return u
+	case <-time.After(5 * time.Second):
+		p.t.Fatal("timed out waiting for an update")
+	}
+	return nil
+}
+
+func TestSpeaker(t *testing.T) {
+	peer := newFakePeer(t)
+	defer peer.l.Close()
+	go peer.serve(65001)
+
+	s, err := NewSpeaker(&Config{
+		ASN:       65000,
+		Aggregate: "172.16.1.0/24",
+		Peers:     []PeerConfig{{Address: "127.0.0.1", ASN: 65001, Port: peer.port()}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s.Advertise(net.ParseIP("172.16.0.5"))
+	u := peer.next()
+	if u[0] != msgUpdate || string(u[len(u)-5:]) != string([]byte{32, 172, 16, 0, 5}) {
+		t.Fatalf("unexpected update %v", u)
+	}
+
+	// The host routes of the aggregate are announced as the aggregate
+	s.Advertise(net.ParseIP("172.16.1.7"))
+	s.Advertise(net.ParseIP("172.16.1.8"))
+	if r := s.Routes(); len(r) != 2 || r[0].String() != "172.16.0.5/32" || r[1].String() != "172.16.1.0/24" {
+		t.Fatalf("unexpected routes %v", r)
+	}
+	if u = peer.next(); string(u[len(u)-4:]) != string([]byte{24, 172, 16, 1}) {
+		t.Fatalf("unexpected update %v", u)
+	}
+
+	s.Withdraw(net.ParseIP("172.16.0.5"))
+	if u = peer.next(); string(u[1:8]) != string([]byte{0, 5, 32, 172, 16, 0, 5}) {
+		t.Fatalf("unexpected withdrawal %v", u)
+	}
+
+	// Stopping withdraws the remaining routes before closing the session
+	s.Stop()
+	if u = peer.next(); string(u[1:7]) != string([]byte{0, 4, 24, 172, 16, 1}) {
+		t.Fatalf("unexpected withdrawal %v", u)
+	}
+	if u = peer.next(); u[0] != msgNotification || u[1] != errCease {
+		t.Fatalf("unexpected notification %v", u)
+	}
+}
+
+func TestSpeakerBadPeerAS(t *testing.T) {
+	peer := newFakePeer(t)
+	defer peer.l.Close()
+	go peer.serve(65002)
+
+	s, err := NewSpeaker(&Config{
+		ASN:   65000,
+		Peers: []PeerConfig{{Address: "127.0.0.1", ASN: 65001, Port: peer.port()}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop()
+
+	if u := peer.next(); u[0] != msgNotification || u[1] != errOpen || u[2] != errOpenBadPeerAS {
+		t.Fatalf("unexpected notification %v", u)
+	}
+}
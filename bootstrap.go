@@ -0,0 +1,51 @@
+package libnetwork
+
+import (
+	"github.com/docker/libnetwork/driverapi"
+	"github.com/docker/libnetwork/types"
+	"github.com/sirupsen/logrus"
+)
+
+// EndpointReady passes the ready signal of the orchestrator on to the
+// network driver, which has to implement driverapi.EndpointOpener, ending
+// the bootstrap window of the endpoint on the networks waiting for it
+func (c *controller) EndpointReady(networkID, endpointID string) error {
+	nw, err := c.NetworkByID(networkID)
+	if err != nil {
+		return err
+	}
+	n := nw.(*network)
+	if _, err := n.EndpointByID(endpointID); err != nil {
+		return err
+	}
+
+	d, err := n.driver(true)
+	if err != nil {
+		return err
+	}
+	o, ok := d.(driverapi.EndpointOpener)
+	if !ok {
+		return types.NotImplementedErrorf("the %s driver does not support the bootstrap window of the endpoints", n.Type())
+	}
+	return o.OpenEndpoint(n.ID(), endpointID, true)
+}
+
+// openEndpoint tells the network driver the join of the endpoint is
+// applied. The endpoint stays held when the driver fails to open it.
+func (ep *endpoint) openEndpoint() {
+	n, err := ep.getNetworkFromStore()
+	if err != nil {
+		logrus.Warnf("Failed to get the network of endpoint %.7s to open it: %v", ep.ID(), err)
+		return
+	}
+	d, err := n.driver(true)
+	if err != nil {
+		logrus.Warnf("Failed to get the driver of endpoint %.7s to open it: %v", ep.ID(), err)
+		return
+	}
+	if o, ok := d.(driverapi.EndpointOpener); ok {
+		if err := o.OpenEndpoint(n.ID(), ep.ID(), false); err != nil {
+			logrus.Errorf("Failed to open the traffic of endpoint %s (%.7s): %v", ep.Name(), ep.ID(), err)
+		}
+	}
+}
@@ -0,0 +1,232 @@
+package libnetwork
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/http"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/libnetwork/diagnostic"
+	"github.com/docker/libnetwork/internal/caller"
+	"github.com/docker/libnetwork/types"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/net/bpf"
+)
+
+const (
+	defaultCaptureDuration = 10 * time.Second
+	maxCaptureDuration     = 5 * time.Minute
+	defaultCaptureBytes    = 10 << 20
+	maxCaptureBytes        = 100 << 20
+	captureSnapLen         = 65535
+	// pcapLinkTypeEthernet is the LINKTYPE_ETHERNET of the pcap files
+	pcapLinkTypeEthernet = 1
+)
+
+// capturePaths2Func are the diagnostic handlers of the packet captures
+var capturePaths2Func = map[string]diagnostic.HTTPHandlerFunc{
+	"/capture": captureDiag,
+}
+
+// captureSource reads the packets of a capture
+type captureSource interface {
+	// ReadPacket returns the next packet, truncated to the buffer, with
+	// its length on the wire. It returns a zero length packet when no
+	// packet arrived within the timeout.
+	ReadPacket(buf []byte, timeout time.Duration) (n int, wireLen int, err error)
+	Close() error
+}
+
+// captureOptions are the bounds of a capture
+type captureOptions struct {
+	duration time.Duration
+	maxBytes int64
+	filter   []bpf.RawInstruction
+}
+
+func parseCaptureOptions(r *http.Request) (*captureOptions, error) {
+	o := &captureOptions{duration: defaultCaptureDuration, maxBytes: defaultCaptureBytes}
+	if v := r.Form.Get("duration"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 || d > maxCaptureDuration {
+			return nil, types.BadRequestErrorf("invalid capture duration %q: expected up to %v", v, maxCaptureDuration)
+		}
+		o.duration = d
+	}
+	if v := r.Form.Get("bytes"); v != "" {
+		b, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || b <= 0 || b > maxCaptureBytes {
+			return nil, types.BadRequestErrorf("invalid capture size %q: expected up to %d bytes", v, maxCaptureBytes)
+		}
+		o.maxBytes = b
+	}
+	if v := r.Form.Get("filter"); v != "" {
+		f, err := compileBPFFilter(v)
+		if err != nil {
+			return nil, err
+		}
+		o.filter = f
+	}
+	return o, nil
+}
+
+// compileBPFFilter compiles the filter expression with tcpdump, which only
+// prints the program and does not capture
+func compileBPFFilter(expr string) ([]bpf.RawInstruction, error) {
+	path, err := exec.LookPath("tcpdump")
+	if err != nil {
+		return nil, types.NotImplementedErrorf("compiling the capture filters requires tcpdump")
+	}
+	out, err := exec.Command(path, "-y", "EN10MB", "-s", strconv.Itoa(captureSnapLen), "-ddd", expr).CombinedOutput()
+	if err != nil {
+		return nil, types.BadRequestErrorf("invalid capture filter %q: %s", expr, bytes.TrimSpace(out))
+	}
+	return parseBPFProgram(out)
+}
+
+// parseBPFProgram parses the decimal program printed by tcpdump -ddd, its
+// instruction count followed by one "code jt jf k" line per instruction
+func parseBPFProgram(b []byte) ([]bpf.RawInstruction, error) {
+	s := bufio.NewScanner(bytes.NewReader(b))
+	if !s.Scan() {
+		return nil, fmt.Errorf("empty BPF program")
+	}
+	count, err := strconv.Atoi(strings.TrimSpace(s.Text()))
+	if err != nil || count <= 0 {
+		return nil, fmt.Errorf("invalid BPF program length %q", s.Text())
+	}
+	prog := make([]bpf.RawInstruction, 0, count)
+	for s.Scan() {
+		var ins bpf.RawInstruction
+		if _, err := fmt.Sscanf(s.Text(), "%d %d %d %d", &ins.Op, &ins.Jt, &ins.Jf, &ins.K); err != nil {
+			return nil, fmt.Errorf("invalid BPF instruction %q: %v", s.Text(), err)
+		}
+		prog = append(prog, ins)
+	}
+	if len(prog) != count {
+		return nil, fmt.Errorf("BPF program of %d instructions, %d expected", len(prog), count)
+	}
+	return prog, nil
+}
+
+// writePcapHeader writes the global header of a pcap file
+func writePcapHeader(w io.Writer) error {
+	h := make([]byte, 24)
+	binary.LittleEndian.PutUint32(h[0:], 0xa1b2c3d4)
+	binary.LittleEndian.PutUint16(h[4:], 2)
+	binary.LittleEndian.PutUint16(h[6:], 4)
+	binary.LittleEndian.PutUint32(h[16:], captureSnapLen)
+	binary.LittleEndian.PutUint32(h[20:], pcapLinkTypeEthernet)
+	_, err := w.Write(h)
+	return err
+}
+
+// writePcapPacket writes the record of a packet of a pcap file
+func writePcapPacket(w io.Writer, ts time.Time, data []byte, wireLen int) error {
+	h := make([]byte, 16)
+	binary.LittleEndian.PutUint32(h[0:], uint32(ts.Unix()))
+	binary.LittleEndian.PutUint32(h[4:], uint32(ts.Nanosecond()/1000))
+	binary.LittleEndian.PutUint32(h[8:], uint32(len(data)))
+	binary.LittleEndian.PutUint32(h[12:], uint32(wireLen))
+	if _, err := w.Write(h); err != nil {
+		return err
+	}
+	_, err := w.Write(data)
+	return err
+}
+
+// captureDiag streams, in the pcap format, the packets of an interface of
+// a sandbox matching the BPF filter, up to the duration and size bounds
+func captureDiag(ctx interface{}, w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	diagnostic.DebugHTTPForm(r)
+	_, json := diagnostic.ParseHTTPFormOptions(r)
+
+	// audit logs
+	log := logrus.WithFields(logrus.Fields{"component": "diagnostic", "remoteIP": r.RemoteAddr, "method": caller.Name(0), "url": r.URL.String()})
+	log.Info("capture")
+
+	c, ok := ctx.(*controller)
+	if !ok {
+		diagnostic.HTTPReply(w, diagnostic.FailCommand(fmt.Errorf("controller not available")), json)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		diagnostic.HTTPReply(w, diagnostic.FailCommand(fmt.Errorf("streaming not supported")), json)
+		return
+	}
+
+	sid, ifName := r.Form.Get("sid"), r.Form.Get("interface")
+	if sid == "" || ifName == "" {
+		diagnostic.HTTPReply(w, diagnostic.WrongCommand("capture", "sid=<sandbox id>&interface=<name>[&filter=<expr>][&duration=10s][&bytes=10485760]"), json)
+		return
+	}
+	opts, err := parseCaptureOptions(r)
+	if err != nil {
+		diagnostic.HTTPReply(w, diagnostic.FailCommand(err), json)
+		return
+	}
+	s, err := c.SandboxByID(sid)
+	if err != nil {
+		diagnostic.HTTPReply(w, diagnostic.FailCommand(err), json)
+		return
+	}
+	sb := s.(*sandbox)
+	sb.Lock()
+	osSbox := sb.osSbox
+	sb.Unlock()
+	if osSbox == nil {
+		diagnostic.HTTPReply(w, diagnostic.FailCommand(fmt.Errorf("sandbox %.7s has no namespace", sid)), json)
+		return
+	}
+
+	src, err := openCapture(osSbox, ifName, opts.filter)
+	if err != nil {
+		log.WithError(err).Error("capture failed")
+		diagnostic.HTTPReply(w, diagnostic.FailCommand(err), json)
+		return
+	}
+	defer src.Close()
+
+	w.Header().Set("Content-Type", "application/vnd.tcpdump.pcap")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%.12s-%s.pcap", sid, ifName))
+	w.WriteHeader(http.StatusOK)
+	if err := writePcapHeader(w); err != nil {
+		return
+	}
+	flusher.Flush()
+
+	var (
+		deadline = time.Now().Add(opts.duration)
+		written  int64
+		packets  int
+		buf      = make([]byte, captureSnapLen)
+	)
+	for time.Now().Before(deadline) && r.Context().Err() == nil {
+		n, wireLen, err := src.ReadPacket(buf, time.Second)
+		if err != nil {
+			log.WithError(err).Warn("capture stopped")
+			break
+		}
+		if n == 0 {
+			continue
+		}
+		if written+int64(16+n) > opts.maxBytes {
+			break
+		}
+		if err := writePcapPacket(w, time.Now(), buf[:n], wireLen); err != nil {
+			break
+		}
+		written += int64(16 + n)
+		packets++
+		flusher.Flush()
+	}
+	log.Infof("capture done, %d packets, %d bytes", packets, written)
+}
@@ -0,0 +1,93 @@
+package libnetwork
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"github.com/docker/libnetwork/osl"
+	"golang.org/x/net/bpf"
+	"golang.org/x/sys/unix"
+)
+
+// packetSource is a packet socket bound to an interface of a sandbox
+type packetSource struct {
+	fd int
+}
+
+// openCapture opens a packet socket on the interface, in the namespace of
+// the sandbox, with the filter attached. The socket stays on the namespace
+// once opened.
+func openCapture(osSbox osl.Sandbox, ifName string, filter []bpf.RawInstruction) (captureSource, error) {
+	var (
+		fd  = -1
+		err error
+	)
+	if ierr := osSbox.InvokeFunc(func() {
+		var iface *net.Interface
+		if iface, err = net.InterfaceByName(ifName); err != nil {
+			return
+		}
+		if fd, err = unix.Socket(unix.AF_PACKET, unix.SOCK_RAW|unix.SOCK_CLOEXEC, int(htons(unix.ETH_P_ALL))); err != nil {
+			return
+		}
+		if len(filter) != 0 {
+			if err = attachFilter(fd, filter); err != nil {
+				return
+			}
+		}
+		err = unix.Bind(fd, &unix.SockaddrLinklayer{Protocol: htons(unix.ETH_P_ALL), Ifindex: iface.Index})
+	}); ierr != nil {
+		err = ierr
+	}
+	if err != nil {
+		if fd >= 0 {
+			unix.Close(fd)
+		}
+		return nil, fmt.Errorf("failed to open the capture of %s: %v", ifName, err)
+	}
+	return &packetSource{fd: fd}, nil
+}
+
+func attachFilter(fd int, filter []bpf.RawInstruction) error {
+	prog := make([]unix.SockFilter, len(filter))
+	for i, ins := range filter {
+		prog[i] = unix.SockFilter{Code: ins.Op, Jt: ins.Jt, Jf: ins.Jf, K: ins.K}
+	}
+	fprog := unix.SockFprog{Len: uint16(len(prog)), Filter: &prog[0]}
+	_, _, errno := syscall.Syscall6(syscall.SYS_SETSOCKOPT, uintptr(fd), uintptr(unix.SOL_SOCKET), uintptr(unix.SO_ATTACH_FILTER),
+		uintptr(unsafe.Pointer(&fprog)), unsafe.Sizeof(fprog), 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+func (s *packetSource) ReadPacket(buf []byte, timeout time.Duration) (int, int, error) {
+	tv := unix.NsecToTimeval(timeout.Nanoseconds())
+	if err := unix.SetsockoptTimeval(s.fd, unix.SOL_SOCKET, unix.SO_RCVTIMEO, &tv); err != nil {
+		return 0, 0, err
+	}
+	// The length on the wire is returned with MSG_TRUNC
+	n, _, err := unix.Recvfrom(s.fd, buf, unix.MSG_TRUNC)
+	if err != nil {
+		if err == unix.EAGAIN || err == unix.EINTR {
+			return 0, 0, nil
+		}
+		return 0, 0, err
+	}
+	if n > len(buf) {
+		return len(buf), n, nil
+	}
+	return n, n, nil
+}
+
+func (s *packetSource) Close() error {
+	return unix.Close(s.fd)
+}
+
+func htons(v uint16) uint16 {
+	return v<<8 | v>>8
+}
@@ -0,0 +1,13 @@
+// +build !linux
+
+package libnetwork
+
+import (
+	"github.com/docker/libnetwork/osl"
+	"github.com/docker/libnetwork/types"
+	"golang.org/x/net/bpf"
+)
+
+func openCapture(osSbox osl.Sandbox, ifName string, filter []bpf.RawInstruction) (captureSource, error) {
+	return nil, types.NotImplementedErrorf("packet captures are not supported on this platform")
+}
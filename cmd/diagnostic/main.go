@@ -2,43 +2,18 @@ package main
 
 import (
 	"bufio"
-	"encoding/base64"
-	"encoding/json"
+	"context"
 	"flag"
 	"fmt"
-	"io"
-	"io/ioutil"
-	"net/http"
 	"os"
 	"strings"
 
 	"github.com/docker/libnetwork"
-	"github.com/docker/libnetwork/diagnostic"
+	"github.com/docker/libnetwork/diagnostic/client"
 	"github.com/docker/libnetwork/drivers/overlay"
 	"github.com/sirupsen/logrus"
 )
 
-const (
-	readyPath    = "http://%s:%d/ready"
-	joinNetwork  = "http://%s:%d/joinnetwork?nid=%s"
-	leaveNetwork = "http://%s:%d/leavenetwork?nid=%s"
-	clusterPeers = "http://%s:%d/clusterpeers?json"
-	networkPeers = "http://%s:%d/networkpeers?nid=%s&json"
-	dumpTable    = "http://%s:%d/gettable?nid=%s&tname=%s&json"
-	deleteEntry  = "http://%s:%d/deleteentry?nid=%s&tname=%s&key=%s&json"
-)
-
-func httpIsOk(body io.ReadCloser) {
-	b, err := ioutil.ReadAll(body)
-	if err != nil {
-		logrus.Fatalf("Failed the body parse %s", err)
-	}
-	if !strings.Contains(string(b), "OK") {
-		logrus.Fatalf("Server not ready %s", b)
-	}
-	body.Close()
-}
-
 func main() {
 	ipPtr := flag.String("ip", "127.0.0.1", "ip address")
 	portPtr := flag.Int("port", 2000, "port")
@@ -47,6 +22,7 @@ func main() {
 	remediatePtr := flag.Bool("r", false, "perform remediation deleting orphan entries")
 	joinPtr := flag.Bool("a", false, "join/leave network")
 	verbosePtr := flag.Bool("v", false, "verbose output")
+	tokenPtr := flag.String("token", os.Getenv("DIAGNOSTIC_TOKEN"), "diagnostic server auth token")
 
 	flag.Parse()
 
@@ -60,27 +36,25 @@ func main() {
 	}
 
 	logrus.Infof("Connecting to %s:%d checking ready", *ipPtr, *portPtr)
-	resp, err := http.Get(fmt.Sprintf(readyPath, *ipPtr, *portPtr))
-	if err != nil {
+	c := client.New(fmt.Sprintf("%s:%d", *ipPtr, *portPtr), client.OptionToken(*tokenPtr))
+	ctx := context.Background()
+	if err := c.Ready(ctx); err != nil {
 		logrus.WithError(err).Fatalf("The connection failed")
 	}
-	httpIsOk(resp.Body)
 
-	clusterPeers := fetchNodePeers(*ipPtr, *portPtr, "")
+	clusterPeers := fetchNodePeers(ctx, c, "")
 	var networkPeers map[string]string
 	var joinedNetwork bool
 	if *networkPtr != "" {
 		if *joinPtr {
 			logrus.Infof("Joining the network:%q", *networkPtr)
-			resp, err = http.Get(fmt.Sprintf(joinNetwork, *ipPtr, *portPtr, *networkPtr))
-			if err != nil {
+			if err := c.JoinNetwork(ctx, *networkPtr); err != nil {
 				logrus.WithError(err).Fatalf("Failed joining the network")
 			}
-			httpIsOk(resp.Body)
 			joinedNetwork = true
 		}
 
-		networkPeers = fetchNodePeers(*ipPtr, *portPtr, *networkPtr)
+		networkPeers = fetchNodePeers(ctx, c, *networkPtr)
 		if len(networkPeers) == 0 {
 			logrus.Warnf("There is no peer on network %q, check the network ID, and verify that is the non truncated version", *networkPtr)
 		}
@@ -88,94 +62,61 @@ func main() {
 
 	switch *tablePtr {
 	case "sd":
-		fetchTable(*ipPtr, *portPtr, *networkPtr, "endpoint_table", clusterPeers, networkPeers, *remediatePtr)
+		fetchTable(ctx, c, *networkPtr, "endpoint_table", clusterPeers, networkPeers, *remediatePtr)
 	case "overlay":
-		fetchTable(*ipPtr, *portPtr, *networkPtr, "overlay_peer_table", clusterPeers, networkPeers, *remediatePtr)
+		fetchTable(ctx, c, *networkPtr, "overlay_peer_table", clusterPeers, networkPeers, *remediatePtr)
 	}
 
 	if joinedNetwork {
 		logrus.Infof("Leaving the network:%q", *networkPtr)
-		resp, err = http.Get(fmt.Sprintf(leaveNetwork, *ipPtr, *portPtr, *networkPtr))
-		if err != nil {
+		if err := c.LeaveNetwork(ctx, *networkPtr); err != nil {
 			logrus.WithError(err).Fatalf("Failed leaving the network")
 		}
-		httpIsOk(resp.Body)
 	}
 }
 
-func fetchNodePeers(ip string, port int, network string) map[string]string {
+func fetchNodePeers(ctx context.Context, c *client.Client, network string) map[string]string {
+	var (
+		peers []client.Peer
+		err   error
+	)
 	if network == "" {
 		logrus.Infof("Fetch cluster peers")
+		peers, err = c.ClusterPeers(ctx)
 	} else {
 		logrus.Infof("Fetch peers network:%q", network)
+		peers, err = c.NetworkPeers(ctx, network)
 	}
-
-	var path string
-	if network != "" {
-		path = fmt.Sprintf(networkPeers, ip, port, network)
-	} else {
-		path = fmt.Sprintf(clusterPeers, ip, port)
-	}
-
-	resp, err := http.Get(path)
 	if err != nil {
-		logrus.WithError(err).Fatalf("Failed fetching path")
-	}
-	defer resp.Body.Close()
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		logrus.WithError(err).Fatalf("Failed the body parse")
-	}
-
-	output := diagnostic.HTTPResult{Details: &diagnostic.TablePeersResult{}}
-	err = json.Unmarshal(body, &output)
-	if err != nil {
-		logrus.WithError(err).Fatalf("Failed the json unmarshalling")
+		logrus.WithError(err).Fatalf("Failed fetching the peers")
 	}
 
-	logrus.Debugf("Parsing JSON response")
-	result := make(map[string]string, output.Details.(*diagnostic.TablePeersResult).Length)
-	for _, v := range output.Details.(*diagnostic.TablePeersResult).Elements {
+	result := make(map[string]string, len(peers))
+	for _, v := range peers {
 		logrus.Debugf("name:%s ip:%s", v.Name, v.IP)
 		result[v.Name] = v.IP
 	}
 	return result
 }
 
-func fetchTable(ip string, port int, network, tableName string, clusterPeers, networkPeers map[string]string, remediate bool) {
+func fetchTable(ctx context.Context, c *client.Client, network, tableName string, clusterPeers, networkPeers map[string]string, remediate bool) {
 	logrus.Infof("Fetch %s table and check owners", tableName)
-	resp, err := http.Get(fmt.Sprintf(dumpTable, ip, port, network, tableName))
+	entries, err := c.Table(ctx, network, tableName)
 	if err != nil {
 		logrus.WithError(err).Fatalf("Failed fetching endpoint table")
 	}
-	defer resp.Body.Close()
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		logrus.WithError(err).Fatalf("Failed the body parse")
-	}
-
-	output := diagnostic.HTTPResult{Details: &diagnostic.TableEndpointsResult{}}
-	err = json.Unmarshal(body, &output)
-	if err != nil {
-		logrus.WithError(err).Fatalf("Failed the json unmarshalling")
-	}
 
 	logrus.Debug("Parsing data structures")
 	var orphanKeys []string
-	for _, v := range output.Details.(*diagnostic.TableEndpointsResult).Elements {
-		decoded, err := base64.StdEncoding.DecodeString(v.Value)
-		if err != nil {
-			logrus.WithError(err).Errorf("Failed decoding entry")
-			continue
-		}
+	for _, v := range entries {
 		switch tableName {
 		case "endpoint_table":
 			var elem libnetwork.EndpointRecord
-			elem.Unmarshal(decoded)
+			elem.Unmarshal(v.Value)
 			logrus.Debugf("key:%s value:%+v owner:%s", v.Key, elem, v.Owner)
 		case "overlay_peer_table":
 			var elem overlay.PeerRecord
-			elem.Unmarshal(decoded)
+			elem.Unmarshal(v.Value)
 			logrus.Debugf("key:%s value:%+v owner:%s", v.Key, elem, v.Owner)
 		}
 
@@ -195,12 +136,10 @@ func fetchTable(ip string, port int, network, tableName string, clusterPeers, ne
 		text = strings.Replace(text, "\n", "", -1)
 		if strings.Compare(text, "Yes") == 0 {
 			for _, k := range orphanKeys {
-				resp, err := http.Get(fmt.Sprintf(deleteEntry, ip, port, network, tableName, k))
-				if err != nil {
+				if err := c.DeleteEntry(ctx, network, tableName, k); err != nil {
 					logrus.WithError(err).Errorf("Failed deleting entry k:%s", k)
 					break
 				}
-				resp.Body.Close()
 			}
 		} else {
 			logrus.Infof("Deletion skipped")
@@ -0,0 +1,63 @@
+// kvmigrate copies the libnetwork state from a libkv backend, typically an
+// etcd cluster accessed through the v2 API, to an etcd v3 keyspace.
+//
+//	kvmigrate -from etcd://10.0.0.1:2379 -to etcdv3://10.0.0.1:2379
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/docker/libkv"
+	"github.com/docker/libkv/store"
+	"github.com/docker/libkv/store/consul"
+	"github.com/docker/libkv/store/etcd"
+	"github.com/docker/libkv/store/zookeeper"
+	"github.com/docker/libnetwork/datastore/etcdv3"
+	"github.com/sirupsen/logrus"
+)
+
+func newStore(uri string) (store.Store, error) {
+	parts := strings.SplitN(uri, "://", 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("invalid store uri %q, expected <backend>://<addr>[,<addr>]", uri)
+	}
+	return libkv.NewStore(store.Backend(parts[0]), strings.Split(parts[1], ","), &store.Config{})
+}
+
+func main() {
+	fromPtr := flag.String("from", "", "source store, <backend>://<addr>[,<addr>]")
+	toPtr := flag.String("to", "", "destination store, <backend>://<addr>[,<addr>]")
+	rootPtr := flag.String("root", "docker/network", "root of the keyspace to copy")
+	overwritePtr := flag.Bool("overwrite", false, "overwrite the keys already present in the destination")
+	flag.Parse()
+
+	if *fromPtr == "" || *toPtr == "" {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	consul.Register()
+	etcd.Register()
+	etcdv3.Register()
+	zookeeper.Register()
+
+	src, err := newStore(*fromPtr)
+	if err != nil {
+		logrus.Fatalf("Failed to open the source store: %v", err)
+	}
+	defer src.Close()
+	dst, err := newStore(*toPtr)
+	if err != nil {
+		logrus.Fatalf("Failed to open the destination store: %v", err)
+	}
+	defer dst.Close()
+
+	n, err := etcdv3.Migrate(src, dst, *rootPtr, *overwritePtr)
+	if err != nil {
+		logrus.Fatalf("Migration failed after %d keys: %v", n, err)
+	}
+	logrus.Infof("Copied %d keys under %s", n, *rootPtr)
+}
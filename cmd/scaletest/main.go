@@ -0,0 +1,354 @@
+// scaletest creates thousands of synthetic endpoints, each joined to a
+// sandbox of its own with its namespace and veth pair, on a controller
+// running in the process, applies a filter policy on them and tears them all
+// down, reporting the latencies of the operations and the memory used along
+// the way. It is meant to check the performance work on the drivers against
+// a baseline before it gets merged.
+//
+//	scaletest -endpoints 2000 -networks 4 -parallel 16 -rules 8
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/docker/docker/pkg/reexec"
+	"github.com/docker/libnetwork"
+	"github.com/docker/libnetwork/config"
+	"github.com/docker/libnetwork/driverapi"
+	"github.com/docker/libnetwork/netlabel"
+	"github.com/docker/libnetwork/options"
+	"github.com/docker/libnetwork/types"
+	"github.com/sirupsen/logrus"
+)
+
+// latencies are the durations of the calls of an operation
+type latencies struct {
+	sync.Mutex
+	l      []time.Duration
+	failed int
+}
+
+func (l *latencies) time(fn func() error) error {
+	start := time.Now()
+	err := fn()
+	l.Lock()
+	if err != nil {
+		l.failed++
+	} else {
+		l.l = append(l.l, time.Since(start))
+	}
+	l.Unlock()
+	return err
+}
+
+// OpStats are the latencies of the calls of an operation
+type OpStats struct {
+	Op     string        `json:"op"`
+	Count  int           `json:"count"`
+	Failed int           `json:"failed"`
+	Total  time.Duration `json:"total"`
+	P50    time.Duration `json:"p50"`
+	P90    time.Duration `json:"p90"`
+	P99    time.Duration `json:"p99"`
+	Max    time.Duration `json:"max"`
+}
+
+func (l *latencies) stats(op string) OpStats {
+	l.Lock()
+	defer l.Unlock()
+	s := OpStats{Op: op, Count: len(l.l), Failed: l.failed}
+	if len(l.l) == 0 {
+		return s
+	}
+	sorted := append([]time.Duration(nil), l.l...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	for _, d := range sorted {
+		s.Total += d
+	}
+	pct := func(p int) time.Duration { return sorted[(len(sorted)-1)*p/100] }
+	s.P50, s.P90, s.P99, s.Max = pct(50), pct(90), pct(99), sorted[len(sorted)-1]
+	return s
+}
+
+// MemStats is the memory used by the process at the end of a phase
+type MemStats struct {
+	Phase      string `json:"phase"`
+	HeapAlloc  uint64 `json:"heap_alloc"`
+	Sys        uint64 `json:"sys"`
+	RSS        uint64 `json:"rss"`
+	Goroutines int    `json:"goroutines"`
+}
+
+func readMemStats(phase string) MemStats {
+	runtime.GC()
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	return MemStats{Phase: phase, HeapAlloc: m.HeapAlloc, Sys: m.Sys, RSS: readRSS(), Goroutines: runtime.NumGoroutine()}
+}
+
+// readRSS returns the resident memory of the process, 0 when unknown
+func readRSS() uint64 {
+	b, err := ioutil.ReadFile("/proc/self/status")
+	if err != nil {
+		return 0
+	}
+	for _, line := range strings.Split(string(b), "\n") {
+		if !strings.HasPrefix(line, "VmRSS:") {
+			continue
+		}
+		f := strings.Fields(line)
+		if len(f) < 2 {
+			return 0
+		}
+		kb, _ := strconv.ParseUint(f[1], 10, 64)
+		return kb * 1024
+	}
+	return 0
+}
+
+// Report is the outcome of a run
+type Report struct {
+	Driver    string        `json:"driver"`
+	Networks  int           `json:"networks"`
+	Endpoints int           `json:"endpoints"`
+	Parallel  int           `json:"parallel"`
+	Elapsed   time.Duration `json:"elapsed"`
+	Ops       []OpStats     `json:"ops"`
+	Memory    []MemStats    `json:"memory"`
+}
+
+func (r *Report) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "driver:%s networks:%d endpoints:%d parallel:%d elapsed:%s\n", r.Driver, r.Networks, r.Endpoints, r.Parallel, r.Elapsed)
+	for _, s := range r.Ops {
+		fmt.Fprintf(&b, "%-16s count:%-6d failed:%-4d total:%-12s p50:%-10s p90:%-10s p99:%-10s max:%s\n",
+			s.Op, s.Count, s.Failed, s.Total, s.P50, s.P90, s.P99, s.Max)
+	}
+	for _, m := range r.Memory {
+		fmt.Fprintf(&b, "%-16s heap:%dMiB sys:%dMiB rss:%dMiB goroutines:%d\n",
+			m.Phase, m.HeapAlloc>>20, m.Sys>>20, m.RSS>>20, m.Goroutines)
+	}
+	return b.String()
+}
+
+// filterPolicy allows tcp to as many ports as rules from the endpoints of the
+// network
+func filterPolicy(version string, rules int) *driverapi.FilterPolicy {
+	p := &driverapi.FilterPolicy{Version: version}
+	for i := 0; i < rules; i++ {
+		p.Allow = append(p.Allow, driverapi.FilterRule{Proto: "tcp", Ports: strconv.Itoa(8000 + i), Source: "${NETWORK_SUBNET}"})
+	}
+	return p
+}
+
+type synthetic struct {
+	ep libnetwork.Endpoint
+	sb libnetwork.Sandbox
+}
+
+func main() {
+	if reexec.Init() {
+		return
+	}
+
+	driverPtr := flag.String("driver", "bridge", "driver of the networks")
+	networksPtr := flag.Int("networks", 1, "number of networks the endpoints are spread over")
+	endpointsPtr := flag.Int("endpoints", 1000, "number of endpoints")
+	parallelPtr := flag.Int("parallel", 8, "number of endpoints set up and torn down at once")
+	rulesPtr := flag.Int("rules", 8, "number of rules of the filter policy applied on the endpoints, 0 for none")
+	keepPtr := flag.Bool("keep", false, "keep the endpoints, skipping the teardown")
+	jsonPtr := flag.Bool("json", false, "print the report in JSON")
+	verbosePtr := flag.Bool("v", false, "verbose output")
+	flag.Parse()
+
+	if *networksPtr < 1 || *endpointsPtr < 1 || *parallelPtr < 1 {
+		flag.Usage()
+		os.Exit(1)
+	}
+	if *verbosePtr {
+		logrus.SetLevel(logrus.DebugLevel)
+	} else {
+		logrus.SetLevel(logrus.WarnLevel)
+	}
+
+	dataDir, err := ioutil.TempDir("", "scaletest")
+	if err != nil {
+		logrus.Fatalf("Failed to create the data directory: %v", err)
+	}
+	defer os.RemoveAll(dataDir)
+
+	genericOption := map[string]interface{}{
+		netlabel.GenericData: options.Generic{"EnableIPForwarding": true, "EnableIPTables": true},
+	}
+	c, err := libnetwork.New(config.OptionDataDir(dataDir), config.OptionDriverConfig(*driverPtr, genericOption))
+	if err != nil {
+		logrus.Fatalf("Failed to start the controller: %v", err)
+	}
+	defer c.Stop()
+
+	// An interruption stops the setup and goes on with the teardown
+	ctx, cancel := context.WithCancel(context.Background())
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sigCh
+		logrus.Warn("Interrupted, tearing the endpoints down")
+		cancel()
+	}()
+
+	r := run(ctx, c, *driverPtr, *networksPtr, *endpointsPtr, *parallelPtr, *rulesPtr, *keepPtr)
+	if *jsonPtr {
+		b, _ := json.MarshalIndent(r, "", "  ")
+		fmt.Println(string(b))
+		return
+	}
+	fmt.Print(r.String())
+}
+
+func run(ctx context.Context, c libnetwork.NetworkController, driver string, networks, endpoints, parallel, rules int, keep bool) *Report {
+	var (
+		start    = time.Now()
+		r        = &Report{Driver: driver, Networks: networks, Endpoints: endpoints, Parallel: parallel}
+		netOps   latencies
+		epOps    latencies
+		sbOps    latencies
+		joinOps  latencies
+		stageOps latencies
+		downOps  latencies
+		delOps   latencies
+	)
+	r.Memory = append(r.Memory, readMemStats("start"))
+
+	var nws []libnetwork.Network
+	for i := 0; i < networks; i++ {
+		var n libnetwork.Network
+		err := netOps.time(func() (err error) {
+			n, err = c.NewNetwork(driver, fmt.Sprintf("scale-%d", i), "")
+			return err
+		})
+		if err != nil {
+			logrus.Errorf("Failed to create network scale-%d: %v", i, err)
+			continue
+		}
+		nws = append(nws, n)
+	}
+
+	eps := make([]*synthetic, endpoints)
+	if len(nws) > 0 {
+		forEach(ctx, endpoints, parallel, func(i int) {
+			n := nws[i%len(nws)]
+			s := &synthetic{}
+			if err := epOps.time(func() (err error) {
+				s.ep, err = n.CreateEndpoint(fmt.Sprintf("scale-ep-%d", i))
+				return err
+			}); err != nil {
+				logrus.Errorf("Failed to create endpoint %d: %v", i, err)
+				return
+			}
+			eps[i] = s
+			if err := sbOps.time(func() (err error) {
+				s.sb, err = c.NewSandbox(fmt.Sprintf("scale-%d", i), libnetwork.OptionHostname(fmt.Sprintf("scale-%d", i)))
+				return err
+			}); err != nil {
+				logrus.Errorf("Failed to create the sandbox of endpoint %d: %v", i, err)
+				return
+			}
+			if err := joinOps.time(func() error { return s.ep.Join(s.sb) }); err != nil {
+				logrus.Errorf("Failed to join endpoint %d: %v", i, err)
+			}
+		})
+	}
+	r.Memory = append(r.Memory, readMemStats("joined"))
+
+	// The policy is staged on all the endpoints of a network at once, which
+	// is the time the driver takes to program their filters
+	if rules > 0 && ctx.Err() == nil {
+		for _, n := range nws {
+			var canaries []string
+			for _, ep := range n.Endpoints() {
+				canaries = append(canaries, ep.ID())
+			}
+			if len(canaries) == 0 {
+				continue
+			}
+			ro := &libnetwork.FilterRollout{Policy: filterPolicy("scale-v1", rules), Canaries: canaries}
+			if err := stageOps.time(func() error { return c.StageFilterPolicy(n.ID(), ro) }); err != nil {
+				if _, ok := err.(types.NotImplementedError); ok {
+					logrus.Warnf("The %s driver does not filter the endpoints", driver)
+					break
+				}
+				logrus.Errorf("Failed to apply the filter policy on network %s: %v", n.Name(), err)
+			}
+		}
+		r.Memory = append(r.Memory, readMemStats("filtered"))
+	}
+
+	if !keep {
+		forEach(context.Background(), endpoints, parallel, func(i int) {
+			s := eps[i]
+			if s == nil {
+				return
+			}
+			if s.sb != nil {
+				if err := downOps.time(s.sb.Delete); err != nil {
+					logrus.Errorf("Failed to delete the sandbox of endpoint %d: %v", i, err)
+				}
+			}
+			if err := delOps.time(func() error { return s.ep.Delete(false) }); err != nil {
+				logrus.Errorf("Failed to delete endpoint %d: %v", i, err)
+			}
+		})
+		for _, n := range nws {
+			if err := n.Delete(); err != nil {
+				logrus.Errorf("Failed to delete network %s: %v", n.Name(), err)
+			}
+		}
+		r.Memory = append(r.Memory, readMemStats("torn down"))
+	}
+
+	r.Ops = []OpStats{
+		netOps.stats("network create"),
+		epOps.stats("endpoint create"),
+		sbOps.stats("sandbox create"),
+		joinOps.stats("join"),
+		stageOps.stats("filter apply"),
+		downOps.stats("sandbox delete"),
+		delOps.stats("endpoint delete"),
+	}
+	r.Elapsed = time.Since(start)
+	return r
+}
+
+// forEach calls fn for the indexes up to n from parallel goroutines, no more
+// calls starting once the context is done
+func forEach(ctx context.Context, n, parallel int, fn func(i int)) {
+	var wg sync.WaitGroup
+	ch := make(chan int)
+	for w := 0; w < parallel; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range ch {
+				fn(i)
+			}
+		}()
+	}
+	for i := 0; i < n && ctx.Err() == nil; i++ {
+		ch <- i
+	}
+	close(ch)
+	wg.Wait()
+}
@@ -2,17 +2,25 @@ package config
 
 import (
 	"strings"
+	"time"
 
 	"github.com/BurntSushi/toml"
 	"github.com/docker/docker/pkg/discovery"
 	"github.com/docker/docker/pkg/plugingetter"
 	"github.com/docker/go-connections/tlsconfig"
 	"github.com/docker/libkv/store"
+	"github.com/docker/libnetwork/authz"
 	"github.com/docker/libnetwork/cluster"
 	"github.com/docker/libnetwork/datastore"
+	"github.com/docker/libnetwork/ephook"
+	"github.com/docker/libnetwork/ipams/rest"
 	"github.com/docker/libnetwork/ipamutils"
+	"github.com/docker/libnetwork/lbhook"
 	"github.com/docker/libnetwork/netlabel"
 	"github.com/docker/libnetwork/osl"
+	"github.com/docker/libnetwork/otlp"
+	"github.com/docker/libnetwork/routeexport"
+	"github.com/docker/libnetwork/webhook"
 	"github.com/sirupsen/logrus"
 )
 
@@ -43,6 +51,100 @@ type DaemonCfg struct {
 	ClusterProvider        cluster.Provider
 	NetworkControlPlaneMTU int
 	DefaultAddressPool     []*ipamutils.NetworkToSplit
+	MaxEndpointsPerNetwork int
+	MaxSandboxes           int
+	SandboxPoolSize        int
+	OpLatencyBudget        time.Duration
+	OrphanCleanupInterval  time.Duration
+	NextHopProbeInterval   time.Duration
+	NextHopRemoveRoutes    bool
+	AccountingInterval     time.Duration
+	SocketAuditInterval    time.Duration
+	LLDPUplinks            []string
+	LLDPInterval           time.Duration
+	FailoverUplinks        []string
+	FlowExportEnterprise   uint32
+	DiagnosticAuthToken    string
+	DiagnosticProfiling    bool
+	NetworkDBQueueLimit    int
+	NetworkDBQueuePolicy   string
+	NetworkDBSnapshotPort  int
+	LBHooks                map[string]lbhook.Provider
+	EndpointHooks          map[string]ephook.Hook
+	Webhooks               []webhook.Target
+	WebhookDeadLetterPath  string
+	JournalPath            string
+	SplitBrain             *SplitBrainPolicy
+	OTLP                   *otlp.Config
+	RESTIPAMs              []rest.Config
+	RouteExporters         map[string]routeexport.Exporter
+	Authorizer             authz.Authorizer
+	DriverOpLimits         map[string]DriverOpLimit
+	IPv6StableSecret       string
+	IPv6ULA                *IPv6ULA
+	InterNetworkPolicies   []InterNetworkPolicy
+	FaultInjection         bool
+	Faults                 string
+}
+
+// DriverOpLimit caps the driver calls of an operation, across the drivers
+type DriverOpLimit struct {
+	// Concurrency is the number of calls in progress at most, none for no cap
+	Concurrency int
+	// Rate is the number of calls started per second, none for no limit
+	Rate float64
+	// Burst is the number of calls which can start at once above the rate
+	Burst int
+}
+
+// SplitBrainPolicy configures the detection of the partitions of the
+// global datastore and of the gossip cluster, and the fencing applied to
+// the global networks while partitioned. The unset durations and ratio
+// take their defaults.
+type SplitBrainPolicy struct {
+	// Fencing is "none" for the partitions to be only detected and
+	// reported, or "freeze" for the updates of the service bindings from
+	// the cluster to be held meanwhile, the load balancers keeping their
+	// last known good backends
+	Fencing string
+	// HeartbeatInterval is the interval the heartbeat of the controller
+	// is written to the global datastore and the signals checked at
+	HeartbeatInterval time.Duration
+	// HeartbeatTTL is how long a heartbeat holds once not written again
+	HeartbeatTTL time.Duration
+	// NodeRatio is the ratio of the peers failed in the gossip cluster,
+	// or with a stale heartbeat, at which a partition is suspected
+	NodeRatio float64
+	// HealGrace is how long the signals must be clear before the held
+	// updates are applied
+	HealGrace time.Duration
+	// MaxFence bounds how long the updates are held, the outages lasting
+	// longer being taken as real failures
+	MaxFence time.Duration
+}
+
+// IPv6ULA configures the RFC 4193 unique local prefix of the cluster, the
+// IPv6 subnets of the networks created without any being carved from it
+type IPv6ULA struct {
+	// Prefix is the unique local prefix, generated as a /48 and kept in
+	// the datastore when empty
+	Prefix string
+	// SubnetSize is the prefix length of the subnets of the networks, 64
+	// when unset
+	SubnetSize int
+}
+
+// InterNetworkPolicy tells whether and how the endpoints of a local network
+// reach the ones of another local network, the networks being given by
+// name or ID
+type InterNetworkPolicy struct {
+	From string
+	To   string
+	// Action is allow or deny
+	Action string
+	// Bidirectional lets the endpoints of To open connections to the ones
+	// of From too, the replies only getting back otherwise
+	Bidirectional bool
 }
 
 // ClusterCfg represents cluster configuration
@@ -54,11 +156,21 @@ type ClusterCfg struct {
 }
 
 // LoadDefaultScopes loads default scope configs for scopes which
-// doesn't have explicit user specified configs.
+// doesn't have explicit user specified configs. A scope carrying only
+// a key provider gets the default provider and address.
 func (c *Config) LoadDefaultScopes(dataDir string) {
 	for k, v := range datastore.DefaultScopes(dataDir) {
-		if _, ok := c.Scopes[k]; !ok {
+		s, ok := c.Scopes[k]
+		if !ok {
 			c.Scopes[k] = v
+			continue
+		}
+		if s.Client.KeyProvider != nil && s.Client.Provider == "" && s.Client.Address == "" {
+			s.Client.Provider = v.Client.Provider
+			s.Client.Address = v.Client.Address
+			if s.Client.Config == nil {
+				s.Client.Config = v.Client.Config
+			}
 		}
 	}
 }
@@ -253,6 +365,318 @@ func OptionNetworkControlPlaneMTU(exp int) Option {
 	}
 }
 
+// OptionMaxEndpointsPerNetwork function returns an option setter for the
+// maximum number of endpoints of a network, zero meaning no limit
+func OptionMaxEndpointsPerNetwork(max int) Option {
+	return func(c *Config) {
+		logrus.Debugf("Option MaxEndpointsPerNetwork: %d", max)
+		c.Daemon.MaxEndpointsPerNetwork = max
+	}
+}
+
+// OptionMaxSandboxes function returns an option setter for the maximum
+// number of container sandboxes on the host, zero meaning no limit
+func OptionMaxSandboxes(max int) Option {
+	return func(c *Config) {
+		logrus.Debugf("Option MaxSandboxes: %d", max)
+		c.Daemon.MaxSandboxes = max
+	}
+}
+
+// OptionSandboxPoolSize function returns an option setter for the number of
+// sandboxes pre-created for the containers to claim
+func OptionSandboxPoolSize(size int) Option {
+	return func(c *Config) {
+		logrus.Debugf("Option SandboxPoolSize: %d", size)
+		c.Daemon.SandboxPoolSize = size
+	}
+}
+
+// OptionOpLatencyBudget function returns an option setter for the latency
+// budget of the endpoint creations, joins and leaves, whose calls going over
+// it get the breakdown of their steps logged. Zero disables the timing.
+func OptionOpLatencyBudget(budget time.Duration) Option {
+	return func(c *Config) {
+		logrus.Debugf("Option OpLatencyBudget: %s", budget)
+		c.Daemon.OpLatencyBudget = budget
+	}
+}
+
+// OptionDriverOpLimit function returns an option setter for the limit of
+// the driver calls of the operation, "endpoint-create" or "join", for a
+// mass container restart not to overwhelm the kernel and stall the other
+// operations
+func OptionDriverOpLimit(op string, limit DriverOpLimit) Option {
+	return func(c *Config) {
+		logrus.Debugf("Option DriverOpLimit: %s %+v", op, limit)
+		if c.Daemon.DriverOpLimits == nil {
+			c.Daemon.DriverOpLimits = make(map[string]DriverOpLimit)
+		}
+		c.Daemon.DriverOpLimits[op] = limit
+	}
+}
+
+// OptionOrphanCleanupInterval function returns an option setter for the
+// interval at which the elected controller reclaims the global scope
+// endpoints of the hosts which left the cluster, zero disabling it
+func OptionOrphanCleanupInterval(interval time.Duration) Option {
+	return func(c *Config) {
+		logrus.Debugf("Option OrphanCleanupInterval: %v", interval)
+		c.Daemon.OrphanCleanupInterval = interval
+	}
+}
+
+// OptionNetworkDBQueueLimit function returns an option setter for the
+// maximum number of table broadcasts queued per network by networkdb and
+// the policy applied when it is reached
+func OptionNetworkDBQueueLimit(limit int, policy string) Option {
+	return func(c *Config) {
+		logrus.Debugf("Option NetworkDBQueueLimit: %d %s", limit, policy)
+		c.Daemon.NetworkDBQueueLimit = limit
+		c.Daemon.NetworkDBQueuePolicy = policy
+	}
+}
+
+// OptionNetworkDBSnapshotPort function returns an option setter for the
+// TCP port on which networkdb serves the table snapshots to the joining nodes
+func OptionNetworkDBSnapshotPort(port int) Option {
+	return func(c *Config) {
+		logrus.Debugf("Option NetworkDBSnapshotPort: %d", port)
+		c.Daemon.NetworkDBSnapshotPort = port
+	}
+}
+
+// OptionNextHopProbeInterval function returns an option setter for the
+// interval at which the next hops configured on the networks, the gateways
+// and the next hops of the static routes programmed in the sandboxes are
+// probed, zero disabling the probes
+func OptionNextHopProbeInterval(interval time.Duration) Option {
+	return func(c *Config) {
+		logrus.Debugf("Option NextHopProbeInterval: %v", interval)
+		c.Daemon.NextHopProbeInterval = interval
+	}
+}
+
+// OptionNextHopRemoveRoutes function returns an option setter to remove the
+// routes through a next hop found down from the sandboxes, until it is up
+func OptionNextHopRemoveRoutes(remove bool) Option {
+	return func(c *Config) {
+		logrus.Debugf("Option NextHopRemoveRoutes: %v", remove)
+		c.Daemon.NextHopRemoveRoutes = remove
+	}
+}
+
+// OptionAccountingInterval function returns an option setter for the
+// interval at which the traffic counters of the endpoints are scraped for
+// their monthly accounting and byte quotas, zero disabling the accounting
+func OptionAccountingInterval(interval time.Duration) Option {
+	return func(c *Config) {
+		logrus.Debugf("Option AccountingInterval: %v", interval)
+		c.Daemon.AccountingInterval = interval
+	}
+}
+
+// OptionSocketAuditInterval function returns an option setter for the
+// interval at which the connect() calls rejected by the socket policies of
+// the endpoints are published, zero disabling the audit
+func OptionSocketAuditInterval(interval time.Duration) Option {
+	return func(c *Config) {
+		logrus.Debugf("Option SocketAuditInterval: %v", interval)
+		c.Daemon.SocketAuditInterval = interval
+	}
+}
+
+// OptionLLDPUplinks function returns an option setter for the uplinks the
+// identity of the host and its container prefixes are advertised on through
+// LLDP, at the interval or lldp.DefaultInterval when zero
+func OptionLLDPUplinks(uplinks []string, interval time.Duration) Option {
+	return func(c *Config) {
+		logrus.Debugf("Option LLDPUplinks: %v every %v", uplinks, interval)
+		c.Daemon.LLDPUplinks = uplinks
+		c.Daemon.LLDPInterval = interval
+	}
+}
+
+// OptionFailoverUplinks function returns an option setter for the bonding
+// or teaming uplinks watched for failovers, after which the next hops, the
+// gratuitous ARPs and the overlay tunnels are programmed again
+func OptionFailoverUplinks(uplinks []string) Option {
+	return func(c *Config) {
+		logrus.Debugf("Option FailoverUplinks: %v", uplinks)
+		c.Daemon.FailoverUplinks = uplinks
+	}
+}
+
+// OptionFlowExportEnterprise function returns an option setter for the
+// private enterprise number of the endpoint elements of the exported flows
+func OptionFlowExportEnterprise(number uint32) Option {
+	return func(c *Config) {
+		logrus.Debugf("Option FlowExportEnterprise: %d", number)
+		c.Daemon.FlowExportEnterprise = number
+	}
+}
+
+// OptionLBHook function returns an option setter registering an external
+// load balancer hook, which the services of the networks labeled with its
+// name are published to
+func OptionLBHook(name string, provider lbhook.Provider) Option {
+	return func(c *Config) {
+		logrus.Debugf("Option LBHook: %s", name)
+		if c.Daemon.LBHooks == nil {
+			c.Daemon.LBHooks = map[string]lbhook.Provider{}
+		}
+		c.Daemon.LBHooks[name] = provider
+	}
+}
+
+// OptionEndpointHook function returns an option setter registering a
+// hook run on the lifecycle of the endpoints of the networks labeled with
+// its name
+func OptionEndpointHook(name string, hook ephook.Hook) Option {
+	return func(c *Config) {
+		logrus.Debugf("Option EndpointHook: %s", name)
+		if c.Daemon.EndpointHooks == nil {
+			c.Daemon.EndpointHooks = map[string]ephook.Hook{}
+		}
+		c.Daemon.EndpointHooks[name] = hook
+	}
+}
+
+// OptionWebhook function returns an option setter adding a target the
+// lifecycle events are posted to
+func OptionWebhook(target webhook.Target) Option {
+	return func(c *Config) {
+		logrus.Debugf("Option Webhook: %s %s", target.Name, target.URL)
+		c.Daemon.Webhooks = append(c.Daemon.Webhooks, target)
+	}
+}
+
+// OptionWebhookDeadLetterPath function returns an option setter for the
+// file the webhook deliveries given up on are appended to
+func OptionWebhookDeadLetterPath(path string) Option {
+	return func(c *Config) {
+		logrus.Debugf("Option WebhookDeadLetterPath: %s", path)
+		c.Daemon.WebhookDeadLetterPath = path
+	}
+}
+
+// OptionJournalPath function returns an option setter for the file the
+// operations on the networks and endpoints are journaled to
+func OptionJournalPath(path string) Option {
+	return func(c *Config) {
+		logrus.Debugf("Option JournalPath: %s", path)
+		c.Daemon.JournalPath = path
+	}
+}
+
+// OptionSplitBrainPolicy function returns an option setter for the
+// detection of the partitions and the fencing of the global networks
+func OptionSplitBrainPolicy(p SplitBrainPolicy) Option {
+	return func(c *Config) {
+		logrus.Debugf("Option SplitBrainPolicy: fencing %q", p.Fencing)
+		c.Daemon.SplitBrain = &p
+	}
+}
+
+// OptionOTLPExporter function returns an option setter for the collector
+// the spans of the timed operations and their duration metrics are
+// exported to
+func OptionOTLPExporter(cfg otlp.Config) Option {
+	return func(c *Config) {
+		logrus.Debugf("Option OTLPExporter: %s", cfg.Endpoint)
+		c.Daemon.OTLP = &cfg
+	}
+}
+
+// OptionRESTIPAM function returns an option setter registering an ipam
+// driver which delegates the allocations to an external IPAM system over
+// its REST contract
+func OptionRESTIPAM(cfg rest.Config) Option {
+	return func(c *Config) {
+		logrus.Debugf("Option RESTIPAM: %s %s", cfg.Name, cfg.URL)
+		c.Daemon.RESTIPAMs = append(c.Daemon.RESTIPAMs, cfg)
+	}
+}
+
+// OptionRouteExporter function returns an option setter registering a
+// route exporter, which the drivers export the routes of the networks
+// labeled with its name to
+func OptionRouteExporter(name string, exporter routeexport.Exporter) Option {
+	return func(c *Config) {
+		logrus.Debugf("Option RouteExporter: %s", name)
+		if c.Daemon.RouteExporters == nil {
+			c.Daemon.RouteExporters = map[string]routeexport.Exporter{}
+		}
+		c.Daemon.RouteExporters[name] = exporter
+	}
+}
+
+// OptionAuthorizer function returns an option setter for the authorizer the
+// controller asks before carrying its operations out
+func OptionAuthorizer(a authz.Authorizer) Option {
+	return func(c *Config) {
+		logrus.Debugf("Option Authorizer: %T", a)
+		c.Daemon.Authorizer = a
+	}
+}
+
+// OptionDiagnosticAuthToken function returns an option setter for the bearer
+// token the requests to the diagnostic server have to carry
+func OptionDiagnosticAuthToken(token string) Option {
+	return func(c *Config) {
+		logrus.Debugf("Option DiagnosticAuthToken set")
+		c.Daemon.DiagnosticAuthToken = token
+	}
+}
+
+// OptionIPv6StableSecret function returns an option setter for the secret
+// key the stable-privacy IPv6 addresses of the endpoints are derived with
+func OptionIPv6StableSecret(secret string) Option {
+	return func(c *Config) {
+		logrus.Debugf("Option IPv6StableSecret set")
+		c.Daemon.IPv6StableSecret = secret
+	}
+}
+
+// OptionIPv6ULA function returns an option setter for the unique local
+// prefix the IPv6 subnets of the networks are carved from
+func OptionIPv6ULA(u IPv6ULA) Option {
+	return func(c *Config) {
+		logrus.Debugf("Option IPv6ULA: prefix %q, subnet size %d", u.Prefix, u.SubnetSize)
+		c.Daemon.IPv6ULA = &u
+	}
+}
+
+// OptionDiagnosticProfiling function returns an option setter to expose the
+// pprof handlers on the diagnostic server
+func OptionDiagnosticProfiling(enable bool) Option {
+	return func(c *Config) {
+		logrus.Debugf("Option DiagnosticProfiling: %v", enable)
+		c.Daemon.DiagnosticProfiling = enable
+	}
+}
+
+// OptionInterNetworkPolicies function returns an option setter for the
+// policies of the traffic between the local networks
+func OptionInterNetworkPolicies(policies []InterNetworkPolicy) Option {
+	return func(c *Config) {
+		logrus.Debugf("Option InterNetworkPolicies: %v", policies)
+		c.Daemon.InterNetworkPolicies = policies
+	}
+}
+
+// OptionFaultInjection function returns an option setter enabling the fault
+// injection in the programming of the network, with the faults of the spec
+// as of faults.Configure. The faults can be changed through the diagnostic
+// server from then on.
+func OptionFaultInjection(spec string) Option {
+	return func(c *Config) {
+		logrus.Warnf("Option FaultInjection: %q", spec)
+		c.Daemon.FaultInjection = true
+		c.Daemon.Faults = spec
+	}
+}
+
 // ProcessOptions processes options and stores it in config
 func (c *Config) ProcessOptions(options ...Option) {
 	for _, opt := range options {
@@ -300,6 +724,30 @@ func OptionLocalKVProviderConfig(config *store.Config) Option {
 	}
 }
 
+// OptionKVResilience function returns an option setter for the retries,
+// the circuit breaker and the stale reads of the calls to the global kvstore
+func OptionKVResilience(r datastore.Resilience) Option {
+	return func(c *Config) {
+		logrus.Debugf("Option OptionKVResilience: %+v", r)
+		if _, ok := c.Scopes[datastore.GlobalScope]; !ok {
+			c.Scopes[datastore.GlobalScope] = &datastore.ScopeCfg{}
+		}
+		c.Scopes[datastore.GlobalScope].Client.Resilience = &r
+	}
+}
+
+// OptionLocalKVEncryption function returns an option setter for the key
+// provider used to encrypt the values of the local kvstore
+func OptionLocalKVEncryption(kp datastore.KeyProvider) Option {
+	return func(c *Config) {
+		logrus.Debugf("Option OptionLocalKVEncryption: %v", kp)
+		if _, ok := c.Scopes[datastore.LocalScope]; !ok {
+			c.Scopes[datastore.LocalScope] = &datastore.ScopeCfg{}
+		}
+		c.Scopes[datastore.LocalScope].Client.KeyProvider = kp
+	}
+}
+
 // OptionActiveSandboxes function returns an option setter for passing the sandboxes
 // which were active during previous daemon life
 func OptionActiveSandboxes(sandboxes map[string]interface{}) Option {
@@ -0,0 +1,128 @@
+package libnetwork
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/docker/libnetwork/config"
+	"github.com/docker/libnetwork/driverapi"
+	"github.com/sirupsen/logrus"
+)
+
+// liveDaemonSettings are the daemon settings the controller applies without
+// a restart, to the networks, endpoints and sandboxes created from then on.
+// The driver configurations are reloaded by the drivers implementing
+// driverapi.ConfigReloader.
+var liveDaemonSettings = map[string]bool{
+	"MaxEndpointsPerNetwork": true,
+	"MaxSandboxes":           true,
+	"SandboxPoolSize":        true,
+	"OpLatencyBudget":        true,
+	"DiagnosticAuthToken":    true,
+	"Authorizer":             true,
+	"DriverOpLimits":         true,
+	"IPv6StableSecret":       true,
+}
+
+// ReloadDaemonConfiguration applies the settings of the new daemon
+// configuration which can change at run time and returns the names of the
+// changed settings which require a restart, the driver ones as in
+// DriverCfg.bridge.EnableIPTables. The configuration is the complete one,
+// a setting it leaves out is changed to its zero value. The datastore
+// configurations are reloaded by ReloadConfiguration.
+func (c *controller) ReloadDaemonConfiguration(cfgOptions ...config.Option) ([]string, error) {
+	procReloadConfig <- true
+	defer func() { <-procReloadConfig }()
+
+	cfg := config.ParseConfigOptions(cfgOptions...)
+
+	c.Lock()
+	current := c.cfg.Daemon
+	c.Unlock()
+
+	var restart []string
+	next := current
+	cv, nv, rv := reflect.ValueOf(&current).Elem(), reflect.ValueOf(&cfg.Daemon).Elem(), reflect.ValueOf(&next).Elem()
+	for i := 0; i < cv.NumField(); i++ {
+		name := cv.Type().Field(i).Name
+		if name == "DriverCfg" || reflect.DeepEqual(cv.Field(i).Interface(), nv.Field(i).Interface()) {
+			continue
+		}
+		if !liveDaemonSettings[name] {
+			restart = append(restart, name)
+			continue
+		}
+		rv.Field(i).Set(nv.Field(i))
+	}
+
+	driverRestart, driverCfg := c.reloadDriverConfigs(current.DriverCfg, cfg.Daemon.DriverCfg)
+	restart = append(restart, driverRestart...)
+	next.DriverCfg = driverCfg
+
+	c.Lock()
+	updated := *c.cfg
+	updated.Daemon = next
+	c.cfg = &updated
+	c.Unlock()
+
+	if next.DiagnosticAuthToken != current.DiagnosticAuthToken {
+		c.DiagnosticServer.SetAuthToken(next.DiagnosticAuthToken)
+	}
+	if next.SandboxPoolSize != current.SandboxPoolSize && sandboxPoolSupported() {
+		if err := c.PoolSandboxes(next.SandboxPoolSize); err != nil {
+			logrus.Warnf("Failed to resize the sandbox pool to %d: %v", next.SandboxPoolSize, err)
+		}
+	}
+
+	sort.Strings(restart)
+	if len(restart) > 0 {
+		logrus.Warnf("Reloaded the network configuration, the changes of %s take effect on the next restart", strings.Join(restart, ", "))
+	} else {
+		logrus.Info("Reloaded the network configuration")
+	}
+	return restart, nil
+}
+
+// reloadDriverConfigs hands the changed driver configurations over to their
+// drivers, and returns the settings requiring a restart along the driver
+// configurations now in effect
+func (c *controller) reloadDriverConfigs(current, next map[string]interface{}) ([]string, map[string]interface{}) {
+	var restart []string
+	effective := make(map[string]interface{}, len(current))
+	for name, v := range current {
+		effective[name] = v
+	}
+
+	names := make(map[string]bool)
+	for name := range current {
+		names[name] = true
+	}
+	for name := range next {
+		names[name] = true
+	}
+
+	for name := range names {
+		if reflect.DeepEqual(current[name], next[name]) {
+			continue
+		}
+		d, _ := c.drvRegistry.Driver(name)
+		cr, ok := d.(driverapi.ConfigReloader)
+		drvCfg, isMap := next[name].(map[string]interface{})
+		if !ok || !isMap {
+			restart = append(restart, "DriverCfg."+name)
+			continue
+		}
+		settings, err := cr.ReloadConfig(drvCfg)
+		if err != nil {
+			logrus.Warnf("Failed to reload the configuration of the %s driver: %v", name, err)
+			restart = append(restart, "DriverCfg."+name)
+			continue
+		}
+		for _, s := range settings {
+			restart = append(restart, "DriverCfg."+name+"."+s)
+		}
+		effective[name] = next[name]
+	}
+	return restart, effective
+}
@@ -0,0 +1,383 @@
+package libnetwork
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/libnetwork/diagnostic"
+	"github.com/docker/libnetwork/driverapi"
+	"github.com/docker/libnetwork/internal/caller"
+	"github.com/docker/libnetwork/types"
+	"github.com/sirupsen/logrus"
+)
+
+// Probes of the connectivity checks
+const (
+	// ProbeICMP sends an ICMP echo request to the destination
+	ProbeICMP = "icmp"
+	// ProbeTCP opens a TCP connection to a port of the destination
+	ProbeTCP = "tcp"
+	// ProbeARP resolves the link layer address of the next hop towards the
+	// destination, with ARP or neighbor solicitations
+	ProbeARP = "arp"
+)
+
+// Status of the steps of the connectivity checks
+const (
+	HopOK      = "ok"
+	HopFailed  = "failed"
+	HopSkipped = "skipped"
+	HopInfo    = "info"
+)
+
+const (
+	defaultConnectivityTimeout = 2 * time.Second
+	maxConnectivityTimeout     = 30 * time.Second
+)
+
+// connectivityPaths2Func are the diagnostic handlers of the connectivity
+// checks
+var connectivityPaths2Func = map[string]diagnostic.HTTPHandlerFunc{
+	"/connectivity": connectivityDiag,
+}
+
+// ConnectivityCheck is an active test of the data path from an endpoint,
+// towards another endpoint or an address
+type ConnectivityCheck struct {
+	// NetworkID and EndpointID are the source endpoint, which has to be
+	// joined to a sandbox
+	NetworkID  string
+	EndpointID string
+	// DestNetworkID and DestEndpointID are the destination endpoint,
+	// unless Address is set
+	DestNetworkID  string
+	DestEndpointID string
+	// Address is the destination address
+	Address net.IP
+	// Probe is one of ProbeICMP, ProbeTCP and ProbeARP, ProbeICMP when
+	// empty
+	Probe string
+	// Port is the destination port of ProbeTCP
+	Port int
+	// Timeout bounds the wait of the probe
+	Timeout time.Duration
+}
+
+// ConnectivityHop is the outcome of a step of a connectivity check
+type ConnectivityHop struct {
+	Step   string `json:"step"`
+	Status string `json:"status"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// ConnectivityReport is the outcome of a connectivity check, Reachable
+// telling if the probe was answered
+type ConnectivityReport struct {
+	Source      string            `json:"source"`
+	Destination string            `json:"destination"`
+	Probe       string            `json:"probe"`
+	Reachable   bool              `json:"reachable"`
+	RTT         time.Duration     `json:"rtt,omitempty"`
+	Hops        []ConnectivityHop `json:"hops"`
+}
+
+func (r *ConnectivityReport) String() string {
+	var b strings.Builder
+	status := "unreachable"
+	if r.Reachable {
+		status = fmt.Sprintf("reachable in %v", r.RTT)
+	}
+	fmt.Fprintf(&b, "%s -> %s %s: %s\n", r.Source, r.Destination, r.Probe, status)
+	for _, h := range r.Hops {
+		fmt.Fprintf(&b, "  %-10s %-8s %s\n", h.Step, h.Status, h.Detail)
+	}
+	return b.String()
+}
+
+// sandboxRoute is the route a sandbox selects towards a destination
+type sandboxRoute struct {
+	dst       net.IP
+	gw        net.IP
+	src       net.IP
+	linkIndex int
+	linkName  string
+}
+
+func (r *sandboxRoute) String() string {
+	s := r.dst.String()
+	if r.gw != nil {
+		s += " via " + r.gw.String()
+	}
+	s += " dev " + r.linkName
+	if r.src != nil {
+		s += " src " + r.src.String()
+	}
+	return s
+}
+
+// neighbor returns the address whose link layer address is resolved to
+// reach the destination
+func (r *sandboxRoute) neighbor() net.IP {
+	if r.gw != nil {
+		return r.gw
+	}
+	return r.dst
+}
+
+func (r *ConnectivityReport) addHop(step, status, format string, args ...interface{}) {
+	r.Hops = append(r.Hops, ConnectivityHop{Step: step, Status: status, Detail: fmt.Sprintf(format, args...)})
+}
+
+func (check *ConnectivityCheck) validate() error {
+	if check.NetworkID == "" || check.EndpointID == "" {
+		return types.BadRequestErrorf("the source endpoint of the connectivity check is required")
+	}
+	if (check.Address == nil) == (check.DestEndpointID == "") {
+		return types.BadRequestErrorf("the connectivity check takes either a destination endpoint or an address")
+	}
+	if check.DestEndpointID != "" && check.DestNetworkID == "" {
+		return types.BadRequestErrorf("the network of the destination endpoint is required")
+	}
+	switch check.Probe {
+	case "", ProbeICMP, ProbeARP:
+	case ProbeTCP:
+		if check.Port <= 0 || check.Port > 65535 {
+			return types.BadRequestErrorf("invalid port %d of the tcp probe", check.Port)
+		}
+	default:
+		return types.BadRequestErrorf("invalid probe %q: expected one of %s, %s and %s", check.Probe, ProbeICMP, ProbeTCP, ProbeARP)
+	}
+	if check.Timeout < 0 || check.Timeout > maxConnectivityTimeout {
+		return types.BadRequestErrorf("invalid probe timeout %v: expected up to %v", check.Timeout, maxConnectivityTimeout)
+	}
+	return nil
+}
+
+// endpointAddress returns the address of the endpoint of the family of
+// the peer address, or its IPv4 address when the peer is not known
+func endpointAddress(ep *endpoint, peer net.IP) net.IP {
+	iface := ep.Iface()
+	if iface == nil {
+		return nil
+	}
+	if peer != nil && peer.To4() == nil {
+		if a := iface.AddressIPv6(); a != nil {
+			return a.IP
+		}
+		return nil
+	}
+	if a := iface.Address(); a != nil {
+		return a.IP
+	}
+	return nil
+}
+
+func (c *controller) connectivityEndpoint(nid, eid string) (*endpoint, error) {
+	n, err := c.NetworkByID(nid)
+	if err != nil {
+		return nil, err
+	}
+	e, err := n.EndpointByID(eid)
+	if err != nil {
+		return nil, err
+	}
+	return e.(*endpoint), nil
+}
+
+// CheckConnectivity probes the destination from the sandbox of the source
+// endpoint. Besides the probe, the report tells the route the source
+// sandbox selects, the verdict the driver rules give to the probe, the
+// state of the neighbor entry of the next hop and the flows the host tracks
+// between the addresses.
+func (c *controller) CheckConnectivity(check *ConnectivityCheck) (*ConnectivityReport, error) {
+	if err := check.validate(); err != nil {
+		return nil, err
+	}
+	timeout := check.Timeout
+	if timeout == 0 {
+		timeout = defaultConnectivityTimeout
+	}
+	probe := check.Probe
+	if probe == "" {
+		probe = ProbeICMP
+	}
+
+	src, err := c.connectivityEndpoint(check.NetworkID, check.EndpointID)
+	if err != nil {
+		return nil, err
+	}
+	dst := check.Address
+	if dst == nil {
+		dstEp, err := c.connectivityEndpoint(check.DestNetworkID, check.DestEndpointID)
+		if err != nil {
+			return nil, err
+		}
+		if dst = endpointAddress(dstEp, endpointAddress(src, nil)); dst == nil {
+			return nil, types.BadRequestErrorf("destination endpoint %.7s has no address", check.DestEndpointID)
+		}
+	}
+	srcIP := endpointAddress(src, dst)
+
+	report := &ConnectivityReport{
+		Source:      src.Name(),
+		Destination: dst.String(),
+		Probe:       probe,
+	}
+	if probe == ProbeTCP {
+		report.Destination = net.JoinHostPort(dst.String(), strconv.Itoa(check.Port))
+	}
+
+	sb, ok := src.getSandbox()
+	if !ok {
+		return nil, types.BadRequestErrorf("endpoint %.7s is not joined to a sandbox", check.EndpointID)
+	}
+	sb.Lock()
+	osSbox := sb.osSbox
+	sb.Unlock()
+	if osSbox == nil {
+		return nil, types.BadRequestErrorf("sandbox %.7s has no namespace", sb.ID())
+	}
+	if srcIP == nil {
+		report.addHop("source", HopFailed, "endpoint %.7s has no address of the family of %s", check.EndpointID, dst)
+		return report, nil
+	}
+	report.addHop("source", HopOK, "%s in sandbox %.7s", srcIP, sb.ID())
+
+	route, err := lookupSandboxRoute(sb, dst)
+	if err != nil {
+		report.addHop("route", HopFailed, "%v", err)
+		report.addHop("probe", HopSkipped, "")
+		return report, nil
+	}
+	report.addHop("route", HopOK, "%s", route)
+
+	if probe != ProbeARP {
+		c.addFilterHop(report, check, &driverapi.Flow{Proto: probe, Src: srcIP, Dst: dst, DstPort: check.Port})
+	}
+
+	start := time.Now()
+	switch probe {
+	case ProbeICMP:
+		err = probeNextHop(osSbox, dst, timeout)
+	case ProbeTCP:
+		err = probeTCP(osSbox, report.Destination, timeout)
+	case ProbeARP:
+		err = probeNeighbor(sb, osSbox, route, timeout)
+	}
+	if err != nil {
+		report.addHop("probe", HopFailed, "%v", err)
+	} else {
+		report.Reachable = true
+		report.RTT = time.Since(start)
+		report.addHop("probe", HopOK, "answered in %v", report.RTT)
+	}
+
+	if state, err := sandboxNeighborState(sb, route); err != nil {
+		report.addHop("neighbor", HopFailed, "%v", err)
+	} else {
+		report.addHop("neighbor", HopInfo, "%s %s", route.neighbor(), state)
+	}
+
+	if probe != ProbeARP {
+		flows, err := conntrackFlows(srcIP, dst)
+		switch {
+		case err != nil:
+			report.addHop("conntrack", HopFailed, "%v", err)
+		case len(flows) == 0:
+			report.addHop("conntrack", HopInfo, "no flow tracked on the host")
+		default:
+			report.addHop("conntrack", HopInfo, "%s", strings.Join(flows, "; "))
+		}
+	}
+
+	return report, nil
+}
+
+// addFilterHop adds the verdict the driver rules give to the probe
+func (c *controller) addFilterHop(report *ConnectivityReport, check *ConnectivityCheck, flow *driverapi.Flow) {
+	v, err := c.SimulateVerdict(check.NetworkID, check.EndpointID, flow)
+	if err != nil {
+		if _, ok := err.(types.NotImplementedError); ok {
+			report.addHop("filter", HopSkipped, "%v", err)
+			return
+		}
+		report.addHop("filter", HopFailed, "%v", err)
+		return
+	}
+	status := HopOK
+	if v.Verdict != "ACCEPT" {
+		status = HopFailed
+	}
+	detail := v.Verdict
+	if s := decidingStep(v); s != nil {
+		detail = fmt.Sprintf("%s by %s/%s %s", v.Verdict, s.Table, s.Chain, s.Rule)
+		if s.Note != "" {
+			detail += " (" + s.Note + ")"
+		}
+	}
+	report.addHop("filter", status, "%s", detail)
+}
+
+// connectivityDiag runs a connectivity check, the source endpoint being
+// nid and eid, the destination either dst_nid and dst_eid or address
+func connectivityDiag(ctx interface{}, w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	diagnostic.DebugHTTPForm(r)
+	_, json := diagnostic.ParseHTTPFormOptions(r)
+
+	// audit logs
+	log := logrus.WithFields(logrus.Fields{"component": "diagnostic", "remoteIP": r.RemoteAddr, "method": caller.Name(0), "url": r.URL.String()})
+	log.Info("connectivity check")
+
+	c, ok := ctx.(*controller)
+	if !ok {
+		diagnostic.HTTPReply(w, diagnostic.FailCommand(fmt.Errorf("controller not available")), json)
+		return
+	}
+
+	check := &ConnectivityCheck{
+		NetworkID:      r.Form.Get("nid"),
+		EndpointID:     r.Form.Get("eid"),
+		DestNetworkID:  r.Form.Get("dst_nid"),
+		DestEndpointID: r.Form.Get("dst_eid"),
+		Probe:          r.Form.Get("probe"),
+	}
+	if check.NetworkID == "" || check.EndpointID == "" {
+		diagnostic.HTTPReply(w, diagnostic.WrongCommand("connectivity", "nid=<network id>&eid=<endpoint id>&(dst_nid=<network id>&dst_eid=<endpoint id>|address=<ip>)[&probe=icmp|tcp|arp][&port=<port>][&timeout=2s]"), json)
+		return
+	}
+	if v := r.Form.Get("address"); v != "" {
+		if check.Address = net.ParseIP(v); check.Address == nil {
+			diagnostic.HTTPReply(w, diagnostic.FailCommand(fmt.Errorf("invalid address %q", v)), json)
+			return
+		}
+	}
+	if v := r.Form.Get("port"); v != "" {
+		p, err := strconv.Atoi(v)
+		if err != nil {
+			diagnostic.HTTPReply(w, diagnostic.FailCommand(fmt.Errorf("invalid port %q", v)), json)
+			return
+		}
+		check.Port = p
+	}
+	if v := r.Form.Get("timeout"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			diagnostic.HTTPReply(w, diagnostic.FailCommand(fmt.Errorf("invalid timeout %q", v)), json)
+			return
+		}
+		check.Timeout = d
+	}
+
+	report, err := c.CheckConnectivity(check)
+	if err != nil {
+		log.WithError(err).Error("connectivity check failed")
+		diagnostic.HTTPReply(w, diagnostic.FailCommand(err), json)
+		return
+	}
+	diagnostic.HTTPReply(w, diagnostic.CommandSucceed(report), json)
+}
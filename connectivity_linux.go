@@ -0,0 +1,213 @@
+package libnetwork
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+	"time"
+
+	"github.com/docker/libnetwork/osl"
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netns"
+)
+
+// neighborPollInterval is the interval the neighbor entry is checked at
+// while it is resolved
+const neighborPollInterval = 50 * time.Millisecond
+
+func sandboxNetlinkHandle(sb *sandbox) (*netlink.Handle, error) {
+	nsh, err := netns.GetFromPath(sb.Key())
+	if err != nil {
+		return nil, fmt.Errorf("failed to open namespace %s: %v", sb.Key(), err)
+	}
+	defer nsh.Close()
+	nlh, err := netlink.NewHandleAt(nsh, syscall.NETLINK_ROUTE)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open a netlink handle in %s: %v", sb.Key(), err)
+	}
+	return nlh, nil
+}
+
+// lookupSandboxRoute asks the kernel of the sandbox for its route towards
+// the destination
+func lookupSandboxRoute(sb *sandbox, dst net.IP) (*sandboxRoute, error) {
+	nlh, err := sandboxNetlinkHandle(sb)
+	if err != nil {
+		return nil, err
+	}
+	defer nlh.Delete()
+
+	routes, err := nlh.RouteGet(dst)
+	if err != nil {
+		return nil, fmt.Errorf("no route to %s: %v", dst, err)
+	}
+	if len(routes) == 0 {
+		return nil, fmt.Errorf("no route to %s", dst)
+	}
+	r := &sandboxRoute{
+		dst:       dst,
+		gw:        routes[0].Gw,
+		src:       routes[0].Src,
+		linkIndex: routes[0].LinkIndex,
+	}
+	link, err := nlh.LinkByIndex(r.linkIndex)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find the interface %d of the route to %s: %v", r.linkIndex, dst, err)
+	}
+	r.linkName = link.Attrs().Name
+	if link.Attrs().OperState == netlink.OperDown {
+		return nil, fmt.Errorf("the interface %s of the route to %s is down", r.linkName, dst)
+	}
+	return r, nil
+}
+
+func neighborEntry(nlh *netlink.Handle, route *sandboxRoute) (*netlink.Neigh, error) {
+	family := netlink.FAMILY_V4
+	if route.neighbor().To4() == nil {
+		family = netlink.FAMILY_V6
+	}
+	neighs, err := nlh.NeighList(route.linkIndex, family)
+	if err != nil {
+		return nil, err
+	}
+	for i := range neighs {
+		if neighs[i].IP.Equal(route.neighbor()) {
+			return &neighs[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// sandboxNeighborState returns the state, and the link layer address once
+// resolved, of the neighbor entry of the next hop of the route
+func sandboxNeighborState(sb *sandbox, route *sandboxRoute) (string, error) {
+	nlh, err := sandboxNetlinkHandle(sb)
+	if err != nil {
+		return "", err
+	}
+	defer nlh.Delete()
+
+	n, err := neighborEntry(nlh, route)
+	if err != nil {
+		return "", err
+	}
+	if n == nil {
+		return "not resolved", nil
+	}
+	state := neighStateName(n.State)
+	if n.HardwareAddr != nil {
+		state = n.HardwareAddr.String() + " " + state
+	}
+	return state, nil
+}
+
+func neighStateName(state int) string {
+	switch {
+	case state&netlink.NUD_PERMANENT != 0:
+		return "permanent"
+	case state&netlink.NUD_NOARP != 0:
+		return "noarp"
+	case state&netlink.NUD_REACHABLE != 0:
+		return "reachable"
+	case state&netlink.NUD_STALE != 0:
+		return "stale"
+	case state&netlink.NUD_DELAY != 0:
+		return "delay"
+	case state&netlink.NUD_PROBE != 0:
+		return "probe"
+	case state&netlink.NUD_INCOMPLETE != 0:
+		return "incomplete"
+	case state&netlink.NUD_FAILED != 0:
+		return "failed"
+	}
+	return "none"
+}
+
+// probeNeighbor has the sandbox resolve the next hop of the route, sending
+// a datagram to the discard port of the next hop, and waits for its
+// neighbor entry to be resolved
+func probeNeighbor(sb *sandbox, osSbox osl.Sandbox, route *sandboxRoute, timeout time.Duration) error {
+	nlh, err := sandboxNetlinkHandle(sb)
+	if err != nil {
+		return err
+	}
+	defer nlh.Delete()
+
+	if n, err := neighborEntry(nlh, route); err == nil && n != nil && n.State&(netlink.NUD_REACHABLE|netlink.NUD_PERMANENT|netlink.NUD_NOARP) != 0 {
+		return nil
+	}
+
+	var conn net.Conn
+	if ierr := osSbox.InvokeFunc(func() {
+		conn, err = net.Dial("udp", net.JoinHostPort(route.neighbor().String(), "9"))
+	}); ierr != nil {
+		return ierr
+	}
+	if err != nil {
+		return err
+	}
+	conn.Write([]byte("libnetwk"))
+	conn.Close()
+
+	deadline := time.Now().Add(timeout)
+	for {
+		n, err := neighborEntry(nlh, route)
+		if err != nil {
+			return err
+		}
+		if n != nil {
+			switch {
+			case n.State&netlink.NUD_FAILED != 0:
+				return fmt.Errorf("%s did not answer the resolution", route.neighbor())
+			case n.State != netlink.NUD_NONE && n.State&netlink.NUD_INCOMPLETE == 0 && n.HardwareAddr != nil:
+				return nil
+			}
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("%s not resolved within %v", route.neighbor(), timeout)
+		}
+		time.Sleep(neighborPollInterval)
+	}
+}
+
+// probeTCP opens a TCP connection, from the namespace of the sandbox
+func probeTCP(osSbox osl.Sandbox, addr string, timeout time.Duration) error {
+	var (
+		conn net.Conn
+		err  error
+	)
+	if ierr := osSbox.InvokeFunc(func() {
+		conn, err = net.DialTimeout("tcp", addr, timeout)
+	}); ierr != nil {
+		return ierr
+	}
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// conntrackFlows returns the flows the host tracks from the source to the
+// destination address, translated ones included
+func conntrackFlows(src, dst net.IP) ([]string, error) {
+	family := netlink.InetFamily(syscall.AF_INET)
+	if dst.To4() == nil {
+		family = netlink.InetFamily(syscall.AF_INET6)
+	}
+	flows, err := netlink.ConntrackTableList(netlink.ConntrackTable, family)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the conntrack flows: %v", err)
+	}
+	var matches []string
+	for _, f := range flows {
+		if !f.Forward.SrcIP.Equal(src) || !(f.Forward.DstIP.Equal(dst) || f.Reverse.SrcIP.Equal(dst)) {
+			continue
+		}
+		s := fmt.Sprintf("proto %d %s:%d -> %s:%d", f.Forward.Protocol, f.Forward.SrcIP, f.Forward.SrcPort, f.Forward.DstIP, f.Forward.DstPort)
+		if !f.Reverse.SrcIP.Equal(f.Forward.DstIP) || !f.Reverse.DstIP.Equal(f.Forward.SrcIP) {
+			s += fmt.Sprintf(" translated %s:%d <- %s:%d", f.Reverse.DstIP, f.Reverse.DstPort, f.Reverse.SrcIP, f.Reverse.SrcPort)
+		}
+		matches = append(matches, s)
+	}
+	return matches, nil
+}
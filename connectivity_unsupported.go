@@ -0,0 +1,31 @@
+// +build !linux
+
+package libnetwork
+
+import (
+	"net"
+	"time"
+
+	"github.com/docker/libnetwork/osl"
+	"github.com/docker/libnetwork/types"
+)
+
+func lookupSandboxRoute(sb *sandbox, dst net.IP) (*sandboxRoute, error) {
+	return nil, types.NotImplementedErrorf("connectivity checks are not supported on this platform")
+}
+
+func sandboxNeighborState(sb *sandbox, route *sandboxRoute) (string, error) {
+	return "", types.NotImplementedErrorf("connectivity checks are not supported on this platform")
+}
+
+func probeNeighbor(sb *sandbox, osSbox osl.Sandbox, route *sandboxRoute, timeout time.Duration) error {
+	return types.NotImplementedErrorf("connectivity checks are not supported on this platform")
+}
+
+func probeTCP(osSbox osl.Sandbox, addr string, timeout time.Duration) error {
+	return types.NotImplementedErrorf("connectivity checks are not supported on this platform")
+}
+
+func conntrackFlows(src, dst net.IP) ([]string, error) {
+	return nil, types.NotImplementedErrorf("connectivity checks are not supported on this platform")
+}
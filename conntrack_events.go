@@ -0,0 +1,317 @@
+package libnetwork
+
+import (
+	"encoding/binary"
+	"math"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/docker/go-events"
+	"github.com/docker/libnetwork/types"
+	"github.com/sirupsen/logrus"
+)
+
+// defaultConntrackRate is the number of conntrack events per second sent to
+// a watcher passing no rate
+const defaultConntrackRate = 100
+
+// The conntrack event types
+const (
+	ConntrackNew     = "new"
+	ConntrackDestroy = "destroy"
+)
+
+// ConntrackEvent is a connection of an endpoint tracked or forgotten by the
+// conntrack table of the host. Dst is the address replying, past the
+// destination NAT. Dropped is the number of events of the watcher left out
+// by its rate limit since the previous one sent.
+type ConntrackEvent struct {
+	Type       string    `json:"type"`
+	Time       time.Time `json:"time"`
+	NetworkID  string    `json:"network_id"`
+	EndpointID string    `json:"endpoint_id"`
+	Proto      uint8     `json:"proto"`
+	Src        net.IP    `json:"src"`
+	Dst        net.IP    `json:"dst"`
+	SrcPort    uint16    `json:"src_port,omitempty"`
+	DstPort    uint16    `json:"dst_port,omitempty"`
+	Dropped    uint64    `json:"dropped,omitempty"`
+}
+
+// The netlink attributes of the conntrack messages
+const (
+	ctnlMsgNew    = 1<<8 | 0 // NFNL_SUBSYS_CTNETLINK << 8 | IPCTNL_MSG_CT_NEW
+	ctnlMsgDelete = 1<<8 | 2 // NFNL_SUBSYS_CTNETLINK << 8 | IPCTNL_MSG_CT_DELETE
+
+	ctaTupleOrig  = 1
+	ctaTupleReply = 2
+	ctaTupleIP    = 1
+	ctaTupleProto = 2
+	ctaIPV4Src    = 1
+	ctaIPV4Dst    = 2
+	ctaIPV6Src    = 3
+	ctaIPV6Dst    = 4
+	ctaProtoNum   = 1
+	ctaProtoSrc   = 2
+	ctaProtoDst   = 3
+
+	nlaTypeMask   = 0x3fff
+	sizeofNfgen   = 4
+	sizeofNlaHead = 4
+)
+
+// conntrackMessage is a netlink message of the conntrack event groups
+type conntrackMessage struct {
+	typ  uint16
+	data []byte
+}
+
+// parseAttrs splits the netlink attributes by type, the nested flag cleared
+func parseAttrs(b []byte) map[uint16][]byte {
+	attrs := map[uint16][]byte{}
+	for len(b) >= sizeofNlaHead {
+		l := int(binary.LittleEndian.Uint16(b))
+		if l < sizeofNlaHead || l > len(b) {
+			break
+		}
+		attrs[binary.LittleEndian.Uint16(b[2:])&nlaTypeMask] = b[sizeofNlaHead:l]
+		l = (l + 3) &^ 3
+		if l > len(b) {
+			break
+		}
+		b = b[l:]
+	}
+	return attrs
+}
+
+// parseTuple returns the addresses and ports of a conntrack tuple
+func parseTuple(b []byte) (src, dst net.IP, proto uint8, sport, dport uint16) {
+	attrs := parseAttrs(b)
+	ip := parseAttrs(attrs[ctaTupleIP])
+	if v := ip[ctaIPV4Src]; len(v) == net.IPv4len {
+		src, dst = net.IP(v).To4(), net.IP(ip[ctaIPV4Dst]).To4()
+	} else if v := ip[ctaIPV6Src]; len(v) == net.IPv6len {
+		src, dst = net.IP(v), net.IP(ip[ctaIPV6Dst])
+	}
+	p := parseAttrs(attrs[ctaTupleProto])
+	if v := p[ctaProtoNum]; len(v) == 1 {
+		proto = v[0]
+	}
+	// The ports are in network order
+	if v := p[ctaProtoSrc]; len(v) == 2 {
+		sport = binary.BigEndian.Uint16(v)
+	}
+	if v := p[ctaProtoDst]; len(v) == 2 {
+		dport = binary.BigEndian.Uint16(v)
+	}
+	return
+}
+
+// parseConntrackMessage returns the type and the flow of a conntrack event
+// message, false for the other messages
+func parseConntrackMessage(m conntrackMessage) (string, trackedFlow, bool) {
+	var typ string
+	switch m.typ {
+	case ctnlMsgNew:
+		typ = ConntrackNew
+	case ctnlMsgDelete:
+		typ = ConntrackDestroy
+	default:
+		return "", trackedFlow{}, false
+	}
+	if len(m.data) < sizeofNfgen {
+		return "", trackedFlow{}, false
+	}
+	attrs := parseAttrs(m.data[sizeofNfgen:])
+	src, _, proto, sport, _ := parseTuple(attrs[ctaTupleOrig])
+	dst, _, _, dport, _ := parseTuple(attrs[ctaTupleReply])
+	if src == nil || dst == nil {
+		return "", trackedFlow{}, false
+	}
+	return typ, trackedFlow{Src: src, Dst: dst, Proto: proto, SrcPort: sport, DstPort: dport}, true
+}
+
+// eventLimiter is the token bucket of the events sent to a watcher, holding
+// a second of events
+type eventLimiter struct {
+	rate    float64
+	tokens  float64
+	last    time.Time
+	dropped uint64
+}
+
+func newEventLimiter(rate int, now time.Time) *eventLimiter {
+	return &eventLimiter{rate: float64(rate), tokens: float64(rate), last: now}
+}
+
+// allow takes a token of the bucket, counting the event as dropped when it
+// is empty
+func (l *eventLimiter) allow(now time.Time) bool {
+	l.tokens = math.Min(l.rate, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+	if l.tokens < 1 {
+		l.dropped++
+		return false
+	}
+	l.tokens--
+	return true
+}
+
+// conntrackWatcher is a watcher of the conntrack events of an endpoint
+type conntrackWatcher struct {
+	nid, eid string
+	ips      map[string]bool
+	limiter  *eventLimiter
+	sink     events.Sink
+}
+
+// conntrackWatch holds the watchers of the conntrack events, the events
+// being received while there is one
+type conntrackWatch struct {
+	mu       sync.Mutex
+	watchers map[*conntrackWatcher]struct{}
+	stop     chan struct{}
+}
+
+// WatchConntrack returns a channel where the new and destroyed conntrack
+// entries of the connections of the endpoint are sent as ConntrackEvent
+// values, at most rate a second or defaultConntrackRate if rate is not
+// positive, and a function to call to stop watching. The entries are the
+// ones of the conntrack table of the host, so the connections of the
+// sandboxes of the drivers routing in another namespace are not seen.
+func (c *controller) WatchConntrack(networkID, endpointID string, rate int) (*events.Channel, func(), error) {
+	n, err := c.NetworkByID(networkID)
+	if err != nil {
+		return nil, nil, err
+	}
+	e, err := n.EndpointByID(endpointID)
+	if err != nil {
+		return nil, nil, err
+	}
+	ips := map[string]bool{}
+	if iface := e.(*endpoint).Iface(); iface != nil {
+		if iface.Address() != nil {
+			ips[iface.Address().IP.String()] = true
+		}
+		if iface.AddressIPv6() != nil {
+			ips[iface.AddressIPv6().IP.String()] = true
+		}
+	}
+	if len(ips) == 0 {
+		return nil, nil, types.BadRequestErrorf("endpoint %s has no address to watch the connections of", e.Name())
+	}
+	if rate <= 0 {
+		rate = defaultConntrackRate
+	}
+
+	ch := events.NewChannel(0)
+	w := &conntrackWatcher{
+		nid:     n.ID(),
+		eid:     e.ID(),
+		ips:     ips,
+		limiter: newEventLimiter(rate, time.Now()),
+		sink:    events.NewQueue(ch),
+	}
+
+	cw := &c.conntrackWatch
+	cw.mu.Lock()
+	if len(cw.watchers) == 0 {
+		s, err := openConntrackSocket()
+		if err != nil {
+			cw.mu.Unlock()
+			return nil, nil, err
+		}
+		cw.stop = make(chan struct{})
+		go c.receiveConntrackEvents(s, cw.stop)
+	}
+	if cw.watchers == nil {
+		cw.watchers = map[*conntrackWatcher]struct{}{}
+	}
+	cw.watchers[w] = struct{}{}
+	cw.mu.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			cw.mu.Lock()
+			delete(cw.watchers, w)
+			if len(cw.watchers) == 0 && cw.stop != nil {
+				close(cw.stop)
+				cw.stop = nil
+			}
+			cw.mu.Unlock()
+			ch.Close()
+			w.sink.Close()
+		})
+	}, nil
+}
+
+// stopConntrackWatch stops receiving the conntrack events, the watchers
+// being left without events
+func (c *controller) stopConntrackWatch() {
+	cw := &c.conntrackWatch
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+	if cw.stop != nil {
+		close(cw.stop)
+		cw.stop = nil
+	}
+}
+
+// receiveConntrackEvents sends the events of the socket to the watchers
+// until stopped
+func (c *controller) receiveConntrackEvents(s *conntrackSocket, stop chan struct{}) {
+	defer s.close()
+	for {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+		msgs, err := s.receive()
+		if err != nil {
+			logrus.Warnf("Failed to receive the conntrack events: %v", err)
+			return
+		}
+		for _, m := range msgs {
+			c.dispatchConntrackEvent(m, time.Now())
+		}
+	}
+}
+
+// dispatchConntrackEvent sends the event of the message to the watchers of
+// the endpoints at either end of its connection
+func (c *controller) dispatchConntrackEvent(m conntrackMessage, now time.Time) {
+	typ, f, ok := parseConntrackMessage(m)
+	if !ok {
+		return
+	}
+	cw := &c.conntrackWatch
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+	for w := range cw.watchers {
+		if !w.ips[f.Src.String()] && !w.ips[f.Dst.String()] {
+			continue
+		}
+		if !w.limiter.allow(now) {
+			continue
+		}
+		ev := ConntrackEvent{
+			Type:       typ,
+			Time:       now,
+			NetworkID:  w.nid,
+			EndpointID: w.eid,
+			Proto:      f.Proto,
+			Src:        f.Src,
+			Dst:        f.Dst,
+			SrcPort:    f.SrcPort,
+			DstPort:    f.DstPort,
+			Dropped:    w.limiter.dropped,
+		}
+		w.limiter.dropped = 0
+		if err := w.sink.Write(ev); err != nil {
+			logrus.Debugf("Failed to send the conntrack event of endpoint %s: %v", w.eid, err)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package libnetwork
+
+import (
+	"fmt"
+	"syscall"
+
+	"github.com/vishvananda/netlink/nl"
+)
+
+// The conntrack multicast groups of the new and destroyed entries
+const (
+	nfnlgrpConntrackNew     = 1
+	nfnlgrpConntrackDestroy = 3
+)
+
+// conntrackSocket is the netlink socket of the conntrack events
+type conntrackSocket struct {
+	s *nl.NetlinkSocket
+}
+
+func openConntrackSocket() (*conntrackSocket, error) {
+	s, err := nl.Subscribe(syscall.NETLINK_NETFILTER, nfnlgrpConntrackNew, nfnlgrpConntrackDestroy)
+	if err != nil {
+		return nil, fmt.Errorf("failed to subscribe to the conntrack events: %v", err)
+	}
+	// The receiver wakes up every second to see whether it is stopped
+	if err := s.SetReceiveTimeout(&syscall.Timeval{Sec: 1}); err != nil {
+		s.Close()
+		return nil, fmt.Errorf("failed to set the timeout of the conntrack event socket: %v", err)
+	}
+	return &conntrackSocket{s: s}, nil
+}
+
+// receive returns the next messages of the socket, none when the timeout
+// expires first. The events lost to a full socket buffer are skipped.
+func (cs *conntrackSocket) receive() ([]conntrackMessage, error) {
+	msgs, err := cs.s.Receive()
+	if err != nil {
+		if err == syscall.EAGAIN || err == syscall.EWOULDBLOCK || err == syscall.EINTR || err == syscall.ENOBUFS {
+			return nil, nil
+		}
+		return nil, err
+	}
+	out := make([]conntrackMessage, 0, len(msgs))
+	for _, m := range msgs {
+		out = append(out, conntrackMessage{typ: m.Header.Type, data: m.Data})
+	}
+	return out, nil
+}
+
+func (cs *conntrackSocket) close() {
+	cs.s.Close()
+}
@@ -0,0 +1,17 @@
+// +build !linux
+
+package libnetwork
+
+import "github.com/docker/libnetwork/types"
+
+type conntrackSocket struct{}
+
+func openConntrackSocket() (*conntrackSocket, error) {
+	return nil, types.NotImplementedErrorf("conntrack events are not supported on this platform")
+}
+
+func (cs *conntrackSocket) receive() ([]conntrackMessage, error) {
+	return nil, nil
+}
+
+func (cs *conntrackSocket) close() {}
@@ -45,6 +45,7 @@ package libnetwork
 
 import (
 	"fmt"
+	"io"
 	"net"
 	"path/filepath"
 	"runtime"
@@ -57,6 +58,8 @@ import (
 	"github.com/docker/docker/pkg/plugingetter"
 	"github.com/docker/docker/pkg/plugins"
 	"github.com/docker/docker/pkg/stringid"
+	"github.com/docker/go-events"
+	"github.com/docker/libnetwork/authz"
 	"github.com/docker/libnetwork/cluster"
 	"github.com/docker/libnetwork/config"
 	"github.com/docker/libnetwork/datastore"
@@ -65,10 +68,16 @@ import (
 	"github.com/docker/libnetwork/driverapi"
 	"github.com/docker/libnetwork/drvregistry"
 	"github.com/docker/libnetwork/hostdiscovery"
+	"github.com/docker/libnetwork/internal/faults"
 	"github.com/docker/libnetwork/ipamapi"
+	"github.com/docker/libnetwork/journal"
+	"github.com/docker/libnetwork/lldp"
 	"github.com/docker/libnetwork/netlabel"
+	"github.com/docker/libnetwork/options"
 	"github.com/docker/libnetwork/osl"
+	"github.com/docker/libnetwork/otlp"
 	"github.com/docker/libnetwork/types"
+	"github.com/docker/libnetwork/webhook"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 )
@@ -118,6 +127,10 @@ type NetworkController interface {
 	// SandboxDestroy destroys a sandbox given a container ID
 	SandboxDestroy(id string) error
 
+	// ConnectSandbox connects the sandbox to the networks of the attachments,
+	// creating and joining all their endpoints or none
+	ConnectSandbox(sandboxID string, attachments []SandboxAttachment) ([]Endpoint, error)
+
 	// Stop network controller
 	Stop()
 
@@ -136,12 +149,198 @@ type NetworkController interface {
 	// SetKeys configures the encryption key for gossip and overlay data path
 	SetKeys(keys []*types.EncryptionKey) error
 
+	// StartAgent starts the agent with the given join addresses and keys,
+	// without a cluster provider
+	StartAgent(cfg AgentConfig) error
+
+	// StopAgent stops the agent started with StartAgent
+	StopAgent() error
+
 	// StartDiagnostic start the network diagnostic mode
 	StartDiagnostic(port int)
 	// StopDiagnostic start the network diagnostic mode
 	StopDiagnostic()
 	// IsDiagnosticEnabled returns true if the diagnostic is enabled
 	IsDiagnosticEnabled() bool
+
+	// ExportSnapshot writes a versioned json snapshot of the locally scoped state
+	ExportSnapshot(w io.Writer) error
+	// ImportSnapshot restores a snapshot written by ExportSnapshot, the
+	// imported state is picked up by the next controller start
+	ImportSnapshot(r io.Reader) error
+
+	// Reconcile audits the kernel state against the libnetwork state and
+	// reports the drifts, repairing them when repair is set
+	Reconcile(repair bool) ([]driverapi.Drift, error)
+
+	// Statistics returns the interface statistics of all the sandboxes,
+	// keyed by sandbox ID and interface name
+	Statistics() (map[string]map[string]*types.InterfaceStatistics, error)
+
+	// Watch streams the network lifecycle events of the passed types, or of
+	// all types if none is passed. The returned function stops the watch.
+	Watch(types ...EventType) (*events.Channel, func())
+
+	// NextHopStatus returns the health of the next hops probed when the
+	// next hop probes are enabled
+	NextHopStatus() []NextHopStatus
+
+	// MirrorEndpoint mirrors the traffic of the endpoint to the target, or
+	// stops the mirroring when the target is nil
+	MirrorEndpoint(networkID, endpointID string, target *driverapi.MirrorTarget) error
+
+	// CheckConnectivity probes the destination of the check from the
+	// sandbox of its source endpoint and reports the outcome of each step
+	CheckConnectivity(check *ConnectivityCheck) (*ConnectivityReport, error)
+
+	// SimulateVerdict tells which of the generated rules decides of the
+	// fate of a new flow to or from the endpoint, without querying the
+	// kernel
+	SimulateVerdict(networkID, endpointID string, flow *driverapi.Flow) (*driverapi.Verdict, error)
+
+	// EndpointReady signals the endpoint ready, opening its traffic on the
+	// networks holding it until the orchestrator says so
+	EndpointReady(networkID, endpointID string) error
+
+	// QuarantineEndpoint cuts the traffic of the endpoint off without
+	// stopping it, or lets it through again when the quarantine is nil
+	QuarantineEndpoint(networkID, endpointID string, q *Quarantine) error
+
+	// SetEndpointQuota caps the traffic of the endpoint in a calendar month,
+	// or removes the cap when the quota is nil
+	SetEndpointQuota(networkID, endpointID string, q *Quota) error
+
+	// EndpointUsage returns the traffic of the endpoint in the current
+	// month along with its quota
+	EndpointUsage(networkID, endpointID string) (*EndpointUsage, error)
+
+	// Uplinks returns the state of the bonding or teaming uplinks watched
+	// for failovers
+	Uplinks() []UplinkStatus
+
+	// StageFilterPolicy applies a new version of the filter policy of the
+	// network on its canary endpoints, to be promoted or rolled back
+	StageFilterPolicy(networkID string, r *FilterRollout) error
+
+	// PromoteFilterPolicy applies the staged filter policy on all the
+	// endpoints of the network
+	PromoteFilterPolicy(networkID string) error
+
+	// RollbackFilterPolicy applies the current filter policy back on the
+	// canaries of the staged one
+	RollbackFilterPolicy(networkID string) error
+
+	// FilterRolloutStatus returns the state of the rollout of the filter
+	// policy of the network, with the counters of its endpoints
+	FilterRolloutStatus(networkID string) (*FilterRolloutStatus, error)
+
+	// FilterPolicyHistory returns the filter policies applied on the
+	// endpoint, oldest first
+	FilterPolicyHistory(networkID, endpointID string) ([]FilterPolicyVersion, error)
+
+	// DiffFilterPolicy compares two versions of the filter policy applied
+	// on the endpoint
+	DiffFilterPolicy(networkID, endpointID, from, to string) (*FilterPolicyDiff, error)
+
+	// RevertFilterPolicy applies back a prior version of the filter policy
+	// on the endpoints of the network
+	RevertFilterPolicy(networkID, version string) error
+
+	// PartitionStatus returns the state of the detection of the
+	// partitions of the global datastore and of the gossip cluster
+	PartitionStatus() *PartitionStatus
+
+	// ULAStatus returns the unique local prefix of the cluster and the
+	// IPv6 subnets of the networks carved from it
+	ULAStatus() (*ULAStatus, error)
+
+	// OperationJournal returns the last operations journaled, the oldest
+	// first
+	OperationJournal(limit int) ([]journal.Entry, error)
+
+	// ReplayJournal creates back the networks and endpoints of the
+	// operation journal the controller does not know of
+	ReplayJournal() (*JournalReplay, error)
+
+	// SetEndpointShadowMode applies the filter policies of the endpoint
+	// for audit only, or enforces them again
+	SetEndpointShadowMode(networkID, endpointID string, shadow bool) error
+
+	// ShadowModeStatus returns the filter counters of the endpoints of the
+	// network in shadow mode
+	ShadowModeStatus(networkID string) ([]ShadowEndpointStatus, error)
+
+	// CollectLeakedAddresses lists the addresses allocated out of the
+	// pools of the network no endpoint holds, releasing them when apply
+	// is set
+	CollectLeakedAddresses(networkID string, apply bool) ([]LeakedAddress, error)
+
+	// SetInterNetworkPolicies replaces the policies of the traffic between
+	// the local networks, applied through their drivers
+	SetInterNetworkPolicies(policies []config.InterNetworkPolicy) error
+
+	// InterNetworkPolicies returns the policies of the traffic between the
+	// local networks
+	InterNetworkPolicies() []config.InterNetworkPolicy
+
+	// AddExternalEndpoint registers a peer which is not a container on a
+	// network, for its name to resolve and to be referred to by the filter
+	// policies
+	AddExternalEndpoint(ee *ExternalEndpoint) error
+
+	// RemoveExternalEndpoint unregisters the external endpoint of the
+	// network
+	RemoveExternalEndpoint(networkID, name string) error
+
+	// ExternalEndpoints returns the external endpoints of the network
+	ExternalEndpoints(networkID string) ([]ExternalEndpoint, error)
+
+	// WatchConntrack returns a channel where the new and destroyed
+	// conntrack entries of the connections of the endpoint are sent, at
+	// most rate a second, and a function to call to stop watching
+	WatchConntrack(networkID, endpointID string, rate int) (*events.Channel, func(), error)
+
+	// AddFloatingIP makes a local endpoint a candidate holder of a floating
+	// IP shared with the endpoints of other hosts
+	AddFloatingIP(cfg *FloatingIPConfig) error
+
+	// RemoveFloatingIP withdraws the local candidate of the floating IP
+	RemoveFloatingIP(name string) error
+
+	// FloatingIPs returns the state of the local candidates of the floating
+	// IPs
+	FloatingIPs() []FloatingIPStatus
+
+	// PoolSandboxes keeps count sandboxes pre-created for the next
+	// containers to claim, zero drains the pool
+	PoolSandboxes(count int) error
+
+	// ForceEndpointCleanup removes the resources the drivers keep for the
+	// deleted endpoints during their quiesce period
+	ForceEndpointCleanup() int
+
+	// CollectOrphans removes the kernel objects libnetwork tagged whose
+	// owner is gone, and returns the number of objects removed
+	CollectOrphans() int
+
+	// DriverNetworkOptions returns the schema of the labels configuring
+	// the networks of the driver
+	DriverNetworkOptions(networkType string) (options.Schema, error)
+
+	// ReloadDaemonConfiguration applies the daemon settings which can
+	// change at run time, and returns the changed ones requiring a restart
+	ReloadDaemonConfiguration(cfgOptions ...config.Option) ([]string, error)
+
+	// Tenants returns the tenants owning networks
+	Tenants() []string
+
+	// TenantResources returns the networks, endpoints and sandboxes of the
+	// tenant
+	TenantResources(tenant string) (*TenantResources, error)
+
+	// WalkTenantNetworks uses the provided function to walk the networks
+	// of the tenant
+	WalkTenantNetworks(tenant string, walker NetworkWalker)
 }
 
 // NetworkWalker is a client provided function which will be used to walk the Networks.
@@ -161,6 +360,7 @@ type controller struct {
 	cfg                    *config.Config
 	stores                 []datastore.DataStore
 	discovery              hostdiscovery.HostDiscovery
+	discoveryActive        bool
 	extKeyListener         net.Listener
 	watchCh                chan *endpoint
 	unWatchCh              chan *endpoint
@@ -170,6 +370,7 @@ type controller struct {
 	defOsSbox              osl.Sandbox
 	ingressSandbox         *sandbox
 	sboxOnce               sync.Once
+	stopOnce               sync.Once
 	agent                  *agent
 	networkLocker          *locker.Locker
 	agentInitDone          chan struct{}
@@ -177,6 +378,39 @@ type controller struct {
 	keys                   []*types.EncryptionKey
 	clusterConfigAvailable bool
 	DiagnosticServer       *diagnostic.Server
+	eventBroadcaster       *events.Broadcaster
+	janitorStop            chan struct{}
+	nextHopProber          *nextHopProber
+	nextHopStop            chan struct{}
+	lldpAdvertiser         *lldp.Advertiser
+	flowExportStop         chan struct{}
+	socketAuditStop        chan struct{}
+	floatingIPs            map[string]*floatingIP
+	dnsPaused              map[string]bool
+	filterRollouts         map[string]*filterRollout
+	filterMu               sync.Mutex
+	idempotentCalls        map[string]*idempotentCall
+	idempotencyMu          sync.Mutex
+	deletingEndpoints      map[string]map[string]*endpoint
+	interNetworkPolicies   []config.InterNetworkPolicy
+	interNetworkMu         sync.Mutex
+	externalEndpoints      map[string]map[string]*ExternalEndpoint
+	lbHookQueue            chan lbHookUpdate
+	lbHookStop             chan struct{}
+	webhooks               *webhook.Notifier
+	webhooksStop           func()
+	journal                *journal.Journal
+	splitBrain             *splitBrainDetector
+	splitBrainStop         chan struct{}
+	ulaPrefix              *net.IPNet
+	otlpExporter           *otlp.Exporter
+	opTracer               opTracer
+	opLimiter              opLimiter
+	writeBehind            writeBehind
+	sbPool                 sandboxPool
+	conntrackWatch         conntrackWatch
+	accounting             accounting
+	uplinkWatch            uplinkWatch
 	sync.Mutex
 }
 
@@ -186,7 +420,7 @@ type initializer struct {
 }
 
 // New creates a new instance of network controller.
-func New(cfgOptions ...config.Option) (NetworkController, error) {
+func New(cfgOptions ...config.Option) (_ NetworkController, retErr error) {
 	c := &controller{
 		id:               stringid.GenerateRandomID(),
 		cfg:              config.ParseConfigOptions(cfgOptions...),
@@ -196,8 +430,93 @@ func New(cfgOptions ...config.Option) (NetworkController, error) {
 		agentInitDone:    make(chan struct{}),
 		networkLocker:    locker.New(),
 		DiagnosticServer: diagnostic.New(),
+		eventBroadcaster: events.NewBroadcaster(),
 	}
 	c.DiagnosticServer.Init()
+	c.DiagnosticServer.RegisterHandler(c, reconcilePaths2Func)
+	c.DiagnosticServer.RegisterHandler(c, statisticsPaths2Func)
+	c.DiagnosticServer.RegisterHandler(c, eventsPaths2Func)
+	c.DiagnosticServer.RegisterHandler(c, webhookPaths2Func)
+	c.DiagnosticServer.RegisterHandler(c, sandboxPolicyPaths2Func)
+	c.DiagnosticServer.RegisterHandler(c, nextHopPaths2Func)
+	c.DiagnosticServer.RegisterHandler(c, inspectPaths2Func)
+	c.DiagnosticServer.RegisterHandler(c, opTracePaths2Func)
+	c.DiagnosticServer.RegisterHandler(c, capturePaths2Func)
+	c.DiagnosticServer.RegisterHandler(c, connectivityPaths2Func)
+	c.DiagnosticServer.RegisterHandler(c, verdictPaths2Func)
+	c.DiagnosticServer.RegisterHandler(c, floatingIPPaths2Func)
+	c.DiagnosticServer.RegisterHandler(c, driverOptionsPaths2Func)
+	c.DiagnosticServer.RegisterHandler(c, tenancyPaths2Func)
+	c.DiagnosticServer.RegisterHandler(c, shadowModePaths2Func)
+	c.DiagnosticServer.RegisterHandler(c, journalPaths2Func)
+	c.DiagnosticServer.RegisterHandler(c, splitBrainPaths2Func)
+	c.DiagnosticServer.RegisterHandler(c, filterPaths2Func)
+	c.DiagnosticServer.RegisterHandler(c, ulaPaths2Func)
+	c.DiagnosticServer.RegisterHandler(c, accountingPaths2Func)
+	c.DiagnosticServer.RegisterHandler(c, uplinkPaths2Func)
+	c.DiagnosticServer.SetAuthToken(c.cfg.Daemon.DiagnosticAuthToken)
+	if c.cfg.Daemon.DiagnosticProfiling {
+		c.DiagnosticServer.EnableProfiling()
+	}
+	if c.cfg.Daemon.FaultInjection {
+		if err := faults.Configure(c.cfg.Daemon.Faults); err != nil {
+			return nil, err
+		}
+		c.DiagnosticServer.RegisterHandler(c, faultsPaths2Func)
+		logrus.Warnf("Fault injection enabled, injecting %v", faults.Faults())
+	}
+
+	if len(c.cfg.Daemon.Webhooks) > 0 {
+		if err := c.startWebhooks(); err != nil {
+			return nil, err
+		}
+		defer func() {
+			if retErr != nil {
+				c.webhooksStop()
+			}
+		}()
+	}
+
+	if c.cfg.Daemon.IPv6ULA != nil {
+		if err := validateIPv6ULA(c.cfg.Daemon.IPv6ULA); err != nil {
+			return nil, err
+		}
+	}
+
+	if c.cfg.Daemon.SplitBrain != nil {
+		if err := c.startSplitBrainDetection(); err != nil {
+			return nil, err
+		}
+		defer func() {
+			if retErr != nil {
+				c.stopSplitBrainDetection()
+			}
+		}()
+	}
+
+	if c.cfg.Daemon.JournalPath != "" {
+		if err := c.openJournal(); err != nil {
+			return nil, err
+		}
+		defer func() {
+			if retErr != nil {
+				c.journal.Close()
+			}
+		}()
+	}
+
+	if c.cfg.Daemon.OTLP != nil {
+		e, err := otlp.New(*c.cfg.Daemon.OTLP)
+		if err != nil {
+			return nil, err
+		}
+		c.otlpExporter = e
+		defer func() {
+			if retErr != nil {
+				e.Stop()
+			}
+		}()
+	}
 
 	if err := c.initStores(); err != nil {
 		return nil, err
@@ -222,7 +541,7 @@ func New(cfgOptions ...config.Option) (NetworkController, error) {
 		}
 	}
 
-	if err = initIPAMDrivers(drvRegistry, nil, c.getStore(datastore.GlobalScope), c.cfg.Daemon.DefaultAddressPool); err != nil {
+	if err = initIPAMDrivers(drvRegistry, nil, c.getStore(datastore.GlobalScope), c.cfg.Daemon.DefaultAddressPool, c.cfg.Daemon.RESTIPAMs); err != nil {
 		return nil, err
 	}
 
@@ -236,7 +555,64 @@ func New(cfgOptions ...config.Option) (NetworkController, error) {
 		}
 	}
 
+	// The background loops are started before the last fallible steps, so
+	// a failure of these stops them
+	defer func() {
+		if retErr != nil {
+			c.stopLoops()
+		}
+	}()
+
+	if interval := c.cfg.Daemon.OrphanCleanupInterval; interval > 0 {
+		c.janitorStop = make(chan struct{})
+		go c.runJanitor(interval, c.janitorStop)
+	}
+
+	if interval := c.cfg.Daemon.NextHopProbeInterval; interval > 0 {
+		c.nextHopProber = &nextHopProber{
+			removeRoutes: c.cfg.Daemon.NextHopRemoveRoutes,
+			status:       map[string]*NextHopStatus{},
+			removed:      map[string][]routeRef{},
+		}
+		c.nextHopStop = make(chan struct{})
+		go c.runNextHopProbes(interval, c.nextHopStop)
+	}
+
+	if interval := c.cfg.Daemon.AccountingInterval; interval > 0 {
+		c.startAccounting(interval)
+	}
+
+	if len(c.cfg.Daemon.FailoverUplinks) > 0 {
+		if err := c.startUplinkWatch(c.cfg.Daemon.FailoverUplinks); err != nil {
+			logrus.Warnf("Failed to watch the uplinks for failovers: %v", err)
+		}
+	}
+
+	if len(c.cfg.Daemon.LLDPUplinks) > 0 {
+		if err := c.startLLDP(); err != nil {
+			logrus.Warnf("Failed to start the LLDP advertisements: %v", err)
+		}
+	}
+
+	if interval := c.cfg.Daemon.SocketAuditInterval; interval > 0 {
+		c.socketAuditStop = make(chan struct{})
+		go c.runSocketAudit(interval, c.socketAuditStop)
+	}
+
+	if len(c.cfg.Daemon.LBHooks) > 0 {
+		c.lbHookQueue = make(chan lbHookUpdate, lbHookQueueLen)
+		c.lbHookStop = make(chan struct{})
+		go c.runLBHooks(c.lbHookQueue, c.lbHookStop)
+	}
+
 	c.WalkNetworks(populateSpecial)
+	c.WalkNetworks(func(nw Network) bool {
+		if nw.(*network).exportsFlows() {
+			c.startFlowExport()
+			return true
+		}
+		return false
+	})
 
 	// Reserve pools first before doing cleanup. Otherwise the
 	// cleanups of endpoint/network and sandbox below will
@@ -245,13 +621,29 @@ func New(cfgOptions ...config.Option) (NetworkController, error) {
 
 	// Cleanup resources
 	c.sandboxCleanup(c.cfg.ActiveSandboxes)
+	c.cleanupSandboxPool()
 	c.cleanupLocalEndpoints()
 	c.networkCleanup()
+	c.restoreExternalEndpoints()
+
+	if len(c.cfg.Daemon.InterNetworkPolicies) > 0 {
+		if err := c.SetInterNetworkPolicies(c.cfg.Daemon.InterNetworkPolicies); err != nil {
+			return nil, err
+		}
+	}
 
 	if err := c.startExternalKeyListener(); err != nil {
 		return nil, err
 	}
 
+	if size := c.cfg.Daemon.SandboxPoolSize; size > 0 {
+		go func() {
+			if err := c.PoolSandboxes(size); err != nil {
+				logrus.Warnf("Failed to fill the sandbox pool: %v", err)
+			}
+		}()
+	}
+
 	return c, nil
 }
 
@@ -430,6 +822,10 @@ func (c *controller) makeDriverConfig(ntype string) map[string]interface{} {
 		config[netlabel.Key(label)] = netlabel.Value(label)
 	}
 
+	if len(c.cfg.Daemon.RouteExporters) > 0 {
+		config[netlabel.RouteExporters] = c.cfg.Daemon.RouteExporters
+	}
+
 	drvCfg, ok := c.cfg.Daemon.DriverCfg[ntype]
 	if ok {
 		for k, v := range drvCfg.(map[string]interface{}) {
@@ -442,10 +838,12 @@ func (c *controller) makeDriverConfig(ntype string) map[string]interface{} {
 			continue
 		}
 		config[netlabel.MakeKVClient(k)] = discoverapi.DatastoreConfigData{
-			Scope:    k,
-			Provider: v.Client.Provider,
-			Address:  v.Client.Address,
-			Config:   v.Client.Config,
+			Scope:       k,
+			Provider:    v.Client.Provider,
+			Address:     v.Client.Address,
+			Config:      v.Client.Config,
+			KeyProvider: v.Client.KeyProvider,
+			Resilience:  v.Client.Resilience,
 		}
 	}
 
@@ -495,10 +893,12 @@ func (c *controller) ReloadConfiguration(cfgOptions ...config.Option) error {
 			continue
 		}
 		dsConfig = &discoverapi.DatastoreConfigData{
-			Scope:    scope,
-			Provider: sCfg.Client.Provider,
-			Address:  sCfg.Client.Address,
-			Config:   sCfg.Client.Config,
+			Scope:       scope,
+			Provider:    sCfg.Client.Provider,
+			Address:     sCfg.Client.Address,
+			Config:      sCfg.Client.Config,
+			KeyProvider: sCfg.Client.KeyProvider,
+			Resilience:  sCfg.Client.Resilience,
 		}
 		break
 	}
@@ -522,7 +922,10 @@ func (c *controller) ReloadConfiguration(cfgOptions ...config.Option) error {
 		return false
 	})
 
-	if c.discovery == nil && c.cfg.Cluster.Watcher != nil {
+	c.Lock()
+	hd := c.discovery
+	c.Unlock()
+	if hd == nil && c.cfg.Cluster.Watcher != nil {
 		if err := c.initDiscovery(c.cfg.Cluster.Watcher); err != nil {
 			logrus.Errorf("Failed to Initialize Discovery after configuration update: %v", err)
 		}
@@ -575,11 +978,14 @@ func (c *controller) clusterHostID() string {
 }
 
 func (c *controller) isNodeAlive(node string) bool {
-	if c.discovery == nil {
+	c.Lock()
+	hd := c.discovery
+	c.Unlock()
+	if hd == nil {
 		return false
 	}
 
-	nodes := c.discovery.Fetch()
+	nodes := hd.Fetch()
 	for _, n := range nodes {
 		if n.String() == node {
 			return true
@@ -594,11 +1000,18 @@ func (c *controller) initDiscovery(watcher discovery.Watcher) error {
 		return fmt.Errorf("discovery initialization requires a valid configuration")
 	}
 
-	c.discovery = hostdiscovery.NewHostDiscovery(watcher)
-	return c.discovery.Watch(c.activeCallback, c.hostJoinCallback, c.hostLeaveCallback)
+	hd := hostdiscovery.NewHostDiscovery(watcher)
+	c.Lock()
+	c.discovery = hd
+	c.Unlock()
+	return hd.Watch(c.activeCallback, c.hostJoinCallback, c.hostLeaveCallback)
 }
 
 func (c *controller) activeCallback() {
+	c.Lock()
+	c.discoveryActive = true
+	c.Unlock()
+
 	ds := c.getStore(datastore.GlobalScope)
 	if ds != nil && !ds.Active() {
 		ds.RestartWatch()
@@ -696,15 +1109,82 @@ func (c *controller) RegisterDriver(networkType string, driver driverapi.Driver,
 	}
 
 	c.agentDriverNotify(driver)
+	c.restoreDriverEndpoints(networkType, driver)
 	return nil
 }
 
+// restoreDriverEndpoints tells the driver registering, if it keeps its own
+// track of its endpoints, of the endpoints of its networks in the store,
+// as the ones restored before a remote plugin is activated again after a
+// daemon restart
+func (c *controller) restoreDriverEndpoints(networkType string, driver driverapi.Driver) {
+	r, ok := driver.(driverapi.EndpointRestorer)
+	if !ok {
+		return
+	}
+	nws, err := c.getNetworksFromStore()
+	if err != nil {
+		logrus.Warnf("Failed to get the networks of driver %s to restore its endpoints: %v", networkType, err)
+		return
+	}
+	var eps []driverapi.EndpointState
+	for _, n := range nws {
+		if n.Type() != networkType || n.ConfigOnly() {
+			continue
+		}
+		epl, err := n.getEndpointsFromStore()
+		if err != nil {
+			logrus.Warnf("Failed to get the endpoints of network %s to restore them in driver %s: %v", n.Name(), networkType, err)
+			continue
+		}
+		for _, ep := range epl {
+			st := driverapi.EndpointState{NetworkID: n.ID(), EndpointID: ep.ID()}
+			if ep.sandboxID != "" {
+				st.Joined = true
+				c.Lock()
+				sb, ok := c.sandboxes[ep.sandboxID]
+				c.Unlock()
+				if ok {
+					st.SandboxKey = sb.Key()
+				} else {
+					st.SandboxKey = osl.GenerateKey(ep.sandboxID)
+				}
+			}
+			eps = append(eps, st)
+		}
+	}
+	r.RestoreEndpoints(eps)
+}
+
 // XXX  This should be made driver agnostic.  See comment below.
 const overlayDSROptionString = "dsr"
 
 // NewNetwork creates a new network of the specified network type. The options
 // are network specific and modeled in a generic way.
 func (c *controller) NewNetwork(networkType, name string, id string, options ...NetworkOption) (Network, error) {
+	key := networkIdempotencyKey(options)
+	if key == "" {
+		return c.newNetwork(networkType, name, id, options...)
+	}
+	prev, finish := c.claimIdempotencyKey("network/"+key, func() string {
+		return c.networkIDByIdempotencyKey(key)
+	})
+	if prev != "" {
+		logrus.Debugf("Network creation with idempotency key %s returns network %.7s", key, prev)
+		return c.NetworkByID(prev)
+	}
+	nw, err := c.newNetwork(networkType, name, id, options...)
+	if err != nil {
+		finish("", err)
+		return nil, err
+	}
+	finish(nw.ID(), nil)
+	return nw, nil
+}
+
+func (c *controller) newNetwork(networkType, name string, id string, options ...NetworkOption) (Network, error) {
+	defer c.opTracer.start(opNetworkCreate)()
+
 	var (
 		cap *driverapi.Capability
 		err error
@@ -747,6 +1227,18 @@ func (c *controller) NewNetwork(networkType, name string, id string, options ...
 	if err = network.validateConfiguration(); err != nil {
 		return nil, err
 	}
+	if err = network.setupTenant(); err != nil {
+		return nil, err
+	}
+	if err = network.setupDeletionProtection(); err != nil {
+		return nil, err
+	}
+	if _, err = network.nextHops(); err != nil {
+		return nil, err
+	}
+	if err = c.authorize(network.authzRequest(authz.NetworkCreate)); err != nil {
+		return nil, err
+	}
 
 	// Reset network types, force local scope and skip allocation and
 	// plumbing for configuration networks. Reset of the config-only
@@ -843,8 +1335,8 @@ func (c *controller) NewNetwork(networkType, name string, id string, options ...
 	// to implement in this manner.
 	if gval, ok := network.generic[netlabel.GenericData]; ok && network.networkType == "overlay" {
 		optMap := gval.(map[string]string)
-		if _, ok := optMap[overlayDSROptionString]; ok {
-			network.loadBalancerMode = loadBalancerModeDSR
+		if network.loadBalancerMode, network.dsrVIPs, err = parseDSROptions(optMap, network.ingress); err != nil {
+			return nil, err
 		}
 	}
 
@@ -877,6 +1369,7 @@ addToStore:
 	}()
 
 	if network.configOnly {
+		c.journalNetwork(journal.NetworkCreate, network)
 		return network, nil
 	}
 
@@ -903,6 +1396,14 @@ addToStore:
 	}
 
 	c.arrangeUserFilterRule()
+	c.applyInterNetworkPolicies()
+
+	if network.exportsFlows() {
+		c.startFlowExport()
+	}
+
+	c.publish(Event{Type: EventNetworkCreate, NetworkID: network.id, NetworkName: network.name})
+	c.journalNetwork(journal.NetworkCreate, network)
 
 	return network, nil
 }
@@ -1072,7 +1573,12 @@ func (c *controller) NewSandbox(containerID string, options ...SandboxOption) (S
 		return nil, types.BadRequestErrorf("invalid container ID")
 	}
 
-	var sb *sandbox
+	defer c.opTracer.start(opSandboxCreate)()
+
+	var (
+		sb     *sandbox
+		isStub bool
+	)
 	c.Lock()
 	for _, s := range c.sandboxes {
 		if s.containerID == containerID {
@@ -1089,6 +1595,7 @@ func (c *controller) NewSandbox(containerID string, options ...SandboxOption) (S
 			// isStub flag.
 			sb = s
 			sb.isStub = false
+			isStub = true
 			break
 		}
 	}
@@ -1121,6 +1628,13 @@ func (c *controller) NewSandbox(containerID string, options ...SandboxOption) (S
 		return nil, types.ForbiddenErrorf("ingress sandbox already present")
 	}
 
+	if max := c.cfg.Daemon.MaxSandboxes; max > 0 && !sb.ingress && sb.loadBalancerNID == "" && c.sandboxes[sb.id] == nil {
+		if cnt := c.containerSandboxCount(); cnt >= max {
+			c.Unlock()
+			return nil, &QuotaExceededError{Resource: "sandboxes", Scope: "the host", Limit: max}
+		}
+	}
+
 	if sb.ingress {
 		c.ingressSandbox = sb
 		sb.config.hostsPath = filepath.Join(c.cfg.Daemon.DataDir, "/network/files/hosts")
@@ -1131,6 +1645,10 @@ func (c *controller) NewSandbox(containerID string, options ...SandboxOption) (S
 	}
 	c.Unlock()
 
+	if !isStub {
+		c.claimPooledSandbox(sb)
+	}
+
 	var err error
 	defer func() {
 		if err != nil {
@@ -1227,6 +1745,19 @@ func (c *controller) SandboxByID(id string) (Sandbox, error) {
 	return s, nil
 }
 
+// containerSandboxCount returns the number of sandboxes created for
+// containers, like MaxSandboxes the ingress and load balancer sandboxes
+// are not counted. Must be called with the controller lock held.
+func (c *controller) containerSandboxCount() int {
+	cnt := 0
+	for _, s := range c.sandboxes {
+		if !s.ingress && s.loadBalancerNID == "" {
+			cnt++
+		}
+	}
+	return cnt
+}
+
 // SandboxDestroy destroys a sandbox given a container ID
 func (c *controller) SandboxDestroy(id string) error {
 	var sb *sandbox
@@ -1325,10 +1856,59 @@ func (c *controller) getIPAMDriver(name string) (ipamapi.Ipam, *ipamapi.Capabili
 	return id, cap, nil
 }
 
+// stopLoops stops the background loops started by New
+func (c *controller) stopLoops() {
+	if c.janitorStop != nil {
+		close(c.janitorStop)
+	}
+	if c.nextHopStop != nil {
+		close(c.nextHopStop)
+	}
+	c.stopAccounting()
+	c.stopUplinkWatch()
+	if c.lldpAdvertiser != nil {
+		c.lldpAdvertiser.Stop()
+	}
+	c.Lock()
+	flowExportStop := c.flowExportStop
+	c.Unlock()
+	if flowExportStop != nil {
+		close(flowExportStop)
+	}
+	if c.socketAuditStop != nil {
+		close(c.socketAuditStop)
+	}
+	if c.lbHookStop != nil {
+		close(c.lbHookStop)
+	}
+}
+
+// Stop stops the network controller. The stops after the first one are
+// no-ops, as the stop channels of the loops can be closed only once.
 func (c *controller) Stop() {
-	c.closeStores()
-	c.stopExternalKeyListener()
-	osl.GC()
+	c.stopOnce.Do(func() {
+		c.stopFloatingIPs()
+		c.stopLoops()
+		if c.splitBrainStop != nil {
+			c.stopSplitBrainDetection()
+		}
+		c.stopConntrackWatch()
+		if c.webhooksStop != nil {
+			c.webhooksStop()
+		}
+		if c.otlpExporter != nil {
+			c.otlpExporter.Stop()
+		}
+		c.eventBroadcaster.Close()
+		if c.journal != nil {
+			c.journal.Close()
+		}
+		c.stopWriteBehind()
+		c.closeStores()
+		c.stopExternalKeyListener()
+		c.drainSandboxPool()
+		osl.GC()
+	})
 }
 
 // StartDiagnostic start the network dias mode
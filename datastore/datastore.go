@@ -11,10 +11,11 @@ import (
 	"github.com/docker/libkv"
 	"github.com/docker/libkv/store"
 	"github.com/docker/libnetwork/discoverapi"
+	"github.com/docker/libnetwork/internal/faults"
 	"github.com/docker/libnetwork/types"
 )
 
-//DataStore exported
+// DataStore exported
 type DataStore interface {
 	// GetObject gets data from datastore and unmarshals to the specified object
 	GetObject(key string, o KVObject) error
@@ -109,6 +110,12 @@ type ScopeClientCfg struct {
 	Provider string
 	Address  string
 	Config   *store.Config
+	// KeyProvider, when set, enables the encryption of the values
+	// written to the store with the key it returns
+	KeyProvider KeyProvider
+	// Resilience, when set, enables the retries and the circuit breaker
+	// of the calls to the store
+	Resilience *Resilience
 }
 
 const (
@@ -174,14 +181,14 @@ func (cfg *ScopeCfg) IsValid() bool {
 	return true
 }
 
-//Key provides convenient method to create a Key
+// Key provides convenient method to create a Key
 func Key(key ...string) string {
 	keychain := append(rootChain, key...)
 	str := strings.Join(keychain, "/")
 	return str + "/"
 }
 
-//ParseKey provides convenient method to unpack the key to complement the Key function
+// ParseKey provides convenient method to unpack the key to complement the Key function
 func ParseKey(key string) ([]string, error) {
 	chain := strings.Split(strings.Trim(key, "/"), "/")
 
@@ -193,7 +200,7 @@ func ParseKey(key string) ([]string, error) {
 }
 
 // newClient used to connect to KV Store
-func newClient(scope string, kv string, addr string, config *store.Config, cached bool) (DataStore, error) {
+func newClient(scope string, kv string, addr string, config *store.Config, kp KeyProvider, r *Resilience, cached bool) (DataStore, error) {
 
 	if cached && scope != LocalScope {
 		return nil, fmt.Errorf("caching supported only for scope %s", LocalScope)
@@ -228,6 +235,16 @@ func newClient(scope string, kv string, addr string, config *store.Config, cache
 		return nil, err
 	}
 
+	if r != nil {
+		store = newResilientStore(store, *r)
+	}
+
+	if kp != nil {
+		if store, err = newEncryptedStore(store, kp); err != nil {
+			return nil, err
+		}
+	}
+
 	ds := &datastore{scope: scope, store: store, active: true, watchCh: make(chan struct{}), sequential: sequential}
 	if cached {
 		ds.cache = newCache(ds)
@@ -252,7 +269,7 @@ func NewDataStore(scope string, cfg *ScopeCfg) (DataStore, error) {
 		cached = true
 	}
 
-	return newClient(scope, cfg.Client.Provider, cfg.Client.Address, cfg.Client.Config, cached)
+	return newClient(scope, cfg.Client.Provider, cfg.Client.Address, cfg.Client.Config, cfg.Client.KeyProvider, cfg.Client.Resilience, cached)
 }
 
 // NewDataStoreFromConfig creates a new instance of LibKV data store starting from the datastore config data
@@ -267,11 +284,23 @@ func NewDataStoreFromConfig(dsc discoverapi.DatastoreConfigData) (DataStore, err
 		return nil, fmt.Errorf("cannot parse store configuration: %v", dsc.Config)
 	}
 
+	kp, ok := dsc.KeyProvider.(KeyProvider)
+	if !ok && dsc.KeyProvider != nil {
+		return nil, fmt.Errorf("cannot parse store key provider: %T", dsc.KeyProvider)
+	}
+
+	r, ok := dsc.Resilience.(*Resilience)
+	if !ok && dsc.Resilience != nil {
+		return nil, fmt.Errorf("cannot parse store resilience: %T", dsc.Resilience)
+	}
+
 	scopeCfg := &ScopeCfg{
 		Client: ScopeClientCfg{
-			Address:  dsc.Address,
-			Provider: dsc.Provider,
-			Config:   sCfgP,
+			Address:     dsc.Address,
+			Provider:    dsc.Provider,
+			Config:      sCfgP,
+			KeyProvider: kp,
+			Resilience:  r,
 		},
 	}
 
@@ -406,6 +435,12 @@ func (ds *datastore) PutObjectAtomic(kvObject KVObject) error {
 		goto add_cache
 	}
 
+	if drop, err := injectWriteFault(kvObject); err != nil {
+		return err
+	} else if drop {
+		goto add_cache
+	}
+
 	if kvObject.Exists() {
 		previous = &store.KVPair{Key: Key(kvObject.Key()...), LastIndex: kvObject.Index()}
 	} else {
@@ -447,6 +482,12 @@ func (ds *datastore) PutObject(kvObject KVObject) error {
 		goto add_cache
 	}
 
+	if drop, err := injectWriteFault(kvObject); err != nil {
+		return err
+	} else if drop {
+		goto add_cache
+	}
+
 	if err := ds.putObjectWithKey(kvObject, kvObject.Key()...); err != nil {
 		return err
 	}
@@ -582,6 +623,19 @@ func (ds *datastore) Map(key string, kvObject KVObject) (map[string]KVObject, er
 	return kvol, nil
 }
 
+// injectWriteFault injects the datastore faults in the write of the object,
+// which is not written to the store when the fault drops it
+func injectWriteFault(kvObject KVObject) (bool, error) {
+	switch err := faults.Inject(faults.Datastore); err {
+	case nil:
+		return false, nil
+	case faults.ErrDropped:
+		return true, nil
+	default:
+		return false, fmt.Errorf("failed to write %s: %v", Key(kvObject.Key()...), err)
+	}
+}
+
 // DeleteObject unconditionally deletes a record from the store
 func (ds *datastore) DeleteObject(kvObject KVObject) error {
 	if ds.sequential {
@@ -0,0 +1,277 @@
+package datastore
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/docker/libkv/store"
+)
+
+// KeyProvider returns the 32 bytes AES-256 key used to encrypt the values
+// of a store.
+type KeyProvider interface {
+	Key() ([]byte, error)
+}
+
+// FileKeyProvider reads the key from a file
+type FileKeyProvider struct {
+	Path string
+}
+
+// Key reads and decodes the content of the file
+func (p *FileKeyProvider) Key() ([]byte, error) {
+	b, err := ioutil.ReadFile(p.Path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read store key: %v", err)
+	}
+	return decodeKey(b)
+}
+
+func (p *FileKeyProvider) String() string {
+	return "file:" + p.Path
+}
+
+// EnvKeyProvider reads the key from an environment variable
+type EnvKeyProvider struct {
+	Name string
+}
+
+// Key decodes the value of the environment variable
+func (p *EnvKeyProvider) Key() ([]byte, error) {
+	v, ok := os.LookupEnv(p.Name)
+	if !ok {
+		return nil, fmt.Errorf("store key variable %s is not set", p.Name)
+	}
+	return decodeKey([]byte(v))
+}
+
+func (p *EnvKeyProvider) String() string {
+	return "env:" + p.Name
+}
+
+// CommandKeyProvider runs an external program, typically a hook fetching
+// or unwrapping the key through a KMS, and reads the key on its output.
+type CommandKeyProvider struct {
+	Path    string
+	Args    []string
+	Timeout time.Duration
+}
+
+const defaultKeyCommandTimeout = 30 * time.Second
+
+// Key runs the command and decodes its standard output
+func (p *CommandKeyProvider) Key() ([]byte, error) {
+	timeout := p.Timeout
+	if timeout == 0 {
+		timeout = defaultKeyCommandTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, p.Path, p.Args...)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("store key command %s failed: %v: %s", p.Path, err, strings.TrimSpace(stderr.String()))
+	}
+	return decodeKey(out)
+}
+
+func (p *CommandKeyProvider) String() string {
+	return "command:" + p.Path
+}
+
+// decodeKey accepts a key as 32 raw bytes, or hex or base64 encoded
+func decodeKey(b []byte) ([]byte, error) {
+	if len(b) == 32 {
+		return b, nil
+	}
+	s := strings.TrimSpace(string(b))
+	if k, err := hex.DecodeString(s); err == nil && len(k) == 32 {
+		return k, nil
+	}
+	if k, err := base64.StdEncoding.DecodeString(s); err == nil && len(k) == 32 {
+		return k, nil
+	}
+	return nil, fmt.Errorf("store key must be 32 bytes, raw or hex/base64 encoded")
+}
+
+// encryptedMagic prefixes the encrypted values. Values without it are
+// returned as is, which allows an existing store to be encrypted in place as
+// its objects get rewritten.
+var encryptedMagic = []byte("lnenc1:")
+
+// encryptedStore seals with AES-GCM the values written to the wrapped
+// store. Keys are left in clear.
+type encryptedStore struct {
+	store.Store
+	aead cipher.AEAD
+}
+
+func newEncryptedStore(s store.Store, kp KeyProvider) (store.Store, error) {
+	key, err := kp.Key()
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &encryptedStore{Store: s, aead: aead}, nil
+}
+
+func (s *encryptedStore) seal(key string, value []byte) ([]byte, error) {
+	hdr := len(encryptedMagic) + s.aead.NonceSize()
+	out := make([]byte, hdr, hdr+len(value)+s.aead.Overhead())
+	copy(out, encryptedMagic)
+	nonce := out[len(encryptedMagic):]
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return s.aead.Seal(out, nonce, value, additionalData(key)), nil
+}
+
+// additionalData binds a value to its key so that encrypted values cannot
+// be swapped between keys. Backends differ on leading and trailing slashes.
+func additionalData(key string) []byte {
+	return []byte(strings.Trim(key, "/"))
+}
+
+func (s *encryptedStore) open(kv *store.KVPair) (*store.KVPair, error) {
+	if kv == nil || !bytes.HasPrefix(kv.Value, encryptedMagic) {
+		return kv, nil
+	}
+	data := kv.Value[len(encryptedMagic):]
+	ns := s.aead.NonceSize()
+	if len(data) < ns {
+		return nil, fmt.Errorf("invalid encrypted value for key %s", kv.Key)
+	}
+	value, err := s.aead.Open(nil, data[:ns], data[ns:], additionalData(kv.Key))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt value for key %s: %v", kv.Key, err)
+	}
+	return &store.KVPair{Key: kv.Key, Value: value, LastIndex: kv.LastIndex}, nil
+}
+
+func (s *encryptedStore) openAll(kvs []*store.KVPair) ([]*store.KVPair, error) {
+	out := make([]*store.KVPair, 0, len(kvs))
+	for _, kv := range kvs {
+		kv, err := s.open(kv)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, kv)
+	}
+	return out, nil
+}
+
+// Put encrypts and stores the value at "key"
+func (s *encryptedStore) Put(key string, value []byte, options *store.WriteOptions) error {
+	sealed, err := s.seal(key, value)
+	if err != nil {
+		return err
+	}
+	return s.Store.Put(key, sealed, options)
+}
+
+// Get returns the decrypted value at "key"
+func (s *encryptedStore) Get(key string) (*store.KVPair, error) {
+	kv, err := s.Store.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	if kv != nil && kv.Key == "" {
+		kv.Key = key
+	}
+	return s.open(kv)
+}
+
+// List returns the decrypted values under "directory"
+func (s *encryptedStore) List(directory string) ([]*store.KVPair, error) {
+	kvs, err := s.Store.List(directory)
+	if err != nil {
+		return nil, err
+	}
+	return s.openAll(kvs)
+}
+
+// AtomicPut encrypts and stores the value at "key" if it was not modified
+func (s *encryptedStore) AtomicPut(key string, value []byte, previous *store.KVPair, options *store.WriteOptions) (bool, *store.KVPair, error) {
+	sealed, err := s.seal(key, value)
+	if err != nil {
+		return false, nil, err
+	}
+	ok, kv, err := s.Store.AtomicPut(key, sealed, previous, options)
+	if kv != nil {
+		kv = &store.KVPair{Key: key, Value: value, LastIndex: kv.LastIndex}
+	}
+	return ok, kv, err
+}
+
+// Watch returns the decrypted values of "key"
+func (s *encryptedStore) Watch(key string, stopCh <-chan struct{}) (<-chan *store.KVPair, error) {
+	in, err := s.Store.Watch(key, stopCh)
+	if err != nil {
+		return nil, err
+	}
+	out := make(chan *store.KVPair)
+	go func() {
+		defer close(out)
+		for kv := range in {
+			kv, err := s.open(kv)
+			if err != nil {
+				log.Printf("Stopping watch of %s: %v", key, err)
+				return
+			}
+			select {
+			case out <- kv:
+			case <-stopCh:
+				return
+			}
+		}
+	}()
+	return out, nil
+}
+
+// WatchTree returns the decrypted values under "directory"
+func (s *encryptedStore) WatchTree(directory string, stopCh <-chan struct{}) (<-chan []*store.KVPair, error) {
+	in, err := s.Store.WatchTree(directory, stopCh)
+	if err != nil {
+		return nil, err
+	}
+	out := make(chan []*store.KVPair)
+	go func() {
+		defer close(out)
+		for kvs := range in {
+			kvs, err := s.openAll(kvs)
+			if err != nil {
+				log.Printf("Stopping watch of %s: %v", directory, err)
+				return
+			}
+			select {
+			case out <- kvs:
+			case <-stopCh:
+				return
+			}
+		}
+	}()
+	return out, nil
+}
@@ -0,0 +1,127 @@
+package datastore
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/docker/libkv"
+	"github.com/docker/libkv/store"
+	"github.com/docker/libkv/store/boltdb"
+)
+
+func newTestBoltStore(t *testing.T) (store.Store, func()) {
+	boltdb.Register()
+	dir, err := ioutil.TempDir("", "encrypt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := libkv.NewStore(store.BOLTDB, []string{filepath.Join(dir, "local-kv.db")}, &store.Config{Bucket: "libnetwork"})
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return s, func() {
+		s.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestEncryptedStore(t *testing.T) {
+	raw, cleanup := newTestBoltStore(t)
+	defer cleanup()
+
+	key := bytes.Repeat([]byte{0x42}, 32)
+	os.Setenv("LIBNETWORK_TEST_KEY", hex.EncodeToString(key))
+	defer os.Unsetenv("LIBNETWORK_TEST_KEY")
+
+	s, err := newEncryptedStore(raw, &EnvKeyProvider{Name: "LIBNETWORK_TEST_KEY"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := raw.Put("docker/network/v1.0/network/old/", []byte("clear"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Put("docker/network/v1.0/network/n1/", []byte("secret"), nil); err != nil {
+		t.Fatal(err)
+	}
+	kv, err := raw.Get("docker/network/v1.0/network/n1/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(kv.Value, []byte("secret")) || !bytes.HasPrefix(kv.Value, encryptedMagic) {
+		t.Fatalf("value stored in clear: %q", kv.Value)
+	}
+
+	kv, err = s.Get("docker/network/v1.0/network/n1/")
+	if err != nil || string(kv.Value) != "secret" {
+		t.Fatalf("unexpected value %v: %v", kv, err)
+	}
+	ok, kv, err := s.AtomicPut("docker/network/v1.0/network/n1/", []byte("again"), kv, nil)
+	if err != nil || !ok || string(kv.Value) != "again" {
+		t.Fatalf("atomic put failed %v: %v", kv, err)
+	}
+
+	kvs, err := s.List("docker/network/v1.0/network")
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := map[string]bool{}
+	for _, kv := range kvs {
+		values[string(kv.Value)] = true
+	}
+	if len(kvs) != 2 || !values["again"] || !values["clear"] {
+		t.Fatalf("unexpected list %v", values)
+	}
+
+	// A value moved to another key must not decrypt
+	kv, _ = raw.Get("docker/network/v1.0/network/n1/")
+	if err := raw.Put("docker/network/v1.0/network/n2/", kv.Value, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Get("docker/network/v1.0/network/n2/"); err == nil {
+		t.Fatal("expected a decryption failure for a swapped value")
+	}
+
+	other, err := newEncryptedStore(raw, &EnvKeyProvider{Name: "LIBNETWORK_TEST_KEY"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kv, err := other.Get("docker/network/v1.0/network/n1/"); err != nil || string(kv.Value) != "again" {
+		t.Fatalf("unexpected value with a new handle %v: %v", kv, err)
+	}
+}
+
+func TestKeyProviders(t *testing.T) {
+	key := bytes.Repeat([]byte{0x17}, 32)
+
+	f, err := ioutil.TempFile("", "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString(hex.EncodeToString(key) + "\n")
+	f.Close()
+
+	k, err := (&FileKeyProvider{Path: f.Name()}).Key()
+	if err != nil || !bytes.Equal(k, key) {
+		t.Fatalf("unexpected file key %x: %v", k, err)
+	}
+
+	k, err = (&CommandKeyProvider{Path: "/bin/sh", Args: []string{"-c", "cat " + f.Name()}}).Key()
+	if err != nil || !bytes.Equal(k, key) {
+		t.Fatalf("unexpected command key %x: %v", k, err)
+	}
+
+	if _, err := (&CommandKeyProvider{Path: "/bin/sh", Args: []string{"-c", "echo denied >&2; exit 1"}}).Key(); err == nil {
+		t.Fatal("expected a failure of the key command")
+	}
+
+	if _, err := decodeKey([]byte("short")); err == nil {
+		t.Fatal("expected a failure for an invalid key")
+	}
+}
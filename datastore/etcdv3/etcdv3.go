@@ -0,0 +1,514 @@
+// Package etcdv3 implements a libkv store backend for the etcd v3 API.
+//
+// The etcd v3 API is natively served over gRPC; this backend talks to the
+// JSON gateway etcd exposes for it on the client port (/v3/...), which is
+// enabled by default since etcd 3.3 and keeps the backend free of the gRPC
+// dependency chain. Keys are mapped one to one, with libkv's directories
+// expressed as key prefixes.
+package etcdv3
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/libkv"
+	"github.com/docker/libkv/store"
+)
+
+// ETCDV3 is the libkv backend name of this store
+const ETCDV3 store.Backend = "etcdv3"
+
+const (
+	apiPrefix      = "/v3"
+	defaultTimeout = 10 * time.Second
+)
+
+// EtcdV3 is the receiver type for the Store interface
+type EtcdV3 struct {
+	endpoints []string
+	client    *http.Client
+	stream    *http.Client
+	timeout   time.Duration
+	username  string
+	password  string
+
+	sync.Mutex
+	token   string
+	current int // index of the last endpoint which answered
+}
+
+// Register registers etcdv3 to libkv
+func Register() {
+	libkv.AddStore(ETCDV3, New)
+}
+
+// New creates a new etcd v3 client given a list of endpoints and an
+// optional tls config
+func New(addrs []string, options *store.Config) (store.Store, error) {
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("no etcd endpoint specified")
+	}
+	s := &EtcdV3{timeout: defaultTimeout}
+	tr := &http.Transport{}
+	scheme := "http"
+	if options != nil {
+		if options.ConnectionTimeout != 0 {
+			s.timeout = options.ConnectionTimeout
+		}
+		s.username, s.password = options.Username, options.Password
+		tlsCfg := options.TLS
+		if tlsCfg == nil && options.ClientTLS != nil {
+			var err error
+			if tlsCfg, err = loadTLS(options.ClientTLS); err != nil {
+				return nil, err
+			}
+		}
+		if tlsCfg != nil {
+			tr.TLSClientConfig = tlsCfg
+			scheme = "https"
+		}
+	}
+	for _, a := range addrs {
+		if !strings.Contains(a, "://") {
+			a = scheme + "://" + a
+		}
+		s.endpoints = append(s.endpoints, strings.TrimSuffix(a, "/"))
+	}
+	s.client = &http.Client{Transport: tr, Timeout: s.timeout}
+	// Watches and lease keep alives are long lived streams
+	s.stream = &http.Client{Transport: tr}
+
+	return s, nil
+}
+
+func loadTLS(c *store.ClientTLSConfig) (*tls.Config, error) {
+	cfg := &tls.Config{}
+	if c.CertFile != "" && c.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load etcd client certificate: %v", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	if c.CACertFile != "" {
+		pem, err := ioutil.ReadFile(c.CACertFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read etcd CA certificate: %v", err)
+		}
+		cfg.RootCAs = x509.NewCertPool()
+		if !cfg.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in %s", c.CACertFile)
+		}
+	}
+	return cfg, nil
+}
+
+// normalize the key for usage in etcd
+func (s *EtcdV3) normalize(key string) string {
+	return store.Normalize(key)
+}
+
+// prefixRange returns the key range covering the content of a directory
+func prefixRange(dir string) (string, string) {
+	key := strings.TrimSuffix(store.Normalize(dir), "/") + "/"
+	end := []byte(key)
+	end[len(end)-1]++
+	return key, string(end)
+}
+
+func b64(s string) string {
+	return base64.StdEncoding.EncodeToString([]byte(s))
+}
+
+// rpcError is the error message returned by the gateway
+type rpcError struct {
+	Error   string `json:"error"`
+	Message string `json:"message"`
+	Code    int    `json:"code"`
+}
+
+func (e *rpcError) String() string {
+	if e.Message != "" {
+		return e.Message
+	}
+	return e.Error
+}
+
+// request sends a unary call to the gateway, failing over to the next
+// endpoint when the current one cannot be reached
+func (s *EtcdV3) request(ctx context.Context, client *http.Client, path string, in interface{}) (*http.Response, error) {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return nil, err
+	}
+	s.Lock()
+	start, token := s.current, s.token
+	s.Unlock()
+
+	var lastErr error
+	for i := 0; i < len(s.endpoints); i++ {
+		idx := (start + i) % len(s.endpoints)
+		req, err := http.NewRequest("POST", s.endpoints[idx]+apiPrefix+path, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			req.Header.Set("Authorization", token)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			lastErr = err
+			continue
+		}
+		s.Lock()
+		s.current = idx
+		s.Unlock()
+		return resp, nil
+	}
+	return nil, fmt.Errorf("%v: %v", store.ErrNotReachable, lastErr)
+}
+
+func (s *EtcdV3) call(path string, in, out interface{}) error {
+	return s.callAuth(path, in, out, true)
+}
+
+func (s *EtcdV3) callAuth(path string, in, out interface{}, retryAuth bool) error {
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+	resp, err := s.request(ctx, s.client, path, in)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		rerr := &rpcError{}
+		if json.Unmarshal(b, rerr) != nil {
+			rerr.Error = strings.TrimSpace(string(b))
+		}
+		if retryAuth && s.username != "" && isAuthError(resp.StatusCode, rerr) {
+			if err := s.authenticate(); err != nil {
+				return err
+			}
+			return s.callAuth(path, in, out, false)
+		}
+		return fmt.Errorf("etcd %s failed: %s", path, rerr)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(b, out)
+}
+
+func isAuthError(status int, e *rpcError) bool {
+	if status == http.StatusUnauthorized {
+		return true
+	}
+	msg := e.String()
+	return strings.Contains(msg, "user name is empty") || strings.Contains(msg, "invalid auth token")
+}
+
+// authenticate obtains a token for the configured user
+func (s *EtcdV3) authenticate() error {
+	var resp struct {
+		Token string `json:"token"`
+	}
+	req := map[string]string{"name": s.username, "password": s.password}
+	if err := s.callAuth("/auth/authenticate", req, &resp, false); err != nil {
+		return err
+	}
+	s.Lock()
+	s.token = resp.Token
+	s.Unlock()
+	return nil
+}
+
+// int64 values are encoded as strings by the gateway
+type jsonInt int64
+
+func (i *jsonInt) UnmarshalJSON(b []byte) error {
+	v, err := strconv.ParseInt(strings.Trim(string(b), `"`), 10, 64)
+	if err != nil {
+		return err
+	}
+	*i = jsonInt(v)
+	return nil
+}
+
+type header struct {
+	Revision jsonInt `json:"revision"`
+}
+
+type keyValue struct {
+	Key            []byte  `json:"key"`
+	Value          []byte  `json:"value"`
+	CreateRevision jsonInt `json:"create_revision"`
+	ModRevision    jsonInt `json:"mod_revision"`
+	Lease          jsonInt `json:"lease"`
+}
+
+func (kv *keyValue) pair() *store.KVPair {
+	return &store.KVPair{
+		Key:       string(kv.Key),
+		Value:     kv.Value,
+		LastIndex: uint64(kv.ModRevision),
+	}
+}
+
+type rangeRequest struct {
+	Key      string `json:"key"`
+	RangeEnd string `json:"range_end,omitempty"`
+	KeysOnly bool   `json:"keys_only,omitempty"`
+}
+
+type rangeResponse struct {
+	Header header      `json:"header"`
+	Kvs    []*keyValue `json:"kvs"`
+}
+
+type putRequest struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+	Lease int64  `json:"lease,omitempty"`
+}
+
+type deleteRequest struct {
+	Key      string `json:"key"`
+	RangeEnd string `json:"range_end,omitempty"`
+}
+
+type deleteResponse struct {
+	Header  header  `json:"header"`
+	Deleted jsonInt `json:"deleted"`
+}
+
+type compare struct {
+	Key            string `json:"key"`
+	Target         string `json:"target"`
+	Result         string `json:"result"`
+	ModRevision    int64  `json:"mod_revision,omitempty"`
+	CreateRevision int64  `json:"create_revision,omitempty"`
+}
+
+type requestOp struct {
+	RequestRange       *rangeRequest  `json:"request_range,omitempty"`
+	RequestPut         *putRequest    `json:"request_put,omitempty"`
+	RequestDeleteRange *deleteRequest `json:"request_delete_range,omitempty"`
+}
+
+type txnRequest struct {
+	Compare []compare   `json:"compare"`
+	Success []requestOp `json:"success"`
+	Failure []requestOp `json:"failure,omitempty"`
+}
+
+type txnResponse struct {
+	Header    header `json:"header"`
+	Succeeded bool   `json:"succeeded"`
+	Responses []struct {
+		ResponseRange *rangeResponse `json:"response_range"`
+	} `json:"responses"`
+}
+
+type leaseGrantResponse struct {
+	ID  jsonInt `json:"ID"`
+	TTL jsonInt `json:"TTL"`
+}
+
+func ttlSeconds(ttl time.Duration) int64 {
+	secs := int64(ttl / time.Second)
+	if ttl%time.Second != 0 {
+		secs++
+	}
+	return secs
+}
+
+// grant creates a lease with the given time to live
+func (s *EtcdV3) grant(ttl time.Duration) (int64, error) {
+	var resp leaseGrantResponse
+	req := map[string]int64{"TTL": ttlSeconds(ttl)}
+	if err := s.call("/lease/grant", req, &resp); err != nil {
+		return 0, err
+	}
+	return int64(resp.ID), nil
+}
+
+func (s *EtcdV3) revoke(id int64) error {
+	return s.call("/lease/revoke", map[string]int64{"ID": id}, nil)
+}
+
+func (s *EtcdV3) leaseFor(opts *store.WriteOptions) (int64, error) {
+	if opts == nil || opts.TTL <= 0 {
+		return 0, nil
+	}
+	return s.grant(opts.TTL)
+}
+
+// Get the value at "key", returns the last modified index to use in
+// conjunction with Atomic calls
+func (s *EtcdV3) Get(key string) (*store.KVPair, error) {
+	var resp rangeResponse
+	if err := s.call("/kv/range", &rangeRequest{Key: b64(s.normalize(key))}, &resp); err != nil {
+		return nil, err
+	}
+	if len(resp.Kvs) == 0 {
+		return nil, store.ErrKeyNotFound
+	}
+	return resp.Kvs[0].pair(), nil
+}
+
+// Put a value at "key". A TTL in the options attaches the key to a lease.
+func (s *EtcdV3) Put(key string, value []byte, opts *store.WriteOptions) error {
+	if opts != nil && opts.IsDir {
+		// Directories are implicit in the v3 key space
+		return nil
+	}
+	lease, err := s.leaseFor(opts)
+	if err != nil {
+		return err
+	}
+	return s.call("/kv/put", &putRequest{Key: b64(s.normalize(key)), Value: base64.StdEncoding.EncodeToString(value), Lease: lease}, nil)
+}
+
+// Delete a value at "key"
+func (s *EtcdV3) Delete(key string) error {
+	var resp deleteResponse
+	if err := s.call("/kv/deleterange", &deleteRequest{Key: b64(s.normalize(key))}, &resp); err != nil {
+		return err
+	}
+	if resp.Deleted == 0 {
+		return store.ErrKeyNotFound
+	}
+	return nil
+}
+
+// Exists checks if the key exists inside the store
+func (s *EtcdV3) Exists(key string) (bool, error) {
+	_, err := s.Get(key)
+	if err != nil {
+		if err == store.ErrKeyNotFound {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// List child nodes of a given directory. Unlike the v2 backend, the whole
+// subtree is returned.
+func (s *EtcdV3) List(directory string) ([]*store.KVPair, error) {
+	k, end := prefixRange(directory)
+	var resp rangeResponse
+	if err := s.call("/kv/range", &rangeRequest{Key: b64(k), RangeEnd: b64(end)}, &resp); err != nil {
+		return nil, err
+	}
+	if len(resp.Kvs) == 0 {
+		return nil, store.ErrKeyNotFound
+	}
+	kvs := make([]*store.KVPair, 0, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		kvs = append(kvs, kv.pair())
+	}
+	return kvs, nil
+}
+
+// DeleteTree deletes a range of keys under a given directory
+func (s *EtcdV3) DeleteTree(directory string) error {
+	k, end := prefixRange(directory)
+	return s.call("/kv/deleterange", &deleteRequest{Key: b64(k), RangeEnd: b64(end)}, nil)
+}
+
+// AtomicPut puts a value at "key" if the key has not been modified in the
+// meantime, throws an error if this is the case
+func (s *EtcdV3) AtomicPut(key string, value []byte, previous *store.KVPair, opts *store.WriteOptions) (bool, *store.KVPair, error) {
+	lease, err := s.leaseFor(opts)
+	if err != nil {
+		return false, nil, err
+	}
+	k := b64(s.normalize(key))
+	cmp := compare{Key: k, Result: "EQUAL"}
+	if previous == nil {
+		// Create only if the key does not exist yet
+		cmp.Target = "CREATE"
+	} else {
+		cmp.Target = "MOD"
+		cmp.ModRevision = int64(previous.LastIndex)
+	}
+	req := &txnRequest{
+		Compare: []compare{cmp},
+		Success: []requestOp{{RequestPut: &putRequest{Key: k, Value: base64.StdEncoding.EncodeToString(value), Lease: lease}}},
+		Failure: []requestOp{{RequestRange: &rangeRequest{Key: k, KeysOnly: true}}},
+	}
+	var resp txnResponse
+	if err := s.call("/kv/txn", req, &resp); err != nil {
+		return false, nil, err
+	}
+	if !resp.Succeeded {
+		if lease != 0 {
+			s.revoke(lease)
+		}
+		if previous == nil {
+			return false, nil, store.ErrKeyExists
+		}
+		if !rangeFound(&resp) {
+			return false, nil, store.ErrKeyNotFound
+		}
+		return false, nil, store.ErrKeyModified
+	}
+	return true, &store.KVPair{Key: key, Value: value, LastIndex: uint64(resp.Header.Revision)}, nil
+}
+
+// AtomicDelete deletes a value at "key" if the key has not been modified
+// in the meantime, throws an error if this is the case
+func (s *EtcdV3) AtomicDelete(key string, previous *store.KVPair) (bool, error) {
+	if previous == nil {
+		return false, store.ErrPreviousNotSpecified
+	}
+	k := b64(s.normalize(key))
+	req := &txnRequest{
+		Compare: []compare{{Key: k, Target: "MOD", Result: "EQUAL", ModRevision: int64(previous.LastIndex)}},
+		Success: []requestOp{{RequestDeleteRange: &deleteRequest{Key: k}}},
+		Failure: []requestOp{{RequestRange: &rangeRequest{Key: k, KeysOnly: true}}},
+	}
+	var resp txnResponse
+	if err := s.call("/kv/txn", req, &resp); err != nil {
+		return false, err
+	}
+	if !resp.Succeeded {
+		if !rangeFound(&resp) {
+			return false, store.ErrKeyNotFound
+		}
+		return false, store.ErrKeyModified
+	}
+	return true, nil
+}
+
+func rangeFound(resp *txnResponse) bool {
+	return len(resp.Responses) > 0 && resp.Responses[0].ResponseRange != nil && len(resp.Responses[0].ResponseRange.Kvs) > 0
+}
+
+// Close closes the client connection
+func (s *EtcdV3) Close() {
+	if tr, ok := s.client.Transport.(*http.Transport); ok {
+		tr.CloseIdleConnections()
+	}
+}
@@ -0,0 +1,440 @@
+package etcdv3
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/docker/libkv/store"
+)
+
+// fakeGateway is a minimal in memory implementation of the etcd v3 JSON
+// gateway, covering the calls made by the backend
+type fakeGateway struct {
+	sync.Mutex
+	rev      int64
+	kvs      map[string]*fakeKV
+	leases   map[int64][]string
+	watchers []*fakeWatcher
+}
+
+type fakeKV struct {
+	value              []byte
+	create, mod, lease int64
+}
+
+type fakeWatcher struct {
+	key, end string
+	ch       chan string
+}
+
+func newFakeGateway() *fakeGateway {
+	return &fakeGateway{kvs: map[string]*fakeKV{}, leases: map[int64][]string{}}
+}
+
+func inRange(k, key, end string) bool {
+	if end == "" {
+		return k == key
+	}
+	return k >= key && k < end
+}
+
+func (g *fakeGateway) kvJSON(k string, kv *fakeKV) map[string]interface{} {
+	return map[string]interface{}{
+		"key":             []byte(k),
+		"value":           kv.value,
+		"create_revision": fmt.Sprint(kv.create),
+		"mod_revision":    fmt.Sprint(kv.mod),
+	}
+}
+
+func (g *fakeGateway) rangeLocked(req *rangeRequest) map[string]interface{} {
+	key, end := decode(req.Key), decode(req.RangeEnd)
+	var keys []string
+	for k := range g.kvs {
+		if inRange(k, key, end) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	kvs := []interface{}{}
+	for _, k := range keys {
+		kvs = append(kvs, g.kvJSON(k, g.kvs[k]))
+	}
+	return map[string]interface{}{"header": g.header(), "kvs": kvs}
+}
+
+func (g *fakeGateway) header() map[string]string {
+	return map[string]string{"revision": fmt.Sprint(g.rev)}
+}
+
+func (g *fakeGateway) notify(ev map[string]interface{}, k string) {
+	b, _ := json.Marshal(map[string]interface{}{"result": map[string]interface{}{"events": []interface{}{ev}}})
+	for _, w := range g.watchers {
+		if inRange(k, w.key, w.end) {
+			w.ch <- string(b)
+		}
+	}
+}
+
+func (g *fakeGateway) putLocked(req *putRequest) {
+	k := decode(req.Key)
+	g.rev++
+	kv, ok := g.kvs[k]
+	if !ok {
+		kv = &fakeKV{create: g.rev}
+		g.kvs[k] = kv
+	}
+	kv.value = []byte(decode(req.Value))
+	kv.mod = g.rev
+	if req.Lease != 0 {
+		kv.lease = req.Lease
+		g.leases[req.Lease] = append(g.leases[req.Lease], k)
+	}
+	g.notify(map[string]interface{}{"kv": g.kvJSON(k, kv)}, k)
+}
+
+func (g *fakeGateway) deleteLocked(req *deleteRequest) int {
+	key, end := decode(req.Key), decode(req.RangeEnd)
+	n := 0
+	for k := range g.kvs {
+		if inRange(k, key, end) {
+			g.rev++
+			delete(g.kvs, k)
+			g.notify(map[string]interface{}{"type": "DELETE", "kv": map[string]interface{}{"key": []byte(k)}}, k)
+			n++
+		}
+	}
+	return n
+}
+
+func decode(s string) string {
+	var b []byte
+	json.Unmarshal([]byte(`"`+s+`"`), &b)
+	return string(b)
+}
+
+func (g *fakeGateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	dec := json.NewDecoder(r.Body)
+	reply := func(v interface{}) {
+		json.NewEncoder(w).Encode(v)
+	}
+	switch strings.TrimPrefix(r.URL.Path, apiPrefix) {
+	case "/kv/range":
+		var req rangeRequest
+		dec.Decode(&req)
+		g.Lock()
+		defer g.Unlock()
+		reply(g.rangeLocked(&req))
+	case "/kv/put":
+		var req putRequest
+		dec.Decode(&req)
+		g.Lock()
+		defer g.Unlock()
+		g.putLocked(&req)
+		reply(map[string]interface{}{"header": g.header()})
+	case "/kv/deleterange":
+		var req deleteRequest
+		dec.Decode(&req)
+		g.Lock()
+		defer g.Unlock()
+		n := g.deleteLocked(&req)
+		reply(map[string]interface{}{"header": g.header(), "deleted": fmt.Sprint(n)})
+	case "/kv/txn":
+		var req txnRequest
+		dec.Decode(&req)
+		g.Lock()
+		defer g.Unlock()
+		ok := true
+		for _, c := range req.Compare {
+			kv := g.kvs[decode(c.Key)]
+			var v int64
+			if kv != nil {
+				v = kv.mod
+				if c.Target == "CREATE" {
+					v = kv.create
+				}
+			}
+			exp := c.ModRevision
+			if c.Target == "CREATE" {
+				exp = c.CreateRevision
+			}
+			ok = ok && v == exp
+		}
+		ops := req.Success
+		if !ok {
+			ops = req.Failure
+		}
+		var resps []interface{}
+		for _, op := range ops {
+			switch {
+			case op.RequestPut != nil:
+				g.putLocked(op.RequestPut)
+				resps = append(resps, map[string]interface{}{})
+			case op.RequestDeleteRange != nil:
+				g.deleteLocked(op.RequestDeleteRange)
+				resps = append(resps, map[string]interface{}{})
+			case op.RequestRange != nil:
+				resps = append(resps, map[string]interface{}{"response_range": g.rangeLocked(op.RequestRange)})
+			}
+		}
+		res := map[string]interface{}{"header": g.header(), "responses": resps}
+		if ok {
+			res["succeeded"] = true
+		}
+		reply(res)
+	case "/lease/grant":
+		g.Lock()
+		defer g.Unlock()
+		id := int64(len(g.leases) + 1)
+		g.leases[id] = nil
+		reply(map[string]string{"ID": fmt.Sprint(id), "TTL": "20"})
+	case "/lease/revoke":
+		var req map[string]int64
+		dec.Decode(&req)
+		g.Lock()
+		defer g.Unlock()
+		for _, k := range g.leases[req["ID"]] {
+			if kv, ok := g.kvs[k]; ok && kv.lease == req["ID"] {
+				g.deleteLocked(&deleteRequest{Key: b64(k)})
+			}
+		}
+		delete(g.leases, req["ID"])
+		reply(map[string]interface{}{"header": g.header()})
+	case "/watch":
+		var req struct {
+			CreateRequest watchCreateRequest `json:"create_request"`
+		}
+		dec.Decode(&req)
+		wt := &fakeWatcher{key: decode(req.CreateRequest.Key), end: decode(req.CreateRequest.RangeEnd), ch: make(chan string, 16)}
+		g.Lock()
+		g.watchers = append(g.watchers, wt)
+		g.Unlock()
+		reply(map[string]interface{}{"result": map[string]interface{}{"created": true}})
+		w.(http.Flusher).Flush()
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case ev := <-wt.ch:
+				fmt.Fprintln(w, ev)
+				w.(http.Flusher).Flush()
+			}
+		}
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func newTestStore(t *testing.T) (store.Store, func()) {
+	srv := httptest.NewServer(newFakeGateway())
+	kv, err := New([]string{"127.0.0.1:1", strings.TrimPrefix(srv.URL, "http://")}, &store.Config{ConnectionTimeout: 2 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return kv, func() {
+		kv.Close()
+		srv.Close()
+	}
+}
+
+func TestPutGetDelete(t *testing.T) {
+	kv, cleanup := newTestStore(t)
+	defer cleanup()
+
+	if _, err := kv.Get("docker/network/v1.0/network/n1"); err != store.ErrKeyNotFound {
+		t.Fatalf("expected ErrKeyNotFound, got %v", err)
+	}
+	if err := kv.Put("docker/network/v1.0/network/n1", []byte("v1"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := kv.Put("docker/network/v1.0/network/n2", []byte("v2"), nil); err != nil {
+		t.Fatal(err)
+	}
+	pair, err := kv.Get("docker/network/v1.0/network/n1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(pair.Value) != "v1" || pair.LastIndex == 0 {
+		t.Fatalf("unexpected pair %+v", pair)
+	}
+	list, err := kv.List("docker/network/v1.0/network")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 {
+		t.Fatalf("expected 2 keys, got %d", len(list))
+	}
+	if err := kv.Delete("docker/network/v1.0/network/n1"); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := kv.Exists("docker/network/v1.0/network/n1"); err != nil || ok {
+		t.Fatalf("key still exists: %v", err)
+	}
+	if err := kv.DeleteTree("docker/network"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := kv.List("docker/network"); err != store.ErrKeyNotFound {
+		t.Fatalf("expected an empty tree, got %v", err)
+	}
+}
+
+func TestAtomic(t *testing.T) {
+	kv, cleanup := newTestStore(t)
+	defer cleanup()
+
+	ok, pair, err := kv.AtomicPut("k", []byte("a"), nil, nil)
+	if err != nil || !ok {
+		t.Fatalf("atomic create failed: %v", err)
+	}
+	if _, _, err := kv.AtomicPut("k", []byte("b"), nil, nil); err != store.ErrKeyExists {
+		t.Fatalf("expected ErrKeyExists, got %v", err)
+	}
+	ok, pair2, err := kv.AtomicPut("k", []byte("b"), pair, nil)
+	if err != nil || !ok {
+		t.Fatalf("atomic update failed: %v", err)
+	}
+	if _, _, err := kv.AtomicPut("k", []byte("c"), pair, nil); err != store.ErrKeyModified {
+		t.Fatalf("expected ErrKeyModified, got %v", err)
+	}
+	if _, err := kv.AtomicDelete("k", pair); err != store.ErrKeyModified {
+		t.Fatalf("expected ErrKeyModified, got %v", err)
+	}
+	if ok, err := kv.AtomicDelete("k", pair2); err != nil || !ok {
+		t.Fatalf("atomic delete failed: %v", err)
+	}
+	if _, err := kv.AtomicDelete("k", pair2); err != store.ErrKeyNotFound {
+		t.Fatalf("expected ErrKeyNotFound, got %v", err)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	kv, cleanup := newTestStore(t)
+	defer cleanup()
+
+	if err := kv.Put("k", []byte("1"), nil); err != nil {
+		t.Fatal(err)
+	}
+	stopCh := make(chan struct{})
+	ch, err := kv.Watch("k", stopCh)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The modification made before the current value is read comes after it
+	if err := kv.Put("k", []byte("2"), nil); err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{"1", "2"} {
+		select {
+		case pair := <-ch:
+			if string(pair.Value) != expected {
+				t.Fatalf("expected value %s, got %s", expected, pair.Value)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timeout waiting for value %s", expected)
+		}
+	}
+
+	close(stopCh)
+	select {
+	case _, ok := <-ch:
+		if ok {
+			t.Fatal("value sent after the watch stopped")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("watch channel not closed once stopped")
+	}
+}
+
+func TestWatchTree(t *testing.T) {
+	kv, cleanup := newTestStore(t)
+	defer cleanup()
+
+	if err := kv.Put("dir/a", []byte("1"), nil); err != nil {
+		t.Fatal(err)
+	}
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	ch, err := kv.WatchTree("dir", stopCh)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := func(n int) {
+		select {
+		case l := <-ch:
+			if len(l) != n {
+				t.Fatalf("expected %d keys, got %d", n, len(l))
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("timeout waiting for watch")
+		}
+	}
+	expect(1)
+	if err := kv.Put("dir/b", []byte("2"), nil); err != nil {
+		t.Fatal(err)
+	}
+	expect(2)
+}
+
+func TestLock(t *testing.T) {
+	kv, cleanup := newTestStore(t)
+	defer cleanup()
+
+	l1, _ := kv.NewLock("lock", nil)
+	if _, err := l1.Lock(nil); err != nil {
+		t.Fatal(err)
+	}
+	l2, _ := kv.NewLock("lock", nil)
+	acquired := make(chan error)
+	go func() {
+		_, err := l2.Lock(nil)
+		acquired <- err
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("lock acquired while held")
+	case <-time.After(200 * time.Millisecond):
+	}
+	if err := l1.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-acquired:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for the lock")
+	}
+	l2.Unlock()
+}
+
+func TestMigrate(t *testing.T) {
+	src, cleanup := newTestStore(t)
+	defer cleanup()
+	dst, cleanup2 := newTestStore(t)
+	defer cleanup2()
+
+	for _, k := range []string{"docker/network/v1.0/network/n1", "docker/network/v1.0/endpoint/n1/e1"} {
+		if err := src.Put(k, []byte(k), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	n, err := Migrate(src, dst, "docker/network", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Fatalf("expected 2 keys copied, got %d", n)
+	}
+	pair, err := dst.Get("docker/network/v1.0/endpoint/n1/e1")
+	if err != nil || string(pair.Value) != "docker/network/v1.0/endpoint/n1/e1" {
+		t.Fatalf("unexpected migrated value %v: %v", pair, err)
+	}
+}
@@ -0,0 +1,159 @@
+package etcdv3
+
+import (
+	"encoding/base64"
+	"sync"
+	"time"
+
+	"github.com/docker/libkv/store"
+	"github.com/sirupsen/logrus"
+)
+
+const defaultLockTTL = 20 * time.Second
+
+type etcdLock struct {
+	s        *EtcdV3
+	key      string
+	value    []byte
+	ttl      time.Duration
+	renewCh  chan struct{}
+	lease    int64
+	unlockCh chan struct{}
+	mu       sync.Mutex
+}
+
+type keepAliveResponse struct {
+	Result *struct {
+		ID  jsonInt `json:"ID"`
+		TTL jsonInt `json:"TTL"`
+	} `json:"result"`
+}
+
+// NewLock returns a handle to a lock struct which can be used to provide
+// mutual exclusion on a key. The lock is bound to a lease, which is kept
+// alive while the lock is held.
+func (s *EtcdV3) NewLock(key string, options *store.LockOptions) (store.Locker, error) {
+	l := &etcdLock{s: s, key: s.normalize(key), ttl: defaultLockTTL}
+	if options != nil {
+		l.value = options.Value
+		if options.TTL != 0 {
+			l.ttl = options.TTL
+		}
+		l.renewCh = options.RenewLock
+	}
+	return l, nil
+}
+
+// Lock attempts to acquire the lock and blocks while doing so. It returns a
+// channel that is closed if the lock is lost, the lock attempt is aborted
+// when stopChan is closed.
+func (l *etcdLock) Lock(stopChan chan struct{}) (<-chan struct{}, error) {
+	lease, err := l.s.grant(l.ttl)
+	if err != nil {
+		return nil, err
+	}
+	k := b64(l.key)
+	req := &txnRequest{
+		Compare: []compare{{Key: k, Target: "CREATE", Result: "EQUAL"}},
+		Success: []requestOp{{RequestPut: &putRequest{Key: k, Value: base64.StdEncoding.EncodeToString(l.value), Lease: lease}}},
+		Failure: []requestOp{{RequestRange: &rangeRequest{Key: k, KeysOnly: true}}},
+	}
+	for {
+		var resp txnResponse
+		if err := l.s.call("/kv/txn", req, &resp); err != nil {
+			l.s.revoke(lease)
+			return nil, err
+		}
+		if resp.Succeeded {
+			break
+		}
+		// Wait for the holder to release the lock
+		if err := l.waitDelete(k, int64(resp.Header.Revision)+1, stopChan); err != nil {
+			l.s.revoke(lease)
+			return nil, err
+		}
+	}
+
+	unlockCh := make(chan struct{})
+	l.mu.Lock()
+	l.lease = lease
+	l.unlockCh = unlockCh
+	l.mu.Unlock()
+
+	lostCh := make(chan struct{})
+	go l.keepAlive(lease, unlockCh, lostCh)
+	return lostCh, nil
+}
+
+// waitDelete blocks until the key is deleted or stopChan is closed
+func (l *etcdLock) waitDelete(k string, rev int64, stopChan chan struct{}) error {
+	deleted := make(chan bool, 1)
+	stopWatch := make(chan struct{})
+	defer close(stopWatch)
+	err := l.s.watch(k, "", rev, stopWatch, func(evs []watchEvent) {
+		if evs == nil {
+			select {
+			case deleted <- false:
+			default:
+			}
+			return
+		}
+		for _, ev := range evs {
+			if ev.Type == "DELETE" {
+				select {
+				case deleted <- true:
+				default:
+				}
+				return
+			}
+		}
+	})
+	if err != nil {
+		return err
+	}
+	select {
+	case <-deleted:
+		// Retry the acquisition in both cases, the key may be gone
+		return nil
+	case <-stopChan:
+		return store.ErrCannotLock
+	}
+}
+
+func (l *etcdLock) keepAlive(lease int64, unlockCh, lostCh chan struct{}) {
+	defer close(lostCh)
+	t := time.NewTicker(l.ttl / 3)
+	defer t.Stop()
+	for {
+		select {
+		case <-unlockCh:
+			return
+		case <-l.renewCh:
+			// Renewal stopped by the caller, the lease will expire
+			return
+		case <-t.C:
+			var resp keepAliveResponse
+			if err := l.s.call("/lease/keepalive", map[string]int64{"ID": lease}, &resp); err != nil {
+				logrus.Warnf("etcd lock %s: failed to renew lease: %v", l.key, err)
+				continue
+			}
+			if resp.Result == nil || resp.Result.TTL <= 0 {
+				logrus.Warnf("etcd lock %s: lease expired", l.key)
+				return
+			}
+		}
+	}
+}
+
+// Unlock the lock, deleting the key
+func (l *etcdLock) Unlock() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.unlockCh == nil {
+		return nil
+	}
+	close(l.unlockCh)
+	l.unlockCh = nil
+	// Revoking the lease deletes the key bound to it
+	return l.s.revoke(l.lease)
+}
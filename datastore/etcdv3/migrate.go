@@ -0,0 +1,58 @@
+package etcdv3
+
+import (
+	"strings"
+
+	"github.com/docker/libkv/store"
+)
+
+// Migrate copies the keys found under root in the src store, typically the
+// libkv etcd (v2 API) backend, into dst. Directories are walked recursively
+// since the v2 backend only lists the direct children of a node. Keys which
+// already exist in dst are overwritten only if overwrite is set. It returns
+// the number of keys copied.
+func Migrate(src, dst store.Store, root string, overwrite bool) (int, error) {
+	kvs, err := src.List(root)
+	if err != nil {
+		if err == store.ErrKeyNotFound {
+			return 0, nil
+		}
+		return 0, err
+	}
+	copied := 0
+	for _, kv := range kvs {
+		key := strings.TrimPrefix(kv.Key, "/")
+		if key == strings.Trim(root, "/") {
+			continue
+		}
+		children, err := src.List(key)
+		if err == nil && len(children) > 0 && !isSelf(children, key) {
+			n, err := Migrate(src, dst, key, overwrite)
+			copied += n
+			if err != nil {
+				return copied, err
+			}
+			continue
+		}
+		if !overwrite {
+			exists, err := dst.Exists(key)
+			if err != nil {
+				return copied, err
+			}
+			if exists {
+				continue
+			}
+		}
+		if err := dst.Put(key, kv.Value, nil); err != nil {
+			return copied, err
+		}
+		copied++
+	}
+	return copied, nil
+}
+
+// isSelf returns whether the listing of a key only returned the key
+// itself, which is how some backends list a leaf
+func isSelf(kvs []*store.KVPair, key string) bool {
+	return len(kvs) == 1 && strings.Trim(kvs[0].Key, "/") == key
+}
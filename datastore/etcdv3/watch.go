@@ -0,0 +1,208 @@
+package etcdv3
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/docker/libkv/store"
+	"github.com/sirupsen/logrus"
+)
+
+type watchCreateRequest struct {
+	Key           string `json:"key"`
+	RangeEnd      string `json:"range_end,omitempty"`
+	StartRevision int64  `json:"start_revision,omitempty"`
+}
+
+type watchEvent struct {
+	Type string    `json:"type"` // PUT is the default and omitted
+	Kv   *keyValue `json:"kv"`
+}
+
+type watchResponse struct {
+	Result *struct {
+		Header   header       `json:"header"`
+		Created  bool         `json:"created"`
+		Canceled bool         `json:"canceled"`
+		Events   []watchEvent `json:"events"`
+	} `json:"result"`
+	Error *rpcError `json:"error"`
+}
+
+// watch streams the events for the key range starting at the given
+// revision. Every batch of events is passed to fn; the stream ends when
+// stopCh is closed or the connection is lost.
+func (s *EtcdV3) watch(key, end string, rev int64, stopCh <-chan struct{}, fn func([]watchEvent)) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	req := map[string]interface{}{
+		"create_request": &watchCreateRequest{Key: key, RangeEnd: end, StartRevision: rev},
+	}
+	resp, err := s.request(ctx, s.stream, "/watch", req)
+	if err != nil {
+		cancel()
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		cancel()
+		return fmt.Errorf("etcd watch failed: %s", resp.Status)
+	}
+	go func() {
+		<-stopCh
+		cancel()
+	}()
+	go func() {
+		defer cancel()
+		defer resp.Body.Close()
+		dec := json.NewDecoder(resp.Body)
+		for {
+			var wr watchResponse
+			if err := dec.Decode(&wr); err != nil {
+				if ctx.Err() == nil {
+					logrus.Warnf("etcd watch on %s terminated: %v", key, err)
+				}
+				fn(nil)
+				return
+			}
+			if wr.Error != nil {
+				logrus.Warnf("etcd watch on %s failed: %s", key, wr.Error)
+				fn(nil)
+				return
+			}
+			if wr.Result == nil || wr.Result.Canceled {
+				fn(nil)
+				return
+			}
+			if len(wr.Result.Events) > 0 {
+				fn(wr.Result.Events)
+			}
+		}
+	}()
+	return nil
+}
+
+// Watch for changes on a "key". It returns a channel that sends the current
+// value first and a new value for every subsequent modification. The
+// channel is closed when stopCh is closed or the watch fails.
+func (s *EtcdV3) Watch(key string, stopCh <-chan struct{}) (<-chan *store.KVPair, error) {
+	k := s.normalize(key)
+	var resp rangeResponse
+	if err := s.call("/kv/range", &rangeRequest{Key: b64(k)}, &resp); err != nil {
+		return nil, err
+	}
+
+	// The events are handed to the goroutine sending the current value, so
+	// that the value is sent first and the channel closed by its only
+	// sender. The watch resumes right after the revision of the value.
+	events := make(chan []watchEvent)
+	done := make(chan struct{})
+	err := s.watch(b64(k), "", int64(resp.Header.Revision)+1, stopCh, func(evs []watchEvent) {
+		if evs == nil {
+			close(done)
+			return
+		}
+		select {
+		case events <- evs:
+		case <-stopCh:
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	watchCh := make(chan *store.KVPair)
+	go func() {
+		defer close(watchCh)
+		if len(resp.Kvs) > 0 {
+			select {
+			case watchCh <- resp.Kvs[0].pair():
+			case <-stopCh:
+				return
+			case <-done:
+				return
+			}
+		}
+		for {
+			select {
+			case evs := <-events:
+				for _, ev := range evs {
+					if ev.Type == "DELETE" || ev.Kv == nil {
+						continue
+					}
+					select {
+					case watchCh <- ev.Kv.pair():
+					case <-stopCh:
+						return
+					}
+				}
+			case <-stopCh:
+				return
+			case <-done:
+				return
+			}
+		}
+	}()
+	return watchCh, nil
+}
+
+// WatchTree watches for changes on a "directory". It returns a channel that
+// sends the list of child nodes first and again after every modification.
+func (s *EtcdV3) WatchTree(directory string, stopCh <-chan struct{}) (<-chan []*store.KVPair, error) {
+	k, end := prefixRange(directory)
+	var resp rangeResponse
+	if err := s.call("/kv/range", &rangeRequest{Key: b64(k), RangeEnd: b64(end)}, &resp); err != nil {
+		return nil, err
+	}
+
+	watchCh := make(chan []*store.KVPair)
+	notify := make(chan struct{}, 1)
+	done := make(chan struct{})
+	err := s.watch(b64(k), b64(end), int64(resp.Header.Revision)+1, stopCh, func(evs []watchEvent) {
+		if evs == nil {
+			close(done)
+			return
+		}
+		select {
+		case notify <- struct{}{}:
+		default:
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	go func() {
+		defer close(watchCh)
+		list := make([]*store.KVPair, 0, len(resp.Kvs))
+		for _, kv := range resp.Kvs {
+			list = append(list, kv.pair())
+		}
+		for {
+			select {
+			case watchCh <- list:
+			case <-stopCh:
+				return
+			case <-done:
+				return
+			}
+			select {
+			case <-notify:
+			case <-stopCh:
+				return
+			case <-done:
+				return
+			}
+			var err error
+			if list, err = s.List(directory); err != nil {
+				if err != store.ErrKeyNotFound {
+					logrus.Warnf("etcd watch on %s: failed to list: %v", directory, err)
+					return
+				}
+				list = []*store.KVPair{}
+			}
+		}
+	}()
+	return watchCh, nil
+}
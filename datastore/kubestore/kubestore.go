@@ -0,0 +1,518 @@
+// Package kubestore implements a libkv store backend persisting the keys as
+// Kubernetes custom resources, so that clusters already running Kubernetes do
+// not need a separate key-value store for the global scope state.
+//
+// Every key is stored as a namespaced KVPair object of the
+// libnetwork.docker.com/v1 API group (see docs/kubestore.md for the
+// CustomResourceDefinition). The object resource version is used as the
+// libkv index, which gives atomic operations the optimistic concurrency
+// semantics of the API server.
+package kubestore
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/libkv"
+	"github.com/docker/libkv/store"
+)
+
+const (
+	// KUBERNETES is the libkv backend name of this store
+	KUBERNETES store.Backend = "kubernetes"
+
+	// Group is the API group of the KVPair resource
+	Group = "libnetwork.docker.com"
+	// Version is the API version of the KVPair resource
+	Version = "v1"
+	// Kind is the kind of the KVPair resource
+	Kind = "KVPair"
+	// Plural is the resource name of the KVPair resource
+	Plural = "kvpairs"
+
+	// DefaultNamespace hosts the objects unless configured otherwise
+	DefaultNamespace = "libnetwork"
+
+	inClusterAddr   = "in-cluster"
+	tokenFile       = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+	rootCAFile      = "/var/run/secrets/kubernetes.io/serviceaccount/ca.crt"
+	defaultTimeout  = 10 * time.Second
+	objectPrefix    = "kv-"
+	objectHashChars = 40
+)
+
+// TLSClientConfig mirrors the TLS settings of the client-go rest.Config
+type TLSClientConfig struct {
+	Insecure bool
+	CAFile   string
+	CertFile string
+	KeyFile  string
+	CAData   []byte
+	CertData []byte
+	KeyData  []byte
+}
+
+// Config is the subset of the client-go rest.Config used to reach the
+// API server. A rest.Config can be converted field by field.
+type Config struct {
+	Host            string
+	BearerToken     string
+	BearerTokenFile string
+	Username        string
+	Password        string
+	TLSClientConfig TLSClientConfig
+	Timeout         time.Duration
+}
+
+// InClusterConfig returns the configuration of a client running in a pod,
+// authenticated with the pod service account
+func InClusterConfig() (*Config, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, fmt.Errorf("not running in a Kubernetes cluster")
+	}
+	return &Config{
+		Host:            "https://" + net.JoinHostPort(host, port),
+		BearerTokenFile: tokenFile,
+		TLSClientConfig: TLSClientConfig{CAFile: rootCAFile},
+	}, nil
+}
+
+// KubeStore is the receiver type for the Store interface
+type KubeStore struct {
+	base    string // collection URL
+	token   string
+	user    string
+	pass    string
+	client  *http.Client
+	stream  *http.Client
+	timeout time.Duration
+}
+
+// object is the KVPair custom resource
+type object struct {
+	APIVersion string     `json:"apiVersion"`
+	Kind       string     `json:"kind"`
+	Metadata   objectMeta `json:"metadata"`
+	Spec       objectSpec `json:"spec"`
+}
+
+type objectMeta struct {
+	Name            string `json:"name"`
+	ResourceVersion string `json:"resourceVersion,omitempty"`
+}
+
+type objectSpec struct {
+	Key   string `json:"key"`
+	Value []byte `json:"value,omitempty"`
+}
+
+type objectList struct {
+	Metadata struct {
+		ResourceVersion string `json:"resourceVersion"`
+	} `json:"metadata"`
+	Items []*object `json:"items"`
+}
+
+type status struct {
+	Reason  string `json:"reason"`
+	Message string `json:"message"`
+	Code    int    `json:"code"`
+}
+
+// Register registers the kubernetes store to libkv
+func Register() {
+	libkv.AddStore(KUBERNETES, New)
+}
+
+// New is the libkv initializer. The address is the API server URL, or
+// "in-cluster" to use the pod service account. The store bucket selects the
+// namespace, the password is used as bearer token when no user is set.
+func New(addrs []string, options *store.Config) (store.Store, error) {
+	var cfg *Config
+	if len(addrs) == 0 || addrs[0] == "" || addrs[0] == inClusterAddr {
+		var err error
+		if cfg, err = InClusterConfig(); err != nil {
+			return nil, err
+		}
+	} else {
+		cfg = &Config{Host: addrs[0]}
+	}
+	ns := DefaultNamespace
+	var tlsCfg *tls.Config
+	if options != nil {
+		if options.Bucket != "" {
+			ns = options.Bucket
+		}
+		if options.Username != "" {
+			cfg.Username, cfg.Password = options.Username, options.Password
+		} else if options.Password != "" {
+			cfg.BearerToken = options.Password
+		}
+		if options.ClientTLS != nil {
+			cfg.TLSClientConfig.CAFile = options.ClientTLS.CACertFile
+			cfg.TLSClientConfig.CertFile = options.ClientTLS.CertFile
+			cfg.TLSClientConfig.KeyFile = options.ClientTLS.KeyFile
+		}
+		tlsCfg = options.TLS
+		cfg.Timeout = options.ConnectionTimeout
+	}
+	return newStore(cfg, ns, tlsCfg)
+}
+
+// NewStore returns a store keeping its objects in the given namespace
+func NewStore(cfg *Config, namespace string) (*KubeStore, error) {
+	return newStore(cfg, namespace, nil)
+}
+
+func newStore(cfg *Config, namespace string, tlsCfg *tls.Config) (*KubeStore, error) {
+	host := cfg.Host
+	if !strings.Contains(host, "://") {
+		host = "https://" + host
+	}
+	u, err := url.Parse(host)
+	if err != nil {
+		return nil, fmt.Errorf("invalid API server address %q: %v", cfg.Host, err)
+	}
+	if tlsCfg == nil && u.Scheme == "https" {
+		if tlsCfg, err = buildTLS(&cfg.TLSClientConfig); err != nil {
+			return nil, err
+		}
+	}
+	token := cfg.BearerToken
+	if token == "" && cfg.BearerTokenFile != "" {
+		b, err := ioutil.ReadFile(cfg.BearerTokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the bearer token: %v", err)
+		}
+		token = strings.TrimSpace(string(b))
+	}
+	timeout := cfg.Timeout
+	if timeout == 0 {
+		timeout = defaultTimeout
+	}
+	tr := &http.Transport{TLSClientConfig: tlsCfg}
+	return &KubeStore{
+		base:    fmt.Sprintf("%s/apis/%s/%s/namespaces/%s/%s", strings.TrimSuffix(u.String(), "/"), Group, Version, namespace, Plural),
+		token:   token,
+		user:    cfg.Username,
+		pass:    cfg.Password,
+		client:  &http.Client{Transport: tr, Timeout: timeout},
+		stream:  &http.Client{Transport: tr},
+		timeout: timeout,
+	}, nil
+}
+
+func buildTLS(c *TLSClientConfig) (*tls.Config, error) {
+	cfg := &tls.Config{InsecureSkipVerify: c.Insecure}
+	ca := c.CAData
+	if len(ca) == 0 && c.CAFile != "" {
+		var err error
+		if ca, err = ioutil.ReadFile(c.CAFile); err != nil {
+			return nil, fmt.Errorf("failed to read the API server CA: %v", err)
+		}
+	}
+	if len(ca) > 0 {
+		cfg.RootCAs = x509.NewCertPool()
+		if !cfg.RootCAs.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("no valid certificate in the API server CA")
+		}
+	}
+	cert, key := c.CertData, c.KeyData
+	if len(cert) == 0 && c.CertFile != "" {
+		var err error
+		if cert, err = ioutil.ReadFile(c.CertFile); err != nil {
+			return nil, err
+		}
+		if key, err = ioutil.ReadFile(c.KeyFile); err != nil {
+			return nil, err
+		}
+	}
+	if len(cert) > 0 {
+		pair, err := tls.X509KeyPair(cert, key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load the client certificate: %v", err)
+		}
+		cfg.Certificates = []tls.Certificate{pair}
+	}
+	return cfg, nil
+}
+
+// objectName maps a key to a valid object name
+func objectName(key string) string {
+	h := sha256.Sum256([]byte(key))
+	return objectPrefix + hex.EncodeToString(h[:])[:objectHashChars]
+}
+
+func normalize(key string) string {
+	return strings.Trim(key, "/")
+}
+
+func parseIndex(rv string) uint64 {
+	v, _ := strconv.ParseUint(rv, 10, 64)
+	return v
+}
+
+func (o *object) pair() *store.KVPair {
+	return &store.KVPair{Key: o.Spec.Key, Value: o.Spec.Value, LastIndex: parseIndex(o.Metadata.ResourceVersion)}
+}
+
+func newObject(key string, value []byte, previous *store.KVPair) *object {
+	o := &object{
+		APIVersion: Group + "/" + Version,
+		Kind:       Kind,
+		Metadata:   objectMeta{Name: objectName(key)},
+		Spec:       objectSpec{Key: key, Value: value},
+	}
+	if previous != nil {
+		o.Metadata.ResourceVersion = strconv.FormatUint(previous.LastIndex, 10)
+	}
+	return o
+}
+
+// do sends a request to the API server and decodes the response into out.
+// It returns the HTTP status code along with any error.
+func (s *KubeStore) do(method, path string, in, out interface{}) (int, error) {
+	var body []byte
+	if in != nil {
+		var err error
+		if body, err = json.Marshal(in); err != nil {
+			return 0, err
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+	resp, err := s.send(ctx, s.client, method, path, body)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return resp.StatusCode, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		st := &status{}
+		if json.Unmarshal(b, st) != nil || st.Message == "" {
+			st.Message = strings.TrimSpace(string(b))
+		}
+		return resp.StatusCode, fmt.Errorf("%s %s: %s", method, path, st.Message)
+	}
+	if out != nil {
+		return resp.StatusCode, json.Unmarshal(b, out)
+	}
+	return resp.StatusCode, nil
+}
+
+func (s *KubeStore) send(ctx context.Context, client *http.Client, method, path string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequest(method, s.base+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if s.token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.token)
+	} else if s.user != "" {
+		req.SetBasicAuth(s.user, s.pass)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		if ctx.Err() == nil {
+			return nil, fmt.Errorf("%v: %v", store.ErrNotReachable, err)
+		}
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (s *KubeStore) get(key string) (*object, error) {
+	o := &object{}
+	code, err := s.do("GET", "/"+objectName(key), nil, o)
+	if code == http.StatusNotFound {
+		return nil, store.ErrKeyNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+// Get the value at "key"
+func (s *KubeStore) Get(key string) (*store.KVPair, error) {
+	o, err := s.get(normalize(key))
+	if err != nil {
+		return nil, err
+	}
+	return o.pair(), nil
+}
+
+// Put a value at "key", creating or replacing the object
+func (s *KubeStore) Put(key string, value []byte, options *store.WriteOptions) error {
+	if options != nil && options.IsDir {
+		return nil
+	}
+	key = normalize(key)
+	for {
+		var previous *store.KVPair
+		o, err := s.get(key)
+		switch err {
+		case nil:
+			previous = o.pair()
+		case store.ErrKeyNotFound:
+		default:
+			return err
+		}
+		_, _, err = s.AtomicPut(key, value, previous, options)
+		if err != store.ErrKeyExists && err != store.ErrKeyModified && err != store.ErrKeyNotFound {
+			return err
+		}
+	}
+}
+
+// Delete the value at "key"
+func (s *KubeStore) Delete(key string) error {
+	code, err := s.do("DELETE", "/"+objectName(normalize(key)), nil, nil)
+	if code == http.StatusNotFound {
+		return store.ErrKeyNotFound
+	}
+	return err
+}
+
+// Exists checks if the key exists inside the store
+func (s *KubeStore) Exists(key string) (bool, error) {
+	_, err := s.get(normalize(key))
+	if err == store.ErrKeyNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// list returns the objects under the directory, sorted by key, along with
+// the resource version of the collection
+func (s *KubeStore) list(directory string) ([]*store.KVPair, string, error) {
+	var l objectList
+	if _, err := s.do("GET", "", nil, &l); err != nil {
+		return nil, "", err
+	}
+	prefix := normalize(directory) + "/"
+	kvs := []*store.KVPair{}
+	for _, o := range l.Items {
+		if strings.HasPrefix(o.Spec.Key, prefix) {
+			kvs = append(kvs, o.pair())
+		}
+	}
+	sort.Slice(kvs, func(i, j int) bool { return kvs[i].Key < kvs[j].Key })
+	return kvs, l.Metadata.ResourceVersion, nil
+}
+
+// List the content of a given directory, recursively. Keys are not indexed
+// by the API server, the filtering happens on the client side.
+func (s *KubeStore) List(directory string) ([]*store.KVPair, error) {
+	kvs, _, err := s.list(directory)
+	if err != nil {
+		return nil, err
+	}
+	if len(kvs) == 0 {
+		return nil, store.ErrKeyNotFound
+	}
+	return kvs, nil
+}
+
+// DeleteTree deletes a range of keys under a given directory
+func (s *KubeStore) DeleteTree(directory string) error {
+	kvs, _, err := s.list(directory)
+	if err != nil {
+		return err
+	}
+	for _, kv := range kvs {
+		if err := s.Delete(kv.Key); err != nil && err != store.ErrKeyNotFound {
+			return err
+		}
+	}
+	return nil
+}
+
+// AtomicPut creates the object if previous is nil, or replaces it if its
+// resource version still matches the previous index
+func (s *KubeStore) AtomicPut(key string, value []byte, previous *store.KVPair, options *store.WriteOptions) (bool, *store.KVPair, error) {
+	key = normalize(key)
+	var (
+		o    = newObject(key, value, previous)
+		out  = &object{}
+		code int
+		err  error
+	)
+	if previous == nil {
+		code, err = s.do("POST", "", o, out)
+		if code == http.StatusConflict {
+			return false, nil, store.ErrKeyExists
+		}
+	} else {
+		code, err = s.do("PUT", "/"+o.Metadata.Name, o, out)
+		switch code {
+		case http.StatusConflict:
+			return false, nil, store.ErrKeyModified
+		case http.StatusNotFound:
+			return false, nil, store.ErrKeyNotFound
+		}
+	}
+	if err != nil {
+		return false, nil, err
+	}
+	return true, out.pair(), nil
+}
+
+// AtomicDelete deletes the object if its resource version still matches
+// the previous index
+func (s *KubeStore) AtomicDelete(key string, previous *store.KVPair) (bool, error) {
+	if previous == nil {
+		return false, store.ErrPreviousNotSpecified
+	}
+	opts := map[string]interface{}{
+		"apiVersion":    "v1",
+		"kind":          "DeleteOptions",
+		"preconditions": map[string]string{"resourceVersion": strconv.FormatUint(previous.LastIndex, 10)},
+	}
+	code, err := s.do("DELETE", "/"+objectName(normalize(key)), opts, nil)
+	switch code {
+	case http.StatusConflict:
+		return false, store.ErrKeyModified
+	case http.StatusNotFound:
+		return false, store.ErrKeyNotFound
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// NewLock is not supported by this backend
+func (s *KubeStore) NewLock(key string, options *store.LockOptions) (store.Locker, error) {
+	return nil, store.ErrCallNotSupported
+}
+
+// Close the store connection
+func (s *KubeStore) Close() {
+	if tr, ok := s.client.Transport.(*http.Transport); ok {
+		tr.CloseIdleConnections()
+	}
+}
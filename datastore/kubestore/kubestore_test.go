@@ -0,0 +1,214 @@
+package kubestore
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/docker/libkv/store"
+)
+
+// fakeAPIServer serves the KVPair collection of a single namespace
+type fakeAPIServer struct {
+	sync.Mutex
+	rv       int
+	objects  map[string]*object
+	watchers []chan *watchEvent
+}
+
+func (a *fakeAPIServer) fail(w http.ResponseWriter, code int) {
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(&status{Code: code, Message: http.StatusText(code)})
+}
+
+func (a *fakeAPIServer) emit(typ string, o *object) {
+	cp := *o
+	for _, ch := range a.watchers {
+		ch <- &watchEvent{Type: typ, Object: &cp}
+	}
+}
+
+func (a *fakeAPIServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	base := fmt.Sprintf("/apis/%s/%s/namespaces/test/%s", Group, Version, Plural)
+	if !strings.HasPrefix(r.URL.Path, base) {
+		a.fail(w, http.StatusNotFound)
+		return
+	}
+	name := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, base), "/")
+
+	if r.URL.Query().Get("watch") == "true" {
+		ch := make(chan *watchEvent, 16)
+		a.Lock()
+		a.watchers = append(a.watchers, ch)
+		a.Unlock()
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case ev := <-ch:
+				json.NewEncoder(w).Encode(ev)
+				w.(http.Flusher).Flush()
+			}
+		}
+	}
+
+	a.Lock()
+	defer a.Unlock()
+	var in struct {
+		object
+		Preconditions struct {
+			ResourceVersion string `json:"resourceVersion"`
+		} `json:"preconditions"`
+	}
+	json.NewDecoder(r.Body).Decode(&in)
+	switch {
+	case r.Method == "GET" && name == "":
+		l := objectList{}
+		l.Metadata.ResourceVersion = fmt.Sprint(a.rv)
+		for _, o := range a.objects {
+			l.Items = append(l.Items, o)
+		}
+		json.NewEncoder(w).Encode(&l)
+	case r.Method == "GET":
+		o, ok := a.objects[name]
+		if !ok {
+			a.fail(w, http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(o)
+	case r.Method == "POST":
+		if _, ok := a.objects[in.Metadata.Name]; ok {
+			a.fail(w, http.StatusConflict)
+			return
+		}
+		a.rv++
+		in.Metadata.ResourceVersion = fmt.Sprint(a.rv)
+		a.objects[in.Metadata.Name] = &in.object
+		a.emit("ADDED", &in.object)
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(&in.object)
+	case r.Method == "PUT":
+		o, ok := a.objects[name]
+		if !ok {
+			a.fail(w, http.StatusNotFound)
+			return
+		}
+		if o.Metadata.ResourceVersion != in.Metadata.ResourceVersion {
+			a.fail(w, http.StatusConflict)
+			return
+		}
+		a.rv++
+		in.Metadata.ResourceVersion = fmt.Sprint(a.rv)
+		a.objects[name] = &in.object
+		a.emit("MODIFIED", &in.object)
+		json.NewEncoder(w).Encode(&in.object)
+	case r.Method == "DELETE":
+		o, ok := a.objects[name]
+		if !ok {
+			a.fail(w, http.StatusNotFound)
+			return
+		}
+		if rv := in.Preconditions.ResourceVersion; rv != "" && rv != o.Metadata.ResourceVersion {
+			a.fail(w, http.StatusConflict)
+			return
+		}
+		a.rv++
+		delete(a.objects, name)
+		a.emit("DELETED", o)
+		json.NewEncoder(w).Encode(&status{Code: http.StatusOK})
+	}
+}
+
+func newTestStore(t *testing.T) (*KubeStore, func()) {
+	srv := httptest.NewServer(&fakeAPIServer{objects: map[string]*object{}})
+	s, err := NewStore(&Config{Host: srv.URL, BearerToken: "token"}, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, func() {
+		s.Close()
+		srv.Close()
+	}
+}
+
+func TestKubeStore(t *testing.T) {
+	s, cleanup := newTestStore(t)
+	defer cleanup()
+
+	if _, err := s.Get("docker/network/v1.0/network/n1"); err != store.ErrKeyNotFound {
+		t.Fatalf("expected ErrKeyNotFound, got %v", err)
+	}
+	ok, pair, err := s.AtomicPut("docker/network/v1.0/network/n1", []byte("a"), nil, nil)
+	if err != nil || !ok {
+		t.Fatalf("atomic create failed: %v", err)
+	}
+	if _, _, err := s.AtomicPut("docker/network/v1.0/network/n1", []byte("b"), nil, nil); err != store.ErrKeyExists {
+		t.Fatalf("expected ErrKeyExists, got %v", err)
+	}
+	if err := s.Put("docker/network/v1.0/network/n1", []byte("b"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := s.AtomicPut("docker/network/v1.0/network/n1", []byte("c"), pair, nil); err != store.ErrKeyModified {
+		t.Fatalf("expected ErrKeyModified, got %v", err)
+	}
+	got, err := s.Get("/docker/network/v1.0/network/n1")
+	if err != nil || string(got.Value) != "b" {
+		t.Fatalf("unexpected value %v: %v", got, err)
+	}
+	if err := s.Put("docker/network/v1.0/endpoint/n1/e1", []byte("e"), nil); err != nil {
+		t.Fatal(err)
+	}
+	l, err := s.List("docker/network/v1.0")
+	if err != nil || len(l) != 2 {
+		t.Fatalf("unexpected list %v: %v", l, err)
+	}
+	if _, err := s.AtomicDelete("docker/network/v1.0/network/n1", pair); err != store.ErrKeyModified {
+		t.Fatalf("expected ErrKeyModified, got %v", err)
+	}
+	if ok, err := s.AtomicDelete("docker/network/v1.0/network/n1", got); err != nil || !ok {
+		t.Fatalf("atomic delete failed: %v", err)
+	}
+	if err := s.DeleteTree("docker/network"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.List("docker/network"); err != store.ErrKeyNotFound {
+		t.Fatalf("expected an empty tree, got %v", err)
+	}
+}
+
+func TestKubeStoreWatch(t *testing.T) {
+	s, cleanup := newTestStore(t)
+	defer cleanup()
+
+	if err := s.Put("epcnt/n1", []byte("1"), nil); err != nil {
+		t.Fatal(err)
+	}
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	ch, err := s.Watch("epcnt/n1", stopCh)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := func(v string) {
+		select {
+		case kv := <-ch:
+			if string(kv.Value) != v {
+				t.Fatalf("expected %s, got %s", v, kv.Value)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("timeout waiting for watch")
+		}
+	}
+	expect("1")
+	if err := s.Put("epcnt/n1", []byte("2"), nil); err != nil {
+		t.Fatal(err)
+	}
+	expect("2")
+}
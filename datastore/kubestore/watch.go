@@ -0,0 +1,174 @@
+package kubestore
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/docker/libkv/store"
+	"github.com/sirupsen/logrus"
+)
+
+type watchEvent struct {
+	Type   string  `json:"type"`
+	Object *object `json:"object"`
+}
+
+// watch streams the changes of the collection from the resource version,
+// optionally restricted by a field selector. fn is invoked for every event
+// and with a nil event when the stream ends.
+func (s *KubeStore) watch(rv, fieldSelector string, stopCh <-chan struct{}, fn func(*watchEvent)) error {
+	q := url.Values{}
+	q.Set("watch", "true")
+	if rv != "" {
+		q.Set("resourceVersion", rv)
+	}
+	if fieldSelector != "" {
+		q.Set("fieldSelector", fieldSelector)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	resp, err := s.send(ctx, s.stream, "GET", "?"+q.Encode(), nil)
+	if err != nil {
+		cancel()
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		cancel()
+		return fmt.Errorf("watch failed: %s", resp.Status)
+	}
+	go func() {
+		<-stopCh
+		cancel()
+	}()
+	go func() {
+		defer cancel()
+		defer resp.Body.Close()
+		dec := json.NewDecoder(resp.Body)
+		for {
+			var ev watchEvent
+			if err := dec.Decode(&ev); err != nil {
+				if ctx.Err() == nil {
+					logrus.Warnf("kubernetes store watch terminated: %v", err)
+				}
+				fn(nil)
+				return
+			}
+			if ev.Type == "ERROR" {
+				logrus.Warnf("kubernetes store watch failed, the resource version may be too old")
+				fn(nil)
+				return
+			}
+			fn(&ev)
+		}
+	}()
+	return nil
+}
+
+// Watch for changes on a "key". The current value is sent first, then the
+// new value after every modification.
+func (s *KubeStore) Watch(key string, stopCh <-chan struct{}) (<-chan *store.KVPair, error) {
+	key = normalize(key)
+	o, err := s.get(key)
+	if err != nil && err != store.ErrKeyNotFound {
+		return nil, err
+	}
+	rv := ""
+	if o != nil {
+		rv = o.Metadata.ResourceVersion
+	}
+
+	watchCh := make(chan *store.KVPair)
+	done := make(chan struct{})
+	err = s.watch(rv, "metadata.name="+objectName(key), stopCh, func(ev *watchEvent) {
+		if ev == nil {
+			close(done)
+			return
+		}
+		if ev.Type != "ADDED" && ev.Type != "MODIFIED" || ev.Object == nil {
+			return
+		}
+		select {
+		case watchCh <- ev.Object.pair():
+		case <-stopCh:
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		defer close(watchCh)
+		if o != nil {
+			select {
+			case watchCh <- o.pair():
+			case <-stopCh:
+				return
+			case <-done:
+				return
+			}
+		}
+		select {
+		case <-stopCh:
+		case <-done:
+		}
+	}()
+	return watchCh, nil
+}
+
+// WatchTree watches for changes under a "directory". The content of the
+// directory is sent first, then again after every modification.
+func (s *KubeStore) WatchTree(directory string, stopCh <-chan struct{}) (<-chan []*store.KVPair, error) {
+	kvs, rv, err := s.list(directory)
+	if err != nil {
+		return nil, err
+	}
+	prefix := normalize(directory) + "/"
+
+	watchCh := make(chan []*store.KVPair)
+	notify := make(chan struct{}, 1)
+	done := make(chan struct{})
+	err = s.watch(rv, "", stopCh, func(ev *watchEvent) {
+		if ev == nil {
+			close(done)
+			return
+		}
+		if ev.Object == nil || !strings.HasPrefix(ev.Object.Spec.Key, prefix) {
+			return
+		}
+		select {
+		case notify <- struct{}{}:
+		default:
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		defer close(watchCh)
+		for {
+			select {
+			case watchCh <- kvs:
+			case <-stopCh:
+				return
+			case <-done:
+				return
+			}
+			select {
+			case <-notify:
+			case <-stopCh:
+				return
+			case <-done:
+				return
+			}
+			var err error
+			if kvs, _, err = s.list(directory); err != nil {
+				logrus.Warnf("kubernetes store watch on %s: failed to list: %v", directory, err)
+				return
+			}
+		}
+	}()
+	return watchCh, nil
+}
@@ -0,0 +1,247 @@
+package datastore
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/docker/libkv/store"
+	"github.com/sirupsen/logrus"
+)
+
+// SchemaKeyPrefix is the prefix of the schema versions of the components
+// keeping their state in the store
+const SchemaKeyPrefix = "schema"
+
+// Migration rewrites the state a component keeps in the store under a
+// prefix, from the previous schema version of the component to Version.
+// The daemons sharing a global store may migrate it concurrently, the
+// values already migrated are then passed to Migrate again.
+type Migration struct {
+	// Component is the owner of the state, as "libnetwork", "bridge" or
+	// "ipam"
+	Component   string
+	Version     int
+	Description string
+	// Prefix is the key chain of the values migrated, none for a version
+	// with no state to rewrite
+	Prefix []string
+	// Migrate returns the value of the key at the new version, nil for
+	// the key to be deleted
+	Migrate func(key string, value []byte) ([]byte, error)
+}
+
+// DowngradeError is returned for a store written by a daemon knowing of a
+// schema version of the component newer than this one does
+type DowngradeError struct {
+	Component string
+	Stored    int
+	Supported int
+}
+
+func (e *DowngradeError) Error() string {
+	return fmt.Sprintf("the %s state of the store is at schema version %d, newer than version %d of this daemon: refusing to downgrade",
+		e.Component, e.Stored, e.Supported)
+}
+
+// Forbidden denotes the type of this error
+func (e *DowngradeError) Forbidden() {}
+
+var migrations = struct {
+	sync.Mutex
+	m map[string][]Migration
+}{m: map[string][]Migration{}}
+
+// RegisterMigration adds the migration of the component to its next
+// version. The versions of a component start at 1 and follow each other.
+func RegisterMigration(m Migration) error {
+	migrations.Lock()
+	defer migrations.Unlock()
+	l := migrations.m[m.Component]
+	if m.Component == "" || m.Version != len(l)+1 {
+		return fmt.Errorf("invalid migration %q of %s to version %d, expected version %d", m.Description, m.Component, m.Version, len(l)+1)
+	}
+	migrations.m[m.Component] = append(l, m)
+	return nil
+}
+
+// SchemaVersion returns the latest schema version of the component
+func SchemaVersion(component string) int {
+	migrations.Lock()
+	defer migrations.Unlock()
+	return len(migrations.m[component])
+}
+
+type schemaRecord struct {
+	Version int       `json:"version"`
+	Updated time.Time `json:"updated"`
+}
+
+// Migrate brings the state of the components in the store to their
+// latest schema version, the migrations of each version being applied in
+// turn. It refuses a store which has a component at a version newer than
+// the daemon knows of, including the components the daemon does not know
+// of at all, before migrating anything.
+func Migrate(ds DataStore) error {
+	kv := ds.KVStore()
+	migrations.Lock()
+	pending := make(map[string][]Migration, len(migrations.m))
+	for c, l := range migrations.m {
+		pending[c] = l
+	}
+	migrations.Unlock()
+
+	stored, err := schemaVersions(kv)
+	if err != nil {
+		return err
+	}
+	for c, v := range stored {
+		if v.Version > len(pending[c]) {
+			return &DowngradeError{Component: c, Stored: v.Version, Supported: len(pending[c])}
+		}
+	}
+
+	components := make([]string, 0, len(pending))
+	for c := range pending {
+		components = append(components, c)
+	}
+	sort.Strings(components)
+	for _, c := range components {
+		from := 0
+		if v, ok := stored[c]; ok {
+			from = v.Version
+		}
+		for _, m := range pending[c][from:] {
+			start := time.Now()
+			n, err := applyMigration(kv, m)
+			if err != nil {
+				return fmt.Errorf("failed to migrate the %s state of the %s store to version %d (%s): %v", c, ds.Scope(), m.Version, m.Description, err)
+			}
+			if err := setSchemaVersion(kv, c, m.Version); err != nil {
+				return err
+			}
+			logrus.Infof("Migrated the %s state of the %s store to version %d (%s): %d keys rewritten in %v", c, ds.Scope(), m.Version, m.Description, n, time.Since(start))
+		}
+	}
+	return nil
+}
+
+// SchemaVersions returns the schema versions of the components in the
+// store
+func SchemaVersions(ds DataStore) (map[string]int, error) {
+	stored, err := schemaVersions(ds.KVStore())
+	if err != nil {
+		return nil, err
+	}
+	versions := make(map[string]int, len(stored))
+	for c, v := range stored {
+		versions[c] = v.Version
+	}
+	return versions, nil
+}
+
+func schemaVersions(kv store.Store) (map[string]schemaRecord, error) {
+	pairs, err := kv.List(Key(SchemaKeyPrefix))
+	if err != nil && err != store.ErrKeyNotFound {
+		return nil, fmt.Errorf("failed to read the schema versions: %v", err)
+	}
+	versions := make(map[string]schemaRecord, len(pairs))
+	for _, p := range pairs {
+		chain, err := ParseKey(p.Key)
+		if err != nil || len(chain) != 2 {
+			continue
+		}
+		var r schemaRecord
+		if err := json.Unmarshal(p.Value, &r); err != nil {
+			return nil, fmt.Errorf("invalid schema version of %s: %v", chain[1], err)
+		}
+		versions[chain[1]] = r
+	}
+	return versions, nil
+}
+
+// setSchemaVersion records the version of the component, unless another
+// daemon recorded it or a later one meanwhile
+func setSchemaVersion(kv store.Store, component string, version int) error {
+	key := Key(SchemaKeyPrefix, component)
+	value, _ := json.Marshal(&schemaRecord{Version: version, Updated: time.Now().UTC()})
+	for {
+		prev, err := kv.Get(key)
+		if err != nil && err != store.ErrKeyNotFound {
+			return err
+		}
+		if err == store.ErrKeyNotFound {
+			prev = nil
+		} else if prev != nil {
+			var r schemaRecord
+			if json.Unmarshal(prev.Value, &r) == nil && r.Version >= version {
+				return nil
+			}
+		}
+		if _, _, err := kv.AtomicPut(key, value, prev, nil); err != store.ErrKeyModified && err != store.ErrKeyExists {
+			return err
+		}
+	}
+}
+
+// applyMigration rewrites the values under the prefix of the migration
+// and returns the number of keys rewritten or deleted
+func applyMigration(kv store.Store, m Migration) (int, error) {
+	if m.Prefix == nil || m.Migrate == nil {
+		return 0, nil
+	}
+	pairs, err := kv.List(Key(m.Prefix...))
+	if err != nil {
+		if err == store.ErrKeyNotFound {
+			return 0, nil
+		}
+		return 0, err
+	}
+	var count int
+	for _, p := range pairs {
+		changed, err := migrateKey(kv, m, p)
+		if err != nil {
+			return count, fmt.Errorf("%s: %v", p.Key, err)
+		}
+		if changed {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// migrateKey rewrites the value of the key, reading it again when it got
+// modified meanwhile
+func migrateKey(kv store.Store, m Migration, p *store.KVPair) (bool, error) {
+	for {
+		if len(p.Value) == 0 {
+			return false, nil
+		}
+		value, err := m.Migrate(p.Key, p.Value)
+		if err != nil {
+			return false, err
+		}
+		if value == nil {
+			_, err = kv.AtomicDelete(p.Key, p)
+		} else if bytes.Equal(value, p.Value) {
+			return false, nil
+		} else {
+			_, _, err = kv.AtomicPut(p.Key, value, p, nil)
+		}
+		if err == nil {
+			return true, nil
+		}
+		if err != store.ErrKeyModified {
+			return false, err
+		}
+		if p, err = kv.Get(p.Key); err != nil {
+			if err == store.ErrKeyNotFound {
+				return false, nil
+			}
+			return false, err
+		}
+	}
+}
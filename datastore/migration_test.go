@@ -0,0 +1,89 @@
+package datastore
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/docker/libkv/store"
+	"github.com/docker/libkv/store/boltdb"
+)
+
+func newBoltStore(t *testing.T, dir string) DataStore {
+	boltdb.Register()
+	ds, err := NewDataStore(LocalScope, &ScopeCfg{Client: ScopeClientCfg{
+		Provider: string(store.BOLTDB),
+		Address:  filepath.Join(dir, "local-kv.db"),
+		Config:   &store.Config{Bucket: "libnetwork"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ds
+}
+
+func TestMigrate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "migration")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ds := newBoltStore(t, dir)
+	defer ds.Close()
+
+	kv := ds.KVStore()
+	kv.Put(Key("migration-test", "a"), []byte("v0"), nil)
+	kv.Put(Key("migration-test", "b"), []byte("stale"), nil)
+
+	if err := RegisterMigration(Migration{Component: "migration-test", Version: 2}); err == nil {
+		t.Fatal("expected an error for a version out of order")
+	}
+	for _, m := range []Migration{
+		{Component: "migration-test", Version: 1, Description: "initial schema"},
+		{Component: "migration-test", Version: 2, Description: "rename", Prefix: []string{"migration-test"},
+			Migrate: func(key string, value []byte) ([]byte, error) {
+				if bytes.Equal(value, []byte("stale")) {
+					return nil, nil
+				}
+				return []byte("v2"), nil
+			}},
+	} {
+		if err := RegisterMigration(m); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := Migrate(ds); err != nil {
+		t.Fatal(err)
+	}
+	if p, err := kv.Get(Key("migration-test", "a")); err != nil || string(p.Value) != "v2" {
+		t.Fatalf("key not migrated: %v", err)
+	}
+	if _, err := kv.Get(Key("migration-test", "b")); err != store.ErrKeyNotFound {
+		t.Fatalf("key not deleted: %v", err)
+	}
+	versions, err := SchemaVersions(ds)
+	if err != nil || versions["migration-test"] != 2 {
+		t.Fatalf("unexpected versions %v: %v", versions, err)
+	}
+
+	// A migrated store is left alone
+	kv.Put(Key("migration-test", "c"), []byte("v0"), nil)
+	if err := Migrate(ds); err != nil {
+		t.Fatal(err)
+	}
+	if p, _ := kv.Get(Key("migration-test", "c")); string(p.Value) != "v0" {
+		t.Fatal("key migrated twice")
+	}
+
+	// The state of a newer daemon is refused
+	if err := setSchemaVersion(kv, "migration-test", 3); err != nil {
+		t.Fatal(err)
+	}
+	err = Migrate(ds)
+	if _, ok := err.(*DowngradeError); !ok {
+		t.Fatalf("expected a downgrade error, got %v", err)
+	}
+}
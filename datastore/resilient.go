@@ -0,0 +1,286 @@
+package datastore
+
+import (
+	"errors"
+	"log"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/libkv/store"
+)
+
+// ErrStoreUnavailable is returned, without calling the store, while the
+// circuit breaker of a resilient store is open
+var ErrStoreUnavailable = errors.New("store unavailable, too many consecutive failures")
+
+// Resilience configures the retries of the failed calls to a store, the
+// circuit breaker cutting the calls off after consecutive failures, and
+// the reads served from the last values read while the store fails.
+// The zero values get the defaults.
+type Resilience struct {
+	// Retries is the number of retries of a failed call
+	Retries int
+	// Backoff is the delay before the first retry, doubled on each one and
+	// jittered by up to a half
+	Backoff time.Duration
+	// MaxBackoff is the delay between the retries at most
+	MaxBackoff time.Duration
+	// BreakerThreshold is the number of consecutive failed calls opening
+	// the circuit breaker
+	BreakerThreshold int
+	// BreakerCooldown is the time the breaker stays open before a call is
+	// let through to probe the store
+	BreakerCooldown time.Duration
+	// StaleReads serves the reads the store fails from the values last
+	// read
+	StaleReads bool
+}
+
+const (
+	defaultStoreRetries     = 3
+	defaultStoreBackoff     = 100 * time.Millisecond
+	defaultStoreMaxBackoff  = 2 * time.Second
+	defaultBreakerThreshold = 5
+	defaultBreakerCooldown  = 10 * time.Second
+)
+
+// resilientStore retries the calls failing for reasons other than the
+// state of the keys, the atomic ones included: a retried atomic call whose
+// first attempt went through fails on the modified key, as for a conflict.
+type resilientStore struct {
+	store.Store
+	cfg Resilience
+
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+	probing   bool
+	values    map[string]*store.KVPair
+	lists     map[string][]*store.KVPair
+}
+
+func newResilientStore(s store.Store, r Resilience) store.Store {
+	if r.Retries <= 0 {
+		r.Retries = defaultStoreRetries
+	}
+	if r.Backoff <= 0 {
+		r.Backoff = defaultStoreBackoff
+	}
+	if r.MaxBackoff <= 0 {
+		r.MaxBackoff = defaultStoreMaxBackoff
+	}
+	if r.BreakerThreshold <= 0 {
+		r.BreakerThreshold = defaultBreakerThreshold
+	}
+	if r.BreakerCooldown <= 0 {
+		r.BreakerCooldown = defaultBreakerCooldown
+	}
+	return &resilientStore{
+		Store:  s,
+		cfg:    r,
+		values: make(map[string]*store.KVPair),
+		lists:  make(map[string][]*store.KVPair),
+	}
+}
+
+// transient tells the errors worth a retry from the ones reporting the
+// state of the keys or the options of the call
+func transient(err error) bool {
+	switch err {
+	case nil, store.ErrKeyNotFound, store.ErrKeyModified, store.ErrKeyExists,
+		store.ErrPreviousNotSpecified, store.ErrCallNotSupported, ErrStoreUnavailable:
+		return false
+	}
+	return true
+}
+
+// allow reports whether a call can go through the breaker, letting a
+// single probing call through once the cooldown is over
+func (s *resilientStore) allow() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.failures < s.cfg.BreakerThreshold {
+		return true
+	}
+	if s.probing || time.Now().Before(s.openUntil) {
+		return false
+	}
+	s.probing = true
+	return true
+}
+
+// record accounts for the outcome of a call attempt
+func (s *resilientStore) record(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.probing = false
+	if !transient(err) {
+		if s.failures >= s.cfg.BreakerThreshold {
+			log.Printf("Store reachable again, closing the circuit breaker")
+		}
+		s.failures = 0
+		return
+	}
+	s.failures++
+	if s.failures >= s.cfg.BreakerThreshold {
+		if s.failures == s.cfg.BreakerThreshold {
+			log.Printf("Store failed %d consecutive calls, opening the circuit breaker for %s: %v", s.failures, s.cfg.BreakerCooldown, err)
+		}
+		s.openUntil = time.Now().Add(s.cfg.BreakerCooldown)
+	}
+}
+
+// do calls fn until it succeeds, fails for a non transient reason or runs
+// out of retries, backing off between the attempts
+func (s *resilientStore) do(fn func() error) error {
+	backoff := s.cfg.Backoff
+	for attempt := 0; ; attempt++ {
+		if !s.allow() {
+			return ErrStoreUnavailable
+		}
+		err := fn()
+		s.record(err)
+		if !transient(err) || attempt == s.cfg.Retries {
+			return err
+		}
+
+		time.Sleep(backoff + time.Duration(rand.Int63n(int64(backoff)/2+1)))
+		if backoff *= 2; backoff > s.cfg.MaxBackoff {
+			backoff = s.cfg.MaxBackoff
+		}
+	}
+}
+
+// forget drops the values last read which a write to the key, or to the
+// keys under it, makes stale
+func (s *resilientStore) forget(key string) {
+	if !s.cfg.StaleReads {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for k := range s.values {
+		if strings.HasPrefix(k, key) {
+			delete(s.values, k)
+		}
+	}
+	for dir := range s.lists {
+		if strings.HasPrefix(key, dir) || strings.HasPrefix(dir, key) {
+			delete(s.lists, dir)
+		}
+	}
+}
+
+// Get returns the value at "key", the last one read when the store fails
+func (s *resilientStore) Get(key string) (*store.KVPair, error) {
+	var kv *store.KVPair
+	err := s.do(func() (err error) {
+		kv, err = s.Store.Get(key)
+		return err
+	})
+	if !s.cfg.StaleReads {
+		return kv, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case err == nil:
+		s.values[key] = kv
+	case err == store.ErrKeyNotFound:
+		delete(s.values, key)
+	case s.values[key] != nil:
+		log.Printf("Serving the last value read of %s: %v", key, err)
+		return s.values[key], nil
+	}
+	return kv, err
+}
+
+// List returns the values under "directory", the last ones read when the
+// store fails
+func (s *resilientStore) List(directory string) ([]*store.KVPair, error) {
+	var kvs []*store.KVPair
+	err := s.do(func() (err error) {
+		kvs, err = s.Store.List(directory)
+		return err
+	})
+	if !s.cfg.StaleReads {
+		return kvs, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case err == nil:
+		s.lists[directory] = kvs
+	case err == store.ErrKeyNotFound:
+		delete(s.lists, directory)
+	default:
+		if stale, ok := s.lists[directory]; ok {
+			log.Printf("Serving the last values read under %s: %v", directory, err)
+			return stale, nil
+		}
+	}
+	return kvs, err
+}
+
+// Exists checks whether "key" is present
+func (s *resilientStore) Exists(key string) (bool, error) {
+	var ok bool
+	err := s.do(func() (err error) {
+		ok, err = s.Store.Exists(key)
+		return err
+	})
+	return ok, err
+}
+
+// Put stores the value at "key"
+func (s *resilientStore) Put(key string, value []byte, options *store.WriteOptions) error {
+	defer s.forget(key)
+	return s.do(func() error {
+		return s.Store.Put(key, value, options)
+	})
+}
+
+// Delete removes "key"
+func (s *resilientStore) Delete(key string) error {
+	defer s.forget(key)
+	return s.do(func() error {
+		return s.Store.Delete(key)
+	})
+}
+
+// DeleteTree removes the keys under "directory"
+func (s *resilientStore) DeleteTree(directory string) error {
+	defer s.forget(directory)
+	return s.do(func() error {
+		return s.Store.DeleteTree(directory)
+	})
+}
+
+// AtomicPut stores the value at "key" if it was not modified
+func (s *resilientStore) AtomicPut(key string, value []byte, previous *store.KVPair, options *store.WriteOptions) (bool, *store.KVPair, error) {
+	defer s.forget(key)
+	var (
+		ok bool
+		kv *store.KVPair
+	)
+	err := s.do(func() (err error) {
+		ok, kv, err = s.Store.AtomicPut(key, value, previous, options)
+		return err
+	})
+	return ok, kv, err
+}
+
+// AtomicDelete removes "key" if it was not modified
+func (s *resilientStore) AtomicDelete(key string, previous *store.KVPair) (bool, error) {
+	defer s.forget(key)
+	var ok bool
+	err := s.do(func() (err error) {
+		ok, err = s.Store.AtomicDelete(key, previous)
+		return err
+	})
+	return ok, err
+}
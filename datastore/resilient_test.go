@@ -0,0 +1,81 @@
+package datastore
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/docker/libkv/store"
+)
+
+var errFlaky = errors.New("connection refused")
+
+// flakyStore fails the calls while down
+type flakyStore struct {
+	store.Store
+	down  bool
+	calls int
+}
+
+func (s *flakyStore) Get(key string) (*store.KVPair, error) {
+	s.calls++
+	if s.down {
+		return nil, errFlaky
+	}
+	kv, err := s.Store.Get(key)
+	if kv == nil && err == nil {
+		return nil, store.ErrKeyNotFound
+	}
+	return kv, err
+}
+
+func (s *flakyStore) Put(key string, value []byte, options *store.WriteOptions) error {
+	s.calls++
+	if s.down {
+		return errFlaky
+	}
+	return s.Store.Put(key, value, options)
+}
+
+func TestResilientStore(t *testing.T) {
+	fs := &flakyStore{Store: NewMockStore()}
+	s := newResilientStore(fs, Resilience{Retries: 2, Backoff: time.Millisecond, BreakerThreshold: 4, BreakerCooldown: 20 * time.Millisecond, StaleReads: true})
+
+	if err := s.Put("k", []byte("v1"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Get("k"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Get("missing"); err != store.ErrKeyNotFound {
+		t.Fatalf("expected a key not found error, got %v", err)
+	}
+
+	fs.down, fs.calls = true, 0
+	kv, err := s.Get("k")
+	if err != nil || string(kv.Value) != "v1" {
+		t.Fatalf("expected the last value read, got %v %v", kv, err)
+	}
+	if fs.calls != 3 {
+		t.Fatalf("expected 3 attempts, got %d", fs.calls)
+	}
+
+	if err := s.Put("k", []byte("v2"), nil); err != ErrStoreUnavailable {
+		t.Fatalf("expected the breaker to open, got %v", err)
+	}
+	if fs.calls != 4 {
+		t.Fatalf("expected the breaker to cut the calls off after 4 failures, got %d calls", fs.calls)
+	}
+	if _, err := s.Get("k"); err != ErrStoreUnavailable {
+		t.Fatalf("expected no stale value once written, got %v", err)
+	}
+
+	fs.down = false
+	time.Sleep(30 * time.Millisecond)
+	if err := s.Put("k", []byte("v2"), nil); err != nil {
+		t.Fatalf("expected the breaker to let the probe through, got %v", err)
+	}
+	if kv, err := s.Get("k"); err != nil || kv.LastIndex != 2 {
+		t.Fatalf("unexpected value %v %v", kv, err)
+	}
+}
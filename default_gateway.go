@@ -1,7 +1,9 @@
 package libnetwork
 
 import (
+	"context"
 	"fmt"
+	"sort"
 	"strings"
 
 	"github.com/docker/libnetwork/netlabel"
@@ -29,7 +31,7 @@ var procGwNetwork = make(chan (bool), 1)
    - its deleted when an endpoint with GW joins the container
 */
 
-func (sb *sandbox) setupDefaultGW() error {
+func (sb *sandbox) setupDefaultGW(ctx context.Context) error {
 
 	// check if the container already has a GW endpoint
 	if ep := sb.getEndpointInGWNetwork(); ep != nil {
@@ -87,7 +89,7 @@ func (sb *sandbox) setupDefaultGW() error {
 
 	epLocal := newEp.(*endpoint)
 
-	if err = epLocal.sbJoin(sb); err != nil {
+	if err = epLocal.sbJoin(ctx, sb); err != nil {
 		return fmt.Errorf("container %s: endpoint join on GW Network failed: %v", sb.containerID, err)
 	}
 
@@ -101,7 +103,7 @@ func (sb *sandbox) clearDefaultGW() error {
 	if ep = sb.getEndpointInGWNetwork(); ep == nil {
 		return nil
 	}
-	if err := ep.sbLeave(sb, false); err != nil {
+	if err := ep.sbLeave(nil, sb, false); err != nil {
 		return fmt.Errorf("container %s: endpoint leaving GW Network failed: %v", sb.containerID, err)
 	}
 	if err := ep.Delete(false); err != nil {
@@ -199,3 +201,88 @@ func (sb *sandbox) getGatewayEndpoint() *endpoint {
 	}
 	return nil
 }
+
+// GatewayEndpoint returns the endpoint providing the default gateway of the
+// sandbox, or nil if there is none
+func (sb *sandbox) GatewayEndpoint() Endpoint {
+	if ep := sb.getGatewayEndpoint(); ep != nil {
+		return ep
+	}
+	return nil
+}
+
+// SetEndpointPriority changes the priority of an endpoint connected to the
+// sandbox. When this changes the endpoint providing the default gateway,
+// the default routes and the external connectivity are moved to the new
+// gateway endpoint without disconnecting any endpoint.
+func (sb *sandbox) SetEndpointPriority(epi Endpoint, prio int) error {
+	if epi == nil {
+		return types.BadRequestErrorf("invalid endpoint")
+	}
+
+	sb.joinLeaveStart()
+	defer sb.joinLeaveEnd()
+
+	ep := sb.getEndpoint(epi.ID())
+	if ep == nil {
+		return types.NotFoundErrorf("endpoint %s is not connected to sandbox %s", epi.ID(), sb.ID())
+	}
+
+	oldGwEp := sb.getGatewayEndpoint()
+
+	sb.Lock()
+	sb.epPriority[ep.ID()] = prio
+	sort.SliceStable(sb.endpoints, func(i, j int) bool {
+		return sb.endpoints[i].Less(sb.endpoints[j])
+	})
+	sb.Unlock()
+
+	if newGwEp := sb.getGatewayEndpoint(); newGwEp != oldGwEp {
+		logrus.Debugf("Moving the default gateway of sandbox %.7s to endpoint %s", sb.ID(), epi.Name())
+		if err := sb.updateGateway(newGwEp); err != nil {
+			return err
+		}
+		if err := moveExternalConnectivity(sb, oldGwEp, newGwEp); err != nil {
+			return err
+		}
+	}
+
+	return sb.storeUpdate()
+}
+
+func moveExternalConnectivity(sb *sandbox, from, to *endpoint) error {
+	if from != nil {
+		n, err := from.getNetworkFromStore()
+		if err != nil {
+			return fmt.Errorf("failed to get network from store for revoking external connectivity: %v", err)
+		}
+		d, err := n.driver(true)
+		if err != nil {
+			return fmt.Errorf("failed to get driver for revoking external connectivity: %v", err)
+		}
+		if err := d.RevokeExternalConnectivity(n.ID(), from.ID()); err != nil {
+			return types.InternalErrorf("driver failed revoking external connectivity on endpoint %s (%s): %v",
+				from.Name(), from.ID(), err)
+		}
+	}
+
+	if to == nil {
+		return nil
+	}
+	n, err := to.getNetworkFromStore()
+	if err != nil {
+		return fmt.Errorf("failed to get network from store for programming external connectivity: %v", err)
+	}
+	if n.Internal() {
+		return nil
+	}
+	d, err := n.driver(true)
+	if err != nil {
+		return fmt.Errorf("failed to get driver for programming external connectivity: %v", err)
+	}
+	if err := d.ProgramExternalConnectivity(n.ID(), to.ID(), sb.Labels()); err != nil {
+		return types.InternalErrorf("driver failed programming external connectivity on endpoint %s (%s): %v",
+			to.Name(), to.ID(), err)
+	}
+	return nil
+}
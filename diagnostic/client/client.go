@@ -0,0 +1,467 @@
+// Package client is the Go client of the diagnostic server of libnetwork.
+// It wraps the commands exposing the networks, endpoints and sandboxes of
+// the controller, the tables of the networkdb, the filter policies and the
+// packet captures with typed requests and replies, and carries the bearer
+// token the server may require.
+package client
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/libnetwork/diagnostic"
+	"github.com/docker/libnetwork/driverapi"
+)
+
+// ErrUnauthorized is returned when the server rejects the token of the client
+var ErrUnauthorized = errors.New("unauthorized by the diagnostic server")
+
+// Error is a command the server failed, or refused for its wrong parameters,
+// Usage being set then
+type Error struct {
+	Path    string
+	Message string
+	Err     string
+	Usage   string
+}
+
+func (e *Error) Error() string {
+	if e.Usage != "" {
+		return fmt.Sprintf("%s: %s, usage: %s", e.Path, e.Message, e.Usage)
+	}
+	return fmt.Sprintf("%s: %s", e.Path, e.Err)
+}
+
+// Client sends the commands to a diagnostic server
+type Client struct {
+	base  string
+	token string
+	http  *http.Client
+}
+
+// Option is a setting of the client
+type Option func(c *Client)
+
+// OptionToken sets the bearer token of the requests
+func OptionToken(token string) Option {
+	return func(c *Client) {
+		c.token = token
+	}
+}
+
+// OptionHTTPClient sets the HTTP client the requests are sent with, the
+// default one being used otherwise
+func OptionHTTPClient(hc *http.Client) Option {
+	return func(c *Client) {
+		c.http = hc
+	}
+}
+
+// New returns the client of the diagnostic server at the address, a
+// host:port pair or a base URL
+func New(addr string, opts ...Option) *Client {
+	if !strings.Contains(addr, "://") {
+		addr = "http://" + addr
+	}
+	c := &Client{base: strings.TrimSuffix(addr, "/"), http: http.DefaultClient}
+	for _, o := range opts {
+		o(c)
+	}
+	return c
+}
+
+func (c *Client) request(ctx context.Context, path string, form url.Values) (*http.Response, error) {
+	req, err := http.NewRequest("GET", c.base+path+"?"+form.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	rsp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if rsp.StatusCode == http.StatusUnauthorized {
+		rsp.Body.Close()
+		return nil, ErrUnauthorized
+	}
+	if rsp.StatusCode != http.StatusOK {
+		b, _ := ioutil.ReadAll(io.LimitReader(rsp.Body, 512))
+		rsp.Body.Close()
+		return nil, fmt.Errorf("%s: unexpected status %s: %s", path, rsp.Status, strings.TrimSpace(string(b)))
+	}
+	return rsp, nil
+}
+
+// Do sends the command of the path with the form values, asking for a JSON
+// reply, and decodes the details of a successful reply in out, unless nil
+func (c *Client) Do(ctx context.Context, path string, form url.Values, out interface{}) error {
+	if form == nil {
+		form = url.Values{}
+	}
+	form.Set("json", "")
+	rsp, err := c.request(ctx, path, form)
+	if err != nil {
+		return err
+	}
+	defer rsp.Body.Close()
+	return decodeReply(path, rsp.Body, out)
+}
+
+func decodeReply(path string, r io.Reader, out interface{}) error {
+	var reply struct {
+		Message string          `json:"message"`
+		Details json.RawMessage `json:"details"`
+	}
+	if err := json.NewDecoder(r).Decode(&reply); err != nil {
+		return fmt.Errorf("%s: invalid reply: %v", path, err)
+	}
+	switch reply.Message {
+	case "OK":
+	case "FAIL":
+		var e diagnostic.ErrorCmd
+		json.Unmarshal(reply.Details, &e)
+		return &Error{Path: path, Message: reply.Message, Err: e.Error}
+	default:
+		var u diagnostic.UsageCmd
+		json.Unmarshal(reply.Details, &u)
+		return &Error{Path: path, Message: reply.Message, Usage: u.Usage}
+	}
+	if out == nil || len(reply.Details) == 0 || string(reply.Details) == "null" {
+		return nil
+	}
+	if err := json.Unmarshal(reply.Details, out); err != nil {
+		return fmt.Errorf("%s: invalid reply details: %v", path, err)
+	}
+	return nil
+}
+
+// Ready checks the server is up
+func (c *Client) Ready(ctx context.Context) error {
+	return c.Do(ctx, "/ready", nil, nil)
+}
+
+// Network is a network of the controller
+type Network struct {
+	ID            string            `json:"id"`
+	Name          string            `json:"name"`
+	Type          string            `json:"type"`
+	Scope         string            `json:"scope"`
+	Internal      bool              `json:"internal,omitempty"`
+	IPv6          bool              `json:"ipv6,omitempty"`
+	DriverOptions map[string]string `json:"driver_options,omitempty"`
+	Endpoints     int               `json:"endpoints"`
+}
+
+// Endpoint is an endpoint of the controller, with the operational data of
+// its driver when requested
+type Endpoint struct {
+	ID          string                 `json:"id"`
+	Name        string                 `json:"name"`
+	NetworkID   string                 `json:"network_id"`
+	NetworkName string                 `json:"network_name"`
+	SandboxID   string                 `json:"sandbox_id,omitempty"`
+	Address     string                 `json:"address,omitempty"`
+	AddressIPv6 string                 `json:"address_ipv6,omitempty"`
+	MacAddress  string                 `json:"mac_address,omitempty"`
+	Locator     string                 `json:"locator,omitempty"`
+	DriverInfo  map[string]interface{} `json:"driver_info,omitempty"`
+	DriverError string                 `json:"driver_error,omitempty"`
+}
+
+// Sandbox is a sandbox of the controller with the IDs of its endpoints
+type Sandbox struct {
+	ID          string   `json:"id"`
+	ContainerID string   `json:"container_id"`
+	Key         string   `json:"key"`
+	Endpoints   []string `json:"endpoints"`
+}
+
+// Networks returns the networks of the controller, or the one the name or
+// ID identifies if not empty
+func (c *Client) Networks(ctx context.Context, network string) ([]Network, error) {
+	var l []Network
+	return l, c.Do(ctx, "/networks", networkForm(network), &l)
+}
+
+// Endpoints returns the endpoints of the networks, or of the one the name
+// or ID identifies if not empty, with the data of their driver if oper
+func (c *Client) Endpoints(ctx context.Context, network string, oper bool) ([]Endpoint, error) {
+	form := networkForm(network)
+	if oper {
+		form.Set("oper", "")
+	}
+	var l []Endpoint
+	return l, c.Do(ctx, "/endpoints", form, &l)
+}
+
+// Sandboxes returns the sandboxes of the controller
+func (c *Client) Sandboxes(ctx context.Context) ([]Sandbox, error) {
+	var l []Sandbox
+	return l, c.Do(ctx, "/sandboxes", nil, &l)
+}
+
+func networkForm(network string) url.Values {
+	form := url.Values{}
+	if network != "" {
+		form.Set("nid", network)
+	}
+	return form
+}
+
+// Peer is a node of the cluster or of a network
+type Peer struct {
+	Name string
+	IP   string
+}
+
+// TableEntry is an entry of a networkdb table
+type TableEntry struct {
+	Key   string
+	Value []byte
+	Owner string
+}
+
+// ClusterPeers returns the nodes of the cluster
+func (c *Client) ClusterPeers(ctx context.Context) ([]Peer, error) {
+	return c.peers(ctx, "/clusterpeers", nil)
+}
+
+// NetworkPeers returns the nodes which joined the network
+func (c *Client) NetworkPeers(ctx context.Context, nid string) ([]Peer, error) {
+	return c.peers(ctx, "/networkpeers", url.Values{"nid": {nid}})
+}
+
+func (c *Client) peers(ctx context.Context, path string, form url.Values) ([]Peer, error) {
+	var r diagnostic.TablePeersResult
+	if err := c.Do(ctx, path, form, &r); err != nil {
+		return nil, err
+	}
+	l := make([]Peer, 0, len(r.Elements))
+	for _, p := range r.Elements {
+		l = append(l, Peer{Name: p.Name, IP: p.IP})
+	}
+	return l, nil
+}
+
+// JoinNetwork joins the node to the network in the networkdb
+func (c *Client) JoinNetwork(ctx context.Context, nid string) error {
+	return c.Do(ctx, "/joinnetwork", url.Values{"nid": {nid}}, nil)
+}
+
+// LeaveNetwork makes the node leave the network in the networkdb
+func (c *Client) LeaveNetwork(ctx context.Context, nid string) error {
+	return c.Do(ctx, "/leavenetwork", url.Values{"nid": {nid}}, nil)
+}
+
+// Table returns the entries of the table of the network, their values
+// decoded
+func (c *Client) Table(ctx context.Context, nid, table string) ([]TableEntry, error) {
+	var r diagnostic.TableEndpointsResult
+	if err := c.Do(ctx, "/gettable", url.Values{"nid": {nid}, "tname": {table}}, &r); err != nil {
+		return nil, err
+	}
+	l := make([]TableEntry, 0, len(r.Elements))
+	for _, e := range r.Elements {
+		v, err := base64.StdEncoding.DecodeString(e.Value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value of the entry %s of table %s: %v", e.Key, table, err)
+		}
+		l = append(l, TableEntry{Key: e.Key, Value: v, Owner: e.Owner})
+	}
+	return l, nil
+}
+
+// DeleteEntry deletes the entry of the table of the network
+func (c *Client) DeleteEntry(ctx context.Context, nid, table, key string) error {
+	return c.Do(ctx, "/deleteentry", url.Values{"nid": {nid}, "tname": {table}, "key": {key}}, nil)
+}
+
+// FilterEndpointStatus is the filter policy version of an endpoint with the
+// packets it let through and the ones it rejected
+type FilterEndpointStatus struct {
+	ID       string
+	Version  string
+	Canary   bool
+	Accepted uint64
+	Rejected uint64
+}
+
+// FilterRolloutStatus is the state of the rollout of the filter policy of a
+// network
+type FilterRolloutStatus struct {
+	NetworkID string `json:"network_id"`
+	Current   string
+	Staged    string
+	Started   time.Time
+	Deadline  time.Time
+	Endpoints []FilterEndpointStatus
+}
+
+// FilterRollout is the new version of the filter policy of a network, staged
+// on the canaries first
+type FilterRollout struct {
+	Policy   *driverapi.FilterPolicy
+	Canaries []string
+	// Window is the time the canaries are observed for before the policy
+	// gets promoted or rolled back, 0 leaving the decision to the client
+	Window time.Duration
+	// MaxRejectRatio is the ratio of the packets to the canaries the policy
+	// may reject and still get promoted
+	MaxRejectRatio float64
+}
+
+// FilterStatus returns the state of the rollout of the filter policy of the
+// network
+func (c *Client) FilterStatus(ctx context.Context, nid string) (*FilterRolloutStatus, error) {
+	return c.filterPolicy(ctx, nid, "status", nil)
+}
+
+// StageFilterPolicy stages the new version of the filter policy of the
+// network on its canaries
+func (c *Client) StageFilterPolicy(ctx context.Context, nid string, r *FilterRollout) (*FilterRolloutStatus, error) {
+	if r == nil || r.Policy == nil {
+		return nil, fmt.Errorf("the filter rollout requires a policy")
+	}
+	b, err := json.Marshal(r.Policy)
+	if err != nil {
+		return nil, err
+	}
+	form := url.Values{"policy": {string(b)}, "canaries": {strings.Join(r.Canaries, ",")}}
+	if r.Window > 0 {
+		form.Set("window", r.Window.String())
+	}
+	if r.MaxRejectRatio > 0 {
+		form.Set("maxreject", strconv.FormatFloat(r.MaxRejectRatio, 'f', -1, 64))
+	}
+	return c.filterPolicy(ctx, nid, "stage", form)
+}
+
+// PromoteFilterPolicy applies the staged filter policy on all the endpoints
+// of the network
+func (c *Client) PromoteFilterPolicy(ctx context.Context, nid string) (*FilterRolloutStatus, error) {
+	return c.filterPolicy(ctx, nid, "promote", nil)
+}
+
+// RollbackFilterPolicy applies the current filter policy back on the
+// canaries of the staged one
+func (c *Client) RollbackFilterPolicy(ctx context.Context, nid string) (*FilterRolloutStatus, error) {
+	return c.filterPolicy(ctx, nid, "rollback", nil)
+}
+
+// RevertFilterPolicy applies back a prior version of the filter policy on
+// all the endpoints of the network
+func (c *Client) RevertFilterPolicy(ctx context.Context, nid, version string) (*FilterRolloutStatus, error) {
+	return c.filterPolicy(ctx, nid, "revert", url.Values{"version": {version}})
+}
+
+func (c *Client) filterPolicy(ctx context.Context, nid, op string, form url.Values) (*FilterRolloutStatus, error) {
+	if form == nil {
+		form = url.Values{}
+	}
+	form.Set("nid", nid)
+	form.Set("op", op)
+	s := &FilterRolloutStatus{}
+	if err := c.Do(ctx, "/filterpolicy", form, s); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// ShadowEndpointStatus is the state of the filter of an endpoint in shadow
+// mode
+type ShadowEndpointStatus struct {
+	ID          string `json:"id"`
+	Version     string `json:"version"`
+	Audit       bool   `json:"audit"`
+	Accepted    uint64 `json:"accepted"`
+	WouldReject uint64 `json:"would_reject"`
+}
+
+// ShadowMode returns the endpoints of the network in shadow mode
+func (c *Client) ShadowMode(ctx context.Context, nid string) ([]ShadowEndpointStatus, error) {
+	return c.shadowMode(ctx, url.Values{"nid": {nid}})
+}
+
+// SetShadowMode applies the filter policy of the endpoint for audit only,
+// or enforces it again, and returns the endpoints of the network in shadow
+// mode
+func (c *Client) SetShadowMode(ctx context.Context, nid, eid string, shadow bool) ([]ShadowEndpointStatus, error) {
+	return c.shadowMode(ctx, url.Values{"nid": {nid}, "eid": {eid}, "enable": {strconv.FormatBool(shadow)}})
+}
+
+func (c *Client) shadowMode(ctx context.Context, form url.Values) ([]ShadowEndpointStatus, error) {
+	var r struct {
+		Endpoints []ShadowEndpointStatus `json:"endpoints"`
+	}
+	if err := c.Do(ctx, "/shadowmode", form, &r); err != nil {
+		return nil, err
+	}
+	return r.Endpoints, nil
+}
+
+// Verdict simulates the flow to or from the endpoint of the network through
+// the rules of its driver
+func (c *Client) Verdict(ctx context.Context, nid, eid string, flow *driverapi.Flow) (*driverapi.Verdict, error) {
+	form := url.Values{"nid": {nid}, "eid": {eid}, "proto": {flow.Proto}, "src": {flow.Src.String()}, "dst": {flow.Dst.String()}}
+	if flow.SrcPort != 0 {
+		form.Set("sport", strconv.Itoa(flow.SrcPort))
+	}
+	if flow.DstPort != 0 {
+		form.Set("dport", strconv.Itoa(flow.DstPort))
+	}
+	v := &driverapi.Verdict{}
+	if err := c.Do(ctx, "/verdict", form, v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// CaptureOptions bound a packet capture, the server defaults applying to
+// the zero values
+type CaptureOptions struct {
+	// Filter is the BPF expression of the packets captured
+	Filter   string
+	Duration time.Duration
+	Bytes    int64
+}
+
+// Capture returns the stream, in the pcap format, of the packets of the
+// interface of the sandbox, to be closed by the caller
+func (c *Client) Capture(ctx context.Context, sid, iface string, opts CaptureOptions) (io.ReadCloser, error) {
+	form := url.Values{"sid": {sid}, "interface": {iface}, "json": {""}}
+	if opts.Filter != "" {
+		form.Set("filter", opts.Filter)
+	}
+	if opts.Duration > 0 {
+		form.Set("duration", opts.Duration.String())
+	}
+	if opts.Bytes > 0 {
+		form.Set("bytes", strconv.FormatInt(opts.Bytes, 10))
+	}
+	rsp, err := c.request(ctx, "/capture", form)
+	if err != nil {
+		return nil, err
+	}
+	// The server replies JSON when the capture does not start
+	if strings.HasPrefix(rsp.Header.Get("Content-Type"), "application/json") {
+		defer rsp.Body.Close()
+		if err := decodeReply("/capture", rsp.Body, nil); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("/capture: the capture did not start")
+	}
+	return rsp.Body, nil
+}
@@ -0,0 +1,113 @@
+package client
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/libnetwork/diagnostic"
+)
+
+func newTestServer(t *testing.T, token string) *httptest.Server {
+	s := diagnostic.New()
+	s.Init()
+	s.SetAuthToken(token)
+	s.RegisterHandler(nil, map[string]diagnostic.HTTPHandlerFunc{
+		"/gettable": func(ctx interface{}, w http.ResponseWriter, r *http.Request) {
+			r.ParseForm()
+			_, json := diagnostic.ParseHTTPFormOptions(r)
+			if r.Form.Get("nid") == "" {
+				diagnostic.HTTPReply(w, diagnostic.WrongCommand("missing parameter", "gettable?tname=table_name&nid=network_id"), json)
+				return
+			}
+			if r.Form.Get("tname") != "endpoint_table" {
+				diagnostic.HTTPReply(w, diagnostic.FailCommand(fmt.Errorf("no table %s", r.Form.Get("tname"))), json)
+				return
+			}
+			diagnostic.HTTPReply(w, diagnostic.CommandSucceed(&diagnostic.TableObj{
+				Length: 1,
+				Elements: []diagnostic.StringInterface{&diagnostic.TableEntryObj{
+					Key:   "k1",
+					Value: base64.StdEncoding.EncodeToString([]byte("v1")),
+					Owner: "node1",
+				}},
+			}), json)
+		},
+		"/capture": func(ctx interface{}, w http.ResponseWriter, r *http.Request) {
+			r.ParseForm()
+			if r.Form.Get("duration") != "2s" {
+				_, json := diagnostic.ParseHTTPFormOptions(r)
+				diagnostic.HTTPReply(w, diagnostic.FailCommand(fmt.Errorf("invalid capture duration")), json)
+				return
+			}
+			w.Header().Set("Content-Type", "application/vnd.tcpdump.pcap")
+			w.Write([]byte("pcap"))
+		},
+	})
+	return httptest.NewServer(s)
+}
+
+func TestClientAuth(t *testing.T) {
+	srv := newTestServer(t, "secret")
+	defer srv.Close()
+	ctx := context.Background()
+
+	if err := New(srv.URL).Ready(ctx); err != ErrUnauthorized {
+		t.Fatalf("expected the request without token to be unauthorized, got %v", err)
+	}
+	if err := New(srv.URL, OptionToken("wrong")).Ready(ctx); err != ErrUnauthorized {
+		t.Fatalf("expected the request with the wrong token to be unauthorized, got %v", err)
+	}
+	if err := New(strings.TrimPrefix(srv.URL, "http://"), OptionToken("secret")).Ready(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestClientTable(t *testing.T) {
+	srv := newTestServer(t, "")
+	defer srv.Close()
+	c := New(srv.URL)
+	ctx := context.Background()
+
+	l, err := c.Table(ctx, "n1", "endpoint_table")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(l) != 1 || l[0].Key != "k1" || string(l[0].Value) != "v1" || l[0].Owner != "node1" {
+		t.Fatalf("unexpected entries %+v", l)
+	}
+
+	_, err = c.Table(ctx, "n1", "overlay_peer_table")
+	if e, ok := err.(*Error); !ok || e.Err != "no table overlay_peer_table" {
+		t.Fatalf("expected the failure of the command, got %v", err)
+	}
+	_, err = c.Table(ctx, "", "endpoint_table")
+	if e, ok := err.(*Error); !ok || e.Usage == "" {
+		t.Fatalf("expected the usage of the command, got %v", err)
+	}
+}
+
+func TestClientCapture(t *testing.T) {
+	srv := newTestServer(t, "")
+	defer srv.Close()
+	c := New(srv.URL)
+	ctx := context.Background()
+
+	if _, err := c.Capture(ctx, "s1", "eth0", CaptureOptions{}); err == nil {
+		t.Fatal("expected the capture to fail")
+	}
+	r, err := c.Capture(ctx, "s1", "eth0", CaptureOptions{Duration: 2 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if b, _ := ioutil.ReadAll(r); string(b) != "pcap" {
+		t.Fatalf("unexpected capture %q", b)
+	}
+}
@@ -0,0 +1,99 @@
+package diagnostic
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"runtime"
+	"runtime/pprof"
+	"runtime/trace"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The profiling handlers are written against runtime/pprof rather than
+// taken from net/http/pprof, whose init registers them on
+// http.DefaultServeMux, out of the reach of the token check of the server.
+
+const pprofPrefix = "/debug/pprof/"
+
+// pprofIndex lists the profiles of the runtime, or writes the one named
+// after the prefix, in text when debug is set
+func pprofIndex(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, pprofPrefix)
+	if name == "" {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		for _, p := range pprof.Profiles() {
+			fmt.Fprintf(w, "%s %d\n", p.Name(), p.Count())
+		}
+		fmt.Fprintf(w, "profile\ntrace\n")
+		return
+	}
+
+	p := pprof.Lookup(name)
+	if p == nil {
+		http.Error(w, fmt.Sprintf("unknown profile %s", name), http.StatusNotFound)
+		return
+	}
+	debug, _ := strconv.Atoi(r.FormValue("debug"))
+	if name == "heap" && r.FormValue("gc") != "" {
+		runtime.GC()
+	}
+	if debug != 0 {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	} else {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+	}
+	p.WriteTo(w, debug)
+}
+
+// pprofCmdline writes the command line of the process, its arguments
+// separated by NUL bytes
+func pprofCmdline(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprint(w, strings.Join(os.Args, "\x00"))
+}
+
+// pprofProfile writes a CPU profile of the given seconds, 30 by default
+func pprofProfile(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", `attachment; filename="profile"`)
+	if err := pprof.StartCPUProfile(w); err != nil {
+		pprofError(w, fmt.Sprintf("could not enable CPU profiling: %v", err))
+		return
+	}
+	pprofSleep(r, 30*time.Second)
+	pprof.StopCPUProfile()
+}
+
+// pprofTrace writes an execution trace of the given seconds, 1 by default
+func pprofTrace(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", `attachment; filename="trace"`)
+	if err := trace.Start(w); err != nil {
+		pprofError(w, fmt.Sprintf("could not enable tracing: %v", err))
+		return
+	}
+	pprofSleep(r, time.Second)
+	trace.Stop()
+}
+
+// pprofSleep waits for the seconds the request asks for, or the default,
+// unless the client goes away
+func pprofSleep(r *http.Request, def time.Duration) {
+	d := def
+	if sec, err := strconv.ParseFloat(r.FormValue("seconds"), 64); err == nil && sec > 0 {
+		d = time.Duration(sec * float64(time.Second))
+	}
+	select {
+	case <-time.After(d):
+	case <-r.Context().Done():
+	}
+}
+
+func pprofError(w http.ResponseWriter, msg string) {
+	w.Header().Del("Content-Disposition")
+	http.Error(w, msg, http.StatusInternalServerError)
+}
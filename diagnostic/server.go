@@ -2,14 +2,17 @@ package diagnostic
 
 import (
 	"context"
+	"crypto/subtle"
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strings"
 	"sync"
 	"sync/atomic"
 
 	stackdump "github.com/docker/docker/pkg/signal"
 	"github.com/docker/libnetwork/internal/caller"
+	"github.com/docker/libnetwork/internal/loglevel"
 	"github.com/sirupsen/logrus"
 )
 
@@ -31,6 +34,7 @@ var diagPaths2Func = map[string]HTTPHandlerFunc{
 	"/help":      help,
 	"/ready":     ready,
 	"/stackdump": stackTrace,
+	"/loglevel":  logLevel,
 }
 
 // Server when the debug is enabled exposes a
@@ -41,6 +45,7 @@ type Server struct {
 	port              int
 	mux               *http.ServeMux
 	registeredHanders map[string]bool
+	authToken         string
 	sync.Mutex
 }
 
@@ -72,9 +77,48 @@ func (s *Server) RegisterHandler(ctx interface{}, hdlrs map[string]HTTPHandlerFu
 	}
 }
 
+// EnableProfiling registers the pprof handlers under /debug/pprof/
+func (s *Server) EnableProfiling() {
+	s.Lock()
+	defer s.Unlock()
+	for path, fun := range map[string]http.HandlerFunc{
+		pprofPrefix:             pprofIndex,
+		pprofPrefix + "cmdline": pprofCmdline,
+		pprofPrefix + "profile": pprofProfile,
+		pprofPrefix + "trace":   pprofTrace,
+	} {
+		if _, ok := s.registeredHanders[path]; ok {
+			continue
+		}
+		s.mux.HandleFunc(path, fun)
+		s.registeredHanders[path] = true
+	}
+}
+
+// SetAuthToken sets the token the requests have to carry as bearer token in
+// their Authorization header, the empty token disabling the check
+func (s *Server) SetAuthToken(token string) {
+	s.Lock()
+	defer s.Unlock()
+	s.authToken = token
+}
+
 // ServeHTTP this is the method called bu the ListenAndServe, and is needed to allow us to
 // use our custom mux
 func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.Lock()
+	token := s.authToken
+	s.Unlock()
+
+	if token != "" {
+		got := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			logrus.WithFields(logrus.Fields{"component": "diagnostic", "remoteIP": r.RemoteAddr, "url": r.URL.String()}).Warn("unauthorized request")
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+	}
 	s.mux.ServeHTTP(w, r)
 }
 
@@ -179,6 +223,32 @@ func stackTrace(ctx interface{}, w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+func logLevel(ctx interface{}, w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	DebugHTTPForm(r)
+	_, json := ParseHTTPFormOptions(r)
+
+	// audit logs
+	log := logrus.WithFields(logrus.Fields{"component": "diagnostic", "remoteIP": r.RemoteAddr, "method": caller.Name(0), "url": r.URL.String()})
+	log.Info("log level")
+
+	if subsystem := r.Form.Get("subsystem"); subsystem != "" {
+		if err := loglevel.Set(subsystem, r.Form.Get("level")); err != nil {
+			log.WithError(err).Error("failed to set the log level")
+			HTTPReply(w, FailCommand(err), json)
+			return
+		}
+	} else if _, ok := r.Form["level"]; ok {
+		rsp := WrongCommand("missing subsystem", fmt.Sprintf("%s?subsystem=<%s>&level=<level, empty for the default>", r.URL.Path, strings.Join(loglevel.Subsystems(), "|")))
+		HTTPReply(w, rsp, json)
+		return
+	}
+
+	levels, base := loglevel.Levels()
+	log.Info("log level done")
+	HTTPReply(w, CommandSucceed(&LogLevelResult{Default: base, Subsystems: levels}), json)
+}
+
 // DebugHTTPForm helper to print the form url parameters
 func DebugHTTPForm(r *http.Request) {
 	for k, v := range r.Form {
@@ -203,6 +273,15 @@ func ParseHTTPFormOptions(r *http.Request) (bool, *JSONOutput) {
 	return unsafe, &JSONOutput{enable: json, prettyPrint: pretty}
 }
 
+// ParseHTTPFormJSONOptions parses the printing options of the commands
+// replying JSON unless the "text" form value is set
+func ParseHTTPFormJSONOptions(r *http.Request) (bool, *JSONOutput) {
+	unsafe, j := ParseHTTPFormOptions(r)
+	_, text := r.Form["text"]
+	j.enable = !text
+	return unsafe, j
+}
+
 // HTTPReply helper function that takes care of sending the message out
 func HTTPReply(w http.ResponseWriter, r *HTTPResult, j *JSONOutput) (int, error) {
 	var response []byte
@@ -0,0 +1,118 @@
+package diagnostic
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+type echoResult struct {
+	Name string `json:"name"`
+}
+
+func (r *echoResult) String() string {
+	return "name:" + r.Name
+}
+
+func newEchoServer(token string) *Server {
+	s := New()
+	s.Init()
+	s.SetAuthToken(token)
+	s.RegisterHandler(nil, map[string]HTTPHandlerFunc{
+		"/echo": func(ctx interface{}, w http.ResponseWriter, r *http.Request) {
+			r.ParseForm()
+			_, json := ParseHTTPFormJSONOptions(r)
+			HTTPReply(w, CommandSucceed(&echoResult{Name: r.Form.Get("name")}), json)
+		},
+	})
+	return s
+}
+
+func TestServerAuthToken(t *testing.T) {
+	s := newEchoServer("secret")
+
+	for _, auth := range []string{"", "Bearer wrong", "secret-but-longer"} {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/echo?name=a", nil)
+		if auth != "" {
+			r.Header.Set("Authorization", auth)
+		}
+		s.ServeHTTP(w, r)
+		if w.Code != http.StatusUnauthorized || w.Header().Get("WWW-Authenticate") != "Bearer" {
+			t.Fatalf("request with authorization %q got %d", auth, w.Code)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/echo?name=a", nil)
+	r.Header.Set("Authorization", "Bearer secret")
+	s.ServeHTTP(w, r)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"name":"a"`) {
+		t.Fatalf("authorized request got %d: %s", w.Code, w.Body.String())
+	}
+
+	// The empty token lifts the check
+	s.SetAuthToken("")
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", "/echo?name=b", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("request without a token got %d", w.Code)
+	}
+}
+
+func TestServerProfiling(t *testing.T) {
+	s := newEchoServer("secret")
+	s.EnableProfiling()
+
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", "/debug/pprof/goroutine?debug=1", nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("profile without a token got %d", w.Code)
+	}
+
+	for path, expected := range map[string]string{
+		"/debug/pprof/":                   "goroutine",
+		"/debug/pprof/goroutine?debug=1":  "goroutine profile:",
+		"/debug/pprof/cmdline":            os.Args[0],
+		"/debug/pprof/profile?seconds=.1": "",
+	} {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", path, nil)
+		r.Header.Set("Authorization", "Bearer secret")
+		s.ServeHTTP(w, r)
+		if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), expected) {
+			t.Fatalf("%s got %d: %s", path, w.Code, w.Body.String())
+		}
+	}
+
+	w = httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/debug/pprof/nosuchprofile", nil)
+	r.Header.Set("Authorization", "Bearer secret")
+	s.ServeHTTP(w, r)
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("unknown profile got %d", w.Code)
+	}
+
+	// Nothing gets exposed out of the server
+	if _, pattern := http.DefaultServeMux.Handler(httptest.NewRequest("GET", "/debug/pprof/", nil)); pattern != "" {
+		t.Fatalf("profiling registered on the default mux as %s", pattern)
+	}
+}
+
+func TestParseHTTPFormJSONOptions(t *testing.T) {
+	s := newEchoServer("")
+
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", "/echo?name=a", nil))
+	if body := w.Body.String(); !strings.Contains(body, `"details":{"name":"a"}`) {
+		t.Fatalf("unexpected default reply %s", body)
+	}
+
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", "/echo?name=a&text", nil))
+	if body := w.Body.String(); strings.Contains(body, "{") || !strings.Contains(body, "name:a") {
+		t.Fatalf("unexpected text reply %s", body)
+	}
+}
@@ -1,6 +1,9 @@
 package diagnostic
 
-import "fmt"
+import (
+	"fmt"
+	"sort"
+)
 
 // StringInterface interface that has to be implemented by messages
 type StringInterface interface {
@@ -121,12 +124,41 @@ type TablePeersResult struct {
 	Elements []PeerEntryObj `json:"entries"`
 }
 
-// NetworkStatsResult network db stats related to entries and queue len for a network
+// NetworkStatsResult network db stats related to entries, queue len and broadcasts for a network
 type NetworkStatsResult struct {
 	Entries  int `json:"entries"`
 	QueueLen int `jsoin:"qlen"`
+
+	QueueLimit  int    `json:"qlimit"`
+	Dropped     uint64 `json:"dropped"`
+	Rejected    uint64 `json:"rejected"`
+	Transmits   uint64 `json:"transmits"`
+	Retransmits uint64 `json:"retransmits"`
+	LagAvg      string `json:"lag_avg"`
+	LagMax      string `json:"lag_max"`
 }
 
 func (n *NetworkStatsResult) String() string {
-	return fmt.Sprintf("entries: %d, qlen: %d\n", n.Entries, n.QueueLen)
+	return fmt.Sprintf("entries: %d, qlen: %d, qlimit: %d, dropped: %d, rejected: %d, transmits: %d, retransmits: %d, lag avg: %s, lag max: %s\n",
+		n.Entries, n.QueueLen, n.QueueLimit, n.Dropped, n.Rejected, n.Transmits, n.Retransmits, n.LagAvg, n.LagMax)
+}
+
+// LogLevelResult is the default log level and the levels of the subsystems
+// which have their own
+type LogLevelResult struct {
+	Default    string            `json:"default"`
+	Subsystems map[string]string `json:"subsystems"`
+}
+
+func (l *LogLevelResult) String() string {
+	output := fmt.Sprintf("default: %s\n", l.Default)
+	names := make([]string, 0, len(l.Subsystems))
+	for name := range l.Subsystems {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		output += fmt.Sprintf("%s: %s\n", name, l.Subsystems[name])
+	}
+	return output
 }
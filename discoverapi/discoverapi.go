@@ -37,6 +37,10 @@ type DatastoreConfigData struct {
 	Provider string
 	Address  string
 	Config   interface{}
+	// KeyProvider is the datastore.KeyProvider of an encrypted store
+	KeyProvider interface{}
+	// Resilience is the *datastore.Resilience of the calls to the store
+	Resilience interface{}
 }
 
 // DriverEncryptionConfig contains the initial datapath encryption key(s)
@@ -0,0 +1,138 @@
+package libnetwork
+
+import (
+	"net"
+
+	"github.com/docker/libnetwork/netlabel"
+	"github.com/docker/libnetwork/netutils"
+	"github.com/docker/libnetwork/types"
+	"github.com/miekg/dns"
+	"github.com/sirupsen/logrus"
+)
+
+// dns64Backend is implemented by the DNS backends of the sandboxes
+// connected to a NAT64 network, whose resolver synthesizes the AAAA records
+// of the names having only IPv4 addresses, as in RFC 6147
+type dns64Backend interface {
+	// DNS64Prefix returns the NAT64 prefix the IPv4 addresses are
+	// embedded in, nil for none
+	DNS64Prefix() *net.IPNet
+}
+
+// dnsRecorder keeps the response written instead of sending it
+type dnsRecorder struct {
+	dns.ResponseWriter
+	msg *dns.Msg
+}
+
+func (rec *dnsRecorder) WriteMsg(msg *dns.Msg) error {
+	rec.msg = msg
+	return nil
+}
+
+// nat64Prefix returns the NAT64 prefix of the network, nil for none
+func (n *network) nat64Prefix() *net.IPNet {
+	v, ok := n.DriverOptions()[netlabel.NAT64Prefix]
+	if !ok {
+		return nil
+	}
+	_, prefix, err := net.ParseCIDR(v)
+	if err != nil || prefix.IP.To4() != nil {
+		return nil
+	}
+	return prefix
+}
+
+// DNS64Prefix returns the NAT64 prefix of the first network of the sandbox
+// having one
+func (sb *sandbox) DNS64Prefix() *net.IPNet {
+	for _, ep := range sb.getConnectedEndpoints() {
+		if n := ep.getNetwork(); n != nil {
+			if prefix := n.nat64Prefix(); prefix != nil {
+				return prefix
+			}
+		}
+	}
+	return nil
+}
+
+// dns64Prefix returns the NAT64 prefix the AAAA records of the query are
+// synthesized in, nil when they are not
+func (r *resolver) dns64Prefix(query *dns.Msg) *net.IPNet {
+	if query == nil || len(query.Question) == 0 || query.Question[0].Qtype != dns.TypeAAAA {
+		return nil
+	}
+	// The validating clients get the records as signed
+	if query.CheckingDisabled {
+		return nil
+	}
+	b, ok := r.backend.(dns64Backend)
+	if !ok {
+		return nil
+	}
+	return b.DNS64Prefix()
+}
+
+// serveDNS64 answers the AAAA query, synthesizing the records from the A
+// records of the name when it has none. The names of the containers are
+// left alone, their IPv4 addresses not being reachable through the NAT64.
+func (r *resolver) serveDNS64(w dns.ResponseWriter, query *dns.Msg, prefix *net.IPNet) {
+	rec := &dnsRecorder{ResponseWriter: w}
+	r.serveDNS(rec, query)
+	resp := rec.msg
+	if resp == nil {
+		return
+	}
+	if resp.Rcode == dns.RcodeSuccess && !resp.Truncated && !hasAnswer(resp, dns.TypeAAAA) {
+		name := query.Question[0].Name
+		if addr, _ := r.backend.ResolveName(name, types.IPv4); addr == nil {
+			if synth := r.synthesizeAAAA(w, query, prefix); synth != nil {
+				resp.Answer = synth
+				if maxSize := maxRespSize(w.LocalAddr().Network(), query); resp.Len() > maxSize {
+					truncateResp(resp, maxSize, w.LocalAddr().Network() == "tcp")
+				}
+			}
+		}
+	}
+	if err := w.WriteMsg(resp); err != nil {
+		logrus.Errorf("[resolver] error writing resolver resp, %s", err)
+	}
+}
+
+// synthesizeAAAA resolves the A records of the queried name and returns
+// the AAAA records embedding them in the prefix, along the CNAME records
+// leading to them, nil when there are none
+func (r *resolver) synthesizeAAAA(w dns.ResponseWriter, query *dns.Msg, prefix *net.IPNet) []dns.RR {
+	aQuery := query.Copy()
+	aQuery.Id = dns.Id()
+	aQuery.Question[0].Qtype = dns.TypeA
+	rec := &dnsRecorder{ResponseWriter: w}
+	r.serveDNS(rec, aQuery)
+	if rec.msg == nil || rec.msg.Rcode != dns.RcodeSuccess || !hasAnswer(rec.msg, dns.TypeA) {
+		return nil
+	}
+
+	var answer []dns.RR
+	for _, rr := range rec.msg.Answer {
+		switch rr := rr.(type) {
+		case *dns.CNAME:
+			answer = append(answer, rr)
+		case *dns.A:
+			answer = append(answer, &dns.AAAA{
+				Hdr:  dns.RR_Header{Name: rr.Hdr.Name, Rrtype: dns.TypeAAAA, Class: dns.ClassINET, Ttl: rr.Hdr.Ttl},
+				AAAA: netutils.NAT64Address(prefix, rr.A),
+			})
+		}
+	}
+	logrus.Debugf("[resolver] synthesized the AAAA records of %s in %s", query.Question[0].Name, prefix)
+	return answer
+}
+
+func hasAnswer(msg *dns.Msg, rrtype uint16) bool {
+	for _, rr := range msg.Answer {
+		if rr.Header().Rrtype == rrtype {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,114 @@
+package libnetwork
+
+import (
+	"net"
+	"testing"
+
+	"github.com/docker/libnetwork/types"
+	"github.com/miekg/dns"
+)
+
+// dns64TestBackend resolves the names of a single container and forwards
+// the other queries
+type dns64TestBackend struct {
+	prefix *net.IPNet
+}
+
+func (b *dns64TestBackend) ResolveName(name string, iplen int) ([]net.IP, bool) {
+	if name != "c1." {
+		return nil, false
+	}
+	if iplen == types.IPv4 {
+		return []net.IP{net.ParseIP("172.20.0.2")}, false
+	}
+	return nil, true
+}
+
+func (b *dns64TestBackend) ResolveIP(name string) string { return "" }
+
+func (b *dns64TestBackend) ResolveService(name string) ([]*net.SRV, []net.IP) { return nil, nil }
+
+func (b *dns64TestBackend) ExecFunc(f func()) error {
+	f()
+	return nil
+}
+
+func (b *dns64TestBackend) NdotsSet() bool { return false }
+
+func (b *dns64TestBackend) HandleQueryResp(name string, ip net.IP) {}
+
+func (b *dns64TestBackend) DNS64Prefix() *net.IPNet { return b.prefix }
+
+func dns64TestHandler(w dns.ResponseWriter, r *dns.Msg) {
+	m := new(dns.Msg)
+	m.SetReply(r)
+	q := r.Question[0]
+	hdr := func(name string, rrtype uint16) dns.RR_Header {
+		return dns.RR_Header{Name: name, Rrtype: rrtype, Class: dns.ClassINET, Ttl: 60}
+	}
+	switch {
+	case q.Name == "alias.example." && q.Qtype == dns.TypeA:
+		m.Answer = append(m.Answer,
+			&dns.CNAME{Hdr: hdr(q.Name, dns.TypeCNAME), Target: "v4.example."},
+			&dns.A{Hdr: hdr("v4.example.", dns.TypeA), A: net.ParseIP("198.51.100.7")})
+	case q.Name == "dual.example." && q.Qtype == dns.TypeAAAA:
+		m.Answer = append(m.Answer, &dns.AAAA{Hdr: hdr(q.Name, dns.TypeAAAA), AAAA: net.ParseIP("2001:db8::7")})
+	case q.Name == "missing.example.":
+		m.SetRcode(r, dns.RcodeNameError)
+	}
+	w.WriteMsg(m)
+}
+
+func TestDNS64(t *testing.T) {
+	dns.HandleFunc("example.", dns64TestHandler)
+	defer dns.HandleRemove("example.")
+	server := &dns.Server{Addr: "127.0.0.1:53", Net: "tcp"}
+	go server.ListenAndServe()
+	defer server.Shutdown()
+	waitForLocalDNSServer(t)
+
+	_, prefix, _ := net.ParseCIDR("64:ff9b::/96")
+	r := NewResolver(resolverIPSandbox, true, "", &dns64TestBackend{prefix: prefix}).(*resolver)
+	r.SetExtServers([]extDNSEntry{{IPStr: "127.0.0.1", HostLoopback: true}})
+
+	query := func(name string, qtype uint16) *dns.Msg {
+		w := new(tstwriter)
+		q := new(dns.Msg)
+		q.SetQuestion(name, qtype)
+		r.ServeDNS(w, q)
+		checkNonNullResponse(t, w.GetResponse())
+		return w.GetResponse()
+	}
+
+	// synthesized from the A record, along the CNAME leading to it
+	resp := query("alias.example.", dns.TypeAAAA)
+	checkDNSResponseCode(t, resp, dns.RcodeSuccess)
+	checkDNSAnswersCount(t, resp, 2)
+	checkDNSRRType(t, resp.Answer[0].Header().Rrtype, dns.TypeCNAME)
+	checkDNSRRType(t, resp.Answer[1].Header().Rrtype, dns.TypeAAAA)
+	if aaaa := resp.Answer[1].(*dns.AAAA); !aaaa.AAAA.Equal(net.ParseIP("64:ff9b::c633:6407")) || aaaa.Hdr.Name != "v4.example." {
+		t.Fatalf("unexpected synthesized record %s", aaaa)
+	}
+
+	// the names with AAAA records keep them
+	resp = query("dual.example.", dns.TypeAAAA)
+	checkDNSAnswersCount(t, resp, 1)
+	if aaaa := resp.Answer[0].(*dns.AAAA); !aaaa.AAAA.Equal(net.ParseIP("2001:db8::7")) {
+		t.Fatalf("unexpected record %s", aaaa)
+	}
+
+	// the errors are not synthesized over
+	resp = query("missing.example.", dns.TypeAAAA)
+	checkDNSResponseCode(t, resp, dns.RcodeNameError)
+	checkDNSAnswersCount(t, resp, 0)
+
+	// the containers keep their IPv4 only addresses
+	resp = query("c1.", dns.TypeAAAA)
+	checkDNSResponseCode(t, resp, dns.RcodeSuccess)
+	checkDNSAnswersCount(t, resp, 0)
+
+	// the A queries are left alone
+	resp = query("alias.example.", dns.TypeA)
+	checkDNSAnswersCount(t, resp, 2)
+	checkDNSRRType(t, resp.Answer[1].Header().Rrtype, dns.TypeA)
+}
@@ -0,0 +1,145 @@
+package libnetwork
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/docker/libnetwork/labelparse"
+	"github.com/docker/libnetwork/netlabel"
+	"github.com/docker/libnetwork/types"
+)
+
+// maxSearchDomain is the longest domain name
+const maxSearchDomain = 253
+
+// dnsOptionRanges are the settings of netlabel.DNSOptions, with the range
+// of values the resolver honors
+var dnsOptionRanges = map[string]struct{ min, max int }{
+	"ndots":    {0, 15},
+	"timeout":  {1, 30},
+	"attempts": {1, 5},
+}
+
+// parseDNSSearch returns the domains of the netlabel.DNSSearch value
+func parseDNSSearch(v string) ([]string, error) {
+	domains, err := labelparse.ParseList(netlabel.DNSSearch, v)
+	if err != nil {
+		return nil, err
+	}
+	for i, d := range domains {
+		if len(d) > maxSearchDomain || strings.ContainsAny(d, " \t/") || strings.Trim(d, ".") == "" {
+			return nil, types.BadRequestErrorf("invalid %s domain %q", netlabel.DNSSearch, d)
+		}
+		domains[i] = strings.TrimSuffix(d, ".")
+	}
+	return domains, nil
+}
+
+// parseDNSOptions returns the resolv.conf options of the netlabel.DNSOptions
+// value, as in ndots:2
+func parseDNSOptions(v string) ([]string, error) {
+	kvs, err := labelparse.ParseKeyValues(netlabel.DNSOptions, v)
+	if err != nil {
+		return nil, err
+	}
+	options := make([]string, 0, len(kvs))
+	for _, kv := range kvs {
+		r, ok := dnsOptionRanges[kv.Key]
+		if !ok {
+			return nil, types.BadRequestErrorf("invalid %s setting %q: expected ndots, timeout or attempts",
+				netlabel.DNSOptions, kv.Key)
+		}
+		n, err := strconv.Atoi(kv.Value)
+		if err != nil || n < r.min || n > r.max {
+			return nil, types.BadRequestErrorf("invalid %s %s %q: expected between %d and %d",
+				netlabel.DNSOptions, kv.Key, kv.Value, r.min, r.max)
+		}
+		options = append(options, kv.Key+":"+strconv.Itoa(n))
+	}
+	return options, nil
+}
+
+func (n *network) validateDNSOptions() error {
+	if v, ok := n.labels[netlabel.DNSSearch]; ok {
+		if _, err := parseDNSSearch(v); err != nil {
+			return err
+		}
+	}
+	if v, ok := n.labels[netlabel.DNSOptions]; ok {
+		if _, err := parseDNSOptions(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// hasDNSOptions tells whether the network sets search domains or resolver
+// options
+func (n *network) hasDNSOptions() bool {
+	labels := n.Labels()
+	_, search := labels[netlabel.DNSSearch]
+	_, options := labels[netlabel.DNSOptions]
+	return search || options
+}
+
+// dnsOptionName returns the name of the resolv.conf option, as ndots for
+// ndots:2
+func dnsOptionName(option string) string {
+	return strings.SplitN(option, ":", 2)[0]
+}
+
+// mergeDNSOptions appends the options of the lower precedence to the ones
+// of the higher, but for those already set
+func mergeDNSOptions(higher, lower []string) []string {
+	set := make(map[string]bool, len(higher))
+	for _, o := range higher {
+		set[dnsOptionName(o)] = true
+	}
+	merged := append([]string(nil), higher...)
+	for _, o := range lower {
+		if !set[dnsOptionName(o)] {
+			set[dnsOptionName(o)] = true
+			merged = append(merged, o)
+		}
+	}
+	return merged
+}
+
+// networkDNS returns the search domains and the resolver options the
+// networks of the sandbox set. The search domains of all of them are
+// searched, those of the endpoint of highest priority first, while each
+// option is set by the endpoint of highest priority setting it.
+func (sb *sandbox) networkDNS() ([]string, []string, error) {
+	var (
+		search  []string
+		options []string
+		seen    = map[string]bool{}
+	)
+	for _, ep := range sb.getConnectedEndpoints() {
+		n := ep.getNetwork()
+		if n == nil {
+			continue
+		}
+		labels := n.Labels()
+		if v, ok := labels[netlabel.DNSSearch]; ok {
+			domains, err := parseDNSSearch(v)
+			if err != nil {
+				return nil, nil, err
+			}
+			for _, d := range domains {
+				if !seen[d] {
+					seen[d] = true
+					search = append(search, d)
+				}
+			}
+		}
+		if v, ok := labels[netlabel.DNSOptions]; ok {
+			o, err := parseDNSOptions(v)
+			if err != nil {
+				return nil, nil, err
+			}
+			options = mergeDNSOptions(options, o)
+		}
+	}
+	return search, options, nil
+}
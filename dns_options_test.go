@@ -0,0 +1,122 @@
+package libnetwork
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/docker/libnetwork/netlabel"
+	"github.com/docker/libnetwork/resolvconf"
+	"github.com/docker/libnetwork/types"
+)
+
+func TestParseNetworkDNSOptions(t *testing.T) {
+	search, err := parseDNSSearch("corp.example.com., svc.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(search, []string{"corp.example.com", "svc.example.com"}) {
+		t.Fatalf("unexpected search domains %v", search)
+	}
+	for _, v := range []string{"corp example.com", ".", "a/b"} {
+		if _, err := parseDNSSearch(v); err == nil {
+			t.Fatalf("invalid search domains %q accepted", v)
+		}
+	}
+
+	options, err := parseDNSOptions("ndots=2, timeout=3,attempts=02")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(options, []string{"ndots:2", "timeout:3", "attempts:2"}) {
+		t.Fatalf("unexpected options %v", options)
+	}
+	for _, v := range []string{"ndots", "ndots=16", "timeout=0", "attempts=6", "rotate=1", "ndots=1,ndots=2"} {
+		if _, err := parseDNSOptions(v); err == nil {
+			t.Fatalf("invalid options %q accepted", v)
+		}
+	}
+
+	n := &network{labels: map[string]string{netlabel.DNSOptions: "ndots=x"}}
+	if err := n.validateDNSOptions(); err == nil {
+		t.Fatal("invalid options accepted")
+	}
+}
+
+func TestSetupNetworkDNS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "netdns")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	origin := filepath.Join(dir, "host.conf")
+	if err := ioutil.WriteFile(origin, []byte("search host.example.com\nnameserver 10.0.0.53\noptions ndots:1 rotate\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	sb := &sandbox{id: "sb1", config: containerConfig{}}
+	sb.config.originResolvConfPath = origin
+	sb.config.resolvConfPath = filepath.Join(dir, "resolv.conf")
+	if err := sb.setupDNS(); err != nil {
+		t.Fatal(err)
+	}
+
+	corp := &network{name: "corp", labels: map[string]string{
+		netlabel.DNSSearch:  "corp.example.com,example.com",
+		netlabel.DNSOptions: "ndots=3,timeout=2",
+	}}
+	svc := &network{name: "svc", labels: map[string]string{
+		netlabel.DNSSearch:  "svc.example.com,example.com",
+		netlabel.DNSOptions: "ndots=5,attempts=4",
+	}}
+	plain := &network{name: "plain"}
+	ep1 := &endpoint{name: "ep1", network: corp}
+	ep2 := &endpoint{name: "ep2", network: svc}
+	sb.endpoints = []*endpoint{ep1, ep2, {name: "ep3", network: plain}}
+
+	check := func(search, options []string) {
+		t.Helper()
+		rc, err := resolvconf.GetSpecific(sb.config.resolvConfPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := resolvconf.GetSearchDomains(rc.Content); !reflect.DeepEqual(got, search) {
+			t.Fatalf("unexpected search domains %v, expected %v", got, search)
+		}
+		if got := resolvconf.GetOptions(rc.Content); !reflect.DeepEqual(got, options) {
+			t.Fatalf("unexpected options %v, expected %v", got, options)
+		}
+		if got := resolvconf.GetNameservers(rc.Content, types.IP); !reflect.DeepEqual(got, []string{"10.0.0.53"}) {
+			t.Fatalf("unexpected name servers %v", got)
+		}
+	}
+
+	if err := sb.setupNetworkDNS(svc); err != nil {
+		t.Fatal(err)
+	}
+	check([]string{"corp.example.com", "example.com", "svc.example.com"}, []string{"ndots:3", "timeout:2", "attempts:4", "rotate"})
+
+	// Leaving a network takes its settings back out
+	sb.endpoints = []*endpoint{ep2}
+	if err := sb.setupNetworkDNS(corp); err != nil {
+		t.Fatal(err)
+	}
+	check([]string{"svc.example.com", "example.com"}, []string{"ndots:5", "attempts:4", "rotate"})
+
+	// The sandbox configuration has the last word
+	sb.config.dnsSearchList = []string{"mine.example.com"}
+	sb.config.dnsOptionsList = []string{"ndots:1"}
+	if err := sb.setupNetworkDNS(svc); err != nil {
+		t.Fatal(err)
+	}
+	check([]string{"mine.example.com"}, []string{"ndots:1", "attempts:4"})
+
+	sb.config.dnsSearchList, sb.config.dnsOptionsList = nil, nil
+	sb.endpoints = nil
+	if err := sb.setupNetworkDNS(svc); err != nil {
+		t.Fatal(err)
+	}
+	check([]string{"host.example.com"}, []string{"ndots:1", "rotate"})
+}
@@ -0,0 +1,116 @@
+package libnetwork
+
+import (
+	"github.com/docker/libnetwork/labelparse"
+	"github.com/docker/libnetwork/netlabel"
+	"github.com/miekg/dns"
+	"github.com/sirupsen/logrus"
+)
+
+// maxTXTString bounds the strings of a TXT record
+const maxTXTString = 255
+
+// txtBackend is implemented by the DNS backends of the sandboxes, whose
+// resolver answers the TXT queries of the endpoint names with the labels
+// the networks publish
+type txtBackend interface {
+	// ResolveTXT returns the published labels of the endpoint of the
+	// name, as in key=value, and whether the name is of an endpoint of a
+	// network publishing labels
+	ResolveTXT(name string) ([]string, bool)
+}
+
+// txtLabels returns the keys of the endpoint labels the network publishes
+// as TXT records, nil for none
+func (n *network) txtLabels() []string {
+	v, ok := n.DriverOptions()[netlabel.DNSTXTLabels]
+	if !ok {
+		return nil
+	}
+	keys, err := labelparse.ParseList("DNS TXT label", v)
+	if err != nil {
+		logrus.Warnf("Ignoring the DNS TXT labels of network %s: %v", n.Name(), err)
+		return nil
+	}
+	return keys
+}
+
+// txtRecords returns the labels of the endpoint among the keys, in their
+// order, as the strings of a TXT record as in RFC 1464
+func txtRecords(ep Endpoint, keys []string) []string {
+	e, ok := ep.(*endpoint)
+	if !ok {
+		return nil
+	}
+	e.Lock()
+	defer e.Unlock()
+	var txt []string
+	for _, k := range keys {
+		v, ok := e.generic[k].(string)
+		if !ok {
+			continue
+		}
+		s := k + "=" + v
+		if len(s) > maxTXTString {
+			logrus.Debugf("Not publishing the label %s of endpoint %s: %d bytes", k, e.name, len(s))
+			continue
+		}
+		txt = append(txt, s)
+	}
+	return txt
+}
+
+// ResolveTXT returns the labels published by the network of the endpoint
+// of the name, which is resolved as the A queries are
+func (sb *sandbox) ResolveTXT(name string) ([]string, bool) {
+	reqName, networkName := splitNetworkName(name)
+	epList := sb.getConnectedEndpoints()
+	for i := range reqName {
+		for _, ep := range epList {
+			n := ep.getNetwork()
+			if networkName[i] != "" && networkName[i] != n.Name() {
+				continue
+			}
+			if networkName[i] == "" && n.scopedResolution() {
+				continue
+			}
+			keys := n.txtLabels()
+			if keys == nil {
+				continue
+			}
+			epName := reqName[i]
+			ep.Lock()
+			if alias, ok := ep.aliases[epName]; ok {
+				epName = alias
+			}
+			ep.Unlock()
+			if peer, err := n.EndpointByName(epName); err == nil {
+				return txtRecords(peer, keys), true
+			}
+		}
+	}
+	return nil, false
+}
+
+// handleTXTQuery answers the TXT query of an endpoint name with its
+// published labels, the other names being forwarded
+func (r *resolver) handleTXTQuery(name string, query *dns.Msg) (*dns.Msg, error) {
+	b, ok := r.backend.(txtBackend)
+	if !ok {
+		return nil, nil
+	}
+	txt, found := b.ResolveTXT(name)
+	if !found {
+		return nil, nil
+	}
+	resp := createRespMsg(query)
+	if len(txt) == 0 {
+		return resp, nil
+	}
+	rr := &dns.TXT{
+		Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: respTTL},
+		Txt: txt,
+	}
+	resp.Answer = append(resp.Answer, rr)
+	return resp, nil
+}
@@ -0,0 +1,65 @@
+package libnetwork
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+// txtTestBackend publishes the labels of c1, c2 publishing none
+type txtTestBackend struct {
+	dns64TestBackend
+}
+
+func (b *txtTestBackend) ResolveTXT(name string) ([]string, bool) {
+	switch name {
+	case "c1.":
+		return []string{"version=1.2", "shard=3"}, true
+	case "c2.":
+		return nil, true
+	}
+	return nil, false
+}
+
+func TestDNSTXTQuery(t *testing.T) {
+	r := NewResolver(resolverIPSandbox, false, "", &txtTestBackend{}).(*resolver)
+	query := func(name string) *dns.Msg {
+		w := new(tstwriter)
+		q := new(dns.Msg)
+		q.SetQuestion(name, dns.TypeTXT)
+		r.ServeDNS(w, q)
+		checkNonNullResponse(t, w.GetResponse())
+		return w.GetResponse()
+	}
+
+	resp := query("c1.")
+	checkDNSResponseCode(t, resp, dns.RcodeSuccess)
+	checkDNSAnswersCount(t, resp, 1)
+	checkDNSRRType(t, resp.Answer[0].Header().Rrtype, dns.TypeTXT)
+	if txt := resp.Answer[0].(*dns.TXT).Txt; !reflect.DeepEqual(txt, []string{"version=1.2", "shard=3"}) {
+		t.Fatalf("unexpected TXT record %v", txt)
+	}
+
+	resp = query("c2.")
+	checkDNSResponseCode(t, resp, dns.RcodeSuccess)
+	checkDNSAnswersCount(t, resp, 0)
+
+	// the other names are left to the external servers
+	resp = query("example.com.")
+	checkDNSResponseCode(t, resp, dns.RcodeServerFailure)
+}
+
+func TestTXTRecords(t *testing.T) {
+	ep := &endpoint{name: "c1", generic: map[string]interface{}{
+		"version": "1.2",
+		"shard":   "3",
+		"ports":   []int{80},
+		"long":    strings.Repeat("x", maxTXTString),
+	}}
+	txt := txtRecords(ep, []string{"shard", "version", "ports", "long", "missing"})
+	if !reflect.DeepEqual(txt, []string{"shard=3", "version=1.2"}) {
+		t.Fatalf("unexpected TXT strings %v", txt)
+	}
+}
@@ -0,0 +1,98 @@
+package libnetwork
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/docker/libnetwork/diagnostic"
+	"github.com/docker/libnetwork/driverapi"
+	"github.com/docker/libnetwork/internal/caller"
+	"github.com/docker/libnetwork/options"
+	"github.com/docker/libnetwork/types"
+	"github.com/sirupsen/logrus"
+)
+
+// driverOptionsPaths2Func are the diagnostic handlers documenting the
+// network labels of the drivers
+var driverOptionsPaths2Func = map[string]diagnostic.HTTPHandlerFunc{
+	"/driveroptions": driverOptionsDiag,
+}
+
+// driverOption is the diagnostic output of a network label of a driver
+type driverOption struct {
+	Name    string `json:"name"`
+	Kind    string `json:"kind"`
+	Default string `json:"default,omitempty"`
+	Doc     string `json:"doc"`
+}
+
+// driverOptionsResult is the diagnostic output of the network labels of a
+// driver
+type driverOptionsResult struct {
+	Driver  string         `json:"driver"`
+	Options []driverOption `json:"options"`
+}
+
+func (r *driverOptionsResult) String() string {
+	var b strings.Builder
+	for _, o := range r.Options {
+		fmt.Fprintf(&b, "driver:%s %s (%s", r.Driver, o.Name, o.Kind)
+		if o.Default != "" {
+			fmt.Fprintf(&b, ", default %s", o.Default)
+		}
+		fmt.Fprintf(&b, "): %s\n", o.Doc)
+	}
+	return b.String()
+}
+
+// DriverNetworkOptions returns the schema of the labels configuring the
+// networks of the driver
+func (c *controller) DriverNetworkOptions(networkType string) (options.Schema, error) {
+	d, _ := c.drvRegistry.Driver(networkType)
+	if d == nil {
+		return nil, types.NotFoundErrorf("no %s driver", networkType)
+	}
+	od, ok := d.(driverapi.OptionDescriber)
+	if !ok {
+		return nil, types.NotImplementedErrorf("the %s driver does not describe its network options", networkType)
+	}
+	return od.NetworkOptions(), nil
+}
+
+func driverOptionsDiag(ctx interface{}, w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	diagnostic.DebugHTTPForm(r)
+	_, json := diagnostic.ParseHTTPFormOptions(r)
+
+	// audit logs
+	log := logrus.WithFields(logrus.Fields{"component": "diagnostic", "remoteIP": r.RemoteAddr, "method": caller.Name(0), "url": r.URL.String()})
+	log.Info("driver options")
+
+	c, ok := ctx.(*controller)
+	if !ok {
+		diagnostic.HTTPReply(w, diagnostic.FailCommand(fmt.Errorf("controller not available")), json)
+		return
+	}
+
+	name := r.Form.Get("driver")
+	if name == "" {
+		diagnostic.HTTPReply(w, diagnostic.WrongCommand("driveroptions", "driver=<network driver>"), json)
+		return
+	}
+	schema, err := c.DriverNetworkOptions(name)
+	if err != nil {
+		log.WithError(err).Error("driver options failed")
+		diagnostic.HTTPReply(w, diagnostic.FailCommand(err), json)
+		return
+	}
+
+	res := &driverOptionsResult{Driver: name}
+	for _, sp := range schema {
+		res.Options = append(res.Options, driverOption{Name: sp.Name, Kind: sp.Kind.String(), Default: sp.Default, Doc: sp.Doc})
+	}
+	sort.Slice(res.Options, func(i, j int) bool { return res.Options[i].Name < res.Options[j].Name })
+	log.Info("driver options done")
+	diagnostic.HTTPReply(w, diagnostic.CommandSucceed(res), json)
+}
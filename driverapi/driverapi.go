@@ -1,10 +1,12 @@
 package driverapi
 
 import (
+	"context"
 	"net"
 
 	"github.com/docker/docker/pkg/plugingetter"
 	"github.com/docker/libnetwork/discoverapi"
+	"github.com/docker/libnetwork/options"
 )
 
 // NetworkPluginEndpointType represents the Endpoint Type used by Plugin system
@@ -89,6 +91,375 @@ type Driver interface {
 	IsBuiltIn() bool
 }
 
+// Reconciler is an optional interface for the drivers able to audit the
+// kernel state they programmed against their own state.
+type Reconciler interface {
+	// Reconcile reports the differences found and, when repair is set,
+	// tries to reprogram the kernel to match the driver state.
+	Reconcile(repair bool) ([]Drift, error)
+}
+
+// DeferredCleaner is an optional interface for the drivers deferring the
+// removal of the resources of the deleted endpoints.
+type DeferredCleaner interface {
+	// ForceCleanup removes the resources of the deleted endpoints right
+	// away, instead of at the end of their quiesce period, and returns the
+	// number of endpoints cleaned up.
+	ForceCleanup() int
+}
+
+// OrphanCollector is an optional interface for the drivers tagging the
+// kernel objects they create with their owner.
+type OrphanCollector interface {
+	// CollectOrphans removes the tagged kernel objects of the owners the
+	// driver does not know of, as left behind by a crash, and returns the
+	// number of objects removed.
+	CollectOrphans() int
+}
+
+// OptionDescriber is an optional interface for the drivers describing the
+// labels configuring their networks.
+type OptionDescriber interface {
+	// NetworkOptions returns the schema of the network labels, which
+	// parses, validates and documents them.
+	NetworkOptions() options.Schema
+}
+
+// ConfigReloader is an optional interface for the drivers able to apply a
+// new driver configuration without a restart.
+type ConfigReloader interface {
+	// ReloadConfig applies the settings of the configuration which can
+	// change at run time and returns the names of the other changed
+	// settings, which are left as they are until the restart.
+	ReloadConfig(option map[string]interface{}) ([]string, error)
+}
+
+// ContextJoiner is an optional interface for the drivers able to abort a
+// join, and the programming of the external connectivity which follows it,
+// when the context of the request is done.
+type ContextJoiner interface {
+	// JoinContext behaves as Join and returns the error of the context
+	// when it is done before the driver completes.
+	JoinContext(ctx context.Context, nid, eid string, sboxKey string, jinfo JoinInfo, options map[string]interface{}) error
+
+	// ProgramExternalConnectivityContext behaves as ProgramExternalConnectivity
+	// and returns the error of the context when it is done before the driver
+	// completes.
+	ProgramExternalConnectivityContext(ctx context.Context, nid, eid string, options map[string]interface{}) error
+}
+
+// OrphanCleaner is an optional interface for the multi-host drivers which
+// keep state about the endpoints of the other hosts.
+type OrphanCleaner interface {
+	// CleanupOrphanEndpoint removes the state kept for an endpoint whose
+	// host left the cluster without deleting it.
+	CleanupOrphanEndpoint(nid, eid string) error
+}
+
+// SubnetAdder is an optional interface for the drivers able to program an
+// additional subnet on an existing network.
+type SubnetAdder interface {
+	// AddSubnet programs the pool, whose gateway is allocated, on the
+	// network.
+	AddSubnet(nid string, ipData IPAMData, v6 bool) error
+}
+
+// Drift describes a piece of kernel state which does not match the state
+// libnetwork holds.
+type Drift struct {
+	NetworkID  string `json:"networkID,omitempty"`
+	EndpointID string `json:"endpointID,omitempty"`
+	// Resource is the kind of kernel object: link, address, route,
+	// iptables, ipvs, fdb...
+	Resource string `json:"resource"`
+	Detail   string `json:"detail"`
+	Repaired bool   `json:"repaired"`
+	// RepairError is set when the repair was attempted and failed
+	RepairError string `json:"repairError,omitempty"`
+}
+
+// SetRepairResult records the outcome of a repair on the drift
+func (d *Drift) SetRepairResult(err error) {
+	if err != nil {
+		d.RepairError = err.Error()
+		return
+	}
+	d.Repaired = true
+}
+
+// Encapsulations of the traffic mirrored to a remote collector
+const (
+	MirrorVXLAN  = "vxlan"
+	MirrorGRETAP = "gretap"
+)
+
+// MirrorTarget is where the traffic of an endpoint is mirrored to, either
+// a host interface or a remote collector
+type MirrorTarget struct {
+	// Interface is the host interface the traffic is mirrored to
+	Interface string
+	// Remote is the address of the collector, reached with Encapsulation
+	Remote net.IP
+	// Local is the source address of the encapsulated traffic
+	Local net.IP
+	// Encapsulation is MirrorVXLAN or MirrorGRETAP
+	Encapsulation string
+	// Key is the VXLAN VNI or the GRE key
+	Key uint32
+}
+
+// Mirrorer is an optional interface for the drivers able to mirror the
+// traffic of their endpoints.
+type Mirrorer interface {
+	// MirrorEndpoint mirrors the traffic of the endpoint to the target,
+	// replacing the previous target, or stops the mirroring when the
+	// target is nil.
+	MirrorEndpoint(nid, eid string, target *MirrorTarget) error
+}
+
+// Flow is the 5-tuple of a simulated packet, Proto being tcp, udp, sctp
+// or icmp
+type Flow struct {
+	Proto   string
+	Src     net.IP
+	SrcPort int
+	Dst     net.IP
+	DstPort int
+}
+
+// VerdictStep is a rule a simulated packet went through
+type VerdictStep struct {
+	Table string `json:"table"`
+	Chain string `json:"chain"`
+	Rule  string `json:"rule"`
+	// Matched tells if the packet matched the rule, the rules which
+	// depend on state the simulation does not know of are listed as
+	// not matched
+	Matched bool   `json:"matched"`
+	Note    string `json:"note,omitempty"`
+}
+
+// Verdict is the outcome of the simulation of a packet, Verdict being
+// the target of the rule which decided of its fate, ACCEPT, DROP or
+// REJECT, or the policy of the chain it fell through
+type Verdict struct {
+	Verdict string `json:"verdict"`
+	// Translated is the packet as seen past the DNAT of a published port
+	Translated *Flow         `json:"translated,omitempty"`
+	Steps      []VerdictStep `json:"steps"`
+}
+
+// VerdictSimulator is an optional interface for the drivers able to tell
+// the fate of a packet of their endpoints from the rules they generate,
+// without querying the kernel.
+type VerdictSimulator interface {
+	// SimulateVerdict walks the rules a new flow to or from the endpoint
+	// goes through and reports the one which matches.
+	SimulateVerdict(nid, eid string, flow *Flow) (*Verdict, error)
+}
+
+// EndpointOpener is an optional interface for the drivers holding the
+// traffic of their new endpoints until their configuration is in place.
+type EndpointOpener interface {
+	// OpenEndpoint tells the driver the join of the endpoint is applied,
+	// or, when ready is set, that the orchestrator signaled the endpoint
+	// ready. The driver lets the traffic through once it got the signals
+	// the network of the endpoint waits for.
+	OpenEndpoint(nid, eid string, ready bool) error
+}
+
+// NamespaceJoiner is an optional interface for the drivers plumbing their
+// endpoints in the namespace of the sandbox themselves, which the join of a
+// sandbox with an external key precedes.
+type NamespaceJoiner interface {
+	// JoinNamespace plumbs the endpoint, joined with the NamespacePending
+	// option, in the namespace of the sandbox once its key is set. It is
+	// called again when the key is set anew.
+	JoinNamespace(nid, eid, sboxKey string) error
+}
+
+// The actions of the quarantine of an endpoint
+const (
+	QuarantineDrop   = "DROP"
+	QuarantineReject = "REJECT"
+)
+
+// Quarantiner is an optional interface for the drivers able to cut the
+// traffic of their endpoints off without stopping them.
+type Quarantiner interface {
+	// QuarantineEndpoint drops or rejects all the traffic of the endpoint,
+	// replacing the previous action, or lets it through again when the
+	// action is empty.
+	QuarantineEndpoint(nid, eid, action string) error
+}
+
+// Throttler is an optional interface for the drivers able to limit the
+// rate of the traffic of their endpoints.
+type Throttler interface {
+	// ThrottleEndpoint drops the traffic of the endpoint above the rate,
+	// in bytes per second in each direction, replacing the previous rate,
+	// or lets it through again when the rate is zero.
+	ThrottleEndpoint(nid, eid string, rate uint64) error
+}
+
+// FailoverHandler is an optional interface for the drivers keeping state
+// tied to the uplinks of the host, told when one of them fails over.
+type FailoverHandler interface {
+	// UplinkFailover programs the state of the driver going through the
+	// uplink again, once its traffic moved to another port
+	UplinkFailover(uplink string) error
+}
+
+// EndpointState is an endpoint of a network of the driver known to the
+// controller, with the key of the sandbox it is joined to if any
+type EndpointState struct {
+	NetworkID  string
+	EndpointID string
+	SandboxKey string
+	Joined     bool
+}
+
+// EndpointRestorer is an optional interface for the drivers keeping their
+// own track of their endpoints, as the remote plugins reconciling their
+// state, told of the endpoints of their networks in the store when they
+// register.
+type EndpointRestorer interface {
+	// RestoreEndpoints seeds the endpoints the driver knows of
+	RestoreEndpoints(eps []EndpointState)
+}
+
+// FilterRule allows the ingress traffic of a protocol, tcp, udp or sctp,
+// to a port or a range of ports, as in 8000-8010, from the subnet if set.
+// The subnet may come from Source instead, an address or a subnet with the
+// ${HOST_IP}, ${NETWORK_SUBNET} and ${GATEWAY} variables, expanded by the
+// driver when the policy is applied. A Source of FilterSetPrefix and the
+// name of an ipset of the host, as in set:corp-admin-nets, allows the
+// sources the set holds; the set is managed out of libnetwork and must
+// exist when the policy is applied. A Source of FilterMACPrefix and a MAC
+// address, as in mac:aa:bb:cc:dd:ee:ff, allows the frames the address
+// sends, in both families. A Source of FilterGeoPrefix and a country code,
+// as in geo:US, allows the subnets of the country, from the GeoIP provider
+// of the driver.
+type FilterRule struct {
+	Proto  string
+	Ports  string
+	From   *net.IPNet
+	Source string `json:",omitempty"`
+	// Peer is the name of an external endpoint of the network, resolved
+	// to its address by the controller
+	Peer string `json:",omitempty"`
+	// Family is the address family, FilterIPv4 or FilterIPv6, the rule
+	// applies to. A rule without a family applies to the family of its
+	// subnet, or to both when it has none; the family of an ipset is set
+	// by the driver from the set.
+	Family string `json:",omitempty"`
+	// TimeStart and TimeStop, as in 08:00 and 18:00, restrict the rule to
+	// a daily window of the kernel time zone, a window passing midnight
+	// when it stops before it starts. Both are set or none is.
+	TimeStart string `json:",omitempty"`
+	TimeStop  string `json:",omitempty"`
+}
+
+// Address families of the filter rules
+const (
+	FilterIPv4 = "ipv4"
+	FilterIPv6 = "ipv6"
+)
+
+// FilterSetPrefix prefixes the name of the ipset of the source expression
+// of a filter rule
+const FilterSetPrefix = "set:"
+
+// FilterMACPrefix prefixes the MAC address of the source expression of a
+// filter rule
+const FilterMACPrefix = "mac:"
+
+// FilterGeoPrefix prefixes the ISO 3166-1 alpha-2 code of the country of
+// the source expression of a filter rule
+const FilterGeoPrefix = "geo:"
+
+// FilterPolicy is a version of the ingress filter of an endpoint. The
+// traffic its rules allow gets through, the rest is rejected, or only
+// logged when the policy is applied for audit.
+type FilterPolicy struct {
+	Version string
+	Allow   []FilterRule
+	Audit   bool `json:",omitempty"`
+}
+
+// FilterCounters are the packets the filter policy of an endpoint let
+// through and rejected, since the version was applied. The rejected
+// packets of an audit policy are the ones it would have rejected.
+type FilterCounters struct {
+	Version  string
+	Audit    bool
+	Accepted uint64
+	Rejected uint64
+}
+
+// EndpointFilterer is an optional interface for the drivers filtering the
+// ingress traffic of their endpoints with versioned policies.
+type EndpointFilterer interface {
+	// FilterEndpoint replaces the filter policy of the endpoint, or lifts
+	// it when the policy is nil.
+	FilterEndpoint(nid, eid string, policy *FilterPolicy) error
+	// FilterCounters returns the counters of the filter policy of the
+	// endpoint, nil when it has none.
+	FilterCounters(nid, eid string) (*FilterCounters, error)
+}
+
+// InterNetworkEnd is a local network of an inter-network rule, with its
+// IPv4 subnets
+type InterNetworkEnd struct {
+	NetworkID string
+	Subnets   []*net.IPNet
+}
+
+// InterNetworkRule lets the endpoints of the From network open connections
+// to the ones of the To network, the replies only getting back unless the
+// rule is bidirectional, or denies the traffic between them when Deny is
+// set.
+type InterNetworkRule struct {
+	From          InterNetworkEnd
+	To            InterNetworkEnd
+	Deny          bool
+	Bidirectional bool
+}
+
+// InterNetworkRouter is an optional interface for the drivers routing the
+// traffic of their networks to the other local networks as the policies
+// of the controller tell.
+type InterNetworkRouter interface {
+	// SetInterNetworkRules replaces the rules on the traffic between the
+	// local networks, the driver applying the ones involving its networks.
+	SetInterNetworkRules(rules []InterNetworkRule) error
+}
+
+// NetworkStatistician is an optional interface for the drivers keeping
+// counters of the data path events they handle for their networks.
+type NetworkStatistician interface {
+	// NetworkStatistics returns the counters of the network by name.
+	NetworkStatistics(nid string) (map[string]uint64, error)
+}
+
+// SocketDenial counts the connect() calls of an endpoint to a destination
+// its socket policy rejected
+type SocketDenial struct {
+	NetworkID  string
+	EndpointID string
+	Dst        net.IP
+	Port       uint16
+	Count      uint64
+}
+
+// SocketPolicer is an optional interface for the drivers rejecting the
+// connect() calls of their endpoints out of their egress allow list.
+type SocketPolicer interface {
+	// SocketDenials returns the connect() calls the socket policies of
+	// the endpoints rejected since the previous call.
+	SocketDenials() ([]SocketDenial, error)
+}
+
 // NetworkInfo provides a go interface for drivers to provide network
 // specific information to libnetwork.
 type NetworkInfo interface {
@@ -151,6 +522,55 @@ type JoinInfo interface {
 	AddTableEntry(tableName string, key string, value []byte) error
 }
 
+// LoopbackInfo is implemented by the JoinInfo of the endpoints which can
+// have addresses configured on the loopback of the sandbox
+type LoopbackInfo interface {
+	// AddLoopbackAddress adds an address to the loopback of the sandbox
+	// when a container joins the endpoint.
+	AddLoopbackAddress(addr *net.IPNet) error
+}
+
+// NeighborInfo is implemented by the JoinInfo of the endpoints which can
+// have static neighbor entries installed in the sandbox
+type NeighborInfo interface {
+	// AddNeighbor adds a permanent neighbor entry on the interface of the
+	// endpoint in the sandbox when a container joins the endpoint.
+	AddNeighbor(ip net.IP, mac net.HardwareAddr) error
+}
+
+// StepTimer is implemented by the InterfaceInfo of the endpoints, and
+// carried by the contexts of the joins, whose operation is timed against a
+// latency budget
+type StepTimer interface {
+	// TimeStep starts timing a step of the operation, the returned function
+	// ends it.
+	TimeStep(name string) func()
+}
+
+type stepTimerKey struct{}
+
+// WithStepTimer returns a copy of the context carrying the step timer
+func WithStepTimer(ctx context.Context, t StepTimer) context.Context {
+	return context.WithValue(ctx, stepTimerKey{}, t)
+}
+
+// TimeStep starts timing a step of the operation on the step timer of the
+// context or InterfaceInfo passed, and returns the function ending it. It
+// does nothing when no timer is carried.
+func TimeStep(v interface{}, name string) func() {
+	var t StepTimer
+	switch v := v.(type) {
+	case context.Context:
+		t, _ = v.Value(stepTimerKey{}).(StepTimer)
+	case StepTimer:
+		t = v
+	}
+	if t == nil {
+		return func() {}
+	}
+	return t.TimeStep(name)
+}
+
 // DriverCallback provides a Callback interface for Drivers into LibNetwork
 type DriverCallback interface {
 	// GetPluginGetter returns the pluginv2 getter.
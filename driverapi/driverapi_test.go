@@ -1,6 +1,7 @@
 package driverapi
 
 import (
+	"context"
 	"encoding/json"
 	"net"
 	"testing"
@@ -117,3 +118,21 @@ func TestValidateAndIsV6(t *testing.T) {
 		t.Fatal("expected error but succeeded")
 	}
 }
+
+type countingTimer map[string]int
+
+func (c countingTimer) TimeStep(name string) func() {
+	return func() { c[name]++ }
+}
+
+func TestTimeStep(t *testing.T) {
+	TimeStep(context.Background(), "none")()
+	TimeStep(nil, "none")()
+
+	c := countingTimer{}
+	TimeStep(WithStepTimer(context.Background(), c), "ctx")()
+	TimeStep(c, "info")()
+	if c["ctx"] != 1 || c["info"] != 1 || len(c) != 2 {
+		t.Fatalf("unexpected timed steps %v", c)
+	}
+}
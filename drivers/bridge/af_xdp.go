@@ -0,0 +1,249 @@
+package bridge
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"unsafe"
+
+	"github.com/docker/libnetwork/labelparse"
+	"github.com/docker/libnetwork/netlabel"
+	"github.com/docker/libnetwork/types"
+	"github.com/sirupsen/logrus"
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+	"golang.org/x/sys/unix"
+)
+
+// afXDPConfig prepares the sandbox interface of an endpoint for AF_XDP
+// sockets, as in queues=4,redirect=on. The veth runs XDP in native mode,
+// which the sockets bind to in copy mode, veth having no zero-copy support.
+type afXDPConfig struct {
+	// Queues overrides the veth queues of the network, one socket
+	// binding to each
+	Queues int `json:",omitempty"`
+	// Redirect loads a program on the sandbox interface redirecting the
+	// frames of each queue to the socket of the queue in its XSKMAP, the
+	// frames of the queues without socket passing on to the stack
+	Redirect bool `json:",omitempty"`
+}
+
+const (
+	bpfMapCreate = 0
+	bpfProgLoad  = 5
+	bpfObjPin    = 6
+
+	bpfMapTypeXSKMap = 17
+	bpfProgTypeXDP   = 6
+)
+
+// afXDPPinRoot is the bpffs directory the XSKMAPs of the endpoints are
+// pinned in, each under the endpoint id, for the sandboxes to mount
+var afXDPPinRoot = "/sys/fs/bpf/libnetwork"
+
+func parseAFXDPOptions(ec *endpointConfiguration, epOptions map[string]interface{}) error {
+	opt, ok := epOptions[netlabel.AFXDP]
+	if !ok {
+		return nil
+	}
+	v, ok := opt.(string)
+	if !ok {
+		return &ErrInvalidEndpointConfig{}
+	}
+	kvs, err := labelparse.ParseKeyValues("AF_XDP setting", v)
+	if err != nil {
+		return err
+	}
+	c := &afXDPConfig{}
+	for _, kv := range kvs {
+		switch kv.Key {
+		case "queues":
+			q, err := strconv.Atoi(kv.Value)
+			if err != nil || q < 1 || q > maxVethQueues {
+				return types.BadRequestErrorf("invalid AF_XDP queues %q: expected 1 to %d", kv.Value, maxVethQueues)
+			}
+			c.Queues = q
+		case "redirect":
+			switch kv.Value {
+			case "on":
+				c.Redirect = true
+			case "off":
+				c.Redirect = false
+			default:
+				return types.BadRequestErrorf("invalid AF_XDP redirect %q: expected on or off", kv.Value)
+			}
+		default:
+			return types.BadRequestErrorf("unsupported AF_XDP setting %q: expected queues or redirect", kv.Key)
+		}
+	}
+	ec.AFXDP = c
+	return nil
+}
+
+// endpointQueues returns the veth queues of the endpoint, the ones of its
+// AF_XDP settings if any, else the ones of the network
+func endpointQueues(ec *endpointConfiguration, config *networkConfiguration) int {
+	if ec != nil && ec.AFXDP != nil && ec.AFXDP.Queues > 0 {
+		return ec.AFXDP.Queues
+	}
+	return config.VethQueues
+}
+
+func xskMapPath(eid string) string {
+	return filepath.Join(afXDPPinRoot, eid)
+}
+
+// setupAFXDP turns GRO on on the host end of the veth, for the frames the
+// sandbox sends with XDP_TX to be received, and with redirect on attaches
+// the redirect program to the sandbox end and pins its XSKMAP. Both stay
+// along the sandbox end when it moves to the sandbox.
+func setupAFXDP(eid string, c *afXDPConfig, hostIfName, containerIfName string) error {
+	if c == nil {
+		return nil
+	}
+	if err := setupVethOffloads(map[string]bool{"gro": true}, hostIfName); err != nil {
+		return err
+	}
+	if !c.Redirect {
+		return nil
+	}
+	sbox, err := netlink.LinkByName(containerIfName)
+	if err != nil {
+		return err
+	}
+	// the map holds a socket for any of the queues a veth can have
+	mapFd, err := createXSKMap(maxVethQueues)
+	if err != nil {
+		return fmt.Errorf("failed to create the XSKMAP of %s: %v", containerIfName, err)
+	}
+	defer unix.Close(mapFd)
+	progFd, err := loadXSKRedirect(mapFd)
+	if err != nil {
+		return fmt.Errorf("failed to load the AF_XDP redirect program: %v", err)
+	}
+	defer unix.Close(progFd)
+	// without flags, as the vendored netlink then sends the flags as the
+	// fd, the program runs in the native mode of veth
+	if err := netlink.LinkSetXdpFd(sbox, progFd); err != nil {
+		return fmt.Errorf("failed to attach the AF_XDP redirect program to %s: %v", containerIfName, err)
+	}
+	if err := os.MkdirAll(afXDPPinRoot, 0700); err != nil {
+		return fmt.Errorf("failed to pin the XSKMAP of %s, %s must be on a bpf filesystem: %v", containerIfName, afXDPPinRoot, err)
+	}
+	// a map left pinned by a crash is replaced
+	removeXSKMap(eid)
+	if err := pinBPFObject(mapFd, xskMapPath(eid)); err != nil {
+		return fmt.Errorf("failed to pin the XSKMAP of %s, %s must be on a bpf filesystem: %v", containerIfName, afXDPPinRoot, err)
+	}
+	return nil
+}
+
+// removeXSKMap unpins the XSKMAP of the endpoint, if any
+func removeXSKMap(eid string) {
+	if err := os.Remove(xskMapPath(eid)); err != nil && !os.IsNotExist(err) {
+		logrus.Warnf("Failed to unpin the XSKMAP of endpoint %.7s: %v", eid, err)
+	}
+}
+
+// bpfInsn is an eBPF instruction, its registers packed together as the
+// bitfields of the kernel are on the host
+type bpfInsn struct {
+	code uint8
+	regs uint8
+	off  int16
+	imm  int32
+}
+
+func bpfRegs(dst, src uint8) uint8 {
+	if nl.NativeEndian() == binary.BigEndian {
+		return dst<<4 | src
+	}
+	return src<<4 | dst
+}
+
+// xskRedirectProgram redirects the frames of each receive queue to the
+// socket of the queue in the map, if any, and passes them on otherwise,
+// as the program libbpf loads by default does
+func xskRedirectProgram(mapFd int) []bpfInsn {
+	return []bpfInsn{
+		{code: 0x61, regs: bpfRegs(2, 1), off: 16},           // r2 = ctx->rx_queue_index
+		{code: 0x63, regs: bpfRegs(10, 2), off: -4},          // *(u32 *)(r10 - 4) = r2
+		{code: 0xbf, regs: bpfRegs(6, 2)},                    // r6 = r2
+		{code: 0xbf, regs: bpfRegs(2, 10)},                   // r2 = r10
+		{code: 0x07, regs: bpfRegs(2, 0), imm: -4},           // r2 += -4
+		{code: 0x18, regs: bpfRegs(1, 1), imm: int32(mapFd)}, // r1 = map
+		{},                   // the upper half of the map
+		{code: 0x85, imm: 1}, // call bpf_map_lookup_elem
+		{code: 0x15, regs: bpfRegs(0, 0), off: 6},            // if r0 == 0 goto pass
+		{code: 0xbf, regs: bpfRegs(2, 6)},                    // r2 = r6
+		{code: 0x18, regs: bpfRegs(1, 1), imm: int32(mapFd)}, // r1 = map
+		{},                                // the upper half of the map
+		{code: 0xb7, regs: bpfRegs(3, 0)}, // r3 = 0
+		{code: 0x85, imm: 51},             // call bpf_redirect_map
+		{code: 0x95},                      // exit
+		{code: 0xb7, regs: bpfRegs(0, 0), imm: 2}, // pass: r0 = XDP_PASS
+		{code: 0x95}, // exit
+	}
+}
+
+func bpf(cmd int, attr unsafe.Pointer, size uintptr) (int, error) {
+	fd, _, errno := unix.Syscall(unix.SYS_BPF, uintptr(cmd), uintptr(attr), size)
+	if errno != 0 {
+		return -1, errno
+	}
+	return int(fd), nil
+}
+
+func createXSKMap(entries int) (int, error) {
+	attr := struct {
+		mapType, keySize, valueSize, maxEntries, mapFlags uint32
+	}{bpfMapTypeXSKMap, 4, 4, uint32(entries), 0}
+	return bpf(bpfMapCreate, unsafe.Pointer(&attr), unsafe.Sizeof(attr))
+}
+
+func loadXSKRedirect(mapFd int) (int, error) {
+	insns := xskRedirectProgram(mapFd)
+	license := []byte("Apache-2.0\x00")
+	log := make([]byte, 1<<16)
+	attr := struct {
+		progType, insnCnt uint32
+		insns, license    uint64
+		logLevel, logSize uint32
+		logBuf            uint64
+	}{
+		progType: bpfProgTypeXDP,
+		insnCnt:  uint32(len(insns)),
+		insns:    uint64(uintptr(unsafe.Pointer(&insns[0]))),
+		license:  uint64(uintptr(unsafe.Pointer(&license[0]))),
+		logLevel: 1,
+		logSize:  uint32(len(log)),
+		logBuf:   uint64(uintptr(unsafe.Pointer(&log[0]))),
+	}
+	fd, err := bpf(bpfProgLoad, unsafe.Pointer(&attr), unsafe.Sizeof(attr))
+	runtime.KeepAlive(insns)
+	runtime.KeepAlive(license)
+	runtime.KeepAlive(log)
+	if err != nil {
+		if n := bytes.IndexByte(log, 0); n > 0 {
+			return -1, fmt.Errorf("%v: %s", err, bytes.TrimSpace(log[:n]))
+		}
+		return -1, err
+	}
+	return fd, nil
+}
+
+func pinBPFObject(fd int, path string) error {
+	p := append([]byte(path), 0)
+	attr := struct {
+		pathname  uint64
+		bpfFd     uint32
+		fileFlags uint32
+	}{uint64(uintptr(unsafe.Pointer(&p[0]))), uint32(fd), 0}
+	_, err := bpf(bpfObjPin, unsafe.Pointer(&attr), unsafe.Sizeof(attr))
+	runtime.KeepAlive(p)
+	return err
+}
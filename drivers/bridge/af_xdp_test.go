@@ -0,0 +1,81 @@
+package bridge
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"syscall"
+	"testing"
+
+	"github.com/docker/libnetwork/netlabel"
+	"github.com/docker/libnetwork/ns"
+	"github.com/docker/libnetwork/testutils"
+	"github.com/vishvananda/netlink"
+)
+
+func TestParseAFXDPOptions(t *testing.T) {
+	ec, err := parseEndpointOptions(map[string]interface{}{netlabel.AFXDP: "queues=4,redirect=on"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(ec.AFXDP, &afXDPConfig{Queues: 4, Redirect: true}) {
+		t.Fatalf("unexpected AF_XDP settings %+v", ec.AFXDP)
+	}
+	if q := endpointQueues(ec, &networkConfiguration{VethQueues: 2}); q != 4 {
+		t.Fatalf("unexpected queues %d", q)
+	}
+	if q := endpointQueues(&endpointConfiguration{}, &networkConfiguration{VethQueues: 2}); q != 2 {
+		t.Fatalf("unexpected queues %d", q)
+	}
+	for _, v := range []string{"queues=0", "queues=65", "redirect=yes", "zerocopy=on"} {
+		if _, err := parseEndpointOptions(map[string]interface{}{netlabel.AFXDP: v}); err == nil {
+			t.Fatalf("expected an error parsing %q", v)
+		}
+	}
+}
+
+func TestSetupAFXDP(t *testing.T) {
+	if !testutils.IsRunningInContainer() {
+		defer testutils.SetupTestOSContext(t)()
+	}
+	root, err := ioutil.TempDir("", "af-xdp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	if err := syscall.Mount("bpf", root, "bpf", 0, ""); err != nil {
+		t.Skipf("no bpf filesystem: %v", err)
+	}
+	defer syscall.Unmount(root, 0)
+	defer func(r string) { afXDPPinRoot = r }(afXDPPinRoot)
+	afXDPPinRoot = root + "/libnetwork"
+
+	nlh := ns.NlHandle()
+	if err := addVeth(nlh, "vethxdp0", "vethxdp1", 2); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if link, err := nlh.LinkByName("vethxdp0"); err == nil {
+			nlh.LinkDel(link)
+		}
+	}()
+
+	if err := setupAFXDP("ep1", &afXDPConfig{Queues: 2, Redirect: true}, "vethxdp0", "vethxdp1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(xskMapPath("ep1")); err != nil {
+		t.Fatalf("XSKMAP not pinned: %v", err)
+	}
+	link, err := netlink.LinkByName("vethxdp1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if xdp := link.Attrs().Xdp; xdp == nil || !xdp.Attached {
+		t.Fatalf("no XDP program attached to %s", link.Attrs().Name)
+	}
+
+	removeXSKMap("ep1")
+	if _, err := os.Stat(xskMapPath("ep1")); !os.IsNotExist(err) {
+		t.Fatalf("XSKMAP still pinned: %v", err)
+	}
+}
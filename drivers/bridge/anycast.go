@@ -0,0 +1,110 @@
+package bridge
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/docker/libnetwork/driverapi"
+	"github.com/docker/libnetwork/netlabel"
+	"github.com/docker/libnetwork/netutils"
+	"github.com/docker/libnetwork/types"
+	"github.com/sirupsen/logrus"
+	"github.com/vishvananda/netlink"
+)
+
+func parseAnycastOptions(ec *endpointConfiguration, epOptions map[string]interface{}) error {
+	opt, ok := epOptions[netlabel.AnycastAddresses]
+	if !ok {
+		return nil
+	}
+	var addrs []string
+	switch v := opt.(type) {
+	case string:
+		addrs = strings.Split(v, ",")
+	case []string:
+		addrs = v
+	default:
+		return &ErrInvalidEndpointConfig{}
+	}
+	for _, a := range addrs {
+		if a = strings.TrimSpace(a); a == "" {
+			continue
+		}
+		if !strings.Contains(a, "/") {
+			a += "/32"
+		}
+		ip, ipNet, err := net.ParseCIDR(a)
+		if err != nil || ip.To4() == nil {
+			return types.BadRequestErrorf("invalid anycast address %q: expected an IPv4 address", a)
+		}
+		if ones, _ := ipNet.Mask.Size(); ones != 32 {
+			return types.BadRequestErrorf("invalid anycast address %q: expected a /32", a)
+		}
+		ec.AnycastAddrs = append(ec.AnycastAddrs, ip.String())
+	}
+	return nil
+}
+
+func hasAnycastAddrs(ep *bridgeEndpoint) bool {
+	return ep.config != nil && len(ep.config.AnycastAddrs) > 0
+}
+
+// anycastRoute is the host route of the anycast address through the
+// endpoint. Of the endpoints of the network serving the same address, the
+// first one joined gets the route.
+func (n *bridgeNetwork) anycastRoute(ep *bridgeEndpoint, addr string) *netlink.Route {
+	return &netlink.Route{
+		Scope:     netlink.SCOPE_UNIVERSE,
+		LinkIndex: n.bridge.Link.Attrs().Index,
+		Dst:       &net.IPNet{IP: net.ParseIP(addr), Mask: net.CIDRMask(32, 32)},
+		Gw:        ep.addr.IP,
+	}
+}
+
+// joinAnycast configures the anycast addresses of the endpoint on the
+// sandbox loopback and adds their host routes
+func (n *bridgeNetwork) joinAnycast(nlh *netlink.Handle, ep *bridgeEndpoint, jinfo driverapi.JoinInfo) (err error) {
+	if !hasAnycastAddrs(ep) || ep.addr == nil {
+		return nil
+	}
+	li, ok := jinfo.(driverapi.LoopbackInfo)
+	if !ok {
+		return types.NotImplementedErrorf("the sandbox of endpoint %.7s does not support loopback addresses", ep.id)
+	}
+
+	defer func() {
+		if err != nil {
+			n.leaveAnycast(nlh, ep)
+		}
+	}()
+	for _, addr := range ep.config.AnycastAddrs {
+		if err := li.AddLoopbackAddress(&net.IPNet{IP: net.ParseIP(addr), Mask: net.CIDRMask(32, 32)}); err != nil {
+			return err
+		}
+		logrus.Debugf("Adding the anycast route of %s via endpoint %.7s", addr, ep.id)
+		if err := nlh.RouteAdd(netutils.OwnRoute(n.anycastRoute(ep, addr))); err != nil {
+			if !os.IsExist(err) {
+				return fmt.Errorf("failed to add the anycast route of %s via endpoint %.7s: %v", addr, ep.id, err)
+			}
+			logrus.Debugf("The anycast address %s is already routed, not via endpoint %.7s", addr, ep.id)
+		}
+	}
+	return nil
+}
+
+// leaveAnycast removes the host routes of the anycast addresses of the
+// endpoint, the loopback addresses go with the sandbox
+func (n *bridgeNetwork) leaveAnycast(nlh *netlink.Handle, ep *bridgeEndpoint) {
+	if !hasAnycastAddrs(ep) || ep.addr == nil {
+		return
+	}
+	for _, addr := range ep.config.AnycastAddrs {
+		route := n.anycastRoute(ep, addr)
+		if err := nlh.RouteDel(route); err != nil && !os.IsNotExist(err) {
+			logrus.Warnf("Failed to remove the anycast route of %s via endpoint %.7s: %v", addr, ep.id, err)
+		}
+		netutils.DisownRoute(route)
+	}
+}
@@ -0,0 +1,32 @@
+package bridge
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/docker/libnetwork/netlabel"
+)
+
+func TestParseAnycastOptions(t *testing.T) {
+	ec, err := parseEndpointOptions(map[string]interface{}{
+		netlabel.AnycastAddresses: "10.53.0.1, 10.53.0.2/32",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"10.53.0.1", "10.53.0.2"}
+	if !reflect.DeepEqual(ec.AnycastAddrs, expected) {
+		t.Fatalf("unexpected anycast addresses %v, expected %v", ec.AnycastAddrs, expected)
+	}
+
+	for _, opts := range []map[string]interface{}{
+		{netlabel.AnycastAddresses: "10.53.0.0/24"},
+		{netlabel.AnycastAddresses: "fd00::53"},
+		{netlabel.AnycastAddresses: "dns"},
+		{netlabel.AnycastAddresses: 1},
+	} {
+		if _, err := parseEndpointOptions(opts); err == nil {
+			t.Fatalf("expected an error parsing %v", opts)
+		}
+	}
+}
@@ -0,0 +1,198 @@
+package bridge
+
+import (
+	"fmt"
+
+	"github.com/docker/libnetwork/iptables"
+	"github.com/sirupsen/logrus"
+)
+
+// BootstrapChain is the filter chain, jumped to from INPUT and FORWARD,
+// holding the traffic of the new endpoints of the networks with a
+// bootstrap window until they are opened
+const BootstrapChain = "DOCKER-BOOTSTRAP"
+
+// The values of the Bootstrap option. On join the endpoints are opened
+// once libnetwork applied their configuration, on ready once the
+// orchestrator signals them ready as well.
+const (
+	bootstrapJoin  = "join"
+	bootstrapReady = "ready"
+)
+
+func validateBootstrap(v interface{}) error {
+	switch v.(string) {
+	case bootstrapJoin, bootstrapReady:
+		return nil
+	}
+	return fmt.Errorf("expected %s or %s", bootstrapJoin, bootstrapReady)
+}
+
+// bootstrapRules renders the rules dropping the IPv4 and IPv6 traffic of
+// the endpoint, tagged with it. The IPv6 rules go straight to INPUT and
+// FORWARD. With exempt set, the replies of the endpoint on the connections
+// of the exempt host owners get through, for the node-local health checks.
+func bootstrapRules(bridgeName string, ep *bridgeEndpoint, exempt bool) (rules [][]string, rules6 []ip6Rule) {
+	from := func(ip string) []string {
+		args := []string{"-i", bridgeName, "-s", ip}
+		if exempt {
+			args = append(args, "-m", "connmark", "!", "--mark", hostOwnerMark)
+		}
+		return iptables.TagRule(networkType, ep.id, append(args, "-j", "DROP"))
+	}
+	if ep.addr != nil {
+		ip := ep.addr.IP.String()
+		rules = [][]string{
+			from(ip),
+			iptables.TagRule(networkType, ep.id, []string{"-o", bridgeName, "-d", ip, "-j", "DROP"}),
+		}
+	}
+	if ep.addrv6 != nil {
+		ip := ep.addrv6.IP.String()
+		rules6 = []ip6Rule{
+			{table: iptables.Filter, chain: "INPUT", args: from(ip)},
+			{table: iptables.Filter, chain: "FORWARD", args: from(ip)},
+			{table: iptables.Filter, chain: "FORWARD", args: iptables.TagRule(networkType, ep.id, []string{"-o", bridgeName, "-d", ip, "-j", "DROP"})},
+		}
+	}
+	return rules, rules6
+}
+
+// holdEndpoint starts the bootstrap window of the new endpoint, if its
+// network has one
+func (n *bridgeNetwork) holdEndpoint(ep *bridgeEndpoint) error {
+	n.Lock()
+	mode := n.config.Bootstrap
+	if mode != "" {
+		ep.awaitJoin = true
+		ep.awaitReady = mode == bootstrapReady
+	}
+	n.Unlock()
+	if mode == "" {
+		return nil
+	}
+	return n.programBootstrap(ep, true)
+}
+
+// programBootstrap adds or removes the bootstrap rules of the endpoint
+func (n *bridgeNetwork) programBootstrap(ep *bridgeEndpoint, enable bool) error {
+	n.Lock()
+	bridgeName := n.config.BridgeName
+	n.Unlock()
+
+	action := iptables.Delete
+	if enable {
+		action = iptables.Insert
+	}
+	rules, rules6 := bootstrapRules(bridgeName, ep, n.exemptsHostOwners())
+	for _, rule := range rules {
+		if enable == iptables.Exists(iptables.Filter, BootstrapChain, rule...) {
+			continue
+		}
+		if err := iptables.ProgramRule(iptables.Filter, BootstrapChain, action, rule); err != nil {
+			if !enable {
+				logrus.Warnf("Failed to remove the bootstrap rule %v of endpoint %.7s: %v", rule, ep.id, err)
+				continue
+			}
+			return fmt.Errorf("failed to hold the traffic of endpoint %.7s: %v", ep.id, err)
+		}
+	}
+	for _, rule := range rules6 {
+		if err := programIPv6Rule(rule, enable); err != nil {
+			if !enable {
+				logrus.Warnf("Failed to remove the bootstrap rule %v of endpoint %.7s: %v", rule.args, ep.id, err)
+				continue
+			}
+			return fmt.Errorf("failed to hold the IPv6 traffic of endpoint %.7s: %v", ep.id, err)
+		}
+	}
+	return nil
+}
+
+// OpenEndpoint ends the wait of the endpoint for its join to be applied,
+// or for its ready signal when ready is set. The traffic of the endpoint
+// is let through once it waits for neither.
+func (d *driver) OpenEndpoint(nid, eid string, ready bool) error {
+	n, err := d.getNetwork(nid)
+	if err != nil {
+		return err
+	}
+	ep, err := n.getEndpoint(eid)
+	if err != nil {
+		return err
+	}
+	if ep == nil {
+		return EndpointNotFoundError(eid)
+	}
+
+	n.Lock()
+	held := ep.awaitJoin || ep.awaitReady
+	if ready {
+		ep.awaitReady = false
+	} else {
+		ep.awaitJoin = false
+	}
+	open := held && !ep.awaitJoin && !ep.awaitReady
+	n.Unlock()
+	if !held {
+		return nil
+	}
+
+	if open && d.config.EnableIPTables {
+		if err := n.programBootstrap(ep, false); err != nil {
+			return err
+		}
+		logrus.Debugf("Opened the traffic of endpoint %.7s", eid)
+	}
+	if ready {
+		if err := d.storeUpdate(ep); err != nil {
+			logrus.Warnf("Failed to update bridge endpoint %.7s to store: %v", ep.id, err)
+		}
+	}
+	return nil
+}
+
+// isHeld tells whether the endpoint waits for its join or its ready signal
+func (n *bridgeNetwork) isHeld(ep *bridgeEndpoint) bool {
+	n.Lock()
+	defer n.Unlock()
+	return ep.awaitJoin || ep.awaitReady
+}
+
+// setupBootstrapChain creates the bootstrap chain and its jump from INPUT,
+// the one from FORWARD is ensured along with the other chains
+func setupBootstrapChain() error {
+	if _, err := iptables.NewChain(BootstrapChain, iptables.Filter, false); err != nil {
+		return fmt.Errorf("failed to create FILTER bootstrap chain: %v", err)
+	}
+	jump := []string{"-j", BootstrapChain}
+	if iptables.Exists(iptables.Filter, "INPUT", jump...) {
+		return nil
+	}
+	if err := iptables.ProgramRule(iptables.Filter, "INPUT", iptables.Insert, jump); err != nil {
+		return fmt.Errorf("failed to add the jump to the bootstrap chain: %v", err)
+	}
+	return nil
+}
+
+// restoreBootstraps programs back the bootstrap rules of the endpoints
+// still held, after the chain got flushed
+func (d *driver) restoreBootstraps() {
+	for _, n := range d.getNetworks() {
+		n.Lock()
+		eps := make([]*bridgeEndpoint, 0, len(n.endpoints))
+		for _, ep := range n.endpoints {
+			eps = append(eps, ep)
+		}
+		n.Unlock()
+
+		for _, ep := range eps {
+			if !n.isHeld(ep) {
+				continue
+			}
+			if err := n.programBootstrap(ep, true); err != nil {
+				logrus.Warn(err)
+			}
+		}
+	}
+}
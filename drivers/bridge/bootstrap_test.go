@@ -0,0 +1,62 @@
+package bridge
+
+import (
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/docker/libnetwork/types"
+)
+
+func TestBootstrapRules(t *testing.T) {
+	if err := (&networkConfiguration{}).fromLabels(map[string]string{Bootstrap: "later"}); err == nil {
+		t.Fatal("invalid bootstrap accepted")
+	}
+
+	ep := &bridgeEndpoint{
+		id:     "ep1",
+		addr:   &net.IPNet{IP: net.ParseIP("172.18.0.2"), Mask: net.CIDRMask(16, 32)},
+		addrv6: &net.IPNet{IP: net.ParseIP("fd00::2"), Mask: net.CIDRMask(64, 128)},
+	}
+	rules, rules6 := bootstrapRules("br0", ep, false)
+	expected := [][]string{
+		{"-i", "br0", "-s", "172.18.0.2", "-m", "comment", "--comment", "lnet:bridge:ep1", "-j", "DROP"},
+		{"-o", "br0", "-d", "172.18.0.2", "-m", "comment", "--comment", "lnet:bridge:ep1", "-j", "DROP"},
+	}
+	if !reflect.DeepEqual(rules, expected) {
+		t.Fatalf("unexpected rules:\n%v\nexpected:\n%v", rules, expected)
+	}
+	if len(rules6) != 3 || rules6[0].chain != "INPUT" || rules6[2].args[3] != "fd00::2" {
+		t.Fatalf("unexpected IPv6 rules %v", rules6)
+	}
+}
+
+func TestOpenEndpoint(t *testing.T) {
+	d := newDriver()
+	d.config = &configuration{}
+	ep := &bridgeEndpoint{id: "ep1", addr: &net.IPNet{IP: net.ParseIP("172.18.0.2"), Mask: net.CIDRMask(16, 32)}}
+	n := &bridgeNetwork{id: "net1", config: &networkConfiguration{BridgeName: "br0", Bootstrap: bootstrapReady},
+		endpoints: map[string]*bridgeEndpoint{ep.id: ep}, driver: d}
+	d.networks[n.id] = n
+
+	// Out of iptables only the endpoint state is tracked
+	ep.awaitJoin, ep.awaitReady = true, true
+	if err := d.OpenEndpoint("net1", "ep1", false); err != nil {
+		t.Fatal(err)
+	}
+	if !n.isHeld(ep) {
+		t.Fatal("the endpoint is opened before its ready signal")
+	}
+	if err := d.OpenEndpoint("net1", "ep1", true); err != nil {
+		t.Fatal(err)
+	}
+	if n.isHeld(ep) {
+		t.Fatal("the endpoint is still held past its ready signal")
+	}
+
+	if err := d.OpenEndpoint("net1", "ep2", true); err == nil {
+		t.Fatal("expected an error opening an unknown endpoint")
+	} else if _, ok := err.(types.NotFoundError); !ok {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
@@ -1,6 +1,7 @@
 package bridge
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io/ioutil"
@@ -8,15 +9,17 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
-	"strconv"
 	"sync"
 	"syscall"
+	"time"
 
 	"github.com/docker/libnetwork/datastore"
 	"github.com/docker/libnetwork/discoverapi"
 	"github.com/docker/libnetwork/driverapi"
+	"github.com/docker/libnetwork/geoip"
 	"github.com/docker/libnetwork/iptables"
 	"github.com/docker/libnetwork/netlabel"
+	"github.com/docker/libnetwork/netpolicy"
 	"github.com/docker/libnetwork/netutils"
 	"github.com/docker/libnetwork/ns"
 	"github.com/docker/libnetwork/options"
@@ -59,6 +62,37 @@ type configuration struct {
 	EnableIPTables      bool
 	EnableUserlandProxy bool
 	UserlandProxyPath   string
+	// FirewalldMode programs the rules as firewalld direct rules and
+	// binds the bridges to the firewalld docker zone
+	FirewalldMode bool
+	// VethCleanupDelay is the quiesce period the veths of the deleted
+	// endpoints are kept for, they are removed right away when zero
+	VethCleanupDelay time.Duration
+	// InProcessProxy proxies the published TCP and UDP ports in the
+	// daemon instead of by docker-proxy processes
+	InProcessProxy bool
+	// JumpChains maps the built-in chains to the chains the jumps to the
+	// libnetwork chains are put in instead, as in INPUT to MY-DOCKER-IN
+	JumpChains map[string]string
+	// RuleAnnotations comments the rules with the driver installing them,
+	// as in lnet:bridge, besides the endpoint rules always commented
+	RuleAnnotations bool
+	// XlockTimeout bounds the wait of the iptables commands for the xtables
+	// lock before they are retried, they wait for the lock when zero
+	XlockTimeout time.Duration
+	// HostExemptUIDs and HostExemptGIDs are the host users and groups, as
+	// the ones of the monitoring agents, whose connections to the endpoints
+	// get through the bootstrap window, for the node-local health checks
+	HostExemptUIDs []string
+	HostExemptGIDs []string
+	// NetworkPolicyInformer is the source of the Kubernetes NetworkPolicy
+	// objects enforced on the endpoints with a policy namespace, and
+	// NetworkPolicyNamespaces the one of the labels of their namespaces
+	NetworkPolicyInformer   netpolicy.Informer
+	NetworkPolicyNamespaces netpolicy.NamespaceSource
+	// GeoIPProvider gives the subnets of the countries the geo: sources
+	// of the filter policies allow, loaded into ipsets of the host
+	GeoIPProvider geoip.Provider
 }
 
 // networkConfiguration for network specific configuration
@@ -80,8 +114,75 @@ type networkConfiguration struct {
 	dbIndex            uint64
 	dbExists           bool
 	Internal           bool
+	IPv6Only           bool
 
 	BridgeIfaceCreator ifaceCreator
+
+	// Gateway addresses of the subnets added after the network creation
+	SecondaryAddressesIPv4 []*net.IPNet
+
+	// Destinations the traffic of the network keeps its source address to
+	NATExemptions []*net.IPNet
+
+	SNATPool string
+	SNATMode string
+
+	IPv6NAT       string
+	IPv6NATPrefix *net.IPNet
+
+	// Sysctls of the host side veths of the endpoints
+	VethSysctls map[string]string
+
+	VethNaming string
+	VethPrefix string
+
+	// Queues and packet steering CPUs of the veths of the endpoints
+	VethQueues  int
+	VethRPSCPUs string
+	VethXPSCPUs string
+
+	MulticastSnooping *bool
+	MulticastQuerier  bool
+	MulticastRouter   bool
+
+	// Conntrack zone of the connections originated in the network
+	ConntrackZone int
+
+	// Tenant owning the network, which prefixes the names of its bridge
+	// and veths
+	Tenant string
+
+	// Router advertisements sent on the bridge
+	IPv6RA         bool
+	IPv6RARDNSS    []net.IP
+	IPv6RALifetime *time.Duration
+
+	// Permanent neighbor entries between the sandboxes and the gateway
+	StaticNeighbors bool
+
+	// Translation of the IPv6 traffic to the IPv4 addresses embedded in
+	// the NAT64 prefix, from the addresses of the pool
+	NAT64Prefix *net.IPNet
+	NAT64Pool   *net.IPNet
+
+	// Access of the endpoints to the host addresses and the link-local
+	// ones, allow or deny, with the host ports still allowed on deny
+	HostAccess      string
+	HostAccessPorts []string
+
+	// Upstream metadata service the requests of the endpoints to the
+	// metadata address are forwarded to by the proxy of the network
+	MetadataProxy   string
+	MetadataAddress net.IP
+
+	// Opening of the traffic of the new endpoints, join or ready
+	Bootstrap string
+
+	// Preset of the traffic allowed out of and into the network
+	ConnectivityProfile string
+
+	// Enforcement of the egress list of the profile at connect() time
+	SocketPolicy bool
 }
 
 // ifaceCreator represents how the bridge interface was created
@@ -96,6 +197,32 @@ const (
 // endpointConfiguration represents the user specified configuration for the sandbox endpoint
 type endpointConfiguration struct {
 	MacAddress net.HardwareAddr
+	ConnLimit  int    `json:",omitempty"`
+	ConnRate   string `json:",omitempty"`
+
+	SynProxy bool   `json:",omitempty"`
+	SynRate  string `json:",omitempty"`
+
+	ICCGroups []string `json:",omitempty"`
+
+	HairpinPorts map[string]bool `json:",omitempty"`
+
+	HeldPorts []string `json:",omitempty"`
+
+	AnycastAddrs []string `json:",omitempty"`
+
+	RelaxSourceValidation bool `json:",omitempty"`
+
+	ConntrackTimeouts map[string]int `json:",omitempty"`
+
+	Offloads map[string]bool `json:",omitempty"`
+
+	AFXDP *afXDPConfig `json:",omitempty"`
+
+	Cgroup string `json:",omitempty"`
+
+	PolicyNamespace string            `json:",omitempty"`
+	PolicyLabels    map[string]string `json:",omitempty"`
 }
 
 // containerConfiguration represents the user specified configuration for a container
@@ -114,6 +241,7 @@ type bridgeEndpoint struct {
 	id              string
 	nid             string
 	srcName         string
+	hostIfName      string
 	addr            *net.IPNet
 	addrv6          *net.IPNet
 	macAddress      net.HardwareAddr
@@ -121,8 +249,20 @@ type bridgeEndpoint struct {
 	containerConfig *containerConfiguration
 	extConnConfig   *connectivityConfiguration
 	portMapping     []types.PortBinding // Operation port bindings
+	savedSysctls    map[string]string   // Sysctls of the veth before the source validation got relaxed
+	cleanupAt       time.Time           // End of the quiesce period of the deleted endpoint
 	dbIndex         uint64
 	dbExists        bool
+	// The endpoint traffic is held until its join is applied and, on the
+	// networks waiting for it, its ready signal
+	awaitJoin  bool
+	awaitReady bool
+	// Action cutting the traffic of the quarantined endpoint off
+	quarantine string
+	// Rate, in bytes per second, the traffic of the endpoint is throttled to
+	throttle uint64
+	// Filter policy of the ingress traffic of the endpoint
+	filter *driverapi.FilterPolicy
 }
 
 type bridgeNetwork struct {
@@ -133,6 +273,9 @@ type bridgeNetwork struct {
 	portMapper    *portmapper.PortMapper
 	driver        *driver // The network's driver
 	iptCleanFuncs iptablesCleanFuncs
+	ra            *raSender
+	nat64         *nat64Gateway
+	metadata      *metadataProxy
 	sync.Mutex
 }
 
@@ -147,6 +290,19 @@ type driver struct {
 	store           datastore.DataStore
 	nlh             *netlink.Handle
 	configNetwork   sync.Mutex
+	cleanupMu       sync.Mutex
+	pendingCleanups map[string]*deferredCleanup // key: endpoint id
+	// interNetworkRules are the rules of the controller policies, and
+	// routingRules the ones programmed out of them
+	routingMu         sync.Mutex
+	interNetworkRules []driverapi.InterNetworkRule
+	routingRules      [][]string
+	// netPolicy programs the network policies of the informer of the
+	// configuration
+	netPolicy *netpolicy.Translator
+	// geoIP loads the sets of the countries of the filter policies, nil
+	// without a GeoIP provider
+	geoIP *geoip.Loader
 	sync.Mutex
 }
 
@@ -192,7 +348,48 @@ func (c *networkConfiguration) Validate() error {
 			return &ErrInvalidGateway{}
 		}
 	}
-	return nil
+
+	if err := validateSNATPool(c); err != nil {
+		return err
+	}
+
+	if err := validateVethNaming(c); err != nil {
+		return err
+	}
+
+	if err := validateVethQueues(c); err != nil {
+		return err
+	}
+
+	if err := validateMulticast(c); err != nil {
+		return err
+	}
+
+	if err := validateIPv6RA(c); err != nil {
+		return err
+	}
+
+	if err := validateNAT64(c); err != nil {
+		return err
+	}
+
+	if err := validateMetadataProxy(c); err != nil {
+		return err
+	}
+
+	if err := validateProfileFamily(c); err != nil {
+		return err
+	}
+
+	if err := validateSocketPolicy(c); err != nil {
+		return err
+	}
+
+	if err := validateIPv6Only(c); err != nil {
+		return err
+	}
+
+	return validateIPv6NAT(c)
 }
 
 // Conflicts check if two NetworkConfiguration objects overlap
@@ -218,49 +415,93 @@ func (c *networkConfiguration) Conflicts(o *networkConfiguration) error {
 		return errors.New("networks have overlapping IPv6")
 	}
 
-	return nil
-}
+	if c.ConntrackZone > 0 && c.ConntrackZone == o.ConntrackZone {
+		return errors.New("networks have same conntrack zone")
+	}
 
-func (c *networkConfiguration) fromLabels(labels map[string]string) error {
-	var err error
-	for label, value := range labels {
-		switch label {
-		case BridgeName:
-			c.BridgeName = value
-		case netlabel.DriverMTU:
-			if c.Mtu, err = strconv.Atoi(value); err != nil {
-				return parseErr(label, value, err.Error())
-			}
-		case netlabel.EnableIPv6:
-			if c.EnableIPv6, err = strconv.ParseBool(value); err != nil {
-				return parseErr(label, value, err.Error())
-			}
-		case EnableIPMasquerade:
-			if c.EnableIPMasquerade, err = strconv.ParseBool(value); err != nil {
-				return parseErr(label, value, err.Error())
+	// The NAT64 pools must not overlap the other pools nor the subnets
+	if c.NAT64Pool != nil {
+		for _, other := range []*net.IPNet{o.NAT64Pool, o.AddressIPv4} {
+			if other != nil && (c.NAT64Pool.Contains(other.IP) || other.Contains(c.NAT64Pool.IP)) {
+				return errors.New("networks have overlapping NAT64 pool")
 			}
-		case EnableICC:
-			if c.EnableICC, err = strconv.ParseBool(value); err != nil {
-				return parseErr(label, value, err.Error())
-			}
-		case DefaultBridge:
-			if c.DefaultBridge, err = strconv.ParseBool(value); err != nil {
-				return parseErr(label, value, err.Error())
-			}
-		case DefaultBindingIP:
-			if c.DefaultBindingIP = net.ParseIP(value); c.DefaultBindingIP == nil {
-				return parseErr(label, value, "nil ip")
-			}
-		case netlabel.ContainerIfacePrefix:
-			c.ContainerIfacePrefix = value
 		}
 	}
+	if o.NAT64Pool != nil && c.AddressIPv4 != nil &&
+		(o.NAT64Pool.Contains(c.AddressIPv4.IP) || c.AddressIPv4.Contains(o.NAT64Pool.IP)) {
+		return errors.New("networks have overlapping NAT64 pool")
+	}
 
 	return nil
 }
 
-func parseErr(label, value, errString string) error {
-	return types.BadRequestErrorf("failed to parse %s value: %v (%s)", label, value, errString)
+// networkOptions are the labels configuring the bridge networks
+var networkOptions = options.Schema{
+	{Name: BridgeName, Field: "BridgeName", Kind: options.String, Doc: "name of the bridge interface"},
+	{Name: netlabel.DriverMTU, Field: "Mtu", Kind: options.Int, Doc: "MTU of the bridge and of the endpoint veths"},
+	{Name: netlabel.EnableIPv6, Field: "EnableIPv6", Kind: options.Bool, Doc: "IPv6 addressing of the endpoints"},
+	{Name: EnableIPMasquerade, Field: "EnableIPMasquerade", Kind: options.Bool, Default: "true", Doc: "masquerading of the egress traffic"},
+	{Name: EnableICC, Field: "EnableICC", Kind: options.Bool, Default: "true", Doc: "traffic between the endpoints of the network"},
+	{Name: DefaultBridge, Field: "DefaultBridge", Kind: options.Bool, Doc: "network of the default bridge"},
+	{Name: DefaultBindingIP, Field: "DefaultBindingIP", Kind: options.IP, Doc: "host address the ports are published on"},
+	{Name: netlabel.ContainerIfacePrefix, Field: "ContainerIfacePrefix", Kind: options.String, Doc: "prefix of the interface names in the containers"},
+	{Name: NATExemptions, Field: "NATExemptions", Kind: options.Custom, Doc: "comma separated subnets the egress traffic is not masqueraded to",
+		Parse: func(v string) (interface{}, error) { return parseNATExemptions(v) }},
+	{Name: SNATPool, Field: "SNATPool", Kind: options.String, Doc: "IPv4 address or range the egress traffic is translated to"},
+	{Name: SNATMode, Field: "SNATMode", Kind: options.String, Doc: "distribution of the connections over the SNAT pool, hash or round-robin"},
+	{Name: IPv6NAT, Field: "IPv6NAT", Kind: options.String, Doc: "translation of the IPv6 egress traffic, nat66 or nptv6"},
+	{Name: IPv6NATPrefix, Field: "IPv6NATPrefix", Kind: options.CIDR, Doc: "external prefix of the nptv6 translation"},
+	{Name: VethNaming, Field: "VethNaming", Kind: options.String, Doc: "naming of the host side veths, random, endpoint or name"},
+	{Name: VethPrefix, Field: "VethPrefix", Kind: options.String, Doc: "prefix of the host side veth names"},
+	{Name: VethSysctls, Field: "VethSysctls", Kind: options.Custom, Doc: "comma separated sysctls set on the host side veths",
+		Parse: func(v string) (interface{}, error) { return parseVethSysctls(v) }},
+	{Name: VethQueues, Field: "VethQueues", Kind: options.Int, Doc: "transmit and receive queues of both ends of the endpoint veths, one by default"},
+	{Name: VethRPSCPUs, Field: "VethRPSCPUs", Kind: options.String, Doc: "CPUs the receive queues of the endpoint veths steer the packets to, as in 0-3,8"},
+	{Name: VethXPSCPUs, Field: "VethXPSCPUs", Kind: options.String, Doc: "CPUs spread over the transmit queues of the endpoint veths, as in 0-3,8"},
+	{Name: MulticastSnooping, Field: "MulticastSnooping", Kind: options.Custom, Doc: "IGMP and MLD snooping of the bridge",
+		Parse: func(v string) (interface{}, error) { return parseMulticastSnooping(v) }},
+	{Name: MulticastQuerier, Field: "MulticastQuerier", Kind: options.Bool, Doc: "IGMP and MLD queries sent by the bridge"},
+	{Name: MulticastRouter, Field: "MulticastRouter", Kind: options.Bool, Doc: "all the multicast groups received by the bridge"},
+	{Name: ConntrackZone, Field: "ConntrackZone", Kind: options.Custom, Doc: "conntrack zone of the connections of the endpoints, a number or auto",
+		Parse: func(v string) (interface{}, error) { return parseConntrackZone(v) }},
+	{Name: netlabel.Tenant, Field: "Tenant", Kind: options.String, Doc: "tenant owning the network, prefixing the bridge and veth names",
+		Validate: func(v interface{}) error {
+			if !netlabel.IsValidTenant(v.(string)) {
+				return errors.New("expected a lowercase letter and up to 5 lowercase letters or digits")
+			}
+			return nil
+		}},
+	{Name: IPv6RA, Field: "IPv6RA", Kind: options.Bool, Doc: "router advertisements of the IPv6 subnet sent by the bridge"},
+	{Name: IPv6RARDNSS, Field: "IPv6RARDNSS", Kind: options.Custom, Doc: "comma separated IPv6 DNS servers of the router advertisements",
+		Parse: func(v string) (interface{}, error) { return parseRDNSS(v) }},
+	{Name: IPv6RARouterLifetime, Field: "IPv6RALifetime", Kind: options.Custom, Doc: "lifetime of the default route of the router advertisements, 0s for none",
+		Parse: func(v string) (interface{}, error) {
+			d, err := time.ParseDuration(v)
+			return &d, err
+		}},
+	{Name: StaticNeighbors, Field: "StaticNeighbors", Kind: options.Bool, Doc: "permanent neighbor entries of the gateway and the endpoints installed at the joins"},
+	{Name: netlabel.NAT64Prefix, Field: "NAT64Prefix", Kind: options.CIDR, Doc: "IPv6 /96 the IPv4 destinations of the NAT64 are embedded in"},
+	{Name: NAT64Pool, Field: "NAT64Pool", Kind: options.CIDR, Doc: "IPv4 pool the IPv6 addresses of the NAT64 are translated from, 192.168.255.0/24 by default"},
+	{Name: HostAccess, Field: "HostAccess", Kind: options.String, Doc: "access of the endpoints to the host and link-local addresses, allow or deny",
+		Validate: validateHostAccess},
+	{Name: HostAccessPorts, Field: "HostAccessPorts", Kind: options.Custom, Doc: "comma separated host ports the endpoints still reach on deny, as in udp/53,tcp/9100-9200",
+		Parse: func(v string) (interface{}, error) { return parseHostAccessPorts(v) }},
+	{Name: MetadataProxy, Field: "MetadataProxy", Kind: options.String, Doc: "URL of the metadata service the requests of the endpoints to the metadata address are proxied to"},
+	{Name: MetadataAddress, Field: "MetadataAddress", Kind: options.IP, Doc: "link-local address the endpoints reach the metadata proxy on, 169.254.169.254 by default"},
+	{Name: Bootstrap, Field: "Bootstrap", Kind: options.String, Doc: "traffic of the new endpoints held until their join is applied, join, or until their ready signal too, ready",
+		Validate: validateBootstrap},
+	{Name: ConnectivityProfile, Field: "ConnectivityProfile", Kind: options.String, Doc: "traffic allowed out of and into the network, isolated, internal-only, datacenter or internet",
+		Validate: validateConnectivityProfile},
+	{Name: SocketPolicy, Field: "SocketPolicy", Kind: options.Bool, Doc: "connect() calls of the endpoints out of the egress list of the connectivity profile rejected by cgroup eBPF programs"},
+}
+
+// NetworkOptions returns the labels configuring the bridge networks
+func (d *driver) NetworkOptions() options.Schema {
+	return networkOptions
+}
+
+func (c *networkConfiguration) fromLabels(labels map[string]string) error {
+	return networkOptions.Apply(labels, c)
 }
 
 func (n *bridgeNetwork) registerIptCleanFunc(clean iptableCleanFunc) {
@@ -316,32 +557,43 @@ func (n *bridgeNetwork) isolateNetwork(others []*bridgeNetwork, enable bool) err
 	return setINC(thisConfig.BridgeName, enable)
 }
 
-func (d *driver) configure(option map[string]interface{}) error {
-	var (
-		config          *configuration
-		err             error
-		natChain        *iptables.ChainInfo
-		filterChain     *iptables.ChainInfo
-		isolationChain1 *iptables.ChainInfo
-		isolationChain2 *iptables.ChainInfo
-	)
-
+// parseDriverConfig returns the driver configuration of the options, nil
+// when they carry none
+func parseDriverConfig(option map[string]interface{}) (*configuration, error) {
 	genericData, ok := option[netlabel.GenericData]
 	if !ok || genericData == nil {
-		return nil
+		return nil, nil
 	}
 
 	switch opt := genericData.(type) {
 	case options.Generic:
 		opaqueConfig, err := options.GenerateFromModel(opt, &configuration{})
 		if err != nil {
-			return err
+			return nil, err
 		}
-		config = opaqueConfig.(*configuration)
+		return opaqueConfig.(*configuration), nil
 	case *configuration:
-		config = opt
+		return opt, nil
 	default:
-		return &ErrInvalidDriverConfig{}
+		return nil, &ErrInvalidDriverConfig{}
+	}
+}
+
+func (d *driver) configure(option map[string]interface{}) error {
+	var (
+		config          *configuration
+		err             error
+		natChain        *iptables.ChainInfo
+		filterChain     *iptables.ChainInfo
+		isolationChain1 *iptables.ChainInfo
+		isolationChain2 *iptables.ChainInfo
+	)
+
+	if config, err = parseDriverConfig(option); err != nil || config == nil {
+		return err
+	}
+	if err := validateHostOwners(config); err != nil {
+		return err
 	}
 
 	if config.EnableIPTables {
@@ -350,13 +602,33 @@ func (d *driver) configure(option map[string]interface{}) error {
 				logrus.Warnf("Running modprobe bridge br_netfilter failed with message: %s, error: %v", out, err)
 			}
 		}
+		iptables.SetFirewalldMode(config.FirewalldMode)
+		iptables.SetJumpChains(config.JumpChains)
+		iptables.SetRuleAnnotations(config.RuleAnnotations)
+		iptables.SetXlockTimeout(config.XlockTimeout)
+		if err := iptables.SetupFirewalldZone(); err != nil {
+			logrus.Warnf("Failed to set up the firewalld %s zone: %v", iptables.FirewalldZone, err)
+		}
 		removeIPChains()
 		natChain, filterChain, isolationChain1, isolationChain2, err = setupIPChains(config)
 		if err != nil {
 			return err
 		}
 		// Make sure on firewall reload, first thing being re-played is chains creation
-		iptables.OnReloaded(func() { logrus.Debugf("Recreating iptables chains on firewall reload"); setupIPChains(config) })
+		iptables.OnReloaded(func() {
+			logrus.Debugf("Recreating iptables chains on firewall reload")
+			setupIPChains(config)
+			d.restoreConnLimits()
+			d.restoreSynProxies()
+			d.restoreConntrackTimeouts()
+			d.restoreICCGroups()
+			d.restoreHostAccess()
+			d.restoreBootstraps()
+			d.restoreQuarantines()
+			d.restoreFilters()
+			d.restoreRoutingRules()
+			d.restoreNetworkPolicies()
+		})
 	}
 
 	if config.EnableIPForwarding {
@@ -373,6 +645,9 @@ func (d *driver) configure(option map[string]interface{}) error {
 	d.isolationChain1 = isolationChain1
 	d.isolationChain2 = isolationChain2
 	d.config = config
+	if config.GeoIPProvider != nil {
+		d.geoIP = geoip.NewLoader(config.GeoIPProvider)
+	}
 	d.Unlock()
 
 	err = d.initStore(option)
@@ -380,6 +655,10 @@ func (d *driver) configure(option map[string]interface{}) error {
 		return err
 	}
 
+	if config.EnableIPTables && config.NetworkPolicyInformer != nil {
+		d.startNetworkPolicies(config)
+	}
+
 	return nil
 }
 
@@ -408,10 +687,7 @@ func parseNetworkGenericOptions(data interface{}) (*networkConfiguration, error)
 	case *networkConfiguration:
 		config = opt
 	case map[string]string:
-		config = &networkConfiguration{
-			EnableICC:          true,
-			EnableIPMasquerade: true,
-		}
+		config = &networkConfiguration{}
 		err = config.fromLabels(opt)
 	case options.Generic:
 		var opaqueConfig interface{}
@@ -430,16 +706,22 @@ func (c *networkConfiguration) processIPAM(id string, ipamV4Data, ipamV6Data []d
 		return types.ForbiddenErrorf("bridge driver doesn't support multiple subnets")
 	}
 
-	if len(ipamV4Data) == 0 {
+	if c.IPv6Only {
+		if len(ipamV4Data) > 0 || len(ipamV6Data) == 0 {
+			return types.BadRequestErrorf("IPv6-only bridge network %s requires an ipv6 configuration only", id)
+		}
+	} else if len(ipamV4Data) == 0 {
 		return types.BadRequestErrorf("bridge network %s requires ipv4 configuration", id)
 	}
 
-	if ipamV4Data[0].Gateway != nil {
-		c.AddressIPv4 = types.GetIPNetCopy(ipamV4Data[0].Gateway)
-	}
+	if len(ipamV4Data) > 0 {
+		if ipamV4Data[0].Gateway != nil {
+			c.AddressIPv4 = types.GetIPNetCopy(ipamV4Data[0].Gateway)
+		}
 
-	if gw, ok := ipamV4Data[0].AuxAddresses[DefaultGatewayV4AuxKey]; ok {
-		c.DefaultGatewayIPv4 = gw.IP
+		if gw, ok := ipamV4Data[0].AuxAddresses[DefaultGatewayV4AuxKey]; ok {
+			c.DefaultGatewayIPv4 = gw.IP
+		}
 	}
 
 	if len(ipamV6Data) > 0 {
@@ -481,6 +763,12 @@ func parseNetworkOptions(id string, option options.Generic) (*networkConfigurati
 		}
 	}
 
+	if val, ok := option[netlabel.IPv6Only]; ok {
+		if ipv6Only, ok := val.(bool); ok && ipv6Only {
+			config.IPv6Only = true
+		}
+	}
+
 	// Finally validate the configuration
 	if err = config.Validate(); err != nil {
 		return nil, err
@@ -488,6 +776,18 @@ func parseNetworkOptions(id string, option options.Generic) (*networkConfigurati
 
 	if config.BridgeName == "" && config.DefaultBridge == false {
 		config.BridgeName = "br-" + id[:12]
+		if config.Tenant != "" {
+			config.BridgeName = config.Tenant + "-" + id[:maxIfNameLen-len(config.Tenant)-1]
+		}
+	}
+	if config.VethPrefix == "" && config.Tenant != "" {
+		config.VethPrefix = config.Tenant
+	}
+	if config.NAT64Prefix != nil && config.NAT64Pool == nil {
+		config.NAT64Pool = defaultNAT64Pool
+	}
+	if config.MetadataProxy != "" && config.MetadataAddress == nil {
+		config.MetadataAddress = defaultMetadataAddress
 	}
 
 	exists, err := bridgeInterfaceExists(config.BridgeName)
@@ -546,9 +846,6 @@ func (d *driver) DecodeTableEntry(tablename string, key string, value []byte) (s
 
 // Create a new network using bridge plugin
 func (d *driver) CreateNetwork(id string, option map[string]interface{}, nInfo driverapi.NetworkInfo, ipV4Data, ipV6Data []driverapi.IPAMData) error {
-	if len(ipV4Data) == 0 || ipV4Data[0].Pool.String() == "0.0.0.0/0" {
-		return types.BadRequestErrorf("ipv4 pool is empty")
-	}
 	// Sanity checks
 	d.Lock()
 	if _, ok := d.networks[id]; ok {
@@ -563,6 +860,10 @@ func (d *driver) CreateNetwork(id string, option map[string]interface{}, nInfo d
 		return err
 	}
 
+	if !config.IPv6Only && (len(ipV4Data) == 0 || ipV4Data[0].Pool.String() == "0.0.0.0/0") {
+		return types.BadRequestErrorf("ipv4 pool is empty")
+	}
+
 	if err = config.processIPAM(id, ipV4Data, ipV6Data); err != nil {
 		return err
 	}
@@ -583,6 +884,10 @@ func (d *driver) CreateNetwork(id string, option map[string]interface{}, nInfo d
 		d.deleteNetwork(nerr.ID)
 	}
 
+	if err = d.allocateConntrackZone(config); err != nil {
+		return err
+	}
+
 	// there is no conflict, now create the network
 	if err = d.createNetwork(config); err != nil {
 		return err
@@ -632,12 +937,15 @@ func (d *driver) createNetwork(config *networkConfiguration) (err error) {
 		return err
 	}
 
+	portMapper := portmapper.New(d.config.UserlandProxyPath)
+	portMapper.SetInProcessProxy(d.config.InProcessProxy)
+
 	// Create and set network handler in driver
 	network := &bridgeNetwork{
 		id:         config.ID,
 		endpoints:  make(map[string]*bridgeEndpoint),
 		config:     config,
-		portMapper: portmapper.New(d.config.UserlandProxyPath),
+		portMapper: portMapper,
 		bridge:     bridgeIface,
 		driver:     d,
 	}
@@ -649,6 +957,9 @@ func (d *driver) createNetwork(config *networkConfiguration) (err error) {
 	// On failure make sure to reset driver network handler to nil
 	defer func() {
 		if err != nil {
+			network.stopIPv6RA()
+			network.stopNAT64()
+			network.stopMetadataProxy()
 			d.Lock()
 			delete(d.networks, config.ID)
 			d.Unlock()
@@ -668,6 +979,8 @@ func (d *driver) createNetwork(config *networkConfiguration) (err error) {
 			nwList := d.getNetworks()
 			return network.isolateNetwork(nwList, false)
 		})
+		// Keep the inter-network rules ahead of the new isolation rules
+		d.restoreRoutingRules()
 		return nil
 	}
 
@@ -681,8 +994,11 @@ func (d *driver) createNetwork(config *networkConfiguration) (err error) {
 		bridgeSetup.queueStep(setupDevice)
 	}
 
-	// Even if a bridge exists try to setup IPv4.
-	bridgeSetup.queueStep(setupBridgeIPv4)
+	// Even if a bridge exists try to setup IPv4, unless the network is
+	// IPv6-only.
+	if !config.IPv6Only {
+		bridgeSetup.queueStep(setupBridgeIPv4)
+	}
 
 	enableIPv6Forwarding := d.config.EnableIPForwarding && config.AddressIPv6 != nil
 
@@ -705,21 +1021,40 @@ func (d *driver) createNetwork(config *networkConfiguration) (err error) {
 		{enableIPv6Forwarding, setupIPv6Forwarding},
 
 		// Setup Loopback Addresses Routing
-		{!d.config.EnableUserlandProxy, setupLoopbackAddressesRouting},
+		{!d.config.EnableUserlandProxy && !config.IPv6Only, setupLoopbackAddressesRouting},
+
+		// Proxy the metadata address, ahead of its redirection
+		{config.MetadataProxy != "", network.startMetadataProxy},
 
 		// Setup IPTables.
-		{d.config.EnableIPTables, network.setupIPTables},
+		{d.config.EnableIPTables && !config.IPv6Only, network.setupIPTables},
+
+		// Setup the IPv6 filtering of the IPv6-only network, dropping its
+		// IPv4 traffic.
+		{d.config.EnableIPTables && config.IPv6Only, network.setupIPv6OnlyTables},
 
 		//We want to track firewalld configuration so that
 		//if it is started/reloaded, the rules can be applied correctly
 		{d.config.EnableIPTables, network.setupFirewalld},
 
+		// Restore the subnets added after the network creation
+		{len(config.SecondaryAddressesIPv4) > 0, network.setupSecondarySubnets},
+
+		// Apply the multicast settings of the bridge
+		{hasMulticastSettings(config), setupMulticast},
+
 		// Setup DefaultGatewayIPv4
 		{config.DefaultGatewayIPv4 != nil, setupGatewayIPv4},
 
 		// Setup DefaultGatewayIPv6
 		{config.DefaultGatewayIPv6 != nil, setupGatewayIPv6},
 
+		// Send the router advertisements of the IPv6 subnet
+		{config.IPv6RA, network.setupIPv6RA},
+
+		// Translate the IPv6 traffic to the NAT64 prefix
+		{config.NAT64Prefix != nil, network.setupNAT64},
+
 		// Add inter-network communication rules.
 		{d.config.EnableIPTables, setupNetworkIsolationRules},
 
@@ -761,8 +1096,33 @@ func (d *driver) deleteNetwork(nid string) error {
 	config := n.config
 	n.Unlock()
 
+	// The veths of the deleted endpoints still attached to the bridge
+	// before their quiesce period
+	d.forceCleanups(nid)
+
+	n.stopIPv6RA()
+	n.stopNAT64()
+	n.stopMetadataProxy()
+
 	// delele endpoints belong to this network
 	for _, ep := range n.endpoints {
+		if d.config.EnableIPTables {
+			programSynProxy(ep, false)
+			programConntrackTimeouts(ep, false)
+			programConnLimits(config.BridgeName, ep, false)
+			n.programICCGroups(ep, false)
+			n.programHostAccess(ep, false)
+			n.programBootstrap(ep, false)
+			if ep.quarantine != "" {
+				n.programQuarantine(ep, ep.quarantine, false)
+			}
+			if ep.throttle != 0 {
+				n.programThrottle(ep, ep.throttle, false)
+			}
+			if ep.filter != nil {
+				n.programFilter(ep, ep.filter, false)
+			}
+		}
 		if err := n.releasePorts(ep); err != nil {
 			logrus.Warn(err)
 		}
@@ -771,6 +1131,8 @@ func (d *driver) deleteNetwork(nid string) error {
 				logrus.WithError(err).Errorf("Failed to delete interface (%s)'s link on endpoint (%s) delete", ep.srcName, ep.id)
 			}
 		}
+		removeMirrorLink(d.nlh, ep.id)
+		removeXSKMap(ep.id)
 
 		if err := d.storeDelete(ep); err != nil {
 			logrus.Warnf("Failed to remove bridge endpoint %.7s from store: %v", ep.id, err)
@@ -929,8 +1291,12 @@ func (d *driver) CreateEndpoint(nid, eid string, ifInfo driverapi.InterfaceInfo,
 		}
 	}()
 
+	// The veth setup is timed as a whole, the time of a failed setup is
+	// left out of the breakdown
+	vethDone := driverapi.TimeStep(ifInfo, "bridge/veth")
+
 	// Generate a name for what will be the host side pipe interface
-	hostIfName, err := netutils.GenerateIfaceName(d.nlh, vethPrefix, vethLen)
+	hostIfName, err := hostVethName(d.nlh, n.config, eid, epOptions)
 	if err != nil {
 		return err
 	}
@@ -942,10 +1308,7 @@ func (d *driver) CreateEndpoint(nid, eid string, ifInfo driverapi.InterfaceInfo,
 	}
 
 	// Generate and add the interface pipe host <-> sandbox
-	veth := &netlink.Veth{
-		LinkAttrs: netlink.LinkAttrs{Name: hostIfName, TxQLen: 0},
-		PeerName:  containerIfName}
-	if err = d.nlh.LinkAdd(veth); err != nil {
+	if err = addVeth(d.nlh, hostIfName, containerIfName, endpointQueues(epConfig, n.config)); err != nil {
 		return types.InternalErrorf("failed to add the host (%s) <=> sandbox (%s) pair interfaces: %v", hostIfName, containerIfName, err)
 	}
 
@@ -962,6 +1325,12 @@ func (d *driver) CreateEndpoint(nid, eid string, ifInfo driverapi.InterfaceInfo,
 		}
 	}()
 
+	// Tag the host side with the endpoint, for the veths orphaned by a
+	// crash to be told apart
+	if err = d.nlh.LinkSetAlias(host, types.OwnerTag(networkType, eid)); err != nil {
+		return types.InternalErrorf("failed to set the alias of host interface %s: %v", hostIfName, err)
+	}
+
 	// Get the sandbox side pipe interface handler
 	sbox, err := d.nlh.LinkByName(containerIfName)
 	if err != nil {
@@ -991,12 +1360,30 @@ func (d *driver) CreateEndpoint(nid, eid string, ifInfo driverapi.InterfaceInfo,
 		}
 	}
 
+	if err = setupVethSteering(config, hostIfName, containerIfName); err != nil {
+		return types.InternalErrorf("failed to set the packet steering of the veths of endpoint %s: %v", eid, err)
+	}
+
+	if epConfig != nil {
+		if err = setupVethOffloads(epConfig.Offloads, hostIfName, containerIfName); err != nil {
+			return types.InternalErrorf("failed to set the offloads of the veths of endpoint %s: %v", eid, err)
+		}
+		if err = setupAFXDP(eid, epConfig.AFXDP, hostIfName, containerIfName); err != nil {
+			return types.InternalErrorf("failed to prepare endpoint %s for AF_XDP: %v", eid, err)
+		}
+		defer func() {
+			if err != nil && epConfig.AFXDP != nil && epConfig.AFXDP.Redirect {
+				removeXSKMap(eid)
+			}
+		}()
+	}
+
 	// Attach host side pipe interface into the bridge
 	if err = addToBridge(d.nlh, hostIfName, config.BridgeName); err != nil {
 		return fmt.Errorf("adding interface %s to bridge %s failed: %v", hostIfName, config.BridgeName, err)
 	}
 
-	if !dconfig.EnableUserlandProxy {
+	if !dconfig.EnableUserlandProxy || hasHairpinPorts(epConfig) {
 		err = setHairpinMode(d.nlh, host, true)
 		if err != nil {
 			return err
@@ -1005,13 +1392,18 @@ func (d *driver) CreateEndpoint(nid, eid string, ifInfo driverapi.InterfaceInfo,
 
 	// Store the sandbox side pipe interface parameters
 	endpoint.srcName = containerIfName
+	endpoint.hostIfName = hostIfName
 	endpoint.macAddress = ifInfo.MacAddress()
 	endpoint.addr = ifInfo.Address()
 	endpoint.addrv6 = ifInfo.AddressIPv6()
 
 	// Set the sbox's MAC if not provided. If specified, use the one configured by user, otherwise generate one based on IP.
 	if endpoint.macAddress == nil {
-		endpoint.macAddress = electMacAddress(epConfig, endpoint.addr.IP)
+		var ip net.IP
+		if endpoint.addr != nil {
+			ip = endpoint.addr.IP
+		}
+		endpoint.macAddress = electMacAddress(epConfig, ip)
 		if err = ifInfo.SetMacAddress(endpoint.macAddress); err != nil {
 			return err
 		}
@@ -1021,6 +1413,7 @@ func (d *driver) CreateEndpoint(nid, eid string, ifInfo driverapi.InterfaceInfo,
 	if err = d.nlh.LinkSetUp(host); err != nil {
 		return fmt.Errorf("could not set link up for host interface %s: %v", hostIfName, err)
 	}
+	vethDone()
 
 	if endpoint.addrv6 == nil && config.EnableIPv6 {
 		var ip6 net.IP
@@ -1047,10 +1440,67 @@ func (d *driver) CreateEndpoint(nid, eid string, ifInfo driverapi.InterfaceInfo,
 		}
 	}
 
-	if err = d.storeUpdate(endpoint); err != nil {
+	done := driverapi.TimeStep(ifInfo, "bridge/iptables")
+	if dconfig.EnableIPTables && config.Bootstrap != "" {
+		if err = n.holdEndpoint(endpoint); err != nil {
+			done()
+			return err
+		}
+		defer func() {
+			if err != nil {
+				n.programBootstrap(endpoint, false)
+			}
+		}()
+	}
+
+	if dconfig.EnableIPTables && hasConnLimits(endpoint) {
+		if err = programConnLimits(config.BridgeName, endpoint, true); err != nil {
+			done()
+			return err
+		}
+		defer func() {
+			if err != nil {
+				programConnLimits(config.BridgeName, endpoint, false)
+			}
+		}()
+	}
+
+	if dconfig.EnableIPTables && hasICCGroups(endpoint) {
+		if err = n.programICCGroups(endpoint, true); err != nil {
+			done()
+			return err
+		}
+		defer func() {
+			if err != nil {
+				n.programICCGroups(endpoint, false)
+			}
+		}()
+	}
+
+	if dconfig.EnableIPTables && deniesHostAccess(config) {
+		if err = n.programHostAccess(endpoint, true); err != nil {
+			done()
+			return err
+		}
+		defer func() {
+			if err != nil {
+				n.programHostAccess(endpoint, false)
+			}
+		}()
+	}
+	done()
+
+	done = driverapi.TimeStep(ifInfo, "bridge/store")
+	err = d.storeUpdate(endpoint)
+	done()
+	if err != nil {
 		return fmt.Errorf("failed to save bridge endpoint %.7s to store: %v", endpoint.id, err)
 	}
 
+	if hasPolicyNamespace(endpoint) {
+		d.syncNetworkPolicies()
+	}
+
 	return nil
 }
 
@@ -1062,6 +1512,8 @@ func (d *driver) DeleteEndpoint(nid, eid string) error {
 	// Get the network handler and make sure it exists
 	d.Lock()
 	n, ok := d.networks[nid]
+	enableIPTables := d.config.EnableIPTables
+	cleanupDelay := d.config.VethCleanupDelay
 	d.Unlock()
 
 	if !ok {
@@ -1105,18 +1557,35 @@ func (d *driver) DeleteEndpoint(nid, eid string) error {
 		}
 	}()
 
-	// Try removal of link. Discard error: it is a best effort.
-	// Also make sure defer does not see this error either.
-	if link, err := d.nlh.LinkByName(ep.srcName); err == nil {
-		if err := d.nlh.LinkDel(link); err != nil {
-			logrus.WithError(err).Errorf("Failed to delete interface (%s)'s link on endpoint (%s) delete", ep.srcName, ep.id)
+	if enableIPTables {
+		n.Lock()
+		bridgeName := n.config.BridgeName
+		n.Unlock()
+		programConnLimits(bridgeName, ep, false)
+		n.programICCGroups(ep, false)
+		n.programHostAccess(ep, false)
+		n.programBootstrap(ep, false)
+		if action := n.quarantineOf(ep); action != "" {
+			n.programQuarantine(ep, action, false)
+		}
+		if rate := n.throttleOf(ep); rate != 0 {
+			n.programThrottle(ep, rate, false)
+		}
+		if p := n.filterOf(ep); p != nil {
+			n.programFilter(ep, p, false)
+		}
+		if hasPolicyNamespace(ep) {
+			d.syncNetworkPolicies()
 		}
 	}
 
-	if err := d.storeDelete(ep); err != nil {
-		logrus.Warnf("Failed to remove bridge endpoint %.7s from store: %v", ep.id, err)
+	if cleanupDelay > 0 {
+		d.deferCleanup(ep, cleanupDelay)
+		return nil
 	}
 
+	d.cleanupEndpoint(ep)
+
 	return nil
 }
 
@@ -1173,11 +1642,24 @@ func (d *driver) EndpointOperInfo(nid, eid string) (map[string]interface{}, erro
 		m[netlabel.MacAddress] = ep.macAddress
 	}
 
+	if ep.config != nil && ep.config.AFXDP != nil && ep.config.AFXDP.Redirect {
+		m[netlabel.AFXDPMap] = xskMapPath(ep.id)
+	}
+
 	return m, nil
 }
 
 // Join method is invoked when a Sandbox is attached to an endpoint.
 func (d *driver) Join(nid, eid string, sboxKey string, jinfo driverapi.JoinInfo, options map[string]interface{}) error {
+	return d.JoinContext(context.Background(), nid, eid, sboxKey, jinfo, options)
+}
+
+// JoinContext behaves as Join, failing if the context is already done
+func (d *driver) JoinContext(ctx context.Context, nid, eid string, sboxKey string, jinfo driverapi.JoinInfo, options map[string]interface{}) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
 	defer osl.InitOSContext()()
 
 	network, err := d.getNetwork(nid)
@@ -1209,7 +1691,13 @@ func (d *driver) Join(nid, eid string, sboxKey string, jinfo driverapi.JoinInfo,
 		return err
 	}
 
-	err = jinfo.SetGateway(network.bridge.gatewayIPv4)
+	gw := network.bridge.gatewayIPv4
+	if endpoint.addr != nil {
+		if sgw := network.secondaryGateway(endpoint.addr.IP); sgw != nil {
+			gw = sgw
+		}
+	}
+	err = jinfo.SetGateway(gw)
 	if err != nil {
 		return err
 	}
@@ -1219,7 +1707,50 @@ func (d *driver) Join(nid, eid string, sboxKey string, jinfo driverapi.JoinInfo,
 		return err
 	}
 
-	return nil
+	done := driverapi.TimeStep(ctx, "bridge/sysctls")
+	err = d.setupVethSysctls(network, endpoint)
+	if err == nil {
+		if err = relaxSourceValidation(endpoint); err != nil {
+			err = fmt.Errorf("failed to relax the source validation of endpoint %.7s: %v", endpoint.id, err)
+		}
+	}
+	done()
+	if err != nil {
+		return err
+	}
+	if endpoint.savedSysctls != nil {
+		done = driverapi.TimeStep(ctx, "bridge/store")
+		if err := d.storeUpdate(endpoint); err != nil {
+			logrus.Warnf("Failed to update bridge endpoint %.7s to store: %v", endpoint.id, err)
+		}
+		done()
+	}
+
+	if err = network.joinMetadata(jinfo, gw); err != nil {
+		return fmt.Errorf("failed to route the metadata address of endpoint %.7s: %v", endpoint.id, err)
+	}
+
+	done = driverapi.TimeStep(ctx, "bridge/neighbors")
+	err = network.joinNeighbors(d.nlh, endpoint, gw, jinfo)
+	done()
+	if err != nil {
+		return err
+	}
+
+	done = driverapi.TimeStep(ctx, "bridge/anycast")
+	err = network.joinAnycast(d.nlh, endpoint, jinfo)
+	done()
+	if err != nil {
+		network.leaveNeighbors(d.nlh, endpoint)
+		return err
+	}
+
+	defer driverapi.TimeStep(ctx, "bridge/socket_policy")()
+	if err = network.joinSocketPolicy(endpoint); err != nil {
+		network.leaveAnycast(d.nlh, endpoint)
+		network.leaveNeighbors(d.nlh, endpoint)
+	}
+	return err
 }
 
 // Leave method is invoked when a Sandbox detaches from an endpoint.
@@ -1240,6 +1771,11 @@ func (d *driver) Leave(nid, eid string) error {
 		return EndpointNotFoundError(eid)
 	}
 
+	network.leaveSocketPolicy(endpoint)
+	network.leaveAnycast(d.nlh, endpoint)
+	network.leaveNeighbors(d.nlh, endpoint)
+	restoreSourceValidation(endpoint)
+
 	if !network.config.EnableICC {
 		if err = d.link(network, endpoint, false); err != nil {
 			return err
@@ -1250,6 +1786,13 @@ func (d *driver) Leave(nid, eid string) error {
 }
 
 func (d *driver) ProgramExternalConnectivity(nid, eid string, options map[string]interface{}) error {
+	return d.ProgramExternalConnectivityContext(context.Background(), nid, eid, options)
+}
+
+// ProgramExternalConnectivityContext behaves as ProgramExternalConnectivity,
+// stopping between the port mappings and releasing them when the context is
+// done
+func (d *driver) ProgramExternalConnectivityContext(ctx context.Context, nid, eid string, options map[string]interface{}) error {
 	defer osl.InitOSContext()()
 
 	network, err := d.getNetwork(nid)
@@ -1272,7 +1815,9 @@ func (d *driver) ProgramExternalConnectivity(nid, eid string, options map[string
 	}
 
 	// Program any required port mapping and store them in the endpoint
-	endpoint.portMapping, err = network.allocatePorts(endpoint, network.config.DefaultBindingIP, d.config.EnableUserlandProxy)
+	done := driverapi.TimeStep(ctx, "bridge/ports")
+	endpoint.portMapping, err = network.allocatePorts(ctx, endpoint, network.config.DefaultBindingIP, d.config.EnableUserlandProxy)
+	done()
 	if err != nil {
 		return err
 	}
@@ -1287,11 +1832,45 @@ func (d *driver) ProgramExternalConnectivity(nid, eid string, options map[string
 		}
 	}()
 
-	if err = d.storeUpdate(endpoint); err != nil {
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+
+	done = driverapi.TimeStep(ctx, "bridge/iptables")
+	if d.config.EnableIPTables && hasSynProxy(endpoint) {
+		if err = programSynProxy(endpoint, true); err != nil {
+			done()
+			return err
+		}
+		defer func() {
+			if err != nil {
+				programSynProxy(endpoint, false)
+			}
+		}()
+	}
+
+	if d.config.EnableIPTables && hasConntrackTimeouts(endpoint) {
+		if err = programConntrackTimeouts(endpoint, true); err != nil {
+			done()
+			return err
+		}
+		defer func() {
+			if err != nil {
+				programConntrackTimeouts(endpoint, false)
+			}
+		}()
+	}
+	done()
+
+	done = driverapi.TimeStep(ctx, "bridge/store")
+	err = d.storeUpdate(endpoint)
+	done()
+	if err != nil {
 		return fmt.Errorf("failed to update bridge endpoint %.7s to store: %v", endpoint.id, err)
 	}
 
 	if !network.config.EnableICC {
+		defer driverapi.TimeStep(ctx, "bridge/iptables")()
 		return d.link(network, endpoint, true)
 	}
 
@@ -1315,6 +1894,11 @@ func (d *driver) RevokeExternalConnectivity(nid, eid string) error {
 		return EndpointNotFoundError(eid)
 	}
 
+	if d.config.EnableIPTables {
+		programSynProxy(endpoint, false)
+		programConntrackTimeouts(endpoint, false)
+	}
+
 	err = network.releasePorts(endpoint)
 	if err != nil {
 		logrus.Warn(err)
@@ -1359,6 +1943,9 @@ func (d *driver) link(network *bridgeNetwork, endpoint *bridgeEndpoint, enable b
 				err = InvalidEndpointIDError(p)
 				return err
 			}
+			if parentEndpoint.addr == nil || endpoint.addr == nil {
+				continue
+			}
 
 			l := newLink(parentEndpoint.addr.IP.String(),
 				endpoint.addr.IP.String(),
@@ -1392,6 +1979,9 @@ func (d *driver) link(network *bridgeNetwork, endpoint *bridgeEndpoint, enable b
 		if childEndpoint.extConnConfig == nil || childEndpoint.extConnConfig.ExposedPorts == nil {
 			continue
 		}
+		if endpoint.addr == nil || childEndpoint.addr == nil {
+			continue
+		}
 
 		l := newLink(endpoint.addr.IP.String(),
 			childEndpoint.addr.IP.String(),
@@ -1447,6 +2037,54 @@ func parseEndpointOptions(epOptions map[string]interface{}) (*endpointConfigurat
 		}
 	}
 
+	if err := parseConnLimitOptions(ec, epOptions); err != nil {
+		return nil, err
+	}
+
+	if err := parseSynProxyOptions(ec, epOptions); err != nil {
+		return nil, err
+	}
+
+	if err := parseICCGroupsOptions(ec, epOptions); err != nil {
+		return nil, err
+	}
+
+	if err := parseHairpinOptions(ec, epOptions); err != nil {
+		return nil, err
+	}
+
+	if err := parseHeldPortsOptions(ec, epOptions); err != nil {
+		return nil, err
+	}
+
+	if err := parseAnycastOptions(ec, epOptions); err != nil {
+		return nil, err
+	}
+
+	if err := parseConntrackTimeoutOptions(ec, epOptions); err != nil {
+		return nil, err
+	}
+
+	if err := parseSourceValidationOptions(ec, epOptions); err != nil {
+		return nil, err
+	}
+
+	if err := parseOffloadOptions(ec, epOptions); err != nil {
+		return nil, err
+	}
+
+	if err := parseAFXDPOptions(ec, epOptions); err != nil {
+		return nil, err
+	}
+
+	if err := parseCgroupOptions(ec, epOptions); err != nil {
+		return nil, err
+	}
+
+	if err := parsePolicyOptions(ec, epOptions); err != nil {
+		return nil, err
+	}
+
 	return ec, nil
 }
 
@@ -1502,5 +2140,8 @@ func electMacAddress(epConfig *endpointConfiguration, ip net.IP) net.HardwareAdd
 	if epConfig != nil && epConfig.MacAddress != nil {
 		return epConfig.MacAddress
 	}
+	if ip == nil {
+		return netutils.GenerateRandomMAC()
+	}
 	return netutils.GenerateMACFromIP(ip)
 }
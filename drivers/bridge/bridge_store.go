@@ -1,9 +1,11 @@
 package bridge
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net"
+	"time"
 
 	"github.com/docker/libnetwork/datastore"
 	"github.com/docker/libnetwork/discoverapi"
@@ -89,8 +91,56 @@ func (d *driver) populateEndpoints() error {
 			}
 			continue
 		}
+		if !ep.cleanupAt.IsZero() {
+			// Deleted before the restart, which ends its quiesce period
+			logrus.Debugf("Completing the deferred cleanup of bridge endpoint (%.7s)", ep.id)
+			d.cleanupEndpoint(ep)
+			continue
+		}
 		n.endpoints[ep.id] = ep
 		n.restorePortAllocations(ep)
+		if err := d.setupVethSysctls(n, ep); err != nil {
+			logrus.Warn(err)
+		}
+		if d.config.EnableIPTables {
+			if err := programConnLimits(n.config.BridgeName, ep, true); err != nil {
+				logrus.Warn(err)
+			}
+			if err := programSynProxy(ep, true); err != nil {
+				logrus.Warn(err)
+			}
+			if err := programConntrackTimeouts(ep, true); err != nil {
+				logrus.Warn(err)
+			}
+			if err := n.programICCGroups(ep, true); err != nil {
+				logrus.Warn(err)
+			}
+			if err := n.programHostAccess(ep, true); err != nil {
+				logrus.Warn(err)
+			}
+			// The joins in progress are over, the ready signals are
+			// still awaited
+			if n.isHeld(ep) {
+				if err := n.programBootstrap(ep, true); err != nil {
+					logrus.Warn(err)
+				}
+			}
+			if ep.quarantine != "" {
+				if err := n.programQuarantine(ep, ep.quarantine, true); err != nil {
+					logrus.Warn(err)
+				}
+			}
+			if ep.throttle != 0 {
+				if err := n.programThrottle(ep, ep.throttle, true); err != nil {
+					logrus.Warn(err)
+				}
+			}
+			if ep.filter != nil {
+				if err := n.programFilter(ep, ep.filter, true); err != nil {
+					logrus.Warn(err)
+				}
+			}
+		}
 		logrus.Debugf("Endpoint (%.7s) restored to network (%.7s)", ep.id, ep.nid)
 	}
 
@@ -139,12 +189,56 @@ func (ncfg *networkConfiguration) MarshalJSON() ([]byte, error) {
 	nMap["EnableICC"] = ncfg.EnableICC
 	nMap["Mtu"] = ncfg.Mtu
 	nMap["Internal"] = ncfg.Internal
+	nMap["IPv6Only"] = ncfg.IPv6Only
 	nMap["DefaultBridge"] = ncfg.DefaultBridge
 	nMap["DefaultBindingIP"] = ncfg.DefaultBindingIP.String()
 	nMap["DefaultGatewayIPv4"] = ncfg.DefaultGatewayIPv4.String()
 	nMap["DefaultGatewayIPv6"] = ncfg.DefaultGatewayIPv6.String()
 	nMap["ContainerIfacePrefix"] = ncfg.ContainerIfacePrefix
 	nMap["BridgeIfaceCreator"] = ncfg.BridgeIfaceCreator
+	nMap["SNATPool"] = ncfg.SNATPool
+	nMap["SNATMode"] = ncfg.SNATMode
+	nMap["IPv6NAT"] = ncfg.IPv6NAT
+	nMap["VethNaming"] = ncfg.VethNaming
+	nMap["VethPrefix"] = ncfg.VethPrefix
+	nMap["VethQueues"] = ncfg.VethQueues
+	nMap["VethRPSCPUs"] = ncfg.VethRPSCPUs
+	nMap["VethXPSCPUs"] = ncfg.VethXPSCPUs
+	nMap["MulticastQuerier"] = ncfg.MulticastQuerier
+	nMap["MulticastRouter"] = ncfg.MulticastRouter
+	nMap["ConntrackZone"] = ncfg.ConntrackZone
+	nMap["Tenant"] = ncfg.Tenant
+	nMap["IPv6RA"] = ncfg.IPv6RA
+	if len(ncfg.IPv6RARDNSS) > 0 {
+		var rdnss []string
+		for _, ip := range ncfg.IPv6RARDNSS {
+			rdnss = append(rdnss, ip.String())
+		}
+		nMap["IPv6RARDNSS"] = rdnss
+	}
+	if ncfg.IPv6RALifetime != nil {
+		nMap["IPv6RALifetime"] = ncfg.IPv6RALifetime.String()
+	}
+	nMap["StaticNeighbors"] = ncfg.StaticNeighbors
+	if ncfg.HostAccess != "" {
+		nMap["HostAccess"] = ncfg.HostAccess
+	}
+	if len(ncfg.HostAccessPorts) > 0 {
+		nMap["HostAccessPorts"] = ncfg.HostAccessPorts
+	}
+	if ncfg.MetadataProxy != "" {
+		nMap["MetadataProxy"] = ncfg.MetadataProxy
+		nMap["MetadataAddress"] = ncfg.MetadataAddress.String()
+	}
+	if ncfg.Bootstrap != "" {
+		nMap["Bootstrap"] = ncfg.Bootstrap
+	}
+	if ncfg.ConnectivityProfile != "" {
+		nMap["ConnectivityProfile"] = ncfg.ConnectivityProfile
+	}
+	if ncfg.SocketPolicy {
+		nMap["SocketPolicy"] = true
+	}
 
 	if ncfg.AddressIPv4 != nil {
 		nMap["AddressIPv4"] = ncfg.AddressIPv4.String()
@@ -154,6 +248,39 @@ func (ncfg *networkConfiguration) MarshalJSON() ([]byte, error) {
 		nMap["AddressIPv6"] = ncfg.AddressIPv6.String()
 	}
 
+	if len(ncfg.SecondaryAddressesIPv4) > 0 {
+		var addrs []string
+		for _, a := range ncfg.SecondaryAddressesIPv4 {
+			addrs = append(addrs, a.String())
+		}
+		nMap["SecondaryAddressesIPv4"] = addrs
+	}
+
+	if ncfg.IPv6NATPrefix != nil {
+		nMap["IPv6NATPrefix"] = ncfg.IPv6NATPrefix.String()
+	}
+
+	if ncfg.NAT64Prefix != nil {
+		nMap["NAT64Prefix"] = ncfg.NAT64Prefix.String()
+		nMap["NAT64Pool"] = ncfg.NAT64Pool.String()
+	}
+
+	if len(ncfg.VethSysctls) > 0 {
+		nMap["VethSysctls"] = ncfg.VethSysctls
+	}
+
+	if ncfg.MulticastSnooping != nil {
+		nMap["MulticastSnooping"] = *ncfg.MulticastSnooping
+	}
+
+	if len(ncfg.NATExemptions) > 0 {
+		var cidrs []string
+		for _, c := range ncfg.NATExemptions {
+			cidrs = append(cidrs, c.String())
+		}
+		nMap["NATExemptions"] = cidrs
+	}
+
 	return json.Marshal(nMap)
 }
 
@@ -179,10 +306,144 @@ func (ncfg *networkConfiguration) UnmarshalJSON(b []byte) error {
 		}
 	}
 
+	if v, ok := nMap["SecondaryAddressesIPv4"]; ok {
+		for _, a := range v.([]interface{}) {
+			addr, err := types.ParseCIDR(a.(string))
+			if err != nil {
+				return types.InternalErrorf("failed to decode bridge network secondary address IPv4 after json unmarshal: %s", a.(string))
+			}
+			ncfg.SecondaryAddressesIPv4 = append(ncfg.SecondaryAddressesIPv4, addr)
+		}
+	}
+
+	if v, ok := nMap["NATExemptions"]; ok {
+		for _, c := range v.([]interface{}) {
+			cidr, err := types.ParseCIDR(c.(string))
+			if err != nil {
+				return types.InternalErrorf("failed to decode bridge network NAT exemption after json unmarshal: %s", c.(string))
+			}
+			ncfg.NATExemptions = append(ncfg.NATExemptions, cidr)
+		}
+	}
+
 	if v, ok := nMap["ContainerIfacePrefix"]; ok {
 		ncfg.ContainerIfacePrefix = v.(string)
 	}
 
+	if v, ok := nMap["SNATPool"]; ok {
+		ncfg.SNATPool = v.(string)
+	}
+
+	if v, ok := nMap["SNATMode"]; ok {
+		ncfg.SNATMode = v.(string)
+	}
+
+	if v, ok := nMap["IPv6NAT"]; ok {
+		ncfg.IPv6NAT = v.(string)
+	}
+
+	if v, ok := nMap["VethNaming"]; ok {
+		ncfg.VethNaming = v.(string)
+	}
+
+	if v, ok := nMap["VethPrefix"]; ok {
+		ncfg.VethPrefix = v.(string)
+	}
+
+	if v, ok := nMap["VethQueues"]; ok {
+		ncfg.VethQueues = int(v.(float64))
+	}
+
+	if v, ok := nMap["VethRPSCPUs"]; ok {
+		ncfg.VethRPSCPUs = v.(string)
+	}
+
+	if v, ok := nMap["VethXPSCPUs"]; ok {
+		ncfg.VethXPSCPUs = v.(string)
+	}
+
+	if v, ok := nMap["Tenant"]; ok {
+		ncfg.Tenant = v.(string)
+	}
+
+	if v, ok := nMap["VethSysctls"]; ok {
+		ncfg.VethSysctls = make(map[string]string)
+		for name, value := range v.(map[string]interface{}) {
+			ncfg.VethSysctls[name] = value.(string)
+		}
+	}
+
+	if v, ok := nMap["MulticastSnooping"]; ok {
+		snooping := v.(bool)
+		ncfg.MulticastSnooping = &snooping
+	}
+
+	if v, ok := nMap["MulticastQuerier"]; ok {
+		ncfg.MulticastQuerier = v.(bool)
+	}
+
+	if v, ok := nMap["MulticastRouter"]; ok {
+		ncfg.MulticastRouter = v.(bool)
+	}
+	if v, ok := nMap["ConntrackZone"]; ok {
+		ncfg.ConntrackZone = int(v.(float64))
+	}
+
+	if v, ok := nMap["IPv6RA"]; ok {
+		ncfg.IPv6RA = v.(bool)
+	}
+	if v, ok := nMap["IPv6RARDNSS"]; ok {
+		for _, ip := range v.([]interface{}) {
+			ncfg.IPv6RARDNSS = append(ncfg.IPv6RARDNSS, net.ParseIP(ip.(string)))
+		}
+	}
+	if v, ok := nMap["IPv6RALifetime"]; ok {
+		d, err := time.ParseDuration(v.(string))
+		if err != nil {
+			return types.InternalErrorf("failed to decode bridge network router lifetime after json unmarshal: %s", v.(string))
+		}
+		ncfg.IPv6RALifetime = &d
+	}
+	if v, ok := nMap["StaticNeighbors"]; ok {
+		ncfg.StaticNeighbors = v.(bool)
+	}
+	if v, ok := nMap["HostAccess"]; ok {
+		ncfg.HostAccess = v.(string)
+	}
+	if v, ok := nMap["HostAccessPorts"]; ok {
+		for _, p := range v.([]interface{}) {
+			ncfg.HostAccessPorts = append(ncfg.HostAccessPorts, p.(string))
+		}
+	}
+	if v, ok := nMap["MetadataProxy"]; ok {
+		ncfg.MetadataProxy = v.(string)
+		ncfg.MetadataAddress = net.ParseIP(nMap["MetadataAddress"].(string))
+	}
+	if v, ok := nMap["Bootstrap"]; ok {
+		ncfg.Bootstrap = v.(string)
+	}
+	if v, ok := nMap["ConnectivityProfile"]; ok {
+		ncfg.ConnectivityProfile = v.(string)
+	}
+	if v, ok := nMap["SocketPolicy"]; ok {
+		ncfg.SocketPolicy = v.(bool)
+	}
+
+	if v, ok := nMap["IPv6NATPrefix"]; ok {
+		if ncfg.IPv6NATPrefix, err = types.ParseCIDR(v.(string)); err != nil {
+			return types.InternalErrorf("failed to decode bridge network IPv6 NAT prefix after json unmarshal: %s", v.(string))
+		}
+	}
+
+	if v, ok := nMap["NAT64Prefix"]; ok {
+		if ncfg.NAT64Prefix, err = types.ParseCIDR(v.(string)); err != nil {
+			return types.InternalErrorf("failed to decode bridge network NAT64 prefix after json unmarshal: %s", v.(string))
+		}
+		if ncfg.NAT64Pool, err = types.ParseCIDR(nMap["NAT64Pool"].(string)); err != nil {
+			return types.InternalErrorf("failed to decode bridge network NAT64 pool after json unmarshal: %s", nMap["NAT64Pool"].(string))
+		}
+	}
+
 	ncfg.DefaultBridge = nMap["DefaultBridge"].(bool)
 	ncfg.DefaultBindingIP = net.ParseIP(nMap["DefaultBindingIP"].(string))
 	ncfg.DefaultGatewayIPv4 = net.ParseIP(nMap["DefaultGatewayIPv4"].(string))
@@ -196,6 +457,9 @@ func (ncfg *networkConfiguration) UnmarshalJSON(b []byte) error {
 	if v, ok := nMap["Internal"]; ok {
 		ncfg.Internal = v.(bool)
 	}
+	if v, ok := nMap["IPv6Only"]; ok {
+		ncfg.IPv6Only = v.(bool)
+	}
 
 	if v, ok := nMap["BridgeIfaceCreator"]; ok {
 		ncfg.BridgeIfaceCreator = ifaceCreator(v.(float64))
@@ -260,8 +524,13 @@ func (ep *bridgeEndpoint) MarshalJSON() ([]byte, error) {
 	epMap["id"] = ep.id
 	epMap["nid"] = ep.nid
 	epMap["SrcName"] = ep.srcName
+	if ep.hostIfName != "" {
+		epMap["HostIfName"] = ep.hostIfName
+	}
 	epMap["MacAddress"] = ep.macAddress.String()
-	epMap["Addr"] = ep.addr.String()
+	if ep.addr != nil {
+		epMap["Addr"] = ep.addr.String()
+	}
 	if ep.addrv6 != nil {
 		epMap["Addrv6"] = ep.addrv6.String()
 	}
@@ -269,6 +538,24 @@ func (ep *bridgeEndpoint) MarshalJSON() ([]byte, error) {
 	epMap["ContainerConfig"] = ep.containerConfig
 	epMap["ExternalConnConfig"] = ep.extConnConfig
 	epMap["PortMapping"] = ep.portMapping
+	if ep.savedSysctls != nil {
+		epMap["SavedSysctls"] = ep.savedSysctls
+	}
+	if !ep.cleanupAt.IsZero() {
+		epMap["CleanupAt"] = ep.cleanupAt
+	}
+	if ep.awaitReady {
+		epMap["AwaitReady"] = true
+	}
+	if ep.throttle != 0 {
+		epMap["Throttle"] = ep.throttle
+	}
+	if ep.quarantine != "" {
+		epMap["Quarantine"] = ep.quarantine
+	}
+	if ep.filter != nil {
+		epMap["Filter"] = ep.filter
+	}
 
 	return json.Marshal(epMap)
 }
@@ -301,6 +588,9 @@ func (ep *bridgeEndpoint) UnmarshalJSON(b []byte) error {
 	ep.id = epMap["id"].(string)
 	ep.nid = epMap["nid"].(string)
 	ep.srcName = epMap["SrcName"].(string)
+	if v, ok := epMap["HostIfName"]; ok {
+		ep.hostIfName = v.(string)
+	}
 	d, _ := json.Marshal(epMap["Config"])
 	if err := json.Unmarshal(d, &ep.config); err != nil {
 		logrus.Warnf("Failed to decode endpoint config %v", err)
@@ -317,6 +607,32 @@ func (ep *bridgeEndpoint) UnmarshalJSON(b []byte) error {
 	if err := json.Unmarshal(d, &ep.portMapping); err != nil {
 		logrus.Warnf("Failed to decode endpoint port mapping %v", err)
 	}
+	if v, ok := epMap["SavedSysctls"]; ok {
+		ep.savedSysctls = make(map[string]string)
+		for name, value := range v.(map[string]interface{}) {
+			ep.savedSysctls[name] = value.(string)
+		}
+	}
+	if v, ok := epMap["CleanupAt"]; ok {
+		if ep.cleanupAt, err = time.Parse(time.RFC3339Nano, v.(string)); err != nil {
+			return types.InternalErrorf("failed to decode bridge endpoint cleanup time (%s) after json unmarshal: %v", v.(string), err)
+		}
+	}
+	if v, ok := epMap["AwaitReady"]; ok {
+		ep.awaitReady = v.(bool)
+	}
+	if v, ok := epMap["Quarantine"]; ok {
+		ep.quarantine = v.(string)
+	}
+	if v, ok := epMap["Throttle"]; ok {
+		ep.throttle = uint64(v.(float64))
+	}
+	if v, ok := epMap["Filter"]; ok {
+		d, _ = json.Marshal(v)
+		if err := json.Unmarshal(d, &ep.filter); err != nil {
+			logrus.Warnf("Failed to decode endpoint filter policy %v", err)
+		}
+	}
 
 	return nil
 }
@@ -380,7 +696,7 @@ func (n *bridgeNetwork) restorePortAllocations(ep *bridgeEndpoint) {
 	}
 	tmp := ep.extConnConfig.PortBindings
 	ep.extConnConfig.PortBindings = ep.portMapping
-	_, err := n.allocatePorts(ep, n.config.DefaultBindingIP, n.driver.config.EnableUserlandProxy)
+	_, err := n.allocatePorts(context.Background(), ep, n.config.DefaultBindingIP, n.driver.config.EnableUserlandProxy)
 	if err != nil {
 		logrus.Warnf("Failed to reserve existing port mapping for endpoint %.7s:%v", ep.id, err)
 	}
@@ -348,6 +348,49 @@ func TestCreateFullOptionsLabels(t *testing.T) {
 	}
 }
 
+func TestNetworkOptionsSchema(t *testing.T) {
+	if err := networkOptions.Check(networkConfiguration{}); err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := parseNetworkGenericOptions(map[string]string{BridgeName: "cu"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !config.EnableICC || !config.EnableIPMasquerade || config.BridgeName != "cu" {
+		t.Fatalf("unexpected configuration %+v", config)
+	}
+
+	_, err = parseNetworkGenericOptions(map[string]string{netlabel.DriverMTU: "large"})
+	if _, ok := err.(types.BadRequestError); !ok {
+		t.Fatalf("expected a bad request error for an invalid MTU, got %v", err)
+	}
+}
+
+func TestTenantNaming(t *testing.T) {
+	if !testutils.IsRunningInContainer() {
+		defer testutils.SetupTestOSContext(t)()
+	}
+
+	id := "0123456789abcdef0123456789abcdef"
+	config, err := parseNetworkOptions(id, options.Generic{
+		netlabel.GenericData: map[string]string{netlabel.Tenant: "ads"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.BridgeName != "ads-0123456789a" || config.VethPrefix != "ads" {
+		t.Fatalf("unexpected names of the tenant network: bridge %s, veth prefix %s", config.BridgeName, config.VethPrefix)
+	}
+
+	_, err = parseNetworkOptions(id, options.Generic{
+		netlabel.GenericData: map[string]string{netlabel.Tenant: "Not-A-Tenant"},
+	})
+	if _, ok := err.(types.BadRequestError); !ok {
+		t.Fatalf("expected a bad request error for an invalid tenant, got %v", err)
+	}
+}
+
 func TestCreate(t *testing.T) {
 	if !testutils.IsRunningInContainer() {
 		defer testutils.SetupTestOSContext(t)()
@@ -0,0 +1,145 @@
+package bridge
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/docker/libnetwork/iptables"
+	"github.com/sirupsen/logrus"
+)
+
+// ProfileChain is the filter chain, jumped to from FORWARD, restricting
+// the traffic of the networks with a connectivity profile to the
+// destinations and sources of their profile
+const ProfileChain = "DOCKER-PROFILE"
+
+// connectivityProfile is the external connectivity of a network, the
+// traffic between its endpoints is left to the ICC settings
+type connectivityProfile struct {
+	// egress are the destinations out of the network the endpoints open
+	// connections to, any when nil
+	egress []string
+	// ingress are the sources out of the network opening connections to
+	// the endpoints, any when nil
+	ingress []string
+}
+
+// privateNets are the private IPv4 ranges of the datacenters, the shared
+// address space of the carrier-grade NATs included
+var privateNets = []string{"10.0.0.0/8", "100.64.0.0/10", "172.16.0.0/12", "192.168.0.0/16"}
+
+// connectivityProfiles are the presets of the ConnectivityProfile option
+var connectivityProfiles = map[string]connectivityProfile{
+	// No traffic in or out of the network
+	"isolated": {egress: []string{}, ingress: []string{}},
+	// Connections from the private ranges only, none out of the network
+	"internal-only": {egress: []string{}, ingress: privateNets},
+	// Connections with the private ranges only, both ways
+	"datacenter": {egress: privateNets, ingress: privateNets},
+	// Connections out to anywhere, in from the private ranges only
+	"internet": {ingress: privateNets},
+}
+
+func validateConnectivityProfile(v interface{}) error {
+	if _, ok := connectivityProfiles[v.(string)]; ok {
+		return nil
+	}
+	names := make([]string, 0, len(connectivityProfiles))
+	for name := range connectivityProfiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return fmt.Errorf("expected one of %s", strings.Join(names, ", "))
+}
+
+// validateProfileFamily refuses the profiles on the IPv6 networks, the
+// rules of the profiles filtering the IPv4 traffic only
+func validateProfileFamily(c *networkConfiguration) error {
+	if c.ConnectivityProfile != "" && c.EnableIPv6 {
+		return errors.New("the connectivity profiles are not supported on the IPv6 networks")
+	}
+	return nil
+}
+
+// profileRules renders the rules of the connectivity profile of the
+// network. The replies of the connections the profile allows get through
+// ahead of the allowed destinations and sources, the rest of the traffic
+// going out of or into the network is rejected or dropped last.
+func profileRules(bridgeName, profile string) [][]string {
+	p, ok := connectivityProfiles[profile]
+	if !ok {
+		return nil
+	}
+	out := []string{"-i", bridgeName, "!", "-o", bridgeName}
+	in := []string{"-o", bridgeName, "!", "-i", bridgeName}
+	rule := func(dir []string, args ...string) []string {
+		return append(append([]string{}, dir...), args...)
+	}
+	established := []string{"-m", "conntrack", "--ctstate", "RELATED,ESTABLISHED", "-j", "RETURN"}
+
+	var rules [][]string
+	if p.egress != nil {
+		rules = append(rules, rule(out, established...))
+		for _, cidr := range p.egress {
+			rules = append(rules, rule(out, "-d", cidr, "-j", "RETURN"))
+		}
+		rules = append(rules, rule(out, "-j", "REJECT", "--reject-with", "icmp-admin-prohibited"))
+	}
+	if p.ingress != nil {
+		rules = append(rules, rule(in, established...))
+		for _, cidr := range p.ingress {
+			rules = append(rules, rule(in, "-s", cidr, "-j", "RETURN"))
+		}
+		rules = append(rules, rule(in, "-j", "DROP"))
+	}
+	return rules
+}
+
+// programProfile adds or removes the rules of the connectivity profile of
+// the network
+func programProfile(bridgeName, profile string, enable bool) error {
+	action := iptables.Delete
+	if enable {
+		action = iptables.Append
+	}
+	for _, rule := range profileRules(bridgeName, profile) {
+		if enable == iptables.Exists(iptables.Filter, ProfileChain, rule...) {
+			continue
+		}
+		if err := iptables.ProgramRule(iptables.Filter, ProfileChain, action, rule); err != nil {
+			if !enable {
+				logrus.Warnf("Failed to remove the connectivity profile rule %v of bridge %s: %v", rule, bridgeName, err)
+				continue
+			}
+			return fmt.Errorf("failed to program the %s connectivity profile of bridge %s: %v", profile, bridgeName, err)
+		}
+	}
+	return nil
+}
+
+// setupConnectivityProfile programs the connectivity profile of the
+// network, if any
+func (n *bridgeNetwork) setupConnectivityProfile(config *networkConfiguration) error {
+	if config.ConnectivityProfile == "" {
+		return nil
+	}
+	if err := programProfile(config.BridgeName, config.ConnectivityProfile, true); err != nil {
+		programProfile(config.BridgeName, config.ConnectivityProfile, false)
+		return err
+	}
+	n.registerIptCleanFunc(func() error {
+		return programProfile(config.BridgeName, config.ConnectivityProfile, false)
+	})
+	return nil
+}
+
+// setupProfileChain creates the connectivity profile chain, its jump from
+// FORWARD is added along with the other chains of the networks
+func setupProfileChain() error {
+	if _, err := iptables.NewChain(ProfileChain, iptables.Filter, false); err != nil {
+		return fmt.Errorf("failed to create FILTER connectivity profile chain: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package bridge
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/docker/libnetwork/iptables"
+	"github.com/docker/libnetwork/testutils/fakeiptables"
+)
+
+func TestParseConnectivityProfile(t *testing.T) {
+	c := &networkConfiguration{}
+	if err := c.fromLabels(map[string]string{ConnectivityProfile: "datacenter"}); err != nil {
+		t.Fatal(err)
+	}
+	if c.ConnectivityProfile != "datacenter" {
+		t.Fatalf("unexpected connectivity profile %q", c.ConnectivityProfile)
+	}
+	if err := (&networkConfiguration{}).fromLabels(map[string]string{ConnectivityProfile: "open"}); err == nil {
+		t.Fatal("invalid connectivity profile accepted")
+	}
+	if err := validateProfileFamily(&networkConfiguration{ConnectivityProfile: "isolated", EnableIPv6: true}); err == nil {
+		t.Fatal("connectivity profile accepted on an IPv6 network")
+	}
+}
+
+func TestProfileRules(t *testing.T) {
+	expected := [][]string{
+		{"-i", "br0", "!", "-o", "br0", "-m", "conntrack", "--ctstate", "RELATED,ESTABLISHED", "-j", "RETURN"},
+		{"-i", "br0", "!", "-o", "br0", "-j", "REJECT", "--reject-with", "icmp-admin-prohibited"},
+		{"-o", "br0", "!", "-i", "br0", "-m", "conntrack", "--ctstate", "RELATED,ESTABLISHED", "-j", "RETURN"},
+		{"-o", "br0", "!", "-i", "br0", "-j", "DROP"},
+	}
+	if rules := profileRules("br0", "isolated"); !reflect.DeepEqual(rules, expected) {
+		t.Fatalf("unexpected rules:\n%v\nexpected:\n%v", rules, expected)
+	}
+
+	// Out to anywhere, no egress rule
+	rules := profileRules("br0", "internet")
+	if len(rules) != len(privateNets)+2 {
+		t.Fatalf("unexpected rules %v", rules)
+	}
+	for _, r := range rules {
+		if r[0] != "-o" {
+			t.Fatalf("unexpected egress rule %v", r)
+		}
+	}
+	if rules := profileRules("br0", ""); rules != nil {
+		t.Fatalf("unexpected rules without a profile %v", rules)
+	}
+}
+
+func TestProgramProfile(t *testing.T) {
+	ipt := fakeiptables.New()
+	defer ipt.Install()()
+
+	if err := setupProfileChain(); err != nil {
+		t.Fatal(err)
+	}
+	n := &bridgeNetwork{}
+	config := &networkConfiguration{BridgeName: "br0", ConnectivityProfile: "datacenter"}
+	if err := n.setupConnectivityProfile(config); err != nil {
+		t.Fatal(err)
+	}
+	// Programming back the rules, as on the firewall reloads, adds none
+	if err := programProfile("br0", "datacenter", true); err != nil {
+		t.Fatal(err)
+	}
+	expected := profileRules("br0", "datacenter")
+	if rules := ipt.IPv4().Rules(iptables.Filter, ProfileChain); !reflect.DeepEqual(rules, expected) {
+		t.Fatalf("unexpected rules:\n%v\nexpected:\n%v", rules, expected)
+	}
+
+	for _, clean := range n.iptCleanFuncs {
+		if err := clean(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if rules := ipt.IPv4().Rules(iptables.Filter, ProfileChain); len(rules) != 0 {
+		t.Fatalf("unexpected rules left %v", rules)
+	}
+}
@@ -0,0 +1,135 @@
+package bridge
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+
+	"github.com/docker/libnetwork/iptables"
+	"github.com/docker/libnetwork/netlabel"
+	"github.com/docker/libnetwork/types"
+	"github.com/sirupsen/logrus"
+)
+
+// ConnLimitChain is the filter chain, jumped to from FORWARD, capping the
+// connections opened towards the endpoints, per source address
+const ConnLimitChain = "DOCKER-CONNLIMIT"
+
+var connRateRe = regexp.MustCompile(`^[1-9][0-9]*/(s|sec|second|m|min|minute|h|hour|d|day)$`)
+
+func parseConnLimitOptions(ec *endpointConfiguration, epOptions map[string]interface{}) error {
+	if opt, ok := epOptions[netlabel.ConnLimit]; ok {
+		var limit int
+		switch v := opt.(type) {
+		case int:
+			limit = v
+		case string:
+			l, err := strconv.Atoi(v)
+			if err != nil {
+				return types.BadRequestErrorf("invalid connection limit %q: %v", v, err)
+			}
+			limit = l
+		default:
+			return &ErrInvalidEndpointConfig{}
+		}
+		if limit <= 0 {
+			return types.BadRequestErrorf("invalid connection limit %d: it must be positive", limit)
+		}
+		ec.ConnLimit = limit
+	}
+
+	if opt, ok := epOptions[netlabel.ConnRate]; ok {
+		rate, ok := opt.(string)
+		if !ok {
+			return &ErrInvalidEndpointConfig{}
+		}
+		if !connRateRe.MatchString(rate) {
+			return types.BadRequestErrorf("invalid connection rate %q: expected as in 20/sec", rate)
+		}
+		ec.ConnRate = rate
+	}
+
+	return nil
+}
+
+func hasConnLimits(ep *bridgeEndpoint) bool {
+	return ep.config != nil && (ep.config.ConnLimit > 0 || ep.config.ConnRate != "")
+}
+
+// connLimitRules renders the rules of the endpoint in ConnLimitChain. The
+// concurrent connections are only accounted for TCP, the rate of the new
+// connections for all the protocols. The rules are tagged with the endpoint.
+func connLimitRules(bridgeName string, ep *bridgeEndpoint) [][]string {
+	dst := []string{"-o", bridgeName, "-d", ep.addr.IP.String()}
+
+	var rules [][]string
+	if ep.config.ConnLimit > 0 {
+		rules = append(rules, iptables.TagRule(networkType, ep.id, append(append([]string{}, dst...),
+			"-p", "tcp", "--syn",
+			"-m", "connlimit", "--connlimit-above", strconv.Itoa(ep.config.ConnLimit), "--connlimit-mask", "32",
+			"-j", "REJECT", "--reject-with", "tcp-reset")))
+	}
+	if ep.config.ConnRate != "" {
+		rules = append(rules, iptables.TagRule(networkType, ep.id, append(append([]string{}, dst...),
+			"-m", "conntrack", "--ctstate", "NEW",
+			"-m", "hashlimit", "--hashlimit-above", ep.config.ConnRate,
+			"--hashlimit-mode", "srcip", "--hashlimit-name", hashlimitName("dkr-", ep.id),
+			"-j", "DROP")))
+	}
+	return rules
+}
+
+// hashlimitName returns the name of a hash table of the endpoint, which
+// is limited to 15 characters with the four characters prefix
+func hashlimitName(prefix, eid string) string {
+	if len(eid) > 11 {
+		eid = eid[:11]
+	}
+	return prefix + eid
+}
+
+// programConnLimits adds or removes the connection limit rules of the
+// endpoint
+func programConnLimits(bridgeName string, ep *bridgeEndpoint, enable bool) error {
+	if !hasConnLimits(ep) || ep.addr == nil {
+		return nil
+	}
+
+	action := iptables.Delete
+	if enable {
+		action = iptables.Append
+	}
+	for _, rule := range connLimitRules(bridgeName, ep) {
+		if enable && iptables.Exists(iptables.Filter, ConnLimitChain, rule...) {
+			continue
+		}
+		if err := iptables.ProgramRule(iptables.Filter, ConnLimitChain, action, rule); err != nil {
+			if !enable {
+				logrus.Warnf("Failed to remove the connection limit rule %v of endpoint %.7s: %v", rule, ep.id, err)
+				continue
+			}
+			return fmt.Errorf("failed to program the connection limits of endpoint %.7s: %v", ep.id, err)
+		}
+	}
+	return nil
+}
+
+// restoreConnLimits programs back the connection limits of all the
+// endpoints, after the chain got flushed
+func (d *driver) restoreConnLimits() {
+	for _, n := range d.getNetworks() {
+		n.Lock()
+		bridgeName := n.config.BridgeName
+		eps := make([]*bridgeEndpoint, 0, len(n.endpoints))
+		for _, ep := range n.endpoints {
+			eps = append(eps, ep)
+		}
+		n.Unlock()
+
+		for _, ep := range eps {
+			if err := programConnLimits(bridgeName, ep, true); err != nil {
+				logrus.Warn(err)
+			}
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package bridge
+
+import (
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/docker/libnetwork/netlabel"
+)
+
+func TestParseConnLimitOptions(t *testing.T) {
+	ec, err := parseEndpointOptions(map[string]interface{}{
+		netlabel.ConnLimit: "10",
+		netlabel.ConnRate:  "20/sec",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ec.ConnLimit != 10 || ec.ConnRate != "20/sec" {
+		t.Fatalf("unexpected endpoint configuration: %+v", ec)
+	}
+
+	for _, opts := range []map[string]interface{}{
+		{netlabel.ConnLimit: "0"},
+		{netlabel.ConnLimit: "ten"},
+		{netlabel.ConnLimit: 1.5},
+		{netlabel.ConnRate: "20"},
+		{netlabel.ConnRate: "0/sec"},
+	} {
+		if _, err := parseEndpointOptions(opts); err == nil {
+			t.Fatalf("expected an error parsing %v", opts)
+		}
+	}
+}
+
+func TestConnLimitRules(t *testing.T) {
+	ep := &bridgeEndpoint{
+		id:     "0123456789abcdef",
+		addr:   &net.IPNet{IP: net.ParseIP("172.17.0.2"), Mask: net.CIDRMask(16, 32)},
+		config: &endpointConfiguration{ConnLimit: 10, ConnRate: "20/sec"},
+	}
+	expected := [][]string{
+		{"-o", "docker0", "-d", "172.17.0.2", "-p", "tcp", "--syn",
+			"-m", "connlimit", "--connlimit-above", "10", "--connlimit-mask", "32",
+			"-m", "comment", "--comment", "lnet:bridge:0123456789abcdef",
+			"-j", "REJECT", "--reject-with", "tcp-reset"},
+		{"-o", "docker0", "-d", "172.17.0.2", "-m", "conntrack", "--ctstate", "NEW",
+			"-m", "hashlimit", "--hashlimit-above", "20/sec",
+			"--hashlimit-mode", "srcip", "--hashlimit-name", "dkr-0123456789a",
+			"-m", "comment", "--comment", "lnet:bridge:0123456789abcdef",
+			"-j", "DROP"},
+	}
+	if rules := connLimitRules("docker0", ep); !reflect.DeepEqual(rules, expected) {
+		t.Fatalf("unexpected rules:\n%v\nexpected:\n%v", rules, expected)
+	}
+
+	if hasConnLimits(&bridgeEndpoint{config: &endpointConfiguration{}}) {
+		t.Fatal("endpoint without limits reported as limited")
+	}
+}
@@ -0,0 +1,246 @@
+package bridge
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"syscall"
+	"time"
+
+	"github.com/docker/libnetwork/iptables"
+	"github.com/docker/libnetwork/labelparse"
+	"github.com/docker/libnetwork/netlabel"
+	"github.com/docker/libnetwork/types"
+	"github.com/sirupsen/logrus"
+	"github.com/vishvananda/netlink/nl"
+)
+
+// ConntrackTimeoutChain is the raw chain, jumped to from PREROUTING, which
+// attaches the conntrack timeout policies of the endpoints to the
+// connections towards their published ports
+const ConntrackTimeoutChain = "DOCKER-CT"
+
+// The conntrack timeouts an endpoint can tune, as in
+// udp=1h,udp_unreplied=30s. The udp timeout is the one of the flows which
+// got replies, as the long-lived UDP and QUIC sessions.
+const (
+	ctTimeoutUDP          = "udp"
+	ctTimeoutUDPUnreplied = "udp_unreplied"
+	ctTimeoutTCP          = "tcp"
+)
+
+// nfnetlink cttimeout, as in linux/netfilter/nfnetlink_cttimeout.h
+const (
+	nfnlSubsysCttimeout = 8
+	ctTimeoutMsgNew     = 0
+	ctTimeoutMsgDelete  = 2
+
+	ctaTimeoutName    = 1
+	ctaTimeoutL3Proto = 2
+	ctaTimeoutL4Proto = 3
+	ctaTimeoutData    = 4
+
+	ctaTimeoutTCPEstablished = 3
+	ctaTimeoutUDPUnreplied   = 1
+	ctaTimeoutUDPReplied     = 2
+)
+
+// ctTimeoutAttrs maps the timeouts to their protocol and cttimeout attribute
+var ctTimeoutAttrs = map[string]struct {
+	proto types.Protocol
+	attr  int
+}{
+	ctTimeoutUDP:          {types.UDP, ctaTimeoutUDPReplied},
+	ctTimeoutUDPUnreplied: {types.UDP, ctaTimeoutUDPUnreplied},
+	ctTimeoutTCP:          {types.TCP, ctaTimeoutTCPEstablished},
+}
+
+func parseConntrackTimeoutOptions(ec *endpointConfiguration, epOptions map[string]interface{}) error {
+	opt, ok := epOptions[netlabel.ConntrackTimeouts]
+	if !ok {
+		return nil
+	}
+	v, ok := opt.(string)
+	if !ok {
+		return &ErrInvalidEndpointConfig{}
+	}
+
+	kvs, err := labelparse.ParseKeyValues("conntrack timeout", v)
+	if err != nil {
+		return err
+	}
+	timeouts := make(map[string]int)
+	for _, kv := range kvs {
+		if _, ok := ctTimeoutAttrs[kv.Key]; !ok {
+			return types.BadRequestErrorf("invalid conntrack timeout %s=%s: unknown timeout %s", kv.Key, kv.Value, kv.Key)
+		}
+		d, err := time.ParseDuration(kv.Value)
+		if err != nil {
+			return types.BadRequestErrorf("invalid conntrack timeout %s=%s: %v", kv.Key, kv.Value, err)
+		}
+		if d < time.Second {
+			return types.BadRequestErrorf("invalid conntrack timeout %s=%s: it must be at least a second", kv.Key, kv.Value)
+		}
+		timeouts[kv.Key] = int(d / time.Second)
+	}
+	ec.ConntrackTimeouts = timeouts
+	return nil
+}
+
+func hasConntrackTimeouts(ep *bridgeEndpoint) bool {
+	return ep.config != nil && len(ep.config.ConntrackTimeouts) > 0
+}
+
+// ctTimeoutPolicyName returns the name of the timeout policy of the
+// endpoint for the protocol, which is limited to 32 characters
+func ctTimeoutPolicyName(eid string, proto types.Protocol) string {
+	return hashlimitName("dkr-", eid) + "-" + proto.String()
+}
+
+// conntrackTimeoutPolicies groups the timeouts of the endpoint by protocol
+func conntrackTimeoutPolicies(ep *bridgeEndpoint) map[types.Protocol]map[int]uint32 {
+	policies := make(map[types.Protocol]map[int]uint32)
+	for name, secs := range ep.config.ConntrackTimeouts {
+		a, ok := ctTimeoutAttrs[name]
+		if !ok {
+			continue
+		}
+		if policies[a.proto] == nil {
+			policies[a.proto] = make(map[int]uint32)
+		}
+		policies[a.proto][a.attr] = uint32(secs)
+	}
+	return policies
+}
+
+// conntrackTimeoutRules renders the rules attaching the timeout policies of
+// the endpoint to the connections towards its published ports, tagged with
+// the endpoint
+func conntrackTimeoutRules(ep *bridgeEndpoint) [][]string {
+	policies := conntrackTimeoutPolicies(ep)
+	var rules [][]string
+	for _, pb := range ep.portMapping {
+		if _, ok := policies[pb.Proto]; !ok || (pb.HostIP != nil && pb.HostIP.To4() == nil) {
+			continue
+		}
+		rules = append(rules, iptables.TagRule(networkType, ep.id, append(publishedPortMatch(pb),
+			"-j", "CT", "--timeout", ctTimeoutPolicyName(ep.id, pb.Proto))))
+	}
+	return rules
+}
+
+// ctTimeoutRequest builds the cttimeout request creating, or updating, the
+// IPv4 timeout policy
+func ctTimeoutRequest(name string, proto types.Protocol, timeouts map[int]uint32) *nl.NetlinkRequest {
+	req := nl.NewNetlinkRequest(nfnlSubsysCttimeout<<8|ctTimeoutMsgNew, syscall.NLM_F_CREATE|syscall.NLM_F_ACK)
+	req.AddData(&nl.Nfgenmsg{NfgenFamily: syscall.AF_INET, Version: nl.NFNETLINK_V0})
+	req.AddData(nl.NewRtAttr(ctaTimeoutName, nl.ZeroTerminated(name)))
+
+	l3 := make([]byte, 2)
+	binary.BigEndian.PutUint16(l3, syscall.AF_INET)
+	req.AddData(nl.NewRtAttr(ctaTimeoutL3Proto, l3))
+	req.AddData(nl.NewRtAttr(ctaTimeoutL4Proto, []byte{uint8(proto)}))
+
+	attrs := make([]int, 0, len(timeouts))
+	for attr := range timeouts {
+		attrs = append(attrs, attr)
+	}
+	sort.Ints(attrs)
+	data := nl.NewRtAttr(ctaTimeoutData|nl.NLA_F_NESTED, nil)
+	for _, attr := range attrs {
+		b := make([]byte, 4)
+		binary.BigEndian.PutUint32(b, timeouts[attr])
+		nl.NewRtAttrChild(data, attr, b)
+	}
+	req.AddData(data)
+	return req
+}
+
+func deleteCTTimeoutPolicy(name string) error {
+	req := nl.NewNetlinkRequest(nfnlSubsysCttimeout<<8|ctTimeoutMsgDelete, syscall.NLM_F_ACK)
+	req.AddData(&nl.Nfgenmsg{NfgenFamily: syscall.AF_INET, Version: nl.NFNETLINK_V0})
+	req.AddData(nl.NewRtAttr(ctaTimeoutName, nl.ZeroTerminated(name)))
+	_, err := req.Execute(syscall.NETLINK_NETFILTER, 0)
+	return err
+}
+
+// programConntrackTimeouts adds or removes the timeout policies of the
+// endpoint and the rules attaching them to its published ports. A policy
+// referenced by live connections is left in place, it gets updated on its
+// next use.
+func programConntrackTimeouts(ep *bridgeEndpoint, enable bool) error {
+	if !hasConntrackTimeouts(ep) {
+		return nil
+	}
+	policies := conntrackTimeoutPolicies(ep)
+
+	if enable {
+		for proto, timeouts := range policies {
+			name := ctTimeoutPolicyName(ep.id, proto)
+			if _, err := ctTimeoutRequest(name, proto, timeouts).Execute(syscall.NETLINK_NETFILTER, 0); err != nil {
+				return fmt.Errorf("failed to create the conntrack timeout policy %s of endpoint %.7s: %v", name, ep.id, err)
+			}
+		}
+	}
+
+	action := iptables.Delete
+	if enable {
+		action = iptables.Append
+	}
+	for _, rule := range conntrackTimeoutRules(ep) {
+		if enable && iptables.Exists(iptables.RawTable, ConntrackTimeoutChain, rule...) {
+			continue
+		}
+		if err := iptables.ProgramRule(iptables.RawTable, ConntrackTimeoutChain, action, rule); err != nil {
+			if !enable {
+				logrus.Warnf("Failed to remove the conntrack timeout rule %v of endpoint %.7s: %v", rule, ep.id, err)
+				continue
+			}
+			return fmt.Errorf("failed to program the conntrack timeouts of endpoint %.7s: %v", ep.id, err)
+		}
+	}
+
+	if !enable {
+		for proto := range policies {
+			name := ctTimeoutPolicyName(ep.id, proto)
+			if err := deleteCTTimeoutPolicy(name); err != nil && err != syscall.ENOENT {
+				logrus.Debugf("Failed to delete the conntrack timeout policy %s of endpoint %.7s: %v", name, ep.id, err)
+			}
+		}
+	}
+	return nil
+}
+
+// setupConntrackTimeoutChain creates the conntrack timeout chain and its jump
+func setupConntrackTimeoutChain() error {
+	if _, err := iptables.NewChain(ConntrackTimeoutChain, iptables.RawTable, false); err != nil {
+		return fmt.Errorf("failed to create RAW conntrack timeout chain: %v", err)
+	}
+	jump := []string{"-j", ConntrackTimeoutChain}
+	if iptables.Exists(iptables.RawTable, "PREROUTING", jump...) {
+		return nil
+	}
+	if err := iptables.ProgramRule(iptables.RawTable, "PREROUTING", iptables.Insert, jump); err != nil {
+		return fmt.Errorf("failed to add the jump to the RAW conntrack timeout chain: %v", err)
+	}
+	return nil
+}
+
+// restoreConntrackTimeouts programs back the conntrack timeout rules of all
+// the endpoints, after the chain got flushed
+func (d *driver) restoreConntrackTimeouts() {
+	for _, n := range d.getNetworks() {
+		n.Lock()
+		eps := make([]*bridgeEndpoint, 0, len(n.endpoints))
+		for _, ep := range n.endpoints {
+			eps = append(eps, ep)
+		}
+		n.Unlock()
+
+		for _, ep := range eps {
+			if err := programConntrackTimeouts(ep, true); err != nil {
+				logrus.Warn(err)
+			}
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package bridge
+
+import (
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/docker/libnetwork/netlabel"
+	"github.com/docker/libnetwork/types"
+)
+
+func TestParseConntrackTimeoutOptions(t *testing.T) {
+	ec, err := parseEndpointOptions(map[string]interface{}{
+		netlabel.ConntrackTimeouts: "udp=1h, udp_unreplied=45s,tcp=12h",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]int{ctTimeoutUDP: 3600, ctTimeoutUDPUnreplied: 45, ctTimeoutTCP: 43200}
+	if !reflect.DeepEqual(ec.ConntrackTimeouts, expected) {
+		t.Fatalf("unexpected conntrack timeouts: %v", ec.ConntrackTimeouts)
+	}
+
+	for _, v := range []interface{}{"udp", "sctp=1h", "udp=forever", "udp=10ms", 3600} {
+		if _, err := parseEndpointOptions(map[string]interface{}{netlabel.ConntrackTimeouts: v}); err == nil {
+			t.Fatalf("expected an error parsing %v", v)
+		}
+	}
+}
+
+func TestConntrackTimeoutRules(t *testing.T) {
+	ep := &bridgeEndpoint{
+		id:     "0123456789abcdef",
+		config: &endpointConfiguration{ConntrackTimeouts: map[string]int{ctTimeoutUDP: 3600}},
+		portMapping: []types.PortBinding{
+			{Proto: types.UDP, HostIP: net.ParseIP("10.0.0.1"), HostPort: 443},
+			{Proto: types.UDP, HostPort: 4000, HostPortEnd: 4010},
+			{Proto: types.TCP, HostPort: 443},
+			{Proto: types.UDP, HostIP: net.ParseIP("2001:db8::1"), HostPort: 443},
+		},
+	}
+
+	expected := [][]string{
+		{"-d", "10.0.0.1", "-p", "udp", "--dport", "443",
+			"-m", "comment", "--comment", "lnet:bridge:0123456789abcdef", "-j", "CT", "--timeout", "dkr-0123456789a-udp"},
+		{"-m", "addrtype", "--dst-type", "LOCAL", "-p", "udp", "--dport", "4000:4010",
+			"-m", "comment", "--comment", "lnet:bridge:0123456789abcdef", "-j", "CT", "--timeout", "dkr-0123456789a-udp"},
+	}
+	if rules := conntrackTimeoutRules(ep); !reflect.DeepEqual(rules, expected) {
+		t.Fatalf("unexpected rules:\n%v\nexpected:\n%v", rules, expected)
+	}
+
+	policies := conntrackTimeoutPolicies(ep)
+	if !reflect.DeepEqual(policies, map[types.Protocol]map[int]uint32{types.UDP: {ctaTimeoutUDPReplied: 3600}}) {
+		t.Fatalf("unexpected policies: %v", policies)
+	}
+
+	// The request carries the name, the protocols and the nested timeouts
+	b := ctTimeoutRequest("dkr-0123456789a-udp", types.UDP, policies[types.UDP]).Serialize()
+	if len(b) != 16+4+24+8+8+12 {
+		t.Fatalf("unexpected request length %d", len(b))
+	}
+}
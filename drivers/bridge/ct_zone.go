@@ -0,0 +1,79 @@
+package bridge
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/docker/libnetwork/iptables"
+	"github.com/docker/libnetwork/types"
+)
+
+const (
+	// autoConntrackZone is the value of the ConntrackZone label asking a
+	// zone distinct from the ones of the other networks to be picked
+	autoConntrackZone = "auto"
+	// ctZoneAuto marks the configuration of a network waiting for its zone
+	ctZoneAuto    = -1
+	maxCTZone     = 65535
+	ctZoneRuleTag = "CONNTRACK ZONE"
+)
+
+// parseConntrackZone parses the ConntrackZone label, the zone number or auto
+func parseConntrackZone(value string) (int, error) {
+	if value == autoConntrackZone {
+		return ctZoneAuto, nil
+	}
+	zone, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, err
+	}
+	if zone < 1 || zone > maxCTZone {
+		return 0, fmt.Errorf("the zone must be between 1 and %d", maxCTZone)
+	}
+	return zone, nil
+}
+
+// allocateConntrackZone picks the lowest zone not used by the other
+// networks for the configuration asking for one
+func (d *driver) allocateConntrackZone(config *networkConfiguration) error {
+	if config.ConntrackZone != ctZoneAuto {
+		return nil
+	}
+	used := make(map[int]bool)
+	for _, nw := range d.getNetworks() {
+		nw.Lock()
+		used[nw.config.ConntrackZone] = true
+		nw.Unlock()
+	}
+	for zone := 1; zone <= maxCTZone; zone++ {
+		if !used[zone] {
+			config.ConntrackZone = zone
+			return nil
+		}
+	}
+	return types.ForbiddenErrorf("no conntrack zone left for network %s", config.ID)
+}
+
+// conntrackZoneRule is the rule placing the connections the endpoints of
+// the network originate in its zone. The zone only applies to the original
+// direction, the replies coming back from the outside still find the
+// connections in the default zone.
+func conntrackZoneRule(config *networkConfiguration) iptRule {
+	return iptRule{table: iptables.RawTable, chain: "PREROUTING", preArgs: []string{"-t", string(iptables.RawTable)},
+		args: []string{"-i", config.BridgeName, "-j", "CT", "--zone-orig", strconv.Itoa(config.ConntrackZone)}}
+}
+
+// setupConntrackZone programs the conntrack zone rule of the network
+func (n *bridgeNetwork) setupConntrackZone(config *networkConfiguration) error {
+	if config.ConntrackZone <= 0 {
+		return nil
+	}
+	rule := conntrackZoneRule(config)
+	if err := programChainRule(rule, ctZoneRuleTag, true); err != nil {
+		return err
+	}
+	n.registerIptCleanFunc(func() error {
+		return programChainRule(rule, ctZoneRuleTag, false)
+	})
+	return nil
+}
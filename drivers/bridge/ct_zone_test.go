@@ -0,0 +1,51 @@
+package bridge
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestConntrackZoneLabel(t *testing.T) {
+	for value, expected := range map[string]int{"auto": ctZoneAuto, "1": 1, "65535": 65535} {
+		config := &networkConfiguration{}
+		if err := config.fromLabels(map[string]string{ConntrackZone: value}); err != nil {
+			t.Fatal(err)
+		}
+		if config.ConntrackZone != expected {
+			t.Fatalf("unexpected zone %d parsing %q", config.ConntrackZone, value)
+		}
+	}
+
+	for _, value := range []string{"0", "65536", "default"} {
+		config := &networkConfiguration{}
+		if err := config.fromLabels(map[string]string{ConntrackZone: value}); err == nil {
+			t.Fatalf("expected an error parsing %q", value)
+		}
+	}
+}
+
+func TestAllocateConntrackZone(t *testing.T) {
+	d := newDriver()
+	d.networks["n1"] = &bridgeNetwork{config: &networkConfiguration{ID: "n1", ConntrackZone: 1}}
+	d.networks["n2"] = &bridgeNetwork{config: &networkConfiguration{ID: "n2", ConntrackZone: 3}}
+
+	config := &networkConfiguration{ID: "n3", ConntrackZone: ctZoneAuto}
+	if err := d.allocateConntrackZone(config); err != nil {
+		t.Fatal(err)
+	}
+	if config.ConntrackZone != 2 {
+		t.Fatalf("expected zone 2, got %d", config.ConntrackZone)
+	}
+
+	if err := d.networks["n2"].config.Conflicts(&networkConfiguration{BridgeName: "br1", ConntrackZone: 3}); err == nil {
+		t.Fatal("expected the networks with the same conntrack zone to conflict")
+	}
+}
+
+func TestConntrackZoneRule(t *testing.T) {
+	rule := conntrackZoneRule(&networkConfiguration{BridgeName: "br0", ConntrackZone: 7})
+	expected := []string{"-i", "br0", "-j", "CT", "--zone-orig", "7"}
+	if !reflect.DeepEqual(rule.args, expected) || rule.chain != "PREROUTING" {
+		t.Fatalf("unexpected rule %+v", rule)
+	}
+}
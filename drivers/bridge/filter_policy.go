@@ -0,0 +1,694 @@
+package bridge
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/docker/libnetwork/driverapi"
+	"github.com/docker/libnetwork/geoip"
+	"github.com/docker/libnetwork/iptables"
+	"github.com/docker/libnetwork/ns"
+	"github.com/docker/libnetwork/types"
+	"github.com/sirupsen/logrus"
+	"github.com/vishvananda/netlink"
+)
+
+// FilterChain is the filter chain, jumped to from FORWARD, filtering the
+// ingress traffic of the endpoints with a filter policy
+const FilterChain = "DOCKER-FILTER"
+
+// filterCommentPrefix is the prefix of the comment marking the version of
+// the filter policy a rule is rendered from
+const filterCommentPrefix = "lnet-filter:"
+
+// FilterAuditNFLOGGroup is the NFLOG group the packets an audit filter
+// policy would reject are logged to, prefixed with lnet-audit: and the
+// endpoint ID
+const FilterAuditNFLOGGroup = 100
+
+var filterVersionRe = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,64}$`)
+
+func validateFilterPolicy(p *driverapi.FilterPolicy) error {
+	if !filterVersionRe.MatchString(p.Version) {
+		return types.BadRequestErrorf("invalid filter policy version %q", p.Version)
+	}
+	for _, r := range p.Allow {
+		if r.Proto != "tcp" && r.Proto != "udp" && r.Proto != "sctp" {
+			return types.BadRequestErrorf("invalid protocol %q of filter policy %s: expected tcp, udp or sctp", r.Proto, p.Version)
+		}
+		if _, _, err := parsePortRange(r.Ports); err != nil {
+			return types.BadRequestErrorf("invalid ports of filter policy %s: %v", p.Version, err)
+		}
+		if r.Family != "" && r.Family != driverapi.FilterIPv4 && r.Family != driverapi.FilterIPv6 {
+			return types.BadRequestErrorf("invalid family %q of filter policy %s: expected %s or %s", r.Family, p.Version, driverapi.FilterIPv4, driverapi.FilterIPv6)
+		}
+		if r.From != nil && r.Family != "" && subnetFamily(r.From) != r.Family {
+			return types.BadRequestErrorf("invalid source %s of filter policy %s: expected an %s subnet", r.From, p.Version, r.Family)
+		}
+		if r.From != nil && r.Source != "" {
+			return types.BadRequestErrorf("invalid rule of filter policy %s: both a subnet and a source expression", p.Version)
+		}
+		if name := strings.TrimPrefix(r.Source, driverapi.FilterSetPrefix); name != r.Source && !iptables.ValidIPSetName(name) {
+			return types.BadRequestErrorf("invalid ipset %q of filter policy %s", name, p.Version)
+		}
+		if addr := strings.TrimPrefix(r.Source, driverapi.FilterMACPrefix); addr != r.Source {
+			if _, err := parseFilterMAC(addr); err != nil {
+				return types.BadRequestErrorf("invalid MAC source of filter policy %s: %v", p.Version, err)
+			}
+		}
+		if code := strings.TrimPrefix(r.Source, driverapi.FilterGeoPrefix); code != r.Source && !geoip.ValidCountry(strings.ToUpper(code)) {
+			return types.BadRequestErrorf("invalid country %q of filter policy %s", code, p.Version)
+		}
+		if err := validateFilterWindow(r.TimeStart, r.TimeStop); err != nil {
+			return types.BadRequestErrorf("invalid time window of filter policy %s: %v", p.Version, err)
+		}
+	}
+	return nil
+}
+
+var filterTimeRe = regexp.MustCompile(`^([01][0-9]|2[0-3]):[0-5][0-9](:[0-5][0-9])?$`)
+
+// validateFilterWindow checks the daily time window of a filter rule, if
+// any, is given as hh:mm[:ss] times the time match takes
+func validateFilterWindow(start, stop string) error {
+	if start == "" && stop == "" {
+		return nil
+	}
+	if start == "" || stop == "" {
+		return errors.New("expected both a start and a stop time")
+	}
+	for _, t := range []string{start, stop} {
+		if !filterTimeRe.MatchString(t) {
+			return fmt.Errorf("expected a time as hh:mm[:ss], got %q", t)
+		}
+	}
+	if start == stop {
+		return fmt.Errorf("the window starting and stopping at %s is empty", start)
+	}
+	return nil
+}
+
+func subnetFamily(subnet *net.IPNet) string {
+	if subnet.IP.To4() != nil {
+		return driverapi.FilterIPv4
+	}
+	return driverapi.FilterIPv6
+}
+
+// filterRuleFamily returns the address family the rule applies to, empty
+// for both
+func filterRuleFamily(r driverapi.FilterRule) string {
+	switch {
+	case r.Family != "":
+		return r.Family
+	case r.From != nil:
+		return subnetFamily(r.From)
+	case strings.HasPrefix(r.Source, driverapi.FilterSetPrefix):
+		// The sets of the policies applied before the family got recorded
+		// are IPv4 ones
+		return driverapi.FilterIPv4
+	}
+	return ""
+}
+
+// groupFilterRules groups the rules of the filter policy by the address
+// family they apply to, the rules of both families being in each group
+func groupFilterRules(p *driverapi.FilterPolicy) map[string][]driverapi.FilterRule {
+	groups := map[string][]driverapi.FilterRule{}
+	for _, r := range p.Allow {
+		switch f := filterRuleFamily(r); f {
+		case "":
+			groups[driverapi.FilterIPv4] = append(groups[driverapi.FilterIPv4], r)
+			groups[driverapi.FilterIPv6] = append(groups[driverapi.FilterIPv6], r)
+		default:
+			groups[f] = append(groups[f], r)
+		}
+	}
+	return groups
+}
+
+// filterFamily is the address family of the ingress traffic of the
+// endpoints a filter policy is programmed for, through iptables or
+// ip6tables
+type filterFamily struct {
+	name   string
+	ipv    iptables.IPV
+	reject string
+}
+
+var (
+	filterIPv4 = &filterFamily{name: driverapi.FilterIPv4, ipv: iptables.Iptables, reject: "icmp-admin-prohibited"}
+	filterIPv6 = &filterFamily{name: driverapi.FilterIPv6, ipv: iptables.IP6Tables, reject: "icmp6-adm-prohibited"}
+)
+
+// addr returns the address of the endpoint in the family, nil if it has
+// none
+func (f *filterFamily) addr(ep *bridgeEndpoint) *net.IPNet {
+	if f.ipv == iptables.IP6Tables {
+		return ep.addrv6
+	}
+	return ep.addr
+}
+
+func (f *filterFamily) exists(rule []string) bool {
+	if f.ipv == iptables.IP6Tables {
+		return iptables.Exists6(iptables.Filter, FilterChain, rule...)
+	}
+	return iptables.Exists(iptables.Filter, FilterChain, rule...)
+}
+
+func (f *filterFamily) program(action iptables.Action, rule []string) error {
+	if f.ipv == iptables.IP6Tables {
+		return iptables.ProgramRule6(iptables.Filter, FilterChain, action, rule)
+	}
+	return iptables.ProgramRule(iptables.Filter, FilterChain, action, rule)
+}
+
+func (f *filterFamily) counters() ([]iptables.CountedRule, error) {
+	if f.ipv == iptables.IP6Tables {
+		return iptables.RuleCounters6(iptables.Filter, FilterChain)
+	}
+	return iptables.RuleCounters(iptables.Filter, FilterChain)
+}
+
+// filterFamilies returns the families of the addresses of the endpoint
+func filterFamilies(ep *bridgeEndpoint) []*filterFamily {
+	var families []*filterFamily
+	for _, f := range []*filterFamily{filterIPv4, filterIPv6} {
+		if f.addr(ep) != nil {
+			families = append(families, f)
+		}
+	}
+	return families
+}
+
+// filterRules renders the rules of the filter policy of the endpoint in
+// the family, tagged with the endpoint and marked with their tier and the
+// version of the policy. The replies of the connections of the endpoint
+// get through ahead of the allowed traffic, the rest of its ingress
+// traffic of the family is rejected last, or logged and let through by an
+// audit policy. The verdict is the last rule.
+func filterRules(bridgeName string, ep *bridgeEndpoint, p *driverapi.FilterPolicy, f *filterFamily) [][]string {
+	dst := []string{"-o", bridgeName, "-d", f.addr(ep).IP.String()}
+	rule := func(tier iptables.Tier, args ...string) []string {
+		tagged := iptables.TagRule(networkType, ep.id, append(append([]string{}, dst...), args...))
+		return iptables.CommentRule(filterCommentPrefix+p.Version, iptables.TierRule(tier, tagged))
+	}
+
+	rules := [][]string{
+		rule(iptables.TierPlatform, "-m", "conntrack", "--ctstate", "RELATED,ESTABLISHED", "-j", "RETURN"),
+	}
+	for _, r := range groupFilterRules(p)[f.name] {
+		var args []string
+		if r.From != nil {
+			args = append(args, "-s", r.From.String())
+		} else if name := strings.TrimPrefix(r.Source, driverapi.FilterSetPrefix); name != r.Source {
+			args = append(args, "-m", "set", "--match-set", name, "src")
+		} else if addr := strings.TrimPrefix(r.Source, driverapi.FilterMACPrefix); addr != r.Source {
+			args = append(args, "-m", "mac", "--mac-source", addr)
+		} else if code := strings.TrimPrefix(r.Source, driverapi.FilterGeoPrefix); code != r.Source {
+			args = append(args, "-m", "set", "--match-set", geoip.SetName(code, f == filterIPv6), "src")
+		}
+		if r.TimeStart != "" {
+			args = append(args, "-m", "time", "--timestart", r.TimeStart, "--timestop", r.TimeStop, "--kerneltz")
+		}
+		ports := strings.Replace(r.Ports, "-", ":", 1)
+		rules = append(rules, rule(iptables.TierTenant, append(args, "-p", r.Proto, "--dport", ports, "-j", "RETURN")...))
+	}
+	if p.Audit {
+		prefix := fmt.Sprintf("lnet-audit:%.12s", ep.id)
+		return append(rules, rule(iptables.TierDefault, "-j", "NFLOG", "--nflog-group", strconv.Itoa(FilterAuditNFLOGGroup), "--nflog-prefix", prefix))
+	}
+	return append(rules, rule(iptables.TierDefault, "-j", "REJECT", "--reject-with", f.reject))
+}
+
+// switchFilterAudit replaces the verdict of the filter policy of the
+// endpoint, the only rule the audit and enforced modes of a version do not
+// share, the new verdict being in place before the previous one goes away
+func (n *bridgeNetwork) switchFilterAudit(ep *bridgeEndpoint, prev, p *driverapi.FilterPolicy) error {
+	n.Lock()
+	bridgeName := n.config.BridgeName
+	n.Unlock()
+
+	for _, f := range filterFamilies(ep) {
+		if f == filterIPv6 && !filterChain6Exists() {
+			// Only the IPv4 traffic got filtered, without ip6tables
+			continue
+		}
+		prevRules, rules := filterRules(bridgeName, ep, prev, f), filterRules(bridgeName, ep, p, f)
+		verdict, prevVerdict := rules[len(rules)-1], prevRules[len(prevRules)-1]
+		if !f.exists(verdict) {
+			if err := f.program(iptables.Append, verdict); err != nil {
+				return fmt.Errorf("failed to switch the audit of the filter policy %s of endpoint %.7s: %v", p.Version, ep.id, err)
+			}
+		}
+		if err := f.program(iptables.Delete, prevVerdict); err != nil {
+			logrus.Warnf("Failed to remove the filter rule %v of endpoint %.7s: %v", prevVerdict, ep.id, err)
+		}
+	}
+	return nil
+}
+
+// programFilter adds or removes the rules of the filter policy of the
+// endpoint
+func (n *bridgeNetwork) programFilter(ep *bridgeEndpoint, p *driverapi.FilterPolicy, enable bool) error {
+	n.Lock()
+	bridgeName := n.config.BridgeName
+	n.Unlock()
+
+	action := iptables.Delete
+	if enable {
+		action = iptables.Append
+		if err := n.loadGeoSets(p); err != nil {
+			return err
+		}
+	}
+	for _, f := range filterFamilies(ep) {
+		if enable && f == filterIPv6 {
+			if err := setupFilterChain6(); err != nil {
+				if ep.addr == nil {
+					return err
+				}
+				logrus.Warnf("Filtering the IPv4 traffic of endpoint %.7s only: %v", ep.id, err)
+				continue
+			}
+		}
+		for _, rule := range filterRules(bridgeName, ep, p, f) {
+			if enable == f.exists(rule) {
+				continue
+			}
+			if err := f.program(action, rule); err != nil {
+				if !enable {
+					logrus.Warnf("Failed to remove the filter rule %v of endpoint %.7s: %v", rule, ep.id, err)
+					continue
+				}
+				return fmt.Errorf("failed to program the filter policy %s of endpoint %.7s: %v", p.Version, ep.id, err)
+			}
+		}
+	}
+	return nil
+}
+
+// FilterEndpoint replaces the filter policy of the endpoint, or lifts it
+// when the policy is nil. The rules of the new version are in place before
+// the ones of the previous version go away.
+func (d *driver) FilterEndpoint(nid, eid string, policy *driverapi.FilterPolicy) error {
+	if policy != nil {
+		if err := validateFilterPolicy(policy); err != nil {
+			return err
+		}
+	}
+	if !d.config.EnableIPTables {
+		return types.NotImplementedErrorf("the filter policies of the endpoints require iptables to be enabled")
+	}
+	n, err := d.getNetwork(nid)
+	if err != nil {
+		return err
+	}
+	ep, err := n.getEndpoint(eid)
+	if err != nil {
+		return err
+	}
+	if ep == nil {
+		return EndpointNotFoundError(eid)
+	}
+	if ep.addr == nil && ep.addrv6 == nil {
+		return types.BadRequestErrorf("endpoint %.7s has no address to filter", eid)
+	}
+	if policy != nil {
+		if policy, err = n.expandFilterPolicy(policy); err != nil {
+			return err
+		}
+		if err := checkFilterFamilies(ep, policy); err != nil {
+			return err
+		}
+	}
+
+	prev := n.filterOf(ep)
+	if prev != nil && policy != nil && prev.Version == policy.Version {
+		if !reflect.DeepEqual(prev.Allow, policy.Allow) {
+			return types.ForbiddenErrorf("filter policy %s of endpoint %.7s is applied with other rules", policy.Version, eid)
+		}
+		if prev.Audit == policy.Audit {
+			return nil
+		}
+		if err := n.switchFilterAudit(ep, prev, policy); err != nil {
+			return err
+		}
+	} else {
+		if prev == nil && policy == nil {
+			return nil
+		}
+		if policy != nil {
+			if err := n.programFilter(ep, policy, true); err != nil {
+				n.programFilter(ep, policy, false)
+				return err
+			}
+		}
+		if prev != nil {
+			n.programFilter(ep, prev, false)
+		}
+	}
+
+	n.Lock()
+	ep.filter = policy
+	n.Unlock()
+	if err := d.storeUpdate(ep); err != nil {
+		logrus.Warnf("Failed to update bridge endpoint %.7s to store: %v", ep.id, err)
+	}
+	return nil
+}
+
+// FilterCounters returns the packets the allowed rules of the filter
+// policy of the endpoint let through and the ones it rejected
+func (d *driver) FilterCounters(nid, eid string) (*driverapi.FilterCounters, error) {
+	n, err := d.getNetwork(nid)
+	if err != nil {
+		return nil, err
+	}
+	ep, err := n.getEndpoint(eid)
+	if err != nil {
+		return nil, err
+	}
+	if ep == nil {
+		return nil, EndpointNotFoundError(eid)
+	}
+	p := n.filterOf(ep)
+	if p == nil {
+		return nil, nil
+	}
+
+	c := &driverapi.FilterCounters{Version: p.Version, Audit: p.Audit}
+	owner, version := types.OwnerTag(networkType, ep.id), filterCommentPrefix+p.Version
+	for _, f := range filterFamilies(ep) {
+		if f == filterIPv6 && !filterChain6Exists() {
+			// Only the IPv4 traffic got filtered, without ip6tables
+			continue
+		}
+		rules, err := f.counters()
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range rules {
+			if !hasComment(r.Args, owner) || !hasComment(r.Args, version) {
+				continue
+			}
+			switch tier, _ := iptables.RuleTier(r.Args); tier {
+			case iptables.TierTenant:
+				c.Accepted += r.Packets
+			case iptables.TierDefault:
+				c.Rejected += r.Packets
+			}
+		}
+	}
+	return c, nil
+}
+
+// checkFilterFamilies checks the endpoint has an address in each family
+// the rules of the filter policy are restricted to
+func checkFilterFamilies(ep *bridgeEndpoint, p *driverapi.FilterPolicy) error {
+	for _, r := range p.Allow {
+		switch f := filterRuleFamily(r); {
+		case f == driverapi.FilterIPv4 && ep.addr == nil:
+			return types.BadRequestErrorf("filter policy %s has IPv4 rules, endpoint %.7s has no IPv4 address", p.Version, ep.id)
+		case f == driverapi.FilterIPv6 && ep.addrv6 == nil:
+			return types.BadRequestErrorf("filter policy %s has IPv6 rules, endpoint %.7s has no IPv6 address", p.Version, ep.id)
+		}
+	}
+	return nil
+}
+
+func hasComment(args []string, comment string) bool {
+	for i := 0; i < len(args)-1; i++ {
+		if args[i] == "--comment" && args[i+1] == comment {
+			return true
+		}
+	}
+	return false
+}
+
+// filterOf returns the filter policy of the endpoint, if any
+func (n *bridgeNetwork) filterOf(ep *bridgeEndpoint) *driverapi.FilterPolicy {
+	n.Lock()
+	defer n.Unlock()
+	return ep.filter
+}
+
+// setupFilterChain creates the filter chain, its jump from FORWARD is
+// added along with the other chains of the networks
+func setupFilterChain() error {
+	if _, err := iptables.NewChain(FilterChain, iptables.Filter, false); err != nil {
+		return fmt.Errorf("failed to create FILTER filter policy chain: %v", err)
+	}
+	return nil
+}
+
+// setupFilterChain6 creates the ip6tables filter chain and its jump from
+// FORWARD, on the first endpoint with an IPv6 address getting a filter
+// policy
+func setupFilterChain6() error {
+	if !filterChain6Exists() {
+		if _, err := iptables.Raw6("-t", string(iptables.Filter), "-N", FilterChain); err != nil {
+			return fmt.Errorf("failed to create the IPv6 FILTER filter policy chain: %v", err)
+		}
+	}
+	jump := []string{"-j", FilterChain}
+	if !iptables.Exists6(iptables.Filter, "FORWARD", jump...) {
+		if err := iptables.ProgramRule6(iptables.Filter, "FORWARD", iptables.Insert, jump); err != nil {
+			return fmt.Errorf("failed to add the jump to the IPv6 FILTER filter policy chain: %v", err)
+		}
+	}
+	return nil
+}
+
+// filterChain6Exists tells whether the ip6tables filter chain exists, which
+// it does not on the hosts without ip6tables
+func filterChain6Exists() bool {
+	_, err := iptables.Raw6("-t", string(iptables.Filter), "-n", "-L", FilterChain)
+	return err == nil
+}
+
+// removeFilterChain6 flushes the ip6tables filter chain left by a previous
+// run. Errors are ignored: the chain, or ip6tables, may not exist.
+func removeFilterChain6() {
+	iptables.Raw6("-t", string(iptables.Filter), "-F", FilterChain)
+}
+
+// restoreFilters programs back the filter policies of the endpoints, after
+// the chain got flushed
+func (d *driver) restoreFilters() {
+	for _, n := range d.getNetworks() {
+		n.Lock()
+		eps := make([]*bridgeEndpoint, 0, len(n.endpoints))
+		for _, ep := range n.endpoints {
+			eps = append(eps, ep)
+		}
+		n.Unlock()
+
+		for _, ep := range eps {
+			if p := n.filterOf(ep); p != nil {
+				if err := n.programFilter(ep, p, true); err != nil {
+					logrus.Warn(err)
+				}
+			}
+		}
+	}
+}
+
+// expandFilterPolicy returns a copy of the filter policy with the subnets
+// of its rules expanded from their source expressions, against the
+// addresses of the host and of the network. The ipsets of the rules are
+// checked to exist, and the rules get the family of their addresses. The
+// MAC addresses are put in their canonical form, and the country codes in
+// upper case.
+func (n *bridgeNetwork) expandFilterPolicy(p *driverapi.FilterPolicy) (*driverapi.FilterPolicy, error) {
+	clone := *p
+	clone.Allow = append([]driverapi.FilterRule{}, p.Allow...)
+
+	var vars map[string]string
+	for i, r := range clone.Allow {
+		if r.Source == "" {
+			continue
+		}
+		if name := strings.TrimPrefix(r.Source, driverapi.FilterSetPrefix); name != r.Source {
+			family, err := checkFilterSet(name, p.Version)
+			if err != nil {
+				return nil, err
+			}
+			if r.Family != "" && r.Family != family {
+				return nil, types.BadRequestErrorf("ipset %s of filter policy %s holds %s addresses, the rule is an %s one", name, p.Version, family, r.Family)
+			}
+			clone.Allow[i].Family = family
+			continue
+		}
+		if addr := strings.TrimPrefix(r.Source, driverapi.FilterMACPrefix); addr != r.Source {
+			mac, err := parseFilterMAC(addr)
+			if err != nil {
+				return nil, types.BadRequestErrorf("invalid MAC source of filter policy %s: %v", p.Version, err)
+			}
+			clone.Allow[i].Source = driverapi.FilterMACPrefix + mac.String()
+			continue
+		}
+		if code := strings.TrimPrefix(r.Source, driverapi.FilterGeoPrefix); code != r.Source {
+			if n.driver == nil || n.driver.geoIP == nil {
+				return nil, types.NotImplementedErrorf("the country %s of filter policy %s requires a GeoIP provider", code, p.Version)
+			}
+			clone.Allow[i].Source = driverapi.FilterGeoPrefix + strings.ToUpper(code)
+			continue
+		}
+		if vars == nil {
+			vars = n.filterVariables()
+		}
+		from, err := expandFilterSource(r.Source, vars)
+		if err != nil {
+			return nil, types.BadRequestErrorf("invalid source %q of filter policy %s: %v", r.Source, p.Version, err)
+		}
+		if r.Family != "" && subnetFamily(from) != r.Family {
+			return nil, types.BadRequestErrorf("invalid source %q of filter policy %s: expected an %s subnet, got %s", r.Source, p.Version, r.Family, from)
+		}
+		clone.Allow[i].From = from
+	}
+	return &clone, nil
+}
+
+// parseFilterMAC parses the MAC address of a source expression, the 48-bit
+// addresses of the ethernet frames the mac match checks
+func parseFilterMAC(addr string) (net.HardwareAddr, error) {
+	mac, err := net.ParseMAC(addr)
+	if err != nil {
+		return nil, err
+	}
+	if len(mac) != 6 {
+		return nil, fmt.Errorf("expected a 48-bit MAC address, got %q", addr)
+	}
+	return mac, nil
+}
+
+// loadGeoSets loads the ipsets of the countries of the filter policy, for
+// its rules to match
+func (n *bridgeNetwork) loadGeoSets(p *driverapi.FilterPolicy) error {
+	for _, r := range p.Allow {
+		code := strings.TrimPrefix(r.Source, driverapi.FilterGeoPrefix)
+		if code == r.Source {
+			continue
+		}
+		if n.driver == nil || n.driver.geoIP == nil {
+			return types.NotImplementedErrorf("the country %s of filter policy %s requires a GeoIP provider", code, p.Version)
+		}
+		if err := n.driver.geoIP.Load(code); err != nil {
+			return fmt.Errorf("failed to load the sets of country %s of filter policy %s: %v", code, p.Version, err)
+		}
+	}
+	return nil
+}
+
+// lookupIPSet returns the header of the ipset of the host
+var lookupIPSet = iptables.LookupIPSet
+
+// checkFilterSet checks the ipset of the filter policy exists, and returns
+// the family of the addresses or subnets it holds
+func checkFilterSet(name, version string) (string, error) {
+	set, err := lookupIPSet(name)
+	if err != nil {
+		if _, ok := err.(types.NotFoundError); ok {
+			return "", types.NotFoundErrorf("ipset %s of filter policy %s does not exist", name, version)
+		}
+		return "", fmt.Errorf("failed to check ipset %s of filter policy %s: %v", name, version, err)
+	}
+	switch set.Family {
+	case "inet":
+		return driverapi.FilterIPv4, nil
+	case "inet6":
+		return driverapi.FilterIPv6, nil
+	}
+	return "", types.BadRequestErrorf("ipset %s of filter policy %s is a %s set of family %q: expected the inet or inet6 family", name, version, set.Type, set.Family)
+}
+
+// filterVariables returns the values of the variables of the source
+// expressions on the network. HOST_IP is the host address the ports of the
+// network are published on, or else the address of the host the default
+// route goes out from.
+func (n *bridgeNetwork) filterVariables() map[string]string {
+	n.Lock()
+	config, bridge := n.config, n.bridge
+	n.Unlock()
+
+	vars := map[string]string{}
+	if bridge != nil && bridge.bridgeIPv4 != nil {
+		gw := bridge.bridgeIPv4
+		vars["GATEWAY"] = gw.IP.String()
+		vars["NETWORK_SUBNET"] = (&net.IPNet{IP: gw.IP.Mask(gw.Mask), Mask: gw.Mask}).String()
+	}
+	if ip := config.DefaultBindingIP; ip != nil && !ip.IsUnspecified() && ip.To4() != nil {
+		vars["HOST_IP"] = ip.String()
+	} else if ip, err := defaultHostIP(); err == nil {
+		vars["HOST_IP"] = ip.String()
+	} else {
+		logrus.Debugf("No host address for the filter policies of network %.7s: %v", config.ID, err)
+	}
+	return vars
+}
+
+// defaultHostIP returns the IPv4 address of the host the default route
+// goes out from
+var defaultHostIP = func() (net.IP, error) {
+	nlh := ns.NlHandle()
+	routes, err := nlh.RouteList(nil, netlink.FAMILY_V4)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range routes {
+		if r.Dst != nil {
+			continue
+		}
+		if r.Src != nil {
+			return r.Src, nil
+		}
+		link, err := nlh.LinkByIndex(r.LinkIndex)
+		if err != nil {
+			return nil, err
+		}
+		addrs, err := nlh.AddrList(link, netlink.FAMILY_V4)
+		if err != nil {
+			return nil, err
+		}
+		if len(addrs) > 0 {
+			return addrs[0].IP, nil
+		}
+	}
+	return nil, errors.New("no default route")
+}
+
+// expandFilterSource expands the variables of the source expression, an
+// address or a subnet, the addresses standing for their /32 or /128
+func expandFilterSource(src string, vars map[string]string) (*net.IPNet, error) {
+	var unknown []string
+	expanded := os.Expand(src, func(name string) string {
+		v, ok := vars[name]
+		if !ok {
+			unknown = append(unknown, name)
+		}
+		return v
+	})
+	if len(unknown) > 0 {
+		return nil, fmt.Errorf("undefined variables %s", strings.Join(unknown, ", "))
+	}
+	if !strings.Contains(expanded, "/") {
+		if strings.Contains(expanded, ":") {
+			expanded += "/128"
+		} else {
+			expanded += "/32"
+		}
+	}
+	_, subnet, err := net.ParseCIDR(expanded)
+	if err != nil {
+		return nil, fmt.Errorf("expected an address or a subnet, got %q", expanded)
+	}
+	return subnet, nil
+}
@@ -0,0 +1,486 @@
+package bridge
+
+import (
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/docker/libnetwork/driverapi"
+	"github.com/docker/libnetwork/geoip"
+	"github.com/docker/libnetwork/iptables"
+	"github.com/docker/libnetwork/testutils/fakeiptables"
+	"github.com/docker/libnetwork/types"
+)
+
+func TestFilterRules(t *testing.T) {
+	ep := &bridgeEndpoint{id: "ep1", addr: &net.IPNet{IP: net.ParseIP("172.18.0.2"), Mask: net.CIDRMask(16, 32)}}
+	_, from, _ := net.ParseCIDR("10.0.0.0/8")
+	p := &driverapi.FilterPolicy{Version: "v2", Allow: []driverapi.FilterRule{{Proto: "tcp", Ports: "8000-8080", From: from}}}
+	tags := func(tier string) []string {
+		return []string{"-m", "comment", "--comment", "lnet:bridge:ep1", "-m", "comment", "--comment", "lnet-tier:" + tier, "-m", "comment", "--comment", "lnet-filter:v2"}
+	}
+	rule := func(tier string, match []string, target ...string) []string {
+		r := append([]string{"-o", "br0", "-d", "172.18.0.2"}, match...)
+		return append(append(r, tags(tier)...), target...)
+	}
+	expected := [][]string{
+		rule("platform", []string{"-m", "conntrack", "--ctstate", "RELATED,ESTABLISHED"}, "-j", "RETURN"),
+		rule("tenant", []string{"-s", "10.0.0.0/8", "-p", "tcp", "--dport", "8000:8080"}, "-j", "RETURN"),
+		rule("default", nil, "-j", "REJECT", "--reject-with", "icmp-admin-prohibited"),
+	}
+	if rules := filterRules("br0", ep, p, filterIPv4); !reflect.DeepEqual(rules, expected) {
+		t.Fatalf("unexpected rules:\n%v\nexpected:\n%v", rules, expected)
+	}
+	if families := filterFamilies(ep); len(families) != 1 || families[0] != filterIPv4 {
+		t.Fatalf("unexpected families %v of the IPv4 endpoint", families)
+	}
+
+	// The rules of a dual-stack endpoint are grouped by family, the ones
+	// without a source going to both
+	ep.addrv6 = &net.IPNet{IP: net.ParseIP("fd00::2"), Mask: net.CIDRMask(64, 128)}
+	_, from6, _ := net.ParseCIDR("fd01::/64")
+	p.Allow = append(p.Allow, driverapi.FilterRule{Proto: "udp", Ports: "53", From: from6}, driverapi.FilterRule{Proto: "tcp", Ports: "443"})
+	rule6 := func(tier string, match []string, target ...string) []string {
+		r := append([]string{"-o", "br0", "-d", "fd00::2"}, match...)
+		return append(append(r, tags(tier)...), target...)
+	}
+	expected = append(expected[:2:2],
+		rule("tenant", []string{"-p", "tcp", "--dport", "443"}, "-j", "RETURN"),
+		expected[2])
+	expected6 := [][]string{
+		rule6("platform", []string{"-m", "conntrack", "--ctstate", "RELATED,ESTABLISHED"}, "-j", "RETURN"),
+		rule6("tenant", []string{"-s", "fd01::/64", "-p", "udp", "--dport", "53"}, "-j", "RETURN"),
+		rule6("tenant", []string{"-p", "tcp", "--dport", "443"}, "-j", "RETURN"),
+		rule6("default", nil, "-j", "REJECT", "--reject-with", "icmp6-adm-prohibited"),
+	}
+	if rules := filterRules("br0", ep, p, filterIPv4); !reflect.DeepEqual(rules, expected) {
+		t.Fatalf("unexpected IPv4 rules:\n%v\nexpected:\n%v", rules, expected)
+	}
+	if rules := filterRules("br0", ep, p, filterIPv6); !reflect.DeepEqual(rules, expected6) {
+		t.Fatalf("unexpected IPv6 rules:\n%v\nexpected:\n%v", rules, expected6)
+	}
+	if err := validateFilterPolicy(p); err != nil {
+		t.Fatal(err)
+	}
+
+	// The rules restricted to a family need an address of the family
+	ep.addr = nil
+	if err := checkFilterFamilies(ep, p); err == nil {
+		t.Fatal("IPv4 rules accepted for an IPv6-only endpoint")
+	}
+	p.Allow = p.Allow[1:]
+	if err := checkFilterFamilies(ep, p); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, p := range []*driverapi.FilterPolicy{
+		{Version: "v 1"},
+		{Version: "v1", Allow: []driverapi.FilterRule{{Proto: "icmp", Ports: "80"}}},
+		{Version: "v1", Allow: []driverapi.FilterRule{{Proto: "tcp", Ports: "80-70"}}},
+		{Version: "v1", Allow: []driverapi.FilterRule{{Proto: "tcp", Ports: "80", From: &net.IPNet{IP: net.ParseIP("fd00::"), Mask: net.CIDRMask(64, 128)}, Family: driverapi.FilterIPv4}}},
+		{Version: "v1", Allow: []driverapi.FilterRule{{Proto: "tcp", Ports: "80", Family: "inet"}}},
+	} {
+		if err := validateFilterPolicy(p); err == nil {
+			t.Fatalf("invalid filter policy %+v accepted", p)
+		}
+	}
+}
+
+func TestExpandFilterPolicy(t *testing.T) {
+	defer func(f func() (net.IP, error)) { defaultHostIP = f }(defaultHostIP)
+	defaultHostIP = func() (net.IP, error) { return net.ParseIP("192.0.2.20"), nil }
+
+	n := &bridgeNetwork{
+		config: &networkConfiguration{BridgeName: "br0"},
+		bridge: &bridgeInterface{bridgeIPv4: &net.IPNet{IP: net.ParseIP("172.18.0.1"), Mask: net.CIDRMask(16, 32)}},
+	}
+	p := &driverapi.FilterPolicy{Version: "v1", Allow: []driverapi.FilterRule{
+		{Proto: "tcp", Ports: "80", Source: "${NETWORK_SUBNET}"},
+		{Proto: "tcp", Ports: "22", Source: "${HOST_IP}"},
+		{Proto: "udp", Ports: "53", Source: "${GATEWAY}/32"},
+		{Proto: "tcp", Ports: "443"},
+		{Proto: "tcp", Ports: "8443", Source: "fd00::1"},
+	}}
+	expanded, err := n.expandFilterPolicy(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var from []string
+	for _, r := range expanded.Allow {
+		if r.From != nil {
+			from = append(from, r.From.String())
+		}
+	}
+	if !reflect.DeepEqual(from, []string{"172.18.0.0/16", "192.0.2.20/32", "172.18.0.1/32", "fd00::1/128"}) {
+		t.Fatalf("unexpected expanded sources %v", from)
+	}
+	if p.Allow[0].From != nil {
+		t.Fatal("the expansion modified the policy")
+	}
+
+	// The publishing address of the network is the host address
+	n.config.DefaultBindingIP = net.ParseIP("192.0.2.10")
+	if expanded, err = n.expandFilterPolicy(p); err != nil {
+		t.Fatal(err)
+	}
+	if from := expanded.Allow[1].From.String(); from != "192.0.2.10/32" {
+		t.Fatalf("unexpected host address %s", from)
+	}
+
+	for _, src := range []string{"${SUBNET}", "${GATEWAY}/40", "host"} {
+		p := &driverapi.FilterPolicy{Version: "v1", Allow: []driverapi.FilterRule{{Proto: "tcp", Ports: "80", Source: src}}}
+		if _, err := n.expandFilterPolicy(p); err == nil {
+			t.Fatalf("invalid source %q accepted", src)
+		}
+	}
+	v6 := &driverapi.FilterPolicy{Version: "v1", Allow: []driverapi.FilterRule{{Proto: "tcp", Ports: "80", Source: "${HOST_IP}", Family: driverapi.FilterIPv6}}}
+	if _, err := n.expandFilterPolicy(v6); err == nil {
+		t.Fatal("IPv4 source accepted for an IPv6 rule")
+	}
+	_, from10, _ := net.ParseCIDR("10.0.0.0/8")
+	both := &driverapi.FilterPolicy{Version: "v1", Allow: []driverapi.FilterRule{{Proto: "tcp", Ports: "80", From: from10, Source: "${HOST_IP}"}}}
+	if err := validateFilterPolicy(both); err == nil {
+		t.Fatal("rule with both a subnet and a source accepted")
+	}
+}
+
+func TestFilterPolicyMAC(t *testing.T) {
+	n := &bridgeNetwork{config: &networkConfiguration{BridgeName: "br0"}}
+	p := &driverapi.FilterPolicy{Version: "v1", Allow: []driverapi.FilterRule{{Proto: "tcp", Ports: "502", Source: "mac:AA-BB-CC-DD-EE-FF"}}}
+	if err := validateFilterPolicy(p); err != nil {
+		t.Fatal(err)
+	}
+	expanded, err := n.expandFilterPolicy(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := expanded.Allow[0]; r.Source != "mac:aa:bb:cc:dd:ee:ff" || r.From != nil || r.Family != "" {
+		t.Fatalf("unexpected expanded rule %+v", r)
+	}
+
+	// The address of the sender is matched in both families
+	ep := &bridgeEndpoint{id: "ep1", addr: &net.IPNet{IP: net.ParseIP("172.18.0.2"), Mask: net.CIDRMask(16, 32)},
+		addrv6: &net.IPNet{IP: net.ParseIP("fd00::2"), Mask: net.CIDRMask(64, 128)}}
+	for _, f := range []*filterFamily{filterIPv4, filterIPv6} {
+		rule := strings.Join(filterRules("br0", ep, expanded, f)[1], " ")
+		if !strings.Contains(rule, "-m mac --mac-source aa:bb:cc:dd:ee:ff -p tcp --dport 502") {
+			t.Fatalf("unexpected %s rule %s", f.name, rule)
+		}
+	}
+
+	for _, src := range []string{"mac:aa:bb:cc:dd:ee", "mac:00:00:00:00:fe:80:00:00:00:00:00:00:02:00:5e:10:00:00:00:01", "mac:host"} {
+		p := &driverapi.FilterPolicy{Version: "v1", Allow: []driverapi.FilterRule{{Proto: "tcp", Ports: "80", Source: src}}}
+		if err := validateFilterPolicy(p); err == nil {
+			t.Fatalf("invalid MAC source %q accepted", src)
+		}
+	}
+}
+
+func TestFilterPolicyWindow(t *testing.T) {
+	_, from, _ := net.ParseCIDR("10.1.0.0/16")
+	p := &driverapi.FilterPolicy{Version: "v1", Allow: []driverapi.FilterRule{{Proto: "tcp", Ports: "22", From: from, TimeStart: "08:00", TimeStop: "18:00"}}}
+	if err := validateFilterPolicy(p); err != nil {
+		t.Fatal(err)
+	}
+	ep := &bridgeEndpoint{id: "ep1", addr: &net.IPNet{IP: net.ParseIP("172.18.0.2"), Mask: net.CIDRMask(16, 32)}}
+	rule := strings.Join(filterRules("br0", ep, p, filterIPv4)[1], " ")
+	if !strings.Contains(rule, "-s 10.1.0.0/16 -m time --timestart 08:00 --timestop 18:00 --kerneltz -p tcp --dport 22") {
+		t.Fatalf("unexpected rule %s", rule)
+	}
+
+	// A window may pass midnight
+	p.Allow[0].TimeStart, p.Allow[0].TimeStop = "22:00:30", "06:00"
+	if err := validateFilterPolicy(p); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, w := range [][2]string{{"08:00", ""}, {"", "18:00"}, {"8:00", "18:00"}, {"08:00", "24:00"}, {"08:00", "18:60"}, {"08:00", "08:00"}, {"08h", "18h"}} {
+		p.Allow[0].TimeStart, p.Allow[0].TimeStop = w[0], w[1]
+		if err := validateFilterPolicy(p); err == nil {
+			t.Fatalf("invalid time window %v accepted", w)
+		}
+	}
+}
+
+type geoProvider map[string][]*net.IPNet
+
+func (p geoProvider) Subnets(country string) ([]*net.IPNet, error) {
+	return p[country], nil
+}
+
+func TestFilterPolicyGeo(t *testing.T) {
+	d := newDriver()
+	n := &bridgeNetwork{config: &networkConfiguration{BridgeName: "br0"}, driver: d}
+	p := &driverapi.FilterPolicy{Version: "v1", Allow: []driverapi.FilterRule{{Proto: "tcp", Ports: "443", Source: "geo:us"}}}
+	if err := validateFilterPolicy(p); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := n.expandFilterPolicy(p); err == nil {
+		t.Fatal("country accepted without a GeoIP provider")
+	} else if _, ok := err.(types.NotImplementedError); !ok {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	d.geoIP = geoip.NewLoader(geoProvider{})
+	expanded, err := n.expandFilterPolicy(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := expanded.Allow[0]; r.Source != "geo:US" || r.From != nil || r.Family != "" {
+		t.Fatalf("unexpected expanded rule %+v", r)
+	}
+
+	// Each family matches the set of the country of its addresses
+	ep := &bridgeEndpoint{id: "ep1", addr: &net.IPNet{IP: net.ParseIP("172.18.0.2"), Mask: net.CIDRMask(16, 32)},
+		addrv6: &net.IPNet{IP: net.ParseIP("fd00::2"), Mask: net.CIDRMask(64, 128)}}
+	if rule := strings.Join(filterRules("br0", ep, expanded, filterIPv4)[1], " "); !strings.Contains(rule, "-m set --match-set lnet-geo4-us src -p tcp --dport 443") {
+		t.Fatalf("unexpected IPv4 rule %s", rule)
+	}
+	if rule := strings.Join(filterRules("br0", ep, expanded, filterIPv6)[1], " "); !strings.Contains(rule, "-m set --match-set lnet-geo6-us src -p tcp --dport 443") {
+		t.Fatalf("unexpected IPv6 rule %s", rule)
+	}
+
+	for _, src := range []string{"geo:USA", "geo:", "geo:1A"} {
+		p := &driverapi.FilterPolicy{Version: "v1", Allow: []driverapi.FilterRule{{Proto: "tcp", Ports: "80", Source: src}}}
+		if err := validateFilterPolicy(p); err == nil {
+			t.Fatalf("invalid country source %q accepted", src)
+		}
+	}
+}
+
+func TestFilterPolicySet(t *testing.T) {
+	defer func(f func(string) (*iptables.IPSet, error)) { lookupIPSet = f }(lookupIPSet)
+	lookupIPSet = func(name string) (*iptables.IPSet, error) {
+		switch name {
+		case "corp-admin-nets":
+			return &iptables.IPSet{Name: name, Type: "hash:net", Family: "inet"}, nil
+		case "corp-admin-nets6":
+			return &iptables.IPSet{Name: name, Type: "hash:net", Family: "inet6"}, nil
+		}
+		return nil, types.NotFoundErrorf("ipset %s does not exist", name)
+	}
+
+	n := &bridgeNetwork{config: &networkConfiguration{BridgeName: "br0"}}
+	p := &driverapi.FilterPolicy{Version: "v1", Allow: []driverapi.FilterRule{{Proto: "tcp", Ports: "22", Source: "set:corp-admin-nets"}}}
+	if err := validateFilterPolicy(p); err != nil {
+		t.Fatal(err)
+	}
+	expanded, err := n.expandFilterPolicy(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expanded.Allow[0].From != nil {
+		t.Fatalf("unexpected subnet %s of the set", expanded.Allow[0].From)
+	}
+	if expanded.Allow[0].Family != driverapi.FilterIPv4 {
+		t.Fatalf("unexpected family %q of the set", expanded.Allow[0].Family)
+	}
+	ep := &bridgeEndpoint{id: "ep1", addr: &net.IPNet{IP: net.ParseIP("172.18.0.2"), Mask: net.CIDRMask(16, 32)},
+		addrv6: &net.IPNet{IP: net.ParseIP("fd00::2"), Mask: net.CIDRMask(64, 128)}}
+	rule := strings.Join(filterRules("br0", ep, expanded, filterIPv4)[1], " ")
+	if !strings.Contains(rule, "-m set --match-set corp-admin-nets src -p tcp --dport 22") {
+		t.Fatalf("unexpected rule %s", rule)
+	}
+	if rules := filterRules("br0", ep, expanded, filterIPv6); len(rules) != 2 {
+		t.Fatalf("unexpected IPv6 rules %v of an IPv4 set", rules)
+	}
+
+	p.Allow[0].Source = "set:missing"
+	if _, err := n.expandFilterPolicy(p); err == nil {
+		t.Fatal("missing ipset accepted")
+	} else if _, ok := err.(types.NotFoundError); !ok {
+		t.Fatalf("unexpected error %v", err)
+	}
+	p.Allow[0].Source = "set:corp-admin-nets6"
+	if expanded, err = n.expandFilterPolicy(p); err != nil {
+		t.Fatal(err)
+	}
+	if expanded.Allow[0].Family != driverapi.FilterIPv6 {
+		t.Fatalf("unexpected family %q of the IPv6 set", expanded.Allow[0].Family)
+	}
+	p.Allow[0].Family = driverapi.FilterIPv4
+	if _, err := n.expandFilterPolicy(p); err == nil {
+		t.Fatal("IPv6 ipset accepted for an IPv4 rule")
+	}
+	p.Allow[0].Family = ""
+	p.Allow[0].Source = "set:bad name"
+	if err := validateFilterPolicy(p); err == nil {
+		t.Fatal("invalid ipset name accepted")
+	}
+}
+
+func TestFilterEndpoint(t *testing.T) {
+	ipt := fakeiptables.New()
+	defer ipt.Install()()
+
+	if err := setupFilterChain(); err != nil {
+		t.Fatal(err)
+	}
+	d := newDriver()
+	d.config = &configuration{EnableIPTables: true}
+	ep := &bridgeEndpoint{id: "ep1", addr: &net.IPNet{IP: net.ParseIP("172.18.0.2"), Mask: net.CIDRMask(16, 32)}}
+	n := &bridgeNetwork{id: "net1", config: &networkConfiguration{BridgeName: "br0"},
+		endpoints: map[string]*bridgeEndpoint{ep.id: ep}, driver: d}
+	d.networks[n.id] = n
+
+	v1 := &driverapi.FilterPolicy{Version: "v1", Allow: []driverapi.FilterRule{{Proto: "tcp", Ports: "80"}}}
+	if err := d.FilterEndpoint("net1", "ep1", v1); err != nil {
+		t.Fatal(err)
+	}
+	if rules := ipt.IPv4().Rules(iptables.Filter, FilterChain); len(rules) != 3 {
+		t.Fatalf("unexpected rules %v", rules)
+	}
+
+	changed := &driverapi.FilterPolicy{Version: "v1", Allow: []driverapi.FilterRule{{Proto: "udp", Ports: "53"}}}
+	if err := d.FilterEndpoint("net1", "ep1", changed); err == nil {
+		t.Fatal("other rules accepted under the same version")
+	} else if _, ok := err.(types.ForbiddenError); !ok {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	v2 := &driverapi.FilterPolicy{Version: "v2", Allow: []driverapi.FilterRule{{Proto: "tcp", Ports: "80"}, {Proto: "tcp", Ports: "443"}}}
+	if err := d.FilterEndpoint("net1", "ep1", v2); err != nil {
+		t.Fatal(err)
+	}
+	rules := ipt.IPv4().Rules(iptables.Filter, FilterChain)
+	if len(rules) != 4 {
+		t.Fatalf("unexpected rules %v", rules)
+	}
+	for _, r := range rules {
+		if !hasComment(r, "lnet-filter:v2") {
+			t.Fatalf("rule %v of the previous version left behind", r)
+		}
+	}
+	c, err := d.FilterCounters("net1", "ep1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c == nil || c.Version != "v2" {
+		t.Fatalf("unexpected counters %+v", c)
+	}
+
+	// The audit of the version only replaces its verdict
+	audit := *v2
+	audit.Audit = true
+	if err := d.FilterEndpoint("net1", "ep1", &audit); err != nil {
+		t.Fatal(err)
+	}
+	rules = ipt.IPv4().Rules(iptables.Filter, FilterChain)
+	if len(rules) != 4 {
+		t.Fatalf("unexpected rules %v", rules)
+	}
+	if last := strings.Join(rules[3], " "); !strings.Contains(last, "-j NFLOG --nflog-group 100 --nflog-prefix lnet-audit:ep1") {
+		t.Fatalf("unexpected verdict %s of the audit policy", last)
+	}
+	if c, err := d.FilterCounters("net1", "ep1"); err != nil || !c.Audit {
+		t.Fatalf("unexpected counters %+v: %v", c, err)
+	}
+	if err := d.FilterEndpoint("net1", "ep1", v2); err != nil {
+		t.Fatal(err)
+	}
+	if rules = ipt.IPv4().Rules(iptables.Filter, FilterChain); len(rules) != 4 || !strings.Contains(strings.Join(rules[3], " "), "-j REJECT") {
+		t.Fatalf("unexpected rules %v once enforced", rules)
+	}
+
+	if err := d.FilterEndpoint("net1", "ep1", nil); err != nil {
+		t.Fatal(err)
+	}
+	if rules := ipt.IPv4().Rules(iptables.Filter, FilterChain); len(rules) != 0 {
+		t.Fatalf("unexpected rules %v after lifting the filter", rules)
+	}
+	if n.filterOf(ep) != nil {
+		t.Fatal("the filter policy is kept after it got lifted")
+	}
+
+	// The IPv6 traffic of a dual-stack endpoint is filtered through
+	// ip6tables, in a chain jumped to from FORWARD
+	ep.addrv6 = &net.IPNet{IP: net.ParseIP("fd00::2"), Mask: net.CIDRMask(64, 128)}
+	_, from6, _ := net.ParseCIDR("fd01::/64")
+	v3 := &driverapi.FilterPolicy{Version: "v3", Allow: []driverapi.FilterRule{{Proto: "tcp", Ports: "80"}, {Proto: "tcp", Ports: "22", From: from6}}}
+	if err := d.FilterEndpoint("net1", "ep1", v3); err != nil {
+		t.Fatal(err)
+	}
+	if rules := ipt.IPv4().Rules(iptables.Filter, FilterChain); len(rules) != 3 {
+		t.Fatalf("unexpected IPv4 rules %v", rules)
+	}
+	if !ipt.IPv6().HasRule(iptables.Filter, "FORWARD", "-j", FilterChain) {
+		t.Fatal("no jump to the IPv6 filter chain")
+	}
+	rules6 := ipt.IPv6().Rules(iptables.Filter, FilterChain)
+	if len(rules6) != 4 || !strings.Contains(strings.Join(rules6[2], " "), "-s fd01::/64") ||
+		!strings.Contains(strings.Join(rules6[3], " "), "icmp6-adm-prohibited") {
+		t.Fatalf("unexpected IPv6 rules %v", rules6)
+	}
+	if c, err := d.FilterCounters("net1", "ep1"); err != nil || c.Version != "v3" {
+		t.Fatalf("unexpected counters %+v: %v", c, err)
+	}
+	if err := d.FilterEndpoint("net1", "ep1", nil); err != nil {
+		t.Fatal(err)
+	}
+	if rules6 := ipt.IPv6().Rules(iptables.Filter, FilterChain); len(rules6) != 0 {
+		t.Fatalf("unexpected IPv6 rules %v after lifting the filter", rules6)
+	}
+
+	ep.addr = nil
+	if err := d.FilterEndpoint("net1", "ep1", v3); err != nil {
+		t.Fatal(err)
+	}
+	_, from10, _ := net.ParseCIDR("10.0.0.0/8")
+	v4 := &driverapi.FilterPolicy{Version: "v4", Allow: []driverapi.FilterRule{{Proto: "tcp", Ports: "22", From: from10}}}
+	if err := d.FilterEndpoint("net1", "ep1", v4); err == nil {
+		t.Fatal("IPv4 rules accepted for an IPv6-only endpoint")
+	}
+}
+
+// noIP6tables is an iptables backend of a host without ip6tables
+type noIP6tables struct {
+	*fakeiptables.Iptables
+}
+
+func (b noIP6tables) Run(ipv iptables.IPV, args ...string) ([]byte, error) {
+	if ipv == iptables.IP6Tables {
+		return nil, iptables.ErrIp6tablesNotFound
+	}
+	return b.Iptables.Run(ipv, args...)
+}
+
+func TestFilterEndpointWithoutIP6tables(t *testing.T) {
+	ipt := fakeiptables.New()
+	defer iptables.SetBackend(noIP6tables{ipt})()
+
+	if err := setupFilterChain(); err != nil {
+		t.Fatal(err)
+	}
+	d := newDriver()
+	d.config = &configuration{EnableIPTables: true}
+	ep := &bridgeEndpoint{id: "ep1", addr: &net.IPNet{IP: net.ParseIP("172.18.0.2"), Mask: net.CIDRMask(16, 32)},
+		addrv6: &net.IPNet{IP: net.ParseIP("fd00::2"), Mask: net.CIDRMask(64, 128)}}
+	n := &bridgeNetwork{id: "net1", config: &networkConfiguration{BridgeName: "br0"},
+		endpoints: map[string]*bridgeEndpoint{ep.id: ep}, driver: d}
+	d.networks[n.id] = n
+
+	// A dual-stack endpoint gets its IPv4 traffic filtered only
+	v1 := &driverapi.FilterPolicy{Version: "v1", Allow: []driverapi.FilterRule{{Proto: "tcp", Ports: "80"}}}
+	if err := d.FilterEndpoint("net1", "ep1", v1); err != nil {
+		t.Fatal(err)
+	}
+	if rules := ipt.IPv4().Rules(iptables.Filter, FilterChain); len(rules) != 3 {
+		t.Fatalf("unexpected IPv4 rules %v", rules)
+	}
+	if c, err := d.FilterCounters("net1", "ep1"); err != nil || c.Version != "v1" {
+		t.Fatalf("unexpected counters %+v: %v", c, err)
+	}
+	if err := d.FilterEndpoint("net1", "ep1", nil); err != nil {
+		t.Fatal(err)
+	}
+	if rules := ipt.IPv4().Rules(iptables.Filter, FilterChain); len(rules) != 0 {
+		t.Fatalf("unexpected IPv4 rules %v after lifting the filter", rules)
+	}
+
+	// An IPv6-only endpoint cannot be filtered at all
+	ep.addr = nil
+	if err := d.FilterEndpoint("net1", "ep1", v1); err == nil {
+		t.Fatal("filter policy applied to an IPv6-only endpoint without ip6tables")
+	}
+}
@@ -0,0 +1,108 @@
+package bridge
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/docker/libnetwork/netlabel"
+	"github.com/docker/libnetwork/portmapper"
+	"github.com/docker/libnetwork/types"
+)
+
+func parseHairpinOptions(ec *endpointConfiguration, epOptions map[string]interface{}) error {
+	opt, ok := epOptions[netlabel.HairpinPorts]
+	if !ok {
+		return nil
+	}
+	ports := make(map[string]bool)
+	switch v := opt.(type) {
+	case string:
+		for _, e := range strings.Split(v, ",") {
+			if e = strings.TrimSpace(e); e == "" {
+				continue
+			}
+			kv := strings.SplitN(e, "=", 2)
+			if len(kv) != 2 {
+				return types.BadRequestErrorf("invalid hairpin port %q: expected as in 80/tcp=on", e)
+			}
+			enable, err := parseHairpinValue(kv[1])
+			if err != nil {
+				return types.BadRequestErrorf("invalid hairpin port %q: %v", e, err)
+			}
+			ports[kv[0]] = enable
+		}
+	case map[string]bool:
+		for k, enable := range v {
+			ports[k] = enable
+		}
+	default:
+		return &ErrInvalidEndpointConfig{}
+	}
+
+	for k, enable := range ports {
+		key, err := hairpinPortKey(k)
+		if err != nil {
+			return err
+		}
+		if ec.HairpinPorts == nil {
+			ec.HairpinPorts = make(map[string]bool)
+		}
+		ec.HairpinPorts[key] = enable
+	}
+	return nil
+}
+
+func parseHairpinValue(v string) (bool, error) {
+	switch strings.ToLower(v) {
+	case "on":
+		return true, nil
+	case "off":
+		return false, nil
+	}
+	return strconv.ParseBool(v)
+}
+
+// hairpinPortKey normalizes the container port of a hairpin option to the
+// port/proto form, the protocol being tcp when missing
+func hairpinPortKey(s string) (string, error) {
+	port, proto := s, "tcp"
+	if i := strings.Index(s, "/"); i >= 0 {
+		port, proto = s[:i], s[i+1:]
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil || p == 0 {
+		return "", types.BadRequestErrorf("invalid hairpin port %q", s)
+	}
+	if types.ParseProtocol(proto) == 0 || types.ParseProtocol(proto) == types.ICMP {
+		return "", types.BadRequestErrorf("invalid protocol of the hairpin port %q", s)
+	}
+	return strconv.FormatUint(p, 10) + "/" + strings.ToLower(proto), nil
+}
+
+// hasHairpinPorts tells if a port of the endpoint is reached in hairpin,
+// which requires the bridge port to reflect the traffic
+func hasHairpinPorts(ec *endpointConfiguration) bool {
+	if ec == nil {
+		return false
+	}
+	for _, enable := range ec.HairpinPorts {
+		if enable {
+			return true
+		}
+	}
+	return false
+}
+
+// portHairpin returns the hairpin of the binding, the driver default
+// applies to the ports without an option
+func portHairpin(hairpinPorts map[string]bool, b types.PortBinding) portmapper.Hairpin {
+	enable, ok := hairpinPorts[strconv.Itoa(int(b.Port))+"/"+b.Proto.String()]
+	switch {
+	case !ok:
+		return portmapper.HairpinDefault
+	case enable:
+		return portmapper.HairpinOn
+	default:
+		return portmapper.HairpinOff
+	}
+}
@@ -0,0 +1,54 @@
+package bridge
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/docker/libnetwork/netlabel"
+	"github.com/docker/libnetwork/portmapper"
+	"github.com/docker/libnetwork/types"
+)
+
+func TestParseHairpinOptions(t *testing.T) {
+	ec, err := parseEndpointOptions(map[string]interface{}{
+		netlabel.HairpinPorts: "80/tcp=on, 53/UDP=off,8443=true",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]bool{"80/tcp": true, "53/udp": false, "8443/tcp": true}
+	if !reflect.DeepEqual(ec.HairpinPorts, expected) {
+		t.Fatalf("unexpected hairpin ports %v, expected %v", ec.HairpinPorts, expected)
+	}
+	if !hasHairpinPorts(ec) {
+		t.Fatal("expected the endpoint to have hairpin ports")
+	}
+
+	for _, opts := range []map[string]interface{}{
+		{netlabel.HairpinPorts: "80/tcp"},
+		{netlabel.HairpinPorts: "80/tcp=maybe"},
+		{netlabel.HairpinPorts: "0/tcp=on"},
+		{netlabel.HairpinPorts: "80/icmp=on"},
+		{netlabel.HairpinPorts: 80},
+	} {
+		if _, err := parseEndpointOptions(opts); err == nil {
+			t.Fatalf("expected an error parsing %v", opts)
+		}
+	}
+}
+
+func TestPortHairpin(t *testing.T) {
+	ports := map[string]bool{"80/tcp": true, "53/udp": false}
+	for _, c := range []struct {
+		b        types.PortBinding
+		expected portmapper.Hairpin
+	}{
+		{types.PortBinding{Proto: types.TCP, Port: 80}, portmapper.HairpinOn},
+		{types.PortBinding{Proto: types.UDP, Port: 53}, portmapper.HairpinOff},
+		{types.PortBinding{Proto: types.UDP, Port: 80}, portmapper.HairpinDefault},
+	} {
+		if h := portHairpin(ports, c.b); h != c.expected {
+			t.Fatalf("unexpected hairpin %d of %v, expected %d", h, c.b, c.expected)
+		}
+	}
+}
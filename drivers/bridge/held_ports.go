@@ -0,0 +1,52 @@
+package bridge
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/docker/libnetwork/netlabel"
+	"github.com/docker/libnetwork/types"
+)
+
+func parseHeldPortsOptions(ec *endpointConfiguration, epOptions map[string]interface{}) error {
+	opt, ok := epOptions[netlabel.HeldPorts]
+	if !ok {
+		return nil
+	}
+	var ports []string
+	switch v := opt.(type) {
+	case string:
+		ports = strings.Split(v, ",")
+	case []string:
+		ports = v
+	default:
+		return &ErrInvalidEndpointConfig{}
+	}
+
+	for _, p := range ports {
+		if p = strings.TrimSpace(p); p == "" {
+			continue
+		}
+		key, err := hairpinPortKey(p)
+		if err != nil {
+			return types.BadRequestErrorf("invalid held port %q", p)
+		}
+		if !strings.HasSuffix(key, "/tcp") {
+			return types.BadRequestErrorf("invalid held port %q: only the tcp ports can be held", p)
+		}
+		ec.HeldPorts = append(ec.HeldPorts, key)
+	}
+	return nil
+}
+
+// portHeld tells if the host port of the binding is held open by the
+// daemon, which proxies its connections to the container
+func portHeld(heldPorts []string, b types.PortBinding) bool {
+	key := strconv.Itoa(int(b.Port)) + "/" + b.Proto.String()
+	for _, p := range heldPorts {
+		if p == key {
+			return true
+		}
+	}
+	return false
+}
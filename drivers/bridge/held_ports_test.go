@@ -0,0 +1,47 @@
+package bridge
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/docker/libnetwork/netlabel"
+	"github.com/docker/libnetwork/types"
+)
+
+func TestParseHeldPortsOptions(t *testing.T) {
+	ec, err := parseEndpointOptions(map[string]interface{}{
+		netlabel.HeldPorts: "80/tcp, 8443,",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"80/tcp", "8443/tcp"}
+	if !reflect.DeepEqual(ec.HeldPorts, expected) {
+		t.Fatalf("unexpected held ports %v, expected %v", ec.HeldPorts, expected)
+	}
+
+	for _, c := range []struct {
+		b    types.PortBinding
+		held bool
+	}{
+		{types.PortBinding{Proto: types.TCP, Port: 80}, true},
+		{types.PortBinding{Proto: types.TCP, Port: 8443}, true},
+		{types.PortBinding{Proto: types.UDP, Port: 80}, false},
+		{types.PortBinding{Proto: types.TCP, Port: 81}, false},
+	} {
+		if held := portHeld(ec.HeldPorts, c.b); held != c.held {
+			t.Fatalf("unexpected hold %t of %s", held, c.b.String())
+		}
+	}
+
+	for _, opts := range []map[string]interface{}{
+		{netlabel.HeldPorts: "53/udp"},
+		{netlabel.HeldPorts: "0/tcp"},
+		{netlabel.HeldPorts: "http"},
+		{netlabel.HeldPorts: 80},
+	} {
+		if _, err := parseEndpointOptions(opts); err == nil {
+			t.Fatalf("expected an error parsing %v", opts)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package bridge
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/docker/libnetwork/iptables"
+	"github.com/docker/libnetwork/types"
+	"github.com/sirupsen/logrus"
+)
+
+// HostAccessChain is the filter chain, jumped to from INPUT and FORWARD,
+// keeping the endpoints of the networks denying the host access off the
+// host addresses and the link-local ones, as the metadata services
+const HostAccessChain = "DOCKER-HOST-ACCESS"
+
+// The values of the HostAccess option
+const (
+	hostAccessAllow = "allow"
+	hostAccessDeny  = "deny"
+)
+
+// linkLocalNet is the link-local IPv4 subnet the metadata services of the
+// clouds are reached on, as 169.254.169.254
+const linkLocalNet = "169.254.0.0/16"
+
+func validateHostAccess(v interface{}) error {
+	switch v.(string) {
+	case hostAccessAllow, hostAccessDeny:
+		return nil
+	}
+	return fmt.Errorf("expected %s or %s", hostAccessAllow, hostAccessDeny)
+}
+
+// parseHostAccessPorts parses the comma separated ports of the
+// HostAccessPorts option, as in udp/53,tcp/8000-8010, into their rule
+// form, as in udp/53 and tcp/8000:8010
+func parseHostAccessPorts(v string) ([]string, error) {
+	var ports []string
+	for _, e := range strings.Split(v, ",") {
+		if e = strings.TrimSpace(e); e == "" {
+			continue
+		}
+		parts := strings.SplitN(e, "/", 2)
+		if len(parts) != 2 || (parts[0] != "tcp" && parts[0] != "udp" && parts[0] != "sctp") {
+			return nil, types.BadRequestErrorf("invalid host access port %q: expected as in tcp/22 or udp/8000-8010", e)
+		}
+		low, high, err := parsePortRange(parts[1])
+		if err != nil {
+			return nil, types.BadRequestErrorf("invalid host access port %q: %v", e, err)
+		}
+		port := strconv.Itoa(low)
+		if high > low {
+			port += ":" + strconv.Itoa(high)
+		}
+		ports = append(ports, parts[0]+"/"+port)
+	}
+	return ports, nil
+}
+
+func parsePortRange(s string) (int, int, error) {
+	parts := strings.SplitN(s, "-", 2)
+	low, err := strconv.Atoi(parts[0])
+	if err != nil || low < 1 || low > 65535 {
+		return 0, 0, fmt.Errorf("invalid port %q", parts[0])
+	}
+	high := low
+	if len(parts) == 2 {
+		if high, err = strconv.Atoi(parts[1]); err != nil || high < low || high > 65535 {
+			return 0, 0, fmt.Errorf("invalid port range %q", s)
+		}
+	}
+	return low, high, nil
+}
+
+func deniesHostAccess(config *networkConfiguration) bool {
+	return config != nil && config.HostAccess == hostAccessDeny
+}
+
+// hostAccessRules renders the rules of the endpoint of a network denying
+// the host access, tagged with the endpoint and marked with their tier. The
+// replies of the connections the host opens and the metadata proxy of the
+// network are let through and the link-local addresses rejected ahead of
+// the allowed ports of the tenant, the rest of the IPv4 traffic of the
+// endpoint to the host addresses is rejected last.
+func hostAccessRules(config *networkConfiguration, ep *bridgeEndpoint) [][]string {
+	src := []string{"-i", config.BridgeName, "-s", ep.addr.IP.String()}
+	local := []string{"-m", "addrtype", "--dst-type", "LOCAL"}
+	rule := func(tier iptables.Tier, args ...string) []string {
+		return iptables.TierRule(tier, iptables.TagRule(networkType, ep.id, append(append([]string{}, src...), args...)))
+	}
+
+	rules := [][]string{
+		rule(iptables.TierPlatform, "-m", "conntrack", "--ctstate", "RELATED,ESTABLISHED", "-j", "RETURN"),
+	}
+	if config.MetadataProxy != "" {
+		// The metadata address is redirected to the proxy on the host
+		rules = append(rules, rule(iptables.TierPlatform, "-m", "conntrack", "--ctstate", "DNAT", "--ctorigdst", config.MetadataAddress.String(), "-j", "RETURN"))
+	}
+	rules = append(rules, rule(iptables.TierPlatform, "-d", linkLocalNet, "-j", "REJECT", "--reject-with", "icmp-admin-prohibited"))
+	for _, p := range config.HostAccessPorts {
+		parts := strings.SplitN(p, "/", 2)
+		rules = append(rules, rule(iptables.TierTenant, append(append([]string{}, local...), "-p", parts[0], "--dport", parts[1], "-j", "RETURN")...))
+	}
+	return append(rules, rule(iptables.TierDefault, append(append([]string{}, local...), "-j", "REJECT", "--reject-with", "icmp-admin-prohibited")...))
+}
+
+// programHostAccess adds or removes the host access rules of the endpoint
+func (n *bridgeNetwork) programHostAccess(ep *bridgeEndpoint, enable bool) error {
+	n.Lock()
+	config := n.config
+	n.Unlock()
+	if !deniesHostAccess(config) || ep.addr == nil {
+		return nil
+	}
+
+	action := iptables.Delete
+	if enable {
+		action = iptables.Append
+	}
+	for _, rule := range hostAccessRules(config, ep) {
+		if enable == iptables.Exists(iptables.Filter, HostAccessChain, rule...) {
+			continue
+		}
+		if err := iptables.ProgramRule(iptables.Filter, HostAccessChain, action, rule); err != nil {
+			if !enable {
+				logrus.Warnf("Failed to remove the host access rule %v of endpoint %.7s: %v", rule, ep.id, err)
+				continue
+			}
+			return fmt.Errorf("failed to program the host access of endpoint %.7s: %v", ep.id, err)
+		}
+	}
+	return nil
+}
+
+// setupHostAccessChain creates the host access chain and its jump from
+// INPUT, the one from FORWARD is ensured along with the other chains
+func setupHostAccessChain() error {
+	if _, err := iptables.NewChain(HostAccessChain, iptables.Filter, false); err != nil {
+		return fmt.Errorf("failed to create FILTER host access chain: %v", err)
+	}
+	jump := []string{"-j", HostAccessChain}
+	if iptables.Exists(iptables.Filter, "INPUT", jump...) {
+		return nil
+	}
+	if err := iptables.ProgramRule(iptables.Filter, "INPUT", iptables.Insert, jump); err != nil {
+		return fmt.Errorf("failed to add the jump to the host access chain: %v", err)
+	}
+	return nil
+}
+
+// restoreHostAccess programs back the host access rules of all the
+// endpoints, after the chain got flushed
+func (d *driver) restoreHostAccess() {
+	for _, n := range d.getNetworks() {
+		n.Lock()
+		eps := make([]*bridgeEndpoint, 0, len(n.endpoints))
+		for _, ep := range n.endpoints {
+			eps = append(eps, ep)
+		}
+		n.Unlock()
+
+		for _, ep := range eps {
+			if err := n.programHostAccess(ep, true); err != nil {
+				logrus.Warn(err)
+			}
+		}
+	}
+}
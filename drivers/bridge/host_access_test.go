@@ -0,0 +1,82 @@
+package bridge
+
+import (
+	"net"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/docker/libnetwork/iptables"
+	"github.com/docker/libnetwork/testutils/fakeiptables"
+)
+
+func TestParseHostAccessOptions(t *testing.T) {
+	c := &networkConfiguration{}
+	if err := c.fromLabels(map[string]string{
+		HostAccess:      "deny",
+		HostAccessPorts: "udp/53, tcp/9100-9200,",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if c.HostAccess != hostAccessDeny || !reflect.DeepEqual(c.HostAccessPorts, []string{"udp/53", "tcp/9100:9200"}) {
+		t.Fatalf("unexpected host access %s %v", c.HostAccess, c.HostAccessPorts)
+	}
+
+	if err := (&networkConfiguration{}).fromLabels(map[string]string{HostAccess: "block"}); err == nil {
+		t.Fatal("invalid host access accepted")
+	}
+	for _, v := range []string{"53", "icmp/1", "tcp/0", "tcp/70000", "udp/20-10", "tcp/a"} {
+		if _, err := parseHostAccessPorts(v); err == nil {
+			t.Fatalf("invalid host access ports %q accepted", v)
+		}
+	}
+}
+
+func TestHostAccessRules(t *testing.T) {
+	config := &networkConfiguration{BridgeName: "br0", HostAccess: hostAccessDeny, HostAccessPorts: []string{"udp/53"}}
+	ep := &bridgeEndpoint{id: "ep1", addr: &net.IPNet{IP: net.ParseIP("172.18.0.2"), Mask: net.CIDRMask(16, 32)}}
+
+	expected := [][]string{
+		{"-i", "br0", "-s", "172.18.0.2", "-m", "conntrack", "--ctstate", "RELATED,ESTABLISHED", "-m", "comment", "--comment", "lnet:bridge:ep1", "-m", "comment", "--comment", "lnet-tier:platform", "-j", "RETURN"},
+		{"-i", "br0", "-s", "172.18.0.2", "-d", "169.254.0.0/16", "-m", "comment", "--comment", "lnet:bridge:ep1", "-m", "comment", "--comment", "lnet-tier:platform", "-j", "REJECT", "--reject-with", "icmp-admin-prohibited"},
+		{"-i", "br0", "-s", "172.18.0.2", "-m", "addrtype", "--dst-type", "LOCAL", "-p", "udp", "--dport", "53", "-m", "comment", "--comment", "lnet:bridge:ep1", "-m", "comment", "--comment", "lnet-tier:tenant", "-j", "RETURN"},
+		{"-i", "br0", "-s", "172.18.0.2", "-m", "addrtype", "--dst-type", "LOCAL", "-m", "comment", "--comment", "lnet:bridge:ep1", "-m", "comment", "--comment", "lnet-tier:default", "-j", "REJECT", "--reject-with", "icmp-admin-prohibited"},
+	}
+	if rules := hostAccessRules(config, ep); !reflect.DeepEqual(rules, expected) {
+		t.Fatalf("unexpected rules:\n%v\nexpected:\n%v", rules, expected)
+	}
+
+	if deniesHostAccess(&networkConfiguration{}) || deniesHostAccess(nil) {
+		t.Fatal("host access denied by default")
+	}
+}
+
+func TestProgramHostAccessTiers(t *testing.T) {
+	ipt := fakeiptables.New()
+	defer ipt.Install()()
+
+	if err := setupHostAccessChain(); err != nil {
+		t.Fatal(err)
+	}
+	n := &bridgeNetwork{config: &networkConfiguration{BridgeName: "br0", HostAccess: hostAccessDeny, HostAccessPorts: []string{"udp/53"}}}
+	for i, ip := range []string{"172.18.0.2", "172.18.0.3"} {
+		ep := &bridgeEndpoint{id: "ep" + strconv.Itoa(i), addr: &net.IPNet{IP: net.ParseIP(ip), Mask: net.CIDRMask(16, 32)}}
+		if err := n.programHostAccess(ep, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The rules of the endpoint programmed last stay within their tiers
+	rules := ipt.IPv4().Rules(iptables.Filter, HostAccessChain)
+	if len(rules) != 8 {
+		t.Fatalf("unexpected rules %v", rules)
+	}
+	last := iptables.TierPlatform
+	for _, r := range rules {
+		tier, ok := iptables.RuleTier(r)
+		if !ok || tier < last {
+			t.Fatalf("rule %v out of its tier in %v", r, rules)
+		}
+		last = tier
+	}
+}
@@ -0,0 +1,83 @@
+package bridge
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/docker/libnetwork/iptables"
+	"github.com/docker/libnetwork/types"
+	"github.com/sirupsen/logrus"
+)
+
+// HostOwnerChain is the mangle chain, jumped to from OUTPUT, marking the
+// connections the exempt host users and groups open
+const HostOwnerChain = "DOCKER-HOST-OWNER"
+
+// hostOwnerMark is the connection mark bit of the connections of the exempt
+// host owners, the replies of the endpoints on them get through the
+// bootstrap window
+const hostOwnerMark = "0x200000/0x200000"
+
+// ownerRe matches the users and groups the owner match takes, as a number,
+// a range of numbers or a name
+var ownerRe = regexp.MustCompile(`^([0-9]+(-[0-9]+)?|[A-Za-z_][A-Za-z0-9_.-]*\$?)$`)
+
+func validateHostOwners(config *configuration) error {
+	for _, o := range append(append([]string{}, config.HostExemptUIDs...), config.HostExemptGIDs...) {
+		if !ownerRe.MatchString(o) {
+			return types.BadRequestErrorf("invalid exempt host owner %q: expected a name, an ID or a range of IDs", o)
+		}
+	}
+	return nil
+}
+
+func hasHostOwners(config *configuration) bool {
+	return config != nil && (len(config.HostExemptUIDs) > 0 || len(config.HostExemptGIDs) > 0)
+}
+
+// hostOwnerRules renders the rules marking the connections of the exempt
+// host users and groups
+func hostOwnerRules(config *configuration) [][]string {
+	var rules [][]string
+	for _, uid := range config.HostExemptUIDs {
+		rules = append(rules, []string{"-m", "owner", "--uid-owner", uid, "-j", "CONNMARK", "--set-xmark", hostOwnerMark})
+	}
+	for _, gid := range config.HostExemptGIDs {
+		rules = append(rules, []string{"-m", "owner", "--gid-owner", gid, "-j", "CONNMARK", "--set-xmark", hostOwnerMark})
+	}
+	return rules
+}
+
+// setupHostOwnerChain creates the host owner chain with the rules of the
+// exempt host owners, and its jump from OUTPUT. The IPv6 rules go straight
+// to OUTPUT.
+func setupHostOwnerChain(config *configuration) error {
+	if !hasHostOwners(config) {
+		return nil
+	}
+	if _, err := iptables.NewChain(HostOwnerChain, iptables.Mangle, false); err != nil {
+		return fmt.Errorf("failed to create MANGLE host owner chain: %v", err)
+	}
+	for _, rule := range hostOwnerRules(config) {
+		if err := iptables.ProgramRule(iptables.Mangle, HostOwnerChain, iptables.Append, rule); err != nil {
+			return fmt.Errorf("failed to exempt the host owner of %v: %v", rule, err)
+		}
+		if err := programIPv6Rule(ip6Rule{table: iptables.Mangle, chain: "OUTPUT", args: rule}, true); err != nil {
+			logrus.Warnf("Failed to exempt the IPv6 connections of the host owner of %v: %v", rule, err)
+		}
+	}
+	jump := []string{"-j", HostOwnerChain}
+	if iptables.Exists(iptables.Mangle, "OUTPUT", jump...) {
+		return nil
+	}
+	if err := iptables.ProgramRule(iptables.Mangle, "OUTPUT", iptables.Insert, jump); err != nil {
+		return fmt.Errorf("failed to add the jump to the host owner chain: %v", err)
+	}
+	return nil
+}
+
+// exemptsHostOwners tells whether the driver of the network exempts host
+// owners
+func (n *bridgeNetwork) exemptsHostOwners() bool {
+	return n.driver != nil && hasHostOwners(n.driver.config)
+}
@@ -0,0 +1,52 @@
+package bridge
+
+import (
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/docker/libnetwork/iptables"
+	"github.com/docker/libnetwork/testutils/fakeiptables"
+)
+
+func TestValidateHostOwners(t *testing.T) {
+	if err := validateHostOwners(&configuration{HostExemptUIDs: []string{"0", "1000-1010", "node_exporter"}, HostExemptGIDs: []string{"monitoring"}}); err != nil {
+		t.Fatal(err)
+	}
+	for _, o := range []string{"", "1000-", "a b", "-j"} {
+		if err := validateHostOwners(&configuration{HostExemptGIDs: []string{o}}); err == nil {
+			t.Fatalf("invalid host owner %q accepted", o)
+		}
+	}
+}
+
+func TestSetupHostOwnerChain(t *testing.T) {
+	ipt := fakeiptables.New()
+	defer ipt.Install()()
+
+	config := &configuration{HostExemptUIDs: []string{"1000"}, HostExemptGIDs: []string{"monitoring"}}
+	if err := setupHostOwnerChain(config); err != nil {
+		t.Fatal(err)
+	}
+	expected := [][]string{
+		{"-m", "owner", "--uid-owner", "1000", "-j", "CONNMARK", "--set-xmark", hostOwnerMark},
+		{"-m", "owner", "--gid-owner", "monitoring", "-j", "CONNMARK", "--set-xmark", hostOwnerMark},
+	}
+	if rules := ipt.IPv4().Rules(iptables.Mangle, HostOwnerChain); !reflect.DeepEqual(rules, expected) {
+		t.Fatalf("unexpected rules:\n%v\nexpected:\n%v", rules, expected)
+	}
+	if !ipt.IPv4().HasRule(iptables.Mangle, "OUTPUT", "-j", HostOwnerChain) {
+		t.Fatal("missing the jump to the host owner chain")
+	}
+	if !ipt.IPv6().HasRule(iptables.Mangle, "OUTPUT", expected[1]...) {
+		t.Fatal("missing the IPv6 rule of the exempt group")
+	}
+
+	// The replies of the held endpoints to the exempt owners get through
+	ep := &bridgeEndpoint{id: "ep1", addr: &net.IPNet{IP: net.ParseIP("172.18.0.2"), Mask: net.CIDRMask(16, 32)}}
+	rules, _ := bootstrapRules("br0", ep, true)
+	from := []string{"-i", "br0", "-s", "172.18.0.2", "-m", "connmark", "!", "--mark", hostOwnerMark, "-m", "comment", "--comment", "lnet:bridge:ep1", "-j", "DROP"}
+	if !reflect.DeepEqual(rules[0], from) {
+		t.Fatalf("unexpected bootstrap rule %v", rules[0])
+	}
+}
@@ -0,0 +1,149 @@
+package bridge
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/docker/libnetwork/iptables"
+	"github.com/docker/libnetwork/netlabel"
+	"github.com/sirupsen/logrus"
+)
+
+// ICCChain is the filter chain, jumped to from FORWARD, confining the
+// endpoints of the ICC groups to the peers they share a group with
+const ICCChain = "DOCKER-ICC"
+
+func parseICCGroupsOptions(ec *endpointConfiguration, epOptions map[string]interface{}) error {
+	opt, ok := epOptions[netlabel.ICCGroups]
+	if !ok {
+		return nil
+	}
+	var groups []string
+	switch v := opt.(type) {
+	case string:
+		groups = strings.Split(v, ",")
+	case []string:
+		groups = v
+	default:
+		return &ErrInvalidEndpointConfig{}
+	}
+	for _, g := range groups {
+		if g = strings.TrimSpace(g); g != "" {
+			ec.ICCGroups = append(ec.ICCGroups, g)
+		}
+	}
+	return nil
+}
+
+func hasICCGroups(ep *bridgeEndpoint) bool {
+	return ep.config != nil && len(ep.config.ICCGroups) > 0
+}
+
+// shareICCGroup tells if the endpoints can talk, an endpoint out of any
+// group only talks to the ones out of any group
+func shareICCGroup(ep1, ep2 *bridgeEndpoint) bool {
+	if !hasICCGroups(ep1) || !hasICCGroups(ep2) {
+		return !hasICCGroups(ep1) && !hasICCGroups(ep2)
+	}
+	for _, g1 := range ep1.config.ICCGroups {
+		for _, g2 := range ep2.config.ICCGroups {
+			if g1 == g2 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// iccGroupRules renders the rules of the grouped endpoint. The traffic
+// with the peers sharing a group returns to FORWARD, where the network ICC
+// rule applies, the rest of its traffic on the bridge is dropped. The
+// returns are in the tenant tier and the drops in the default one, so that
+// the former precede the latter. The returns between two endpoints,
+// rendered alike for both, are tagged with the lower of their IDs and the
+// drops with the endpoint.
+func iccGroupRules(bridgeName string, ep *bridgeEndpoint, peers []*bridgeEndpoint) (returns [][]string, drops [][]string) {
+	onBridge := []string{"-i", bridgeName, "-o", bridgeName}
+	ip := ep.addr.IP.String()
+	for _, peer := range peers {
+		if peer.id == ep.id || peer.addr == nil || !shareICCGroup(ep, peer) {
+			continue
+		}
+		peerIP := peer.addr.IP.String()
+		owner := ep.id
+		if peer.id < owner {
+			owner = peer.id
+		}
+		returns = append(returns,
+			iptables.TierRule(iptables.TierTenant, iptables.TagRule(networkType, owner, append(append([]string{}, onBridge...), "-s", ip, "-d", peerIP, "-j", "RETURN"))),
+			iptables.TierRule(iptables.TierTenant, iptables.TagRule(networkType, owner, append(append([]string{}, onBridge...), "-s", peerIP, "-d", ip, "-j", "RETURN"))))
+	}
+	drops = [][]string{
+		iptables.TierRule(iptables.TierDefault, iptables.TagRule(networkType, ep.id, append(append([]string{}, onBridge...), "-s", ip, "-j", "DROP"))),
+		iptables.TierRule(iptables.TierDefault, iptables.TagRule(networkType, ep.id, append(append([]string{}, onBridge...), "-d", ip, "-j", "DROP"))),
+	}
+	return returns, drops
+}
+
+// programICCGroups adds or removes the ICC group rules of the endpoint
+// with regard to the current endpoints of the network
+func (n *bridgeNetwork) programICCGroups(ep *bridgeEndpoint, enable bool) error {
+	if !hasICCGroups(ep) || ep.addr == nil {
+		return nil
+	}
+
+	n.Lock()
+	bridgeName := n.config.BridgeName
+	peers := make([]*bridgeEndpoint, 0, len(n.endpoints))
+	for _, peer := range n.endpoints {
+		peers = append(peers, peer)
+	}
+	n.Unlock()
+
+	returns, drops := iccGroupRules(bridgeName, ep, peers)
+	for _, r := range []struct {
+		action iptables.Action
+		rules  [][]string
+	}{
+		{iptables.Insert, returns},
+		{iptables.Append, drops},
+	} {
+		for _, rule := range r.rules {
+			exists := iptables.Exists(iptables.Filter, ICCChain, rule...)
+			if enable == exists {
+				continue
+			}
+			action := r.action
+			if !enable {
+				action = iptables.Delete
+			}
+			if err := iptables.ProgramRule(iptables.Filter, ICCChain, action, rule); err != nil {
+				if !enable {
+					logrus.Warnf("Failed to remove the ICC group rule %v of endpoint %.7s: %v", rule, ep.id, err)
+					continue
+				}
+				return fmt.Errorf("failed to program the ICC groups of endpoint %.7s: %v", ep.id, err)
+			}
+		}
+	}
+	return nil
+}
+
+// restoreICCGroups programs back the ICC group rules of all the
+// endpoints, after the chain got flushed
+func (d *driver) restoreICCGroups() {
+	for _, n := range d.getNetworks() {
+		n.Lock()
+		eps := make([]*bridgeEndpoint, 0, len(n.endpoints))
+		for _, ep := range n.endpoints {
+			eps = append(eps, ep)
+		}
+		n.Unlock()
+
+		for _, ep := range eps {
+			if err := n.programICCGroups(ep, true); err != nil {
+				logrus.Warn(err)
+			}
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package bridge
+
+import (
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/docker/libnetwork/netlabel"
+)
+
+func TestParseICCGroupsOptions(t *testing.T) {
+	ec, err := parseEndpointOptions(map[string]interface{}{netlabel.ICCGroups: "web, db,"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(ec.ICCGroups, []string{"web", "db"}) {
+		t.Fatalf("unexpected groups: %v", ec.ICCGroups)
+	}
+
+	if _, err := parseEndpointOptions(map[string]interface{}{netlabel.ICCGroups: 1}); err == nil {
+		t.Fatal("expected an error parsing non string groups")
+	}
+}
+
+func TestICCGroupRules(t *testing.T) {
+	newEp := func(id, ip string, groups ...string) *bridgeEndpoint {
+		return &bridgeEndpoint{
+			id:     id,
+			addr:   &net.IPNet{IP: net.ParseIP(ip), Mask: net.CIDRMask(16, 32)},
+			config: &endpointConfiguration{ICCGroups: groups},
+		}
+	}
+	web := newEp("web", "172.17.0.2", "web")
+	app := newEp("app", "172.17.0.3", "web", "db")
+	db := newEp("db", "172.17.0.4", "db")
+	other := newEp("other", "172.17.0.5")
+
+	if !shareICCGroup(web, app) || shareICCGroup(web, db) || shareICCGroup(web, other) || !shareICCGroup(other, newEp("x", "172.17.0.6")) {
+		t.Fatal("unexpected group membership")
+	}
+
+	returns, drops := iccGroupRules("docker0", web, []*bridgeEndpoint{web, app, db, other})
+	expectedReturns := [][]string{
+		{"-i", "docker0", "-o", "docker0", "-s", "172.17.0.2", "-d", "172.17.0.3", "-m", "comment", "--comment", "lnet:bridge:app", "-m", "comment", "--comment", "lnet-tier:tenant", "-j", "RETURN"},
+		{"-i", "docker0", "-o", "docker0", "-s", "172.17.0.3", "-d", "172.17.0.2", "-m", "comment", "--comment", "lnet:bridge:app", "-m", "comment", "--comment", "lnet-tier:tenant", "-j", "RETURN"},
+	}
+	expectedDrops := [][]string{
+		{"-i", "docker0", "-o", "docker0", "-s", "172.17.0.2", "-m", "comment", "--comment", "lnet:bridge:web", "-m", "comment", "--comment", "lnet-tier:default", "-j", "DROP"},
+		{"-i", "docker0", "-o", "docker0", "-d", "172.17.0.2", "-m", "comment", "--comment", "lnet:bridge:web", "-m", "comment", "--comment", "lnet-tier:default", "-j", "DROP"},
+	}
+	if !reflect.DeepEqual(returns, expectedReturns) {
+		t.Fatalf("unexpected returns:\n%v\nexpected:\n%v", returns, expectedReturns)
+	}
+	if !reflect.DeepEqual(drops, expectedDrops) {
+		t.Fatalf("unexpected drops:\n%v\nexpected:\n%v", drops, expectedDrops)
+	}
+}
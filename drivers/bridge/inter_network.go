@@ -0,0 +1,136 @@
+package bridge
+
+import (
+	"fmt"
+
+	"github.com/docker/libnetwork/driverapi"
+	"github.com/docker/libnetwork/iptables"
+	"github.com/sirupsen/logrus"
+)
+
+// SetInterNetworkRules renders the inter-network rules involving the
+// bridge networks at the top of the first isolation stage, ahead of the
+// isolation of the bridges from each other. The internal networks are left
+// isolated. Between two bridges, an allow rule returns from the isolation
+// for the connections of From, and the replies of To; between a bridge and
+// a network of another driver, the bridge only renders the deny rules, the
+// outgoing traffic of the bridges being accepted already and the incoming
+// one being up to the published ports.
+func (d *driver) SetInterNetworkRules(rules []driverapi.InterNetworkRule) error {
+	d.routingMu.Lock()
+	d.interNetworkRules = rules
+	d.routingMu.Unlock()
+	return d.ensureRoutingRules()
+}
+
+// routingRules renders the inter-network rules, the bridges mapping the
+// IDs of the non internal bridge networks to their bridge names
+func routingRules(rules []driverapi.InterNetworkRule, bridges map[string]string) [][]string {
+	var (
+		out     [][]string
+		newConn = []string{"-m", "conntrack", "--ctstate", "NEW"}
+		replies = []string{"-m", "conntrack", "--ctstate", "RELATED,ESTABLISHED"}
+	)
+	rule := func(args ...[]string) []string {
+		var r []string
+		for _, a := range args {
+			r = append(r, a...)
+		}
+		return r
+	}
+	for _, r := range rules {
+		from, fromBridge := bridges[r.From.NetworkID]
+		to, toBridge := bridges[r.To.NetworkID]
+		target := []string{"-j", "RETURN"}
+		if r.Deny {
+			target = []string{"-j", "DROP"}
+		}
+		switch {
+		case fromBridge && toBridge:
+			if r.Deny {
+				out = append(out, rule([]string{"-i", from, "-o", to}, newConn, target))
+				if r.Bidirectional {
+					out = append(out, rule([]string{"-i", to, "-o", from}, newConn, target))
+				}
+				continue
+			}
+			out = append(out, rule([]string{"-i", from, "-o", to}, target))
+			if r.Bidirectional {
+				out = append(out, rule([]string{"-i", to, "-o", from}, target))
+			} else {
+				out = append(out, rule([]string{"-i", to, "-o", from}, replies, target))
+			}
+		case fromBridge && r.Deny:
+			for _, s := range r.To.Subnets {
+				out = append(out, rule([]string{"-i", from, "-d", s.String()}, newConn, target))
+				if r.Bidirectional {
+					out = append(out, rule([]string{"-o", from, "-s", s.String()}, newConn, target))
+				}
+			}
+		case toBridge && r.Deny:
+			for _, s := range r.From.Subnets {
+				out = append(out, rule([]string{"-o", to, "-s", s.String()}, newConn, target))
+				if r.Bidirectional {
+					out = append(out, rule([]string{"-i", to, "-d", s.String()}, newConn, target))
+				}
+			}
+		}
+	}
+	return out
+}
+
+// routingBridges returns the bridge names of the non internal networks of
+// the driver by network ID
+func (d *driver) routingBridges() map[string]string {
+	bridges := map[string]string{}
+	for _, n := range d.getNetworks() {
+		n.Lock()
+		if n.config != nil && !n.config.Internal {
+			bridges[n.id] = n.config.BridgeName
+		}
+		n.Unlock()
+	}
+	return bridges
+}
+
+// ensureRoutingRules programs the inter-network rules again at the top of
+// the first isolation stage, replacing the ones programmed before. It is
+// called once the isolation rules of a network got inserted, and after a
+// firewall reload.
+func (d *driver) ensureRoutingRules() error {
+	d.Lock()
+	enabled := d.config.EnableIPTables
+	d.Unlock()
+	if !enabled {
+		return nil
+	}
+
+	d.routingMu.Lock()
+	defer d.routingMu.Unlock()
+	for _, r := range d.routingRules {
+		if !iptables.Exists(iptables.Filter, IsolationChain1, r...) {
+			continue
+		}
+		if err := iptables.ProgramRule(iptables.Filter, IsolationChain1, iptables.Delete, r); err != nil {
+			logrus.Warnf("Failed to remove the inter-network rule %v: %v", r, err)
+		}
+	}
+	d.routingRules = nil
+
+	rules := routingRules(d.interNetworkRules, d.routingBridges())
+	for i := len(rules) - 1; i >= 0; i-- {
+		if err := iptables.ProgramRule(iptables.Filter, IsolationChain1, iptables.Insert, rules[i]); err != nil {
+			return fmt.Errorf("failed to program the inter-network rule %v: %v", rules[i], err)
+		}
+		d.routingRules = append(d.routingRules, rules[i])
+	}
+	return nil
+}
+
+// restoreRoutingRules programs back the inter-network rules after the
+// chains got flushed
+func (d *driver) restoreRoutingRules() {
+	if err := d.ensureRoutingRules(); err != nil {
+		logrus.Warn(err)
+	}
+}
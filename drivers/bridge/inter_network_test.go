@@ -0,0 +1,88 @@
+package bridge
+
+import (
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/docker/libnetwork/driverapi"
+	"github.com/docker/libnetwork/iptables"
+	"github.com/docker/libnetwork/testutils/fakeiptables"
+)
+
+func TestRoutingRules(t *testing.T) {
+	_, foreign, _ := net.ParseCIDR("10.10.0.0/24")
+	bridges := map[string]string{"n1": "br1", "n2": "br2"}
+	end := func(id string, subnets ...*net.IPNet) driverapi.InterNetworkEnd {
+		return driverapi.InterNetworkEnd{NetworkID: id, Subnets: subnets}
+	}
+	rules := routingRules([]driverapi.InterNetworkRule{
+		{From: end("n1"), To: end("n2")},
+		{From: end("n2"), To: end("r1", foreign), Deny: true, Bidirectional: true},
+		// Allowing a network of another driver is up to the bridge defaults
+		{From: end("n1"), To: end("r1", foreign)},
+		// No bridge network of the driver
+		{From: end("r1", foreign), To: end("r2"), Deny: true},
+	}, bridges)
+	expected := [][]string{
+		{"-i", "br1", "-o", "br2", "-j", "RETURN"},
+		{"-i", "br2", "-o", "br1", "-m", "conntrack", "--ctstate", "RELATED,ESTABLISHED", "-j", "RETURN"},
+		{"-i", "br2", "-d", "10.10.0.0/24", "-m", "conntrack", "--ctstate", "NEW", "-j", "DROP"},
+		{"-o", "br2", "-s", "10.10.0.0/24", "-m", "conntrack", "--ctstate", "NEW", "-j", "DROP"},
+	}
+	if !reflect.DeepEqual(rules, expected) {
+		t.Fatalf("unexpected rules:\n%v\nexpected:\n%v", rules, expected)
+	}
+}
+
+func TestEnsureRoutingRules(t *testing.T) {
+	ipt := fakeiptables.New()
+	defer ipt.Install()()
+
+	d := newDriver()
+	d.config.EnableIPTables = true
+	d.networks["n1"] = &bridgeNetwork{id: "n1", config: &networkConfiguration{BridgeName: "br1"}}
+	d.networks["n2"] = &bridgeNetwork{id: "n2", config: &networkConfiguration{BridgeName: "br2"}}
+	d.networks["n3"] = &bridgeNetwork{id: "n3", config: &networkConfiguration{BridgeName: "br3", Internal: true}}
+	for _, chain := range []string{IsolationChain1, IsolationChain2} {
+		if _, err := iptables.NewChain(chain, iptables.Filter, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	isolation1 := []string{"-i", "br1", "!", "-o", "br1", "-j", IsolationChain2}
+	isolation2 := []string{"-i", "br2", "!", "-o", "br2", "-j", IsolationChain2}
+	if err := iptables.ProgramRule(iptables.Filter, IsolationChain1, iptables.Append, isolation1); err != nil {
+		t.Fatal(err)
+	}
+
+	rules := []driverapi.InterNetworkRule{
+		{From: driverapi.InterNetworkEnd{NetworkID: "n1"}, To: driverapi.InterNetworkEnd{NetworkID: "n2"}, Bidirectional: true},
+		// The internal networks stay isolated
+		{From: driverapi.InterNetworkEnd{NetworkID: "n1"}, To: driverapi.InterNetworkEnd{NetworkID: "n3"}},
+	}
+	if err := d.SetInterNetworkRules(rules); err != nil {
+		t.Fatal(err)
+	}
+	// A new isolation rule lands on top, the inter-network rules go back
+	// ahead of it
+	if err := iptables.ProgramRule(iptables.Filter, IsolationChain1, iptables.Insert, isolation2); err != nil {
+		t.Fatal(err)
+	}
+	d.restoreRoutingRules()
+	expected := [][]string{
+		{"-i", "br1", "-o", "br2", "-j", "RETURN"},
+		{"-i", "br2", "-o", "br1", "-j", "RETURN"},
+		isolation2,
+		isolation1,
+	}
+	if r := ipt.IPv4().Rules(iptables.Filter, IsolationChain1); !reflect.DeepEqual(r, expected) {
+		t.Fatalf("unexpected rules:\n%v\nexpected:\n%v", r, expected)
+	}
+
+	if err := d.SetInterNetworkRules(nil); err != nil {
+		t.Fatal(err)
+	}
+	if r := ipt.IPv4().Rules(iptables.Filter, IsolationChain1); !reflect.DeepEqual(r, [][]string{isolation2, isolation1}) {
+		t.Fatalf("unexpected rules left %v", r)
+	}
+}
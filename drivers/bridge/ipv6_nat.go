@@ -0,0 +1,109 @@
+package bridge
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/docker/libnetwork/iptables"
+	"github.com/docker/libnetwork/types"
+)
+
+// Translations of the IPv6 egress traffic of a network
+const (
+	// IPv6NAT66 masquerades the traffic behind the host address
+	IPv6NAT66 = "nat66"
+	// IPv6NPT translates the network prefix to IPv6NATPrefix statelessly,
+	// as in RFC 6296
+	IPv6NPT = "nptv6"
+)
+
+type ip6Rule struct {
+	table iptables.Table
+	chain string
+	args  []string
+}
+
+func validateIPv6NAT(config *networkConfiguration) error {
+	switch config.IPv6NAT {
+	case "":
+		if config.IPv6NATPrefix != nil {
+			return types.BadRequestErrorf("the IPv6 NAT prefix requires the %s translation", IPv6NPT)
+		}
+		return nil
+	case IPv6NAT66:
+		if config.IPv6NATPrefix != nil {
+			return types.BadRequestErrorf("the IPv6 NAT prefix is only used by the %s translation", IPv6NPT)
+		}
+	case IPv6NPT:
+		if config.IPv6NATPrefix == nil {
+			return types.BadRequestErrorf("the %s translation requires an IPv6 NAT prefix", IPv6NPT)
+		}
+		if ones, bits := config.IPv6NATPrefix.Mask.Size(); bits != 128 || ones > 64 {
+			return types.BadRequestErrorf("invalid IPv6 NAT prefix %s: expected an IPv6 prefix up to /64", config.IPv6NATPrefix)
+		}
+	default:
+		return types.BadRequestErrorf("invalid IPv6 NAT %q", config.IPv6NAT)
+	}
+	if !config.EnableIPv6 {
+		return types.BadRequestErrorf("the IPv6 NAT requires IPv6 to be enabled")
+	}
+	return nil
+}
+
+// ipv6NATRules renders the ip6tables rules translating the traffic of the
+// subnet leaving the bridge
+func ipv6NATRules(bridgeIface string, subnet *net.IPNet, mode string, prefix *net.IPNet) ([]ip6Rule, error) {
+	switch mode {
+	case IPv6NAT66:
+		return []ip6Rule{{table: iptables.Nat, chain: "POSTROUTING",
+			args: []string{"-s", subnet.String(), "!", "-o", bridgeIface, "-j", "MASQUERADE"}}}, nil
+	case IPv6NPT:
+		if subnet.Mask.String() != prefix.Mask.String() {
+			return nil, types.BadRequestErrorf("the IPv6 NAT prefix %s and the subnet %s lengths differ", prefix, subnet)
+		}
+		return []ip6Rule{
+			{table: iptables.Mangle, chain: "POSTROUTING",
+				args: []string{"-s", subnet.String(), "!", "-o", bridgeIface, "-j", "SNPT", "--src-pfx", subnet.String(), "--dst-pfx", prefix.String()}},
+			{table: iptables.Mangle, chain: "PREROUTING",
+				args: []string{"-d", prefix.String(), "!", "-i", bridgeIface, "-j", "DNPT", "--src-pfx", prefix.String(), "--dst-pfx", subnet.String()}},
+		}, nil
+	}
+	return nil, nil
+}
+
+func programIPv6Rule(rule ip6Rule, enable bool) error {
+	exists := iptables.Exists6(rule.table, rule.chain, rule.args...)
+	if enable && !exists {
+		return iptables.ProgramRule6(rule.table, rule.chain, iptables.Insert, rule.args)
+	}
+	if !enable && exists {
+		return iptables.ProgramRule6(rule.table, rule.chain, iptables.Delete, rule.args)
+	}
+	return nil
+}
+
+// setupIPv6NAT programs the IPv6 translation of the network
+func (n *bridgeNetwork) setupIPv6NAT(config *networkConfiguration) error {
+	if config.IPv6NAT == "" || config.AddressIPv6 == nil {
+		return nil
+	}
+	subnet := &net.IPNet{IP: config.AddressIPv6.IP.Mask(config.AddressIPv6.Mask), Mask: config.AddressIPv6.Mask}
+	rules, err := ipv6NATRules(config.BridgeName, subnet, config.IPv6NAT, config.IPv6NATPrefix)
+	if err != nil {
+		return err
+	}
+	for _, rule := range rules {
+		if err := programIPv6Rule(rule, true); err != nil {
+			return fmt.Errorf("failed to program the IPv6 NAT of network %.7s: %v", config.ID, err)
+		}
+	}
+	n.registerIptCleanFunc(func() error {
+		for _, rule := range rules {
+			if err := programIPv6Rule(rule, false); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return nil
+}
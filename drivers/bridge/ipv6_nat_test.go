@@ -0,0 +1,66 @@
+package bridge
+
+import (
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestValidateIPv6NAT(t *testing.T) {
+	_, prefix, _ := net.ParseCIDR("2001:db8:1::/64")
+
+	for _, c := range []*networkConfiguration{
+		{},
+		{EnableIPv6: true, IPv6NAT: IPv6NAT66},
+		{EnableIPv6: true, IPv6NAT: IPv6NPT, IPv6NATPrefix: prefix},
+	} {
+		if err := validateIPv6NAT(c); err != nil {
+			t.Fatalf("unexpected error validating %+v: %v", c, err)
+		}
+	}
+
+	_, v4, _ := net.ParseCIDR("10.0.0.0/8")
+	for _, c := range []*networkConfiguration{
+		{IPv6NAT: IPv6NAT66},
+		{EnableIPv6: true, IPv6NAT: "nat64"},
+		{EnableIPv6: true, IPv6NAT: IPv6NPT},
+		{EnableIPv6: true, IPv6NAT: IPv6NPT, IPv6NATPrefix: v4},
+		{EnableIPv6: true, IPv6NAT: IPv6NAT66, IPv6NATPrefix: prefix},
+		{EnableIPv6: true, IPv6NATPrefix: prefix},
+	} {
+		if err := validateIPv6NAT(c); err == nil {
+			t.Fatalf("expected an error validating %+v", c)
+		}
+	}
+}
+
+func TestIPv6NATRules(t *testing.T) {
+	_, subnet, _ := net.ParseCIDR("fd00:1::/64")
+	_, prefix, _ := net.ParseCIDR("2001:db8:1::/64")
+
+	rules, err := ipv6NATRules("br0", subnet, IPv6NAT66, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"-s", "fd00:1::/64", "!", "-o", "br0", "-j", "MASQUERADE"}
+	if len(rules) != 1 || rules[0].chain != "POSTROUTING" || !reflect.DeepEqual(rules[0].args, expected) {
+		t.Fatalf("unexpected rules: %+v", rules)
+	}
+
+	rules, err = ipv6NATRules("br0", subnet, IPv6NPT, prefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedNPT := [][]string{
+		{"-s", "fd00:1::/64", "!", "-o", "br0", "-j", "SNPT", "--src-pfx", "fd00:1::/64", "--dst-pfx", "2001:db8:1::/64"},
+		{"-d", "2001:db8:1::/64", "!", "-i", "br0", "-j", "DNPT", "--src-pfx", "2001:db8:1::/64", "--dst-pfx", "fd00:1::/64"},
+	}
+	if len(rules) != 2 || !reflect.DeepEqual(rules[0].args, expectedNPT[0]) || !reflect.DeepEqual(rules[1].args, expectedNPT[1]) {
+		t.Fatalf("unexpected rules: %+v", rules)
+	}
+
+	_, short, _ := net.ParseCIDR("2001:db8::/48")
+	if _, err := ipv6NATRules("br0", subnet, IPv6NPT, short); err == nil {
+		t.Fatal("expected an error translating between prefixes of different lengths")
+	}
+}
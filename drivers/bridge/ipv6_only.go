@@ -0,0 +1,95 @@
+package bridge
+
+import (
+	"fmt"
+
+	"github.com/docker/libnetwork/iptables"
+	"github.com/docker/libnetwork/types"
+)
+
+// validateIPv6Only checks the IPv6-only network enables IPv6 and uses none
+// of the settings needing an IPv4 address on the bridge
+func validateIPv6Only(config *networkConfiguration) error {
+	if !config.IPv6Only {
+		return nil
+	}
+	if !config.EnableIPv6 {
+		return types.BadRequestErrorf("the IPv6-only network requires IPv6 to be enabled")
+	}
+	switch {
+	case config.MetadataProxy != "":
+		return types.BadRequestErrorf("the metadata proxy requires an IPv4 address on the bridge of the IPv6-only network")
+	case config.SNATPool != "":
+		return types.BadRequestErrorf("the SNAT pool is not supported on the IPv6-only network")
+	case len(config.SecondaryAddressesIPv4) > 0:
+		return types.BadRequestErrorf("the IPv6-only network cannot have IPv4 subnets")
+	}
+	return nil
+}
+
+// ipv6OnlyRules returns the ip6tables rules forwarding the traffic of the
+// IPv6-only network, as the IPv4 bridge rules would
+func ipv6OnlyRules(config *networkConfiguration) []ip6Rule {
+	br := config.BridgeName
+	iccAction := "DROP"
+	if config.EnableICC {
+		iccAction = "ACCEPT"
+	}
+	rules := []ip6Rule{
+		{table: iptables.Filter, chain: "FORWARD", args: []string{"-i", br, "-o", br, "-j", iccAction}},
+	}
+	if config.Internal {
+		return append(rules,
+			ip6Rule{table: iptables.Filter, chain: "FORWARD", args: []string{"-i", br, "!", "-o", br, "-j", "DROP"}},
+			ip6Rule{table: iptables.Filter, chain: "FORWARD", args: []string{"!", "-i", br, "-o", br, "-j", "DROP"}})
+	}
+	return append(rules,
+		ip6Rule{table: iptables.Filter, chain: "FORWARD", args: []string{"-i", br, "!", "-o", br, "-j", "ACCEPT"}},
+		ip6Rule{table: iptables.Filter, chain: "FORWARD", args: []string{"-o", br, "-m", "conntrack", "--ctstate", "RELATED,ESTABLISHED", "-j", "ACCEPT"}})
+}
+
+// ipv4DropRules returns the iptables rules dropping the IPv4 traffic to and
+// from the bridge of the IPv6-only network
+func ipv4DropRules(config *networkConfiguration) []iptRule {
+	br := config.BridgeName
+	return []iptRule{
+		{table: iptables.Filter, chain: "FORWARD", args: []string{"-i", br, "-j", "DROP"}},
+		{table: iptables.Filter, chain: "FORWARD", args: []string{"-o", br, "-j", "DROP"}},
+		{table: iptables.Filter, chain: "INPUT", args: []string{"-i", br, "-j", "DROP"}},
+	}
+}
+
+// setupIPv6OnlyTables programs the filtering of the IPv6-only network in
+// place of the IPv4 one of setupIPTables
+func (n *bridgeNetwork) setupIPv6OnlyTables(config *networkConfiguration, i *bridgeInterface) error {
+	rules6 := ipv6OnlyRules(config)
+	for _, rule := range rules6 {
+		if err := programIPv6Rule(rule, true); err != nil {
+			return fmt.Errorf("failed to program the IPv6 filtering of network %.7s: %v", config.ID, err)
+		}
+	}
+	rules4 := ipv4DropRules(config)
+	for _, rule := range rules4 {
+		if err := programChainRule(rule, "IPv4 DROP", true); err != nil {
+			return err
+		}
+	}
+	n.registerIptCleanFunc(func() error {
+		for _, rule := range rules6 {
+			if err := programIPv6Rule(rule, false); err != nil {
+				return err
+			}
+		}
+		for _, rule := range rules4 {
+			if err := programChainRule(rule, "IPv4 DROP", false); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	if err := n.setupIPv6NAT(config); err != nil {
+		return fmt.Errorf("Failed to Setup IP tables: %s", err.Error())
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package bridge
+
+import (
+	"encoding/json"
+	"net"
+	"testing"
+
+	"github.com/docker/libnetwork/driverapi"
+)
+
+func TestValidateIPv6Only(t *testing.T) {
+	for _, c := range []*networkConfiguration{
+		{},
+		{EnableIPv6: true, IPv6Only: true},
+		{EnableIPv6: true, IPv6Only: true, IPv6NAT: IPv6NAT66},
+	} {
+		if err := validateIPv6Only(c); err != nil {
+			t.Fatalf("unexpected error validating %+v: %v", c, err)
+		}
+	}
+
+	_, v4, _ := net.ParseCIDR("10.1.0.1/24")
+	for _, c := range []*networkConfiguration{
+		{IPv6Only: true},
+		{EnableIPv6: true, IPv6Only: true, MetadataProxy: "http://127.0.0.1:8080"},
+		{EnableIPv6: true, IPv6Only: true, SNATPool: "192.0.2.10-192.0.2.20"},
+		{EnableIPv6: true, IPv6Only: true, SecondaryAddressesIPv4: []*net.IPNet{v4}},
+	} {
+		if err := validateIPv6Only(c); err == nil {
+			t.Fatalf("expected an error validating %+v", c)
+		}
+	}
+}
+
+func TestIPv6OnlyIPAM(t *testing.T) {
+	_, pool6, _ := net.ParseCIDR("fd00:1::/64")
+	gw6 := &net.IPNet{IP: net.ParseIP("fd00:1::1"), Mask: pool6.Mask}
+	_, pool4, _ := net.ParseCIDR("10.1.0.0/24")
+	v6Data := []driverapi.IPAMData{{Pool: pool6, Gateway: gw6}}
+	v4Data := []driverapi.IPAMData{{Pool: pool4}}
+
+	c := &networkConfiguration{EnableIPv6: true, IPv6Only: true}
+	if err := c.processIPAM("n1", nil, v6Data); err != nil {
+		t.Fatal(err)
+	}
+	if c.AddressIPv4 != nil || c.AddressIPv6.String() != "fd00:1::1/64" {
+		t.Fatalf("unexpected addresses %v %v", c.AddressIPv4, c.AddressIPv6)
+	}
+	if err := (&networkConfiguration{EnableIPv6: true, IPv6Only: true}).processIPAM("n1", v4Data, v6Data); err == nil {
+		t.Fatal("expected an error processing the IPv4 data of the IPv6-only network")
+	}
+	if err := (&networkConfiguration{EnableIPv6: true, IPv6Only: true}).processIPAM("n1", nil, nil); err == nil {
+		t.Fatal("expected an error processing the IPv6-only network without IPv6 data")
+	}
+	if err := (&networkConfiguration{EnableIPv6: true}).processIPAM("n1", nil, v6Data); err == nil {
+		t.Fatal("expected an error processing the network without IPv4 data")
+	}
+}
+
+func TestIPv6OnlyRules(t *testing.T) {
+	rules := ipv6OnlyRules(&networkConfiguration{BridgeName: "br0", EnableICC: true})
+	if len(rules) != 3 || rules[0].args[len(rules[0].args)-1] != "ACCEPT" {
+		t.Fatalf("unexpected rules: %+v", rules)
+	}
+	rules = ipv6OnlyRules(&networkConfiguration{BridgeName: "br0", Internal: true})
+	if len(rules) != 3 {
+		t.Fatalf("unexpected rules: %+v", rules)
+	}
+	for _, r := range rules {
+		if r.args[len(r.args)-1] != "DROP" {
+			t.Fatalf("expected the internal network to drop all but its own traffic: %+v", rules)
+		}
+	}
+}
+
+func TestIPv6OnlyEndpointStore(t *testing.T) {
+	ep := &bridgeEndpoint{
+		id:         "ep1",
+		nid:        "n1",
+		srcName:    "veth0",
+		macAddress: net.HardwareAddr{0x02, 0x42, 0x0a, 0x00, 0x00, 0x01},
+		addrv6:     &net.IPNet{IP: net.ParseIP("fd00:1::2"), Mask: net.CIDRMask(64, 128)},
+	}
+	b, err := json.Marshal(ep)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var restored bridgeEndpoint
+	if err := json.Unmarshal(b, &restored); err != nil {
+		t.Fatal(err)
+	}
+	if restored.addr != nil || restored.addrv6.String() != ep.addrv6.String() {
+		t.Fatalf("unexpected restored addresses %v %v", restored.addr, restored.addrv6)
+	}
+}
@@ -15,4 +15,114 @@ const (
 
 	// DefaultBridge label
 	DefaultBridge = "com.docker.network.bridge.default_bridge"
+
+	// NATExemptions label, the comma separated destination subnets the
+	// traffic of the network is not masqueraded to
+	NATExemptions = "com.docker.network.bridge.nat_exempt"
+
+	// SNATPool label, the IPv4 address or range the egress traffic of the
+	// network is translated to instead of being masqueraded
+	SNATPool = "com.docker.network.bridge.snat_pool"
+
+	// SNATMode label, the distribution of the connections over the SNAT
+	// pool, hash or round-robin
+	SNATMode = "com.docker.network.bridge.snat_mode"
+
+	// IPv6NAT label, the translation of the IPv6 egress traffic of the
+	// network, nat66 or nptv6
+	IPv6NAT = "com.docker.network.bridge.ipv6_nat"
+
+	// IPv6NATPrefix label, the external prefix of the nptv6 translation
+	IPv6NATPrefix = "com.docker.network.bridge.ipv6_nat_prefix"
+
+	// VethSysctls label, the comma separated sysctls set on the host side
+	// veths of the endpoints, as in "rp_filter=2,proxy_arp=1"
+	VethSysctls = "com.docker.network.bridge.veth_sysctls"
+
+	// VethNaming label, the naming of the host side veths of the
+	// endpoints, random, endpoint or name
+	VethNaming = "com.docker.network.bridge.veth_naming"
+
+	// VethPrefix label, the prefix of the host side veth names
+	VethPrefix = "com.docker.network.bridge.veth_prefix"
+
+	// VethQueues label, the number of transmit and receive queues of
+	// both ends of the veths of the endpoints, one when not set
+	VethQueues = "com.docker.network.bridge.veth_queues"
+
+	// VethRPSCPUs label, the comma separated CPUs and CPU ranges, as in
+	// "0-3,8", the receive queues of the veths steer the packets to
+	VethRPSCPUs = "com.docker.network.bridge.veth_rps_cpus"
+
+	// VethXPSCPUs label, the comma separated CPUs and CPU ranges spread
+	// over the transmit queues of the veths, each CPU sending on one queue
+	VethXPSCPUs = "com.docker.network.bridge.veth_xps_cpus"
+
+	// MulticastSnooping label, the IGMP and MLD snooping of the bridge,
+	// the kernel default when not set
+	MulticastSnooping = "com.docker.network.bridge.multicast_snooping"
+
+	// MulticastQuerier label, the bridge sends the IGMP and MLD queries
+	// when no other querier is seen
+	MulticastQuerier = "com.docker.network.bridge.multicast_querier"
+
+	// MulticastRouter label, the bridge receives all the multicast groups
+	// and the host routes the multicast traffic to it, for a multicast
+	// routing daemon such as a PIM one to forward the container groups
+	MulticastRouter = "com.docker.network.bridge.multicast_router"
+
+	// ConntrackZone label, the conntrack zone of the connections the
+	// endpoints originate, a number or auto for one distinct from the
+	// zones of the other networks
+	ConntrackZone = "com.docker.network.bridge.conntrack_zone"
+
+	// IPv6RA label, the bridge sends the router advertisements of the IPv6
+	// subnet for the containers to configure themselves with SLAAC
+	IPv6RA = "com.docker.network.bridge.ipv6_ra"
+
+	// IPv6RARDNSS label, the comma separated IPv6 DNS servers advertised
+	// along the subnet
+	IPv6RARDNSS = "com.docker.network.bridge.ipv6_ra_rdnss"
+
+	// IPv6RARouterLifetime label, the lifetime of the default route
+	// through the bridge the advertisements give, none for no default route
+	IPv6RARouterLifetime = "com.docker.network.bridge.ipv6_ra_router_lifetime"
+
+	// StaticNeighbors label, the neighbor entries of the gateway in the
+	// sandboxes and of the endpoints on the host are installed at the joins
+	StaticNeighbors = "com.docker.network.bridge.static_neighbors"
+
+	// NAT64Pool label, the IPv4 pool the IPv6 addresses of the network are
+	// bound to by the NAT64 of the netlabel.NAT64Prefix option
+	NAT64Pool = "com.docker.network.bridge.nat64_pool"
+
+	// HostAccess label, allow or deny the traffic of the endpoints to the
+	// host addresses and to the link-local ones, as the metadata services
+	HostAccess = "com.docker.network.bridge.host_access"
+
+	// HostAccessPorts label, the host ports the endpoints still reach when
+	// the HostAccess option denies the access
+	HostAccessPorts = "com.docker.network.bridge.host_access_ports"
+
+	// MetadataProxy label, the URL of the metadata service the proxy of
+	// the network forwards the requests of the endpoints to, with their
+	// identity
+	MetadataProxy = "com.docker.network.bridge.metadata_proxy"
+
+	// MetadataAddress label, the link-local address the endpoints reach
+	// the metadata proxy on
+	MetadataAddress = "com.docker.network.bridge.metadata_address"
+
+	// Bootstrap label, the traffic of the new endpoints is dropped until
+	// their join is applied, join, or until their ready signal too, ready
+	Bootstrap = "com.docker.network.bridge.bootstrap"
+
+	// ConnectivityProfile label, the preset of the traffic allowed out of
+	// and into the network, isolated, internal-only, datacenter or internet
+	ConnectivityProfile = "com.docker.network.bridge.connectivity_profile"
+
+	// SocketPolicy label, the connect() calls of the endpoints to the
+	// destinations out of the egress list of the connectivity profile are
+	// rejected by cgroup eBPF programs, ahead of the iptables rules
+	SocketPolicy = "com.docker.network.bridge.socket_policy"
 )
@@ -0,0 +1,214 @@
+package bridge
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/docker/libnetwork/driverapi"
+	"github.com/docker/libnetwork/iptables"
+	"github.com/docker/libnetwork/types"
+	"github.com/sirupsen/logrus"
+)
+
+// MetadataChain is the nat chain, jumped to from PREROUTING, redirecting
+// the metadata addresses of the networks to their proxy
+const MetadataChain = "DOCKER-METADATA"
+
+// The headers of the endpoint identity the metadata proxy sets on the
+// requests it forwards. The ones the endpoints send are dropped.
+const (
+	HeaderEndpointID = "X-Libnetwork-Endpoint-ID"
+	HeaderNetworkID  = "X-Libnetwork-Network-ID"
+	HeaderEndpointIP = "X-Libnetwork-Endpoint-IP"
+)
+
+// headerPrefix is the prefix of the identity headers
+const headerPrefix = "X-Libnetwork-"
+
+// metadataPort is the port the endpoints reach the metadata address on
+const metadataPort = 80
+
+// defaultMetadataAddress is the address the metadata services of the
+// clouds answer on
+var defaultMetadataAddress = net.IPv4(169, 254, 169, 254).To4()
+
+// linkLocalIPv4 is the subnet the metadata addresses are taken from
+var linkLocalIPv4 = &net.IPNet{IP: net.IPv4(169, 254, 0, 0).To4(), Mask: net.CIDRMask(16, 32)}
+
+// metadataProxy forwards the requests of the endpoints of a network to the
+// metadata address to the upstream metadata service, with their identity
+type metadataProxy struct {
+	n        *bridgeNetwork
+	listener net.Listener
+	server   *http.Server
+}
+
+func validateMetadataProxy(config *networkConfiguration) error {
+	if config.MetadataProxy == "" {
+		if config.MetadataAddress != nil {
+			return types.BadRequestErrorf("the metadata address requires a metadata proxy")
+		}
+		return nil
+	}
+	u, err := url.Parse(config.MetadataProxy)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return types.BadRequestErrorf("invalid metadata proxy %q: expected an http or https URL", config.MetadataProxy)
+	}
+	if ip := config.MetadataAddress; ip != nil && (ip.To4() == nil || !linkLocalIPv4.Contains(ip)) {
+		return types.BadRequestErrorf("invalid metadata address %s: expected an address of %s", ip, linkLocalIPv4)
+	}
+	return nil
+}
+
+// metadataRule renders the rule redirecting the traffic of the bridge to
+// the metadata address to the proxy listening on the gateway
+func metadataRule(config *networkConfiguration, proxy *net.TCPAddr) iptRule {
+	return iptRule{table: iptables.Nat, chain: MetadataChain, preArgs: []string{"-t", "nat"}, args: []string{
+		"-i", config.BridgeName, "-d", config.MetadataAddress.String(), "-p", "tcp", "--dport", strconv.Itoa(metadataPort),
+		"-j", "DNAT", "--to-destination", proxy.String(),
+	}}
+}
+
+// startMetadataProxy starts the metadata proxy of the network on the
+// gateway, on a port of the kernel choosing the redirection points to
+func (n *bridgeNetwork) startMetadataProxy(config *networkConfiguration, i *bridgeInterface) error {
+	if !n.driver.config.EnableIPTables {
+		return types.BadRequestErrorf("the metadata proxy requires iptables to be enabled")
+	}
+	upstream, err := url.Parse(config.MetadataProxy)
+	if err != nil {
+		return err
+	}
+	l, err := net.Listen("tcp", net.JoinHostPort(i.bridgeIPv4.IP.String(), "0"))
+	if err != nil {
+		return fmt.Errorf("failed to listen for the metadata proxy of network %.7s: %v", config.ID, err)
+	}
+
+	p := &metadataProxy{n: n, listener: l}
+	p.server = &http.Server{Handler: p.handler(httpProxy(upstream))}
+	go func() {
+		if err := p.server.Serve(l); err != nil && err != http.ErrServerClosed {
+			logrus.Warnf("Metadata proxy of network %.7s stopped: %v", config.ID, err)
+		}
+	}()
+
+	n.Lock()
+	n.metadata = p
+	n.Unlock()
+	return nil
+}
+
+// httpProxy forwards the requests to the upstream service, addressed to
+// its host
+func httpProxy(upstream *url.URL) http.Handler {
+	rp := httputil.NewSingleHostReverseProxy(upstream)
+	director := rp.Director
+	rp.Director = func(r *http.Request) {
+		director(r)
+		r.Host = upstream.Host
+	}
+	return rp
+}
+
+// handler identifies the endpoint by the source address of the request,
+// and passes the request on with the identity headers
+func (p *metadataProxy) handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			http.Error(w, "unknown endpoint", http.StatusForbidden)
+			return
+		}
+		ep := p.n.endpointByIP(net.ParseIP(host))
+		if ep == nil {
+			http.Error(w, "unknown endpoint", http.StatusForbidden)
+			return
+		}
+		for h := range r.Header {
+			if strings.HasPrefix(http.CanonicalHeaderKey(h), headerPrefix) {
+				r.Header.Del(h)
+			}
+		}
+		r.Header.Set(HeaderEndpointID, ep.id)
+		r.Header.Set(HeaderNetworkID, ep.nid)
+		r.Header.Set(HeaderEndpointIP, ep.addr.IP.String())
+		next.ServeHTTP(w, r)
+	})
+}
+
+// endpointByIP returns the endpoint of the network with the IPv4 address
+func (n *bridgeNetwork) endpointByIP(ip net.IP) *bridgeEndpoint {
+	n.Lock()
+	defer n.Unlock()
+	for _, ep := range n.endpoints {
+		if ep.addr != nil && ep.addr.IP.Equal(ip) {
+			return ep
+		}
+	}
+	return nil
+}
+
+// setupMetadataRedirect redirects the metadata address to the proxy of
+// the network, if any
+func (n *bridgeNetwork) setupMetadataRedirect(config *networkConfiguration) error {
+	n.Lock()
+	p := n.metadata
+	n.Unlock()
+	if p == nil {
+		return nil
+	}
+	rule := metadataRule(config, p.listener.Addr().(*net.TCPAddr))
+	if err := programChainRule(rule, "METADATA", true); err != nil {
+		return err
+	}
+	n.registerIptCleanFunc(func() error {
+		return programChainRule(rule, "METADATA", false)
+	})
+	return nil
+}
+
+// stopMetadataProxy stops the metadata proxy of the network, if any
+func (n *bridgeNetwork) stopMetadataProxy() {
+	n.Lock()
+	p := n.metadata
+	n.metadata = nil
+	n.Unlock()
+	if p != nil {
+		p.server.Close()
+	}
+}
+
+// joinMetadata routes the metadata address of the network through the
+// gateway in the sandbox. A sandbox reaches one network on an address,
+// the networks sharing a sandbox are to configure different ones.
+func (n *bridgeNetwork) joinMetadata(jinfo driverapi.JoinInfo, gw net.IP) error {
+	n.Lock()
+	p, config := n.metadata, n.config
+	n.Unlock()
+	if p == nil || gw == nil {
+		return nil
+	}
+	dst := &net.IPNet{IP: config.MetadataAddress, Mask: net.CIDRMask(32, 32)}
+	return jinfo.AddStaticRoute(dst, types.NEXTHOP, gw)
+}
+
+// setupMetadataChain creates the metadata chain and its jump from
+// PREROUTING
+func setupMetadataChain() error {
+	if _, err := iptables.NewChain(MetadataChain, iptables.Nat, false); err != nil {
+		return fmt.Errorf("failed to create NAT metadata chain: %v", err)
+	}
+	jump := []string{"-j", MetadataChain}
+	if iptables.Exists(iptables.Nat, "PREROUTING", jump...) {
+		return nil
+	}
+	if err := iptables.ProgramRule(iptables.Nat, "PREROUTING", iptables.Insert, jump); err != nil {
+		return fmt.Errorf("failed to add the jump to the metadata chain: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package bridge
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestValidateMetadataProxy(t *testing.T) {
+	for _, c := range []*networkConfiguration{
+		{MetadataProxy: "ftp://metadata"},
+		{MetadataProxy: "metadata:8080"},
+		{MetadataProxy: "http://metadata", MetadataAddress: net.ParseIP("10.0.0.1")},
+		{MetadataAddress: defaultMetadataAddress},
+	} {
+		if err := validateMetadataProxy(c); err == nil {
+			t.Fatalf("invalid metadata proxy %+v accepted", c)
+		}
+	}
+	if err := validateMetadataProxy(&networkConfiguration{MetadataProxy: "https://metadata/v1", MetadataAddress: net.ParseIP("169.254.170.2")}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMetadataRule(t *testing.T) {
+	config := &networkConfiguration{BridgeName: "br0", MetadataProxy: "http://metadata", MetadataAddress: defaultMetadataAddress}
+	rule := metadataRule(config, &net.TCPAddr{IP: net.ParseIP("172.18.0.1"), Port: 41000})
+	expected := []string{"-i", "br0", "-d", "169.254.169.254", "-p", "tcp", "--dport", "80", "-j", "DNAT", "--to-destination", "172.18.0.1:41000"}
+	if rule.chain != MetadataChain || !reflect.DeepEqual(rule.args, expected) {
+		t.Fatalf("unexpected rule %s %v", rule.chain, rule.args)
+	}
+
+	// The redirected traffic passes the host access filtering
+	config.HostAccess = hostAccessDeny
+	ep := &bridgeEndpoint{id: "ep1", addr: &net.IPNet{IP: net.ParseIP("172.18.0.2"), Mask: net.CIDRMask(16, 32)}}
+	expected = []string{"-i", "br0", "-s", "172.18.0.2", "-m", "conntrack", "--ctstate", "DNAT", "--ctorigdst", "169.254.169.254", "-m", "comment", "--comment", "lnet:bridge:ep1", "-m", "comment", "--comment", "lnet-tier:platform", "-j", "RETURN"}
+	if rules := hostAccessRules(config, ep); !reflect.DeepEqual(rules[1], expected) {
+		t.Fatalf("unexpected host access rules %v", rules)
+	}
+}
+
+func TestMetadataProxyHandler(t *testing.T) {
+	var got *http.Request
+	upstream := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+		w.Write([]byte("i-1234"))
+	})
+
+	ep := &bridgeEndpoint{id: "ep1", nid: "net1", addr: &net.IPNet{IP: net.ParseIP("172.18.0.2"), Mask: net.CIDRMask(16, 32)}}
+	n := &bridgeNetwork{endpoints: map[string]*bridgeEndpoint{ep.id: ep}}
+	p := &metadataProxy{n: n}
+	h := p.handler(upstream)
+
+	req := httptest.NewRequest("GET", "http://169.254.169.254/latest/meta-data/instance-id", nil)
+	req.RemoteAddr = "172.18.0.2:40000"
+	req.Header.Set(HeaderEndpointID, "spoofed")
+	req.Header.Set("X-Libnetwork-Tenant", "spoofed")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || w.Body.String() != "i-1234" {
+		t.Fatalf("unexpected response %d %q", w.Code, w.Body.String())
+	}
+	if got.URL.Path != "/latest/meta-data/instance-id" || got.Header.Get(HeaderEndpointID) != "ep1" ||
+		got.Header.Get(HeaderNetworkID) != "net1" || got.Header.Get(HeaderEndpointIP) != "172.18.0.2" ||
+		got.Header.Get("X-Libnetwork-Tenant") != "" {
+		t.Fatalf("unexpected upstream request %s %v", got.URL, got.Header)
+	}
+
+	req.RemoteAddr = "172.18.0.9:40000"
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("expected the unknown endpoint to be turned down, got %d", w.Code)
+	}
+}
@@ -0,0 +1,179 @@
+package bridge
+
+import (
+	"fmt"
+	"syscall"
+
+	"github.com/docker/libnetwork/driverapi"
+	"github.com/docker/libnetwork/types"
+	"github.com/sirupsen/logrus"
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+)
+
+const (
+	mirrorLinkPrefix = "dmir-"
+	vxlanMirrorPort  = 4789
+)
+
+// mirrorLinkName is the name of the tunnel the traffic of the endpoint is
+// mirrored to a remote collector through
+func mirrorLinkName(eid string) string {
+	if len(eid) > 10 {
+		eid = eid[:10]
+	}
+	return mirrorLinkPrefix + eid
+}
+
+// MirrorEndpoint mirrors the traffic of the host side veth of the endpoint,
+// in both directions, with tc mirred actions on a clsact qdisc
+func (d *driver) MirrorEndpoint(nid, eid string, target *driverapi.MirrorTarget) (err error) {
+	n, err := d.getNetwork(nid)
+	if err != nil {
+		return err
+	}
+	ep, err := n.getEndpoint(eid)
+	if err != nil {
+		return err
+	}
+	if ep == nil {
+		return EndpointNotFoundError(eid)
+	}
+	if ep.hostIfName == "" {
+		return types.NotImplementedErrorf("the host side interface of endpoint %.7s is not known", eid)
+	}
+
+	nlh := d.nlh
+	veth, err := nlh.LinkByName(ep.hostIfName)
+	if err != nil {
+		return fmt.Errorf("failed to find the host side interface %s of endpoint %.7s: %v", ep.hostIfName, eid, err)
+	}
+
+	// The previous mirroring is replaced
+	stopMirror(nlh, veth, eid)
+	if target == nil {
+		return nil
+	}
+	defer func() {
+		if err != nil {
+			stopMirror(nlh, veth, eid)
+		}
+	}()
+
+	to, err := mirrorTargetLink(nlh, eid, target)
+	if err != nil {
+		return err
+	}
+
+	qdisc := &netlink.GenericQdisc{
+		QdiscAttrs: netlink.QdiscAttrs{
+			LinkIndex: veth.Attrs().Index,
+			Handle:    netlink.MakeHandle(0xffff, 0),
+			Parent:    netlink.HANDLE_CLSACT,
+		},
+		QdiscType: "clsact",
+	}
+	if err := nlh.QdiscAdd(qdisc); err != nil {
+		return fmt.Errorf("failed to add the clsact qdisc to %s: %v", ep.hostIfName, err)
+	}
+	for _, parent := range []uint32{netlink.HANDLE_MIN_INGRESS, netlink.HANDLE_MIN_EGRESS} {
+		if err := nlh.FilterAdd(mirrorFilter(veth.Attrs().Index, parent, to.Attrs().Index)); err != nil {
+			return fmt.Errorf("failed to add the mirroring filter to %s: %v", ep.hostIfName, err)
+		}
+	}
+	return nil
+}
+
+// mirrorFilter matches all the packets and mirrors them to the egress of
+// the target interface
+func mirrorFilter(linkIndex int, parent uint32, targetIndex int) *netlink.U32 {
+	return &netlink.U32{
+		FilterAttrs: netlink.FilterAttrs{
+			LinkIndex: linkIndex,
+			Parent:    parent,
+			Priority:  1,
+			Protocol:  syscall.ETH_P_ALL,
+		},
+		Sel: &netlink.TcU32Sel{
+			Flags: nl.TC_U32_TERMINAL,
+			Keys:  []netlink.TcU32Key{{}},
+		},
+		Actions: []netlink.Action{
+			&netlink.MirredAction{
+				ActionAttrs:  netlink.ActionAttrs{Action: netlink.TC_ACT_PIPE},
+				MirredAction: netlink.TCA_EGRESS_MIRROR,
+				Ifindex:      targetIndex,
+			},
+		},
+	}
+}
+
+// mirrorTargetLink returns the host interface of the target, or creates
+// the tunnel to its remote collector
+func mirrorTargetLink(nlh *netlink.Handle, eid string, target *driverapi.MirrorTarget) (netlink.Link, error) {
+	if target.Interface != "" {
+		link, err := nlh.LinkByName(target.Interface)
+		if err != nil {
+			return nil, types.BadRequestErrorf("failed to find the mirror interface %s: %v", target.Interface, err)
+		}
+		return link, nil
+	}
+
+	attrs := netlink.LinkAttrs{Name: mirrorLinkName(eid)}
+	var link netlink.Link
+	switch target.Encapsulation {
+	case driverapi.MirrorVXLAN:
+		link = &netlink.Vxlan{
+			LinkAttrs: attrs,
+			VxlanId:   int(target.Key),
+			SrcAddr:   target.Local,
+			Group:     target.Remote,
+			Port:      vxlanMirrorPort,
+		}
+	case driverapi.MirrorGRETAP:
+		gretap := &netlink.Gretap{
+			LinkAttrs: attrs,
+			Local:     target.Local,
+			Remote:    target.Remote,
+		}
+		if target.Key != 0 {
+			gretap.OKey = target.Key
+			gretap.OFlags = nl.GRE_KEY
+		}
+		link = gretap
+	default:
+		return nil, types.BadRequestErrorf("invalid encapsulation %q of the mirrored traffic", target.Encapsulation)
+	}
+	if err := nlh.LinkAdd(link); err != nil {
+		return nil, fmt.Errorf("failed to create the %s mirror tunnel to %s: %v", target.Encapsulation, target.Remote, err)
+	}
+	if err := nlh.LinkSetUp(link); err != nil {
+		return nil, fmt.Errorf("failed to set up the mirror tunnel %s: %v", attrs.Name, err)
+	}
+	return link, nil
+}
+
+// stopMirror removes the clsact qdisc of the veth, with its filters, and
+// the tunnel of the endpoint
+func stopMirror(nlh *netlink.Handle, veth netlink.Link, eid string) {
+	qdiscs, err := nlh.QdiscList(veth)
+	if err == nil {
+		for _, q := range qdiscs {
+			if q.Type() == "clsact" {
+				if err := nlh.QdiscDel(q); err != nil {
+					logrus.Warnf("Failed to remove the mirroring of endpoint %.7s: %v", eid, err)
+				}
+			}
+		}
+	}
+	removeMirrorLink(nlh, eid)
+}
+
+// removeMirrorLink removes the tunnel of the endpoint, if any
+func removeMirrorLink(nlh *netlink.Handle, eid string) {
+	if link, err := nlh.LinkByName(mirrorLinkName(eid)); err == nil {
+		if err := nlh.LinkDel(link); err != nil {
+			logrus.Warnf("Failed to remove the mirror tunnel of endpoint %.7s: %v", eid, err)
+		}
+	}
+}
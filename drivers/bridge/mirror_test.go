@@ -0,0 +1,25 @@
+package bridge
+
+import (
+	"testing"
+
+	"github.com/vishvananda/netlink"
+)
+
+func TestMirrorFilter(t *testing.T) {
+	if name := mirrorLinkName("0123456789abcdef"); name != "dmir-0123456789" || len(name) > maxIfNameLen {
+		t.Fatalf("unexpected mirror link name %q", name)
+	}
+
+	f := mirrorFilter(10, netlink.HANDLE_MIN_EGRESS, 20)
+	if f.LinkIndex != 10 || f.Parent != netlink.HANDLE_MIN_EGRESS {
+		t.Fatalf("unexpected filter attributes: %v", f.FilterAttrs)
+	}
+	if len(f.Sel.Keys) != 1 || f.Sel.Keys[0].Mask != 0 {
+		t.Fatalf("expected a filter matching all the packets: %+v", f.Sel)
+	}
+	m, ok := f.Actions[0].(*netlink.MirredAction)
+	if !ok || m.MirredAction != netlink.TCA_EGRESS_MIRROR || m.Ifindex != 20 || m.Action != netlink.TC_ACT_PIPE {
+		t.Fatalf("unexpected filter action: %+v", f.Actions[0])
+	}
+}
@@ -0,0 +1,102 @@
+package bridge
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+
+	"github.com/docker/libnetwork/iptables"
+	"github.com/docker/libnetwork/types"
+)
+
+const (
+	// multicastRouterPermanent makes the bridge a permanent multicast
+	// router port, receiving all the groups whatever the snooping
+	multicastRouterPermanent = "2"
+	// multicastRange is the IPv4 multicast destination range
+	multicastRange = "224.0.0.0/4"
+)
+
+// bridgeSysfsRoot is where the settings of the bridges are exposed
+var bridgeSysfsRoot = "/sys/class/net"
+
+type bridgeSetting struct {
+	name  string
+	value string
+}
+
+// multicastSettings returns the multicast settings of the bridge, the
+// snooping and querier settings covering both IGMP and MLD
+func multicastSettings(config *networkConfiguration) []bridgeSetting {
+	var settings []bridgeSetting
+	if config.MulticastSnooping != nil {
+		settings = append(settings, bridgeSetting{"multicast_snooping", boolSetting(*config.MulticastSnooping)})
+	}
+	if config.MulticastQuerier {
+		settings = append(settings, bridgeSetting{"multicast_querier", "1"})
+	}
+	if config.MulticastRouter {
+		settings = append(settings, bridgeSetting{"multicast_router", multicastRouterPermanent})
+	}
+	return settings
+}
+
+func boolSetting(b bool) string {
+	if b {
+		return "1"
+	}
+	return "0"
+}
+
+func hasMulticastSettings(config *networkConfiguration) bool {
+	return len(multicastSettings(config)) > 0
+}
+
+// setupMulticast applies the multicast settings of the network to the
+// bridge. As a multicast router port, the bridge hands the groups of the
+// containers to the multicast routing daemon of the host.
+func setupMulticast(config *networkConfiguration, i *bridgeInterface) error {
+	for _, s := range multicastSettings(config) {
+		path := filepath.Join(bridgeSysfsRoot, config.BridgeName, "bridge", s.name)
+		if err := ioutil.WriteFile(path, []byte(s.value), 0644); err != nil {
+			return fmt.Errorf("failed to set the %s of bridge %s: %v", s.name, config.BridgeName, err)
+		}
+	}
+	return nil
+}
+
+// multicastForwardRule accepts the multicast traffic the host routes
+// towards the bridge
+func multicastForwardRule(bridgeName string) iptRule {
+	return iptRule{table: iptables.Filter, chain: "FORWARD", args: []string{"-o", bridgeName, "-d", multicastRange, "-j", "ACCEPT"}}
+}
+
+func (n *bridgeNetwork) setupMulticastForwarding(config *networkConfiguration) error {
+	if !config.MulticastRouter {
+		return nil
+	}
+	rule := multicastForwardRule(config.BridgeName)
+	if err := programChainRule(rule, "MULTICAST FORWARDING", true); err != nil {
+		return err
+	}
+	n.registerIptCleanFunc(func() error {
+		return programChainRule(rule, "MULTICAST FORWARDING", false)
+	})
+	return nil
+}
+
+func parseMulticastSnooping(value string) (*bool, error) {
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
+
+func validateMulticast(c *networkConfiguration) error {
+	if c.MulticastQuerier && c.MulticastSnooping != nil && !*c.MulticastSnooping {
+		return types.BadRequestErrorf("the multicast querier requires the multicast snooping")
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package bridge
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMulticastLabels(t *testing.T) {
+	c := networkConfiguration{}
+	if err := c.fromLabels(map[string]string{
+		MulticastSnooping: "true",
+		MulticastQuerier:  "true",
+		MulticastRouter:   "true",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if c.MulticastSnooping == nil || !*c.MulticastSnooping || !c.MulticastQuerier || !c.MulticastRouter {
+		t.Fatalf("unexpected multicast configuration: %+v", c)
+	}
+	if err := validateMulticast(&c); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.fromLabels(map[string]string{MulticastSnooping: "false"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := validateMulticast(&c); err == nil {
+		t.Fatal("expected an error validating a querier without snooping")
+	}
+
+	if err := c.fromLabels(map[string]string{MulticastRouter: "pim"}); err == nil {
+		t.Fatal("expected an error parsing an invalid multicast router label")
+	}
+}
+
+func TestSetupMulticast(t *testing.T) {
+	root, err := ioutil.TempDir("", "bridge-sysfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	defer func(r string) { bridgeSysfsRoot = r }(bridgeSysfsRoot)
+	bridgeSysfsRoot = root
+
+	dir := filepath.Join(root, "br-mc", "bridge")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	snooping := false
+	config := &networkConfiguration{BridgeName: "br-mc", MulticastSnooping: &snooping, MulticastRouter: true}
+	if !hasMulticastSettings(config) {
+		t.Fatal("multicast settings not reported")
+	}
+	if err := setupMulticast(config, nil); err != nil {
+		t.Fatal(err)
+	}
+	for name, expected := range map[string]string{"multicast_snooping": "0", "multicast_router": multicastRouterPermanent} {
+		b, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != expected {
+			t.Fatalf("unexpected %s %q, expected %q", name, b, expected)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "multicast_querier")); !os.IsNotExist(err) {
+		t.Fatal("multicast querier set while not configured")
+	}
+
+	if hasMulticastSettings(&networkConfiguration{BridgeName: "br0"}) {
+		t.Fatal("multicast settings reported without configuration")
+	}
+}
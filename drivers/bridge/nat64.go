@@ -0,0 +1,229 @@
+package bridge
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"sync"
+	"syscall"
+	"unsafe"
+
+	"github.com/docker/libnetwork/iptables"
+	"github.com/docker/libnetwork/types"
+	"github.com/sirupsen/logrus"
+	"github.com/vishvananda/netlink"
+)
+
+// defaultNAT64Pool is the IPv4 pool the IPv6 addresses of a NAT64 network
+// are bound to when none is configured
+var defaultNAT64Pool = &net.IPNet{IP: net.IPv4(192, 168, 255, 0).To4(), Mask: net.CIDRMask(24, 32)}
+
+// nat64Gateway runs the translation of a network on its TUN interface
+type nat64Gateway struct {
+	t    *nat64Translator
+	tun  *os.File
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+func validateNAT64(config *networkConfiguration) error {
+	if config.NAT64Prefix == nil {
+		if config.NAT64Pool != nil {
+			return types.BadRequestErrorf("the NAT64 pool requires a NAT64 prefix")
+		}
+		return nil
+	}
+	if ones, bits := config.NAT64Prefix.Mask.Size(); bits != 128 || ones != 96 {
+		return types.BadRequestErrorf("invalid NAT64 prefix %s: expected an IPv6 /96", config.NAT64Prefix)
+	}
+	if config.NAT64Pool != nil {
+		if ones, bits := config.NAT64Pool.Mask.Size(); bits != 32 || ones > 30 {
+			return types.BadRequestErrorf("invalid NAT64 pool %s: expected an IPv4 subnet up to /30", config.NAT64Pool)
+		}
+	}
+	if !config.EnableIPv6 {
+		return types.BadRequestErrorf("the NAT64 requires IPv6 to be enabled")
+	}
+	if config.Internal {
+		return types.BadRequestErrorf("the NAT64 is not available on the internal networks")
+	}
+	return nil
+}
+
+// nat64Rules renders the iptables rules masquerading the pool behind the
+// host address, and the ip6tables rules forwarding the traffic of the
+// bridge to the translation
+func nat64Rules(bridgeName, tunName string, pool *net.IPNet) ([]iptRule, []ip6Rule) {
+	rules := []iptRule{
+		{table: iptables.Nat, chain: "POSTROUTING", preArgs: []string{"-t", "nat"}, args: []string{"-s", pool.String(), "!", "-o", tunName, "-j", "MASQUERADE"}},
+		{table: iptables.Filter, chain: "FORWARD", args: []string{"-i", tunName, "!", "-o", tunName, "-j", "ACCEPT"}},
+		{table: iptables.Filter, chain: "FORWARD", args: []string{"-o", tunName, "-m", "conntrack", "--ctstate", "RELATED,ESTABLISHED", "-j", "ACCEPT"}},
+	}
+	rules6 := []ip6Rule{
+		{table: iptables.Filter, chain: "FORWARD", args: []string{"-i", bridgeName, "-o", tunName, "-j", "ACCEPT"}},
+		{table: iptables.Filter, chain: "FORWARD", args: []string{"-i", tunName, "-o", bridgeName, "-j", "ACCEPT"}},
+	}
+	return rules, rules6
+}
+
+// openTUN creates the TUN interface and returns its file, the reads of
+// which the close interrupts
+func openTUN(name string) (*os.File, error) {
+	fd, err := syscall.Open("/dev/net/tun", syscall.O_RDWR|syscall.O_NONBLOCK|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, err
+	}
+	var req struct {
+		name  [syscall.IFNAMSIZ]byte
+		flags uint16
+		_     [22]byte
+	}
+	copy(req.name[:], name)
+	req.flags = syscall.IFF_TUN | syscall.IFF_NO_PI
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), uintptr(syscall.TUNSETIFF), uintptr(unsafe.Pointer(&req))); errno != 0 {
+		syscall.Close(fd)
+		return nil, errno
+	}
+	return os.NewFile(uintptr(fd), "/dev/net/tun"), nil
+}
+
+// setupNAT64 translates the traffic of the network to the IPv4 addresses
+// embedded in the NAT64 prefix on a TUN interface, the prefix being routed
+// to it and the translated traffic masqueraded as the IPv4 subnets
+func (n *bridgeNetwork) setupNAT64(config *networkConfiguration, i *bridgeInterface) error {
+	tunName := "nat64" + config.ID[:7]
+	tun, err := openTUN(tunName)
+	if err != nil {
+		return fmt.Errorf("failed to create the NAT64 interface %s: %v", tunName, err)
+	}
+	g := &nat64Gateway{t: newNAT64Translator(config.NAT64Prefix, config.NAT64Pool), tun: tun, stop: make(chan struct{})}
+	if err := setupNAT64Link(i.nlh, tunName, config); err != nil {
+		tun.Close()
+		return err
+	}
+
+	if n.driver.config.EnableIPTables {
+		rules, rules6 := nat64Rules(config.BridgeName, tunName, config.NAT64Pool)
+		for _, rule := range rules {
+			if err := programChainRule(rule, "NAT64", true); err != nil {
+				tun.Close()
+				return err
+			}
+		}
+		for _, rule := range rules6 {
+			if err := programIPv6Rule(rule, true); err != nil {
+				tun.Close()
+				return fmt.Errorf("failed to program the NAT64 of network %.7s: %v", config.ID, err)
+			}
+		}
+		n.registerIptCleanFunc(func() error {
+			for _, rule := range rules {
+				if err := programChainRule(rule, "NAT64", false); err != nil {
+					return err
+				}
+			}
+			for _, rule := range rules6 {
+				if err := programIPv6Rule(rule, false); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}
+
+	g.wg.Add(1)
+	go g.run(tunName)
+
+	n.Lock()
+	n.nat64 = g
+	n.Unlock()
+	return nil
+}
+
+// setupNAT64Link brings the TUN interface up and routes the NAT64 prefix
+// and the pool to it
+func setupNAT64Link(nlh *netlink.Handle, tunName string, config *networkConfiguration) error {
+	link, err := nlh.LinkByName(tunName)
+	if err != nil {
+		return fmt.Errorf("failed to get the NAT64 interface %s: %v", tunName, err)
+	}
+	mtu := config.Mtu
+	if mtu == 0 {
+		mtu = 1500
+	}
+	if err := nlh.LinkSetMTU(link, mtu); err != nil {
+		return fmt.Errorf("failed to set the MTU of the NAT64 interface %s: %v", tunName, err)
+	}
+	// the replies enter the interface from the IPv4 destinations, routed
+	// elsewhere
+	if err := ioutil.WriteFile("/proc/sys/net/ipv4/conf/"+tunName+"/rp_filter", []byte("2"), 0644); err != nil {
+		return fmt.Errorf("failed to loosen the reverse path filter of the NAT64 interface %s: %v", tunName, err)
+	}
+	if err := nlh.LinkSetUp(link); err != nil {
+		return fmt.Errorf("failed to bring the NAT64 interface %s up: %v", tunName, err)
+	}
+	for _, dst := range []*net.IPNet{config.NAT64Prefix, config.NAT64Pool} {
+		route := &netlink.Route{LinkIndex: link.Attrs().Index, Dst: dst, Scope: netlink.SCOPE_LINK}
+		if err := nlh.RouteAdd(route); err != nil {
+			return fmt.Errorf("failed to route %s to the NAT64 interface %s: %v", dst, tunName, err)
+		}
+	}
+	return nil
+}
+
+// stopNAT64 stops the translation of the network, if any, the TUN
+// interface going away with its file
+func (n *bridgeNetwork) stopNAT64() {
+	n.Lock()
+	g := n.nat64
+	n.nat64 = nil
+	n.Unlock()
+	if g != nil {
+		close(g.stop)
+		g.tun.Close()
+		g.wg.Wait()
+	}
+}
+
+// run translates the packets read from the TUN interface and writes them
+// back, until the interface is closed
+func (g *nat64Gateway) run(tunName string) {
+	defer g.wg.Done()
+
+	buf := make([]byte, 65535)
+	for {
+		n, err := g.tun.Read(buf)
+		if err != nil {
+			select {
+			case <-g.stop:
+				return
+			default:
+			}
+			logrus.Warnf("Stopping the NAT64 on %s: %v", tunName, err)
+			return
+		}
+		if n == 0 {
+			continue
+		}
+
+		var out []byte
+		switch buf[0] >> 4 {
+		case 6:
+			out, err = g.t.translate6to4(buf[:n])
+		case 4:
+			out, err = g.t.translate4to6(buf[:n])
+		default:
+			continue
+		}
+		if err != nil {
+			if err != errNAT64Drop {
+				logrus.Debugf("Dropped a packet on %s: %v", tunName, err)
+			}
+			continue
+		}
+		if _, err := g.tun.Write(out); err != nil {
+			logrus.Debugf("Failed to write a translated packet on %s: %v", tunName, err)
+		}
+	}
+}
@@ -0,0 +1,286 @@
+package bridge
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+)
+
+func testNAT64Translator(t *testing.T, pool string) *nat64Translator {
+	_, prefix, _ := net.ParseCIDR("64:ff9b::/96")
+	_, p, err := net.ParseCIDR(pool)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return newNAT64Translator(prefix, p)
+}
+
+// ipv6Packet returns the IPv6 packet carrying the segment, its TCP, UDP or
+// ICMPv6 checksum computed
+func ipv6Packet(src, dst net.IP, proto byte, seg []byte) []byte {
+	pkt := make([]byte, 40, 40+len(seg))
+	pkt[0] = 0x60
+	binary.BigEndian.PutUint16(pkt[4:6], uint16(len(seg)))
+	pkt[6] = proto
+	pkt[7] = 64
+	copy(pkt[8:24], src.To16())
+	copy(pkt[24:40], dst.To16())
+	pkt = append(pkt, seg...)
+	off := map[byte]int{protoTCP: 16, protoUDP: 6, protoICMPv6: 2}[proto]
+	binary.BigEndian.PutUint16(pkt[40+off:], checksum(pkt[40:], pseudoHeader6(pkt[8:24], pkt[24:40], proto, len(seg))))
+	return pkt
+}
+
+// ipv4Packet returns the IPv4 packet carrying the segment, its TCP, UDP or
+// ICMP checksum computed
+func ipv4Packet(src, dst net.IP, proto byte, seg []byte) []byte {
+	pkt := make([]byte, 20, 20+len(seg))
+	pkt[0] = 0x45
+	binary.BigEndian.PutUint16(pkt[2:4], uint16(20+len(seg)))
+	pkt[8] = 64
+	pkt[9] = proto
+	copy(pkt[12:16], src.To4())
+	copy(pkt[16:20], dst.To4())
+	binary.BigEndian.PutUint16(pkt[10:12], checksum(pkt[:20], 0))
+	pkt = append(pkt, seg...)
+	switch proto {
+	case protoICMP:
+		binary.BigEndian.PutUint16(pkt[22:24], checksum(pkt[20:], 0))
+	case protoTCP, protoUDP:
+		off := map[byte]int{protoTCP: 16, protoUDP: 6}[proto]
+		binary.BigEndian.PutUint16(pkt[20+off:], checksum(pkt[20:], pseudoHeader4(pkt[12:16], pkt[16:20], proto, len(seg))))
+	}
+	return pkt
+}
+
+func udpSegment(sport, dport uint16, data string) []byte {
+	seg := make([]byte, 8, 8+len(data))
+	binary.BigEndian.PutUint16(seg[0:2], sport)
+	binary.BigEndian.PutUint16(seg[2:4], dport)
+	binary.BigEndian.PutUint16(seg[4:6], uint16(8+len(data)))
+	return append(seg, data...)
+}
+
+func tcpSegment(sport, dport uint16, data string) []byte {
+	seg := make([]byte, 20, 20+len(data))
+	binary.BigEndian.PutUint16(seg[0:2], sport)
+	binary.BigEndian.PutUint16(seg[2:4], dport)
+	binary.BigEndian.PutUint32(seg[4:8], 12345)
+	seg[12] = 5 << 4
+	seg[13] = 0x02
+	binary.BigEndian.PutUint16(seg[14:16], 65535)
+	return append(seg, data...)
+}
+
+func TestNAT64Translation(t *testing.T) {
+	tr := testNAT64Translator(t, "192.168.255.0/24")
+	client := net.ParseIP("2001:db8:1::2")
+	server := net.ParseIP("198.51.100.7")
+	embedded := net.ParseIP("64:ff9b::c633:6407")
+
+	for _, tc := range []struct {
+		name  string
+		proto byte
+		seg   func() []byte
+	}{
+		{"udp", protoUDP, func() []byte { return udpSegment(40000, 53, "query") }},
+		{"tcp", protoTCP, func() []byte { return tcpSegment(40001, 80, "GET / HTTP/1.0\r\n\r\n") }},
+	} {
+		out, err := tr.translate6to4(ipv6Packet(client, embedded, tc.proto, tc.seg()))
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if checksum(out[:20], 0) != 0 {
+			t.Fatalf("%s: invalid IPv4 header checksum % x", tc.name, out[:20])
+		}
+		src, dst := net.IP(out[12:16]), net.IP(out[16:20])
+		if !src.Equal(net.ParseIP("192.168.255.1")) || !dst.Equal(server) || out[9] != tc.proto || out[8] != 64 || out[6] != 0x40 {
+			t.Fatalf("%s: unexpected IPv4 header % x", tc.name, out[:20])
+		}
+		if checksum(out[20:], pseudoHeader4(out[12:16], out[16:20], tc.proto, len(out)-20)) != 0 {
+			t.Fatalf("%s: invalid checksum after the translation to IPv4", tc.name)
+		}
+
+		// the reply from the server to the pool address
+		seg := append([]byte{}, out[20:]...)
+		copy(seg[0:2], out[22:24])
+		copy(seg[2:4], out[20:22])
+		seg[6], seg[7] = 0, 0
+		if tc.proto == protoTCP {
+			seg[16], seg[17] = 0, 0
+		}
+		back, err := tr.translate4to6(ipv4Packet(server, src, tc.proto, seg))
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if !net.IP(back[8:24]).Equal(embedded) || !net.IP(back[24:40]).Equal(client) || back[6] != tc.proto {
+			t.Fatalf("%s: unexpected IPv6 header % x", tc.name, back[:40])
+		}
+		if int(binary.BigEndian.Uint16(back[4:6])) != len(back)-40 {
+			t.Fatalf("%s: unexpected payload length in % x", tc.name, back[:40])
+		}
+		if checksum(back[40:], pseudoHeader6(back[8:24], back[24:40], tc.proto, len(back)-40)) != 0 {
+			t.Fatalf("%s: invalid checksum after the translation to IPv6", tc.name)
+		}
+	}
+}
+
+func TestNAT64Echo(t *testing.T) {
+	tr := testNAT64Translator(t, "192.168.255.0/24")
+	client := net.ParseIP("2001:db8:1::2")
+	embedded := net.ParseIP("64:ff9b::198.51.100.7")
+
+	echo := []byte{icmpv6EchoRequest, 0, 0, 0, 0x12, 0x34, 0, 1, 'p', 'i', 'n', 'g'}
+	out, err := tr.translate6to4(ipv6Packet(client, embedded, protoICMPv6, echo))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out[9] != protoICMP || out[20] != icmpEchoRequest || checksum(out[20:], 0) != 0 {
+		t.Fatalf("unexpected echo request % x", out)
+	}
+
+	reply := append([]byte{}, out[20:]...)
+	reply[0], reply[2], reply[3] = icmpEchoReply, 0, 0
+	back, err := tr.translate4to6(ipv4Packet(net.ParseIP("198.51.100.7"), net.IP(out[12:16]), protoICMP, reply))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if back[6] != protoICMPv6 || back[40] != icmpv6EchoReply || string(back[48:]) != "ping" {
+		t.Fatalf("unexpected echo reply % x", back)
+	}
+	if checksum(back[40:], pseudoHeader6(back[8:24], back[24:40], protoICMPv6, len(back)-40)) != 0 {
+		t.Fatal("invalid ICMPv6 checksum of the echo reply")
+	}
+
+	// the other ICMPv6 messages are not translated
+	if _, err := tr.translate6to4(ipv6Packet(client, embedded, protoICMPv6, []byte{1, 4, 0, 0, 0, 0, 0, 0})); err != errNAT64Drop {
+		t.Fatalf("expected the ICMPv6 error to be dropped, got %v", err)
+	}
+}
+
+func TestNAT64ICMPErrors(t *testing.T) {
+	tr := testNAT64Translator(t, "192.168.255.0/24")
+	client := net.ParseIP("2001:db8:1::2")
+	server := net.ParseIP("198.51.100.7")
+	router := net.ParseIP("203.0.113.1")
+
+	out, err := tr.translate6to4(ipv6Packet(client, net.ParseIP("64:ff9b::198.51.100.7"), protoUDP, udpSegment(40000, 9, "data")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// fragmentation needed on a 1400 bytes link
+	msg := []byte{3, 4, 0, 0, 0, 0, 0x05, 0x78}
+	msg = append(msg, out[:28]...)
+	back, err := tr.translate4to6(ipv4Packet(router, net.IP(out[12:16]), protoICMP, msg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	icmp := back[40:]
+	if icmp[0] != 2 || icmp[1] != 0 || binary.BigEndian.Uint32(icmp[4:8]) != 1420 {
+		t.Fatalf("unexpected packet too big % x", icmp[:8])
+	}
+	if !net.IP(back[8:24]).Equal(net.ParseIP("64:ff9b::203.0.113.1")) {
+		t.Fatalf("unexpected source %s", net.IP(back[8:24]))
+	}
+	inner := icmp[8:]
+	if inner[0]>>4 != 6 || !net.IP(inner[8:24]).Equal(client) || !net.IP(inner[24:40]).Equal(net.ParseIP("64:ff9b::198.51.100.7")) {
+		t.Fatalf("unexpected inner packet % x", inner)
+	}
+	if checksum(back[40:], pseudoHeader6(back[8:24], back[24:40], protoICMPv6, len(back)-40)) != 0 {
+		t.Fatal("invalid ICMPv6 checksum of the packet too big")
+	}
+
+	// port unreachable
+	msg[1], msg[6], msg[7] = 3, 0, 0
+	back, err = tr.translate4to6(ipv4Packet(server, net.IP(out[12:16]), protoICMP, msg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if back[40] != 1 || back[41] != 4 {
+		t.Fatalf("unexpected port unreachable % x", back[40:48])
+	}
+
+	// the fragments are dropped
+	frag := ipv4Packet(server, net.IP(out[12:16]), protoUDP, udpSegment(9, 40000, "data"))
+	frag[6] = 0x20
+	if _, err := tr.translate4to6(frag); err != errNAT64Drop {
+		t.Fatalf("expected the fragment to be dropped, got %v", err)
+	}
+}
+
+func TestNAT64Bindings(t *testing.T) {
+	tr := testNAT64Translator(t, "192.168.255.0/30")
+	now := time.Now()
+	tr.now = func() time.Time { return now }
+
+	a, b, c := net.ParseIP("2001:db8::a"), net.ParseIP("2001:db8::b"), net.ParseIP("2001:db8::c")
+	va, ok := tr.bind(a)
+	if !ok || net.IP(va[:]).String() != "192.168.255.1" {
+		t.Fatalf("unexpected binding %v of %s", net.IP(va[:]), a)
+	}
+	vb, ok := tr.bind(b)
+	if !ok || net.IP(vb[:]).String() != "192.168.255.2" {
+		t.Fatalf("unexpected binding %v of %s", net.IP(vb[:]), b)
+	}
+	if again, _ := tr.bind(a); again != va {
+		t.Fatalf("expected %s to keep its binding, got %v", a, net.IP(again[:]))
+	}
+	if _, ok := tr.bind(c); ok {
+		t.Fatal("expected no binding with the pool in use")
+	}
+
+	// a is used again, the binding of b goes idle
+	now = now.Add(nat64BindingIdle)
+	tr.bind(a)
+	vc, ok := tr.bind(c)
+	if !ok || vc != vb {
+		t.Fatalf("expected %s to get the idle binding %v, got %v", c, net.IP(vb[:]), net.IP(vc[:]))
+	}
+	if v6, ok := tr.bound(vb[:]); !ok || !net.IP(v6[:]).Equal(c) {
+		t.Fatalf("unexpected address %v bound to %v", net.IP(v6[:]), net.IP(vb[:]))
+	}
+	if _, ok := tr.byV6[[16]byte{}]; ok {
+		t.Fatal("unexpected binding of the unspecified address")
+	}
+}
+
+func TestValidateNAT64(t *testing.T) {
+	_, prefix, _ := net.ParseCIDR("64:ff9b::/96")
+	_, pool, _ := net.ParseCIDR("10.64.0.0/24")
+	_, wide, _ := net.ParseCIDR("64:ff9b::/64")
+	_, v6pool, _ := net.ParseCIDR("2001:db8::/120")
+
+	for _, tc := range []struct {
+		config networkConfiguration
+		valid  bool
+	}{
+		{networkConfiguration{}, true},
+		{networkConfiguration{EnableIPv6: true, NAT64Prefix: prefix}, true},
+		{networkConfiguration{EnableIPv6: true, NAT64Prefix: prefix, NAT64Pool: pool}, true},
+		{networkConfiguration{NAT64Prefix: prefix}, false},
+		{networkConfiguration{EnableIPv6: true, NAT64Prefix: prefix, Internal: true}, false},
+		{networkConfiguration{EnableIPv6: true, NAT64Prefix: wide}, false},
+		{networkConfiguration{EnableIPv6: true, NAT64Prefix: prefix, NAT64Pool: v6pool}, false},
+		{networkConfiguration{EnableIPv6: true, NAT64Pool: pool}, false},
+	} {
+		if err := validateNAT64(&tc.config); (err == nil) != tc.valid {
+			t.Fatalf("unexpected validation of %s from %s with IPv6 %t: %v", tc.config.NAT64Prefix, tc.config.NAT64Pool, tc.config.EnableIPv6, err)
+		}
+	}
+
+	c1 := &networkConfiguration{BridgeName: "br1", NAT64Pool: pool}
+	_, subnet, _ := net.ParseCIDR("10.64.0.0/16")
+	c2 := &networkConfiguration{BridgeName: "br2", AddressIPv4: subnet}
+	if err := c1.Conflicts(c2); err == nil {
+		t.Fatal("expected the pool overlapping the subnet to conflict")
+	}
+	if err := c2.Conflicts(c1); err == nil {
+		t.Fatal("expected the subnet overlapping the pool to conflict")
+	}
+	c3 := &networkConfiguration{BridgeName: "br3", NAT64Pool: pool}
+	if err := c1.Conflicts(c3); err == nil {
+		t.Fatal("expected the pools to conflict")
+	}
+}
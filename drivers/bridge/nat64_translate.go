@@ -0,0 +1,413 @@
+package bridge
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/docker/libnetwork/netutils"
+)
+
+const (
+	icmpEchoReply     = 0
+	icmpEchoRequest   = 8
+	icmpv6EchoRequest = 128
+	icmpv6EchoReply   = 129
+
+	protoICMP   = 1
+	protoTCP    = 6
+	protoUDP    = 17
+	protoICMPv6 = 58
+
+	// nat64BindingIdle is the time a binding of an IPv6 address to an
+	// IPv4 address of the pool goes unused at least before it is reused
+	nat64BindingIdle = 10 * time.Minute
+	// ipv6MinMTU bounds the ICMPv6 errors and the reported path MTUs
+	ipv6MinMTU = 1280
+)
+
+var errNAT64Drop = errors.New("packet not translated")
+
+// nat64Binding binds an IPv6 address of the network to an IPv4 address of
+// the pool
+type nat64Binding struct {
+	v6   [16]byte
+	v4   [4]byte
+	last time.Time
+}
+
+// nat64Translator translates the IPv6 packets of the network to the IPv4
+// addresses embedded in the NAT64 prefix, and their IPv4 replies, as in
+// RFC 7915. Each IPv6 address of the network is bound to an address of the
+// pool, the host masquerading the pool as for the IPv4 subnets.
+type nat64Translator struct {
+	prefix *net.IPNet
+	pool   *net.IPNet
+
+	mu   sync.Mutex
+	byV6 map[[16]byte]*nat64Binding
+	byV4 map[[4]byte]*nat64Binding
+	next uint32
+	now  func() time.Time
+}
+
+func newNAT64Translator(prefix, pool *net.IPNet) *nat64Translator {
+	return &nat64Translator{
+		prefix: prefix,
+		pool:   pool,
+		byV6:   make(map[[16]byte]*nat64Binding),
+		byV4:   make(map[[4]byte]*nat64Binding),
+		now:    time.Now,
+	}
+}
+
+// bind returns the IPv4 address of the pool bound to the IPv6 address,
+// binding a free one or the one idle for the longest time when there is
+// none bound
+func (t *nat64Translator) bind(v6 []byte) ([4]byte, bool) {
+	var key [16]byte
+	copy(key[:], v6)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := t.now()
+	if b, ok := t.byV6[key]; ok {
+		b.last = now
+		return b.v4, true
+	}
+
+	base := binary.BigEndian.Uint32(t.pool.IP.To4())
+	ones, _ := t.pool.Mask.Size()
+	size := uint32(1) << uint(32-ones)
+	// without the network and broadcast addresses of the pool
+	hosts := size - 2
+	for i := uint32(0); i < hosts; i++ {
+		ord := 1 + (t.next+i)%hosts
+		var v4 [4]byte
+		binary.BigEndian.PutUint32(v4[:], base+ord)
+		if _, used := t.byV4[v4]; !used {
+			t.next = ord
+			return t.add(key, v4, now), true
+		}
+	}
+
+	var oldest *nat64Binding
+	for _, b := range t.byV4 {
+		if oldest == nil || b.last.Before(oldest.last) {
+			oldest = b
+		}
+	}
+	if oldest == nil || now.Sub(oldest.last) < nat64BindingIdle {
+		return [4]byte{}, false
+	}
+	delete(t.byV6, oldest.v6)
+	return t.add(key, oldest.v4, now), true
+}
+
+func (t *nat64Translator) add(v6 [16]byte, v4 [4]byte, now time.Time) [4]byte {
+	b := &nat64Binding{v6: v6, v4: v4, last: now}
+	t.byV6[v6] = b
+	t.byV4[v4] = b
+	return v4
+}
+
+// bound returns the IPv6 address bound to the IPv4 address of the pool
+func (t *nat64Translator) bound(v4 []byte) ([16]byte, bool) {
+	var key [4]byte
+	copy(key[:], v4)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	b, ok := t.byV4[key]
+	if !ok {
+		return [16]byte{}, false
+	}
+	b.last = t.now()
+	return b.v6, true
+}
+
+// translate6to4 translates the IPv6 packet sent to the NAT64 prefix. The
+// packets with extension headers and the ICMPv6 messages other than the
+// echoes are dropped.
+func (t *nat64Translator) translate6to4(pkt []byte) ([]byte, error) {
+	if len(pkt) < 40 || pkt[0]>>4 != 6 {
+		return nil, errNAT64Drop
+	}
+	payload := pkt[40:]
+	if plen := int(binary.BigEndian.Uint16(pkt[4:6])); plen < len(payload) {
+		payload = payload[:plen]
+	}
+	dst := netutils.NAT64Embedded(t.prefix, net.IP(pkt[24:40]))
+	if dst == nil {
+		return nil, errNAT64Drop
+	}
+	src, ok := t.bind(pkt[8:24])
+	if !ok {
+		return nil, errNAT64Drop
+	}
+
+	proto := pkt[6]
+	switch proto {
+	case protoTCP, protoUDP:
+	case protoICMPv6:
+		if len(payload) < 8 {
+			return nil, errNAT64Drop
+		}
+		proto = protoICMP
+	default:
+		return nil, errNAT64Drop
+	}
+
+	out := make([]byte, 20+len(payload))
+	out[0] = 0x45
+	out[1] = pkt[0]<<4 | pkt[1]>>4
+	binary.BigEndian.PutUint16(out[2:4], uint16(len(out)))
+	// don't fragment, the sender learns the path MTU as over IPv6
+	binary.BigEndian.PutUint16(out[6:8], 0x4000)
+	out[8] = pkt[7]
+	out[9] = proto
+	copy(out[12:16], src[:])
+	copy(out[16:20], dst)
+	binary.BigEndian.PutUint16(out[10:12], checksum(out[:20], 0))
+	copy(out[20:], payload)
+
+	seg := out[20:]
+	switch proto {
+	case protoICMP:
+		switch seg[0] {
+		case icmpv6EchoRequest:
+			seg[0] = icmpEchoRequest
+		case icmpv6EchoReply:
+			seg[0] = icmpEchoReply
+		default:
+			return nil, errNAT64Drop
+		}
+		seg[2], seg[3] = 0, 0
+		binary.BigEndian.PutUint16(seg[2:4], checksum(seg, 0))
+	default:
+		from := pseudoHeader6(pkt[8:24], pkt[24:40], proto, len(seg))
+		to := pseudoHeader4(out[12:16], out[16:20], proto, len(seg))
+		if err := updateChecksum(proto, seg, from, to); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// translate4to6 translates the IPv4 packet sent to the pool. The fragments
+// and the ICMP messages other than the echoes, the destination unreachable
+// and time exceeded errors are dropped.
+func (t *nat64Translator) translate4to6(pkt []byte) ([]byte, error) {
+	if len(pkt) < 20 || pkt[0]>>4 != 4 {
+		return nil, errNAT64Drop
+	}
+	ihl := int(pkt[0]&0x0f) * 4
+	total := int(binary.BigEndian.Uint16(pkt[2:4]))
+	if ihl < 20 || total < ihl || total > len(pkt) {
+		return nil, errNAT64Drop
+	}
+	if binary.BigEndian.Uint16(pkt[6:8])&0x3fff != 0 {
+		return nil, errNAT64Drop
+	}
+	dst, ok := t.bound(pkt[16:20])
+	if !ok {
+		return nil, errNAT64Drop
+	}
+
+	payload := pkt[ihl:total]
+	proto := pkt[9]
+	switch proto {
+	case protoTCP, protoUDP:
+	case protoICMP:
+		if len(payload) < 8 {
+			return nil, errNAT64Drop
+		}
+		proto = protoICMPv6
+	default:
+		return nil, errNAT64Drop
+	}
+
+	out := make([]byte, 40, 40+len(payload)+20)
+	out[0] = 0x60 | pkt[1]>>4
+	out[1] = pkt[1] << 4
+	out[6] = proto
+	out[7] = pkt[8]
+	copy(out[8:24], netutils.NAT64Address(t.prefix, net.IP(pkt[12:16])))
+	copy(out[24:40], dst[:])
+
+	if proto == protoICMPv6 {
+		msg, err := t.icmpTo6(payload)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, msg...)
+		binary.BigEndian.PutUint16(out[4:6], uint16(len(out)-40))
+		out[42], out[43] = 0, 0
+		binary.BigEndian.PutUint16(out[42:44], checksum(out[40:], pseudoHeader6(out[8:24], out[24:40], protoICMPv6, len(out)-40)))
+		return out, nil
+	}
+
+	out = append(out, payload...)
+	binary.BigEndian.PutUint16(out[4:6], uint16(len(payload)))
+	from := pseudoHeader4(pkt[12:16], pkt[16:20], proto, len(payload))
+	to := pseudoHeader6(out[8:24], out[24:40], proto, len(payload))
+	if err := updateChecksum(proto, out[40:], from, to); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// icmpTo6 translates the ICMP message to ICMPv6, its checksum left to the
+// caller
+func (t *nat64Translator) icmpTo6(msg []byte) ([]byte, error) {
+	out := make([]byte, 8, len(msg)+20)
+	copy(out, msg[:8])
+	switch msg[0] {
+	case icmpEchoRequest:
+		out[0] = icmpv6EchoRequest
+		return append(out, msg[8:]...), nil
+	case icmpEchoReply:
+		out[0] = icmpv6EchoReply
+		return append(out, msg[8:]...), nil
+	case 3: // destination unreachable
+		binary.BigEndian.PutUint32(out[4:8], 0)
+		switch msg[1] {
+		case 0, 1, 5, 6, 7, 8, 11, 12:
+			out[0], out[1] = 1, 0 // no route
+		case 9, 10, 13:
+			out[0], out[1] = 1, 1 // administratively prohibited
+		case 3:
+			out[0], out[1] = 1, 4 // port unreachable
+		case 4:
+			out[0], out[1] = 2, 0 // packet too big
+			mtu := int(binary.BigEndian.Uint16(msg[6:8])) + 20
+			if mtu < ipv6MinMTU {
+				mtu = ipv6MinMTU
+			}
+			binary.BigEndian.PutUint32(out[4:8], uint32(mtu))
+		default:
+			return nil, errNAT64Drop
+		}
+	case 11: // time exceeded
+		out[0], out[1] = 3, msg[1]
+		binary.BigEndian.PutUint32(out[4:8], 0)
+	default:
+		return nil, errNAT64Drop
+	}
+
+	inner, err := t.innerTo6(msg[8:])
+	if err != nil {
+		return nil, err
+	}
+	out = append(out, inner...)
+	if len(out) > ipv6MinMTU-40 {
+		out = out[:ipv6MinMTU-40]
+	}
+	return out, nil
+}
+
+// innerTo6 translates the header of the IPv4 packet carried by an ICMP
+// error, sent from the pool, the transport header being copied as is
+func (t *nat64Translator) innerTo6(pkt []byte) ([]byte, error) {
+	if len(pkt) < 20 || pkt[0]>>4 != 4 {
+		return nil, errNAT64Drop
+	}
+	ihl := int(pkt[0]&0x0f) * 4
+	if ihl < 20 || len(pkt) < ihl {
+		return nil, errNAT64Drop
+	}
+	src, ok := t.bound(pkt[12:16])
+	if !ok {
+		return nil, errNAT64Drop
+	}
+	proto := pkt[9]
+	if proto == protoICMP {
+		proto = protoICMPv6
+	}
+
+	rest := pkt[ihl:]
+	out := make([]byte, 40, 40+len(rest))
+	out[0] = 0x60 | pkt[1]>>4
+	out[1] = pkt[1] << 4
+	if total := int(binary.BigEndian.Uint16(pkt[2:4])); total > ihl {
+		binary.BigEndian.PutUint16(out[4:6], uint16(total-ihl))
+	}
+	out[6] = proto
+	out[7] = pkt[8]
+	copy(out[8:24], src[:])
+	copy(out[24:40], netutils.NAT64Address(t.prefix, net.IP(pkt[16:20])))
+	out = append(out, rest...)
+	if proto == protoICMPv6 && len(rest) >= 1 {
+		switch rest[0] {
+		case icmpEchoRequest:
+			out[40] = icmpv6EchoRequest
+		case icmpEchoReply:
+			out[40] = icmpv6EchoReply
+		}
+	}
+	return out, nil
+}
+
+// checksum returns the internet checksum of the data, on top of the sum
+// of a pseudo header
+func checksum(b []byte, sum uint32) uint16 {
+	for i := 0; i+1 < len(b); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(b[i:]))
+	}
+	if len(b)%2 == 1 {
+		sum += uint32(b[len(b)-1]) << 8
+	}
+	return ^fold(sum)
+}
+
+// fold returns the 16 bits one's complement sum of the sum
+func fold(sum uint32) uint16 {
+	for sum > 0xffff {
+		sum = sum>>16 + sum&0xffff
+	}
+	return uint16(sum)
+}
+
+func pseudoHeader4(src, dst []byte, proto byte, length int) uint32 {
+	var sum uint32
+	for _, a := range [][]byte{src, dst} {
+		sum += uint32(binary.BigEndian.Uint16(a[0:2])) + uint32(binary.BigEndian.Uint16(a[2:4]))
+	}
+	return sum + uint32(proto) + uint32(length)
+}
+
+func pseudoHeader6(src, dst []byte, proto byte, length int) uint32 {
+	var sum uint32
+	for _, a := range [][]byte{src, dst} {
+		for i := 0; i < 16; i += 2 {
+			sum += uint32(binary.BigEndian.Uint16(a[i:]))
+		}
+	}
+	return sum + uint32(length>>16) + uint32(length&0xffff) + uint32(proto)
+}
+
+// updateChecksum updates the TCP or UDP checksum of the segment for the
+// change of its pseudo header, keeping the errors in the data detectable
+// as in RFC 1624. The UDP segments without a checksum are given one.
+func updateChecksum(proto byte, seg []byte, from, to uint32) error {
+	off, minLen := 16, 20
+	if proto == protoUDP {
+		off, minLen = 6, 8
+	}
+	if len(seg) < minLen {
+		return errNAT64Drop
+	}
+	var sum uint16
+	if old := binary.BigEndian.Uint16(seg[off:]); proto == protoUDP && old == 0 {
+		sum = checksum(seg, to)
+	} else {
+		sum = ^fold(uint32(^old) + uint32(^fold(from)) + uint32(fold(to)))
+	}
+	if sum == 0 && proto == protoUDP {
+		sum = 0xffff
+	}
+	binary.BigEndian.PutUint16(seg[off:], sum)
+	return nil
+}
@@ -0,0 +1,49 @@
+package bridge
+
+import (
+	"net"
+
+	"github.com/docker/libnetwork/iptables"
+	"github.com/docker/libnetwork/labelparse"
+)
+
+// parseNATExemptions parses the comma separated destination CIDRs of the
+// NATExemptions label
+func parseNATExemptions(value string) ([]*net.IPNet, error) {
+	return labelparse.ParseNetList(value, labelparse.IPv4)
+}
+
+// natExemptionRules are the rules keeping the source address of the
+// traffic of the subnet towards the exempted destinations, they are
+// inserted above the masquerade rule of the subnet
+func natExemptionRules(bridgeIface string, subnet *net.IPNet, cidrs []*net.IPNet) []iptRule {
+	rules := make([]iptRule, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		rules = append(rules, iptRule{table: iptables.Nat, chain: "POSTROUTING", preArgs: []string{"-t", "nat"},
+			args: []string{"-s", subnet.String(), "-d", cidr.String(), "!", "-o", bridgeIface, "-j", "RETURN"}})
+	}
+	return rules
+}
+
+// setupNATExemptions programs the NAT exemptions of the subnet, to be
+// called once its masquerade rule is in place
+func (n *bridgeNetwork) setupNATExemptions(config *networkConfiguration, subnet *net.IPNet) error {
+	if !config.EnableIPMasquerade || len(config.NATExemptions) == 0 {
+		return nil
+	}
+	rules := natExemptionRules(config.BridgeName, subnet, config.NATExemptions)
+	for _, rule := range rules {
+		if err := programChainRule(rule, "NAT EXEMPTION", true); err != nil {
+			return err
+		}
+	}
+	n.registerIptCleanFunc(func() error {
+		for _, rule := range rules {
+			if err := programChainRule(rule, "NAT EXEMPTION", false); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return nil
+}
@@ -0,0 +1,43 @@
+package bridge
+
+import (
+	"encoding/json"
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestNATExemptions(t *testing.T) {
+	config := &networkConfiguration{DefaultBindingIP: net.IPv4zero}
+	if err := config.fromLabels(map[string]string{NATExemptions: "10.0.0.0/8, 192.168.0.0/16"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(config.NATExemptions) != 2 || config.NATExemptions[1].String() != "192.168.0.0/16" {
+		t.Fatalf("unexpected exemptions: %v", config.NATExemptions)
+	}
+
+	for _, value := range []string{"10.0.0.0", "fd00::/8"} {
+		if err := (&networkConfiguration{}).fromLabels(map[string]string{NATExemptions: value}); err == nil {
+			t.Fatalf("expected an error parsing %q", value)
+		}
+	}
+
+	_, subnet, _ := net.ParseCIDR("172.18.0.0/16")
+	rules := natExemptionRules("br0", subnet, config.NATExemptions[:1])
+	expected := []string{"-s", "172.18.0.0/16", "-d", "10.0.0.0/8", "!", "-o", "br0", "-j", "RETURN"}
+	if len(rules) != 1 || rules[0].chain != "POSTROUTING" || !reflect.DeepEqual(rules[0].args, expected) {
+		t.Fatalf("unexpected rules: %+v", rules)
+	}
+
+	b, err := json.Marshal(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored := &networkConfiguration{}
+	if err := json.Unmarshal(b, restored); err != nil {
+		t.Fatal(err)
+	}
+	if len(restored.NATExemptions) != 2 || restored.NATExemptions[0].String() != "10.0.0.0/8" {
+		t.Fatalf("exemptions not restored: %v", restored.NATExemptions)
+	}
+}
@@ -0,0 +1,86 @@
+package bridge
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/docker/libnetwork/driverapi"
+	"github.com/docker/libnetwork/types"
+	"github.com/sirupsen/logrus"
+	"github.com/vishvananda/netlink"
+)
+
+// hostNeighbors returns the permanent neighbor entries of the addresses of
+// the endpoint on the bridge, which holds the gateway addresses the host
+// resolves them from
+func (n *bridgeNetwork) hostNeighbors(ep *bridgeEndpoint) []*netlink.Neigh {
+	var neighs []*netlink.Neigh
+	for _, addr := range []*net.IPNet{ep.addr, ep.addrv6} {
+		if addr == nil || ep.macAddress == nil {
+			continue
+		}
+		neighs = append(neighs, &netlink.Neigh{
+			LinkIndex:    n.bridge.Link.Attrs().Index,
+			IP:           addr.IP,
+			HardwareAddr: ep.macAddress,
+			State:        netlink.NUD_PERMANENT,
+		})
+	}
+	return neighs
+}
+
+// joinNeighbors installs the permanent neighbor entries of the gateways in
+// the sandbox and of the endpoint on the host, for the first packets not to
+// wait on the address resolution
+func (n *bridgeNetwork) joinNeighbors(nlh *netlink.Handle, ep *bridgeEndpoint, gw net.IP, jinfo driverapi.JoinInfo) (err error) {
+	if !n.config.StaticNeighbors {
+		return nil
+	}
+	ni, ok := jinfo.(driverapi.NeighborInfo)
+	if !ok {
+		return types.NotImplementedErrorf("the sandbox of endpoint %.7s does not support static neighbors", ep.id)
+	}
+
+	// the link of a bridge just created does not carry the address set
+	link, err := nlh.LinkByName(n.config.BridgeName)
+	if err != nil {
+		return fmt.Errorf("failed to get the bridge %s: %v", n.config.BridgeName, err)
+	}
+	mac := link.Attrs().HardwareAddr
+	if gw != nil && ep.addr != nil {
+		if err := ni.AddNeighbor(gw, mac); err != nil {
+			return err
+		}
+	}
+	if gw6 := n.bridge.gatewayIPv6; gw6 != nil && ep.addrv6 != nil {
+		if err := ni.AddNeighbor(gw6, mac); err != nil {
+			return err
+		}
+	}
+
+	defer func() {
+		if err != nil {
+			n.leaveNeighbors(nlh, ep)
+		}
+	}()
+	for _, neigh := range n.hostNeighbors(ep) {
+		logrus.Debugf("Adding the neighbor entry of %s for endpoint %.7s", neigh.IP, ep.id)
+		if err := nlh.NeighSet(neigh); err != nil {
+			return fmt.Errorf("failed to add the neighbor entry of %s for endpoint %.7s: %v", neigh.IP, ep.id, err)
+		}
+	}
+	return nil
+}
+
+// leaveNeighbors removes the neighbor entries of the endpoint from the
+// host, the ones of the sandbox go with its interface
+func (n *bridgeNetwork) leaveNeighbors(nlh *netlink.Handle, ep *bridgeEndpoint) {
+	if !n.config.StaticNeighbors {
+		return
+	}
+	for _, neigh := range n.hostNeighbors(ep) {
+		if err := nlh.NeighDel(neigh); err != nil {
+			logrus.Debugf("Failed to remove the neighbor entry of %s for endpoint %.7s: %v", neigh.IP, ep.id, err)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package bridge
+
+import (
+	"net"
+	"testing"
+
+	"github.com/docker/libnetwork/netlabel"
+	"github.com/docker/libnetwork/testutils"
+	"github.com/docker/libnetwork/types"
+	"github.com/vishvananda/netlink"
+)
+
+type neighborEndpoint struct {
+	*testEndpoint
+	neighbors map[string]net.HardwareAddr
+}
+
+func (te *neighborEndpoint) AddNeighbor(ip net.IP, mac net.HardwareAddr) error {
+	te.neighbors[ip.String()] = mac
+	return nil
+}
+
+func TestStaticNeighbors(t *testing.T) {
+	defer testutils.SetupTestOSContext(t)()
+	d := newDriver()
+	if err := d.configure(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	netconfig := &networkConfiguration{BridgeName: DefaultBridgeName, EnableICC: true, StaticNeighbors: true}
+	ipdList := getIPv4Data(t, "")
+	if err := d.CreateNetwork("net1", map[string]interface{}{netlabel.GenericData: netconfig}, nil, ipdList, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	te := &neighborEndpoint{testEndpoint: newTestEndpoint(ipdList[0].Pool, 11), neighbors: make(map[string]net.HardwareAddr)}
+	if err := d.CreateEndpoint("net1", "ep1", te.Interface(), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := d.Join("net1", "ep1", "sbox", te.testEndpoint, nil); err == nil {
+		t.Fatal("joined a sandbox not supporting static neighbors")
+	} else if _, ok := err.(types.NotImplementedError); !ok {
+		t.Fatalf("expected a not implemented error, got %v", err)
+	}
+	if err := d.Join("net1", "ep1", "sbox", te, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	link, err := netlink.LinkByName(DefaultBridgeName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mac := te.neighbors[te.gw.String()]; mac.String() != link.Attrs().HardwareAddr.String() {
+		t.Fatalf("expected the neighbor entry of gateway %s with %s, got %v", te.gw, link.Attrs().HardwareAddr, te.neighbors)
+	}
+
+	hostEntry := func() *netlink.Neigh {
+		neighs, err := netlink.NeighList(link.Attrs().Index, netlink.FAMILY_V4)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, n := range neighs {
+			if n.IP.Equal(te.iface.addr.IP) {
+				return &n
+			}
+		}
+		return nil
+	}
+	if n := hostEntry(); n == nil || n.State != netlink.NUD_PERMANENT || n.HardwareAddr.String() != te.iface.mac.String() {
+		t.Fatalf("unexpected host neighbor entry %+v", n)
+	}
+
+	if err := d.Leave("net1", "ep1"); err != nil {
+		t.Fatal(err)
+	}
+	if n := hostEntry(); n != nil {
+		t.Fatalf("host neighbor entry left after the leave: %+v", n)
+	}
+}
@@ -0,0 +1,143 @@
+package bridge
+
+import (
+	"strings"
+
+	"github.com/docker/libnetwork/netlabel"
+	"github.com/docker/libnetwork/netpolicy"
+	"github.com/docker/libnetwork/types"
+	"github.com/sirupsen/logrus"
+)
+
+// newNetPolicyProgrammer returns the programmer of the chains of the
+// network policies
+var newNetPolicyProgrammer = netpolicy.NewIptablesProgrammer
+
+func parsePolicyOptions(ec *endpointConfiguration, epOptions map[string]interface{}) error {
+	if opt, ok := epOptions[netlabel.PolicyNamespace]; ok {
+		v, ok := opt.(string)
+		if !ok {
+			return &ErrInvalidEndpointConfig{}
+		}
+		ec.PolicyNamespace = v
+	}
+	opt, ok := epOptions[netlabel.PolicyLabels]
+	if !ok {
+		return nil
+	}
+	v, ok := opt.(string)
+	if !ok {
+		return &ErrInvalidEndpointConfig{}
+	}
+	labels, err := parsePolicyLabels(v)
+	if err != nil {
+		return err
+	}
+	if ec.PolicyNamespace == "" {
+		return types.BadRequestErrorf("invalid policy labels %q: the endpoint has no policy namespace", v)
+	}
+	ec.PolicyLabels = labels
+	return nil
+}
+
+// parsePolicyLabels parses the comma separated key=value labels of an
+// endpoint, the value being optional
+func parsePolicyLabels(v string) (map[string]string, error) {
+	labels := map[string]string{}
+	for _, kv := range strings.Split(v, ",") {
+		if kv = strings.TrimSpace(kv); kv == "" {
+			continue
+		}
+		parts := strings.SplitN(kv, "=", 2)
+		key := strings.TrimSpace(parts[0])
+		if key == "" {
+			return nil, types.BadRequestErrorf("invalid policy label %q: expected key=value", kv)
+		}
+		if len(parts) == 2 {
+			labels[key] = strings.TrimSpace(parts[1])
+		} else {
+			labels[key] = ""
+		}
+	}
+	return labels, nil
+}
+
+// hasPolicyNamespace tells whether the network policies of a namespace
+// apply to the endpoint
+func hasPolicyNamespace(ep *bridgeEndpoint) bool {
+	return ep.config != nil && ep.config.PolicyNamespace != ""
+}
+
+// netPolicySource provides the endpoints of the bridge networks with a
+// policy namespace to the translator of the network policies
+type netPolicySource struct {
+	d          *driver
+	namespaces netpolicy.NamespaceSource
+}
+
+func (s *netPolicySource) Endpoints() []netpolicy.Endpoint {
+	var eps []netpolicy.Endpoint
+	for _, n := range s.d.getNetworks() {
+		n.Lock()
+		for _, ep := range n.endpoints {
+			if !hasPolicyNamespace(ep) || ep.addr == nil {
+				continue
+			}
+			eps = append(eps, netpolicy.Endpoint{
+				ID:        ep.id,
+				Namespace: ep.config.PolicyNamespace,
+				Labels:    ep.config.PolicyLabels,
+				IP:        ep.addr.IP,
+			})
+		}
+		n.Unlock()
+	}
+	return eps
+}
+
+func (s *netPolicySource) NamespaceLabels(namespace string) map[string]string {
+	if s.namespaces == nil {
+		return nil
+	}
+	return s.namespaces.NamespaceLabels(namespace)
+}
+
+// startNetworkPolicies subscribes the translator of the network policies
+// to the informer of the configuration, the known policies getting
+// programmed for the restored endpoints
+func (d *driver) startNetworkPolicies(config *configuration) {
+	t := netpolicy.NewTranslator(&netPolicySource{d: d, namespaces: config.NetworkPolicyNamespaces}, newNetPolicyProgrammer())
+	d.Lock()
+	d.netPolicy = t
+	d.Unlock()
+	t.Start(config.NetworkPolicyInformer)
+}
+
+// syncNetworkPolicies programs the network policies for the endpoints
+// come and gone. It is called with neither the driver nor the networks
+// locked.
+func (d *driver) syncNetworkPolicies() {
+	d.Lock()
+	t := d.netPolicy
+	d.Unlock()
+	if t == nil {
+		return
+	}
+	if err := t.Sync(); err != nil {
+		logrus.Warnf("Failed to program the network policies: %v", err)
+	}
+}
+
+// restoreNetworkPolicies programs back the network policies, after the
+// chains got flushed
+func (d *driver) restoreNetworkPolicies() {
+	d.Lock()
+	t := d.netPolicy
+	d.Unlock()
+	if t == nil {
+		return
+	}
+	if err := t.Restore(); err != nil {
+		logrus.Warnf("Failed to restore the network policies: %v", err)
+	}
+}
@@ -0,0 +1,174 @@
+package bridge
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/docker/libnetwork/netlabel"
+	"github.com/docker/libnetwork/netpolicy"
+)
+
+type fakePolicyInformer struct {
+	policies []*netpolicy.NetworkPolicy
+}
+
+func (f *fakePolicyInformer) AddEventHandler(h netpolicy.EventHandler) {
+	for _, p := range f.policies {
+		h.OnAdd(p)
+	}
+}
+
+// policyProgrammer records the chains of the network policies
+type policyProgrammer struct {
+	chains map[string][][]string
+	jumps  map[string]bool
+}
+
+func (p *policyProgrammer) EnsureChain(name string) error {
+	if _, ok := p.chains[name]; !ok {
+		p.chains[name] = nil
+	}
+	return nil
+}
+
+func (p *policyProgrammer) SetRules(name string, rules [][]string) error {
+	p.chains[name] = rules
+	return nil
+}
+
+func (p *policyProgrammer) DeleteChain(name string) error {
+	delete(p.chains, name)
+	return nil
+}
+
+func (p *policyProgrammer) EnsureJump(from, to string) error {
+	p.jumps[from+"->"+to] = true
+	return nil
+}
+
+func (p *policyProgrammer) RemoveJump(from, to string) error {
+	delete(p.jumps, from+"->"+to)
+	return nil
+}
+
+func (p *policyProgrammer) ReplaceRule(name string, pos int, rule []string) error {
+	p.chains[name][pos-1] = rule
+	return nil
+}
+
+func (p *policyProgrammer) AppendRule(name string, rule []string) error {
+	p.chains[name] = append(p.chains[name], rule)
+	return nil
+}
+
+func (p *policyProgrammer) DeleteRule(name string, rule []string) error {
+	for i, r := range p.chains[name] {
+		if strings.Join(r, " ") == strings.Join(rule, " ") {
+			p.chains[name] = append(p.chains[name][:i:i], p.chains[name][i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+// rules returns the rules of the chain, one string each
+func (p *policyProgrammer) rules(name string) string {
+	var rules []string
+	for _, r := range p.chains[name] {
+		rules = append(rules, strings.Join(r, " "))
+	}
+	return strings.Join(rules, "\n")
+}
+
+func TestParsePolicyOptions(t *testing.T) {
+	ec, err := parseEndpointOptions(map[string]interface{}{
+		netlabel.PolicyNamespace: "prod",
+		netlabel.PolicyLabels:    "app=db, tier=back,canary",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ec.PolicyNamespace != "prod" || len(ec.PolicyLabels) != 3 || ec.PolicyLabels["tier"] != "back" {
+		t.Fatalf("unexpected endpoint configuration %+v", ec)
+	}
+	if _, ok := ec.PolicyLabels["canary"]; !ok {
+		t.Fatal("the label without a value is dropped")
+	}
+
+	if _, err := parseEndpointOptions(map[string]interface{}{netlabel.PolicyLabels: "app=db"}); err == nil {
+		t.Fatal("policy labels accepted without a policy namespace")
+	}
+	if _, err := parseEndpointOptions(map[string]interface{}{netlabel.PolicyNamespace: "prod", netlabel.PolicyLabels: "=db"}); err == nil {
+		t.Fatal("policy label without a key accepted")
+	}
+}
+
+func TestNetworkPolicies(t *testing.T) {
+	prog := &policyProgrammer{chains: map[string][][]string{}, jumps: map[string]bool{}}
+	defer func(f func() netpolicy.Programmer) { newNetPolicyProgrammer = f }(newNetPolicyProgrammer)
+	newNetPolicyProgrammer = func() netpolicy.Programmer { return prog }
+
+	d := newDriver()
+	d.config = &configuration{EnableIPTables: true}
+	db := &bridgeEndpoint{id: "db", addr: &net.IPNet{IP: net.ParseIP("172.18.0.2"), Mask: net.CIDRMask(16, 32)},
+		config: &endpointConfiguration{PolicyNamespace: "prod", PolicyLabels: map[string]string{"app": "db"}}}
+	other := &bridgeEndpoint{id: "other", addr: &net.IPNet{IP: net.ParseIP("172.18.0.9"), Mask: net.CIDRMask(16, 32)}}
+	n := &bridgeNetwork{id: "net1", config: &networkConfiguration{BridgeName: "br0"},
+		endpoints: map[string]*bridgeEndpoint{db.id: db, other.id: other}, driver: d}
+	d.networks[n.id] = n
+
+	inf := &fakePolicyInformer{policies: []*netpolicy.NetworkPolicy{{
+		ObjectMeta: netpolicy.ObjectMeta{Name: "allow-web", Namespace: "prod"},
+		Spec: netpolicy.NetworkPolicySpec{
+			PodSelector: netpolicy.LabelSelector{MatchLabels: map[string]string{"app": "db"}},
+			Ingress: []netpolicy.IngressRule{{From: []netpolicy.Peer{{
+				PodSelector: &netpolicy.LabelSelector{MatchLabels: map[string]string{"app": "web"}},
+			}}}},
+		},
+	}}}
+	d.startNetworkPolicies(&configuration{EnableIPTables: true, NetworkPolicyInformer: inf})
+
+	if !prog.jumps["FORWARD->"+netpolicy.TopChain] {
+		t.Fatalf("no jump to %s from FORWARD", netpolicy.TopChain)
+	}
+	top := prog.chains[netpolicy.TopChain]
+	if len(top) != 1 || strings.Join(top[0][:2], " ") != "-d 172.18.0.2/32" {
+		t.Fatalf("unexpected rules %v of the top chain", top)
+	}
+	in := top[0][len(top[0])-1]
+	if rules := prog.rules(in); strings.Contains(rules, "-s ") || !strings.Contains(rules, "-j DROP") {
+		t.Fatalf("unexpected rules of the ingress chain before the web endpoint:\n%s", rules)
+	}
+
+	// The endpoints created are selected by the policies
+	web := &bridgeEndpoint{id: "web", addr: &net.IPNet{IP: net.ParseIP("172.18.0.3"), Mask: net.CIDRMask(16, 32)},
+		config: &endpointConfiguration{PolicyNamespace: "prod", PolicyLabels: map[string]string{"app": "web"}}}
+	n.endpoints[web.id] = web
+	d.syncNetworkPolicies()
+	top = prog.chains[netpolicy.TopChain]
+	in = top[0][len(top[0])-1]
+	if rules := prog.rules(in); !strings.Contains(rules, "-s 172.18.0.3/32 -j RETURN") {
+		t.Fatalf("unexpected rules of the ingress chain:\n%s", rules)
+	}
+
+	// The chains removed by a firewall reload are programmed again
+	prog.chains, prog.jumps = map[string][][]string{}, map[string]bool{}
+	d.restoreNetworkPolicies()
+	if !prog.jumps["FORWARD->"+netpolicy.TopChain] || len(prog.chains[netpolicy.TopChain]) != 1 {
+		t.Fatalf("the rules are not restored: %v %v", prog.chains, prog.jumps)
+	}
+	in = prog.chains[netpolicy.TopChain][0][len(top[0])-1]
+	if rules := prog.rules(in); !strings.Contains(rules, "-s 172.18.0.3/32 -j RETURN") {
+		t.Fatalf("unexpected rules of the restored ingress chain:\n%s", rules)
+	}
+
+	delete(n.endpoints, db.id)
+	d.syncNetworkPolicies()
+	if top = prog.chains[netpolicy.TopChain]; len(top) != 0 {
+		t.Fatalf("unexpected rules %v of the top chain once the endpoint is gone", top)
+	}
+	if _, ok := prog.chains[in]; ok {
+		t.Fatalf("the chain %s of the endpoint gone is left", in)
+	}
+}
@@ -0,0 +1,314 @@
+package bridge
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"syscall"
+
+	"github.com/docker/libnetwork/labelparse"
+	"github.com/docker/libnetwork/netlabel"
+	"github.com/docker/libnetwork/types"
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+)
+
+// vethOffloads are the offloads an endpoint can turn on or off, with the
+// device features of each, as ethtool -K names them
+var vethOffloads = map[string][]string{
+	"sg":  {"tx-scatter-gather", "tx-scatter-gather-fraglist"},
+	"tx":  {"tx-checksum-ipv4", "tx-checksum-ip-generic", "tx-checksum-ipv6", "tx-checksum-fcoe-crc", "tx-checksum-sctp"},
+	"rx":  {"rx-checksum"},
+	"tso": {"tx-tcp-segmentation", "tx-tcp-ecn-segmentation", "tx-tcp-mangleid-segmentation", "tx-tcp6-segmentation"},
+	"gso": {"tx-generic-segmentation"},
+	"gro": {"rx-gro"},
+	"lro": {"rx-lro"},
+}
+
+// The ethtool generic netlink family, of which the vendored netlink only
+// knows the generic parts
+const (
+	ethtoolGenlName    = "ethtool"
+	ethtoolGenlVersion = 1
+
+	ethtoolMsgFeaturesGet = 11
+	ethtoolMsgFeaturesSet = 12
+
+	ethtoolAHeaderDevName = 2
+
+	ethtoolAFeaturesHeader = 1
+	ethtoolAFeaturesHw     = 2
+	ethtoolAFeaturesWanted = 3
+	ethtoolAFeaturesActive = 4
+
+	ethtoolABitsetNomask = 1
+	ethtoolABitsetBits   = 3
+
+	ethtoolABitsetBitsBit = 1
+
+	ethtoolABitsetBitName  = 2
+	ethtoolABitsetBitValue = 3
+
+	nlaTypeMask = ^uint16(syscall.NLA_F_NESTED | syscall.NLA_F_NET_BYTEORDER)
+)
+
+// parseOffloads parses the comma separated offloads turned on or off, as
+// in gso=off,gro=off
+func parseOffloads(value string) (map[string]bool, error) {
+	kvs, err := labelparse.ParseKeyValues("offload", value)
+	if err != nil {
+		return nil, err
+	}
+	offloads := make(map[string]bool, len(kvs))
+	for _, kv := range kvs {
+		if _, ok := vethOffloads[kv.Key]; !ok {
+			return nil, types.BadRequestErrorf("unsupported offload %q: expected sg, tx, rx, tso, gso, gro or lro", kv.Key)
+		}
+		switch kv.Value {
+		case "on":
+			offloads[kv.Key] = true
+		case "off":
+			offloads[kv.Key] = false
+		default:
+			return nil, types.BadRequestErrorf("invalid value %q of offload %s: expected on or off", kv.Value, kv.Key)
+		}
+	}
+	return offloads, nil
+}
+
+func parseOffloadOptions(ec *endpointConfiguration, epOptions map[string]interface{}) error {
+	opt, ok := epOptions[netlabel.Offloads]
+	if !ok {
+		return nil
+	}
+	v, ok := opt.(string)
+	if !ok {
+		return &ErrInvalidEndpointConfig{}
+	}
+	offloads, err := parseOffloads(v)
+	if err != nil {
+		return err
+	}
+	ec.Offloads = offloads
+	return nil
+}
+
+// setupVethOffloads turns the offloads of the endpoint on or off on both
+// ends of its veth pair. An offload the device cannot change must already
+// be as asked, the result being verified.
+func setupVethOffloads(offloads map[string]bool, ifNames ...string) error {
+	if len(offloads) == 0 {
+		return nil
+	}
+	family, err := netlink.GenlFamilyGet(ethtoolGenlName)
+	if err != nil {
+		return fmt.Errorf("the offloads require the ethtool netlink interface: %v", err)
+	}
+	names := make([]string, 0, len(offloads))
+	for name := range offloads {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, ifName := range ifNames {
+		hw, active, err := getDeviceFeatures(family.ID, ifName)
+		if err != nil {
+			return err
+		}
+		wanted := map[string]bool{}
+		for _, name := range names {
+			for _, f := range vethOffloads[name] {
+				if hw[f] {
+					wanted[f] = offloads[name]
+				}
+			}
+		}
+		if len(wanted) > 0 {
+			if err := setDeviceFeatures(family.ID, ifName, wanted); err != nil {
+				return err
+			}
+			if _, active, err = getDeviceFeatures(family.ID, ifName); err != nil {
+				return err
+			}
+		}
+		// each offload is set when none of its features is in the other
+		// state, and some is on for the ones turned on
+		for _, name := range names {
+			on := false
+			for _, f := range vethOffloads[name] {
+				if _, ok := wanted[f]; (ok || active[f]) && active[f] != offloads[name] {
+					return fmt.Errorf("could not turn %s %s on %s: %s is %s", name, onOff(offloads[name]), ifName, f, onOff(active[f]))
+				}
+				on = on || active[f]
+			}
+			if offloads[name] && !on {
+				return fmt.Errorf("could not turn %s on on %s: not supported by the device", name, ifName)
+			}
+		}
+	}
+	return nil
+}
+
+func onOff(b bool) string {
+	if b {
+		return "on"
+	}
+	return "off"
+}
+
+func newEthtoolRequest(familyID uint16, cmd uint8, ifName string) *nl.NetlinkRequest {
+	req := nl.NewNetlinkRequest(int(familyID), syscall.NLM_F_ACK)
+	req.AddData(&nl.Genlmsg{Command: cmd, Version: ethtoolGenlVersion})
+	header := nl.NewRtAttr(ethtoolAFeaturesHeader|syscall.NLA_F_NESTED, nil)
+	nl.NewRtAttrChild(header, ethtoolAHeaderDevName, nl.ZeroTerminated(ifName))
+	req.AddData(header)
+	return req
+}
+
+// getDeviceFeatures returns the features of the device which can be
+// changed, and the ones which are active
+func getDeviceFeatures(familyID uint16, ifName string) (map[string]bool, map[string]bool, error) {
+	reply, err := ethtoolExecute(newEthtoolRequest(familyID, ethtoolMsgFeaturesGet, ifName))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get the features of %s: %v", ifName, err)
+	}
+	if len(reply) < nl.SizeofGenlmsg {
+		return nil, nil, fmt.Errorf("unexpected reply getting the features of %s", ifName)
+	}
+	attrs, err := nl.ParseRouteAttr(reply[nl.SizeofGenlmsg:])
+	if err != nil {
+		return nil, nil, err
+	}
+	var hw, active map[string]bool
+	for _, a := range attrs {
+		switch a.Attr.Type & nlaTypeMask {
+		case ethtoolAFeaturesHw:
+			hw, err = parseBitset(a.Value)
+		case ethtoolAFeaturesActive:
+			active, err = parseBitset(a.Value)
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to parse the features of %s: %v", ifName, err)
+		}
+	}
+	return hw, active, nil
+}
+
+// parseBitset parses a bitset of the bit list form, the bits set with no
+// mask or with their value flag
+func parseBitset(b []byte) (map[string]bool, error) {
+	attrs, err := nl.ParseRouteAttr(b)
+	if err != nil {
+		return nil, err
+	}
+	bits := map[string]bool{}
+	nomask := false
+	for _, a := range attrs {
+		if a.Attr.Type&nlaTypeMask == ethtoolABitsetNomask {
+			nomask = true
+		}
+	}
+	for _, a := range attrs {
+		if a.Attr.Type&nlaTypeMask != ethtoolABitsetBits {
+			continue
+		}
+		list, err := nl.ParseRouteAttr(a.Value)
+		if err != nil {
+			return nil, err
+		}
+		for _, bit := range list {
+			if bit.Attr.Type&nlaTypeMask != ethtoolABitsetBitsBit {
+				continue
+			}
+			fields, err := nl.ParseRouteAttr(bit.Value)
+			if err != nil {
+				return nil, err
+			}
+			var (
+				name string
+				set  = nomask
+			)
+			for _, f := range fields {
+				switch f.Attr.Type & nlaTypeMask {
+				case ethtoolABitsetBitName:
+					name = strings.TrimRight(string(f.Value), "\x00")
+				case ethtoolABitsetBitValue:
+					set = true
+				}
+			}
+			if name != "" {
+				bits[name] = set
+			}
+		}
+	}
+	return bits, nil
+}
+
+// setDeviceFeatures turns the features on or off, the others left as is
+func setDeviceFeatures(familyID uint16, ifName string, features map[string]bool) error {
+	req := newEthtoolRequest(familyID, ethtoolMsgFeaturesSet, ifName)
+	wanted := nl.NewRtAttr(ethtoolAFeaturesWanted|syscall.NLA_F_NESTED, nil)
+	bits := nl.NewRtAttrChild(wanted, ethtoolABitsetBits|syscall.NLA_F_NESTED, nil)
+	names := make([]string, 0, len(features))
+	for name := range features {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		bit := nl.NewRtAttrChild(bits, ethtoolABitsetBitsBit|syscall.NLA_F_NESTED, nil)
+		nl.NewRtAttrChild(bit, ethtoolABitsetBitName, nl.ZeroTerminated(name))
+		if features[name] {
+			nl.NewRtAttrChild(bit, ethtoolABitsetBitValue, nil)
+		}
+	}
+	req.AddData(wanted)
+	if _, err := ethtoolExecute(req); err != nil {
+		return fmt.Errorf("failed to set the features of %s: %v", ifName, err)
+	}
+	return nil
+}
+
+// ethtoolExecute sends the request and returns the payload of its reply,
+// if any, once acknowledged. The features replies outgrow the page sized
+// buffer the vendored netlink receives in.
+func ethtoolExecute(req *nl.NetlinkRequest) ([]byte, error) {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, syscall.NETLINK_GENERIC)
+	if err != nil {
+		return nil, err
+	}
+	defer syscall.Close(fd)
+	kernel := &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK}
+	if err := syscall.Bind(fd, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK}); err != nil {
+		return nil, err
+	}
+	if err := syscall.Sendto(fd, req.Serialize(), 0, kernel); err != nil {
+		return nil, err
+	}
+	var reply []byte
+	rb := make([]byte, 1<<16)
+	for {
+		n, _, err := syscall.Recvfrom(fd, rb, 0)
+		if err != nil {
+			return nil, err
+		}
+		msgs, err := syscall.ParseNetlinkMessage(rb[:n])
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range msgs {
+			if m.Header.Seq != req.Seq {
+				continue
+			}
+			if m.Header.Type == syscall.NLMSG_ERROR {
+				if len(m.Data) < 4 {
+					return nil, fmt.Errorf("truncated netlink error")
+				}
+				if errno := int32(nl.NativeEndian().Uint32(m.Data[0:4])); errno != 0 {
+					return nil, syscall.Errno(-errno)
+				}
+				return reply, nil
+			}
+			reply = append([]byte(nil), m.Data...)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package bridge
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/docker/libnetwork/netlabel"
+	"github.com/docker/libnetwork/ns"
+	"github.com/docker/libnetwork/testutils"
+	"github.com/vishvananda/netlink"
+)
+
+func TestParseOffloads(t *testing.T) {
+	offloads, err := parseOffloads("gso=off, gro=off,tx=on")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(offloads, map[string]bool{"gso": false, "gro": false, "tx": true}) {
+		t.Fatalf("unexpected offloads %v", offloads)
+	}
+	for _, v := range []string{"gso", "gso=no", "tcp=off", "gso=off,gso=on"} {
+		if _, err := parseOffloads(v); err == nil {
+			t.Fatalf("expected an error parsing %q", v)
+		}
+	}
+
+	ec, err := parseEndpointOptions(map[string]interface{}{netlabel.Offloads: "lro=off"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(ec.Offloads, map[string]bool{"lro": false}) {
+		t.Fatalf("unexpected offloads %v", ec.Offloads)
+	}
+	if _, err := parseEndpointOptions(map[string]interface{}{netlabel.Offloads: 1}); err == nil {
+		t.Fatal("expected an error with offloads not a string")
+	}
+}
+
+func TestSetupVethOffloads(t *testing.T) {
+	if !testutils.IsRunningInContainer() {
+		defer testutils.SetupTestOSContext(t)()
+	}
+	nlh := ns.NlHandle()
+	if err := nlh.LinkAdd(&netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: "vethol0"}, PeerName: "vethol1"}); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if link, err := nlh.LinkByName("vethol0"); err == nil {
+			nlh.LinkDel(link)
+		}
+	}()
+	family, err := netlink.GenlFamilyGet(ethtoolGenlName)
+	if err != nil {
+		t.Skipf("no ethtool netlink interface: %v", err)
+	}
+
+	offloads := map[string]bool{"gso": false, "gro": false, "tx": false, "sg": true}
+	if err := setupVethOffloads(offloads, "vethol0", "vethol1"); err != nil {
+		t.Fatal(err)
+	}
+	for _, ifName := range []string{"vethol0", "vethol1"} {
+		_, active, err := getDeviceFeatures(family.ID, ifName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, f := range []string{"tx-generic-segmentation", "rx-gro", "tx-checksum-ip-generic"} {
+			if active[f] {
+				t.Fatalf("%s still on on %s", f, ifName)
+			}
+		}
+		if !active["tx-scatter-gather"] {
+			t.Fatalf("tx-scatter-gather off on %s", ifName)
+		}
+	}
+
+	if err := setupVethOffloads(map[string]bool{"lro": true}, "vethol0"); err == nil {
+		t.Fatal("expected an error turning lro on on a veth")
+	}
+}
@@ -0,0 +1,102 @@
+package bridge
+
+import (
+	"github.com/docker/libnetwork/iptables"
+	"github.com/docker/libnetwork/ns"
+	"github.com/docker/libnetwork/types"
+	"github.com/sirupsen/logrus"
+)
+
+// taggedChains are the chains holding the rules tagged with their endpoint
+var taggedChains = []struct {
+	table iptables.Table
+	chain string
+}{
+	{iptables.Filter, ConnLimitChain},
+	{iptables.Filter, ICCChain},
+	{iptables.Filter, HostAccessChain},
+	{iptables.Filter, BootstrapChain},
+	{iptables.Filter, QuarantineChain},
+	{iptables.Filter, FilterChain},
+	{iptables.RawTable, SynProxyChain},
+	{iptables.Filter, SynProxyChain},
+	{iptables.RawTable, ConntrackTimeoutChain},
+}
+
+// knownEndpoints returns the IDs of the endpoints of the networks, with
+// the deleted ones waiting for their quiesce period to be over
+func (d *driver) knownEndpoints() map[string]bool {
+	known := make(map[string]bool)
+	for _, n := range d.getNetworks() {
+		n.Lock()
+		for eid := range n.endpoints {
+			known[eid] = true
+		}
+		n.Unlock()
+	}
+	d.cleanupMu.Lock()
+	for eid := range d.pendingCleanups {
+		known[eid] = true
+	}
+	d.cleanupMu.Unlock()
+	return known
+}
+
+// CollectOrphans removes the host veths and the endpoint rules tagged with
+// the ID of an endpoint the driver does not know of. The objects without
+// the libnetwork tag are left alone, whoever created them.
+func (d *driver) CollectOrphans() int {
+	known := d.knownEndpoints()
+	var count int
+
+	nlh := d.nlh
+	if nlh == nil {
+		nlh = ns.NlHandle()
+	}
+	links, err := nlh.LinkList()
+	if err != nil {
+		logrus.Warnf("Failed to list the links for orphaned veths: %v", err)
+	}
+	for _, link := range links {
+		driver, eid, ok := types.TagOwner(link.Attrs().Alias)
+		if !ok || driver != networkType || eid == "" || link.Type() != "veth" || known[eid] {
+			continue
+		}
+		logrus.Infof("Removing interface %s of unknown endpoint %.7s", link.Attrs().Name, eid)
+		if err := nlh.LinkDel(link); err != nil {
+			logrus.Warnf("Failed to remove orphaned interface %s: %v", link.Attrs().Name, err)
+			continue
+		}
+		count++
+	}
+
+	d.Lock()
+	enableIPTables := d.config.EnableIPTables
+	d.Unlock()
+	if !enableIPTables {
+		return count
+	}
+	for _, c := range taggedChains {
+		if !iptables.ExistChain(c.chain, c.table) {
+			continue
+		}
+		rules, err := iptables.OwnedRules(c.table, c.chain)
+		if err != nil {
+			logrus.Warnf("Failed to list the rules for orphaned ones: %v", err)
+			continue
+		}
+		for _, r := range rules {
+			// The annotated rules of the driver name no endpoint
+			if r.Driver != networkType || r.Endpoint == "" || known[r.Endpoint] {
+				continue
+			}
+			logrus.Infof("Removing rule %v of unknown endpoint %.7s", r.Args, r.Endpoint)
+			if err := iptables.ProgramRule(r.Table, r.Chain, iptables.Delete, r.Args); err != nil {
+				logrus.Warnf("Failed to remove orphaned rule %v: %v", r.Args, err)
+				continue
+			}
+			count++
+		}
+	}
+	return count
+}
@@ -0,0 +1,56 @@
+package bridge
+
+import (
+	"testing"
+
+	"github.com/docker/libnetwork/ns"
+	"github.com/docker/libnetwork/testutils"
+	"github.com/docker/libnetwork/types"
+	"github.com/vishvananda/netlink"
+)
+
+func TestCollectOrphans(t *testing.T) {
+	if !testutils.IsRunningInContainer() {
+		defer testutils.SetupTestOSContext(t)()
+	}
+	d := newDriver()
+	d.config = &configuration{}
+	d.nlh = ns.NlHandle()
+	d.networks["n1"] = &bridgeNetwork{
+		config:    &networkConfiguration{ID: "n1"},
+		endpoints: map[string]*bridgeEndpoint{"known": {id: "known"}},
+	}
+
+	for name, alias := range map[string]string{
+		"vethknown":  types.OwnerTag(networkType, "known"),
+		"vethorphan": types.OwnerTag(networkType, "orphan"),
+		"vethother":  "",
+	} {
+		veth := &netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: name}, PeerName: name + "p"}
+		if err := d.nlh.LinkAdd(veth); err != nil {
+			t.Fatal(err)
+		}
+		if alias == "" {
+			continue
+		}
+		link, err := d.nlh.LinkByName(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := d.nlh.LinkSetAlias(link, alias); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if n := d.CollectOrphans(); n != 1 {
+		t.Fatalf("expected a single orphan, got %d", n)
+	}
+	if _, err := d.nlh.LinkByName("vethorphan"); err == nil {
+		t.Fatal("expected the orphaned veth to be removed")
+	}
+	for _, name := range []string{"vethknown", "vethother"} {
+		if _, err := d.nlh.LinkByName(name); err != nil {
+			t.Fatalf("expected %s to be kept: %v", name, err)
+		}
+	}
+}
@@ -2,10 +2,12 @@ package bridge
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"net"
 
+	"github.com/docker/libnetwork/portmapper"
 	"github.com/docker/libnetwork/types"
 	"github.com/ishidawataru/sctp"
 	"github.com/sirupsen/logrus"
@@ -15,41 +17,99 @@ var (
 	defaultBindingIP = net.IPv4(0, 0, 0, 0)
 )
 
-func (n *bridgeNetwork) allocatePorts(ep *bridgeEndpoint, reqDefBindIP net.IP, ulPxyEnabled bool) ([]types.PortBinding, error) {
+func (n *bridgeNetwork) allocatePorts(ctx context.Context, ep *bridgeEndpoint, reqDefBindIP net.IP, ulPxyEnabled bool) ([]types.PortBinding, error) {
 	if ep.extConnConfig == nil || ep.extConnConfig.PortBindings == nil {
 		return nil, nil
 	}
+	if ep.addr == nil {
+		return nil, types.ForbiddenErrorf("ports cannot be published by the endpoint %.7s having no IPv4 address", ep.id)
+	}
 
 	defHostIP := defaultBindingIP
 	if reqDefBindIP != nil {
 		defHostIP = reqDefBindIP
 	}
 
-	return n.allocatePortsInternal(ep.extConnConfig.PortBindings, ep.addr.IP, defHostIP, ulPxyEnabled)
+	var (
+		hairpinPorts map[string]bool
+		heldPorts    []string
+	)
+	if ep.config != nil {
+		hairpinPorts = ep.config.HairpinPorts
+		heldPorts = ep.config.HeldPorts
+	}
+	return n.allocatePortsInternal(ctx, ep.extConnConfig.PortBindings, ep.addr.IP, defHostIP, ulPxyEnabled, hairpinPorts, heldPorts)
 }
 
-func (n *bridgeNetwork) allocatePortsInternal(bindings []types.PortBinding, containerIP, defHostIP net.IP, ulPxyEnabled bool) ([]types.PortBinding, error) {
-	bs := make([]types.PortBinding, 0, len(bindings))
+func (n *bridgeNetwork) allocatePortsInternal(ctx context.Context, bindings []types.PortBinding, containerIP, defHostIP net.IP, ulPxyEnabled bool, hairpinPorts map[string]bool, heldPorts []string) ([]types.PortBinding, error) {
+	var (
+		bs   = make([]types.PortBinding, 0, len(bindings))
+		reqs = make([]portmapper.MapRequest, 0, len(bindings))
+	)
 	for _, c := range bindings {
 		b := c.GetCopy()
-		if err := n.allocatePort(&b, containerIP, defHostIP, ulPxyEnabled); err != nil {
-			// On allocation failure, release previously allocated ports. On cleanup error, just log a warning message
-			if cuErr := n.releasePortsInternal(bs); cuErr != nil {
-				logrus.Warnf("Upon allocation failure for %v, failed to clear previously allocated port bindings: %v", b, cuErr)
-			}
+		container, err := prepareBinding(&b, containerIP, defHostIP)
+		if err != nil {
 			return nil, err
 		}
+		reqs = append(reqs, portmapper.MapRequest{
+			Container:     container,
+			HostIP:        b.HostIP,
+			HostPortStart: int(b.HostPort),
+			HostPortEnd:   int(b.HostPortEnd),
+			Hairpin:       portHairpin(hairpinPorts, b),
+			Held:          portHeld(heldPorts, b),
+		})
 		bs = append(bs, b)
 	}
-	return bs, nil
-}
 
-func (n *bridgeNetwork) allocatePort(bnd *types.PortBinding, containerIP, defHostIP net.IP, ulPxyEnabled bool) error {
+	// The ports are mapped at once, on failure none of them is
 	var (
-		host net.Addr
-		err  error
+		hosts []net.Addr
+		err   error
 	)
+	for i := 0; i < maxAllocatePortAttempts; i++ {
+		if hosts, err = n.portMapper.MapBatch(ctx, reqs, ulPxyEnabled); err == nil {
+			break
+		}
+		// Another host port may do for a dynamically allocated one, there
+		// is no point in retrying on an explicitly chosen port, nor on a
+		// failure of no port
+		rerr, ok := err.(*portmapper.MapRequestError)
+		if !ok || bs[rerr.Index].HostPort != 0 || ctx.Err() != nil {
+			logrus.Warnf("Failed to allocate and map the ports: %s", err)
+			break
+		}
+		logrus.Warnf("Failed to allocate and map port: %s, retry: %d", err, i+1)
+	}
+	if rerr, ok := err.(*portmapper.MapRequestError); ok {
+		err = rerr.Err
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Save the host ports (regardless they were or not specified in the bindings)
+	for i, host := range hosts {
+		switch netAddr := host.(type) {
+		case *net.TCPAddr:
+			bs[i].HostPort = uint16(netAddr.Port)
+		case *net.UDPAddr:
+			bs[i].HostPort = uint16(netAddr.Port)
+		case *sctp.SCTPAddr:
+			bs[i].HostPort = uint16(netAddr.Port)
+		default:
+			// For completeness
+			n.portMapper.UnmapBatch(hosts)
+			return nil, ErrUnsupportedAddressType(fmt.Sprintf("%T", netAddr))
+		}
+	}
+	return bs, nil
+}
 
+// prepareBinding completes the operational binding and returns its
+// container side transport address
+func prepareBinding(bnd *types.PortBinding, containerIP, defHostIP net.IP) (net.Addr, error) {
 	// Store the container interface address in the operational binding
 	bnd.IP = containerIP
 
@@ -64,42 +124,7 @@ func (n *bridgeNetwork) allocatePort(bnd *types.PortBinding, containerIP, defHos
 	}
 
 	// Construct the container side transport address
-	container, err := bnd.ContainerAddr()
-	if err != nil {
-		return err
-	}
-
-	// Try up to maxAllocatePortAttempts times to get a port that's not already allocated.
-	for i := 0; i < maxAllocatePortAttempts; i++ {
-		if host, err = n.portMapper.MapRange(container, bnd.HostIP, int(bnd.HostPort), int(bnd.HostPortEnd), ulPxyEnabled); err == nil {
-			break
-		}
-		// There is no point in immediately retrying to map an explicitly chosen port.
-		if bnd.HostPort != 0 {
-			logrus.Warnf("Failed to allocate and map port %d-%d: %s", bnd.HostPort, bnd.HostPortEnd, err)
-			break
-		}
-		logrus.Warnf("Failed to allocate and map port: %s, retry: %d", err, i+1)
-	}
-	if err != nil {
-		return err
-	}
-
-	// Save the host port (regardless it was or not specified in the binding)
-	switch netAddr := host.(type) {
-	case *net.TCPAddr:
-		bnd.HostPort = uint16(host.(*net.TCPAddr).Port)
-		return nil
-	case *net.UDPAddr:
-		bnd.HostPort = uint16(host.(*net.UDPAddr).Port)
-		return nil
-	case *sctp.SCTPAddr:
-		bnd.HostPort = uint16(host.(*sctp.SCTPAddr).Port)
-		return nil
-	default:
-		// For completeness
-		return ErrUnsupportedAddressType(fmt.Sprintf("%T", netAddr))
-	}
+	return bnd.ContainerAddr()
 }
 
 func (n *bridgeNetwork) releasePorts(ep *bridgeEndpoint) error {
@@ -110,10 +135,18 @@ func (n *bridgeNetwork) releasePortsInternal(bindings []types.PortBinding) error
 	var errorBuf bytes.Buffer
 
 	// Attempt to release all port bindings, do not stop on failure
+	hosts := make([]net.Addr, 0, len(bindings))
 	for _, m := range bindings {
-		if err := n.releasePort(m); err != nil {
+		// Construct the host side transport address
+		host, err := m.HostAddr()
+		if err != nil {
 			errorBuf.WriteString(fmt.Sprintf("\ncould not release %v because of %v", m, err))
+			continue
 		}
+		hosts = append(hosts, host)
+	}
+	if err := n.portMapper.UnmapBatch(hosts); err != nil {
+		errorBuf.WriteString(fmt.Sprintf("\ncould not release all of %v because of %v", bindings, err))
 	}
 
 	if errorBuf.Len() != 0 {
@@ -121,12 +154,3 @@ func (n *bridgeNetwork) releasePortsInternal(bindings []types.PortBinding) error
 	}
 	return nil
 }
-
-func (n *bridgeNetwork) releasePort(bnd types.PortBinding) error {
-	// Construct the host side transport address
-	host, err := bnd.HostAddr()
-	if err != nil {
-		return err
-	}
-	return n.portMapper.Unmap(host)
-}
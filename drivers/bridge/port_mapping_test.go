@@ -1,11 +1,16 @@
 package bridge
 
 import (
+	"context"
+	"fmt"
+	"net"
 	"os"
 	"testing"
 
 	"github.com/docker/docker/pkg/reexec"
 	"github.com/docker/libnetwork/netlabel"
+	"github.com/docker/libnetwork/portallocator"
+	"github.com/docker/libnetwork/portmapper"
 	"github.com/docker/libnetwork/testutils"
 	"github.com/docker/libnetwork/types"
 )
@@ -95,3 +100,105 @@ func TestPortMappingConfig(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestPortMappingCancelled(t *testing.T) {
+	defer testutils.SetupTestOSContext(t)()
+	d := newDriver()
+
+	genericOption := make(map[string]interface{})
+	genericOption[netlabel.GenericData] = &configuration{}
+	if err := d.configure(genericOption); err != nil {
+		t.Fatalf("Failed to setup driver config: %v", err)
+	}
+
+	sbOptions := make(map[string]interface{})
+	sbOptions[netlabel.PortMap] = []types.PortBinding{{Proto: types.TCP, Port: uint16(500), HostPort: uint16(65000)}}
+
+	netOptions := make(map[string]interface{})
+	netOptions[netlabel.GenericData] = &networkConfiguration{BridgeName: DefaultBridgeName}
+	ipdList := getIPv4Data(t, "")
+	if err := d.CreateNetwork("dummy", netOptions, nil, ipdList, nil); err != nil {
+		t.Fatalf("Failed to create bridge: %v", err)
+	}
+
+	te := newTestEndpoint(ipdList[0].Pool, 11)
+	if err := d.CreateEndpoint("dummy", "ep1", te.Interface(), nil); err != nil {
+		t.Fatalf("Failed to create the endpoint: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := d.JoinContext(ctx, "dummy", "ep1", "sbox", te, sbOptions); err != context.Canceled {
+		t.Fatalf("Expected the join to be cancelled, got: %v", err)
+	}
+	if err := d.Join("dummy", "ep1", "sbox", te, sbOptions); err != nil {
+		t.Fatalf("Failed to join the endpoint: %v", err)
+	}
+
+	if err := d.ProgramExternalConnectivityContext(ctx, "dummy", "ep1", sbOptions); err != context.Canceled {
+		t.Fatalf("Expected the external connectivity programming to be cancelled, got: %v", err)
+	}
+	if ep := d.networks["dummy"].endpoints["ep1"]; ep.portMapping != nil {
+		t.Fatalf("Port bindings left on the endpoint after cancellation: %v", ep.portMapping)
+	}
+
+	// The port must be available again
+	if err := d.ProgramExternalConnectivity("dummy", "ep1", sbOptions); err != nil {
+		t.Fatalf("Failed to program external connectivity: %v", err)
+	}
+	if err := d.RevokeExternalConnectivity("dummy", "ep1"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAllocatePortsRetry(t *testing.T) {
+	n := &bridgeNetwork{portMapper: portmapper.New("")}
+	hostIP := net.ParseIP("127.0.0.1")
+	containerIP := net.ParseIP("172.17.0.2")
+	// nextPort returns the dynamic host port the allocator gives next
+	nextPort := func() int {
+		port, err := n.portMapper.Allocator.RequestPort(hostIP, "tcp", 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		n.portMapper.Allocator.ReleasePort(hostIP, "tcp", port)
+		return port
+	}
+
+	// A dynamic host port taken out of the allocator is retried with the
+	// next one
+	taken := nextPort() + 1
+	l, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", taken))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	bs, err := n.allocatePortsInternal(context.Background(), []types.PortBinding{{Proto: types.TCP, Port: 80}}, containerIP, hostIP, false, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if int(bs[0].HostPort) != taken+1 {
+		t.Fatalf("expected host port %d, got %d", taken+1, bs[0].HostPort)
+	}
+	if err := n.releasePortsInternal(bs); err != nil {
+		t.Fatal(err)
+	}
+
+	// A conflict on an explicit host port fails at once
+	explicit, err := n.portMapper.Allocator.RequestPort(hostIP, "tcp", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n.portMapper.Allocator.ReleasePort(hostIP, "tcp", explicit)
+	first := nextPort()
+	bindings := []types.PortBinding{{Proto: types.TCP, Port: 80}, {Proto: types.TCP, Port: 81, HostPort: uint16(explicit)}}
+	if _, err := n.allocatePortsInternal(context.Background(), bindings, containerIP, hostIP, false, nil, nil); err == nil {
+		t.Fatal("explicit host port allocated twice")
+	} else if _, ok := err.(portallocator.ErrPortAlreadyAllocated); !ok {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if attempts := nextPort() - first - 1; attempts != 1 {
+		t.Fatalf("the conflicting bindings got %d attempts", attempts)
+	}
+}
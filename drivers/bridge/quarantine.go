@@ -0,0 +1,204 @@
+package bridge
+
+import (
+	"fmt"
+
+	"github.com/docker/libnetwork/driverapi"
+	"github.com/docker/libnetwork/iptables"
+	"github.com/docker/libnetwork/types"
+	"github.com/sirupsen/logrus"
+)
+
+// QuarantineChain is the filter chain, jumped to from INPUT and FORWARD
+// ahead of the other chains, cutting the traffic of the quarantined
+// endpoints off
+const QuarantineChain = "DOCKER-QUARANTINE"
+
+// quarantineTarget returns the target of the rules of the action
+func quarantineTarget(action string, ipv6 bool) []string {
+	if action != driverapi.QuarantineReject {
+		return []string{"-j", "DROP"}
+	}
+	if ipv6 {
+		return []string{"-j", "REJECT", "--reject-with", "icmp6-adm-prohibited"}
+	}
+	return []string{"-j", "REJECT", "--reject-with", "icmp-admin-prohibited"}
+}
+
+// quarantineRules renders the rules cutting the IPv4 and IPv6 traffic of
+// the endpoint off, tagged with it. The traffic entering from its veth is
+// matched as well, whatever its source address. The IPv6 rules go
+// straight to INPUT and FORWARD.
+func quarantineRules(bridgeName string, ep *bridgeEndpoint, action string) (rules [][]string, rules6 []ip6Rule) {
+	rule := func(ipv6 bool, args ...string) []string {
+		return iptables.TagRule(networkType, ep.id, append(append([]string{}, args...), quarantineTarget(action, ipv6)...))
+	}
+	var fromVeth []string
+	if ep.hostIfName != "" {
+		fromVeth = []string{"-m", "physdev", "--physdev-in", ep.hostIfName}
+	}
+
+	if ep.addr != nil {
+		ip := ep.addr.IP.String()
+		rules = [][]string{
+			rule(false, "-i", bridgeName, "-s", ip),
+			rule(false, "-o", bridgeName, "-d", ip),
+		}
+		if fromVeth != nil {
+			rules = append(rules, rule(false, fromVeth...))
+		}
+	}
+	if ep.addrv6 != nil {
+		ip := ep.addrv6.IP.String()
+		from := rule(true, "-i", bridgeName, "-s", ip)
+		rules6 = []ip6Rule{
+			{table: iptables.Filter, chain: "INPUT", args: from},
+			{table: iptables.Filter, chain: "FORWARD", args: from},
+			{table: iptables.Filter, chain: "FORWARD", args: rule(true, "-o", bridgeName, "-d", ip)},
+		}
+		if fromVeth != nil {
+			veth := rule(true, fromVeth...)
+			rules6 = append(rules6,
+				ip6Rule{table: iptables.Filter, chain: "INPUT", args: veth},
+				ip6Rule{table: iptables.Filter, chain: "FORWARD", args: veth})
+		}
+	}
+	return rules, rules6
+}
+
+// programQuarantine adds or removes the quarantine rules of the action
+func (n *bridgeNetwork) programQuarantine(ep *bridgeEndpoint, action string, enable bool) error {
+	n.Lock()
+	bridgeName := n.config.BridgeName
+	n.Unlock()
+
+	op := iptables.Delete
+	if enable {
+		op = iptables.Insert
+	}
+	rules, rules6 := quarantineRules(bridgeName, ep, action)
+	for _, rule := range rules {
+		if enable == iptables.Exists(iptables.Filter, QuarantineChain, rule...) {
+			continue
+		}
+		if err := iptables.ProgramRule(iptables.Filter, QuarantineChain, op, rule); err != nil {
+			if !enable {
+				logrus.Warnf("Failed to remove the quarantine rule %v of endpoint %.7s: %v", rule, ep.id, err)
+				continue
+			}
+			return fmt.Errorf("failed to quarantine endpoint %.7s: %v", ep.id, err)
+		}
+	}
+	for _, rule := range rules6 {
+		if err := programIPv6Rule(rule, enable); err != nil {
+			if !enable {
+				logrus.Warnf("Failed to remove the quarantine rule %v of endpoint %.7s: %v", rule.args, ep.id, err)
+				continue
+			}
+			return fmt.Errorf("failed to quarantine the IPv6 traffic of endpoint %.7s: %v", ep.id, err)
+		}
+	}
+	return nil
+}
+
+// QuarantineEndpoint cuts the traffic of the endpoint off with the action,
+// or lets it through again when the action is empty. The rules of a new
+// action are in place before the ones of the previous action go away, so
+// that no packet slips through the change.
+func (d *driver) QuarantineEndpoint(nid, eid, action string) (err error) {
+	switch action {
+	case "", driverapi.QuarantineDrop, driverapi.QuarantineReject:
+	default:
+		return types.BadRequestErrorf("invalid quarantine action %q: expected %s or %s", action, driverapi.QuarantineDrop, driverapi.QuarantineReject)
+	}
+	if !d.config.EnableIPTables {
+		return types.NotImplementedErrorf("the quarantine of the endpoints requires iptables to be enabled")
+	}
+	n, err := d.getNetwork(nid)
+	if err != nil {
+		return err
+	}
+	ep, err := n.getEndpoint(eid)
+	if err != nil {
+		return err
+	}
+	if ep == nil {
+		return EndpointNotFoundError(eid)
+	}
+
+	n.Lock()
+	prev := ep.quarantine
+	n.Unlock()
+	if prev == action {
+		return nil
+	}
+
+	if action != "" {
+		if err := n.programQuarantine(ep, action, true); err != nil {
+			n.programQuarantine(ep, action, false)
+			return err
+		}
+	}
+	if prev != "" {
+		if err := n.programQuarantine(ep, prev, false); err != nil {
+			return err
+		}
+	}
+
+	n.Lock()
+	ep.quarantine = action
+	n.Unlock()
+	if err := d.storeUpdate(ep); err != nil {
+		logrus.Warnf("Failed to update bridge endpoint %.7s to store: %v", ep.id, err)
+	}
+	return nil
+}
+
+// quarantineOf returns the quarantine action of the endpoint, if any
+func (n *bridgeNetwork) quarantineOf(ep *bridgeEndpoint) string {
+	n.Lock()
+	defer n.Unlock()
+	return ep.quarantine
+}
+
+// setupQuarantineChain creates the quarantine chain and its jump from
+// INPUT, the one from FORWARD is ensured along with the other chains
+func setupQuarantineChain() error {
+	if _, err := iptables.NewChain(QuarantineChain, iptables.Filter, false); err != nil {
+		return fmt.Errorf("failed to create FILTER quarantine chain: %v", err)
+	}
+	jump := []string{"-j", QuarantineChain}
+	if iptables.Exists(iptables.Filter, "INPUT", jump...) {
+		return nil
+	}
+	if err := iptables.ProgramRule(iptables.Filter, "INPUT", iptables.Insert, jump); err != nil {
+		return fmt.Errorf("failed to add the jump to the quarantine chain: %v", err)
+	}
+	return nil
+}
+
+// restoreQuarantines programs back the quarantine and throttle rules of
+// the quarantined and throttled endpoints, after the chain got flushed
+func (d *driver) restoreQuarantines() {
+	for _, n := range d.getNetworks() {
+		n.Lock()
+		eps := make([]*bridgeEndpoint, 0, len(n.endpoints))
+		for _, ep := range n.endpoints {
+			eps = append(eps, ep)
+		}
+		n.Unlock()
+
+		for _, ep := range eps {
+			if action := n.quarantineOf(ep); action != "" {
+				if err := n.programQuarantine(ep, action, true); err != nil {
+					logrus.Warn(err)
+				}
+			}
+			if rate := n.throttleOf(ep); rate != 0 {
+				if err := n.programThrottle(ep, rate, true); err != nil {
+					logrus.Warn(err)
+				}
+			}
+		}
+	}
+}
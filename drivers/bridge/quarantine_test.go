@@ -0,0 +1,62 @@
+package bridge
+
+import (
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/docker/libnetwork/driverapi"
+	"github.com/docker/libnetwork/types"
+)
+
+func TestQuarantineRules(t *testing.T) {
+	ep := &bridgeEndpoint{
+		id:         "ep1",
+		hostIfName: "veth1234",
+		addr:       &net.IPNet{IP: net.ParseIP("172.18.0.2"), Mask: net.CIDRMask(16, 32)},
+		addrv6:     &net.IPNet{IP: net.ParseIP("fd00::2"), Mask: net.CIDRMask(64, 128)},
+	}
+	rules, rules6 := quarantineRules("br0", ep, driverapi.QuarantineDrop)
+	expected := [][]string{
+		{"-i", "br0", "-s", "172.18.0.2", "-m", "comment", "--comment", "lnet:bridge:ep1", "-j", "DROP"},
+		{"-o", "br0", "-d", "172.18.0.2", "-m", "comment", "--comment", "lnet:bridge:ep1", "-j", "DROP"},
+		{"-m", "physdev", "--physdev-in", "veth1234", "-m", "comment", "--comment", "lnet:bridge:ep1", "-j", "DROP"},
+	}
+	if !reflect.DeepEqual(rules, expected) {
+		t.Fatalf("unexpected rules:\n%v\nexpected:\n%v", rules, expected)
+	}
+	if len(rules6) != 5 || rules6[0].chain != "INPUT" || rules6[2].args[3] != "fd00::2" {
+		t.Fatalf("unexpected IPv6 rules %v", rules6)
+	}
+
+	rules, rules6 = quarantineRules("br0", ep, driverapi.QuarantineReject)
+	if r := rules[0]; r[len(r)-1] != "icmp-admin-prohibited" {
+		t.Fatalf("unexpected reject rule %v", r)
+	}
+	if r := rules6[0].args; r[len(r)-1] != "icmp6-adm-prohibited" {
+		t.Fatalf("unexpected IPv6 reject rule %v", r)
+	}
+}
+
+func TestQuarantineEndpoint(t *testing.T) {
+	d := newDriver()
+	d.config = &configuration{}
+	ep := &bridgeEndpoint{id: "ep1", addr: &net.IPNet{IP: net.ParseIP("172.18.0.2"), Mask: net.CIDRMask(16, 32)}}
+	n := &bridgeNetwork{id: "net1", config: &networkConfiguration{BridgeName: "br0"},
+		endpoints: map[string]*bridgeEndpoint{ep.id: ep}, driver: d}
+	d.networks[n.id] = n
+
+	if err := d.QuarantineEndpoint("net1", "ep1", "ACCEPT"); err == nil {
+		t.Fatal("invalid quarantine action accepted")
+	} else if _, ok := err.(types.BadRequestError); !ok {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if err := d.QuarantineEndpoint("net1", "ep1", driverapi.QuarantineDrop); err == nil {
+		t.Fatal("quarantine accepted without iptables")
+	} else if _, ok := err.(types.NotImplementedError); !ok {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if n.quarantineOf(ep) != "" {
+		t.Fatal("the endpoint is quarantined after a failure")
+	}
+}
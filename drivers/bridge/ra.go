@@ -0,0 +1,322 @@
+package bridge
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/docker/libnetwork/types"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// raInterval is the interval between the unsolicited router
+	// advertisements, within the RFC 4861 default range
+	raInterval = 200 * time.Second
+	// raInitialInterval is the interval between the first advertisements,
+	// for the containers started along the network to get them quickly
+	raInitialInterval = 16 * time.Second
+	raInitialCount    = 3
+	// raMinDelay is the time between two advertisements at least, the
+	// router solicitations received meanwhile being answered by the next
+	raMinDelay = 3 * time.Second
+	// The advertised prefix lifetimes, the radvd defaults
+	raPrefixValidLifetime     = 24 * time.Hour
+	raPrefixPreferredLifetime = 4 * time.Hour
+	// defaultRARouterLifetime is the lifetime of the default route of the
+	// containers through the bridge
+	defaultRARouterLifetime = 30 * time.Minute
+
+	icmpv6RouterSolicitation  = 133
+	icmpv6RouterAdvertisement = 134
+)
+
+// raConfig is the content of the router advertisements of a network
+type raConfig struct {
+	mac            net.HardwareAddr
+	mtu            int
+	prefix         *net.IPNet
+	rdnss          []net.IP
+	routerLifetime time.Duration
+}
+
+// raSender sends the router advertisements of a network on its bridge
+type raSender struct {
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// parseRDNSS parses the comma separated IPv6 addresses of the recursive DNS
+// servers advertised to the containers
+func parseRDNSS(value string) ([]net.IP, error) {
+	var ips []net.IP
+	for _, s := range strings.Split(value, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		ip := net.ParseIP(s)
+		if ip == nil || ip.To4() != nil {
+			return nil, types.BadRequestErrorf("%s is not an IPv6 address", s)
+		}
+		ips = append(ips, ip)
+	}
+	return ips, nil
+}
+
+func validateIPv6RA(config *networkConfiguration) error {
+	if !config.IPv6RA {
+		if len(config.IPv6RARDNSS) > 0 {
+			return types.BadRequestErrorf("the advertised DNS servers require the router advertisements to be enabled")
+		}
+		return nil
+	}
+	if !config.EnableIPv6 {
+		return types.BadRequestErrorf("the router advertisements require IPv6 to be enabled")
+	}
+	if config.IPv6RALifetime != nil && (*config.IPv6RALifetime < 0 || *config.IPv6RALifetime > 9000*time.Second) {
+		return types.BadRequestErrorf("invalid router lifetime %s: expected up to 9000s", *config.IPv6RALifetime)
+	}
+	return nil
+}
+
+// routerAdvertisement returns the ICMPv6 router advertisement message, with
+// the source link-layer address, MTU, prefix information and RDNSS options
+func routerAdvertisement(cfg *raConfig) []byte {
+	b := make([]byte, 16, 128)
+	b[0] = icmpv6RouterAdvertisement
+	// current hop limit, no managed nor other configuration flag
+	b[4] = 64
+	binary.BigEndian.PutUint16(b[6:8], uint16(cfg.routerLifetime/time.Second))
+
+	if len(cfg.mac) == 6 {
+		b = append(b, 1, 1)
+		b = append(b, cfg.mac...)
+	}
+
+	if cfg.mtu > 0 {
+		opt := make([]byte, 8)
+		opt[0], opt[1] = 5, 1
+		binary.BigEndian.PutUint32(opt[4:8], uint32(cfg.mtu))
+		b = append(b, opt...)
+	}
+
+	opt := make([]byte, 32)
+	opt[0], opt[1] = 3, 4
+	ones, _ := cfg.prefix.Mask.Size()
+	opt[2] = byte(ones)
+	// on-link and autonomous address configuration flags
+	opt[3] = 0xc0
+	binary.BigEndian.PutUint32(opt[4:8], uint32(raPrefixValidLifetime/time.Second))
+	binary.BigEndian.PutUint32(opt[8:12], uint32(raPrefixPreferredLifetime/time.Second))
+	copy(opt[16:32], cfg.prefix.IP.Mask(cfg.prefix.Mask).To16())
+	b = append(b, opt...)
+
+	if len(cfg.rdnss) > 0 {
+		opt := make([]byte, 8, 8+16*len(cfg.rdnss))
+		opt[0], opt[1] = 25, byte(1+2*len(cfg.rdnss))
+		binary.BigEndian.PutUint32(opt[4:8], uint32(3*raInterval/time.Second))
+		for _, ip := range cfg.rdnss {
+			opt = append(opt, ip.To16()...)
+		}
+		b = append(b, opt...)
+	}
+	return b
+}
+
+// setupIPv6RA starts sending the router advertisements of the IPv6 subnet
+// of the network on its bridge, for the containers to configure their
+// addresses and default route with SLAAC
+func (n *bridgeNetwork) setupIPv6RA(config *networkConfiguration, i *bridgeInterface) error {
+	if config.AddressIPv6 == nil {
+		return types.BadRequestErrorf("the router advertisements require an IPv6 subnet")
+	}
+	if ones, _ := config.AddressIPv6.Mask.Size(); ones != 64 {
+		return types.BadRequestErrorf("invalid IPv6 subnet %s for the router advertisements: SLAAC requires a /64", config.AddressIPv6)
+	}
+
+	// the link of a bridge just created does not carry the address set
+	link, err := i.nlh.LinkByName(config.BridgeName)
+	if err != nil {
+		return fmt.Errorf("failed to get the bridge %s: %v", config.BridgeName, err)
+	}
+	cfg := &raConfig{
+		mac:            link.Attrs().HardwareAddr,
+		mtu:            config.Mtu,
+		prefix:         config.AddressIPv6,
+		rdnss:          config.IPv6RARDNSS,
+		routerLifetime: defaultRARouterLifetime,
+	}
+	if config.IPv6RALifetime != nil {
+		cfg.routerLifetime = *config.IPv6RALifetime
+	}
+
+	s := &raSender{stop: make(chan struct{})}
+	s.wg.Add(1)
+	go s.run(config.BridgeName, cfg)
+
+	n.Lock()
+	n.ra = s
+	n.Unlock()
+	return nil
+}
+
+// stopIPv6RA stops the router advertisements of the network, if any
+func (n *bridgeNetwork) stopIPv6RA() {
+	n.Lock()
+	s := n.ra
+	n.ra = nil
+	n.Unlock()
+	if s != nil {
+		close(s.stop)
+		s.wg.Wait()
+	}
+}
+
+// run sends the advertisements periodically and on the solicitations, the
+// socket being opened again after the failures, as when the bridge is
+// recreated
+func (s *raSender) run(bridgeName string, cfg *raConfig) {
+	defer s.wg.Done()
+
+	msg := routerAdvertisement(cfg)
+	solicited := make(chan struct{}, 1)
+	fd := -1
+	var quit chan struct{}
+	closeSocket := func() {
+		// the reader closes the socket once it sees quit
+		if fd >= 0 {
+			close(quit)
+			fd = -1
+		}
+	}
+	defer closeSocket()
+
+	sent := 0
+	var last time.Time
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-timer.C:
+		case <-solicited:
+			if wait := raMinDelay - time.Since(last); wait > 0 {
+				timer.Reset(wait)
+				continue
+			}
+		}
+
+		if fd < 0 {
+			var err error
+			if fd, err = raSocket(bridgeName); err != nil {
+				logrus.Debugf("Failed to open the router advertisement socket of bridge %s: %v", bridgeName, err)
+				fd = -1
+			} else {
+				quit = make(chan struct{})
+				s.wg.Add(1)
+				go s.readSolicitations(fd, solicited, quit)
+			}
+		}
+		if fd >= 0 {
+			if err := sendRA(fd, bridgeName, msg); err != nil {
+				logrus.Debugf("Failed to send a router advertisement on bridge %s: %v", bridgeName, err)
+				closeSocket()
+			}
+		}
+		last = time.Now()
+
+		sent++
+		if sent < raInitialCount {
+			timer.Reset(raInitialInterval)
+		} else {
+			timer.Reset(raInterval)
+		}
+	}
+}
+
+// raSocket opens an ICMPv6 socket on the bridge, joined to the all-routers
+// group to receive the router solicitations
+func raSocket(bridgeName string) (int, error) {
+	iface, err := net.InterfaceByName(bridgeName)
+	if err != nil {
+		return -1, err
+	}
+	fd, err := syscall.Socket(syscall.AF_INET6, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, syscall.IPPROTO_ICMPV6)
+	if err != nil {
+		return -1, err
+	}
+	for _, o := range []struct{ level, opt, value int }{
+		{syscall.IPPROTO_IPV6, syscall.IPV6_MULTICAST_HOPS, 255},
+		{syscall.IPPROTO_IPV6, syscall.IPV6_UNICAST_HOPS, 255},
+		{syscall.IPPROTO_IPV6, syscall.IPV6_MULTICAST_LOOP, 0},
+		{syscall.IPPROTO_IPV6, syscall.IPV6_MULTICAST_IF, iface.Index},
+	} {
+		if err := syscall.SetsockoptInt(fd, o.level, o.opt, o.value); err != nil {
+			syscall.Close(fd)
+			return -1, err
+		}
+	}
+	if err := syscall.SetsockoptString(fd, syscall.SOL_SOCKET, syscall.SO_BINDTODEVICE, bridgeName); err != nil {
+		syscall.Close(fd)
+		return -1, err
+	}
+	// the reads time out for the reader to see when to close the socket
+	tv := syscall.NsecToTimeval(int64(time.Second))
+	if err := syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &tv); err != nil {
+		syscall.Close(fd)
+		return -1, err
+	}
+	mreq := &syscall.IPv6Mreq{Interface: uint32(iface.Index)}
+	copy(mreq.Multiaddr[:], net.IPv6linklocalallrouters)
+	if err := syscall.SetsockoptIPv6Mreq(fd, syscall.IPPROTO_IPV6, syscall.IPV6_JOIN_GROUP, mreq); err != nil {
+		syscall.Close(fd)
+		return -1, err
+	}
+	return fd, nil
+}
+
+func sendRA(fd int, bridgeName string, msg []byte) error {
+	iface, err := net.InterfaceByName(bridgeName)
+	if err != nil {
+		return err
+	}
+	to := &syscall.SockaddrInet6{ZoneId: uint32(iface.Index)}
+	copy(to.Addr[:], net.IPv6linklocalallnodes)
+	return syscall.Sendto(fd, msg, 0, to)
+}
+
+// readSolicitations notifies the router solicitations received on the
+// socket, and closes it on quit
+func (s *raSender) readSolicitations(fd int, solicited, quit chan struct{}) {
+	defer s.wg.Done()
+	defer syscall.Close(fd)
+
+	buf := make([]byte, 1500)
+	for {
+		select {
+		case <-quit:
+			return
+		default:
+		}
+		n, _, err := syscall.Recvfrom(fd, buf, 0)
+		if err != nil {
+			if err == syscall.EINTR || err == syscall.EAGAIN {
+				continue
+			}
+			<-quit
+			return
+		}
+		if n > 0 && buf[0] == icmpv6RouterSolicitation {
+			select {
+			case solicited <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
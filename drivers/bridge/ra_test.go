@@ -0,0 +1,84 @@
+package bridge
+
+import (
+	"bytes"
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestRouterAdvertisement(t *testing.T) {
+	mac, _ := net.ParseMAC("02:42:ac:11:00:01")
+	_, prefix, _ := net.ParseCIDR("2001:db8:1::/64")
+	dns := net.ParseIP("2001:db8::53")
+
+	b := routerAdvertisement(&raConfig{mac: mac, mtu: 1450, prefix: prefix, rdnss: []net.IP{dns}, routerLifetime: 30 * time.Minute})
+	if len(b) != 16+8+8+32+24 {
+		t.Fatalf("unexpected length %d", len(b))
+	}
+	if b[0] != icmpv6RouterAdvertisement || b[4] != 64 || b[6] != 0x07 || b[7] != 0x08 {
+		t.Fatalf("unexpected header % x", b[:16])
+	}
+	if b[16] != 1 || !bytes.Equal(b[18:24], mac) {
+		t.Fatalf("unexpected source link-layer address option % x", b[16:24])
+	}
+	if b[24] != 5 || b[30] != 0x05 || b[31] != 0xaa {
+		t.Fatalf("unexpected MTU option % x", b[24:32])
+	}
+	pi := b[32:64]
+	if pi[0] != 3 || pi[1] != 4 || pi[2] != 64 || pi[3] != 0xc0 || !net.IP(pi[16:32]).Equal(prefix.IP) {
+		t.Fatalf("unexpected prefix information option % x", pi)
+	}
+	rdnss := b[64:]
+	if rdnss[0] != 25 || rdnss[1] != 3 || !net.IP(rdnss[8:24]).Equal(dns) {
+		t.Fatalf("unexpected RDNSS option % x", rdnss)
+	}
+
+	b = routerAdvertisement(&raConfig{prefix: prefix})
+	if len(b) != 16+32 || b[6] != 0 || b[7] != 0 {
+		t.Fatalf("unexpected advertisement with no default route % x", b)
+	}
+}
+
+func TestIPv6RAOptions(t *testing.T) {
+	config, err := parseNetworkGenericOptions(map[string]string{
+		IPv6RA:               "true",
+		IPv6RARDNSS:          "2001:db8::53, 2001:db8::54",
+		IPv6RARouterLifetime: "0s",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !config.IPv6RA || len(config.IPv6RARDNSS) != 2 || config.IPv6RALifetime == nil || *config.IPv6RALifetime != 0 {
+		t.Fatalf("unexpected configuration %+v", config)
+	}
+	if _, err := parseNetworkGenericOptions(map[string]string{IPv6RARDNSS: "10.0.0.53"}); err == nil {
+		t.Fatal("IPv4 DNS server accepted")
+	}
+
+	if err := validateIPv6RA(config); err == nil {
+		t.Fatal("router advertisements accepted with IPv6 disabled")
+	}
+	config.EnableIPv6 = true
+	if err := validateIPv6RA(config); err != nil {
+		t.Fatal(err)
+	}
+	if err := validateIPv6RA(&networkConfiguration{IPv6RARDNSS: config.IPv6RARDNSS}); err == nil {
+		t.Fatal("DNS servers accepted with the router advertisements disabled")
+	}
+
+	config.ID = "net1"
+	b, err := json.Marshal(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored := &networkConfiguration{}
+	if err := json.Unmarshal(b, restored); err != nil {
+		t.Fatal(err)
+	}
+	if !restored.IPv6RA || len(restored.IPv6RARDNSS) != 2 || !restored.IPv6RARDNSS[1].Equal(config.IPv6RARDNSS[1]) ||
+		restored.IPv6RALifetime == nil || *restored.IPv6RALifetime != 0 {
+		t.Fatalf("unexpected restored configuration %+v", restored)
+	}
+}
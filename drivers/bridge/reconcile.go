@@ -0,0 +1,116 @@
+package bridge
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/docker/libnetwork/driverapi"
+	"github.com/docker/libnetwork/iptables"
+	"github.com/vishvananda/netlink"
+)
+
+// Reconcile checks the bridge devices, their addresses and the NAT and
+// forwarding rules of the networks against the driver state
+func (d *driver) Reconcile(repair bool) ([]driverapi.Drift, error) {
+	d.Lock()
+	nlh := d.nlh
+	enableIPTables := d.config.EnableIPTables
+	d.Unlock()
+
+	// No network was created yet
+	if nlh == nil {
+		return nil, nil
+	}
+
+	var drifts []driverapi.Drift
+	for _, n := range d.getNetworks() {
+		drifts = append(drifts, n.reconcile(nlh, enableIPTables, repair)...)
+	}
+	return drifts, nil
+}
+
+func (n *bridgeNetwork) reconcile(nlh *netlink.Handle, enableIPTables, repair bool) []driverapi.Drift {
+	n.Lock()
+	config := n.config
+	var bridgeIPv4 *net.IPNet
+	if n.bridge != nil {
+		bridgeIPv4 = n.bridge.bridgeIPv4
+	}
+	n.Unlock()
+
+	link, err := nlh.LinkByName(config.BridgeName)
+	if err != nil {
+		// Recreating the bridge would not reattach the endpoints to it
+		return []driverapi.Drift{{
+			NetworkID: n.id,
+			Resource:  "link",
+			Detail:    fmt.Sprintf("bridge %s not found", config.BridgeName),
+		}}
+	}
+
+	var drifts []driverapi.Drift
+	if link.Attrs().Flags&net.FlagUp == 0 {
+		dr := driverapi.Drift{
+			NetworkID: n.id,
+			Resource:  "link",
+			Detail:    fmt.Sprintf("bridge %s is down", config.BridgeName),
+		}
+		if repair {
+			dr.SetRepairResult(nlh.LinkSetUp(link))
+		}
+		drifts = append(drifts, dr)
+	}
+
+	if bridgeIPv4 != nil {
+		addrs, err := nlh.AddrList(link, netlink.FAMILY_V4)
+		if err == nil && !findIPv4Address(addrs, bridgeIPv4) {
+			dr := driverapi.Drift{
+				NetworkID: n.id,
+				Resource:  "address",
+				Detail:    fmt.Sprintf("address %s missing on bridge %s", bridgeIPv4, config.BridgeName),
+			}
+			if repair {
+				dr.SetRepairResult(nlh.AddrAdd(link, &netlink.Addr{IPNet: bridgeIPv4}))
+			}
+			drifts = append(drifts, dr)
+		}
+	}
+
+	if !enableIPTables || config.Internal || bridgeIPv4 == nil {
+		return drifts
+	}
+
+	maskedAddrv4 := &net.IPNet{
+		IP:   bridgeIPv4.IP.Mask(bridgeIPv4.Mask),
+		Mask: bridgeIPv4.Mask,
+	}
+	rules := map[string]iptRule{"ACCEPT NON_ICC OUTGOING": outgoingRule(config.BridgeName)}
+	if config.EnableIPMasquerade {
+		rules["NAT"] = masqueradeRule(config.BridgeName, maskedAddrv4)
+	}
+	for descr, rule := range rules {
+		if iptables.Exists(rule.table, rule.chain, rule.args...) {
+			continue
+		}
+		dr := driverapi.Drift{
+			NetworkID: n.id,
+			Resource:  "iptables",
+			Detail:    fmt.Sprintf("%s rule missing in %s %s chain for bridge %s", descr, rule.table, rule.chain, config.BridgeName),
+		}
+		if repair {
+			dr.SetRepairResult(programChainRule(rule, descr, true))
+		}
+		drifts = append(drifts, dr)
+	}
+
+	return drifts
+}
+
+func findIPv4Address(addrs []netlink.Addr, addr *net.IPNet) bool {
+	for _, a := range addrs {
+		if a.IPNet.IP.Equal(addr.IP) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,55 @@
+package bridge
+
+import (
+	"testing"
+
+	"github.com/docker/libnetwork/netlabel"
+	"github.com/docker/libnetwork/testutils"
+	"github.com/vishvananda/netlink"
+)
+
+func TestReconcile(t *testing.T) {
+	if !testutils.IsRunningInContainer() {
+		defer testutils.SetupTestOSContext(t)()
+	}
+	d := newDriver()
+
+	netconfig := &networkConfiguration{BridgeName: DefaultBridgeName}
+	genericOption := make(map[string]interface{})
+	genericOption[netlabel.GenericData] = netconfig
+
+	if err := d.CreateNetwork("dummy", genericOption, nil, getIPv4Data(t, ""), nil); err != nil {
+		t.Fatalf("Failed to create bridge: %v", err)
+	}
+
+	drifts, err := d.Reconcile(false)
+	if err != nil || len(drifts) != 0 {
+		t.Fatalf("Unexpected drifts %v: %v", drifts, err)
+	}
+
+	link, err := d.nlh.LinkByName(DefaultBridgeName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.nlh.LinkSetDown(link); err != nil {
+		t.Fatal(err)
+	}
+	n, _ := d.getNetwork("dummy")
+	if err := d.nlh.AddrDel(link, &netlink.Addr{IPNet: n.bridge.bridgeIPv4}); err != nil {
+		t.Fatal(err)
+	}
+
+	drifts, err = d.Reconcile(true)
+	if err != nil || len(drifts) != 2 {
+		t.Fatalf("Expected the link and address drifts, got %v: %v", drifts, err)
+	}
+	for _, dr := range drifts {
+		if !dr.Repaired {
+			t.Fatalf("Drift was not repaired: %v", dr)
+		}
+	}
+
+	if drifts, err = d.Reconcile(false); err != nil || len(drifts) != 0 {
+		t.Fatalf("Unexpected drifts after repair %v: %v", drifts, err)
+	}
+}
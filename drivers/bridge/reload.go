@@ -0,0 +1,59 @@
+package bridge
+
+import (
+	"reflect"
+
+	"github.com/docker/libnetwork/iptables"
+)
+
+// liveSettings are the driver settings which only matter to the endpoints
+// deleted, and the rules programmed, from then on, and can change without
+// a restart
+var liveSettings = map[string]bool{
+	"VethCleanupDelay": true,
+	"RuleAnnotations":  true,
+	"XlockTimeout":     true,
+}
+
+// ReloadConfig applies the live settings of the new driver configuration
+// and returns the names of the other changed settings, which are left as
+// they are until the restart
+func (d *driver) ReloadConfig(option map[string]interface{}) ([]string, error) {
+	config, err := parseDriverConfig(option)
+	if err != nil || config == nil {
+		return nil, err
+	}
+
+	d.Lock()
+	current := d.config
+	d.Unlock()
+	if current == nil {
+		current = &configuration{}
+	}
+
+	var restart []string
+	next := *current
+	cv, nv, rv := reflect.ValueOf(current).Elem(), reflect.ValueOf(config).Elem(), reflect.ValueOf(&next).Elem()
+	for i := 0; i < cv.NumField(); i++ {
+		name := cv.Type().Field(i).Name
+		if reflect.DeepEqual(cv.Field(i).Interface(), nv.Field(i).Interface()) {
+			continue
+		}
+		if !liveSettings[name] {
+			restart = append(restart, name)
+			continue
+		}
+		rv.Field(i).Set(nv.Field(i))
+	}
+
+	if next.EnableIPTables {
+		iptables.SetRuleAnnotations(next.RuleAnnotations)
+		iptables.SetXlockTimeout(next.XlockTimeout)
+	}
+
+	d.Lock()
+	d.config = &next
+	d.Unlock()
+
+	return restart, nil
+}
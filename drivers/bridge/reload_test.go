@@ -0,0 +1,31 @@
+package bridge
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/docker/libnetwork/netlabel"
+)
+
+func TestReloadConfig(t *testing.T) {
+	d := newDriver()
+	d.config = &configuration{EnableUserlandProxy: true, VethCleanupDelay: time.Second}
+
+	restart, err := d.ReloadConfig(map[string]interface{}{
+		netlabel.GenericData: &configuration{VethCleanupDelay: time.Minute},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(restart, []string{"EnableUserlandProxy"}) {
+		t.Fatalf("unexpected settings requiring a restart %v", restart)
+	}
+	if d.config.VethCleanupDelay != time.Minute || !d.config.EnableUserlandProxy {
+		t.Fatalf("unexpected configuration after the reload %+v", d.config)
+	}
+
+	if restart, err := d.ReloadConfig(nil); err != nil || restart != nil {
+		t.Fatalf("expected nothing to reload, got %v %v", restart, err)
+	}
+}
@@ -0,0 +1,110 @@
+package bridge
+
+import (
+	"net"
+	"syscall"
+
+	"github.com/docker/libnetwork/driverapi"
+	"github.com/docker/libnetwork/types"
+	"github.com/vishvananda/netlink"
+)
+
+// AddSubnet programs an additional IPv4 subnet on the bridge of the
+// network: its gateway address is assigned to the bridge and, when enabled,
+// its traffic leaving the host is masqueraded
+func (d *driver) AddSubnet(nid string, ipData driverapi.IPAMData, v6 bool) error {
+	if v6 {
+		return types.NotImplementedErrorf("bridge driver doesn't support additional IPv6 subnets")
+	}
+	if ipData.Gateway == nil {
+		return types.BadRequestErrorf("subnet %s requires a gateway", ipData.Pool)
+	}
+
+	n, err := d.getNetwork(nid)
+	if err != nil {
+		return err
+	}
+
+	n.Lock()
+	config := n.config
+	bridge := n.bridge
+	if config.Internal {
+		n.Unlock()
+		return types.ForbiddenErrorf("subnets cannot be added to internal bridge network %s", config.BridgeName)
+	}
+	if config.IPv6Only {
+		n.Unlock()
+		return types.ForbiddenErrorf("IPv4 subnets cannot be added to IPv6-only bridge network %s", config.BridgeName)
+	}
+	for _, a := range append([]*net.IPNet{config.AddressIPv4}, config.SecondaryAddressesIPv4...) {
+		if a != nil && (a.Contains(ipData.Gateway.IP) || ipData.Gateway.Contains(a.IP)) {
+			n.Unlock()
+			return types.ForbiddenErrorf("subnet %s overlaps with subnet %s of bridge %s", ipData.Pool, a, config.BridgeName)
+		}
+	}
+	n.Unlock()
+
+	gw := types.GetIPNetCopy(ipData.Gateway)
+	if err := n.setupSecondarySubnet(config, bridge, gw); err != nil {
+		return err
+	}
+
+	n.Lock()
+	config.SecondaryAddressesIPv4 = append(config.SecondaryAddressesIPv4, gw)
+	n.Unlock()
+
+	return d.storeUpdate(config)
+}
+
+// setupSecondarySubnets reprograms the additional subnets of a restored network
+func (n *bridgeNetwork) setupSecondarySubnets(config *networkConfiguration, i *bridgeInterface) error {
+	for _, gw := range config.SecondaryAddressesIPv4 {
+		if err := n.setupSecondarySubnet(config, i, gw); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (n *bridgeNetwork) setupSecondarySubnet(config *networkConfiguration, i *bridgeInterface, gw *net.IPNet) error {
+	if err := i.nlh.AddrAdd(i.Link, &netlink.Addr{IPNet: gw}); err != nil && err != syscall.EEXIST {
+		return &IPv4AddrAddError{IP: gw, Err: err}
+	}
+
+	n.driver.Lock()
+	enableIPTables := n.driver.config.EnableIPTables
+	n.driver.Unlock()
+	if !enableIPTables || !config.EnableIPMasquerade {
+		return nil
+	}
+
+	subnet := &net.IPNet{IP: gw.IP.Mask(gw.Mask), Mask: gw.Mask}
+	rule := masqueradeRule(config.BridgeName, subnet)
+	if err := programChainRule(rule, "NAT", true); err != nil {
+		return err
+	}
+	n.registerIptCleanFunc(func() error {
+		return programChainRule(rule, "NAT", false)
+	})
+	if err := n.setupSNATPool(config, subnet); err != nil {
+		return err
+	}
+	return n.setupNATExemptions(config, subnet)
+}
+
+// secondaryGateway returns the gateway of the additional subnet the address
+// belongs to, nil when it belongs to the primary one
+func (n *bridgeNetwork) secondaryGateway(ip net.IP) net.IP {
+	n.Lock()
+	defer n.Unlock()
+
+	if n.config.AddressIPv4 != nil && n.config.AddressIPv4.Contains(ip) {
+		return nil
+	}
+	for _, gw := range n.config.SecondaryAddressesIPv4 {
+		if gw.Contains(ip) {
+			return gw.IP
+		}
+	}
+	return nil
+}
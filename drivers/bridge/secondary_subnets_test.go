@@ -0,0 +1,62 @@
+package bridge
+
+import (
+	"testing"
+
+	"github.com/docker/libnetwork/driverapi"
+	"github.com/docker/libnetwork/netlabel"
+	"github.com/docker/libnetwork/testutils"
+	"github.com/docker/libnetwork/types"
+	"github.com/vishvananda/netlink"
+)
+
+func TestAddSubnet(t *testing.T) {
+	if !testutils.IsRunningInContainer() {
+		defer testutils.SetupTestOSContext(t)()
+	}
+	d := newDriver()
+
+	netconfig := &networkConfiguration{BridgeName: DefaultBridgeName}
+	genericOption := make(map[string]interface{})
+	genericOption[netlabel.GenericData] = netconfig
+
+	ipdList := getIPv4Data(t, "")
+	if err := d.CreateNetwork("dummy", genericOption, nil, ipdList, nil); err != nil {
+		t.Fatalf("Failed to create bridge: %v", err)
+	}
+
+	if err := d.AddSubnet("dummy", ipdList[0], false); err == nil {
+		t.Fatal("Expected failure adding the primary subnet again")
+	} else if _, ok := err.(types.ForbiddenError); !ok {
+		t.Fatalf("Unexpected error type adding the primary subnet again: %v", err)
+	}
+
+	pool, _ := types.ParseCIDR("10.199.0.0/24")
+	gw, _ := types.ParseCIDR("10.199.0.1/24")
+	if err := d.AddSubnet("dummy", driverapi.IPAMData{Pool: pool, Gateway: gw}, false); err != nil {
+		t.Fatalf("Failed to add subnet: %v", err)
+	}
+
+	link, err := d.nlh.LinkByName(DefaultBridgeName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addrs, err := d.nlh.AddrList(link, netlink.FAMILY_V4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !findIPv4Address(addrs, gw) {
+		t.Fatalf("Gateway %s of the added subnet not found on the bridge", gw)
+	}
+
+	te := newTestEndpoint(pool, 10)
+	if err := d.CreateEndpoint("dummy", "ep", te.Interface(), nil); err != nil {
+		t.Fatalf("Failed to create endpoint: %v", err)
+	}
+	if err := d.Join("dummy", "ep", "sbox", te, nil); err != nil {
+		t.Fatalf("Failed to join endpoint: %v", err)
+	}
+	if !gw.IP.Equal(te.gw) {
+		t.Fatalf("Expected gateway %s for the endpoint of the added subnet, found %s", gw.IP, te.gw)
+	}
+}
@@ -13,8 +13,23 @@ func (n *bridgeNetwork) setupFirewalld(config *networkConfiguration, i *bridgeIn
 		return IPTableCfgError(config.BridgeName)
 	}
 
-	iptables.OnReloaded(func() { n.setupIPTables(config, i) })
+	if config.IPv6Only {
+		iptables.OnReloaded(func() { n.setupIPv6OnlyTables(config, i) })
+	} else {
+		iptables.OnReloaded(func() { n.setupIPTables(config, i) })
+	}
 	iptables.OnReloaded(n.portMapper.ReMapAll)
 
+	if iptables.FirewalldModeEnabled() {
+		if err := iptables.AddInterfaceFirewalld(config.BridgeName); err != nil {
+			return err
+		}
+		// The runtime interface bindings do not survive the reloads
+		iptables.OnReloaded(func() { iptables.AddInterfaceFirewalld(config.BridgeName) })
+		n.registerIptCleanFunc(func() error {
+			return iptables.DelInterfaceFirewalld(config.BridgeName)
+		})
+	}
+
 	return nil
 }
@@ -6,6 +6,7 @@ import (
 	"net"
 
 	"github.com/docker/libnetwork/iptables"
+	"github.com/docker/libnetwork/netpolicy"
 	"github.com/sirupsen/logrus"
 	"github.com/vishvananda/netlink"
 )
@@ -82,6 +83,50 @@ func setupIPChains(config *configuration) (*iptables.ChainInfo, *iptables.ChainI
 		}
 	}()
 
+	if _, err = iptables.NewChain(ConnLimitChain, iptables.Filter, false); err != nil {
+		return nil, nil, nil, nil, fmt.Errorf("failed to create FILTER connection limit chain: %v", err)
+	}
+
+	if _, err = iptables.NewChain(ICCChain, iptables.Filter, false); err != nil {
+		return nil, nil, nil, nil, fmt.Errorf("failed to create FILTER ICC groups chain: %v", err)
+	}
+
+	if err = setupHostAccessChain(); err != nil {
+		return nil, nil, nil, nil, err
+	}
+
+	if err = setupMetadataChain(); err != nil {
+		return nil, nil, nil, nil, err
+	}
+
+	if err = setupBootstrapChain(); err != nil {
+		return nil, nil, nil, nil, err
+	}
+
+	if err = setupQuarantineChain(); err != nil {
+		return nil, nil, nil, nil, err
+	}
+
+	if err = setupHostOwnerChain(config); err != nil {
+		return nil, nil, nil, nil, err
+	}
+
+	if err = setupFilterChain(); err != nil {
+		return nil, nil, nil, nil, err
+	}
+
+	if err = setupProfileChain(); err != nil {
+		return nil, nil, nil, nil, err
+	}
+
+	if err = setupSynProxyChains(); err != nil {
+		return nil, nil, nil, nil, err
+	}
+
+	if err = setupConntrackTimeoutChain(); err != nil {
+		return nil, nil, nil, nil, err
+	}
+
 	if err := iptables.AddReturnRule(IsolationChain1); err != nil {
 		return nil, nil, nil, nil, err
 	}
@@ -127,6 +172,18 @@ func (n *bridgeNetwork) setupIPTables(config *networkConfiguration, i *bridgeInt
 		n.registerIptCleanFunc(func() error {
 			return setupIPTablesInternal(config.BridgeName, maskedAddrv4, config.EnableICC, config.EnableIPMasquerade, hairpinMode, false)
 		})
+		if err = n.setupSNATPool(config, maskedAddrv4); err != nil {
+			return fmt.Errorf("Failed to Setup IP tables: %s", err.Error())
+		}
+		if err = n.setupNATExemptions(config, maskedAddrv4); err != nil {
+			return fmt.Errorf("Failed to Setup IP tables: %s", err.Error())
+		}
+		if err = n.setupIPv6NAT(config); err != nil {
+			return fmt.Errorf("Failed to Setup IP tables: %s", err.Error())
+		}
+		if err = n.setupMulticastForwarding(config); err != nil {
+			return fmt.Errorf("Failed to Setup IP tables: %s", err.Error())
+		}
 		natChain, filterChain, _, _, err := n.getDriverChains()
 		if err != nil {
 			return fmt.Errorf("Failed to setup IP tables, cannot acquire chain info %s", err.Error())
@@ -149,8 +206,41 @@ func (n *bridgeNetwork) setupIPTables(config *networkConfiguration, i *bridgeInt
 		n.portMapper.SetIptablesChain(natChain, n.getNetworkBridgeName())
 	}
 
+	if err = n.setupConntrackZone(config); err != nil {
+		return fmt.Errorf("Failed to Setup IP tables: %s", err.Error())
+	}
+
+	if err = n.setupMetadataRedirect(config); err != nil {
+		return fmt.Errorf("Failed to Setup IP tables: %s", err.Error())
+	}
+
+	if err = n.setupConnectivityProfile(config); err != nil {
+		return fmt.Errorf("Failed to Setup IP tables: %s", err.Error())
+	}
+
 	d.Lock()
-	err = iptables.EnsureJumpRule("FORWARD", IsolationChain1)
+	err = iptables.EnsureJumpRule("FORWARD", FilterChain)
+	if err == nil {
+		err = iptables.EnsureJumpRule("FORWARD", ICCChain)
+	}
+	if err == nil {
+		err = iptables.EnsureJumpRule("FORWARD", HostAccessChain)
+	}
+	if err == nil {
+		err = iptables.EnsureJumpRule("FORWARD", ConnLimitChain)
+	}
+	if err == nil {
+		err = iptables.EnsureJumpRule("FORWARD", ProfileChain)
+	}
+	if err == nil {
+		err = iptables.EnsureJumpRule("FORWARD", IsolationChain1)
+	}
+	if err == nil {
+		err = iptables.EnsureJumpRule("FORWARD", BootstrapChain)
+	}
+	if err == nil {
+		err = iptables.EnsureJumpRule("FORWARD", QuarantineChain)
+	}
 	d.Unlock()
 	if err != nil {
 		return err
@@ -169,11 +259,10 @@ type iptRule struct {
 func setupIPTablesInternal(bridgeIface string, addr net.Addr, icc, ipmasq, hairpin, enable bool) error {
 
 	var (
-		address   = addr.String()
-		natRule   = iptRule{table: iptables.Nat, chain: "POSTROUTING", preArgs: []string{"-t", "nat"}, args: []string{"-s", address, "!", "-o", bridgeIface, "-j", "MASQUERADE"}}
+		natRule   = masqueradeRule(bridgeIface, addr)
 		hpNatRule = iptRule{table: iptables.Nat, chain: "POSTROUTING", preArgs: []string{"-t", "nat"}, args: []string{"-m", "addrtype", "--src-type", "LOCAL", "-o", bridgeIface, "-j", "MASQUERADE"}}
 		skipDNAT  = iptRule{table: iptables.Nat, chain: DockerChain, preArgs: []string{"-t", "nat"}, args: []string{"-i", bridgeIface, "-j", "RETURN"}}
-		outRule   = iptRule{table: iptables.Filter, chain: "FORWARD", args: []string{"-i", bridgeIface, "!", "-o", bridgeIface, "-j", "ACCEPT"}}
+		outRule   = outgoingRule(bridgeIface)
 	)
 
 	// Set NAT.
@@ -205,6 +294,17 @@ func setupIPTablesInternal(bridgeIface string, addr net.Addr, icc, ipmasq, hairp
 	return programChainRule(outRule, "ACCEPT NON_ICC OUTGOING", enable)
 }
 
+// masqueradeRule is the NAT rule for the traffic of the bridge subnet
+// leaving the bridge
+func masqueradeRule(bridgeIface string, addr net.Addr) iptRule {
+	return iptRule{table: iptables.Nat, chain: "POSTROUTING", preArgs: []string{"-t", "nat"}, args: []string{"-s", addr.String(), "!", "-o", bridgeIface, "-j", "MASQUERADE"}}
+}
+
+// outgoingRule accepts the non inter-container traffic leaving the bridge
+func outgoingRule(bridgeIface string) iptRule {
+	return iptRule{table: iptables.Filter, chain: "FORWARD", args: []string{"-i", bridgeIface, "!", "-o", bridgeIface, "-j", "ACCEPT"}}
+}
+
 func programChainRule(rule iptRule, ruleDescr string, insert bool) error {
 	var (
 		prefix    []string
@@ -327,12 +427,26 @@ func removeIPChains() {
 		{Name: DockerChain, Table: iptables.Filter},
 		{Name: IsolationChain1, Table: iptables.Filter},
 		{Name: IsolationChain2, Table: iptables.Filter},
+		{Name: ConnLimitChain, Table: iptables.Filter},
+		{Name: ICCChain, Table: iptables.Filter},
+		{Name: HostAccessChain, Table: iptables.Filter},
+		{Name: MetadataChain, Table: iptables.Nat},
+		{Name: BootstrapChain, Table: iptables.Filter},
+		{Name: QuarantineChain, Table: iptables.Filter},
+		{Name: HostOwnerChain, Table: iptables.Mangle},
+		{Name: FilterChain, Table: iptables.Filter},
+		{Name: ProfileChain, Table: iptables.Filter},
+		{Name: SynProxyChain, Table: iptables.Filter},
+		{Name: SynProxyChain, Table: iptables.RawTable},
+		{Name: ConntrackTimeoutChain, Table: iptables.RawTable},
 		{Name: oldIsolationChain, Table: iptables.Filter},
 	} {
 		if err := chainInfo.Remove(); err != nil {
 			logrus.Warnf("Failed to remove existing iptables entries in table %s chain %s : %v", chainInfo.Table, chainInfo.Name, err)
 		}
 	}
+	removeFilterChain6()
+	netpolicy.RemoveIptablesChains()
 }
 
 func setupInternalNetworkRules(bridgeIface string, addr net.Addr, icc, insert bool) error {
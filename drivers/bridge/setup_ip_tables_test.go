@@ -7,6 +7,7 @@ import (
 	"github.com/docker/libnetwork/iptables"
 	"github.com/docker/libnetwork/portmapper"
 	"github.com/docker/libnetwork/testutils"
+	"github.com/docker/libnetwork/testutils/fakeiptables"
 	"github.com/vishvananda/netlink"
 )
 
@@ -134,3 +135,59 @@ func assertBridgeConfig(config *networkConfiguration, br *bridgeInterface, d *dr
 		t.Fatalf("%v", err)
 	}
 }
+
+func TestSetupIPTablesFake(t *testing.T) {
+	ipt := fakeiptables.New()
+	defer ipt.Install()()
+	v4 := ipt.IPv4()
+
+	if _, _, _, _, err := setupIPChains(&configuration{EnableIPTables: true}); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		table iptables.Table
+		chain string
+	}{
+		{iptables.Nat, DockerChain},
+		{iptables.Filter, DockerChain},
+		{iptables.Filter, IsolationChain1},
+		{iptables.Filter, IsolationChain2},
+	} {
+		if !v4.HasChain(c.table, c.chain) {
+			t.Fatalf("missing chain %s/%s", c.table, c.chain)
+		}
+	}
+	if !v4.HasRule(iptables.Filter, IsolationChain1, "-j", "RETURN") {
+		t.Fatalf("missing return rule in %s", IsolationChain1)
+	}
+
+	addr := &net.IPNet{IP: net.ParseIP("172.30.0.0"), Mask: net.CIDRMask(16, 32)}
+	if err := setupIPTablesInternal("br-fake", addr, false, true, false, true); err != nil {
+		t.Fatal(err)
+	}
+	natRule := masqueradeRule("br-fake", addr)
+	if !v4.HasRule(iptables.Nat, "POSTROUTING", natRule.args...) {
+		t.Fatalf("missing masquerade rule:\n%s", v4.Save())
+	}
+	if !v4.HasRule(iptables.Nat, DockerChain, "-i", "br-fake", "-j", "RETURN") {
+		t.Fatalf("missing skip DNAT rule:\n%s", v4.Save())
+	}
+	if !v4.HasRule(iptables.Filter, "FORWARD", "-i", "br-fake", "-o", "br-fake", "-j", "DROP") {
+		t.Fatalf("missing ICC rule:\n%s", v4.Save())
+	}
+
+	if err := setupIPTablesInternal("br-fake", addr, false, true, false, false); err != nil {
+		t.Fatal(err)
+	}
+	for _, table := range []iptables.Table{iptables.Nat, iptables.Filter} {
+		for _, chain := range []string{"POSTROUTING", "FORWARD", DockerChain} {
+			for _, rule := range v4.Rules(table, chain) {
+				for _, arg := range rule {
+					if arg == "br-fake" {
+						t.Fatalf("rule %v left in %s/%s", rule, table, chain)
+					}
+				}
+			}
+		}
+	}
+}
@@ -17,16 +17,18 @@ func setupVerifyAndReconcile(config *networkConfiguration, i *bridgeInterface) e
 		return fmt.Errorf("Failed to verify ip addresses: %v", err)
 	}
 
-	addrv4, _ := selectIPv4Address(addrsv4, config.AddressIPv4)
+	if !config.IPv6Only {
+		addrv4, _ := selectIPv4Address(addrsv4, config.AddressIPv4)
 
-	// Verify that the bridge does have an IPv4 address.
-	if addrv4.IPNet == nil {
-		return &ErrNoIPAddr{}
-	}
+		// Verify that the bridge does have an IPv4 address.
+		if addrv4.IPNet == nil {
+			return &ErrNoIPAddr{}
+		}
 
-	// Verify that the bridge IPv4 address matches the requested configuration.
-	if config.AddressIPv4 != nil && !addrv4.IP.Equal(config.AddressIPv4.IP) {
-		return &IPv4AddrNoMatchError{IP: addrv4.IP, CfgIP: config.AddressIPv4.IP}
+		// Verify that the bridge IPv4 address matches the requested configuration.
+		if config.AddressIPv4 != nil && !addrv4.IP.Equal(config.AddressIPv4.IP) {
+			return &IPv4AddrNoMatchError{IP: addrv4.IP, CfgIP: config.AddressIPv4.IP}
+		}
 	}
 
 	// Verify that one of the bridge IPv6 addresses matches the requested
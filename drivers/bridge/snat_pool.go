@@ -0,0 +1,117 @@
+package bridge
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strconv"
+
+	"github.com/docker/libnetwork/iptables"
+	"github.com/docker/libnetwork/labelparse"
+	"github.com/docker/libnetwork/types"
+)
+
+// Policies of the distribution of the connections over the SNAT pool
+const (
+	// SNATHash maps a source address to the same pool address
+	SNATHash = "hash"
+	// SNATRoundRobin hands out the pool addresses to the new connections
+	// in turn
+	SNATRoundRobin = "round-robin"
+)
+
+// maxSNATRoundRobin bounds the pool size in round-robin mode, which takes
+// one rule per address
+const maxSNATRoundRobin = 256
+
+// parseSNATPool parses a pool, a single IPv4 address or a range as in
+// 203.0.113.10-203.0.113.20
+func parseSNATPool(value string) (net.IP, net.IP, error) {
+	return labelparse.ParseIPRange(value, labelparse.IPv4)
+}
+
+func validateSNATPool(config *networkConfiguration) error {
+	switch config.SNATMode {
+	case "", SNATHash, SNATRoundRobin:
+	default:
+		return types.BadRequestErrorf("invalid SNAT mode %q", config.SNATMode)
+	}
+	if config.SNATPool == "" {
+		if config.SNATMode != "" {
+			return types.BadRequestErrorf("the SNAT mode requires a SNAT pool")
+		}
+		return nil
+	}
+	start, end, err := parseSNATPool(config.SNATPool)
+	if err != nil {
+		return err
+	}
+	if config.SNATMode == SNATRoundRobin && binary.BigEndian.Uint32(end)-binary.BigEndian.Uint32(start) >= maxSNATRoundRobin {
+		return types.BadRequestErrorf("the SNAT pool %s exceeds the %d addresses of the round-robin mode", config.SNATPool, maxSNATRoundRobin)
+	}
+	return nil
+}
+
+// snatPoolRules are the rules translating the traffic of the subnet to the
+// pool, in their order in the chain. The round-robin rules rely on
+// conntrack, which only runs the nat table for the first packet of a
+// connection.
+func snatPoolRules(bridgeIface string, subnet *net.IPNet, pool, mode string) ([]iptRule, error) {
+	start, end, err := parseSNATPool(pool)
+	if err != nil {
+		return nil, err
+	}
+	match := []string{"-s", subnet.String(), "!", "-o", bridgeIface}
+
+	if mode != SNATRoundRobin {
+		to := start.String()
+		if !start.Equal(end) {
+			to += "-" + end.String()
+		}
+		return []iptRule{{table: iptables.Nat, chain: "POSTROUTING", preArgs: []string{"-t", "nat"},
+			args: append(match, "-j", "SNAT", "--to-source", to, "--persistent")}}, nil
+	}
+
+	first, last := binary.BigEndian.Uint32(start), binary.BigEndian.Uint32(end)
+	n := int(last - first + 1)
+	rules := make([]iptRule, 0, n)
+	for i := 0; i < n; i++ {
+		ip := make(net.IP, 4)
+		binary.BigEndian.PutUint32(ip, first+uint32(i))
+		args := append([]string{}, match...)
+		// The next rules get the connections left over
+		if i < n-1 {
+			args = append(args, "-m", "statistic", "--mode", "nth", "--every", strconv.Itoa(n-i), "--packet", "0")
+		}
+		rules = append(rules, iptRule{table: iptables.Nat, chain: "POSTROUTING", preArgs: []string{"-t", "nat"},
+			args: append(args, "-j", "SNAT", "--to-source", ip.String())})
+	}
+	return rules, nil
+}
+
+// setupSNATPool programs the SNAT pool rules of the subnet above its
+// masquerade rule, to be called before setupNATExemptions
+func (n *bridgeNetwork) setupSNATPool(config *networkConfiguration, subnet *net.IPNet) error {
+	if !config.EnableIPMasquerade || config.SNATPool == "" {
+		return nil
+	}
+	rules, err := snatPoolRules(config.BridgeName, subnet, config.SNATPool, config.SNATMode)
+	if err != nil {
+		return err
+	}
+	// The rules are inserted on top of the chain
+	for i := len(rules) - 1; i >= 0; i-- {
+		if err := programChainRule(rules[i], "SNAT POOL", true); err != nil {
+			return fmt.Errorf("failed to program the SNAT pool of subnet %s: %v", subnet, err)
+		}
+	}
+	n.registerIptCleanFunc(func() error {
+		for _, rule := range rules {
+			if err := programChainRule(rule, "SNAT POOL", false); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return nil
+}
@@ -0,0 +1,62 @@
+package bridge
+
+import (
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestValidateSNATPool(t *testing.T) {
+	for _, c := range []*networkConfiguration{
+		{},
+		{SNATPool: "203.0.113.10"},
+		{SNATPool: "203.0.113.10-203.0.113.20", SNATMode: SNATRoundRobin},
+	} {
+		if err := validateSNATPool(c); err != nil {
+			t.Fatalf("unexpected error validating %+v: %v", c, err)
+		}
+	}
+
+	for _, c := range []*networkConfiguration{
+		{SNATMode: SNATHash},
+		{SNATPool: "203.0.113.10", SNATMode: "random"},
+		{SNATPool: "203.0.113.20-203.0.113.10"},
+		{SNATPool: "2001:db8::1"},
+		{SNATPool: "10.0.0.0-10.0.1.255", SNATMode: SNATRoundRobin},
+	} {
+		if err := validateSNATPool(c); err == nil {
+			t.Fatalf("expected an error validating %+v", c)
+		}
+	}
+}
+
+func TestSNATPoolRules(t *testing.T) {
+	_, subnet, _ := net.ParseCIDR("172.18.0.0/16")
+
+	rules, err := snatPoolRules("br0", subnet, "203.0.113.10-203.0.113.20", SNATHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"-s", "172.18.0.0/16", "!", "-o", "br0", "-j", "SNAT", "--to-source", "203.0.113.10-203.0.113.20", "--persistent"}
+	if len(rules) != 1 || !reflect.DeepEqual(rules[0].args, expected) {
+		t.Fatalf("unexpected rules: %+v", rules)
+	}
+
+	rules, err = snatPoolRules("br0", subnet, "203.0.113.10-203.0.113.12", SNATRoundRobin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedRR := [][]string{
+		{"-s", "172.18.0.0/16", "!", "-o", "br0", "-m", "statistic", "--mode", "nth", "--every", "3", "--packet", "0", "-j", "SNAT", "--to-source", "203.0.113.10"},
+		{"-s", "172.18.0.0/16", "!", "-o", "br0", "-m", "statistic", "--mode", "nth", "--every", "2", "--packet", "0", "-j", "SNAT", "--to-source", "203.0.113.11"},
+		{"-s", "172.18.0.0/16", "!", "-o", "br0", "-j", "SNAT", "--to-source", "203.0.113.12"},
+	}
+	if len(rules) != len(expectedRR) {
+		t.Fatalf("unexpected rules: %+v", rules)
+	}
+	for i, r := range rules {
+		if !reflect.DeepEqual(r.args, expectedRR[i]) {
+			t.Fatalf("unexpected rule %d:\n%v\nexpected:\n%v", i, r.args, expectedRR[i])
+		}
+	}
+}
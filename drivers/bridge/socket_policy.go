@@ -0,0 +1,520 @@
+package bridge
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"unsafe"
+
+	"github.com/docker/libnetwork/driverapi"
+	"github.com/docker/libnetwork/netlabel"
+	"github.com/docker/libnetwork/types"
+	"github.com/sirupsen/logrus"
+	"github.com/vishvananda/netlink/nl"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	bpfMapLookupElem = 1
+	bpfMapDeleteElem = 3
+	bpfMapGetNextKey = 4
+	bpfObjGet        = 7
+	bpfProgAttach    = 8
+	bpfProgDetach    = 9
+
+	bpfMapTypeHash            = 1
+	bpfProgTypeCgroupSockAddr = 18
+
+	bpfCgroupInet4Connect = 10
+	bpfCgroupInet6Connect = 11
+
+	bpfFAllowMulti = 2
+)
+
+// The offsets of the fields of struct bpf_sock_addr
+const (
+	sockAddrUserIP4  = 4
+	sockAddrUserIP6  = 8
+	sockAddrUserPort = 24
+)
+
+// socketDenialEntries are the destinations the map of an endpoint counts
+// the rejected connect() calls to between two reads, the calls to the
+// other destinations being rejected uncounted
+const socketDenialEntries = 1024
+
+// socketDenialKeyLen is the length of the keys of the map, the IPv6 or
+// IPv4-mapped destination address and the port in network order
+const socketDenialKeyLen = net.IPv6len + 4
+
+// loopbackNet is always allowed, the embedded DNS server listening there
+var loopbackNet = &net.IPNet{IP: net.IPv4(127, 0, 0, 0).To4(), Mask: net.CIDRMask(8, 32)}
+
+func validateSocketPolicy(c *networkConfiguration) error {
+	if !c.SocketPolicy {
+		return nil
+	}
+	if p, ok := connectivityProfiles[c.ConnectivityProfile]; !ok || p.egress == nil {
+		return errors.New("the socket policy needs a connectivity profile restricting the egress traffic")
+	}
+	return nil
+}
+
+func parseCgroupOptions(ec *endpointConfiguration, epOptions map[string]interface{}) error {
+	opt, ok := epOptions[netlabel.Cgroup]
+	if !ok {
+		return nil
+	}
+	v, ok := opt.(string)
+	if !ok {
+		return &ErrInvalidEndpointConfig{}
+	}
+	if !filepath.IsAbs(v) {
+		return types.BadRequestErrorf("invalid cgroup %q: expected an absolute path", v)
+	}
+	ec.Cgroup = filepath.Clean(v)
+	return nil
+}
+
+// hasSocketPolicy tells whether the connect() calls of the endpoint are
+// policed, which needs the cgroup of its sandbox
+func hasSocketPolicy(config *networkConfiguration, ep *bridgeEndpoint) bool {
+	return config.SocketPolicy && ep.config != nil && ep.config.Cgroup != ""
+}
+
+// socketPolicyNets returns the destinations the endpoints connect to: the
+// egress list of the profile, the subnets of the network, whose traffic is
+// left to the ICC settings, and the loopback one
+func socketPolicyNets(config *networkConfiguration) []*net.IPNet {
+	nets := []*net.IPNet{loopbackNet}
+	for _, cidr := range connectivityProfiles[config.ConnectivityProfile].egress {
+		if _, n, err := net.ParseCIDR(cidr); err == nil {
+			nets = append(nets, n)
+		}
+	}
+	for _, gw := range append([]*net.IPNet{config.AddressIPv4}, config.SecondaryAddressesIPv4...) {
+		if gw != nil {
+			nets = append(nets, &net.IPNet{IP: gw.IP.Mask(gw.Mask), Mask: gw.Mask})
+		}
+	}
+	return nets
+}
+
+// bpfAsm assembles a program whose jumps go to labels
+type bpfAsm struct {
+	insns  []bpfInsn
+	labels map[string]int
+	jumps  map[int]string
+}
+
+func (a *bpfAsm) emit(insns ...bpfInsn) {
+	a.insns = append(a.insns, insns...)
+}
+
+func (a *bpfAsm) jump(insn bpfInsn, label string) {
+	if a.jumps == nil {
+		a.jumps = map[int]string{}
+	}
+	a.jumps[len(a.insns)] = label
+	a.insns = append(a.insns, insn)
+}
+
+func (a *bpfAsm) label(name string) {
+	if a.labels == nil {
+		a.labels = map[string]int{}
+	}
+	a.labels[name] = len(a.insns)
+}
+
+func (a *bpfAsm) assemble() []bpfInsn {
+	for i, label := range a.jumps {
+		a.insns[i].off = int16(a.labels[label] - i - 1)
+	}
+	return a.insns
+}
+
+// nativeWord returns the 4 bytes in network order as the program loads them
+func nativeWord(b []byte) int32 {
+	return int32(nl.NativeEndian().Uint32(b))
+}
+
+// ldMapFd returns the two instructions loading the map in the register
+func ldMapFd(dst uint8, mapFd int) []bpfInsn {
+	return []bpfInsn{{code: 0x18, regs: bpfRegs(dst, 1), imm: int32(mapFd)}, {}}
+}
+
+// socketPolicyProgram rejects the connect() calls to the destinations out
+// of the allowed IPv4 subnets, counting them in the map by destination and
+// port. The IPv6 program checks the IPv4-mapped destinations of the dual
+// stack sockets against the subnets, lets ::1 through and rejects the
+// other destinations.
+func socketPolicyProgram(v6 bool, allowed []*net.IPNet, mapFd int) []bpfInsn {
+	mapped := nativeWord([]byte{0, 0, 0xff, 0xff})
+	a := &bpfAsm{}
+	a.emit(bpfInsn{code: 0xbf, regs: bpfRegs(6, 1)}) // r6 = ctx
+	if v6 {
+		a.emit(bpfInsn{code: 0x61, regs: bpfRegs(2, 6), off: sockAddrUserIP6}) // r2 = ctx->user_ip6[0]
+		a.jump(bpfInsn{code: 0x55, regs: bpfRegs(2, 0)}, "deny")               // if r2 != 0 goto deny
+		a.emit(bpfInsn{code: 0x61, regs: bpfRegs(2, 6), off: sockAddrUserIP6 + 4})
+		a.jump(bpfInsn{code: 0x55, regs: bpfRegs(2, 0)}, "deny")
+		a.emit(bpfInsn{code: 0x61, regs: bpfRegs(2, 6), off: sockAddrUserIP6 + 8})
+		a.jump(bpfInsn{code: 0x15, regs: bpfRegs(2, 0)}, "loopback")  // if r2 == 0 goto loopback
+		a.emit(bpfInsn{code: 0xb4, regs: bpfRegs(3, 0), imm: mapped}) // w3 = ::ffff:0:0
+		a.jump(bpfInsn{code: 0x5d, regs: bpfRegs(2, 3)}, "deny")      // if r2 != r3 goto deny
+		a.emit(bpfInsn{code: 0x61, regs: bpfRegs(2, 6), off: sockAddrUserIP6 + 12})
+	} else {
+		a.emit(bpfInsn{code: 0x61, regs: bpfRegs(2, 6), off: sockAddrUserIP4}) // r2 = ctx->user_ip4
+	}
+	// the 32 bits operations keep the addresses with the high bit set
+	// from being sign extended
+	for _, n := range allowed {
+		a.emit(
+			bpfInsn{code: 0xbf, regs: bpfRegs(3, 2)},                              // r3 = r2
+			bpfInsn{code: 0x54, regs: bpfRegs(3, 0), imm: nativeWord(n.Mask)},     // w3 &= mask
+			bpfInsn{code: 0xb4, regs: bpfRegs(4, 0), imm: nativeWord(n.IP.To4())}, // w4 = subnet
+		)
+		a.jump(bpfInsn{code: 0x1d, regs: bpfRegs(3, 4)}, "allow") // if r3 == r4 goto allow
+	}
+	if v6 {
+		a.jump(bpfInsn{code: 0x05}, "deny")
+		a.label("loopback")
+		a.emit(
+			bpfInsn{code: 0x61, regs: bpfRegs(2, 6), off: sockAddrUserIP6 + 12},
+			bpfInsn{code: 0xb4, regs: bpfRegs(3, 0), imm: nativeWord([]byte{0, 0, 0, 1})},
+		)
+		a.jump(bpfInsn{code: 0x1d, regs: bpfRegs(2, 3)}, "allow")
+	}
+
+	// the key is the destination at r10 - 24 and the port at r10 - 8
+	a.label("deny")
+	if v6 {
+		for i := int16(0); i < 4; i++ {
+			a.emit(
+				bpfInsn{code: 0x61, regs: bpfRegs(3, 6), off: sockAddrUserIP6 + 4*i},
+				bpfInsn{code: 0x63, regs: bpfRegs(10, 3), off: -24 + 4*i},
+			)
+		}
+	} else {
+		a.emit(
+			bpfInsn{code: 0x62, regs: bpfRegs(10, 0), off: -24},
+			bpfInsn{code: 0x62, regs: bpfRegs(10, 0), off: -20},
+			bpfInsn{code: 0x62, regs: bpfRegs(10, 0), off: -16, imm: mapped},
+			bpfInsn{code: 0x63, regs: bpfRegs(10, 2), off: -12},
+		)
+	}
+	a.emit(
+		bpfInsn{code: 0x61, regs: bpfRegs(3, 6), off: sockAddrUserPort}, // r3 = ctx->user_port
+		bpfInsn{code: 0x63, regs: bpfRegs(10, 3), off: -8},
+	)
+	a.emit(ldMapFd(1, mapFd)...)
+	a.emit(
+		bpfInsn{code: 0xbf, regs: bpfRegs(2, 10)},          // r2 = r10
+		bpfInsn{code: 0x07, regs: bpfRegs(2, 0), imm: -24}, // r2 += -24
+		bpfInsn{code: 0x85, imm: 1},                        // call bpf_map_lookup_elem
+	)
+	a.jump(bpfInsn{code: 0x15, regs: bpfRegs(0, 0)}, "insert") // if r0 == 0 goto insert
+	a.emit(
+		bpfInsn{code: 0xb7, regs: bpfRegs(1, 0), imm: 1}, // r1 = 1
+		bpfInsn{code: 0xdb, regs: bpfRegs(0, 1)},         // lock *(u64 *)r0 += r1
+	)
+	a.jump(bpfInsn{code: 0x05}, "reject")
+	a.label("insert")
+	a.emit(bpfInsn{code: 0x7a, regs: bpfRegs(10, 0), off: -32, imm: 1}) // *(u64 *)(r10 - 32) = 1
+	a.emit(ldMapFd(1, mapFd)...)
+	a.emit(
+		bpfInsn{code: 0xbf, regs: bpfRegs(2, 10)},
+		bpfInsn{code: 0x07, regs: bpfRegs(2, 0), imm: -24},
+		bpfInsn{code: 0xbf, regs: bpfRegs(3, 10)},          // r3 = r10
+		bpfInsn{code: 0x07, regs: bpfRegs(3, 0), imm: -32}, // r3 += -32
+		bpfInsn{code: 0xb7, regs: bpfRegs(4, 0)},           // r4 = BPF_ANY
+		bpfInsn{code: 0x85, imm: 2},                        // call bpf_map_update_elem
+	)
+	a.label("reject")
+	a.emit(bpfInsn{code: 0xb7, regs: bpfRegs(0, 0)}, bpfInsn{code: 0x95}) // return 0
+	a.label("allow")
+	a.emit(bpfInsn{code: 0xb7, regs: bpfRegs(0, 0), imm: 1}, bpfInsn{code: 0x95}) // return 1
+	return a.assemble()
+}
+
+// socketPolicyPath is the path the program or the map of the socket
+// policy of the endpoint is pinned at
+func socketPolicyPath(eid, name string) string {
+	return filepath.Join(afXDPPinRoot, eid+"."+name)
+}
+
+var socketPolicyHooks = []struct {
+	name       string
+	attachType uint32
+	v6         bool
+}{
+	{"connect4", bpfCgroupInet4Connect, false},
+	{"connect6", bpfCgroupInet6Connect, true},
+}
+
+func createDenialMap() (int, error) {
+	attr := struct {
+		mapType, keySize, valueSize, maxEntries, mapFlags uint32
+	}{bpfMapTypeHash, socketDenialKeyLen, 8, socketDenialEntries, 0}
+	return bpf(bpfMapCreate, unsafe.Pointer(&attr), unsafe.Sizeof(attr))
+}
+
+func loadSocketPolicy(attachType uint32, insns []bpfInsn) (int, error) {
+	license := []byte("Apache-2.0\x00")
+	log := make([]byte, 1<<16)
+	attr := struct {
+		progType, insnCnt               uint32
+		insns, license                  uint64
+		logLevel, logSize               uint32
+		logBuf                          uint64
+		kernVersion, progFlags          uint32
+		progName                        [16]byte
+		progIfindex, expectedAttachType uint32
+	}{
+		progType:           bpfProgTypeCgroupSockAddr,
+		insnCnt:            uint32(len(insns)),
+		insns:              uint64(uintptr(unsafe.Pointer(&insns[0]))),
+		license:            uint64(uintptr(unsafe.Pointer(&license[0]))),
+		logLevel:           1,
+		logSize:            uint32(len(log)),
+		logBuf:             uint64(uintptr(unsafe.Pointer(&log[0]))),
+		expectedAttachType: attachType,
+	}
+	fd, err := bpf(bpfProgLoad, unsafe.Pointer(&attr), unsafe.Sizeof(attr))
+	runtime.KeepAlive(insns)
+	runtime.KeepAlive(license)
+	runtime.KeepAlive(log)
+	if err != nil {
+		if n := bytes.IndexByte(log, 0); n > 0 {
+			return -1, fmt.Errorf("%v: %s", err, bytes.TrimSpace(log[:n]))
+		}
+		return -1, err
+	}
+	return fd, nil
+}
+
+func getBPFObject(path string) (int, error) {
+	p := append([]byte(path), 0)
+	attr := struct {
+		pathname  uint64
+		bpfFd     uint32
+		fileFlags uint32
+	}{uint64(uintptr(unsafe.Pointer(&p[0]))), 0, 0}
+	fd, err := bpf(bpfObjGet, unsafe.Pointer(&attr), unsafe.Sizeof(attr))
+	runtime.KeepAlive(p)
+	return fd, err
+}
+
+func cgroupAttach(cmd int, cgroupFd, progFd int, attachType uint32) error {
+	attr := struct {
+		targetFd, attachBpfFd, attachType, attachFlags uint32
+	}{uint32(cgroupFd), uint32(progFd), attachType, bpfFAllowMulti}
+	_, err := bpf(cmd, unsafe.Pointer(&attr), unsafe.Sizeof(attr))
+	return err
+}
+
+// attachSocketPolicy loads the connect4 and connect6 programs allowing the
+// subnets, attaches them to the cgroup of the endpoint and pins them along
+// their map, for the detach and the reads to find them after a restart.
+// The programs leave the other sockets of the cgroup alone, as the
+// unconnected UDP ones, which the iptables rules filter only.
+func attachSocketPolicy(eid, cgroup string, allowed []*net.IPNet) (err error) {
+	cgroupFd, err := unix.Open(cgroup, unix.O_RDONLY|unix.O_DIRECTORY, 0)
+	if err != nil {
+		return fmt.Errorf("failed to open cgroup %s: %v", cgroup, err)
+	}
+	defer unix.Close(cgroupFd)
+
+	if err := os.MkdirAll(afXDPPinRoot, 0700); err != nil {
+		return fmt.Errorf("failed to pin the socket policy, %s must be on a bpf filesystem: %v", afXDPPinRoot, err)
+	}
+	// the programs left attached by a crash are replaced
+	detachSocketPolicy(eid, cgroup)
+	defer func() {
+		if err != nil {
+			detachSocketPolicy(eid, cgroup)
+		}
+	}()
+
+	mapFd, err := createDenialMap()
+	if err != nil {
+		return fmt.Errorf("failed to create the denial map: %v", err)
+	}
+	defer unix.Close(mapFd)
+	if err := pinBPFObject(mapFd, socketPolicyPath(eid, "denials")); err != nil {
+		return fmt.Errorf("failed to pin the denial map, %s must be on a bpf filesystem: %v", afXDPPinRoot, err)
+	}
+	for _, h := range socketPolicyHooks {
+		progFd, err := loadSocketPolicy(h.attachType, socketPolicyProgram(h.v6, allowed, mapFd))
+		if err != nil {
+			return fmt.Errorf("failed to load the %s program: %v", h.name, err)
+		}
+		err = pinBPFObject(progFd, socketPolicyPath(eid, h.name))
+		if err == nil {
+			err = cgroupAttach(bpfProgAttach, cgroupFd, progFd, h.attachType)
+		}
+		unix.Close(progFd)
+		if err != nil {
+			return fmt.Errorf("failed to attach the %s program to cgroup %s: %v", h.name, cgroup, err)
+		}
+	}
+	return nil
+}
+
+// detachSocketPolicy detaches the pinned programs of the endpoint from its
+// cgroup, if it is still there, and unpins them and their map
+func detachSocketPolicy(eid, cgroup string) {
+	cgroupFd, err := unix.Open(cgroup, unix.O_RDONLY|unix.O_DIRECTORY, 0)
+	if err != nil {
+		cgroupFd = -1
+	}
+	for _, h := range socketPolicyHooks {
+		path := socketPolicyPath(eid, h.name)
+		progFd, err := getBPFObject(path)
+		if err != nil {
+			continue
+		}
+		if cgroupFd >= 0 {
+			if err := cgroupAttach(bpfProgDetach, cgroupFd, progFd, h.attachType); err != nil {
+				logrus.Warnf("Failed to detach the %s program of endpoint %.7s from cgroup %s: %v", h.name, eid, cgroup, err)
+			}
+		}
+		unix.Close(progFd)
+		os.Remove(path)
+	}
+	if cgroupFd >= 0 {
+		unix.Close(cgroupFd)
+	}
+	if err := os.Remove(socketPolicyPath(eid, "denials")); err != nil && !os.IsNotExist(err) {
+		logrus.Warnf("Failed to unpin the denial map of endpoint %.7s: %v", eid, err)
+	}
+}
+
+func mapElem(cmd int, mapFd int, key, value []byte) error {
+	attr := struct {
+		mapFd uint32
+		_     uint32
+		key   uint64
+		value uint64
+		flags uint64
+	}{mapFd: uint32(mapFd)}
+	if key != nil {
+		attr.key = uint64(uintptr(unsafe.Pointer(&key[0])))
+	}
+	if value != nil {
+		attr.value = uint64(uintptr(unsafe.Pointer(&value[0])))
+	}
+	_, err := bpf(cmd, unsafe.Pointer(&attr), unsafe.Sizeof(attr))
+	runtime.KeepAlive(key)
+	runtime.KeepAlive(value)
+	return err
+}
+
+// parseDenialKey returns the destination and the port of a key of the
+// denial map
+func parseDenialKey(key []byte) (net.IP, uint16) {
+	ip := net.IP(append([]byte{}, key[:net.IPv6len]...))
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	return ip, binary.BigEndian.Uint16(key[net.IPv6len:])
+}
+
+// readSocketDenials returns and clears the counts of the denial map of the
+// endpoint. The rejections counted between the read and the clear of a
+// destination are lost.
+func readSocketDenials(eid string) ([]driverapi.SocketDenial, error) {
+	mapFd, err := getBPFObject(socketPolicyPath(eid, "denials"))
+	if err != nil {
+		return nil, err
+	}
+	defer unix.Close(mapFd)
+
+	var keys [][]byte
+	var prev []byte
+	for len(keys) < socketDenialEntries {
+		next := make([]byte, socketDenialKeyLen)
+		if err := mapElem(bpfMapGetNextKey, mapFd, prev, next); err != nil {
+			if err == unix.ENOENT {
+				break
+			}
+			return nil, err
+		}
+		keys = append(keys, next)
+		prev = next
+	}
+
+	var denials []driverapi.SocketDenial
+	value := make([]byte, 8)
+	for _, key := range keys {
+		if err := mapElem(bpfMapLookupElem, mapFd, key, value); err != nil {
+			continue
+		}
+		mapElem(bpfMapDeleteElem, mapFd, key, nil)
+		ip, port := parseDenialKey(key)
+		denials = append(denials, driverapi.SocketDenial{Dst: ip, Port: port, Count: nl.NativeEndian().Uint64(value)})
+	}
+	return denials, nil
+}
+
+// joinSocketPolicy attaches the socket policy of the endpoint to its
+// cgroup, which must exist by the join, as the cgroup of a pod does
+func (n *bridgeNetwork) joinSocketPolicy(ep *bridgeEndpoint) error {
+	n.Lock()
+	config := n.config
+	n.Unlock()
+	if !hasSocketPolicy(config, ep) {
+		return nil
+	}
+	if err := attachSocketPolicy(ep.id, ep.config.Cgroup, socketPolicyNets(config)); err != nil {
+		return fmt.Errorf("failed to attach the socket policy of endpoint %.7s: %v", ep.id, err)
+	}
+	return nil
+}
+
+func (n *bridgeNetwork) leaveSocketPolicy(ep *bridgeEndpoint) {
+	n.Lock()
+	config := n.config
+	n.Unlock()
+	if hasSocketPolicy(config, ep) {
+		detachSocketPolicy(ep.id, ep.config.Cgroup)
+	}
+}
+
+// SocketDenials returns the connect() calls the socket policies of the
+// endpoints rejected since the previous call
+func (d *driver) SocketDenials() ([]driverapi.SocketDenial, error) {
+	var denials []driverapi.SocketDenial
+	for _, n := range d.getNetworks() {
+		n.Lock()
+		config := n.config
+		eps := make([]*bridgeEndpoint, 0, len(n.endpoints))
+		for _, ep := range n.endpoints {
+			eps = append(eps, ep)
+		}
+		n.Unlock()
+
+		for _, ep := range eps {
+			if !hasSocketPolicy(config, ep) {
+				continue
+			}
+			epDenials, err := readSocketDenials(ep.id)
+			if err != nil {
+				// the endpoints which did not join have no map
+				if err != unix.ENOENT {
+					logrus.Warnf("Failed to read the socket denials of endpoint %.7s: %v", ep.id, err)
+				}
+				continue
+			}
+			for _, dn := range epDenials {
+				dn.NetworkID, dn.EndpointID = ep.nid, ep.id
+				denials = append(denials, dn)
+			}
+		}
+	}
+	return denials, nil
+}
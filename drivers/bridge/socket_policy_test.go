@@ -0,0 +1,147 @@
+package bridge
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"testing"
+
+	"github.com/docker/libnetwork/netlabel"
+	"github.com/docker/libnetwork/types"
+)
+
+func TestParseSocketPolicy(t *testing.T) {
+	c := &networkConfiguration{}
+	if err := c.fromLabels(map[string]string{ConnectivityProfile: "datacenter", SocketPolicy: "true"}); err != nil {
+		t.Fatal(err)
+	}
+	if !c.SocketPolicy {
+		t.Fatal("socket policy not enabled")
+	}
+	if err := validateSocketPolicy(c); err != nil {
+		t.Fatal(err)
+	}
+	for _, profile := range []string{"", "internet"} {
+		if err := validateSocketPolicy(&networkConfiguration{ConnectivityProfile: profile, SocketPolicy: true}); err == nil {
+			t.Fatalf("socket policy accepted with profile %q", profile)
+		}
+	}
+
+	ec, err := parseEndpointOptions(map[string]interface{}{netlabel.Cgroup: "/sys/fs/cgroup/pod1/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ec.Cgroup != "/sys/fs/cgroup/pod1" {
+		t.Fatalf("unexpected cgroup %q", ec.Cgroup)
+	}
+	if _, err := parseEndpointOptions(map[string]interface{}{netlabel.Cgroup: "pod1"}); err == nil {
+		t.Fatal("relative cgroup accepted")
+	}
+}
+
+func TestSocketPolicyNets(t *testing.T) {
+	gw, _ := types.ParseCIDR("172.18.0.1/16")
+	gw2, _ := types.ParseCIDR("172.19.0.1/24")
+	c := &networkConfiguration{ConnectivityProfile: "isolated", AddressIPv4: gw, SecondaryAddressesIPv4: []*net.IPNet{gw2}}
+	var nets []string
+	for _, n := range socketPolicyNets(c) {
+		nets = append(nets, n.String())
+	}
+	expected := []string{"127.0.0.0/8", "172.18.0.0/16", "172.19.0.0/24"}
+	if len(nets) != len(expected) {
+		t.Fatalf("unexpected allowed subnets %v", nets)
+	}
+	for i := range nets {
+		if nets[i] != expected[i] {
+			t.Fatalf("unexpected allowed subnets %v", nets)
+		}
+	}
+	c.ConnectivityProfile = "datacenter"
+	if n := len(socketPolicyNets(c)); n != len(expected)+len(privateNets) {
+		t.Fatalf("unexpected allowed subnets %d", n)
+	}
+}
+
+func TestSocketPolicyProgram(t *testing.T) {
+	_, n, _ := net.ParseCIDR("10.0.0.0/8")
+	for _, v6 := range []bool{false, true} {
+		insns := socketPolicyProgram(v6, []*net.IPNet{n}, 3)
+		if last := insns[len(insns)-1]; last.code != 0x95 {
+			t.Fatalf("program does not end with an exit: %+v", last)
+		}
+		for i, insn := range insns {
+			if insn.code&0x07 != 0x05 || insn.code == 0x85 || insn.code == 0x95 {
+				continue
+			}
+			if target := i + 1 + int(insn.off); insn.off <= 0 || target >= len(insns) {
+				t.Fatalf("jump %d of the v6=%t program out of range: %d", i, v6, target)
+			}
+		}
+	}
+	ip, port := parseDenialKey([]byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0xff, 0xff, 8, 8, 8, 8, 0x01, 0xbb, 0, 0})
+	if !ip.Equal(net.ParseIP("8.8.8.8")) || len(ip) != net.IPv4len || port != 443 {
+		t.Fatalf("unexpected destination %s:%d", ip, port)
+	}
+}
+
+func TestAttachSocketPolicy(t *testing.T) {
+	root, err := ioutil.TempDir("", "socket-policy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	bpffs, cgroupfs := filepath.Join(root, "bpf"), filepath.Join(root, "cgroup")
+	os.Mkdir(bpffs, 0700)
+	os.Mkdir(cgroupfs, 0700)
+	if err := syscall.Mount("bpf", bpffs, "bpf", 0, ""); err != nil {
+		t.Skipf("no bpf filesystem: %v", err)
+	}
+	defer syscall.Unmount(bpffs, 0)
+	if err := syscall.Mount("cgroup2", cgroupfs, "cgroup2", 0, ""); err != nil {
+		t.Skipf("no cgroup2 filesystem: %v", err)
+	}
+	defer syscall.Unmount(cgroupfs, 0)
+	defer func(r string) { afXDPPinRoot = r }(afXDPPinRoot)
+	afXDPPinRoot = bpffs + "/libnetwork"
+
+	cgroup := filepath.Join(cgroupfs, "libnetwork-test")
+	if err := os.Mkdir(cgroup, 0755); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(cgroup)
+	_, allowed, _ := net.ParseCIDR("127.0.0.0/8")
+	if err := attachSocketPolicy("ep1", cgroup, []*net.IPNet{allowed}); err != nil {
+		t.Skipf("cannot attach the socket policy: %v", err)
+	}
+	defer detachSocketPolicy("ep1", cgroup)
+
+	// Move the test into the cgroup for a connect() out of the allowed
+	// subnet, which is rejected before any packet goes out
+	if err := ioutil.WriteFile(filepath.Join(cgroup, "cgroup.procs"), []byte(strconv.Itoa(os.Getpid())), 0644); err != nil {
+		t.Skipf("cannot join the test cgroup: %v", err)
+	}
+	defer ioutil.WriteFile(filepath.Join(cgroupfs, "cgroup.procs"), []byte(strconv.Itoa(os.Getpid())), 0644)
+	fd, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_DGRAM, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = syscall.Connect(fd, &syscall.SockaddrInet4{Port: 53, Addr: [4]byte{192, 0, 2, 1}})
+	syscall.Close(fd)
+	if err != syscall.EPERM {
+		t.Fatalf("expected the connect() to be rejected, got %v", err)
+	}
+
+	denials, err := readSocketDenials("ep1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(denials) != 1 || !denials[0].Dst.Equal(net.ParseIP("192.0.2.1")) || denials[0].Port != 53 || denials[0].Count != 1 {
+		t.Fatalf("unexpected denials %+v", denials)
+	}
+	if denials, _ := readSocketDenials("ep1"); len(denials) != 0 {
+		t.Fatalf("denials not cleared %+v", denials)
+	}
+}
@@ -0,0 +1,104 @@
+package bridge
+
+import (
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"github.com/docker/libnetwork/netlabel"
+	"github.com/docker/libnetwork/types"
+	"github.com/sirupsen/logrus"
+)
+
+// relaxedSysctls are set on the host side veth of the endpoints relaxing
+// the source validation, for the replies they send from the addresses of
+// a direct server return VIP, which the host does not route to them, to be
+// let through
+var relaxedSysctls = map[string]string{
+	"rp_filter":    "0",
+	"accept_local": "1",
+}
+
+func parseSourceValidationOptions(ec *endpointConfiguration, epOptions map[string]interface{}) error {
+	opt, ok := epOptions[netlabel.RelaxSourceValidation]
+	if !ok {
+		return nil
+	}
+	switch v := opt.(type) {
+	case bool:
+		ec.RelaxSourceValidation = v
+	case string:
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return types.BadRequestErrorf("invalid source validation option %q: %v", v, err)
+		}
+		ec.RelaxSourceValidation = b
+	default:
+		return &ErrInvalidEndpointConfig{}
+	}
+	return nil
+}
+
+func hasRelaxedSourceValidation(ep *bridgeEndpoint) bool {
+	return ep.config != nil && ep.config.RelaxSourceValidation
+}
+
+// endpointVethSysctls returns the sysctl profile of the network the host
+// side veth of the endpoint follows, less the relaxed ones
+func endpointVethSysctls(ep *bridgeEndpoint, profile map[string]string) map[string]string {
+	if !hasRelaxedSourceValidation(ep) {
+		return profile
+	}
+	sysctls := make(map[string]string, len(profile))
+	for name, value := range profile {
+		if _, ok := relaxedSysctls[name]; !ok {
+			sysctls[name] = value
+		}
+	}
+	return sysctls
+}
+
+// relaxSourceValidation sets the relaxed sysctls of the host side veth of
+// the endpoint, saving the values they had for Leave to restore them
+func relaxSourceValidation(ep *bridgeEndpoint) error {
+	if !hasRelaxedSourceValidation(ep) || ep.hostIfName == "" {
+		return nil
+	}
+
+	if ep.savedSysctls == nil {
+		saved := make(map[string]string, len(relaxedSysctls))
+		for name := range relaxedSysctls {
+			b, err := ioutil.ReadFile(vethSysctlPath(ep.hostIfName, name))
+			if err != nil {
+				return err
+			}
+			saved[name] = strings.TrimSpace(string(b))
+		}
+		ep.savedSysctls = saved
+	}
+	if _, err := applyVethSysctls(ep.hostIfName, relaxedSysctls); err != nil {
+		return err
+	}
+
+	// The source validation applies the stricter of the interface and of
+	// the all setting
+	if b, err := ioutil.ReadFile(vethSysctlPath("all", "rp_filter")); err == nil && strings.TrimSpace(string(b)) != "0" {
+		logrus.Warnf("net.ipv4.conf.all.rp_filter is %s, it still applies to %s of endpoint %.7s",
+			strings.TrimSpace(string(b)), ep.hostIfName, ep.id)
+	}
+	logrus.Debugf("Relaxed the source validation of %s of endpoint %.7s", ep.hostIfName, ep.id)
+	return nil
+}
+
+// restoreSourceValidation sets back the sysctls saved by
+// relaxSourceValidation
+func restoreSourceValidation(ep *bridgeEndpoint) {
+	if ep.savedSysctls == nil || ep.hostIfName == "" {
+		return
+	}
+	if _, err := applyVethSysctls(ep.hostIfName, ep.savedSysctls); err != nil {
+		logrus.Warnf("Failed to restore the source validation of %s of endpoint %.7s: %v", ep.hostIfName, ep.id, err)
+		return
+	}
+	ep.savedSysctls = nil
+}
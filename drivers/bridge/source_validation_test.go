@@ -0,0 +1,65 @@
+package bridge
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/docker/libnetwork/netlabel"
+)
+
+func TestRelaxSourceValidation(t *testing.T) {
+	root, err := ioutil.TempDir("", "source-validation")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	defer func(r string) { vethSysctlRoot = r }(vethSysctlRoot)
+	vethSysctlRoot = root
+
+	if err := os.MkdirAll(filepath.Join(root, "ipv4", "conf", "veth0"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for name, value := range map[string]string{"rp_filter": "1\n", "accept_local": "0\n"} {
+		if err := ioutil.WriteFile(vethSysctlPath("veth0", name), []byte(value), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ec, err := parseEndpointOptions(map[string]interface{}{netlabel.RelaxSourceValidation: "true"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ep := &bridgeEndpoint{id: "ep1", hostIfName: "veth0", config: ec}
+	if err := relaxSourceValidation(ep); err != nil {
+		t.Fatal(err)
+	}
+	for name, expected := range relaxedSysctls {
+		if b, _ := ioutil.ReadFile(vethSysctlPath("veth0", name)); strings.TrimSpace(string(b)) != expected {
+			t.Fatalf("%s not relaxed: %s", name, b)
+		}
+	}
+
+	// The network profile does not set back the relaxed sysctls
+	profile := map[string]string{"rp_filter": "2", "proxy_arp": "1"}
+	if s := endpointVethSysctls(ep, profile); !reflect.DeepEqual(s, map[string]string{"proxy_arp": "1"}) {
+		t.Fatalf("unexpected sysctls of the endpoint %v", s)
+	}
+
+	restoreSourceValidation(ep)
+	for name, expected := range map[string]string{"rp_filter": "1", "accept_local": "0"} {
+		if b, _ := ioutil.ReadFile(vethSysctlPath("veth0", name)); strings.TrimSpace(string(b)) != expected {
+			t.Fatalf("%s not restored: %s", name, b)
+		}
+	}
+	if ep.savedSysctls != nil {
+		t.Fatal("saved sysctls not cleared")
+	}
+
+	if _, err := parseEndpointOptions(map[string]interface{}{netlabel.RelaxSourceValidation: "sometimes"}); err == nil {
+		t.Fatal("expected an error parsing an invalid option")
+	}
+}
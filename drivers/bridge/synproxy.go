@@ -0,0 +1,204 @@
+package bridge
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"github.com/docker/libnetwork/iptables"
+	"github.com/docker/libnetwork/netlabel"
+	"github.com/docker/libnetwork/types"
+	"github.com/sirupsen/logrus"
+)
+
+// SynProxyChain is the chain, in the raw table jumped to from PREROUTING
+// and in the filter table jumped to from INPUT, handing the handshakes
+// towards the published TCP ports of the endpoints to the host SYNPROXY
+const SynProxyChain = "DOCKER-SYNPROXY"
+
+const (
+	// defaultSynRate is the rate of SYNs per source when none is configured
+	defaultSynRate = "100/sec"
+	tcpLoosePath   = "/proc/sys/net/netfilter/nf_conntrack_tcp_loose"
+)
+
+func parseSynProxyOptions(ec *endpointConfiguration, epOptions map[string]interface{}) error {
+	if opt, ok := epOptions[netlabel.SynProxy]; ok {
+		switch v := opt.(type) {
+		case bool:
+			ec.SynProxy = v
+		case string:
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				return types.BadRequestErrorf("invalid syn proxy option %q: %v", v, err)
+			}
+			ec.SynProxy = b
+		default:
+			return &ErrInvalidEndpointConfig{}
+		}
+	}
+
+	if opt, ok := epOptions[netlabel.SynRate]; ok {
+		rate, ok := opt.(string)
+		if !ok {
+			return &ErrInvalidEndpointConfig{}
+		}
+		if !connRateRe.MatchString(rate) {
+			return types.BadRequestErrorf("invalid syn rate %q: expected as in 20/sec", rate)
+		}
+		if !ec.SynProxy {
+			return types.BadRequestErrorf("the syn rate requires the syn proxy to be enabled")
+		}
+		ec.SynRate = rate
+	}
+
+	return nil
+}
+
+func hasSynProxy(ep *bridgeEndpoint) bool {
+	return ep.config != nil && ep.config.SynProxy
+}
+
+// synProxyRules renders the rules of a published TCP port of the endpoint.
+// The SYNs are exempted from connection tracking in the raw table, so that
+// they reach INPUT untranslated where the rate limit applies and SYNPROXY
+// completes the handshake before the connection is let through the DNAT.
+// The rules are tagged with the endpoint.
+func synProxyRules(ep *bridgeEndpoint, pb types.PortBinding) (raw [][]string, filter [][]string) {
+	dst := iptables.TagRule(networkType, ep.id, publishedPortMatch(pb))
+
+	rate := ep.config.SynRate
+	if rate == "" {
+		rate = defaultSynRate
+	}
+
+	raw = [][]string{
+		append(append([]string{}, dst...), "--syn", "-j", "CT", "--notrack"),
+	}
+	filter = [][]string{
+		append(append([]string{}, dst...), "--syn",
+			"-m", "hashlimit", "--hashlimit-above", rate,
+			"--hashlimit-mode", "srcip", "--hashlimit-name", hashlimitName("syn-", ep.id),
+			"-j", "DROP"),
+		append(append([]string{}, dst...), "-m", "conntrack", "--ctstate", "INVALID,UNTRACKED",
+			"-j", "SYNPROXY", "--sack-perm", "--timestamp", "--wscale", "7", "--mss", "1460"),
+		append(append([]string{}, dst...), "-m", "conntrack", "--ctstate", "INVALID",
+			"-j", "DROP"),
+	}
+	return raw, filter
+}
+
+// publishedPortMatch renders the match of the packets towards the host
+// side of the port binding, before their DNAT
+func publishedPortMatch(pb types.PortBinding) []string {
+	var dst []string
+	if pb.HostIP == nil || pb.HostIP.IsUnspecified() {
+		dst = []string{"-m", "addrtype", "--dst-type", "LOCAL"}
+	} else {
+		dst = []string{"-d", pb.HostIP.String()}
+	}
+	dport := strconv.Itoa(int(pb.HostPort))
+	if pb.HostPortEnd > pb.HostPort {
+		dport += ":" + strconv.Itoa(int(pb.HostPortEnd))
+	}
+	return append(dst, "-p", pb.Proto.String(), "--dport", dport)
+}
+
+// programSynProxy adds or removes the syn proxy rules of the published TCP
+// ports of the endpoint
+func programSynProxy(ep *bridgeEndpoint, enable bool) error {
+	if !hasSynProxy(ep) {
+		return nil
+	}
+
+	if enable {
+		checkTCPLoose()
+	}
+
+	action := iptables.Delete
+	if enable {
+		action = iptables.Append
+	}
+	for _, pb := range ep.portMapping {
+		if pb.Proto != types.TCP || (pb.HostIP != nil && pb.HostIP.To4() == nil) {
+			continue
+		}
+		raw, filter := synProxyRules(ep, pb)
+		for _, r := range []struct {
+			table iptables.Table
+			rules [][]string
+		}{
+			{iptables.RawTable, raw},
+			{iptables.Filter, filter},
+		} {
+			for _, rule := range r.rules {
+				if enable && iptables.Exists(r.table, SynProxyChain, rule...) {
+					continue
+				}
+				if err := iptables.ProgramRule(r.table, SynProxyChain, action, rule); err != nil {
+					if !enable {
+						logrus.Warnf("Failed to remove the syn proxy rule %v of endpoint %.7s: %v", rule, ep.id, err)
+						continue
+					}
+					return fmt.Errorf("failed to program the syn proxy of endpoint %.7s: %v", ep.id, err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// checkTCPLoose warns when conntrack picks up the connections from their
+// ACKs, which lets them bypass SYNPROXY
+func checkTCPLoose() {
+	b, err := ioutil.ReadFile(tcpLoosePath)
+	if err != nil {
+		return
+	}
+	if strings.TrimSpace(string(b)) != "0" {
+		logrus.Warnf("%s is enabled, the syn proxy of the published ports can be bypassed", tcpLoosePath)
+	}
+}
+
+// setupSynProxyChains creates the syn proxy chains and their jumps
+func setupSynProxyChains() error {
+	for _, c := range []struct {
+		table iptables.Table
+		from  string
+	}{
+		{iptables.RawTable, "PREROUTING"},
+		{iptables.Filter, "INPUT"},
+	} {
+		if _, err := iptables.NewChain(SynProxyChain, c.table, false); err != nil {
+			return fmt.Errorf("failed to create %s syn proxy chain: %v", strings.ToUpper(string(c.table)), err)
+		}
+		jump := []string{"-j", SynProxyChain}
+		if iptables.Exists(c.table, c.from, jump...) {
+			continue
+		}
+		if err := iptables.ProgramRule(c.table, c.from, iptables.Insert, jump); err != nil {
+			return fmt.Errorf("failed to add the jump to the %s syn proxy chain: %v", strings.ToUpper(string(c.table)), err)
+		}
+	}
+	return nil
+}
+
+// restoreSynProxies programs back the syn proxy rules of all the
+// endpoints, after the chains got flushed
+func (d *driver) restoreSynProxies() {
+	for _, n := range d.getNetworks() {
+		n.Lock()
+		eps := make([]*bridgeEndpoint, 0, len(n.endpoints))
+		for _, ep := range n.endpoints {
+			eps = append(eps, ep)
+		}
+		n.Unlock()
+
+		for _, ep := range eps {
+			if err := programSynProxy(ep, true); err != nil {
+				logrus.Warn(err)
+			}
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package bridge
+
+import (
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/docker/libnetwork/netlabel"
+	"github.com/docker/libnetwork/types"
+)
+
+func TestParseSynProxyOptions(t *testing.T) {
+	ec, err := parseEndpointOptions(map[string]interface{}{
+		netlabel.SynProxy: "true",
+		netlabel.SynRate:  "50/sec",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ec.SynProxy || ec.SynRate != "50/sec" {
+		t.Fatalf("unexpected endpoint configuration: %+v", ec)
+	}
+
+	for _, opts := range []map[string]interface{}{
+		{netlabel.SynProxy: "yes please"},
+		{netlabel.SynProxy: 1},
+		{netlabel.SynProxy: true, netlabel.SynRate: "50"},
+		{netlabel.SynRate: "50/sec"},
+	} {
+		if _, err := parseEndpointOptions(opts); err == nil {
+			t.Fatalf("expected an error parsing %v", opts)
+		}
+	}
+}
+
+func TestSynProxyRules(t *testing.T) {
+	ep := &bridgeEndpoint{
+		id:     "0123456789abcdef",
+		config: &endpointConfiguration{SynProxy: true},
+	}
+
+	raw, filter := synProxyRules(ep, types.PortBinding{Proto: types.TCP, HostIP: net.ParseIP("10.0.0.1"), HostPort: 8080})
+	expectedRaw := [][]string{
+		{"-d", "10.0.0.1", "-p", "tcp", "--dport", "8080", "-m", "comment", "--comment", "lnet:bridge:0123456789abcdef", "--syn", "-j", "CT", "--notrack"},
+	}
+	expectedFilter := [][]string{
+		{"-d", "10.0.0.1", "-p", "tcp", "--dport", "8080", "-m", "comment", "--comment", "lnet:bridge:0123456789abcdef", "--syn",
+			"-m", "hashlimit", "--hashlimit-above", defaultSynRate,
+			"--hashlimit-mode", "srcip", "--hashlimit-name", "syn-0123456789a",
+			"-j", "DROP"},
+		{"-d", "10.0.0.1", "-p", "tcp", "--dport", "8080", "-m", "comment", "--comment", "lnet:bridge:0123456789abcdef", "-m", "conntrack", "--ctstate", "INVALID,UNTRACKED",
+			"-j", "SYNPROXY", "--sack-perm", "--timestamp", "--wscale", "7", "--mss", "1460"},
+		{"-d", "10.0.0.1", "-p", "tcp", "--dport", "8080", "-m", "comment", "--comment", "lnet:bridge:0123456789abcdef", "-m", "conntrack", "--ctstate", "INVALID",
+			"-j", "DROP"},
+	}
+	if !reflect.DeepEqual(raw, expectedRaw) {
+		t.Fatalf("unexpected raw rules:\n%v\nexpected:\n%v", raw, expectedRaw)
+	}
+	if !reflect.DeepEqual(filter, expectedFilter) {
+		t.Fatalf("unexpected filter rules:\n%v\nexpected:\n%v", filter, expectedFilter)
+	}
+
+	raw, _ = synProxyRules(ep, types.PortBinding{Proto: types.TCP, HostPort: 8080, HostPortEnd: 8090})
+	expectedRaw = [][]string{
+		{"-m", "addrtype", "--dst-type", "LOCAL", "-p", "tcp", "--dport", "8080:8090",
+			"-m", "comment", "--comment", "lnet:bridge:0123456789abcdef", "--syn", "-j", "CT", "--notrack"},
+	}
+	if !reflect.DeepEqual(raw, expectedRaw) {
+		t.Fatalf("unexpected raw rules:\n%v\nexpected:\n%v", raw, expectedRaw)
+	}
+
+	if hasSynProxy(&bridgeEndpoint{config: &endpointConfiguration{}}) {
+		t.Fatal("endpoint without syn proxy reported as proxied")
+	}
+}
@@ -0,0 +1,145 @@
+package bridge
+
+import (
+	"fmt"
+
+	"github.com/docker/libnetwork/iptables"
+	"github.com/docker/libnetwork/types"
+	"github.com/sirupsen/logrus"
+)
+
+// hashlimitRate renders the rate, in bytes per second, in the kb/s unit of
+// hashlimit, rounded up
+func hashlimitRate(rate uint64) string {
+	return fmt.Sprintf("%dkb/s", (rate+1023)/1024)
+}
+
+// throttleRules renders the rules dropping the IPv4 and IPv6 traffic of
+// the endpoint above the rate, tagged with it. Each direction has its own
+// hashlimit bucket, named after the short endpoint id to fit the length
+// limit of the bucket names. The rules go to the quarantine chain, ahead
+// of the other chains; the IPv6 ones go straight to FORWARD.
+func throttleRules(bridgeName string, ep *bridgeEndpoint, rate uint64) (rules [][]string, rules6 []ip6Rule) {
+	id := ep.id
+	if len(id) > 7 {
+		id = id[:7]
+	}
+	limit := func(ipv6 bool, dir string, args ...string) []string {
+		name := "thr" + id + dir
+		if ipv6 {
+			name += "6"
+		}
+		args = append(append([]string{}, args...),
+			"-m", "hashlimit", "--hashlimit-above", hashlimitRate(rate), "--hashlimit-name", name, "-j", "DROP")
+		return iptables.TagRule(networkType, ep.id, args)
+	}
+
+	if ep.addr != nil {
+		ip := ep.addr.IP.String()
+		rules = [][]string{
+			limit(false, "o", "-i", bridgeName, "-s", ip),
+			limit(false, "i", "-o", bridgeName, "-d", ip),
+		}
+	}
+	if ep.addrv6 != nil {
+		ip := ep.addrv6.IP.String()
+		rules6 = []ip6Rule{
+			{table: iptables.Filter, chain: "FORWARD", args: limit(true, "o", "-i", bridgeName, "-s", ip)},
+			{table: iptables.Filter, chain: "FORWARD", args: limit(true, "i", "-o", bridgeName, "-d", ip)},
+		}
+	}
+	return rules, rules6
+}
+
+// programThrottle adds or removes the throttle rules of the rate
+func (n *bridgeNetwork) programThrottle(ep *bridgeEndpoint, rate uint64, enable bool) error {
+	n.Lock()
+	bridgeName := n.config.BridgeName
+	n.Unlock()
+
+	op := iptables.Delete
+	if enable {
+		op = iptables.Insert
+	}
+	rules, rules6 := throttleRules(bridgeName, ep, rate)
+	for _, rule := range rules {
+		if enable == iptables.Exists(iptables.Filter, QuarantineChain, rule...) {
+			continue
+		}
+		if err := iptables.ProgramRule(iptables.Filter, QuarantineChain, op, rule); err != nil {
+			if !enable {
+				logrus.Warnf("Failed to remove the throttle rule %v of endpoint %.7s: %v", rule, ep.id, err)
+				continue
+			}
+			return fmt.Errorf("failed to throttle endpoint %.7s: %v", ep.id, err)
+		}
+	}
+	for _, rule := range rules6 {
+		if err := programIPv6Rule(rule, enable); err != nil {
+			if !enable {
+				logrus.Warnf("Failed to remove the throttle rule %v of endpoint %.7s: %v", rule.args, ep.id, err)
+				continue
+			}
+			return fmt.Errorf("failed to throttle the IPv6 traffic of endpoint %.7s: %v", ep.id, err)
+		}
+	}
+	return nil
+}
+
+// ThrottleEndpoint drops the traffic of the endpoint above the rate, or
+// lets it through again when the rate is zero. Unlike the quarantine, the
+// rules of the previous rate go away before the ones of the new rate are
+// in place: the hashlimit buckets of the endpoint keep the settings they
+// were created with as long as a rule uses them.
+func (d *driver) ThrottleEndpoint(nid, eid string, rate uint64) error {
+	if !d.config.EnableIPTables {
+		return types.NotImplementedErrorf("the throttling of the endpoints requires iptables to be enabled")
+	}
+	n, err := d.getNetwork(nid)
+	if err != nil {
+		return err
+	}
+	ep, err := n.getEndpoint(eid)
+	if err != nil {
+		return err
+	}
+	if ep == nil {
+		return EndpointNotFoundError(eid)
+	}
+
+	prev := n.throttleOf(ep)
+	if prev == rate {
+		return nil
+	}
+
+	if prev != 0 {
+		if err := n.programThrottle(ep, prev, false); err != nil {
+			return err
+		}
+	}
+	n.Lock()
+	ep.throttle = 0
+	n.Unlock()
+	if rate != 0 {
+		if err := n.programThrottle(ep, rate, true); err != nil {
+			n.programThrottle(ep, rate, false)
+			return err
+		}
+	}
+
+	n.Lock()
+	ep.throttle = rate
+	n.Unlock()
+	if err := d.storeUpdate(ep); err != nil {
+		logrus.Warnf("Failed to update bridge endpoint %.7s to store: %v", ep.id, err)
+	}
+	return nil
+}
+
+// throttleOf returns the throttle rate of the endpoint, zero when it is not
+// throttled
+func (n *bridgeNetwork) throttleOf(ep *bridgeEndpoint) uint64 {
+	n.Lock()
+	defer n.Unlock()
+	return ep.throttle
+}
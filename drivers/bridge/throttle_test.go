@@ -0,0 +1,59 @@
+package bridge
+
+import (
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/docker/libnetwork/types"
+)
+
+func TestHashlimitRate(t *testing.T) {
+	for rate, expected := range map[uint64]string{
+		1:       "1kb/s",
+		1024:    "1kb/s",
+		1025:    "2kb/s",
+		1048576: "1024kb/s",
+	} {
+		if r := hashlimitRate(rate); r != expected {
+			t.Fatalf("unexpected hashlimit rate %s for %d, expected %s", r, rate, expected)
+		}
+	}
+}
+
+func TestThrottleRules(t *testing.T) {
+	ep := &bridgeEndpoint{
+		id:     "ep1234567890",
+		addr:   &net.IPNet{IP: net.ParseIP("172.18.0.2"), Mask: net.CIDRMask(16, 32)},
+		addrv6: &net.IPNet{IP: net.ParseIP("fd00::2"), Mask: net.CIDRMask(64, 128)},
+	}
+	rules, rules6 := throttleRules("br0", ep, 2048)
+	expected := [][]string{
+		{"-i", "br0", "-s", "172.18.0.2", "-m", "hashlimit", "--hashlimit-above", "2kb/s", "--hashlimit-name", "threp12345o", "-m", "comment", "--comment", "lnet:bridge:ep1234567890", "-j", "DROP"},
+		{"-o", "br0", "-d", "172.18.0.2", "-m", "hashlimit", "--hashlimit-above", "2kb/s", "--hashlimit-name", "threp12345i", "-m", "comment", "--comment", "lnet:bridge:ep1234567890", "-j", "DROP"},
+	}
+	if !reflect.DeepEqual(rules, expected) {
+		t.Fatalf("unexpected rules:\n%v\nexpected:\n%v", rules, expected)
+	}
+	if len(rules6) != 2 || rules6[0].chain != "FORWARD" || rules6[1].args[9] != "threp12345i6" {
+		t.Fatalf("unexpected IPv6 rules %v", rules6)
+	}
+}
+
+func TestThrottleEndpoint(t *testing.T) {
+	d := newDriver()
+	d.config = &configuration{}
+	ep := &bridgeEndpoint{id: "ep1", addr: &net.IPNet{IP: net.ParseIP("172.18.0.2"), Mask: net.CIDRMask(16, 32)}}
+	n := &bridgeNetwork{id: "net1", config: &networkConfiguration{BridgeName: "br0"},
+		endpoints: map[string]*bridgeEndpoint{ep.id: ep}, driver: d}
+	d.networks[n.id] = n
+
+	if err := d.ThrottleEndpoint("net1", "ep1", 1024); err == nil {
+		t.Fatal("throttle accepted without iptables")
+	} else if _, ok := err.(types.NotImplementedError); !ok {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if n.throttleOf(ep) != 0 {
+		t.Fatal("the endpoint is throttled after a failure")
+	}
+}
@@ -0,0 +1,632 @@
+package bridge
+
+import (
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/docker/libnetwork/driverapi"
+	"github.com/docker/libnetwork/iptables"
+	"github.com/docker/libnetwork/portmapper"
+	"github.com/docker/libnetwork/types"
+)
+
+// userChain is the filter chain, jumped to from the top of FORWARD, where
+// the users put their own rules
+const userChain = "DOCKER-USER"
+
+// verdictRule is a rule of the chain model walked by the simulations
+type verdictRule struct {
+	table iptables.Table
+	chain string
+	args  []string
+}
+
+// verdictNetwork is the snapshot of a network the simulations use
+type verdictNetwork struct {
+	bridgeName string
+	subnet     *net.IPNet
+	gateway    net.IP
+	internal   bool
+	icc        bool
+	mcRouter   bool
+	endpoints  []*bridgeEndpoint
+	// hostAccess holds the host access options of the network
+	hostAccess *networkConfiguration
+	// held are the endpoints in their bootstrap window
+	held map[string]bool
+	// quarantined are the actions of the quarantined endpoints
+	quarantined map[string]string
+	// filters are the filter policies of the filtered endpoints
+	filters map[string]*driverapi.FilterPolicy
+	// profile is the connectivity profile of the network, if any
+	profile string
+}
+
+// verdictModel is the snapshot of the driver state the rules are generated
+// from
+type verdictModel struct {
+	networks   []*verdictNetwork
+	hairpin    bool
+	dropPolicy bool
+	// hostOwners tells the bootstrap rules let the replies to the exempt
+	// host owners through
+	hostOwners bool
+	// routing are the inter-network rules heading the first isolation
+	// stage
+	routing [][]string
+}
+
+func (m *verdictModel) networkOf(ip net.IP) *verdictNetwork {
+	for _, n := range m.networks {
+		if n.subnet != nil && n.subnet.Contains(ip) {
+			return n
+		}
+	}
+	return nil
+}
+
+func (n *verdictNetwork) endpointOf(ip net.IP) *bridgeEndpoint {
+	if n == nil {
+		return nil
+	}
+	for _, ep := range n.endpoints {
+		if ep.addr != nil && ep.addr.IP.Equal(ip) {
+			return ep
+		}
+	}
+	return nil
+}
+
+func (n *verdictNetwork) bridge() string {
+	if n == nil {
+		return ""
+	}
+	return n.bridgeName
+}
+
+// SimulateVerdict walks the rules the driver generates for a new flow to or
+// from the endpoint. The rules come from the driver state, the kernel is not
+// queried, so the rules added by hand are not taken into account.
+func (d *driver) SimulateVerdict(nid, eid string, flow *driverapi.Flow) (*driverapi.Verdict, error) {
+	n, err := d.getNetwork(nid)
+	if err != nil {
+		return nil, err
+	}
+	ep, err := n.getEndpoint(eid)
+	if err != nil {
+		return nil, err
+	}
+	if ep == nil {
+		return nil, EndpointNotFoundError(eid)
+	}
+
+	d.Lock()
+	config := d.config
+	d.Unlock()
+	if !config.EnableIPTables {
+		return &driverapi.Verdict{
+			Verdict: "ACCEPT",
+			Steps:   []driverapi.VerdictStep{{Table: string(iptables.Filter), Chain: "FORWARD", Note: "iptables is disabled, no rules are generated"}},
+		}, nil
+	}
+
+	m := &verdictModel{
+		hairpin:    !config.EnableUserlandProxy,
+		dropPolicy: config.EnableIPForwarding,
+		hostOwners: hasHostOwners(config),
+	}
+	d.routingMu.Lock()
+	m.routing = routingRules(d.interNetworkRules, d.routingBridges())
+	d.routingMu.Unlock()
+	var self *verdictNetwork
+	for _, bn := range d.getNetworks() {
+		bn.Lock()
+		vn := &verdictNetwork{
+			bridgeName: bn.config.BridgeName,
+			internal:   bn.config.Internal,
+			icc:        bn.config.EnableICC,
+			mcRouter:   bn.config.MulticastRouter,
+			profile:    bn.config.ConnectivityProfile,
+			hostAccess: &networkConfiguration{
+				BridgeName:      bn.config.BridgeName,
+				HostAccess:      bn.config.HostAccess,
+				HostAccessPorts: bn.config.HostAccessPorts,
+			},
+		}
+		if bn.bridge != nil && bn.bridge.bridgeIPv4 != nil {
+			vn.subnet = &net.IPNet{IP: bn.bridge.bridgeIPv4.IP.Mask(bn.bridge.bridgeIPv4.Mask), Mask: bn.bridge.bridgeIPv4.Mask}
+			vn.gateway = bn.bridge.bridgeIPv4.IP
+		}
+		for _, e := range bn.endpoints {
+			vn.endpoints = append(vn.endpoints, e)
+			if e.awaitJoin || e.awaitReady {
+				if vn.held == nil {
+					vn.held = map[string]bool{}
+				}
+				vn.held[e.id] = true
+			}
+			if e.quarantine != "" {
+				if vn.quarantined == nil {
+					vn.quarantined = map[string]string{}
+				}
+				vn.quarantined[e.id] = e.quarantine
+			}
+			if e.filter != nil {
+				if vn.filters == nil {
+					vn.filters = map[string]*driverapi.FilterPolicy{}
+				}
+				vn.filters[e.id] = e.filter
+			}
+		}
+		bn.Unlock()
+		m.networks = append(m.networks, vn)
+		if bn == n {
+			self = vn
+		}
+	}
+	if self == nil {
+		return nil, types.NotFoundErrorf("network %s not found", nid)
+	}
+
+	return m.simulate(self, ep, flow)
+}
+
+// simulate walks the chains the flow goes through, and returns at the first
+// rule with a terminating target
+func (m *verdictModel) simulate(self *verdictNetwork, ep *bridgeEndpoint, flow *driverapi.Flow) (*driverapi.Verdict, error) {
+	if ep.addr == nil {
+		return nil, types.BadRequestErrorf("endpoint %.7s has no IPv4 address", ep.id)
+	}
+	if flow.Src.To4() == nil || flow.Dst.To4() == nil {
+		return nil, types.NotImplementedErrorf("the simulation of the IPv6 flows is not supported")
+	}
+	switch flow.Proto {
+	case "tcp", "udp", "sctp", "icmp":
+	default:
+		return nil, types.BadRequestErrorf("invalid protocol %q of the simulated flow", flow.Proto)
+	}
+
+	v := &driverapi.Verdict{}
+	pkt := *flow
+	if !pkt.Src.Equal(ep.addr.IP) && !pkt.Dst.Equal(ep.addr.IP) {
+		pb, rule, ok := m.publishedPort(self, ep, &pkt)
+		if !ok {
+			return nil, types.BadRequestErrorf("the flow is neither from nor to endpoint %.7s, nor to one of its published ports", ep.id)
+		}
+		if rule == nil {
+			// Out of the hairpin mode the bridge traffic to the host
+			// addresses does not get translated
+			v.Verdict = "ACCEPT"
+			v.Steps = append(v.Steps, driverapi.VerdictStep{
+				Table: string(iptables.Filter), Chain: "INPUT",
+				Note: "port " + strconv.Itoa(int(pb.HostPort)) + " is reached through the userland proxy, the INPUT rules are not simulated",
+			})
+			return v, nil
+		}
+		v.Steps = append(v.Steps, driverapi.VerdictStep{Table: string(rule.table), Chain: rule.chain, Rule: strings.Join(rule.args, " "), Matched: true})
+		pkt.Dst, pkt.DstPort = ep.addr.IP, int(pb.Port)
+		translated := pkt
+		v.Translated = &translated
+	}
+
+	for _, n := range m.networks {
+		if n.gateway != nil && n.gateway.Equal(pkt.Dst) {
+			in := m.networkOf(pkt.Src)
+			if srcEp := in.endpointOf(pkt.Src); srcEp != nil {
+				chains := map[string][]verdictRule{}
+				add := func(chain string, args ...string) {
+					chains[chain] = append(chains[chain], verdictRule{table: iptables.Filter, chain: chain, args: args})
+				}
+				if action, ok := in.quarantined[srcEp.id]; ok {
+					add("INPUT", "-j", QuarantineChain)
+					for _, r := range in.quarantineRules(srcEp, action) {
+						add(QuarantineChain, r...)
+					}
+				}
+				if in.held[srcEp.id] {
+					add("INPUT", "-j", BootstrapChain)
+					rules, _ := bootstrapRules(in.bridgeName, srcEp, m.hostOwners)
+					for _, r := range rules {
+						add(BootstrapChain, r...)
+					}
+				}
+				if deniesHostAccess(in.hostAccess) {
+					add("INPUT", "-j", HostAccessChain)
+					for _, r := range hostAccessRules(in.hostAccess, srcEp) {
+						add(HostAccessChain, r...)
+					}
+				}
+				if verdict, ok := walkChain(v, chains, "INPUT", &pkt, in.bridgeName, ""); ok {
+					v.Verdict = verdict
+					return v, nil
+				}
+			}
+			v.Verdict = "ACCEPT"
+			v.Steps = append(v.Steps, driverapi.VerdictStep{
+				Table: string(iptables.Filter), Chain: "INPUT",
+				Note: "the flow is delivered to the host, the INPUT rules are not simulated",
+			})
+			return v, nil
+		}
+	}
+
+	in, out := m.networkOf(pkt.Src), m.networkOf(pkt.Dst)
+	chains := m.chains(in, out, &pkt)
+	if verdict, ok := walkChain(v, chains, "FORWARD", &pkt, in.bridge(), out.bridge()); ok {
+		v.Verdict = verdict
+		return v, nil
+	}
+
+	v.Verdict = "ACCEPT"
+	if m.dropPolicy {
+		v.Verdict = "DROP"
+	}
+	v.Steps = append(v.Steps, driverapi.VerdictStep{Table: string(iptables.Filter), Chain: "FORWARD", Rule: "-P FORWARD " + v.Verdict, Matched: true, Note: "policy"})
+	return v, nil
+}
+
+// publishedPort returns the binding of the endpoint the flow is destined to,
+// the DNAT rule translating it unless the flow comes from the bridge out of
+// the hairpin mode
+func (m *verdictModel) publishedPort(self *verdictNetwork, ep *bridgeEndpoint, pkt *driverapi.Flow) (types.PortBinding, *verdictRule, bool) {
+	for _, pb := range ep.portMapping {
+		if pb.Proto.String() != pkt.Proto || int(pb.HostPort) != pkt.DstPort {
+			continue
+		}
+		hostIP := pb.HostIP
+		if hostIP == nil || hostIP.IsUnspecified() {
+			hostIP = net.IPv4zero
+		} else if !hostIP.Equal(pkt.Dst) {
+			continue
+		}
+
+		hairpin := m.hairpin
+		var hairpinPorts map[string]bool
+		if ep.config != nil {
+			hairpinPorts = ep.config.HairpinPorts
+		}
+		switch portHairpin(hairpinPorts, pb) {
+		case portmapper.HairpinOn:
+			hairpin = true
+		case portmapper.HairpinOff:
+			hairpin = false
+		}
+		if !hairpin && self.subnet != nil && self.subnet.Contains(pkt.Src) {
+			return pb, nil, true
+		}
+
+		chain := iptables.ChainInfo{Name: DockerChain, Table: iptables.Nat}
+		r := chain.HairpinForwardRules(hostIP, int(pb.HostPort), pkt.Proto, ep.addr.IP.String(), int(pb.Port), self.bridgeName, hairpin)[0]
+		return pb, &verdictRule{table: r.Table, chain: r.Chain, args: r.Args}, true
+	}
+	return types.PortBinding{}, nil, false
+}
+
+// quarantineRules returns the IPv4 quarantine rules of the endpoint on its
+// addresses. The ones on its veth are left out, the simulated flows are
+// told apart by their addresses only.
+func (n *verdictNetwork) quarantineRules(ep *bridgeEndpoint, action string) [][]string {
+	var rules [][]string
+	all, _ := quarantineRules(n.bridgeName, ep, action)
+	for _, r := range all {
+		if !strings.Contains(strings.Join(r, " "), "physdev") {
+			rules = append(rules, r)
+		}
+	}
+	return rules
+}
+
+// chains renders the filter chains the flow goes through, keyed by name,
+// restricted to the rules of the networks the flow enters and leaves
+func (m *verdictModel) chains(in, out *verdictNetwork, pkt *driverapi.Flow) map[string][]verdictRule {
+	c := map[string][]verdictRule{}
+	add := func(chain string, args ...string) {
+		c[chain] = append(c[chain], verdictRule{table: iptables.Filter, chain: chain, args: args})
+	}
+
+	add("FORWARD", "-j", userChain)
+	add("FORWARD", "-j", QuarantineChain)
+	add("FORWARD", "-j", BootstrapChain)
+	add("FORWARD", "-j", IsolationChain1)
+	add("FORWARD", "-j", ProfileChain)
+	add("FORWARD", "-j", ConnLimitChain)
+	add("FORWARD", "-j", HostAccessChain)
+	add("FORWARD", "-j", ICCChain)
+	add("FORWARD", "-j", FilterChain)
+	if out != nil && !out.internal {
+		add("FORWARD", "-o", out.bridgeName, "-m", "conntrack", "--ctstate", "RELATED,ESTABLISHED", "-j", "ACCEPT")
+		add("FORWARD", "-o", out.bridgeName, "-j", DockerChain)
+		if out.mcRouter {
+			add("FORWARD", multicastForwardRule(out.bridgeName).args...)
+		}
+	}
+	if in != nil && !in.internal {
+		add("FORWARD", outgoingRule(in.bridgeName).args...)
+	}
+	for _, n := range uniqueNetworks(in, out) {
+		target := "DROP"
+		if n.icc {
+			target = "ACCEPT"
+		}
+		add("FORWARD", "-i", n.bridgeName, "-o", n.bridgeName, "-j", target)
+	}
+
+	// The user chain is only returned from, its rules are not known
+	c[userChain] = nil
+
+	for _, r := range m.routing {
+		add(IsolationChain1, r...)
+	}
+
+	for _, n := range uniqueNetworks(in, out) {
+		if n.internal {
+			add(IsolationChain1, "-i", n.bridgeName, "!", "-d", n.subnet.String(), "-j", "DROP")
+			add(IsolationChain1, "-o", n.bridgeName, "!", "-s", n.subnet.String(), "-j", "DROP")
+		}
+	}
+	if in != nil && !in.internal {
+		add(IsolationChain1, "-i", in.bridgeName, "!", "-o", in.bridgeName, "-j", IsolationChain2)
+	}
+	if out != nil && !out.internal {
+		add(IsolationChain2, "-o", out.bridgeName, "-j", "DROP")
+	}
+
+	for _, n := range uniqueNetworks(in, out) {
+		for _, r := range profileRules(n.bridgeName, n.profile) {
+			add(ProfileChain, r...)
+		}
+	}
+
+	srcEp, dstEp := in.endpointOf(pkt.Src), out.endpointOf(pkt.Dst)
+	if dstEp != nil && hasConnLimits(dstEp) {
+		for _, r := range connLimitRules(out.bridgeName, dstEp) {
+			add(ConnLimitChain, r...)
+		}
+	}
+	if dstEp != nil && out.filters[dstEp.id] != nil {
+		for _, r := range filterRules(out.bridgeName, dstEp, out.filters[dstEp.id], filterIPv4) {
+			add(FilterChain, r...)
+		}
+	}
+
+	for _, p := range []struct {
+		n  *verdictNetwork
+		ep *bridgeEndpoint
+	}{{in, srcEp}, {out, dstEp}} {
+		if p.ep == nil {
+			continue
+		}
+		if action, ok := p.n.quarantined[p.ep.id]; ok {
+			for _, r := range p.n.quarantineRules(p.ep, action) {
+				add(QuarantineChain, r...)
+			}
+		}
+		if p.n.held[p.ep.id] {
+			rules, _ := bootstrapRules(p.n.bridgeName, p.ep, m.hostOwners)
+			for _, r := range rules {
+				add(BootstrapChain, r...)
+			}
+		}
+	}
+
+	// The forwarded flows are not to the host addresses, only the rules on
+	// the link-local addresses apply
+	if srcEp != nil && deniesHostAccess(in.hostAccess) {
+		for _, r := range hostAccessRules(in.hostAccess, srcEp) {
+			if !strings.Contains(strings.Join(r, " "), "addrtype") {
+				add(HostAccessChain, r...)
+			}
+		}
+	}
+
+	if in != nil && in == out {
+		var drops [][]string
+		for _, ep := range []*bridgeEndpoint{srcEp, dstEp} {
+			if ep == nil || !hasICCGroups(ep) {
+				continue
+			}
+			returns, d := iccGroupRules(in.bridgeName, ep, in.endpoints)
+			for _, r := range returns {
+				add(ICCChain, r...)
+			}
+			drops = append(drops, d...)
+		}
+		for _, r := range drops {
+			add(ICCChain, r...)
+		}
+	}
+
+	if out != nil && dstEp != nil {
+		chain := iptables.ChainInfo{Name: DockerChain, Table: iptables.Filter}
+		for _, pb := range dstEp.portMapping {
+			r := chain.ForwardRules(net.IPv4zero, int(pb.HostPort), pb.Proto.String(), dstEp.addr.IP.String(), int(pb.Port), out.bridgeName)[1]
+			add(DockerChain, r.Args...)
+		}
+		if srcEp != nil && in == out {
+			for _, l := range linkedPorts(srcEp, dstEp) {
+				for _, r := range chain.LinkRules(l.parent, l.child, int(l.port.Port), l.port.Proto.String(), out.bridgeName) {
+					add(DockerChain, r.Args...)
+				}
+			}
+		}
+	}
+	return c
+}
+
+type linkedPort struct {
+	parent, child net.IP
+	port          types.TransportPort
+}
+
+// linkedPorts returns the exposed ports of the link between the endpoints,
+// whichever is the parent
+func linkedPorts(ep1, ep2 *bridgeEndpoint) []linkedPort {
+	var ports []linkedPort
+	for _, p := range []struct{ parent, child *bridgeEndpoint }{{ep1, ep2}, {ep2, ep1}} {
+		if p.child.containerConfig == nil || p.child.extConnConfig == nil {
+			continue
+		}
+		linked := false
+		for _, id := range p.child.containerConfig.ParentEndpoints {
+			linked = linked || id == p.parent.id
+		}
+		if p.parent.containerConfig != nil {
+			for _, id := range p.parent.containerConfig.ChildEndpoints {
+				linked = linked || id == p.child.id
+			}
+		}
+		if !linked {
+			continue
+		}
+		for _, tp := range p.child.extConnConfig.ExposedPorts {
+			ports = append(ports, linkedPort{parent: p.parent.addr.IP, child: p.child.addr.IP, port: tp})
+		}
+	}
+	return ports
+}
+
+func uniqueNetworks(in, out *verdictNetwork) []*verdictNetwork {
+	var nets []*verdictNetwork
+	if in != nil {
+		nets = append(nets, in)
+	}
+	if out != nil && out != in {
+		nets = append(nets, out)
+	}
+	return nets
+}
+
+// walkChain walks the rules of the chain, and of the chains they jump to.
+// It returns the target of the first terminating rule matched, false when
+// the packet falls through the chain or returns from it.
+func walkChain(v *driverapi.Verdict, chains map[string][]verdictRule, chain string, pkt *driverapi.Flow, inIf, outIf string) (string, bool) {
+	for _, r := range chains[chain] {
+		matched, target, note := matchRule(r.args, pkt, inIf, outIf)
+		step := driverapi.VerdictStep{Table: string(r.table), Chain: r.chain, Rule: strings.Join(r.args, " "), Matched: matched, Note: note}
+		if target == userChain {
+			step.Note = "the user rules are not simulated"
+		}
+		v.Steps = append(v.Steps, step)
+		if !matched {
+			continue
+		}
+		switch target {
+		case "ACCEPT", "DROP", "REJECT":
+			return target, true
+		case "RETURN":
+			return "", false
+		}
+		if _, ok := chains[target]; ok {
+			if verdict, ok := walkChain(v, chains, target, pkt, inIf, outIf); ok {
+				return verdict, true
+			}
+		}
+	}
+	return "", false
+}
+
+// matchRule evaluates the matches of the rule against the packet of a new
+// flow, entering through inIf and leaving through outIf. The matches on the
+// rate and the count of the connections cannot be evaluated, the rules
+// with them are reported as not matched, with a note. The time windows are
+// taken as open, with a note.
+func matchRule(args []string, pkt *driverapi.Flow, inIf, outIf string) (bool, string, string) {
+	var (
+		matched = true
+		negate  bool
+		target  string
+		note    string
+	)
+	for i := 0; i < len(args); i++ {
+		var (
+			cond  = true
+			known = true
+		)
+		next := func() string {
+			if i+1 < len(args) {
+				i++
+				return args[i]
+			}
+			return ""
+		}
+		switch args[i] {
+		case "!":
+			negate = true
+			continue
+		case "-i":
+			cond = next() == inIf
+		case "-o":
+			cond = next() == outIf
+		case "-s":
+			cond = matchAddr(next(), pkt.Src)
+		case "-d":
+			cond = matchAddr(next(), pkt.Dst)
+		case "-p":
+			cond = next() == pkt.Proto
+		case "--sport":
+			cond = matchPort(next(), pkt.SrcPort)
+		case "--dport":
+			cond = matchPort(next(), pkt.DstPort)
+		case "--syn":
+			cond = pkt.Proto == "tcp"
+		case "--ctstate":
+			cond = strings.Contains(next(), "NEW")
+			if !cond {
+				note = "the flow is simulated as new"
+			}
+		case "-m":
+			switch next() {
+			case "connlimit":
+				note = "matches the sources past the connection limit"
+			case "hashlimit":
+				note = "matches the sources past the connection rate"
+			case "time":
+				note = "the time window of the rule is not checked"
+			}
+		case "-j":
+			target = next()
+			i = len(args)
+		default:
+			// The options of the modules
+			known = false
+			if strings.HasPrefix(args[i], "--") {
+				next()
+			}
+		}
+		if known && cond == negate {
+			matched = false
+		}
+		negate = false
+	}
+	if matched && strings.HasPrefix(note, "matches") {
+		matched = false
+	}
+	return matched, target, note
+}
+
+func matchAddr(s string, ip net.IP) bool {
+	if s == "0/0" {
+		return true
+	}
+	if !strings.Contains(s, "/") {
+		return net.ParseIP(s).Equal(ip)
+	}
+	_, nw, err := net.ParseCIDR(s)
+	return err == nil && nw.Contains(ip)
+}
+
+func matchPort(s string, port int) bool {
+	parts := strings.SplitN(s, ":", 2)
+	low, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return false
+	}
+	high := low
+	if len(parts) == 2 {
+		if high, err = strconv.Atoi(parts[1]); err != nil {
+			return false
+		}
+	}
+	return port >= low && port <= high
+}
@@ -0,0 +1,154 @@
+package bridge
+
+import (
+	"net"
+	"testing"
+
+	"github.com/docker/libnetwork/driverapi"
+	"github.com/docker/libnetwork/types"
+)
+
+func TestSimulateVerdict(t *testing.T) {
+	newEp := func(id, ip string, ec *endpointConfiguration) *bridgeEndpoint {
+		return &bridgeEndpoint{id: id, addr: &net.IPNet{IP: net.ParseIP(ip), Mask: net.CIDRMask(24, 32)}, config: ec}
+	}
+	ep1 := newEp("ep1", "172.20.0.2", &endpointConfiguration{})
+	ep1.portMapping = []types.PortBinding{{Proto: types.TCP, IP: ep1.addr.IP, Port: 80, HostPort: 8080}}
+	ep2 := newEp("ep2", "172.20.0.3", &endpointConfiguration{})
+	grouped := newEp("ep3", "172.20.0.4", &endpointConfiguration{ICCGroups: []string{"db"}})
+	other := newEp("ep4", "172.21.0.2", &endpointConfiguration{})
+	internal := newEp("ep5", "172.22.0.2", &endpointConfiguration{})
+
+	_, sub1, _ := net.ParseCIDR("172.20.0.0/24")
+	_, sub2, _ := net.ParseCIDR("172.21.0.0/24")
+	_, sub3, _ := net.ParseCIDR("172.22.0.0/24")
+	n1 := &verdictNetwork{bridgeName: "br1", subnet: sub1, gateway: net.ParseIP("172.20.0.1"), endpoints: []*bridgeEndpoint{ep1, ep2, grouped}}
+	n2 := &verdictNetwork{bridgeName: "br2", subnet: sub2, gateway: net.ParseIP("172.21.0.1"), icc: true, endpoints: []*bridgeEndpoint{other},
+		hostAccess: &networkConfiguration{BridgeName: "br2", HostAccess: hostAccessDeny, HostAccessPorts: []string{"udp/53"}}}
+	n3 := &verdictNetwork{bridgeName: "br3", subnet: sub3, gateway: net.ParseIP("172.22.0.1"), internal: true, icc: true, endpoints: []*bridgeEndpoint{internal}}
+	held := newEp("ep6", "172.22.0.3", &endpointConfiguration{})
+	n3.endpoints = append(n3.endpoints, held)
+	n3.held = map[string]bool{held.id: true}
+	quarantined := newEp("ep7", "172.20.0.5", &endpointConfiguration{})
+	n1.endpoints = append(n1.endpoints, quarantined)
+	n1.quarantined = map[string]string{quarantined.id: driverapi.QuarantineReject}
+	filtered := newEp("ep8", "172.21.0.3", &endpointConfiguration{})
+	n2.endpoints = append(n2.endpoints, filtered)
+	n2.filters = map[string]*driverapi.FilterPolicy{filtered.id: {Version: "v1", Allow: []driverapi.FilterRule{{Proto: "tcp", Ports: "80"}}}}
+	profiled := newEp("ep9", "172.23.0.2", &endpointConfiguration{})
+	_, sub4, _ := net.ParseCIDR("172.23.0.0/24")
+	n4 := &verdictNetwork{bridgeName: "br4", subnet: sub4, gateway: net.ParseIP("172.23.0.1"), profile: "datacenter", endpoints: []*bridgeEndpoint{profiled}}
+	m := &verdictModel{networks: []*verdictNetwork{n1, n2, n3, n4}, hairpin: true, dropPolicy: true}
+
+	for _, c := range []struct {
+		name    string
+		self    *verdictNetwork
+		ep      *bridgeEndpoint
+		flow    driverapi.Flow
+		verdict string
+		rule    string
+	}{
+		{"icc disabled", n1, ep1, driverapi.Flow{Proto: "tcp", Src: ep1.addr.IP, SrcPort: 40000, Dst: ep2.addr.IP, DstPort: 80},
+			"DROP", "-i br1 -o br1 -j DROP"},
+		{"outgoing", n1, ep1, driverapi.Flow{Proto: "udp", Src: ep1.addr.IP, SrcPort: 40000, Dst: net.ParseIP("8.8.8.8"), DstPort: 53},
+			"ACCEPT", "-i br1 ! -o br1 -j ACCEPT"},
+		{"published port", n1, ep1, driverapi.Flow{Proto: "tcp", Src: net.ParseIP("10.0.0.1"), SrcPort: 40000, Dst: net.ParseIP("192.168.1.1"), DstPort: 8080},
+			"ACCEPT", "! -i br1 -o br1 -p tcp -d 172.20.0.2 --dport 80 -j ACCEPT"},
+		{"unpublished port", n1, ep1, driverapi.Flow{Proto: "tcp", Src: net.ParseIP("10.0.0.1"), SrcPort: 40000, Dst: ep1.addr.IP, DstPort: 22},
+			"DROP", "-P FORWARD DROP"},
+		{"isolated networks", n1, ep1, driverapi.Flow{Proto: "tcp", Src: ep1.addr.IP, SrcPort: 40000, Dst: other.addr.IP, DstPort: 80},
+			"DROP", "-o br2 -j DROP"},
+		{"icc group", n1, grouped, driverapi.Flow{Proto: "tcp", Src: ep2.addr.IP, SrcPort: 40000, Dst: grouped.addr.IP, DstPort: 5432},
+			"DROP", "-i br1 -o br1 -d 172.20.0.4 -m comment --comment lnet:bridge:ep3 -m comment --comment lnet-tier:default -j DROP"},
+		{"internal network", n3, internal, driverapi.Flow{Proto: "icmp", Src: internal.addr.IP, Dst: net.ParseIP("8.8.8.8")},
+			"DROP", "-i br3 ! -d 172.22.0.0/24 -j DROP"},
+		{"host", n1, ep1, driverapi.Flow{Proto: "icmp", Src: ep1.addr.IP, Dst: n1.gateway},
+			"ACCEPT", ""},
+		{"bootstrap window", n3, held, driverapi.Flow{Proto: "tcp", Src: internal.addr.IP, SrcPort: 40000, Dst: held.addr.IP, DstPort: 80},
+			"DROP", "-o br3 -d 172.22.0.3 -m comment --comment lnet:bridge:ep6 -j DROP"},
+		{"bootstrap window to the host", n3, held, driverapi.Flow{Proto: "udp", Src: held.addr.IP, SrcPort: 40000, Dst: n3.gateway, DstPort: 53},
+			"DROP", "-i br3 -s 172.22.0.3 -m comment --comment lnet:bridge:ep6 -j DROP"},
+		{"quarantine", n1, quarantined, driverapi.Flow{Proto: "tcp", Src: quarantined.addr.IP, SrcPort: 40000, Dst: net.ParseIP("8.8.8.8"), DstPort: 443},
+			"REJECT", "-i br1 -s 172.20.0.5 -m comment --comment lnet:bridge:ep7 -j REJECT --reject-with icmp-admin-prohibited"},
+		{"quarantine to the host", n1, quarantined, driverapi.Flow{Proto: "udp", Src: quarantined.addr.IP, SrcPort: 40000, Dst: n1.gateway, DstPort: 53},
+			"REJECT", "-i br1 -s 172.20.0.5 -m comment --comment lnet:bridge:ep7 -j REJECT --reject-with icmp-admin-prohibited"},
+		{"host access denied", n2, other, driverapi.Flow{Proto: "tcp", Src: other.addr.IP, SrcPort: 40000, Dst: n2.gateway, DstPort: 22},
+			"REJECT", "-i br2 -s 172.21.0.2 -m addrtype --dst-type LOCAL -m comment --comment lnet:bridge:ep4 -m comment --comment lnet-tier:default -j REJECT --reject-with icmp-admin-prohibited"},
+		{"host access port", n2, other, driverapi.Flow{Proto: "udp", Src: other.addr.IP, SrcPort: 40000, Dst: n2.gateway, DstPort: 53},
+			"ACCEPT", ""},
+		{"metadata service", n2, other, driverapi.Flow{Proto: "tcp", Src: other.addr.IP, SrcPort: 40000, Dst: net.ParseIP("169.254.169.254"), DstPort: 80},
+			"REJECT", "-i br2 -s 172.21.0.2 -d 169.254.0.0/16 -m comment --comment lnet:bridge:ep4 -m comment --comment lnet-tier:platform -j REJECT --reject-with icmp-admin-prohibited"},
+		{"filter policy", n2, filtered, driverapi.Flow{Proto: "tcp", Src: other.addr.IP, SrcPort: 40000, Dst: filtered.addr.IP, DstPort: 22},
+			"REJECT", "-o br2 -d 172.21.0.3 -m comment --comment lnet:bridge:ep8 -m comment --comment lnet-tier:default -m comment --comment lnet-filter:v1 -j REJECT --reject-with icmp-admin-prohibited"},
+		{"filter policy allowed", n2, filtered, driverapi.Flow{Proto: "tcp", Src: other.addr.IP, SrcPort: 40000, Dst: filtered.addr.IP, DstPort: 80},
+			"ACCEPT", "-i br2 -o br2 -j ACCEPT"},
+		{"connectivity profile", n4, profiled, driverapi.Flow{Proto: "tcp", Src: profiled.addr.IP, SrcPort: 40000, Dst: net.ParseIP("8.8.8.8"), DstPort: 443},
+			"REJECT", "-i br4 ! -o br4 -j REJECT --reject-with icmp-admin-prohibited"},
+		{"connectivity profile allowed", n4, profiled, driverapi.Flow{Proto: "tcp", Src: profiled.addr.IP, SrcPort: 40000, Dst: net.ParseIP("10.1.2.3"), DstPort: 443},
+			"ACCEPT", "-i br4 ! -o br4 -j ACCEPT"},
+		{"connectivity profile ingress", n4, profiled, driverapi.Flow{Proto: "tcp", Src: net.ParseIP("8.8.8.8"), SrcPort: 40000, Dst: profiled.addr.IP, DstPort: 443},
+			"DROP", "-o br4 ! -i br4 -j DROP"},
+	} {
+		v, err := m.simulate(c.self, c.ep, &c.flow)
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		last := v.Steps[len(v.Steps)-1]
+		if v.Verdict != c.verdict || last.Rule != c.rule || (c.rule != "" && !last.Matched) {
+			t.Fatalf("%s: unexpected verdict %s by %q, expected %s by %q: %+v", c.name, v.Verdict, last.Rule, c.verdict, c.rule, v.Steps)
+		}
+	}
+
+	// The connections from br1 to br2 are allowed, not the ones back
+	m.routing = routingRules([]driverapi.InterNetworkRule{{From: driverapi.InterNetworkEnd{NetworkID: "n1"}, To: driverapi.InterNetworkEnd{NetworkID: "n2"}}},
+		map[string]string{"n1": "br1", "n2": "br2"})
+	v, err := m.simulate(n1, ep1, &driverapi.Flow{Proto: "tcp", Src: ep1.addr.IP, SrcPort: 40000, Dst: other.addr.IP, DstPort: 80})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last := v.Steps[len(v.Steps)-1]; v.Verdict != "ACCEPT" || last.Rule != "-i br1 ! -o br1 -j ACCEPT" {
+		t.Fatalf("unexpected verdict %s of the allowed inter-network flow: %+v", v.Verdict, v.Steps)
+	}
+	v, err = m.simulate(n2, other, &driverapi.Flow{Proto: "tcp", Src: other.addr.IP, SrcPort: 40000, Dst: ep1.addr.IP, DstPort: 80})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last := v.Steps[len(v.Steps)-1]; v.Verdict != "DROP" || last.Rule != "-o br1 -j DROP" {
+		t.Fatalf("unexpected verdict %s of the reverse inter-network flow: %+v", v.Verdict, v.Steps)
+	}
+	m.routing = nil
+
+	v, err = m.simulate(n1, ep1, &driverapi.Flow{Proto: "tcp", Src: net.ParseIP("10.0.0.1"), SrcPort: 40000, Dst: net.ParseIP("192.168.1.1"), DstPort: 8080})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Translated == nil || !v.Translated.Dst.Equal(ep1.addr.IP) || v.Translated.DstPort != 80 {
+		t.Fatalf("unexpected translation %+v", v.Translated)
+	}
+
+	if _, err := m.simulate(n1, ep1, &driverapi.Flow{Proto: "tcp", Src: net.ParseIP("10.0.0.1"), Dst: net.ParseIP("10.0.0.2"), DstPort: 80}); err == nil {
+		t.Fatal("expected an error simulating a flow not involving the endpoint")
+	}
+	if _, err := m.simulate(n1, ep1, &driverapi.Flow{Proto: "gre", Src: ep1.addr.IP, Dst: ep2.addr.IP}); err == nil {
+		t.Fatal("expected an error simulating an invalid protocol")
+	}
+}
+
+func TestMatchRule(t *testing.T) {
+	pkt := &driverapi.Flow{Proto: "tcp", Src: net.ParseIP("10.0.0.1"), SrcPort: 40000, Dst: net.ParseIP("172.20.0.2"), DstPort: 80}
+	for _, c := range []struct {
+		args    []string
+		matched bool
+	}{
+		{[]string{"-o", "br1", "-p", "tcp", "--dport", "80:90", "-j", "ACCEPT"}, true},
+		{[]string{"!", "-i", "br1", "-o", "br1", "-j", "ACCEPT"}, true},
+		{[]string{"-i", "br1", "-j", "ACCEPT"}, false},
+		{[]string{"-s", "10.0.0.0/8", "!", "-d", "172.20.0.2", "-j", "DROP"}, false},
+		{[]string{"-o", "br1", "-m", "conntrack", "--ctstate", "RELATED,ESTABLISHED", "-j", "ACCEPT"}, false},
+		{[]string{"-o", "br1", "-p", "tcp", "--syn", "-m", "connlimit", "--connlimit-above", "10", "-j", "REJECT", "--reject-with", "tcp-reset"}, false},
+	} {
+		if matched, _, _ := matchRule(c.args, pkt, "", "br1"); matched != c.matched {
+			t.Fatalf("unexpected match %v of %v", matched, c.args)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package bridge
+
+import (
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// deferredCleanup is a deleted endpoint whose veth waits for the quiesce
+// period to be over before being removed
+type deferredCleanup struct {
+	ep    *bridgeEndpoint
+	timer *time.Timer
+}
+
+// deferCleanup quiesces the veth of the deleted endpoint, down and out of
+// the bridge so that no more traffic flows through it while its counters
+// stay readable, and schedules its removal. The endpoint stays in the store
+// until then, for the removal to complete after a daemon restart. The
+// rules matching the endpoint address are not deferred, the address may
+// be handed out again before the end of the quiesce period.
+func (d *driver) deferCleanup(ep *bridgeEndpoint, delay time.Duration) {
+	if ep.hostIfName != "" {
+		if link, err := d.nlh.LinkByName(ep.hostIfName); err == nil {
+			if err := d.nlh.LinkSetNoMaster(link); err != nil {
+				logrus.Debugf("Failed to detach interface %s of deleted endpoint %.7s from the bridge: %v", ep.hostIfName, ep.id, err)
+			}
+			if err := d.nlh.LinkSetDown(link); err != nil {
+				logrus.Debugf("Failed to bring down interface %s of deleted endpoint %.7s: %v", ep.hostIfName, ep.id, err)
+			}
+		}
+	}
+
+	ep.cleanupAt = time.Now().Add(delay)
+	if err := d.storeUpdate(ep); err != nil {
+		logrus.Warnf("Failed to mark bridge endpoint %.7s for deferred cleanup in store: %v", ep.id, err)
+	}
+
+	dc := &deferredCleanup{ep: ep}
+	d.cleanupMu.Lock()
+	if d.pendingCleanups == nil {
+		d.pendingCleanups = make(map[string]*deferredCleanup)
+	}
+	d.pendingCleanups[ep.id] = dc
+	dc.timer = time.AfterFunc(delay, func() { d.runCleanup(ep.id) })
+	d.cleanupMu.Unlock()
+
+	logrus.Debugf("Deferred the cleanup of bridge endpoint %.7s by %s", ep.id, delay)
+}
+
+// runCleanup removes the resources of the pending endpoint, unless the
+// cleanup was already run
+func (d *driver) runCleanup(eid string) {
+	d.cleanupMu.Lock()
+	dc, ok := d.pendingCleanups[eid]
+	if ok {
+		delete(d.pendingCleanups, eid)
+		dc.timer.Stop()
+	}
+	d.cleanupMu.Unlock()
+
+	if ok {
+		d.cleanupEndpoint(dc.ep)
+	}
+}
+
+// cleanupEndpoint removes the veth, the mirror tunnel and the store record
+// of the deleted endpoint
+func (d *driver) cleanupEndpoint(ep *bridgeEndpoint) {
+	// Try removal of link. Discard error: it is a best effort.
+	if link, err := d.nlh.LinkByName(ep.srcName); err == nil {
+		if err := d.nlh.LinkDel(link); err != nil {
+			logrus.WithError(err).Errorf("Failed to delete interface (%s)'s link on endpoint (%s) delete", ep.srcName, ep.id)
+		}
+	}
+	removeMirrorLink(d.nlh, ep.id)
+	removeXSKMap(ep.id)
+
+	if err := d.storeDelete(ep); err != nil {
+		logrus.Warnf("Failed to remove bridge endpoint %.7s from store: %v", ep.id, err)
+	}
+}
+
+// forceCleanups runs the pending cleanups of the network, or of all the
+// networks when nid is empty, right away and returns how many were run
+func (d *driver) forceCleanups(nid string) int {
+	d.cleanupMu.Lock()
+	var eids []string
+	for eid, dc := range d.pendingCleanups {
+		if nid == "" || dc.ep.nid == nid {
+			eids = append(eids, eid)
+		}
+	}
+	d.cleanupMu.Unlock()
+
+	for _, eid := range eids {
+		d.runCleanup(eid)
+	}
+	return len(eids)
+}
+
+// ForceCleanup removes the resources of the deleted endpoints waiting for
+// their quiesce period to be over
+func (d *driver) ForceCleanup() int {
+	return d.forceCleanups("")
+}
@@ -0,0 +1,39 @@
+package bridge
+
+import (
+	"testing"
+	"time"
+
+	"github.com/docker/libnetwork/ns"
+	"github.com/docker/libnetwork/testutils"
+)
+
+func TestDeferredCleanup(t *testing.T) {
+	if !testutils.IsRunningInContainer() {
+		defer testutils.SetupTestOSContext(t)()
+	}
+	d := newDriver()
+	d.nlh = ns.NlHandle()
+
+	d.deferCleanup(&bridgeEndpoint{id: "ep1", nid: "n1", srcName: "vethtest1"}, time.Hour)
+	d.deferCleanup(&bridgeEndpoint{id: "ep2", nid: "n2", srcName: "vethtest2"}, time.Hour)
+
+	if n := d.forceCleanups("n3"); n != 0 {
+		t.Fatalf("expected no cleanup for an unknown network, got %d", n)
+	}
+	if n := d.forceCleanups("n1"); n != 1 {
+		t.Fatalf("expected the cleanup of the network endpoint, got %d", n)
+	}
+	if _, ok := d.pendingCleanups["ep1"]; ok {
+		t.Fatal("expected the cleanup of ep1 to be done")
+	}
+
+	// A cleanup runs once, the timer firing after the forced one does nothing
+	d.runCleanup("ep1")
+	if n := d.ForceCleanup(); n != 1 {
+		t.Fatalf("expected the cleanup of the remaining endpoint, got %d", n)
+	}
+	if len(d.pendingCleanups) != 0 {
+		t.Fatalf("unexpected pending cleanups %v", d.pendingCleanups)
+	}
+}
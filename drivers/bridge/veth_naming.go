@@ -0,0 +1,95 @@
+package bridge
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/docker/libnetwork/netlabel"
+	"github.com/docker/libnetwork/netutils"
+	"github.com/docker/libnetwork/types"
+	"github.com/sirupsen/logrus"
+	"github.com/vishvananda/netlink"
+)
+
+// Naming schemes of the host side veths
+const (
+	// VethNamingRandom names the veths with random suffixes, the default
+	VethNamingRandom = "random"
+	// VethNamingEndpoint names the veths after the endpoint id
+	VethNamingEndpoint = "endpoint"
+	// VethNamingName names the veths after the endpoint name, which is
+	// the container name for the containers
+	VethNamingName = "name"
+)
+
+// maxIfNameLen is the length of the interface names, IFNAMSIZ less the
+// terminating NUL
+const maxIfNameLen = 15
+
+var (
+	vethPrefixRe   = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9_-]{0,7}$`)
+	ifNameUnsafeRe = regexp.MustCompile(`[^a-zA-Z0-9_.-]`)
+)
+
+func validateVethNaming(config *networkConfiguration) error {
+	switch config.VethNaming {
+	case "", VethNamingRandom, VethNamingEndpoint, VethNamingName:
+	default:
+		return types.BadRequestErrorf("invalid veth naming %q", config.VethNaming)
+	}
+	if config.VethPrefix != "" && !vethPrefixRe.MatchString(config.VethPrefix) {
+		return types.BadRequestErrorf("invalid veth prefix %q: expected a letter and up to 7 letters, digits, _ or -", config.VethPrefix)
+	}
+	return nil
+}
+
+// hostVethName returns the name of the host side veth of the endpoint, as
+// per the naming of the network. The names taken by other interfaces are
+// given a numbered suffix, the random naming is the last resort.
+func hostVethName(nlh *netlink.Handle, config *networkConfiguration, eid string, epOptions map[string]interface{}) (string, error) {
+	prefix := vethPrefix
+	if config.VethPrefix != "" {
+		prefix = config.VethPrefix
+	}
+
+	var base string
+	switch config.VethNaming {
+	case VethNamingEndpoint:
+		base = eid
+	case VethNamingName:
+		if name, ok := epOptions[netlabel.EndpointName].(string); ok {
+			base = ifNameUnsafeRe.ReplaceAllString(name, "")
+		}
+	}
+	if base == "" {
+		return netutils.GenerateIfaceName(nlh, prefix, vethLen)
+	}
+
+	linkByName := netlink.LinkByName
+	if nlh != nil {
+		linkByName = nlh.LinkByName
+	}
+	name := ifName(prefix, base, "")
+	for i := 1; i <= 9; i++ {
+		if _, err := linkByName(name); err != nil {
+			if strings.Contains(err.Error(), "not found") {
+				return name, nil
+			}
+			return "", err
+		}
+		name = ifName(prefix, base, "-"+strconv.Itoa(i))
+	}
+
+	logrus.Warnf("The veth names of endpoint %.7s are taken, falling back to a random name", eid)
+	return netutils.GenerateIfaceName(nlh, prefix, vethLen)
+}
+
+// ifName truncates the base so that the name fits the interface names
+func ifName(prefix, base, suffix string) string {
+	if n := maxIfNameLen - len(prefix) - len(suffix); len(base) > n {
+		base = base[:n]
+	}
+	return fmt.Sprintf("%s%s%s", prefix, base, suffix)
+}
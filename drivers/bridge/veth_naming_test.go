@@ -0,0 +1,66 @@
+package bridge
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/docker/libnetwork/netlabel"
+)
+
+func TestHostVethName(t *testing.T) {
+	config := &networkConfiguration{VethNaming: VethNamingEndpoint}
+	name, err := hostVethName(nil, config, "0123456789abcdef", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != "veth0123456789a" {
+		t.Fatalf("unexpected endpoint veth name %q", name)
+	}
+
+	config = &networkConfiguration{VethNaming: VethNamingName, VethPrefix: "ct"}
+	name, err = hostVethName(nil, config, "0123456789abcdef", map[string]interface{}{netlabel.EndpointName: "web/frontend_1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != "ctwebfrontend_1" {
+		t.Fatalf("unexpected name derived veth name %q", name)
+	}
+
+	// Without a name the veth gets a random one
+	name, err = hostVethName(nil, config, "0123456789abcdef", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(name, "ct") || len(name) != len("ct")+vethLen {
+		t.Fatalf("unexpected random veth name %q", name)
+	}
+
+	// The loopback name is taken
+	config = &networkConfiguration{VethNaming: VethNamingName, VethPrefix: "l"}
+	name, err = hostVethName(nil, config, "0123456789abcdef", map[string]interface{}{netlabel.EndpointName: "o"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != "lo-1" {
+		t.Fatalf("unexpected veth name %q of a taken name", name)
+	}
+
+	if ifName("veth", "averyveryverylongname", "-9") != "vethaveryvery-9" {
+		t.Fatalf("unexpected truncated name %q", ifName("veth", "averyveryverylongname", "-9"))
+	}
+}
+
+func TestValidateVethNaming(t *testing.T) {
+	for _, c := range []*networkConfiguration{
+		{VethNaming: "hash"},
+		{VethPrefix: "0veth"},
+		{VethPrefix: "averylongprefix"},
+	} {
+		if err := validateVethNaming(c); err == nil {
+			t.Fatalf("expected an error validating %+v", c)
+		}
+	}
+	if err := validateVethNaming(&networkConfiguration{VethNaming: VethNamingName, VethPrefix: "ct-"}); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,206 @@
+package bridge
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/docker/libnetwork/labelparse"
+	"github.com/docker/libnetwork/types"
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+)
+
+const (
+	// maxVethQueues bounds the queues of the endpoint veths
+	maxVethQueues = 64
+	// maxCPUs bounds the CPUs of the RPS and XPS lists
+	maxCPUs = 4096
+
+	// The queue count attributes the vendored netlink does not know of
+	iflaNumTxQueues = 31
+	iflaNumRxQueues = 32
+)
+
+var vethQueuesRoot = "/sys/class/net"
+
+// parseCPUList parses the comma separated CPUs and CPU ranges, as in 0-3,8,
+// sorted and without duplicates
+func parseCPUList(value string) ([]int, error) {
+	elems, err := labelparse.ParseList("CPU", value)
+	if err != nil {
+		return nil, err
+	}
+	seen := map[int]bool{}
+	for _, e := range elems {
+		bounds := strings.SplitN(e, "-", 2)
+		first, err := strconv.Atoi(bounds[0])
+		if err != nil || first < 0 || first >= maxCPUs {
+			return nil, fmt.Errorf("invalid CPU %q: expected 0 to %d", e, maxCPUs-1)
+		}
+		last := first
+		if len(bounds) == 2 {
+			last, err = strconv.Atoi(bounds[1])
+			if err != nil || last < first || last >= maxCPUs {
+				return nil, fmt.Errorf("invalid CPU range %q: expected as in 0-3", e)
+			}
+		}
+		for c := first; c <= last; c++ {
+			seen[c] = true
+		}
+	}
+	cpus := make([]int, 0, len(seen))
+	for c := range seen {
+		cpus = append(cpus, c)
+	}
+	sort.Ints(cpus)
+	return cpus, nil
+}
+
+// cpuMask formats the CPUs as the sysfs bitmaps do, the 32 bits groups in
+// hexadecimal separated by commas, the most significant first
+func cpuMask(cpus []int) string {
+	if len(cpus) == 0 {
+		return "0"
+	}
+	groups := make([]uint32, cpus[len(cpus)-1]/32+1)
+	for _, c := range cpus {
+		groups[c/32] |= 1 << uint(c%32)
+	}
+	parts := make([]string, 0, len(groups))
+	for i := len(groups) - 1; i >= 0; i-- {
+		if i == len(groups)-1 {
+			parts = append(parts, strconv.FormatUint(uint64(groups[i]), 16))
+		} else {
+			parts = append(parts, fmt.Sprintf("%08x", groups[i]))
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+// xpsMasks spreads the CPUs over the transmit queues, each CPU sending on
+// a single queue, the queues left without CPUs mapped to none
+func xpsMasks(cpus []int, queues int) []string {
+	perQueue := make([][]int, queues)
+	for i, c := range cpus {
+		perQueue[i%queues] = append(perQueue[i%queues], c)
+	}
+	masks := make([]string, queues)
+	for q := range perQueue {
+		masks[q] = cpuMask(perQueue[q])
+	}
+	return masks
+}
+
+func validateVethQueues(c *networkConfiguration) error {
+	if c.VethQueues < 0 || c.VethQueues > maxVethQueues {
+		return types.BadRequestErrorf("invalid veth queues %d: expected up to %d", c.VethQueues, maxVethQueues)
+	}
+	for label, v := range map[string]string{VethRPSCPUs: c.VethRPSCPUs, VethXPSCPUs: c.VethXPSCPUs} {
+		if v == "" {
+			continue
+		}
+		if _, err := parseCPUList(v); err != nil {
+			return types.BadRequestErrorf("invalid %s %q: %v", label, v, err)
+		}
+	}
+	return nil
+}
+
+// addVeth adds the veth pair of an endpoint, with the queues of the
+// network when more than one
+func addVeth(nlh *netlink.Handle, hostIfName, containerIfName string, queues int) error {
+	if queues <= 1 {
+		return nlh.LinkAdd(&netlink.Veth{
+			LinkAttrs: netlink.LinkAttrs{Name: hostIfName, TxQLen: 0},
+			PeerName:  containerIfName})
+	}
+	return addMultiQueueVeth(hostIfName, containerIfName, queues)
+}
+
+// addMultiQueueVeth adds the veth pair with as many transmit and receive
+// queues on both ends, in the namespace of the calling thread
+func addMultiQueueVeth(hostIfName, containerIfName string, queues int) error {
+	req := nl.NewNetlinkRequest(syscall.RTM_NEWLINK, syscall.NLM_F_CREATE|syscall.NLM_F_EXCL|syscall.NLM_F_ACK)
+	req.AddData(nl.NewIfInfomsg(syscall.AF_UNSPEC))
+	req.AddData(nl.NewRtAttr(syscall.IFLA_IFNAME, nl.ZeroTerminated(hostIfName)))
+	req.AddData(nl.NewRtAttr(syscall.IFLA_TXQLEN, nl.Uint32Attr(0)))
+	req.AddData(nl.NewRtAttr(iflaNumTxQueues, nl.Uint32Attr(uint32(queues))))
+	req.AddData(nl.NewRtAttr(iflaNumRxQueues, nl.Uint32Attr(uint32(queues))))
+
+	linkInfo := nl.NewRtAttr(syscall.IFLA_LINKINFO, nil)
+	nl.NewRtAttrChild(linkInfo, nl.IFLA_INFO_KIND, nl.NonZeroTerminated("veth"))
+	data := nl.NewRtAttrChild(linkInfo, nl.IFLA_INFO_DATA, nil)
+	peer := nl.NewRtAttrChild(data, nl.VETH_INFO_PEER, nil)
+	nl.NewIfInfomsgChild(peer, syscall.AF_UNSPEC)
+	nl.NewRtAttrChild(peer, syscall.IFLA_IFNAME, nl.ZeroTerminated(containerIfName))
+	nl.NewRtAttrChild(peer, syscall.IFLA_TXQLEN, nl.Uint32Attr(0))
+	nl.NewRtAttrChild(peer, iflaNumTxQueues, nl.Uint32Attr(uint32(queues)))
+	nl.NewRtAttrChild(peer, iflaNumRxQueues, nl.Uint32Attr(uint32(queues)))
+	req.AddData(linkInfo)
+
+	_, err := req.Execute(syscall.NETLINK_ROUTE, 0)
+	return err
+}
+
+// setupVethSteering sets the RPS and XPS CPUs of the network on the queues
+// of both ends of the veth pair, before the sandbox side leaves the host
+// namespace, the masks moving along it
+func setupVethSteering(config *networkConfiguration, ifNames ...string) error {
+	if config.VethRPSCPUs == "" && config.VethXPSCPUs == "" {
+		return nil
+	}
+	rps, _ := parseCPUList(config.VethRPSCPUs)
+	xps, _ := parseCPUList(config.VethXPSCPUs)
+	for _, ifName := range ifNames {
+		rxQueues, txQueues, err := vethQueues(ifName)
+		if err != nil {
+			return err
+		}
+		if len(rps) > 0 {
+			mask := cpuMask(rps)
+			for q := 0; q < rxQueues; q++ {
+				if err := writeQueueMask(ifName, fmt.Sprintf("rx-%d/rps_cpus", q), mask); err != nil {
+					return err
+				}
+			}
+		}
+		if len(xps) > 0 {
+			for q, mask := range xpsMasks(xps, txQueues) {
+				if err := writeQueueMask(ifName, fmt.Sprintf("tx-%d/xps_cpus", q), mask); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// vethQueues counts the receive and transmit queues of the interface
+func vethQueues(ifName string) (int, int, error) {
+	entries, err := ioutil.ReadDir(filepath.Join(vethQueuesRoot, ifName, "queues"))
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to list the queues of %s: %v", ifName, err)
+	}
+	var rx, tx int
+	for _, e := range entries {
+		switch {
+		case strings.HasPrefix(e.Name(), "rx-"):
+			rx++
+		case strings.HasPrefix(e.Name(), "tx-"):
+			tx++
+		}
+	}
+	return rx, tx, nil
+}
+
+func writeQueueMask(ifName, queueFile, mask string) error {
+	if err := ioutil.WriteFile(filepath.Join(vethQueuesRoot, ifName, "queues", queueFile), []byte(mask), 0644); err != nil {
+		return fmt.Errorf("failed to set %s of %s to %s: %v", queueFile, ifName, mask, err)
+	}
+	return nil
+}
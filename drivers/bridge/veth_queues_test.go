@@ -0,0 +1,148 @@
+package bridge
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"syscall"
+	"testing"
+
+	"github.com/docker/libnetwork/ns"
+	"github.com/docker/libnetwork/testutils"
+	"github.com/vishvananda/netlink/nl"
+)
+
+func TestParseCPUList(t *testing.T) {
+	cpus, err := parseCPUList("8, 0-3,2,40-41")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(cpus, []int{0, 1, 2, 3, 8, 40, 41}) {
+		t.Fatalf("unexpected CPUs %v", cpus)
+	}
+	if m := cpuMask(cpus); m != "300,0000010f" {
+		t.Fatalf("unexpected mask %s", m)
+	}
+	if m := cpuMask([]int{0, 1}); m != "3" {
+		t.Fatalf("unexpected mask %s", m)
+	}
+
+	for _, v := range []string{"a", "-1", "3-1", "0-", "4096", "1-x"} {
+		if _, err := parseCPUList(v); err == nil {
+			t.Fatalf("expected an error parsing %q", v)
+		}
+	}
+
+	masks := xpsMasks([]int{0, 1, 2, 3, 4}, 4)
+	if !reflect.DeepEqual(masks, []string{"11", "2", "4", "8"}) {
+		t.Fatalf("unexpected XPS masks %v", masks)
+	}
+	masks = xpsMasks([]int{5}, 2)
+	if !reflect.DeepEqual(masks, []string{"20", "0"}) {
+		t.Fatalf("unexpected XPS masks %v", masks)
+	}
+
+	c := networkConfiguration{}
+	if err := c.fromLabels(map[string]string{VethQueues: "4", VethRPSCPUs: "0-3"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := validateVethQueues(&c); err != nil || c.VethQueues != 4 || c.VethRPSCPUs != "0-3" {
+		t.Fatalf("unexpected configuration %+v: %v", c, err)
+	}
+	for _, bad := range []networkConfiguration{{VethQueues: 65}, {VethXPSCPUs: "0-a"}} {
+		if err := validateVethQueues(&bad); err == nil {
+			t.Fatalf("invalid configuration %+v accepted", bad)
+		}
+	}
+}
+
+func TestSetupVethSteering(t *testing.T) {
+	root, err := ioutil.TempDir("", "veth-queues")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	defer func(r string) { vethQueuesRoot = r }(vethQueuesRoot)
+	vethQueuesRoot = root
+
+	for _, ifName := range []string{"veth0", "eth0"} {
+		for _, q := range []string{"rx-0", "rx-1", "tx-0", "tx-1"} {
+			if err := os.MkdirAll(filepath.Join(root, ifName, "queues", q), 0755); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	config := &networkConfiguration{VethRPSCPUs: "0-3", VethXPSCPUs: "2,3"}
+	if err := setupVethSteering(config, "veth0", "eth0"); err != nil {
+		t.Fatal(err)
+	}
+	for _, ifName := range []string{"veth0", "eth0"} {
+		for file, expected := range map[string]string{
+			"rx-0/rps_cpus": "f", "rx-1/rps_cpus": "f", "tx-0/xps_cpus": "4", "tx-1/xps_cpus": "8",
+		} {
+			b, err := ioutil.ReadFile(filepath.Join(root, ifName, "queues", file))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(b) != expected {
+				t.Fatalf("unexpected %s of %s: %s, expected %s", file, ifName, b, expected)
+			}
+		}
+	}
+}
+
+func TestAddMultiQueueVeth(t *testing.T) {
+	if !testutils.IsRunningInContainer() {
+		defer testutils.SetupTestOSContext(t)()
+	}
+	nlh := ns.NlHandle()
+	if err := addVeth(nlh, "vethmq0", "vethmq1", 4); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if link, err := nlh.LinkByName("vethmq0"); err == nil {
+			nlh.LinkDel(link)
+		}
+	}()
+
+	for _, name := range []string{"vethmq0", "vethmq1"} {
+		link, err := nlh.LinkByName(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if link.Type() != "veth" {
+			t.Fatalf("unexpected type %s of %s", link.Type(), name)
+		}
+		if tx, rx := numQueues(t, link.Attrs().Index); tx != 4 || rx != 4 {
+			t.Fatalf("unexpected queues of %s: %d transmit, %d receive", name, tx, rx)
+		}
+	}
+}
+
+// numQueues returns the transmit and receive queue counts of the link,
+// which the vendored netlink does not parse
+func numQueues(t *testing.T, index int) (int, int) {
+	req := nl.NewNetlinkRequest(syscall.RTM_GETLINK, syscall.NLM_F_ACK)
+	msg := nl.NewIfInfomsg(syscall.AF_UNSPEC)
+	msg.Index = int32(index)
+	req.AddData(msg)
+	msgs, err := req.Execute(syscall.NETLINK_ROUTE, syscall.RTM_NEWLINK)
+	if err != nil || len(msgs) != 1 {
+		t.Fatalf("failed to get link %d: %v", index, err)
+	}
+	attrs, err := nl.ParseRouteAttr(msgs[0][syscall.SizeofIfInfomsg:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tx, rx int
+	for _, a := range attrs {
+		switch a.Attr.Type {
+		case iflaNumTxQueues:
+			tx = int(nl.NativeEndian().Uint32(a.Value))
+		case iflaNumRxQueues:
+			rx = int(nl.NativeEndian().Uint32(a.Value))
+		}
+	}
+	return tx, rx
+}
@@ -0,0 +1,135 @@
+package bridge
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/libnetwork/labelparse"
+	"github.com/sirupsen/logrus"
+)
+
+// vethSysctlsInterval is the period the sysctls of the host side veths are
+// verified at, and set back when something else changed them
+const vethSysctlsInterval = 30 * time.Second
+
+// vethSysctls are the sysctls a network can set on the host side veths of
+// its endpoints, with the protocol directory and the maximum value
+var vethSysctls = map[string]struct {
+	proto string
+	max   int
+}{
+	"rp_filter":    {"ipv4", 2},
+	"accept_local": {"ipv4", 1},
+	"proxy_arp":    {"ipv4", 1},
+	"forwarding":   {"ipv4", 1},
+	"accept_ra":    {"ipv6", 2},
+}
+
+var (
+	vethSysctlRoot      = "/proc/sys/net"
+	vethSysctlWatchOnce sync.Once
+)
+
+// parseVethSysctls parses the comma separated name=value sysctls
+func parseVethSysctls(value string) (map[string]string, error) {
+	kvs, err := labelparse.ParseKeyValues("sysctl", value)
+	if err != nil {
+		return nil, err
+	}
+	sysctls := make(map[string]string)
+	for _, kv := range kvs {
+		s, ok := vethSysctls[kv.Key]
+		if !ok {
+			return nil, fmt.Errorf("unsupported sysctl %q", kv.Key)
+		}
+		v, err := strconv.Atoi(kv.Value)
+		if err != nil || v < 0 || v > s.max {
+			return nil, fmt.Errorf("invalid value %q of sysctl %s: expected 0 to %d", kv.Value, kv.Key, s.max)
+		}
+		sysctls[kv.Key] = strconv.Itoa(v)
+	}
+	return sysctls, nil
+}
+
+func vethSysctlPath(ifName, name string) string {
+	return filepath.Join(vethSysctlRoot, vethSysctls[name].proto, "conf", ifName, name)
+}
+
+// applyVethSysctls sets the sysctls of the interface which differ from the
+// profile, it returns the ones which had to be set
+func applyVethSysctls(ifName string, sysctls map[string]string) ([]string, error) {
+	names := make([]string, 0, len(sysctls))
+	for name := range sysctls {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var set []string
+	for _, name := range names {
+		path := vethSysctlPath(ifName, name)
+		if b, err := ioutil.ReadFile(path); err == nil && strings.TrimSpace(string(b)) == sysctls[name] {
+			continue
+		}
+		if err := ioutil.WriteFile(path, []byte(sysctls[name]), 0644); err != nil {
+			return set, fmt.Errorf("failed to set %s of %s: %v", name, ifName, err)
+		}
+		set = append(set, name)
+	}
+	return set, nil
+}
+
+// setupVethSysctls applies the sysctl profile of the network to the host
+// side veth of the endpoint, and starts their verification
+func (d *driver) setupVethSysctls(n *bridgeNetwork, ep *bridgeEndpoint) error {
+	if len(n.config.VethSysctls) == 0 || ep.hostIfName == "" {
+		return nil
+	}
+	if _, err := applyVethSysctls(ep.hostIfName, endpointVethSysctls(ep, n.config.VethSysctls)); err != nil {
+		return err
+	}
+	vethSysctlWatchOnce.Do(func() {
+		go d.watchVethSysctls(vethSysctlsInterval)
+	})
+	return nil
+}
+
+// watchVethSysctls sets back the sysctls of the host side veths which
+// drifted from the profile of their network
+func (d *driver) watchVethSysctls(interval time.Duration) {
+	for range time.Tick(interval) {
+		d.reapplyVethSysctls()
+	}
+}
+
+func (d *driver) reapplyVethSysctls() {
+	for _, n := range d.getNetworks() {
+		n.Lock()
+		profile := n.config.VethSysctls
+		ifSysctls := make(map[string]map[string]string)
+		for _, ep := range n.endpoints {
+			if ep.hostIfName != "" {
+				ifSysctls[ep.hostIfName] = endpointVethSysctls(ep, profile)
+			}
+		}
+		n.Unlock()
+		if len(profile) == 0 {
+			continue
+		}
+
+		for ifName, sysctls := range ifSysctls {
+			set, err := applyVethSysctls(ifName, sysctls)
+			if len(set) != 0 {
+				logrus.Warnf("Set back the drifted sysctls %s of %s in network %.7s", strings.Join(set, ","), ifName, n.id)
+			}
+			if err != nil {
+				logrus.Debugf("Failed to verify the sysctls of %s: %v", ifName, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package bridge
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseVethSysctls(t *testing.T) {
+	sysctls, err := parseVethSysctls("rp_filter=2, proxy_arp=1,accept_ra=0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{"rp_filter": "2", "proxy_arp": "1", "accept_ra": "0"}
+	if !reflect.DeepEqual(sysctls, expected) {
+		t.Fatalf("unexpected sysctls %v, expected %v", sysctls, expected)
+	}
+
+	for _, v := range []string{"rp_filter", "rp_filter=3", "proxy_arp=on", "mtu=1500"} {
+		if _, err := parseVethSysctls(v); err == nil {
+			t.Fatalf("expected an error parsing %q", v)
+		}
+	}
+
+	c := networkConfiguration{}
+	if err := c.fromLabels(map[string]string{VethSysctls: "forwarding=0"}); err != nil {
+		t.Fatal(err)
+	}
+	if c.VethSysctls["forwarding"] != "0" {
+		t.Fatalf("unexpected sysctls from the labels: %v", c.VethSysctls)
+	}
+}
+
+func TestApplyVethSysctls(t *testing.T) {
+	root, err := ioutil.TempDir("", "veth-sysctls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	defer func(r string) { vethSysctlRoot = r }(vethSysctlRoot)
+	vethSysctlRoot = root
+
+	for _, proto := range []string{"ipv4", "ipv6"} {
+		if err := os.MkdirAll(filepath.Join(root, proto, "conf", "veth0"), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ioutil.WriteFile(vethSysctlPath("veth0", "rp_filter"), []byte("2\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	sysctls := map[string]string{"rp_filter": "2", "accept_ra": "0"}
+	set, err := applyVethSysctls("veth0", sysctls)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(set, []string{"accept_ra"}) {
+		t.Fatalf("unexpected sysctls set: %v", set)
+	}
+
+	// Drifted values are set back
+	if err := ioutil.WriteFile(vethSysctlPath("veth0", "rp_filter"), []byte("1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if set, err = applyVethSysctls("veth0", sysctls); err != nil || !reflect.DeepEqual(set, []string{"rp_filter"}) {
+		t.Fatalf("unexpected sysctls set back: %v, %v", set, err)
+	}
+	if set, _ = applyVethSysctls("veth0", sysctls); len(set) != 0 {
+		t.Fatalf("unexpected sysctls set on verification: %v", set)
+	}
+}
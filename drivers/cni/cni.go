@@ -0,0 +1,178 @@
+// Package cni implements a libnetwork driver which delegates endpoint
+// plumbing to CNI plugins. A network of this driver type references a CNI
+// network configuration; joining an endpoint runs the configured plugin
+// chain with the ADD command against the sandbox namespace and leaving it
+// runs the chain with the DEL command.
+package cni
+
+import (
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/docker/libnetwork/datastore"
+	"github.com/docker/libnetwork/discoverapi"
+	"github.com/docker/libnetwork/driverapi"
+	"github.com/docker/libnetwork/netlabel"
+	"github.com/docker/libnetwork/types"
+)
+
+const (
+	cniType = "cni" // driver type name
+
+	// networkOpt selects the CNI network configuration by name: -o cni.network=<name>
+	networkOpt = "cni.network"
+	// configOpt carries an inline CNI network configuration (list): -o cni.config=<json>
+	configOpt = "cni.config"
+	// ifNameOpt overrides the name of the interface created by the plugins
+	ifNameOpt = "cni.ifname"
+
+	defaultConfDir = "/etc/cni/net.d"
+	defaultBinDir  = "/opt/cni/bin"
+
+	ifNamePrefix = "cni"
+	ifNameIDLen  = 7
+)
+
+var (
+	// ConfDirLabel is the daemon label overriding the CNI configuration directory
+	ConfDirLabel = netlabel.DriverPrefix + "." + cniType + ".conf_dir"
+	// BinDirLabel is the daemon label overriding the CNI plugin search path (colon separated)
+	BinDirLabel = netlabel.DriverPrefix + "." + cniType + ".bin_dir"
+)
+
+type endpointTable map[string]*endpoint
+
+type networkTable map[string]*network
+
+type driver struct {
+	confDir  string
+	binDirs  []string
+	networks networkTable
+	store    datastore.DataStore
+	sync.Mutex
+}
+
+type network struct {
+	id        string
+	config    *configuration
+	endpoints endpointTable
+	sync.Mutex
+}
+
+type endpoint struct {
+	id       string
+	nid      string
+	addr     string
+	addrv6   string
+	ifName   string
+	netns    string
+	pending  bool // joined, the namespace still to be set
+	result   []byte
+	dbIndex  uint64
+	dbExists bool
+}
+
+// Init initializes and registers the libnetwork cni driver
+func Init(dc driverapi.DriverCallback, config map[string]interface{}) error {
+	c := driverapi.Capability{
+		DataScope:         datastore.LocalScope,
+		ConnectivityScope: datastore.LocalScope,
+	}
+	d := &driver{
+		confDir:  defaultConfDir,
+		binDirs:  []string{defaultBinDir},
+		networks: networkTable{},
+	}
+	if v, ok := config[ConfDirLabel].(string); ok && v != "" {
+		d.confDir = v
+	}
+	if v, ok := config[BinDirLabel].(string); ok && v != "" {
+		d.binDirs = filepath.SplitList(v)
+	}
+	if err := d.initStore(config); err != nil {
+		return err
+	}
+
+	return dc.RegisterDriver(cniType, d, c)
+}
+
+func (d *driver) getNetwork(nid string) (*network, error) {
+	d.Lock()
+	defer d.Unlock()
+	n, ok := d.networks[nid]
+	if !ok {
+		return nil, types.NotFoundErrorf("network %s not found", nid)
+	}
+	return n, nil
+}
+
+func (n *network) endpoint(eid string) *endpoint {
+	n.Lock()
+	defer n.Unlock()
+	return n.endpoints[eid]
+}
+
+func (n *network) addEndpoint(ep *endpoint) {
+	n.Lock()
+	n.endpoints[ep.id] = ep
+	n.Unlock()
+}
+
+func (n *network) deleteEndpoint(eid string) {
+	n.Lock()
+	delete(n.endpoints, eid)
+	n.Unlock()
+}
+
+// defaultIfName derives a per endpoint interface name, unique within the
+// sandbox, for plugins which create the interface themselves
+func defaultIfName(eid string) string {
+	id := strings.Replace(eid, "-", "", -1)
+	if len(id) > ifNameIDLen {
+		id = id[:ifNameIDLen]
+	}
+	return ifNamePrefix + id
+}
+
+func (d *driver) NetworkAllocate(id string, option map[string]string, ipV4Data, ipV6Data []driverapi.IPAMData) (map[string]string, error) {
+	return nil, types.NotImplementedErrorf("not implemented")
+}
+
+func (d *driver) NetworkFree(id string) error {
+	return types.NotImplementedErrorf("not implemented")
+}
+
+func (d *driver) Type() string {
+	return cniType
+}
+
+func (d *driver) IsBuiltIn() bool {
+	return true
+}
+
+// The CNI plugins own the external connectivity of their endpoints
+func (d *driver) ProgramExternalConnectivity(nid, eid string, options map[string]interface{}) error {
+	return nil
+}
+
+func (d *driver) RevokeExternalConnectivity(nid, eid string) error {
+	return nil
+}
+
+// DiscoverNew is a notification for a new discovery event
+func (d *driver) DiscoverNew(dType discoverapi.DiscoveryType, data interface{}) error {
+	return nil
+}
+
+// DiscoverDelete is a notification for a discovery delete event
+func (d *driver) DiscoverDelete(dType discoverapi.DiscoveryType, data interface{}) error {
+	return nil
+}
+
+func (d *driver) EventNotify(etype driverapi.EventType, nid, tableName, key string, value []byte) {
+}
+
+func (d *driver) DecodeTableEntry(tablename string, key string, value []byte) (string, map[string]string) {
+	return "", nil
+}
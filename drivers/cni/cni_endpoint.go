@@ -0,0 +1,260 @@
+package cni
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/docker/libnetwork/driverapi"
+	"github.com/docker/libnetwork/netlabel"
+	"github.com/docker/libnetwork/types"
+	"github.com/sirupsen/logrus"
+)
+
+// CreateEndpoint records the endpoint. The plugins are only invoked on Join,
+// once the sandbox namespace is known.
+func (d *driver) CreateEndpoint(nid, eid string, ifInfo driverapi.InterfaceInfo, epOptions map[string]interface{}) error {
+	n, err := d.getNetwork(nid)
+	if err != nil {
+		return err
+	}
+	if n.endpoint(eid) != nil {
+		return types.ForbiddenErrorf("endpoint %s exists", eid)
+	}
+	ep := &endpoint{
+		id:     eid,
+		nid:    nid,
+		ifName: n.config.IfName,
+	}
+	if ep.ifName == "" {
+		ep.ifName = defaultIfName(eid)
+	}
+	if ifInfo != nil {
+		if a := ifInfo.Address(); a != nil {
+			ep.addr = a.String()
+		}
+		if a := ifInfo.AddressIPv6(); a != nil {
+			ep.addrv6 = a.String()
+		}
+	}
+	if opt, ok := epOptions[netlabel.PortMap]; ok {
+		if pbs, ok := opt.([]types.PortBinding); ok && len(pbs) > 0 {
+			logrus.Warnf("%s driver does not support port mappings", cniType)
+		}
+	}
+
+	if err := d.storeUpdate(ep); err != nil {
+		return fmt.Errorf("failed to save cni endpoint %.7s to store: %v", ep.id, err)
+	}
+	n.addEndpoint(ep)
+
+	return nil
+}
+
+// DeleteEndpoint removes the endpoint, releasing the plugin resources if the
+// endpoint is still attached to a sandbox
+func (d *driver) DeleteEndpoint(nid, eid string) error {
+	n, err := d.getNetwork(nid)
+	if err != nil {
+		return err
+	}
+	ep := n.endpoint(eid)
+	if ep == nil {
+		return types.NotFoundErrorf("endpoint %s not found", eid)
+	}
+	if ep.netns != "" && !ep.pending {
+		if err := d.del(n.config.list, ep.runtimeConf(), ep.result, len(n.config.list.Plugins)); err != nil {
+			logrus.Warnf("Failed to release cni endpoint %.7s on delete: %v", ep.id, err)
+		}
+	}
+	if err := d.storeDelete(ep); err != nil {
+		logrus.Warnf("Failed to remove cni endpoint %.7s from store: %v", ep.id, err)
+	}
+	n.deleteEndpoint(eid)
+
+	return nil
+}
+
+// EndpointOperInfo returns the addresses assigned by the plugins
+func (d *driver) EndpointOperInfo(nid, eid string) (map[string]interface{}, error) {
+	n, err := d.getNetwork(nid)
+	if err != nil {
+		return nil, err
+	}
+	ep := n.endpoint(eid)
+	if ep == nil {
+		return nil, types.NotFoundErrorf("endpoint %s not found", eid)
+	}
+	m := map[string]interface{}{"cni.ifname": ep.ifName}
+	if len(ep.result) > 0 {
+		var res result
+		if err := json.Unmarshal(ep.result, &res); err == nil {
+			ips := make([]string, 0, len(res.IPs))
+			for _, ip := range res.IPs {
+				ips = append(ips, ip.Address)
+			}
+			m["cni.ips"] = ips
+		}
+	}
+	return m, nil
+}
+
+// Join runs the plugin chain with the ADD command against the sandbox, or
+// defers it to JoinNamespace when the namespace of the sandbox is pending
+func (d *driver) Join(nid, eid string, sboxKey string, jinfo driverapi.JoinInfo, options map[string]interface{}) error {
+	n, err := d.getNetwork(nid)
+	if err != nil {
+		return err
+	}
+	ep := n.endpoint(eid)
+	if ep == nil {
+		return types.NotFoundErrorf("endpoint %s not found", eid)
+	}
+	ep.netns = sboxKey
+	if pending, _ := options[netlabel.NamespacePending].(bool); pending {
+		ep.pending = true
+	} else if err := d.add(n, ep); err != nil {
+		ep.netns = ""
+		return err
+	}
+	// The plugins own the routing of the sandbox
+	jinfo.DisableGatewayService()
+
+	if err := d.storeUpdate(ep); err != nil {
+		return fmt.Errorf("failed to save cni endpoint %.7s to store: %v", ep.id, err)
+	}
+	return nil
+}
+
+// JoinNamespace runs the plugin chain with the ADD command against the
+// namespace of the sandbox, set after the endpoint joined. When the key is
+// set anew, the endpoint is first released from the former namespace.
+func (d *driver) JoinNamespace(nid, eid, sboxKey string) error {
+	n, err := d.getNetwork(nid)
+	if err != nil {
+		return err
+	}
+	ep := n.endpoint(eid)
+	if ep == nil {
+		return types.NotFoundErrorf("endpoint %s not found", eid)
+	}
+	if ep.netns == "" {
+		return types.ForbiddenErrorf("endpoint %.7s is not joined", eid)
+	}
+	if !ep.pending {
+		if err := d.del(n.config.list, ep.runtimeConf(), ep.result, len(n.config.list.Plugins)); err != nil {
+			logrus.Warnf("Failed to release cni endpoint %.7s from its former namespace: %v", ep.id, err)
+		}
+		ep.pending, ep.result = true, nil
+	}
+	ep.netns = sboxKey
+	if err := d.add(n, ep); err != nil {
+		return err
+	}
+	ep.pending = false
+
+	if err := d.storeUpdate(ep); err != nil {
+		return fmt.Errorf("failed to save cni endpoint %.7s to store: %v", ep.id, err)
+	}
+	return nil
+}
+
+// add plumbs the endpoint in its namespace, undoing what the plugins did
+// when they fail or assign an address other than the one of the endpoint
+func (d *driver) add(n *network, ep *endpoint) error {
+	res, err := d.addChain(n.config.list, ep.runtimeConf())
+	if err != nil {
+		return err
+	}
+	if err := ep.checkResult(res); err != nil {
+		if derr := d.del(n.config.list, ep.runtimeConf(), res, len(n.config.list.Plugins)); derr != nil {
+			err = fmt.Errorf("%v; rollback failed: %v", err, derr)
+		}
+		return err
+	}
+	ep.result = res
+	return nil
+}
+
+// checkResult makes sure the addresses the plugins assigned, if any, are
+// the ones libnetwork allocated to the endpoint, as a plugin ignoring the
+// IP argument would leave the endpoint with an address libnetwork does not
+// know of
+func (ep *endpoint) checkResult(b []byte) error {
+	if len(b) == 0 {
+		return nil
+	}
+	var res result
+	if err := json.Unmarshal(b, &res); err != nil {
+		return fmt.Errorf("invalid CNI result of endpoint %.7s: %v", ep.id, err)
+	}
+	for _, expected := range []string{ep.addr, ep.addrv6} {
+		if expected == "" {
+			continue
+		}
+		want, err := types.ParseCIDR(expected)
+		if err != nil {
+			continue
+		}
+		var (
+			got     []string
+			matched bool
+		)
+		for _, ip := range res.IPs {
+			addr, err := types.ParseCIDR(ip.Address)
+			if err != nil {
+				return fmt.Errorf("invalid address %q in the CNI result of endpoint %.7s", ip.Address, ep.id)
+			}
+			if (addr.IP.To4() == nil) != (want.IP.To4() == nil) {
+				continue
+			}
+			matched = matched || addr.IP.Equal(want.IP)
+			got = append(got, ip.Address)
+		}
+		// A result without an address of the family leaves it to libnetwork
+		if !matched && len(got) > 0 {
+			return types.ForbiddenErrorf("CNI plugins assigned %s to endpoint %.7s instead of its address %s", strings.Join(got, ", "), ep.id, expected)
+		}
+	}
+	return nil
+}
+
+// Leave runs the plugin chain with the DEL command
+func (d *driver) Leave(nid, eid string) error {
+	n, err := d.getNetwork(nid)
+	if err != nil {
+		return err
+	}
+	ep := n.endpoint(eid)
+	if ep == nil {
+		return types.NotFoundErrorf("endpoint %s not found", eid)
+	}
+	// The plugins did not run when the namespace is still pending
+	if !ep.pending {
+		if err := d.del(n.config.list, ep.runtimeConf(), ep.result, len(n.config.list.Plugins)); err != nil {
+			return err
+		}
+	}
+	ep.netns, ep.pending, ep.result = "", false, nil
+
+	if err := d.storeUpdate(ep); err != nil {
+		logrus.Warnf("Failed to update cni endpoint %.7s in store: %v", ep.id, err)
+	}
+	return nil
+}
+
+func (ep *endpoint) runtimeConf() *runtimeConf {
+	rt := &runtimeConf{
+		containerID: ep.id,
+		netns:       ep.netns,
+		ifName:      ep.ifName,
+	}
+	// Pass the address allocated by libnetwork, honored by host-local and
+	// static IPAM plugins
+	if ep.addr != "" {
+		if ip, err := types.ParseCIDR(ep.addr); err == nil {
+			rt.args = append(rt.args, [2]string{"IP", ip.IP.String()})
+		}
+	}
+	return rt
+}
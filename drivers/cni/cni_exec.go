@@ -0,0 +1,226 @@
+package cni
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	cmdAdd = "ADD"
+	cmdDel = "DEL"
+)
+
+// netConfList is a CNI network configuration list. Single plugin
+// configurations (.conf files) are converted into a list of one.
+type netConfList struct {
+	CNIVersion string                   `json:"cniVersion"`
+	Name       string                   `json:"name"`
+	Plugins    []map[string]interface{} `json:"plugins"`
+}
+
+// runtimeConf carries the per invocation parameters passed to the plugins
+type runtimeConf struct {
+	containerID string
+	netns       string
+	ifName      string
+	args        [][2]string
+}
+
+// cniError is the error structure a plugin prints on failure
+type cniError struct {
+	Code    uint   `json:"code"`
+	Msg     string `json:"msg"`
+	Details string `json:"details,omitempty"`
+}
+
+func (e *cniError) Error() string {
+	if e.Details != "" {
+		return fmt.Sprintf("%s; %s", e.Msg, e.Details)
+	}
+	return e.Msg
+}
+
+// result is the subset of the CNI result the driver consumes
+type result struct {
+	IPs []struct {
+		Version string `json:"version"`
+		Address string `json:"address"`
+		Gateway string `json:"gateway,omitempty"`
+	} `json:"ips"`
+}
+
+func parseConfList(b []byte) (*netConfList, error) {
+	var raw map[string]interface{}
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return nil, fmt.Errorf("invalid CNI configuration: %v", err)
+	}
+	if _, ok := raw["plugins"]; !ok {
+		// Single plugin configuration
+		list := &netConfList{Plugins: []map[string]interface{}{raw}}
+		list.Name, _ = raw["name"].(string)
+		list.CNIVersion, _ = raw["cniVersion"].(string)
+		return list, list.validate()
+	}
+	list := &netConfList{}
+	if err := json.Unmarshal(b, list); err != nil {
+		return nil, fmt.Errorf("invalid CNI configuration list: %v", err)
+	}
+	return list, list.validate()
+}
+
+func (l *netConfList) validate() error {
+	if l.Name == "" {
+		return fmt.Errorf("CNI configuration is missing the network name")
+	}
+	if len(l.Plugins) == 0 {
+		return fmt.Errorf("CNI configuration %s has no plugins", l.Name)
+	}
+	for i, p := range l.Plugins {
+		if t, _ := p["type"].(string); t == "" {
+			return fmt.Errorf("plugin %d in CNI configuration %s has no type", i, l.Name)
+		}
+	}
+	return nil
+}
+
+// loadConfList looks up the network configuration with the given name in the
+// configuration directory. Files are examined in lexical order, as CNI runtimes do.
+func loadConfList(dir, name string) (*netConfList, []byte, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read CNI configuration directory %s: %v", dir, err)
+	}
+	names := make([]string, 0, len(files))
+	for _, f := range files {
+		switch filepath.Ext(f.Name()) {
+		case ".conf", ".conflist", ".json":
+			names = append(names, f.Name())
+		}
+	}
+	sort.Strings(names)
+	for _, fn := range names {
+		b, err := ioutil.ReadFile(filepath.Join(dir, fn))
+		if err != nil {
+			return nil, nil, err
+		}
+		l, err := parseConfList(b)
+		if err != nil {
+			logrus.Warnf("cni: skipping invalid configuration file %s: %v", fn, err)
+			continue
+		}
+		if l.Name == name {
+			return l, b, nil
+		}
+	}
+	return nil, nil, fmt.Errorf("CNI network configuration %q not found in %s", name, dir)
+}
+
+func (d *driver) findPlugin(pluginType string) (string, error) {
+	for _, dir := range d.binDirs {
+		p := filepath.Join(dir, pluginType)
+		if fi, err := os.Stat(p); err == nil && !fi.IsDir() {
+			return p, nil
+		}
+	}
+	return "", fmt.Errorf("CNI plugin %s not found in %s", pluginType, strings.Join(d.binDirs, ":"))
+}
+
+// pluginConf renders the configuration passed on stdin to a plugin of the
+// chain, injecting the list name and version and the previous result
+func (l *netConfList) pluginConf(i int, prevResult []byte) ([]byte, error) {
+	conf := make(map[string]interface{}, len(l.Plugins[i])+3)
+	for k, v := range l.Plugins[i] {
+		conf[k] = v
+	}
+	conf["name"] = l.Name
+	conf["cniVersion"] = l.CNIVersion
+	if len(prevResult) > 0 {
+		conf["prevResult"] = json.RawMessage(prevResult)
+	}
+	return json.Marshal(conf)
+}
+
+func (d *driver) execPlugin(cmd string, l *netConfList, i int, rt *runtimeConf, prevResult []byte) ([]byte, error) {
+	pluginType := l.Plugins[i]["type"].(string)
+	path, err := d.findPlugin(pluginType)
+	if err != nil {
+		return nil, err
+	}
+	stdin, err := l.pluginConf(i, prevResult)
+	if err != nil {
+		return nil, err
+	}
+
+	// Plugins fail on the arguments they do not know of unless told to
+	// ignore them, as the CNI conventions require runtimes to do
+	args := make([]string, 0, len(rt.args)+1)
+	args = append(args, "IgnoreUnknown=1")
+	for _, kv := range rt.args {
+		args = append(args, kv[0]+"="+kv[1])
+	}
+
+	c := exec.Command(path)
+	c.Env = append(os.Environ(),
+		"CNI_COMMAND="+cmd,
+		"CNI_CONTAINERID="+rt.containerID,
+		"CNI_NETNS="+rt.netns,
+		"CNI_IFNAME="+rt.ifName,
+		"CNI_ARGS="+strings.Join(args, ";"),
+		"CNI_PATH="+strings.Join(d.binDirs, string(filepath.ListSeparator)),
+	)
+	c.Stdin = bytes.NewReader(stdin)
+	var stdout, stderr bytes.Buffer
+	c.Stdout = &stdout
+	c.Stderr = &stderr
+	if err := c.Run(); err != nil {
+		cerr := &cniError{}
+		if jerr := json.Unmarshal(stdout.Bytes(), cerr); jerr == nil && cerr.Msg != "" {
+			return nil, fmt.Errorf("CNI plugin %s %s failed: %v", pluginType, cmd, cerr)
+		}
+		return nil, fmt.Errorf("CNI plugin %s %s failed: %v: %s", pluginType, cmd, err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.Bytes(), nil
+}
+
+// addChain runs the ADD command through the plugin chain and returns the final result
+func (d *driver) addChain(l *netConfList, rt *runtimeConf) ([]byte, error) {
+	var prev []byte
+	for i := range l.Plugins {
+		out, err := d.execPlugin(cmdAdd, l, i, rt, prev)
+		if err != nil {
+			// Undo what the preceding plugins did
+			if derr := d.del(l, rt, prev, i); derr != nil {
+				return nil, fmt.Errorf("%v; rollback failed: %v", err, derr)
+			}
+			return nil, err
+		}
+		if len(bytes.TrimSpace(out)) > 0 {
+			prev = out
+		}
+	}
+	return prev, nil
+}
+
+// del runs the DEL command through the first n plugins of the chain, in
+// reverse order. All plugins are invoked even if some fail.
+func (d *driver) del(l *netConfList, rt *runtimeConf, prevResult []byte, n int) error {
+	var errs []string
+	for i := n - 1; i >= 0; i-- {
+		if _, err := d.execPlugin(cmdDel, l, i, rt, prevResult); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return nil
+}
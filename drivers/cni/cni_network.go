@@ -0,0 +1,114 @@
+package cni
+
+import (
+	"fmt"
+
+	"github.com/docker/libnetwork/driverapi"
+	"github.com/docker/libnetwork/netlabel"
+	"github.com/docker/libnetwork/types"
+	"github.com/sirupsen/logrus"
+)
+
+// CreateNetwork binds the network to a CNI network configuration, selected by
+// name from the configuration directory or passed inline
+func (d *driver) CreateNetwork(nid string, option map[string]interface{}, nInfo driverapi.NetworkInfo, ipV4Data, ipV6Data []driverapi.IPAMData) error {
+	config, err := d.parseNetworkOptions(nid, option)
+	if err != nil {
+		return err
+	}
+	if err := d.createNetwork(config); err != nil {
+		return err
+	}
+	// update persistent db, rollback on fail
+	if err := d.storeUpdate(config); err != nil {
+		d.deleteNetwork(nid)
+		logrus.Debugf("encountered an error rolling back a network create for %s : %v", nid, err)
+		return err
+	}
+
+	return nil
+}
+
+func (d *driver) createNetwork(config *configuration) error {
+	if config.list == nil {
+		l, err := parseConfList([]byte(config.ConfList))
+		if err != nil {
+			return err
+		}
+		config.list = l
+	}
+	d.Lock()
+	defer d.Unlock()
+	if _, ok := d.networks[config.ID]; ok {
+		return types.ForbiddenErrorf("network %s exists", config.ID)
+	}
+	d.networks[config.ID] = &network{
+		id:        config.ID,
+		config:    config,
+		endpoints: endpointTable{},
+	}
+
+	return nil
+}
+
+func (d *driver) deleteNetwork(nid string) {
+	d.Lock()
+	delete(d.networks, nid)
+	d.Unlock()
+}
+
+// DeleteNetwork deletes the network for the specified driver type
+func (d *driver) DeleteNetwork(nid string) error {
+	n, err := d.getNetwork(nid)
+	if err != nil {
+		return err
+	}
+	n.Lock()
+	for _, ep := range n.endpoints {
+		if err := d.storeDelete(ep); err != nil {
+			logrus.Warnf("Failed to remove cni endpoint %.7s from store: %v", ep.id, err)
+		}
+	}
+	n.Unlock()
+	d.deleteNetwork(nid)
+
+	if err := d.storeDelete(n.config); err != nil {
+		return fmt.Errorf("error deleting id %s from datastore: %v", nid, err)
+	}
+	return nil
+}
+
+// parseNetworkOptions parses docker network options
+func (d *driver) parseNetworkOptions(nid string, option map[string]interface{}) (*configuration, error) {
+	var opts map[string]string
+	if genData, ok := option[netlabel.GenericData]; ok && genData != nil {
+		if opts, ok = genData.(map[string]string); !ok {
+			return nil, types.BadRequestErrorf("unrecognized network configuration format: %v", genData)
+		}
+	}
+
+	config := &configuration{ID: nid, IfName: opts[ifNameOpt]}
+	name, inline := opts[networkOpt], opts[configOpt]
+	switch {
+	case name != "" && inline != "":
+		return nil, types.BadRequestErrorf("options %s and %s are mutually exclusive", networkOpt, configOpt)
+	case inline != "":
+		l, err := parseConfList([]byte(inline))
+		if err != nil {
+			return nil, types.BadRequestErrorf("%v", err)
+		}
+		config.ConfList, config.list = inline, l
+	case name != "":
+		l, b, err := loadConfList(d.confDir, name)
+		if err != nil {
+			return nil, types.BadRequestErrorf("%v", err)
+		}
+		// The configuration is copied so that the network survives later
+		// changes to the file
+		config.ConfList, config.list = string(b), l
+	default:
+		return nil, types.BadRequestErrorf("one of the %s or %s options is required", networkOpt, configOpt)
+	}
+
+	return config, nil
+}
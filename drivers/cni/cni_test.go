@@ -0,0 +1,318 @@
+package cni
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/pkg/plugingetter"
+	"github.com/docker/libnetwork/driverapi"
+	"github.com/docker/libnetwork/netlabel"
+	_ "github.com/docker/libnetwork/testutils"
+	"github.com/docker/libnetwork/types"
+)
+
+const fakePlugin = `#!/bin/sh
+echo "$CNI_COMMAND $CNI_CONTAINERID $CNI_NETNS $CNI_IFNAME $CNI_ARGS" >> "$(dirname "$0")/calls.log"
+cat > /dev/null
+if [ "$CNI_COMMAND" = "ADD" ]; then
+	echo '{"cniVersion":"0.4.0","ips":[{"version":"4","address":"10.1.0.5/24"}]}'
+fi
+`
+
+type driverTester struct {
+	t *testing.T
+	d *driver
+}
+
+func (dt *driverTester) GetPluginGetter() plugingetter.PluginGetter {
+	return nil
+}
+
+func (dt *driverTester) RegisterDriver(name string, drv driverapi.Driver, cap driverapi.Capability) error {
+	if name != cniType {
+		dt.t.Fatalf("Expected driver register name to be %q. Instead got %q", cniType, name)
+	}
+	dt.d = drv.(*driver)
+	return nil
+}
+
+type joinInfo struct {
+	disabledGw bool
+	driverapi.JoinInfo
+}
+
+func (j *joinInfo) DisableGatewayService() {
+	j.disabledGw = true
+}
+
+func setup(t *testing.T) (string, *driver) {
+	dir, err := ioutil.TempDir("", "cni")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "fake"), []byte(fakePlugin), 0755); err != nil {
+		t.Fatal(err)
+	}
+	conf := `{"cniVersion":"0.4.0","name":"testnet","plugins":[{"type":"fake"},{"type":"fake","chained":true}]}`
+	if err := ioutil.WriteFile(filepath.Join(dir, "10-test.conflist"), []byte(conf), 0644); err != nil {
+		t.Fatal(err)
+	}
+	dt := &driverTester{t: t}
+	if err := Init(dt, map[string]interface{}{ConfDirLabel: dir, BinDirLabel: dir}); err != nil {
+		t.Fatal(err)
+	}
+	return dir, dt.d
+}
+
+func TestCNIJoinLeave(t *testing.T) {
+	dir, d := setup(t)
+	defer os.RemoveAll(dir)
+
+	opts := map[string]interface{}{netlabel.GenericData: map[string]string{networkOpt: "testnet"}}
+	if err := d.CreateNetwork("net1", opts, nil, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.CreateEndpoint("net1", "ep1", nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	ji := &joinInfo{}
+	if err := d.Join("net1", "ep1", "/var/run/netns/sb1", ji, nil); err != nil {
+		t.Fatal(err)
+	}
+	if !ji.disabledGw {
+		t.Fatal("expected gateway service to be disabled")
+	}
+	info, err := d.EndpointOperInfo("net1", "ep1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ips := info["cni.ips"].([]string); len(ips) != 1 || ips[0] != "10.1.0.5/24" {
+		t.Fatalf("unexpected endpoint addresses: %v", info["cni.ips"])
+	}
+	if err := d.Leave("net1", "ep1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.DeleteEndpoint("net1", "ep1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.DeleteNetwork("net1"); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := ioutil.ReadFile(filepath.Join(dir, "calls.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	calls := strings.Split(strings.TrimSpace(string(b)), "\n")
+	if len(calls) != 4 {
+		t.Fatalf("expected 4 plugin calls, got %d: %v", len(calls), calls)
+	}
+	ifName := defaultIfName("ep1")
+	for i, cmd := range []string{"ADD", "ADD", "DEL", "DEL"} {
+		exp := cmd + " ep1 /var/run/netns/sb1 " + ifName + " IgnoreUnknown=1"
+		if strings.TrimSpace(calls[i]) != exp {
+			t.Fatalf("unexpected plugin call %d: %q, expected %q", i, calls[i], exp)
+		}
+	}
+}
+
+func readCalls(t *testing.T, dir string) []string {
+	b, err := ioutil.ReadFile(filepath.Join(dir, "calls.log"))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Split(strings.TrimSpace(string(b)), "\n")
+}
+
+func TestCNIJoinPendingNamespace(t *testing.T) {
+	dir, d := setup(t)
+	defer os.RemoveAll(dir)
+
+	opts := map[string]interface{}{netlabel.GenericData: map[string]string{networkOpt: "testnet"}}
+	if err := d.CreateNetwork("net1", opts, nil, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.CreateEndpoint("net1", "ep1", nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	ji := &joinInfo{}
+	if err := d.Join("net1", "ep1", "/var/run/netns/sb1", ji, map[string]interface{}{netlabel.NamespacePending: true}); err != nil {
+		t.Fatal(err)
+	}
+	if !ji.disabledGw {
+		t.Fatal("expected gateway service to be disabled")
+	}
+	if calls := readCalls(t, dir); len(calls) != 0 {
+		t.Fatalf("plugins run before the namespace is set: %v", calls)
+	}
+
+	// The key is set, then set anew
+	for i := 0; i < 2; i++ {
+		if err := d.JoinNamespace("net1", "ep1", "/var/run/netns/sb1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := d.Leave("net1", "ep1"); err != nil {
+		t.Fatal(err)
+	}
+	calls := readCalls(t, dir)
+	exp := []string{"ADD", "ADD", "DEL", "DEL", "ADD", "ADD", "DEL", "DEL"}
+	if len(calls) != len(exp) {
+		t.Fatalf("expected %d plugin calls, got %d: %v", len(exp), len(calls), calls)
+	}
+	for i, cmd := range exp {
+		if !strings.HasPrefix(calls[i], cmd+" ep1 /var/run/netns/sb1 ") {
+			t.Fatalf("unexpected plugin call %d: %q, expected %s", i, calls[i], cmd)
+		}
+	}
+
+	// Leaving before the key is set runs no plugin
+	if err := d.Join("net1", "ep1", "/var/run/netns/sb2", &joinInfo{}, map[string]interface{}{netlabel.NamespacePending: true}); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Leave("net1", "ep1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.DeleteEndpoint("net1", "ep1"); err != nil {
+		t.Fatal(err)
+	}
+	if calls := readCalls(t, dir); len(calls) != len(exp) {
+		t.Fatalf("unexpected plugin calls without a namespace: %v", calls[len(exp):])
+	}
+}
+
+// ipamInfo is the interface of an endpoint with the address libnetwork
+// allocated
+type ipamInfo struct {
+	driverapi.InterfaceInfo
+	addr *net.IPNet
+}
+
+func (i *ipamInfo) Address() *net.IPNet {
+	return i.addr
+}
+
+func (i *ipamInfo) AddressIPv6() *net.IPNet {
+	return nil
+}
+
+func TestCNIJoinAddressMismatch(t *testing.T) {
+	dir, d := setup(t)
+	defer os.RemoveAll(dir)
+
+	opts := map[string]interface{}{netlabel.GenericData: map[string]string{networkOpt: "testnet"}}
+	if err := d.CreateNetwork("net1", opts, nil, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	for eid, addr := range map[string]string{"ep1": "10.1.0.5/24", "ep2": "10.1.0.9/24"} {
+		ip, _ := types.ParseCIDR(addr)
+		if err := d.CreateEndpoint("net1", eid, &ipamInfo{addr: ip}, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The plugins assigned the address libnetwork allocated
+	if err := d.Join("net1", "ep1", "/var/run/netns/sb1", &joinInfo{}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// The plugins assigned another address: the join fails and is undone
+	if err := d.Join("net1", "ep2", "/var/run/netns/sb2", &joinInfo{}, nil); err == nil {
+		t.Fatal("join succeeded with the address of the plugins differing from the IPAM one")
+	}
+	n, err := d.getNetwork("net1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ep := n.endpoint("ep2"); ep.netns != "" || ep.result != nil {
+		t.Fatalf("endpoint left joined: %+v", ep)
+	}
+	b, err := ioutil.ReadFile(filepath.Join(dir, "calls.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var dels int
+	for _, call := range strings.Split(strings.TrimSpace(string(b)), "\n") {
+		if strings.HasPrefix(call, "DEL ep2 /var/run/netns/sb2 ") {
+			dels++
+		}
+	}
+	if dels != 2 {
+		t.Fatalf("expected the 2 plugins to be called with DEL, got calls %q", b)
+	}
+}
+
+const envPlugin = `#!/bin/sh
+env | grep '^CNI_' | sort > "$(dirname "$0")/env.log"
+cat > /dev/null
+`
+
+func TestCNIPluginEnv(t *testing.T) {
+	dir, d := setup(t)
+	defer os.RemoveAll(dir)
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "env"), []byte(envPlugin), 0755); err != nil {
+		t.Fatal(err)
+	}
+	opts := map[string]interface{}{netlabel.GenericData: map[string]string{configOpt: `{"cniVersion":"0.4.0","name":"envnet","type":"env"}`}}
+	if err := d.CreateNetwork("net1", opts, nil, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	ip, _ := types.ParseCIDR("10.1.0.5/24")
+	if err := d.CreateEndpoint("net1", "ep1", &ipamInfo{addr: ip}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Join("net1", "ep1", "/var/run/netns/sb1", &joinInfo{}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := ioutil.ReadFile(filepath.Join(dir, "env.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := strings.Join([]string{
+		"CNI_ARGS=IgnoreUnknown=1;IP=10.1.0.5",
+		"CNI_COMMAND=ADD",
+		"CNI_CONTAINERID=ep1",
+		"CNI_IFNAME=" + defaultIfName("ep1"),
+		"CNI_NETNS=/var/run/netns/sb1",
+		"CNI_PATH=" + dir,
+	}, "\n") + "\n"
+	if string(b) != exp {
+		t.Fatalf("unexpected plugin environment:\n%s\nexpected:\n%s", b, exp)
+	}
+}
+
+func TestCNINetworkOptions(t *testing.T) {
+	dir, d := setup(t)
+	defer os.RemoveAll(dir)
+
+	for _, o := range []map[string]string{
+		{},
+		{networkOpt: "missing"},
+		{configOpt: `{"name":"x"}`},
+		{networkOpt: "testnet", configOpt: `{"name":"x","type":"fake"}`},
+	} {
+		if err := d.CreateNetwork("bad", map[string]interface{}{netlabel.GenericData: o}, nil, nil, nil); err == nil {
+			t.Fatalf("expected failure for options %v", o)
+		}
+	}
+	opts := map[string]interface{}{netlabel.GenericData: map[string]string{configOpt: `{"name":"inline","type":"fake"}`}}
+	if err := d.CreateNetwork("net2", opts, nil, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	n, err := d.getNetwork("net2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n.config.list.Name != "inline" || len(n.config.list.Plugins) != 1 {
+		t.Fatalf("unexpected configuration %+v", n.config.list)
+	}
+}
@@ -4,10 +4,13 @@ import (
 	"net"
 	"sync"
 
+	"github.com/docker/libnetwork/bgp"
 	"github.com/docker/libnetwork/datastore"
 	"github.com/docker/libnetwork/discoverapi"
 	"github.com/docker/libnetwork/driverapi"
+	"github.com/docker/libnetwork/netlabel"
 	"github.com/docker/libnetwork/osl"
+	"github.com/docker/libnetwork/routeexport"
 	"github.com/docker/libnetwork/types"
 )
 
@@ -20,6 +23,9 @@ const (
 	modeL3              = "l3"     // ipvlan L3 mode
 	parentOpt           = "parent" // parent interface -o parent
 	modeOpt             = "_mode"  // ipvlan mode ux opt suffix
+	// routeExporterOpt names the route exporter of the daemon the routes
+	// of the L3 endpoints are exported to -o route_exporter
+	routeExporterOpt = "route_exporter"
 )
 
 var driverModeOpt = ipvlanType + modeOpt // mode -o ipvlan_mode
@@ -32,7 +38,8 @@ type driver struct {
 	networks networkTable
 	sync.Once
 	sync.Mutex
-	store datastore.DataStore
+	store     datastore.DataStore
+	exporters map[string]routeexport.Exporter
 }
 
 type endpoint struct {
@@ -52,6 +59,7 @@ type network struct {
 	endpoints endpointTable
 	driver    *driver
 	config    *configuration
+	speaker   *bgp.Speaker
 	sync.Mutex
 }
 
@@ -64,6 +72,9 @@ func Init(dc driverapi.DriverCallback, config map[string]interface{}) error {
 	d := &driver{
 		networks: networkTable{},
 	}
+	if e, ok := config[netlabel.RouteExporters].(map[string]routeexport.Exporter); ok {
+		d.exporters = e
+	}
 	d.initStore(config)
 
 	return dc.RegisterDriver(ipvlanType, d, c)
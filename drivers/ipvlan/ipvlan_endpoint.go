@@ -57,6 +57,7 @@ func (d *driver) CreateEndpoint(nid, eid string, ifInfo driverapi.InterfaceInfo,
 	}
 
 	n.addEndpoint(ep)
+	n.advertise(ep)
 
 	return nil
 }
@@ -81,6 +82,7 @@ func (d *driver) DeleteEndpoint(nid, eid string) error {
 		}
 	}
 
+	n.withdraw(ep)
 	if err := d.storeDelete(ep); err != nil {
 		logrus.Warnf("Failed to remove ipvlan endpoint %.7s from store: %v", ep.id, err)
 	}
@@ -5,6 +5,7 @@ import (
 
 	"github.com/docker/docker/pkg/parsers/kernel"
 	"github.com/docker/docker/pkg/stringid"
+	"github.com/docker/libnetwork/bgp"
 	"github.com/docker/libnetwork/driverapi"
 	"github.com/docker/libnetwork/netlabel"
 	"github.com/docker/libnetwork/ns"
@@ -50,6 +51,18 @@ func (d *driver) CreateNetwork(nid string, option map[string]interface{}, nInfo
 	default:
 		return fmt.Errorf("requested ipvlan mode '%s' is not valid, 'l2' mode is the ipvlan driver default", config.IpvlanMode)
 	}
+	// the endpoints are routed to only in L3 mode
+	if config.BGP != nil && config.IpvlanMode != modeL3 {
+		return fmt.Errorf("bgp advertisement requires the ipvlan '%s' mode", modeL3)
+	}
+	if config.RouteExporter != "" {
+		if config.IpvlanMode != modeL3 {
+			return fmt.Errorf("route export requires the ipvlan '%s' mode", modeL3)
+		}
+		if _, ok := d.exporters[config.RouteExporter]; !ok {
+			return fmt.Errorf("route exporter %q is not configured", config.RouteExporter)
+		}
+	}
 	// loopback is not a valid parent link
 	if config.Parent == "lo" {
 		return fmt.Errorf("loopback interface is not a valid %s parent link", ipvlanType)
@@ -114,6 +127,9 @@ func (d *driver) createNetwork(config *configuration) error {
 		endpoints: endpointTable{},
 		config:    config,
 	}
+	if err := n.startSpeaker(); err != nil {
+		return err
+	}
 	// add the *network
 	d.addNetwork(n)
 
@@ -159,6 +175,8 @@ func (d *driver) DeleteNetwork(nid string) error {
 			logrus.Warnf("Failed to remove ipvlan endpoint %.7s from store: %v", ep.id, err)
 		}
 	}
+	// withdraw the routes of the endpoints before they go away
+	n.stopSpeaker()
 	// delete the *network
 	d.deleteNetwork(nid)
 	// delete the network record from persistent cache
@@ -223,7 +241,18 @@ func (config *configuration) fromOptions(labels map[string]string) error {
 		case driverModeOpt:
 			// parse driver option '-o ipvlan_mode'
 			config.IpvlanMode = value
+		case routeExporterOpt:
+			// parse driver option '-o route_exporter'
+			config.RouteExporter = value
+		}
+	}
+	// parse the driver options '-o bgp_*'
+	if bgp.IsConfigured(labels) {
+		c, err := bgp.ParseOptions(labels)
+		if err != nil {
+			return err
 		}
+		config.BGP = c
 	}
 	return nil
 }
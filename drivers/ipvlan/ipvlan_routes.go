@@ -0,0 +1,83 @@
+package ipvlan
+
+import (
+	"net"
+
+	"github.com/docker/libnetwork/bgp"
+	"github.com/docker/libnetwork/routeexport"
+	"github.com/sirupsen/logrus"
+)
+
+// startSpeaker starts the bgp speaker advertising the endpoints of an L3
+// network to the upstream routers, the L3 parent routing to them
+func (n *network) startSpeaker() error {
+	if n.config.BGP == nil {
+		return nil
+	}
+	s, err := bgp.NewSpeaker(n.config.BGP)
+	if err != nil {
+		return err
+	}
+	n.Lock()
+	n.speaker = s
+	n.Unlock()
+	logrus.Debugf("Started the bgp speaker of ipvlan network %.7s", n.id)
+	return nil
+}
+
+// stopSpeaker withdraws the routes of the network and stops its speaker
+func (n *network) stopSpeaker() {
+	n.Lock()
+	s := n.speaker
+	n.speaker = nil
+	n.Unlock()
+	if s != nil {
+		s.Stop()
+	}
+}
+
+// advertise announces the routes of the endpoint to the bgp peers and to
+// the route exporter of the network
+func (n *network) advertise(ep *endpoint) {
+	n.Lock()
+	s := n.speaker
+	n.Unlock()
+	if s != nil && ep.addr != nil {
+		s.Advertise(ep.addr.IP)
+	}
+	n.exportRoutes(ep, true)
+}
+
+func (n *network) withdraw(ep *endpoint) {
+	n.Lock()
+	s := n.speaker
+	n.Unlock()
+	if s != nil && ep.addr != nil {
+		s.Withdraw(ep.addr.IP)
+	}
+	n.exportRoutes(ep, false)
+}
+
+// exportRoutes exports or withdraws the host routes of the endpoint, out
+// of the parent the L3 endpoints are reached through
+func (n *network) exportRoutes(ep *endpoint, export bool) {
+	e, ok := n.driver.exporters[n.config.RouteExporter]
+	if !ok {
+		return
+	}
+	for _, addr := range []*net.IPNet{ep.addr, ep.addrv6} {
+		if addr == nil {
+			continue
+		}
+		r := routeexport.HostRoute(n.id, addr.IP, nil, n.config.Parent)
+		var err error
+		if export {
+			err = e.ExportRoute(r)
+		} else {
+			err = e.WithdrawRoute(r)
+		}
+		if err != nil {
+			logrus.Warnf("Failed to export the route of ipvlan endpoint %.7s: %v", ep.id, err)
+		}
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"net"
 
+	"github.com/docker/libnetwork/bgp"
 	"github.com/docker/libnetwork/datastore"
 	"github.com/docker/libnetwork/discoverapi"
 	"github.com/docker/libnetwork/netlabel"
@@ -30,6 +31,8 @@ type configuration struct {
 	CreatedSlaveLink bool
 	Ipv4Subnets      []*ipv4Subnet
 	Ipv6Subnets      []*ipv6Subnet
+	BGP              *bgp.Config
+	RouteExporter    string
 }
 
 type ipv4Subnet struct {
@@ -103,6 +106,7 @@ func (d *driver) populateEndpoints() error {
 			continue
 		}
 		n.endpoints[ep.id] = ep
+		n.advertise(ep)
 		logrus.Debugf("Endpoint (%.7s) restored to network (%.7s)", ep.id, ep.nid)
 	}
 
@@ -164,6 +168,16 @@ func (config *configuration) MarshalJSON() ([]byte, error) {
 		}
 		nMap["Ipv6Subnets"] = string(iis)
 	}
+	if config.RouteExporter != "" {
+		nMap["RouteExporter"] = config.RouteExporter
+	}
+	if config.BGP != nil {
+		b, err := json.Marshal(config.BGP)
+		if err != nil {
+			return nil, err
+		}
+		nMap["BGP"] = string(b)
+	}
 
 	return json.Marshal(nMap)
 }
@@ -193,6 +207,15 @@ func (config *configuration) UnmarshalJSON(b []byte) error {
 			return err
 		}
 	}
+	if v, ok := nMap["RouteExporter"]; ok {
+		config.RouteExporter = v.(string)
+	}
+	if v, ok := nMap["BGP"]; ok {
+		config.BGP = &bgp.Config{}
+		if err := json.Unmarshal([]byte(v.(string)), config.BGP); err != nil {
+			return err
+		}
+	}
 
 	return nil
 }
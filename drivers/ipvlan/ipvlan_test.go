@@ -1,10 +1,14 @@
 package ipvlan
 
 import (
+	"net"
+	"reflect"
 	"testing"
 
 	"github.com/docker/docker/pkg/plugingetter"
+	"github.com/docker/libnetwork/bgp"
 	"github.com/docker/libnetwork/driverapi"
+	"github.com/docker/libnetwork/routeexport"
 	_ "github.com/docker/libnetwork/testutils"
 )
 
@@ -63,3 +67,68 @@ func TestIpvlanType(t *testing.T) {
 			dt.d.Type())
 	}
 }
+
+func TestIpvlanBGPOptions(t *testing.T) {
+	config := &configuration{}
+	if err := config.fromOptions(map[string]string{
+		driverModeOpt:   modeL3,
+		bgp.ASNOption:   "65000",
+		bgp.PeersOption: "10.0.0.1:65001",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if config.BGP == nil || config.BGP.ASN != 65000 || len(config.BGP.Peers) != 1 {
+		t.Fatalf("unexpected bgp configuration %+v", config.BGP)
+	}
+
+	restored := &configuration{}
+	if err := restored.SetValue(config.Value()); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(restored.BGP, config.BGP) {
+		t.Fatalf("bgp configuration not restored: %+v", restored.BGP)
+	}
+
+	if err := (&configuration{}).fromOptions(map[string]string{bgp.ASNOption: "65000"}); err == nil {
+		t.Fatal("expected an error for a bgp configuration without peers")
+	}
+}
+
+type fakeExporter struct {
+	routes map[string]bool
+}
+
+func (e *fakeExporter) ExportRoute(r routeexport.Route) error {
+	e.routes[r.String()] = true
+	return nil
+}
+
+func (e *fakeExporter) WithdrawRoute(r routeexport.Route) error {
+	delete(e.routes, r.String())
+	return nil
+}
+
+func TestIpvlanExportRoutes(t *testing.T) {
+	e := &fakeExporter{routes: map[string]bool{}}
+	d := &driver{exporters: map[string]routeexport.Exporter{"frr": e}}
+	n := &network{
+		id:     "n1",
+		driver: d,
+		config: &configuration{Parent: "eth0", IpvlanMode: modeL3, RouteExporter: "frr"},
+	}
+	ep := &endpoint{
+		id:     "ep1",
+		addr:   &net.IPNet{IP: net.ParseIP("172.16.0.5"), Mask: net.CIDRMask(24, 32)},
+		addrv6: &net.IPNet{IP: net.ParseIP("fd00::5"), Mask: net.CIDRMask(64, 128)},
+	}
+
+	n.advertise(ep)
+	expected := map[string]bool{"172.16.0.5/32 dev eth0": true, "fd00::5/128 dev eth0": true}
+	if !reflect.DeepEqual(e.routes, expected) {
+		t.Fatalf("unexpected routes %v", e.routes)
+	}
+	n.withdraw(ep)
+	if len(e.routes) != 0 {
+		t.Fatalf("routes not withdrawn: %v", e.routes)
+	}
+}
@@ -22,6 +22,10 @@ const (
 	modePassthru        = "passthru" // macvlan mode passthrough
 	parentOpt           = "parent"   // parent interface -o parent
 	modeOpt             = "_mode"    // macvlan mode ux opt suffix
+	// dadTimeoutOpt is the time the endpoint addresses are probed for on
+	// the parent before a join, for the joins of an address another host
+	// uses to fail
+	dadTimeoutOpt = "dad_timeout"
 )
 
 var driverModeOpt = macvlanType + modeOpt // mode --option macvlan_mode
@@ -38,6 +38,19 @@ func (d *driver) Join(nid, eid string, sboxKey string, jinfo driverapi.JoinInfo,
 	if ep == nil {
 		return fmt.Errorf("could not find endpoint with id %s", eid)
 	}
+	// probe the address on the parent, which gets the answers of the other
+	// hosts but not of the other macvlan interfaces of this one
+	if n.config.DADTimeout > 0 && ep.addr != nil {
+		if err := netutils.ProbeIPv4(n.config.Parent, ep.addr.IP, n.config.DADTimeout); err != nil {
+			if link, e := ns.NlHandle().LinkByName(vethName); e == nil {
+				ns.NlHandle().LinkDel(link)
+			}
+			if _, ok := err.(*netutils.AddressConflictError); ok {
+				return err
+			}
+			return fmt.Errorf("failed to probe address %s on %s: %v", ep.addr.IP, n.config.Parent, err)
+		}
+	}
 	// parse and match the endpoint address with the available v4 subnets
 	if len(n.config.Ipv4Subnets) > 0 {
 		s := n.getSubnetforIPv4(ep.addr)
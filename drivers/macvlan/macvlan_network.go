@@ -2,6 +2,7 @@ package macvlan
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/docker/docker/pkg/parsers/kernel"
 	"github.com/docker/docker/pkg/stringid"
@@ -229,6 +230,13 @@ func (config *configuration) fromOptions(labels map[string]string) error {
 		case driverModeOpt:
 			// parse driver option '-o macvlan_mode'
 			config.MacvlanMode = value
+		case dadTimeoutOpt:
+			// parse driver option '-o dad_timeout'
+			d, err := time.ParseDuration(value)
+			if err != nil || d < 0 {
+				return types.BadRequestErrorf("invalid %s value %q", dadTimeoutOpt, value)
+			}
+			config.DADTimeout = d
 		}
 	}
 
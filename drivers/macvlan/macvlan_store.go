@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net"
+	"time"
 
 	"github.com/docker/libnetwork/datastore"
 	"github.com/docker/libnetwork/discoverapi"
@@ -30,6 +31,7 @@ type configuration struct {
 	CreatedSlaveLink bool
 	Ipv4Subnets      []*ipv4Subnet
 	Ipv6Subnets      []*ipv6Subnet
+	DADTimeout       time.Duration
 }
 
 type ipv4Subnet struct {
@@ -150,6 +152,9 @@ func (config *configuration) MarshalJSON() ([]byte, error) {
 	nMap["MacvlanMode"] = config.MacvlanMode
 	nMap["Internal"] = config.Internal
 	nMap["CreatedSubIface"] = config.CreatedSlaveLink
+	if config.DADTimeout != 0 {
+		nMap["DADTimeout"] = config.DADTimeout.String()
+	}
 	if len(config.Ipv4Subnets) > 0 {
 		iis, err := json.Marshal(config.Ipv4Subnets)
 		if err != nil {
@@ -183,6 +188,11 @@ func (config *configuration) UnmarshalJSON(b []byte) error {
 	config.MacvlanMode = nMap["MacvlanMode"].(string)
 	config.Internal = nMap["Internal"].(bool)
 	config.CreatedSlaveLink = nMap["CreatedSubIface"].(bool)
+	if v, ok := nMap["DADTimeout"]; ok {
+		if config.DADTimeout, err = time.ParseDuration(v.(string)); err != nil {
+			return err
+		}
+	}
 	if v, ok := nMap["Ipv4Subnets"]; ok {
 		if err := json.Unmarshal([]byte(v.(string)), &config.Ipv4Subnets); err != nil {
 			return err
@@ -2,6 +2,7 @@ package macvlan
 
 import (
 	"testing"
+	"time"
 
 	"github.com/docker/docker/pkg/plugingetter"
 	"github.com/docker/libnetwork/driverapi"
@@ -63,3 +64,25 @@ func TestMacvlanType(t *testing.T) {
 			dt.d.Type())
 	}
 }
+
+func TestMacvlanDADTimeout(t *testing.T) {
+	config := &configuration{}
+	if err := config.fromOptions(map[string]string{parentOpt: "eth0", dadTimeoutOpt: "500ms"}); err != nil {
+		t.Fatal(err)
+	}
+	if config.DADTimeout != 500*time.Millisecond {
+		t.Fatalf("unexpected timeout %s", config.DADTimeout)
+	}
+	if err := (&configuration{}).fromOptions(map[string]string{dadTimeoutOpt: "soon"}); err == nil {
+		t.Fatal("invalid timeout accepted")
+	}
+
+	config.ID = "net1"
+	restored := &configuration{}
+	if err := restored.SetValue(config.Value()); err != nil {
+		t.Fatal(err)
+	}
+	if restored.DADTimeout != config.DADTimeout {
+		t.Fatalf("timeout not restored: %s", restored.DADTimeout)
+	}
+}
@@ -0,0 +1,193 @@
+package overlay
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/docker/libnetwork/types"
+)
+
+const (
+	// arpRateOption caps the rate, per second, of the ARP misses of the
+	// network resolved through the cluster, zero lifting the cap
+	arpRateOption = "arp_rate"
+	// unknownUnicastFloodOption, when false, stops the bridges of the
+	// network from flooding the unknown unicast frames to the VTEPs
+	unknownUnicastFloodOption = "unknown_unicast_flood"
+
+	defaultARPRate = 100
+	// missHoldTime is how long an address which failed to resolve is not
+	// looked up again
+	missHoldTime = 5 * time.Second
+	// maxHeldMisses bounds the addresses held, the oldest being dropped
+	maxHeldMisses = 4096
+)
+
+// missLimiter rate limits the resolutions of the ARP and fdb misses the
+// vxlan interfaces notify, each of which queries the cluster. The misses
+// of the addresses which just failed to resolve are dropped too, so that
+// a container scanning its subnet does not load the whole segment.
+type missLimiter struct {
+	sync.Mutex
+	rate   int
+	tokens float64
+	last   time.Time
+	held   map[string]time.Time
+	stats  missStats
+}
+
+// missStats are the counters of the misses of a network
+type missStats struct {
+	received    uint64
+	resolved    uint64
+	unresolved  uint64
+	rateLimited uint64
+	held        uint64
+}
+
+func newMissLimiter(rate int) *missLimiter {
+	return &missLimiter{
+		rate:   rate,
+		tokens: float64(rate),
+		held:   map[string]time.Time{},
+	}
+}
+
+func parseARPRate(val string) (int, error) {
+	rate, err := strconv.Atoi(val)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse %v: %v", val, err)
+	}
+	if rate < 0 {
+		return 0, types.BadRequestErrorf("invalid ARP rate %d: it must not be negative", rate)
+	}
+	return rate, nil
+}
+
+// allow tells if the miss of the address is resolved, consuming a token
+func (l *missLimiter) allow(ip net.IP, now time.Time) bool {
+	l.Lock()
+	defer l.Unlock()
+
+	l.stats.received++
+	if until, ok := l.held[ip.String()]; ok {
+		if now.Before(until) {
+			l.stats.held++
+			return false
+		}
+		delete(l.held, ip.String())
+	}
+
+	if l.rate == 0 {
+		return true
+	}
+	if !l.last.IsZero() {
+		l.tokens += now.Sub(l.last).Seconds() * float64(l.rate)
+		if l.tokens > float64(l.rate) {
+			l.tokens = float64(l.rate)
+		}
+	}
+	l.last = now
+	if l.tokens < 1 {
+		l.stats.rateLimited++
+		return false
+	}
+	l.tokens--
+	return true
+}
+
+// done accounts for the outcome of a resolution, holding the address
+// when it failed
+func (l *missLimiter) done(ip net.IP, resolved bool, now time.Time) {
+	l.Lock()
+	defer l.Unlock()
+
+	if resolved {
+		l.stats.resolved++
+		return
+	}
+	l.stats.unresolved++
+	if len(l.held) >= maxHeldMisses {
+		l.expireHeld(now)
+	}
+	l.held[ip.String()] = now.Add(missHoldTime)
+}
+
+// expireHeld drops the expired addresses, and the oldest half when none
+// expired. Must be called with the limiter locked.
+func (l *missLimiter) expireHeld(now time.Time) {
+	for ip, until := range l.held {
+		if !now.Before(until) {
+			delete(l.held, ip)
+		}
+	}
+	if len(l.held) < maxHeldMisses {
+		return
+	}
+	ips := make([]string, 0, len(l.held))
+	for ip := range l.held {
+		ips = append(ips, ip)
+	}
+	sort.Slice(ips, func(i, j int) bool { return l.held[ips[i]].Before(l.held[ips[j]]) })
+	for _, ip := range ips[:len(ips)/2] {
+		delete(l.held, ip)
+	}
+}
+
+func (l *missLimiter) statistics() map[string]uint64 {
+	l.Lock()
+	defer l.Unlock()
+	return map[string]uint64{
+		"miss_received":     l.stats.received,
+		"miss_resolved":     l.stats.resolved,
+		"miss_unresolved":   l.stats.unresolved,
+		"miss_rate_limited": l.stats.rateLimited,
+		"miss_held":         l.stats.held,
+	}
+}
+
+// limiter returns the miss limiter of the network, created on first use
+// as the networks restored from the store have none
+func (n *network) limiter() *missLimiter {
+	n.Lock()
+	defer n.Unlock()
+	if n.misses == nil {
+		n.misses = newMissLimiter(n.arpRate)
+	}
+	return n.misses
+}
+
+// NetworkStatistics returns the counters of the misses and of the learned
+// peers of the network, and the sizes of its host-gw tables
+func (d *driver) NetworkStatistics(nid string) (map[string]uint64, error) {
+	n := d.network(nid)
+	if n == nil {
+		return nil, types.NotFoundErrorf("network %s not found", nid)
+	}
+	stats := n.limiter().statistics()
+	for name, v := range n.learned().statistics() {
+		stats[name] = v
+	}
+	for name, v := range n.hostGWStatistics() {
+		stats[name] = v
+	}
+	return stats, nil
+}
+
+// setUnknownUnicastFlood sets whether the bridge of the subnet floods the
+// unknown unicast frames to its vxlan interface
+func (n *network) setUnknownUnicastFlood(s *subnet, flood bool) error {
+	nlh, link, err := vxlanLink(n.sbox, s.vxlanName)
+	if err != nil {
+		return err
+	}
+	defer nlh.Delete()
+	if err := nlh.LinkSetFlood(link, flood); err != nil {
+		return fmt.Errorf("failed to set the unknown unicast flooding of %s: %v", link.Attrs().Name, err)
+	}
+	return nil
+}
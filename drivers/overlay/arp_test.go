@@ -0,0 +1,72 @@
+package overlay
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestMissLimiter(t *testing.T) {
+	now := time.Now()
+	l := newMissLimiter(2)
+	ip := net.ParseIP("10.0.0.3")
+
+	if !l.allow(ip, now) || !l.allow(ip, now) {
+		t.Fatal("misses within the rate limited")
+	}
+	if l.allow(ip, now) {
+		t.Fatal("miss past the rate allowed")
+	}
+	if !l.allow(ip, now.Add(500*time.Millisecond)) {
+		t.Fatal("tokens not refilled")
+	}
+
+	// An unresolved address is held
+	unknown := net.ParseIP("10.0.0.200")
+	l.done(unknown, false, now)
+	later := now.Add(10 * time.Second)
+	if l.allow(unknown, now.Add(missHoldTime/2)) {
+		t.Fatal("held address allowed")
+	}
+	if !l.allow(unknown, later) {
+		t.Fatal("address still held past the hold time")
+	}
+	l.done(ip, true, later)
+
+	stats := l.statistics()
+	for name, expected := range map[string]uint64{
+		"miss_received":     6,
+		"miss_resolved":     1,
+		"miss_unresolved":   1,
+		"miss_rate_limited": 1,
+		"miss_held":         1,
+	} {
+		if stats[name] != expected {
+			t.Fatalf("unexpected %s %d, expected %d: %v", name, stats[name], expected, stats)
+		}
+	}
+}
+
+func TestMissLimiterUnlimited(t *testing.T) {
+	now := time.Now()
+	l := newMissLimiter(0)
+	for i := 0; i < 1000; i++ {
+		if !l.allow(net.ParseIP("10.0.0.3"), now) {
+			t.Fatal("miss limited without a rate")
+		}
+	}
+
+	for i := 0; i < maxHeldMisses+10; i++ {
+		l.done(net.IPv4(10, byte(i>>16), byte(i>>8), byte(i)), false, now.Add(time.Duration(i)))
+	}
+	if len(l.held) > maxHeldMisses {
+		t.Fatalf("held addresses not bounded: %d", len(l.held))
+	}
+
+	if _, err := parseARPRate("-1"); err == nil {
+		t.Fatal("expected an error for a negative rate")
+	}
+	if _, err := parseARPRate("fast"); err == nil {
+		t.Fatal("expected an error for an invalid rate")
+	}
+}
@@ -114,7 +114,7 @@ func (d *driver) checkEncryption(nid string, rIP net.IP, vxlanID uint32, isLocal
 
 	if add {
 		for _, rIP := range nodes {
-			if err := setupEncryption(lIP, aIP, rIP, vxlanID, d.secMap, d.keys); err != nil {
+			if err := setupEncryption(lIP, aIP, rIP, vxlanID, n.encryptionMatches(vxlanID), d.secMap, d.keys); err != nil {
 				logrus.Warnf("Failed to program network encryption between %s and %s: %v", lIP, rIP, err)
 			}
 		}
@@ -129,20 +129,19 @@ func (d *driver) checkEncryption(nid string, rIP net.IP, vxlanID uint32, isLocal
 	return nil
 }
 
-func setupEncryption(localIP, advIP, remoteIP net.IP, vni uint32, em *encrMap, keys []*key) error {
+func setupEncryption(localIP, advIP, remoteIP net.IP, vni uint32, matches []string, em *encrMap, keys []*key) error {
 	logrus.Debugf("Programming encryption for vxlan %d between %s and %s", vni, localIP, remoteIP)
 	rIPs := remoteIP.String()
 
 	indices := make([]*spi, 0, len(keys))
 
-	err := programMangle(vni, true)
-	if err != nil {
-		logrus.Warn(err)
-	}
-
-	err = programInput(vni, true)
-	if err != nil {
-		logrus.Warn(err)
+	for _, c := range matches {
+		if err := programMangle(c, true); err != nil {
+			logrus.Warn(err)
+		}
+		if err := programInput(c, true); err != nil {
+			logrus.Warn(err)
+		}
 	}
 
 	for i, k := range keys {
@@ -199,10 +198,11 @@ func removeEncryption(localIP, remoteIP net.IP, em *encrMap) error {
 	return nil
 }
 
-func programMangle(vni uint32, add bool) (err error) {
+// programMangle marks the vxlan packets matching the u32 expression c for
+// the encryption
+func programMangle(c string, add bool) (err error) {
 	var (
 		p      = strconv.FormatUint(uint64(overlayutils.VXLANUDPPort()), 10)
-		c      = fmt.Sprintf("0>>22&0x3C@12&0xFFFFFF00=%d", int(vni)<<8)
 		m      = strconv.FormatUint(uint64(r), 10)
 		chain  = "OUTPUT"
 		rule   = []string{"-p", "udp", "--dport", p, "-m", "u32", "--u32", c, "-j", "MARK", "--set-mark", m}
@@ -226,11 +226,12 @@ func programMangle(vni uint32, add bool) (err error) {
 	return
 }
 
-func programInput(vni uint32, add bool) (err error) {
+// programInput drops the vxlan packets matching the u32 expression c which
+// were not received encrypted
+func programInput(c string, add bool) (err error) {
 	var (
 		port       = strconv.FormatUint(uint64(overlayutils.VXLANUDPPort()), 10)
-		vniMatch   = fmt.Sprintf("0>>22&0x3C@12&0xFFFFFF00=%d", int(vni)<<8)
-		plainVxlan = []string{"-p", "udp", "--dport", port, "-m", "u32", "--u32", vniMatch, "-j"}
+		plainVxlan = []string{"-p", "udp", "--dport", port, "-m", "u32", "--u32", c, "-j"}
 		ipsecVxlan = append([]string{"-m", "policy", "--dir", "in", "--pol", "ipsec"}, plainVxlan...)
 		block      = append(plainVxlan, "DROP")
 		accept     = append(ipsecVxlan, "ACCEPT")
@@ -286,7 +287,7 @@ func programSA(localIP, remoteIP net.IP, spi *spi, k *key, dir int, add bool) (f
 
 		if add != exists {
 			logrus.Debugf("%s: rSA{%s}", action, rSA)
-			if err := xfrmProgram(rSA); err != nil {
+			if err := programState(xfrmProgram, rSA, add, true); err != nil {
 				logrus.Warnf("Failed %s rSA{%s}: %v", action, rSA, err)
 			}
 		}
@@ -312,7 +313,7 @@ func programSA(localIP, remoteIP net.IP, spi *spi, k *key, dir int, add bool) (f
 
 		if add != exists {
 			logrus.Debugf("%s fSA{%s}", action, fSA)
-			if err := xfrmProgram(fSA); err != nil {
+			if err := programState(xfrmProgram, fSA, add, false); err != nil {
 				logrus.Warnf("Failed %s fSA{%s}: %v.", action, fSA, err)
 			}
 		}
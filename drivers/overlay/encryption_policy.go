@@ -0,0 +1,150 @@
+package overlay
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/docker/libnetwork/labelparse"
+	"github.com/docker/libnetwork/types"
+)
+
+const (
+	// encryptedSubnetsOption restricts the encryption of the network to
+	// the listed subnets of the network
+	encryptedSubnetsOption = "encrypted_subnets"
+	// encryptedPairsOption restricts the encryption of the network to the
+	// traffic between the listed pairs of endpoint prefixes, as in
+	// 10.0.0.0/28-10.0.0.16/28
+	encryptedPairsOption = "encrypted_pairs"
+
+	// innerSrcOffset and innerDstOffset are the offsets, from the UDP
+	// header, of the addresses of the encapsulated IPv4 header past the
+	// UDP(8), VXLAN(8) and ethernet(14) headers
+	innerSrcOffset = 42
+	innerDstOffset = 46
+)
+
+// encryptionPair is a pair of endpoint prefixes the traffic between which
+// is encrypted, in both directions
+type encryptionPair struct {
+	a *net.IPNet
+	b *net.IPNet
+}
+
+func (p encryptionPair) String() string {
+	return p.a.String() + "-" + p.b.String()
+}
+
+// parseEncryptionPrefix parses a prefix of an encryption policy, a bare
+// address being a host prefix
+func parseEncryptionPrefix(val string) (*net.IPNet, error) {
+	return labelparse.ParseIPOrNet(strings.TrimSpace(val), labelparse.IPv4)
+}
+
+func parseEncryptedSubnets(val string) ([]*net.IPNet, error) {
+	elems, err := labelparse.ParseList("encrypted subnet", val)
+	if err != nil {
+		return nil, err
+	}
+	var subnets []*net.IPNet
+	for _, v := range elems {
+		s, err := parseEncryptionPrefix(v)
+		if err != nil {
+			return nil, err
+		}
+		subnets = append(subnets, s)
+	}
+	return subnets, nil
+}
+
+func parseEncryptedPairs(val string) ([]encryptionPair, error) {
+	elems, err := labelparse.ParseList("encryption pair", val)
+	if err != nil {
+		return nil, err
+	}
+	var pairs []encryptionPair
+	for _, v := range elems {
+		ends := strings.Split(v, "-")
+		if len(ends) != 2 {
+			return nil, types.BadRequestErrorf("invalid encryption pair %q: expected as in 10.0.0.0/28-10.0.0.16/28", v)
+		}
+		a, err := parseEncryptionPrefix(ends[0])
+		if err != nil {
+			return nil, err
+		}
+		b, err := parseEncryptionPrefix(ends[1])
+		if err != nil {
+			return nil, err
+		}
+		pairs = append(pairs, encryptionPair{a: a, b: b})
+	}
+	return pairs, nil
+}
+
+// validateEncryptionPolicy checks that the encrypted subnets are subnets
+// of the network
+func (n *network) validateEncryptionPolicy() error {
+	for _, es := range n.encSubnets {
+		found := false
+		for _, s := range n.subnets {
+			if types.CompareIPNet(es, s.subnetIP) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return types.BadRequestErrorf("the encrypted subnet %s is not a subnet of the network", es)
+		}
+	}
+	return nil
+}
+
+// selectiveEncryption tells if only part of the traffic of the network is
+// encrypted
+func (n *network) selectiveEncryption() bool {
+	return len(n.encSubnets) > 0 || len(n.encPairs) > 0
+}
+
+// vniMatch is the u32 match of the vxlan packets of the VNI
+func vniMatch(vni uint32) string {
+	return fmt.Sprintf("0>>22&0x3C@12&0xFFFFFF00=%d", int(vni)<<8)
+}
+
+// prefixMatch is the u32 match of the encapsulated address at the offset
+// being in the prefix
+func prefixMatch(offset int, prefix *net.IPNet) string {
+	ip := binary.BigEndian.Uint32(prefix.IP.To4())
+	mask := binary.BigEndian.Uint32(prefix.Mask)
+	return fmt.Sprintf("0>>22&0x3C@%d&0x%X=0x%X", offset, mask, ip&mask)
+}
+
+// encryptionMatches returns the u32 matches of the vxlan packets of the
+// VNI which are encrypted, none when the VNI is left in clear
+func (n *network) encryptionMatches(vni uint32) []string {
+	if !n.selectiveEncryption() {
+		return []string{vniMatch(vni)}
+	}
+
+	for _, s := range n.subnets {
+		if s.vni != vni {
+			continue
+		}
+		for _, es := range n.encSubnets {
+			if types.CompareIPNet(es, s.subnetIP) {
+				return []string{vniMatch(vni)}
+			}
+		}
+	}
+
+	var matches []string
+	for _, p := range n.encPairs {
+		for _, dir := range [][2]*net.IPNet{{p.a, p.b}, {p.b, p.a}} {
+			matches = append(matches, vniMatch(vni)+
+				"&&"+prefixMatch(innerSrcOffset, dir[0])+
+				"&&"+prefixMatch(innerDstOffset, dir[1]))
+		}
+	}
+	return matches
+}
@@ -0,0 +1,84 @@
+package overlay
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/docker/libnetwork/types"
+)
+
+func TestParseEncryptionPolicy(t *testing.T) {
+	subnets, err := parseEncryptedSubnets("10.0.0.0/24, 10.0.1.0/24")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(subnets) != 2 || subnets[1].String() != "10.0.1.0/24" {
+		t.Fatalf("unexpected subnets %v", subnets)
+	}
+
+	pairs, err := parseEncryptedPairs("10.0.0.0/28-10.0.0.16/28,10.0.0.5-10.0.0.6")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pairs) != 2 || pairs[0].String() != "10.0.0.0/28-10.0.0.16/28" || pairs[1].String() != "10.0.0.5/32-10.0.0.6/32" {
+		t.Fatalf("unexpected pairs %v", pairs)
+	}
+
+	for _, v := range []string{"10.0.0.0/28", "10.0.0.0/28-", "fd00::/64-10.0.0.0/24", "a-b"} {
+		if _, err := parseEncryptedPairs(v); err == nil {
+			t.Fatalf("expected an error parsing %q", v)
+		}
+	}
+	if _, err := parseEncryptedSubnets("10.0.0.0/33"); err == nil {
+		t.Fatal("expected an error parsing an invalid subnet")
+	}
+}
+
+func TestEncryptionMatches(t *testing.T) {
+	s1, _ := types.ParseCIDR("10.0.0.0/24")
+	s2, _ := types.ParseCIDR("10.0.1.0/24")
+	n := &network{subnets: []*subnet{{subnetIP: s1, vni: 256}, {subnetIP: s2, vni: 257}}}
+
+	// The whole network is encrypted by default
+	if m := n.encryptionMatches(257); !reflect.DeepEqual(m, []string{"0>>22&0x3C@12&0xFFFFFF00=65792"}) {
+		t.Fatalf("unexpected matches %v", m)
+	}
+
+	n.encSubnets, _ = parseEncryptedSubnets("10.0.0.0/24")
+	if err := n.validateEncryptionPolicy(); err != nil {
+		t.Fatal(err)
+	}
+	if m := n.encryptionMatches(256); !reflect.DeepEqual(m, []string{"0>>22&0x3C@12&0xFFFFFF00=65536"}) {
+		t.Fatalf("unexpected matches %v", m)
+	}
+	if m := n.encryptionMatches(257); len(m) != 0 {
+		t.Fatalf("unexpected matches of a clear subnet %v", m)
+	}
+
+	n.encPairs, _ = parseEncryptedPairs("10.0.1.0/28-10.0.1.20")
+	expected := []string{
+		"0>>22&0x3C@12&0xFFFFFF00=65792&&0>>22&0x3C@42&0xFFFFFFF0=0xA000100&&0>>22&0x3C@46&0xFFFFFFFF=0xA000114",
+		"0>>22&0x3C@12&0xFFFFFF00=65792&&0>>22&0x3C@42&0xFFFFFFFF=0xA000114&&0>>22&0x3C@46&0xFFFFFFF0=0xA000100",
+	}
+	if m := n.encryptionMatches(257); !reflect.DeepEqual(m, expected) {
+		t.Fatalf("unexpected matches:\n%v\nexpected:\n%v", m, expected)
+	}
+
+	n.encSubnets, _ = parseEncryptedSubnets("10.0.2.0/24")
+	if err := n.validateEncryptionPolicy(); err == nil {
+		t.Fatal("expected an error for an encrypted subnet out of the network")
+	}
+}
+
+func TestEncryptionPolicyStore(t *testing.T) {
+	n := &network{id: "n1", secure: true}
+	n.encSubnets, _ = parseEncryptedSubnets("10.0.0.0/24")
+	n.encPairs, _ = parseEncryptedPairs("10.0.1.0/28-10.0.1.16/28")
+	restored := &network{id: "n1"}
+	if err := restored.SetValue(n.Value()); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(restored.encSubnets, n.encSubnets) || !reflect.DeepEqual(restored.encPairs, n.encPairs) {
+		t.Fatalf("policy not restored: %v %v", restored.encSubnets, restored.encPairs)
+	}
+}
@@ -0,0 +1,56 @@
+package overlay
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/sirupsen/logrus"
+)
+
+// UplinkFailover checks the advertise address is still local and, when it
+// is held by the uplink, points the local peers at it again and programs
+// the neighbor and fdb entries of the remote peers again in the overlay
+// sandboxes, dropping the ones the kernel resolved through the old port.
+// The offloads of the uplink are set up again for its new port.
+func (d *driver) UplinkFailover(uplink string) error {
+	d.Lock()
+	advertiseAddress, bindAddress := d.advertiseAddress, d.bindAddress
+	d.Unlock()
+	if advertiseAddress == "" {
+		return nil
+	}
+	if err := validateSelf(advertiseAddress); err != nil {
+		return fmt.Errorf("uplink %s failed over: %v", uplink, err)
+	}
+
+	ip := net.ParseIP(bindAddress)
+	if ip == nil || ip.IsUnspecified() {
+		ip = net.ParseIP(advertiseAddress)
+	}
+	if dev, err := underlayDevice(ip); err == nil && dev != uplink {
+		return nil
+	}
+
+	d.peerDBUpdateSelf()
+
+	d.Lock()
+	nids := make([]string, 0, len(d.networks))
+	for nid := range d.networks {
+		nids = append(nids, nid)
+	}
+	d.Unlock()
+	for _, nid := range nids {
+		if n := d.network(nid); n != nil && n.sandbox() != nil {
+			d.initSandboxPeerDB(nid)
+		}
+	}
+
+	if d.vxlanOffload {
+		csum := setupTunnelOffload(ip)
+		d.Lock()
+		d.udpTunnelCsum = csum
+		d.Unlock()
+	}
+	logrus.Infof("Programmed the overlay peers again after the failover of uplink %s", uplink)
+	return nil
+}
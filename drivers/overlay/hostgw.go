@@ -0,0 +1,406 @@
+package overlay
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"syscall"
+
+	"github.com/docker/libnetwork/iptables"
+	"github.com/docker/libnetwork/netutils"
+	"github.com/docker/libnetwork/ns"
+	"github.com/docker/libnetwork/routeagg"
+	"github.com/sirupsen/logrus"
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netns"
+)
+
+// hostGWOption makes the network route the traffic to the peers on the
+// hosts of the local segment, instead of encapsulating it
+const hostGWOption = "host_gw"
+
+// hostGWAggregateOption makes the host-gw mode replace the host routes
+// of the peers sharing a next hop with aggregate routes
+const hostGWAggregateOption = "host_gw_aggregate"
+
+// The next hops of the host-gw tables but for the VTEPs of the routed
+// peers: the local endpoints are behind the host end of the veth pair,
+// the routed peers behind the sandbox end
+const (
+	hostGWLocalHop  = "local"
+	hostGWRoutedHop = "routed"
+)
+
+// hostGWTable holds the routes of a subnet of the network on the host
+// and in the sandbox. The addresses reached over the bridge or the vxlan
+// interface are reserved in the tables, so that no aggregate covers them.
+type hostGWTable struct {
+	subnet *net.IPNet
+	host   *routeagg.Table
+	sbox   *routeagg.Table
+}
+
+// hostGWTable returns the table of the subnet of the address, created on
+// its first use with the gateway reserved. Must be called with the
+// network lock.
+func (n *network) hostGWTable(ip net.IP) *hostGWTable {
+	if ip.To4() == nil {
+		return nil
+	}
+	for _, t := range n.hostGWTables {
+		if t.subnet.Contains(ip) {
+			return t
+		}
+	}
+	for _, s := range n.subnets {
+		if !s.subnetIP.Contains(ip) {
+			continue
+		}
+		t := &hostGWTable{
+			subnet: s.subnetIP,
+			host:   routeagg.NewTable(s.subnetIP, n.hostGWAggregate),
+			sbox:   routeagg.NewTable(s.subnetIP, n.hostGWAggregate),
+		}
+		if s.gwIP != nil {
+			t.host.Set(s.gwIP.IP, "")
+			t.sbox.Set(s.gwIP.IP, "")
+		}
+		n.hostGWTables = append(n.hostGWTables, t)
+		return t
+	}
+	return nil
+}
+
+// hostGWStatistics returns the sizes of the host-gw tables
+func (n *network) hostGWStatistics() map[string]uint64 {
+	n.Lock()
+	defer n.Unlock()
+	stats := map[string]uint64{}
+	for _, t := range n.hostGWTables {
+		stats["host_gw_hosts"] += uint64(t.host.Hosts())
+		stats["host_gw_host_routes"] += uint64(len(t.host.Routes()))
+		stats["host_gw_sandbox_routes"] += uint64(len(t.sbox.Routes()))
+		stats["host_gw_aggregates"] += uint64(t.host.Aggregates() + t.sbox.Aggregates())
+	}
+	return stats
+}
+
+// hostGWHostRoute returns the host route, through the host end of the
+// veth pair for the local endpoints
+func hostGWHostRoute(linkIndex int, r routeagg.Route) *netlink.Route {
+	if r.NextHop == hostGWLocalHop {
+		return &netlink.Route{LinkIndex: linkIndex, Dst: r.Dst, Scope: netlink.SCOPE_LINK}
+	}
+	return &netlink.Route{Dst: r.Dst, Gw: net.ParseIP(r.NextHop)}
+}
+
+// hostGWLinkNames returns the names of the host and sandbox ends of the
+// veth pair the routed traffic of the network goes through
+func hostGWLinkNames(nid string) (string, string) {
+	if len(nid) > 9 {
+		nid = nid[:9]
+	}
+	return "ovhg-" + nid, "ovhs-" + nid
+}
+
+// setupHostGW links the network sandbox to the host with a veth pair.
+// Both ends answer the ARP requests for the addresses routed through the
+// other side, so that the containers reach the routed peers of their
+// subnet and the host reaches the local containers.
+func (n *network) setupHostGW() error {
+	if n.hostGWReady {
+		return nil
+	}
+	hostName, sboxName := hostGWLinkNames(n.id)
+
+	nlh := ns.NlHandle()
+	if l, err := nlh.LinkByName(hostName); err == nil {
+		nlh.LinkDel(l)
+	}
+	veth := &netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: hostName}, PeerName: sboxName}
+	if err := nlh.LinkAdd(veth); err != nil {
+		return fmt.Errorf("failed to create the host-gw veth pair %s: %v", hostName, err)
+	}
+	if err := n.setupHostGWLinks(hostName, sboxName); err != nil {
+		nlh.LinkDel(veth)
+		return err
+	}
+	for _, rule := range hostGWForwardRules(hostName) {
+		if iptables.Exists(iptables.Filter, "FORWARD", rule...) {
+			continue
+		}
+		if err := iptables.ProgramRule(iptables.Filter, "FORWARD", iptables.Insert, rule); err != nil {
+			nlh.LinkDel(veth)
+			return fmt.Errorf("failed to accept the host-gw traffic of %s: %v", hostName, err)
+		}
+	}
+
+	n.hostGWReady = true
+	n.hostGWPeers = map[string]net.IP{}
+	return nil
+}
+
+func (n *network) setupHostGWLinks(hostName, sboxName string) error {
+	nlh := ns.NlHandle()
+	peer, err := nlh.LinkByName(sboxName)
+	if err != nil {
+		return fmt.Errorf("failed to find the host-gw interface %s: %v", sboxName, err)
+	}
+	nsh, err := netns.GetFromPath(n.sbox.Key())
+	if err != nil {
+		return fmt.Errorf("failed to open namespace %s: %v", n.sbox.Key(), err)
+	}
+	defer nsh.Close()
+	if err := nlh.LinkSetNsFd(peer, int(nsh)); err != nil {
+		return fmt.Errorf("failed to move %s into the network sandbox: %v", sboxName, err)
+	}
+	sboxNlh, err := netlink.NewHandleAt(nsh, syscall.NETLINK_ROUTE)
+	if err != nil {
+		return fmt.Errorf("failed to open a netlink handle in %s: %v", n.sbox.Key(), err)
+	}
+	defer sboxNlh.Delete()
+	if peer, err = sboxNlh.LinkByName(sboxName); err != nil {
+		return fmt.Errorf("failed to find the host-gw interface %s in the sandbox: %v", sboxName, err)
+	}
+	if err := sboxNlh.LinkSetUp(peer); err != nil {
+		return fmt.Errorf("failed to bring %s up: %v", sboxName, err)
+	}
+	host, err := nlh.LinkByName(hostName)
+	if err != nil {
+		return fmt.Errorf("failed to find the host-gw interface %s: %v", hostName, err)
+	}
+	if err := nlh.LinkSetUp(host); err != nil {
+		return fmt.Errorf("failed to bring %s up: %v", hostName, err)
+	}
+
+	if err := writeProxyARP(hostName); err != nil {
+		return err
+	}
+	n.sbox.InvokeFunc(func() {
+		if err = ioutil.WriteFile("/proc/sys/net/ipv4/ip_forward", []byte("1"), 0644); err != nil {
+			return
+		}
+		err = writeProxyARP(sboxName)
+	})
+	return err
+}
+
+func writeProxyARP(ifName string) error {
+	path := filepath.Join("/proc/sys/net/ipv4/conf", ifName, "proxy_arp")
+	if err := ioutil.WriteFile(path, []byte("1"), 0644); err != nil {
+		return fmt.Errorf("failed to enable the proxy ARP of %s: %v", ifName, err)
+	}
+	return nil
+}
+
+func hostGWForwardRules(hostName string) [][]string {
+	return [][]string{
+		{"-i", hostName, "-j", "ACCEPT"},
+		{"-o", hostName, "-j", "ACCEPT"},
+	}
+}
+
+// setupHostGWSubnet makes the bridge of the subnet answer for the routed
+// peers
+func (n *network) setupHostGWSubnet(s *subnet) error {
+	if err := n.setupHostGW(); err != nil {
+		return err
+	}
+	name := s.brName
+	for _, i := range n.sbox.Info().Interfaces() {
+		if i.SrcName() == s.brName {
+			name = i.DstName()
+		}
+	}
+	var err error
+	n.sbox.InvokeFunc(func() {
+		err = writeProxyARP(name)
+	})
+	return err
+}
+
+// hostGWActive tells if the peers of the network on the local segment are
+// routed
+func (n *network) hostGWActive() bool {
+	n.Lock()
+	defer n.Unlock()
+	return n.hostGWReady
+}
+
+// hostGWRouted tells if the peer is routed
+func (n *network) hostGWRouted(peerIP net.IP) bool {
+	n.Lock()
+	defer n.Unlock()
+	_, ok := n.hostGWPeers[peerIP.String()]
+	return ok
+}
+
+// cleanupHostGW removes the veth pair, with the routes through it, and
+// the host routes to the routed peers. Must be called with the network
+// lock.
+func (n *network) cleanupHostGW() {
+	if !n.hostGWReady {
+		return
+	}
+	hostName, _ := hostGWLinkNames(n.id)
+	nlh := ns.NlHandle()
+	for _, t := range n.hostGWTables {
+		for _, r := range t.host.Routes() {
+			route := hostGWHostRoute(0, r)
+			// The routes through the veth pair go away with it
+			if r.NextHop == hostGWLocalHop {
+				netutils.DisownRoute(route)
+				continue
+			}
+			if err := nlh.RouteDel(route); err != nil {
+				logrus.Debugf("Failed to remove the host-gw route to %s: %v", r.Dst, err)
+			}
+			netutils.DisownRoute(route)
+		}
+	}
+	for _, rule := range hostGWForwardRules(hostName) {
+		if err := iptables.ProgramRule(iptables.Filter, "FORWARD", iptables.Delete, rule); err != nil {
+			logrus.Debugf("Failed to remove the host-gw rule %v: %v", rule, err)
+		}
+	}
+	if l, err := nlh.LinkByName(hostName); err == nil {
+		if err := nlh.LinkDel(l); err != nil {
+			logrus.Warnf("Failed to remove the host-gw interface %s: %v", hostName, err)
+		}
+	}
+	n.hostGWReady = false
+	n.hostGWPeers = nil
+	n.hostGWTables = nil
+}
+
+// hostGWReachable tells if the VTEP is on a segment of the host, which
+// makes its peers routed rather than encapsulated. The VTEPs behind a
+// router keep being reached through the vxlan interfaces.
+func hostGWReachable(vtep net.IP) bool {
+	routes, err := ns.NlHandle().RouteGet(vtep)
+	if err != nil || len(routes) == 0 {
+		return false
+	}
+	return routes[0].Gw == nil
+}
+
+// programHostGWPeer adds, or removes, the routes to the remote peer: in
+// the sandbox through the veth pair, and on the host through the VTEP
+func (n *network) programHostGWPeer(peerIP, vtep net.IP, add bool) error {
+	if !add {
+		n.Lock()
+		delete(n.hostGWPeers, peerIP.String())
+		n.Unlock()
+		return n.programHostGWRoutes(peerIP, "", "", false)
+	}
+	if err := n.programHostGWRoutes(peerIP, vtep.String(), hostGWRoutedHop, true); err != nil {
+		return err
+	}
+	n.Lock()
+	if n.hostGWPeers != nil {
+		n.hostGWPeers[peerIP.String()] = vtep
+	}
+	n.Unlock()
+	logrus.Debugf("Host-gw routes to %s via %s added", peerIP, vtep)
+	return nil
+}
+
+// programHostGWLocal adds, or removes, the host route to the local
+// endpoint through the veth pair. The sandbox reaches it on the bridge.
+func (n *network) programHostGWLocal(ip net.IP, add bool) error {
+	return n.programHostGWRoutes(ip, hostGWLocalHop, "", add)
+}
+
+// reserveHostGWPeer keeps the aggregates from covering the encapsulated
+// peer, which is reached through the vxlan interface
+func (n *network) reserveHostGWPeer(ip net.IP, add bool) error {
+	return n.programHostGWRoutes(ip, "", "", add)
+}
+
+// programHostGWRoutes sets, or deletes, the next hops of the address in
+// the tables of its subnet and applies the changes of their routes
+func (n *network) programHostGWRoutes(ip net.IP, hostHop, sboxHop string, set bool) error {
+	n.Lock()
+	t := n.hostGWTable(ip)
+	if t == nil {
+		n.Unlock()
+		return nil
+	}
+	var hostAdd, hostDel, sboxAdd, sboxDel []routeagg.Route
+	if set {
+		hostAdd, hostDel = t.host.Set(ip, hostHop)
+		sboxAdd, sboxDel = t.sbox.Set(ip, sboxHop)
+	} else {
+		hostAdd, hostDel = t.host.Delete(ip)
+		sboxAdd, sboxDel = t.sbox.Delete(ip)
+	}
+	n.Unlock()
+
+	if len(sboxAdd) > 0 || len(sboxDel) > 0 {
+		if err := n.applyHostGWSandboxRoutes(sboxAdd, sboxDel); err != nil {
+			return err
+		}
+	}
+	if len(hostAdd) > 0 || len(hostDel) > 0 {
+		return n.applyHostGWHostRoutes(hostAdd, hostDel)
+	}
+	return nil
+}
+
+// applyHostGWHostRoutes adds the routes of the host before removing the
+// ones they replace, so that the traffic is never left without a route
+func (n *network) applyHostGWHostRoutes(add, del []routeagg.Route) error {
+	hostName, _ := hostGWLinkNames(n.id)
+	nlh := ns.NlHandle()
+	link, err := nlh.LinkByName(hostName)
+	if err != nil {
+		return fmt.Errorf("failed to find the host-gw interface %s: %v", hostName, err)
+	}
+	for _, r := range add {
+		if err := nlh.RouteReplace(netutils.OwnRoute(hostGWHostRoute(link.Attrs().Index, r))); err != nil {
+			return fmt.Errorf("failed to route %s via %s: %v", r.Dst, r.NextHop, err)
+		}
+	}
+	for _, r := range del {
+		route := hostGWHostRoute(link.Attrs().Index, r)
+		netutils.DisownRoute(route)
+		if err := nlh.RouteDel(route); err != nil && err != syscall.ESRCH {
+			logrus.Warnf("Failed to remove the host-gw route to %s via %s: %v", r.Dst, r.NextHop, err)
+		}
+	}
+	return nil
+}
+
+// applyHostGWSandboxRoutes adds, then removes, the routes of the sandbox
+// through the veth pair
+func (n *network) applyHostGWSandboxRoutes(add, del []routeagg.Route) error {
+	_, sboxName := hostGWLinkNames(n.id)
+	nsh, err := netns.GetFromPath(n.sbox.Key())
+	if err != nil {
+		return fmt.Errorf("failed to open namespace %s: %v", n.sbox.Key(), err)
+	}
+	defer nsh.Close()
+	sboxNlh, err := netlink.NewHandleAt(nsh, syscall.NETLINK_ROUTE)
+	if err != nil {
+		return fmt.Errorf("failed to open a netlink handle in %s: %v", n.sbox.Key(), err)
+	}
+	defer sboxNlh.Delete()
+	link, err := sboxNlh.LinkByName(sboxName)
+	if err != nil {
+		return fmt.Errorf("failed to find the host-gw interface %s: %v", sboxName, err)
+	}
+	for _, r := range add {
+		route := &netlink.Route{LinkIndex: link.Attrs().Index, Dst: r.Dst, Scope: netlink.SCOPE_LINK}
+		if err := sboxNlh.RouteReplace(route); err != nil {
+			return fmt.Errorf("failed to route %s through %s in the sandbox: %v", r.Dst, sboxName, err)
+		}
+	}
+	for _, r := range del {
+		route := &netlink.Route{LinkIndex: link.Attrs().Index, Dst: r.Dst, Scope: netlink.SCOPE_LINK}
+		if err := sboxNlh.RouteDel(route); err != nil && err != syscall.ESRCH {
+			return fmt.Errorf("failed to remove the sandbox route to %s: %v", r.Dst, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package overlay
+
+import (
+	"net"
+	"testing"
+
+	"github.com/docker/libnetwork/types"
+)
+
+func TestHostGWLinkNames(t *testing.T) {
+	host, sbox := hostGWLinkNames("0123456789abcdef0123456789abcdef")
+	if host != "ovhg-012345678" || sbox != "ovhs-012345678" {
+		t.Fatalf("unexpected names %s %s", host, sbox)
+	}
+	// The interface names are limited to 15 characters
+	if len(host) > 15 || len(sbox) > 15 {
+		t.Fatalf("names too long: %s %s", host, sbox)
+	}
+
+	host, sbox = hostGWLinkNames("abc")
+	if host != "ovhg-abc" || sbox != "ovhs-abc" {
+		t.Fatalf("unexpected names %s %s", host, sbox)
+	}
+}
+
+func TestHostGWStore(t *testing.T) {
+	n := &network{id: "n1", hostGW: true, hostGWAggregate: true, fdbLimit: 10, arpRate: 5}
+	restored := &network{id: "n1"}
+	if err := restored.SetValue(n.Value()); err != nil {
+		t.Fatal(err)
+	}
+	if !restored.hostGW || !restored.hostGWAggregate || restored.fdbLimit != 10 || restored.arpRate != 5 {
+		t.Fatalf("options not restored: %+v", restored)
+	}
+}
+
+func TestHostGWTable(t *testing.T) {
+	subnetIP, _ := types.ParseCIDR("10.0.0.0/24")
+	gwIP, _ := types.ParseCIDR("10.0.0.1/24")
+	n := &network{id: "n1", hostGWAggregate: true, subnets: []*subnet{{subnetIP: subnetIP, gwIP: gwIP}}}
+	if n.hostGWTable(net.ParseIP("10.0.1.2")) != nil || n.hostGWTable(net.ParseIP("fd00::2")) != nil {
+		t.Fatal("table for an address out of the subnets")
+	}
+	tb := n.hostGWTable(net.ParseIP("10.0.0.2"))
+	if tb == nil || n.hostGWTable(net.ParseIP("10.0.0.3")) != tb {
+		t.Fatal("table of the subnet not shared")
+	}
+	for i := 2; i < 10; i++ {
+		tb.host.Set(net.IPv4(10, 0, 0, byte(i)), "192.168.0.2")
+		tb.sbox.Set(net.IPv4(10, 0, 0, byte(i)), hostGWRoutedHop)
+	}
+	// The gateway is reserved, so that no aggregate covers it
+	for _, r := range tb.sbox.Routes() {
+		if r.Dst.Contains(gwIP.IP) {
+			t.Fatalf("aggregate %s covers the gateway", r.Dst)
+		}
+	}
+	stats := n.hostGWStatistics()
+	if stats["host_gw_hosts"] != 9 || stats["host_gw_host_routes"] != 3 || stats["host_gw_aggregates"] != 6 {
+		t.Fatalf("unexpected statistics %v", stats)
+	}
+}
@@ -0,0 +1,129 @@
+package overlay
+
+import (
+	"container/list"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/docker/libnetwork/types"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// fdbLimitOption caps the number of the peers of the network learned
+	// from the misses, the least recently used being evicted past it
+	fdbLimitOption = "fdb_limit"
+
+	// learnedAlarmRatio is the fill ratio of the limit past which an
+	// alarm is raised
+	learnedAlarmRatio = 0.9
+	// learnedAlarmInterval spaces the alarms of a network
+	learnedAlarmInterval = time.Minute
+)
+
+// learnedPeer is a peer programmed in the sandbox on a miss
+type learnedPeer struct {
+	ip   net.IP
+	mask net.IPMask
+	mac  net.HardwareAddr
+	vtep net.IP
+}
+
+// learnedPeers keeps the peers of a network learned from the misses in
+// least recently used order. Each of them holds an fdb and a neighbor
+// entry in the sandbox, so that bounding them bounds the kernel tables.
+type learnedPeers struct {
+	sync.Mutex
+	nid     string
+	limit   int
+	order   *list.List
+	peers   map[string]*list.Element
+	evicted uint64
+	alarmed time.Time
+}
+
+func newLearnedPeers(nid string, limit int) *learnedPeers {
+	return &learnedPeers{
+		nid:   nid,
+		limit: limit,
+		order: list.New(),
+		peers: map[string]*list.Element{},
+	}
+}
+
+func parseFDBLimit(val string) (int, error) {
+	limit, err := strconv.Atoi(val)
+	if err != nil {
+		return 0, types.BadRequestErrorf("invalid fdb limit %q: %v", val, err)
+	}
+	if limit < 0 {
+		return 0, types.BadRequestErrorf("invalid fdb limit %d: it must not be negative", limit)
+	}
+	return limit, nil
+}
+
+// add records the use of the peer and returns the peers evicted to stay
+// within the limit
+func (l *learnedPeers) add(p learnedPeer, now time.Time) []learnedPeer {
+	l.Lock()
+	defer l.Unlock()
+
+	key := p.ip.String()
+	if e, ok := l.peers[key]; ok {
+		e.Value = p
+		l.order.MoveToFront(e)
+		return nil
+	}
+	l.peers[key] = l.order.PushFront(p)
+
+	if l.limit == 0 {
+		return nil
+	}
+	var evicted []learnedPeer
+	for l.order.Len() > l.limit {
+		e := l.order.Back()
+		old := l.order.Remove(e).(learnedPeer)
+		delete(l.peers, old.ip.String())
+		evicted = append(evicted, old)
+	}
+	l.evicted += uint64(len(evicted))
+
+	if float64(l.order.Len()) >= learnedAlarmRatio*float64(l.limit) && now.Sub(l.alarmed) >= learnedAlarmInterval {
+		l.alarmed = now
+		logrus.Warnf("Overlay network %.7s learned %d peers out of the limit of %d, %d evicted so far", l.nid, l.order.Len(), l.limit, l.evicted)
+	}
+	return evicted
+}
+
+func (l *learnedPeers) statistics() map[string]uint64 {
+	l.Lock()
+	defer l.Unlock()
+	return map[string]uint64{
+		"fdb_learned": uint64(l.order.Len()),
+		"fdb_limit":   uint64(l.limit),
+		"fdb_evicted": l.evicted,
+	}
+}
+
+// learned returns the learned peers of the network, created on first use
+// as the networks restored from the store have none
+func (n *network) learned() *learnedPeers {
+	n.Lock()
+	defer n.Unlock()
+	if n.learnedPeers == nil {
+		n.learnedPeers = newLearnedPeers(n.id, n.fdbLimit)
+	}
+	return n.learnedPeers
+}
+
+// learnPeer programs the peer resolved on a miss, evicting the least
+// recently used learned peers past the limit of the network
+func (n *network) learnPeer(p learnedPeer, l2Miss, l3Miss bool) {
+	for _, old := range n.learned().add(p, time.Now()) {
+		logrus.Debugf("Evicting learned peer %s of network %.7s", old.ip, n.id)
+		n.driver.peerDelete(n.id, "dummy", old.ip, old.mask, old.mac, old.vtep, false)
+	}
+	n.driver.peerAdd(n.id, "dummy", p.ip, p.mask, p.mac, p.vtep, l2Miss, l3Miss, false)
+}
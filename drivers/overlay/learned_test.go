@@ -0,0 +1,62 @@
+package overlay
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func testLearnedPeer(i byte) learnedPeer {
+	return learnedPeer{
+		ip:   net.IPv4(10, 0, 0, i),
+		mask: net.CIDRMask(24, 32),
+		mac:  net.HardwareAddr{0x02, 0x42, 10, 0, 0, i},
+		vtep: net.ParseIP("192.168.0.2"),
+	}
+}
+
+func TestLearnedPeersEviction(t *testing.T) {
+	now := time.Now()
+	l := newLearnedPeers("n1", 3)
+
+	for i := byte(1); i <= 3; i++ {
+		if evicted := l.add(testLearnedPeer(i), now); len(evicted) != 0 {
+			t.Fatalf("unexpected eviction %v", evicted)
+		}
+	}
+	// A new use makes 10.0.0.1 the most recently used
+	l.add(testLearnedPeer(1), now)
+
+	evicted := l.add(testLearnedPeer(4), now)
+	if len(evicted) != 1 || !evicted[0].ip.Equal(net.IPv4(10, 0, 0, 2)) {
+		t.Fatalf("unexpected eviction %v", evicted)
+	}
+	evicted = l.add(testLearnedPeer(5), now)
+	if len(evicted) != 1 || !evicted[0].ip.Equal(net.IPv4(10, 0, 0, 3)) {
+		t.Fatalf("unexpected eviction %v", evicted)
+	}
+
+	stats := l.statistics()
+	if stats["fdb_learned"] != 3 || stats["fdb_limit"] != 3 || stats["fdb_evicted"] != 2 {
+		t.Fatalf("unexpected statistics %v", stats)
+	}
+	if l.alarmed != now {
+		t.Fatal("no alarm raised at the limit")
+	}
+}
+
+func TestLearnedPeersUnlimited(t *testing.T) {
+	l := newLearnedPeers("n1", 0)
+	for i := byte(1); i < 200; i++ {
+		if evicted := l.add(testLearnedPeer(i), time.Now()); len(evicted) != 0 {
+			t.Fatalf("unexpected eviction %v", evicted)
+		}
+	}
+	if l.statistics()["fdb_learned"] != 199 {
+		t.Fatalf("unexpected statistics %v", l.statistics())
+	}
+
+	if _, err := parseFDBLimit("-5"); err == nil {
+		t.Fatal("expected an error for a negative limit")
+	}
+}
@@ -0,0 +1,101 @@
+package overlay
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+
+	"github.com/docker/libnetwork/osl"
+	"github.com/sirupsen/logrus"
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netns"
+)
+
+// floodMAC is the address of the fdb entries the vxlan interfaces replicate
+// the broadcast, multicast and unknown unicast frames to
+var floodMAC = net.HardwareAddr{0, 0, 0, 0, 0, 0}
+
+// programFloodEntry adds or removes the flood entry of the remote VTEP on
+// the vxlan interface of the subnet. The multicast frames are replicated to
+// each of the VTEPs with a flood entry, the head end replication making the
+// multicast traffic cross the underlay as unicast.
+func (n *network) programFloodEntry(s *subnet, vtep net.IP, add bool) error {
+	sbox := n.sandbox()
+	if sbox == nil {
+		return nil
+	}
+	nlh, link, err := vxlanLink(sbox, s.vxlanName)
+	if err != nil {
+		return err
+	}
+	defer nlh.Delete()
+	name := link.Attrs().Name
+
+	neigh := &netlink.Neigh{
+		LinkIndex:    link.Attrs().Index,
+		Family:       syscall.AF_BRIDGE,
+		Flags:        netlink.NTF_SELF,
+		State:        netlink.NUD_PERMANENT,
+		IP:           vtep,
+		HardwareAddr: floodMAC,
+	}
+	if add {
+		// Appended, an entry per VTEP shares the address
+		if err := nlh.NeighAppend(neigh); err != nil && err != syscall.EEXIST {
+			return fmt.Errorf("failed to add the flood entry of %s on %s: %v", vtep, name, err)
+		}
+		logrus.Debugf("Multicast flood entry added for vtep %s on %s", vtep, name)
+		return nil
+	}
+	if err := nlh.NeighDel(neigh); err != nil && err != syscall.ENOENT {
+		return fmt.Errorf("failed to remove the flood entry of %s on %s: %v", vtep, name, err)
+	}
+	logrus.Debugf("Multicast flood entry removed for vtep %s on %s", vtep, name)
+	return nil
+}
+
+// vxlanLink returns a netlink handle in the sandbox and the vxlan
+// interface by its name before it was moved into the sandbox. The handle
+// has to be deleted by the caller.
+func vxlanLink(sbox osl.Sandbox, vxlanName string) (*netlink.Handle, netlink.Link, error) {
+	nsh, err := netns.GetFromPath(sbox.Key())
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open namespace %s: %v", sbox.Key(), err)
+	}
+	defer nsh.Close()
+	nlh, err := netlink.NewHandleAt(nsh, syscall.NETLINK_ROUTE)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open a netlink handle in %s: %v", sbox.Key(), err)
+	}
+
+	// The vxlan interfaces are renamed when moved into the sandbox
+	name := vxlanName
+	for _, i := range sbox.Info().Interfaces() {
+		if i.SrcName() == vxlanName {
+			name = i.DstName()
+		}
+	}
+	link, err := nlh.LinkByName(name)
+	if err != nil {
+		nlh.Delete()
+		return nil, nil, fmt.Errorf("failed to find the vxlan interface %s: %v", name, err)
+	}
+	return nlh, link, nil
+}
+
+// releaseFloodEntry removes the flood entry of the VTEP once the last
+// remote peer of the subnet behind it is gone
+func (d *driver) releaseFloodEntry(n *network, s *subnet, vtep net.IP) error {
+	inUse := false
+	d.peerDbNetworkWalk(n.id, func(pKey *peerKey, pEntry *peerEntry) bool {
+		if !pEntry.isLocal && pEntry.vtep.Equal(vtep) && s.subnetIP.Contains(pKey.peerIP) {
+			inUse = true
+			return true
+		}
+		return false
+	})
+	if inUse {
+		return nil
+	}
+	return n.programFloodEntry(s, vtep, false)
+}
@@ -0,0 +1,279 @@
+package overlay
+
+import (
+	"fmt"
+	"net"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/docker/libnetwork/ns"
+	"github.com/docker/libnetwork/types"
+	"github.com/sirupsen/logrus"
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+)
+
+const (
+	// xfrmaOffloadDev is the attribute of the device an SA is offloaded
+	// to, which the vendored netlink does not know of
+	xfrmaOffloadDev = 0x1c
+	// xfrmOffloadInbound flags an SA offloaded for the received packets
+	xfrmOffloadInbound = 2
+)
+
+// tunnelOffloadFeatures are the features of the underlay device offloading
+// the VXLAN encapsulation, the UDP tunnel ports being notified to the
+// device by the kernel
+var tunnelOffloadFeatures = []string{
+	"tx-udp_tnl-segmentation",
+	"tx-udp_tnl-csum-segmentation",
+	"rx-udp_tunnel-port-offload",
+}
+
+// tunnelCsumFeature lets the device compute the outer UDP checksum of
+// the segmented packets, turning it on for the vxlan links is then free
+const tunnelCsumFeature = "tx-udp_tnl-csum-segmentation"
+
+// espOffloader offloads the SAs to the devices the traffic to the remote
+// nodes goes through, falling back to the software crypto for the devices
+// unable to
+type espOffloader struct {
+	sync.Mutex
+	enabled     bool
+	unsupported map[int]bool
+}
+
+var espOffload = &espOffloader{unsupported: map[int]bool{}}
+
+func (o *espOffloader) enable(enabled bool) {
+	o.Lock()
+	o.enabled = enabled
+	o.Unlock()
+}
+
+// offload adds the SA offloaded to the device routing to the remote node,
+// it returns false when the SA has to be added in software
+func (o *espOffloader) offload(sa *netlink.XfrmState, inbound bool) bool {
+	o.Lock()
+	defer o.Unlock()
+	if !o.enabled {
+		return false
+	}
+
+	remote := sa.Dst
+	if inbound {
+		remote = sa.Src
+	}
+	routes, err := ns.NlHandle().RouteGet(remote)
+	if err != nil || len(routes) == 0 {
+		logrus.Debugf("Could not find the device routing to %s, not offloading the SA: %v", remote, err)
+		return false
+	}
+	ifIndex := routes[0].LinkIndex
+	if o.unsupported[ifIndex] {
+		return false
+	}
+
+	err = xfrmStateAddOffload(sa, ifIndex, inbound)
+	switch err {
+	case nil:
+		logrus.Debugf("Offloaded SA{%s} to device %d", sa, ifIndex)
+		return true
+	case syscall.EEXIST:
+		return true
+	}
+	o.unsupported[ifIndex] = true
+	logrus.Warnf("ESP offload is not supported by device %d, falling back to software crypto: %v", ifIndex, err)
+	return false
+}
+
+// programState programs the SA, offloading the new ones to the underlay
+// device when the ESP offload is enabled
+func programState(program func(*netlink.XfrmState) error, sa *netlink.XfrmState, add, inbound bool) error {
+	if add && espOffload.offload(sa, inbound) {
+		return nil
+	}
+	return program(sa)
+}
+
+func offloadAttrData(ifIndex int, inbound bool) []byte {
+	b := make([]byte, 8)
+	nl.NativeEndian().PutUint32(b, uint32(ifIndex))
+	if inbound {
+		b[4] = xfrmOffloadInbound
+	}
+	return b
+}
+
+func aeadAttrData(a *netlink.XfrmStateAlgo) []byte {
+	algo := nl.XfrmAlgoAEAD{
+		AlgKeyLen: uint32(len(a.Key) * 8),
+		AlgICVLen: uint32(a.ICVLen),
+		AlgKey:    a.Key,
+	}
+	copy(algo.AlgName[:len(algo.AlgName)-1], a.Name)
+	return algo.Serialize()
+}
+
+// xfrmStateAddOffload adds the SA, with the offload attribute the
+// vendored netlink is unable to set
+func xfrmStateAddOffload(sa *netlink.XfrmState, ifIndex int, inbound bool) error {
+	if sa.Aead == nil {
+		return fmt.Errorf("only the AEAD SAs are offloaded")
+	}
+	req := nl.NewNetlinkRequest(nl.XFRM_MSG_NEWSA, syscall.NLM_F_CREATE|syscall.NLM_F_EXCL|syscall.NLM_F_ACK)
+
+	msg := &nl.XfrmUsersaInfo{}
+	msg.Family = uint16(nl.GetIPFamily(sa.Dst))
+	msg.Id.Daddr.FromIP(sa.Dst)
+	msg.Saddr.FromIP(sa.Src)
+	msg.Id.Proto = uint8(sa.Proto)
+	msg.Mode = uint8(sa.Mode)
+	msg.Id.Spi = nl.Swap32(uint32(sa.Spi))
+	msg.Reqid = uint32(sa.Reqid)
+	msg.ReplayWindow = uint8(sa.ReplayWindow)
+	msg.Lft.SoftByteLimit = nl.XFRM_INF
+	msg.Lft.HardByteLimit = nl.XFRM_INF
+	msg.Lft.SoftPacketLimit = nl.XFRM_INF
+	msg.Lft.HardPacketLimit = nl.XFRM_INF
+	req.AddData(msg)
+
+	req.AddData(nl.NewRtAttr(nl.XFRMA_ALG_AEAD, aeadAttrData(sa.Aead)))
+	req.AddData(nl.NewRtAttr(xfrmaOffloadDev, offloadAttrData(ifIndex, inbound)))
+
+	_, err := req.Execute(syscall.NETLINK_XFRM, 0)
+	return err
+}
+
+func parseOffloadOption(config map[string]interface{}, label string) (bool, error) {
+	opt, ok := config[label]
+	if !ok {
+		return false, nil
+	}
+	switch v := opt.(type) {
+	case bool:
+		return v, nil
+	case string:
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return false, types.BadRequestErrorf("invalid value %q of %s: %v", v, label, err)
+		}
+		return b, nil
+	}
+	return false, types.BadRequestErrorf("invalid value %v of %s", opt, label)
+}
+
+// featureState is the state of a device feature as listed by ethtool
+type featureState struct {
+	on    bool
+	fixed bool
+}
+
+// parseEthtoolFeatures parses the output of ethtool -k
+func parseEthtoolFeatures(out string) map[string]featureState {
+	features := map[string]featureState{}
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || !strings.HasSuffix(fields[0], ":") {
+			continue
+		}
+		features[strings.TrimSuffix(fields[0], ":")] = featureState{
+			on:    fields[1] == "on",
+			fixed: len(fields) > 2 && fields[2] == "[fixed]",
+		}
+	}
+	return features
+}
+
+// underlayDevice returns the name of the device holding the address
+func underlayDevice(ip net.IP) (string, error) {
+	links, err := ns.NlHandle().LinkList()
+	if err != nil {
+		return "", err
+	}
+	for _, l := range links {
+		addrs, err := ns.NlHandle().AddrList(l, netlink.FAMILY_ALL)
+		if err != nil {
+			continue
+		}
+		for _, a := range addrs {
+			if a.IP.Equal(ip) {
+				return l.Attrs().Name, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("no device holds %s", ip)
+}
+
+// setupTunnelOffload turns on the UDP tunnel offloads the underlay device
+// supports. It tells if the device computes the outer UDP checksums, the
+// offloads missing are left to the software.
+func setupTunnelOffload(ip net.IP) bool {
+	dev, err := underlayDevice(ip)
+	if err != nil {
+		logrus.Warnf("Could not find the underlay device for the vxlan offload: %v", err)
+		return false
+	}
+	if _, err := exec.LookPath("ethtool"); err != nil {
+		logrus.Warnf("ethtool is not available, the vxlan offload of %s is left as is", dev)
+		return false
+	}
+	out, err := exec.Command("ethtool", "-k", dev).CombinedOutput()
+	if err != nil {
+		logrus.Warnf("Could not list the features of %s: %v: %s", dev, err, out)
+		return false
+	}
+
+	features := parseEthtoolFeatures(string(out))
+	for _, name := range tunnelOffloadFeatures {
+		f, ok := features[name]
+		if !ok || (f.fixed && !f.on) {
+			logrus.Infof("%s of %s is not supported, it is done in software", name, dev)
+			continue
+		}
+		if f.on {
+			continue
+		}
+		if out, err := exec.Command("ethtool", "-K", dev, name, "on").CombinedOutput(); err != nil {
+			logrus.Warnf("Could not enable %s of %s: %v: %s", name, dev, err, out)
+			continue
+		}
+		f.on = true
+		features[name] = f
+		logrus.Infof("Enabled %s of %s", name, dev)
+	}
+	return features[tunnelCsumFeature].on
+}
+
+// tunnelCsum tells if the outer UDP checksums of the vxlan links are
+// offloaded
+func (d *driver) tunnelCsum() bool {
+	d.Lock()
+	defer d.Unlock()
+	return d.udpTunnelCsum
+}
+
+// initTunnelOffload sets up the offloads of the device of the bind
+// address, or of the advertise address when bound to all
+func (d *driver) initTunnelOffload(advertiseAddress, bindAddress string) {
+	if !d.vxlanOffload {
+		return
+	}
+	d.offloadOnce.Do(func() {
+		ip := net.ParseIP(bindAddress)
+		if ip == nil || ip.IsUnspecified() {
+			ip = net.ParseIP(advertiseAddress)
+		}
+		if ip == nil {
+			logrus.Warnf("The vxlan offload requires the address of the underlay device")
+			return
+		}
+		csum := setupTunnelOffload(ip)
+		d.Lock()
+		d.udpTunnelCsum = csum
+		d.Unlock()
+	})
+}
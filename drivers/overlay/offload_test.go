@@ -0,0 +1,75 @@
+package overlay
+
+import (
+	"testing"
+
+	"github.com/docker/libnetwork/netlabel"
+	"github.com/vishvananda/netlink"
+)
+
+func TestParseEthtoolFeatures(t *testing.T) {
+	out := `Features for eth0:
+rx-checksumming: on
+tx-udp_tnl-segmentation: off
+tx-udp_tnl-csum-segmentation: off [fixed]
+rx-udp_tunnel-port-offload: on [fixed]
+esp-hw-offload: off [requested on]
+`
+	features := parseEthtoolFeatures(out)
+	for name, expected := range map[string]featureState{
+		"rx-checksumming":              {on: true},
+		"tx-udp_tnl-segmentation":      {},
+		"tx-udp_tnl-csum-segmentation": {fixed: true},
+		"rx-udp_tunnel-port-offload":   {on: true, fixed: true},
+		"esp-hw-offload":               {},
+	} {
+		if f, ok := features[name]; !ok || f != expected {
+			t.Fatalf("unexpected state of %s: %+v", name, f)
+		}
+	}
+	if _, ok := features["Features for eth0"]; ok {
+		t.Fatal("the header was parsed as a feature")
+	}
+}
+
+func TestParseOffloadOption(t *testing.T) {
+	config := map[string]interface{}{
+		netlabel.OverlayVxlanOffload: "true",
+		netlabel.OverlayESPOffload:   false,
+	}
+	if on, err := parseOffloadOption(config, netlabel.OverlayVxlanOffload); err != nil || !on {
+		t.Fatalf("unexpected vxlan offload %t: %v", on, err)
+	}
+	if on, err := parseOffloadOption(config, netlabel.OverlayESPOffload); err != nil || on {
+		t.Fatalf("unexpected esp offload %t: %v", on, err)
+	}
+	if on, err := parseOffloadOption(map[string]interface{}{}, netlabel.OverlayESPOffload); err != nil || on {
+		t.Fatalf("unexpected default offload %t: %v", on, err)
+	}
+	for _, v := range []interface{}{"maybe", 1} {
+		if _, err := parseOffloadOption(map[string]interface{}{netlabel.OverlayESPOffload: v}, netlabel.OverlayESPOffload); err == nil {
+			t.Fatalf("expected an error parsing %v", v)
+		}
+	}
+}
+
+func TestOffloadAttributes(t *testing.T) {
+	b := offloadAttrData(3, true)
+	if len(b) != 8 || b[4] != xfrmOffloadInbound {
+		t.Fatalf("unexpected offload attribute %v", b)
+	}
+	if b := offloadAttrData(3, false); b[4] != 0 {
+		t.Fatalf("unexpected outbound offload attribute %v", b)
+	}
+
+	k := &key{value: make([]byte, 16), tag: 1}
+	b = aeadAttrData(buildAeadAlgo(k, 0x100))
+	// name(64), key length(4), icv length(4), key and salt(20)
+	if len(b) != 92 || string(b[:17]) != "rfc4106(gcm(aes))" || b[17] != 0 {
+		t.Fatalf("unexpected aead attribute %v", b)
+	}
+
+	if err := xfrmStateAddOffload(&netlink.XfrmState{}, 1, false); err == nil {
+		t.Fatal("expected an error offloading an SA without AEAD")
+	}
+}
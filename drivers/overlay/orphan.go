@@ -0,0 +1,47 @@
+package overlay
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/sirupsen/logrus"
+)
+
+// CleanupOrphanEndpoint removes the peer entries of an endpoint whose host
+// left the cluster without deleting it. When the peers are propagated over
+// serf, the leave is broadcast on behalf of the gone host so that all the
+// nodes of the cluster drop the entries.
+func (d *driver) CleanupOrphanEndpoint(nid, eid string) error {
+	type orphanPeer struct {
+		ip   net.IP
+		mask net.IPMask
+		mac  net.HardwareAddr
+		vtep net.IP
+	}
+	var peers []orphanPeer
+	d.peerDbNetworkWalk(nid, func(pKey *peerKey, pEntry *peerEntry) bool {
+		if pEntry.eid == eid && !pEntry.isLocal {
+			peers = append(peers, orphanPeer{ip: pKey.peerIP, mask: pEntry.peerIPMask, mac: pKey.peerMac, vtep: pEntry.vtep})
+		}
+		return false
+	})
+
+	d.Lock()
+	serfInstance := d.serfInstance
+	d.Unlock()
+
+	for _, p := range peers {
+		logrus.Debugf("Removing orphan peer %s %s of endpoint %.7s on network %.7s", p.ip, p.mac, eid, nid)
+		if serfInstance == nil {
+			d.peerDelete(nid, eid, p.ip, p.mask, p.mac, p.vtep, false)
+			continue
+		}
+		// Delivered locally as well
+		eName := fmt.Sprintf("jl %s %s %s", p.vtep, nid, eid)
+		ePayload := fmt.Sprintf("leave %s %s %s", p.ip, net.IP(p.mask), p.mac)
+		if err := serfInstance.UserEvent(eName, []byte(ePayload), true); err != nil {
+			return fmt.Errorf("failed to broadcast the removal of orphan peer %s: %v", p.ip, err)
+		}
+	}
+	return nil
+}
@@ -13,6 +13,7 @@ import (
 	"strings"
 	"sync"
 	"syscall"
+	"time"
 
 	"github.com/docker/docker/pkg/reexec"
 	"github.com/docker/libnetwork/datastore"
@@ -68,7 +69,27 @@ type network struct {
 	initErr   error
 	subnets   []*subnet
 	secure    bool
-	mtu       int
+	// encSubnets and encPairs restrict the encryption of a secure
+	// network to part of its traffic
+	encSubnets []*net.IPNet
+	encPairs   []encryptionPair
+	multicast  bool
+	mtu        int
+	// arpRate caps the rate of the misses resolved through the cluster
+	arpRate int
+	// noUnicastFlood stops the flooding of the unknown unicast frames
+	noUnicastFlood bool
+	misses         *missLimiter
+	// fdbLimit caps the peers learned from the misses
+	fdbLimit     int
+	learnedPeers *learnedPeers
+	// hostGW routes the traffic to the peers on the local segment
+	hostGW      bool
+	hostGWReady bool
+	hostGWPeers map[string]net.IP
+	// hostGWAggregate summarizes the host-gw routes of each subnet
+	hostGWAggregate bool
+	hostGWTables    []*hostGWTable
 	sync.Mutex
 }
 
@@ -151,6 +172,7 @@ func (d *driver) CreateNetwork(id string, option map[string]interface{}, nInfo d
 		driver:    d,
 		endpoints: endpointTable{},
 		subnets:   []*subnet{},
+		arpRate:   defaultARPRate,
 	}
 
 	vnis := make([]uint32, 0, len(ipV4Data))
@@ -171,6 +193,63 @@ func (d *driver) CreateNetwork(id string, option map[string]interface{}, nInfo d
 		if _, ok := optMap[secureOption]; ok {
 			n.secure = true
 		}
+		if val, ok := optMap[encryptedSubnetsOption]; ok {
+			var err error
+			if n.encSubnets, err = parseEncryptedSubnets(val); err != nil {
+				return err
+			}
+			n.secure = true
+		}
+		if val, ok := optMap[encryptedPairsOption]; ok {
+			var err error
+			if n.encPairs, err = parseEncryptedPairs(val); err != nil {
+				return err
+			}
+			n.secure = true
+		}
+		if val, ok := optMap[multicastOption]; ok {
+			var err error
+			if n.multicast, err = strconv.ParseBool(val); err != nil {
+				return fmt.Errorf("failed to parse %v: %v", val, err)
+			}
+		}
+		if val, ok := optMap[arpRateOption]; ok {
+			var err error
+			if n.arpRate, err = parseARPRate(val); err != nil {
+				return err
+			}
+		}
+		if val, ok := optMap[unknownUnicastFloodOption]; ok {
+			flood, err := strconv.ParseBool(val)
+			if err != nil {
+				return fmt.Errorf("failed to parse %v: %v", val, err)
+			}
+			n.noUnicastFlood = !flood
+		}
+		if val, ok := optMap[hostGWOption]; ok {
+			var err error
+			if n.hostGW, err = strconv.ParseBool(val); err != nil {
+				return fmt.Errorf("failed to parse %v: %v", val, err)
+			}
+			if n.hostGW && n.secure {
+				return types.BadRequestErrorf("the host-gw mode does not encrypt the traffic, it can not be enabled on an encrypted network")
+			}
+		}
+		if val, ok := optMap[hostGWAggregateOption]; ok {
+			var err error
+			if n.hostGWAggregate, err = strconv.ParseBool(val); err != nil {
+				return fmt.Errorf("failed to parse %v: %v", val, err)
+			}
+			if n.hostGWAggregate && !n.hostGW {
+				return types.BadRequestErrorf("the host-gw routes can only be aggregated on a network in host-gw mode")
+			}
+		}
+		if val, ok := optMap[fdbLimitOption]; ok {
+			var err error
+			if n.fdbLimit, err = parseFDBLimit(val); err != nil {
+				return err
+			}
+		}
 		if val, ok := optMap[netlabel.DriverMTU]; ok {
 			var err error
 			if n.mtu, err = strconv.Atoi(val); err != nil {
@@ -201,6 +280,10 @@ func (d *driver) CreateNetwork(id string, option map[string]interface{}, nInfo d
 		n.subnets = append(n.subnets, s)
 	}
 
+	if err := n.validateEncryptionPolicy(); err != nil {
+		return err
+	}
+
 	d.Lock()
 	defer d.Unlock()
 	if d.networks[n.id] != nil {
@@ -214,8 +297,8 @@ func (d *driver) CreateNetwork(id string, option map[string]interface{}, nInfo d
 	// Make sure no rule is on the way from any stale secure network
 	if !n.secure {
 		for _, vni := range vnis {
-			programMangle(vni, false)
-			programInput(vni, false)
+			programMangle(vniMatch(vni), false)
+			programInput(vniMatch(vni), false)
 		}
 	}
 
@@ -279,6 +362,14 @@ func (d *driver) DeleteNetwork(nid string) error {
 	doPeerFlush = true
 	delete(d.networks, nid)
 
+	// The matches depend on the vnis of the subnets, which the release resets
+	encMatches := map[uint32][]string{}
+	if n.secure {
+		for _, s := range n.subnets {
+			encMatches[s.vni] = n.encryptionMatches(s.vni)
+		}
+	}
+
 	vnis, err := n.releaseVxlanID()
 	if err != nil {
 		return err
@@ -286,8 +377,10 @@ func (d *driver) DeleteNetwork(nid string) error {
 
 	if n.secure {
 		for _, vni := range vnis {
-			programMangle(vni, false)
-			programInput(vni, false)
+			for _, c := range encMatches[vni] {
+				programMangle(c, false)
+				programInput(c, false)
+			}
 		}
 	}
 
@@ -373,6 +466,8 @@ func (n *network) leaveSandbox() {
 // to be called while holding network lock
 func (n *network) destroySandbox() {
 	if n.sbox != nil {
+		n.cleanupHostGW()
+
 		for _, iface := range n.sbox.Info().Interfaces() {
 			if err := iface.Remove(); err != nil {
 				logrus.Debugf("Remove interface %s failed: %v", iface.SrcName(), err)
@@ -463,7 +558,7 @@ func networkOnceInit() {
 		return
 	}
 
-	err := createVxlan("testvxlan", 1, 0)
+	err := createVxlan("testvxlan", 1, 0, true, false)
 	if err != nil {
 		logrus.Errorf("Failed to create testvxlan interface: %v", err)
 		return
@@ -603,7 +698,8 @@ func (n *network) setupSubnetSandbox(s *subnet, brName, vxlanName string) error
 		return fmt.Errorf("bridge creation in sandbox failed for subnet %q: %v", s.subnetIP.String(), err)
 	}
 
-	err := createVxlan(vxlanName, s.vni, n.maxMTU())
+	// With a limit, the remote entries are only programmed by the driver
+	err := createVxlan(vxlanName, s.vni, n.maxMTU(), n.fdbLimit == 0, n.driver.tunnelCsum())
 	if err != nil {
 		return err
 	}
@@ -676,6 +772,21 @@ func (n *network) initSubnetSandbox(s *subnet, restore bool) error {
 	s.vxlanName = vxlanName
 	s.brName = brName
 
+	if n.noUnicastFlood {
+		if err := n.setUnknownUnicastFlood(s, false); err != nil {
+			logrus.Warnf("Failed to suppress the unknown unicast flooding of network %.7s: %v", n.id, err)
+		}
+	}
+
+	if n.hostGW {
+		if hostMode {
+			logrus.Warnf("The host-gw mode of network %.7s requires a network sandbox, its peers are encapsulated", n.id)
+		} else if err := n.setupHostGWSubnet(s); err != nil {
+			logrus.Warnf("Failed to set up the host-gw mode of network %.7s, its peers are encapsulated: %v", n.id, err)
+			n.cleanupHostGW()
+		}
+	}
+
 	return nil
 }
 
@@ -848,12 +959,17 @@ func (n *network) watchMiss(nlSock *nl.NetlinkSocket, nsPath string) {
 			}
 
 			logrus.Debugf("miss notification: dest IP %v, dest MAC %v", ip, mac)
+			limiter := n.limiter()
+			if !limiter.allow(ip, time.Now()) {
+				continue
+			}
 			mac, IPmask, vtep, err := n.driver.resolvePeer(n.id, ip)
+			limiter.done(ip, err == nil, time.Now())
 			if err != nil {
 				logrus.Errorf("could not resolve peer %q: %v", ip, err)
 				continue
 			}
-			n.driver.peerAdd(n.id, "dummy", ip, IPmask, mac, vtep, l2Miss, l3Miss, false)
+			n.learnPeer(learnedPeer{ip: ip, mask: IPmask, mac: mac, vtep: vtep}, l2Miss, l3Miss)
 		}
 	}
 }
@@ -935,6 +1051,23 @@ func (n *network) Value() []byte {
 	}
 
 	m["secure"] = n.secure
+	if n.selectiveEncryption() {
+		var subnets, pairs []string
+		for _, es := range n.encSubnets {
+			subnets = append(subnets, es.String())
+		}
+		for _, p := range n.encPairs {
+			pairs = append(pairs, p.String())
+		}
+		m["encrypted_subnets"] = strings.Join(subnets, ",")
+		m["encrypted_pairs"] = strings.Join(pairs, ",")
+	}
+	m["multicast"] = n.multicast
+	m["arp_rate"] = n.arpRate
+	m["no_unicast_flood"] = n.noUnicastFlood
+	m["fdb_limit"] = n.fdbLimit
+	m["host_gw"] = n.hostGW
+	m["host_gw_aggregate"] = n.hostGWAggregate
 	m["subnets"] = netJSON
 	m["mtu"] = n.mtu
 	b, err := json.Marshal(m)
@@ -986,6 +1119,39 @@ func (n *network) SetValue(value []byte) error {
 		if val, ok := m["secure"]; ok {
 			n.secure = val.(bool)
 		}
+		if val, ok := m["encrypted_subnets"]; ok && val.(string) != "" {
+			subnets, err := parseEncryptedSubnets(val.(string))
+			if err != nil {
+				return err
+			}
+			n.encSubnets = subnets
+		}
+		if val, ok := m["encrypted_pairs"]; ok && val.(string) != "" {
+			pairs, err := parseEncryptedPairs(val.(string))
+			if err != nil {
+				return err
+			}
+			n.encPairs = pairs
+		}
+		if val, ok := m["multicast"]; ok {
+			n.multicast = val.(bool)
+		}
+		n.arpRate = defaultARPRate
+		if val, ok := m["arp_rate"]; ok {
+			n.arpRate = int(val.(float64))
+		}
+		if val, ok := m["no_unicast_flood"]; ok {
+			n.noUnicastFlood = val.(bool)
+		}
+		if val, ok := m["fdb_limit"]; ok {
+			n.fdbLimit = int(val.(float64))
+		}
+		if val, ok := m["host_gw"]; ok {
+			n.hostGW = val.(bool)
+		}
+		if val, ok := m["host_gw_aggregate"]; ok {
+			n.hostGWAggregate = val.(bool)
+		}
 		if val, ok := m["mtu"]; ok {
 			n.mtu = int(val.(float64))
 		}
@@ -27,6 +27,8 @@ const (
 	vxlanIDEnd   = (1 << 24) - 1
 	vxlanEncap   = 50
 	secureOption = "encrypted"
+	// multicastOption enables the multicast forwarding of the network
+	multicastOption = "multicast"
 )
 
 var initVxlanIdm = make(chan (bool), 1)
@@ -52,6 +54,10 @@ type driver struct {
 	keys             []*key
 	peerOpCh         chan *peerOperation
 	peerOpCancel     context.CancelFunc
+	// vxlanOffload enables the UDP tunnel offloads of the underlay device
+	vxlanOffload  bool
+	offloadOnce   sync.Once
+	udpTunnelCsum bool
 	sync.Mutex
 }
 
@@ -71,6 +77,16 @@ func Init(dc driverapi.DriverCallback, config map[string]interface{}) error {
 		peerOpCh: make(chan *peerOperation),
 	}
 
+	var err error
+	if d.vxlanOffload, err = parseOffloadOption(config, netlabel.OverlayVxlanOffload); err != nil {
+		return err
+	}
+	espOffloadEnabled, err := parseOffloadOption(config, netlabel.OverlayESPOffload)
+	if err != nil {
+		return err
+	}
+	espOffload.enable(espOffloadEnabled)
+
 	// Launch the go routine for processing peer operations
 	ctx, cancel := context.WithCancel(context.Background())
 	d.peerOpCancel = cancel
@@ -245,6 +261,8 @@ func (d *driver) nodeJoin(advertiseAddress, bindAddress string, self bool) {
 		d.bindAddress = bindAddress
 		d.Unlock()
 
+		d.initTunnelOffload(advertiseAddress, bindAddress)
+
 		// If containers are already running on this network update the
 		// advertise address in the peerDB
 		d.localJoinOnce.Do(func() {
@@ -353,13 +353,17 @@ func (d *driver) peerAddOp(nid, eid string, peerIP net.IP, peerIPMask net.IPMask
 		}
 	}
 
-	// Local peers do not need any further configuration
-	if localPeer {
+	n := d.network(nid)
+	if n == nil {
 		return nil
 	}
 
-	n := d.network(nid)
-	if n == nil {
+	// Local peers do not need any further configuration, but for their
+	// host route when the network is routed
+	if localPeer {
+		if n.hostGWActive() {
+			return n.programHostGWLocal(peerIP, true)
+		}
 		return nil
 	}
 
@@ -389,6 +393,16 @@ func (d *driver) peerAddOp(nid, eid string, peerIP net.IP, peerIPMask net.IPMask
 		return fmt.Errorf("subnet sandbox join failed for %q: %v", s.subnetIP.String(), err)
 	}
 
+	// The peers on the local segment are routed rather than encapsulated
+	if n.hostGWActive() {
+		if hostGWReachable(vtep) {
+			return n.programHostGWPeer(peerIP, vtep, true)
+		}
+		if err := n.reserveHostGWPeer(peerIP, true); err != nil {
+			logrus.Warn(err)
+		}
+	}
+
 	if err := d.checkEncryption(nid, vtep, n.vxlanID(s), false, true); err != nil {
 		logrus.Warn(err)
 	}
@@ -410,6 +424,12 @@ func (d *driver) peerAddOp(nid, eid string, peerIP net.IP, peerIPMask net.IPMask
 		return fmt.Errorf("could not add fdb entry for nid:%s eid:%s into the sandbox:%v", nid, eid, err)
 	}
 
+	if n.multicast {
+		if err := n.programFloodEntry(s, vtep, true); err != nil {
+			return fmt.Errorf("could not add the multicast flood entry for nid:%s eid:%s into the sandbox:%v", nid, eid, err)
+		}
+	}
+
 	return nil
 }
 
@@ -451,12 +471,37 @@ func (d *driver) peerDeleteOp(nid, eid string, peerIP net.IP, peerIPMask net.IPM
 		return nil
 	}
 
-	if err := d.checkEncryption(nid, vtep, 0, localPeer, false); err != nil {
+	if localPeer && n.hostGWActive() {
+		if err := n.programHostGWLocal(peerIP, false); err != nil {
+			logrus.Debug(err)
+		}
+	}
+
+	// The routed peers have no encryption, flood, fdb nor neighbor entry
+	routed := !localPeer && n.hostGWRouted(peerIP)
+	if routed {
+		if err := n.programHostGWPeer(peerIP, vtep, false); err != nil {
+			return err
+		}
+	} else if err := d.checkEncryption(nid, vtep, 0, localPeer, false); err != nil {
 		logrus.Warn(err)
 	}
+	if !localPeer && !routed && n.hostGWActive() {
+		if err := n.reserveHostGWPeer(peerIP, false); err != nil {
+			logrus.Debug(err)
+		}
+	}
+
+	if n.multicast && !localPeer && !routed {
+		if s := n.getSubnetforIP(&net.IPNet{IP: peerIP, Mask: peerIPMask}); s != nil {
+			if err := d.releaseFloodEntry(n, s, vtep); err != nil {
+				logrus.Warn(err)
+			}
+		}
+	}
 
 	// Local peers do not have any local configuration to delete
-	if !localPeer {
+	if !localPeer && !routed {
 		// Remove fdb entry to the bridge for the peer mac
 		if err := sbox.DeleteNeighbor(vtep, peerMac, true); err != nil {
 			if _, ok := err.(osl.NeighborSearchError); ok && dbEntries > 0 {
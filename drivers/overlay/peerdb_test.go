@@ -1,6 +1,7 @@
 package overlay
 
 import (
+	"context"
 	"net"
 	"testing"
 
@@ -28,3 +29,39 @@ func TestPeerMarshal(t *testing.T) {
 		t.Fatalf("Incorrect Unmarshalling for eid: %v != %v", x.vtep, p.vtep)
 	}
 }
+
+func TestCleanupOrphanEndpoint(t *testing.T) {
+	d := &driver{
+		peerDb:   peerNetworkMap{mp: map[string]*peerMap{}},
+		peerOpCh: make(chan *peerOperation),
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go d.peerOpRoutine(ctx, d.peerOpCh)
+
+	mask := net.CIDRMask(24, 32)
+	vtep := net.ParseIP("192.168.56.10")
+	mac1, _ := net.ParseMAC("02:42:0a:00:00:02")
+	mac2, _ := net.ParseMAC("02:42:0a:00:00:03")
+	mac3, _ := net.ParseMAC("02:42:0a:00:00:04")
+	d.peerDbAdd("nid", "orphan", net.ParseIP("10.0.0.2"), mask, mac1, vtep, false)
+	d.peerDbAdd("nid", "orphan", net.ParseIP("10.0.0.3"), mask, mac2, vtep, false)
+	d.peerDbAdd("nid", "other", net.ParseIP("10.0.0.4"), mask, mac3, vtep, false)
+	d.peerDbAdd("nid2", "orphan", net.ParseIP("10.0.0.2"), mask, mac1, vtep, false)
+
+	if err := d.CleanupOrphanEndpoint("nid", "orphan"); err != nil {
+		t.Fatal(err)
+	}
+	// The peer operations are handled in order, the flush of an unknown
+	// network returning once the deletions are done
+	d.peerFlush("sync")
+
+	peers := map[string]string{}
+	d.peerDbWalk(func(nid string, pKey *peerKey, pEntry *peerEntry) bool {
+		peers[nid+" "+pKey.peerIP.String()] = pEntry.eid
+		return false
+	})
+	if len(peers) != 2 || peers["nid 10.0.0.4"] != "other" || peers["nid2 10.0.0.2"] != "orphan" {
+		t.Fatalf("unexpected peers left: %v", peers)
+	}
+}
@@ -0,0 +1,124 @@
+package overlay
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"syscall"
+
+	"github.com/docker/libnetwork/driverapi"
+	"github.com/sirupsen/logrus"
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netns"
+)
+
+// Reconcile checks the neighbor and fdb entries of the remote peers in the
+// overlay sandboxes against the peer database
+func (d *driver) Reconcile(repair bool) ([]driverapi.Drift, error) {
+	d.Lock()
+	nids := make([]string, 0, len(d.networks))
+	for nid := range d.networks {
+		nids = append(nids, nid)
+	}
+	d.Unlock()
+
+	var drifts []driverapi.Drift
+	for _, nid := range nids {
+		n := d.network(nid)
+		if n == nil || n.sandbox() == nil {
+			continue
+		}
+		dr, err := n.reconcile(repair)
+		if err != nil {
+			logrus.Warnf("Failed to reconcile overlay network %.7s: %v", nid, err)
+			continue
+		}
+		drifts = append(drifts, dr...)
+	}
+	return drifts, nil
+}
+
+func (n *network) reconcile(repair bool) ([]driverapi.Drift, error) {
+	sbox := n.sandbox()
+	nsh, err := netns.GetFromPath(sbox.Key())
+	if err != nil {
+		return nil, fmt.Errorf("failed to open namespace %s: %v", sbox.Key(), err)
+	}
+	defer nsh.Close()
+	nlh, err := netlink.NewHandleAt(nsh, syscall.NETLINK_ROUTE)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open a netlink handle in %s: %v", sbox.Key(), err)
+	}
+	defer nlh.Delete()
+
+	// The vxlan interfaces are renamed when moved into the sandbox
+	dstNames := map[string]string{}
+	for _, i := range sbox.Info().Interfaces() {
+		dstNames[i.SrcName()] = i.DstName()
+	}
+
+	var drifts []driverapi.Drift
+	n.driver.peerDbNetworkWalk(n.id, func(pKey *peerKey, pEntry *peerEntry) bool {
+		if pEntry.isLocal {
+			return false
+		}
+		s := n.getSubnetforIP(&net.IPNet{IP: pKey.peerIP, Mask: pEntry.peerIPMask})
+		if s == nil || s.vxlanName == "" {
+			return false
+		}
+		link, err := nlh.LinkByName(dstNames[s.vxlanName])
+		if err != nil {
+			drifts = append(drifts, driverapi.Drift{
+				NetworkID: n.id,
+				Resource:  "link",
+				Detail:    fmt.Sprintf("vxlan interface %s missing in the sandbox of subnet %s", s.vxlanName, s.subnetIP),
+			})
+			return false
+		}
+		idx := link.Attrs().Index
+
+		if !hasNeighbor(nlh, idx, syscall.AF_INET, pKey.peerIP, pKey.peerMac) {
+			dr := driverapi.Drift{
+				NetworkID:  n.id,
+				EndpointID: pEntry.eid,
+				Resource:   "neighbor",
+				Detail:     fmt.Sprintf("neighbor entry %s %s missing on %s", pKey.peerIP, pKey.peerMac, s.vxlanName),
+			}
+			if repair {
+				dr.SetRepairResult(sbox.AddNeighbor(pKey.peerIP, pKey.peerMac, true, sbox.NeighborOptions().LinkName(s.vxlanName)))
+			}
+			drifts = append(drifts, dr)
+		}
+
+		if !hasNeighbor(nlh, idx, syscall.AF_BRIDGE, pEntry.vtep, pKey.peerMac) {
+			dr := driverapi.Drift{
+				NetworkID:  n.id,
+				EndpointID: pEntry.eid,
+				Resource:   "fdb",
+				Detail:     fmt.Sprintf("fdb entry %s dst %s missing on %s", pKey.peerMac, pEntry.vtep, s.vxlanName),
+			}
+			if repair {
+				dr.SetRepairResult(sbox.AddNeighbor(pEntry.vtep, pKey.peerMac, true, sbox.NeighborOptions().LinkName(s.vxlanName),
+					sbox.NeighborOptions().Family(syscall.AF_BRIDGE)))
+			}
+			drifts = append(drifts, dr)
+		}
+		return false
+	})
+
+	return drifts, nil
+}
+
+func hasNeighbor(nlh *netlink.Handle, linkIndex, family int, ip net.IP, mac net.HardwareAddr) bool {
+	neighs, err := nlh.NeighList(linkIndex, family)
+	if err != nil {
+		// Do not report a drift on a transient error
+		return true
+	}
+	for _, nh := range neighs {
+		if nh.IP.Equal(ip) && bytes.Equal(nh.HardwareAddr, mac) {
+			return true
+		}
+	}
+	return false
+}
@@ -6,6 +6,7 @@ package api
 
 import (
 	"net"
+	"time"
 
 	"github.com/docker/libnetwork/discoverapi"
 	"github.com/docker/libnetwork/driverapi"
@@ -21,11 +22,19 @@ func (r *Response) GetError() string {
 	return r.Err
 }
 
+// Protocol versions a remote driver can advertise in its capabilities.
+// Drivers which do not advertise any version speak ProtocolVersion1.
+const (
+	ProtocolVersion1 = 1
+	ProtocolVersion2 = 2
+)
+
 // GetCapabilityResponse is the response of GetCapability request
 type GetCapabilityResponse struct {
 	Response
 	Scope             string
 	ConnectivityScope string
+	ProtocolVersion   int
 }
 
 // AllocateNetworkRequest requests allocation of new network by manager
@@ -219,3 +228,91 @@ type DiscoveryNotification struct {
 type DiscoveryResponse struct {
 	Response
 }
+
+// EventType identifies the kind of endpoint event sent to a protocol v2 driver
+type EventType string
+
+const (
+	// EventEndpointJoined is sent once an endpoint has been joined to a sandbox
+	EventEndpointJoined EventType = "EndpointJoined"
+	// EventEndpointLeft is sent once an endpoint has left its sandbox
+	EventEndpointLeft EventType = "EndpointLeft"
+	// EventExternalConnectivity is sent once external connectivity has been programmed for an endpoint
+	EventExternalConnectivity EventType = "ExternalConnectivity"
+	// EventFilterApplied is sent once the driver has applied, or lifted, the filter policy of an endpoint
+	EventFilterApplied EventType = "FilterApplied"
+	// EventError is sent when an operation on an endpoint failed
+	EventError EventType = "Error"
+)
+
+// Event describes a change of state of an endpoint owned by the driver
+type Event struct {
+	Type       EventType
+	NetworkID  string
+	EndpointID string
+	SandboxKey string `json:",omitempty"`
+	Operation  string `json:",omitempty"`
+	Error      string `json:",omitempty"`
+	// FilterVersion is the version of the filter policy of a FilterApplied
+	// event, empty when the policy was lifted
+	FilterVersion string `json:",omitempty"`
+	Timestamp     time.Time
+}
+
+// EventsRequest carries a batch of events to a protocol v2 driver
+type EventsRequest struct {
+	Events []Event
+}
+
+// EventsResponse is the response to an EventsRequest
+type EventsResponse struct {
+	Response
+}
+
+// EndpointState is the libnetwork view of an endpoint owned by the driver
+type EndpointState struct {
+	NetworkID  string
+	EndpointID string
+	SandboxKey string `json:",omitempty"`
+	Joined     bool
+}
+
+// SyncEndpointsRequest carries the full list of endpoints libnetwork knows
+// about for the driver, sent when a protocol v2 driver (re)activates. After
+// a daemon restart the list holds the endpoints of the networks of the
+// driver in the store.
+type SyncEndpointsRequest struct {
+	Endpoints []EndpointState
+}
+
+// SyncEndpointsResponse is the response to a SyncEndpointsRequest
+type SyncEndpointsResponse struct {
+	Response
+}
+
+// FilterEndpointRequest asks a protocol v2 driver to replace the filter
+// policy of an endpoint, or to lift it when the policy is nil
+type FilterEndpointRequest struct {
+	NetworkID  string
+	EndpointID string
+	Policy     *driverapi.FilterPolicy
+}
+
+// FilterEndpointResponse is the response to a FilterEndpointRequest
+type FilterEndpointResponse struct {
+	Response
+}
+
+// FilterCountersRequest asks a protocol v2 driver for the counters of the
+// filter policy of an endpoint
+type FilterCountersRequest struct {
+	NetworkID  string
+	EndpointID string
+}
+
+// FilterCountersResponse is the response to a FilterCountersRequest, with
+// no counters when the endpoint has no filter policy
+type FilterCountersResponse struct {
+	Response
+	Counters *driverapi.FilterCounters
+}
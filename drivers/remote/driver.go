@@ -1,8 +1,11 @@
 package remote
 
 import (
+	"context"
 	"fmt"
 	"net"
+	"sync"
+	"time"
 
 	"github.com/docker/docker/pkg/plugingetter"
 	"github.com/docker/docker/pkg/plugins"
@@ -18,6 +21,11 @@ import (
 type driver struct {
 	endpoint    *plugins.Client
 	networkType string
+	protocol    int
+	events      chan api.Event
+	stopCh      chan struct{}
+	endpoints   map[string]*api.EndpointState
+	sync.Mutex
 }
 
 type maybeError interface {
@@ -25,22 +33,57 @@ type maybeError interface {
 }
 
 func newDriver(name string, client *plugins.Client) driverapi.Driver {
-	return &driver{networkType: name, endpoint: client}
+	return &driver{
+		networkType: name,
+		endpoint:    client,
+		protocol:    api.ProtocolVersion1,
+		endpoints:   make(map[string]*api.EndpointState),
+	}
 }
 
 // Init makes sure a remote driver is registered when a network driver
 // plugin is activated.
 func Init(dc driverapi.DriverCallback, config map[string]interface{}) error {
+	var (
+		mu     sync.Mutex
+		active = make(map[string]*driver)
+	)
 	newPluginHandler := func(name string, client *plugins.Client) {
 		// negotiate driver capability with client
-		d := newDriver(name, client)
-		c, err := d.(*driver).getCapabilities()
+		d := newDriver(name, client).(*driver)
+		c, err := d.getCapabilities()
 		if err != nil {
 			logrus.Errorf("error getting capability for %s due to %v", name, err)
 			return
 		}
+
+		// A plugin being activated again under the same name has been
+		// restarted. Carry over what we know about its endpoints so that
+		// protocol v2 plugins can reconcile their state. The previous
+		// instance keeps delivering its events until the new one is
+		// registered.
+		mu.Lock()
+		old := active[name]
+		if old != nil {
+			d.adoptState(old)
+		}
+		mu.Unlock()
+
+		d.startEvents()
 		if err = dc.RegisterDriver(name, d, *c); err != nil {
+			d.stopEvents()
 			logrus.Errorf("error registering driver for %s due to %v", name, err)
+			return
+		}
+
+		mu.Lock()
+		active[name] = d
+		mu.Unlock()
+		if old != nil {
+			old.stopEvents()
+		}
+		if err = d.syncEndpoints(); err != nil {
+			logrus.Warnf("error syncing endpoints with driver %s: %v", name, err)
 		}
 	}
 
@@ -112,6 +155,15 @@ func (d *driver) getCapabilities() (*driverapi.Capability, error) {
 		return nil, fmt.Errorf("invalid capability: expecting 'local' or 'global', got %s", capResp.Scope)
 	}
 
+	switch capResp.ProtocolVersion {
+	case 0, api.ProtocolVersion1:
+		d.protocol = api.ProtocolVersion1
+	case api.ProtocolVersion2:
+		d.protocol = api.ProtocolVersion2
+	default:
+		return nil, fmt.Errorf("invalid capability: unsupported protocol version %d", capResp.ProtocolVersion)
+	}
+
 	return c, nil
 }
 
@@ -123,9 +175,24 @@ func (d *driver) Config(option map[string]interface{}) error {
 }
 
 func (d *driver) call(methodName string, arg interface{}, retVal maybeError) error {
+	return d.callContext(context.Background(), methodName, arg, retVal)
+}
+
+// callContext bounds the plugin request by the deadline of the context
+func (d *driver) callContext(ctx context.Context, methodName string, arg interface{}, retVal maybeError) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	var opts []func(*plugins.RequestOpts)
+	if deadline, ok := ctx.Deadline(); ok {
+		opts = append(opts, plugins.WithRequestTimeout(time.Until(deadline)))
+	}
 	method := driverapi.NetworkPluginEndpointType + "." + methodName
-	err := d.endpoint.Call(method, arg, retVal)
+	err := d.endpoint.CallWithOptions(method, arg, retVal, opts...)
 	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
 		return err
 	}
 	if e := retVal.GetError(); e != "" {
@@ -197,8 +264,10 @@ func (d *driver) CreateEndpoint(nid, eid string, ifInfo driverapi.InterfaceInfo,
 	}
 	var res api.CreateEndpointResponse
 	if err := d.call("CreateEndpoint", create, &res); err != nil {
+		d.emitError("CreateEndpoint", nid, eid, err)
 		return err
 	}
+	d.trackEndpoint(nid, eid)
 
 	inIface, err := parseInterface(res)
 	if err != nil {
@@ -241,7 +310,12 @@ func (d *driver) DeleteEndpoint(nid, eid string) error {
 		NetworkID:  nid,
 		EndpointID: eid,
 	}
-	return d.call("DeleteEndpoint", delete, &api.DeleteEndpointResponse{})
+	if err := d.call("DeleteEndpoint", delete, &api.DeleteEndpointResponse{}); err != nil {
+		d.emitError("DeleteEndpoint", nid, eid, err)
+		return err
+	}
+	d.untrackEndpoint(eid)
+	return nil
 }
 
 func (d *driver) EndpointOperInfo(nid, eid string) (map[string]interface{}, error) {
@@ -258,6 +332,11 @@ func (d *driver) EndpointOperInfo(nid, eid string) (map[string]interface{}, erro
 
 // Join method is invoked when a Sandbox is attached to an endpoint.
 func (d *driver) Join(nid, eid string, sboxKey string, jinfo driverapi.JoinInfo, options map[string]interface{}) error {
+	return d.JoinContext(context.Background(), nid, eid, sboxKey, jinfo, options)
+}
+
+// JoinContext behaves as Join, bounding the plugin request by the context
+func (d *driver) JoinContext(ctx context.Context, nid, eid string, sboxKey string, jinfo driverapi.JoinInfo, options map[string]interface{}) error {
 	join := &api.JoinRequest{
 		NetworkID:  nid,
 		EndpointID: eid,
@@ -268,7 +347,8 @@ func (d *driver) Join(nid, eid string, sboxKey string, jinfo driverapi.JoinInfo,
 		res api.JoinResponse
 		err error
 	)
-	if err = d.call("Join", join, &res); err != nil {
+	if err = d.callContext(ctx, "Join", join, &res); err != nil {
+		d.emitError("Join", nid, eid, err)
 		return err
 	}
 
@@ -310,6 +390,8 @@ func (d *driver) Join(nid, eid string, sboxKey string, jinfo driverapi.JoinInfo,
 	if res.DisableGatewayService {
 		jinfo.DisableGatewayService()
 	}
+	d.setJoined(nid, eid, sboxKey, true)
+	d.emit(api.Event{Type: api.EventEndpointJoined, NetworkID: nid, EndpointID: eid, SandboxKey: sboxKey})
 	return nil
 }
 
@@ -319,22 +401,39 @@ func (d *driver) Leave(nid, eid string) error {
 		NetworkID:  nid,
 		EndpointID: eid,
 	}
-	return d.call("Leave", leave, &api.LeaveResponse{})
+	if err := d.call("Leave", leave, &api.LeaveResponse{}); err != nil {
+		d.emitError("Leave", nid, eid, err)
+		return err
+	}
+	d.setJoined(nid, eid, "", false)
+	d.emit(api.Event{Type: api.EventEndpointLeft, NetworkID: nid, EndpointID: eid})
+	return nil
 }
 
 // ProgramExternalConnectivity is invoked to program the rules to allow external connectivity for the endpoint.
 func (d *driver) ProgramExternalConnectivity(nid, eid string, options map[string]interface{}) error {
+	return d.ProgramExternalConnectivityContext(context.Background(), nid, eid, options)
+}
+
+// ProgramExternalConnectivityContext behaves as ProgramExternalConnectivity,
+// bounding the plugin request by the context
+func (d *driver) ProgramExternalConnectivityContext(ctx context.Context, nid, eid string, options map[string]interface{}) error {
 	data := &api.ProgramExternalConnectivityRequest{
 		NetworkID:  nid,
 		EndpointID: eid,
 		Options:    options,
 	}
-	err := d.call("ProgramExternalConnectivity", data, &api.ProgramExternalConnectivityResponse{})
+	err := d.callContext(ctx, "ProgramExternalConnectivity", data, &api.ProgramExternalConnectivityResponse{})
 	if err != nil && plugins.IsNotFound(err) {
 		// It is not mandatory yet to support this method
 		return nil
 	}
-	return err
+	if err != nil {
+		d.emitError("ProgramExternalConnectivity", nid, eid, err)
+		return err
+	}
+	d.emit(api.Event{Type: api.EventExternalConnectivity, NetworkID: nid, EndpointID: eid})
+	return nil
 }
 
 // RevokeExternalConnectivity method is invoked to remove any external connectivity programming related to the endpoint.
@@ -351,6 +450,46 @@ func (d *driver) RevokeExternalConnectivity(nid, eid string) error {
 	return err
 }
 
+// FilterEndpoint hands the filter policy of the endpoint to the plugin, a
+// protocol v2 one only
+func (d *driver) FilterEndpoint(nid, eid string, policy *driverapi.FilterPolicy) error {
+	if !d.supportsV2() {
+		return types.NotImplementedErrorf("the %s remote driver does not support the filter policies of the endpoints", d.networkType)
+	}
+	filter := &api.FilterEndpointRequest{
+		NetworkID:  nid,
+		EndpointID: eid,
+		Policy:     policy,
+	}
+	if err := d.call("FilterEndpoint", filter, &api.FilterEndpointResponse{}); err != nil {
+		d.emitError("FilterEndpoint", nid, eid, err)
+		return err
+	}
+	ev := api.Event{Type: api.EventFilterApplied, NetworkID: nid, EndpointID: eid}
+	if policy != nil {
+		ev.FilterVersion = policy.Version
+	}
+	d.emit(ev)
+	return nil
+}
+
+// FilterCounters returns the counters of the filter policy of the endpoint
+// the plugin keeps
+func (d *driver) FilterCounters(nid, eid string) (*driverapi.FilterCounters, error) {
+	if !d.supportsV2() {
+		return nil, types.NotImplementedErrorf("the %s remote driver does not support the filter policies of the endpoints", d.networkType)
+	}
+	counters := &api.FilterCountersRequest{
+		NetworkID:  nid,
+		EndpointID: eid,
+	}
+	var res api.FilterCountersResponse
+	if err := d.call("FilterCounters", counters, &res); err != nil {
+		return nil, err
+	}
+	return res.Counters, nil
+}
+
 func (d *driver) Type() string {
 	return d.networkType
 }
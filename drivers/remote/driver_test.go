@@ -12,11 +12,13 @@ import (
 	"net/http/httptest"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/docker/docker/pkg/plugins"
 	"github.com/docker/libnetwork/datastore"
 	"github.com/docker/libnetwork/discoverapi"
 	"github.com/docker/libnetwork/driverapi"
+	"github.com/docker/libnetwork/drivers/remote/api"
 	_ "github.com/docker/libnetwork/testutils"
 	"github.com/docker/libnetwork/types"
 )
@@ -470,6 +472,13 @@ func TestRemoteDriver(t *testing.T) {
 	if err = d.DiscoverDelete(discoverapi.NodeDiscovery, data); err != nil {
 		t.Fatal(err)
 	}
+
+	// The filter policies need protocol v2
+	if err = d.(*driver).FilterEndpoint(netID, endID, &driverapi.FilterPolicy{Version: "v1"}); err == nil {
+		t.Fatal("filter policy handed to a protocol v1 driver")
+	} else if _, ok := err.(types.NotImplementedError); !ok {
+		t.Fatalf("unexpected error %v", err)
+	}
 }
 
 func TestDriverError(t *testing.T) {
@@ -607,3 +616,187 @@ func TestRollback(t *testing.T) {
 		t.Fatal("Expected to have had DeleteEndpoint called")
 	}
 }
+
+func TestRemoteDriverV2Events(t *testing.T) {
+	var plugin = "test-net-driver-v2"
+
+	mux := http.NewServeMux()
+	defer setupPlugin(t, plugin, mux)()
+
+	eventCh := make(chan map[string]interface{}, 16)
+	syncCh := make(chan []interface{}, 1)
+
+	handle(t, mux, "GetCapabilities", func(msg map[string]interface{}) interface{} {
+		return map[string]interface{}{
+			"Scope":           "local",
+			"ProtocolVersion": 2,
+		}
+	})
+	handle(t, mux, "CreateEndpoint", func(msg map[string]interface{}) interface{} {
+		return map[string]interface{}{}
+	})
+	handle(t, mux, "Join", func(msg map[string]interface{}) interface{} {
+		return map[string]interface{}{}
+	})
+	handle(t, mux, "Leave", func(msg map[string]interface{}) interface{} {
+		return map[string]interface{}{
+			"Err": "leave failed",
+		}
+	})
+	handle(t, mux, "FilterEndpoint", func(msg map[string]interface{}) interface{} {
+		policy := msg["Policy"].(map[string]interface{})
+		if msg["EndpointID"] != "ep1" || policy["Version"] != "v1" {
+			t.Fatalf("unexpected filter request %v", msg)
+		}
+		return map[string]interface{}{}
+	})
+	handle(t, mux, "FilterCounters", func(msg map[string]interface{}) interface{} {
+		return map[string]interface{}{
+			"Counters": map[string]interface{}{"Version": "v1", "Accepted": 3, "Rejected": 1},
+		}
+	})
+	handle(t, mux, "Events", func(msg map[string]interface{}) interface{} {
+		for _, ev := range msg["Events"].([]interface{}) {
+			eventCh <- ev.(map[string]interface{})
+		}
+		return map[string]interface{}{}
+	})
+	handle(t, mux, "SyncEndpoints", func(msg map[string]interface{}) interface{} {
+		eps, _ := msg["Endpoints"].([]interface{})
+		syncCh <- eps
+		return map[string]interface{}{}
+	})
+
+	p, err := plugins.Get(plugin, driverapi.NetworkPluginEndpointType)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := getPluginClient(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := newDriver(plugin, client).(*driver)
+	if _, err := d.getCapabilities(); err != nil {
+		t.Fatal(err)
+	}
+	if !d.supportsV2() {
+		t.Fatal("driver should have negotiated protocol v2")
+	}
+	d.startEvents()
+	defer d.stopEvents()
+
+	if err := d.CreateEndpoint("net1", "ep1", &testEndpoint{t: t}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Join("net1", "ep1", "sandbox-key", &testEndpoint{t: t}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Leave("net1", "ep1"); err == nil {
+		t.Fatal("expected leave to fail")
+	}
+	if err := d.FilterEndpoint("net1", "ep1", &driverapi.FilterPolicy{Version: "v1"}); err != nil {
+		t.Fatal(err)
+	}
+	counters, err := d.FilterCounters("net1", "ep1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if counters == nil || counters.Version != "v1" || counters.Accepted != 3 || counters.Rejected != 1 {
+		t.Fatalf("unexpected filter counters %+v", counters)
+	}
+
+	expected := []struct {
+		etype string
+		op    string
+	}{
+		{string(api.EventEndpointJoined), ""},
+		{string(api.EventError), "Leave"},
+		{string(api.EventFilterApplied), ""},
+	}
+	for _, exp := range expected {
+		select {
+		case ev := <-eventCh:
+			if ev["Type"] != exp.etype || ev["EndpointID"] != "ep1" {
+				t.Fatalf("unexpected event %v, expected type %s", ev, exp.etype)
+			}
+			if exp.op != "" && ev["Operation"] != exp.op {
+				t.Fatalf("unexpected operation in event %v, expected %s", ev, exp.op)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for %s event", exp.etype)
+		}
+	}
+
+	// Simulate a plugin restart: the new driver instance must replay
+	// the endpoint state to the plugin.
+	nd := newDriver(plugin, client).(*driver)
+	if _, err := nd.getCapabilities(); err != nil {
+		t.Fatal(err)
+	}
+	nd.adoptState(d)
+	if err := nd.syncEndpoints(); err != nil {
+		t.Fatal(err)
+	}
+	eps := <-syncCh
+	if len(eps) != 1 {
+		t.Fatalf("expected 1 endpoint in sync request, got %v", eps)
+	}
+	st := eps[0].(map[string]interface{})
+	if st["EndpointID"] != "ep1" || st["Joined"] != true || st["SandboxKey"] != "sandbox-key" {
+		t.Fatalf("unexpected endpoint state in sync request: %v", st)
+	}
+
+	// After a daemon restart the state comes from the endpoints of the
+	// store instead, the state of the previous instance winning
+	rd := newDriver(plugin, client).(*driver)
+	if _, err := rd.getCapabilities(); err != nil {
+		t.Fatal(err)
+	}
+	rd.adoptState(d)
+	rd.RestoreEndpoints([]driverapi.EndpointState{
+		{NetworkID: "net1", EndpointID: "ep1"},
+		{NetworkID: "net1", EndpointID: "ep2", SandboxKey: "other-key", Joined: true},
+	})
+	if err := rd.syncEndpoints(); err != nil {
+		t.Fatal(err)
+	}
+	eps = <-syncCh
+	if len(eps) != 2 {
+		t.Fatalf("expected 2 endpoints in sync request, got %v", eps)
+	}
+	if st := eps[0].(map[string]interface{}); st["EndpointID"] != "ep1" || st["SandboxKey"] != "sandbox-key" {
+		t.Fatalf("unexpected endpoint state in sync request: %v", st)
+	}
+	if st := eps[1].(map[string]interface{}); st["EndpointID"] != "ep2" || st["Joined"] != true || st["SandboxKey"] != "other-key" {
+		t.Fatalf("unexpected restored endpoint state in sync request: %v", st)
+	}
+}
+
+func TestGetUnsupportedProtocolVersion(t *testing.T) {
+	var plugin = "test-net-driver-bad-proto"
+
+	mux := http.NewServeMux()
+	defer setupPlugin(t, plugin, mux)()
+
+	handle(t, mux, "GetCapabilities", func(msg map[string]interface{}) interface{} {
+		return map[string]interface{}{
+			"Scope":           "local",
+			"ProtocolVersion": 42,
+		}
+	})
+
+	p, err := plugins.Get(plugin, driverapi.NetworkPluginEndpointType)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := getPluginClient(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := newDriver(plugin, client)
+	if _, err = d.(*driver).getCapabilities(); err == nil {
+		t.Fatal("There should be error reported when an unsupported protocol version is advertised")
+	}
+}
@@ -0,0 +1,165 @@
+package remote
+
+import (
+	"sort"
+	"time"
+
+	"github.com/docker/libnetwork/driverapi"
+	"github.com/docker/libnetwork/drivers/remote/api"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// eventQueueSize bounds the number of events buffered for a driver.
+	// Events are dropped rather than blocking driver operations when the
+	// plugin does not keep up.
+	eventQueueSize = 256
+	// maxEventBatch is the maximum number of events sent in a single call
+	maxEventBatch = 64
+)
+
+func (d *driver) supportsV2() bool {
+	return d.protocol >= api.ProtocolVersion2
+}
+
+// startEvents starts the event dispatcher for protocol v2 drivers
+func (d *driver) startEvents() {
+	if !d.supportsV2() {
+		return
+	}
+	d.events = make(chan api.Event, eventQueueSize)
+	d.stopCh = make(chan struct{})
+	go d.dispatchEvents(d.events, d.stopCh)
+}
+
+// stopEvents stops the event dispatcher, if running
+func (d *driver) stopEvents() {
+	if d.stopCh != nil {
+		close(d.stopCh)
+		d.stopCh = nil
+	}
+}
+
+func (d *driver) dispatchEvents(events chan api.Event, stopCh chan struct{}) {
+	for {
+		select {
+		case <-stopCh:
+			return
+		case ev := <-events:
+			batch := []api.Event{ev}
+		drain:
+			for len(batch) < maxEventBatch {
+				select {
+				case ev := <-events:
+					batch = append(batch, ev)
+				default:
+					break drain
+				}
+			}
+			if err := d.call("Events", &api.EventsRequest{Events: batch}, &api.EventsResponse{}); err != nil {
+				logrus.Warnf("remote driver %s: failed to deliver %d events: %v", d.networkType, len(batch), err)
+			}
+		}
+	}
+}
+
+func (d *driver) emit(ev api.Event) {
+	if d.events == nil {
+		return
+	}
+	ev.Timestamp = time.Now().UTC()
+	select {
+	case d.events <- ev:
+	default:
+		logrus.Warnf("remote driver %s: event queue full, dropping %s event for endpoint %s", d.networkType, ev.Type, ev.EndpointID)
+	}
+}
+
+func (d *driver) emitError(op, nid, eid string, err error) {
+	if err == nil {
+		return
+	}
+	d.emit(api.Event{Type: api.EventError, Operation: op, NetworkID: nid, EndpointID: eid, Error: err.Error()})
+}
+
+func (d *driver) trackEndpoint(nid, eid string) {
+	if !d.supportsV2() {
+		return
+	}
+	d.Lock()
+	d.endpoints[eid] = &api.EndpointState{NetworkID: nid, EndpointID: eid}
+	d.Unlock()
+}
+
+func (d *driver) untrackEndpoint(eid string) {
+	if !d.supportsV2() {
+		return
+	}
+	d.Lock()
+	delete(d.endpoints, eid)
+	d.Unlock()
+}
+
+func (d *driver) setJoined(nid, eid, sboxKey string, joined bool) {
+	if !d.supportsV2() {
+		return
+	}
+	d.Lock()
+	st, ok := d.endpoints[eid]
+	if !ok {
+		st = &api.EndpointState{NetworkID: nid, EndpointID: eid}
+		d.endpoints[eid] = st
+	}
+	st.Joined = joined
+	st.SandboxKey = sboxKey
+	d.Unlock()
+}
+
+// adoptState takes over the endpoint state tracked by the driver
+// instance which was registered for the same plugin before it restarted
+func (d *driver) adoptState(old *driver) {
+	old.Lock()
+	defer old.Unlock()
+	d.Lock()
+	defer d.Unlock()
+	for eid, st := range old.endpoints {
+		cp := *st
+		d.endpoints[eid] = &cp
+	}
+}
+
+// RestoreEndpoints seeds the endpoint state with the endpoints of the
+// networks of the plugin in the store, for the sync of a plugin activated
+// again after a daemon restart. The state carried over from the previous
+// instance of the plugin is kept.
+func (d *driver) RestoreEndpoints(eps []driverapi.EndpointState) {
+	if !d.supportsV2() {
+		return
+	}
+	d.Lock()
+	defer d.Unlock()
+	for _, ep := range eps {
+		if _, ok := d.endpoints[ep.EndpointID]; ok {
+			continue
+		}
+		d.endpoints[ep.EndpointID] = &api.EndpointState{NetworkID: ep.NetworkID, EndpointID: ep.EndpointID, SandboxKey: ep.SandboxKey, Joined: ep.Joined}
+	}
+}
+
+// syncEndpoints sends the full list of known endpoints to the plugin, so
+// that it can reconcile its own state after a restart
+func (d *driver) syncEndpoints() error {
+	if !d.supportsV2() {
+		return nil
+	}
+	d.Lock()
+	req := &api.SyncEndpointsRequest{Endpoints: make([]api.EndpointState, 0, len(d.endpoints))}
+	for _, st := range d.endpoints {
+		req.Endpoints = append(req.Endpoints, *st)
+	}
+	d.Unlock()
+	sort.Slice(req.Endpoints, func(i, j int) bool {
+		return req.Endpoints[i].EndpointID < req.Endpoints[j].EndpointID
+	})
+	return d.call("SyncEndpoints", req, &api.SyncEndpointsResponse{})
+}
@@ -4,22 +4,30 @@ import (
 	"github.com/docker/libnetwork/drvregistry"
 	"github.com/docker/libnetwork/ipamapi"
 	builtinIpam "github.com/docker/libnetwork/ipams/builtin"
+	dhcpIpam "github.com/docker/libnetwork/ipams/dhcp"
 	nullIpam "github.com/docker/libnetwork/ipams/null"
 	remoteIpam "github.com/docker/libnetwork/ipams/remote"
+	restIpam "github.com/docker/libnetwork/ipams/rest"
 	"github.com/docker/libnetwork/ipamutils"
 )
 
-func initIPAMDrivers(r *drvregistry.DrvRegistry, lDs, gDs interface{}, addressPool []*ipamutils.NetworkToSplit) error {
+func initIPAMDrivers(r *drvregistry.DrvRegistry, lDs, gDs interface{}, addressPool []*ipamutils.NetworkToSplit, restIPAMs []restIpam.Config) error {
 	builtinIpam.SetDefaultIPAddressPool(addressPool)
 	for _, fn := range [](func(ipamapi.Callback, interface{}, interface{}) error){
 		builtinIpam.Init,
 		remoteIpam.Init,
 		nullIpam.Init,
+		dhcpIpam.Init,
 	} {
 		if err := fn(r, lDs, gDs); err != nil {
 			return err
 		}
 	}
+	for _, cfg := range restIPAMs {
+		if err := restIpam.Register(r, cfg); err != nil {
+			return err
+		}
+	}
 
 	return nil
 }
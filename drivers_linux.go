@@ -2,6 +2,7 @@ package libnetwork
 
 import (
 	"github.com/docker/libnetwork/drivers/bridge"
+	"github.com/docker/libnetwork/drivers/cni"
 	"github.com/docker/libnetwork/drivers/host"
 	"github.com/docker/libnetwork/drivers/ipvlan"
 	"github.com/docker/libnetwork/drivers/macvlan"
@@ -13,6 +14,7 @@ import (
 func getInitializers(experimental bool) []initializer {
 	in := []initializer{
 		{bridge.Init, "bridge"},
+		{cni.Init, "cni"},
 		{host.Init, "host"},
 		{ipvlan.Init, "ipvlan"},
 		{macvlan.Init, "macvlan"},
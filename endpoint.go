@@ -1,14 +1,19 @@
 package libnetwork
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net"
 	"strings"
 	"sync"
 
+	"github.com/docker/libnetwork/authz"
 	"github.com/docker/libnetwork/datastore"
+	"github.com/docker/libnetwork/driverapi"
+	"github.com/docker/libnetwork/ephook"
 	"github.com/docker/libnetwork/ipamapi"
+	"github.com/docker/libnetwork/journal"
 	"github.com/docker/libnetwork/netlabel"
 	"github.com/docker/libnetwork/options"
 	"github.com/docker/libnetwork/types"
@@ -30,6 +35,10 @@ type Endpoint interface {
 	// the network resources allocated for the endpoint.
 	Join(sandbox Sandbox, options ...EndpointOption) error
 
+	// JoinContext behaves as Join and rolls the join back when the context
+	// is done before it completes.
+	JoinContext(ctx context.Context, sandbox Sandbox, options ...EndpointOption) error
+
 	// Leave detaches the network resources populated in the sandbox.
 	Leave(sandbox Sandbox, options ...EndpointOption) error
 
@@ -75,6 +84,10 @@ type endpoint struct {
 	dbExists          bool
 	serviceEnabled    bool
 	loadBalancer      bool
+	staticRoutes      []*types.StaticRoute
+	idempotencyKey    string
+	stableMAC         bool
+	macKey            string
 	sync.Mutex
 }
 
@@ -102,6 +115,14 @@ func (ep *endpoint) MarshalJSON() ([]byte, error) {
 	epMap["ingressPorts"] = ep.ingressPorts
 	epMap["svcAliases"] = ep.svcAliases
 	epMap["loadBalancer"] = ep.loadBalancer
+	epMap["staticRoutes"] = ep.staticRoutes
+	if ep.idempotencyKey != "" {
+		epMap["idempotencyKey"] = ep.idempotencyKey
+	}
+	if ep.stableMAC {
+		epMap["stableMAC"] = ep.stableMAC
+		epMap["macKey"] = ep.macKey
+	}
 
 	return json.Marshal(epMap)
 }
@@ -206,6 +227,17 @@ func (ep *endpoint) UnmarshalJSON(b []byte) (err error) {
 		ep.loadBalancer = v.(bool)
 	}
 
+	if v, ok := epMap["idempotencyKey"]; ok {
+		ep.idempotencyKey = v.(string)
+	}
+
+	if v, ok := epMap["stableMAC"]; ok {
+		ep.stableMAC = v.(bool)
+	}
+	if v, ok := epMap["macKey"]; ok {
+		ep.macKey = v.(string)
+	}
+
 	sal, _ := json.Marshal(epMap["svcAliases"])
 	var svcAliases []string
 	json.Unmarshal(sal, &svcAliases)
@@ -220,6 +252,11 @@ func (ep *endpoint) UnmarshalJSON(b []byte) (err error) {
 	var myAliases []string
 	json.Unmarshal(ma, &myAliases)
 	ep.myAliases = myAliases
+
+	sr, _ := json.Marshal(epMap["staticRoutes"])
+	var staticRoutes []*types.StaticRoute
+	json.Unmarshal(sr, &staticRoutes)
+	ep.staticRoutes = staticRoutes
 	return nil
 }
 
@@ -244,6 +281,9 @@ func (ep *endpoint) CopyTo(o datastore.KVObject) error {
 	dstEp.svcID = ep.svcID
 	dstEp.virtualIP = ep.virtualIP
 	dstEp.loadBalancer = ep.loadBalancer
+	dstEp.idempotencyKey = ep.idempotencyKey
+	dstEp.stableMAC = ep.stableMAC
+	dstEp.macKey = ep.macKey
 
 	dstEp.svcAliases = make([]string, len(ep.svcAliases))
 	copy(dstEp.svcAliases, ep.svcAliases)
@@ -251,6 +291,9 @@ func (ep *endpoint) CopyTo(o datastore.KVObject) error {
 	dstEp.ingressPorts = make([]*PortConfig, len(ep.ingressPorts))
 	copy(dstEp.ingressPorts, ep.ingressPorts)
 
+	dstEp.staticRoutes = make([]*types.StaticRoute, len(ep.staticRoutes))
+	copy(dstEp.staticRoutes, ep.staticRoutes)
+
 	if ep.iface != nil {
 		dstEp.iface = &endpointInterface{}
 		ep.iface.CopyTo(dstEp.iface)
@@ -427,6 +470,10 @@ func (ep *endpoint) getNetworkFromStore() (*network, error) {
 }
 
 func (ep *endpoint) Join(sbox Sandbox, options ...EndpointOption) error {
+	return ep.JoinContext(context.Background(), sbox, options...)
+}
+
+func (ep *endpoint) JoinContext(ctx context.Context, sbox Sandbox, options ...EndpointOption) error {
 	if sbox == nil {
 		return types.BadRequestErrorf("endpoint cannot be joined by nil container")
 	}
@@ -436,19 +483,41 @@ func (ep *endpoint) Join(sbox Sandbox, options ...EndpointOption) error {
 		return types.BadRequestErrorf("not a valid Sandbox interface")
 	}
 
-	sb.joinLeaveStart()
+	if err := ep.authorize(authz.EndpointJoin, sb); err != nil {
+		return err
+	}
+
+	defer sb.controller.opTracer.start(opJoin)()
+	t := sb.controller.startOpTiming(opJoin, ep.Name())
+	defer t.end()
+
+	done := t.TimeStep("lock")
+	err := sb.joinLeaveStartContext(ctx)
+	done()
+	if err != nil {
+		return err
+	}
 	defer sb.joinLeaveEnd()
 
-	return ep.sbJoin(sb, options...)
+	if err := ep.sbJoin(t.context(ctx), sb, options...); err != nil {
+		return err
+	}
+	ep.openEndpoint()
+	return nil
 }
 
-func (ep *endpoint) sbJoin(sb *sandbox, options ...EndpointOption) (err error) {
+// sbJoin joins the endpoint to the sandbox, timing its steps on the step
+// timer the context may carry, which is handed over to the driver
+func (ep *endpoint) sbJoin(ctx context.Context, sb *sandbox, options ...EndpointOption) (err error) {
+	done := driverapi.TimeStep(ctx, "store")
 	n, err := ep.getNetworkFromStore()
 	if err != nil {
+		done()
 		return fmt.Errorf("failed to get network from store during join: %v", err)
 	}
 
 	ep, err = n.getEndpointFromStore(ep.ID())
+	done()
 	if err != nil {
 		return fmt.Errorf("failed to get endpoint from store during join: %v", err)
 	}
@@ -475,12 +544,48 @@ func (ep *endpoint) sbJoin(sb *sandbox, options ...EndpointOption) (err error) {
 
 	ep.processOptions(options...)
 
+	if prio := n.gatewayPriority(); prio != 0 {
+		sb.Lock()
+		if _, ok := sb.epPriority[epid]; !ok {
+			sb.epPriority[epid] = prio
+		}
+		sb.Unlock()
+	}
+
 	d, err := n.driver(true)
 	if err != nil {
 		return fmt.Errorf("failed to get driver during join: %v", err)
 	}
 
-	err = d.Join(nid, epid, sb.Key(), ep, sb.Labels())
+	done = driverapi.TimeStep(ctx, "hooks")
+	err = n.getController().runEndpointHooks(ctx, ephook.PhasePreJoin, n, ep, sb)
+	done()
+	if err != nil {
+		return err
+	}
+	// the join is published, journaled and handed to the post-join hooks
+	// on each of its successful returns, the early ones of the gateway and
+	// load balancer endpoints included, as the deferred check sees the
+	// error they return
+	defer func() {
+		if err == nil {
+			c := n.getController()
+			c.publishEndpointEvent(EventEndpointJoin, n, ep, sb)
+			c.journalEndpoint(journal.EndpointJoin, n, ep, sb)
+			c.runEndpointHooks(context.Background(), ephook.PhasePostJoin, n, ep, sb)
+		}
+	}()
+
+	done = driverapi.TimeStep(ctx, "throttle")
+	release, err := n.getController().acquireDriverOp(ctx, opJoin)
+	done()
+	if err != nil {
+		return err
+	}
+	done = driverapi.TimeStep(ctx, "driver")
+	err = driverJoin(ctx, d, nid, epid, sb.Key(), ep, sb.joinOptions())
+	done()
+	release()
 	if err != nil {
 		return err
 	}
@@ -492,21 +597,28 @@ func (ep *endpoint) sbJoin(sb *sandbox, options ...EndpointOption) (err error) {
 		}
 	}()
 
+	// Add the routes requested for the endpoint to the ones set by the driver
+	ep.addUserStaticRoutes(n.routeMetricValue())
+
 	// Watch for service records
 	if !n.getController().isAgent() {
 		n.getController().watchSvcRecord(ep)
 	}
 
+	done = driverapi.TimeStep(ctx, "dns")
 	if doUpdateHostsFile(n, sb) {
 		address := ""
 		if ip := ep.getFirstInterfaceAddress(); ip != nil {
 			address = ip.String()
 		}
 		if err = sb.updateHostsFile(address); err != nil {
+			done()
 			return err
 		}
 	}
-	if err = sb.updateDNS(n.enableIPv6); err != nil {
+	err = sb.updateDNS(n.enableIPv6)
+	done()
+	if err != nil {
 		return err
 	}
 
@@ -520,15 +632,32 @@ func (ep *endpoint) sbJoin(sb *sandbox, options ...EndpointOption) (err error) {
 		}
 	}()
 
-	if err = sb.populateNetworkResources(ep); err != nil {
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+
+	done = driverapi.TimeStep(ctx, "sandbox")
+	err = sb.populateNetworkResources(ctx, ep)
+	done()
+	if err != nil {
+		return err
+	}
+
+	if err = ctx.Err(); err != nil {
 		return err
 	}
 
-	if err = n.getController().updateToStore(ep); err != nil {
+	done = driverapi.TimeStep(ctx, "store")
+	err = n.getController().updateToStore(ep)
+	done()
+	if err != nil {
 		return err
 	}
 
-	if err = ep.addDriverInfoToCluster(); err != nil {
+	done = driverapi.TimeStep(ctx, "cluster")
+	err = ep.addDriverInfoToCluster()
+	done()
+	if err != nil {
 		return err
 	}
 
@@ -547,12 +676,13 @@ func (ep *endpoint) sbJoin(sb *sandbox, options ...EndpointOption) (err error) {
 	}
 
 	if sb.needDefaultGW() && sb.getEndpointInGWNetwork() == nil {
-		return sb.setupDefaultGW()
+		return sb.setupDefaultGW(ctx)
 	}
 
 	moveExtConn := sb.getGatewayEndpoint() != extEp
 
 	if moveExtConn {
+		defer driverapi.TimeStep(ctx, "external-connectivity")()
 		if extEp != nil {
 			logrus.Debugf("Revoking external connectivity on endpoint %s (%s)", extEp.Name(), extEp.ID())
 			extN, err := extEp.getNetworkFromStore()
@@ -579,7 +709,7 @@ func (ep *endpoint) sbJoin(sb *sandbox, options ...EndpointOption) (err error) {
 		}
 		if !n.internal {
 			logrus.Debugf("Programming external connectivity on endpoint %s (%s)", ep.Name(), ep.ID())
-			if err = d.ProgramExternalConnectivity(n.ID(), ep.ID(), sb.Labels()); err != nil {
+			if err = driverProgramExternalConnectivity(ctx, d, n.ID(), ep.ID(), sb.Labels()); err != nil {
 				return types.InternalErrorf(
 					"driver failed programming external connectivity on endpoint %s (%s): %v",
 					ep.Name(), ep.ID(), err)
@@ -598,6 +728,40 @@ func (ep *endpoint) sbJoin(sb *sandbox, options ...EndpointOption) (err error) {
 	return nil
 }
 
+// joinNamespace lets the driver of the endpoint plumb it in the namespace
+// of the sandbox, set after the endpoint joined
+func (ep *endpoint) joinNamespace(sb *sandbox) error {
+	n, err := ep.getNetworkFromStore()
+	if err != nil {
+		return err
+	}
+	d, err := n.driver(true)
+	if err != nil {
+		return err
+	}
+	if nj, ok := d.(driverapi.NamespaceJoiner); ok {
+		if err := nj.JoinNamespace(n.ID(), ep.ID(), sb.Key()); err != nil {
+			return fmt.Errorf("failed to join endpoint %s to the namespace of sandbox %.7s: %v", ep.Name(), sb.ID(), err)
+		}
+	}
+	return nil
+}
+
+// driverJoin hands the context to the drivers able to abort a join
+func driverJoin(ctx context.Context, d driverapi.Driver, nid, eid string, sboxKey string, jinfo driverapi.JoinInfo, options map[string]interface{}) error {
+	if cj, ok := d.(driverapi.ContextJoiner); ok {
+		return cj.JoinContext(ctx, nid, eid, sboxKey, jinfo, options)
+	}
+	return d.Join(nid, eid, sboxKey, jinfo, options)
+}
+
+func driverProgramExternalConnectivity(ctx context.Context, d driverapi.Driver, nid, eid string, options map[string]interface{}) error {
+	if cj, ok := d.(driverapi.ContextJoiner); ok {
+		return cj.ProgramExternalConnectivityContext(ctx, nid, eid, options)
+	}
+	return d.ProgramExternalConnectivity(nid, eid, options)
+}
+
 func doUpdateHostsFile(n *network, sb *sandbox) bool {
 	return !n.ingress && n.Name() != libnGWNetwork
 }
@@ -698,19 +862,34 @@ func (ep *endpoint) Leave(sbox Sandbox, options ...EndpointOption) error {
 		return types.BadRequestErrorf("not a valid Sandbox interface")
 	}
 
+	if err := ep.authorize(authz.EndpointLeave, sb); err != nil {
+		return err
+	}
+
+	defer sb.controller.opTracer.start(opLeave)()
+	t := sb.controller.startOpTiming(opLeave, ep.Name())
+	defer t.end()
+
+	done := t.TimeStep("lock")
 	sb.joinLeaveStart()
+	done()
 	defer sb.joinLeaveEnd()
 
-	return ep.sbLeave(sb, false, options...)
+	return ep.sbLeave(t, sb, false, options...)
 }
 
-func (ep *endpoint) sbLeave(sb *sandbox, force bool, options ...EndpointOption) error {
+// sbLeave detaches the endpoint from the sandbox, timing its steps on t
+// which may be nil
+func (ep *endpoint) sbLeave(t *opTiming, sb *sandbox, force bool, options ...EndpointOption) (err error) {
+	done := t.TimeStep("store")
 	n, err := ep.getNetworkFromStore()
 	if err != nil {
+		done()
 		return fmt.Errorf("failed to get network from store during leave: %v", err)
 	}
 
 	ep, err = n.getEndpointFromStore(ep.ID())
+	done()
 	if err != nil {
 		return fmt.Errorf("failed to get endpoint from store during leave: %v", err)
 	}
@@ -745,43 +924,67 @@ func (ep *endpoint) sbLeave(sb *sandbox, force bool, options ...EndpointOption)
 	if d != nil {
 		if moveExtConn {
 			logrus.Debugf("Revoking external connectivity on endpoint %s (%s)", ep.Name(), ep.ID())
+			done = t.TimeStep("external-connectivity")
 			if err := d.RevokeExternalConnectivity(n.id, ep.id); err != nil {
 				logrus.Warnf("driver failed revoking external connectivity on endpoint %s (%s): %v",
 					ep.Name(), ep.ID(), err)
 			}
+			done()
 		}
 
+		done = t.TimeStep("driver")
 		if err := d.Leave(n.id, ep.id); err != nil {
 			if _, ok := err.(types.MaskableError); !ok {
 				logrus.Warnf("driver error disconnecting container %s : %v", ep.name, err)
 			}
 		}
+		done()
 	}
 
+	done = t.TimeStep("cluster")
 	if err := ep.deleteServiceInfoFromCluster(sb, true, "sbLeave"); err != nil {
 		logrus.Warnf("Failed to clean up service info on container %s disconnect: %v", ep.name, err)
 	}
+	done()
 
+	done = t.TimeStep("sandbox")
 	if err := sb.clearNetworkResources(ep); err != nil {
 		logrus.Warnf("Failed to clean up network resources on container %s disconnect: %v", ep.name, err)
 	}
+	done()
 
 	// Update the store about the sandbox detach only after we
 	// have completed sb.clearNetworkresources above to avoid
 	// spurious logs when cleaning up the sandbox when the daemon
 	// ungracefully exits and restarts before completing sandbox
 	// detach but after store has been updated.
-	if err := n.getController().updateToStore(ep); err != nil {
+	done = t.TimeStep("store")
+	err = n.getController().updateToStore(ep)
+	done()
+	if err != nil {
 		return err
 	}
+	// the leave is published and journaled on each of its successful
+	// returns, the early one of the sandbox falling back to the gateway
+	// network included
+	defer func() {
+		if err == nil {
+			n.getController().publishEndpointEvent(EventEndpointLeave, n, ep, sb)
+			n.getController().journalEndpoint(journal.EndpointLeave, n, ep, sb)
+		}
+	}()
 
 	if e := ep.deleteDriverInfoFromCluster(); e != nil {
 		logrus.Errorf("Failed to delete endpoint state for endpoint %s from cluster: %v", ep.Name(), e)
 	}
 
+	done = t.TimeStep("hooks")
+	n.getController().runEndpointHooks(context.Background(), ephook.PhaseLeave, n, ep, sb)
+	done()
+
 	sb.deleteHostsEntries(n.getSvcRecords(ep))
 	if !sb.inDelete && sb.needDefaultGW() && sb.getEndpointInGWNetwork() == nil {
-		return sb.setupDefaultGW()
+		return sb.setupDefaultGW(context.Background())
 	}
 
 	// New endpoint providing external connectivity for the sandbox
@@ -836,11 +1039,15 @@ func (ep *endpoint) Delete(force bool) error {
 	}
 
 	if sb != nil {
-		if e := ep.sbLeave(sb.(*sandbox), force); e != nil {
+		if e := ep.sbLeave(nil, sb.(*sandbox), force); e != nil {
 			logrus.Warnf("failed to leave sandbox for endpoint %s : %v", name, e)
 		}
 	}
 
+	// The addresses of the endpoint are held until released, the leaked
+	// address collection no longer finds the endpoint in the store
+	defer n.getController().holdDeletedAddresses(ep)()
+
 	if err = n.getController().deleteFromStore(ep); err != nil {
 		return err
 	}
@@ -861,11 +1068,17 @@ func (ep *endpoint) Delete(force bool) error {
 		return err
 	}
 
+	n.getController().runEndpointHooks(context.Background(), ephook.PhaseDelete, n, ep, nil)
+
 	ep.releaseAddress()
+	n.getController().forgetPausedRecords(ep.ID())
+	n.getController().forgetAccounting(ep.ID())
+	n.getController().forgetFilterHistory(n, ep.ID())
 
 	if err := n.getEpCnt().DecEndpointCnt(); err != nil {
 		logrus.Warnf("failed to decrement endpoint count for ep %s: %v", ep.ID(), err)
 	}
+	n.getController().journalEndpoint(journal.EndpointDelete, n, ep, nil)
 
 	return nil
 }
@@ -912,6 +1125,66 @@ func (ep *endpoint) getSandbox() (*sandbox, bool) {
 	return ps, ok
 }
 
+func validateStaticRoutes(routes []*types.StaticRoute) error {
+	for _, r := range routes {
+		if r.Destination == nil {
+			return types.BadRequestErrorf("static route without destination")
+		}
+		switch r.RouteType {
+		case types.NEXTHOP:
+			if r.NextHop == nil {
+				return types.BadRequestErrorf("static route to %s has no next hop", r.Destination)
+			}
+			if (r.NextHop.To4() == nil) != (r.Destination.IP.To4() == nil) {
+				return types.BadRequestErrorf("next hop %s and destination %s of static route are of different families", r.NextHop, r.Destination)
+			}
+		case types.CONNECTED:
+			if r.NextHop != nil {
+				return types.BadRequestErrorf("connected static route to %s cannot have a next hop", r.Destination)
+			}
+		default:
+			return types.BadRequestErrorf("static route to %s has invalid type %d", r.Destination, r.RouteType)
+		}
+	}
+	return nil
+}
+
+// addUserStaticRoutes adds the routes of CreateOptionStaticRoutes to the
+// join info, they are then programmed and removed along with the routes
+// set by the driver. The next hop routes without a metric get the one of
+// the network.
+func (ep *endpoint) addUserStaticRoutes(metric int) {
+	ep.Lock()
+	defer ep.Unlock()
+
+	for _, r := range ep.staticRoutes {
+		if r.RouteType == types.NEXTHOP {
+			ep.joinInfo.StaticRoutes = append(ep.joinInfo.StaticRoutes, r.GetCopy())
+			continue
+		}
+		// The interface routes persist across joins
+		found := false
+		for _, dst := range ep.iface.routes {
+			if types.CompareIPNet(dst, r.Destination) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			ep.iface.routes = append(ep.iface.routes, types.GetIPNetCopy(r.Destination))
+		}
+	}
+
+	if metric == 0 {
+		return
+	}
+	for _, r := range ep.joinInfo.StaticRoutes {
+		if r.Metric == 0 {
+			r.Metric = metric
+		}
+	}
+}
+
 func (ep *endpoint) getFirstInterfaceAddress() net.IP {
 	ep.Lock()
 	defer ep.Unlock()
@@ -919,6 +1192,9 @@ func (ep *endpoint) getFirstInterfaceAddress() net.IP {
 	if ep.iface.addr != nil {
 		return ep.iface.addr.IP
 	}
+	if ep.iface.addrv6 != nil {
+		return ep.iface.addrv6.IP
+	}
 
 	return nil
 }
@@ -1032,6 +1308,39 @@ func CreateOptionMyAlias(alias string) EndpointOption {
 	}
 }
 
+// CreateOptionStaticRoutes function returns an option setter for the static
+// routes to program in the sandbox when the endpoint joins it. A
+// types.NEXTHOP route goes via its next hop, a types.CONNECTED route via
+// the endpoint interface.
+func CreateOptionStaticRoutes(routes []*types.StaticRoute) EndpointOption {
+	return func(ep *endpoint) {
+		for _, r := range routes {
+			ep.staticRoutes = append(ep.staticRoutes, r.GetCopy())
+		}
+	}
+}
+
+// CreateOptionIdempotencyKey sets the key identifying the creation of the
+// endpoint across the retries of the caller: a creation with the key of an
+// endpoint of the network created before returns that endpoint.
+func CreateOptionIdempotencyKey(key string) EndpointOption {
+	return func(ep *endpoint) {
+		ep.idempotencyKey = key
+	}
+}
+
+// CreateOptionStableMAC makes the MAC address of the endpoint stable
+// across its recreations: the MAC assigned to the key on the network the
+// first time is kept in the store and given to the endpoints created with
+// the key later on. The MAC is derived from the IPv4 address of the
+// endpoint when the key is empty. An explicit MAC address prevails.
+func CreateOptionStableMAC(key string) EndpointOption {
+	return func(ep *endpoint) {
+		ep.stableMAC = true
+		ep.macKey = key
+	}
+}
+
 // CreateOptionLoadBalancer function returns an option setter for denoting the endpoint is a load balancer for a network
 func CreateOptionLoadBalancer() EndpointOption {
 	return func(ep *endpoint) {
@@ -1070,7 +1379,7 @@ func (ep *endpoint) assignAddress(ipam ipamapi.Ipam, assignIPv4, assignIPv6 bool
 
 	logrus.Debugf("Assigning addresses for endpoint %s's interface on network %s", ep.Name(), n.Name())
 
-	if assignIPv4 {
+	if assignIPv4 && !n.ipv6Only {
 		if err = ep.assignAddressVersion(4, ipam); err != nil {
 			return err
 		}
@@ -1120,11 +1429,29 @@ func (ep *endpoint) assignAddressVersion(ipVer int, ipam ipamapi.Ipam) error {
 		progAdd = (*address).IP
 	}
 
+	opts := ep.ipamOptions
+	if _, ok := n.ipamOptions[ipamapi.HostPoolSize]; ok {
+		// Allocate out of the pools carved for this host
+		if host := n.getController().clusterHostID(); host != "" {
+			opts = make(map[string]string, len(ep.ipamOptions)+1)
+			for k, v := range ep.ipamOptions {
+				opts[k] = v
+			}
+			opts[ipamapi.HostPoolOwner] = host
+		}
+	}
+
+	if ipVer == 6 && progAdd == nil {
+		if mode := n.ipv6AddressMode(); mode != "" {
+			return ep.assignDerivedAddressV6(ipam, ipInfo, mode, opts)
+		}
+	}
+
 	for _, d := range ipInfo {
 		if progAdd != nil && !d.Pool.Contains(progAdd) {
 			continue
 		}
-		addr, _, err := ipam.RequestAddress(d.PoolID, progAdd, ep.ipamOptions)
+		addr, _, err := ipam.RequestAddress(d.PoolID, progAdd, opts)
 		if err == nil {
 			ep.Lock()
 			*address = addr
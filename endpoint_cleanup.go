@@ -0,0 +1,25 @@
+package libnetwork
+
+import (
+	"github.com/docker/libnetwork/driverapi"
+	"github.com/sirupsen/logrus"
+)
+
+// ForceEndpointCleanup has the drivers implementing
+// driverapi.DeferredCleaner remove the resources of the deleted endpoints
+// still in their quiesce period, and returns how many endpoints got cleaned up
+func (c *controller) ForceEndpointCleanup() int {
+	var count int
+	c.drvRegistry.WalkDrivers(func(name string, driver driverapi.Driver, capability driverapi.Capability) bool {
+		dc, ok := driver.(driverapi.DeferredCleaner)
+		if !ok {
+			return false
+		}
+		if n := dc.ForceCleanup(); n > 0 {
+			logrus.Debugf("Driver %s cleaned up %d deleted endpoints", name, n)
+			count += n
+		}
+		return false
+	})
+	return count
+}
@@ -174,9 +174,15 @@ retry:
 }
 
 func (ec *endpointCnt) IncEndpointCnt() error {
+	if ec.n.getController().writeBehindCount(ec, true) {
+		return nil
+	}
 	return ec.atomicIncDecEpCnt(true)
 }
 
 func (ec *endpointCnt) DecEndpointCnt() error {
+	if ec.n.getController().writeBehindCount(ec, false) {
+		return nil
+	}
 	return ec.atomicIncDecEpCnt(false)
 }
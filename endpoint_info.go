@@ -64,6 +64,7 @@ type endpointInterface struct {
 	routes    []*net.IPNet
 	v4PoolID  string
 	v6PoolID  string
+	timing    *opTiming
 }
 
 func (epi *endpointInterface) MarshalJSON() ([]byte, error) {
@@ -174,10 +175,19 @@ type endpointJoinInfo struct {
 	gw                    net.IP
 	gw6                   net.IP
 	StaticRoutes          []*types.StaticRoute
+	LoopbackAddrs         []*net.IPNet
+	Neighbors             []*staticNeighbor
 	driverTableEntries    []*tableEntry
 	disableGatewayService bool
 }
 
+// staticNeighbor is a permanent neighbor entry of the interface of the
+// endpoint in the sandbox
+type staticNeighbor struct {
+	ip  net.IP
+	mac net.HardwareAddr
+}
+
 type tableEntry struct {
 	tableName string
 	key       string
@@ -285,6 +295,11 @@ func (epi *endpointInterface) SetNames(srcName string, dstPrefix string) error {
 	return nil
 }
 
+// TimeStep times a step of the driver on the creation of the endpoint
+func (epi *endpointInterface) TimeStep(name string) func() {
+	return epi.timing.TimeStep(name)
+}
+
 func (ep *endpoint) InterfaceName() driverapi.InterfaceNameInfo {
 	ep.Lock()
 	defer ep.Unlock()
@@ -312,6 +327,22 @@ func (ep *endpoint) AddStaticRoute(destination *net.IPNet, routeType int, nextHo
 	return nil
 }
 
+func (ep *endpoint) AddLoopbackAddress(addr *net.IPNet) error {
+	ep.Lock()
+	defer ep.Unlock()
+
+	ep.joinInfo.LoopbackAddrs = append(ep.joinInfo.LoopbackAddrs, types.GetIPNetCopy(addr))
+	return nil
+}
+
+func (ep *endpoint) AddNeighbor(ip net.IP, mac net.HardwareAddr) error {
+	ep.Lock()
+	defer ep.Unlock()
+
+	ep.joinInfo.Neighbors = append(ep.joinInfo.Neighbors, &staticNeighbor{ip: types.GetIPCopy(ip), mac: types.GetMacCopy(mac)})
+	return nil
+}
+
 func (ep *endpoint) AddTableEntry(tableName, key string, value []byte) error {
 	ep.Lock()
 	defer ep.Unlock()
@@ -413,6 +444,20 @@ func (epj *endpointJoinInfo) MarshalJSON() ([]byte, error) {
 	}
 	epMap["disableGatewayService"] = epj.disableGatewayService
 	epMap["StaticRoutes"] = epj.StaticRoutes
+	if len(epj.LoopbackAddrs) != 0 {
+		addrs := make([]string, 0, len(epj.LoopbackAddrs))
+		for _, a := range epj.LoopbackAddrs {
+			addrs = append(addrs, a.String())
+		}
+		epMap["LoopbackAddrs"] = addrs
+	}
+	if len(epj.Neighbors) != 0 {
+		neighbors := make([]map[string]string, 0, len(epj.Neighbors))
+		for _, nh := range epj.Neighbors {
+			neighbors = append(neighbors, map[string]string{"IP": nh.ip.String(), "MAC": nh.mac.String()})
+		}
+		epMap["Neighbors"] = neighbors
+	}
 	return json.Marshal(epMap)
 }
 
@@ -444,6 +489,27 @@ func (epj *endpointJoinInfo) UnmarshalJSON(b []byte) error {
 	}
 	epj.StaticRoutes = StaticRoutes
 
+	if v, ok := epMap["LoopbackAddrs"]; ok {
+		for _, a := range v.([]interface{}) {
+			addr, err := types.ParseCIDR(a.(string))
+			if err != nil {
+				return err
+			}
+			epj.LoopbackAddrs = append(epj.LoopbackAddrs, addr)
+		}
+	}
+
+	if v, ok := epMap["Neighbors"]; ok {
+		for _, n := range v.([]interface{}) {
+			nh := n.(map[string]interface{})
+			mac, err := net.ParseMAC(nh["MAC"].(string))
+			if err != nil {
+				return err
+			}
+			epj.Neighbors = append(epj.Neighbors, &staticNeighbor{ip: net.ParseIP(nh["IP"].(string)), mac: mac})
+		}
+	}
+
 	return nil
 }
 
@@ -451,6 +517,14 @@ func (epj *endpointJoinInfo) CopyTo(dstEpj *endpointJoinInfo) error {
 	dstEpj.disableGatewayService = epj.disableGatewayService
 	dstEpj.StaticRoutes = make([]*types.StaticRoute, len(epj.StaticRoutes))
 	copy(dstEpj.StaticRoutes, epj.StaticRoutes)
+	dstEpj.LoopbackAddrs = make([]*net.IPNet, 0, len(epj.LoopbackAddrs))
+	for _, a := range epj.LoopbackAddrs {
+		dstEpj.LoopbackAddrs = append(dstEpj.LoopbackAddrs, types.GetIPNetCopy(a))
+	}
+	dstEpj.Neighbors = make([]*staticNeighbor, 0, len(epj.Neighbors))
+	for _, nh := range epj.Neighbors {
+		dstEpj.Neighbors = append(dstEpj.Neighbors, &staticNeighbor{ip: types.GetIPCopy(nh.ip), mac: types.GetMacCopy(nh.mac)})
+	}
 	dstEpj.driverTableEntries = make([]*tableEntry, len(epj.driverTableEntries))
 	copy(dstEpj.driverTableEntries, epj.driverTableEntries)
 	dstEpj.gw = types.GetIPCopy(epj.gw)
@@ -0,0 +1,85 @@
+package libnetwork
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/docker/libnetwork/ephook"
+	"github.com/docker/libnetwork/netlabel"
+	"github.com/sirupsen/logrus"
+)
+
+// endpointHookNames returns the configured endpoint hooks the network is
+// labeled with, in order
+func (c *controller) endpointHookNames(n *network) []string {
+	value, ok := n.Labels()[netlabel.EndpointHooks]
+	if !ok {
+		return nil
+	}
+	var names []string
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if _, ok := c.cfg.Daemon.EndpointHooks[name]; !ok {
+			logrus.Warnf("Network %s is labeled with the unknown endpoint hook %q", n.Name(), name)
+			continue
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+// endpointHookEvent returns the event of the phase of the endpoint, sb
+// being nil out of a sandbox
+func endpointHookEvent(phase ephook.Phase, n *network, ep *endpoint, sb *sandbox) *ephook.Event {
+	ev := &ephook.Event{
+		Phase:         phase,
+		NetworkID:     n.ID(),
+		NetworkName:   n.Name(),
+		NetworkType:   n.Type(),
+		NetworkLabels: n.Labels(),
+		EndpointID:    ep.ID(),
+		EndpointName:  ep.Name(),
+	}
+	if iface := ep.Iface(); iface != nil {
+		if mac := iface.MacAddress(); mac != nil {
+			ev.MacAddress = mac.String()
+		}
+		if addr := iface.Address(); addr != nil {
+			ev.IPv4Address = addr.String()
+		}
+		if addr := iface.AddressIPv6(); addr != nil {
+			ev.IPv6Address = addr.String()
+		}
+	}
+	if sb != nil {
+		ev.SandboxID = sb.ID()
+		ev.SandboxKey = sb.Key()
+		ev.ContainerID = sb.ContainerID()
+	}
+	return ev
+}
+
+// runEndpointHooks runs the endpoint hooks of the network on the phase of
+// the endpoint, in order. On a blocking phase the first failure is
+// returned and the hooks after it are not run.
+func (c *controller) runEndpointHooks(ctx context.Context, phase ephook.Phase, n *network, ep *endpoint, sb *sandbox) error {
+	names := c.endpointHookNames(n)
+	if len(names) == 0 {
+		return nil
+	}
+	ev := endpointHookEvent(phase, n, ep, sb)
+	for _, name := range names {
+		logrus.Debugf("Running endpoint hook %s on the %s", name, ev)
+		if err := c.cfg.Daemon.EndpointHooks[name].Run(ctx, ev); err != nil {
+			if phase.Blocking() {
+				return fmt.Errorf("endpoint hook %s failed on the %s: %v", name, ev, err)
+			}
+			logrus.Warnf("Endpoint hook %s failed on the %s: %v", name, ev, err)
+		}
+	}
+	return nil
+}
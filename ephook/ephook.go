@@ -0,0 +1,115 @@
+// Package ephook runs site specific actions on the lifecycle of the
+// endpoints, whatever their driver. A Hook is given each step of the
+// endpoints of the networks labeled with its name as an Event, so that
+// actions such as registering the addresses with an external IPAM or
+// configuring the top of rack switch need no driver of their own.
+package ephook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// Phase is the step of the lifecycle of an endpoint a hook is run on
+type Phase string
+
+const (
+	// PhaseCreate is run once the endpoint is created by its driver and
+	// has its addresses. A failure fails the creation.
+	PhaseCreate Phase = "create"
+	// PhasePreJoin is run before the endpoint joins the sandbox, its
+	// interface not being in the sandbox yet. A failure fails the join.
+	PhasePreJoin Phase = "pre-join"
+	// PhasePostJoin is run once the endpoint joined the sandbox
+	PhasePostJoin Phase = "post-join"
+	// PhaseLeave is run once the endpoint left the sandbox
+	PhaseLeave Phase = "leave"
+	// PhaseDelete is run once the endpoint is deleted
+	PhaseDelete Phase = "delete"
+)
+
+// Blocking tells whether a failure of a hook on the phase fails the
+// operation, the failures on the other phases being only logged
+func (p Phase) Blocking() bool {
+	return p == PhaseCreate || p == PhasePreJoin
+}
+
+// Event is the endpoint a hook is run for, as of the phase
+type Event struct {
+	Phase         Phase             `json:"phase"`
+	NetworkID     string            `json:"network_id"`
+	NetworkName   string            `json:"network_name"`
+	NetworkType   string            `json:"network_type"`
+	NetworkLabels map[string]string `json:"network_labels,omitempty"`
+	EndpointID    string            `json:"endpoint_id"`
+	EndpointName  string            `json:"endpoint_name"`
+	MacAddress    string            `json:"mac_address,omitempty"`
+	IPv4Address   string            `json:"ipv4_address,omitempty"`
+	IPv6Address   string            `json:"ipv6_address,omitempty"`
+	SandboxID     string            `json:"sandbox_id,omitempty"`
+	SandboxKey    string            `json:"sandbox_key,omitempty"`
+	ContainerID   string            `json:"container_id,omitempty"`
+}
+
+func (ev *Event) String() string {
+	return fmt.Sprintf("%s of endpoint %s (%.7s) on %s", ev.Phase, ev.EndpointName, ev.EndpointID, ev.NetworkName)
+}
+
+// Hook is an action run on the phases of the endpoints. The hooks of a
+// network are run in order, the operation waiting for them.
+type Hook interface {
+	Run(ctx context.Context, ev *Event) error
+}
+
+// Func is an in-process Hook
+type Func func(ctx context.Context, ev *Event) error
+
+// Run calls the function
+func (f Func) Run(ctx context.Context, ev *Event) error {
+	return f(ctx, ev)
+}
+
+// Command is a Hook running an executable, given the event as JSON on its
+// standard input and its phase in the LIBNETWORK_HOOK_PHASE variable. A
+// non zero exit status is a failure.
+type Command struct {
+	Path    string
+	Args    []string
+	Timeout time.Duration
+}
+
+const defaultCommandTimeout = 30 * time.Second
+
+// Run runs the command, killing it past its timeout
+func (c *Command) Run(ctx context.Context, ev *Event) error {
+	b, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	timeout := c.Timeout
+	if timeout == 0 {
+		timeout = defaultCommandTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, c.Path, c.Args...)
+	cmd.Stdin = bytes.NewReader(b)
+	cmd.Stderr = &stderr
+	cmd.Env = append(os.Environ(), "LIBNETWORK_HOOK_PHASE="+string(ev.Phase))
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("hook command %s failed: %v: %s", c.Path, err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+func (c *Command) String() string {
+	return "command:" + c.Path
+}
@@ -0,0 +1,73 @@
+package ephook
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCommand(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ephook")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	out := filepath.Join(dir, "event.json")
+	script := filepath.Join(dir, "hook")
+	if err := ioutil.WriteFile(script, []byte("#!/bin/sh\necho $LIBNETWORK_HOOK_PHASE > "+out+".phase\ncat > "+out+"\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	ev := &Event{Phase: PhasePreJoin, NetworkID: "n1", NetworkName: "net", EndpointID: "ep1", IPv4Address: "10.0.0.2/24"}
+	if err := (&Command{Path: script}).Run(context.Background(), ev); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got Event
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Phase != PhasePreJoin || got.EndpointID != "ep1" || got.IPv4Address != "10.0.0.2/24" || got.SandboxID != "" {
+		t.Fatalf("unexpected event %+v", got)
+	}
+	if b, _ := ioutil.ReadFile(out + ".phase"); strings.TrimSpace(string(b)) != "pre-join" {
+		t.Fatalf("unexpected phase %q", b)
+	}
+
+	if err := ioutil.WriteFile(script, []byte("#!/bin/sh\necho not registered >&2\nexit 3\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	err = (&Command{Path: script}).Run(context.Background(), ev)
+	if err == nil || !strings.Contains(err.Error(), "not registered") {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	if err := ioutil.WriteFile(script, []byte("#!/bin/sh\nexec sleep 5\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	if err := (&Command{Path: script, Timeout: 100 * time.Millisecond}).Run(context.Background(), ev); err == nil {
+		t.Fatal("expected the hook to time out")
+	}
+	if time.Since(start) > 3*time.Second {
+		t.Fatal("the hook was not killed on its timeout")
+	}
+}
+
+func TestPhaseBlocking(t *testing.T) {
+	for p, blocking := range map[Phase]bool{
+		PhaseCreate: true, PhasePreJoin: true, PhasePostJoin: false, PhaseLeave: false, PhaseDelete: false,
+	} {
+		if p.Blocking() != blocking {
+			t.Fatalf("unexpected blocking %v of %s", p.Blocking(), p)
+		}
+	}
+}
@@ -135,6 +135,21 @@ func (aee *ActiveEndpointsError) Error() string {
 // Forbidden denotes the type of this error
 func (aee *ActiveEndpointsError) Forbidden() {}
 
+// QuotaExceededError is returned when creating a resource would exceed a
+// limit set in the daemon configuration.
+type QuotaExceededError struct {
+	Resource string
+	Scope    string
+	Limit    int
+}
+
+func (qe *QuotaExceededError) Error() string {
+	return fmt.Sprintf("maximum number of %s (%d) reached for %s", qe.Resource, qe.Limit, qe.Scope)
+}
+
+// Forbidden denotes the type of this error
+func (qe *QuotaExceededError) Forbidden() {}
+
 // UnknownEndpointError is returned when libnetwork could not find in its database
 // an endpoint with the same name and id.
 type UnknownEndpointError struct {
@@ -0,0 +1,203 @@
+package libnetwork
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/docker/go-events"
+	"github.com/docker/libnetwork/diagnostic"
+	"github.com/docker/libnetwork/internal/caller"
+	"github.com/sirupsen/logrus"
+)
+
+// EventType identifies the kind of a network lifecycle event
+type EventType string
+
+const (
+	// EventNetworkCreate is published when a network is created
+	EventNetworkCreate EventType = "network-create"
+	// EventNetworkDelete is published when a network is deleted
+	EventNetworkDelete EventType = "network-delete"
+	// EventEndpointJoin is published when an endpoint joins a sandbox
+	EventEndpointJoin EventType = "endpoint-join"
+	// EventEndpointLeave is published when an endpoint leaves a sandbox
+	EventEndpointLeave EventType = "endpoint-leave"
+	// EventServiceAdd is published when a service backend is added
+	EventServiceAdd EventType = "service-add"
+	// EventServiceRemove is published when a service backend is removed
+	EventServiceRemove EventType = "service-remove"
+	// EventFilterUpdate is published when the filter rules are programmed
+	EventFilterUpdate EventType = "filter-update"
+	// EventNextHopDown is published when a probed next hop stops answering
+	EventNextHopDown EventType = "nexthop-down"
+	// EventNextHopUp is published when a next hop which was down answers again
+	EventNextHopUp EventType = "nexthop-up"
+	// EventNetworkDegraded is published when a next hop of a network goes down
+	EventNetworkDegraded EventType = "network-degraded"
+	// EventNetworkRecovered is published when the next hops of a degraded
+	// network are all up again
+	EventNetworkRecovered EventType = "network-recovered"
+	// EventSocketDenied is published when the socket policy of an endpoint
+	// rejected connect() calls to a destination
+	EventSocketDenied EventType = "socket-denied"
+	// EventPartitionDetected is published when a partition of the global
+	// datastore or of the gossip cluster is suspected
+	EventPartitionDetected EventType = "partition-detected"
+	// EventPartitionHealed is published when the signals of a partition
+	// are clear again, once the held updates are applied
+	EventPartitionHealed EventType = "partition-healed"
+	// EventQuotaExceeded is published when the traffic of an endpoint in
+	// the month exceeds its quota, with the action taken on it
+	EventQuotaExceeded EventType = "quota-exceeded"
+	// EventQuotaReset is published when the action taken on an endpoint
+	// exceeding its quota is lifted at the start of the month
+	EventQuotaReset EventType = "quota-reset"
+	// EventUplinkFailover is published when the traffic of a bonding or
+	// teaming uplink moved to other ports, with the active ports
+	EventUplinkFailover EventType = "uplink-failover"
+)
+
+// Event is a network lifecycle event sent to the controller watchers
+type Event struct {
+	Type         EventType `json:"type"`
+	Time         time.Time `json:"time"`
+	NetworkID    string    `json:"network_id,omitempty"`
+	NetworkName  string    `json:"network_name,omitempty"`
+	EndpointID   string    `json:"endpoint_id,omitempty"`
+	EndpointName string    `json:"endpoint_name,omitempty"`
+	SandboxID    string    `json:"sandbox_id,omitempty"`
+	ContainerID  string    `json:"container_id,omitempty"`
+	ServiceName  string    `json:"service_name,omitempty"`
+	ServiceID    string    `json:"service_id,omitempty"`
+	NextHop      string    `json:"next_hop,omitempty"`
+	Destination  string    `json:"destination,omitempty"`
+	Uplink       string    `json:"uplink,omitempty"`
+	Count        uint64    `json:"count,omitempty"`
+	Reason       string    `json:"reason,omitempty"`
+}
+
+// eventsPaths2Func are the diagnostic handlers of the lifecycle events
+var eventsPaths2Func = map[string]diagnostic.HTTPHandlerFunc{
+	"/events": eventsDiag,
+}
+
+// Watch returns a channel where the lifecycle events of the passed types,
+// or of every type if none is passed, are sent as Event values, and a
+// function to call to stop watching.
+func (c *controller) Watch(types ...EventType) (*events.Channel, func()) {
+	ch := events.NewChannel(0)
+	sink := events.Sink(events.NewQueue(ch))
+
+	if len(types) > 0 {
+		sink = events.NewFilter(sink, events.MatcherFunc(func(ev events.Event) bool {
+			e, ok := ev.(Event)
+			if !ok {
+				return false
+			}
+			for _, t := range types {
+				if e.Type == t {
+					return true
+				}
+			}
+			return false
+		}))
+	}
+
+	c.eventBroadcaster.Add(sink)
+	return ch, func() {
+		c.eventBroadcaster.Remove(sink)
+		ch.Close()
+		sink.Close()
+	}
+}
+
+func (c *controller) publish(ev Event) {
+	ev.Time = time.Now()
+	if err := c.eventBroadcaster.Write(ev); err != nil {
+		logrus.Debugf("Failed to publish %s event: %v", ev.Type, err)
+	}
+}
+
+func (c *controller) publishEndpointEvent(t EventType, n *network, ep *endpoint, sb *sandbox) {
+	c.publish(Event{
+		Type:         t,
+		NetworkID:    n.ID(),
+		NetworkName:  n.Name(),
+		EndpointID:   ep.ID(),
+		EndpointName: ep.Name(),
+		SandboxID:    sb.ID(),
+		ContainerID:  sb.ContainerID(),
+	})
+}
+
+// eventsDiag streams the lifecycle events as server-sent events until the
+// client goes away. The "type" form value filters the event types.
+func eventsDiag(ctx interface{}, w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	diagnostic.DebugHTTPForm(r)
+	_, json := diagnostic.ParseHTTPFormOptions(r)
+
+	// audit logs
+	log := logrus.WithFields(logrus.Fields{"component": "diagnostic", "remoteIP": r.RemoteAddr, "method": caller.Name(0), "url": r.URL.String()})
+	log.Info("watch events")
+
+	c, ok := ctx.(*controller)
+	if !ok {
+		diagnostic.HTTPReply(w, diagnostic.FailCommand(fmt.Errorf("controller not available")), json)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		diagnostic.HTTPReply(w, diagnostic.FailCommand(fmt.Errorf("streaming not supported")), json)
+		return
+	}
+
+	var types []EventType
+	for _, v := range r.Form["type"] {
+		for _, t := range strings.Split(v, ",") {
+			if t != "" {
+				types = append(types, EventType(t))
+			}
+		}
+	}
+
+	ch, cancel := c.Watch(types...)
+	defer cancel()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			log.Info("watch events done")
+			return
+		case <-ch.Done():
+			return
+		case ev := <-ch.C:
+			e, ok := ev.(Event)
+			if !ok {
+				continue
+			}
+			if err := writeSSE(w, e); err != nil {
+				log.WithError(err).Info("watch events stopped")
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+func writeSSE(w http.ResponseWriter, e Event) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type, b)
+	return err
+}
@@ -0,0 +1,367 @@
+package libnetwork
+
+import (
+	"encoding/json"
+	"net"
+	"regexp"
+	"sort"
+	"sync"
+
+	"github.com/docker/libnetwork/datastore"
+	"github.com/docker/libnetwork/driverapi"
+	"github.com/docker/libnetwork/netutils"
+	"github.com/docker/libnetwork/types"
+	"github.com/sirupsen/logrus"
+)
+
+const externalEndpointKeyPrefix = "external_endpoint"
+
+var externalEndpointNameRe = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9_.-]{0,62}[A-Za-z0-9])?$`)
+
+// ExternalEndpoint is a peer of the endpoints of a network which is not a
+// container, e.g. a database VM. Its name resolves to its addresses on the
+// network like the names of the containers, and the filter policies of the
+// network refer to it by name through the Peer of their rules. The
+// external endpoints of a network resolve on the hosts which registered
+// them only.
+type ExternalEndpoint struct {
+	Name        string            `json:"name"`
+	NetworkID   string            `json:"network_id"`
+	Address     net.IP            `json:"address,omitempty"`
+	AddressIPv6 net.IP            `json:"address_ipv6,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+}
+
+// validate checks the name and the addresses of the external endpoint. On
+// an IPv6-only network it has an IPv6 address only, elsewhere an IPv4 one
+// along with the optional IPv6 one.
+func (ee *ExternalEndpoint) validate(ipv6Only bool) error {
+	if !externalEndpointNameRe.MatchString(ee.Name) {
+		return ErrInvalidName(ee.Name)
+	}
+	if ipv6Only {
+		if ee.Address != nil {
+			return types.BadRequestErrorf("invalid address %v of external endpoint %s: the network is IPv6 only", ee.Address, ee.Name)
+		}
+		if ee.AddressIPv6 == nil {
+			return types.BadRequestErrorf("invalid external endpoint %s: an IPv6 unicast address is expected on the IPv6-only network", ee.Name)
+		}
+	} else if ee.Address == nil || ee.Address.To4() == nil || ee.Address.IsUnspecified() {
+		return types.BadRequestErrorf("invalid address %v of external endpoint %s: an IPv4 unicast address is expected", ee.Address, ee.Name)
+	}
+	if ee.AddressIPv6 != nil && (ee.AddressIPv6.To4() != nil || ee.AddressIPv6.IsUnspecified()) {
+		return types.BadRequestErrorf("invalid IPv6 address %v of external endpoint %s", ee.AddressIPv6, ee.Name)
+	}
+	return nil
+}
+
+// address returns the IPv4 address of the external endpoint, or its IPv6
+// one on an IPv6-only network
+func (ee *ExternalEndpoint) address() net.IP {
+	if ee.Address == nil {
+		return ee.AddressIPv6
+	}
+	return ee.Address
+}
+
+// externalEndpointRecord is the record of an external endpoint in the
+// store of its network
+type externalEndpointRecord struct {
+	ExternalEndpoint
+	scope    string
+	dbIndex  uint64
+	dbExists bool
+	sync.Mutex
+}
+
+func (r *externalEndpointRecord) Key() []string {
+	return []string{externalEndpointKeyPrefix, r.NetworkID, r.Name}
+}
+
+func (r *externalEndpointRecord) KeyPrefix() []string {
+	return []string{externalEndpointKeyPrefix, r.NetworkID}
+}
+
+func (r *externalEndpointRecord) Value() []byte {
+	r.Lock()
+	defer r.Unlock()
+
+	b, err := json.Marshal(r.ExternalEndpoint)
+	if err != nil {
+		return nil
+	}
+	return b
+}
+
+func (r *externalEndpointRecord) SetValue(value []byte) error {
+	r.Lock()
+	defer r.Unlock()
+
+	return json.Unmarshal(value, &r.ExternalEndpoint)
+}
+
+func (r *externalEndpointRecord) Index() uint64 {
+	r.Lock()
+	defer r.Unlock()
+	return r.dbIndex
+}
+
+func (r *externalEndpointRecord) SetIndex(index uint64) {
+	r.Lock()
+	r.dbIndex = index
+	r.dbExists = true
+	r.Unlock()
+}
+
+func (r *externalEndpointRecord) Exists() bool {
+	r.Lock()
+	defer r.Unlock()
+	return r.dbExists
+}
+
+func (r *externalEndpointRecord) Skip() bool {
+	return false
+}
+
+func (r *externalEndpointRecord) New() datastore.KVObject {
+	return &externalEndpointRecord{scope: r.scope}
+}
+
+func (r *externalEndpointRecord) CopyTo(o datastore.KVObject) error {
+	r.Lock()
+	defer r.Unlock()
+
+	dst := o.(*externalEndpointRecord)
+	dst.ExternalEndpoint = r.ExternalEndpoint
+	dst.scope = r.scope
+	dst.dbIndex = r.dbIndex
+	dst.dbExists = r.dbExists
+
+	return nil
+}
+
+func (r *externalEndpointRecord) DataScope() string {
+	return r.scope
+}
+
+// AddExternalEndpoint registers the external endpoint on its network. Its
+// name may be taken neither by an endpoint of the network nor by another
+// external endpoint.
+func (c *controller) AddExternalEndpoint(ee *ExternalEndpoint) error {
+	nw, err := c.NetworkByID(ee.NetworkID)
+	if err != nil {
+		return err
+	}
+	n := nw.(*network)
+	if err := ee.validate(n.IPv6Only()); err != nil {
+		return err
+	}
+	if n.ConfigOnly() {
+		return types.ForbiddenErrorf("external endpoints cannot be added to the configuration network %s", n.Name())
+	}
+
+	c.networkLocker.Lock(n.id)
+	defer c.networkLocker.Unlock(n.id)
+
+	if _, err := n.EndpointByName(ee.Name); err == nil {
+		return types.ForbiddenErrorf("an endpoint named %s exists on network %s", ee.Name, n.Name())
+	}
+	c.Lock()
+	_, exists := c.externalEndpoints[n.id][ee.Name]
+	c.Unlock()
+	if exists {
+		return types.ForbiddenErrorf("an external endpoint named %s exists on network %s", ee.Name, n.Name())
+	}
+
+	r := &externalEndpointRecord{ExternalEndpoint: *ee, scope: n.DataScope()}
+	r.NetworkID = n.id
+	if err := c.updateToStore(r); err != nil {
+		return err
+	}
+	c.addExternalEndpoint(&r.ExternalEndpoint)
+	logrus.Infof("Added external endpoint %s (%s) to network %s", ee.Name, ee.address(), n.Name())
+	return nil
+}
+
+// RemoveExternalEndpoint unregisters the external endpoint of the network.
+// The filter policies referring to it keep its address until they are
+// applied again.
+func (c *controller) RemoveExternalEndpoint(networkID, name string) error {
+	nw, err := c.NetworkByID(networkID)
+	if err != nil {
+		return err
+	}
+	n := nw.(*network)
+
+	c.networkLocker.Lock(n.id)
+	defer c.networkLocker.Unlock(n.id)
+
+	c.Lock()
+	ee, ok := c.externalEndpoints[n.id][name]
+	c.Unlock()
+	if !ok {
+		return types.NotFoundErrorf("external endpoint %s not found on network %s", name, n.Name())
+	}
+	r := &externalEndpointRecord{ExternalEndpoint: *ee, scope: n.DataScope()}
+	if store := c.getStore(n.DataScope()); store != nil {
+		if err := store.GetObject(datastore.Key(r.Key()...), r); err != nil && err != datastore.ErrKeyNotFound {
+			return err
+		}
+		if r.Exists() {
+			if err := c.deleteFromStore(r); err != nil {
+				return err
+			}
+		}
+	}
+
+	c.Lock()
+	delete(c.externalEndpoints[n.id], name)
+	if len(c.externalEndpoints[n.id]) == 0 {
+		delete(c.externalEndpoints, n.id)
+	}
+	c.Unlock()
+	logrus.Infof("Removed external endpoint %s of network %s", name, n.Name())
+	return nil
+}
+
+// ExternalEndpoints returns the external endpoints of the network, sorted
+// by name
+func (c *controller) ExternalEndpoints(networkID string) ([]ExternalEndpoint, error) {
+	if _, err := c.NetworkByID(networkID); err != nil {
+		return nil, err
+	}
+	c.Lock()
+	list := make([]ExternalEndpoint, 0, len(c.externalEndpoints[networkID]))
+	for _, ee := range c.externalEndpoints[networkID] {
+		list = append(list, *ee)
+	}
+	c.Unlock()
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list, nil
+}
+
+func (c *controller) addExternalEndpoint(ee *ExternalEndpoint) {
+	c.Lock()
+	defer c.Unlock()
+	if c.externalEndpoints == nil {
+		c.externalEndpoints = map[string]map[string]*ExternalEndpoint{}
+	}
+	if c.externalEndpoints[ee.NetworkID] == nil {
+		c.externalEndpoints[ee.NetworkID] = map[string]*ExternalEndpoint{}
+	}
+	c.externalEndpoints[ee.NetworkID][ee.Name] = ee
+}
+
+// restoreExternalEndpoints loads the external endpoints of the known
+// networks back from the stores
+func (c *controller) restoreExternalEndpoints() {
+	for _, store := range c.getStores() {
+		kvol, err := store.List(datastore.Key(externalEndpointKeyPrefix), &externalEndpointRecord{scope: store.Scope()})
+		if err != nil {
+			if err != datastore.ErrKeyNotFound {
+				logrus.Warnf("Failed to load the external endpoints of scope %s: %v", store.Scope(), err)
+			}
+			continue
+		}
+		for _, kvo := range kvol {
+			r := kvo.(*externalEndpointRecord)
+			if _, err := c.NetworkByID(r.NetworkID); err != nil {
+				continue
+			}
+			ee := r.ExternalEndpoint
+			c.addExternalEndpoint(&ee)
+		}
+	}
+}
+
+// forgetExternalEndpoints removes the external endpoints of the deleted
+// network
+func (c *controller) forgetExternalEndpoints(n *network) {
+	c.Lock()
+	eps := c.externalEndpoints[n.id]
+	delete(c.externalEndpoints, n.id)
+	c.Unlock()
+	for _, ee := range eps {
+		r := &externalEndpointRecord{ExternalEndpoint: *ee, scope: n.DataScope()}
+		store := c.getStore(r.scope)
+		if store == nil {
+			return
+		}
+		if err := store.GetObject(datastore.Key(r.Key()...), r); err != nil {
+			continue
+		}
+		if err := c.deleteFromStore(r); err != nil {
+			logrus.Warnf("Failed to remove external endpoint %s of network %s from the store: %v", ee.Name, n.Name(), err)
+		}
+	}
+}
+
+// resolveExternalName returns the addresses of the external endpoint of
+// the network of the name, and whether there is one. It is called with the
+// controller locked.
+func (c *controller) resolveExternalName(nid, name string, ipType int) ([]net.IP, bool) {
+	ee, ok := c.externalEndpoints[nid][name]
+	if !ok {
+		return nil, false
+	}
+	if ipType == types.IPv6 {
+		if ee.AddressIPv6 == nil {
+			return nil, true
+		}
+		return []net.IP{ee.AddressIPv6}, true
+	}
+	if ee.Address == nil {
+		return nil, true
+	}
+	return []net.IP{ee.Address}, true
+}
+
+// resolveExternalIP returns the name of the external endpoint of the
+// network of the reversed address, if any. It is called with the
+// controller locked.
+func (c *controller) resolveExternalIP(nid, reversed string) string {
+	for _, ee := range c.externalEndpoints[nid] {
+		if (ee.Address != nil && netutils.ReverseIP(ee.Address.String()) == reversed) ||
+			(ee.AddressIPv6 != nil && netutils.ReverseIP(ee.AddressIPv6.String()) == reversed) {
+			return ee.Name
+		}
+	}
+	return ""
+}
+
+// resolveFilterPeers returns the filter policy with the peers of its rules
+// resolved to the addresses of the external endpoints of the network
+func (c *controller) resolveFilterPeers(n *network, p *driverapi.FilterPolicy) (*driverapi.FilterPolicy, error) {
+	if p == nil {
+		return nil, nil
+	}
+	var clone *driverapi.FilterPolicy
+	for i, r := range p.Allow {
+		if r.Peer == "" {
+			continue
+		}
+		if r.From != nil || r.Source != "" {
+			return nil, types.BadRequestErrorf("invalid rule of filter policy %s: a peer along with a source", p.Version)
+		}
+		c.Lock()
+		ee, ok := c.externalEndpoints[n.id][r.Peer]
+		c.Unlock()
+		if !ok {
+			return nil, types.BadRequestErrorf("unknown peer %s of filter policy %s: no such external endpoint on network %s", r.Peer, p.Version, n.Name())
+		}
+		if clone == nil {
+			cp := *p
+			cp.Allow = append([]driverapi.FilterRule(nil), p.Allow...)
+			clone = &cp
+		}
+		if ee.Address == nil {
+			clone.Allow[i].From = &net.IPNet{IP: ee.AddressIPv6, Mask: net.CIDRMask(128, 128)}
+		} else {
+			clone.Allow[i].From = &net.IPNet{IP: ee.Address.To4(), Mask: net.CIDRMask(32, 32)}
+		}
+	}
+	if clone == nil {
+		return p, nil
+	}
+	return clone, nil
+}
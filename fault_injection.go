@@ -0,0 +1,68 @@
+package libnetwork
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/docker/libnetwork/diagnostic"
+	"github.com/docker/libnetwork/internal/caller"
+	"github.com/docker/libnetwork/internal/faults"
+	"github.com/sirupsen/logrus"
+)
+
+// faultsPaths2Func are the diagnostic handlers of the fault injection,
+// registered only when the configuration enables it
+var faultsPaths2Func = map[string]diagnostic.HTTPHandlerFunc{
+	"/faults": faultsDiag,
+}
+
+type faultsResult struct {
+	Faults map[faults.Point]faults.Fault `json:"faults"`
+}
+
+func (r *faultsResult) String() string {
+	var lines []string
+	for p, f := range r.Faults {
+		lines = append(lines, fmt.Sprintf("%s: %s\n", p, f))
+	}
+	sort.Strings(lines)
+	return strings.Join(lines, "")
+}
+
+// faultsDiag sets the faults of a point, e.g. with
+// /faults?point=iptables&faults=fail:10%,delay:50ms, the empty faults
+// clearing them, and lists the faults injected
+func faultsDiag(ctx interface{}, w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	diagnostic.DebugHTTPForm(r)
+	_, json := diagnostic.ParseHTTPFormOptions(r)
+
+	// audit logs
+	log := logrus.WithFields(logrus.Fields{"component": "diagnostic", "remoteIP": r.RemoteAddr, "method": caller.Name(0), "url": r.URL.String()})
+	log.Info("faults")
+
+	if point := r.Form.Get("point"); point != "" {
+		if err := setFaults(faults.Point(point), r.Form.Get("faults")); err != nil {
+			log.WithError(err).Error("failed to set the faults")
+			diagnostic.HTTPReply(w, diagnostic.FailCommand(err), json)
+			return
+		}
+		log.Warnf("Injecting %s faults %q", point, r.Form.Get("faults"))
+	}
+
+	diagnostic.HTTPReply(w, diagnostic.CommandSucceed(&faultsResult{Faults: faults.Faults()}), json)
+}
+
+func setFaults(p faults.Point, spec string) error {
+	var f faults.Fault
+	if spec != "" {
+		parsed, err := faults.Parse(string(p) + "=" + spec)
+		if err != nil {
+			return err
+		}
+		f = parsed[p]
+	}
+	return faults.Set(p, f)
+}
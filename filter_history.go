@@ -0,0 +1,332 @@
+package libnetwork
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/libnetwork/datastore"
+	"github.com/docker/libnetwork/driverapi"
+	"github.com/docker/libnetwork/types"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	filterHistoryKeyPrefix = "filter_policy"
+	// filterHistoryLimit is the number of versions kept per endpoint
+	filterHistoryLimit = 16
+)
+
+// FilterPolicyVersion is a filter policy applied on an endpoint, the
+// policy being nil when the filter of the endpoint got lifted
+type FilterPolicyVersion struct {
+	Policy  *driverapi.FilterPolicy `json:"policy,omitempty"`
+	Applied time.Time               `json:"applied"`
+}
+
+// FilterPolicyDiff lists the rules a filter policy version allows over
+// another and the ones it no longer allows
+type FilterPolicyDiff struct {
+	From    string
+	To      string
+	Added   []driverapi.FilterRule
+	Removed []driverapi.FilterRule
+}
+
+// filterPolicyRecord is the history of the filter policies applied on an
+// endpoint, oldest first, and whether they are applied for audit only
+type filterPolicyRecord struct {
+	NetworkID  string                `json:"network_id"`
+	EndpointID string                `json:"endpoint_id"`
+	History    []FilterPolicyVersion `json:"history"`
+	Shadow     bool                  `json:"shadow,omitempty"`
+	scope      string
+	dbIndex    uint64
+	dbExists   bool
+	sync.Mutex
+}
+
+func (r *filterPolicyRecord) Key() []string {
+	return []string{filterHistoryKeyPrefix, r.NetworkID, r.EndpointID}
+}
+
+func (r *filterPolicyRecord) KeyPrefix() []string {
+	return []string{filterHistoryKeyPrefix, r.NetworkID}
+}
+
+func (r *filterPolicyRecord) Value() []byte {
+	r.Lock()
+	defer r.Unlock()
+
+	b, err := json.Marshal(r)
+	if err != nil {
+		return nil
+	}
+	return b
+}
+
+func (r *filterPolicyRecord) SetValue(value []byte) error {
+	r.Lock()
+	defer r.Unlock()
+
+	return json.Unmarshal(value, r)
+}
+
+func (r *filterPolicyRecord) Index() uint64 {
+	r.Lock()
+	defer r.Unlock()
+	return r.dbIndex
+}
+
+func (r *filterPolicyRecord) SetIndex(index uint64) {
+	r.Lock()
+	r.dbIndex = index
+	r.dbExists = true
+	r.Unlock()
+}
+
+func (r *filterPolicyRecord) Exists() bool {
+	r.Lock()
+	defer r.Unlock()
+	return r.dbExists
+}
+
+func (r *filterPolicyRecord) Skip() bool {
+	return false
+}
+
+func (r *filterPolicyRecord) New() datastore.KVObject {
+	return &filterPolicyRecord{NetworkID: r.NetworkID, scope: r.scope}
+}
+
+func (r *filterPolicyRecord) CopyTo(o datastore.KVObject) error {
+	r.Lock()
+	defer r.Unlock()
+
+	dst := o.(*filterPolicyRecord)
+	dst.NetworkID = r.NetworkID
+	dst.EndpointID = r.EndpointID
+	dst.History = append([]FilterPolicyVersion(nil), r.History...)
+	dst.Shadow = r.Shadow
+	dst.scope = r.scope
+	dst.dbIndex = r.dbIndex
+	dst.dbExists = r.dbExists
+
+	return nil
+}
+
+func (r *filterPolicyRecord) DataScope() string {
+	return r.scope
+}
+
+// filterEndpoint applies the filter policy on the endpoint through the
+// driver and records it in the history of the endpoint. The policy is
+// applied for audit while the endpoint is in shadow mode, the history
+// keeping it as given.
+func (c *controller) filterEndpoint(n *network, f driverapi.EndpointFilterer, eid string, p *driverapi.FilterPolicy) error {
+	resolved, err := c.resolveFilterPeers(n, p)
+	if err != nil {
+		return err
+	}
+	if resolved != nil && !resolved.Audit && c.endpointShadowed(n, eid) {
+		audit := *resolved
+		audit.Audit = true
+		resolved = &audit
+	}
+	if err := f.FilterEndpoint(n.ID(), eid, resolved); err != nil {
+		return err
+	}
+	if err := c.recordFilterPolicy(n, eid, p); err != nil {
+		logrus.Warnf("Failed to record the filter policy of endpoint %.7s: %v", eid, err)
+	}
+	return nil
+}
+
+// recordFilterPolicy appends the filter policy to the history of the
+// endpoint, unless it is its last version already
+func (c *controller) recordFilterPolicy(n *network, eid string, p *driverapi.FilterPolicy) error {
+	store := c.getStore(n.DataScope())
+	if store == nil {
+		return nil
+	}
+	for {
+		r := &filterPolicyRecord{NetworkID: n.ID(), EndpointID: eid, scope: n.DataScope()}
+		if err := store.GetObject(datastore.Key(r.Key()...), r); err != nil && err != datastore.ErrKeyNotFound {
+			return err
+		}
+		if l := len(r.History); l > 0 && filterVersion(r.History[l-1].Policy) == filterVersion(p) {
+			return nil
+		}
+		r.History = append(r.History, FilterPolicyVersion{Policy: p, Applied: time.Now()})
+		if l := len(r.History); l > filterHistoryLimit {
+			r.History = r.History[l-filterHistoryLimit:]
+		}
+		if err := c.updateToStore(r); err != datastore.ErrKeyModified {
+			return err
+		}
+	}
+}
+
+func (c *controller) filterHistory(n *network, eid string) ([]FilterPolicyVersion, error) {
+	store := c.getStore(n.DataScope())
+	if store == nil {
+		return nil, ErrDataStoreNotInitialized(n.DataScope())
+	}
+	r := &filterPolicyRecord{NetworkID: n.ID(), EndpointID: eid, scope: n.DataScope()}
+	if err := store.GetObject(datastore.Key(r.Key()...), r); err != nil {
+		if err == datastore.ErrKeyNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return r.History, nil
+}
+
+// forgetFilterHistory drops the history of the filter policies of the
+// deleted endpoint
+func (c *controller) forgetFilterHistory(n *network, eid string) {
+	store := c.getStore(n.DataScope())
+	if store == nil {
+		return
+	}
+	r := &filterPolicyRecord{NetworkID: n.ID(), EndpointID: eid, scope: n.DataScope()}
+	if err := store.GetObject(datastore.Key(r.Key()...), r); err != nil {
+		return
+	}
+	if err := c.deleteFromStore(r); err != nil {
+		logrus.Warnf("Failed to delete the filter policy history of endpoint %.7s: %v", eid, err)
+	}
+}
+
+// FilterPolicyHistory returns the filter policies applied on the endpoint,
+// oldest first
+func (c *controller) FilterPolicyHistory(networkID, endpointID string) ([]FilterPolicyVersion, error) {
+	nw, err := c.NetworkByID(networkID)
+	if err != nil {
+		return nil, err
+	}
+	n := nw.(*network)
+	if _, err := n.EndpointByID(endpointID); err != nil {
+		return nil, err
+	}
+	return c.filterHistory(n, endpointID)
+}
+
+// DiffFilterPolicy compares two versions of the filter policy applied on
+// the endpoint
+func (c *controller) DiffFilterPolicy(networkID, endpointID, from, to string) (*FilterPolicyDiff, error) {
+	history, err := c.FilterPolicyHistory(networkID, endpointID)
+	if err != nil {
+		return nil, err
+	}
+	pf, pt := findFilterVersion(history, from), findFilterVersion(history, to)
+	if pf == nil {
+		return nil, types.NotFoundErrorf("filter policy %s was not applied on endpoint %.7s", from, endpointID)
+	}
+	if pt == nil {
+		return nil, types.NotFoundErrorf("filter policy %s was not applied on endpoint %.7s", to, endpointID)
+	}
+	return diffFilterPolicies(pf, pt), nil
+}
+
+// RevertFilterPolicy applies back a prior version of the filter policy,
+// found in the history of the endpoints of the network, on all of them and
+// on the ones created after. The rollout staged on the network, if any, is
+// dropped.
+func (c *controller) RevertFilterPolicy(networkID, version string) error {
+	n, f, err := c.endpointFilterer(networkID)
+	if err != nil {
+		return err
+	}
+	c.filterMu.Lock()
+	defer c.filterMu.Unlock()
+
+	var policy *driverapi.FilterPolicy
+	eps := n.Endpoints()
+	for _, ep := range eps {
+		history, err := c.filterHistory(n, ep.ID())
+		if err != nil {
+			return err
+		}
+		if policy = findFilterVersion(history, version); policy != nil {
+			break
+		}
+	}
+	if policy == nil {
+		return types.NotFoundErrorf("filter policy %s was not applied on the endpoints of network %s", version, n.Name())
+	}
+
+	var failed []string
+	for _, ep := range eps {
+		if err := c.filterEndpoint(n, f, ep.ID(), policy); err != nil {
+			logrus.Warnf("Failed to revert endpoint %.7s to filter policy %s: %v", ep.ID(), version, err)
+			failed = append(failed, ep.ID())
+		}
+	}
+
+	ro := c.filterRollouts[n.ID()]
+	if ro == nil {
+		ro = &filterRollout{}
+		if c.filterRollouts == nil {
+			c.filterRollouts = map[string]*filterRollout{}
+		}
+		c.filterRollouts[n.ID()] = ro
+	}
+	ro.stopTimer()
+	ro.current, ro.staged, ro.canaries = policy, nil, nil
+
+	logrus.Infof("Reverted network %s to filter policy %s", n.Name(), version)
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to revert endpoints %s to filter policy %s", strings.Join(failed, ", "), version)
+	}
+	return nil
+}
+
+func filterVersion(p *driverapi.FilterPolicy) string {
+	if p == nil {
+		return ""
+	}
+	return p.Version
+}
+
+// findFilterVersion returns the latest policy of the version in the history
+func findFilterVersion(history []FilterPolicyVersion, version string) *driverapi.FilterPolicy {
+	for i := len(history) - 1; i >= 0; i-- {
+		if p := history[i].Policy; p != nil && p.Version == version {
+			return p
+		}
+	}
+	return nil
+}
+
+func diffFilterPolicies(from, to *driverapi.FilterPolicy) *FilterPolicyDiff {
+	d := &FilterPolicyDiff{From: from.Version, To: to.Version}
+	key := func(r driverapi.FilterRule) string {
+		src := ""
+		if r.From != nil {
+			src = r.From.String()
+		}
+		return r.Proto + "/" + r.Ports + "/" + src + "/" + r.Source + "/" + r.Peer + "/" + r.Family + "/" + r.TimeStart + "-" + r.TimeStop
+	}
+	in := func(rules []driverapi.FilterRule, r driverapi.FilterRule) bool {
+		for _, o := range rules {
+			if key(o) == key(r) {
+				return true
+			}
+		}
+		return false
+	}
+	for _, r := range to.Allow {
+		if !in(from.Allow, r) {
+			d.Added = append(d.Added, r)
+		}
+	}
+	for _, r := range from.Allow {
+		if !in(to.Allow, r) {
+			d.Removed = append(d.Removed, r)
+		}
+	}
+	return d
+}
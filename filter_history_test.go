@@ -0,0 +1,253 @@
+package libnetwork
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/docker/libnetwork/datastore"
+	"github.com/docker/libnetwork/driverapi"
+	"github.com/docker/libnetwork/types"
+)
+
+var filterDriverName = "filter network driver"
+
+// filterDriver creates the endpoints and records the filter policies
+// applied on them, failing on the endpoints set in fail
+type filterDriver struct {
+	deletableDriver
+	sync.Mutex
+	policies map[string]*driverapi.FilterPolicy
+	counters map[string]*driverapi.FilterCounters
+	fail     map[string]bool
+}
+
+func (d *filterDriver) Type() string {
+	return filterDriverName
+}
+
+func (d *filterDriver) CreateEndpoint(nid, eid string, ifInfo driverapi.InterfaceInfo, options map[string]interface{}) error {
+	return nil
+}
+
+func (d *filterDriver) FilterEndpoint(nid, eid string, policy *driverapi.FilterPolicy) error {
+	d.Lock()
+	defer d.Unlock()
+	if d.fail[eid] {
+		return fmt.Errorf("I will not filter endpoint %s", eid)
+	}
+	d.policies[eid] = policy
+	return nil
+}
+
+func (d *filterDriver) FilterCounters(nid, eid string) (*driverapi.FilterCounters, error) {
+	d.Lock()
+	defer d.Unlock()
+	return d.counters[eid], nil
+}
+
+// version returns the version of the filter policy applied on the endpoint
+func (d *filterDriver) version(eid string) string {
+	d.Lock()
+	defer d.Unlock()
+	return filterVersion(d.policies[eid])
+}
+
+// newFilterNetwork returns a network of the filterDriver with the endpoints
+func newFilterNetwork(t *testing.T, names ...string) (*controller, *filterDriver, Network, []Endpoint) {
+	cfgOptions, err := OptionBoltdbWithRandomDBFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := New(cfgOptions...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fd := &filterDriver{policies: map[string]*driverapi.FilterPolicy{}, counters: map[string]*driverapi.FilterCounters{}, fail: map[string]bool{}}
+	err = c.(*controller).drvRegistry.AddDriver(filterDriverName, func(reg driverapi.DriverCallback, opt map[string]interface{}) error {
+		return reg.RegisterDriver(filterDriverName, fd, driverapi.Capability{DataScope: datastore.LocalScope})
+	}, nil)
+	if err != nil {
+		c.Stop()
+		t.Fatal(err)
+	}
+	n, err := c.NewNetwork(filterDriverName, "filtered", "")
+	if err != nil {
+		c.Stop()
+		t.Fatal(err)
+	}
+	var eps []Endpoint
+	for _, name := range names {
+		ep, err := n.CreateEndpoint(name)
+		if err != nil {
+			c.Stop()
+			t.Fatal(err)
+		}
+		eps = append(eps, ep)
+	}
+	return c.(*controller), fd, n, eps
+}
+
+func TestFilterPolicyRevert(t *testing.T) {
+	c, fd, n, eps := newFilterNetwork(t, "ep1", "ep2")
+	defer c.Stop()
+	ep1, ep2 := eps[0], eps[1]
+
+	_, web, _ := net.ParseCIDR("10.1.0.0/24")
+	v1 := &driverapi.FilterPolicy{Version: "v1", Allow: []driverapi.FilterRule{{Proto: "tcp", Ports: "80", From: web}}}
+	v2 := &driverapi.FilterPolicy{Version: "v2", Allow: []driverapi.FilterRule{{Proto: "tcp", Ports: "443", From: web}, {Proto: "tcp", Ports: "80", From: web}}}
+	v3 := &driverapi.FilterPolicy{Version: "v3", Allow: []driverapi.FilterRule{{Proto: "udp", Ports: "53"}}}
+	for _, p := range []*driverapi.FilterPolicy{v1, v2, v3} {
+		if err := c.StageFilterPolicy(n.ID(), &FilterRollout{Policy: p, Canaries: []string{ep1.ID()}}); err != nil {
+			t.Fatal(err)
+		}
+		if err := c.PromoteFilterPolicy(n.ID()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	history, err := c.FilterPolicyHistory(n.ID(), ep1.ID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var versions []string
+	for _, v := range history {
+		versions = append(versions, filterVersion(v.Policy))
+	}
+	if strings.Join(versions, ",") != "v1,v2,v3" {
+		t.Fatalf("unexpected history %v of the canary", versions)
+	}
+
+	// The diff lists the rules allowed over the version and the ones gone
+	diff, err := c.DiffFilterPolicy(n.ID(), ep1.ID(), "v1", "v2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff.From != "v1" || diff.To != "v2" || len(diff.Added) != 1 || diff.Added[0].Ports != "443" || len(diff.Removed) != 0 {
+		t.Fatalf("unexpected diff from v1 to v2 %+v", diff)
+	}
+	if diff, err = c.DiffFilterPolicy(n.ID(), ep1.ID(), "v2", "v3"); err != nil {
+		t.Fatal(err)
+	}
+	if len(diff.Added) != 1 || diff.Added[0].Proto != "udp" || len(diff.Removed) != 2 {
+		t.Fatalf("unexpected diff from v2 to v3 %+v", diff)
+	}
+	if _, err := c.DiffFilterPolicy(n.ID(), ep1.ID(), "v1", "v9"); err == nil {
+		t.Fatal("diff to a version never applied")
+	} else if _, ok := err.(types.NotFoundError); !ok {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	// Reverting applies the older version on all the endpoints and on the
+	// new ones
+	if err := c.RevertFilterPolicy(n.ID(), "v1"); err != nil {
+		t.Fatal(err)
+	}
+	if fd.version(ep1.ID()) != "v1" || fd.version(ep2.ID()) != "v1" {
+		t.Fatalf("unexpected versions %s and %s after the revert", fd.version(ep1.ID()), fd.version(ep2.ID()))
+	}
+	ep3, err := n.CreateEndpoint("ep3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fd.version(ep3.ID()) != "v1" {
+		t.Fatalf("new endpoint got filter policy %q", fd.version(ep3.ID()))
+	}
+	if history, _ = c.FilterPolicyHistory(n.ID(), ep1.ID()); filterVersion(history[len(history)-1].Policy) != "v1" || len(history) != 4 {
+		t.Fatalf("the revert is not recorded in the history %v", history)
+	}
+	if s, err := c.FilterRolloutStatus(n.ID()); err != nil || s.Current != "v1" || s.Staged != "" {
+		t.Fatalf("unexpected status %+v after the revert: %v", s, err)
+	}
+	if err := c.RevertFilterPolicy(n.ID(), "v9"); err == nil {
+		t.Fatal("revert to a version never applied")
+	}
+
+	// A deleted endpoint takes its history along
+	if err := ep3.Delete(false); err != nil {
+		t.Fatal(err)
+	}
+	if history, err := c.filterHistory(n.(*network), ep3.ID()); err != nil || len(history) != 0 {
+		t.Fatalf("history %v of the deleted endpoint left: %v", history, err)
+	}
+}
+
+func TestFilterRolloutAbort(t *testing.T) {
+	c, fd, n, eps := newFilterNetwork(t, "ep1", "ep2")
+	defer c.Stop()
+	ep1, ep2 := eps[0], eps[1]
+
+	v1 := &driverapi.FilterPolicy{Version: "v1"}
+	if err := c.StageFilterPolicy(n.ID(), &FilterRollout{Policy: v1, Canaries: []string{ep1.ID()}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.PromoteFilterPolicy(n.ID()); err != nil {
+		t.Fatal(err)
+	}
+
+	// The canaries staged already get the current version back when the
+	// staging fails on one of them
+	fd.fail[ep2.ID()] = true
+	v2 := &driverapi.FilterPolicy{Version: "v2"}
+	if err := c.StageFilterPolicy(n.ID(), &FilterRollout{Policy: v2, Canaries: []string{ep1.ID(), ep2.ID()}}); err == nil {
+		t.Fatal("staged on a failing canary")
+	}
+	if fd.version(ep1.ID()) != "v1" {
+		t.Fatalf("canary left with filter policy %q", fd.version(ep1.ID()))
+	}
+	if s, _ := c.FilterRolloutStatus(n.ID()); s.Staged != "" {
+		t.Fatalf("failed rollout left staged %+v", s)
+	}
+	delete(fd.fail, ep2.ID())
+
+	// The canaries rejecting more than the ratio at the end of the window
+	// roll the policy back
+	if err := c.StageFilterPolicy(n.ID(), &FilterRollout{Policy: v2, Canaries: []string{ep1.ID()}, Window: time.Hour, MaxRejectRatio: 0.1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.StageFilterPolicy(n.ID(), &FilterRollout{Policy: v2, Canaries: []string{ep2.ID()}}); err == nil {
+		t.Fatal("staged twice")
+	}
+	fd.counters[ep1.ID()] = &driverapi.FilterCounters{Version: "v2", Accepted: 80, Rejected: 20}
+	c.decideFilterRollout(n.ID(), c.filterRollouts[n.ID()])
+	if fd.version(ep1.ID()) != "v1" || fd.version(ep2.ID()) != "v1" {
+		t.Fatalf("unexpected versions %s and %s after the rollback", fd.version(ep1.ID()), fd.version(ep2.ID()))
+	}
+	if s, _ := c.FilterRolloutStatus(n.ID()); s.Current != "v1" || s.Staged != "" {
+		t.Fatalf("unexpected status %+v after the rollback", s)
+	}
+
+	// Within the ratio, the policy gets promoted
+	if err := c.StageFilterPolicy(n.ID(), &FilterRollout{Policy: v2, Canaries: []string{ep1.ID()}, Window: time.Hour, MaxRejectRatio: 0.5}); err != nil {
+		t.Fatal(err)
+	}
+	c.decideFilterRollout(n.ID(), c.filterRollouts[n.ID()])
+	if fd.version(ep2.ID()) != "v2" {
+		t.Fatalf("filter policy %q after the promotion", fd.version(ep2.ID()))
+	}
+	if err := c.RollbackFilterPolicy(n.ID()); err == nil {
+		t.Fatal("rolled back without a staged policy")
+	}
+}
+
+func TestRejectRatioExceeded(t *testing.T) {
+	for _, tc := range []struct {
+		accepted, rejected uint64
+		ratio              float64
+		exceeded           bool
+	}{
+		{0, 0, 0, false},
+		{10, 0, 0, false},
+		{9, 1, 0, true},
+		{9, 1, 0.1, false},
+		{8, 2, 0.1, true},
+		{0, 5, 1, false},
+	} {
+		if rejectRatioExceeded(tc.accepted, tc.rejected, tc.ratio) != tc.exceeded {
+			t.Fatalf("%d rejected of %d over ratio %v: expected %t", tc.rejected, tc.accepted+tc.rejected, tc.ratio, tc.exceeded)
+		}
+	}
+}
@@ -0,0 +1,451 @@
+package libnetwork
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/libnetwork/diagnostic"
+	"github.com/docker/libnetwork/driverapi"
+	"github.com/docker/libnetwork/internal/caller"
+	"github.com/docker/libnetwork/types"
+	"github.com/sirupsen/logrus"
+)
+
+// filterPaths2Func are the diagnostic handlers of the rollouts of the filter
+// policies of the networks
+var filterPaths2Func = map[string]diagnostic.HTTPHandlerFunc{
+	"/filterpolicy": filterPolicyDiag,
+}
+
+// FilterRollout describes the rollout of a new version of the filter policy
+// of the endpoints of a network, staged on the canary endpoints first
+type FilterRollout struct {
+	// Policy is the new version of the filter policy
+	Policy *driverapi.FilterPolicy
+	// Canaries are the IDs of the endpoints the policy is staged on
+	Canaries []string
+	// Window is the time the canaries are observed for before the policy
+	// gets promoted or rolled back, 0 leaving the decision to
+	// PromoteFilterPolicy and RollbackFilterPolicy
+	Window time.Duration
+	// MaxRejectRatio is the ratio of the packets to the canaries the policy
+	// may reject at the end of the window and still get promoted
+	MaxRejectRatio float64
+}
+
+// FilterRolloutStatus is the state of the rollout of the filter policy of
+// a network
+type FilterRolloutStatus struct {
+	// Current is the version the endpoints of the network get
+	Current string
+	// Staged is the version on the canaries, if any
+	Staged    string
+	Started   time.Time
+	Deadline  time.Time
+	Endpoints []FilterEndpointStatus
+}
+
+// FilterEndpointStatus is the filter policy version of an endpoint with the
+// packets it let through and the ones it rejected
+type FilterEndpointStatus struct {
+	ID       string
+	Version  string
+	Canary   bool
+	Accepted uint64
+	Rejected uint64
+}
+
+type filterRollout struct {
+	current        *driverapi.FilterPolicy
+	staged         *driverapi.FilterPolicy
+	canaries       map[string]bool
+	started        time.Time
+	deadline       time.Time
+	maxRejectRatio float64
+	timer          *time.Timer
+}
+
+// StageFilterPolicy applies the new version of the filter policy of the
+// network on the canary endpoints, through the network driver, which has to
+// implement driverapi.EndpointFilterer. The other endpoints keep the
+// current version until the staged one gets promoted.
+func (c *controller) StageFilterPolicy(networkID string, r *FilterRollout) error {
+	if r == nil || r.Policy == nil || r.Policy.Version == "" {
+		return types.BadRequestErrorf("the filter rollout requires a policy version")
+	}
+	if len(r.Canaries) == 0 {
+		return types.BadRequestErrorf("the filter rollout requires canary endpoints")
+	}
+	if r.MaxRejectRatio < 0 || r.MaxRejectRatio > 1 || r.Window < 0 {
+		return types.BadRequestErrorf("invalid filter rollout window %s and reject ratio %v", r.Window, r.MaxRejectRatio)
+	}
+	n, f, err := c.endpointFilterer(networkID)
+	if err != nil {
+		return err
+	}
+
+	c.filterMu.Lock()
+	defer c.filterMu.Unlock()
+	ro := c.filterRollouts[n.ID()]
+	if ro != nil && ro.staged != nil {
+		return types.ForbiddenErrorf("filter policy %s is staged on network %s already", ro.staged.Version, n.Name())
+	}
+	if ro != nil && ro.current != nil && ro.current.Version == r.Policy.Version {
+		return types.ForbiddenErrorf("filter policy %s is the current one of network %s", r.Policy.Version, n.Name())
+	}
+	if ro == nil {
+		ro = &filterRollout{}
+	}
+
+	canaries := map[string]bool{}
+	for _, eid := range r.Canaries {
+		if _, err := n.EndpointByID(eid); err != nil {
+			return err
+		}
+		canaries[eid] = true
+	}
+	var staged []string
+	for eid := range canaries {
+		if err := c.filterEndpoint(n, f, eid, r.Policy); err != nil {
+			for _, s := range staged {
+				if err := c.filterEndpoint(n, f, s, ro.current); err != nil {
+					logrus.Warnf("Failed to restore the filter policy of canary endpoint %.7s: %v", s, err)
+				}
+			}
+			return fmt.Errorf("failed to stage filter policy %s on endpoint %.7s: %v", r.Policy.Version, eid, err)
+		}
+		staged = append(staged, eid)
+	}
+
+	ro.staged = r.Policy
+	ro.canaries = canaries
+	ro.started = time.Now()
+	ro.deadline = time.Time{}
+	ro.maxRejectRatio = r.MaxRejectRatio
+	if r.Window > 0 {
+		ro.deadline = ro.started.Add(r.Window)
+		ro.timer = time.AfterFunc(r.Window, func() { c.decideFilterRollout(n.ID(), ro) })
+	}
+	if c.filterRollouts == nil {
+		c.filterRollouts = map[string]*filterRollout{}
+	}
+	c.filterRollouts[n.ID()] = ro
+
+	logrus.Infof("Staged filter policy %s on %d canary endpoints of network %s", r.Policy.Version, len(canaries), n.Name())
+	return nil
+}
+
+// PromoteFilterPolicy applies the staged filter policy on all the endpoints
+// of the network, and on the ones created after
+func (c *controller) PromoteFilterPolicy(networkID string) error {
+	n, f, err := c.endpointFilterer(networkID)
+	if err != nil {
+		return err
+	}
+	c.filterMu.Lock()
+	defer c.filterMu.Unlock()
+	return c.promoteFilterPolicy(n, f)
+}
+
+func (c *controller) promoteFilterPolicy(n *network, f driverapi.EndpointFilterer) error {
+	ro := c.filterRollouts[n.ID()]
+	if ro == nil || ro.staged == nil {
+		return types.ForbiddenErrorf("no filter policy is staged on network %s", n.Name())
+	}
+	ro.stopTimer()
+
+	var failed []string
+	for _, ep := range n.Endpoints() {
+		if err := c.filterEndpoint(n, f, ep.ID(), ro.staged); err != nil {
+			logrus.Warnf("Failed to apply filter policy %s on endpoint %.7s: %v", ro.staged.Version, ep.ID(), err)
+			failed = append(failed, ep.ID())
+		}
+	}
+	ro.current, ro.staged, ro.canaries = ro.staged, nil, nil
+
+	logrus.Infof("Promoted filter policy %s on network %s", ro.current.Version, n.Name())
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to apply filter policy %s on endpoints %s", ro.current.Version, strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// RollbackFilterPolicy applies the current filter policy back on the
+// canaries of the staged one
+func (c *controller) RollbackFilterPolicy(networkID string) error {
+	n, f, err := c.endpointFilterer(networkID)
+	if err != nil {
+		return err
+	}
+	c.filterMu.Lock()
+	defer c.filterMu.Unlock()
+	return c.rollbackFilterPolicy(n, f)
+}
+
+func (c *controller) rollbackFilterPolicy(n *network, f driverapi.EndpointFilterer) error {
+	ro := c.filterRollouts[n.ID()]
+	if ro == nil || ro.staged == nil {
+		return types.ForbiddenErrorf("no filter policy is staged on network %s", n.Name())
+	}
+	ro.stopTimer()
+
+	var failed []string
+	for eid := range ro.canaries {
+		if err := c.filterEndpoint(n, f, eid, ro.current); err != nil {
+			if _, ok := err.(types.NotFoundError); ok {
+				continue
+			}
+			logrus.Warnf("Failed to restore the filter policy of canary endpoint %.7s: %v", eid, err)
+			failed = append(failed, eid)
+		}
+	}
+	version := ro.staged.Version
+	ro.staged, ro.canaries = nil, nil
+
+	logrus.Infof("Rolled filter policy %s back on network %s", version, n.Name())
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to roll filter policy %s back on endpoints %s", version, strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// FilterRolloutStatus returns the state of the rollout of the filter policy
+// of the network, with the counters of its endpoints
+func (c *controller) FilterRolloutStatus(networkID string) (*FilterRolloutStatus, error) {
+	n, f, err := c.endpointFilterer(networkID)
+	if err != nil {
+		return nil, err
+	}
+	c.filterMu.Lock()
+	defer c.filterMu.Unlock()
+
+	s := &FilterRolloutStatus{}
+	ro := c.filterRollouts[n.ID()]
+	if ro != nil {
+		if ro.current != nil {
+			s.Current = ro.current.Version
+		}
+		if ro.staged != nil {
+			s.Staged, s.Started, s.Deadline = ro.staged.Version, ro.started, ro.deadline
+		}
+	}
+	for _, ep := range n.Endpoints() {
+		es := FilterEndpointStatus{ID: ep.ID(), Canary: ro != nil && ro.canaries[ep.ID()]}
+		fc, err := f.FilterCounters(n.ID(), ep.ID())
+		if err != nil {
+			logrus.Debugf("Failed to read the filter counters of endpoint %.7s: %v", ep.ID(), err)
+		} else if fc != nil {
+			es.Version, es.Accepted, es.Rejected = fc.Version, fc.Accepted, fc.Rejected
+		}
+		s.Endpoints = append(s.Endpoints, es)
+	}
+	return s, nil
+}
+
+// decideFilterRollout promotes the staged filter policy at the end of its
+// window if the canaries rejected no more than the ratio of their packets
+// allowed, and rolls it back otherwise
+func (c *controller) decideFilterRollout(networkID string, ro *filterRollout) {
+	n, f, err := c.endpointFilterer(networkID)
+	if err != nil {
+		logrus.Warnf("Failed to decide of the filter rollout of network %.7s: %v", networkID, err)
+		return
+	}
+	c.filterMu.Lock()
+	defer c.filterMu.Unlock()
+	if c.filterRollouts[n.ID()] != ro || ro.staged == nil || ro.timer == nil {
+		return
+	}
+
+	var accepted, rejected uint64
+	for eid := range ro.canaries {
+		fc, err := f.FilterCounters(n.ID(), eid)
+		if err != nil || fc == nil || fc.Version != ro.staged.Version {
+			continue
+		}
+		accepted += fc.Accepted
+		rejected += fc.Rejected
+	}
+	if rejectRatioExceeded(accepted, rejected, ro.maxRejectRatio) {
+		logrus.Infof("Filter policy %s rejected %d of %d packets to the canaries of network %s", ro.staged.Version, rejected, accepted+rejected, n.Name())
+		err = c.rollbackFilterPolicy(n, f)
+	} else {
+		err = c.promoteFilterPolicy(n, f)
+	}
+	if err != nil {
+		logrus.Warn(err)
+	}
+}
+
+// rejectRatioExceeded tells whether the rejected packets are past the ratio
+// of all the packets, none being under any ratio
+func rejectRatioExceeded(accepted, rejected uint64, ratio float64) bool {
+	total := accepted + rejected
+	return total > 0 && float64(rejected) > ratio*float64(total)
+}
+
+func (ro *filterRollout) stopTimer() {
+	if ro.timer != nil {
+		ro.timer.Stop()
+		ro.timer = nil
+	}
+}
+
+// applyFilterPolicy applies the current filter policy of the network on
+// the new endpoint
+func (c *controller) applyFilterPolicy(n *network, ep *endpoint) {
+	c.filterMu.Lock()
+	defer c.filterMu.Unlock()
+	ro := c.filterRollouts[n.ID()]
+	if ro == nil || ro.current == nil {
+		return
+	}
+	d, err := n.driver(true)
+	if err != nil {
+		logrus.Warnf("Failed to apply filter policy %s on endpoint %.7s: %v", ro.current.Version, ep.ID(), err)
+		return
+	}
+	if f, ok := d.(driverapi.EndpointFilterer); ok {
+		if err := c.filterEndpoint(n, f, ep.ID(), ro.current); err != nil {
+			logrus.Warnf("Failed to apply filter policy %s on endpoint %.7s: %v", ro.current.Version, ep.ID(), err)
+		}
+	}
+}
+
+// forgetFilterRollout drops the filter rollout of the deleted network
+func (c *controller) forgetFilterRollout(nid string) {
+	c.filterMu.Lock()
+	defer c.filterMu.Unlock()
+	if ro := c.filterRollouts[nid]; ro != nil {
+		ro.stopTimer()
+		delete(c.filterRollouts, nid)
+	}
+}
+
+func (c *controller) endpointFilterer(networkID string) (*network, driverapi.EndpointFilterer, error) {
+	nw, err := c.NetworkByID(networkID)
+	if err != nil {
+		return nil, nil, err
+	}
+	n := nw.(*network)
+	d, err := n.driver(true)
+	if err != nil {
+		return nil, nil, err
+	}
+	f, ok := d.(driverapi.EndpointFilterer)
+	if !ok {
+		return nil, nil, types.NotImplementedErrorf("the %s driver does not support the filter policies of the endpoints", n.Type())
+	}
+	return n, f, nil
+}
+
+type filterRolloutResult struct {
+	NetworkID string `json:"network_id"`
+	FilterRolloutStatus
+}
+
+func (r *filterRolloutResult) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "nid:%s current:%s staged:%s", r.NetworkID, r.Current, r.Staged)
+	if !r.Deadline.IsZero() {
+		fmt.Fprintf(&b, " deadline:%s", r.Deadline.Format(time.RFC3339))
+	}
+	b.WriteString("\n")
+	for _, e := range r.Endpoints {
+		fmt.Fprintf(&b, "eid:%.7s version:%s canary:%t accepted:%d rejected:%d\n", e.ID, e.Version, e.Canary, e.Accepted, e.Rejected)
+	}
+	return b.String()
+}
+
+// parseFilterRollout reads the rollout of the stage command: the policy in
+// JSON, the comma separated canaries, the window and the reject ratio
+func parseFilterRollout(r *http.Request) (*FilterRollout, error) {
+	ro := &FilterRollout{Policy: &driverapi.FilterPolicy{}}
+	if err := json.Unmarshal([]byte(r.Form.Get("policy")), ro.Policy); err != nil {
+		return nil, types.BadRequestErrorf("invalid filter policy: %v", err)
+	}
+	for _, eid := range strings.Split(r.Form.Get("canaries"), ",") {
+		if eid = strings.TrimSpace(eid); eid != "" {
+			ro.Canaries = append(ro.Canaries, eid)
+		}
+	}
+	if v := r.Form.Get("window"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return nil, types.BadRequestErrorf("invalid filter rollout window %q: %v", v, err)
+		}
+		ro.Window = d
+	}
+	if v := r.Form.Get("maxreject"); v != "" {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return nil, types.BadRequestErrorf("invalid filter rollout reject ratio %q: %v", v, err)
+		}
+		ro.MaxRejectRatio = f
+	}
+	return ro, nil
+}
+
+// filterPolicyDiag stages, promotes, rolls back or reverts the filter policy
+// of a network, and replies the state of its rollout
+func filterPolicyDiag(ctx interface{}, w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	diagnostic.DebugHTTPForm(r)
+	_, json := diagnostic.ParseHTTPFormOptions(r)
+
+	// audit logs
+	log := logrus.WithFields(logrus.Fields{"component": "diagnostic", "remoteIP": r.RemoteAddr, "method": caller.Name(0), "url": r.URL.String()})
+	log.Info("filter policy")
+
+	c, ok := ctx.(*controller)
+	if !ok {
+		diagnostic.HTTPReply(w, diagnostic.FailCommand(fmt.Errorf("controller not available")), json)
+		return
+	}
+
+	const usage = "nid=<network id>[&op=<status|stage|promote|rollback|revert>][&policy=<json>&canaries=<eid,...>[&window=<duration>][&maxreject=<ratio>]][&version=<version>]"
+	nid := r.Form.Get("nid")
+	if nid == "" {
+		diagnostic.HTTPReply(w, diagnostic.WrongCommand("filterpolicy", usage), json)
+		return
+	}
+	var err error
+	switch r.Form.Get("op") {
+	case "", "status":
+	case "stage":
+		var ro *FilterRollout
+		if ro, err = parseFilterRollout(r); err == nil {
+			err = c.StageFilterPolicy(nid, ro)
+		}
+	case "promote":
+		err = c.PromoteFilterPolicy(nid)
+	case "rollback":
+		err = c.RollbackFilterPolicy(nid)
+	case "revert":
+		if r.Form.Get("version") == "" {
+			diagnostic.HTTPReply(w, diagnostic.WrongCommand("filterpolicy", usage), json)
+			return
+		}
+		err = c.RevertFilterPolicy(nid, r.Form.Get("version"))
+	default:
+		diagnostic.HTTPReply(w, diagnostic.WrongCommand("filterpolicy", usage), json)
+		return
+	}
+	if err != nil {
+		log.WithError(err).Error("filter policy failed")
+		diagnostic.HTTPReply(w, diagnostic.FailCommand(err), json)
+		return
+	}
+
+	s, err := c.FilterRolloutStatus(nid)
+	if err != nil {
+		log.WithError(err).Error("filter policy failed")
+		diagnostic.HTTPReply(w, diagnostic.FailCommand(err), json)
+		return
+	}
+	diagnostic.HTTPReply(w, diagnostic.CommandSucceed(&filterRolloutResult{NetworkID: nid, FilterRolloutStatus: *s}), json)
+}
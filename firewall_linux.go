@@ -11,10 +11,12 @@ func (c *controller) arrangeUserFilterRule() {
 	c.Lock()
 	arrangeUserFilterRule()
 	c.Unlock()
+	c.publish(Event{Type: EventFilterUpdate})
 	iptables.OnReloaded(func() {
 		c.Lock()
 		arrangeUserFilterRule()
 		c.Unlock()
+		c.publish(Event{Type: EventFilterUpdate})
 	})
 }
 
@@ -0,0 +1,575 @@
+package libnetwork
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/libnetwork/authz"
+	"github.com/docker/libnetwork/datastore"
+	"github.com/docker/libnetwork/diagnostic"
+	"github.com/docker/libnetwork/internal/caller"
+	"github.com/docker/libnetwork/types"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	floatingIPKeyPrefix = "floating_ip"
+	// floatingIPLeaseTTL is how long a lease holds once not renewed
+	floatingIPLeaseTTL = 9 * time.Second
+	// floatingIPRenewInterval is the interval the leases are renewed, or
+	// their expiry checked by the standby candidates, at
+	floatingIPRenewInterval = 3 * time.Second
+	// floatingIPSkewUnit is the extra wait per priority point below
+	// MaxFloatingIPPriority before an expired lease is taken over
+	floatingIPSkewUnit = 20 * time.Millisecond
+
+	// MaxFloatingIPPriority is the highest priority of a candidate
+	MaxFloatingIPPriority = 255
+	// DefaultFloatingIPPriority is the priority of the candidates which
+	// do not set one
+	DefaultFloatingIPPriority = 100
+)
+
+// floatingIPPaths2Func are the diagnostic handlers of the floating IPs
+var floatingIPPaths2Func = map[string]diagnostic.HTTPHandlerFunc{
+	"/floatingips": floatingIPsDiag,
+}
+
+// FloatingIPConfig makes a local endpoint a candidate holder of a floating
+// IP, an IPv4 address the candidates of several hosts share and which one
+// of them holds at a time. The holder is elected through a lease in the
+// global datastore; it gets the address on its interface, the host a route
+// to it through the endpoint and, when Interface is set, the address is
+// answered for and announced on that host interface by ARP.
+type FloatingIPConfig struct {
+	// Name identifies the floating IP across the hosts
+	Name       string `json:"name"`
+	Address    net.IP `json:"address"`
+	NetworkID  string `json:"network_id"`
+	EndpointID string `json:"endpoint_id"`
+	// Priority orders the takeover of an expired lease, the candidates
+	// with the highest priority getting it first. The holder keeps the
+	// lease as long as it renews it, whatever the priorities.
+	Priority int `json:"priority"`
+	// Interface is the host interface the address is announced on
+	Interface string `json:"interface,omitempty"`
+}
+
+// FloatingIPStatus is the state of a local candidate of a floating IP
+type FloatingIPStatus struct {
+	FloatingIPConfig
+	Active    bool      `json:"active"`
+	Holder    string    `json:"holder,omitempty"`
+	Since     time.Time `json:"since"`
+	LastError string    `json:"last_error,omitempty"`
+}
+
+type floatingIPsResult struct {
+	FloatingIPs []FloatingIPStatus `json:"floating_ips"`
+}
+
+func (r *floatingIPsResult) String() string {
+	var b strings.Builder
+	for _, s := range r.FloatingIPs {
+		state := "standby"
+		if s.Active {
+			state = "active"
+		}
+		fmt.Fprintf(&b, "name:%s address:%s eid:%.7s priority:%d %s holder:%s %s\n",
+			s.Name, s.Address, s.EndpointID, s.Priority, state, s.Holder, s.LastError)
+	}
+	return b.String()
+}
+
+func (cfg *FloatingIPConfig) validate() error {
+	if cfg.Name == "" {
+		return types.BadRequestErrorf("the floating IP has no name")
+	}
+	if strings.Contains(cfg.Name, "/") {
+		return types.BadRequestErrorf("invalid floating IP name %q", cfg.Name)
+	}
+	if cfg.Address == nil || cfg.Address.To4() == nil || cfg.Address.IsUnspecified() {
+		return types.BadRequestErrorf("invalid floating IP address %v: an IPv4 unicast address is expected", cfg.Address)
+	}
+	if cfg.NetworkID == "" || cfg.EndpointID == "" {
+		return types.BadRequestErrorf("the floating IP %s has no endpoint", cfg.Name)
+	}
+	if cfg.Priority < 0 || cfg.Priority > MaxFloatingIPPriority {
+		return types.BadRequestErrorf("invalid floating IP priority %d: it must be within 0 and %d", cfg.Priority, MaxFloatingIPPriority)
+	}
+	return nil
+}
+
+// floatingIPLease is the record of the holder of a floating IP in the
+// global datastore. The expiry is compared to the local clock of the
+// candidates, which are expected to be kept in sync.
+type floatingIPLease struct {
+	name       string
+	Address    string    `json:"address"`
+	Owner      string    `json:"owner"`
+	EndpointID string    `json:"endpoint_id"`
+	Priority   int       `json:"priority"`
+	Expiry     time.Time `json:"expiry"`
+	dbIndex    uint64
+	dbExists   bool
+	sync.Mutex
+}
+
+func (l *floatingIPLease) Key() []string {
+	return []string{floatingIPKeyPrefix, l.name}
+}
+
+func (l *floatingIPLease) KeyPrefix() []string {
+	return []string{floatingIPKeyPrefix}
+}
+
+func (l *floatingIPLease) Value() []byte {
+	l.Lock()
+	defer l.Unlock()
+
+	b, err := json.Marshal(l)
+	if err != nil {
+		return nil
+	}
+	return b
+}
+
+func (l *floatingIPLease) SetValue(value []byte) error {
+	l.Lock()
+	defer l.Unlock()
+
+	return json.Unmarshal(value, l)
+}
+
+func (l *floatingIPLease) Index() uint64 {
+	l.Lock()
+	defer l.Unlock()
+	return l.dbIndex
+}
+
+func (l *floatingIPLease) SetIndex(index uint64) {
+	l.Lock()
+	l.dbIndex = index
+	l.dbExists = true
+	l.Unlock()
+}
+
+func (l *floatingIPLease) Exists() bool {
+	l.Lock()
+	defer l.Unlock()
+	return l.dbExists
+}
+
+func (l *floatingIPLease) Skip() bool {
+	return false
+}
+
+func (l *floatingIPLease) New() datastore.KVObject {
+	return &floatingIPLease{name: l.name}
+}
+
+func (l *floatingIPLease) CopyTo(o datastore.KVObject) error {
+	l.Lock()
+	defer l.Unlock()
+
+	dst := o.(*floatingIPLease)
+	dst.name = l.name
+	dst.Address = l.Address
+	dst.Owner = l.Owner
+	dst.EndpointID = l.EndpointID
+	dst.Priority = l.Priority
+	dst.Expiry = l.Expiry
+	dst.dbIndex = l.dbIndex
+	dst.dbExists = l.dbExists
+
+	return nil
+}
+
+func (l *floatingIPLease) DataScope() string {
+	return datastore.GlobalScope
+}
+
+// floatingIP is a local candidate of a floating IP
+type floatingIP struct {
+	sync.Mutex
+	config FloatingIPConfig
+	active bool
+	holder string
+	since  time.Time
+	// leaseExpiry is the expiry of the lease while it is held
+	leaseExpiry time.Time
+	// expiredSince is when the lease of another holder was first seen
+	// expired
+	expiredSince time.Time
+	lastError    string
+	stop         chan struct{}
+	done         chan struct{}
+}
+
+// takeoverSkew is the wait, past the expiry of the lease, before the
+// candidate takes it over
+func (f *floatingIP) takeoverSkew() time.Duration {
+	return time.Duration(MaxFloatingIPPriority-f.config.Priority) * floatingIPSkewUnit
+}
+
+// shouldAcquire tells if the candidate, owner being its controller id,
+// writes the lease: it renews its own lease, creates a missing one and
+// takes over the one of another holder once expired for its skew
+func (f *floatingIP) shouldAcquire(lease *floatingIPLease, found bool, owner string, now time.Time) bool {
+	if !found || lease.Owner == owner {
+		f.expiredSince = time.Time{}
+		return true
+	}
+	if now.Before(lease.Expiry) {
+		f.expiredSince = time.Time{}
+		return false
+	}
+	if f.expiredSince.IsZero() {
+		f.expiredSince = now
+	}
+	return now.Sub(f.expiredSince) >= f.takeoverSkew()
+}
+
+func (f *floatingIP) status() FloatingIPStatus {
+	f.Lock()
+	defer f.Unlock()
+	return FloatingIPStatus{
+		FloatingIPConfig: f.config,
+		Active:           f.active,
+		Holder:           f.holder,
+		Since:            f.since,
+		LastError:        f.lastError,
+	}
+}
+
+func (f *floatingIP) setError(err error) {
+	f.Lock()
+	defer f.Unlock()
+	if err == nil {
+		f.lastError = ""
+		return
+	}
+	f.lastError = err.Error()
+}
+
+// AddFloatingIP makes the local endpoint of cfg a candidate holder of the
+// floating IP
+func (c *controller) AddFloatingIP(cfg *FloatingIPConfig) error {
+	if cfg == nil {
+		return types.BadRequestErrorf("no floating IP configuration")
+	}
+	config := *cfg
+	if config.Priority == 0 {
+		config.Priority = DefaultFloatingIPPriority
+	}
+	if err := config.validate(); err != nil {
+		return err
+	}
+	if c.getStore(datastore.GlobalScope) == nil {
+		return types.ForbiddenErrorf("floating IPs require a global datastore")
+	}
+	if _, err := c.floatingIPEndpoint(&config); err != nil {
+		return err
+	}
+	if err := c.authorizeFloatingIP(authz.FloatingIPAdd, &config); err != nil {
+		return err
+	}
+
+	f := &floatingIP{
+		config: config,
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	c.Lock()
+	if c.floatingIPs == nil {
+		c.floatingIPs = map[string]*floatingIP{}
+	}
+	if _, ok := c.floatingIPs[config.Name]; ok {
+		c.Unlock()
+		return types.ForbiddenErrorf("the floating IP %s already has a local candidate", config.Name)
+	}
+	c.floatingIPs[config.Name] = f
+	c.Unlock()
+
+	go c.runFloatingIP(f)
+	return nil
+}
+
+// RemoveFloatingIP withdraws the local candidate of the floating IP,
+// releasing the lease when held so that a standby takes over at once
+func (c *controller) RemoveFloatingIP(name string) error {
+	c.Lock()
+	f, ok := c.floatingIPs[name]
+	c.Unlock()
+	if !ok {
+		return types.NotFoundErrorf("floating IP %s has no local candidate", name)
+	}
+	if err := c.authorizeFloatingIP(authz.FloatingIPRemove, &f.config); err != nil {
+		return err
+	}
+
+	c.Lock()
+	if c.floatingIPs[name] != f {
+		c.Unlock()
+		return types.NotFoundErrorf("floating IP %s has no local candidate", name)
+	}
+	delete(c.floatingIPs, name)
+	c.Unlock()
+	close(f.stop)
+	<-f.done
+	return nil
+}
+
+// FloatingIPs returns the state of the local candidates of the floating
+// IPs
+func (c *controller) FloatingIPs() []FloatingIPStatus {
+	c.Lock()
+	fips := make([]*floatingIP, 0, len(c.floatingIPs))
+	for _, f := range c.floatingIPs {
+		fips = append(fips, f)
+	}
+	c.Unlock()
+
+	status := make([]FloatingIPStatus, 0, len(fips))
+	for _, f := range fips {
+		status = append(status, f.status())
+	}
+	sort.Slice(status, func(i, j int) bool { return status[i].Name < status[j].Name })
+	return status
+}
+
+// stopFloatingIPs withdraws all the local candidates
+func (c *controller) stopFloatingIPs() {
+	c.Lock()
+	var names []string
+	for name := range c.floatingIPs {
+		names = append(names, name)
+	}
+	c.Unlock()
+
+	for _, name := range names {
+		c.RemoveFloatingIP(name)
+	}
+}
+
+func (c *controller) floatingIPEndpoint(cfg *FloatingIPConfig) (*endpoint, error) {
+	ep, err := c.connectivityEndpoint(cfg.NetworkID, cfg.EndpointID)
+	if err != nil {
+		return nil, err
+	}
+	if ep.Iface() == nil || ep.Iface().Address() == nil {
+		return nil, types.BadRequestErrorf("endpoint %.7s of floating IP %s has no IPv4 address", cfg.EndpointID, cfg.Name)
+	}
+	return ep, nil
+}
+
+func (c *controller) runFloatingIP(f *floatingIP) {
+	defer close(f.done)
+
+	ticker := time.NewTicker(floatingIPRenewInterval)
+	defer ticker.Stop()
+	for {
+		c.electFloatingIP(f)
+		select {
+		case <-ticker.C:
+		case <-f.stop:
+			c.releaseFloatingIP(f)
+			return
+		}
+	}
+}
+
+// electFloatingIP runs a round of the election of the holder of the
+// floating IP: the lease is read and, when due, written back with the
+// local candidate as owner. The datastore only accepts the write of the
+// index read, so that a single candidate wins a takeover.
+func (c *controller) electFloatingIP(f *floatingIP) {
+	f.Lock()
+	cfg := f.config
+	f.Unlock()
+
+	store := c.getStore(datastore.GlobalScope)
+	if store == nil {
+		c.standbyFloatingIP(f, "", fmt.Errorf("global datastore not available"))
+		return
+	}
+
+	lease := &floatingIPLease{name: cfg.Name}
+	err := store.GetObject(datastore.Key(lease.Key()...), lease)
+	if err != nil && err != datastore.ErrKeyNotFound {
+		c.checkFloatingIPLease(f, fmt.Errorf("failed to read the lease of floating IP %s: %v", cfg.Name, err))
+		return
+	}
+	found := err == nil
+
+	now := time.Now()
+	f.Lock()
+	acquire := f.shouldAcquire(lease, found, c.id, now)
+	f.Unlock()
+	if !acquire {
+		c.standbyFloatingIP(f, lease.Owner, nil)
+		return
+	}
+
+	// The endpoint may have left its sandbox since the last round
+	if _, err := c.floatingIPEndpoint(&cfg); err != nil {
+		c.standbyFloatingIP(f, lease.Owner, err)
+		if found && lease.Owner == c.id {
+			store.DeleteObjectAtomic(lease)
+		}
+		return
+	}
+
+	lease.Address = cfg.Address.String()
+	lease.Owner = c.id
+	lease.EndpointID = cfg.EndpointID
+	lease.Priority = cfg.Priority
+	lease.Expiry = now.Add(floatingIPLeaseTTL)
+	if err := store.PutObjectAtomic(lease); err != nil {
+		if err == datastore.ErrKeyModified {
+			// Another candidate won the round
+			c.standbyFloatingIP(f, "", nil)
+			return
+		}
+		c.checkFloatingIPLease(f, fmt.Errorf("failed to write the lease of floating IP %s: %v", cfg.Name, err))
+		return
+	}
+
+	f.Lock()
+	f.leaseExpiry = lease.Expiry
+	wasActive := f.active
+	f.Unlock()
+	if wasActive {
+		f.setError(nil)
+		return
+	}
+
+	logrus.Infof("Floating IP %s (%s) taken over by endpoint %.7s", cfg.Name, cfg.Address, cfg.EndpointID)
+	if err := c.programFloatingIP(&cfg, true); err != nil {
+		logrus.Warnf("Failed to program floating IP %s: %v", cfg.Name, err)
+		c.programFloatingIP(&cfg, false)
+		// Let a standby with a working endpoint take over
+		store.DeleteObjectAtomic(lease)
+		f.setError(err)
+		return
+	}
+	f.Lock()
+	f.active = true
+	f.holder = c.id
+	f.since = now
+	f.lastError = ""
+	f.Unlock()
+}
+
+// checkFloatingIPLease withdraws the floating IP when the datastore can not
+// be reached past the expiry of the held lease, as a standby may have
+// taken it over meanwhile
+func (c *controller) checkFloatingIPLease(f *floatingIP, err error) {
+	logrus.Warn(err)
+	f.setError(err)
+
+	f.Lock()
+	expired := f.active && time.Now().After(f.leaseExpiry)
+	f.Unlock()
+	if expired {
+		c.standbyFloatingIP(f, "", err)
+	}
+}
+
+// standbyFloatingIP withdraws the floating IP when it was active
+func (c *controller) standbyFloatingIP(f *floatingIP, holder string, err error) {
+	f.Lock()
+	wasActive := f.active
+	cfg := f.config
+	f.active = false
+	f.holder = holder
+	if wasActive {
+		f.since = time.Now()
+	}
+	if err != nil {
+		f.lastError = err.Error()
+	}
+	f.Unlock()
+
+	if !wasActive {
+		return
+	}
+	logrus.Infof("Floating IP %s (%s) withdrawn from endpoint %.7s", cfg.Name, cfg.Address, cfg.EndpointID)
+	if err := c.programFloatingIP(&cfg, false); err != nil {
+		logrus.Warnf("Failed to withdraw floating IP %s: %v", cfg.Name, err)
+	}
+}
+
+// releaseFloatingIP withdraws the floating IP and deletes its lease when
+// held
+func (c *controller) releaseFloatingIP(f *floatingIP) {
+	f.Lock()
+	cfg := f.config
+	wasActive := f.active
+	f.Unlock()
+
+	c.standbyFloatingIP(f, "", nil)
+	if !wasActive {
+		return
+	}
+	store := c.getStore(datastore.GlobalScope)
+	if store == nil {
+		return
+	}
+	lease := &floatingIPLease{name: cfg.Name}
+	if err := store.GetObject(datastore.Key(lease.Key()...), lease); err != nil {
+		return
+	}
+	if lease.Owner != c.id {
+		return
+	}
+	if err := store.DeleteObjectAtomic(lease); err != nil {
+		logrus.Warnf("Failed to release the lease of floating IP %s: %v", cfg.Name, err)
+	}
+}
+
+// gratuitousARP renders the broadcast ARP request announcing the address
+// at the hardware address
+func gratuitousARP(mac net.HardwareAddr, ip net.IP) []byte {
+	b := make([]byte, 42)
+	// Ethernet header
+	copy(b[0:6], []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff})
+	copy(b[6:12], mac)
+	binary.BigEndian.PutUint16(b[12:14], 0x0806)
+	// ARP request, the sender and target addresses being the announced one
+	binary.BigEndian.PutUint16(b[14:16], 1)
+	binary.BigEndian.PutUint16(b[16:18], 0x0800)
+	b[18] = 6
+	b[19] = 4
+	binary.BigEndian.PutUint16(b[20:22], 1)
+	copy(b[22:28], mac)
+	copy(b[28:32], ip.To4())
+	copy(b[38:42], ip.To4())
+	return b
+}
+
+// floatingIPsDiag lists the local candidates of the floating IPs
+func floatingIPsDiag(ctx interface{}, w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	diagnostic.DebugHTTPForm(r)
+	_, json := diagnostic.ParseHTTPFormOptions(r)
+
+	// audit logs
+	log := logrus.WithFields(logrus.Fields{"component": "diagnostic", "remoteIP": r.RemoteAddr, "method": caller.Name(0), "url": r.URL.String()})
+	log.Info("floating IPs")
+
+	c, ok := ctx.(*controller)
+	if !ok {
+		diagnostic.HTTPReply(w, diagnostic.FailCommand(fmt.Errorf("controller not available")), json)
+		return
+	}
+
+	diagnostic.HTTPReply(w, diagnostic.CommandSucceed(&floatingIPsResult{FloatingIPs: c.FloatingIPs()}), json)
+}
@@ -0,0 +1,129 @@
+package libnetwork
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+
+	"github.com/docker/libnetwork/netutils"
+	"github.com/sirupsen/logrus"
+	"github.com/vishvananda/netlink"
+)
+
+// programFloatingIP adds, or removes, the floating IP on the interface of
+// the endpoint in its sandbox, the host route to it through the endpoint
+// and, with a host interface, the proxy ARP entry answering for it there.
+// The removal goes through all the steps, returning the first failure.
+func (c *controller) programFloatingIP(cfg *FloatingIPConfig, add bool) error {
+	ep, err := c.floatingIPEndpoint(cfg)
+	if err != nil {
+		return err
+	}
+	sb, ok := ep.getSandbox()
+	if !ok {
+		return fmt.Errorf("endpoint %.7s of floating IP %s is not attached to a sandbox", ep.ID(), cfg.Name)
+	}
+	sb.Lock()
+	osSbox := sb.osSbox
+	sb.Unlock()
+	if osSbox == nil {
+		return fmt.Errorf("sandbox %.7s of floating IP %s has no network namespace", sb.ID(), cfg.Name)
+	}
+	ifName := findIfaceDstName(sb, ep)
+	if ifName == "" {
+		return fmt.Errorf("failed to find the interface of endpoint %.7s in its sandbox", ep.ID())
+	}
+
+	addr := &net.IPNet{IP: cfg.Address.To4(), Mask: net.CIDRMask(32, 32)}
+	route := &netlink.Route{Dst: addr, Gw: ep.Iface().Address().IP}
+
+	if !add {
+		var first error
+		keep := func(err error) {
+			if err != nil && first == nil {
+				first = err
+			}
+		}
+		if cfg.Interface != "" {
+			keep(programProxyARP(cfg.Interface, addr.IP, false))
+		}
+		if err := netlink.RouteDel(route); err != nil && err != syscall.ESRCH {
+			keep(fmt.Errorf("failed to remove the route to floating IP %s: %v", cfg.Address, err))
+		}
+		netutils.DisownRoute(route)
+		if err := osSbox.RemoveAliasIP(ifName, addr); err != nil && err != syscall.EADDRNOTAVAIL {
+			keep(fmt.Errorf("failed to remove floating IP %s from %s: %v", cfg.Address, ifName, err))
+		}
+		return first
+	}
+
+	if err := osSbox.AddAliasIP(ifName, addr); err != nil && err != syscall.EEXIST {
+		return fmt.Errorf("failed to add floating IP %s to %s: %v", cfg.Address, ifName, err)
+	}
+	if err := netlink.RouteReplace(netutils.OwnRoute(route)); err != nil {
+		return fmt.Errorf("failed to route floating IP %s through %s: %v", cfg.Address, route.Gw, err)
+	}
+	if cfg.Interface != "" {
+		if err := programProxyARP(cfg.Interface, addr.IP, true); err != nil {
+			return err
+		}
+		if err := announceFloatingIP(cfg.Interface, addr.IP); err != nil {
+			// The neighbors learn of the new holder on their next resolution
+			logrus.Warnf("Failed to announce floating IP %s on %s: %v", cfg.Address, cfg.Interface, err)
+		}
+	}
+	return nil
+}
+
+// programProxyARP adds or removes the proxy neighbor entry making the host
+// answer the ARP requests for the address on the interface, which the
+// kernel does for the addresses routed through another interface
+func programProxyARP(ifName string, ip net.IP, add bool) error {
+	link, err := netlink.LinkByName(ifName)
+	if err != nil {
+		return fmt.Errorf("failed to find the interface %s of floating IP %s: %v", ifName, ip, err)
+	}
+	neigh := &netlink.Neigh{
+		LinkIndex: link.Attrs().Index,
+		Family:    netlink.FAMILY_V4,
+		Flags:     netlink.NTF_PROXY,
+		IP:        ip,
+	}
+	if !add {
+		if err := netlink.NeighDel(neigh); err != nil && err != syscall.ENOENT {
+			return fmt.Errorf("failed to remove the proxy ARP entry of floating IP %s on %s: %v", ip, ifName, err)
+		}
+		return nil
+	}
+	if err := netlink.NeighSet(neigh); err != nil {
+		return fmt.Errorf("failed to add the proxy ARP entry of floating IP %s on %s: %v", ip, ifName, err)
+	}
+	return nil
+}
+
+// announceFloatingIP broadcasts a gratuitous ARP on the interface, so that
+// the neighbors update their entry of the address to the new holder
+func announceFloatingIP(ifName string, ip net.IP) error {
+	iface, err := net.InterfaceByName(ifName)
+	if err != nil {
+		return err
+	}
+	if len(iface.HardwareAddr) != 6 {
+		return fmt.Errorf("the interface has no ethernet address")
+	}
+
+	proto := htons(syscall.ETH_P_ARP)
+	fd, err := syscall.Socket(syscall.AF_PACKET, syscall.SOCK_RAW, int(proto))
+	if err != nil {
+		return err
+	}
+	defer syscall.Close(fd)
+
+	to := &syscall.SockaddrLinklayer{
+		Protocol: proto,
+		Ifindex:  iface.Index,
+		Halen:    6,
+	}
+	copy(to.Addr[:], []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff})
+	return syscall.Sendto(fd, gratuitousARP(iface.HardwareAddr, ip), 0, to)
+}
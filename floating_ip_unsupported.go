@@ -0,0 +1,9 @@
+// +build !linux
+
+package libnetwork
+
+import "github.com/docker/libnetwork/types"
+
+func (c *controller) programFloatingIP(cfg *FloatingIPConfig, add bool) error {
+	return types.NotImplementedErrorf("floating IPs are not supported on this platform")
+}
@@ -0,0 +1,216 @@
+package libnetwork
+
+import (
+	"fmt"
+	"hash/fnv"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/docker/libnetwork/ipfix"
+	"github.com/docker/libnetwork/netlabel"
+	"github.com/docker/libnetwork/types"
+	"github.com/sirupsen/logrus"
+)
+
+// flowExportInterval is the interval the conntrack table is polled at for
+// the new flows of the networks exporting theirs
+const flowExportInterval = 10 * time.Second
+
+// trackedFlow is a flow of the conntrack table of the host, Dst being the
+// address replying, past the destination NAT
+type trackedFlow struct {
+	Src, Dst         net.IP
+	Proto            uint8
+	SrcPort, DstPort uint16
+}
+
+func (f trackedFlow) key() string {
+	return fmt.Sprintf("%d/%s:%d/%s:%d", f.Proto, f.Src, f.SrcPort, f.Dst, f.DstPort)
+}
+
+// flowExport is the export of the flows of a network to its collector
+type flowExport struct {
+	collector string
+	sampling  uint32
+	exporter  *ipfix.Exporter
+	// seen are the flows found on the last poll, not exported again
+	seen map[string]bool
+}
+
+// parseFlowExport returns the collector and the sampling interval of the
+// flow export labels
+func parseFlowExport(labels map[string]string) (string, uint32, error) {
+	collector, ok := labels[netlabel.FlowExport]
+	if !ok {
+		return "", 0, nil
+	}
+	host, port, err := net.SplitHostPort(collector)
+	if p, perr := strconv.Atoi(port); err != nil || host == "" || perr != nil || p < 1 || p > 65535 {
+		return "", 0, types.BadRequestErrorf("invalid %s %q: expected host:port", netlabel.FlowExport, collector)
+	}
+	sampling := uint32(1)
+	if v, ok := labels[netlabel.FlowExportSampling]; ok {
+		s, err := strconv.ParseUint(v, 10, 32)
+		if err != nil || s == 0 {
+			return "", 0, types.BadRequestErrorf("invalid %s %q: expected a positive integer", netlabel.FlowExportSampling, v)
+		}
+		sampling = uint32(s)
+	}
+	return collector, sampling, nil
+}
+
+func (n *network) validateFlowExport() error {
+	_, _, err := parseFlowExport(n.labels)
+	return err
+}
+
+// sampled tells whether the flow is among the one in sampling exported,
+// the same flows being picked on every poll
+func sampled(key string, sampling uint32) bool {
+	if sampling <= 1 {
+		return true
+	}
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return h.Sum32()%sampling == 0
+}
+
+// exportsFlows tells whether the network is labeled with a collector
+func (n *network) exportsFlows() bool {
+	_, ok := n.Labels()[netlabel.FlowExport]
+	return ok
+}
+
+// startFlowExport starts the export loop, once the first network labeled
+// with a collector is created or restored. It is left running when the
+// networks with a collector go away.
+func (c *controller) startFlowExport() {
+	c.Lock()
+	defer c.Unlock()
+	if c.flowExportStop != nil {
+		return
+	}
+	c.flowExportStop = make(chan struct{})
+	go c.runFlowExport(flowExportInterval, c.flowExportStop)
+}
+
+// runFlowExport exports the new flows of the networks labeled with a
+// collector at every interval. The flows are the ones of the conntrack
+// table of the host, so the flows of the sandboxes of the drivers routing
+// in another namespace are not seen.
+func (c *controller) runFlowExport(interval time.Duration, stopCh chan struct{}) {
+	exports := map[string]*flowExport{}
+	defer func() {
+		for _, e := range exports {
+			e.exporter.Close()
+		}
+	}()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			c.exportFlows(exports)
+		case <-stopCh:
+			return
+		}
+	}
+}
+
+// exportFlows updates the exports of the networks and sends the flows
+// which are new since the last poll
+func (c *controller) exportFlows(exports map[string]*flowExport) {
+	networks := map[string]*network{}
+	for _, nw := range c.Networks() {
+		n := nw.(*network)
+		collector, sampling, err := parseFlowExport(n.Labels())
+		if err != nil || collector == "" {
+			continue
+		}
+		e := exports[n.ID()]
+		if e != nil && (e.collector != collector || e.sampling != sampling) {
+			e.exporter.Close()
+			e = nil
+		}
+		if e == nil {
+			h := fnv.New32a()
+			h.Write([]byte(n.ID()))
+			exporter, err := ipfix.NewExporter(collector, h.Sum32(), c.cfg.Daemon.FlowExportEnterprise)
+			if err != nil {
+				logrus.Warnf("Failed to export the flows of network %s: %v", n.Name(), err)
+				delete(exports, n.ID())
+				continue
+			}
+			e = &flowExport{collector: collector, sampling: sampling, exporter: exporter, seen: map[string]bool{}}
+			exports[n.ID()] = e
+		}
+		networks[n.ID()] = n
+	}
+	for nid, e := range exports {
+		if networks[nid] == nil {
+			e.exporter.Close()
+			delete(exports, nid)
+		}
+	}
+	if len(exports) == 0 {
+		return
+	}
+
+	flows, err := listTrackedFlows()
+	if err != nil {
+		logrus.Warnf("Failed to list the flows to export: %v", err)
+		return
+	}
+	now := time.Now()
+	for nid, e := range exports {
+		n := networks[nid]
+		if err := e.exporter.Export(e.newRecords(n.ID(), flowEndpoints(n), flows, now)); err != nil {
+			logrus.Warnf("Failed to export the flows of network %s to %s: %v", n.Name(), e.collector, err)
+		}
+	}
+}
+
+// flowEndpoints maps the IPv4 addresses of the endpoints of the network to
+// their IDs
+func flowEndpoints(n *network) map[string]string {
+	endpoints := map[string]string{}
+	for _, ep := range n.Endpoints() {
+		if iface := ep.(*endpoint).Iface(); iface != nil && iface.Address() != nil {
+			endpoints[iface.Address().IP.String()] = ep.ID()
+		}
+	}
+	return endpoints
+}
+
+// newRecords returns the records of the sampled flows of the endpoints,
+// mapped by address, not seen on the last poll
+func (e *flowExport) newRecords(nid string, endpoints map[string]string, flows []trackedFlow, now time.Time) []*ipfix.Record {
+	var records []*ipfix.Record
+	seen := map[string]bool{}
+	for _, f := range flows {
+		src, dst := endpoints[f.Src.String()], endpoints[f.Dst.String()]
+		if src == "" && dst == "" {
+			continue
+		}
+		key := f.key()
+		seen[key] = true
+		if e.seen[key] || !sampled(key, e.sampling) {
+			continue
+		}
+		records = append(records, &ipfix.Record{
+			Src:              f.Src,
+			Dst:              f.Dst,
+			Protocol:         f.Proto,
+			SrcPort:          f.SrcPort,
+			DstPort:          f.DstPort,
+			Start:            now,
+			SamplingInterval: e.sampling,
+			NetworkID:        nid,
+			SrcEndpoint:      src,
+			DstEndpoint:      dst,
+		})
+	}
+	e.seen = seen
+	return records
+}
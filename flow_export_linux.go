@@ -0,0 +1,28 @@
+package libnetwork
+
+import (
+	"fmt"
+	"syscall"
+
+	"github.com/vishvananda/netlink"
+)
+
+// listTrackedFlows returns the IPv4 flows of the conntrack table of the
+// host
+func listTrackedFlows() ([]trackedFlow, error) {
+	flows, err := netlink.ConntrackTableList(netlink.ConntrackTable, netlink.InetFamily(syscall.AF_INET))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the conntrack flows: %v", err)
+	}
+	tracked := make([]trackedFlow, 0, len(flows))
+	for _, f := range flows {
+		tracked = append(tracked, trackedFlow{
+			Src:     f.Forward.SrcIP,
+			Dst:     f.Reverse.SrcIP,
+			Proto:   f.Forward.Protocol,
+			SrcPort: f.Forward.SrcPort,
+			DstPort: f.Reverse.SrcPort,
+		})
+	}
+	return tracked, nil
+}
@@ -0,0 +1,9 @@
+// +build !linux
+
+package libnetwork
+
+import "github.com/docker/libnetwork/types"
+
+func listTrackedFlows() ([]trackedFlow, error) {
+	return nil, types.NotImplementedErrorf("flow export is not supported on this platform")
+}
@@ -0,0 +1,139 @@
+// Package geoip loads the subnets allocated to the countries into ipsets
+// of the host, for the filter rules to allow the traffic of a country. The
+// subnets come from a pluggable Provider, and each country gets one set
+// per address family.
+package geoip
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/docker/libnetwork/iptables"
+	"github.com/docker/libnetwork/types"
+)
+
+var countryRe = regexp.MustCompile(`^[A-Z]{2}$`)
+
+// ValidCountry tells if the code is an ISO 3166-1 alpha-2 country code, in
+// upper case
+func ValidCountry(code string) bool {
+	return countryRe.MatchString(code)
+}
+
+// SetName returns the name of the ipset holding the IPv4, or the IPv6,
+// subnets of the country
+func SetName(country string, ipv6 bool) string {
+	if ipv6 {
+		return "lnet-geo6-" + strings.ToLower(country)
+	}
+	return "lnet-geo4-" + strings.ToLower(country)
+}
+
+// Provider gives the subnets allocated to the countries
+type Provider interface {
+	// Subnets returns the IPv4 and IPv6 subnets of the country, given by
+	// its ISO 3166-1 alpha-2 code, a NotFoundError when it knows none
+	Subnets(country string) ([]*net.IPNet, error)
+}
+
+// loadIPSet replaces the members of the ipset of the host
+var loadIPSet = iptables.LoadIPSet
+
+// Loader loads the subnets of the countries of its Provider into the
+// ipsets of the host, once per country. The sets of a country are loaded
+// again, from the subnets the provider then gives, when the daemon
+// restarts.
+type Loader struct {
+	sync.Mutex
+	provider Provider
+	loaded   map[string]bool
+}
+
+// NewLoader returns a Loader of the subnets of the provider
+func NewLoader(provider Provider) *Loader {
+	return &Loader{provider: provider, loaded: map[string]bool{}}
+}
+
+// Load loads the subnets of the country into its ipsets, unless they got
+// loaded already
+func (l *Loader) Load(country string) error {
+	if !ValidCountry(country) {
+		return types.BadRequestErrorf("invalid country code %q", country)
+	}
+	l.Lock()
+	defer l.Unlock()
+	if l.loaded[country] {
+		return nil
+	}
+
+	subnets, err := l.provider.Subnets(country)
+	if err != nil {
+		return err
+	}
+	var v4, v6 []string
+	for _, s := range subnets {
+		if s.IP.To4() != nil {
+			v4 = append(v4, s.String())
+		} else {
+			v6 = append(v6, s.String())
+		}
+	}
+	if err := loadIPSet(SetName(country, false), "hash:net", "inet", v4); err != nil {
+		return err
+	}
+	if err := loadIPSet(SetName(country, true), "hash:net", "inet6", v6); err != nil {
+		return err
+	}
+	l.loaded[country] = true
+	return nil
+}
+
+// DirProvider is a reference Provider reading the subnets of the countries
+// from the zone files of a directory, as in us.zone for US, which list a
+// subnet per line. The empty lines and the ones starting with # are
+// skipped.
+type DirProvider struct {
+	dir string
+}
+
+// NewDirProvider returns a DirProvider of the zone files of the directory
+func NewDirProvider(dir string) *DirProvider {
+	return &DirProvider{dir: dir}
+}
+
+// Subnets implements Provider
+func (p *DirProvider) Subnets(country string) ([]*net.IPNet, error) {
+	path := filepath.Join(p.dir, strings.ToLower(country)+".zone")
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, types.NotFoundErrorf("no zone file of country %s", country)
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	var subnets []*net.IPNet
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		_, subnet, err := net.ParseCIDR(line)
+		if err != nil {
+			return nil, fmt.Errorf("invalid subnet on line %d of %s: %v", n, path, err)
+		}
+		subnets = append(subnets, subnet)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", path, err)
+	}
+	return subnets, nil
+}
@@ -0,0 +1,106 @@
+package geoip
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/docker/libnetwork/types"
+)
+
+type loadedSet struct {
+	typ, family string
+	entries     []string
+}
+
+type countProvider struct {
+	calls   int
+	subnets map[string][]*net.IPNet
+}
+
+func (p *countProvider) Subnets(country string) ([]*net.IPNet, error) {
+	p.calls++
+	s, ok := p.subnets[country]
+	if !ok {
+		return nil, types.NotFoundErrorf("no subnets of country %s", country)
+	}
+	return s, nil
+}
+
+func TestLoader(t *testing.T) {
+	defer func(f func(string, string, string, []string) error) { loadIPSet = f }(loadIPSet)
+	sets := map[string]loadedSet{}
+	loadIPSet = func(name, typ, family string, entries []string) error {
+		sets[name] = loadedSet{typ: typ, family: family, entries: entries}
+		return nil
+	}
+	_, v4, _ := net.ParseCIDR("192.0.2.0/24")
+	_, v6, _ := net.ParseCIDR("2001:db8::/32")
+	p := &countProvider{subnets: map[string][]*net.IPNet{"US": {v4, v6}}}
+	l := NewLoader(p)
+
+	if err := l.Load("US"); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]loadedSet{
+		"lnet-geo4-us": {typ: "hash:net", family: "inet", entries: []string{"192.0.2.0/24"}},
+		"lnet-geo6-us": {typ: "hash:net", family: "inet6", entries: []string{"2001:db8::/32"}},
+	}
+	if !reflect.DeepEqual(sets, expected) {
+		t.Fatalf("unexpected sets %+v", sets)
+	}
+
+	// The sets of a country are loaded once
+	if err := l.Load("US"); err != nil {
+		t.Fatal(err)
+	}
+	if p.calls != 1 {
+		t.Fatalf("the subnets of the country got asked %d times", p.calls)
+	}
+
+	if err := l.Load("FR"); err == nil {
+		t.Fatal("unknown country loaded")
+	} else if _, ok := err.(types.NotFoundError); !ok {
+		t.Fatalf("unexpected error %v", err)
+	}
+	for _, code := range []string{"us", "USA", "U1", ""} {
+		if err := l.Load(code); err == nil {
+			t.Fatalf("invalid country code %q loaded", code)
+		}
+	}
+}
+
+func TestDirProvider(t *testing.T) {
+	dir, err := ioutil.TempDir("", "geoip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	zone := "# United States\n192.0.2.0/24\n\n2001:db8::/32\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, "us.zone"), []byte(zone), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "fr.zone"), []byte("192.0.2.0/24\nhost\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	p := NewDirProvider(dir)
+	subnets, err := p.Subnets("US")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(subnets) != 2 || subnets[0].String() != "192.0.2.0/24" || subnets[1].String() != "2001:db8::/32" {
+		t.Fatalf("unexpected subnets %v", subnets)
+	}
+	if _, err := p.Subnets("FR"); err == nil {
+		t.Fatal("invalid zone file accepted")
+	}
+	if _, err := p.Subnets("DE"); err == nil {
+		t.Fatal("missing zone file accepted")
+	} else if _, ok := err.(types.NotFoundError); !ok {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
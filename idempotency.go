@@ -0,0 +1,93 @@
+package libnetwork
+
+import (
+	"github.com/docker/libnetwork/netlabel"
+)
+
+// idempotentCall is a creation in progress under an idempotency key, the
+// retries with the key waiting for its outcome
+type idempotentCall struct {
+	done chan struct{}
+	id   string
+	err  error
+}
+
+// claimIdempotencyKey returns the ID of the object created under the key,
+// waiting for the creation in progress if any. When there is none, lookup
+// finding nothing either, the key is claimed and finish is to be called
+// with the outcome of the creation. A failed creation releases the key to
+// the next retry.
+func (c *controller) claimIdempotencyKey(key string, lookup func() string) (string, func(id string, err error)) {
+	for {
+		c.idempotencyMu.Lock()
+		if call, ok := c.idempotentCalls[key]; ok {
+			c.idempotencyMu.Unlock()
+			<-call.done
+			if call.err == nil {
+				return call.id, nil
+			}
+			continue
+		}
+		if id := lookup(); id != "" {
+			c.idempotencyMu.Unlock()
+			return id, nil
+		}
+		call := &idempotentCall{done: make(chan struct{})}
+		if c.idempotentCalls == nil {
+			c.idempotentCalls = map[string]*idempotentCall{}
+		}
+		c.idempotentCalls[key] = call
+		c.idempotencyMu.Unlock()
+
+		return "", func(id string, err error) {
+			call.id, call.err = id, err
+			// The created object carries the key from now on
+			c.idempotencyMu.Lock()
+			delete(c.idempotentCalls, key)
+			c.idempotencyMu.Unlock()
+			close(call.done)
+		}
+	}
+}
+
+// networkIdempotencyKey returns the idempotency key the options of the
+// network creation carry, if any
+func networkIdempotencyKey(options []NetworkOption) string {
+	probe := &network{generic: map[string]interface{}{netlabel.GenericData: make(map[string]string)}}
+	probe.processOptions(options...)
+	return probe.idempotencyKey
+}
+
+// endpointIdempotencyKey returns the idempotency key the options of the
+// endpoint creation carry, if any
+func endpointIdempotencyKey(options []EndpointOption) string {
+	probe := &endpoint{generic: make(map[string]interface{}), iface: &endpointInterface{}}
+	probe.processOptions(options...)
+	return probe.idempotencyKey
+}
+
+func (c *controller) networkIDByIdempotencyKey(key string) string {
+	for _, nw := range c.Networks() {
+		n := nw.(*network)
+		n.Lock()
+		match := n.idempotencyKey == key
+		n.Unlock()
+		if match {
+			return n.ID()
+		}
+	}
+	return ""
+}
+
+func (n *network) endpointIDByIdempotencyKey(key string) string {
+	for _, e := range n.Endpoints() {
+		ep := e.(*endpoint)
+		ep.Lock()
+		match := ep.idempotencyKey == key
+		ep.Unlock()
+		if match {
+			return ep.ID()
+		}
+	}
+	return ""
+}
@@ -0,0 +1,61 @@
+package libnetwork
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestClaimIdempotencyKey(t *testing.T) {
+	c := &controller{}
+	created := ""
+	lookup := func() string { return created }
+
+	prev, finish := c.claimIdempotencyKey("network/k1", lookup)
+	if prev != "" || finish == nil {
+		t.Fatalf("unexpected claim %q of a new key", prev)
+	}
+
+	// A retry in the middle of the creation waits for its outcome
+	got := make(chan string)
+	go func() {
+		id, _ := c.claimIdempotencyKey("network/k1", lookup)
+		got <- id
+	}()
+	select {
+	case id := <-got:
+		t.Fatalf("retry returned %q before the creation ended", id)
+	case <-time.After(50 * time.Millisecond):
+	}
+	created = "n1"
+	finish("n1", nil)
+	if id := <-got; id != "n1" {
+		t.Fatalf("retry returned %q, expected n1", id)
+	}
+	if id, _ := c.claimIdempotencyKey("network/k1", lookup); id != "n1" {
+		t.Fatalf("late retry returned %q, expected n1", id)
+	}
+
+	// A failed creation releases the key
+	prev, finish = c.claimIdempotencyKey("network/k2", func() string { return "" })
+	if prev != "" {
+		t.Fatalf("unexpected claim %q of a new key", prev)
+	}
+	finish("", errors.New("failed"))
+	if prev, finish = c.claimIdempotencyKey("network/k2", func() string { return "" }); prev != "" || finish == nil {
+		t.Fatalf("the key of a failed creation is not released: %q", prev)
+	}
+	finish("", errors.New("failed"))
+}
+
+func TestIdempotencyKeyOptions(t *testing.T) {
+	if k := networkIdempotencyKey([]NetworkOption{NetworkOptionInternalNetwork(), NetworkOptionIdempotencyKey("k1")}); k != "k1" {
+		t.Fatalf("unexpected network key %q", k)
+	}
+	if k := endpointIdempotencyKey([]EndpointOption{CreateOptionAnonymous()}); k != "" {
+		t.Fatalf("unexpected endpoint key %q", k)
+	}
+	if k := endpointIdempotencyKey([]EndpointOption{CreateOptionIdempotencyKey("k2"), CreateOptionDNS([]string{"8.8.8.8"})}); k != "k2" {
+		t.Fatalf("unexpected endpoint key %q", k)
+	}
+}
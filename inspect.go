@@ -0,0 +1,253 @@
+package libnetwork
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/docker/libnetwork/datastore"
+	"github.com/docker/libnetwork/diagnostic"
+	"github.com/docker/libnetwork/internal/caller"
+	"github.com/sirupsen/logrus"
+)
+
+// inspectPaths2Func are the diagnostic handlers exposing the controller
+// state, they reply JSON unless text is requested
+var inspectPaths2Func = map[string]diagnostic.HTTPHandlerFunc{
+	"/networks":  inspectDiag(inspectNetworks),
+	"/endpoints": inspectDiag(inspectEndpoints),
+	"/sandboxes": inspectDiag(inspectSandboxes),
+	"/ipam":      inspectDiag(inspectIpam),
+	"/iptables":  inspectDiag(inspectIptables),
+	"/ipvs":      inspectDiag(inspectIpvs),
+	"/sockets":   inspectDiag(inspectSockets),
+}
+
+// inspectResult is the diagnostic output of an inspection, its text form
+// being its indented JSON form
+type inspectResult struct {
+	v interface{}
+}
+
+func (r *inspectResult) MarshalJSON() ([]byte, error) {
+	return json.Marshal(r.v)
+}
+
+func (r *inspectResult) String() string {
+	b, err := json.MarshalIndent(r.v, "", "  ")
+	if err != nil {
+		return err.Error()
+	}
+	return string(b)
+}
+
+func inspectDiag(fn func(c *controller, r *http.Request) (interface{}, error)) diagnostic.HTTPHandlerFunc {
+	return func(ctx interface{}, w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		diagnostic.DebugHTTPForm(r)
+		_, json := diagnostic.ParseHTTPFormJSONOptions(r)
+
+		// audit logs
+		log := logrus.WithFields(logrus.Fields{"component": "diagnostic", "remoteIP": r.RemoteAddr, "method": caller.Name(0), "url": r.URL.String()})
+		log.Info("inspect")
+
+		c, ok := ctx.(*controller)
+		if !ok {
+			diagnostic.HTTPReply(w, diagnostic.FailCommand(fmt.Errorf("controller not available")), json)
+			return
+		}
+		v, err := fn(c, r)
+		if err != nil {
+			log.WithError(err).Error("inspect failed")
+			diagnostic.HTTPReply(w, diagnostic.FailCommand(err), json)
+			return
+		}
+		log.Info("inspect done")
+		diagnostic.HTTPReply(w, diagnostic.CommandSucceed(&inspectResult{v: v}), json)
+	}
+}
+
+type networkInspect struct {
+	ID            string            `json:"id"`
+	Name          string            `json:"name"`
+	Type          string            `json:"type"`
+	Scope         string            `json:"scope"`
+	Internal      bool              `json:"internal,omitempty"`
+	IPv6          bool              `json:"ipv6,omitempty"`
+	DriverOptions map[string]string `json:"driver_options,omitempty"`
+	Endpoints     int               `json:"endpoints"`
+	Degraded      bool              `json:"degraded,omitempty"`
+}
+
+type endpointInspect struct {
+	ID          string                 `json:"id"`
+	Name        string                 `json:"name"`
+	NetworkID   string                 `json:"network_id"`
+	NetworkName string                 `json:"network_name"`
+	SandboxID   string                 `json:"sandbox_id,omitempty"`
+	Address     string                 `json:"address,omitempty"`
+	AddressIPv6 string                 `json:"address_ipv6,omitempty"`
+	MacAddress  string                 `json:"mac_address,omitempty"`
+	Locator     string                 `json:"locator,omitempty"`
+	DriverInfo  map[string]interface{} `json:"driver_info,omitempty"`
+	DriverError string                 `json:"driver_error,omitempty"`
+}
+
+type sandboxInspect struct {
+	ID          string   `json:"id"`
+	ContainerID string   `json:"container_id"`
+	Key         string   `json:"key"`
+	Endpoints   []string `json:"endpoints"`
+}
+
+type ipamInspect struct {
+	NetworkID   string      `json:"network_id"`
+	NetworkName string      `json:"network_name"`
+	Driver      string      `json:"driver"`
+	IPv4        []*IpamInfo `json:"ipv4,omitempty"`
+	IPv6        []*IpamInfo `json:"ipv6,omitempty"`
+	// The allocation state of the drivers which can dump it
+	Database string `json:"database,omitempty"`
+}
+
+// ipamDumper is implemented by the ipam drivers which can dump their state
+type ipamDumper interface {
+	DumpDatabase() string
+}
+
+// inspectedNetworks returns the networks, or the one the "nid" form value
+// names or identifies
+func (c *controller) inspectedNetworks(r *http.Request) ([]*network, error) {
+	nws, err := c.getNetworksFromStore()
+	if err != nil {
+		return nil, err
+	}
+	nid := r.FormValue("nid")
+	var list []*network
+	for _, n := range nws {
+		if nid == "" || n.ID() == nid || n.Name() == nid {
+			list = append(list, n)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name() < list[j].Name() })
+	return list, nil
+}
+
+func inspectNetworks(c *controller, r *http.Request) (interface{}, error) {
+	list := []networkInspect{}
+	nws, err := c.inspectedNetworks(r)
+	if err != nil {
+		return nil, err
+	}
+	for _, n := range nws {
+		list = append(list, networkInspect{
+			ID:            n.ID(),
+			Name:          n.Name(),
+			Type:          n.Type(),
+			Scope:         n.Scope(),
+			Internal:      n.Internal(),
+			IPv6:          n.IPv6Enabled(),
+			DriverOptions: n.DriverOptions(),
+			Endpoints:     len(n.Endpoints()),
+			Degraded:      n.Degraded(),
+		})
+	}
+	return list, nil
+}
+
+// inspectEndpoints lists the endpoints of the networks, with the operational
+// data of their driver when the "oper" form value is set
+func inspectEndpoints(c *controller, r *http.Request) (interface{}, error) {
+	_, oper := r.Form["oper"]
+	list := []endpointInspect{}
+	nws, err := c.inspectedNetworks(r)
+	if err != nil {
+		return nil, err
+	}
+	for _, n := range nws {
+		for _, e := range n.Endpoints() {
+			ep := e.(*endpoint)
+			ei := endpointInspect{
+				ID:          ep.ID(),
+				Name:        ep.Name(),
+				NetworkID:   n.ID(),
+				NetworkName: n.Name(),
+				SandboxID:   ep.sandboxID,
+				Locator:     ep.locator,
+			}
+			if iface := ep.Iface(); iface != nil {
+				if iface.Address() != nil {
+					ei.Address = iface.Address().String()
+				}
+				if iface.AddressIPv6() != nil {
+					ei.AddressIPv6 = iface.AddressIPv6().String()
+				}
+				if iface.MacAddress() != nil {
+					ei.MacAddress = iface.MacAddress().String()
+				}
+			}
+			if oper && c.operInfoLocal(n, ep) {
+				info, err := ep.DriverInfo()
+				if err != nil {
+					ei.DriverError = err.Error()
+				}
+				ei.DriverInfo = info
+			}
+			list = append(list, ei)
+		}
+	}
+	return list, nil
+}
+
+// operInfoLocal tells whether the driver holds the operational data of the
+// endpoint on this host, the remote endpoints of the global networks having
+// none here
+func (c *controller) operInfoLocal(n *network, ep *endpoint) bool {
+	return n.Scope() == datastore.LocalScope || ep.locator == "" || ep.locator == c.clusterHostID()
+}
+
+func inspectSandboxes(c *controller, r *http.Request) (interface{}, error) {
+	c.Lock()
+	sandboxes := make([]*sandbox, 0, len(c.sandboxes))
+	for _, sb := range c.sandboxes {
+		sandboxes = append(sandboxes, sb)
+	}
+	c.Unlock()
+
+	list := []sandboxInspect{}
+	for _, sb := range sandboxes {
+		si := sandboxInspect{ID: sb.ID(), ContainerID: sb.ContainerID(), Key: sb.Key(), Endpoints: []string{}}
+		for _, ep := range sb.getConnectedEndpoints() {
+			si.Endpoints = append(si.Endpoints, ep.ID())
+		}
+		list = append(list, si)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list, nil
+}
+
+func inspectIpam(c *controller, r *http.Request) (interface{}, error) {
+	list := []ipamInspect{}
+	dumped := map[string]bool{}
+	nws, err := c.inspectedNetworks(r)
+	if err != nil {
+		return nil, err
+	}
+	for _, n := range nws {
+		driver, _, _, _ := n.IpamConfig()
+		ii := ipamInspect{NetworkID: n.ID(), NetworkName: n.Name(), Driver: driver}
+		ii.IPv4, ii.IPv6 = n.IpamInfo()
+		// The state of a driver covers all its networks
+		if !dumped[driver] {
+			if ipam, _, err := c.getIPAMDriver(driver); err == nil {
+				if d, ok := ipam.(ipamDumper); ok {
+					ii.Database = d.DumpDatabase()
+					dumped[driver] = true
+				}
+			}
+		}
+		list = append(list, ii)
+	}
+	return list, nil
+}
@@ -0,0 +1,204 @@
+package libnetwork
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/docker/libnetwork/iptables"
+	"github.com/docker/libnetwork/ipvs"
+)
+
+var inspectedTables = []string{"filter", "nat", "mangle"}
+
+type iptablesInspect struct {
+	// Namespace is "host" or the ID of the sandbox the rules are programmed in
+	Namespace string              `json:"namespace"`
+	Rules     map[string][]string `json:"rules"`
+}
+
+type ipvsServiceInspect struct {
+	Address      string                   `json:"address,omitempty"`
+	Protocol     uint16                   `json:"protocol,omitempty"`
+	Port         uint16                   `json:"port,omitempty"`
+	FWMark       uint32                   `json:"fwmark,omitempty"`
+	SchedName    string                   `json:"scheduler"`
+	Destinations []ipvsDestinationInspect `json:"destinations"`
+}
+
+type ipvsDestinationInspect struct {
+	Address             string `json:"address"`
+	Port                uint16 `json:"port,omitempty"`
+	Weight              int    `json:"weight"`
+	ActiveConnections   int    `json:"active_connections"`
+	InactiveConnections int    `json:"inactive_connections"`
+}
+
+type ipvsInspect struct {
+	NetworkID string               `json:"network_id"`
+	SandboxID string               `json:"sandbox_id"`
+	Services  []ipvsServiceInspect `json:"services"`
+	Error     string               `json:"error,omitempty"`
+}
+
+func dumpIptables(filter []string) map[string][]string {
+	rules := map[string][]string{}
+	for _, table := range inspectedTables {
+		out, err := iptables.Raw("-t", table, "-S")
+		if err != nil {
+			rules[table] = []string{"error: " + err.Error()}
+			continue
+		}
+		for _, l := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+			if l == "" || !matchesAny(l, filter) {
+				continue
+			}
+			rules[table] = append(rules[table], l)
+		}
+	}
+	return rules
+}
+
+func matchesAny(l string, filter []string) bool {
+	if len(filter) == 0 {
+		return true
+	}
+	for _, f := range filter {
+		if strings.Contains(l, f) {
+			return true
+		}
+	}
+	return false
+}
+
+// inspectIptables dumps the host rules, or, when the "eid" form value names
+// an endpoint, the host rules matching its addresses or interface and the
+// rules programmed in its sandbox
+func inspectIptables(c *controller, r *http.Request) (interface{}, error) {
+	eid := r.FormValue("eid")
+	if eid == "" {
+		return []iptablesInspect{{Namespace: "host", Rules: dumpIptables(nil)}}, nil
+	}
+
+	ep, err := c.localEndpoint(eid)
+	if err != nil {
+		return nil, err
+	}
+	var filter []string
+	if iface := ep.Iface(); iface != nil {
+		if iface.Address() != nil {
+			filter = append(filter, iface.Address().IP.String())
+		}
+		if iface.AddressIPv6() != nil {
+			filter = append(filter, iface.AddressIPv6().IP.String())
+		}
+		if ep.iface.srcName != "" {
+			filter = append(filter, ep.iface.srcName)
+		}
+	}
+	list := []iptablesInspect{{Namespace: "host", Rules: dumpIptables(filter)}}
+
+	if sb, ok := ep.getSandbox(); ok && sb.osSbox != nil {
+		var rules map[string][]string
+		if err := sb.osSbox.InvokeFunc(func() {
+			rules = dumpIptables(nil)
+		}); err != nil {
+			return nil, fmt.Errorf("failed to dump the rules of sandbox %.7s: %v", sb.ID(), err)
+		}
+		list = append(list, iptablesInspect{Namespace: sb.ID(), Rules: rules})
+	}
+	return list, nil
+}
+
+// localEndpoint finds the endpoint, of a network of the controller, with the
+// given ID or name
+func (c *controller) localEndpoint(eid string) (*endpoint, error) {
+	nws, err := c.getNetworksFromStore()
+	if err != nil {
+		return nil, err
+	}
+	for _, n := range nws {
+		for _, e := range n.Endpoints() {
+			if e.ID() == eid || e.Name() == eid {
+				return e.(*endpoint), nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("endpoint %s not found", eid)
+}
+
+// inspectIpvs dumps the services and destinations of the load balancers
+func inspectIpvs(c *controller, r *http.Request) (interface{}, error) {
+	c.Lock()
+	services := make([]*service, 0, len(c.serviceBindings))
+	for _, s := range c.serviceBindings {
+		services = append(services, s)
+	}
+	c.Unlock()
+
+	nids := map[string]bool{}
+	for _, s := range services {
+		s.Lock()
+		for nid := range s.loadBalancers {
+			nids[nid] = true
+		}
+		s.Unlock()
+	}
+
+	list := []ipvsInspect{}
+	for nid := range nids {
+		n, err := c.NetworkByID(nid)
+		if err != nil {
+			continue
+		}
+		_, sb, err := n.(*network).findLBEndpointSandbox()
+		if err != nil || sb.osSbox == nil {
+			continue
+		}
+		list = append(list, dumpIpvs(nid, sb))
+	}
+	return list, nil
+}
+
+func dumpIpvs(nid string, sb *sandbox) ipvsInspect {
+	ii := ipvsInspect{NetworkID: nid, SandboxID: sb.ID(), Services: []ipvsServiceInspect{}}
+	i, err := ipvs.New(sb.Key())
+	if err != nil {
+		ii.Error = err.Error()
+		return ii
+	}
+	defer i.Close()
+
+	svcs, err := i.GetServices()
+	if err != nil {
+		ii.Error = err.Error()
+		return ii
+	}
+	for _, s := range svcs {
+		si := ipvsServiceInspect{
+			Protocol:     s.Protocol,
+			Port:         s.Port,
+			FWMark:       s.FWMark,
+			SchedName:    s.SchedName,
+			Destinations: []ipvsDestinationInspect{},
+		}
+		if s.Address != nil {
+			si.Address = s.Address.String()
+		}
+		dsts, err := i.GetDestinations(s)
+		if err != nil {
+			ii.Error = err.Error()
+		}
+		for _, d := range dsts {
+			si.Destinations = append(si.Destinations, ipvsDestinationInspect{
+				Address:             d.Address.String(),
+				Port:                d.Port,
+				Weight:              d.Weight,
+				ActiveConnections:   d.ActiveConnections,
+				InactiveConnections: d.InactiveConnections,
+			})
+		}
+		ii.Services = append(ii.Services, si)
+	}
+	return ii
+}
@@ -0,0 +1,115 @@
+package libnetwork
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/docker/libnetwork/config"
+	"github.com/docker/libnetwork/datastore"
+	"github.com/docker/libnetwork/driverapi"
+)
+
+var inspectDriverName = "inspected network driver"
+
+// inspectDriver creates the endpoints and reports the operational data of
+// each of them
+type inspectDriver struct {
+	deletableDriver
+}
+
+func (d *inspectDriver) Type() string {
+	return inspectDriverName
+}
+
+func (d *inspectDriver) CreateEndpoint(nid, eid string, ifInfo driverapi.InterfaceInfo, options map[string]interface{}) error {
+	return nil
+}
+
+func (d *inspectDriver) EndpointOperInfo(nid, eid string) (map[string]interface{}, error) {
+	return map[string]interface{}{"veth": "veth" + eid[:7]}, nil
+}
+
+func TestOperInfoLocal(t *testing.T) {
+	c := &controller{cfg: &config.Config{Cluster: config.ClusterCfg{Address: "10.0.0.1:2377"}}}
+	local := &network{scope: datastore.LocalScope}
+	global := &network{scope: datastore.GlobalScope}
+
+	for _, tc := range []struct {
+		n       *network
+		locator string
+		local   bool
+	}{
+		{local, "", true},
+		{local, "10.0.0.2", true},
+		{global, "", true},
+		{global, "10.0.0.1", true},
+		{global, "10.0.0.2", false},
+	} {
+		if c.operInfoLocal(tc.n, &endpoint{locator: tc.locator}) != tc.local {
+			t.Fatalf("endpoint located on %q of a %s network: expected %t", tc.locator, tc.n.scope, tc.local)
+		}
+	}
+}
+
+func TestInspectEndpoints(t *testing.T) {
+	cfgOptions, err := OptionBoltdbWithRandomDBFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	nc, err := New(cfgOptions...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nc.Stop()
+	c := nc.(*controller)
+	err = c.drvRegistry.AddDriver(inspectDriverName, func(reg driverapi.DriverCallback, opt map[string]interface{}) error {
+		return reg.RegisterDriver(inspectDriverName, &inspectDriver{}, driverapi.Capability{DataScope: datastore.LocalScope})
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := c.NewNetwork(inspectDriverName, "inspected", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ep, err := n.CreateEndpoint("ep1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reply := func(query string) string {
+		w := httptest.NewRecorder()
+		inspectPaths2Func["/endpoints"](c, w, httptest.NewRequest("GET", "/endpoints?"+query, nil))
+		return w.Body.String()
+	}
+	endpoints := func(query string) []endpointInspect {
+		var res struct {
+			Details []endpointInspect `json:"details"`
+		}
+		if err := json.Unmarshal([]byte(reply(query)), &res); err != nil {
+			t.Fatalf("invalid reply to %q: %v", query, err)
+		}
+		return res.Details
+	}
+
+	list := endpoints("nid=inspected")
+	if len(list) != 1 || list[0].ID != ep.ID() || list[0].NetworkName != "inspected" || list[0].Address == "" {
+		t.Fatalf("unexpected endpoints %+v", list)
+	}
+	if list[0].DriverInfo != nil {
+		t.Fatalf("operational data %v listed without oper", list[0].DriverInfo)
+	}
+	if list = endpoints("nid=inspected&oper"); len(list) != 1 || list[0].DriverInfo["veth"] != "veth"+ep.ID()[:7] {
+		t.Fatalf("unexpected operational data of the endpoints %+v", list)
+	}
+	if list = endpoints("nid=other"); len(list) != 0 {
+		t.Fatalf("endpoints %+v of another network listed", list)
+	}
+
+	// The text reply is the indented JSON
+	if out := reply("nid=inspected&text"); !strings.Contains(out, "\n    \"name\": \"ep1\",") {
+		t.Fatalf("unexpected text reply:\n%s", out)
+	}
+}
@@ -0,0 +1,17 @@
+// +build !linux
+
+package libnetwork
+
+import (
+	"net/http"
+
+	"github.com/docker/libnetwork/types"
+)
+
+func inspectIptables(c *controller, r *http.Request) (interface{}, error) {
+	return nil, types.NotImplementedErrorf("iptables are not supported on this platform")
+}
+
+func inspectIpvs(c *controller, r *http.Request) (interface{}, error) {
+	return nil, types.NotImplementedErrorf("ipvs is not supported on this platform")
+}
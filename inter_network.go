@@ -0,0 +1,123 @@
+package libnetwork
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/docker/libnetwork/config"
+	"github.com/docker/libnetwork/datastore"
+	"github.com/docker/libnetwork/driverapi"
+	"github.com/docker/libnetwork/types"
+	"github.com/sirupsen/logrus"
+)
+
+func validateInterNetworkPolicies(policies []config.InterNetworkPolicy) error {
+	for _, p := range policies {
+		if p.From == "" || p.To == "" {
+			return types.BadRequestErrorf("inter-network policy %+v misses a network", p)
+		}
+		if p.From == p.To {
+			return types.BadRequestErrorf("inter-network policy %+v is between a network and itself", p)
+		}
+		if p.Action != "allow" && p.Action != "deny" {
+			return types.BadRequestErrorf("invalid action %q of inter-network policy %+v: expected allow or deny", p.Action, p)
+		}
+	}
+	return nil
+}
+
+// SetInterNetworkPolicies replaces the policies of the traffic between the
+// local networks and renders them through the drivers implementing
+// driverapi.InterNetworkRouter. The policies naming a network which does
+// not exist yet apply once it is created; the traffic the policies do not
+// cover is left to the drivers.
+func (c *controller) SetInterNetworkPolicies(policies []config.InterNetworkPolicy) error {
+	if err := validateInterNetworkPolicies(policies); err != nil {
+		return err
+	}
+	c.interNetworkMu.Lock()
+	defer c.interNetworkMu.Unlock()
+	c.interNetworkPolicies = append([]config.InterNetworkPolicy(nil), policies...)
+	return c.renderInterNetworkPolicies()
+}
+
+// InterNetworkPolicies returns the policies of the traffic between the
+// local networks
+func (c *controller) InterNetworkPolicies() []config.InterNetworkPolicy {
+	c.interNetworkMu.Lock()
+	defer c.interNetworkMu.Unlock()
+	return append([]config.InterNetworkPolicy(nil), c.interNetworkPolicies...)
+}
+
+// applyInterNetworkPolicies renders the policies again once a network got
+// created or deleted, the failures being logged only
+func (c *controller) applyInterNetworkPolicies() {
+	c.interNetworkMu.Lock()
+	defer c.interNetworkMu.Unlock()
+	if len(c.interNetworkPolicies) == 0 {
+		return
+	}
+	if err := c.renderInterNetworkPolicies(); err != nil {
+		logrus.Warnf("Failed to apply the inter-network policies: %v", err)
+	}
+}
+
+// renderInterNetworkPolicies resolves the networks of the policies and
+// passes the resulting rules to the drivers. It is called with the
+// interNetworkMu held.
+func (c *controller) renderInterNetworkPolicies() error {
+	var rules []driverapi.InterNetworkRule
+	for _, p := range c.interNetworkPolicies {
+		from, ok := c.interNetworkEnd(p.From)
+		if !ok {
+			continue
+		}
+		to, ok := c.interNetworkEnd(p.To)
+		if !ok {
+			continue
+		}
+		rules = append(rules, driverapi.InterNetworkRule{
+			From:          from,
+			To:            to,
+			Deny:          p.Action == "deny",
+			Bidirectional: p.Bidirectional,
+		})
+	}
+
+	var errs []string
+	c.drvRegistry.WalkDrivers(func(name string, driver driverapi.Driver, capability driverapi.Capability) bool {
+		r, ok := driver.(driverapi.InterNetworkRouter)
+		if !ok {
+			return false
+		}
+		if err := r.SetInterNetworkRules(rules); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", name, err))
+		}
+		return false
+	})
+	if len(errs) > 0 {
+		return fmt.Errorf("failed to set the inter-network rules of the drivers: %v", errs)
+	}
+	return nil
+}
+
+// interNetworkEnd resolves the local network by name, then by ID
+func (c *controller) interNetworkEnd(nameOrID string) (driverapi.InterNetworkEnd, bool) {
+	nw, err := c.NetworkByName(nameOrID)
+	if err != nil {
+		if nw, err = c.NetworkByID(nameOrID); err != nil {
+			return driverapi.InterNetworkEnd{}, false
+		}
+	}
+	n := nw.(*network)
+	if n.ConfigOnly() || n.Scope() != datastore.LocalScope {
+		return driverapi.InterNetworkEnd{}, false
+	}
+	end := driverapi.InterNetworkEnd{NetworkID: n.ID()}
+	for _, d := range n.getIPInfo(4) {
+		if d.Pool != nil {
+			end.Subnets = append(end.Subnets, &net.IPNet{IP: d.Pool.IP, Mask: d.Pool.Mask})
+		}
+	}
+	return end, true
+}
@@ -0,0 +1,208 @@
+// Package faults injects failures and delays in the programming of the
+// network, to test how the daemon and the orchestrators above it cope with
+// partial failures. The injection is off until Enable is called, the
+// points then failing, dropping or delaying the operations as set.
+package faults
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Point is an operation the faults are injected in
+type Point string
+
+const (
+	// Iptables is the run of an iptables command
+	Iptables Point = "iptables"
+	// Netlink is the programming of the interfaces, routes and neighbors of
+	// the sandboxes through netlink
+	Netlink Point = "netlink"
+	// Datastore is the write of an object to the datastore
+	Datastore Point = "datastore"
+)
+
+var points = []Point{Datastore, Iptables, Netlink}
+
+// Fault is the faults injected in the operations of a point
+type Fault struct {
+	// Fail is the percentage of the operations failing
+	Fail int
+	// Drop is the percentage of the operations skipped as if they succeeded
+	Drop int
+	// Delay is the time the operations wait for
+	Delay time.Duration
+}
+
+func (f Fault) String() string {
+	return fmt.Sprintf("fail:%d%%,drop:%d%%,delay:%s", f.Fail, f.Drop, f.Delay)
+}
+
+// ErrDropped is returned by Inject for the operation to be skipped
+var ErrDropped = errors.New("operation dropped by the fault injection")
+
+// InjectedError is the failure of an operation injected at a point
+type InjectedError Point
+
+func (e InjectedError) Error() string {
+	return fmt.Sprintf("injected %s failure", string(e))
+}
+
+var (
+	enabled int32
+	mu      sync.RWMutex
+	faults  = map[Point]Fault{}
+)
+
+// Enable turns the fault injection on, the next Set calls being refused
+// until then
+func Enable() {
+	atomic.StoreInt32(&enabled, 1)
+}
+
+// Enabled tells whether the fault injection is on
+func Enabled() bool {
+	return atomic.LoadInt32(&enabled) == 1
+}
+
+// Set sets the faults injected at the point, the zero Fault injecting none
+func Set(p Point, f Fault) error {
+	if !Enabled() {
+		return errors.New("the fault injection is not enabled")
+	}
+	if !validPoint(p) {
+		return fmt.Errorf("unknown fault injection point %q", p)
+	}
+	if f.Fail < 0 || f.Drop < 0 || f.Fail+f.Drop > 100 || f.Delay < 0 {
+		return fmt.Errorf("invalid %s faults %s", p, f)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if f == (Fault{}) {
+		delete(faults, p)
+	} else {
+		faults[p] = f
+	}
+	return nil
+}
+
+// Faults returns the faults injected at the points which have some
+func Faults() map[Point]Fault {
+	mu.RLock()
+	defer mu.RUnlock()
+	r := make(map[Point]Fault, len(faults))
+	for p, f := range faults {
+		r[p] = f
+	}
+	return r
+}
+
+// Configure enables the fault injection with the faults of the spec, a
+// list of point=key:value,... separated by semicolons, e.g.
+// "iptables=fail:10%;netlink=delay:200ms;datastore=drop:5%"
+func Configure(spec string) error {
+	parsed, err := Parse(spec)
+	if err != nil {
+		return err
+	}
+	Enable()
+	for p, f := range parsed {
+		if err := Set(p, f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Parse parses a spec of faults as of Configure
+func Parse(spec string) (map[Point]Fault, error) {
+	parsed := map[Point]Fault{}
+	for _, entry := range strings.Split(spec, ";") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		kv := strings.SplitN(entry, "=", 2)
+		p := Point(strings.TrimSpace(kv[0]))
+		if !validPoint(p) || len(kv) != 2 {
+			return nil, fmt.Errorf("invalid fault injection entry %q", entry)
+		}
+		f := parsed[p]
+		for _, setting := range strings.Split(kv[1], ",") {
+			s := strings.SplitN(strings.TrimSpace(setting), ":", 2)
+			if len(s) != 2 {
+				return nil, fmt.Errorf("invalid fault %q of %s", setting, p)
+			}
+			var err error
+			switch s[0] {
+			case "fail":
+				f.Fail, err = parsePercent(s[1])
+			case "drop":
+				f.Drop, err = parsePercent(s[1])
+			case "delay":
+				f.Delay, err = time.ParseDuration(s[1])
+			default:
+				err = fmt.Errorf("unknown fault %q", s[0])
+			}
+			if err != nil {
+				return nil, fmt.Errorf("invalid fault %q of %s: %v", setting, p, err)
+			}
+		}
+		parsed[p] = f
+	}
+	return parsed, nil
+}
+
+func parsePercent(s string) (int, error) {
+	return strconv.Atoi(strings.TrimSuffix(s, "%"))
+}
+
+// Points returns the names of the fault injection points, sorted
+func Points() []string {
+	names := make([]string, 0, len(points))
+	for _, p := range points {
+		names = append(names, string(p))
+	}
+	sort.Strings(names)
+	return names
+}
+
+func validPoint(p Point) bool {
+	for _, v := range points {
+		if p == v {
+			return true
+		}
+	}
+	return false
+}
+
+// Inject injects the faults set at the point in the operation about to
+// run. It waits for the delay, then returns an InjectedError for the
+// operation to fail with, ErrDropped for it to be skipped, or nil.
+func Inject(p Point) error {
+	if !Enabled() {
+		return nil
+	}
+	mu.RLock()
+	f, ok := faults[p]
+	mu.RUnlock()
+	if !ok {
+		return nil
+	}
+	if f.Delay > 0 {
+		time.Sleep(f.Delay)
+	}
+	switch n := rand.Intn(100); {
+	case n < f.Fail:
+		return InjectedError(p)
+	case n < f.Fail+f.Drop:
+		return ErrDropped
+	}
+	return nil
+}
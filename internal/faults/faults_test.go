@@ -0,0 +1,68 @@
+package faults
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	parsed, err := Parse("iptables=fail:10%,delay:5ms; datastore=drop:5;")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f := parsed[Iptables]; f != (Fault{Fail: 10, Delay: 5 * time.Millisecond}) {
+		t.Fatalf("unexpected iptables faults %s", f)
+	}
+	if f := parsed[Datastore]; f != (Fault{Drop: 5}) {
+		t.Fatalf("unexpected datastore faults %s", f)
+	}
+	if _, ok := parsed[Netlink]; ok || len(parsed) != 2 {
+		t.Fatalf("unexpected faults %v", parsed)
+	}
+
+	for _, spec := range []string{"ethtool=fail:1", "iptables", "iptables=fail", "iptables=explode:1", "netlink=delay:soon", "netlink=fail:x%"} {
+		if _, err := Parse(spec); err == nil {
+			t.Errorf("expected an error for %q", spec)
+		}
+	}
+}
+
+func TestInject(t *testing.T) {
+	defer func() {
+		enabled = 0
+		faults = map[Point]Fault{}
+	}()
+
+	if err := Set(Iptables, Fault{Fail: 100}); err == nil {
+		t.Fatal("expected the faults to be refused before the injection is enabled")
+	}
+	if err := Inject(Iptables); err != nil {
+		t.Fatalf("expected no fault injected while disabled, got %v", err)
+	}
+
+	if err := Configure("iptables=fail:100%;datastore=drop:100%"); err != nil {
+		t.Fatal(err)
+	}
+	if err := Inject(Iptables); err != InjectedError(Iptables) {
+		t.Fatalf("expected an injected iptables failure, got %v", err)
+	}
+	if err := Inject(Datastore); err != ErrDropped {
+		t.Fatalf("expected a dropped datastore write, got %v", err)
+	}
+	if err := Inject(Netlink); err != nil {
+		t.Fatalf("expected no netlink fault, got %v", err)
+	}
+
+	if err := Set(Netlink, Fault{Fail: 60, Drop: 50}); err == nil {
+		t.Fatal("expected an error for faults past 100%")
+	}
+	if err := Set(Iptables, Fault{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := Inject(Iptables); err != nil {
+		t.Fatalf("expected the iptables faults to be cleared, got %v", err)
+	}
+	if f := Faults(); len(f) != 1 || f[Datastore].Drop != 100 {
+		t.Fatalf("unexpected faults %v", f)
+	}
+}
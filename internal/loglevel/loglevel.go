@@ -0,0 +1,184 @@
+// Package loglevel controls the log level of the subsystems of libnetwork
+// apart from the level of the standard logger, e.g. to debug iptables
+// without the gossip of networkdb.
+package loglevel
+
+import (
+	"fmt"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Field is the field of the entries which names their subsystem, the
+// entries without it going to the subsystem of the function logging them
+const Field = "subsystem"
+
+// subsystems maps the subsystems to the patterns of the names of the
+// functions logging for them
+var subsystems = map[string][]string{
+	"iptables":  {"docker/libnetwork/iptables."},
+	"overlay":   {"docker/libnetwork/drivers/overlay.", "docker/libnetwork/drivers/overlay/"},
+	"networkdb": {"docker/libnetwork/networkdb."},
+	"resolver":  {"docker/libnetwork.(*resolver)."},
+}
+
+// Subsystems returns the names of the subsystems, sorted
+func Subsystems() []string {
+	names := make([]string, 0, len(subsystems))
+	for name := range subsystems {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// control raises the level of its logger to the most verbose level of the
+// subsystems and drops the entries past the level of their subsystem, or
+// past the level the logger had for the entries of no subsystem
+type control struct {
+	sync.RWMutex
+	logger *logrus.Logger
+	next   logrus.Formatter
+	// base is the level of the logger before it got raised
+	base   logrus.Level
+	raised logrus.Level
+	levels map[string]logrus.Level
+}
+
+var std = &control{logger: logrus.StandardLogger()}
+
+// Set sets the log level of the subsystem, the empty level putting it back
+// to the level of the standard logger
+func Set(subsystem, level string) error {
+	return std.set(subsystem, level)
+}
+
+// Levels returns the levels of the subsystems which have one and the level
+// of the standard logger the others get
+func Levels() (map[string]string, string) {
+	return std.get()
+}
+
+func (c *control) set(subsystem, level string) error {
+	if _, ok := subsystems[subsystem]; !ok {
+		return fmt.Errorf("unknown subsystem %q: expected one of %s", subsystem, strings.Join(Subsystems(), ", "))
+	}
+	var lvl logrus.Level
+	if level != "" {
+		var err error
+		if lvl, err = logrus.ParseLevel(level); err != nil {
+			return err
+		}
+	}
+
+	c.Lock()
+	defer c.Unlock()
+	if f, ok := c.logger.Formatter.(*filter); !ok || f.c != c {
+		// Not installed yet, or the formatter got replaced since
+		c.next = c.logger.Formatter
+		c.base = c.level()
+		c.logger.Formatter = &filter{c: c}
+	} else if c.level() != c.raised {
+		// The level of the logger got set since
+		c.base = c.level()
+	}
+
+	if c.levels == nil {
+		c.levels = map[string]logrus.Level{}
+	}
+	if level == "" {
+		delete(c.levels, subsystem)
+	} else {
+		c.levels[subsystem] = lvl
+	}
+
+	if len(c.levels) == 0 {
+		c.logger.Formatter = c.next
+		c.logger.SetLevel(c.base)
+		return nil
+	}
+	c.raised = c.base
+	for _, l := range c.levels {
+		if l > c.raised {
+			c.raised = l
+		}
+	}
+	c.logger.SetLevel(c.raised)
+	return nil
+}
+
+func (c *control) get() (map[string]string, string) {
+	c.RLock()
+	defer c.RUnlock()
+	base := c.level()
+	if len(c.levels) > 0 {
+		base = c.base
+	}
+	levels := make(map[string]string, len(c.levels))
+	for s, l := range c.levels {
+		levels[s] = l.String()
+	}
+	return levels, base.String()
+}
+
+func (c *control) level() logrus.Level {
+	return logrus.Level(atomic.LoadUint32((*uint32)(&c.logger.Level)))
+}
+
+// filter is the formatter of the controlled logger, formatting the entries
+// it does not drop through the formatter it replaced. The hooks of the
+// logger still see all the entries of the raised level.
+type filter struct {
+	c *control
+}
+
+func (f *filter) Format(entry *logrus.Entry) ([]byte, error) {
+	c := f.c
+	subsystem := subsystemOf(entry)
+	c.RLock()
+	next := c.next
+	level, ok := c.levels[subsystem]
+	if !ok {
+		level = c.base
+	}
+	c.RUnlock()
+	if entry.Level > level || next == nil {
+		return nil, nil
+	}
+	return next.Format(entry)
+}
+
+// subsystemOf returns the subsystem of the entry, from its field or from
+// the first function out of logrus in the stack
+func subsystemOf(entry *logrus.Entry) string {
+	if s, ok := entry.Data[Field].(string); ok {
+		return s
+	}
+	pcs := make([]uintptr, 16)
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(3, pcs)])
+	for {
+		frame, more := frames.Next()
+		if !strings.Contains(frame.Function, "sirupsen/logrus.") {
+			return subsystemOfFunc(frame.Function)
+		}
+		if !more {
+			return ""
+		}
+	}
+}
+
+func subsystemOfFunc(name string) string {
+	for s, patterns := range subsystems {
+		for _, p := range patterns {
+			if strings.Contains(name, p) {
+				return s
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,93 @@
+package loglevel
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestSubsystemOfFunc(t *testing.T) {
+	for name, expected := range map[string]string{
+		"github.com/docker/libnetwork/iptables.ProgramChain":                           "iptables",
+		"github.com/docker/docker/vendor/github.com/docker/libnetwork/iptables.Raw":    "iptables",
+		"github.com/docker/libnetwork/drivers/overlay.(*driver).peerAdd":               "overlay",
+		"github.com/docker/libnetwork/drivers/overlay/ovmanager.(*driver).NetworkFree": "overlay",
+		"github.com/docker/libnetwork/networkdb.(*NetworkDB).gossip":                   "networkdb",
+		"github.com/docker/libnetwork.(*resolver).ServeDNS":                            "resolver",
+		"github.com/docker/libnetwork.(*controller).NewNetwork":                        "",
+		"github.com/docker/libnetwork/drivers/overlayfoo.Init":                         "",
+	} {
+		if s := subsystemOfFunc(name); s != expected {
+			t.Errorf("expected subsystem %q for %s, got %q", expected, name, s)
+		}
+	}
+}
+
+func TestSet(t *testing.T) {
+	var out bytes.Buffer
+	logger := logrus.New()
+	logger.Out = &out
+	logger.Formatter = &logrus.TextFormatter{DisableTimestamp: true}
+	logger.SetLevel(logrus.InfoLevel)
+	c := &control{logger: logger}
+
+	if err := c.set("bogus", "debug"); err == nil {
+		t.Fatal("expected an error for an unknown subsystem")
+	}
+	if err := c.set("iptables", "chatty"); err == nil {
+		t.Fatal("expected an error for an invalid level")
+	}
+	if err := c.set("iptables", "debug"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.set("networkdb", "warn"); err != nil {
+		t.Fatal(err)
+	}
+	if l := c.level(); l != logrus.DebugLevel {
+		t.Fatalf("expected the logger level to be raised to debug, got %s", l)
+	}
+
+	logger.WithField(Field, "iptables").Debug("iptables debug")
+	logger.WithField(Field, "overlay").Debug("overlay debug")
+	logger.WithField(Field, "overlay").Info("overlay info")
+	logger.WithField(Field, "networkdb").Info("networkdb info")
+	logger.Debug("other debug")
+	logger.Info("other info")
+	for msg, expected := range map[string]bool{
+		"iptables debug": true,
+		"overlay debug":  false,
+		"overlay info":   true,
+		"networkdb info": false,
+		"other debug":    false,
+		"other info":     true,
+	} {
+		if strings.Contains(out.String(), msg) != expected {
+			t.Errorf("expected %q logged %v, got:\n%s", msg, expected, out.String())
+		}
+	}
+
+	levels, base := c.get()
+	if base != "info" || len(levels) != 2 || levels["iptables"] != "debug" || levels["networkdb"] != "warning" {
+		t.Fatalf("unexpected levels %v and base level %s", levels, base)
+	}
+
+	// The level set on the logger meanwhile is the new base level
+	logger.SetLevel(logrus.ErrorLevel)
+	if err := c.set("networkdb", ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, base := c.get(); base != "error" {
+		t.Fatalf("expected the error base level, got %s", base)
+	}
+	if err := c.set("iptables", ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := logger.Formatter.(*filter); ok {
+		t.Fatal("expected the formatter to be put back")
+	}
+	if l := c.level(); l != logrus.ErrorLevel {
+		t.Fatalf("expected the logger level to be put back to error, got %s", l)
+	}
+}
@@ -0,0 +1,56 @@
+package ipam
+
+import (
+	"hash/fnv"
+	"net"
+	"strconv"
+
+	"github.com/docker/libnetwork/bitseq"
+	"github.com/docker/libnetwork/ipamapi"
+	"github.com/docker/libnetwork/types"
+)
+
+// keyedProbes is the number of addresses derived from the address key which
+// are tried before falling back to the regular allocation
+const keyedProbes = 8
+
+// keyOrdinal maps the address key and the probe number on an ordinal of the
+// [start, end] range
+func keyOrdinal(key string, probe int, start, end uint64) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	if probe > 0 {
+		h.Write([]byte("#" + strconv.Itoa(probe)))
+	}
+	return start + h.Sum64()%(end-start+1)
+}
+
+// getKeyedAddress allocates the address derived from the key, so that the same
+// key is given the same address as long as it is free. On collision a fixed
+// sequence of alternatives derived from the key is tried, then any free
+// address of the pool is returned.
+func (a *Allocator) getKeyedAddress(nw *net.IPNet, bitmask *bitseq.Handle, ipr *AddressRange, key string, serial bool) (net.IP, error) {
+	if bitmask.Unselected() <= 0 {
+		return nil, ipamapi.ErrNoAvailableIPs
+	}
+
+	start, end := uint64(0), bitmask.Bits()-1
+	if ipr != nil {
+		start, end = ipr.Start, ipr.End
+	}
+
+	base := types.GetIPNetCopy(nw)
+	for probe := 0; probe < keyedProbes; probe++ {
+		ordinal := keyOrdinal(key, probe, start, end)
+		switch err := bitmask.Set(ordinal); err {
+		case nil:
+			return generateAddress(ordinal, base), nil
+		case bitseq.ErrBitAllocated:
+			continue
+		default:
+			return nil, err
+		}
+	}
+
+	return a.getAddress(nw, bitmask, nil, ipr, serial)
+}
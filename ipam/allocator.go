@@ -216,6 +216,11 @@ func (a *Allocator) RequestPool(addressSpace, pool, subPool string, options map[
 		return "", nil, nil, types.InternalErrorf("failed to parse pool request for address space %q pool %q subpool %q: %v", addressSpace, pool, subPool, err)
 	}
 
+	hostPoolSize, err := parseHostPoolSize(options, subPool)
+	if err != nil {
+		return "", nil, nil, err
+	}
+
 	pdf := k == nil
 
 retry:
@@ -244,6 +249,13 @@ retry:
 		return "", nil, nil, err
 	}
 
+	if hostPoolSize != 0 {
+		if err := aSpace.setHostPoolSize(*k, hostPoolSize); err != nil {
+			aSpace.updatePoolDBOnRemoval(*k)
+			return "", nil, nil, err
+		}
+	}
+
 	if err := a.writeToStore(aSpace); err != nil {
 		if _, ok := err.(types.RetryError); !ok {
 			return "", nil, nil, types.InternalErrorf("pool configuration failed because of %s", err.Error())
@@ -481,6 +493,7 @@ func (a *Allocator) RequestAddress(poolID string, prefAddress net.IP, opts map[s
 		k = c.ParentKey
 		c = aSpace.subnets[k]
 	}
+	hostPools := p.HostPoolSize != 0
 	aSpace.Unlock()
 
 	bm, err := a.retrieveBitmask(k, c.Pool)
@@ -496,7 +509,15 @@ func (a *Allocator) RequestAddress(poolID string, prefAddress net.IP, opts map[s
 			serial = (val == "true")
 		}
 	}
-	ip, err := a.getAddress(p.Pool, bm, prefAddress, p.Range, serial)
+	if host := opts[ipamapi.HostPoolOwner]; hostPools && host != "" && prefAddress == nil {
+		return a.requestHostPoolAddress(k, bm, host, serial)
+	}
+	var ip net.IP
+	if key := opts[ipamapi.AddressKey]; key != "" && prefAddress == nil {
+		ip, err = a.getKeyedAddress(p.Pool, bm, p.Range, key, serial)
+	} else {
+		ip, err = a.getAddress(p.Pool, bm, prefAddress, p.Range, serial)
+	}
 	if err != nil {
 		return nil, nil, err
 	}
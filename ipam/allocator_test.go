@@ -1559,3 +1559,148 @@ func TestParallelPredefinedRequest4(t *testing.T) {
 func TestParallelPredefinedRequest5(t *testing.T) {
 	runParallelTests(t, 4)
 }
+
+func TestHostPools(t *testing.T) {
+	a, err := getAllocator(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, _, err := a.RequestPool(localAddressSpace, "10.20.0.0/28", "", map[string]string{ipamapi.HostPoolSize: "28"}, false); err == nil {
+		t.Fatal("Expected failure for a host pool as large as the pool")
+	}
+
+	pid, _, _, err := a.RequestPool(localAddressSpace, "10.20.0.0/28", "", map[string]string{ipamapi.HostPoolSize: "30"}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h1 := map[string]string{ipamapi.HostPoolOwner: "host1"}
+	h2 := map[string]string{ipamapi.HostPoolOwner: "host2"}
+	for _, tc := range []struct {
+		opts map[string]string
+		ip   string
+		pool string
+	}{
+		{h1, "10.20.0.1", "10.20.0.0/30"},
+		{h2, "10.20.0.4", "10.20.0.4/30"},
+		{h1, "10.20.0.2", "10.20.0.0/30"},
+		{h1, "10.20.0.3", "10.20.0.0/30"},
+		// The first block of the host is exhausted
+		{h1, "10.20.0.8", "10.20.0.8/30"},
+		{h2, "10.20.0.5", "10.20.0.4/30"},
+	} {
+		ip, data, err := a.RequestAddress(pid, nil, tc.opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ip.IP.String() != tc.ip || data[ipamapi.HostPool] != tc.pool {
+			t.Fatalf("Expected %s from %s for %s, got %s from %s", tc.ip, tc.pool, tc.opts[ipamapi.HostPoolOwner], ip.IP, data[ipamapi.HostPool])
+		}
+	}
+
+	aSpace, err := a.getAddrSpace(localAddressSpace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k := SubnetKey{}
+	if err := k.FromString(pid); err != nil {
+		t.Fatal(err)
+	}
+	b, err := json.Marshal(aSpace.subnets[k])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var p PoolData
+	if err := json.Unmarshal(b, &p); err != nil {
+		t.Fatal(err)
+	}
+	if p.HostPoolSize != 30 || len(p.HostPools["host1"]) != 2 || len(p.HostPools["host2"]) != 1 ||
+		p.HostPools["host1"][1].String() != "10.20.0.8/30" {
+		t.Fatalf("Host pools not preserved in the pool data: %v", p.HostPools)
+	}
+}
+
+func TestRequestAddressByKey(t *testing.T) {
+	a, err := getAllocator(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pid, _, _, err := a.RequestPool(localAddressSpace, "10.30.0.0/29", "", nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := map[string]string{ipamapi.AddressKey: "web.1"}
+	ip, _, err := a.RequestAddress(pid, nil, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.ReleaseAddress(pid, ip.IP); err != nil {
+		t.Fatal(err)
+	}
+	ip2, _, err := a.RequestAddress(pid, nil, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ip.IP.Equal(ip2.IP) {
+		t.Fatalf("Expected the same address %s for the same key, got %s", ip.IP, ip2.IP)
+	}
+
+	// On collision another address is returned until the pool is exhausted
+	seen := map[string]bool{ip.IP.String(): true}
+	for i := 0; i < 5; i++ {
+		ip, _, err := a.RequestAddress(pid, nil, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if seen[ip.IP.String()] {
+			t.Fatalf("Address %s allocated twice", ip.IP)
+		}
+		seen[ip.IP.String()] = true
+	}
+	if _, _, err := a.RequestAddress(pid, nil, opts); err != ipamapi.ErrNoAvailableIPs {
+		t.Fatalf("Expected %v, got %v", ipamapi.ErrNoAvailableIPs, err)
+	}
+}
+
+func TestListAddresses(t *testing.T) {
+	a, err := getAllocator(false)
+	assert.NilError(t, err)
+
+	poolID, _, _, err := a.RequestPool(localAddressSpace, "172.29.0.0/24", "", nil, false)
+	assert.NilError(t, err)
+	subPoolID, _, _, err := a.RequestPool(localAddressSpace, "172.30.0.0/16", "172.30.1.0/28", nil, false)
+	assert.NilError(t, err)
+
+	if ips, err := a.ListAddresses(poolID); err != nil || len(ips) != 0 {
+		t.Fatalf("unexpected addresses %v of a new pool: %v", ips, err)
+	}
+	for _, ip := range []string{"172.29.0.1", "172.29.0.7"} {
+		_, _, err := a.RequestAddress(poolID, net.ParseIP(ip), nil)
+		assert.NilError(t, err)
+	}
+	_, _, err = a.RequestAddress(subPoolID, nil, nil)
+	assert.NilError(t, err)
+
+	ips, err := a.ListAddresses(poolID)
+	assert.NilError(t, err)
+	if len(ips) != 2 || !ips[0].Equal(net.ParseIP("172.29.0.1")) || !ips[1].Equal(net.ParseIP("172.29.0.7")) {
+		t.Fatalf("unexpected addresses %v", ips)
+	}
+	if ips, err = a.ListAddresses(subPoolID); err != nil || len(ips) != 1 || !ips[0].Equal(net.ParseIP("172.30.1.0")) {
+		t.Fatalf("unexpected addresses %v of the sub pool: %v", ips, err)
+	}
+
+	assert.NilError(t, a.ReleaseAddress(poolID, net.ParseIP("172.29.0.7")))
+	if ips, err = a.ListAddresses(poolID); err != nil || len(ips) != 1 {
+		t.Fatalf("unexpected addresses %v after the release: %v", ips, err)
+	}
+
+	v6PoolID, _, _, err := a.RequestPool(localAddressSpace, "fd00::/64", "", nil, true)
+	assert.NilError(t, err)
+	if _, err := a.ListAddresses(v6PoolID); err == nil {
+		t.Fatal("the addresses of a /64 pool got listed")
+	}
+}
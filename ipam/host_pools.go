@@ -0,0 +1,162 @@
+package ipam
+
+import (
+	"net"
+	"strconv"
+
+	"github.com/docker/libnetwork/bitseq"
+	"github.com/docker/libnetwork/ipamapi"
+	"github.com/docker/libnetwork/types"
+)
+
+// parseHostPoolSize validates the prefix length of the host pools requested
+// for the pool, returning zero when the pool is not to be carved
+func parseHostPoolSize(options map[string]string, subPool string) (int, error) {
+	v, ok := options[ipamapi.HostPoolSize]
+	if !ok {
+		return 0, nil
+	}
+	size, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, types.BadRequestErrorf("invalid host pool size %q: %v", v, err)
+	}
+	if subPool != "" {
+		return 0, types.BadRequestErrorf("host pools cannot be carved out of a sub pool")
+	}
+	return size, nil
+}
+
+// setHostPoolSize marks the pool as carved in blocks of the given size
+func (aSpace *addrSpace) setHostPoolSize(k SubnetKey, size int) error {
+	aSpace.Lock()
+	defer aSpace.Unlock()
+
+	p, ok := aSpace.subnets[k]
+	if !ok {
+		return types.NotFoundErrorf("cannot find address pool for poolID:%s", k.String())
+	}
+	ones, bits := p.Pool.Mask.Size()
+	if size <= ones || size > bits {
+		return types.BadRequestErrorf("host pool size /%d does not fit in pool %s", size, p.Pool)
+	}
+	p.HostPoolSize = size
+	return nil
+}
+
+// carveHostPool assigns to the host the first block of the pool which is
+// neither assigned to another host nor overlapping a sub pool
+func (aSpace *addrSpace) carveHostPool(k SubnetKey, host string) (*net.IPNet, error) {
+	aSpace.Lock()
+	defer aSpace.Unlock()
+
+	p, ok := aSpace.subnets[k]
+	if !ok {
+		return nil, types.NotFoundErrorf("cannot find address pool for poolID:%s", k.String())
+	}
+	_, bits := p.Pool.Mask.Size()
+	mask := net.CIDRMask(p.HostPoolSize, bits)
+
+	ip := p.Pool.IP.Mask(p.Pool.Mask)
+	for ip != nil && p.Pool.Contains(ip) {
+		block := &net.IPNet{IP: ip, Mask: mask}
+		if !aSpace.blockInUse(k, p, block) {
+			if p.HostPools == nil {
+				p.HostPools = make(map[string][]*net.IPNet)
+			}
+			p.HostPools[host] = append(p.HostPools[host], block)
+			return block, nil
+		}
+		ip = nextBlock(ip, p.HostPoolSize)
+	}
+	return nil, ipamapi.ErrNoAvailablePool
+}
+
+func (aSpace *addrSpace) blockInUse(k SubnetKey, p *PoolData, block *net.IPNet) bool {
+	for _, blocks := range p.HostPools {
+		for _, b := range blocks {
+			if b.Contains(block.IP) || block.Contains(b.IP) {
+				return true
+			}
+		}
+	}
+	for _, sp := range aSpace.subnets {
+		if sp.Range == nil || sp.ParentKey != k {
+			continue
+		}
+		if sp.Range.Sub.Contains(block.IP) || block.Contains(sp.Range.Sub.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+// nextBlock returns the address of the block of the given prefix length
+// following the one of ip, nil past the end of the address space
+func nextBlock(ip net.IP, size int) net.IP {
+	next := make(net.IP, len(ip))
+	copy(next, ip)
+	i := (size - 1) / 8
+	carry := uint(1) << uint(7-(size-1)%8)
+	for ; i >= 0 && carry != 0; i-- {
+		sum := uint(next[i]) + carry
+		next[i] = byte(sum)
+		carry = sum >> 8
+	}
+	if carry != 0 {
+		return nil
+	}
+	return next
+}
+
+// requestHostPoolAddress allocates an address out of the blocks assigned to
+// the host, carving a new block out of the pool when they are exhausted.
+// The blocks are recorded in the address space so that their assignment is
+// shared through the datastore.
+func (a *Allocator) requestHostPoolAddress(k SubnetKey, bm *bitseq.Handle, host string, serial bool) (*net.IPNet, map[string]string, error) {
+	for {
+		aSpace, err := a.getAddrSpace(k.AddressSpace)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		aSpace.Lock()
+		p, ok := aSpace.subnets[k]
+		if !ok {
+			aSpace.Unlock()
+			return nil, nil, types.NotFoundErrorf("cannot find address pool for poolID:%s", k.String())
+		}
+		pool := p.Pool
+		blocks := append([]*net.IPNet(nil), p.HostPools[host]...)
+		aSpace.Unlock()
+
+		for _, b := range blocks {
+			ipr, err := getAddressRange(b.String(), pool)
+			if err != nil {
+				return nil, nil, types.InternalErrorf("invalid host pool %s: %v", b, err)
+			}
+			ip, err := a.getAddress(pool, bm, nil, ipr, serial)
+			if err == nil {
+				return &net.IPNet{IP: ip, Mask: pool.Mask}, map[string]string{ipamapi.HostPool: b.String()}, nil
+			}
+			if err != ipamapi.ErrNoAvailableIPs {
+				return nil, nil, err
+			}
+		}
+
+		if _, err := aSpace.carveHostPool(k, host); err != nil {
+			if err == ipamapi.ErrNoAvailablePool {
+				return nil, nil, ipamapi.ErrNoAvailableIPs
+			}
+			return nil, nil, err
+		}
+		if err := a.writeToStore(aSpace); err != nil {
+			if _, ok := err.(types.RetryError); !ok {
+				return nil, nil, types.InternalErrorf("host pool allocation failed because of %s", err.Error())
+			}
+			// Another host carved a block meanwhile
+			if err := a.refresh(k.AddressSpace); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package ipam
+
+import (
+	"net"
+
+	"github.com/docker/libnetwork/types"
+)
+
+// maxListedAddresses is the size of the largest pool the allocated
+// addresses can be listed of
+const maxListedAddresses = 1 << 20
+
+// ListAddresses returns the addresses allocated out of the pool, leaving
+// out the network and broadcast addresses the allocator reserves
+func (a *Allocator) ListAddresses(poolID string) ([]net.IP, error) {
+	k := SubnetKey{}
+	if err := k.FromString(poolID); err != nil {
+		return nil, types.BadRequestErrorf("invalid pool id: %s", poolID)
+	}
+
+	if err := a.refresh(k.AddressSpace); err != nil {
+		return nil, err
+	}
+
+	aSpace, err := a.getAddrSpace(k.AddressSpace)
+	if err != nil {
+		return nil, err
+	}
+
+	aSpace.Lock()
+	p, ok := aSpace.subnets[k]
+	if !ok {
+		aSpace.Unlock()
+		return nil, types.NotFoundErrorf("cannot find address pool for poolID:%s", poolID)
+	}
+	c := p
+	for c.Range != nil {
+		k = c.ParentKey
+		c = aSpace.subnets[k]
+	}
+	aSpace.Unlock()
+
+	bm, err := a.retrieveBitmask(k, c.Pool)
+	if err != nil {
+		return nil, types.InternalErrorf("could not find bitmask in datastore for %s on address listing of pool %s: %v",
+			k.String(), poolID, err)
+	}
+
+	start, end := uint64(0), bm.Bits()-1
+	if p.Range != nil {
+		start, end = p.Range.Start, p.Range.End
+	}
+	if end-start >= maxListedAddresses {
+		return nil, types.ForbiddenErrorf("pool %s is too large for its addresses to be listed", poolID)
+	}
+
+	base := types.GetIPNetCopy(p.Pool)
+	v4 := getAddressVersion(p.Pool.IP) == v4
+	var ips []net.IP
+	for o := start; o <= end; o++ {
+		if o == 0 || (v4 && o == bm.Bits()-1) {
+			continue
+		}
+		if bm.IsSet(o) {
+			ips = append(ips, generateAddress(o, base))
+		}
+	}
+	return ips, nil
+}
@@ -25,6 +25,11 @@ type PoolData struct {
 	Pool      *net.IPNet
 	Range     *AddressRange `json:",omitempty"`
 	RefCount  int
+	// HostPoolSize is the prefix length of the blocks of the pool
+	// assigned to the hosts, zero when the pool is shared
+	HostPoolSize int
+	// HostPools are the blocks of the pool assigned to each host
+	HostPools map[string][]*net.IPNet
 }
 
 // addrSpace contains the pool configurations for the address space
@@ -122,6 +127,18 @@ func (p *PoolData) MarshalJSON() ([]byte, error) {
 	if p.Range != nil {
 		m["Range"] = p.Range
 	}
+	if p.HostPoolSize != 0 {
+		m["HostPoolSize"] = p.HostPoolSize
+	}
+	if len(p.HostPools) > 0 {
+		hp := make(map[string][]string, len(p.HostPools))
+		for host, blocks := range p.HostPools {
+			for _, b := range blocks {
+				hp[host] = append(hp[host], b.String())
+			}
+		}
+		m["HostPools"] = hp
+	}
 	return json.Marshal(m)
 }
 
@@ -130,10 +147,12 @@ func (p *PoolData) UnmarshalJSON(data []byte) error {
 	var (
 		err error
 		t   struct {
-			ParentKey SubnetKey
-			Pool      string
-			Range     *AddressRange `json:",omitempty"`
-			RefCount  int
+			ParentKey    SubnetKey
+			Pool         string
+			Range        *AddressRange `json:",omitempty"`
+			RefCount     int
+			HostPoolSize int
+			HostPools    map[string][]string
 		}
 	)
 
@@ -144,11 +163,24 @@ func (p *PoolData) UnmarshalJSON(data []byte) error {
 	p.ParentKey = t.ParentKey
 	p.Range = t.Range
 	p.RefCount = t.RefCount
+	p.HostPoolSize = t.HostPoolSize
 	if t.Pool != "" {
 		if p.Pool, err = types.ParseCIDR(t.Pool); err != nil {
 			return err
 		}
 	}
+	if len(t.HostPools) > 0 {
+		p.HostPools = make(map[string][]*net.IPNet, len(t.HostPools))
+		for host, blocks := range t.HostPools {
+			for _, b := range blocks {
+				_, nw, err := net.ParseCIDR(b)
+				if err != nil {
+					return err
+				}
+				p.HostPools[host] = append(p.HostPools[host], nw)
+			}
+		}
+	}
 
 	return nil
 }
@@ -220,6 +252,15 @@ func (p *PoolData) CopyTo(dstP *PoolData) error {
 	}
 
 	dstP.RefCount = p.RefCount
+	dstP.HostPoolSize = p.HostPoolSize
+	if p.HostPools != nil {
+		dstP.HostPools = make(map[string][]*net.IPNet, len(p.HostPools))
+		for host, blocks := range p.HostPools {
+			for _, b := range blocks {
+				dstP.HostPools[host] = append(dstP.HostPools[host], types.GetIPNetCopy(b))
+			}
+		}
+	}
 	return nil
 }
 
@@ -0,0 +1,140 @@
+package libnetwork
+
+import (
+	"net"
+
+	"github.com/docker/libnetwork/ipamapi"
+	"github.com/docker/libnetwork/types"
+	"github.com/sirupsen/logrus"
+)
+
+// LeakedAddress is an address allocated out of a pool of a network which
+// neither an endpoint of the network, its gateway nor its auxiliary
+// addresses hold
+type LeakedAddress struct {
+	PoolID   string
+	Address  net.IP
+	Released bool
+	Error    string
+}
+
+// CollectLeakedAddresses lists the leaked addresses of the network through
+// its IPAM driver, which has to implement ipamapi.AddressLister, and
+// releases them when apply is set. The endpoint creations of the network
+// wait for the collection, their addresses are not taken for leaked ones.
+func (c *controller) CollectLeakedAddresses(networkID string, apply bool) ([]LeakedAddress, error) {
+	nw, err := c.NetworkByID(networkID)
+	if err != nil {
+		return nil, err
+	}
+	n := nw.(*network)
+	if n.ConfigOnly() {
+		return nil, nil
+	}
+
+	ipam, _, err := c.getIPAMDriver(n.ipamType)
+	if err != nil {
+		return nil, err
+	}
+	lister, ok := ipam.(ipamapi.AddressLister)
+	if !ok {
+		return nil, types.NotImplementedErrorf("the %s IPAM driver does not list its allocated addresses", n.ipamType)
+	}
+
+	c.networkLocker.Lock(n.id)
+	defer c.networkLocker.Unlock(n.id)
+
+	held := n.heldAddresses()
+	var leaked []LeakedAddress
+	for _, d := range append(n.getIPInfo(4), n.getIPInfo(6)...) {
+		ips, err := lister.ListAddresses(d.PoolID)
+		if err != nil {
+			return nil, err
+		}
+		for _, ip := range ips {
+			if held[ip.String()] {
+				continue
+			}
+			l := LeakedAddress{PoolID: d.PoolID, Address: ip}
+			if apply {
+				if err := ipam.ReleaseAddress(d.PoolID, ip); err != nil {
+					l.Error = err.Error()
+				} else {
+					l.Released = true
+				}
+			}
+			leaked = append(leaked, l)
+		}
+	}
+
+	if apply && len(leaked) > 0 {
+		logrus.Infof("Collected %d leaked addresses of network %s", len(leaked), n.Name())
+	}
+	return leaked, nil
+}
+
+// heldAddresses returns the addresses the gateways, the auxiliary
+// addresses and the endpoints of the network hold
+func (n *network) heldAddresses() map[string]bool {
+	held := map[string]bool{}
+	for _, d := range append(n.getIPInfo(4), n.getIPInfo(6)...) {
+		if d.Gateway != nil {
+			held[d.Gateway.IP.String()] = true
+		}
+		for _, aux := range d.AuxAddresses {
+			held[aux.IP.String()] = true
+		}
+	}
+	eps := n.getController().deletedEndpoints(n)
+	for _, e := range n.Endpoints() {
+		eps = append(eps, e.(*endpoint))
+	}
+	for _, ep := range eps {
+		ep.Lock()
+		if ep.iface != nil {
+			if ep.iface.addr != nil {
+				held[ep.iface.addr.IP.String()] = true
+			}
+			if ep.iface.addrv6 != nil {
+				held[ep.iface.addrv6.IP.String()] = true
+			}
+		}
+		ep.Unlock()
+	}
+	return held
+}
+
+// holdDeletedAddresses counts the addresses of the endpoint in deletion as
+// held until the returned function is called
+func (c *controller) holdDeletedAddresses(ep *endpoint) func() {
+	eid, nid := ep.ID(), ep.getNetwork().ID()
+	c.Lock()
+	if c.deletingEndpoints == nil {
+		c.deletingEndpoints = map[string]map[string]*endpoint{}
+	}
+	if c.deletingEndpoints[nid] == nil {
+		c.deletingEndpoints[nid] = map[string]*endpoint{}
+	}
+	c.deletingEndpoints[nid][eid] = ep
+	c.Unlock()
+	return func() {
+		c.Lock()
+		delete(c.deletingEndpoints[nid], eid)
+		if len(c.deletingEndpoints[nid]) == 0 {
+			delete(c.deletingEndpoints, nid)
+		}
+		c.Unlock()
+	}
+}
+
+// deletedEndpoints returns the endpoints of the network in deletion
+func (c *controller) deletedEndpoints(n *network) []*endpoint {
+	nid := n.ID()
+	c.Lock()
+	defer c.Unlock()
+	var eps []*endpoint
+	for _, ep := range c.deletingEndpoints[nid] {
+		eps = append(eps, ep)
+	}
+	return eps
+}
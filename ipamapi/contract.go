@@ -18,6 +18,8 @@ const (
 	DefaultIPAM = "default"
 	// NullIPAM is the name of the built-in null ipam driver
 	NullIPAM = "null"
+	// DHCPIPAM is the name of the built-in dhcp ipam driver
+	DHCPIPAM = "dhcp"
 	// PluginEndpointType represents the Endpoint Type used by Plugin system
 	PluginEndpointType = "IpamDriver"
 	// RequestAddressType represents the Address Type used when requesting an address
@@ -85,6 +87,13 @@ type Ipam interface {
 	IsBuiltIn() bool
 }
 
+// AddressLister is the optional interface of the IPAM drivers which list
+// the addresses allocated out of their pools
+type AddressLister interface {
+	// ListAddresses returns the addresses allocated out of the pool
+	ListAddresses(poolID string) ([]net.IP, error)
+}
+
 // Capability represents the requirements and capabilities of the IPAM driver
 type Capability struct {
 	// Whether on address request, libnetwork must
@@ -7,4 +7,21 @@ const (
 	// AllocSerialPrefix constant marks the reserved label space for libnetwork ipam
 	// allocation ordering.(serial/first available)
 	AllocSerialPrefix = Prefix + ".ipam.serial"
+
+	// HostPoolSize is the pool option carrying the prefix length of the
+	// per host pools carved out of the pool
+	HostPoolSize = Prefix + ".ipam.host_pool_size"
+
+	// HostPoolOwner is the address option carrying the identifier of the
+	// host the address is requested for
+	HostPoolOwner = Prefix + ".ipam.host"
+
+	// HostPool is the address data carrying the host pool the address was
+	// allocated from
+	HostPool = Prefix + ".ipam.host_pool"
+
+	// AddressKey is the address option carrying the key the address is
+	// derived from, so that requests with the same key get the same address
+	// as long as it is available
+	AddressKey = Prefix + ".ipam.address_key"
 )
@@ -0,0 +1,203 @@
+package dhcp
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Timeouts of the successive attempts of an exchange with the server
+var retryTimeouts = []time.Duration{time.Second, 2 * time.Second, 4 * time.Second}
+
+// minRetryInterval bounds the retries of a failed renewal
+const minRetryInterval = 10 * time.Second
+
+// transport carries the DHCP messages on the parent interface of a pool
+type transport interface {
+	// send broadcasts the message when dst is nil
+	send(m *message, dst net.IP) error
+	receive(deadline time.Time) (*message, error)
+	close() error
+}
+
+// lease is an address leased to an endpoint and kept renewed until the
+// address is released
+type lease struct {
+	sync.Mutex
+	iface    string
+	clientID []byte
+	hwAddr   net.HardwareAddr
+	ip       net.IP
+	mask     net.IPMask
+	router   net.IP
+	server   net.IP
+	renewal  time.Duration
+	expiry   time.Time
+	stop     chan struct{}
+}
+
+func newXid() uint32 {
+	b := make([]byte, 4)
+	rand.Read(b)
+	return binary.BigEndian.Uint32(b)
+}
+
+func (l *lease) newMessage(t byte) *message {
+	return &message{
+		op:     opRequest,
+		xid:    newXid(),
+		flags:  flagBroadcast,
+		chaddr: l.hwAddr,
+		options: map[byte][]byte{
+			optMessageType:  {t},
+			optClientID:     l.clientID,
+			optParamRequest: {optSubnetMask, optRouter, optLeaseTime, optRenewalTime},
+		},
+	}
+}
+
+// exchange sends the request until a reply of the expected type, or a NAK,
+// is received
+func exchange(t transport, req *message, want byte) (*message, error) {
+	for _, timeout := range retryTimeouts {
+		if err := t.send(req, nil); err != nil {
+			return nil, err
+		}
+		deadline := time.Now().Add(timeout)
+		for {
+			rep, err := t.receive(deadline)
+			if err != nil {
+				if ne, ok := err.(net.Error); ok && ne.Timeout() {
+					break
+				}
+				return nil, err
+			}
+			if rep.op != opReply || rep.xid != req.xid {
+				continue
+			}
+			switch rep.msgType() {
+			case want:
+				return rep, nil
+			case msgNak:
+				return nil, fmt.Errorf("request refused by the dhcp server")
+			}
+		}
+	}
+	return nil, fmt.Errorf("no reply from a dhcp server")
+}
+
+// discover returns the offer of a server for the lease
+func (l *lease) discover(t transport) (*message, error) {
+	return exchange(t, l.newMessage(msgDiscover), msgOffer)
+}
+
+// request obtains the offered address, or the address of the lease being
+// renewed when offer is nil. The renewals are broadcast in the INIT-REBOOT
+// state as the replies to a unicast request would be sent to the leased
+// address, which is only reachable from the namespace of the endpoint.
+func (l *lease) request(t transport, offer *message) error {
+	req := l.newMessage(msgRequest)
+	l.Lock()
+	if offer != nil {
+		req.options[optRequestedIP] = offer.yiaddr.To4()
+		if sid := offer.ipOption(optServerID); sid != nil {
+			req.options[optServerID] = sid.To4()
+		}
+	} else {
+		req.options[optRequestedIP] = l.ip.To4()
+	}
+	l.Unlock()
+
+	ack, err := exchange(t, req, msgAck)
+	if err != nil {
+		return err
+	}
+	return l.update(ack)
+}
+
+func (l *lease) update(ack *message) error {
+	mask := ack.options[optSubnetMask]
+	if len(mask) != net.IPv4len {
+		return fmt.Errorf("dhcp server did not provide the subnet mask of %s", ack.yiaddr)
+	}
+	duration := ack.durationOption(optLeaseTime)
+	if duration == 0 {
+		return fmt.Errorf("dhcp server did not provide the lease time of %s", ack.yiaddr)
+	}
+
+	l.Lock()
+	defer l.Unlock()
+	l.ip = ack.yiaddr.To4()
+	l.mask = net.IPMask(mask)
+	l.router = ack.ipOption(optRouter)
+	l.server = ack.ipOption(optServerID)
+	l.renewal = ack.durationOption(optRenewalTime)
+	if l.renewal == 0 || l.renewal >= duration {
+		l.renewal = duration / 2
+	}
+	l.expiry = time.Now().Add(duration)
+	return nil
+}
+
+func (l *lease) release(t transport) error {
+	m := l.newMessage(msgRelease)
+	delete(m.options, optParamRequest)
+	m.flags = 0
+	l.Lock()
+	m.ciaddr = l.ip
+	server := l.server
+	if server != nil {
+		m.options[optServerID] = server.To4()
+	}
+	l.Unlock()
+	return t.send(m, server)
+}
+
+// maintain renews the lease until it is released. A failed renewal is
+// retried until the lease expires, an address change cannot be applied to
+// the running endpoint.
+func (a *allocator) maintain(l *lease) {
+	l.Lock()
+	next := l.renewal
+	l.Unlock()
+
+	for {
+		timer := time.NewTimer(next)
+		select {
+		case <-l.stop:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		err := a.withTransport(l.iface, func(t transport) error {
+			return l.request(t, nil)
+		})
+
+		l.Lock()
+		if err == nil {
+			next = l.renewal
+			l.Unlock()
+			logrus.Debugf("Renewed dhcp lease of %s on %s", l.ip, l.iface)
+			continue
+		}
+		remaining := time.Until(l.expiry)
+		ip := l.ip
+		l.Unlock()
+
+		if remaining <= 0 {
+			logrus.Errorf("DHCP lease of %s on %s expired: %v", ip, l.iface, err)
+		} else {
+			logrus.Warnf("Failed to renew dhcp lease of %s on %s: %v", ip, l.iface, err)
+		}
+		next = remaining / 2
+		if next < minRetryInterval {
+			next = minRetryInterval
+		}
+	}
+}
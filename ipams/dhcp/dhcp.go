@@ -0,0 +1,380 @@
+// Package dhcp implements the dhcp ipam driver. The IPv4 addresses of the
+// endpoints are leased from a DHCP server reachable on the parent interface of
+// the network, and renewed by the driver until they are released. It is meant
+// for the networks whose endpoints are directly attached to the parent
+// interface segment, such as macvlan and ipvlan networks. The IPv6 pools are
+// prefixes delegated by the upstream router through DHCPv6, out of which the
+// driver carves the endpoint addresses.
+package dhcp
+
+import (
+	"crypto/rand"
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/docker/libnetwork/discoverapi"
+	"github.com/docker/libnetwork/ipamapi"
+	"github.com/docker/libnetwork/netlabel"
+	"github.com/docker/libnetwork/netutils"
+	"github.com/docker/libnetwork/types"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// InterfaceOption is the pool option carrying the interface the DHCP
+	// server is reached through
+	InterfaceOption = "dhcp_interface"
+
+	// PrefixDelegationOption is the pool option carrying the interface the
+	// IPv6 prefix is delegated through, the dhcp interface when not set
+	PrefixDelegationOption = "dhcp_pd_interface"
+
+	defaultAS = "dhcp"
+)
+
+type pool struct {
+	iface   string
+	subnet  *net.IPNet
+	gateway *net.IPNet
+	// Set for the IPv6 pools, along with the addresses carved out of the
+	// delegated prefix
+	delegation *delegation
+	addresses  map[string]bool
+	next       uint64
+}
+
+type allocator struct {
+	sync.Mutex
+	pools  map[string]*pool
+	leases map[string]*lease
+	// The exchanges on an interface are serialized as they share the
+	// DHCP client port
+	ifaceLocks    map[string]*sync.Mutex
+	newTransport  func(iface string) (transport, error)
+	newTransport6 func(iface string) (transport6, error)
+}
+
+func newAllocator() *allocator {
+	return &allocator{
+		pools:         map[string]*pool{},
+		leases:        map[string]*lease{},
+		ifaceLocks:    map[string]*sync.Mutex{},
+		newTransport:  openTransport,
+		newTransport6: openTransport6,
+	}
+}
+
+func leaseKey(poolID string, ip net.IP) string {
+	return poolID + "/" + ip.String()
+}
+
+func (a *allocator) lockIface(key string) func() {
+	a.Lock()
+	mu, ok := a.ifaceLocks[key]
+	if !ok {
+		mu = &sync.Mutex{}
+		a.ifaceLocks[key] = mu
+	}
+	a.Unlock()
+
+	mu.Lock()
+	return mu.Unlock
+}
+
+func (a *allocator) withTransport(iface string, fn func(t transport) error) error {
+	defer a.lockIface(iface)()
+
+	t, err := a.newTransport(iface)
+	if err != nil {
+		return fmt.Errorf("failed to open a dhcp client on %s: %v", iface, err)
+	}
+	defer t.close()
+	return fn(t)
+}
+
+func (a *allocator) withTransport6(iface string, fn func(t transport6) error) error {
+	defer a.lockIface(iface + "/v6")()
+
+	t, err := a.newTransport6(iface)
+	if err != nil {
+		return fmt.Errorf("failed to open a dhcpv6 client on %s: %v", iface, err)
+	}
+	defer t.close()
+	return fn(t)
+}
+
+func newLease(iface string, key string) *lease {
+	l := &lease{
+		iface:  iface,
+		hwAddr: netutils.GenerateRandomMAC(),
+		stop:   make(chan struct{}),
+	}
+	// Type 0 client identifiers are not hardware addresses
+	if key == "" {
+		id := make([]byte, 16)
+		rand.Read(id)
+		key = fmt.Sprintf("%x", id)
+	}
+	if len(key) > 254 {
+		key = key[:254]
+	}
+	l.clientID = append([]byte{0}, key...)
+	return l
+}
+
+func (a *allocator) GetDefaultAddressSpaces() (string, string, error) {
+	return defaultAS, defaultAS, nil
+}
+
+func (a *allocator) RequestPool(addressSpace, poolStr, subPool string, options map[string]string, v6 bool) (string, *net.IPNet, map[string]string, error) {
+	if addressSpace != defaultAS {
+		return "", nil, nil, types.BadRequestErrorf("unknown address space: %s", addressSpace)
+	}
+	if subPool != "" {
+		return "", nil, nil, types.BadRequestErrorf("dhcp ipam driver does not handle specific address subpool requests")
+	}
+	iface := options[InterfaceOption]
+	if v6 && options[PrefixDelegationOption] != "" {
+		iface = options[PrefixDelegationOption]
+	}
+	if iface == "" {
+		return "", nil, nil, types.BadRequestErrorf("dhcp ipam driver requires the %s option", InterfaceOption)
+	}
+	if v6 {
+		if poolStr != "" {
+			return "", nil, nil, types.BadRequestErrorf("dhcp ipam driver does not handle specific IPv6 address pool requests")
+		}
+		return a.requestDelegatedPool(iface)
+	}
+
+	p := &pool{iface: iface}
+	if poolStr != "" {
+		_, nw, err := net.ParseCIDR(poolStr)
+		if err != nil || nw.IP.To4() == nil {
+			return "", nil, nil, ipamapi.ErrInvalidPool
+		}
+		p.subnet = nw
+	}
+
+	// Learn the subnet and the router from an offer. The interface may not
+	// exist yet when it is created by the network driver, the pool then has
+	// to be configured.
+	var offer *message
+	err := a.withTransport(iface, func(t transport) error {
+		var err error
+		offer, err = newLease(iface, "").discover(t)
+		return err
+	})
+	if err != nil {
+		if p.subnet == nil {
+			return "", nil, nil, types.NoServiceErrorf("failed to discover the subnet served on %s: %v", iface, err)
+		}
+		logrus.Warnf("Failed to discover the subnet served on %s: %v", iface, err)
+	} else {
+		mask := offer.options[optSubnetMask]
+		if len(mask) != net.IPv4len {
+			return "", nil, nil, types.NoServiceErrorf("dhcp server on %s did not provide the subnet mask", iface)
+		}
+		offered := &net.IPNet{IP: offer.yiaddr.Mask(mask), Mask: mask}
+		if p.subnet == nil {
+			p.subnet = offered
+		} else if !p.subnet.Contains(offer.yiaddr) {
+			return "", nil, nil, types.ForbiddenErrorf("pool %s does not contain the address %s offered on %s", p.subnet, offer.yiaddr, iface)
+		}
+		if r := offer.ipOption(optRouter); r != nil && p.subnet.Contains(r) {
+			p.gateway = &net.IPNet{IP: r, Mask: p.subnet.Mask}
+		}
+	}
+
+	poolID := fmt.Sprintf("%s/%s/%s", defaultAS, iface, p.subnet)
+	a.Lock()
+	defer a.Unlock()
+	if _, ok := a.pools[poolID]; ok {
+		return "", nil, nil, ipamapi.ErrPoolOverlap
+	}
+	a.pools[poolID] = p
+
+	var data map[string]string
+	if p.gateway != nil {
+		data = map[string]string{netlabel.Gateway: p.gateway.String()}
+	}
+	return poolID, types.GetIPNetCopy(p.subnet), data, nil
+}
+
+// requestDelegatedPool obtains a prefix delegated through the interface
+func (a *allocator) requestDelegatedPool(iface string) (string, *net.IPNet, map[string]string, error) {
+	d := newDelegation(iface)
+	if err := a.withTransport6(iface, d.solicit); err != nil {
+		return "", nil, nil, types.NoServiceErrorf("failed to obtain a delegated prefix on %s: %v", iface, err)
+	}
+	p := &pool{
+		iface:      iface,
+		subnet:     types.GetIPNetCopy(d.ia.prefix),
+		delegation: d,
+		addresses:  map[string]bool{},
+	}
+
+	poolID := fmt.Sprintf("%s/%s/%s", defaultAS, iface, p.subnet)
+	a.Lock()
+	if _, ok := a.pools[poolID]; ok {
+		a.Unlock()
+		return "", nil, nil, ipamapi.ErrPoolOverlap
+	}
+	a.pools[poolID] = p
+	a.Unlock()
+	go a.maintainDelegation(p)
+
+	logrus.Debugf("Obtained prefix %s delegated on %s", p.subnet, iface)
+	return poolID, types.GetIPNetCopy(p.subnet), nil, nil
+}
+
+func (a *allocator) ReleasePool(poolID string) error {
+	a.Lock()
+	p, ok := a.pools[poolID]
+	if !ok {
+		a.Unlock()
+		return types.NotFoundErrorf("cannot find address pool for poolID:%s", poolID)
+	}
+	delete(a.pools, poolID)
+	a.Unlock()
+
+	if d := p.delegation; d != nil {
+		close(d.stop)
+		if err := a.withTransport6(d.iface, d.release); err != nil {
+			logrus.Warnf("Failed to release prefix %s delegated on %s: %v", p.subnet, d.iface, err)
+		}
+	}
+	return nil
+}
+
+func (a *allocator) RequestAddress(poolID string, ip net.IP, opts map[string]string) (*net.IPNet, map[string]string, error) {
+	a.Lock()
+	p, ok := a.pools[poolID]
+	a.Unlock()
+	if !ok {
+		return nil, nil, types.NotFoundErrorf("cannot find address pool for poolID:%s", poolID)
+	}
+	if ip != nil && !p.subnet.Contains(ip) {
+		return nil, nil, ipamapi.ErrIPOutOfRange
+	}
+
+	if p.delegation != nil {
+		return a.requestDelegatedAddress(p, ip)
+	}
+
+	// The gateway is the router of the segment, not a leased address
+	if opts[ipamapi.RequestAddressType] == netlabel.Gateway {
+		if ip != nil {
+			return &net.IPNet{IP: ip, Mask: p.subnet.Mask}, nil, nil
+		}
+		if p.gateway == nil {
+			return nil, nil, types.BadRequestErrorf("no router was discovered on %s, the gateway has to be configured", p.iface)
+		}
+		return types.GetIPNetCopy(p.gateway), nil, nil
+	}
+
+	l := newLease(p.iface, opts[ipamapi.AddressKey])
+	err := a.withTransport(p.iface, func(t transport) error {
+		var offer *message
+		if ip != nil {
+			// Request the address directly as in the INIT-REBOOT state
+			l.ip = ip.To4()
+		} else {
+			var err error
+			if offer, err = l.discover(t); err != nil {
+				return err
+			}
+		}
+		return l.request(t, offer)
+	})
+	if err != nil {
+		return nil, nil, types.NoServiceErrorf("failed to lease an address on %s: %v", p.iface, err)
+	}
+
+	if ip != nil && !ip.Equal(l.ip) {
+		a.releaseLease(l)
+		return nil, nil, ipamapi.ErrIPAlreadyAllocated
+	}
+	if !p.subnet.Contains(l.ip) {
+		a.releaseLease(l)
+		return nil, nil, types.InternalErrorf("address %s leased on %s is out of pool %s", l.ip, p.iface, p.subnet)
+	}
+
+	a.Lock()
+	a.leases[leaseKey(poolID, l.ip)] = l
+	a.Unlock()
+	go a.maintain(l)
+
+	logrus.Debugf("Leased %s on %s", l.ip, p.iface)
+	return &net.IPNet{IP: types.GetIPCopy(l.ip), Mask: p.subnet.Mask}, nil, nil
+}
+
+// requestDelegatedAddress carves the address out of the delegated prefix,
+// the gateway being allocated as any other address
+func (a *allocator) requestDelegatedAddress(p *pool, ip net.IP) (*net.IPNet, map[string]string, error) {
+	a.Lock()
+	defer a.Unlock()
+
+	if ip != nil {
+		if p.addresses[ip.String()] {
+			return nil, nil, ipamapi.ErrIPAlreadyAllocated
+		}
+		p.addresses[ip.String()] = true
+		return &net.IPNet{IP: types.GetIPCopy(ip), Mask: p.subnet.Mask}, nil, nil
+	}
+
+	ip, err := p.nextAddress()
+	if err != nil {
+		return nil, nil, ipamapi.ErrNoAvailableIPs
+	}
+	return &net.IPNet{IP: ip, Mask: p.subnet.Mask}, nil, nil
+}
+
+func (a *allocator) releaseLease(l *lease) {
+	if err := a.withTransport(l.iface, l.release); err != nil {
+		logrus.Warnf("Failed to release dhcp lease of %s on %s: %v", l.ip, l.iface, err)
+	}
+}
+
+func (a *allocator) ReleaseAddress(poolID string, ip net.IP) error {
+	a.Lock()
+	p, ok := a.pools[poolID]
+	if !ok {
+		a.Unlock()
+		return types.NotFoundErrorf("cannot find address pool for poolID:%s", poolID)
+	}
+	if p.delegation != nil {
+		delete(p.addresses, ip.String())
+		a.Unlock()
+		return nil
+	}
+	l, ok := a.leases[leaseKey(poolID, ip)]
+	delete(a.leases, leaseKey(poolID, ip))
+	a.Unlock()
+
+	// The gateway and the configured auxiliary addresses are not leased
+	if !ok {
+		return nil
+	}
+	close(l.stop)
+	a.releaseLease(l)
+	return nil
+}
+
+func (a *allocator) DiscoverNew(dType discoverapi.DiscoveryType, data interface{}) error {
+	return nil
+}
+
+func (a *allocator) DiscoverDelete(dType discoverapi.DiscoveryType, data interface{}) error {
+	return nil
+}
+
+func (a *allocator) IsBuiltIn() bool {
+	return true
+}
+
+// Init registers the dhcp ipam driver
+func Init(ic ipamapi.Callback, l, g interface{}) error {
+	return ic.RegisterIpamDriver(ipamapi.DHCPIPAM, newAllocator())
+}
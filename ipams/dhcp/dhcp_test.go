@@ -0,0 +1,329 @@
+package dhcp
+
+import (
+	"encoding/binary"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/docker/libnetwork/ipamapi"
+	"github.com/docker/libnetwork/netlabel"
+	_ "github.com/docker/libnetwork/testutils"
+)
+
+// fakeServer leases the addresses of 192.168.50.0/24 from .100
+type fakeServer struct {
+	sync.Mutex
+	next     byte
+	leases   map[string]net.IP
+	released []net.IP
+	replies  chan *message
+}
+
+func newFakeServer() *fakeServer {
+	return &fakeServer{next: 100, leases: map[string]net.IP{}}
+}
+
+func (s *fakeServer) transport(iface string) (transport, error) {
+	return &fakeTransport{s: s, replies: make(chan *message, 1)}, nil
+}
+
+func (s *fakeServer) handle(m *message) *message {
+	s.Lock()
+	defer s.Unlock()
+
+	id := string(m.options[optClientID])
+	rep := &message{op: opReply, xid: m.xid, chaddr: m.chaddr, options: map[byte][]byte{
+		optServerID:   net.IPv4(192, 168, 50, 1).To4(),
+		optSubnetMask: net.CIDRMask(24, 32),
+		optRouter:     net.IPv4(192, 168, 50, 1).To4(),
+		optLeaseTime:  make([]byte, 4),
+	}}
+	binary.BigEndian.PutUint32(rep.options[optLeaseTime], 3600)
+
+	switch m.msgType() {
+	case msgDiscover:
+		ip, ok := s.leases[id]
+		if !ok {
+			ip = net.IPv4(192, 168, 50, s.next).To4()
+		}
+		rep.yiaddr = ip
+		rep.options[optMessageType] = []byte{msgOffer}
+	case msgRequest:
+		ip := m.ipOption(optRequestedIP)
+		for other, lip := range s.leases {
+			if other != id && lip.Equal(ip) {
+				rep.options[optMessageType] = []byte{msgNak}
+				return rep
+			}
+		}
+		if _, ok := s.leases[id]; !ok && ip[3] == s.next {
+			s.next++
+		}
+		s.leases[id] = ip
+		rep.yiaddr = ip
+		rep.options[optMessageType] = []byte{msgAck}
+	case msgRelease:
+		delete(s.leases, id)
+		s.released = append(s.released, m.ciaddr)
+		return nil
+	}
+	return rep
+}
+
+type fakeTransport struct {
+	s       *fakeServer
+	replies chan *message
+}
+
+func (t *fakeTransport) send(m *message, dst net.IP) error {
+	// Go through the wire format
+	req, err := parseMessage(m.marshal())
+	if err != nil {
+		return err
+	}
+	if rep := t.s.handle(req); rep != nil {
+		t.replies <- rep
+	}
+	return nil
+}
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func (t *fakeTransport) receive(deadline time.Time) (*message, error) {
+	select {
+	case m := <-t.replies:
+		return parseMessage(m.marshal())
+	case <-time.After(time.Until(deadline)):
+		return nil, timeoutError{}
+	}
+}
+
+func (t *fakeTransport) close() error {
+	return nil
+}
+
+func TestMessageMarshalling(t *testing.T) {
+	m := &message{
+		op:     opRequest,
+		xid:    0xdeadbeef,
+		flags:  flagBroadcast,
+		ciaddr: net.IPv4(10, 0, 0, 2).To4(),
+		chaddr: net.HardwareAddr{2, 0, 0, 0, 0, 1},
+		options: map[byte][]byte{
+			optMessageType: {msgRequest},
+			optClientID:    []byte("\x00key"),
+		},
+	}
+	b := m.marshal()
+	if len(b) < minMessageLen {
+		t.Fatalf("Message shorter than %d bytes: %d", minMessageLen, len(b))
+	}
+	p, err := parseMessage(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.op != m.op || p.xid != m.xid || p.flags != m.flags || !p.ciaddr.Equal(m.ciaddr) ||
+		p.chaddr.String() != m.chaddr.String() || p.msgType() != msgRequest || string(p.options[optClientID]) != "\x00key" {
+		t.Fatalf("Unexpected message after marshalling: %+v", p)
+	}
+
+	if _, err := parseMessage(b[:100]); err == nil {
+		t.Fatal("Expected failure parsing a truncated message")
+	}
+}
+
+func TestRequestPoolAndAddress(t *testing.T) {
+	s := newFakeServer()
+	a := newAllocator()
+	a.newTransport = s.transport
+
+	if _, _, _, err := a.RequestPool(defaultAS, "", "", nil, false); err == nil {
+		t.Fatal("Expected failure without the interface option")
+	}
+
+	opts := map[string]string{InterfaceOption: "eth0"}
+	pid, nw, data, err := a.RequestPool(defaultAS, "", "", opts, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nw.String() != "192.168.50.0/24" || data[netlabel.Gateway] != "192.168.50.1/24" {
+		t.Fatalf("Unexpected pool %s and data %v", nw, data)
+	}
+	if _, _, _, err := a.RequestPool(defaultAS, "10.0.0.0/24", "", opts, false); err == nil {
+		t.Fatal("Expected failure for a pool not containing the offered address")
+	}
+
+	gw, _, err := a.RequestAddress(pid, nil, map[string]string{ipamapi.RequestAddressType: netlabel.Gateway})
+	if err != nil || gw.String() != "192.168.50.1/24" {
+		t.Fatalf("Unexpected gateway %v: %v", gw, err)
+	}
+
+	ip1, _, err := a.RequestAddress(pid, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ip2, _, err := a.RequestAddress(pid, nil, map[string]string{ipamapi.AddressKey: "web.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ip1.String() != "192.168.50.100/24" || ip2.String() != "192.168.50.101/24" {
+		t.Fatalf("Unexpected addresses %s and %s", ip1, ip2)
+	}
+
+	if _, _, err := a.RequestAddress(pid, ip1.IP, nil); err == nil {
+		t.Fatal("Expected failure requesting an address leased to another client")
+	}
+
+	if err := a.ReleaseAddress(pid, ip2.IP); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.released) != 1 || !s.released[0].Equal(ip2.IP) {
+		t.Fatalf("Expected the release of %s, got %v", ip2.IP, s.released)
+	}
+	// The gateway is not leased
+	if err := a.ReleaseAddress(pid, gw.IP); err != nil {
+		t.Fatal(err)
+	}
+
+	// The same key is given back its address
+	ip3, _, err := a.RequestAddress(pid, ip2.IP, map[string]string{ipamapi.AddressKey: "web.1"})
+	if err != nil || !ip3.IP.Equal(ip2.IP) {
+		t.Fatalf("Expected %s, got %v: %v", ip2.IP, ip3, err)
+	}
+
+	if err := a.ReleasePool(pid); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// fakeServer6 delegates 2001:db8:1::/64, then 2001:db8:2::/64 once renumbered
+type fakeServer6 struct {
+	sync.Mutex
+	prefix   string
+	released bool
+}
+
+func (s *fakeServer6) transport(iface string) (transport6, error) {
+	return &fakeTransport6{s: s, replies: make(chan *message6, 1)}, nil
+}
+
+func (s *fakeServer6) handle(m *message6) *message6 {
+	s.Lock()
+	defer s.Unlock()
+
+	if m.msgType == msg6Release {
+		s.released = true
+		return nil
+	}
+	req, err := parseIAPD(m)
+	if err != nil && m.msgType != msg6Solicit {
+		return nil
+	}
+	if req == nil {
+		req = &iaPD{}
+		if b := m.option(opt6IAPD); len(b) >= 4 {
+			req.iaid = binary.BigEndian.Uint32(b[0:4])
+		}
+	}
+	_, prefix, _ := net.ParseCIDR(s.prefix)
+	ia := &iaPD{iaid: req.iaid, t1: time.Hour, t2: 2 * time.Hour, prefix: prefix, preferred: 3 * time.Hour, valid: 4 * time.Hour}
+
+	rep := &message6{msgType: msg6Reply, xid: m.xid}
+	if m.msgType == msg6Solicit {
+		rep.msgType = msg6Advertise
+	}
+	rep.add(opt6ClientID, m.option(opt6ClientID))
+	rep.add(opt6ServerID, []byte{0, 3, 0, 1, 2, 0, 0, 0, 0, 1})
+	rep.add(opt6IAPD, ia.marshal())
+	return rep
+}
+
+type fakeTransport6 struct {
+	s       *fakeServer6
+	replies chan *message6
+}
+
+func (t *fakeTransport6) send(m *message6) error {
+	req, err := parseMessage6(m.marshal())
+	if err != nil {
+		return err
+	}
+	if rep := t.s.handle(req); rep != nil {
+		t.replies <- rep
+	}
+	return nil
+}
+
+func (t *fakeTransport6) receive(deadline time.Time) (*message6, error) {
+	select {
+	case m := <-t.replies:
+		return parseMessage6(m.marshal())
+	case <-time.After(time.Until(deadline)):
+		return nil, timeoutError{}
+	}
+}
+
+func (t *fakeTransport6) close() error {
+	return nil
+}
+
+func TestPrefixDelegation(t *testing.T) {
+	s := &fakeServer6{prefix: "2001:db8:1::/64"}
+	a := newAllocator()
+	a.newTransport6 = s.transport
+
+	opts := map[string]string{InterfaceOption: "eth0", PrefixDelegationOption: "eth1"}
+	pid, nw, _, err := a.RequestPool(defaultAS, "", "", opts, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nw.String() != "2001:db8:1::/64" {
+		t.Fatalf("Unexpected delegated prefix %s", nw)
+	}
+
+	gw, _, err := a.RequestAddress(pid, nil, map[string]string{ipamapi.RequestAddressType: netlabel.Gateway})
+	if err != nil || gw.String() != "2001:db8:1::1/64" {
+		t.Fatalf("Unexpected gateway %v: %v", gw, err)
+	}
+	ip, _, err := a.RequestAddress(pid, nil, nil)
+	if err != nil || ip.String() != "2001:db8:1::2/64" {
+		t.Fatalf("Unexpected address %v: %v", ip, err)
+	}
+	if _, _, err := a.RequestAddress(pid, ip.IP, nil); err != ipamapi.ErrIPAlreadyAllocated {
+		t.Fatalf("Expected %v, got %v", ipamapi.ErrIPAlreadyAllocated, err)
+	}
+	if err := a.ReleaseAddress(pid, ip.IP); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := a.RequestAddress(pid, ip.IP, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	a.Lock()
+	d := a.pools[pid].delegation
+	a.Unlock()
+
+	s.Lock()
+	s.prefix = "2001:db8:2::/64"
+	s.Unlock()
+	previous, err := d.renew(&fakeTransport6{s: s, replies: make(chan *message6, 1)}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if previous == nil || previous.String() != "2001:db8:1::/64" {
+		t.Fatalf("Expected the renumbering of 2001:db8:1::/64, got %v", previous)
+	}
+
+	if err := a.ReleasePool(pid); err != nil {
+		t.Fatal(err)
+	}
+	if !s.released {
+		t.Fatal("Delegated prefix was not released")
+	}
+}
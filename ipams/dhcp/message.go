@@ -0,0 +1,154 @@
+package dhcp
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"sort"
+	"time"
+)
+
+const (
+	opRequest = 1
+	opReply   = 2
+
+	// Set in the requests so that the server broadcasts its replies, the
+	// leased address not being configured on the host
+	flagBroadcast = 0x8000
+
+	headerLen = 236
+	// Some servers ignore the requests shorter than a BOOTP message
+	minMessageLen = 300
+)
+
+var magicCookie = []byte{99, 130, 83, 99}
+
+// DHCP options
+const (
+	optPad          = 0
+	optSubnetMask   = 1
+	optRouter       = 3
+	optRequestedIP  = 50
+	optLeaseTime    = 51
+	optMessageType  = 53
+	optServerID     = 54
+	optParamRequest = 55
+	optRenewalTime  = 58
+	optClientID     = 61
+	optEnd          = 255
+)
+
+// DHCP message types
+const (
+	msgDiscover = 1
+	msgOffer    = 2
+	msgRequest  = 3
+	msgAck      = 5
+	msgNak      = 6
+	msgRelease  = 7
+)
+
+var errInvalidMessage = errors.New("invalid dhcp message")
+
+type message struct {
+	op      byte
+	xid     uint32
+	flags   uint16
+	ciaddr  net.IP
+	yiaddr  net.IP
+	chaddr  net.HardwareAddr
+	options map[byte][]byte
+}
+
+func (m *message) msgType() byte {
+	if v := m.options[optMessageType]; len(v) == 1 {
+		return v[0]
+	}
+	return 0
+}
+
+func (m *message) ipOption(code byte) net.IP {
+	if v := m.options[code]; len(v) >= net.IPv4len {
+		return net.IP(v[:net.IPv4len])
+	}
+	return nil
+}
+
+func (m *message) durationOption(code byte) time.Duration {
+	if v := m.options[code]; len(v) == 4 {
+		return time.Duration(binary.BigEndian.Uint32(v)) * time.Second
+	}
+	return 0
+}
+
+func (m *message) marshal() []byte {
+	b := make([]byte, headerLen, minMessageLen)
+	b[0] = m.op
+	b[1] = 1 // ethernet
+	b[2] = byte(len(m.chaddr))
+	binary.BigEndian.PutUint32(b[4:8], m.xid)
+	binary.BigEndian.PutUint16(b[10:12], m.flags)
+	if m.ciaddr != nil {
+		copy(b[12:16], m.ciaddr.To4())
+	}
+	if m.yiaddr != nil {
+		copy(b[16:20], m.yiaddr.To4())
+	}
+	copy(b[28:44], m.chaddr)
+	b = append(b, magicCookie...)
+
+	codes := make([]int, 0, len(m.options))
+	for c := range m.options {
+		codes = append(codes, int(c))
+	}
+	sort.Ints(codes)
+	for _, c := range codes {
+		v := m.options[byte(c)]
+		b = append(b, byte(c), byte(len(v)))
+		b = append(b, v...)
+	}
+	b = append(b, optEnd)
+
+	for len(b) < minMessageLen {
+		b = append(b, optPad)
+	}
+	return b
+}
+
+func parseMessage(b []byte) (*message, error) {
+	if len(b) < headerLen+len(magicCookie) || string(b[headerLen:headerLen+4]) != string(magicCookie) {
+		return nil, errInvalidMessage
+	}
+	hlen := int(b[2])
+	if hlen > 16 {
+		return nil, errInvalidMessage
+	}
+	m := &message{
+		op:      b[0],
+		xid:     binary.BigEndian.Uint32(b[4:8]),
+		flags:   binary.BigEndian.Uint16(b[10:12]),
+		ciaddr:  net.IP(append([]byte(nil), b[12:16]...)),
+		yiaddr:  net.IP(append([]byte(nil), b[16:20]...)),
+		chaddr:  net.HardwareAddr(append([]byte(nil), b[28:28+hlen]...)),
+		options: map[byte][]byte{},
+	}
+
+	opts := b[headerLen+4:]
+	for len(opts) > 0 {
+		code := opts[0]
+		if code == optEnd {
+			break
+		}
+		if code == optPad {
+			opts = opts[1:]
+			continue
+		}
+		if len(opts) < 2 || len(opts) < 2+int(opts[1]) {
+			return nil, errInvalidMessage
+		}
+		l := int(opts[1])
+		m.options[code] = append(m.options[code], opts[2:2+l]...)
+		opts = opts[2+l:]
+	}
+	return m, nil
+}
@@ -0,0 +1,177 @@
+package dhcp
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"time"
+)
+
+// DHCPv6 message types
+const (
+	msg6Solicit   = 1
+	msg6Advertise = 2
+	msg6Request   = 3
+	msg6Renew     = 5
+	msg6Rebind    = 6
+	msg6Reply     = 7
+	msg6Release   = 8
+)
+
+// DHCPv6 options
+const (
+	opt6ClientID    = 1
+	opt6ServerID    = 2
+	opt6ElapsedTime = 8
+	opt6StatusCode  = 13
+	opt6IAPD        = 25
+	opt6IAPrefix    = 26
+)
+
+var errInvalidMessage6 = errors.New("invalid dhcpv6 message")
+
+type option6 struct {
+	code uint16
+	data []byte
+}
+
+type message6 struct {
+	msgType byte
+	xid     uint32
+	options []option6
+}
+
+// iaPD is an identity association for prefix delegation along with the
+// prefix delegated to it
+type iaPD struct {
+	iaid      uint32
+	t1, t2    time.Duration
+	prefix    *net.IPNet
+	preferred time.Duration
+	valid     time.Duration
+}
+
+func (m *message6) option(code uint16) []byte {
+	for _, o := range m.options {
+		if o.code == code {
+			return o.data
+		}
+	}
+	return nil
+}
+
+func (m *message6) add(code uint16, data []byte) {
+	m.options = append(m.options, option6{code: code, data: data})
+}
+
+func (m *message6) marshal() []byte {
+	b := []byte{m.msgType, byte(m.xid >> 16), byte(m.xid >> 8), byte(m.xid)}
+	return appendOptions6(b, m.options)
+}
+
+func appendOptions6(b []byte, opts []option6) []byte {
+	for _, o := range opts {
+		var h [4]byte
+		binary.BigEndian.PutUint16(h[0:2], o.code)
+		binary.BigEndian.PutUint16(h[2:4], uint16(len(o.data)))
+		b = append(b, h[:]...)
+		b = append(b, o.data...)
+	}
+	return b
+}
+
+func parseOptions6(b []byte) ([]option6, error) {
+	var opts []option6
+	for len(b) > 0 {
+		if len(b) < 4 {
+			return nil, errInvalidMessage6
+		}
+		code := binary.BigEndian.Uint16(b[0:2])
+		l := int(binary.BigEndian.Uint16(b[2:4]))
+		if len(b) < 4+l {
+			return nil, errInvalidMessage6
+		}
+		opts = append(opts, option6{code: code, data: append([]byte(nil), b[4:4+l]...)})
+		b = b[4+l:]
+	}
+	return opts, nil
+}
+
+func parseMessage6(b []byte) (*message6, error) {
+	if len(b) < 4 {
+		return nil, errInvalidMessage6
+	}
+	opts, err := parseOptions6(b[4:])
+	if err != nil {
+		return nil, err
+	}
+	return &message6{
+		msgType: b[0],
+		xid:     uint32(b[1])<<16 | uint32(b[2])<<8 | uint32(b[3]),
+		options: opts,
+	}, nil
+}
+
+// status returns the status code carried in the options, success when absent
+func status6(opts []option6) uint16 {
+	for _, o := range opts {
+		if o.code == opt6StatusCode && len(o.data) >= 2 {
+			return binary.BigEndian.Uint16(o.data[0:2])
+		}
+	}
+	return 0
+}
+
+func (ia *iaPD) marshal() []byte {
+	b := make([]byte, 12)
+	binary.BigEndian.PutUint32(b[0:4], ia.iaid)
+	binary.BigEndian.PutUint32(b[4:8], uint32(ia.t1/time.Second))
+	binary.BigEndian.PutUint32(b[8:12], uint32(ia.t2/time.Second))
+	if ia.prefix == nil {
+		return b
+	}
+	p := make([]byte, 25)
+	binary.BigEndian.PutUint32(p[0:4], uint32(ia.preferred/time.Second))
+	binary.BigEndian.PutUint32(p[4:8], uint32(ia.valid/time.Second))
+	ones, _ := ia.prefix.Mask.Size()
+	p[8] = byte(ones)
+	copy(p[9:25], ia.prefix.IP.To16())
+	return appendOptions6(b, []option6{{code: opt6IAPrefix, data: p}})
+}
+
+// parseIAPD returns the identity association of the message, with the first
+// prefix delegated to it
+func parseIAPD(m *message6) (*iaPD, error) {
+	b := m.option(opt6IAPD)
+	if len(b) < 12 {
+		return nil, errors.New("no prefix delegation in the dhcpv6 reply")
+	}
+	ia := &iaPD{
+		iaid: binary.BigEndian.Uint32(b[0:4]),
+		t1:   time.Duration(binary.BigEndian.Uint32(b[4:8])) * time.Second,
+		t2:   time.Duration(binary.BigEndian.Uint32(b[8:12])) * time.Second,
+	}
+	opts, err := parseOptions6(b[12:])
+	if err != nil {
+		return nil, err
+	}
+	if st := status6(opts); st != 0 {
+		return nil, errors.New("prefix delegation refused by the dhcpv6 server")
+	}
+	for _, o := range opts {
+		if o.code != opt6IAPrefix || len(o.data) < 25 {
+			continue
+		}
+		ia.preferred = time.Duration(binary.BigEndian.Uint32(o.data[0:4])) * time.Second
+		ia.valid = time.Duration(binary.BigEndian.Uint32(o.data[4:8])) * time.Second
+		// A prefix being withdrawn
+		if ia.valid == 0 {
+			continue
+		}
+		ip := net.IP(append([]byte(nil), o.data[9:25]...))
+		mask := net.CIDRMask(int(o.data[8]), 128)
+		ia.prefix = &net.IPNet{IP: ip.Mask(mask), Mask: mask}
+		return ia, nil
+	}
+	return nil, errors.New("no prefix delegated by the dhcpv6 server")
+}
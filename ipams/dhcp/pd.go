@@ -0,0 +1,247 @@
+package dhcp
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/docker/libnetwork/netutils"
+	"github.com/sirupsen/logrus"
+)
+
+// transport6 carries the DHCPv6 messages on the interface the prefixes are
+// delegated through
+type transport6 interface {
+	// send multicasts the message to the servers and relay agents of the link
+	send(m *message6) error
+	receive(deadline time.Time) (*message6, error)
+	close() error
+}
+
+// delegation is a prefix delegated to a pool and kept renewed until the pool
+// is released
+type delegation struct {
+	sync.Mutex
+	iface    string
+	duid     []byte
+	serverID []byte
+	ia       *iaPD
+	obtained time.Time
+	stop     chan struct{}
+}
+
+func newDelegation(iface string) *delegation {
+	hw := netutils.GenerateRandomMAC()
+	if i, err := net.InterfaceByName(iface); err == nil && len(i.HardwareAddr) == 6 {
+		hw = i.HardwareAddr
+	}
+	// DUID-LL of an ethernet address
+	duid := append([]byte{0, 3, 0, 1}, hw...)
+
+	b := make([]byte, 4)
+	rand.Read(b)
+	return &delegation{
+		iface: iface,
+		duid:  duid,
+		ia:    &iaPD{iaid: binary.BigEndian.Uint32(b)},
+		stop:  make(chan struct{}),
+	}
+}
+
+func (d *delegation) newMessage(t byte) *message6 {
+	m := &message6{msgType: t, xid: newXid() & 0xffffff}
+	m.add(opt6ClientID, d.duid)
+	m.add(opt6ElapsedTime, []byte{0, 0})
+	return m
+}
+
+func exchange6(t transport6, req *message6, want byte) (*message6, error) {
+	for _, timeout := range retryTimeouts {
+		if err := t.send(req); err != nil {
+			return nil, err
+		}
+		deadline := time.Now().Add(timeout)
+		for {
+			rep, err := t.receive(deadline)
+			if err != nil {
+				if ne, ok := err.(net.Error); ok && ne.Timeout() {
+					break
+				}
+				return nil, err
+			}
+			if rep.msgType != want || rep.xid != req.xid {
+				continue
+			}
+			return rep, nil
+		}
+	}
+	return nil, fmt.Errorf("no reply from a dhcpv6 server")
+}
+
+// solicit obtains a prefix from a server of the link
+func (d *delegation) solicit(t transport6) error {
+	d.Lock()
+	sol := d.newMessage(msg6Solicit)
+	sol.add(opt6IAPD, (&iaPD{iaid: d.ia.iaid}).marshal())
+	d.Unlock()
+
+	adv, err := exchange6(t, sol, msg6Advertise)
+	if err != nil {
+		return err
+	}
+	ia, err := parseIAPD(adv)
+	if err != nil {
+		return err
+	}
+	sid := adv.option(opt6ServerID)
+	if sid == nil {
+		return fmt.Errorf("no server identifier in the dhcpv6 advertise")
+	}
+
+	req := d.newMessage(msg6Request)
+	req.add(opt6ServerID, sid)
+	req.add(opt6IAPD, ia.marshal())
+	rep, err := exchange6(t, req, msg6Reply)
+	if err != nil {
+		return err
+	}
+	_, err = d.update(rep)
+	return err
+}
+
+// renew extends the lifetimes of the delegated prefix, through the server
+// which delegated it or, once T2 is reached, through any server. It returns
+// the previous prefix when the server renumbered the delegation.
+func (d *delegation) renew(t transport6, rebind bool) (*net.IPNet, error) {
+	d.Lock()
+	var req *message6
+	if rebind {
+		req = d.newMessage(msg6Rebind)
+	} else {
+		req = d.newMessage(msg6Renew)
+		req.add(opt6ServerID, d.serverID)
+	}
+	req.add(opt6IAPD, d.ia.marshal())
+	d.Unlock()
+
+	rep, err := exchange6(t, req, msg6Reply)
+	if err != nil {
+		return nil, err
+	}
+	return d.update(rep)
+}
+
+func (d *delegation) update(rep *message6) (*net.IPNet, error) {
+	if status6(rep.options) != 0 {
+		return nil, fmt.Errorf("request refused by the dhcpv6 server")
+	}
+	ia, err := parseIAPD(rep)
+	if err != nil {
+		return nil, err
+	}
+	if ia.t1 == 0 || ia.t1 >= ia.valid {
+		ia.t1 = ia.preferred / 2
+	}
+	if ia.t2 == 0 || ia.t2 < ia.t1 {
+		ia.t2 = ia.t1 + ia.t1/2
+	}
+
+	d.Lock()
+	defer d.Unlock()
+	var previous *net.IPNet
+	if d.ia.prefix != nil && !bytes.Equal(d.ia.prefix.IP, ia.prefix.IP) {
+		previous = d.ia.prefix
+	}
+	d.ia = ia
+	d.serverID = rep.option(opt6ServerID)
+	d.obtained = time.Now()
+	return previous, nil
+}
+
+func (d *delegation) release(t transport6) error {
+	d.Lock()
+	m := d.newMessage(msg6Release)
+	m.add(opt6ServerID, d.serverID)
+	m.add(opt6IAPD, d.ia.marshal())
+	d.Unlock()
+	return t.send(m)
+}
+
+// maintainDelegation renews the prefix of the pool until it is released. A
+// renumbered prefix cannot be applied to the network: its endpoints keep the
+// addresses of the prefix delegated at creation, which stay valid until the
+// lifetime granted last for it expires.
+func (a *allocator) maintainDelegation(p *pool) {
+	d := p.delegation
+	d.Lock()
+	next := d.ia.t1
+	d.Unlock()
+
+	for {
+		timer := time.NewTimer(next)
+		select {
+		case <-d.stop:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		d.Lock()
+		elapsed := time.Since(d.obtained)
+		t1, t2, valid := d.ia.t1, d.ia.t2, d.ia.valid
+		d.Unlock()
+
+		var previous *net.IPNet
+		err := a.withTransport6(d.iface, func(t transport6) error {
+			var err error
+			previous, err = d.renew(t, elapsed >= t2)
+			return err
+		})
+		if err == nil {
+			if previous != nil {
+				logrus.Warnf("Prefix %s delegated on %s was renumbered, the network has to be recreated to use the new prefix", previous, d.iface)
+			}
+			d.Lock()
+			next = d.ia.t1
+			d.Unlock()
+			logrus.Debugf("Renewed prefix delegation %s on %s", p.subnet, d.iface)
+			continue
+		}
+
+		remaining := valid - elapsed
+		if remaining <= 0 {
+			logrus.Errorf("Prefix %s delegated on %s expired: %v", p.subnet, d.iface, err)
+		} else {
+			logrus.Warnf("Failed to renew prefix %s delegated on %s: %v", p.subnet, d.iface, err)
+		}
+		next = t1 / 4
+		if next < minRetryInterval {
+			next = minRetryInterval
+		}
+	}
+}
+
+// nextAddress carves an unallocated address out of the delegated prefix
+func (p *pool) nextAddress() (net.IP, error) {
+	ones, bits := p.subnet.Mask.Size()
+	size := uint64(1<<62) - 1
+	if bits-ones < 62 {
+		size = uint64(1)<<uint(bits-ones) - 1
+	}
+	for i := uint64(0); i < size; i++ {
+		p.next = p.next%size + 1
+		ip := make(net.IP, net.IPv6len)
+		copy(ip, p.subnet.IP.To16())
+		lo := binary.BigEndian.Uint64(ip[8:]) | p.next
+		binary.BigEndian.PutUint64(ip[8:], lo)
+		if !p.addresses[ip.String()] {
+			p.addresses[ip.String()] = true
+			return ip, nil
+		}
+	}
+	return nil, fmt.Errorf("prefix %s is exhausted", p.subnet)
+}
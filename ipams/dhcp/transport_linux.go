@@ -0,0 +1,126 @@
+package dhcp
+
+import (
+	"net"
+	"os"
+	"syscall"
+	"time"
+)
+
+const (
+	clientPort  = 68
+	serverPort  = 67
+	clientPort6 = 546
+	serverPort6 = 547
+)
+
+// All_DHCP_Relay_Agents_and_Servers
+var allServers6 = net.ParseIP("ff02::1:2")
+
+type udpTransport struct {
+	conn net.PacketConn
+}
+
+type udpTransport6 struct {
+	conn  net.PacketConn
+	iface string
+}
+
+// bindClient binds an UDP socket of the family to the client port on the
+// interface
+func bindClient(family int, iface string, sa syscall.Sockaddr) (net.PacketConn, error) {
+	fd, err := syscall.Socket(family, syscall.SOCK_DGRAM|syscall.SOCK_CLOEXEC, syscall.IPPROTO_UDP)
+	if err != nil {
+		return nil, err
+	}
+	f := os.NewFile(uintptr(fd), "dhcp-"+iface)
+	defer f.Close()
+
+	opts := []int{syscall.SO_REUSEADDR}
+	if family == syscall.AF_INET {
+		opts = append(opts, syscall.SO_BROADCAST)
+	}
+	for _, opt := range opts {
+		if err := syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, opt, 1); err != nil {
+			return nil, err
+		}
+	}
+	if err := syscall.BindToDevice(fd, iface); err != nil {
+		return nil, err
+	}
+	if err := syscall.Bind(fd, sa); err != nil {
+		return nil, err
+	}
+	return net.FilePacketConn(f)
+}
+
+// openTransport binds the DHCP client port on the interface
+func openTransport(iface string) (transport, error) {
+	conn, err := bindClient(syscall.AF_INET, iface, &syscall.SockaddrInet4{Port: clientPort})
+	if err != nil {
+		return nil, err
+	}
+	return &udpTransport{conn: conn}, nil
+}
+
+// openTransport6 binds the DHCPv6 client port on the interface
+func openTransport6(iface string) (transport6, error) {
+	conn, err := bindClient(syscall.AF_INET6, iface, &syscall.SockaddrInet6{Port: clientPort6})
+	if err != nil {
+		return nil, err
+	}
+	return &udpTransport6{conn: conn, iface: iface}, nil
+}
+
+func (t *udpTransport) send(m *message, dst net.IP) error {
+	if dst == nil {
+		dst = net.IPv4bcast
+	}
+	_, err := t.conn.WriteTo(m.marshal(), &net.UDPAddr{IP: dst, Port: serverPort})
+	return err
+}
+
+func (t *udpTransport) receive(deadline time.Time) (*message, error) {
+	if err := t.conn.SetReadDeadline(deadline); err != nil {
+		return nil, err
+	}
+	b := make([]byte, 1500)
+	for {
+		n, _, err := t.conn.ReadFrom(b)
+		if err != nil {
+			return nil, err
+		}
+		if m, err := parseMessage(b[:n]); err == nil {
+			return m, nil
+		}
+	}
+}
+
+func (t *udpTransport) close() error {
+	return t.conn.Close()
+}
+
+func (t *udpTransport6) send(m *message6) error {
+	_, err := t.conn.WriteTo(m.marshal(), &net.UDPAddr{IP: allServers6, Port: serverPort6, Zone: t.iface})
+	return err
+}
+
+func (t *udpTransport6) receive(deadline time.Time) (*message6, error) {
+	if err := t.conn.SetReadDeadline(deadline); err != nil {
+		return nil, err
+	}
+	b := make([]byte, 1500)
+	for {
+		n, _, err := t.conn.ReadFrom(b)
+		if err != nil {
+			return nil, err
+		}
+		if m, err := parseMessage6(b[:n]); err == nil {
+			return m, nil
+		}
+	}
+}
+
+func (t *udpTransport6) close() error {
+	return t.conn.Close()
+}
@@ -0,0 +1,13 @@
+// +build !linux
+
+package dhcp
+
+import "github.com/docker/libnetwork/types"
+
+func openTransport(iface string) (transport, error) {
+	return nil, types.NotImplementedErrorf("dhcp ipam driver is not supported on this platform")
+}
+
+func openTransport6(iface string) (transport6, error) {
+	return nil, types.NotImplementedErrorf("dhcp ipam driver is not supported on this platform")
+}
@@ -0,0 +1,308 @@
+// Package rest implements an ipam driver backed by an external IPAM system,
+// such as a corporate one, reached through a small REST contract. Unlike a
+// remote ipam plugin the driver is configured on the daemon and registered
+// under the configured name, the external system only having to serve the
+// five calls below.
+//
+// Every call is a POST of a JSON object to the path under the base URL,
+// answered with a JSON object:
+//
+//	/pools/allocate      {"address_space", "pool", "sub_pool", "v6", "metadata"}
+//	                     -> {"pool_id", "pool", "data"}
+//	/pools/release       {"pool_id"}
+//	/addresses/allocate  {"pool_id", "metadata"} -> {"address", "data"}
+//	/addresses/reserve   {"pool_id", "address", "metadata"} -> {"address", "data"}
+//	/addresses/release   {"pool_id", "address"}
+//
+// Pools and addresses are in CIDR notation, an allocated address carrying
+// the prefix length of its pool. An empty pool asks for one chosen by the
+// IPAM system. A reserve asks for a specific address, the gateway of a
+// network or the static address of an endpoint, whereas an allocate lets
+// the IPAM system pick one. The metadata are the ipam options of the
+// network or of the endpoint, the endpoint MAC address among them.
+//
+// A failed call is answered with a non 2xx status and {"error", "code"},
+// the code being one of the Code constants so that the caller can tell,
+// for one, an exhausted pool from an unreachable IPAM system.
+//
+// With a secret configured each request carries the Unix time it was sent
+// at in the X-Libnetwork-Timestamp header and, in X-Libnetwork-Signature,
+// sha256= followed by the hex encoded HMAC-SHA256, keyed with the secret,
+// of the timestamp, the path and the body, joined by newlines.
+package rest
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/libnetwork/discoverapi"
+	"github.com/docker/libnetwork/ipamapi"
+	"github.com/docker/libnetwork/types"
+)
+
+// The paths of the calls
+const (
+	PathAllocatePool    = "/pools/allocate"
+	PathReleasePool     = "/pools/release"
+	PathAllocateAddress = "/addresses/allocate"
+	PathReserveAddress  = "/addresses/reserve"
+	PathReleaseAddress  = "/addresses/release"
+)
+
+// The headers of the signed requests
+const (
+	HeaderTimestamp = "X-Libnetwork-Timestamp"
+	HeaderSignature = "X-Libnetwork-Signature"
+)
+
+// The error codes of the failed calls, mapped to the ipamapi errors
+const (
+	CodePoolNotFound    = "pool_not_found"
+	CodeNoAvailablePool = "no_available_pool"
+	CodeNoAvailableIPs  = "no_available_ips"
+	CodeAddressInUse    = "address_in_use"
+	CodeOutOfRange      = "out_of_range"
+	CodePoolOverlap     = "pool_overlap"
+	CodeInvalidRequest  = "invalid_request"
+)
+
+var codeErrors = map[string]error{
+	CodePoolNotFound:    ipamapi.ErrPoolNotFound,
+	CodeNoAvailablePool: ipamapi.ErrNoAvailablePool,
+	CodeNoAvailableIPs:  ipamapi.ErrNoAvailableIPs,
+	CodeAddressInUse:    ipamapi.ErrIPAlreadyAllocated,
+	CodeOutOfRange:      ipamapi.ErrIPOutOfRange,
+	CodePoolOverlap:     ipamapi.ErrPoolOverlap,
+	CodeInvalidRequest:  ipamapi.ErrInvalidRequest,
+}
+
+const defaultTimeout = 10 * time.Second
+
+// Config configures a rest ipam driver
+type Config struct {
+	// Name is the name the driver is registered under
+	Name string
+	// URL is the base URL of the calls
+	URL string
+	// Secret is the key the requests are signed with, the requests being
+	// unsigned without it
+	Secret string
+	// AddressSpace is the local and global default address space, the
+	// name of the driver when empty
+	AddressSpace string
+	// Timeout bounds each call
+	Timeout time.Duration
+}
+
+// PoolRequest is the body of a /pools/allocate call
+type PoolRequest struct {
+	AddressSpace string            `json:"address_space"`
+	Pool         string            `json:"pool,omitempty"`
+	SubPool      string            `json:"sub_pool,omitempty"`
+	V6           bool              `json:"v6"`
+	Metadata     map[string]string `json:"metadata,omitempty"`
+}
+
+// PoolResponse is the answer to a /pools/allocate call
+type PoolResponse struct {
+	PoolID string            `json:"pool_id"`
+	Pool   string            `json:"pool"`
+	Data   map[string]string `json:"data,omitempty"`
+}
+
+// ReleasePoolRequest is the body of a /pools/release call
+type ReleasePoolRequest struct {
+	PoolID string `json:"pool_id"`
+}
+
+// AddressRequest is the body of the /addresses calls, the address being
+// set on a reserve and on a release
+type AddressRequest struct {
+	PoolID   string            `json:"pool_id"`
+	Address  string            `json:"address,omitempty"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// AddressResponse is the answer to an /addresses/allocate or an
+// /addresses/reserve call
+type AddressResponse struct {
+	Address string            `json:"address"`
+	Data    map[string]string `json:"data,omitempty"`
+}
+
+// ErrorResponse is the answer to a failed call
+type ErrorResponse struct {
+	Error string `json:"error"`
+	Code  string `json:"code,omitempty"`
+}
+
+type allocator struct {
+	cfg    Config
+	base   string
+	client *http.Client
+}
+
+// Register validates the configuration and registers the driver
+func Register(cb ipamapi.Callback, cfg Config) error {
+	if cfg.Name == "" {
+		return fmt.Errorf("rest ipam driver name must be set")
+	}
+	u, err := url.Parse(cfg.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid URL %q of rest ipam driver %s: expected an http or https URL", cfg.URL, cfg.Name)
+	}
+	if cfg.AddressSpace == "" {
+		cfg.AddressSpace = cfg.Name
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = defaultTimeout
+	}
+	a := &allocator{cfg: cfg, base: strings.TrimRight(cfg.URL, "/"), client: &http.Client{}}
+	return cb.RegisterIpamDriverWithCapabilities(cfg.Name, a, &ipamapi.Capability{RequiresMACAddress: true})
+}
+
+func (a *allocator) GetDefaultAddressSpaces() (string, string, error) {
+	return a.cfg.AddressSpace, a.cfg.AddressSpace, nil
+}
+
+func (a *allocator) RequestPool(addressSpace, pool, subPool string, options map[string]string, v6 bool) (string, *net.IPNet, map[string]string, error) {
+	if addressSpace != a.cfg.AddressSpace {
+		return "", nil, nil, types.BadRequestErrorf("unknown address space: %s", addressSpace)
+	}
+	req := &PoolRequest{AddressSpace: addressSpace, Pool: pool, SubPool: subPool, V6: v6, Metadata: options}
+	var res PoolResponse
+	if err := a.call(PathAllocatePool, req, &res); err != nil {
+		return "", nil, nil, err
+	}
+	if res.PoolID == "" {
+		return "", nil, nil, fmt.Errorf("rest ipam %s returned no pool id", a.cfg.Name)
+	}
+	p, err := types.ParseCIDR(res.Pool)
+	if err != nil {
+		return "", nil, nil, fmt.Errorf("rest ipam %s returned an invalid pool %q: %v", a.cfg.Name, res.Pool, err)
+	}
+	return res.PoolID, p, res.Data, nil
+}
+
+func (a *allocator) ReleasePool(poolID string) error {
+	return a.call(PathReleasePool, &ReleasePoolRequest{PoolID: poolID}, nil)
+}
+
+func (a *allocator) RequestAddress(poolID string, prefAddress net.IP, options map[string]string) (*net.IPNet, map[string]string, error) {
+	path := PathAllocateAddress
+	req := &AddressRequest{PoolID: poolID, Metadata: options}
+	if prefAddress != nil {
+		path = PathReserveAddress
+		req.Address = prefAddress.String()
+	}
+	var res AddressResponse
+	if err := a.call(path, req, &res); err != nil {
+		return nil, nil, err
+	}
+	if res.Address == "" {
+		return nil, nil, ipamapi.ErrNoIPReturned
+	}
+	addr, err := types.ParseCIDR(res.Address)
+	if err != nil {
+		return nil, nil, fmt.Errorf("rest ipam %s returned an invalid address %q: %v", a.cfg.Name, res.Address, err)
+	}
+	if prefAddress != nil && !addr.IP.Equal(prefAddress) {
+		return nil, nil, fmt.Errorf("rest ipam %s reserved %s instead of %s", a.cfg.Name, addr.IP, prefAddress)
+	}
+	return addr, res.Data, nil
+}
+
+func (a *allocator) ReleaseAddress(poolID string, address net.IP) error {
+	req := &AddressRequest{PoolID: poolID}
+	if address != nil {
+		req.Address = address.String()
+	}
+	return a.call(PathReleaseAddress, req, nil)
+}
+
+func (a *allocator) DiscoverNew(dType discoverapi.DiscoveryType, data interface{}) error {
+	return nil
+}
+
+func (a *allocator) DiscoverDelete(dType discoverapi.DiscoveryType, data interface{}) error {
+	return nil
+}
+
+// IsBuiltIn is true as the driver is registered by the daemon, no plugin
+// being allowed to take its name over
+func (a *allocator) IsBuiltIn() bool {
+	return true
+}
+
+// call posts the request to the path and decodes the answer into res,
+// unless nil
+func (a *allocator) call(path string, req, res interface{}) error {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), a.cfg.Timeout)
+	defer cancel()
+	hreq, err := http.NewRequest(http.MethodPost, a.base+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	hreq.Header.Set("Content-Type", "application/json")
+	if a.cfg.Secret != "" {
+		ts := strconv.FormatInt(time.Now().Unix(), 10)
+		hreq.Header.Set(HeaderTimestamp, ts)
+		hreq.Header.Set(HeaderSignature, "sha256="+Sign(a.cfg.Secret, ts, path, body))
+	}
+	resp, err := a.client.Do(hreq.WithContext(ctx))
+	if err != nil {
+		return types.InternalErrorf("rest ipam %s %s failed: %v", a.cfg.Name, path, err)
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return types.InternalErrorf("rest ipam %s %s failed: %v", a.cfg.Name, path, err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		var e ErrorResponse
+		json.Unmarshal(b, &e)
+		if err, ok := codeErrors[e.Code]; ok {
+			return err
+		}
+		if e.Error == "" {
+			e.Error = strings.TrimSpace(string(b))
+		}
+		return types.InternalErrorf("rest ipam %s %s failed with status %s: %s", a.cfg.Name, path, resp.Status, e.Error)
+	}
+	if res == nil {
+		return nil
+	}
+	if err := json.Unmarshal(b, res); err != nil {
+		return types.InternalErrorf("rest ipam %s %s returned an invalid answer: %v", a.cfg.Name, path, err)
+	}
+	return nil
+}
+
+// Sign returns the hex encoded HMAC-SHA256, keyed with the secret, of the
+// timestamp, the path and the body of a request, joined by newlines
+func Sign(secret, timestamp, path string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte{'\n'})
+	mac.Write([]byte(path))
+	mac.Write([]byte{'\n'})
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
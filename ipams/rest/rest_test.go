@@ -0,0 +1,184 @@
+package rest
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/docker/docker/pkg/plugingetter"
+	"github.com/docker/libnetwork/ipamapi"
+)
+
+type callback struct {
+	name   string
+	driver ipamapi.Ipam
+	caps   *ipamapi.Capability
+}
+
+func (cb *callback) GetPluginGetter() plugingetter.PluginGetter {
+	return nil
+}
+
+func (cb *callback) RegisterIpamDriver(name string, driver ipamapi.Ipam) error {
+	return cb.RegisterIpamDriverWithCapabilities(name, driver, nil)
+}
+
+func (cb *callback) RegisterIpamDriverWithCapabilities(name string, driver ipamapi.Ipam, caps *ipamapi.Capability) error {
+	cb.name, cb.driver, cb.caps = name, driver, caps
+	return nil
+}
+
+// fakeIPAM serves the contract out of 10.20.0.0/24, handing the addresses
+// out in order
+type fakeIPAM struct {
+	secret string
+	sync.Mutex
+	used     map[string]map[string]string
+	next     int
+	released []string
+}
+
+func (f *fakeIPAM) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	if sig := r.Header.Get(HeaderSignature); sig != "sha256="+Sign(f.secret, r.Header.Get(HeaderTimestamp), r.URL.Path, body) {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(&ErrorResponse{Error: "bad signature"})
+		return
+	}
+	f.Lock()
+	defer f.Unlock()
+	fail := func(status int, code string) {
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(&ErrorResponse{Error: code, Code: code})
+	}
+	switch r.URL.Path {
+	case PathAllocatePool:
+		var req PoolRequest
+		json.Unmarshal(body, &req)
+		if req.V6 {
+			fail(http.StatusServiceUnavailable, CodeNoAvailablePool)
+			return
+		}
+		json.NewEncoder(w).Encode(&PoolResponse{PoolID: "pool1", Pool: "10.20.0.0/24", Data: map[string]string{"vlan": "20"}})
+	case PathReleasePool:
+		f.released = append(f.released, "pool")
+	case PathAllocateAddress, PathReserveAddress:
+		var req AddressRequest
+		json.Unmarshal(body, &req)
+		if req.PoolID != "pool1" {
+			fail(http.StatusNotFound, CodePoolNotFound)
+			return
+		}
+		addr := req.Address
+		if r.URL.Path == PathAllocateAddress {
+			if f.next == 2 {
+				fail(http.StatusConflict, CodeNoAvailableIPs)
+				return
+			}
+			f.next++
+			addr = net.IPv4(10, 20, 0, byte(f.next)).String()
+		}
+		if _, ok := f.used[addr]; ok {
+			fail(http.StatusConflict, CodeAddressInUse)
+			return
+		}
+		f.used[addr] = req.Metadata
+		json.NewEncoder(w).Encode(&AddressResponse{Address: addr + "/24"})
+	case PathReleaseAddress:
+		var req AddressRequest
+		json.Unmarshal(body, &req)
+		delete(f.used, req.Address)
+		f.released = append(f.released, req.Address)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestRESTIPAM(t *testing.T) {
+	f := &fakeIPAM{secret: "s3cret", used: map[string]map[string]string{}}
+	srv := httptest.NewServer(f)
+	defer srv.Close()
+
+	cb := &callback{}
+	if err := Register(cb, Config{Name: "corp", URL: srv.URL + "/", Secret: "s3cret"}); err != nil {
+		t.Fatal(err)
+	}
+	if cb.name != "corp" || !cb.caps.RequiresMACAddress {
+		t.Fatalf("unexpected registration %s %+v", cb.name, cb.caps)
+	}
+	a := cb.driver
+
+	las, gas, err := a.GetDefaultAddressSpaces()
+	if err != nil || las != "corp" || gas != "corp" {
+		t.Fatalf("unexpected address spaces %s %s %v", las, gas, err)
+	}
+	poolID, pool, data, err := a.RequestPool("corp", "", "", nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if poolID != "pool1" || pool.String() != "10.20.0.0/24" || data["vlan"] != "20" {
+		t.Fatalf("unexpected pool %s %s %v", poolID, pool, data)
+	}
+	if _, _, _, err := a.RequestPool("corp", "", "", nil, true); err != ipamapi.ErrNoAvailablePool {
+		t.Fatalf("expected no available pool, got %v", err)
+	}
+	if _, _, _, err := a.RequestPool("other", "", "", nil, false); err == nil {
+		t.Fatal("pool of an unknown address space allocated")
+	}
+
+	gw, _, err := a.RequestAddress(poolID, net.ParseIP("10.20.0.254"), map[string]string{ipamapi.RequestAddressType: "gateway"})
+	if err != nil || gw.String() != "10.20.0.254/24" {
+		t.Fatalf("unexpected gateway %v %v", gw, err)
+	}
+	if _, _, err := a.RequestAddress(poolID, net.ParseIP("10.20.0.254"), nil); err != ipamapi.ErrIPAlreadyAllocated {
+		t.Fatalf("expected address in use, got %v", err)
+	}
+	addr, _, err := a.RequestAddress(poolID, nil, map[string]string{"com.docker.network.endpoint.macaddress": "02:42:0a:14:00:01"})
+	if err != nil || addr.String() != "10.20.0.1/24" {
+		t.Fatalf("unexpected address %v %v", addr, err)
+	}
+	if md := f.used["10.20.0.1"]; md["com.docker.network.endpoint.macaddress"] != "02:42:0a:14:00:01" {
+		t.Fatalf("unexpected metadata %v", md)
+	}
+	a.RequestAddress(poolID, nil, nil)
+	if _, _, err := a.RequestAddress(poolID, nil, nil); err != ipamapi.ErrNoAvailableIPs {
+		t.Fatalf("expected no available addresses, got %v", err)
+	}
+	if _, _, err := a.RequestAddress("pool2", nil, nil); err != ipamapi.ErrPoolNotFound {
+		t.Fatalf("expected pool not found, got %v", err)
+	}
+
+	if err := a.ReleaseAddress(poolID, addr.IP); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.ReleasePool(poolID); err != nil {
+		t.Fatal(err)
+	}
+	if len(f.released) != 2 || f.released[0] != "10.20.0.1" {
+		t.Fatalf("unexpected releases %v", f.released)
+	}
+
+	// An unsigned request is turned down by the IPAM system
+	if err := Register(cb, Config{Name: "corp", URL: srv.URL}); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, err := cb.driver.RequestPool("corp", "", "", nil, false); err == nil {
+		t.Fatal("unsigned request accepted")
+	}
+}
+
+func TestRegisterValidation(t *testing.T) {
+	for _, cfg := range []Config{
+		{URL: "http://ipam"},
+		{Name: "corp", URL: "ipam:8080"},
+		{Name: "corp", URL: "ftp://ipam"},
+	} {
+		if err := Register(&callback{}, cfg); err == nil {
+			t.Fatalf("invalid configuration %+v accepted", cfg)
+		}
+	}
+}
@@ -0,0 +1,232 @@
+// Package ipfix exports flow records to an IPFIX collector over UDP. The
+// records carry the 5-tuple of the flows, their start time and sampling
+// interval, and the IDs of the network and of the endpoints at both ends
+// in enterprise-specific information elements.
+package ipfix
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	version = 10
+
+	setTemplate = 2
+	// templateID is the ID of the template of the flow records
+	templateID = 256
+
+	headerLength    = 16
+	setHeaderLength = 4
+
+	// templateRefresh is the number of messages after which the template
+	// is sent again, for the collectors started since to decode the
+	// records
+	templateRefresh = 32
+	// maxMessageLength keeps the messages within the MTU of the links to
+	// the collectors
+	maxMessageLength = 1400
+
+	enterpriseBit = 0x8000
+	variableLen   = 0xffff
+)
+
+// DefaultEnterpriseNumber is the private enterprise number of the
+// enterprise-specific elements, the number IANA reserves for the examples
+// until one is configured
+const DefaultEnterpriseNumber = 32473
+
+// The information elements of the records
+const (
+	ieProtocolIdentifier       = 4
+	ieSourceTransportPort      = 7
+	ieSourceIPv4Address        = 8
+	ieDestinationTransportPort = 11
+	ieDestinationIPv4Address   = 12
+	ieSamplingInterval         = 34
+	ieFlowStartMilliseconds    = 152
+
+	// The enterprise-specific elements
+	ieNetworkID             = 1
+	ieSourceEndpointID      = 2
+	ieDestinationEndpointID = 3
+)
+
+type field struct {
+	id         uint16
+	length     uint16
+	enterprise bool
+}
+
+var template = []field{
+	{id: ieSourceIPv4Address, length: 4},
+	{id: ieDestinationIPv4Address, length: 4},
+	{id: ieProtocolIdentifier, length: 1},
+	{id: ieSourceTransportPort, length: 2},
+	{id: ieDestinationTransportPort, length: 2},
+	{id: ieFlowStartMilliseconds, length: 8},
+	{id: ieSamplingInterval, length: 4},
+	{id: ieNetworkID, length: variableLen, enterprise: true},
+	{id: ieSourceEndpointID, length: variableLen, enterprise: true},
+	{id: ieDestinationEndpointID, length: variableLen, enterprise: true},
+}
+
+// Record is a flow exported to the collector. The endpoint IDs are empty
+// for the ends of the flow out of the network.
+type Record struct {
+	Src, Dst         net.IP
+	Protocol         uint8
+	SrcPort, DstPort uint16
+	Start            time.Time
+	SamplingInterval uint32
+	NetworkID        string
+	SrcEndpoint      string
+	DstEndpoint      string
+}
+
+// encodeTemplateSet encodes the template set of the flow records
+func encodeTemplateSet(enterprise uint32) []byte {
+	b := make([]byte, setHeaderLength+4)
+	binary.BigEndian.PutUint16(b[setHeaderLength:], templateID)
+	binary.BigEndian.PutUint16(b[setHeaderLength+2:], uint16(len(template)))
+	for _, f := range template {
+		id := f.id
+		if f.enterprise {
+			id |= enterpriseBit
+		}
+		b = append(b, byte(id>>8), byte(id), byte(f.length>>8), byte(f.length))
+		if f.enterprise {
+			b = append(b, byte(enterprise>>24), byte(enterprise>>16), byte(enterprise>>8), byte(enterprise))
+		}
+	}
+	binary.BigEndian.PutUint16(b, setTemplate)
+	binary.BigEndian.PutUint16(b[2:], uint16(len(b)))
+	return b
+}
+
+// encodeRecord encodes the data record of the flow, the IPv4 flows only
+// fitting the template
+func encodeRecord(r *Record) ([]byte, error) {
+	src, dst := r.Src.To4(), r.Dst.To4()
+	if src == nil || dst == nil {
+		return nil, fmt.Errorf("flow %s -> %s is not an IPv4 flow", r.Src, r.Dst)
+	}
+	b := make([]byte, 0, 64)
+	b = append(b, src...)
+	b = append(b, dst...)
+	b = append(b, r.Protocol, byte(r.SrcPort>>8), byte(r.SrcPort), byte(r.DstPort>>8), byte(r.DstPort))
+	var ts [8]byte
+	binary.BigEndian.PutUint64(ts[:], uint64(r.Start.UnixNano()/int64(time.Millisecond)))
+	b = append(b, ts[:]...)
+	var si [4]byte
+	binary.BigEndian.PutUint32(si[:], r.SamplingInterval)
+	b = append(b, si[:]...)
+	for _, s := range []string{r.NetworkID, r.SrcEndpoint, r.DstEndpoint} {
+		if len(s) > 254 {
+			s = s[:254]
+		}
+		b = append(b, byte(len(s)))
+		b = append(b, s...)
+	}
+	return b, nil
+}
+
+// Exporter sends the flow records to a collector
+type Exporter struct {
+	conn       net.Conn
+	domain     uint32
+	enterprise uint32
+	sequence   uint32
+	messages   int
+	sync.Mutex
+}
+
+// NewExporter returns an exporter sending to the collector at the UDP
+// address, in the observation domain, with the enterprise-specific
+// elements of the enterprise, DefaultEnterpriseNumber if 0
+func NewExporter(collector string, domain, enterprise uint32) (*Exporter, error) {
+	conn, err := net.Dial("udp", collector)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach the IPFIX collector %s: %v", collector, err)
+	}
+	if enterprise == 0 {
+		enterprise = DefaultEnterpriseNumber
+	}
+	return &Exporter{conn: conn, domain: domain, enterprise: enterprise}, nil
+}
+
+// Export sends the records to the collector, in as many messages as they
+// take. The records which are not IPv4 flows are skipped.
+func (e *Exporter) Export(records []*Record) error {
+	e.Lock()
+	defer e.Unlock()
+	msgs := e.messagesOf(records, time.Now())
+	for _, m := range msgs {
+		if _, err := e.conn.Write(m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// messagesOf encodes the messages of the records, the template set heading
+// the first message and every templateRefresh messages after
+func (e *Exporter) messagesOf(records []*Record, now time.Time) [][]byte {
+	var (
+		msgs [][]byte
+		data []byte
+		body []byte
+	)
+	flush := func(count int) {
+		if len(data) == 0 {
+			return
+		}
+		var set [setHeaderLength]byte
+		binary.BigEndian.PutUint16(set[:], templateID)
+		binary.BigEndian.PutUint16(set[2:], uint16(setHeaderLength+len(data)))
+		body = append(append(body, set[:]...), data...)
+
+		msg := make([]byte, headerLength, headerLength+len(body))
+		binary.BigEndian.PutUint16(msg, version)
+		binary.BigEndian.PutUint16(msg[2:], uint16(headerLength+len(body)))
+		binary.BigEndian.PutUint32(msg[4:], uint32(now.Unix()))
+		binary.BigEndian.PutUint32(msg[8:], e.sequence)
+		binary.BigEndian.PutUint32(msg[12:], e.domain)
+		msgs = append(msgs, append(msg, body...))
+
+		e.sequence += uint32(count)
+		e.messages++
+		data, body = nil, nil
+	}
+	start := func() {
+		if e.messages%templateRefresh == 0 {
+			body = encodeTemplateSet(e.enterprise)
+		}
+	}
+
+	start()
+	count := 0
+	for _, r := range records {
+		rec, err := encodeRecord(r)
+		if err != nil {
+			continue
+		}
+		if headerLength+len(body)+setHeaderLength+len(data)+len(rec) > maxMessageLength {
+			flush(count)
+			count = 0
+			start()
+		}
+		data = append(data, rec...)
+		count++
+	}
+	flush(count)
+	return msgs
+}
+
+// Close closes the connection to the collector
+func (e *Exporter) Close() error {
+	return e.conn.Close()
+}
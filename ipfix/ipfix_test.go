@@ -0,0 +1,74 @@
+package ipfix
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestEncodeTemplateSet(t *testing.T) {
+	b := encodeTemplateSet(DefaultEnterpriseNumber)
+	if id := binary.BigEndian.Uint16(b); id != setTemplate {
+		t.Fatalf("unexpected set ID %d", id)
+	}
+	// The standard elements take 4 bytes, the enterprise ones 8
+	if l := int(binary.BigEndian.Uint16(b[2:])); l != len(b) || l != setHeaderLength+4+7*4+3*8 {
+		t.Fatalf("unexpected template set length %d of %d bytes", l, len(b))
+	}
+	if id, count := binary.BigEndian.Uint16(b[4:]), binary.BigEndian.Uint16(b[6:]); id != templateID || count != uint16(len(template)) {
+		t.Fatalf("unexpected template %d of %d fields", id, count)
+	}
+	last := b[len(b)-8:]
+	if id := binary.BigEndian.Uint16(last); id != ieDestinationEndpointID|enterpriseBit {
+		t.Fatalf("unexpected last element %x", id)
+	}
+	if pen := binary.BigEndian.Uint32(last[4:]); pen != DefaultEnterpriseNumber {
+		t.Fatalf("unexpected enterprise number %d", pen)
+	}
+}
+
+func TestMessages(t *testing.T) {
+	e := &Exporter{domain: 7, enterprise: DefaultEnterpriseNumber}
+	r := &Record{
+		Src: net.ParseIP("172.17.0.2"), Dst: net.ParseIP("172.17.0.3"), Protocol: 6, SrcPort: 40000, DstPort: 80,
+		Start: time.Unix(1000, 0), SamplingInterval: 10, NetworkID: "n1", SrcEndpoint: "ep1", DstEndpoint: "ep2",
+	}
+	rec, err := encodeRecord(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rec) != 25+(1+2)+(1+3)+(1+3) {
+		t.Fatalf("unexpected record length %d", len(rec))
+	}
+	if _, err := encodeRecord(&Record{Src: net.ParseIP("fd00::1"), Dst: net.ParseIP("fd00::2")}); err == nil {
+		t.Fatal("expected an error for an IPv6 flow")
+	}
+
+	var records []*Record
+	for i := 0; i < 100; i++ {
+		records = append(records, r)
+	}
+	msgs := e.messagesOf(records, time.Unix(2000, 0))
+	if len(msgs) < 2 {
+		t.Fatalf("expected the records to be split, got %d messages", len(msgs))
+	}
+	for i, m := range msgs {
+		if len(m) > maxMessageLength || int(binary.BigEndian.Uint16(m[2:])) != len(m) || binary.BigEndian.Uint16(m) != version {
+			t.Fatalf("invalid message %d of %d bytes", i, len(m))
+		}
+		if domain := binary.BigEndian.Uint32(m[12:]); domain != 7 {
+			t.Fatalf("unexpected observation domain %d", domain)
+		}
+		// Only the first message carries the template
+		if hasTemplate := binary.BigEndian.Uint16(m[headerLength:]) == setTemplate; hasTemplate != (i == 0) {
+			t.Fatalf("unexpected template in message %d: %v", i, hasTemplate)
+		}
+	}
+	if e.sequence != 100 {
+		t.Fatalf("unexpected sequence number %d after 100 records", e.sequence)
+	}
+	if seq := binary.BigEndian.Uint32(msgs[1][8:]); seq == 0 {
+		t.Fatal("expected the sequence number of the second message to count the records of the first")
+	}
+}
@@ -0,0 +1,67 @@
+package iptables
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Backend runs the iptables and ip6tables commands in place of the system
+// binaries, as the in-memory fake of the unit tests running without
+// CAP_NET_ADMIN does
+type Backend interface {
+	// Run runs the command of the family with the arguments and returns
+	// its combined output, the error telling the command failed
+	Run(ipv IPV, args ...string) ([]byte, error)
+}
+
+var (
+	backendMu sync.Mutex
+	backend   Backend
+)
+
+// SetBackend makes the commands run through the backend, nil restoring the
+// system binaries. Neither firewalld nor iptables-restore are used while a
+// backend is set. It returns the function restoring the former backend.
+//
+// Example usage:
+//
+//	defer iptables.SetBackend(fake)()
+func SetBackend(b Backend) func() {
+	backendMu.Lock()
+	prev := backend
+	backend = b
+	backendMu.Unlock()
+	return func() {
+		backendMu.Lock()
+		backend = prev
+		backendMu.Unlock()
+	}
+}
+
+func currentBackend() Backend {
+	backendMu.Lock()
+	defer backendMu.Unlock()
+	return backend
+}
+
+// runBackend runs the command through the backend, its failure reported as
+// the one of the binary
+func runBackend(ctx context.Context, b Backend, ipv IPV, args []string) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	name := "iptables"
+	if ipv == IP6Tables {
+		name = "ip6tables"
+	}
+	logrus.Debugf("%s backend, %v", name, args)
+	output, err := b.Run(ipv, args...)
+	if err != nil {
+		return nil, fmt.Errorf("%s failed: %s %v: %s (%s)", name, name, strings.Join(args, " "), output, err)
+	}
+	return output, nil
+}
@@ -0,0 +1,58 @@
+package iptables
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// CountedRule is a rule of a chain with the packets and the bytes it
+// matched
+type CountedRule struct {
+	Rule
+	Packets uint64
+	Bytes   uint64
+}
+
+// RuleCounters lists the rules of the chain with their counters
+func RuleCounters(table Table, chain string) ([]CountedRule, error) {
+	out, err := Raw("-t", string(table), "-S", chain, "-v")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the counters of chain %s: %v", chain, err)
+	}
+	return parseRuleCounters(table, chain, string(out)), nil
+}
+
+// RuleCounters6 lists the rules of the ip6tables chain with their counters
+func RuleCounters6(table Table, chain string) ([]CountedRule, error) {
+	out, err := Raw6("-t", string(table), "-S", chain, "-v")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the counters of chain %s: %v", chain, err)
+	}
+	return parseRuleCounters(table, chain, string(out)), nil
+}
+
+// parseRuleCounters parses the rules of the chain as printed by iptables
+// -S -v, where the counters follow the -c option, which is left out of the
+// rules
+func parseRuleCounters(table Table, chain, out string) []CountedRule {
+	var rules []CountedRule
+	for _, line := range strings.Split(out, "\n") {
+		args := splitRuleArgs(line)
+		if len(args) < 2 || args[0] != "-A" || args[1] != chain {
+			continue
+		}
+		r := CountedRule{Rule: Rule{Table: table, Chain: chain}}
+		for i := 2; i < len(args); i++ {
+			if args[i] == "-c" && i+2 < len(args) {
+				r.Packets, _ = strconv.ParseUint(args[i+1], 10, 64)
+				r.Bytes, _ = strconv.ParseUint(args[i+2], 10, 64)
+				i += 2
+				continue
+			}
+			r.Args = append(r.Args, args[i])
+		}
+		rules = append(rules, r)
+	}
+	return rules
+}
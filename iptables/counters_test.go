@@ -0,0 +1,28 @@
+package iptables
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseRuleCounters(t *testing.T) {
+	out := `-N DOCKER-FILTER
+-A DOCKER-FILTER -d 172.18.0.2/32 -o br0 -p tcp -m tcp --dport 80 -m comment --comment "lnet-tier:tenant" -c 12 720 -j RETURN
+-A DOCKER-FILTER -d 172.18.0.2/32 -o br0 -c 3 180 -j REJECT --reject-with icmp-admin-prohibited
+-A FORWARD -c 1 60 -j ACCEPT
+`
+	rules := parseRuleCounters(Filter, "DOCKER-FILTER", out)
+	if len(rules) != 2 {
+		t.Fatalf("unexpected rules %+v", rules)
+	}
+	if rules[0].Packets != 12 || rules[0].Bytes != 720 || rules[1].Packets != 3 || rules[1].Bytes != 180 {
+		t.Fatalf("unexpected counters %+v", rules)
+	}
+	expected := []string{"-d", "172.18.0.2/32", "-o", "br0", "-j", "REJECT", "--reject-with", "icmp-admin-prohibited"}
+	if !reflect.DeepEqual(rules[1].Args, expected) {
+		t.Fatalf("unexpected rule %v, expected %v", rules[1].Args, expected)
+	}
+	if tier, ok := RuleTier(rules[0].Args); !ok || tier != TierTenant {
+		t.Fatalf("unexpected tier of %v", rules[0].Args)
+	}
+}
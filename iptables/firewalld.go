@@ -1,6 +1,7 @@
 package iptables
 
 import (
+	"context"
 	"fmt"
 	"strings"
 
@@ -20,15 +21,17 @@ const (
 	Ebtables IPV = "eb"
 )
 const (
-	dbusInterface = "org.fedoraproject.FirewallD1"
-	dbusPath      = "/org/fedoraproject/FirewallD1"
+	dbusInterface  = "org.fedoraproject.FirewallD1"
+	dbusPath       = "/org/fedoraproject/FirewallD1"
+	dbusConfigPath = "/org/fedoraproject/FirewallD1/config"
 )
 
 // Conn is a connection to firewalld dbus endpoint.
 type Conn struct {
-	sysconn *dbus.Conn
-	sysobj  dbus.BusObject
-	signal  chan *dbus.Signal
+	sysconn    *dbus.Conn
+	sysobj     dbus.BusObject
+	sysconfobj dbus.BusObject
+	signal     chan *dbus.Signal
 }
 
 var (
@@ -77,6 +80,7 @@ func (c *Conn) initConnection() error {
 
 	// This never fails, even if the service is not running atm.
 	c.sysobj = c.sysconn.Object(dbusInterface, dbus.ObjectPath(dbusPath))
+	c.sysconfobj = c.sysconn.Object(dbusInterface, dbus.ObjectPath(dbusConfigPath))
 
 	rule := fmt.Sprintf("type='signal',path='%s',interface='%s',sender='%s',member='Reloaded'",
 		dbusPath, dbusInterface, dbusInterface)
@@ -165,3 +169,20 @@ func Passthrough(ipv IPV, args ...string) ([]byte, error) {
 	}
 	return []byte(output), nil
 }
+
+// PassthroughContext calls Passthrough, returning the error of the context
+// if it is done before firewalld replies
+func PassthroughContext(ctx context.Context, ipv IPV, args ...string) ([]byte, error) {
+	var output string
+	logrus.Debugf("Firewalld passthrough: %s, %s", ipv, args)
+	call := connection.sysobj.Go(dbusInterface+".direct.passthrough", 0, make(chan *dbus.Call, 1), ipv, args)
+	select {
+	case <-call.Done:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	if err := call.Store(&output); err != nil {
+		return nil, err
+	}
+	return []byte(output), nil
+}
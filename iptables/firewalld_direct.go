@@ -0,0 +1,272 @@
+package iptables
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	"github.com/godbus/dbus"
+	"github.com/sirupsen/logrus"
+)
+
+// FirewalldZone is the zone the bridge interfaces are bound to in the
+// firewalld mode
+const FirewalldZone = "docker"
+
+// Priorities of the direct rules, firewalld orders the rules of a chain
+// by priority first and by insertion next
+const (
+	insertPriority = 0
+	appendPriority = 1
+)
+
+// firewalldMode tells if the rules are programmed as firewalld direct rules
+var firewalldMode bool
+
+// SetFirewalldMode makes the chains and rules be added as firewalld direct
+// chains and rules, which firewalld owns and replays on its reloads,
+// instead of being passed through to iptables. It has effect only while
+// firewalld is running.
+func SetFirewalldMode(enable bool) {
+	firewalldMode = enable
+}
+
+// FirewalldModeEnabled tells if the firewalld mode is in effect
+func FirewalldModeEnabled() bool {
+	initOnce.Do(initDependencies)
+	return firewalldMode && firewalldRunning
+}
+
+// directCommand is an iptables command which has a firewalld direct
+// equivalent
+type directCommand struct {
+	table  Table
+	action string
+	chain  string
+	rule   []string
+}
+
+// parseDirectCommand splits the iptables arguments, it fails for the
+// commands without a direct equivalent, listing ones included
+func parseDirectCommand(args []string) (*directCommand, bool) {
+	c := &directCommand{table: Filter}
+	if len(args) > 1 && args[0] == "-t" {
+		c.table = Table(args[1])
+		args = args[2:]
+	}
+	if len(args) < 2 {
+		return nil, false
+	}
+	c.action, c.chain, c.rule = args[0], args[1], args[2:]
+
+	switch c.action {
+	case "-A", "-D", "-C":
+		if len(c.rule) == 0 {
+			return nil, false
+		}
+	case "-I":
+		if len(c.rule) == 0 {
+			return nil, false
+		}
+		// The direct rules have no position
+		if _, err := strconv.Atoi(c.rule[0]); err == nil {
+			return nil, false
+		}
+	case "-N", "-X", "-F":
+		if len(c.rule) != 0 {
+			return nil, false
+		}
+	default:
+		return nil, false
+	}
+	return c, true
+}
+
+// directContext runs the command through the firewalld direct interface.
+// It returns false when the command has to be passed through, which is the
+// case of the queries and of the rules not added as direct rules.
+func directContext(ctx context.Context, ipv IPV, args []string) (bool, error) {
+	c, ok := parseDirectCommand(args)
+	if !ok {
+		return false, nil
+	}
+	logrus.Debugf("Firewalld direct: %s, %s", ipv, args)
+
+	switch c.action {
+	case "-A", "-I":
+		priority := appendPriority
+		if c.action == "-I" {
+			priority = insertPriority
+		}
+		return true, directCall(ctx, "addRule", ipv, string(c.table), c.chain, int32(priority), c.rule)
+	case "-C":
+		for _, priority := range []int{insertPriority, appendPriority} {
+			var found bool
+			if err := directStore(ctx, &found, "queryRule", ipv, string(c.table), c.chain, int32(priority), c.rule); err != nil {
+				return true, err
+			}
+			if found {
+				return true, nil
+			}
+		}
+		return false, nil
+	case "-D":
+		for _, priority := range []int{insertPriority, appendPriority} {
+			err := directCall(ctx, "removeRule", ipv, string(c.table), c.chain, int32(priority), c.rule)
+			if err == nil {
+				return true, nil
+			}
+			if !strings.Contains(err.Error(), "NOT_ENABLED") {
+				return true, err
+			}
+		}
+		return false, nil
+	case "-N":
+		return true, directCall(ctx, "addChain", ipv, string(c.table), c.chain)
+	case "-X":
+		err := directCall(ctx, "removeChain", ipv, string(c.table), c.chain)
+		if err != nil && strings.Contains(err.Error(), "NOT_ENABLED") {
+			return false, nil
+		}
+		return true, err
+	case "-F":
+		// Flush the rules iptables holds besides the direct ones too
+		if err := directCall(ctx, "removeRules", ipv, string(c.table), c.chain); err != nil {
+			return true, err
+		}
+		return false, nil
+	}
+	return false, nil
+}
+
+func directCall(ctx context.Context, method string, args ...interface{}) error {
+	err := directStore(ctx, nil, method, args...)
+	if err != nil && strings.Contains(err.Error(), "ALREADY_ENABLED") {
+		return nil
+	}
+	return err
+}
+
+func directStore(ctx context.Context, ret interface{}, method string, args ...interface{}) error {
+	call := connection.sysobj.Go(dbusInterface+".direct."+method, 0, make(chan *dbus.Call, 1), args...)
+	select {
+	case <-call.Done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	if ret == nil {
+		return call.Err
+	}
+	return call.Store(ret)
+}
+
+// firewalldZone are the settings of a permanent zone, in the order of the
+// firewalld D-Bus zone settings
+type firewalldZone struct {
+	version            string
+	name               string
+	description        string
+	unused             bool
+	target             string
+	services           []string
+	ports              [][]interface{}
+	icmpBlocks         []string
+	masquerade         bool
+	forwardPorts       [][]interface{}
+	interfaces         []string
+	sourceAddresses    []string
+	richRules          []string
+	protocols          []string
+	sourcePorts        [][]interface{}
+	icmpBlockInversion bool
+}
+
+func (z firewalldZone) settings() []interface{} {
+	return []interface{}{
+		z.version,
+		z.name,
+		z.description,
+		z.unused,
+		z.target,
+		z.services,
+		z.ports,
+		z.icmpBlocks,
+		z.masquerade,
+		z.forwardPorts,
+		z.interfaces,
+		z.sourceAddresses,
+		z.richRules,
+		z.protocols,
+		z.sourcePorts,
+		z.icmpBlockInversion,
+	}
+}
+
+// SetupFirewalldZone creates the permanent FirewalldZone, accepting the
+// traffic of its interfaces, unless it exists
+func SetupFirewalldZone() error {
+	if !FirewalldModeEnabled() {
+		return nil
+	}
+
+	var zones []string
+	if err := connection.sysobj.Call(dbusInterface+".zone.getZones", 0).Store(&zones); err != nil {
+		return err
+	}
+	for _, z := range zones {
+		if z == FirewalldZone {
+			return nil
+		}
+	}
+
+	logrus.Debugf("Firewalld: creating the %s zone", FirewalldZone)
+	zone := firewalldZone{
+		name:        FirewalldZone,
+		description: "zone of the interfaces of the docker networks",
+		target:      "ACCEPT",
+	}
+	if err := connection.sysconfobj.Call(dbusInterface+".config.addZone", 0, FirewalldZone, zone.settings()).Err; err != nil {
+		return err
+	}
+	// The permanent zone is available at runtime after the reload
+	return connection.sysobj.Call(dbusInterface+".reload", 0).Err
+}
+
+// AddInterfaceFirewalld binds the interface to FirewalldZone
+func AddInterfaceFirewalld(intf string) error {
+	if !FirewalldModeEnabled() {
+		return nil
+	}
+
+	var intfs []string
+	if err := connection.sysobj.Call(dbusInterface+".zone.getInterfaces", 0, FirewalldZone).Store(&intfs); err != nil {
+		return err
+	}
+	for _, i := range intfs {
+		if i == intf {
+			return nil
+		}
+	}
+
+	logrus.Debugf("Firewalld: adding the %s interface to the %s zone", intf, FirewalldZone)
+	return connection.sysobj.Call(dbusInterface+".zone.addInterface", 0, FirewalldZone, intf).Err
+}
+
+// DelInterfaceFirewalld unbinds the interface from FirewalldZone
+func DelInterfaceFirewalld(intf string) error {
+	if !FirewalldModeEnabled() {
+		return nil
+	}
+
+	var intfs []string
+	if err := connection.sysobj.Call(dbusInterface+".zone.getInterfaces", 0, FirewalldZone).Store(&intfs); err != nil {
+		return err
+	}
+	for _, i := range intfs {
+		if i == intf {
+			logrus.Debugf("Firewalld: removing the %s interface from the %s zone", intf, FirewalldZone)
+			return connection.sysobj.Call(dbusInterface+".zone.removeInterface", 0, FirewalldZone, intf).Err
+		}
+	}
+	return nil
+}
@@ -2,6 +2,7 @@ package iptables
 
 import (
 	"net"
+	"reflect"
 	"strconv"
 	"testing"
 )
@@ -87,3 +88,30 @@ func TestPassthrough(t *testing.T) {
 	}
 
 }
+
+func TestParseDirectCommand(t *testing.T) {
+	c, ok := parseDirectCommand([]string{"-t", "nat", "-I", "DOCKER", "-i", "docker0", "-j", "RETURN"})
+	if !ok {
+		t.Fatal("insert command not parsed")
+	}
+	if c.table != Nat || c.action != "-I" || c.chain != "DOCKER" || !reflect.DeepEqual(c.rule, []string{"-i", "docker0", "-j", "RETURN"}) {
+		t.Fatalf("unexpected command: %+v", c)
+	}
+
+	c, ok = parseDirectCommand([]string{"-N", "DOCKER-USER"})
+	if !ok || c.table != Filter || c.action != "-N" || c.chain != "DOCKER-USER" {
+		t.Fatalf("unexpected command: %+v", c)
+	}
+
+	for _, args := range [][]string{
+		{"-t", "nat", "-n", "-L", "DOCKER"},
+		{"-I", "FORWARD", "1", "-j", "DOCKER-USER"},
+		{"-A", "FORWARD"},
+		{"-X", "DOCKER", "-j", "ACCEPT"},
+		{"--version"},
+	} {
+		if _, ok := parseDirectCommand(args); ok {
+			t.Fatalf("unexpected direct command parsed from %v", args)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package iptables
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	ip6tablesPath string
+	// ErrIp6tablesNotFound is returned when the ip6tables binary is not found.
+	ErrIp6tablesNotFound = errors.New("Ip6tables not found")
+)
+
+// Raw6 calls 'ip6tables' system command, passing supplied arguments.
+func Raw6(args ...string) ([]byte, error) {
+	args = annotateCommand(args)
+	if firewalldRunning && currentBackend() == nil {
+		startTime := time.Now()
+		if firewalldMode {
+			if done, err := directContext(context.Background(), IP6Tables, args); done {
+				return nil, err
+			}
+		}
+		output, err := Passthrough(IP6Tables, args...)
+		if err == nil || !strings.Contains(err.Error(), "was not provided by any .service files") {
+			return filterOutput(startTime, output, args...), err
+		}
+	}
+	return raw6(args...)
+}
+
+func raw6(args ...string) ([]byte, error) {
+	if b := currentBackend(); b != nil {
+		return runBackend(context.Background(), b, IP6Tables, args)
+	}
+	initOnce.Do(initDependencies)
+	if ip6tablesPath == "" {
+		return nil, ErrIp6tablesNotFound
+	}
+	if wait := waitArgs(); wait != nil {
+		args = append(wait, args...)
+	} else {
+		bestEffortLock.Lock()
+		defer bestEffortLock.Unlock()
+	}
+
+	logrus.Debugf("%s, %v", ip6tablesPath, args)
+
+	startTime := time.Now()
+	output, err := runXlocked(context.Background(), func() *exec.Cmd {
+		return exec.Command(ip6tablesPath, args...)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("ip6tables failed: ip6tables %v: %s (%s)", strings.Join(args, " "), output, err)
+	}
+
+	return filterOutput(startTime, output, args...), nil
+}
+
+// ProgramRule6 adds the rule specified by args to the ip6tables table/chain
+func ProgramRule6(table Table, chain string, action Action, args []string) error {
+	if output, err := Raw6(append([]string{"-t", string(table), string(action), chain}, args...)...); err != nil || len(output) != 0 {
+		return fmt.Errorf("%s (%v)", string(output), err)
+	}
+	return nil
+}
+
+// Exists6 checks if a rule exists in the ip6tables table/chain
+func Exists6(table Table, chain string, rule ...string) bool {
+	if string(table) == "" {
+		table = Filter
+	}
+	rule = annotateRule(rule)
+
+	b := currentBackend()
+	if b == nil {
+		initOnce.Do(initDependencies)
+		if ip6tablesPath == "" {
+			return false
+		}
+	}
+
+	if supportsCOpt || b != nil {
+		_, err := Raw6(append([]string{"-t", string(table), "-C", chain}, rule...)...)
+		return err == nil
+	}
+
+	ruleString := fmt.Sprintf("%s %s\n", chain, strings.Join(rule, " "))
+	existingRules, _ := exec.Command(ip6tablesPath, "-t", string(table), "-S", chain).Output()
+	return strings.Contains(string(existingRules), ruleString)
+}
+
+// RawCombinedOutputNative6 behaves as RawCombinedOutputNative, invoking the
+// `ip6tables` binary
+func RawCombinedOutputNative6(args ...string) error {
+	if output, err := raw6(annotateCommand(args)...); err != nil || len(output) != 0 {
+		return fmt.Errorf("%s (%v)", string(output), err)
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package iptables
+
+import (
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strings"
+
+	"github.com/docker/libnetwork/types"
+)
+
+// ipsetNameRe matches the names the ipset tool accepts, up to the 31
+// characters of the kernel
+var ipsetNameRe = regexp.MustCompile(`^[A-Za-z0-9_.:-]{1,31}$`)
+
+// IPSet is an ipset of the host, managed out of libnetwork
+type IPSet struct {
+	Name string
+	// Type is the type of the set, as in hash:net
+	Type string
+	// Family is inet or inet6, empty for the sets of sets
+	Family string
+}
+
+// ValidIPSetName tells if the name is a valid ipset name
+func ValidIPSetName(name string) bool {
+	return ipsetNameRe.MatchString(name)
+}
+
+// LookupIPSet returns the header of the ipset, a NotFoundError when the
+// host has no such set
+func LookupIPSet(name string) (*IPSet, error) {
+	if !ValidIPSetName(name) {
+		return nil, types.BadRequestErrorf("invalid ipset name %q", name)
+	}
+	path, err := exec.LookPath("ipset")
+	if err != nil {
+		return nil, fmt.Errorf("ipset is not available to look up set %s: %v", name, err)
+	}
+	out, err := exec.Command(path, "list", "-t", name).CombinedOutput()
+	if err != nil {
+		if strings.Contains(string(out), "does not exist") {
+			return nil, types.NotFoundErrorf("ipset %s does not exist", name)
+		}
+		return nil, fmt.Errorf("failed to look up ipset %s: %v: %s", name, err, strings.TrimSpace(string(out)))
+	}
+	return parseIPSetHeader(name, string(out)), nil
+}
+
+// parseIPSetHeader parses the header of the set as printed by ipset list
+// -t, the family coming after the family keyword of its Header line
+func parseIPSetHeader(name, out string) *IPSet {
+	s := &IPSet{Name: name}
+	for _, line := range strings.Split(out, "\n") {
+		kv := strings.SplitN(line, ":", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		v := strings.TrimSpace(kv[1])
+		switch strings.TrimSpace(kv[0]) {
+		case "Type":
+			s.Type = v
+		case "Header":
+			fields := strings.Fields(v)
+			for i := 0; i < len(fields)-1; i++ {
+				if fields[i] == "family" {
+					s.Family = fields[i+1]
+				}
+			}
+		}
+	}
+	return s
+}
+
+// ipsetMaxElem is the least capacity the loaded sets are created with, the
+// default one of the ipset tool
+const ipsetMaxElem = 65536
+
+// LoadIPSet replaces the members of the ipset of the type and family,
+// inet or inet6, with the entries, creating the set when the host has
+// none. The entries are loaded into a temporary set swapped in for the
+// set, so the rules matching it never see it half loaded.
+func LoadIPSet(name, typ, family string, entries []string) error {
+	tmp := name + "-new"
+	if !ValidIPSetName(name) || !ValidIPSetName(tmp) {
+		return types.BadRequestErrorf("invalid ipset name %q", name)
+	}
+	path, err := exec.LookPath("ipset")
+	if err != nil {
+		return fmt.Errorf("ipset is not available to load set %s: %v", name, err)
+	}
+	// The temporary set left by a failed load may not have the capacity
+	// of the entries
+	exec.Command(path, "destroy", tmp).Run()
+
+	cmd := exec.Command(path, "restore")
+	cmd.Stdin = strings.NewReader(ipsetLoadScript(name, tmp, typ, family, entries))
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to load ipset %s: %v: %s", name, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// ipsetLoadScript returns the ipset restore commands loading the entries
+// into the set through the temporary set
+func ipsetLoadScript(name, tmp, typ, family string, entries []string) string {
+	maxElem := ipsetMaxElem
+	if len(entries) > maxElem {
+		maxElem = len(entries)
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "create %s %s family %s -exist\n", name, typ, family)
+	fmt.Fprintf(&b, "create %s %s family %s maxelem %d\n", tmp, typ, family, maxElem)
+	for _, e := range entries {
+		fmt.Fprintf(&b, "add %s %s -exist\n", tmp, e)
+	}
+	fmt.Fprintf(&b, "swap %s %s\n", tmp, name)
+	fmt.Fprintf(&b, "destroy %s\n", tmp)
+	return b.String()
+}
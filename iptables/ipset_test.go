@@ -0,0 +1,62 @@
+package iptables
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseIPSetHeader(t *testing.T) {
+	out := `Name: corp-admin-nets
+Type: hash:net
+Revision: 6
+Header: family inet hashsize 1024 maxelem 65536
+Size in memory: 504
+References: 1
+Number of entries: 2
+`
+	s := parseIPSetHeader("corp-admin-nets", out)
+	if s.Type != "hash:net" || s.Family != "inet" {
+		t.Fatalf("unexpected set %+v", s)
+	}
+
+	s = parseIPSetHeader("all", "Name: all\nType: list:set\nHeader: size 8\n")
+	if s.Type != "list:set" || s.Family != "" {
+		t.Fatalf("unexpected set of sets %+v", s)
+	}
+}
+
+func TestValidIPSetName(t *testing.T) {
+	for _, name := range []string{"corp-admin-nets", "a", "v4:admins_1.2"} {
+		if !ValidIPSetName(name) {
+			t.Fatalf("valid ipset name %q rejected", name)
+		}
+	}
+	for _, name := range []string{"", "with space", "a;b", "0123456789012345678901234567890123"} {
+		if ValidIPSetName(name) {
+			t.Fatalf("invalid ipset name %q accepted", name)
+		}
+	}
+}
+
+func TestIPSetLoadScript(t *testing.T) {
+	script := ipsetLoadScript("geo", "geo-new", "hash:net", "inet", []string{"192.0.2.0/24", "198.51.100.0/24"})
+	expected := `create geo hash:net family inet -exist
+create geo-new hash:net family inet maxelem 65536
+add geo-new 192.0.2.0/24 -exist
+add geo-new 198.51.100.0/24 -exist
+swap geo-new geo
+destroy geo-new
+`
+	if script != expected {
+		t.Fatalf("unexpected script:\n%s\nexpected:\n%s", script, expected)
+	}
+
+	// The temporary set holds more entries than the default capacity
+	entries := make([]string, ipsetMaxElem+1)
+	for i := range entries {
+		entries[i] = "10.0.0.0/8"
+	}
+	if script := ipsetLoadScript("geo", "geo-new", "hash:net", "inet", entries); !strings.Contains(script, "maxelem 65537\n") {
+		t.Fatalf("unexpected capacity of the temporary set:\n%.200s", script)
+	}
+}
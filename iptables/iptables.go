@@ -1,6 +1,7 @@
 package iptables
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"net"
@@ -11,6 +12,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/docker/libnetwork/internal/faults"
 	"github.com/sirupsen/logrus"
 )
 
@@ -20,7 +22,7 @@ type Action string
 // Policy is the default iptable policies
 type Policy string
 
-// Table refers to Nat, Filter or Mangle.
+// Table refers to Nat, Filter, Mangle or RawTable.
 type Table string
 
 const (
@@ -36,6 +38,8 @@ const (
 	Filter Table = "filter"
 	// Mangle table is used for mangling the packet.
 	Mangle Table = "mangle"
+	// RawTable is used for exempting packets from connection tracking.
+	RawTable Table = "raw"
 	// Drop is the default iptables DROP policy
 	Drop Policy = "DROP"
 	// Accept is the default iptables ACCEPT policy
@@ -87,18 +91,23 @@ func initFirewalld() {
 }
 
 func detectIptables() {
+	if path, err := exec.LookPath("ip6tables"); err == nil {
+		ip6tablesPath = path
+	}
 	path, err := exec.LookPath("iptables")
 	if err != nil {
 		return
 	}
 	iptablesPath = path
 	supportsXlock = exec.Command(iptablesPath, "--wait", "-L", "-n").Run() == nil
+	detectIptablesRestore()
 	mj, mn, mc, err := GetVersion()
 	if err != nil {
 		logrus.Warnf("Failed to read iptables version: %v", err)
 		return
 	}
 	supportsCOpt = supportsCOption(mj, mn, mc)
+	supportsWaitTimeout = supportsWaitTimeoutOption(mj, mn, mc)
 }
 
 func initDependencies() {
@@ -108,6 +117,9 @@ func initDependencies() {
 }
 
 func initCheck() error {
+	if currentBackend() != nil {
+		return nil
+	}
 	initOnce.Do(initDependencies)
 
 	if iptablesPath == "" {
@@ -237,6 +249,23 @@ func RemoveExistingChain(name string, table Table) error {
 
 // Forward adds forwarding rule to 'filter' table and corresponding nat rule to 'nat' table.
 func (c *ChainInfo) Forward(action Action, ip net.IP, port int, proto, destAddr string, destPort int, bridgeName string) error {
+	for _, r := range c.ForwardRules(ip, port, proto, destAddr, destPort, bridgeName) {
+		if err := ProgramRule(r.Table, r.Chain, action, r.Args); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ForwardRules returns the rules programmed by Forward, in their order
+func (c *ChainInfo) ForwardRules(ip net.IP, port int, proto, destAddr string, destPort int, bridgeName string) []Rule {
+	return c.HairpinForwardRules(ip, port, proto, destAddr, destPort, bridgeName, c.HairpinMode)
+}
+
+// HairpinForwardRules returns the rules of ForwardRules, the containers of
+// the bridge reaching the port through the host addresses when hairpin is
+// set, whatever the hairpin mode of the chain
+func (c *ChainInfo) HairpinForwardRules(ip net.IP, port int, proto, destAddr string, destPort int, bridgeName string, hairpin bool) []Rule {
 	daddr := ip.String()
 	if ip.IsUnspecified() {
 		// iptables interprets "0.0.0.0" as "0.0.0.0/32", whereas we
@@ -251,12 +280,12 @@ func (c *ChainInfo) Forward(action Action, ip net.IP, port int, proto, destAddr
 		"--dport", strconv.Itoa(port),
 		"-j", "DNAT",
 		"--to-destination", net.JoinHostPort(destAddr, strconv.Itoa(destPort))}
-	if !c.HairpinMode {
+	if !hairpin {
 		args = append(args, "!", "-i", bridgeName)
 	}
-	if err := ProgramRule(Nat, c.Name, action, args); err != nil {
-		return err
-	}
+	// Out of the hairpin mode the traffic of the bridge returns at the
+	// top of the chain, the hairpin rules go above
+	rules := []Rule{{Table: Nat, Chain: c.Name, Args: args, Insert: hairpin && !c.HairpinMode}}
 
 	args = []string{
 		"!", "-i", bridgeName,
@@ -266,9 +295,7 @@ func (c *ChainInfo) Forward(action Action, ip net.IP, port int, proto, destAddr
 		"--dport", strconv.Itoa(destPort),
 		"-j", "ACCEPT",
 	}
-	if err := ProgramRule(Filter, c.Name, action, args); err != nil {
-		return err
-	}
+	rules = append(rules, Rule{Table: Filter, Chain: c.Name, Args: args})
 
 	args = []string{
 		"-p", proto,
@@ -277,10 +304,7 @@ func (c *ChainInfo) Forward(action Action, ip net.IP, port int, proto, destAddr
 		"--dport", strconv.Itoa(destPort),
 		"-j", "MASQUERADE",
 	}
-
-	if err := ProgramRule(Nat, "POSTROUTING", action, args); err != nil {
-		return err
-	}
+	rules = append(rules, Rule{Table: Nat, Chain: "POSTROUTING", Args: args})
 
 	if proto == "sctp" {
 		// Linux kernel v4.9 and below enables NETIF_F_SCTP_CRC for veth by
@@ -296,17 +320,25 @@ func (c *ChainInfo) Forward(action Action, ip net.IP, port int, proto, destAddr
 			"-j", "CHECKSUM",
 			"--checksum-fill",
 		}
-		if err := ProgramRule(Mangle, "POSTROUTING", action, args); err != nil {
-			return err
-		}
+		rules = append(rules, Rule{Table: Mangle, Chain: "POSTROUTING", Args: args})
 	}
 
-	return nil
+	return rules
 }
 
 // Link adds reciprocal ACCEPT rule for two supplied IP addresses.
 // Traffic is allowed from ip1 to ip2 and vice-versa
 func (c *ChainInfo) Link(action Action, ip1, ip2 net.IP, port int, proto string, bridgeName string) error {
+	for _, r := range c.LinkRules(ip1, ip2, port, proto, bridgeName) {
+		if err := ProgramRule(r.Table, r.Chain, action, r.Args); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LinkRules returns the rules programmed by Link, in their order
+func (c *ChainInfo) LinkRules(ip1, ip2 net.IP, port int, proto string, bridgeName string) []Rule {
 	// forward
 	args := []string{
 		"-i", bridgeName, "-o", bridgeName,
@@ -316,19 +348,24 @@ func (c *ChainInfo) Link(action Action, ip1, ip2 net.IP, port int, proto string,
 		"--dport", strconv.Itoa(port),
 		"-j", "ACCEPT",
 	}
-	if err := ProgramRule(Filter, c.Name, action, args); err != nil {
-		return err
-	}
 	// reverse
-	args[7], args[9] = args[9], args[7]
-	args[10] = "--sport"
-	return ProgramRule(Filter, c.Name, action, args)
+	rargs := append([]string{}, args...)
+	rargs[7], rargs[9] = rargs[9], rargs[7]
+	rargs[10] = "--sport"
+	return []Rule{
+		{Table: Filter, Chain: c.Name, Args: args},
+		{Table: Filter, Chain: c.Name, Args: rargs},
+	}
 }
 
 // ProgramRule adds the rule specified by args only if the
 // rule is not already present in the chain. Reciprocally,
-// it removes the rule only if present.
+// it removes the rule only if present. The rules marked with a tier by
+// TierRule are added within their tier, at its start or its end.
 func ProgramRule(table Table, chain string, action Action, args []string) error {
+	if tier, ok := RuleTier(args); ok && action != Delete {
+		return programTieredRule(table, chain, tier, action, args)
+	}
 	if Exists(table, chain, args...) != (action == Delete) {
 		return nil
 	}
@@ -399,6 +436,7 @@ func exists(native bool, table Table, chain string, rule ...string) bool {
 	if string(table) == "" {
 		table = Filter
 	}
+	rule = annotateRule(rule)
 
 	if err := initCheck(); err != nil {
 		// The exists() signature does not allow us to return an error, but at least
@@ -406,7 +444,7 @@ func exists(native bool, table Table, chain string, rule ...string) bool {
 		return false
 	}
 
-	if supportsCOpt {
+	if supportsCOpt || currentBackend() != nil {
 		// if exit status is 0 then return true, the rule exists
 		_, err := f(append([]string{"-t", string(table), "-C", chain}, rule...)...)
 		return err == nil
@@ -414,6 +452,9 @@ func exists(native bool, table Table, chain string, rule ...string) bool {
 
 	// parse "iptables -S" for the rule (it checks rules in a specific chain
 	// in a specific table and it is very unreliable)
+	if to, ok := jumpChain(chain, jumpTarget(rule)); ok && !native {
+		chain = to
+	}
 	return existsRaw(table, chain, rule...)
 }
 
@@ -445,22 +486,50 @@ func filterOutput(start time.Time, output []byte, args ...string) []byte {
 
 // Raw calls 'iptables' system command, passing supplied arguments.
 func Raw(args ...string) ([]byte, error) {
-	if firewalldRunning {
+	return RawContext(context.Background(), args...)
+}
+
+// RawContext behaves as Raw, killing the 'iptables' command, or giving up on
+// the firewalld reply, when the context is done
+func RawContext(ctx context.Context, args ...string) ([]byte, error) {
+	args = redirectJump(annotateCommand(args))
+	if firewalldRunning && currentBackend() == nil {
 		startTime := time.Now()
-		output, err := Passthrough(Iptables, args...)
+		if firewalldMode {
+			if done, err := directContext(ctx, Iptables, args); done {
+				return nil, err
+			}
+		}
+		output, err := PassthroughContext(ctx, Iptables, args...)
 		if err == nil || !strings.Contains(err.Error(), "was not provided by any .service files") {
 			return filterOutput(startTime, output, args...), err
 		}
 	}
-	return raw(args...)
+	return rawContext(ctx, args...)
 }
 
 func raw(args ...string) ([]byte, error) {
+	return rawContext(context.Background(), annotateCommand(args)...)
+}
+
+func rawContext(ctx context.Context, args ...string) ([]byte, error) {
+	if err := faults.Inject(faults.Iptables); err != nil {
+		if err == faults.ErrDropped {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("iptables failed: iptables %v: %v", strings.Join(args, " "), err)
+	}
+	if b := currentBackend(); b != nil {
+		return runBackend(ctx, b, Iptables, args)
+	}
 	if err := initCheck(); err != nil {
 		return nil, err
 	}
-	if supportsXlock {
-		args = append([]string{"--wait"}, args...)
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if wait := waitArgs(); wait != nil {
+		args = append(wait, args...)
 	} else {
 		bestEffortLock.Lock()
 		defer bestEffortLock.Unlock()
@@ -469,8 +538,13 @@ func raw(args ...string) ([]byte, error) {
 	logrus.Debugf("%s, %v", iptablesPath, args)
 
 	startTime := time.Now()
-	output, err := exec.Command(iptablesPath, args...).CombinedOutput()
+	output, err := runXlocked(ctx, func() *exec.Cmd {
+		return exec.CommandContext(ctx, iptablesPath, args...)
+	})
 	if err != nil {
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("iptables aborted: iptables %v: %v", strings.Join(args, " "), ctx.Err())
+		}
 		return nil, fmt.Errorf("iptables failed: iptables %v: %s (%s)", strings.Join(args, " "), output, err)
 	}
 
@@ -486,6 +560,15 @@ func RawCombinedOutput(args ...string) error {
 	return nil
 }
 
+// RawCombinedOutputContext behaves as RawCombinedOutput, aborting the
+// command when the context is done
+func RawCombinedOutputContext(ctx context.Context, args ...string) error {
+	if output, err := RawContext(ctx, args...); err != nil || len(output) != 0 {
+		return fmt.Errorf("%s (%v)", string(output), err)
+	}
+	return nil
+}
+
 // RawCombinedOutputNative behave as RawCombinedOutput with the difference it
 // will always invoke `iptables` binary
 func RawCombinedOutputNative(args ...string) error {
@@ -0,0 +1,97 @@
+package iptables
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// libnetworkChainPrefix is the prefix of the chains libnetwork creates
+const libnetworkChainPrefix = "DOCKER"
+
+var (
+	jumpChainsMu sync.Mutex
+	// jumpChains maps the built-in chains to the chains the jumps to the
+	// libnetwork chains are put in instead
+	jumpChains map[string]string
+	// jumpChainsCreated are the table/chain which were made sure to exist
+	jumpChainsCreated map[string]bool
+)
+
+// SetJumpChains makes the jumps to the libnetwork chains, which are put at
+// the top of the built-in chains by default, be put in the chains the
+// built-in ones are mapped to, as in INPUT to MY-DOCKER-IN. The host
+// firewall is expected to jump to these chains where the libnetwork rules
+// are to be evaluated. The chains are created when missing.
+func SetJumpChains(chains map[string]string) {
+	jumpChainsMu.Lock()
+	defer jumpChainsMu.Unlock()
+	jumpChains = make(map[string]string, len(chains))
+	for from, to := range chains {
+		jumpChains[from] = to
+	}
+	jumpChainsCreated = make(map[string]bool)
+}
+
+// jumpChain returns the chain the jump to target from chain is put in
+func jumpChain(chain, target string) (string, bool) {
+	if !strings.HasPrefix(target, libnetworkChainPrefix) {
+		return "", false
+	}
+	jumpChainsMu.Lock()
+	defer jumpChainsMu.Unlock()
+	to, ok := jumpChains[chain]
+	return to, ok
+}
+
+// jumpTarget returns the target of the rule
+func jumpTarget(rule []string) string {
+	for i := 0; i < len(rule)-1; i++ {
+		if rule[i] == "-j" || rule[i] == "--jump" {
+			return rule[i+1]
+		}
+	}
+	return ""
+}
+
+// redirectJump rewrites the arguments of the command adding, checking or
+// removing a jump to a libnetwork chain from a mapped built-in chain, the
+// other commands are returned as they are
+func redirectJump(args []string) []string {
+	c, ok := parseDirectCommand(args)
+	if !ok {
+		return args
+	}
+	switch c.action {
+	case "-A", "-I", "-D", "-C":
+	default:
+		return args
+	}
+	to, ok := jumpChain(c.chain, jumpTarget(c.rule))
+	if !ok {
+		return args
+	}
+	if c.action == "-A" || c.action == "-I" {
+		ensureJumpChain(c.table, to)
+	}
+	return append([]string{"-t", string(c.table), c.action, to}, c.rule...)
+}
+
+// ensureJumpChain creates the chain the jumps are redirected to, once
+func ensureJumpChain(table Table, chain string) {
+	key := string(table) + "/" + chain
+	jumpChainsMu.Lock()
+	created := jumpChainsCreated[key]
+	jumpChainsCreated[key] = true
+	jumpChainsMu.Unlock()
+	if created {
+		return
+	}
+	if _, err := Raw("-t", string(table), "-nL", chain); err == nil {
+		return
+	}
+	if output, err := Raw("-t", string(table), "-N", chain); err != nil || len(output) != 0 {
+		logrus.Warnf("Failed to create the %s/%s chain the jumps are redirected to: %s (%v)", table, chain, output, err)
+	}
+}
@@ -0,0 +1,46 @@
+package iptables
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRedirectJump(t *testing.T) {
+	SetJumpChains(map[string]string{"INPUT": "MY-DOCKER-IN", "FORWARD": "MY-DOCKER-FWD"})
+	defer SetJumpChains(nil)
+	// The chains are not created by the test
+	jumpChainsCreated["filter/MY-DOCKER-IN"] = true
+	jumpChainsCreated["filter/MY-DOCKER-FWD"] = true
+
+	for _, c := range []struct {
+		args     []string
+		expected []string
+	}{
+		{
+			[]string{"-I", "FORWARD", "-j", "DOCKER-USER"},
+			[]string{"-t", "filter", "-I", "MY-DOCKER-FWD", "-j", "DOCKER-USER"},
+		},
+		{
+			[]string{"-t", "filter", "-D", "INPUT", "-j", "DOCKER-SYNPROXY"},
+			[]string{"-t", "filter", "-D", "MY-DOCKER-IN", "-j", "DOCKER-SYNPROXY"},
+		},
+		{
+			// Not a jump to a libnetwork chain
+			[]string{"-I", "FORWARD", "-i", "docker0", "-j", "ACCEPT"},
+			[]string{"-I", "FORWARD", "-i", "docker0", "-j", "ACCEPT"},
+		},
+		{
+			// Not a mapped chain
+			[]string{"-t", "nat", "-A", "PREROUTING", "-j", "DOCKER"},
+			[]string{"-t", "nat", "-A", "PREROUTING", "-j", "DOCKER"},
+		},
+		{
+			[]string{"-N", "DOCKER-ICC"},
+			[]string{"-N", "DOCKER-ICC"},
+		},
+	} {
+		if args := redirectJump(c.args); !reflect.DeepEqual(args, c.expected) {
+			t.Fatalf("unexpected redirection of %v: %v, expected %v", c.args, args, c.expected)
+		}
+	}
+}
@@ -0,0 +1,218 @@
+package iptables
+
+import (
+	"fmt"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/docker/libnetwork/types"
+)
+
+var (
+	annotationsMu sync.Mutex
+	// annotations makes the rules installed without a comment get the one
+	// of the driver installing them
+	annotations bool
+)
+
+// SetRuleAnnotations makes the rules installed get a comment naming the
+// driver which installed them, as in lnet:bridge, for the host operators
+// to attribute them. The rules tagged by TagRule keep their comment, which
+// also names the endpoint they are installed for.
+func SetRuleAnnotations(enable bool) {
+	annotationsMu.Lock()
+	annotations = enable
+	annotationsMu.Unlock()
+}
+
+func ruleAnnotations() bool {
+	annotationsMu.Lock()
+	defer annotationsMu.Unlock()
+	return annotations
+}
+
+// TagRule returns the rule with a comment naming the driver and the
+// endpoint it is installed for, ahead of its target, for the orphaned
+// rules to be told apart after a crash
+func TagRule(driver, eid string, args []string) []string {
+	return CommentRule(types.OwnerTag(driver, eid), args)
+}
+
+// CommentRule returns the rule with the comment, ahead of its target
+func CommentRule(comment string, args []string) []string {
+	match := []string{"-m", "comment", "--comment", comment}
+	for i, arg := range args {
+		if arg == "-j" || arg == "--jump" || arg == "-g" || arg == "--goto" {
+			tagged := make([]string, 0, len(args)+len(match))
+			tagged = append(tagged, args[:i]...)
+			tagged = append(tagged, match...)
+			return append(tagged, args[i:]...)
+		}
+	}
+	return append(append([]string{}, args...), match...)
+}
+
+func hasComment(args []string) bool {
+	for _, arg := range args {
+		if arg == "--comment" {
+			return true
+		}
+	}
+	return false
+}
+
+// annotateRule returns the rule with the comment of the driver installing
+// it, when the annotations are enabled and the rule has no comment
+func annotateRule(args []string) []string {
+	if len(args) == 0 || hasComment(args) || !ruleAnnotations() {
+		return args
+	}
+	return CommentRule(types.OwnerTag(callerDriver(), ""), args)
+}
+
+// annotateCommand rewrites the arguments of the command appending,
+// inserting, checking or removing a rule with the comment of the driver
+// installing it, the other commands are returned as they are
+func annotateCommand(args []string) []string {
+	if !ruleAnnotations() {
+		return args
+	}
+	i := 0
+	if len(args) > 1 && args[0] == "-t" {
+		i = 2
+	}
+	if len(args) < i+3 {
+		return args
+	}
+	switch args[i] {
+	case "-A", "-D", "-C":
+		i += 2
+	case "-I":
+		i += 2
+		if _, err := strconv.Atoi(args[i]); err == nil {
+			i++
+		}
+	default:
+		return args
+	}
+	if i >= len(args) || hasComment(args[i:]) {
+		return args
+	}
+	// Removing a rule by its position
+	if args[i-2] == "-D" && i == len(args)-1 {
+		if _, err := strconv.Atoi(args[i]); err == nil {
+			return args
+		}
+	}
+	return append(append([]string{}, args[:i]...), annotateRule(args[i:])...)
+}
+
+// callerDriver returns the name of the package which called into the
+// iptables package, as bridge for the bridge driver
+func callerDriver() string {
+	pcs := make([]uintptr, 16)
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(2, pcs)])
+	for {
+		frame, more := frames.Next()
+		pkg := funcPackage(frame.Function)
+		if pkg != "" && !strings.HasSuffix(pkg, "/iptables") {
+			return pkg[strings.LastIndex(pkg, "/")+1:]
+		}
+		if !more {
+			return "unknown"
+		}
+	}
+}
+
+// funcPackage returns the package path of the function name, as in
+// github.com/docker/libnetwork/drivers/bridge.(*driver).CreateNetwork
+func funcPackage(name string) string {
+	slash := strings.LastIndex(name, "/")
+	if dot := strings.Index(name[slash+1:], "."); dot >= 0 {
+		return name[:slash+1+dot]
+	}
+	return name
+}
+
+// Owner is the driver and the endpoint a tagged rule is installed for
+type Owner struct {
+	Driver   string
+	Endpoint string
+}
+
+// OwnedRule is a rule tagged with the comment of its owner
+type OwnedRule struct {
+	Rule
+	Owner
+}
+
+// OwnedRules lists the rules of the chain bearing the comment of a driver,
+// as set by TagRule or the annotations
+func OwnedRules(table Table, chain string) ([]OwnedRule, error) {
+	out, err := Raw("-t", string(table), "-S", chain)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the rules of chain %s: %v", chain, err)
+	}
+	return parseOwnedRules(table, chain, string(out)), nil
+}
+
+func parseOwnedRules(table Table, chain, out string) []OwnedRule {
+	var rules []OwnedRule
+	for _, line := range strings.Split(out, "\n") {
+		args := splitRuleArgs(line)
+		if len(args) < 2 || args[0] != "-A" || args[1] != chain {
+			continue
+		}
+		args = args[2:]
+		for i := 0; i < len(args)-1; i++ {
+			if args[i] != "--comment" {
+				continue
+			}
+			if driver, eid, ok := types.TagOwner(args[i+1]); ok {
+				rules = append(rules, OwnedRule{
+					Rule:  Rule{Table: table, Chain: chain, Args: args},
+					Owner: Owner{Driver: driver, Endpoint: eid},
+				})
+			}
+			break
+		}
+	}
+	return rules
+}
+
+// splitRuleArgs splits a rule as printed by iptables -S, where the values
+// holding spaces are double quoted
+func splitRuleArgs(line string) []string {
+	var (
+		args   []string
+		cur    strings.Builder
+		quoted bool
+		inArg  bool
+	)
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case c == '\\' && quoted && i+1 < len(line):
+			i++
+			cur.WriteByte(line[i])
+		case c == '"':
+			quoted = !quoted
+			inArg = true
+		case c == ' ' && !quoted:
+			if inArg {
+				args = append(args, cur.String())
+				cur.Reset()
+				inArg = false
+			}
+		default:
+			cur.WriteByte(c)
+			inArg = true
+		}
+	}
+	if inArg {
+		args = append(args, cur.String())
+	}
+	return args
+}
@@ -0,0 +1,86 @@
+package iptables
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTagRule(t *testing.T) {
+	tagged := TagRule("bridge", "ep1", []string{"-d", "172.17.0.2", "-j", "DROP"})
+	expected := []string{"-d", "172.17.0.2", "-m", "comment", "--comment", "lnet:bridge:ep1", "-j", "DROP"}
+	if !reflect.DeepEqual(tagged, expected) {
+		t.Fatalf("unexpected tagged rule %v", tagged)
+	}
+
+	tagged = TagRule("bridge", "ep1", []string{"-d", "172.17.0.2"})
+	expected = []string{"-d", "172.17.0.2", "-m", "comment", "--comment", "lnet:bridge:ep1"}
+	if !reflect.DeepEqual(tagged, expected) {
+		t.Fatalf("unexpected tagged rule without target %v", tagged)
+	}
+}
+
+func TestAnnotateCommand(t *testing.T) {
+	defer SetRuleAnnotations(false)
+
+	args := []string{"-t", "nat", "-A", "DOCKER", "-i", "docker0", "-j", "RETURN"}
+	if annotated := annotateCommand(args); !reflect.DeepEqual(annotated, args) {
+		t.Fatalf("unexpected annotation while disabled %v", annotated)
+	}
+
+	// The tests are run by the testing package, outside of the iptables one
+	SetRuleAnnotations(true)
+	for _, c := range []struct {
+		args     []string
+		expected []string
+	}{
+		{
+			[]string{"-t", "nat", "-A", "DOCKER", "-i", "docker0", "-j", "RETURN"},
+			[]string{"-t", "nat", "-A", "DOCKER", "-i", "docker0", "-m", "comment", "--comment", "lnet:testing", "-j", "RETURN"},
+		},
+		{
+			[]string{"-I", "FORWARD", "1", "-j", "DOCKER-USER"},
+			[]string{"-I", "FORWARD", "1", "-m", "comment", "--comment", "lnet:testing", "-j", "DOCKER-USER"},
+		},
+		{
+			[]string{"-D", "FORWARD", "3"},
+			[]string{"-D", "FORWARD", "3"},
+		},
+		{
+			[]string{"-t", "nat", "-N", "DOCKER"},
+			[]string{"-t", "nat", "-N", "DOCKER"},
+		},
+		{
+			[]string{"-A", "DOCKER", "-m", "comment", "--comment", "web port", "-j", "ACCEPT"},
+			[]string{"-A", "DOCKER", "-m", "comment", "--comment", "web port", "-j", "ACCEPT"},
+		},
+	} {
+		if annotated := annotateCommand(c.args); !reflect.DeepEqual(annotated, c.expected) {
+			t.Fatalf("unexpected annotation of %v: %v", c.args, annotated)
+		}
+	}
+}
+
+func TestParseOwnedRules(t *testing.T) {
+	out := `-N DOCKER-CONNLIMIT
+-A DOCKER-CONNLIMIT -d 172.17.0.2/32 -o docker0 -m comment --comment lnet:bridge:ep1 -j DROP
+-A DOCKER-CONNLIMIT -m comment --comment lnet:bridge -j RETURN
+-A DOCKER-CONNLIMIT -d 172.17.0.3/32 -m comment --comment "web port" -j ACCEPT
+-A DOCKER-CONNLIMIT -d 172.17.0.4/32 -j RETURN
+-A OTHER -m comment --comment lnet:bridge:ep2 -j DROP
+`
+	rules := parseOwnedRules(Filter, "DOCKER-CONNLIMIT", out)
+	if len(rules) != 2 {
+		t.Fatalf("expected two owned rules, got %v", rules)
+	}
+	expected := []string{"-d", "172.17.0.2/32", "-o", "docker0", "-m", "comment", "--comment", "lnet:bridge:ep1", "-j", "DROP"}
+	if rules[0].Owner != (Owner{Driver: "bridge", Endpoint: "ep1"}) || rules[0].Chain != "DOCKER-CONNLIMIT" || !reflect.DeepEqual(rules[0].Args, expected) {
+		t.Fatalf("unexpected owned rule %+v", rules[0])
+	}
+	if rules[1].Owner != (Owner{Driver: "bridge"}) {
+		t.Fatalf("unexpected annotated rule %+v", rules[1])
+	}
+
+	if args := splitRuleArgs(`-A X -m comment --comment "web port" -j ACCEPT`); len(args) != 8 || args[5] != "web port" {
+		t.Fatalf("unexpected split of the quoted rule %q", args)
+	}
+}
@@ -0,0 +1,173 @@
+package iptables
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	iptablesRestorePath string
+	restoreSupportsWait bool
+)
+
+// Rule is a rule of a chain
+type Rule struct {
+	Table Table
+	Chain string
+	Args  []string
+	// Insert puts the rule at the top of the chain when it is added
+	Insert bool
+}
+
+// addAction is the action adding the rule
+func (r Rule) addAction(action Action) Action {
+	if action == Append && r.Insert {
+		return Insert
+	}
+	return action
+}
+
+func detectIptablesRestore() {
+	path, err := exec.LookPath("iptables-restore")
+	if err != nil {
+		return
+	}
+	iptablesRestorePath = path
+	out, _ := exec.Command(iptablesRestorePath, "--help").CombinedOutput()
+	restoreSupportsWait = bytes.Contains(out, []byte("--wait"))
+}
+
+// ProgramRules adds or removes the rules as ProgramRule does, in a single
+// iptables-restore run when it is available and firewalld is not running.
+// When adding the rules fails none of the ones it added is kept, the rules
+// which existed before being left alone; when removing them the failures do
+// not stop the removal of the next ones.
+func ProgramRules(action Action, rules []Rule) error {
+	if len(rules) == 0 {
+		return nil
+	}
+	if err := initCheck(); err != nil {
+		return err
+	}
+	if action != Delete {
+		// The rules already there belong to another mapping or to the
+		// administrator, the batch neither adds them again nor removes
+		// them on failure
+		if rules = missingRules(rules); len(rules) == 0 {
+			return nil
+		}
+	}
+
+	if !firewalldRunning && iptablesRestorePath != "" && currentBackend() == nil {
+		err := restoreRules(action, rules)
+		if err == nil {
+			return nil
+		}
+		logrus.Debugf("Programming the %d rules one by one: %v", len(rules), err)
+		// The tables committed before the failure hold their rules
+		if action != Delete {
+			removeRules(rules)
+		}
+	}
+
+	if action == Delete {
+		return removeRules(rules)
+	}
+	for i, r := range rules {
+		if err := ProgramRule(r.Table, r.Chain, r.addAction(action), r.Args); err != nil {
+			removeRules(rules[:i])
+			return err
+		}
+	}
+	return nil
+}
+
+// missingRules returns the rules which do not exist
+func missingRules(rules []Rule) []Rule {
+	var missing []Rule
+	for _, r := range rules {
+		if !Exists(r.Table, r.Chain, r.Args...) {
+			missing = append(missing, r)
+		}
+	}
+	return missing
+}
+
+// removeRules removes the rules which exist, it returns the first failure
+func removeRules(rules []Rule) error {
+	var firstErr error
+	for _, r := range rules {
+		if !Exists(r.Table, r.Chain, r.Args...) {
+			continue
+		}
+		if err := ProgramRule(r.Table, r.Chain, Delete, r.Args); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// restoreInput renders the rules as the iptables-restore input, the rules
+// of a table keep their order
+func restoreInput(action Action, rules []Rule) []byte {
+	var (
+		tables  []Table
+		byTable = make(map[Table][]Rule)
+	)
+	for _, r := range rules {
+		table := r.Table
+		if table == "" {
+			table = Filter
+		}
+		if _, ok := byTable[table]; !ok {
+			tables = append(tables, table)
+		}
+		byTable[table] = append(byTable[table], r)
+	}
+
+	var b bytes.Buffer
+	for _, table := range tables {
+		fmt.Fprintf(&b, "*%s\n", table)
+		for _, r := range byTable[table] {
+			fmt.Fprintf(&b, "%s %s", r.addAction(action), r.Chain)
+			for _, arg := range annotateRule(r.Args) {
+				if strings.ContainsAny(arg, " \t\"") {
+					arg = fmt.Sprintf("%q", arg)
+				}
+				b.WriteString(" " + arg)
+			}
+			b.WriteString("\n")
+		}
+		b.WriteString("COMMIT\n")
+	}
+	return b.Bytes()
+}
+
+func restoreRules(action Action, rules []Rule) error {
+	args := []string{"--noflush"}
+	if restoreSupportsWait {
+		args = append(args, "--wait")
+	} else {
+		bestEffortLock.Lock()
+		defer bestEffortLock.Unlock()
+	}
+
+	startTime := time.Now()
+	input := restoreInput(action, rules)
+	output, err := runXlocked(context.Background(), func() *exec.Cmd {
+		cmd := exec.Command(iptablesRestorePath, args...)
+		cmd.Stdin = bytes.NewReader(input)
+		return cmd
+	})
+	if err != nil {
+		return fmt.Errorf("iptables-restore failed: %s (%s)", output, err)
+	}
+	filterOutput(startTime, output, append(args, fmt.Sprintf("(%d rules)", len(rules)))...)
+	return nil
+}
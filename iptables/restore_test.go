@@ -0,0 +1,144 @@
+package iptables
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestRestoreInput(t *testing.T) {
+	rules := []Rule{
+		{Table: Nat, Chain: "DOCKER", Args: []string{"-p", "tcp", "--dport", "80", "-j", "DNAT", "--to-destination", "172.17.0.2:80"}},
+		{Chain: "DOCKER", Args: []string{"-p", "tcp", "-m", "comment", "--comment", "web port", "-j", "ACCEPT"}},
+		{Table: Nat, Chain: "POSTROUTING", Args: []string{"-s", "172.17.0.2", "-j", "MASQUERADE"}},
+	}
+	expected := `*nat
+-A DOCKER -p tcp --dport 80 -j DNAT --to-destination 172.17.0.2:80
+-A POSTROUTING -s 172.17.0.2 -j MASQUERADE
+COMMIT
+*filter
+-A DOCKER -p tcp -m comment --comment "web port" -j ACCEPT
+COMMIT
+`
+	if input := string(restoreInput(Append, rules)); input != expected {
+		t.Fatalf("unexpected input:\n%s\nexpected:\n%s", input, expected)
+	}
+}
+
+func TestRestoreInputInsert(t *testing.T) {
+	rules := []Rule{
+		{Table: Nat, Chain: "DOCKER", Args: []string{"-i", "docker0", "-j", "DNAT", "--to-destination", "172.17.0.2:80"}, Insert: true},
+		{Table: Nat, Chain: "DOCKER", Args: []string{"!", "-i", "docker0", "-j", "DNAT", "--to-destination", "172.17.0.2:80"}},
+	}
+	expected := `*nat
+-I DOCKER -i docker0 -j DNAT --to-destination 172.17.0.2:80
+-A DOCKER ! -i docker0 -j DNAT --to-destination 172.17.0.2:80
+COMMIT
+`
+	if input := string(restoreInput(Append, rules)); input != expected {
+		t.Fatalf("unexpected input:\n%s\nexpected:\n%s", input, expected)
+	}
+
+	expected = `*nat
+-D DOCKER -i docker0 -j DNAT --to-destination 172.17.0.2:80
+-D DOCKER ! -i docker0 -j DNAT --to-destination 172.17.0.2:80
+COMMIT
+`
+	if input := string(restoreInput(Delete, rules)); input != expected {
+		t.Fatalf("unexpected input:\n%s\nexpected:\n%s", input, expected)
+	}
+}
+
+func TestHairpinForwardRules(t *testing.T) {
+	c := &ChainInfo{Name: "DOCKER", Table: Nat}
+	ip := net.ParseIP("192.168.1.1")
+
+	dnat := c.HairpinForwardRules(ip, 8080, "tcp", "172.17.0.2", 80, "docker0", true)[0]
+	if !dnat.Insert || strings.Contains(strings.Join(dnat.Args, " "), "-i docker0") {
+		t.Fatalf("unexpected hairpin DNAT rule: %+v", dnat)
+	}
+
+	dnat = c.ForwardRules(ip, 8080, "tcp", "172.17.0.2", 80, "docker0")[0]
+	if dnat.Insert || !strings.HasSuffix(strings.Join(dnat.Args, " "), "! -i docker0") {
+		t.Fatalf("unexpected DNAT rule: %+v", dnat)
+	}
+
+	c.HairpinMode = true
+	dnat = c.ForwardRules(ip, 8080, "tcp", "172.17.0.2", 80, "docker0")[0]
+	if dnat.Insert || strings.Contains(strings.Join(dnat.Args, " "), "-i docker0") {
+		t.Fatalf("unexpected DNAT rule in hairpin mode: %+v", dnat)
+	}
+	dnat = c.HairpinForwardRules(ip, 8080, "tcp", "172.17.0.2", 80, "docker0", false)[0]
+	if !strings.HasSuffix(strings.Join(dnat.Args, " "), "! -i docker0") {
+		t.Fatalf("unexpected DNAT rule out of hairpin: %+v", dnat)
+	}
+}
+
+// ruleBackend keeps the rules the commands add and remove, failing the
+// rules jumping to FAIL
+type ruleBackend struct {
+	rules map[string]int
+}
+
+func (b *ruleBackend) Run(ipv IPV, args ...string) ([]byte, error) {
+	if len(args) < 4 || args[0] != "-t" {
+		return nil, nil
+	}
+	key := args[1] + " " + strings.Join(args[3:], " ")
+	switch args[2] {
+	case "-C":
+		if b.rules[key] == 0 {
+			return []byte("bad rule"), errors.New("exit status 1")
+		}
+	case "-A", "-I":
+		if strings.HasSuffix(key, "-j FAIL") {
+			return []byte("no chain FAIL"), errors.New("exit status 1")
+		}
+		b.rules[key]++
+	case "-D":
+		if b.rules[key] == 0 {
+			return []byte("bad rule"), errors.New("exit status 1")
+		}
+		b.rules[key]--
+	}
+	return nil, nil
+}
+
+func TestProgramRulesRollback(t *testing.T) {
+	b := &ruleBackend{rules: map[string]int{}}
+	defer SetBackend(b)()
+
+	existing := Rule{Table: Nat, Chain: "DOCKER", Args: []string{"-p", "tcp", "--dport", "80", "-j", "ACCEPT"}}
+	if err := ProgramRule(existing.Table, existing.Chain, Append, existing.Args); err != nil {
+		t.Fatal(err)
+	}
+	added := Rule{Table: Nat, Chain: "DOCKER", Args: []string{"-p", "tcp", "--dport", "81", "-j", "ACCEPT"}}
+	failing := Rule{Table: Nat, Chain: "DOCKER", Args: []string{"-p", "tcp", "--dport", "82", "-j", "FAIL"}}
+
+	if err := ProgramRules(Append, []Rule{existing, added, failing}); err == nil {
+		t.Fatal("batch with a failing rule programmed")
+	}
+	// The rule added by the batch is removed, the one there before is kept
+	if !Exists(existing.Table, existing.Chain, existing.Args...) {
+		t.Fatal("the rule existing before the batch got removed")
+	}
+	if Exists(added.Table, added.Chain, added.Args...) {
+		t.Fatal("the rule added by the failed batch is left")
+	}
+	for key, n := range b.rules {
+		if n > 1 {
+			t.Fatalf("rule %s added %d times", key, n)
+		}
+	}
+
+	// A successful batch does not add the existing rule again
+	if err := ProgramRules(Append, []Rule{existing, added}); err != nil {
+		t.Fatal(err)
+	}
+	for key, n := range b.rules {
+		if n != 1 {
+			t.Fatalf("rule %s programmed %d times", key, n)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package iptables
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Tier is the priority tier of a rule in a generated chain. The rules of a
+// tier precede the ones of the tiers after it, whatever the order they are
+// installed in, so that the rules derived from the options of the tenants
+// never take over the mandatory ones of the platform.
+type Tier int
+
+const (
+	// TierPlatform holds the mandatory rules of the platform
+	TierPlatform Tier = iota
+	// TierTenant holds the rules derived from the options of the tenants,
+	// as their allow lists
+	TierTenant
+	// TierDefault holds the verdicts of the traffic no other tier decided
+	TierDefault
+)
+
+// tierCommentPrefix is the prefix of the comment marking the tier of a
+// rule, told apart from the owner tags
+const tierCommentPrefix = "lnet-tier:"
+
+var tierNames = []string{"platform", "tenant", "default"}
+
+func (t Tier) String() string {
+	if t < 0 || int(t) >= len(tierNames) {
+		return "tier" + strconv.Itoa(int(t))
+	}
+	return tierNames[t]
+}
+
+// TierRule returns the rule with the comment marking its tier, ahead of
+// its target
+func TierRule(tier Tier, args []string) []string {
+	return CommentRule(tierCommentPrefix+tier.String(), args)
+}
+
+// RuleTier returns the tier the rule is marked with, false if it has none
+func RuleTier(args []string) (Tier, bool) {
+	for i := 0; i < len(args)-1; i++ {
+		if args[i] != "--comment" || !strings.HasPrefix(args[i+1], tierCommentPrefix) {
+			continue
+		}
+		name := strings.TrimPrefix(args[i+1], tierCommentPrefix)
+		for t, n := range tierNames {
+			if n == name {
+				return Tier(t), true
+			}
+		}
+	}
+	return 0, false
+}
+
+// programTieredRule adds the rule, marked by TierRule, at the start of its
+// tier in the chain on Insert and at its end on Append
+func programTieredRule(table Table, chain string, tier Tier, action Action, args []string) error {
+	if Exists(table, chain, args...) {
+		return nil
+	}
+	out, err := Raw("-t", string(table), "-S", chain)
+	if err != nil {
+		return fmt.Errorf("failed to list the rules of chain %s: %v", chain, err)
+	}
+	pos := tierPosition(chainTiers(chain, string(out)), tier, action == Insert)
+	if pos == 0 {
+		return RawCombinedOutput(append([]string{"-t", string(table), string(Append), chain}, args...)...)
+	}
+	return RawCombinedOutput(append([]string{"-t", string(table), string(Insert), chain, strconv.Itoa(pos)}, args...)...)
+}
+
+// chainTiers returns the tiers of the rules of the chain as printed by
+// iptables -S, in order, -1 for the rules without one
+func chainTiers(chain, out string) []Tier {
+	var tiers []Tier
+	for _, line := range strings.Split(out, "\n") {
+		args := splitRuleArgs(line)
+		if len(args) < 2 || args[0] != "-A" || args[1] != chain {
+			continue
+		}
+		tier, ok := RuleTier(args[2:])
+		if !ok {
+			tier = -1
+		}
+		tiers = append(tiers, tier)
+	}
+	return tiers
+}
+
+// tierPosition returns the position, from 1, to insert a rule of the tier
+// at the start of its tier, or at its end when top is not set, 0 standing
+// for the end of the chain. The rules without a tier are not moved around.
+func tierPosition(tiers []Tier, tier Tier, top bool) int {
+	if top {
+		pos := 1
+		for i, t := range tiers {
+			if t >= 0 && t < tier {
+				pos = i + 2
+			}
+		}
+		return pos
+	}
+	for i, t := range tiers {
+		if t > tier {
+			return i + 1
+		}
+	}
+	return 0
+}
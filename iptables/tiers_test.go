@@ -0,0 +1,65 @@
+package iptables
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTierRule(t *testing.T) {
+	rule := TierRule(TierTenant, TagRule("bridge", "ep1", []string{"-d", "172.17.0.2", "-j", "RETURN"}))
+	expected := []string{"-d", "172.17.0.2", "-m", "comment", "--comment", "lnet:bridge:ep1", "-m", "comment", "--comment", "lnet-tier:tenant", "-j", "RETURN"}
+	if !reflect.DeepEqual(rule, expected) {
+		t.Fatalf("unexpected tiered rule %v", rule)
+	}
+	if tier, ok := RuleTier(rule); !ok || tier != TierTenant {
+		t.Fatalf("unexpected tier %v of %v", tier, rule)
+	}
+	if _, ok := RuleTier([]string{"-m", "comment", "--comment", "lnet:bridge:ep1", "-j", "DROP"}); ok {
+		t.Fatal("tier found on a rule without one")
+	}
+
+	// The owner of a tiered rule is still told apart
+	rules := parseOwnedRules(Filter, "DOCKER-ICC", "-A DOCKER-ICC "+strings.Join(rule, " ")+"\n")
+	if len(rules) != 1 || rules[0].Endpoint != "ep1" {
+		t.Fatalf("unexpected owned rules %v", rules)
+	}
+}
+
+func TestTierPosition(t *testing.T) {
+	out := `-N DOCKER-HOST-ACCESS
+-A DOCKER-HOST-ACCESS -s 172.18.0.2 -m comment --comment "lnet-tier:platform" -j RETURN
+-A DOCKER-HOST-ACCESS -s 172.18.0.2 -m comment --comment "lnet-tier:tenant" -j RETURN
+-A DOCKER-HOST-ACCESS -s 172.18.0.2 -m comment --comment "lnet-tier:default" -j REJECT
+-A DOCKER-HOST-ACCESS -s 172.18.0.3 -m comment --comment "lnet-tier:default" -j REJECT
+`
+	tiers := chainTiers("DOCKER-HOST-ACCESS", out)
+	if !reflect.DeepEqual(tiers, []Tier{TierPlatform, TierTenant, TierDefault, TierDefault}) {
+		t.Fatalf("unexpected tiers %v", tiers)
+	}
+
+	for _, c := range []struct {
+		tier     Tier
+		top      bool
+		expected int
+	}{
+		{TierPlatform, true, 1},
+		{TierPlatform, false, 2},
+		{TierTenant, true, 2},
+		{TierTenant, false, 3},
+		{TierDefault, true, 3},
+		{TierDefault, false, 0},
+	} {
+		if pos := tierPosition(tiers, c.tier, c.top); pos != c.expected {
+			t.Fatalf("unexpected position %d of a %s rule, top %v: expected %d", pos, c.tier, c.top, c.expected)
+		}
+	}
+
+	// A tenant rule inserted in a chain without platform rules goes on top
+	if pos := tierPosition([]Tier{-1, TierDefault}, TierTenant, true); pos != 1 {
+		t.Fatalf("unexpected position %d", pos)
+	}
+	if pos := tierPosition(nil, TierTenant, false); pos != 0 {
+		t.Fatalf("unexpected position %d in an empty chain", pos)
+	}
+}
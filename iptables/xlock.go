@@ -0,0 +1,111 @@
+package iptables
+
+import (
+	"bytes"
+	"context"
+	"math/rand"
+	"os/exec"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// xlockRetries is the number of times a command failing on the xtables
+	// lock is retried
+	xlockRetries = 5
+	// xlockBackoff is the wait ahead of the first retry, doubled on each
+	// of the next ones up to xlockMaxBackoff
+	xlockBackoff    = 100 * time.Millisecond
+	xlockMaxBackoff = 3 * time.Second
+	// xlockExitCode is the exit status of iptables when it could not get
+	// the xtables lock, as RESOURCE_PROBLEM
+	xlockExitCode = 4
+)
+
+var (
+	xlockMu sync.Mutex
+	// xlockTimeout bounds the wait for the xtables lock, zero waits until
+	// the lock is released
+	xlockTimeout time.Duration
+	// supportsWaitTimeout tells if iptables takes a timeout with --wait,
+	// as from v1.6.0
+	supportsWaitTimeout bool
+)
+
+// SetXlockTimeout bounds the wait of the iptables commands for the xtables
+// lock held by another program. The commands timing out are retried a few
+// times, after an exponential backoff. Zero, the default, waits until the
+// lock is released.
+func SetXlockTimeout(timeout time.Duration) {
+	xlockMu.Lock()
+	xlockTimeout = timeout
+	xlockMu.Unlock()
+}
+
+// waitArgs returns the arguments making iptables wait for the xtables
+// lock, nil when waiting is not supported
+func waitArgs() []string {
+	if !supportsXlock {
+		return nil
+	}
+	xlockMu.Lock()
+	timeout := xlockTimeout
+	xlockMu.Unlock()
+	if timeout <= 0 || !supportsWaitTimeout {
+		return []string{"--wait"}
+	}
+	secs := int((timeout + time.Second - 1) / time.Second)
+	return []string{"--wait", strconv.Itoa(secs)}
+}
+
+// supportsWaitTimeoutOption tells if the iptables version takes a timeout
+// with --wait
+func supportsWaitTimeoutOption(mj, mn, mc int) bool {
+	return mj > 1 || (mj == 1 && mn >= 6)
+}
+
+// isXlockContention tells if the command failed for the xtables lock being
+// held by another program
+func isXlockContention(output []byte, err error) bool {
+	if err == nil {
+		return false
+	}
+	if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() == xlockExitCode {
+		return true
+	}
+	return bytes.Contains(output, []byte(xLockWaitMsg)) ||
+		bytes.Contains(output, []byte("Resource temporarily unavailable"))
+}
+
+// xlockRetryDelay returns the wait ahead of the retry, doubling with the
+// attempts and with up to half of it added as jitter, for the commands of
+// concurrent container starts not to retry in lockstep
+func xlockRetryDelay(attempt int) time.Duration {
+	d := xlockBackoff << uint(attempt)
+	if d > xlockMaxBackoff || d <= 0 {
+		d = xlockMaxBackoff
+	}
+	return d + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// runXlocked runs the command built by newCmd, building and running it
+// again while it fails on the xtables lock
+func runXlocked(ctx context.Context, newCmd func() *exec.Cmd) ([]byte, error) {
+	for attempt := 0; ; attempt++ {
+		cmd := newCmd()
+		output, err := cmd.CombinedOutput()
+		if !isXlockContention(output, err) || attempt == xlockRetries || ctx.Err() != nil {
+			return output, err
+		}
+		delay := xlockRetryDelay(attempt)
+		logrus.Debugf("xtables lock contention running %v, retrying in %s", cmd.Args, delay)
+		select {
+		case <-ctx.Done():
+			return output, err
+		case <-time.After(delay):
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package iptables
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestWaitArgs(t *testing.T) {
+	defer func(xlock, timeout bool) {
+		supportsXlock, supportsWaitTimeout = xlock, timeout
+		SetXlockTimeout(0)
+	}(supportsXlock, supportsWaitTimeout)
+
+	supportsXlock, supportsWaitTimeout = false, false
+	if args := waitArgs(); args != nil {
+		t.Fatalf("unexpected wait without lock support %v", args)
+	}
+
+	supportsXlock = true
+	SetXlockTimeout(1500 * time.Millisecond)
+	if args := waitArgs(); !reflect.DeepEqual(args, []string{"--wait"}) {
+		t.Fatalf("unexpected wait without timeout support %v", args)
+	}
+
+	supportsWaitTimeout = true
+	if args := waitArgs(); !reflect.DeepEqual(args, []string{"--wait", "2"}) {
+		t.Fatalf("unexpected wait with timeout %v", args)
+	}
+
+	if supportsWaitTimeoutOption(1, 4, 21) || !supportsWaitTimeoutOption(1, 6, 0) || !supportsWaitTimeoutOption(1, 8, 4) {
+		t.Fatal("unexpected wait timeout support detection")
+	}
+}
+
+func TestXlockContention(t *testing.T) {
+	if !isXlockContention([]byte("Another app is currently holding the xtables lock. Stopped waiting after 5s."), errors.New("exit status 4")) {
+		t.Fatal("expected the lock timeout to be detected")
+	}
+	if !isXlockContention([]byte("iptables: Resource temporarily unavailable."), errors.New("exit status 1")) {
+		t.Fatal("expected EAGAIN to be detected")
+	}
+	if isXlockContention([]byte("iptables: No chain/target/match by that name."), errors.New("exit status 1")) {
+		t.Fatal("unexpected contention on a missing chain")
+	}
+	if isXlockContention([]byte(xLockWaitMsg), nil) {
+		t.Fatal("unexpected contention on a successful command")
+	}
+
+	for attempt := 0; attempt < 10; attempt++ {
+		base := xlockBackoff << uint(attempt)
+		if base > xlockMaxBackoff {
+			base = xlockMaxBackoff
+		}
+		if d := xlockRetryDelay(attempt); d < base || d > base+base/2 {
+			t.Fatalf("unexpected delay %s of attempt %d", d, attempt)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package libnetwork
+
+import (
+	"github.com/docker/libnetwork/types"
+	"github.com/miekg/dns"
+)
+
+// ipv6OnlyBackend is implemented by the DNS backends telling if all the
+// networks they are connected to are IPv6 only
+type ipv6OnlyBackend interface {
+	IPv6Only() bool
+}
+
+// validateIPv6Only checks the IPv6-only network has IPv6 enabled and no
+// IPv4 configuration
+func (n *network) validateIPv6Only() error {
+	if !n.ipv6Only {
+		return nil
+	}
+	if !n.enableIPv6 {
+		return types.BadRequestErrorf("IPv6-only network %s requires IPv6 to be enabled", n.name)
+	}
+	if len(n.ipamV4Config) > 0 {
+		return types.BadRequestErrorf("IPv6-only network %s cannot have an IPv4 configuration", n.name)
+	}
+	if n.ingress {
+		return types.ForbiddenErrorf("ingress network %s cannot be IPv6 only", n.name)
+	}
+	return nil
+}
+
+// IPv6Only tells if all the endpoints of the sandbox are on IPv6-only
+// networks
+func (sb *sandbox) IPv6Only() bool {
+	eps := sb.getConnectedEndpoints()
+	if len(eps) == 0 {
+		return false
+	}
+	for _, ep := range eps {
+		if !ep.getNetwork().IPv6Only() {
+			return false
+		}
+	}
+	return true
+}
+
+// ipv6OnlyQuery tells if the query is an A query of a sandbox having no
+// IPv4 connectivity, answered empty rather than resolved or forwarded
+func (r *resolver) ipv6OnlyQuery(query *dns.Msg) bool {
+	if query == nil || len(query.Question) == 0 || query.Question[0].Qtype != dns.TypeA {
+		return false
+	}
+	b, ok := r.backend.(ipv6OnlyBackend)
+	return ok && b.IPv6Only()
+}
@@ -0,0 +1,71 @@
+package libnetwork
+
+import (
+	"net"
+	"testing"
+
+	"github.com/docker/libnetwork/types"
+	"github.com/miekg/dns"
+)
+
+// ipv6OnlyTestBackend resolves the names of a single container of an
+// IPv6-only network
+type ipv6OnlyTestBackend struct {
+	dns64TestBackend
+}
+
+func (b *ipv6OnlyTestBackend) ResolveName(name string, iplen int) ([]net.IP, bool) {
+	if name != "c1." {
+		return nil, false
+	}
+	if iplen == types.IPv6 {
+		return []net.IP{net.ParseIP("fd00:1::2")}, false
+	}
+	return nil, true
+}
+
+func (b *ipv6OnlyTestBackend) IPv6Only() bool { return true }
+
+func TestIPv6OnlyResolver(t *testing.T) {
+	r := NewResolver(resolverIPSandbox, true, "", &ipv6OnlyTestBackend{}).(*resolver)
+
+	for _, name := range []string{"c1.", "v4.example."} {
+		w := new(tstwriter)
+		q := new(dns.Msg)
+		q.SetQuestion(name, dns.TypeA)
+		r.ServeDNS(w, q)
+		resp := w.GetResponse()
+		checkNonNullResponse(t, resp)
+		checkDNSResponseCode(t, resp, dns.RcodeSuccess)
+		checkDNSAnswersCount(t, resp, 0)
+	}
+
+	w := new(tstwriter)
+	q := new(dns.Msg)
+	q.SetQuestion("c1.", dns.TypeAAAA)
+	r.ServeDNS(w, q)
+	resp := w.GetResponse()
+	checkNonNullResponse(t, resp)
+	checkDNSAnswersCount(t, resp, 1)
+	checkDNSRRType(t, resp.Answer[0].Header().Rrtype, dns.TypeAAAA)
+}
+
+func TestValidateIPv6Only(t *testing.T) {
+	for _, n := range []*network{
+		{name: "n1"},
+		{name: "n1", ipv6Only: true, enableIPv6: true},
+	} {
+		if err := n.validateIPv6Only(); err != nil {
+			t.Fatalf("unexpected error validating %+v: %v", n, err)
+		}
+	}
+	for _, n := range []*network{
+		{name: "n1", ipv6Only: true},
+		{name: "n1", ipv6Only: true, enableIPv6: true, ipamV4Config: []*IpamConf{{PreferredPool: "10.1.0.0/24"}}},
+		{name: "n1", ipv6Only: true, enableIPv6: true, ingress: true},
+	} {
+		if err := n.validateIPv6Only(); err == nil {
+			t.Fatalf("expected an error validating %+v", n)
+		}
+	}
+}
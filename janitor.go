@@ -0,0 +1,186 @@
+package libnetwork
+
+import (
+	"net"
+	"time"
+
+	"github.com/docker/libkv/store"
+	"github.com/docker/libnetwork/datastore"
+	"github.com/docker/libnetwork/driverapi"
+	"github.com/sirupsen/logrus"
+)
+
+// janitorLeaderKey is the global datastore key held by the controller
+// elected to reclaim the endpoints of the hosts which left the cluster
+const janitorLeaderKey = "janitor/leader"
+
+// runJanitor competes for the janitor leadership and, while holding it,
+// periodically reclaims the orphan global scope endpoints. An endpoint is
+// orphan when its host is not known to the discovery anymore in two
+// consecutive passes.
+func (c *controller) runJanitor(interval time.Duration, stopCh chan struct{}) {
+	ds := c.getStore(datastore.GlobalScope)
+	if ds == nil {
+		return
+	}
+
+	lock, err := ds.KVStore().NewLock(datastore.Key(janitorLeaderKey), &store.LockOptions{
+		Value: []byte(c.clusterHostID()),
+		TTL:   2 * interval,
+	})
+	if err != nil {
+		logrus.Warnf("Orphan endpoint cleanup disabled, failed to create the leader lock: %v", err)
+		return
+	}
+
+	for {
+		lostCh, err := lock.Lock(stopCh)
+		if err != nil {
+			logrus.Warnf("Failed to acquire the orphan endpoint cleanup lock: %v", err)
+			select {
+			case <-stopCh:
+				return
+			case <-time.After(interval):
+				continue
+			}
+		}
+		select {
+		case <-stopCh:
+			if lostCh != nil {
+				lock.Unlock()
+			}
+			return
+		default:
+		}
+
+		logrus.Infof("Elected to clean up the orphan endpoints of the cluster")
+		ticker := time.NewTicker(interval)
+		suspects := map[string]bool{}
+	leader:
+		for {
+			select {
+			case <-ticker.C:
+				suspects = c.cleanupOrphanEndpoints(suspects)
+			case <-lostCh:
+				logrus.Infof("Lost the orphan endpoint cleanup leadership")
+				break leader
+			case <-stopCh:
+				ticker.Stop()
+				lock.Unlock()
+				return
+			}
+		}
+		ticker.Stop()
+	}
+}
+
+// cleanupOrphanEndpoints reclaims the endpoints found orphan in the previous
+// pass which still are, and returns the ones newly found orphan
+func (c *controller) cleanupOrphanEndpoints(suspects map[string]bool) map[string]bool {
+	c.Lock()
+	hd, active := c.discovery, c.discoveryActive
+	c.Unlock()
+	// Without discovery, or before it reports the hosts of the cluster, there
+	// is no way to tell a gone host. No host known at all means the discovery
+	// backend lost them rather than the whole cluster being gone.
+	if hd == nil || !active {
+		return nil
+	}
+	nodes := hd.Fetch()
+	if len(nodes) == 0 {
+		return nil
+	}
+
+	nl, err := c.getNetworksForScope(datastore.GlobalScope)
+	if err != nil {
+		logrus.Warnf("Could not get list of networks during orphan endpoint cleanup: %v", err)
+		return suspects
+	}
+
+	hosts := map[string]string{}
+	eps := map[string]*endpoint{}
+	nets := map[string]*network{}
+	for _, n := range nl {
+		if n.ConfigOnly() {
+			continue
+		}
+		epl, err := n.getEndpointsFromStore()
+		if err != nil {
+			logrus.Warnf("Could not get list of endpoints in network %s during orphan endpoint cleanup: %v", n.name, err)
+			continue
+		}
+		for _, ep := range epl {
+			ep.Lock()
+			hosts[ep.ID()] = ep.locator
+			ep.Unlock()
+			eps[ep.ID()] = ep
+			nets[ep.ID()] = n
+		}
+	}
+
+	next, reclaim := orphanPass(suspects, hosts, nodes, c.clusterHostID())
+	for _, id := range reclaim {
+		c.reclaimOrphanEndpoint(nets[id], eps[id], hosts[id])
+	}
+	return next
+}
+
+// orphanPass tells, given the host of each endpoint and the hosts known to
+// the discovery, the endpoints newly found orphan and the ones to reclaim,
+// already found orphan in the previous pass
+func orphanPass(suspects map[string]bool, hosts map[string]string, nodes []net.IP, local string) (map[string]bool, []string) {
+	alive := make(map[string]bool, len(nodes))
+	for _, n := range nodes {
+		alive[n.String()] = true
+	}
+
+	next := map[string]bool{}
+	var reclaim []string
+	for id, host := range hosts {
+		if host == "" || host == local || alive[host] {
+			continue
+		}
+		if !suspects[id] {
+			next[id] = true
+			continue
+		}
+		reclaim = append(reclaim, id)
+	}
+	return next, reclaim
+}
+
+// reclaimOrphanEndpoint releases the cluster wide resources of an endpoint
+// whose host is gone: its service binding, its record in the datastore, its
+// addresses and the state the driver keeps for it on the other hosts
+func (c *controller) reclaimOrphanEndpoint(n *network, ep *endpoint, host string) {
+	logrus.Infof("Removing orphan endpoint %s (%.7s) of network %s left by host %s", ep.Name(), ep.ID(), n.Name(), host)
+
+	if ep.svcID != "" && ep.Iface().Address() != nil {
+		var ingressPorts []*PortConfig
+		if n.ingress {
+			ingressPorts = ep.ingressPorts
+		}
+		if err := c.rmServiceBinding(ep.svcName, ep.svcID, n.ID(), ep.ID(), ep.Name(), ep.virtualIP, ingressPorts, ep.svcAliases, ep.myAliases, ep.Iface().Address().IP, "reclaimOrphanEndpoint", true, true); err != nil {
+			logrus.Warnf("Could not remove the service binding of orphan endpoint %s: %v", ep.Name(), err)
+		}
+	}
+
+	if err := c.deleteFromStore(ep); err != nil {
+		logrus.Warnf("Could not delete orphan endpoint %s from the store: %v", ep.Name(), err)
+		return
+	}
+
+	if d, err := n.driver(true); err == nil {
+		if oc, ok := d.(driverapi.OrphanCleaner); ok {
+			if err := oc.CleanupOrphanEndpoint(n.ID(), ep.ID()); err != nil {
+				logrus.Warnf("Driver failed to clean up orphan endpoint %s: %v", ep.Name(), err)
+			}
+		}
+	}
+
+	ep.releaseAddress()
+
+	if err := n.getEpCnt().DecEndpointCnt(); err != nil {
+		logrus.Warnf("Failed to decrement endpoint count for orphan endpoint %s: %v", ep.ID(), err)
+	}
+}
@@ -0,0 +1,305 @@
+// Package journal keeps a durable log of the operations changing the
+// networks and endpoints of a controller: their creations, with the state
+// of the objects created, their deletions and the joins and leaves of the
+// sandboxes. The entries are appended to a file, one JSON object per line,
+// and synced before the operation returns, so that the objects still in
+// effect can be created back after the local state is lost.
+//
+// The journal is compacted when it is opened: once the entries of the
+// objects no longer in effect are most of the file, it is rewritten with
+// the entries in effect only.
+package journal
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Op is the kind of a journaled operation
+type Op string
+
+const (
+	// NetworkCreate is the creation of a network, with its state
+	NetworkCreate Op = "network-create"
+	// NetworkDelete is the deletion of a network
+	NetworkDelete Op = "network-delete"
+	// EndpointCreate is the creation of an endpoint, with its state
+	EndpointCreate Op = "endpoint-create"
+	// EndpointDelete is the deletion of an endpoint
+	EndpointDelete Op = "endpoint-delete"
+	// EndpointJoin is the join of an endpoint to a sandbox
+	EndpointJoin Op = "endpoint-join"
+	// EndpointLeave is the leave of an endpoint from a sandbox
+	EndpointLeave Op = "endpoint-leave"
+)
+
+const (
+	// maxRecent is the number of entries kept in memory for inspection,
+	// the oldest being dropped
+	maxRecent = 1000
+	// compactMin is the number of entries of the file below which it is
+	// never compacted
+	compactMin = 1024
+)
+
+// Entry is a journaled operation
+type Entry struct {
+	Seq          uint64    `json:"seq"`
+	Time         time.Time `json:"time"`
+	Op           Op        `json:"op"`
+	NetworkID    string    `json:"network_id"`
+	NetworkName  string    `json:"network_name,omitempty"`
+	EndpointID   string    `json:"endpoint_id,omitempty"`
+	EndpointName string    `json:"endpoint_name,omitempty"`
+	SandboxID    string    `json:"sandbox_id,omitempty"`
+	ContainerID  string    `json:"container_id,omitempty"`
+	// State is the object created, as the controller serializes it
+	State json.RawMessage `json:"state,omitempty"`
+}
+
+// Journal is the operation journal kept in a file
+type Journal struct {
+	path string
+
+	sync.Mutex
+	f         *os.File
+	seq       uint64
+	recent    []Entry
+	networks  map[string]Entry
+	endpoints map[string]Entry
+	joins     map[string]Entry
+}
+
+// Open reads the journal of the file, creating it if needed, and compacts
+// it. An entry cut by a crash at the end of the file is dropped.
+func Open(path string) (*Journal, error) {
+	j := &Journal{
+		path:      path,
+		networks:  map[string]Entry{},
+		endpoints: map[string]Entry{},
+		joins:     map[string]Entry{},
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	total, err := j.load()
+	if err != nil {
+		return nil, err
+	}
+	if live := j.liveLocked(); total > compactMin && total > 2*len(live) {
+		// The last entry is kept for the sequence to go on from it
+		if last := j.recent[len(j.recent)-1]; !containsSeq(live, last.Seq) {
+			live = append(live, last)
+		}
+		if err := j.rewrite(live); err != nil {
+			return nil, fmt.Errorf("failed to compact the journal %s: %v", path, err)
+		}
+		logrus.Infof("Compacted the operation journal %s from %d to %d entries", path, total, len(live))
+	}
+	if j.f, err = os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600); err != nil {
+		return nil, err
+	}
+	return j, nil
+}
+
+// load applies the entries of the file and returns their number. The
+// file is cut after the last complete entry, for the next ones not to be
+// appended to a partial one.
+func (j *Journal) load() (int, error) {
+	f, err := os.Open(j.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	defer f.Close()
+
+	var (
+		total int
+		valid int64
+	)
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return total, err
+		}
+		complete := err == nil
+		if entry := bytes.TrimSpace(line); len(entry) > 0 {
+			var e Entry
+			if uerr := json.Unmarshal(entry, &e); uerr != nil || !complete {
+				if !complete {
+					logrus.Warnf("Dropping the partial last entry of the journal %s", j.path)
+					return total, os.Truncate(j.path, valid)
+				}
+				return total, fmt.Errorf("invalid entry %d of the journal %s: %v", total+1, j.path, uerr)
+			}
+			j.apply(e)
+			total++
+		}
+		valid += int64(len(line))
+		if !complete {
+			return total, nil
+		}
+	}
+}
+
+func containsSeq(entries []Entry, seq uint64) bool {
+	for _, e := range entries {
+		if e.Seq == seq {
+			return true
+		}
+	}
+	return false
+}
+
+// rewrite replaces the file with the entries, through a temporary file
+// renamed over it
+func (j *Journal) rewrite(entries []Entry) error {
+	tmp := j.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	for _, e := range entries {
+		b, err := json.Marshal(&e)
+		if err != nil {
+			f.Close()
+			return err
+		}
+		w.Write(append(b, '\n'))
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, j.path)
+}
+
+// Append journals the operation, numbering and timing it, and returns the
+// entry once it is synced to the file
+func (j *Journal) Append(e Entry) (Entry, error) {
+	j.Lock()
+	defer j.Unlock()
+
+	if j.f == nil {
+		return e, fmt.Errorf("the journal %s is closed", j.path)
+	}
+	e.Seq, e.Time = j.seq+1, time.Now().UTC()
+	b, err := json.Marshal(&e)
+	if err != nil {
+		return e, err
+	}
+	if _, err := j.f.Write(append(b, '\n')); err != nil {
+		return e, err
+	}
+	if err := j.f.Sync(); err != nil {
+		return e, err
+	}
+	j.apply(e)
+	return e, nil
+}
+
+// apply accounts for the entry in the objects in effect
+func (j *Journal) apply(e Entry) {
+	if e.Seq > j.seq {
+		j.seq = e.Seq
+	}
+	if len(j.recent) == maxRecent {
+		j.recent = j.recent[1:]
+	}
+	j.recent = append(j.recent, e)
+
+	epKey := e.NetworkID + "/" + e.EndpointID
+	switch e.Op {
+	case NetworkCreate:
+		j.networks[e.NetworkID] = e
+	case NetworkDelete:
+		delete(j.networks, e.NetworkID)
+		for k, o := range j.endpoints {
+			if o.NetworkID == e.NetworkID {
+				delete(j.endpoints, k)
+			}
+		}
+		for k, o := range j.joins {
+			if o.NetworkID == e.NetworkID {
+				delete(j.joins, k)
+			}
+		}
+	case EndpointCreate:
+		j.endpoints[epKey] = e
+	case EndpointDelete:
+		delete(j.endpoints, epKey)
+		delete(j.joins, epKey)
+	case EndpointJoin:
+		j.joins[epKey] = e
+	case EndpointLeave:
+		delete(j.joins, epKey)
+	}
+}
+
+// Recent returns the last entries journaled, at most limit of them if
+// limit is positive, the oldest first
+func (j *Journal) Recent(limit int) []Entry {
+	j.Lock()
+	defer j.Unlock()
+	l := j.recent
+	if limit > 0 && len(l) > limit {
+		l = l[len(l)-limit:]
+	}
+	return append([]Entry(nil), l...)
+}
+
+// Live returns the entries of the networks, endpoints and joins still in
+// effect: the networks first, then the endpoints and the joins, each in
+// the order they were journaled
+func (j *Journal) Live() []Entry {
+	j.Lock()
+	defer j.Unlock()
+	return j.liveLocked()
+}
+
+func (j *Journal) liveLocked() []Entry {
+	l := make([]Entry, 0, len(j.networks)+len(j.endpoints)+len(j.joins))
+	for _, m := range []map[string]Entry{j.networks, j.endpoints, j.joins} {
+		start := len(l)
+		for _, e := range m {
+			l = append(l, e)
+		}
+		kind := l[start:]
+		sort.Slice(kind, func(a, b int) bool { return kind[a].Seq < kind[b].Seq })
+	}
+	return l
+}
+
+// Close closes the file of the journal, the entries appended after
+// failing
+func (j *Journal) Close() error {
+	j.Lock()
+	defer j.Unlock()
+	if j.f == nil {
+		return nil
+	}
+	err := j.f.Close()
+	j.f = nil
+	return err
+}
@@ -0,0 +1,110 @@
+package journal
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func ops(entries []Entry) []Op {
+	var l []Op
+	for _, e := range entries {
+		l = append(l, e.Op)
+	}
+	return l
+}
+
+func TestJournal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "journal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "network", "journal")
+
+	j, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range []Entry{
+		{Op: NetworkCreate, NetworkID: "n1", State: []byte(`{"name":"n1"}`)},
+		{Op: NetworkCreate, NetworkID: "n2"},
+		{Op: EndpointCreate, NetworkID: "n1", EndpointID: "e1"},
+		{Op: EndpointJoin, NetworkID: "n1", EndpointID: "e1", SandboxID: "s1"},
+		{Op: EndpointCreate, NetworkID: "n2", EndpointID: "e2"},
+		{Op: NetworkDelete, NetworkID: "n2"},
+	} {
+		if _, err := j.Append(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	j.Close()
+
+	// A crash in the middle of an append leaves a partial entry, which is
+	// dropped
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"seq":7,"op":"endpoint-le`)
+	f.Close()
+
+	if j, err = Open(path); err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+	live := j.Live()
+	if len(live) != 3 || live[0].NetworkID != "n1" || string(live[0].State) != `{"name":"n1"}` ||
+		live[1].Op != EndpointCreate || live[2].Op != EndpointJoin {
+		t.Fatalf("unexpected entries in effect %+v", live)
+	}
+	e, err := j.Append(Entry{Op: EndpointLeave, NetworkID: "n1", EndpointID: "e1", SandboxID: "s1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Seq != 7 || e.Time.IsZero() {
+		t.Fatalf("unexpected entry %+v", e)
+	}
+	if l := j.Live(); len(l) != 2 {
+		t.Fatalf("unexpected entries in effect %v", ops(l))
+	}
+	if l := j.Recent(2); len(l) != 2 || l[0].Op != NetworkDelete || l[1].Op != EndpointLeave {
+		t.Fatalf("unexpected recent entries %v", ops(l))
+	}
+}
+
+func TestJournalCompaction(t *testing.T) {
+	dir, err := ioutil.TempDir("", "journal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "journal")
+
+	j, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	j.Append(Entry{Op: NetworkCreate, NetworkID: "n1"})
+	for i := 0; i < compactMin; i++ {
+		j.Append(Entry{Op: EndpointJoin, NetworkID: "n1", EndpointID: "e1", SandboxID: "s1"})
+		j.Append(Entry{Op: EndpointLeave, NetworkID: "n1", EndpointID: "e1", SandboxID: "s1"})
+	}
+	j.Close()
+
+	if j, err = Open(path); err != nil {
+		t.Fatal(err)
+	}
+	j.Close()
+	if j, err = Open(path); err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+	if l := j.Recent(0); len(l) != 2 || l[0].Op != NetworkCreate {
+		t.Fatalf("journal not compacted: %d entries", len(l))
+	}
+	if e, _ := j.Append(Entry{Op: NetworkDelete, NetworkID: "n1"}); e.Seq != 2*compactMin+2 {
+		t.Fatalf("unexpected sequence %d after the compaction", e.Seq)
+	}
+}
@@ -0,0 +1,311 @@
+// Package labelparse parses the values of the network and endpoint labels.
+// The labels come from the orchestrators unchecked, the parsers bound the
+// length of the values and the number of their elements, and report their
+// failures as *Error, of one of the ErrorKind.
+package labelparse
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strings"
+)
+
+const (
+	// MaxLength bounds the length of the values
+	MaxLength = 4096
+	// MaxElements bounds the number of elements of the lists
+	MaxElements = 256
+	// maxQuoted bounds the part of the value quoted in the errors
+	maxQuoted = 64
+)
+
+// Family restricts the addresses parsed to a family
+type Family int
+
+const (
+	// AnyFamily accepts the IPv4 and the IPv6 addresses
+	AnyFamily Family = iota
+	// IPv4 accepts the IPv4 addresses only
+	IPv4
+	// IPv6 accepts the IPv6 addresses only
+	IPv6
+)
+
+func (f Family) String() string {
+	switch f {
+	case IPv4:
+		return "IPv4"
+	case IPv6:
+		return "IPv6"
+	}
+	return "IP"
+}
+
+// ErrorKind tells why a value was rejected
+type ErrorKind int
+
+const (
+	// ErrTooLong is the value exceeding MaxLength
+	ErrTooLong ErrorKind = iota + 1
+	// ErrTooManyElements is the list exceeding MaxElements
+	ErrTooManyElements
+	// ErrEmpty is the value or an element of it being empty
+	ErrEmpty
+	// ErrSyntax is the value or an element of it not being well formed
+	ErrSyntax
+	// ErrFamily is the address not being of the family expected
+	ErrFamily
+	// ErrReversed is the range ending before it starts
+	ErrReversed
+	// ErrDuplicate is the key found twice
+	ErrDuplicate
+)
+
+func (k ErrorKind) String() string {
+	switch k {
+	case ErrTooLong:
+		return "too long"
+	case ErrTooManyElements:
+		return "too many elements"
+	case ErrEmpty:
+		return "empty"
+	case ErrSyntax:
+		return "syntax error"
+	case ErrFamily:
+		return "wrong address family"
+	case ErrReversed:
+		return "reversed range"
+	case ErrDuplicate:
+		return "duplicate key"
+	}
+	return "invalid"
+}
+
+// Error is the rejection of a value
+type Error struct {
+	Kind ErrorKind
+	// What names what was expected, as in "IPv4 range"
+	What string
+	// Value is the value, or the element of it, rejected, truncated
+	Value string
+	// Detail completes the error, when not empty
+	Detail string
+}
+
+func (e *Error) Error() string {
+	msg := fmt.Sprintf("invalid %s %q: %s", e.What, e.Value, e.Kind)
+	if e.Detail != "" {
+		msg += ": " + e.Detail
+	}
+	return msg
+}
+
+// BadRequest denotes the type of this error
+func (e *Error) BadRequest() {}
+
+func newError(kind ErrorKind, what, value, detail string) *Error {
+	return &Error{Kind: kind, What: what, Value: truncate(value), Detail: detail}
+}
+
+// truncate shortens the value quoted in an error
+func truncate(value string) string {
+	if len(value) > maxQuoted {
+		return value[:maxQuoted] + "..."
+	}
+	return value
+}
+
+// checkLength rejects the values exceeding MaxLength
+func checkLength(what, value string) error {
+	if len(value) > MaxLength {
+		return newError(ErrTooLong, what, value, fmt.Sprintf("%d bytes, at most %d", len(value), MaxLength))
+	}
+	return nil
+}
+
+// ParseList splits the comma separated value, the elements trimmed and the
+// empty ones dropped
+func ParseList(what, value string) ([]string, error) {
+	if err := checkLength(what, value); err != nil {
+		return nil, err
+	}
+	if n := strings.Count(value, ",") + 1; n > MaxElements {
+		// the empty elements do not count
+		n = 0
+		for _, e := range strings.Split(value, ",") {
+			if strings.TrimSpace(e) != "" {
+				n++
+			}
+		}
+		if n > MaxElements {
+			return nil, newError(ErrTooManyElements, what+" list", value, fmt.Sprintf("%d elements, at most %d", n, MaxElements))
+		}
+	}
+	var elems []string
+	for _, e := range strings.Split(value, ",") {
+		if e = strings.TrimSpace(e); e != "" {
+			elems = append(elems, e)
+		}
+	}
+	return elems, nil
+}
+
+// parseIP parses the address of the family, the IPv4 ones in their 4 bytes
+// form
+func parseIP(what, value string, family Family) (net.IP, error) {
+	if value == "" {
+		return nil, newError(ErrEmpty, what, value, "")
+	}
+	ip := net.ParseIP(value)
+	if ip == nil {
+		return nil, newError(ErrSyntax, what, value, "")
+	}
+	if v4 := ip.To4(); v4 != nil && !strings.Contains(value, ":") {
+		ip = v4
+		if family == IPv6 {
+			return nil, newError(ErrFamily, what, value, "expected "+family.String())
+		}
+	} else if family == IPv4 {
+		return nil, newError(ErrFamily, what, value, "expected "+family.String())
+	}
+	return ip, nil
+}
+
+// ParseIPRange parses a range of the family, as in 10.0.0.10-10.0.0.20, a
+// single address being the range of itself
+func ParseIPRange(value string, family Family) (net.IP, net.IP, error) {
+	what := family.String() + " range"
+	if err := checkLength(what, value); err != nil {
+		return nil, nil, err
+	}
+	bounds := strings.SplitN(value, "-", 2)
+	start, err := parseIP(what, strings.TrimSpace(bounds[0]), family)
+	if err != nil {
+		return nil, nil, err
+	}
+	end := start
+	if len(bounds) == 2 {
+		if end, err = parseIP(what, strings.TrimSpace(bounds[1]), family); err != nil {
+			return nil, nil, err
+		}
+	}
+	if len(start) != len(end) {
+		return nil, nil, newError(ErrFamily, what, value, "the bounds are of different families")
+	}
+	if compareIP(start, end) > 0 {
+		return nil, nil, newError(ErrReversed, what, value, "")
+	}
+	return start, end, nil
+}
+
+func compareIP(a, b net.IP) int {
+	if len(a) == net.IPv4len {
+		x, y := binary.BigEndian.Uint32(a), binary.BigEndian.Uint32(b)
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+		return 0
+	}
+	return strings.Compare(string(a), string(b))
+}
+
+// ParseNet parses a subnet of the family in the CIDR notation, the address
+// masked
+func ParseNet(value string, family Family) (*net.IPNet, error) {
+	what := family.String() + " subnet"
+	if err := checkLength(what, value); err != nil {
+		return nil, err
+	}
+	if value == "" {
+		return nil, newError(ErrEmpty, what, value, "")
+	}
+	if !strings.Contains(value, "/") {
+		return nil, newError(ErrSyntax, what, value, "expected the CIDR notation")
+	}
+	return parseCIDR(what, value, family)
+}
+
+// ParseIPOrNet parses a subnet of the family in the CIDR notation, or an
+// address being the subnet of itself
+func ParseIPOrNet(value string, family Family) (*net.IPNet, error) {
+	what := family.String() + " address or subnet"
+	if err := checkLength(what, value); err != nil {
+		return nil, err
+	}
+	if !strings.Contains(value, "/") {
+		ip, err := parseIP(what, value, family)
+		if err != nil {
+			return nil, err
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(8*len(ip), 8*len(ip))}, nil
+	}
+	return parseCIDR(what, value, family)
+}
+
+func parseCIDR(what, value string, family Family) (*net.IPNet, error) {
+	// the address is checked alone for its error to tell why
+	if _, err := parseIP(what, value[:strings.IndexByte(value, '/')], family); err != nil {
+		err.(*Error).Value = truncate(value)
+		return nil, err
+	}
+	_, ipNet, err := net.ParseCIDR(value)
+	if err != nil {
+		return nil, newError(ErrSyntax, what, value, "")
+	}
+	return ipNet, nil
+}
+
+// ParseNetList parses the comma separated subnets of the family
+func ParseNetList(value string, family Family) ([]*net.IPNet, error) {
+	elems, err := ParseList(family.String()+" subnet", value)
+	if err != nil {
+		return nil, err
+	}
+	nets := make([]*net.IPNet, 0, len(elems))
+	for _, e := range elems {
+		n, err := ParseNet(e, family)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// KeyValue is an element of a key=value list
+type KeyValue struct {
+	Key   string
+	Value string
+}
+
+// ParseKeyValues parses the comma separated key=value elements, as the
+// netfilter and sysctl settings of the labels, in their order. The keys
+// must be unique, the values are left to the caller to check.
+func ParseKeyValues(what, value string) ([]KeyValue, error) {
+	elems, err := ParseList(what, value)
+	if err != nil {
+		return nil, err
+	}
+	kvs := make([]KeyValue, 0, len(elems))
+	seen := make(map[string]bool, len(elems))
+	for _, e := range elems {
+		kv := strings.SplitN(e, "=", 2)
+		if len(kv) != 2 {
+			return nil, newError(ErrSyntax, what, e, "expected as in key=value")
+		}
+		k, v := strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1])
+		if k == "" || v == "" {
+			return nil, newError(ErrEmpty, what, e, "expected as in key=value")
+		}
+		if seen[k] {
+			return nil, newError(ErrDuplicate, what, e, "")
+		}
+		seen[k] = true
+		kvs = append(kvs, KeyValue{Key: k, Value: v})
+	}
+	return kvs, nil
+}
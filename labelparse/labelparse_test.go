@@ -0,0 +1,194 @@
+package labelparse
+
+import (
+	"net"
+	"strings"
+	"testing"
+)
+
+// errorCorpus are rejected values and why, they seed the fuzz targets
+var errorCorpus = []struct {
+	parser string
+	value  string
+	family Family
+	kind   ErrorKind
+}{
+	{"range", "", IPv4, ErrEmpty},
+	{"range", "10.0.0.1-", IPv4, ErrEmpty},
+	{"range", "10.0.0.300", IPv4, ErrSyntax},
+	{"range", "10.0.0.1-10.0.0.x", IPv4, ErrSyntax},
+	{"range", "10.0.0.9-10.0.0.1", IPv4, ErrReversed},
+	{"range", "2001:db8::9-2001:db8::1", IPv6, ErrReversed},
+	{"range", "2001:db8::1", IPv4, ErrFamily},
+	{"range", "10.0.0.1-2001:db8::1", AnyFamily, ErrFamily},
+	{"range", "::ffff:10.0.0.1", IPv4, ErrFamily},
+	{"range", strings.Repeat("1", MaxLength+1), IPv4, ErrTooLong},
+	{"ipornet", "10.0.0.0/33", IPv4, ErrSyntax},
+	{"ipornet", "10.0.0.0/", IPv4, ErrSyntax},
+	{"ipornet", "/24", IPv4, ErrEmpty},
+	{"ipornet", "2001:db8::/64", IPv4, ErrFamily},
+	{"ipornet", "10.0.0.1", IPv6, ErrFamily},
+	{"ipornet", "10.0.0.0/8/8", IPv4, ErrSyntax},
+	{"net", "10.0.0.1", IPv4, ErrSyntax},
+	{"net", "", IPv4, ErrEmpty},
+	{"netlist", "10.0.0.0/8,,x", IPv4, ErrSyntax},
+	{"netlist", strings.Repeat("10.0.0.0/8,", MaxElements+1), IPv4, ErrTooManyElements},
+	{"kv", "a=1,a=2", AnyFamily, ErrDuplicate},
+	{"kv", "a", AnyFamily, ErrSyntax},
+	{"kv", "=1", AnyFamily, ErrEmpty},
+	{"kv", "a= ", AnyFamily, ErrEmpty},
+	{"kv", strings.Repeat("a=1,", MaxLength), AnyFamily, ErrTooLong},
+}
+
+func parse(parser, value string, family Family) error {
+	var err error
+	switch parser {
+	case "range":
+		_, _, err = ParseIPRange(value, family)
+	case "ipornet":
+		_, err = ParseIPOrNet(value, family)
+	case "net":
+		_, err = ParseNet(value, family)
+	case "netlist":
+		_, err = ParseNetList(value, family)
+	case "kv":
+		_, err = ParseKeyValues("setting", value)
+	}
+	return err
+}
+
+func TestErrors(t *testing.T) {
+	for _, c := range errorCorpus {
+		err := parse(c.parser, c.value, c.family)
+		e, ok := err.(*Error)
+		if !ok {
+			t.Fatalf("%s %.20q: expected an *Error, got %v", c.parser, c.value, err)
+		}
+		if e.Kind != c.kind {
+			t.Fatalf("%s %.20q: expected %s, got %v", c.parser, c.value, c.kind, err)
+		}
+		if len(e.Value) > maxQuoted+3 {
+			t.Fatalf("%s: value not truncated in %v", c.parser, err)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	start, end, err := ParseIPRange(" 10.0.0.10 - 10.0.0.20", IPv4)
+	if err != nil || !start.Equal(net.ParseIP("10.0.0.10")) || !end.Equal(net.ParseIP("10.0.0.20")) || len(start) != net.IPv4len {
+		t.Fatalf("unexpected range %s-%s: %v", start, end, err)
+	}
+	if start, end, err = ParseIPRange("2001:db8::1", AnyFamily); err != nil || !start.Equal(end) {
+		t.Fatalf("unexpected range %s-%s: %v", start, end, err)
+	}
+
+	n, err := ParseIPOrNet("10.0.0.5", IPv4)
+	if err != nil || n.String() != "10.0.0.5/32" {
+		t.Fatalf("unexpected subnet %v: %v", n, err)
+	}
+	if n, err = ParseIPOrNet("10.0.0.5/24", IPv4); err != nil || n.String() != "10.0.0.0/24" || len(n.IP) != net.IPv4len {
+		t.Fatalf("unexpected subnet %v: %v", n, err)
+	}
+	if n, err = ParseIPOrNet("2001:db8::1", AnyFamily); err != nil || n.String() != "2001:db8::1/128" {
+		t.Fatalf("unexpected subnet %v: %v", n, err)
+	}
+
+	nets, err := ParseNetList("10.0.0.0/8, ,192.168.0.0/16,", IPv4)
+	if err != nil || len(nets) != 2 || nets[1].String() != "192.168.0.0/16" {
+		t.Fatalf("unexpected subnets %v: %v", nets, err)
+	}
+
+	kvs, err := ParseKeyValues("setting", "b=2, a = 1")
+	if err != nil || len(kvs) != 2 || kvs[0] != (KeyValue{"b", "2"}) || kvs[1] != (KeyValue{"a", "1"}) {
+		t.Fatalf("unexpected settings %v: %v", kvs, err)
+	}
+}
+
+func checkError(t *testing.T, err error) {
+	e, ok := err.(*Error)
+	if !ok {
+		t.Fatalf("expected an *Error, got %T: %v", err, err)
+	}
+	if e.Kind == 0 || len(e.Value) > maxQuoted+3 {
+		t.Fatalf("malformed error %v", err)
+	}
+}
+
+func seed(f *testing.F, parser string, values ...string) {
+	for _, c := range errorCorpus {
+		if c.parser == parser {
+			f.Add(c.value)
+		}
+	}
+	for _, v := range values {
+		f.Add(v)
+	}
+}
+
+func FuzzParseIPRange(f *testing.F) {
+	seed(f, "range", "10.0.0.1-10.0.0.2", "2001:db8::1-2001:db8::2")
+	f.Fuzz(func(t *testing.T, value string) {
+		start, end, err := ParseIPRange(value, AnyFamily)
+		if err != nil {
+			checkError(t, err)
+			return
+		}
+		if len(start) != len(end) || compareIP(start, end) > 0 {
+			t.Fatalf("%q: invalid range %s-%s", value, start, end)
+		}
+		s2, e2, err := ParseIPRange(start.String()+"-"+end.String(), AnyFamily)
+		if err != nil || !s2.Equal(start) || !e2.Equal(end) {
+			t.Fatalf("%q: range %s-%s does not parse back: %v", value, start, end, err)
+		}
+	})
+}
+
+func FuzzParseIPOrNet(f *testing.F) {
+	seed(f, "ipornet", "10.0.0.0/24", "10.0.0.1", "2001:db8::/64")
+	f.Fuzz(func(t *testing.T, value string) {
+		n, err := ParseIPOrNet(value, AnyFamily)
+		if err != nil {
+			checkError(t, err)
+			return
+		}
+		n2, err := ParseIPOrNet(n.String(), AnyFamily)
+		if err != nil || n2.String() != n.String() {
+			t.Fatalf("%q: subnet %s does not parse back: %v", value, n, err)
+		}
+	})
+}
+
+func FuzzParseNetList(f *testing.F) {
+	seed(f, "netlist", "10.0.0.0/8,192.168.0.0/16")
+	f.Fuzz(func(t *testing.T, value string) {
+		nets, err := ParseNetList(value, AnyFamily)
+		if err != nil {
+			checkError(t, err)
+			return
+		}
+		if len(nets) > MaxElements {
+			t.Fatalf("%q: %d subnets", value, len(nets))
+		}
+	})
+}
+
+func FuzzParseKeyValues(f *testing.F) {
+	seed(f, "kv", "rp_filter=2,proxy_arp=1", "udp=1h")
+	f.Fuzz(func(t *testing.T, value string) {
+		kvs, err := ParseKeyValues("setting", value)
+		if err != nil {
+			checkError(t, err)
+			return
+		}
+		if len(kvs) > MaxElements {
+			t.Fatalf("%q: %d settings", value, len(kvs))
+		}
+		seen := make(map[string]bool)
+		for _, kv := range kvs {
+			if kv.Key == "" || kv.Value == "" || strings.ContainsAny(kv.Key, "=,") || strings.Contains(kv.Value, ",") || seen[kv.Key] {
+				t.Fatalf("%q: invalid setting %+v", value, kv)
+			}
+			seen[kv.Key] = true
+		}
+	})
+}
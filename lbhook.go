@@ -0,0 +1,100 @@
+package libnetwork
+
+import (
+	"strings"
+
+	"github.com/docker/libnetwork/lbhook"
+	"github.com/docker/libnetwork/netlabel"
+	"github.com/sirupsen/logrus"
+)
+
+// lbHookQueueLen is the number of changes queued to the hooks before the
+// service bindings wait for the hooks
+const lbHookQueueLen = 256
+
+// lbHookUpdate is a change of a service to publish to a hook, service
+// being nil when the service is removed from the network
+type lbHookUpdate struct {
+	hook      string
+	serviceID string
+	networkID string
+	service   *lbhook.Service
+}
+
+// lbHookName returns the name of the configured hook the network is
+// labeled with, if any
+func (c *controller) lbHookName(n *network) string {
+	name, ok := n.Labels()[netlabel.LBHook]
+	if !ok {
+		return ""
+	}
+	if _, ok := c.cfg.Daemon.LBHooks[name]; !ok {
+		logrus.Warnf("Network %s is labeled with the unknown load balancer hook %q", n.Name(), name)
+		return ""
+	}
+	return name
+}
+
+// publishLBHook queues the state of the service on the network to its
+// hook. It is called with the service locked, so that the changes are
+// queued in order.
+func (c *controller) publishLBHook(s *service, nID string, lb *loadBalancer, removed bool) {
+	if lb.hook == "" || c.lbHookQueue == nil {
+		return
+	}
+
+	u := lbHookUpdate{hook: lb.hook, serviceID: s.id, networkID: nID}
+	if !removed {
+		svc := &lbhook.Service{
+			ID:        s.id,
+			Name:      s.name,
+			NetworkID: nID,
+			VIP:       lb.vip,
+			Backends:  []lbhook.Backend{},
+		}
+		for _, pc := range s.ingressPorts {
+			svc.Ports = append(svc.Ports, lbhook.Port{
+				Name:          pc.Name,
+				Protocol:      strings.ToLower(PortConfig_Protocol_name[int32(pc.Protocol)]),
+				PublishedPort: pc.PublishedPort,
+				TargetPort:    pc.TargetPort,
+			})
+		}
+		for eid, be := range lb.backEnds {
+			if be.disabled {
+				continue
+			}
+			svc.Backends = append(svc.Backends, lbhook.Backend{EndpointID: eid, IP: be.ip})
+		}
+		svc.Sort()
+		u.service = svc
+	}
+
+	select {
+	case c.lbHookQueue <- u:
+	case <-c.lbHookStop:
+	}
+}
+
+// runLBHooks calls the hooks with the queued changes
+func (c *controller) runLBHooks(queue chan lbHookUpdate, stopCh chan struct{}) {
+	for {
+		select {
+		case u := <-queue:
+			provider := c.cfg.Daemon.LBHooks[u.hook]
+			var err error
+			if u.service != nil {
+				logrus.Debugf("Publishing service %s to load balancer hook %s", u.service, u.hook)
+				err = provider.ServiceUpdated(u.service)
+			} else {
+				logrus.Debugf("Removing service %.7s on %.7s from load balancer hook %s", u.serviceID, u.networkID, u.hook)
+				err = provider.ServiceRemoved(u.serviceID, u.networkID)
+			}
+			if err != nil {
+				logrus.Warnf("Load balancer hook %s failed for service %.7s on %.7s: %v", u.hook, u.serviceID, u.networkID, err)
+			}
+		case <-stopCh:
+			return
+		}
+	}
+}
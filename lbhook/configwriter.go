@@ -0,0 +1,214 @@
+package lbhook
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Format is the configuration syntax a ConfigWriter renders
+type Format string
+
+const (
+	// FormatHAProxy renders HAProxy frontends and backends, the UDP ports
+	// being left out as HAProxy does not balance them
+	FormatHAProxy Format = "haproxy"
+	// FormatNginx renders nginx stream upstreams and servers, to be
+	// included in the stream context
+	FormatNginx Format = "nginx"
+)
+
+const configHeader = "# Generated by libnetwork from the published services, do not edit\n"
+
+// ConfigWriter is a reference Provider which renders the published
+// services as the configuration of an HAProxy or nginx load balancer. The
+// file is replaced atomically on each change, and the reload command, if
+// any, run after.
+type ConfigWriter struct {
+	sync.Mutex
+	path     string
+	format   Format
+	reload   []string
+	services map[string]*Service
+}
+
+// NewConfigWriter returns a ConfigWriter rendering the configuration in
+// the format to path, and running the reload command after each change
+func NewConfigWriter(path string, format Format, reload ...string) (*ConfigWriter, error) {
+	switch format {
+	case FormatHAProxy, FormatNginx:
+	default:
+		return nil, fmt.Errorf("unknown load balancer configuration format %q", format)
+	}
+	if path == "" {
+		return nil, fmt.Errorf("no load balancer configuration path")
+	}
+	return &ConfigWriter{
+		path:     path,
+		format:   format,
+		reload:   reload,
+		services: map[string]*Service{},
+	}, nil
+}
+
+// ServiceUpdated renders the configuration with the service
+func (w *ConfigWriter) ServiceUpdated(svc *Service) error {
+	s := *svc
+	s.Ports = append([]Port(nil), svc.Ports...)
+	s.Backends = append([]Backend(nil), svc.Backends...)
+	s.Sort()
+
+	w.Lock()
+	defer w.Unlock()
+	w.services[s.Key()] = &s
+	return w.write()
+}
+
+// ServiceRemoved renders the configuration without the service
+func (w *ConfigWriter) ServiceRemoved(id, networkID string) error {
+	w.Lock()
+	defer w.Unlock()
+	key := (&Service{ID: id, NetworkID: networkID}).Key()
+	if _, ok := w.services[key]; !ok {
+		return nil
+	}
+	delete(w.services, key)
+	return w.write()
+}
+
+// Render returns the configuration of the current services
+func (w *ConfigWriter) Render() []byte {
+	w.Lock()
+	defer w.Unlock()
+	return w.render()
+}
+
+func (w *ConfigWriter) render() []byte {
+	keys := make([]string, 0, len(w.services))
+	for k := range w.services {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b bytes.Buffer
+	b.WriteString(configHeader)
+	for _, k := range keys {
+		s := w.services[k]
+		for _, p := range s.Ports {
+			switch w.format {
+			case FormatHAProxy:
+				renderHAProxy(&b, s, p)
+			case FormatNginx:
+				renderNginx(&b, s, p)
+			}
+		}
+	}
+	return b.Bytes()
+}
+
+// sectionName names the sections of the port of the service, unique
+// across the networks
+func sectionName(s *Service, p Port) string {
+	network := s.NetworkID
+	if len(network) > 12 {
+		network = network[:12]
+	}
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_', r == '.':
+			return r
+		}
+		return '_'
+	}, s.Name)
+	return fmt.Sprintf("%s_%s_%d_%s", name, network, p.ListenPort(), p.Protocol)
+}
+
+func backendName(be Backend) string {
+	if len(be.EndpointID) > 12 {
+		return be.EndpointID[:12]
+	}
+	return be.EndpointID
+}
+
+func renderHAProxy(b *bytes.Buffer, s *Service, p Port) {
+	name := sectionName(s, p)
+	if p.Protocol != "tcp" {
+		fmt.Fprintf(b, "\n# %s: %s ports are not balanced by haproxy\n", name, p.Protocol)
+		return
+	}
+	fmt.Fprintf(b, "\nfrontend %s\n", name)
+	fmt.Fprintf(b, "    mode tcp\n")
+	fmt.Fprintf(b, "    bind *:%d\n", p.ListenPort())
+	fmt.Fprintf(b, "    default_backend %s\n", name)
+	fmt.Fprintf(b, "\nbackend %s\n", name)
+	fmt.Fprintf(b, "    mode tcp\n")
+	fmt.Fprintf(b, "    balance roundrobin\n")
+	for _, be := range s.Backends {
+		fmt.Fprintf(b, "    server %s %s:%d check\n", backendName(be), be.IP, p.TargetPort)
+	}
+}
+
+func renderNginx(b *bytes.Buffer, s *Service, p Port) {
+	name := sectionName(s, p)
+	listen := fmt.Sprintf("%d", p.ListenPort())
+	if p.Protocol == "udp" {
+		listen += " udp"
+	}
+	fmt.Fprintf(b, "\nupstream %s {\n", name)
+	for _, be := range s.Backends {
+		fmt.Fprintf(b, "    server %s:%d;\n", be.IP, p.TargetPort)
+	}
+	if len(s.Backends) == 0 {
+		// An upstream without servers is rejected
+		fmt.Fprintf(b, "    server 127.0.0.1:%d down;\n", p.TargetPort)
+	}
+	fmt.Fprintf(b, "}\n")
+	fmt.Fprintf(b, "\nserver {\n")
+	fmt.Fprintf(b, "    listen %s;\n", listen)
+	fmt.Fprintf(b, "    proxy_pass %s;\n", name)
+	fmt.Fprintf(b, "}\n")
+}
+
+func (w *ConfigWriter) write() error {
+	config := w.render()
+	if old, err := ioutil.ReadFile(w.path); err == nil && bytes.Equal(old, config) {
+		return nil
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(w.path), "."+filepath.Base(w.path))
+	if err != nil {
+		return fmt.Errorf("failed to write the load balancer configuration: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(config); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write the load balancer configuration: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write the load balancer configuration: %v", err)
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return fmt.Errorf("failed to write the load balancer configuration: %v", err)
+	}
+	if err := os.Rename(tmp.Name(), w.path); err != nil {
+		return fmt.Errorf("failed to replace the load balancer configuration: %v", err)
+	}
+
+	if len(w.reload) == 0 {
+		return nil
+	}
+	out, err := exec.Command(w.reload[0], w.reload[1:]...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("failed to reload the load balancer: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+	logrus.Debugf("Load balancer reloaded with the configuration %s", w.path)
+	return nil
+}
@@ -0,0 +1,134 @@
+package lbhook
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func testService() *Service {
+	return &Service{
+		ID:        "svc1",
+		Name:      "web",
+		NetworkID: "0123456789abcdef",
+		VIP:       net.ParseIP("10.0.0.2"),
+		Ports: []Port{
+			{Protocol: "udp", PublishedPort: 53, TargetPort: 5353},
+			{Protocol: "tcp", PublishedPort: 8080, TargetPort: 80},
+		},
+		Backends: []Backend{
+			{EndpointID: "ep2ep2ep2ep2ep2", IP: net.ParseIP("10.0.0.4")},
+			{EndpointID: "ep1ep1ep1ep1ep1", IP: net.ParseIP("10.0.0.3")},
+		},
+	}
+}
+
+func TestRenderHAProxy(t *testing.T) {
+	w, err := NewConfigWriter("/unused", FormatHAProxy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := testService()
+	s.Sort()
+	w.services[s.Key()] = s
+
+	expected := configHeader + `
+# web_0123456789ab_53_udp: udp ports are not balanced by haproxy
+
+frontend web_0123456789ab_8080_tcp
+    mode tcp
+    bind *:8080
+    default_backend web_0123456789ab_8080_tcp
+
+backend web_0123456789ab_8080_tcp
+    mode tcp
+    balance roundrobin
+    server ep1ep1ep1ep1 10.0.0.3:80 check
+    server ep2ep2ep2ep2 10.0.0.4:80 check
+`
+	if got := string(w.Render()); got != expected {
+		t.Fatalf("unexpected configuration:\n%s\nexpected:\n%s", got, expected)
+	}
+}
+
+func TestRenderNginx(t *testing.T) {
+	w, err := NewConfigWriter("/unused", FormatNginx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := testService()
+	s.Name = "web api"
+	s.Ports = s.Ports[:1]
+	s.Sort()
+	w.services[s.Key()] = s
+
+	expected := configHeader + `
+upstream web_api_0123456789ab_53_udp {
+    server 10.0.0.3:5353;
+    server 10.0.0.4:5353;
+}
+
+server {
+    listen 53 udp;
+    proxy_pass web_api_0123456789ab_53_udp;
+}
+`
+	if got := string(w.Render()); got != expected {
+		t.Fatalf("unexpected configuration:\n%s\nexpected:\n%s", got, expected)
+	}
+}
+
+func TestConfigWriter(t *testing.T) {
+	if _, err := NewConfigWriter("/tmp/lb.cfg", Format("envoy")); err == nil {
+		t.Fatal("expected an error for an unknown format")
+	}
+
+	dir, err := ioutil.TempDir("", "lbhook")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "haproxy.cfg")
+	marker := filepath.Join(dir, "reloaded")
+
+	w, err := NewConfigWriter(path, FormatHAProxy, "touch", marker)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.ServiceUpdated(testService()); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), "server ep1ep1ep1ep1 10.0.0.3:80 check") {
+		t.Fatalf("service missing from the configuration:\n%s", b)
+	}
+	if _, err := os.Stat(marker); err != nil {
+		t.Fatalf("load balancer not reloaded: %v", err)
+	}
+
+	// An unchanged configuration is neither written nor reloaded
+	os.Remove(marker)
+	if err := w.ServiceUpdated(testService()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(marker); err == nil {
+		t.Fatal("load balancer reloaded for an unchanged configuration")
+	}
+
+	if err := w.ServiceRemoved("svc1", "0123456789abcdef"); err != nil {
+		t.Fatal(err)
+	}
+	b, err = ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != configHeader {
+		t.Fatalf("service left in the configuration:\n%s", b)
+	}
+}
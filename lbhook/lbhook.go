@@ -0,0 +1,78 @@
+// Package lbhook publishes the services of the networks to external load
+// balancers. A Provider is told of the VIP, ports and backends of each
+// service whenever they change, so that the load balancer tracks the
+// services without polling.
+package lbhook
+
+import (
+	"fmt"
+	"net"
+	"sort"
+)
+
+// Port is a port published by a service
+type Port struct {
+	Name          string `json:"name,omitempty"`
+	Protocol      string `json:"protocol"`
+	PublishedPort uint32 `json:"published_port,omitempty"`
+	TargetPort    uint32 `json:"target_port"`
+}
+
+// ListenPort is the port the load balancer listens on for the port,
+// the target port when the port is not published
+func (p Port) ListenPort() uint32 {
+	if p.PublishedPort != 0 {
+		return p.PublishedPort
+	}
+	return p.TargetPort
+}
+
+// Backend is a task of a service
+type Backend struct {
+	EndpointID string `json:"endpoint_id"`
+	IP         net.IP `json:"ip"`
+}
+
+// Service is the state of a service on a network
+type Service struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	NetworkID string    `json:"network_id"`
+	VIP       net.IP    `json:"vip,omitempty"`
+	Ports     []Port    `json:"ports,omitempty"`
+	Backends  []Backend `json:"backends"`
+}
+
+// Key identifies the service on its network
+func (s *Service) Key() string {
+	return s.ID + "/" + s.NetworkID
+}
+
+// Sort orders the ports and backends of the service, so that an unchanged
+// service renders the same
+func (s *Service) Sort() {
+	sort.Slice(s.Ports, func(i, j int) bool {
+		if s.Ports[i].ListenPort() != s.Ports[j].ListenPort() {
+			return s.Ports[i].ListenPort() < s.Ports[j].ListenPort()
+		}
+		return s.Ports[i].Protocol < s.Ports[j].Protocol
+	})
+	sort.Slice(s.Backends, func(i, j int) bool {
+		return s.Backends[i].EndpointID < s.Backends[j].EndpointID
+	})
+}
+
+func (s *Service) String() string {
+	return fmt.Sprintf("%s (%.7s) on %.7s vip:%v ports:%d backends:%d", s.Name, s.ID, s.NetworkID, s.VIP, len(s.Ports), len(s.Backends))
+}
+
+// Provider is an external load balancer the services are published to.
+// The calls are serialized in the order of the changes.
+type Provider interface {
+	// ServiceUpdated is called when the service is added to the network
+	// or its backends change
+	ServiceUpdated(svc *Service) error
+	// ServiceRemoved is called when the last backend of the service on
+	// the network is removed
+	ServiceRemoved(id, networkID string) error
+}
@@ -1,19 +1,30 @@
 package libnetwork
 
 import (
+	"bytes"
+	"context"
+	"encoding/binary"
 	"encoding/json"
 	"fmt"
 	"net"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/docker/go-events"
+	"github.com/docker/libnetwork/authz"
+	"github.com/docker/libnetwork/config"
 	"github.com/docker/libnetwork/datastore"
 	"github.com/docker/libnetwork/discoverapi"
 	"github.com/docker/libnetwork/driverapi"
+	"github.com/docker/libnetwork/ephook"
+	"github.com/docker/libnetwork/hostdiscovery"
 	"github.com/docker/libnetwork/internal/setmatrix"
 	"github.com/docker/libnetwork/ipamapi"
 	"github.com/docker/libnetwork/netlabel"
 	"github.com/docker/libnetwork/netutils"
+	"github.com/docker/libnetwork/networkdb"
+	"github.com/docker/libnetwork/otlp"
 	"github.com/docker/libnetwork/testutils"
 	"github.com/docker/libnetwork/types"
 )
@@ -204,6 +215,9 @@ func TestEndpointMarshalling(t *testing.T) {
 			v6PoolID:  "poolv6",
 			llAddrs:   lla,
 		},
+		staticRoutes: []*types.StaticRoute{
+			{Destination: &net.IPNet{IP: net.IP{10, 10, 0, 0}, Mask: net.IPMask{255, 255, 0, 0}}, RouteType: types.NEXTHOP, NextHop: net.IP{10, 0, 1, 1}},
+		},
 	}
 
 	b, err := json.Marshal(e)
@@ -220,6 +234,37 @@ func TestEndpointMarshalling(t *testing.T) {
 	if e.name != ee.name || e.id != ee.id || e.sandboxID != ee.sandboxID || !compareEndpointInterface(e.iface, ee.iface) || e.anonymous != ee.anonymous {
 		t.Fatalf("JSON marsh/unmarsh failed.\nOriginal:\n%#v\nDecoded:\n%#v\nOriginal iface: %#v\nDecodediface:\n%#v", e, ee, e.iface, ee.iface)
 	}
+
+	if len(ee.staticRoutes) != 1 || !types.CompareIPNet(e.staticRoutes[0].Destination, ee.staticRoutes[0].Destination) ||
+		!e.staticRoutes[0].NextHop.Equal(ee.staticRoutes[0].NextHop) || e.staticRoutes[0].RouteType != ee.staticRoutes[0].RouteType {
+		t.Fatalf("JSON marsh/unmarsh of static routes failed: %v", ee.staticRoutes)
+	}
+}
+
+func TestValidateStaticRoutes(t *testing.T) {
+	dst, _ := types.ParseCIDR("10.10.0.0/16")
+	dst6, _ := types.ParseCIDR("2001:db8::/64")
+
+	valid := []*types.StaticRoute{
+		{Destination: dst, RouteType: types.NEXTHOP, NextHop: net.ParseIP("10.0.1.1")},
+		{Destination: dst6, RouteType: types.CONNECTED},
+	}
+	if err := validateStaticRoutes(valid); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, r := range []*types.StaticRoute{
+		{RouteType: types.CONNECTED},
+		{Destination: dst, RouteType: types.NEXTHOP},
+		{Destination: dst6, RouteType: types.NEXTHOP, NextHop: net.ParseIP("10.0.1.1")},
+		{Destination: dst, RouteType: types.CONNECTED, NextHop: net.ParseIP("10.0.1.1")},
+		{Destination: dst, RouteType: 5},
+	} {
+		err := validateStaticRoutes([]*types.StaticRoute{r})
+		if _, ok := err.(types.BadRequestError); !ok {
+			t.Fatalf("Expected a BadRequestError for route %v, got: %v", r, err)
+		}
+	}
 }
 
 func compareEndpointInterface(a, b *endpointInterface) bool {
@@ -689,3 +734,1114 @@ func (b *badDriver) EventNotify(etype driverapi.EventType, nid, tableName, key s
 func (b *badDriver) DecodeTableEntry(tablename string, key string, value []byte) (string, map[string]string) {
 	return "", nil
 }
+
+var deletableDriverName = "deletable network driver"
+
+// deletableDriver creates networks which, unlike the ones of the null and
+// host drivers, can be deleted, and fails on endpoints as badDriver does
+type deletableDriver struct {
+	badDriver
+}
+
+func (d *deletableDriver) Type() string {
+	return deletableDriverName
+}
+
+// addDeletableDriver registers the deletableDriver with the controller
+func addDeletableDriver(t *testing.T, c NetworkController) {
+	err := c.(*controller).drvRegistry.AddDriver(deletableDriverName, func(reg driverapi.DriverCallback, opt map[string]interface{}) error {
+		return reg.RegisterDriver(deletableDriverName, &deletableDriver{}, driverapi.Capability{DataScope: datastore.LocalScope})
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestParseBPFProgram(t *testing.T) {
+	prog, err := parseBPFProgram([]byte("4\n40 0 0 12\n21 0 1 2048\n6 0 0 65535\n6 0 0 0\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(prog) != 4 || prog[1].Op != 21 || prog[1].Jf != 1 || prog[1].K != 2048 {
+		t.Fatalf("unexpected program: %v", prog)
+	}
+
+	for _, b := range []string{"", "x\n", "2\n6 0 0 0\n", "1\n6 0 0\n"} {
+		if _, err := parseBPFProgram([]byte(b)); err == nil {
+			t.Fatalf("expected an error parsing %q", b)
+		}
+	}
+}
+
+func TestWritePcap(t *testing.T) {
+	var b bytes.Buffer
+	if err := writePcapHeader(&b); err != nil {
+		t.Fatal(err)
+	}
+	ts := time.Unix(1500000000, 250000000)
+	if err := writePcapPacket(&b, ts, []byte{1, 2, 3}, 60); err != nil {
+		t.Fatal(err)
+	}
+
+	out := b.Bytes()
+	if len(out) != 24+16+3 {
+		t.Fatalf("unexpected pcap length %d", len(out))
+	}
+	if binary.LittleEndian.Uint32(out) != 0xa1b2c3d4 || binary.LittleEndian.Uint32(out[20:]) != pcapLinkTypeEthernet {
+		t.Fatalf("unexpected pcap header %x", out[:24])
+	}
+	rec := out[24:]
+	if binary.LittleEndian.Uint32(rec) != 1500000000 || binary.LittleEndian.Uint32(rec[4:]) != 250000 ||
+		binary.LittleEndian.Uint32(rec[8:]) != 3 || binary.LittleEndian.Uint32(rec[12:]) != 60 {
+		t.Fatalf("unexpected packet record %x", rec[:16])
+	}
+}
+
+func TestConnectivityCheckValidate(t *testing.T) {
+	for _, check := range []*ConnectivityCheck{
+		{NetworkID: "n1", EndpointID: "e1", Address: net.ParseIP("10.0.0.1")},
+		{NetworkID: "n1", EndpointID: "e1", DestNetworkID: "n2", DestEndpointID: "e2", Probe: ProbeARP},
+		{NetworkID: "n1", EndpointID: "e1", Address: net.ParseIP("10.0.0.1"), Probe: ProbeTCP, Port: 80, Timeout: time.Second},
+	} {
+		if err := check.validate(); err != nil {
+			t.Fatalf("unexpected error validating %+v: %v", check, err)
+		}
+	}
+
+	for _, check := range []*ConnectivityCheck{
+		{Address: net.ParseIP("10.0.0.1")},
+		{NetworkID: "n1", EndpointID: "e1"},
+		{NetworkID: "n1", EndpointID: "e1", DestEndpointID: "e2", Address: net.ParseIP("10.0.0.1")},
+		{NetworkID: "n1", EndpointID: "e1", DestEndpointID: "e2"},
+		{NetworkID: "n1", EndpointID: "e1", Address: net.ParseIP("10.0.0.1"), Probe: ProbeTCP},
+		{NetworkID: "n1", EndpointID: "e1", Address: net.ParseIP("10.0.0.1"), Probe: "udp"},
+		{NetworkID: "n1", EndpointID: "e1", Address: net.ParseIP("10.0.0.1"), Timeout: time.Hour},
+	} {
+		if err := check.validate(); err == nil {
+			t.Fatalf("expected an error validating %+v", check)
+		}
+	}
+}
+
+func TestFloatingIPConfigValidate(t *testing.T) {
+	valid := FloatingIPConfig{Name: "vip", Address: net.ParseIP("192.168.1.10"), NetworkID: "n1", EndpointID: "e1", Priority: 100}
+	if err := valid.validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, mod := range []func(*FloatingIPConfig){
+		func(cfg *FloatingIPConfig) { cfg.Name = "" },
+		func(cfg *FloatingIPConfig) { cfg.Name = "a/b" },
+		func(cfg *FloatingIPConfig) { cfg.Address = nil },
+		func(cfg *FloatingIPConfig) { cfg.Address = net.ParseIP("fd00::1") },
+		func(cfg *FloatingIPConfig) { cfg.Address = net.IPv4zero },
+		func(cfg *FloatingIPConfig) { cfg.EndpointID = "" },
+		func(cfg *FloatingIPConfig) { cfg.Priority = MaxFloatingIPPriority + 1 },
+	} {
+		cfg := valid
+		mod(&cfg)
+		if err := cfg.validate(); err == nil {
+			t.Fatalf("expected an error validating %+v", cfg)
+		}
+	}
+}
+
+func TestFloatingIPShouldAcquire(t *testing.T) {
+	now := time.Now()
+	f := &floatingIP{config: FloatingIPConfig{Priority: MaxFloatingIPPriority - 10}}
+
+	if !f.shouldAcquire(&floatingIPLease{}, false, "c1", now) {
+		t.Fatal("missing lease not acquired")
+	}
+	if !f.shouldAcquire(&floatingIPLease{Owner: "c1", Expiry: now.Add(time.Second)}, true, "c1", now) {
+		t.Fatal("own lease not renewed")
+	}
+	held := &floatingIPLease{Owner: "c2", Expiry: now.Add(time.Second)}
+	if f.shouldAcquire(held, true, "c1", now) {
+		t.Fatal("lease of another holder taken over before its expiry")
+	}
+
+	expired := &floatingIPLease{Owner: "c2", Expiry: now.Add(-time.Second)}
+	if f.shouldAcquire(expired, true, "c1", now) {
+		t.Fatal("expired lease taken over before the skew")
+	}
+	if f.shouldAcquire(expired, true, "c1", now.Add(f.takeoverSkew()/2)) {
+		t.Fatal("expired lease taken over before the skew")
+	}
+	if !f.shouldAcquire(expired, true, "c1", now.Add(f.takeoverSkew())) {
+		t.Fatal("expired lease not taken over after the skew")
+	}
+
+	// A renewal by the holder restarts the skew
+	f.shouldAcquire(held, true, "c1", now)
+	if f.shouldAcquire(expired, true, "c1", now.Add(f.takeoverSkew())) {
+		t.Fatal("skew not restarted by the renewal of the lease")
+	}
+}
+
+func TestGratuitousARP(t *testing.T) {
+	mac := net.HardwareAddr{0x02, 0x42, 0xac, 0x11, 0x00, 0x02}
+	b := gratuitousARP(mac, net.ParseIP("192.168.1.10"))
+	if len(b) != 42 {
+		t.Fatalf("unexpected frame length %d", len(b))
+	}
+	if !bytes.Equal(b[0:6], []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}) || !bytes.Equal(b[6:12], mac) ||
+		binary.BigEndian.Uint16(b[12:14]) != 0x0806 || binary.BigEndian.Uint16(b[20:22]) != 1 {
+		t.Fatalf("unexpected frame header %x", b[:22])
+	}
+	if !bytes.Equal(b[22:28], mac) || !bytes.Equal(b[28:32], []byte{192, 168, 1, 10}) ||
+		!bytes.Equal(b[32:38], make([]byte, 6)) || !bytes.Equal(b[38:42], []byte{192, 168, 1, 10}) {
+		t.Fatalf("unexpected frame addresses %x", b[22:])
+	}
+}
+
+func TestPublishLBHook(t *testing.T) {
+	c := &controller{lbHookQueue: make(chan lbHookUpdate, 2), lbHookStop: make(chan struct{})}
+	s := newService("web", "svc1", []*PortConfig{{Protocol: ProtocolTCP, PublishedPort: 8080, TargetPort: 80}}, nil)
+	lb := &loadBalancer{
+		vip: net.ParseIP("10.0.0.2"),
+		backEnds: map[string]*lbBackend{
+			"ep2": {ip: net.ParseIP("10.0.0.4")},
+			"ep1": {ip: net.ParseIP("10.0.0.3")},
+			"ep3": {ip: net.ParseIP("10.0.0.5"), disabled: true},
+		},
+		service: s,
+		hook:    "haproxy",
+	}
+
+	c.publishLBHook(s, "n1", lb, false)
+	u := <-c.lbHookQueue
+	if u.hook != "haproxy" || u.service == nil {
+		t.Fatalf("unexpected update %+v", u)
+	}
+	svc := u.service
+	if svc.Name != "web" || svc.NetworkID != "n1" || !svc.VIP.Equal(lb.vip) ||
+		len(svc.Ports) != 1 || svc.Ports[0].Protocol != "tcp" || svc.Ports[0].PublishedPort != 8080 {
+		t.Fatalf("unexpected service %+v", svc)
+	}
+	if len(svc.Backends) != 2 || svc.Backends[0].EndpointID != "ep1" || svc.Backends[1].EndpointID != "ep2" {
+		t.Fatalf("unexpected backends %+v", svc.Backends)
+	}
+
+	c.publishLBHook(s, "n1", lb, true)
+	if u := <-c.lbHookQueue; u.service != nil || u.serviceID != "svc1" || u.networkID != "n1" {
+		t.Fatalf("unexpected removal %+v", u)
+	}
+
+	lb.hook = ""
+	c.publishLBHook(s, "n1", lb, false)
+	if len(c.lbHookQueue) != 0 {
+		t.Fatal("service published without a hook")
+	}
+}
+
+func TestRunEndpointHooks(t *testing.T) {
+	var events []*ephook.Event
+	record := func(name string, fail bool) ephook.Hook {
+		return ephook.Func(func(ctx context.Context, ev *ephook.Event) error {
+			events = append(events, ev)
+			if fail {
+				return fmt.Errorf("%s failed", name)
+			}
+			return nil
+		})
+	}
+	c := &controller{cfg: &config.Config{}}
+	c.cfg.Daemon.EndpointHooks = map[string]ephook.Hook{"ipam": record("ipam", false), "tor": record("tor", true)}
+
+	n := &network{id: "n1", name: "net", networkType: "bridge", labels: map[string]string{netlabel.EndpointHooks: "ipam, unknown,tor"}}
+	ep := &endpoint{id: "ep1", name: "web", iface: &endpointInterface{
+		mac:  net.HardwareAddr{2, 0, 0, 0, 0, 1},
+		addr: &net.IPNet{IP: net.ParseIP("10.0.0.2"), Mask: net.CIDRMask(24, 32)},
+	}}
+
+	if err := c.runEndpointHooks(context.Background(), ephook.PhaseCreate, n, ep, nil); err == nil || !strings.Contains(err.Error(), "tor failed") {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if len(events) != 2 {
+		t.Fatalf("unexpected hook runs %d", len(events))
+	}
+	ev := events[0]
+	if ev.Phase != ephook.PhaseCreate || ev.NetworkType != "bridge" || ev.EndpointName != "web" ||
+		ev.MacAddress != "02:00:00:00:00:01" || ev.IPv4Address != "10.0.0.2/24" || ev.IPv6Address != "" || ev.SandboxID != "" {
+		t.Fatalf("unexpected event %+v", ev)
+	}
+
+	if err := c.runEndpointHooks(context.Background(), ephook.PhaseLeave, n, ep, nil); err != nil {
+		t.Fatalf("a failure on a non blocking phase returned: %v", err)
+	}
+
+	n.labels = nil
+	events = nil
+	if err := c.runEndpointHooks(context.Background(), ephook.PhaseCreate, n, ep, nil); err != nil || len(events) != 0 {
+		t.Fatalf("hooks run on a network without hooks: %v", err)
+	}
+}
+
+func TestOpTimingBreakdown(t *testing.T) {
+	var nilTiming *opTiming
+	nilTiming.TimeStep("driver")()
+	nilTiming.end()
+	if driverapi.TimeStep(nilTiming.context(context.Background()), "driver") == nil {
+		t.Fatal("no step end returned for an untimed operation")
+	}
+
+	ot := &opTiming{op: opJoin, id: "ep1", budget: time.Millisecond, start: time.Now()}
+	ctx := ot.context(context.Background())
+	ot.add("driver", 2*time.Millisecond)
+	driverapi.TimeStep(ctx, "bridge/veth")()
+	ot.add("driver", 3*time.Millisecond)
+	if len(ot.steps) != 2 || ot.steps[0].name != "driver" || ot.steps[0].d != 5*time.Millisecond || ot.steps[1].name != "bridge/veth" {
+		t.Fatalf("unexpected steps %v", ot.steps)
+	}
+
+	got := ot.breakdown(ot.steps[0].d + ot.steps[1].d + time.Millisecond)
+	if !strings.HasPrefix(got, "driver=5ms bridge/veth=") || !strings.HasSuffix(got, " other=1ms") {
+		t.Fatalf("unexpected breakdown %q", got)
+	}
+}
+
+func TestOpTimingSpans(t *testing.T) {
+	e, err := otlp.New(otlp.Config{Endpoint: "http://127.0.0.1:1", SampleRatio: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Stop()
+	c := &controller{cfg: &config.Config{}, otlpExporter: e}
+
+	ot := c.startOpTiming(opJoin, "ep1")
+	if ot == nil || !ot.sampled {
+		t.Fatal("operation not timed with an exporter")
+	}
+	driverapi.TimeStep(ot.context(context.Background()), "bridge/veth")()
+	ot.TimeStep("driver")()
+	if len(ot.spans) != 2 || ot.spans[0].Name != "join/bridge/veth" || ot.spans[1].Name != "join/driver" {
+		t.Fatalf("unexpected spans %+v", ot.spans)
+	}
+	for _, s := range ot.spans {
+		if s.TraceID != ot.trace || s.ParentID != ot.span || s.End.Before(s.Start) {
+			t.Fatalf("unexpected span %+v", s)
+		}
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	var got *authz.Request
+	c := &controller{cfg: &config.Config{}}
+	n := &network{name: "red-net", id: "abc", networkType: "bridge", ctrlr: c,
+		labels:       map[string]string{netlabel.Tenant: "red"},
+		ipamV4Config: []*IpamConf{{PreferredPool: "10.10.0.0/16"}}}
+
+	if err := c.authorize(n.authzRequest(authz.NetworkCreate)); err != nil {
+		t.Fatalf("operation denied with no authorizer: %v", err)
+	}
+
+	c.cfg.Daemon.Authorizer = authz.Func(func(req *authz.Request) error {
+		got = req
+		if req.Tenant != "blue" {
+			return fmt.Errorf("not a blue network")
+		}
+		return nil
+	})
+	err := c.authorize(n.authzRequest(authz.NetworkDelete))
+	if _, ok := err.(types.ForbiddenError); !ok {
+		t.Fatalf("expected a forbidden error, got %v", err)
+	}
+	if got.Operation != authz.NetworkDelete || got.Tenant != "red" || got.NetworkName != "red-net" ||
+		len(got.Subnets) != 1 || got.Subnets[0] != "10.10.0.0/16" {
+		t.Fatalf("unexpected request %+v", got)
+	}
+	if s := got.String(); s != "network-delete network:red-net tenant:red" {
+		t.Fatalf("unexpected request string %q", s)
+	}
+
+	n.labels[netlabel.Tenant] = "blue"
+	if err := c.authorize(n.authzRequest(authz.NetworkDelete)); err != nil {
+		t.Fatalf("operation denied: %v", err)
+	}
+}
+
+func TestDriverOpLimit(t *testing.T) {
+	c := &controller{cfg: &config.Config{}}
+	release, err := c.acquireDriverOp(context.Background(), opJoin)
+	if err != nil {
+		t.Fatalf("unlimited operation throttled: %v", err)
+	}
+	release()
+
+	config.OptionDriverOpLimit(opJoin, config.DriverOpLimit{Concurrency: 1})(c.cfg)
+	release, err = c.acquireDriverOp(context.Background(), opJoin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.acquireDriverOp(context.Background(), opEndpointCreate); err != nil {
+		t.Fatalf("operation throttled by the limit of another: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := c.acquireDriverOp(ctx, opJoin); err == nil {
+		t.Fatal("call started over the concurrency cap")
+	} else if _, ok := err.(types.TimeoutError); !ok {
+		t.Fatalf("expected a timeout error, got %v", err)
+	}
+	release()
+	release, err = c.acquireDriverOp(context.Background(), opJoin)
+	if err != nil {
+		t.Fatalf("call throttled once released: %v", err)
+	}
+	release()
+
+	config.OptionDriverOpLimit(opJoin, config.DriverOpLimit{Rate: 1, Burst: 2})(c.cfg)
+	for i := 0; i < 2; i++ {
+		if _, err := c.acquireDriverOp(context.Background(), opJoin); err != nil {
+			t.Fatalf("call %d of the burst throttled: %v", i, err)
+		}
+	}
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := c.acquireDriverOp(ctx, opJoin); err == nil {
+		t.Fatal("call started over the rate")
+	}
+	if ol := c.opLimiter.limits[opJoin]; ol.tokens < -0.1 {
+		t.Fatalf("token of the cancelled call not given back: %v", ol.tokens)
+	}
+}
+
+func TestWriteBehindEndpoints(t *testing.T) {
+	c := &controller{cfg: &config.Config{}}
+	defer c.stopWriteBehind()
+	n := &network{name: "wb", id: "wbnet", networkType: "overlay", scope: datastore.GlobalScope, ctrlr: c,
+		labels: map[string]string{netlabel.StoreWriteBehind: "true"}}
+	ep1 := &endpoint{name: "ep1", id: "ep1", network: n, generic: map[string]interface{}{}}
+	ep2 := &endpoint{name: "ep2", id: "ep2", network: n, generic: map[string]interface{}{}}
+
+	for _, ep := range []*endpoint{ep1, ep2} {
+		if err := c.updateToStore(ep); err != nil {
+			t.Fatalf("endpoint not committed locally: %v", err)
+		}
+	}
+	ep, err := n.getEndpointFromStore("ep1")
+	if err != nil || ep.Name() != "ep1" || ep == ep1 {
+		t.Fatalf("expected a copy of the endpoint committed locally, got %v %v", ep, err)
+	}
+	if epl := c.writeBehindEndpoints(n, nil); len(epl) != 2 {
+		t.Fatalf("expected the 2 endpoints committed locally, got %d", len(epl))
+	}
+
+	if err := c.deleteFromStore(ep1); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := c.writeBehind.pending[datastore.Key(ep1.Key()...)]; ok {
+		t.Fatal("endpoint never synced kept to be deleted from the store")
+	}
+
+	ep2.SetIndex(3)
+	if err := c.deleteFromStore(ep2); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := n.getEndpointFromStore("ep2"); err == nil {
+		t.Fatal("deleted endpoint found")
+	}
+
+	n.labels[netlabel.StoreWriteBehind] = "false"
+	if c.writeBehindEndpoint(ep1, false) {
+		t.Fatal("endpoint committed locally on a network not write-behind")
+	}
+}
+
+type collidingIpam struct {
+	ipamapi.Ipam
+	used map[string]bool
+}
+
+func (i *collidingIpam) RequestAddress(poolID string, ip net.IP, opts map[string]string) (*net.IPNet, map[string]string, error) {
+	if i.used[ip.String()] {
+		return nil, nil, ipamapi.ErrIPAlreadyAllocated
+	}
+	i.used[ip.String()] = true
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(64, 128)}, nil, nil
+}
+
+func TestDerivedIPv6Addresses(t *testing.T) {
+	_, prefix, _ := net.ParseCIDR("2001:db8:1::/64")
+	mac, _ := net.ParseMAC("02:42:ac:11:00:02")
+	if ip := eui64Address(prefix, mac); !ip.Equal(net.ParseIP("2001:db8:1::42:acff:fe11:2")) {
+		t.Fatalf("unexpected EUI-64 address %s", ip)
+	}
+
+	secret := []byte("secret")
+	ip := stablePrivacyAddress(prefix, "web", "net1", 0, secret)
+	if ip == nil || !prefix.Contains(ip) {
+		t.Fatalf("unexpected stable-privacy address %s", ip)
+	}
+	if !ip.Equal(stablePrivacyAddress(prefix, "web", "net1", 0, secret)) {
+		t.Fatal("stable-privacy address not stable")
+	}
+	for _, other := range []net.IP{
+		stablePrivacyAddress(prefix, "db", "net1", 0, secret),
+		stablePrivacyAddress(prefix, "web", "net2", 0, secret),
+		stablePrivacyAddress(prefix, "web", "net1", 1, secret),
+		stablePrivacyAddress(prefix, "web", "net1", 0, []byte("other")),
+	} {
+		if other.Equal(ip) {
+			t.Fatalf("same stable-privacy address %s for different inputs", ip)
+		}
+	}
+	for _, iid := range []string{"::", "::fdff:ffff:ffff:ff80", "::200:5eff:fe00:5212", "::ffff:ffff:ffff:ffff"} {
+		if !reservedInterfaceID(net.ParseIP(iid)[8:]) {
+			t.Fatalf("interface identifier %s not reserved", iid)
+		}
+	}
+	if reservedInterfaceID(net.ParseIP("::200:5eff:fe00:5213")[8:]) {
+		t.Fatal("unreserved interface identifier reported reserved")
+	}
+
+	c := &controller{cfg: &config.Config{}}
+	config.OptionIPv6StableSecret("secret")(c.cfg)
+	n := &network{id: "net1", name: "net1", ctrlr: c, enableIPv6: true,
+		labels: map[string]string{netlabel.IPv6AddressMode: "stable-privacy"}}
+	if err := n.validateIPv6AddressMode(); err != nil {
+		t.Fatal(err)
+	}
+	ipInfo := []*IpamInfo{{PoolID: "pool1", IPAMData: driverapi.IPAMData{Pool: prefix}}}
+	ipam := &collidingIpam{used: map[string]bool{ip.String(): true}}
+	ep := &endpoint{name: "web", network: n, iface: &endpointInterface{}}
+	if err := ep.assignDerivedAddressV6(ipam, ipInfo, ipv6AddressModeStablePrivacy, nil); err != nil {
+		t.Fatal(err)
+	}
+	if next := stablePrivacyAddress(prefix, "web", "net1", 1, secret); !ep.iface.addrv6.IP.Equal(next) {
+		t.Fatalf("expected the next stable-privacy address %s on collision, got %s", next, ep.iface.addrv6.IP)
+	}
+
+	ep = &endpoint{name: "db", network: n, iface: &endpointInterface{addr: &net.IPNet{IP: net.ParseIP("172.17.0.2")}}}
+	if err := ep.assignDerivedAddressV6(ipam, ipInfo, ipv6AddressModeSLAAC, nil); err != nil {
+		t.Fatal(err)
+	}
+	if !ep.iface.addrv6.IP.Equal(net.ParseIP("2001:db8:1::42:acff:fe11:2")) || ep.iface.mac.String() != mac.String() {
+		t.Fatalf("unexpected SLAAC address %s for MAC %s", ep.iface.addrv6.IP, ep.iface.mac)
+	}
+	if err := ep.assignDerivedAddressV6(ipam, ipInfo, ipv6AddressModeSLAAC, nil); err == nil {
+		t.Fatal("SLAAC address assigned twice")
+	}
+
+	_, prefix48, _ := net.ParseCIDR("2001:db8::/48")
+	if err := ep.assignDerivedAddressV6(ipam, []*IpamInfo{{PoolID: "pool2", IPAMData: driverapi.IPAMData{Pool: prefix48}}}, ipv6AddressModeSLAAC, nil); err == nil {
+		t.Fatal("derived address assigned out of a /48")
+	}
+
+	n.labels[netlabel.IPv6AddressMode] = "dhcp"
+	if err := n.validateIPv6AddressMode(); err == nil {
+		t.Fatal("invalid address mode accepted")
+	}
+}
+
+func TestTCPMSSClamp(t *testing.T) {
+	for v, expected := range map[string][]string{
+		"pmtu": {"--clamp-mss-to-pmtu"},
+		"1360": {"--set-mss", "1360"},
+	} {
+		args, err := parseTCPMSS(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Join(args, " ") != strings.Join(expected, " ") {
+			t.Fatalf("unexpected arguments %v for %s", args, v)
+		}
+	}
+	for _, v := range []string{"", "100", "65536", "auto"} {
+		if _, err := parseTCPMSS(v); err == nil {
+			t.Fatalf("invalid MSS %q accepted", v)
+		}
+	}
+
+	n := &network{labels: map[string]string{netlabel.TCPMSS: "1360"}}
+	if err := n.validateTCPMSS(); err != nil {
+		t.Fatal(err)
+	}
+	rules := mssClampRules("eth0", n.tcpMSSArgs())
+	if len(rules) != 2 ||
+		strings.Join(rules[0], " ") != "POSTROUTING -o eth0 -p tcp --tcp-flags SYN,RST SYN -j TCPMSS --set-mss 1360" ||
+		strings.Join(rules[1], " ") != "PREROUTING -i eth0 -p tcp --tcp-flags SYN,RST SYN -j TCPMSS --set-mss 1360" {
+		t.Fatalf("unexpected rules %v", rules)
+	}
+
+	n.labels[netlabel.TCPMSS] = "jumbo"
+	if err := n.validateTCPMSS(); err == nil {
+		t.Fatal("invalid MSS accepted")
+	}
+}
+
+func TestTCPKeepalive(t *testing.T) {
+	sysctls, err := parseTCPKeepalive("time=600, intvl=30,probes=5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sysctls) != 3 || sysctls["net.ipv4.tcp_keepalive_time"] != "600" ||
+		sysctls["net.ipv4.tcp_keepalive_intvl"] != "30" || sysctls["net.ipv4.tcp_keepalive_probes"] != "5" {
+		t.Fatalf("unexpected sysctls %v", sysctls)
+	}
+	for _, v := range []string{"time", "time=0", "probes=128", "idle=60", "time=60,time=70", "intvl=x"} {
+		if _, err := parseTCPKeepalive(v); err == nil {
+			t.Fatalf("invalid keepalive %q accepted", v)
+		}
+	}
+
+	n := &network{labels: map[string]string{netlabel.TCPKeepalive: "time=600,probes=5"}}
+	if err := n.validateTCPKeepalive(); err != nil {
+		t.Fatal(err)
+	}
+	other := &network{labels: map[string]string{netlabel.TCPKeepalive: "time=300,intvl=10"}}
+	ep1 := &endpoint{network: n, generic: map[string]interface{}{netlabel.TCPKeepalive: "probes=9"}}
+	ep2 := &endpoint{network: other}
+	sb := &sandbox{endpoints: []*endpoint{ep1, ep2}}
+	sysctls, err = sb.tcpKeepalive()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the endpoint of highest priority wins, the other completing it
+	if len(sysctls) != 3 || sysctls["net.ipv4.tcp_keepalive_time"] != "600" ||
+		sysctls["net.ipv4.tcp_keepalive_probes"] != "9" || sysctls["net.ipv4.tcp_keepalive_intvl"] != "10" {
+		t.Fatalf("unexpected sysctls %v", sysctls)
+	}
+
+	n.labels[netlabel.TCPKeepalive] = "time=forever"
+	if err := n.validateTCPKeepalive(); err == nil {
+		t.Fatal("invalid keepalive accepted")
+	}
+}
+
+func TestParseInetDiagMsg(t *testing.T) {
+	b := make([]byte, sizeofInetDiagMsg+4+minTCPInfo)
+	b[0], b[1] = 2, tcpEstablished
+	binary.BigEndian.PutUint16(b[4:], 8080)
+	binary.BigEndian.PutUint16(b[6:], 41000)
+	copy(b[8:], net.ParseIP("172.20.0.2").To4())
+	copy(b[24:], net.ParseIP("172.20.0.3").To4())
+	binary.LittleEndian.PutUint32(b[56:], 7)
+	binary.LittleEndian.PutUint32(b[60:], 1400)
+	attr := b[sizeofInetDiagMsg:]
+	binary.LittleEndian.PutUint16(attr[0:], uint16(4+minTCPInfo))
+	binary.LittleEndian.PutUint16(attr[2:], inetDiagInfo)
+	info := attr[4:]
+	info[2] = 1
+	binary.LittleEndian.PutUint32(info[68:], 1500)
+	binary.LittleEndian.PutUint32(info[80:], 10)
+	binary.LittleEndian.PutUint32(info[100:], 42)
+
+	s, err := parseInetDiagMsg(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.local.String() != "172.20.0.2:8080" || s.remote.String() != "172.20.0.3:41000" {
+		t.Fatalf("unexpected addresses %s %s", &s.local, &s.remote)
+	}
+	if s.rqueue != 7 || s.wqueue != 1400 || !s.hasInfo || s.retransmits != 1 ||
+		s.rtt != 1500*time.Microsecond || s.cwnd != 10 || s.totalRetrans != 42 {
+		t.Fatalf("unexpected socket %+v", s)
+	}
+
+	if _, err := parseInetDiagMsg(b[:sizeofInetDiagMsg-1]); err == nil {
+		t.Fatal("expected an error parsing a short message")
+	}
+	binary.LittleEndian.PutUint16(attr[0:], 2)
+	if _, err := parseInetDiagMsg(b); err == nil {
+		t.Fatal("expected an error parsing an invalid attribute")
+	}
+}
+
+func TestSummarizeSockets(t *testing.T) {
+	addr := func(s string) net.TCPAddr {
+		a, _ := net.ResolveTCPAddr("tcp", s)
+		return *a
+	}
+	socks := []*tcpSocket{
+		{state: tcpListen, local: addr("0.0.0.0:80"), rqueue: 3, wqueue: 128},
+		{state: tcpListen, local: addr("[::]:22"), wqueue: 128},
+		{state: tcpEstablished, local: addr("10.0.0.2:80"), remote: addr("10.0.0.9:5000"), totalRetrans: 2},
+		{state: tcpEstablished, local: addr("10.0.0.2:80"), remote: addr("10.0.0.9:5001"), wqueue: 900},
+		{state: tcpEstablished, local: addr("10.0.0.2:80"), remote: addr("10.0.0.9:5002"), totalRetrans: 9, hasInfo: true, rtt: time.Millisecond},
+		{state: 6, local: addr("10.0.0.2:80"), remote: addr("10.0.0.9:5003")},
+	}
+	sum := summarizeSockets("sb", socks, 2)
+	if sum.Sockets != 6 || sum.States["ESTAB"] != 3 || sum.States["LISTEN"] != 2 || sum.States["TIME-WAIT"] != 1 {
+		t.Fatalf("unexpected states %v", sum.States)
+	}
+	if sum.Retransmits != 11 || sum.Backlogged != 1 || len(sum.Listeners) != 2 || sum.Listeners[0].Local != "0.0.0.0:80" {
+		t.Fatalf("unexpected summary %+v", sum)
+	}
+	if len(sum.Top) != 2 || sum.Top[0].Remote != "10.0.0.9:5002" || sum.Top[0].RTT != "1ms" || sum.Top[1].Remote != "10.0.0.9:5000" {
+		t.Fatalf("unexpected top sockets %+v", sum.Top)
+	}
+}
+
+func TestExternalEndpointResolution(t *testing.T) {
+	c := &controller{svcRecords: map[string]svcInfo{}}
+	n := &network{id: "n1", name: "net1", ctrlr: c}
+	if err := (&ExternalEndpoint{Name: "db", Address: net.ParseIP("fe80::1")}).validate(false); err == nil {
+		t.Fatal("expected an error for an external endpoint without an IPv4 address")
+	}
+	ee := &ExternalEndpoint{Name: "db", NetworkID: "n1", Address: net.ParseIP("10.1.2.3"), Labels: map[string]string{"role": "database"}}
+	if err := ee.validate(false); err != nil {
+		t.Fatal(err)
+	}
+	if err := ee.validate(true); err == nil {
+		t.Fatal("expected an error for an IPv4 external endpoint on an IPv6 only network")
+	}
+	c.addExternalEndpoint(ee)
+
+	if ips, _ := n.ResolveName("db.", types.IPv4); len(ips) != 1 || !ips[0].Equal(ee.Address) {
+		t.Fatalf("unexpected addresses %v of the external endpoint", ips)
+	}
+	if ips, ipv6Miss := n.ResolveName("db", types.IPv6); ips != nil || !ipv6Miss {
+		t.Fatalf("expected an IPv6 miss, got %v", ips)
+	}
+	if name := n.ResolveIP(netutils.ReverseIP("10.1.2.3")); name != "db.net1" {
+		t.Fatalf("unexpected name %q of the external endpoint address", name)
+	}
+
+	p := &driverapi.FilterPolicy{Version: "v1", Allow: []driverapi.FilterRule{{Proto: "tcp", Ports: "5432", Peer: "db"}, {Proto: "tcp", Ports: "80"}}}
+	resolved, err := c.resolveFilterPeers(n, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if from := resolved.Allow[0].From; from == nil || from.String() != "10.1.2.3/32" {
+		t.Fatalf("unexpected source %v of the peer rule", from)
+	}
+	if p.Allow[0].From != nil || resolved.Allow[1].From != nil {
+		t.Fatal("expected the policy to be left alone and the other rules unresolved")
+	}
+	p.Allow[0].Peer = "cache"
+	if _, err := c.resolveFilterPeers(n, p); err == nil {
+		t.Fatal("expected an error for an unknown peer")
+	}
+
+	ee6 := &ExternalEndpoint{Name: "db6", NetworkID: "n1", AddressIPv6: net.ParseIP("2001:db8::5")}
+	if err := ee6.validate(true); err != nil {
+		t.Fatal(err)
+	}
+	if err := ee6.validate(false); err == nil {
+		t.Fatal("expected an error for an external endpoint without an IPv4 address")
+	}
+	c.addExternalEndpoint(ee6)
+	if ips, ipv6Miss := n.ResolveName("db6", types.IPv4); ips != nil || !ipv6Miss {
+		t.Fatalf("unexpected IPv4 addresses %v of the IPv6 only external endpoint", ips)
+	}
+	p.Allow[0].Peer = "db6"
+	resolved, err = c.resolveFilterPeers(n, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if from := resolved.Allow[0].From; from == nil || from.String() != "2001:db8::5/128" {
+		t.Fatalf("unexpected source %v of the IPv6 peer rule", from)
+	}
+}
+
+func TestFlowExport(t *testing.T) {
+	for labels, valid := range map[string]bool{
+		"":                    true,
+		"collector:4739":      true,
+		"collector:4739/10":   true,
+		"collector":           false,
+		"collector:0":         false,
+		":4739":               false,
+		"collector:4739/zero": false,
+	} {
+		l := map[string]string{}
+		if labels != "" {
+			parts := strings.SplitN(labels, "/", 2)
+			l[netlabel.FlowExport] = parts[0]
+			if len(parts) == 2 {
+				l[netlabel.FlowExportSampling] = parts[1]
+			}
+		}
+		if _, _, err := parseFlowExport(l); (err == nil) != valid {
+			t.Errorf("unexpected validation of %q: %v", labels, err)
+		}
+	}
+
+	endpoints := map[string]string{"172.17.0.2": "ep1"}
+	flows := []trackedFlow{
+		{Src: net.ParseIP("172.17.0.2"), Dst: net.ParseIP("8.8.8.8"), Proto: 17, SrcPort: 40000, DstPort: 53},
+		{Src: net.ParseIP("10.0.0.1"), Dst: net.ParseIP("10.0.0.2"), Proto: 6, SrcPort: 40000, DstPort: 80},
+	}
+	e := &flowExport{sampling: 1, seen: map[string]bool{}}
+	records := e.newRecords("n1", endpoints, flows, time.Now())
+	if len(records) != 1 || records[0].SrcEndpoint != "ep1" || records[0].DstEndpoint != "" || records[0].NetworkID != "n1" {
+		t.Fatalf("unexpected records %+v", records)
+	}
+	// The flows are exported once
+	if records := e.newRecords("n1", endpoints, flows, time.Now()); len(records) != 0 {
+		t.Fatalf("unexpected records of the flows seen already %+v", records)
+	}
+}
+
+type recordSink struct{ events []events.Event }
+
+func (s *recordSink) Write(ev events.Event) error {
+	s.events = append(s.events, ev)
+	return nil
+}
+
+func (s *recordSink) Close() error { return nil }
+
+func TestConntrackEvents(t *testing.T) {
+	attr := func(typ uint16, value []byte) []byte {
+		b := make([]byte, 4, 4+len(value)+3)
+		binary.LittleEndian.PutUint16(b, uint16(4+len(value)))
+		binary.LittleEndian.PutUint16(b[2:], typ)
+		b = append(b, value...)
+		for len(b)%4 != 0 {
+			b = append(b, 0)
+		}
+		return b
+	}
+	port := func(p uint16) []byte { return []byte{byte(p >> 8), byte(p)} }
+	tuple := func(typ uint16, src, dst string, sport, dport uint16) []byte {
+		ip := append(attr(ctaIPV4Src, net.ParseIP(src).To4()), attr(ctaIPV4Dst, net.ParseIP(dst).To4())...)
+		proto := append(append(attr(ctaProtoNum, []byte{6}), attr(ctaProtoSrc, port(sport))...), attr(ctaProtoDst, port(dport))...)
+		return attr(typ|1<<15, append(attr(ctaTupleIP|1<<15, ip), attr(ctaTupleProto|1<<15, proto)...))
+	}
+	// The connection of the endpoint to a published port NATed to 10.0.0.5
+	data := append([]byte{2, 0, 0, 0}, tuple(ctaTupleOrig, "172.17.0.2", "10.0.0.1", 40000, 8080)...)
+	data = append(data, tuple(ctaTupleReply, "10.0.0.5", "172.17.0.2", 80, 40000)...)
+
+	typ, f, ok := parseConntrackMessage(conntrackMessage{typ: ctnlMsgNew, data: data})
+	if !ok || typ != ConntrackNew {
+		t.Fatalf("unexpected parse of the new entry: %s, %v", typ, ok)
+	}
+	if !f.Src.Equal(net.ParseIP("172.17.0.2")) || !f.Dst.Equal(net.ParseIP("10.0.0.5")) || f.Proto != 6 || f.SrcPort != 40000 || f.DstPort != 80 {
+		t.Fatalf("unexpected flow %+v", f)
+	}
+	if _, _, ok := parseConntrackMessage(conntrackMessage{typ: 1<<8 | 1, data: data}); ok {
+		t.Fatal("expected the get messages to be ignored")
+	}
+
+	now := time.Now()
+	sink := &recordSink{}
+	other := &recordSink{}
+	c := &controller{}
+	c.conntrackWatch.watchers = map[*conntrackWatcher]struct{}{
+		{nid: "n1", eid: "ep1", ips: map[string]bool{"172.17.0.2": true}, limiter: newEventLimiter(1, now), sink: sink}:  {},
+		{nid: "n1", eid: "ep2", ips: map[string]bool{"172.17.0.3": true}, limiter: newEventLimiter(1, now), sink: other}: {},
+	}
+	for i := 0; i < 3; i++ {
+		c.dispatchConntrackEvent(conntrackMessage{typ: ctnlMsgDelete, data: data}, now)
+	}
+	c.dispatchConntrackEvent(conntrackMessage{typ: ctnlMsgDelete, data: data}, now.Add(time.Second))
+	if len(other.events) != 0 {
+		t.Fatalf("unexpected events of another endpoint %v", other.events)
+	}
+	if len(sink.events) != 2 {
+		t.Fatalf("expected the events over the rate to be dropped, got %v", sink.events)
+	}
+	if ev := sink.events[1].(ConntrackEvent); ev.Type != ConntrackDestroy || ev.EndpointID != "ep1" || ev.Dropped != 2 {
+		t.Fatalf("unexpected event %+v", ev)
+	}
+}
+
+func TestStableMAC(t *testing.T) {
+	cfgOptions, err := OptionBoltdbWithRandomDBFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := New(cfgOptions...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+	cc := c.(*controller)
+	n := &network{id: "n1", name: "net1", ctrlr: cc, scope: datastore.LocalScope}
+
+	mac, err := cc.assignStableMAC(n, "db-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(mac, netutils.GenerateMACFromKey("db-1", 0)) {
+		t.Fatalf("unexpected MAC address %s", mac)
+	}
+	if again, err := cc.assignStableMAC(n, "db-1"); err != nil || !bytes.Equal(again, mac) {
+		t.Fatalf("key got another MAC address %s: %v", again, err)
+	}
+
+	// A conflicting address moves the key to its next attempt
+	r := &macAssignmentRecord{NetworkID: "n1", StableKey: "db-2", MAC: netutils.GenerateMACFromKey("db-3", 0).String(), scope: datastore.LocalScope}
+	if err := cc.updateToStore(r); err != nil {
+		t.Fatal(err)
+	}
+	if mac, err := cc.assignStableMAC(n, "db-3"); err != nil || !bytes.Equal(mac, netutils.GenerateMACFromKey("db-3", 1)) {
+		t.Fatalf("unexpected MAC address %s of the conflicting key: %v", mac, err)
+	}
+
+	cc.forgetMACAssignments(n)
+	kvol, _ := cc.getStore(datastore.LocalScope).List(datastore.Key(r.KeyPrefix()...), r)
+	if len(kvol) != 0 {
+		t.Fatalf("%d MAC address assignments left", len(kvol))
+	}
+}
+
+func TestNetworkDeletionProtection(t *testing.T) {
+	cfgOptions, err := OptionBoltdbWithRandomDBFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var allowUnprotect bool
+	cfgOptions = append(cfgOptions, config.OptionAuthorizer(authz.Func(func(req *authz.Request) error {
+		if req.Operation == authz.NetworkUnprotect && !allowUnprotect {
+			return fmt.Errorf("not an operator")
+		}
+		return nil
+	})))
+	c, err := New(cfgOptions...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+	addDeletableDriver(t, c)
+
+	if _, err := c.NewNetwork(deletableDriverName, "protected", "", NetworkOptionLabels(map[string]string{netlabel.DeletionProtection: "yes"})); err == nil {
+		t.Fatal("expected an error for an invalid protection label")
+	}
+	nw, err := c.NewNetwork(deletableDriverName, "protected", "", NetworkOptionLabels(map[string]string{netlabel.DeletionProtection: "true"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := nw.Delete(); err == nil {
+		t.Fatal("protected network deleted")
+	} else if _, ok := err.(types.ForbiddenError); !ok {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if err := nw.SetDeletionProtection(false); err == nil {
+		t.Fatal("unauthorized protection lift")
+	}
+	allowUnprotect = true
+	if err := nw.SetDeletionProtection(false); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := nw.AddFinalizer("lb-pool"); err != nil {
+		t.Fatal(err)
+	}
+	if err := nw.Delete(); err == nil {
+		t.Fatal("network with a finalizer deleted")
+	}
+	if stored, err := c.NetworkByID(nw.ID()); err != nil || stored.Info().DeletionProtected() || len(stored.Info().Finalizers()) != 1 {
+		t.Fatalf("unexpected protection of the stored network: %v", err)
+	}
+	if err := nw.RemoveFinalizer("lb-pool"); err != nil {
+		t.Fatal(err)
+	}
+	if err := nw.Delete(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestJournalReplayOptions(t *testing.T) {
+	_, pool, _ := net.ParseCIDR("10.10.0.0/24")
+	gw, _ := types.ParseCIDR("10.10.0.1/24")
+	info := []*IpamInfo{{IPAMData: driverapi.IPAMData{Pool: pool, Gateway: gw}}}
+	cfg := replayIpamConfig(nil, info)
+	if len(cfg) != 1 || cfg[0].PreferredPool != "10.10.0.0/24" || cfg[0].Gateway != "10.10.0.1" {
+		t.Fatalf("unexpected replayed configuration %+v", cfg)
+	}
+	given := []*IpamConf{{PreferredPool: "10.20.0.0/16", SubPool: "10.20.1.0/24"}}
+	if cfg := replayIpamConfig(given, info); cfg[0] != given[0] {
+		t.Fatalf("configured pool replaced %+v", cfg[0])
+	}
+
+	src := &network{name: "net1", configOnly: true, scope: datastore.LocalScope, labels: map[string]string{"a": "b"}, ipamV4Info: info}
+	n := &network{generic: map[string]interface{}{}}
+	networkOptionReplay(src)(n)
+	if !n.configOnly || n.scope != "" || n.labels["a"] != "b" || len(n.ipamV4Config) != 1 {
+		t.Fatalf("unexpected replayed configuration network %+v", n)
+	}
+	if err := n.validateConfiguration(); err != nil {
+		t.Fatal(err)
+	}
+
+	mac, _ := net.ParseMAC("02:42:0a:0a:00:02")
+	addr, _ := types.ParseCIDR("10.10.0.2/24")
+	srcEp := &endpoint{id: "e1", name: "ep1", iface: &endpointInterface{addr: addr, mac: mac}, myAliases: []string{"db"}}
+	ep := &endpoint{generic: map[string]interface{}{}, iface: &endpointInterface{}}
+	endpointOptionReplay(srcEp)(ep)
+	if ep.id != "e1" || !ep.prefAddress.Equal(addr.IP) || ep.iface.mac.String() != mac.String() || len(ep.myAliases) != 1 {
+		t.Fatalf("unexpected replayed endpoint %+v", ep)
+	}
+}
+
+func TestPartitionSignals(t *testing.T) {
+	if r := (partitionSignals{peers: 4, stale: 1, cluster: 4, failed: 1}).reasons(0.5); len(r) != 0 {
+		t.Fatalf("partition suspected from isolated failures: %v", r)
+	}
+	if r := (partitionSignals{peers: 4, stale: 2}).reasons(0.5); len(r) != 1 {
+		t.Fatalf("unexpected reasons %v", r)
+	}
+	if r := (partitionSignals{storeDown: true, cluster: 3, failed: 2}).reasons(0.5); len(r) != 2 {
+		t.Fatalf("unexpected reasons %v", r)
+	}
+
+	c := &controller{eventBroadcaster: events.NewBroadcaster()}
+	defer c.eventBroadcaster.Close()
+	d := newSplitBrainDetector(config.SplitBrainPolicy{Fencing: fencingFreeze, HeartbeatInterval: time.Second})
+	c.splitBrain = d
+	now := time.Now()
+	c.updatePartition(d, []string{"global datastore unreachable"}, now)
+	if s := c.PartitionStatus(); !s.Partitioned || !s.Fenced {
+		t.Fatalf("unexpected status %+v", s)
+	}
+
+	// A removal cancels the addition held, the last update of an endpoint
+	// replacing the previous ones
+	if !c.holdServiceBinding("n1", "e1", networkdb.CreateEvent{}) || !c.holdServiceBinding("n1", "e1", networkdb.DeleteEvent{}) {
+		t.Fatal("update not held while fenced")
+	}
+	c.holdServiceBinding("n1", "e2", networkdb.DeleteEvent{})
+	c.holdServiceBinding("n1", "e2", networkdb.UpdateEvent{})
+	if s := c.PartitionStatus(); s.HeldUpdates != 1 {
+		t.Fatalf("unexpected held updates %d", s.HeldUpdates)
+	}
+
+	// The partition heals once the signals are clear for the grace
+	c.updatePartition(d, nil, now.Add(time.Second))
+	if s := c.PartitionStatus(); !s.Partitioned {
+		t.Fatal("partition healed before the grace")
+	}
+	c.updatePartition(d, nil, now.Add(4*time.Second))
+	if s := c.PartitionStatus(); s.Partitioned || s.Fenced || s.HeldUpdates != 0 {
+		t.Fatalf("unexpected status %+v", s)
+	}
+	if c.holdServiceBinding("n1", "e3", networkdb.CreateEvent{}) {
+		t.Fatal("update held while healthy")
+	}
+}
+
+func TestULASubnets(t *testing.T) {
+	p, err := generateULAPrefix("controller")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ones, _ := p.Mask.Size(); ones != 48 || p.IP[0] != 0xfd {
+		t.Fatalf("unexpected prefix %s", p)
+	}
+
+	_, prefix, _ := net.ParseCIDR("fd12:3456:789a::/48")
+	l := ulaCandidates(prefix, 64, "network1", 3)
+	if len(l) != 3 {
+		t.Fatalf("unexpected candidates %v", l)
+	}
+	first := binary.BigEndian.Uint16(l[0].IP[6:8])
+	for i, s := range l {
+		if ones, _ := s.Mask.Size(); !prefix.Contains(s.IP) || ones != 64 || binary.BigEndian.Uint16(s.IP[6:8]) != first+uint16(i) {
+			t.Fatalf("unexpected candidate %d %s", i, s)
+		}
+	}
+	if again := ulaCandidates(prefix, 64, "network1", 1); again[0].String() != l[0].String() {
+		t.Fatalf("unstable subnet %s, then %s", l[0], again[0])
+	}
+	// The candidates wrap around the prefix, each subnet tried once
+	_, small, _ := net.ParseCIDR("fd12:3456:789a:ff00::/62")
+	if l := ulaCandidates(small, 64, "network1", 10); len(l) != 4 {
+		t.Fatalf("unexpected candidates %v", l)
+	}
+
+	for _, tc := range []struct {
+		u  config.IPv6ULA
+		ok bool
+	}{
+		{config.IPv6ULA{}, true},
+		{config.IPv6ULA{SubnetSize: 56}, true},
+		{config.IPv6ULA{SubnetSize: 48}, false},
+		{config.IPv6ULA{SubnetSize: 80}, false},
+		{config.IPv6ULA{Prefix: "fd00:1::/32"}, true},
+		{config.IPv6ULA{Prefix: "2001:db8::/48"}, false},
+		{config.IPv6ULA{Prefix: "fd00:1:2:3::/64"}, false},
+	} {
+		if err := validateIPv6ULA(&tc.u); (err == nil) != tc.ok {
+			t.Fatalf("unexpected validation of %+v: %v", tc.u, err)
+		}
+	}
+}
+
+type fakeHostDiscovery struct {
+	nodes []net.IP
+}
+
+func (f *fakeHostDiscovery) Watch(hostdiscovery.ActiveCallback, hostdiscovery.JoinCallback, hostdiscovery.LeaveCallback) error {
+	return nil
+}
+
+func (f *fakeHostDiscovery) StopDiscovery() error {
+	return nil
+}
+
+func (f *fakeHostDiscovery) Fetch() []net.IP {
+	return f.nodes
+}
+
+func TestOrphanPass(t *testing.T) {
+	hosts := map[string]string{
+		"ep-local": "10.0.0.1",
+		"ep-alive": "10.0.0.2",
+		"ep-gone":  "10.0.0.3",
+		"ep-none":  "",
+	}
+	nodes := []net.IP{net.ParseIP("10.0.0.1"), net.ParseIP("10.0.0.2")}
+
+	// A suspect survives its first pass
+	suspects, reclaim := orphanPass(map[string]bool{}, hosts, nodes, "10.0.0.1")
+	if len(suspects) != 1 || !suspects["ep-gone"] || len(reclaim) != 0 {
+		t.Fatalf("unexpected first pass: suspects %v, reclaimed %v", suspects, reclaim)
+	}
+
+	// and is reclaimed on the second one
+	next, reclaim := orphanPass(suspects, hosts, nodes, "10.0.0.1")
+	if len(reclaim) != 1 || reclaim[0] != "ep-gone" || len(next) != 0 {
+		t.Fatalf("unexpected second pass: suspects %v, reclaimed %v", next, reclaim)
+	}
+
+	// A host coming back between the passes clears its endpoints
+	nodes = append(nodes, net.ParseIP("10.0.0.3"))
+	next, reclaim = orphanPass(suspects, hosts, nodes, "10.0.0.1")
+	if len(reclaim) != 0 || len(next) != 0 {
+		t.Fatalf("unexpected pass once the host is back: suspects %v, reclaimed %v", next, reclaim)
+	}
+}
+
+func TestCleanupOrphanEndpointsDiscovery(t *testing.T) {
+	suspects := map[string]bool{"ep-gone": true}
+
+	c := &controller{}
+	if next := c.cleanupOrphanEndpoints(suspects); next != nil {
+		t.Fatalf("pass done without discovery: %v", next)
+	}
+
+	// The discovery has not reported the hosts of the cluster yet
+	c.discovery = &fakeHostDiscovery{nodes: []net.IP{net.ParseIP("10.0.0.2")}}
+	if next := c.cleanupOrphanEndpoints(suspects); next != nil {
+		t.Fatalf("pass done before the discovery is active: %v", next)
+	}
+
+	// The discovery reports no host at all
+	c.discovery = &fakeHostDiscovery{}
+	c.activeCallback()
+	if next := c.cleanupOrphanEndpoints(suspects); next != nil {
+		t.Fatalf("pass done with an empty discovery: %v", next)
+	}
+}
+
+func TestControllerStopTwice(t *testing.T) {
+	cfgOptions, err := OptionBoltdbWithRandomDBFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfgOptions = append(cfgOptions,
+		config.OptionOrphanCleanupInterval(time.Hour),
+		config.OptionNextHopProbeInterval(time.Hour),
+		config.OptionSocketAuditInterval(time.Hour))
+	c, err := New(cfgOptions...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Stop()
+	// A second stop does not close the stop channels of the loops again
+	c.Stop()
+}
@@ -9,6 +9,7 @@ import (
 	"net"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"runtime"
 	"strconv"
 	"strings"
@@ -16,6 +17,8 @@ import (
 
 	"github.com/docker/docker/pkg/reexec"
 	"github.com/docker/libnetwork"
+	"github.com/docker/libnetwork/config"
+	"github.com/docker/libnetwork/drivers/cni"
 	"github.com/docker/libnetwork/ipamapi"
 	"github.com/docker/libnetwork/netlabel"
 	"github.com/docker/libnetwork/options"
@@ -518,6 +521,96 @@ func externalKeyTest(t *testing.T, reexec bool) {
 	checkSandbox(t, ep.Info())
 }
 
+// cniPlugin fails the ADD command run before the namespace exists
+const cniPlugin = `#!/bin/sh
+cat > /dev/null
+if [ "$CNI_COMMAND" = "ADD" ] && [ ! -e "$CNI_NETNS" ]; then
+	exit 1
+fi
+echo "$CNI_COMMAND $CNI_NETNS" >> "$(dirname "$0")/calls.log"
+`
+
+func TestCNIExternalKey(t *testing.T) {
+	if !testutils.IsRunningInContainer() {
+		defer testutils.SetupTestOSContext(t)()
+	}
+
+	dir, err := ioutil.TempDir("", "cni")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "fake"), []byte(cniPlugin), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	cfgOptions, err := libnetwork.OptionBoltdbWithRandomDBFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := libnetwork.New(append(cfgOptions, config.OptionDriverConfig("cni", map[string]interface{}{
+		cni.ConfDirLabel: dir,
+		cni.BinDirLabel:  dir,
+	}))...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+
+	n, err := c.NewNetwork("cni", "cninet", "", libnetwork.NetworkOptionGeneric(options.Generic{
+		netlabel.GenericData: map[string]string{"cni.config": `{"cniVersion":"0.4.0","name":"cninet","type":"fake"}`},
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n.Delete()
+	ep, err := n.CreateEndpoint("ep1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ep.Delete(false)
+
+	sb, err := c.NewSandbox("cni_c1", libnetwork.OptionUseExternalKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := sb.Delete(); err != nil {
+			t.Fatal(err)
+		}
+		osl.GC()
+	}()
+
+	// The namespace of the sandbox does not exist yet
+	if err := ep.Join(sb); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "calls.log")); !os.IsNotExist(err) {
+		t.Fatalf("plugins run before the sandbox key is set: %v", err)
+	}
+
+	extKey := filepath.Join(dir, "netns")
+	extOsBox, err := osl.NewSandbox(extKey, true, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer extOsBox.Destroy()
+	if err := sb.SetKey(extKey); err != nil {
+		t.Fatal(err)
+	}
+	if err := ep.Leave(sb); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := ioutil.ReadFile(filepath.Join(dir, "calls.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp := "ADD " + sb.Key() + "\nDEL " + sb.Key() + "\n"; string(b) != exp {
+		t.Fatalf("unexpected plugin calls %q, expected %q", b, exp)
+	}
+}
+
 func reexecSetKey(key string, containerID string, controllerID string) error {
 	type libcontainerState struct {
 		NamespacePaths map[string]string
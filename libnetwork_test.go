@@ -963,6 +963,26 @@ func (f *fakeSandbox) DisableService() error {
 	return nil
 }
 
+func (f *fakeSandbox) Checkpoint() (*libnetwork.SandboxCheckpoint, error) {
+	return nil, nil
+}
+
+func (f *fakeSandbox) RestoreCheckpoint(cp *libnetwork.SandboxCheckpoint) error {
+	return nil
+}
+
+func (f *fakeSandbox) GatewayEndpoint() libnetwork.Endpoint {
+	return nil
+}
+
+func (f *fakeSandbox) SetEndpointPriority(ep libnetwork.Endpoint, prio int) error {
+	return nil
+}
+
+func (f *fakeSandbox) Policy() *libnetwork.SandboxPolicy {
+	return nil
+}
+
 func TestEndpointDeleteWithActiveContainer(t *testing.T) {
 	if !testutils.IsRunningInContainer() {
 		defer testutils.SetupTestOSContext(t)()
@@ -0,0 +1,275 @@
+// Package lldp advertises the identity of the host and the prefixes of its
+// containers on the uplinks of the host through LLDP, for the switches to
+// show the container subnets behind their ports. It only sends, the LLDP
+// frames of the switches are not received.
+package lldp
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// EtherType is the EtherType of the LLDP frames
+	EtherType = 0x88cc
+	// DefaultInterval is the interval the advertisements are sent at
+	DefaultInterval = 30 * time.Second
+	// txHold is the multiplier of the interval giving the TTL of the
+	// advertisements
+	txHold = 4
+)
+
+// NearestBridge is the destination of the LLDP frames, which the 802.1D
+// bridges do not forward
+var NearestBridge = net.HardwareAddr{0x01, 0x80, 0xc2, 0x00, 0x00, 0x0e}
+
+// The TLV types
+const (
+	tlvEnd               = 0
+	tlvChassisID         = 1
+	tlvPortID            = 2
+	tlvTTL               = 3
+	tlvPortDescription   = 4
+	tlvSystemName        = 5
+	tlvSystemDescription = 6
+
+	chassisIDMAC      = 4
+	portIDIfaceName   = 5
+	maxTLVValueLength = 511
+)
+
+// Advertisement is the content of the LLDP data unit sent on an uplink
+type Advertisement struct {
+	ChassisID  net.HardwareAddr
+	PortID     string
+	TTL        time.Duration
+	SystemName string
+	Prefixes   []*net.IPNet
+}
+
+// Marshal encodes the LLDP data unit of the advertisement. The prefixes go
+// in the system description, as many as fit.
+func (a *Advertisement) Marshal() ([]byte, error) {
+	if len(a.ChassisID) != 6 {
+		return nil, fmt.Errorf("invalid chassis ID %v: a MAC address is expected", a.ChassisID)
+	}
+	if a.PortID == "" {
+		return nil, errors.New("the advertisement has no port ID")
+	}
+	ttl := a.TTL / time.Second
+	if ttl < 0 || ttl > 0xffff {
+		return nil, fmt.Errorf("invalid TTL %s", a.TTL)
+	}
+
+	var b []byte
+	b = appendTLV(b, tlvChassisID, append([]byte{chassisIDMAC}, a.ChassisID...))
+	b = appendTLV(b, tlvPortID, append([]byte{portIDIfaceName}, a.PortID...))
+	b = appendTLV(b, tlvTTL, []byte{byte(ttl >> 8), byte(ttl)})
+	b = appendTLV(b, tlvPortDescription, []byte("uplink "+a.PortID))
+	if a.SystemName != "" {
+		b = appendTLV(b, tlvSystemName, []byte(truncate(a.SystemName, maxTLVValueLength)))
+	}
+	b = appendTLV(b, tlvSystemDescription, []byte(describePrefixes(a.Prefixes)))
+	b = appendTLV(b, tlvEnd, nil)
+	return b, nil
+}
+
+func appendTLV(b []byte, typ int, value []byte) []byte {
+	var h [2]byte
+	binary.BigEndian.PutUint16(h[:], uint16(typ)<<9|uint16(len(value)))
+	return append(append(b, h[:]...), value...)
+}
+
+func truncate(s string, n int) string {
+	if len(s) > n {
+		return s[:n]
+	}
+	return s
+}
+
+// describePrefixes lists the prefixes in the system description, ending
+// with the count of the ones left out when they do not all fit
+func describePrefixes(prefixes []*net.IPNet) string {
+	const head = "libnetwork container prefixes:"
+	if len(prefixes) == 0 {
+		return head + " none"
+	}
+	desc := head
+	for i, p := range prefixes {
+		next := " " + p.String()
+		// Room is left for the count of the prefixes after this one
+		var reserve int
+		if left := len(prefixes) - i - 1; left > 0 {
+			reserve = len(fmt.Sprintf(" (+%d more)", left))
+		}
+		if len(desc)+len(next)+reserve > maxTLVValueLength {
+			return desc + fmt.Sprintf(" (+%d more)", len(prefixes)-i)
+		}
+		desc += next
+	}
+	return desc
+}
+
+// Aggregate sorts the prefixes, dropping the ones another one covers
+func Aggregate(prefixes []*net.IPNet) []*net.IPNet {
+	sorted := make([]*net.IPNet, 0, len(prefixes))
+	for _, p := range prefixes {
+		if p != nil {
+			sorted = append(sorted, &net.IPNet{IP: p.IP.Mask(p.Mask), Mask: p.Mask})
+		}
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		oi, _ := sorted[i].Mask.Size()
+		oj, _ := sorted[j].Mask.Size()
+		if oi != oj {
+			return oi < oj
+		}
+		return sorted[i].String() < sorted[j].String()
+	})
+	var out []*net.IPNet
+next:
+	for _, p := range sorted {
+		for _, o := range out {
+			if o.Contains(p.IP) && len(o.IP.To4()) == len(p.IP.To4()) {
+				continue next
+			}
+		}
+		out = append(out, p)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].String() < out[j].String() })
+	return out
+}
+
+// Frame encodes the ethernet frame of the LLDP data unit sent from the
+// source address
+func Frame(src net.HardwareAddr, pdu []byte) []byte {
+	f := make([]byte, 0, 14+len(pdu))
+	f = append(f, NearestBridge...)
+	f = append(f, src...)
+	f = append(f, byte(EtherType>>8), byte(EtherType&0xff))
+	return append(f, pdu...)
+}
+
+// Config is the configuration of the advertiser
+type Config struct {
+	// Interfaces are the uplinks the advertisements are sent on
+	Interfaces []string
+	// Interval is the interval the advertisements are sent at,
+	// DefaultInterval when not set
+	Interval time.Duration
+	// SystemName is the name of the host
+	SystemName string
+	// Prefixes returns the container prefixes of the host
+	Prefixes func() []*net.IPNet
+}
+
+// Advertiser sends the advertisements on the uplinks at the interval of
+// its configuration
+type Advertiser struct {
+	config  Config
+	stop    chan struct{}
+	done    chan struct{}
+	stopped bool
+	sync.Mutex
+	// send sends a frame on an interface, stubbed in the tests
+	send func(iface *net.Interface, frame []byte) error
+}
+
+// NewAdvertiser starts advertising on the interfaces of the configuration
+func NewAdvertiser(c Config) (*Advertiser, error) {
+	if len(c.Interfaces) == 0 {
+		return nil, errors.New("the LLDP advertiser has no interface")
+	}
+	if c.Interval < 0 {
+		return nil, fmt.Errorf("invalid LLDP interval %s", c.Interval)
+	}
+	if c.Interval == 0 {
+		c.Interval = DefaultInterval
+	}
+	if c.Interval*txHold/time.Second > 0xffff {
+		return nil, fmt.Errorf("LLDP interval %s too long", c.Interval)
+	}
+	a := &Advertiser{config: c, stop: make(chan struct{}), done: make(chan struct{}), send: sendFrame}
+	go a.run()
+	return a, nil
+}
+
+// Stop stops the advertisements, sending the shutdown advertisements for
+// the switches to forget the host
+func (a *Advertiser) Stop() {
+	a.Lock()
+	if a.stopped {
+		a.Unlock()
+		return
+	}
+	a.stopped = true
+	a.Unlock()
+	close(a.stop)
+	<-a.done
+}
+
+func (a *Advertiser) run() {
+	defer close(a.done)
+	ticker := time.NewTicker(a.config.Interval)
+	defer ticker.Stop()
+	a.advertise(a.config.Interval * txHold)
+	for {
+		select {
+		case <-ticker.C:
+			a.advertise(a.config.Interval * txHold)
+		case <-a.stop:
+			a.advertise(0)
+			return
+		}
+	}
+}
+
+// advertise sends the advertisement with the TTL on the interfaces, a zero
+// TTL telling the switches to forget the host
+func (a *Advertiser) advertise(ttl time.Duration) {
+	var prefixes []*net.IPNet
+	if a.config.Prefixes != nil {
+		prefixes = Aggregate(a.config.Prefixes())
+	}
+	var chassis net.HardwareAddr
+	for _, name := range a.config.Interfaces {
+		iface, err := net.InterfaceByName(name)
+		if err != nil {
+			logrus.Debugf("Skipping the LLDP advertisement on %s: %v", name, err)
+			continue
+		}
+		if len(iface.HardwareAddr) != 6 {
+			logrus.Debugf("Skipping the LLDP advertisement on %s: no MAC address", name)
+			continue
+		}
+		// The chassis is identified by the first uplink
+		if chassis == nil {
+			chassis = iface.HardwareAddr
+		}
+		if err := a.advertiseOn(iface, chassis, ttl, prefixes); err != nil {
+			logrus.Warnf("Failed to send the LLDP advertisement on %s: %v", name, err)
+		}
+	}
+}
+
+func (a *Advertiser) advertiseOn(iface *net.Interface, chassis net.HardwareAddr, ttl time.Duration, prefixes []*net.IPNet) error {
+	ad := &Advertisement{
+		ChassisID:  chassis,
+		PortID:     iface.Name,
+		TTL:        ttl,
+		SystemName: a.config.SystemName,
+		Prefixes:   prefixes,
+	}
+	pdu, err := ad.Marshal()
+	if err != nil {
+		return err
+	}
+	return a.send(iface, Frame(iface.HardwareAddr, pdu))
+}
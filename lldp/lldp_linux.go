@@ -0,0 +1,28 @@
+package lldp
+
+import (
+	"net"
+	"syscall"
+)
+
+func htons(v uint16) uint16 {
+	return v<<8 | v>>8
+}
+
+// sendFrame sends the ethernet frame on the interface through a packet
+// socket
+func sendFrame(iface *net.Interface, frame []byte) error {
+	fd, err := syscall.Socket(syscall.AF_PACKET, syscall.SOCK_RAW, int(htons(EtherType)))
+	if err != nil {
+		return err
+	}
+	defer syscall.Close(fd)
+
+	addr := &syscall.SockaddrLinklayer{
+		Protocol: htons(EtherType),
+		Ifindex:  iface.Index,
+		Halen:    6,
+	}
+	copy(addr.Addr[:], NearestBridge)
+	return syscall.Sendto(fd, frame, 0, addr)
+}
@@ -0,0 +1,89 @@
+package lldp
+
+import (
+	"bytes"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMarshal(t *testing.T) {
+	_, p1, _ := net.ParseCIDR("172.17.0.0/16")
+	mac, _ := net.ParseMAC("02:42:ac:11:00:02")
+	a := &Advertisement{ChassisID: mac, PortID: "eth0", TTL: 120 * time.Second, SystemName: "host1", Prefixes: []*net.IPNet{p1}}
+	pdu, err := a.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []byte{
+		0x02, 0x07, 0x04, 0x02, 0x42, 0xac, 0x11, 0x00, 0x02, // chassis ID
+		0x04, 0x05, 0x05, 'e', 't', 'h', '0', // port ID
+		0x06, 0x02, 0x00, 0x78, // TTL
+		0x08, 0x0b, 'u', 'p', 'l', 'i', 'n', 'k', ' ', 'e', 't', 'h', '0', // port description
+		0x0a, 0x05, 'h', 'o', 's', 't', '1', // system name
+	}
+	desc := "libnetwork container prefixes: 172.17.0.0/16"
+	expected = append(expected, 0x0c, byte(len(desc)))
+	expected = append(append(expected, desc...), 0x00, 0x00)
+	if !bytes.Equal(pdu, expected) {
+		t.Fatalf("unexpected LLDP data unit:\n%x\nexpected:\n%x", pdu, expected)
+	}
+
+	frame := Frame(mac, pdu)
+	if !bytes.Equal(frame[:6], NearestBridge) || !bytes.Equal(frame[6:12], mac) || frame[12] != 0x88 || frame[13] != 0xcc {
+		t.Fatalf("unexpected frame header %x", frame[:14])
+	}
+
+	if _, err := (&Advertisement{ChassisID: mac}).Marshal(); err == nil {
+		t.Fatal("expected an error for an advertisement without port ID")
+	}
+}
+
+func TestDescribePrefixes(t *testing.T) {
+	var prefixes []*net.IPNet
+	for i := 0; i < 64; i++ {
+		prefixes = append(prefixes, &net.IPNet{IP: net.IPv4(10, byte(i), 0, 0).To4(), Mask: net.CIDRMask(24, 32)})
+	}
+	desc := describePrefixes(prefixes)
+	if len(desc) > maxTLVValueLength || !strings.HasSuffix(desc, " more)") {
+		t.Fatalf("unexpected description of %d bytes: %s", len(desc), desc)
+	}
+	if desc := describePrefixes(nil); desc != "libnetwork container prefixes: none" {
+		t.Fatalf("unexpected description %q", desc)
+	}
+}
+
+func TestAggregate(t *testing.T) {
+	var prefixes []*net.IPNet
+	for _, s := range []string{"172.18.0.0/24", "172.16.0.0/12", "10.0.0.0/24", "10.0.0.0/24", "fd00::/64"} {
+		_, p, _ := net.ParseCIDR(s)
+		prefixes = append(prefixes, p)
+	}
+	var got []string
+	for _, p := range Aggregate(prefixes) {
+		got = append(got, p.String())
+	}
+	if strings.Join(got, ",") != "10.0.0.0/24,172.16.0.0/12,fd00::/64" {
+		t.Fatalf("unexpected aggregate %v", got)
+	}
+}
+
+func TestAdvertiseOn(t *testing.T) {
+	mac, _ := net.ParseMAC("02:42:ac:11:00:02")
+	var sent [][]byte
+	a := &Advertiser{
+		config: Config{SystemName: "host1"},
+		send: func(iface *net.Interface, frame []byte) error {
+			sent = append(sent, frame)
+			return nil
+		},
+	}
+	if err := a.advertiseOn(&net.Interface{Name: "eth1", HardwareAddr: mac}, mac, 0, nil); err != nil {
+		t.Fatal(err)
+	}
+	// The shutdown advertisement has a zero TTL
+	if len(sent) != 1 || !bytes.Contains(sent[0], []byte{0x06, 0x02, 0x00, 0x00}) {
+		t.Fatalf("unexpected frames %x", sent)
+	}
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+// +build !linux
+
+package lldp
+
+import (
+	"errors"
+	"net"
+)
+
+func sendFrame(iface *net.Interface, frame []byte) error {
+	return errors.New("LLDP advertisements are not supported on this platform")
+}
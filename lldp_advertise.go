@@ -0,0 +1,44 @@
+package libnetwork
+
+import (
+	"net"
+	"os"
+
+	"github.com/docker/libnetwork/datastore"
+	"github.com/docker/libnetwork/lldp"
+)
+
+// startLLDP starts advertising the host and the prefixes of its local
+// networks on the configured uplinks
+func (c *controller) startLLDP() error {
+	hostname, _ := os.Hostname()
+	a, err := lldp.NewAdvertiser(lldp.Config{
+		Interfaces: c.cfg.Daemon.LLDPUplinks,
+		Interval:   c.cfg.Daemon.LLDPInterval,
+		SystemName: hostname,
+		Prefixes:   c.lldpPrefixes,
+	})
+	if err != nil {
+		return err
+	}
+	c.lldpAdvertiser = a
+	return nil
+}
+
+// lldpPrefixes returns the pools of the local networks, the pools of the
+// multi-host networks not being behind the host only
+func (c *controller) lldpPrefixes() []*net.IPNet {
+	var prefixes []*net.IPNet
+	for _, nw := range c.Networks() {
+		n := nw.(*network)
+		if n.ConfigOnly() || n.Scope() != datastore.LocalScope {
+			continue
+		}
+		for _, d := range append(n.getIPInfo(4), n.getIPInfo(6)...) {
+			if d.Pool != nil {
+				prefixes = append(prefixes, d.Pool)
+			}
+		}
+	}
+	return prefixes
+}
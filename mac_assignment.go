@@ -0,0 +1,168 @@
+package libnetwork
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net"
+	"sync"
+
+	"github.com/docker/libnetwork/datastore"
+	"github.com/docker/libnetwork/netutils"
+	"github.com/sirupsen/logrus"
+)
+
+const macAssignmentKeyPrefix = "mac_assignment"
+
+// macAssignmentRecord is the MAC address assigned to a stable key on a
+// network. It outlives the endpoints created with the key, for the next
+// ones to get the same MAC address.
+type macAssignmentRecord struct {
+	NetworkID string `json:"network_id"`
+	StableKey string `json:"key"`
+	MAC       string `json:"mac"`
+	scope     string
+	dbIndex   uint64
+	dbExists  bool
+	sync.Mutex
+}
+
+// Key names the record after a digest of the key, which can be any string
+func (r *macAssignmentRecord) Key() []string {
+	sum := sha256.Sum256([]byte(r.StableKey))
+	return []string{macAssignmentKeyPrefix, r.NetworkID, hex.EncodeToString(sum[:])}
+}
+
+func (r *macAssignmentRecord) KeyPrefix() []string {
+	return []string{macAssignmentKeyPrefix, r.NetworkID}
+}
+
+func (r *macAssignmentRecord) Value() []byte {
+	r.Lock()
+	defer r.Unlock()
+
+	b, err := json.Marshal(r)
+	if err != nil {
+		return nil
+	}
+	return b
+}
+
+func (r *macAssignmentRecord) SetValue(value []byte) error {
+	r.Lock()
+	defer r.Unlock()
+
+	return json.Unmarshal(value, r)
+}
+
+func (r *macAssignmentRecord) Index() uint64 {
+	r.Lock()
+	defer r.Unlock()
+	return r.dbIndex
+}
+
+func (r *macAssignmentRecord) SetIndex(index uint64) {
+	r.Lock()
+	r.dbIndex = index
+	r.dbExists = true
+	r.Unlock()
+}
+
+func (r *macAssignmentRecord) Exists() bool {
+	r.Lock()
+	defer r.Unlock()
+	return r.dbExists
+}
+
+func (r *macAssignmentRecord) Skip() bool {
+	return false
+}
+
+func (r *macAssignmentRecord) New() datastore.KVObject {
+	return &macAssignmentRecord{NetworkID: r.NetworkID, scope: r.scope}
+}
+
+func (r *macAssignmentRecord) CopyTo(o datastore.KVObject) error {
+	r.Lock()
+	defer r.Unlock()
+
+	dst := o.(*macAssignmentRecord)
+	dst.NetworkID = r.NetworkID
+	dst.StableKey = r.StableKey
+	dst.MAC = r.MAC
+	dst.scope = r.scope
+	dst.dbIndex = r.dbIndex
+	dst.dbExists = r.dbExists
+
+	return nil
+}
+
+func (r *macAssignmentRecord) DataScope() string {
+	return r.scope
+}
+
+// assignStableMAC returns the MAC address assigned to the key on the
+// network, assigning it on the first use of the key. The address is
+// derived from the key, the next attempts being tried while it is the one
+// of another key of the network. Without a store the address is derived
+// from the key alone.
+func (c *controller) assignStableMAC(n *network, key string) (net.HardwareAddr, error) {
+	store := c.getStore(n.DataScope())
+	if store == nil {
+		return netutils.GenerateMACFromKey(key, 0), nil
+	}
+	for {
+		r := &macAssignmentRecord{NetworkID: n.ID(), StableKey: key, scope: n.DataScope()}
+		err := store.GetObject(datastore.Key(r.Key()...), r)
+		if err == nil {
+			return net.ParseMAC(r.MAC)
+		}
+		if err != datastore.ErrKeyNotFound {
+			return nil, err
+		}
+
+		inUse := map[string]bool{}
+		kvol, err := store.List(datastore.Key(r.KeyPrefix()...), r)
+		if err != nil && err != datastore.ErrKeyNotFound {
+			return nil, err
+		}
+		for _, kvo := range kvol {
+			inUse[kvo.(*macAssignmentRecord).MAC] = true
+		}
+		var mac net.HardwareAddr
+		for attempt := 0; ; attempt++ {
+			if mac = netutils.GenerateMACFromKey(key, attempt); !inUse[mac.String()] {
+				break
+			}
+		}
+
+		r.MAC = mac.String()
+		// The key got assigned by another host meanwhile, get its address
+		if err := c.updateToStore(r); err != datastore.ErrKeyModified {
+			if err != nil {
+				return nil, err
+			}
+			logrus.Debugf("Assigned MAC address %s to key %q on network %s", mac, key, n.Name())
+			return mac, nil
+		}
+	}
+}
+
+// forgetMACAssignments drops the MAC addresses assigned to the keys of the
+// deleted network
+func (c *controller) forgetMACAssignments(n *network) {
+	store := c.getStore(n.DataScope())
+	if store == nil {
+		return
+	}
+	tmp := &macAssignmentRecord{NetworkID: n.ID(), scope: n.DataScope()}
+	kvol, err := store.List(datastore.Key(tmp.KeyPrefix()...), tmp)
+	if err != nil {
+		return
+	}
+	for _, kvo := range kvol {
+		if err := c.deleteFromStore(kvo); err != nil {
+			logrus.Warnf("Failed to delete the MAC address assignment of key %q on network %s: %v", kvo.(*macAssignmentRecord).StableKey, n.Name(), err)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package libnetwork
+
+import (
+	"github.com/docker/libnetwork/driverapi"
+	"github.com/docker/libnetwork/types"
+	"github.com/sirupsen/logrus"
+)
+
+// MirrorEndpoint mirrors the traffic of the endpoint to the target through
+// the network driver, which has to implement driverapi.Mirrorer, or stops
+// the mirroring when the target is nil
+func (c *controller) MirrorEndpoint(networkID, endpointID string, target *driverapi.MirrorTarget) error {
+	nw, err := c.NetworkByID(networkID)
+	if err != nil {
+		return err
+	}
+	n := nw.(*network)
+	if _, err := n.EndpointByID(endpointID); err != nil {
+		return err
+	}
+
+	if target != nil {
+		if (target.Interface == "") == (target.Remote == nil) {
+			return types.BadRequestErrorf("the mirror target takes either an interface or a remote collector")
+		}
+		if target.Remote != nil {
+			switch target.Encapsulation {
+			case driverapi.MirrorVXLAN, driverapi.MirrorGRETAP:
+			default:
+				return types.BadRequestErrorf("invalid encapsulation %q of the mirrored traffic", target.Encapsulation)
+			}
+		}
+	}
+
+	d, err := n.driver(true)
+	if err != nil {
+		return err
+	}
+	m, ok := d.(driverapi.Mirrorer)
+	if !ok {
+		return types.NotImplementedErrorf("the %s driver does not support the mirroring of the endpoints", n.Type())
+	}
+	if err := m.MirrorEndpoint(n.ID(), endpointID, target); err != nil {
+		return err
+	}
+
+	if target == nil {
+		logrus.Infof("Stopped the mirroring of endpoint %.7s", endpointID)
+	} else {
+		logrus.Infof("Mirroring endpoint %.7s to %s%s", endpointID, target.Interface, target.Remote)
+	}
+	return nil
+}
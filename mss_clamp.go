@@ -0,0 +1,58 @@
+package libnetwork
+
+import (
+	"strconv"
+
+	"github.com/docker/libnetwork/netlabel"
+	"github.com/docker/libnetwork/types"
+)
+
+// The bounds of the MSS the TCP connections can be clamped to, the IPv4
+// minimum and the largest segment of the largest IPv4 packet
+const (
+	minTCPMSS = 536
+	maxTCPMSS = 65495
+)
+
+// parseTCPMSS returns the TCPMSS target arguments of the netlabel.TCPMSS
+// value
+func parseTCPMSS(v string) ([]string, error) {
+	if v == "pmtu" {
+		return []string{"--clamp-mss-to-pmtu"}, nil
+	}
+	mss, err := strconv.Atoi(v)
+	if err != nil || mss < minTCPMSS || mss > maxTCPMSS {
+		return nil, types.BadRequestErrorf("invalid %s %q: expected pmtu or a segment size between %d and %d",
+			netlabel.TCPMSS, v, minTCPMSS, maxTCPMSS)
+	}
+	return []string{"--set-mss", strconv.Itoa(mss)}, nil
+}
+
+func (n *network) validateTCPMSS() error {
+	if v, ok := n.labels[netlabel.TCPMSS]; ok {
+		_, err := parseTCPMSS(v)
+		return err
+	}
+	return nil
+}
+
+// tcpMSSArgs returns the TCPMSS target arguments of the connections of the
+// endpoints of the network, none when they are not clamped
+func (n *network) tcpMSSArgs() []string {
+	v, ok := n.Labels()[netlabel.TCPMSS]
+	if !ok {
+		return nil
+	}
+	args, _ := parseTCPMSS(v)
+	return args
+}
+
+// mssClampRules returns the mangle rules clamping the MSS of the TCP SYN
+// segments leaving and entering the sandbox through the interface
+func mssClampRules(ifName string, target []string) [][]string {
+	syn := []string{"-p", "tcp", "--tcp-flags", "SYN,RST", "SYN", "-j", "TCPMSS"}
+	return [][]string{
+		append(append([]string{"POSTROUTING", "-o", ifName}, syn...), target...),
+		append(append([]string{"PREROUTING", "-i", ifName}, syn...), target...),
+	}
+}
@@ -0,0 +1,76 @@
+package libnetwork
+
+import (
+	"github.com/docker/libnetwork/iptables"
+	"github.com/docker/libnetwork/osl"
+	"github.com/sirupsen/logrus"
+)
+
+// setupMSSClamp installs the rules clamping the MSS of the TCP connections
+// on the interface of the endpoint in the sandbox, when its network asks
+// for it
+func (ep *endpoint) setupMSSClamp(osSbox osl.Sandbox, srcName string) error {
+	n := ep.getNetwork()
+	if n == nil {
+		return nil
+	}
+	target := n.tcpMSSArgs()
+	if target == nil {
+		return nil
+	}
+	var ifName string
+	for _, i := range osSbox.Info().Interfaces() {
+		if i.SrcName() == srcName {
+			ifName = i.DstName()
+		}
+	}
+	if ifName == "" {
+		return nil
+	}
+
+	ipv6 := ep.Iface().AddressIPv6() != nil
+	var err error
+	if ierr := osSbox.InvokeFunc(func() {
+		for _, rule := range mssClampRules(ifName, target) {
+			args := append([]string{"-t", string(iptables.Mangle), string(iptables.Append)}, rule...)
+			if err = iptables.RawCombinedOutputNative(args...); err != nil {
+				return
+			}
+			if ipv6 {
+				if err = iptables.RawCombinedOutputNative6(args...); err != nil {
+					return
+				}
+			}
+		}
+	}); ierr != nil {
+		return ierr
+	}
+	if err != nil {
+		ep.clearMSSClamp(osSbox, ifName)
+		return err
+	}
+	logrus.Debugf("Clamping the TCP MSS of endpoint %s on %s with %v", ep.Name(), ifName, target)
+	return nil
+}
+
+// clearMSSClamp removes the MSS clamping rules of the interface of the
+// endpoint in the sandbox, the interface name being reused by the next one
+func (ep *endpoint) clearMSSClamp(osSbox osl.Sandbox, ifName string) {
+	n := ep.getNetwork()
+	if n == nil {
+		return
+	}
+	target := n.tcpMSSArgs()
+	if target == nil {
+		return
+	}
+	osSbox.InvokeFunc(func() {
+		for _, rule := range mssClampRules(ifName, target) {
+			args := append([]string{"-t", string(iptables.Mangle), string(iptables.Delete)}, rule...)
+			if err := iptables.RawCombinedOutputNative(args...); err != nil {
+				logrus.Debugf("Failed to remove the MSS clamping rule of %s: %v", ifName, err)
+			}
+			iptables.RawCombinedOutputNative6(args...)
+		}
+	})
+}
@@ -0,0 +1,18 @@
+// +build !linux
+
+package libnetwork
+
+import (
+	"github.com/docker/libnetwork/osl"
+	"github.com/docker/libnetwork/types"
+)
+
+func (ep *endpoint) setupMSSClamp(osSbox osl.Sandbox, srcName string) error {
+	if n := ep.getNetwork(); n != nil && n.tcpMSSArgs() != nil {
+		return types.NotImplementedErrorf("TCP MSS clamping is not supported on this platform")
+	}
+	return nil
+}
+
+func (ep *endpoint) clearMSSClamp(osSbox osl.Sandbox, ifName string) {
+}
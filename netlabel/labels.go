@@ -27,9 +27,82 @@ const (
 	// ExposedPorts constant represents the container's Exposed Ports
 	ExposedPorts = Prefix + ".endpoint.exposedports"
 
+	// EndpointName constant represents the name of the endpoint, passed
+	// to the drivers at the endpoint creation
+	EndpointName = Prefix + ".endpoint.name"
+
 	// DNSServers A list of DNS servers associated with the endpoint
 	DNSServers = Prefix + ".endpoint.dnsservers"
 
+	// ConnLimit constant represents the maximum number of concurrent TCP
+	// connections a source can open towards the endpoint
+	ConnLimit = Prefix + ".endpoint.connlimit"
+
+	// ConnRate constant represents the maximum rate of new connections a
+	// source can open towards the endpoint, as in "20/sec"
+	ConnRate = Prefix + ".endpoint.connrate"
+
+	// SynProxy constant represents whether the TCP handshakes towards the
+	// published ports of the endpoint are completed by the host SYNPROXY
+	SynProxy = Prefix + ".endpoint.synproxy"
+
+	// SynRate constant represents the maximum rate of SYNs a source can
+	// send to the published ports of a SynProxy endpoint, as in "20/sec"
+	SynRate = Prefix + ".endpoint.synrate"
+
+	// ICCGroups constant represents the comma separated groups of the
+	// endpoint, which only talks on the bridge to the endpoints sharing one
+	ICCGroups = Prefix + ".endpoint.iccgroups"
+
+	// HairpinPorts constant represents the hairpin NAT of the published
+	// ports of the endpoint, as in "80/tcp=on,53/udp=off", which tells if
+	// the containers of the network reach them through the host addresses
+	HairpinPorts = Prefix + ".endpoint.hairpin_ports"
+
+	// HeldPorts constant represents the comma separated TCP ports of the
+	// endpoint, as in "80/tcp,8443", whose published host ports the daemon
+	// holds open and proxies, the connections arriving before the
+	// container listens waiting for it instead of being refused
+	HeldPorts = Prefix + ".endpoint.held_ports"
+
+	// AnycastAddresses constant represents the comma separated /32
+	// addresses configured on the sandbox loopback of the endpoint and
+	// routed to it from the host
+	AnycastAddresses = Prefix + ".endpoint.anycast_addresses"
+
+	// RelaxSourceValidation constant represents whether the reverse path
+	// filtering of the endpoint is disabled, for the asymmetric routing
+	// of the direct server return load balancing
+	RelaxSourceValidation = Prefix + ".endpoint.relax_source_validation"
+
+	// ConntrackTimeouts constant represents the conntrack timeouts of the
+	// connections towards the published ports, as in udp=1h,tcp=12h
+	ConntrackTimeouts = Prefix + ".endpoint.conntrack_timeouts"
+
+	// Offloads constant represents the offloads turned on or off on both
+	// ends of the veth of the endpoint, as in gso=off,gro=off,tx=off
+	Offloads = Prefix + ".endpoint.offloads"
+
+	// AFXDP constant represents the AF_XDP settings of the sandbox
+	// interface of the endpoint, as in queues=4,redirect=on
+	AFXDP = Prefix + ".endpoint.af_xdp"
+
+	// AFXDPMap constant represents the path the XSKMAP of the AF_XDP
+	// redirect program of the endpoint is pinned at
+	AFXDPMap = AFXDP + ".map"
+
+	// Cgroup constant represents the path of the cgroup v2 directory of the
+	// processes of the sandbox of the endpoint
+	Cgroup = Prefix + ".endpoint.cgroup"
+
+	// PolicyNamespace constant represents the Kubernetes namespace of the
+	// endpoint, which the network policies of the namespace apply to
+	PolicyNamespace = Prefix + ".endpoint.policy_namespace"
+
+	// PolicyLabels constant represents the labels of the endpoint the pod
+	// selectors of the network policies match, as in app=web,tier=front
+	PolicyLabels = Prefix + ".endpoint.policy_labels"
+
 	//EnableIPv6 constant represents enabling IPV6 at network level
 	EnableIPv6 = Prefix + ".enable_ipv6"
 
@@ -45,14 +118,110 @@ const (
 	// OverlayVxlanIDList constant represents a list of VXLAN Ids as csv
 	OverlayVxlanIDList = DriverPrefix + ".overlay.vxlanid_list"
 
+	// OverlayVxlanOffload constant enables the UDP tunnel offloads of the
+	// overlay driver underlay device
+	OverlayVxlanOffload = DriverPrefix + ".overlay.vxlan_offload"
+
+	// OverlayESPOffload constant enables the offload of the overlay
+	// driver IPsec SAs to the underlay device
+	OverlayESPOffload = DriverPrefix + ".overlay.esp_offload"
+
 	// Gateway represents the gateway for the network
 	Gateway = Prefix + ".gateway"
 
 	// Internal constant represents that the network is internal which disables default gateway service
 	Internal = Prefix + ".internal"
 
+	// IPv6Only constant represents that the network allocates no IPv4
+	// address, its endpoints being reachable over IPv6 only
+	IPv6Only = Prefix + ".ipv6_only"
+
 	// ContainerIfacePrefix can be used to override the interface prefix used inside the container
 	ContainerIfacePrefix = Prefix + ".container_iface_prefix"
+
+	// LBHook names the external load balancer hook the services of the
+	// network are published to
+	LBHook = Prefix + ".lb_hook"
+
+	// EndpointHooks names the comma separated endpoint hooks run, in
+	// order, on the lifecycle of the endpoints of the network
+	EndpointHooks = Prefix + ".endpoint_hooks"
+
+	// RouteExporters constant represents the route exporters of the
+	// daemon, handed to the drivers in their configuration
+	RouteExporters = Prefix + ".route_exporters"
+
+	// Tenant constant represents the tenant owning the network, which
+	// prefixes its name and the names of its kernel objects
+	Tenant = Prefix + ".tenant"
+
+	// StoreWriteBehind constant represents whether the endpoints of a
+	// global scope network are committed locally and synced to the store
+	// in the background, for them to be created through a store outage
+	StoreWriteBehind = Prefix + ".store.write_behind"
+
+	// IPv6AddressMode constant represents how the IPv6 addresses of the
+	// endpoints of a network are chosen: allocated by the IPAM driver when
+	// unset, derived from the MAC address with "slaac" or with the RFC 7217
+	// algorithm with "stable-privacy"
+	IPv6AddressMode = Prefix + ".ipv6_address_mode"
+
+	// TCPMSS constant represents the MSS the TCP connections of the
+	// endpoints of a network are clamped to, a segment size or "pmtu" for
+	// the path MTU
+	TCPMSS = Prefix + ".tcp_mss"
+
+	// FlowExport constant represents the IPFIX collector, as host:port,
+	// the flows of the endpoints of a network are exported to
+	FlowExport = Prefix + ".flow_export"
+
+	// FlowExportSampling constant represents the one in how many flows of
+	// a network are exported, all of them by default
+	FlowExportSampling = Prefix + ".flow_export.sampling"
+
+	// TCPKeepalive constant represents the TCP keepalive settings of the
+	// sandboxes joining a network, as in time=600,intvl=30,probes=5. The
+	// endpoint option of the same name overrides them setting by setting.
+	TCPKeepalive = Prefix + ".tcp_keepalive"
+
+	// NAT64Prefix constant represents the IPv6 /96 of a network the IPv4
+	// destinations are embedded in, as 64:ff9b::/96, for the driver to
+	// translate the traffic to them and the embedded resolver to synthesize
+	// their AAAA records
+	NAT64Prefix = Prefix + ".nat64_prefix"
+
+	// DNSTXTLabels constant represents the comma separated keys of the
+	// endpoint labels the embedded resolver publishes as the TXT records of
+	// the endpoint names on the network, as in key=value
+	DNSTXTLabels = Prefix + ".dns_txt_labels"
+
+	// DNSSearch constant represents the comma separated search domains
+	// of a network, merged into the resolv.conf of the sandboxes joining it
+	// in place of the ones of the host. The domains of all the networks of
+	// a sandbox are searched, the ones of its endpoint of highest priority
+	// first, after the ones of the sandbox configuration if any.
+	DNSSearch = Prefix + ".dns_search"
+
+	// DNSOptions constant represents the resolver options of a network,
+	// as in ndots=2,timeout=3,attempts=2, merged into the resolv.conf of
+	// the sandboxes joining it. Each option is set by the sandbox
+	// configuration, else by the endpoint of highest priority setting it,
+	// else by the host.
+	DNSOptions = Prefix + ".dns_options"
+
+	// DeletionProtection constant represents whether a network is protected
+	// from deletion when created, until the protection is lifted
+	DeletionProtection = Prefix + ".deletion_protection"
+
+	// NextHops constant represents the comma separated next hops of a
+	// routed network, which the next hop prober checks from the host along
+	// with the gateways and route next hops of its endpoints
+	NextHops = Prefix + ".next_hops"
+
+	// NamespacePending constant is set in the join options when the
+	// namespace of the sandbox, set later with an external key, does not
+	// exist yet
+	NamespacePending = Prefix + ".namespace_pending"
 )
 
 var (
@@ -127,3 +296,18 @@ func KeyValue(label string) (key string, value string) {
 	}
 	return
 }
+
+// IsValidTenant validates the tenant names, a lowercase letter followed by
+// up to 5 lowercase letters or digits, short enough to prefix the names of
+// the kernel objects of their networks
+func IsValidTenant(name string) bool {
+	if len(name) == 0 || len(name) > 6 || name[0] < 'a' || name[0] > 'z' {
+		return false
+	}
+	for _, c := range name {
+		if (c < 'a' || c > 'z') && (c < '0' || c > '9') {
+			return false
+		}
+	}
+	return true
+}
@@ -28,3 +28,16 @@ func TestKeyValue(t *testing.T) {
 		}
 	}
 }
+
+func TestIsValidTenant(t *testing.T) {
+	for _, name := range []string{"a", "ads", "team42"} {
+		if !IsValidTenant(name) {
+			t.Fatalf("expected %q to be a valid tenant", name)
+		}
+	}
+	for _, name := range []string{"", "4ads", "Ads", "ads-1", "toolong"} {
+		if IsValidTenant(name) {
+			t.Fatalf("expected %q to be an invalid tenant", name)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package netpolicy
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/docker/libnetwork/iptables"
+	"github.com/sirupsen/logrus"
+)
+
+type iptablesProgrammer struct{}
+
+// NewIptablesProgrammer returns a Programmer applying the chains to the
+// iptables filter table
+func NewIptablesProgrammer() Programmer {
+	return iptablesProgrammer{}
+}
+
+func (iptablesProgrammer) EnsureChain(name string) error {
+	_, err := iptables.NewChain(name, iptables.Filter, false)
+	return err
+}
+
+func (iptablesProgrammer) SetRules(name string, rules [][]string) error {
+	if err := iptables.RawCombinedOutput("-t", string(iptables.Filter), "-F", name); err != nil {
+		return fmt.Errorf("failed to flush chain %s: %v", name, err)
+	}
+	for _, r := range rules {
+		if err := iptables.ProgramRule(iptables.Filter, name, iptables.Append, r); err != nil {
+			return fmt.Errorf("failed to program rule %v in chain %s: %v", r, name, err)
+		}
+	}
+	return nil
+}
+
+func (iptablesProgrammer) DeleteChain(name string) error {
+	return iptables.RemoveExistingChain(name, iptables.Filter)
+}
+
+func (iptablesProgrammer) EnsureJump(from, to string) error {
+	return iptables.EnsureJumpRule(from, to)
+}
+
+func (iptablesProgrammer) RemoveJump(from, to string) error {
+	return iptables.ProgramRule(iptables.Filter, from, iptables.Delete, []string{"-j", to})
+}
+
+func (iptablesProgrammer) ReplaceRule(name string, pos int, rule []string) error {
+	if err := iptables.RawCombinedOutput(append([]string{"-t", string(iptables.Filter), "-R", name, strconv.Itoa(pos)}, rule...)...); err != nil {
+		return fmt.Errorf("failed to replace rule %d of chain %s with %v: %v", pos, name, rule, err)
+	}
+	return nil
+}
+
+func (iptablesProgrammer) AppendRule(name string, rule []string) error {
+	return iptables.ProgramRule(iptables.Filter, name, iptables.Append, rule)
+}
+
+func (iptablesProgrammer) DeleteRule(name string, rule []string) error {
+	return iptables.ProgramRule(iptables.Filter, name, iptables.Delete, rule)
+}
+
+// isPolicyChain tells whether the chain is one of the chains of the
+// network policies, their shadow chains included
+func isPolicyChain(name string) bool {
+	return name == TopChain || strings.HasPrefix(name, ingressPrefix) ||
+		strings.HasPrefix(name, egressPrefix) || strings.HasPrefix(name, exceptPrefix)
+}
+
+// RemoveIptablesChains removes the chains of the network policies left in
+// the iptables filter table by a previous run, along with the jump to them
+// from FORWARD. The chains are all flushed before being deleted, as they
+// jump to one another.
+func RemoveIptablesChains() {
+	jump := []string{"-j", TopChain}
+	for iptables.Exists(iptables.Filter, "FORWARD", jump...) {
+		if err := iptables.ProgramRule(iptables.Filter, "FORWARD", iptables.Delete, jump); err != nil {
+			logrus.Warnf("Failed to remove the jump to chain %s: %v", TopChain, err)
+			break
+		}
+	}
+
+	out, err := iptables.Raw("-t", string(iptables.Filter), "-S")
+	if err != nil {
+		logrus.Warnf("Failed to list the chains of the network policies: %v", err)
+		return
+	}
+	var chains []string
+	for _, line := range strings.Split(string(out), "\n") {
+		if f := strings.Fields(line); len(f) == 2 && f[0] == "-N" && isPolicyChain(f[1]) {
+			chains = append(chains, f[1])
+		}
+	}
+	for _, name := range chains {
+		if err := iptables.RawCombinedOutput("-t", string(iptables.Filter), "-F", name); err != nil {
+			logrus.Warnf("Failed to flush chain %s: %v", name, err)
+		}
+	}
+	for _, name := range chains {
+		if err := iptables.RawCombinedOutput("-t", string(iptables.Filter), "-X", name); err != nil {
+			logrus.Warnf("Failed to remove chain %s: %v", name, err)
+		}
+	}
+}
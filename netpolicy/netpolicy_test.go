@@ -0,0 +1,355 @@
+package netpolicy
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/docker/libnetwork/iptables"
+	"github.com/docker/libnetwork/testutils/fakeiptables"
+)
+
+type fakeSource struct {
+	eps []Endpoint
+	ns  map[string]map[string]string
+}
+
+func (s *fakeSource) Endpoints() []Endpoint {
+	return s.eps
+}
+
+func (s *fakeSource) NamespaceLabels(ns string) map[string]string {
+	return s.ns[ns]
+}
+
+type fakeProgrammer struct {
+	chains map[string][][]string
+	jumps  map[string]bool
+	// inPlace counts the rewrites of chains jumped to
+	inPlace int
+}
+
+func newFakeProgrammer() *fakeProgrammer {
+	return &fakeProgrammer{chains: map[string][][]string{}, jumps: map[string]bool{}}
+}
+
+func (p *fakeProgrammer) EnsureChain(name string) error {
+	if _, ok := p.chains[name]; !ok {
+		p.chains[name] = nil
+	}
+	return nil
+}
+
+// referenced tells whether a rule of another chain jumps to the chain
+func (p *fakeProgrammer) referenced(name string) bool {
+	for from, rules := range p.chains {
+		for _, r := range rules {
+			if from != name && len(r) >= 2 && r[len(r)-2] == "-j" && r[len(r)-1] == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (p *fakeProgrammer) SetRules(name string, rules [][]string) error {
+	if p.referenced(name) {
+		p.inPlace++
+	}
+	p.chains[name] = rules
+	return nil
+}
+
+func (p *fakeProgrammer) DeleteChain(name string) error {
+	if p.referenced(name) {
+		return fmt.Errorf("chain %s still referenced", name)
+	}
+	delete(p.chains, name)
+	return nil
+}
+
+func (p *fakeProgrammer) EnsureJump(from, to string) error {
+	p.jumps[from+"->"+to] = true
+	return nil
+}
+
+func (p *fakeProgrammer) RemoveJump(from, to string) error {
+	delete(p.jumps, from+"->"+to)
+	return nil
+}
+
+func (p *fakeProgrammer) ReplaceRule(name string, pos int, rule []string) error {
+	if pos < 1 || pos > len(p.chains[name]) {
+		return fmt.Errorf("no rule %d in chain %s", pos, name)
+	}
+	p.chains[name][pos-1] = rule
+	return nil
+}
+
+func (p *fakeProgrammer) AppendRule(name string, rule []string) error {
+	p.chains[name] = append(p.chains[name], rule)
+	return nil
+}
+
+func (p *fakeProgrammer) DeleteRule(name string, rule []string) error {
+	for i, r := range p.chains[name] {
+		if reflect.DeepEqual(r, rule) {
+			p.chains[name] = append(p.chains[name][:i:i], p.chains[name][i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("no rule %v in chain %s", rule, name)
+}
+
+const testPolicy = `{
+  "metadata": {"name": "allow-frontend", "namespace": "prod"},
+  "spec": {
+    "podSelector": {"matchLabels": {"app": "db"}},
+    "ingress": [{
+      "from": [
+        {"podSelector": {"matchLabels": {"app": "web"}}},
+        {"ipBlock": {"cidr": "10.0.0.0/8", "except": ["10.1.0.0/16"]}}
+      ],
+      "ports": [{"protocol": "TCP", "port": 5432}, {"port": "metrics"}]
+    }]
+  }
+}`
+
+func testSource() *fakeSource {
+	return &fakeSource{
+		eps: []Endpoint{
+			{ID: "db", Namespace: "prod", Labels: map[string]string{"app": "db"}, IP: net.ParseIP("172.18.0.2")},
+			{ID: "web", Namespace: "prod", Labels: map[string]string{"app": "web"}, IP: net.ParseIP("172.18.0.3")},
+			{ID: "other", Namespace: "dev", Labels: map[string]string{"app": "web"}, IP: net.ParseIP("172.18.0.4")},
+		},
+		ns: map[string]map[string]string{"prod": {"env": "prod"}, "dev": {"env": "dev"}},
+	}
+}
+
+func TestSelector(t *testing.T) {
+	s := &LabelSelector{
+		MatchLabels: map[string]string{"app": "web"},
+		MatchExpressions: []LabelSelectorRequirement{
+			{Key: "tier", Operator: OpIn, Values: []string{"a", "b"}},
+			{Key: "legacy", Operator: OpDoesNotExist},
+		},
+	}
+	for _, tc := range []struct {
+		labels map[string]string
+		match  bool
+	}{
+		{map[string]string{"app": "web", "tier": "a"}, true},
+		{map[string]string{"app": "web", "tier": "c"}, false},
+		{map[string]string{"app": "web", "tier": "b", "legacy": ""}, false},
+		{map[string]string{"tier": "a"}, false},
+	} {
+		if s.Matches(tc.labels) != tc.match {
+			t.Fatalf("selector match of %v: expected %v", tc.labels, tc.match)
+		}
+	}
+	if !(&LabelSelector{}).Matches(nil) {
+		t.Fatal("empty selector must match everything")
+	}
+}
+
+func TestTranslate(t *testing.T) {
+	var p NetworkPolicy
+	if err := json.Unmarshal([]byte(testPolicy), &p); err != nil {
+		t.Fatal(err)
+	}
+	src := testSource()
+	prog := newFakeProgrammer()
+	tr := NewTranslator(src, prog)
+	tr.OnAdd(&p)
+
+	if !prog.jumps["FORWARD->"+TopChain] {
+		t.Fatal("expected jump from FORWARD")
+	}
+	in := chainName(ingressPrefix, "db")
+	exc := chainName(exceptPrefix, "db", "Ingress", "prod/allow-frontend", "0", "1")
+	expTop := [][]string{{"-d", "172.18.0.2/32", "-j", in}}
+	if !reflect.DeepEqual(prog.chains[TopChain], expTop) {
+		t.Fatalf("unexpected top chain: %v", prog.chains[TopChain])
+	}
+	expIn := [][]string{
+		{"-m", "conntrack", "--ctstate", "RELATED,ESTABLISHED", "-j", "RETURN"},
+		{"-j", "MARK", "--set-xmark", clearMark},
+		{"-s", "172.18.0.3/32", "-p", "tcp", "--dport", "5432", "-j", "RETURN"},
+		{"-s", "10.0.0.0/8", "-p", "tcp", "--dport", "5432", "-j", exc},
+		{"-m", "mark", "--mark", allowMark, "-j", "RETURN"},
+		{"-j", "DROP"},
+	}
+	if !reflect.DeepEqual(prog.chains[in], expIn) {
+		t.Fatalf("unexpected ingress chain:\n%v\nexpected:\n%v", prog.chains[in], expIn)
+	}
+	expExc := [][]string{
+		{"-s", "10.1.0.0/16", "-j", "RETURN"},
+		{"-j", "MARK", "--set-xmark", allowMark},
+	}
+	if !reflect.DeepEqual(prog.chains[exc], expExc) {
+		t.Fatalf("unexpected exception chain: %v", prog.chains[exc])
+	}
+
+	// Restrict the egress of the prod web pods to the dev namespace
+	np := p
+	np.Spec = NetworkPolicySpec{
+		PodSelector: LabelSelector{MatchLabels: map[string]string{"app": "web"}},
+		PolicyTypes: []PolicyType{PolicyTypeEgress},
+		Egress: []EgressRule{{To: []Peer{{
+			NamespaceSelector: &LabelSelector{MatchLabels: map[string]string{"env": "dev"}},
+		}}}},
+	}
+	tr.OnUpdate(&p, &np)
+	if _, ok := prog.chains[in]; ok {
+		t.Fatal("stale ingress chain not removed")
+	}
+	if _, ok := prog.chains[exc]; ok {
+		t.Fatal("stale exception chain not removed")
+	}
+	out := chainName(egressPrefix, "web")
+	expOut := [][]string{
+		{"-m", "conntrack", "--ctstate", "RELATED,ESTABLISHED", "-j", "RETURN"},
+		{"-d", "172.18.0.4/32", "-j", "RETURN"},
+		{"-j", "DROP"},
+	}
+	if !reflect.DeepEqual(prog.chains[out], expOut) {
+		t.Fatalf("unexpected egress chain: %v", prog.chains[out])
+	}
+
+	tr.OnDelete(&np)
+	if len(prog.chains[TopChain]) != 0 || len(prog.chains) != 1 {
+		t.Fatalf("expected only the empty top chain, got %v", prog.chains)
+	}
+	if err := tr.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if len(prog.chains) != 0 || len(prog.jumps) != 0 {
+		t.Fatalf("rules left after close: %v %v", prog.chains, prog.jumps)
+	}
+}
+
+func TestSwapChains(t *testing.T) {
+	var p NetworkPolicy
+	if err := json.Unmarshal([]byte(testPolicy), &p); err != nil {
+		t.Fatal(err)
+	}
+	src := testSource()
+	prog := newFakeProgrammer()
+	tr := NewTranslator(src, prog)
+	tr.OnAdd(&p)
+
+	in := chainName(ingressPrefix, "db")
+	exc := chainName(exceptPrefix, "db", "Ingress", "prod/allow-frontend", "0", "1")
+
+	// Only the exception changes, the ingress chain jumping to it is
+	// rebuilt too
+	np := p
+	np.Spec.Ingress = []IngressRule{{
+		From:  []Peer{{IPBlock: &IPBlock{CIDR: "10.0.0.0/8", Except: []string{"10.2.0.0/16"}}}},
+		Ports: p.Spec.Ingress[0].Ports,
+	}}
+	excNew := chainName(exceptPrefix, "db", "Ingress", "prod/allow-frontend", "0", "0")
+	tr.OnUpdate(&p, &np)
+	if prog.inPlace != 0 {
+		t.Fatalf("%d chains rewritten while jumped to", prog.inPlace)
+	}
+	expTop := [][]string{{"-d", "172.18.0.2/32", "-j", in + shadowSuffix}}
+	if !reflect.DeepEqual(prog.chains[TopChain], expTop) {
+		t.Fatalf("unexpected top chain: %v", prog.chains[TopChain])
+	}
+	for _, name := range []string{in, exc} {
+		if _, ok := prog.chains[name]; ok {
+			t.Fatalf("swapped out chain %s not removed", name)
+		}
+	}
+	if r := prog.chains[in+shadowSuffix][2]; r[len(r)-1] != excNew {
+		t.Fatalf("unexpected ingress rule %v", r)
+	}
+
+	// A change of the exception alone rebuilds it aside as well, and
+	// brings the ingress chain back under its name
+	np2 := np
+	np2.Spec.Ingress = []IngressRule{{
+		From:  []Peer{{IPBlock: &IPBlock{CIDR: "10.0.0.0/8", Except: []string{"10.3.0.0/16"}}}},
+		Ports: p.Spec.Ingress[0].Ports,
+	}}
+	tr.OnUpdate(&np, &np2)
+	if prog.inPlace != 0 {
+		t.Fatalf("%d chains rewritten while jumped to", prog.inPlace)
+	}
+	if r := prog.chains[in][2]; r[len(r)-1] != excNew+shadowSuffix {
+		t.Fatalf("unexpected ingress rule %v", r)
+	}
+	if len(prog.chains) != 3 {
+		t.Fatalf("unexpected chains %v", prog.chains)
+	}
+
+	// The endpoints coming and going add and remove their own rules
+	src.eps = append(src.eps, Endpoint{ID: "db2", Namespace: "prod", Labels: map[string]string{"app": "db"}, IP: net.ParseIP("172.18.0.5")})
+	if err := tr.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if len(prog.chains[TopChain]) != 2 {
+		t.Fatalf("unexpected top chain: %v", prog.chains[TopChain])
+	}
+	src.eps = src.eps[1:]
+	if err := tr.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	expTop = [][]string{{"-d", "172.18.0.5/32", "-j", chainName(ingressPrefix, "db2")}}
+	if !reflect.DeepEqual(prog.chains[TopChain], expTop) {
+		t.Fatalf("unexpected top chain: %v", prog.chains[TopChain])
+	}
+	if prog.inPlace != 0 {
+		t.Fatalf("%d chains rewritten while jumped to", prog.inPlace)
+	}
+
+	if err := tr.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if len(prog.chains) != 0 || len(prog.jumps) != 0 {
+		t.Fatalf("rules left after close: %v %v", prog.chains, prog.jumps)
+	}
+}
+
+func TestChainNameLength(t *testing.T) {
+	// iptables chain names are limited to 28 characters
+	n := chainName(exceptPrefix, strings.Repeat("x", 64), "Ingress", "ns/name", "0", "0") + shadowSuffix
+	if len(n) > 28 {
+		t.Fatalf("chain name %s too long", n)
+	}
+}
+
+func TestRemoveIptablesChains(t *testing.T) {
+	ipt := fakeiptables.New()
+	defer ipt.Install()()
+
+	// The chains of a previous run
+	prog := NewIptablesProgrammer()
+	in, exc := ingressPrefix+"0123456789", exceptPrefix+"0123456789"
+	for _, name := range []string{TopChain, in, exc, in + shadowSuffix, "DOCKER-OTHER"} {
+		if err := prog.EnsureChain(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := prog.SetRules(TopChain, [][]string{{"-d", "172.18.0.2/32", "-j", in}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := prog.SetRules(in, [][]string{{"-j", exc}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := prog.EnsureJump("FORWARD", TopChain); err != nil {
+		t.Fatal(err)
+	}
+
+	RemoveIptablesChains()
+	if chains := ipt.IPv4().Chains(iptables.Filter); !reflect.DeepEqual(chains, []string{"DOCKER-OTHER"}) {
+		t.Fatalf("unexpected chains %v left", chains)
+	}
+	if rules := ipt.IPv4().Rules(iptables.Filter, "FORWARD"); len(rules) != 0 {
+		t.Fatalf("unexpected rules %v left in FORWARD", rules)
+	}
+}
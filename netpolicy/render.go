@@ -0,0 +1,240 @@
+package netpolicy
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// TopChain is the filter table chain, jumped to from FORWARD, which
+	// dispatches the traffic of the policed endpoints to their chains
+	TopChain = "DOCKER-NETPOL"
+
+	ingressPrefix = "NP-IN-"
+	egressPrefix  = "NP-OUT-"
+	exceptPrefix  = "NP-EXC-"
+
+	// allowMark is the packet mark bit used to carry an allow verdict out
+	// of the chains implementing ipBlock exceptions
+	allowMark = "0x100000/0x100000"
+	clearMark = "0x0/0x100000"
+)
+
+// chain is a rendered filter table chain
+type chain struct {
+	name  string
+	rules [][]string
+}
+
+// peerMatch is the rendered match of a rule peer and the target of the
+// rule for matching packets
+type peerMatch struct {
+	args   []string
+	target string
+}
+
+// rule is a direction agnostic view of an ingress or egress rule
+type rule struct {
+	ports []Port
+	peers []Peer
+}
+
+func (p *NetworkPolicy) rules(dir PolicyType) []rule {
+	var rules []rule
+	if dir == PolicyTypeIngress {
+		for _, r := range p.Spec.Ingress {
+			rules = append(rules, rule{r.Ports, r.From})
+		}
+		return rules
+	}
+	for _, r := range p.Spec.Egress {
+		rules = append(rules, rule{r.Ports, r.To})
+	}
+	return rules
+}
+
+// ruleset is the full rendering of the policies. The chains are ordered so
+// that programming them in sequence never references a missing chain.
+type ruleset struct {
+	chains []chain
+	top    [][]string
+}
+
+func chainName(prefix string, parts ...string) string {
+	h := sha256.Sum256([]byte(strings.Join(parts, "/")))
+	return prefix + hex.EncodeToString(h[:])[:12]
+}
+
+func hostCIDR(ip net.IP) string {
+	return ip.String() + "/32"
+}
+
+// render computes the rules implementing the policies for the endpoints
+func render(policies []*NetworkPolicy, eps []Endpoint, src EndpointSource) *ruleset {
+	rs := &ruleset{}
+	var epChains []chain
+	for i := range eps {
+		ep := &eps[i]
+		if ep.IP.To4() == nil {
+			continue
+		}
+		for _, dir := range []PolicyType{PolicyTypeIngress, PolicyTypeEgress} {
+			c, subs, ok := renderEndpoint(ep, dir, policies, eps, src)
+			if !ok {
+				continue
+			}
+			rs.chains = append(rs.chains, subs...)
+			epChains = append(epChains, c)
+			match := "-d"
+			if dir == PolicyTypeEgress {
+				match = "-s"
+			}
+			rs.top = append(rs.top, []string{match, hostCIDR(ep.IP), "-j", c.name})
+		}
+	}
+	rs.chains = append(rs.chains, epChains...)
+	return rs
+}
+
+// renderEndpoint renders the chain policing one direction of the endpoint's
+// traffic. It returns false if no policy selects the endpoint for it.
+func renderEndpoint(ep *Endpoint, dir PolicyType, policies []*NetworkPolicy, eps []Endpoint, src EndpointSource) (chain, []chain, bool) {
+	prefix, addrFlag := ingressPrefix, "-s"
+	if dir == PolicyTypeEgress {
+		prefix, addrFlag = egressPrefix, "-d"
+	}
+	c := chain{name: chainName(prefix, ep.ID)}
+	var (
+		selected bool
+		subs     []chain
+		allow    [][]string
+	)
+	for _, p := range policies {
+		if !p.hasType(dir) || !p.selects(ep) {
+			continue
+		}
+		selected = true
+		for ri, r := range p.rules(dir) {
+			ports := portMatches(p, r.ports)
+			if ports == nil {
+				continue
+			}
+			var peers []peerMatch
+			if len(r.peers) == 0 {
+				peers = []peerMatch{{target: "RETURN"}}
+			}
+			for pi := range r.peers {
+				peer := &r.peers[pi]
+				if peer.IPBlock != nil {
+					m, sub := ipBlockMatch(peer.IPBlock, addrFlag, ep.ID, string(dir), policyKey(p), fmt.Sprint(ri), fmt.Sprint(pi))
+					if m == nil {
+						continue
+					}
+					if sub != nil {
+						subs = append(subs, *sub)
+					}
+					peers = append(peers, *m)
+					continue
+				}
+				for _, pe := range peerEndpoints(peer, p.Namespace, src, eps) {
+					if pe.IP.To4() == nil {
+						continue
+					}
+					peers = append(peers, peerMatch{args: []string{addrFlag, hostCIDR(pe.IP)}, target: "RETURN"})
+				}
+			}
+			for _, pm := range peers {
+				for _, portm := range ports {
+					args := append(append(append([]string{}, pm.args...), portm...), "-j", pm.target)
+					allow = append(allow, args)
+				}
+			}
+		}
+	}
+	if !selected {
+		return c, nil, false
+	}
+
+	c.rules = append(c.rules, []string{"-m", "conntrack", "--ctstate", "RELATED,ESTABLISHED", "-j", "RETURN"})
+	if len(subs) > 0 {
+		c.rules = append(c.rules, []string{"-j", "MARK", "--set-xmark", clearMark})
+	}
+	c.rules = append(c.rules, allow...)
+	if len(subs) > 0 {
+		c.rules = append(c.rules, []string{"-m", "mark", "--mark", allowMark, "-j", "RETURN"})
+	}
+	c.rules = append(c.rules, []string{"-j", "DROP"})
+	return c, subs, true
+}
+
+// portMatches renders the port list of a rule. A nil result means the rule
+// cannot match anything, an empty port list matches all traffic.
+func portMatches(p *NetworkPolicy, ports []Port) [][]string {
+	if len(ports) == 0 {
+		return [][]string{{}}
+	}
+	res := [][]string{}
+	for _, pt := range ports {
+		proto := strings.ToLower(pt.Protocol)
+		if proto == "" {
+			proto = "tcp"
+		}
+		if pt.Port == nil {
+			res = append(res, []string{"-p", proto})
+			continue
+		}
+		if pt.Port.IsString {
+			logrus.Warnf("network policy %s/%s: named port %s is not supported, ignoring it", p.Namespace, p.Name, pt.Port.StrVal)
+			continue
+		}
+		dport := pt.Port.String()
+		if pt.EndPort != nil {
+			dport = fmt.Sprintf("%s:%d", dport, *pt.EndPort)
+		}
+		res = append(res, []string{"-p", proto, "--dport", dport})
+	}
+	if len(res) == 0 {
+		return nil
+	}
+	return res
+}
+
+// ipBlockMatch renders an ipBlock peer. Exceptions are implemented by a
+// dedicated chain which returns for the excepted ranges and marks the
+// packet as allowed otherwise.
+func ipBlockMatch(b *IPBlock, addrFlag string, id ...string) (*peerMatch, *chain) {
+	if _, n, err := net.ParseCIDR(b.CIDR); err != nil || n.IP.To4() == nil {
+		logrus.Debugf("network policy: skipping non IPv4 ipBlock %s", b.CIDR)
+		return nil, nil
+	}
+	if len(b.Except) == 0 {
+		return &peerMatch{args: []string{addrFlag, b.CIDR}, target: "RETURN"}, nil
+	}
+	sub := &chain{name: chainName(exceptPrefix, id...)}
+	for _, e := range b.Except {
+		sub.rules = append(sub.rules, []string{addrFlag, e, "-j", "RETURN"})
+	}
+	sub.rules = append(sub.rules, []string{"-j", "MARK", "--set-xmark", allowMark})
+	return &peerMatch{args: []string{addrFlag, b.CIDR}, target: sub.name}, sub
+}
+
+func sortedPolicies(m map[string]*NetworkPolicy) []*NetworkPolicy {
+	l := make([]*NetworkPolicy, 0, len(m))
+	for _, p := range m {
+		l = append(l, p)
+	}
+	sort.Slice(l, func(i, j int) bool {
+		return policyKey(l[i]) < policyKey(l[j])
+	})
+	return l
+}
+
+func policyKey(p *NetworkPolicy) string {
+	return p.Namespace + "/" + p.Name
+}
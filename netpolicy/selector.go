@@ -0,0 +1,91 @@
+package netpolicy
+
+// Matches returns whether the label set satisfies the selector. Requirements
+// with an unknown operator never match.
+func (s *LabelSelector) Matches(labels map[string]string) bool {
+	for k, v := range s.MatchLabels {
+		if lv, ok := labels[k]; !ok || lv != v {
+			return false
+		}
+	}
+	for _, r := range s.MatchExpressions {
+		if !r.matches(labels) {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *LabelSelectorRequirement) matches(labels map[string]string) bool {
+	v, ok := labels[r.Key]
+	switch r.Operator {
+	case OpExists:
+		return ok
+	case OpDoesNotExist:
+		return !ok
+	case OpIn:
+		return ok && contains(r.Values, v)
+	case OpNotIn:
+		return !ok || !contains(r.Values, v)
+	}
+	return false
+}
+
+func contains(l []string, s string) bool {
+	for _, e := range l {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
+
+// selects returns whether the policy applies to the endpoint
+func (p *NetworkPolicy) selects(ep *Endpoint) bool {
+	return p.Namespace == ep.Namespace && p.Spec.PodSelector.Matches(ep.Labels)
+}
+
+// hasType returns whether the policy applies to the given direction. As in
+// Kubernetes, a policy without explicit types always applies to ingress and
+// applies to egress if it has egress rules.
+func (p *NetworkPolicy) hasType(t PolicyType) bool {
+	if len(p.Spec.PolicyTypes) == 0 {
+		return t == PolicyTypeIngress || (t == PolicyTypeEgress && len(p.Spec.Egress) > 0)
+	}
+	for _, pt := range p.Spec.PolicyTypes {
+		if pt == t {
+			return true
+		}
+	}
+	return false
+}
+
+// peerEndpoints returns the endpoints selected by a pod and/or namespace
+// selector peer of a policy in namespace ns
+func peerEndpoints(peer *Peer, ns string, src EndpointSource, eps []Endpoint) []Endpoint {
+	var (
+		res      []Endpoint
+		nsLabels = map[string]map[string]string{}
+	)
+	for _, ep := range eps {
+		if peer.NamespaceSelector == nil {
+			if ep.Namespace != ns {
+				continue
+			}
+		} else {
+			l, ok := nsLabels[ep.Namespace]
+			if !ok {
+				l = src.NamespaceLabels(ep.Namespace)
+				nsLabels[ep.Namespace] = l
+			}
+			if !peer.NamespaceSelector.Matches(l) {
+				continue
+			}
+		}
+		if peer.PodSelector != nil && !peer.PodSelector.Matches(ep.Labels) {
+			continue
+		}
+		res = append(res, ep)
+	}
+	return res
+}
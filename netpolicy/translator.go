@@ -0,0 +1,292 @@
+package netpolicy
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Programmer applies the rendered chains to the packet filter
+type Programmer interface {
+	// EnsureChain creates the chain in the filter table if missing
+	EnsureChain(name string) error
+	// SetRules replaces the content of the chain with the given rules
+	SetRules(name string, rules [][]string) error
+	// DeleteChain flushes and removes the chain
+	DeleteChain(name string) error
+	// EnsureJump makes sure the from chain jumps to the to chain
+	EnsureJump(from, to string) error
+	// RemoveJump removes the jump from the from chain to the to chain
+	RemoveJump(from, to string) error
+	// ReplaceRule replaces the rule at the position of the chain, counted
+	// from 1, at once
+	ReplaceRule(name string, pos int, rule []string) error
+	// AppendRule appends the rule to the chain
+	AppendRule(name string, rule []string) error
+	// DeleteRule removes the rule from the chain
+	DeleteRule(name string, rule []string) error
+}
+
+// shadowSuffix names the alternate of a chain, its new rules are built in
+// before the jumps to the chain are swapped to it
+const shadowSuffix = "-S"
+
+// chainState is a programmed chain, under its name or its shadow one
+type chainState struct {
+	name  string
+	rules [][]string
+}
+
+// Translator keeps the packet filter in sync with the NetworkPolicy objects
+// and the local endpoints
+type Translator struct {
+	src      EndpointSource
+	prog     Programmer
+	policies map[string]*NetworkPolicy
+	chains   map[string]*chainState
+	// retired are the chains swapped out or no longer referenced, left to
+	// remove
+	retired map[string]struct{}
+	top     [][]string
+	topSet  bool
+	hooked  bool
+	sync.Mutex
+}
+
+// NewTranslator returns a translator programming the policies for the
+// endpoints provided by src
+func NewTranslator(src EndpointSource, prog Programmer) *Translator {
+	return &Translator{
+		src:      src,
+		prog:     prog,
+		policies: map[string]*NetworkPolicy{},
+		chains:   map[string]*chainState{},
+		retired:  map[string]struct{}{},
+	}
+}
+
+// Start subscribes the translator to the policy changes of the informer
+func (t *Translator) Start(inf Informer) {
+	inf.AddEventHandler(t)
+}
+
+// OnAdd is invoked when a policy is added
+func (t *Translator) OnAdd(p *NetworkPolicy) {
+	t.Lock()
+	t.policies[policyKey(p)] = p
+	t.Unlock()
+	t.resync()
+}
+
+// OnUpdate is invoked when a policy is modified
+func (t *Translator) OnUpdate(oldP, newP *NetworkPolicy) {
+	t.Lock()
+	delete(t.policies, policyKey(oldP))
+	t.policies[policyKey(newP)] = newP
+	t.Unlock()
+	t.resync()
+}
+
+// OnDelete is invoked when a policy is removed
+func (t *Translator) OnDelete(p *NetworkPolicy) {
+	t.Lock()
+	delete(t.policies, policyKey(p))
+	t.Unlock()
+	t.resync()
+}
+
+func (t *Translator) resync() {
+	if err := t.Sync(); err != nil {
+		logrus.Errorf("network policy: failed to program rules: %v", err)
+	}
+}
+
+// Sync renders the known policies against the current endpoints and
+// programs the result. It must be invoked when endpoints come and go.
+//
+// The chains whose rules change are built in their alternate, the shadow
+// chain or the chain itself, and the jumps to them are swapped once they
+// are complete: the traffic of an endpoint goes through either its former
+// rules or its new ones, never through a chain being rebuilt.
+func (t *Translator) Sync() error {
+	t.Lock()
+	defer t.Unlock()
+
+	rs := render(sortedPolicies(t.policies), t.src.Endpoints(), t.src)
+
+	if err := t.prog.EnsureChain(TopChain); err != nil {
+		return err
+	}
+	wanted := make(map[string]struct{}, len(rs.chains))
+	for _, c := range rs.chains {
+		wanted[c.name] = struct{}{}
+		// the chains jumped to come first, their names are known
+		rules := t.resolve(c.rules)
+		cur, ok := t.chains[c.name]
+		if ok && reflect.DeepEqual(cur.rules, rules) {
+			continue
+		}
+		name := c.name
+		if ok && cur.name == c.name {
+			name = c.name + shadowSuffix
+		}
+		if err := t.prog.EnsureChain(name); err != nil {
+			return err
+		}
+		if err := t.prog.SetRules(name, rules); err != nil {
+			return err
+		}
+		delete(t.retired, name)
+		if ok {
+			t.retired[cur.name] = struct{}{}
+		}
+		t.chains[c.name] = &chainState{name: name, rules: rules}
+	}
+	if err := t.setTop(t.resolve(rs.top)); err != nil {
+		return err
+	}
+	if !t.hooked {
+		if err := t.prog.EnsureJump("FORWARD", TopChain); err != nil {
+			return err
+		}
+		t.hooked = true
+	}
+
+	// Remove the chains swapped out and the ones no longer referenced,
+	// endpoint chains before the exception chains they jump to
+	for name, c := range t.chains {
+		if _, ok := wanted[name]; !ok {
+			t.retired[c.name] = struct{}{}
+			delete(t.chains, name)
+		}
+	}
+	return t.deleteRetired()
+}
+
+// resolve rewrites the jumps of the rules to the chains under their
+// programmed names
+func (t *Translator) resolve(rules [][]string) [][]string {
+	res := make([][]string, 0, len(rules))
+	for _, r := range rules {
+		n := len(r)
+		if n >= 2 && r[n-2] == "-j" {
+			if c, ok := t.chains[r[n-1]]; ok && c.name != r[n-1] {
+				r = append(append([]string{}, r[:n-1]...), c.name)
+			}
+		}
+		res = append(res, r)
+	}
+	return res
+}
+
+// setTop programs the dispatch rules of the endpoints. The rule of an
+// endpoint keeps its position, its jump being replaced at once.
+func (t *Translator) setTop(rules [][]string) error {
+	if !t.topSet {
+		// the chain may hold the rules of a former run
+		if err := t.prog.SetRules(TopChain, rules); err != nil {
+			return err
+		}
+		t.top, t.topSet = rules, true
+		return nil
+	}
+
+	// a direction and an address match per endpoint
+	key := func(r []string) string { return r[0] + " " + r[1] }
+	want := make(map[string][]string, len(rules))
+	for _, r := range rules {
+		want[key(r)] = r
+	}
+	have := make(map[string]bool, len(t.top))
+	for i, r := range t.top {
+		have[key(r)] = true
+		if w, ok := want[key(r)]; ok && !reflect.DeepEqual(w, r) {
+			if err := t.prog.ReplaceRule(TopChain, i+1, w); err != nil {
+				return err
+			}
+			t.top[i] = w
+		}
+	}
+	for _, r := range rules {
+		if !have[key(r)] {
+			if err := t.prog.AppendRule(TopChain, r); err != nil {
+				return err
+			}
+			t.top = append(t.top, r)
+		}
+	}
+	kept := t.top[:0]
+	for i, r := range t.top {
+		if _, ok := want[key(r)]; ok {
+			kept = append(kept, r)
+			continue
+		}
+		if err := t.prog.DeleteRule(TopChain, r); err != nil {
+			t.top = append(kept, t.top[i:]...)
+			return err
+		}
+	}
+	t.top = kept
+	return nil
+}
+
+// deleteRetired removes the retired chains, endpoint chains before the
+// exception chains
+func (t *Translator) deleteRetired() error {
+	var names []string
+	for name := range t.retired {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return isExceptChain(names[j]) && !isExceptChain(names[i])
+	})
+	for _, name := range names {
+		if err := t.prog.DeleteChain(name); err != nil {
+			return err
+		}
+		delete(t.retired, name)
+	}
+	return nil
+}
+
+// Restore programs the policies again from scratch, once the chains got
+// flushed or removed, as on a firewall reload. The chains programmed
+// under another name than the one they get back are removed.
+func (t *Translator) Restore() error {
+	t.Lock()
+	t.top, t.topSet, t.hooked = nil, false, false
+	for name, c := range t.chains {
+		t.retired[c.name] = struct{}{}
+		delete(t.chains, name)
+	}
+	t.Unlock()
+	return t.Sync()
+}
+
+// Close removes all the rules programmed by the translator
+func (t *Translator) Close() error {
+	t.Lock()
+	defer t.Unlock()
+	if t.hooked {
+		if err := t.prog.RemoveJump("FORWARD", TopChain); err != nil {
+			return err
+		}
+		t.hooked = false
+	}
+	if err := t.prog.DeleteChain(TopChain); err != nil {
+		return err
+	}
+	t.top, t.topSet = nil, false
+	for name, c := range t.chains {
+		t.retired[c.name] = struct{}{}
+		delete(t.chains, name)
+	}
+	return t.deleteRetired()
+}
+
+func isExceptChain(name string) bool {
+	return strings.HasPrefix(name, exceptPrefix)
+}
@@ -0,0 +1,172 @@
+// Package netpolicy translates Kubernetes NetworkPolicy objects into
+// iptables filter rules for the endpoints of the local bridge networks.
+//
+// The package does not depend on the Kubernetes client libraries: the
+// policy types below mirror the networking.k8s.io/v1 API, field names and
+// JSON encoding included, and the objects are consumed through the Informer
+// interface, which an adapter around a shared informer can satisfy.
+//
+// The bridge driver runs a Translator when its configuration carries an
+// Informer, the endpoints being selected through their policy namespace
+// and labels options.
+package netpolicy
+
+import (
+	"encoding/json"
+	"net"
+	"strconv"
+)
+
+// PolicyType is the direction of traffic a policy applies to
+type PolicyType string
+
+const (
+	// PolicyTypeIngress selects traffic towards the endpoint
+	PolicyTypeIngress PolicyType = "Ingress"
+	// PolicyTypeEgress selects traffic originated by the endpoint
+	PolicyTypeEgress PolicyType = "Egress"
+)
+
+// Label selector operators
+const (
+	OpIn           = "In"
+	OpNotIn        = "NotIn"
+	OpExists       = "Exists"
+	OpDoesNotExist = "DoesNotExist"
+)
+
+// ObjectMeta is the subset of the Kubernetes object metadata used here
+type ObjectMeta struct {
+	Name      string            `json:"name,omitempty"`
+	Namespace string            `json:"namespace,omitempty"`
+	Labels    map[string]string `json:"labels,omitempty"`
+}
+
+// NetworkPolicy mirrors the networking.k8s.io/v1 NetworkPolicy object
+type NetworkPolicy struct {
+	ObjectMeta `json:"metadata,omitempty"`
+	Spec       NetworkPolicySpec `json:"spec"`
+}
+
+// NetworkPolicySpec describes what a NetworkPolicy selects and allows
+type NetworkPolicySpec struct {
+	PodSelector LabelSelector `json:"podSelector"`
+	Ingress     []IngressRule `json:"ingress,omitempty"`
+	Egress      []EgressRule  `json:"egress,omitempty"`
+	PolicyTypes []PolicyType  `json:"policyTypes,omitempty"`
+}
+
+// IngressRule allows traffic from the listed peers to the listed ports
+type IngressRule struct {
+	Ports []Port `json:"ports,omitempty"`
+	From  []Peer `json:"from,omitempty"`
+}
+
+// EgressRule allows traffic to the listed peers on the listed ports
+type EgressRule struct {
+	Ports []Port `json:"ports,omitempty"`
+	To    []Peer `json:"to,omitempty"`
+}
+
+// Peer identifies the remote side of a rule
+type Peer struct {
+	PodSelector       *LabelSelector `json:"podSelector,omitempty"`
+	NamespaceSelector *LabelSelector `json:"namespaceSelector,omitempty"`
+	IPBlock           *IPBlock       `json:"ipBlock,omitempty"`
+}
+
+// IPBlock selects a CIDR, minus the listed exceptions
+type IPBlock struct {
+	CIDR   string   `json:"cidr"`
+	Except []string `json:"except,omitempty"`
+}
+
+// Port selects a protocol and a port or port range. Named ports are not
+// supported, rules referencing them are ignored.
+type Port struct {
+	Protocol string       `json:"protocol,omitempty"`
+	Port     *IntOrString `json:"port,omitempty"`
+	EndPort  *int32       `json:"endPort,omitempty"`
+}
+
+// IntOrString holds a port number or a port name
+type IntOrString struct {
+	IntVal   int32
+	StrVal   string
+	IsString bool
+}
+
+// MarshalJSON encodes the value the way the Kubernetes API does
+func (v IntOrString) MarshalJSON() ([]byte, error) {
+	if v.IsString {
+		return json.Marshal(v.StrVal)
+	}
+	return json.Marshal(v.IntVal)
+}
+
+// UnmarshalJSON decodes either a number or a string
+func (v *IntOrString) UnmarshalJSON(b []byte) error {
+	if len(b) > 0 && b[0] == '"' {
+		v.IsString = true
+		return json.Unmarshal(b, &v.StrVal)
+	}
+	v.IsString = false
+	return json.Unmarshal(b, &v.IntVal)
+}
+
+func (v IntOrString) String() string {
+	if v.IsString {
+		return v.StrVal
+	}
+	return strconv.Itoa(int(v.IntVal))
+}
+
+// LabelSelector mirrors the Kubernetes label selector. An empty selector
+// matches everything.
+type LabelSelector struct {
+	MatchLabels      map[string]string          `json:"matchLabels,omitempty"`
+	MatchExpressions []LabelSelectorRequirement `json:"matchExpressions,omitempty"`
+}
+
+// LabelSelectorRequirement is a set based selector requirement
+type LabelSelectorRequirement struct {
+	Key      string   `json:"key"`
+	Operator string   `json:"operator"`
+	Values   []string `json:"values,omitempty"`
+}
+
+// Endpoint is a local endpoint policies are enforced on. In Kubernetes
+// terms it is a pod: it lives in a namespace and carries labels.
+type Endpoint struct {
+	ID        string
+	Namespace string
+	Labels    map[string]string
+	IP        net.IP
+}
+
+// EventHandler receives the policy change notifications
+type EventHandler interface {
+	OnAdd(p *NetworkPolicy)
+	OnUpdate(oldP, newP *NetworkPolicy)
+	OnDelete(p *NetworkPolicy)
+}
+
+// Informer is the source of NetworkPolicy objects
+type Informer interface {
+	// AddEventHandler registers a handler for policy changes. Handlers
+	// are invoked for the already known objects as well.
+	AddEventHandler(h EventHandler)
+}
+
+// NamespaceSource provides the labels of the namespaces, which the
+// namespace selectors of the policies match
+type NamespaceSource interface {
+	NamespaceLabels(namespace string) map[string]string
+}
+
+// EndpointSource provides the endpoints policies are enforced on, and the
+// labels of their namespaces
+type EndpointSource interface {
+	NamespaceSource
+	Endpoints() []Endpoint
+}
@@ -0,0 +1,62 @@
+package netutils
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+)
+
+// AddressConflictError is returned when another host answers for an address
+// being configured on an endpoint
+type AddressConflictError struct {
+	IP           net.IP
+	HardwareAddr net.HardwareAddr
+	Interface    string
+}
+
+func (e *AddressConflictError) Error() string {
+	return fmt.Sprintf("address %s is already in use by %s on %s", e.IP, e.HardwareAddr, e.Interface)
+}
+
+// Forbidden denotes the type of this error
+func (e *AddressConflictError) Forbidden() {}
+
+// arpProbe returns the ethernet frame of an ARP probe for the address, the
+// sender address being unspecified as the address is not yet in use
+func arpProbe(mac net.HardwareAddr, ip net.IP) []byte {
+	b := make([]byte, 42)
+	// Ethernet header
+	copy(b[0:6], []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff})
+	copy(b[6:12], mac)
+	binary.BigEndian.PutUint16(b[12:14], 0x0806)
+	// ARP request
+	binary.BigEndian.PutUint16(b[14:16], 1)
+	binary.BigEndian.PutUint16(b[16:18], 0x0800)
+	b[18] = 6
+	b[19] = 4
+	binary.BigEndian.PutUint16(b[20:22], 1)
+	copy(b[22:28], mac)
+	copy(b[38:42], ip.To4())
+	return b
+}
+
+// arpConflict returns the hardware address of the host the ARP frame tells
+// to be using the address, nil when it does not: replies and announcements
+// with the address as the sender, and probes of the address from another
+// host, which is configuring it at the same time
+func arpConflict(frame []byte, mac net.HardwareAddr, ip net.IP) net.HardwareAddr {
+	if len(frame) < 42 || binary.BigEndian.Uint16(frame[12:14]) != 0x0806 ||
+		binary.BigEndian.Uint16(frame[16:18]) != 0x0800 || frame[18] != 6 || frame[19] != 4 {
+		return nil
+	}
+	sender := net.HardwareAddr(frame[22:28])
+	if sender.String() == mac.String() {
+		return nil
+	}
+	senderIP, targetIP := net.IP(frame[28:32]), net.IP(frame[38:42])
+	op := binary.BigEndian.Uint16(frame[20:22])
+	if senderIP.Equal(ip) || (op == 1 && senderIP.Equal(net.IPv4zero) && targetIP.Equal(ip)) {
+		return append(net.HardwareAddr(nil), sender...)
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package netutils
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+	"time"
+)
+
+// dadProbes is the number of ARP probes sent over the detection time
+const dadProbes = 3
+
+// ProbeIPv4 detects another host using the IPv4 address on the link of the
+// interface, sending ARP probes on it and listening for the answers for the
+// timeout. It returns an *AddressConflictError when there is one.
+func ProbeIPv4(ifName string, ip net.IP, timeout time.Duration) error {
+	if ip.To4() == nil {
+		return fmt.Errorf("%s is not an IPv4 address", ip)
+	}
+	iface, err := net.InterfaceByName(ifName)
+	if err != nil {
+		return err
+	}
+	if len(iface.HardwareAddr) != 6 {
+		return fmt.Errorf("interface %s has no ethernet address", ifName)
+	}
+
+	proto := htons(syscall.ETH_P_ARP)
+	fd, err := syscall.Socket(syscall.AF_PACKET, syscall.SOCK_RAW, int(proto))
+	if err != nil {
+		return err
+	}
+	defer syscall.Close(fd)
+	ll := &syscall.SockaddrLinklayer{Protocol: proto, Ifindex: iface.Index}
+	if err := syscall.Bind(fd, ll); err != nil {
+		return err
+	}
+
+	to := &syscall.SockaddrLinklayer{Protocol: proto, Ifindex: iface.Index, Halen: 6}
+	copy(to.Addr[:], []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff})
+	probe := arpProbe(iface.HardwareAddr, ip)
+
+	interval := timeout / dadProbes
+	deadline := time.Now().Add(timeout)
+	next := time.Now()
+	buf := make([]byte, 128)
+	for sent := 0; time.Now().Before(deadline); {
+		if sent < dadProbes && !time.Now().Before(next) {
+			if err := syscall.Sendto(fd, probe, 0, to); err != nil {
+				return fmt.Errorf("failed to send an ARP probe on %s: %v", ifName, err)
+			}
+			sent++
+			next = next.Add(interval)
+		}
+
+		wait := time.Until(deadline)
+		if sent < dadProbes && time.Until(next) < wait {
+			wait = time.Until(next)
+		}
+		if wait < time.Millisecond {
+			wait = time.Millisecond
+		}
+		tv := syscall.NsecToTimeval(wait.Nanoseconds())
+		if err := syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &tv); err != nil {
+			return err
+		}
+		n, _, err := syscall.Recvfrom(fd, buf, 0)
+		if err != nil {
+			if err == syscall.EAGAIN || err == syscall.EINTR {
+				continue
+			}
+			return err
+		}
+		if mac := arpConflict(buf[:n], iface.HardwareAddr, ip); mac != nil {
+			return &AddressConflictError{IP: ip, HardwareAddr: mac, Interface: ifName}
+		}
+	}
+	return nil
+}
+
+func htons(v uint16) uint16 {
+	return v<<8 | v>>8
+}
@@ -0,0 +1,45 @@
+package netutils
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+
+	"github.com/docker/libnetwork/types"
+)
+
+func TestARPConflict(t *testing.T) {
+	own, _ := net.ParseMAC("02:42:ac:11:00:02")
+	other, _ := net.ParseMAC("02:42:ac:11:00:03")
+	ip := net.ParseIP("192.168.10.5").To4()
+
+	if arpConflict(arpProbe(own, ip), own, ip) != nil {
+		t.Fatal("own probe taken for a conflict")
+	}
+	if mac := arpConflict(arpProbe(other, ip), own, ip); mac.String() != other.String() {
+		t.Fatalf("probe of another host not taken for a conflict: %v", mac)
+	}
+
+	reply := arpProbe(other, ip)
+	binary.BigEndian.PutUint16(reply[20:22], 2)
+	copy(reply[28:32], ip)
+	copy(reply[38:42], net.IPv4zero.To4())
+	if mac := arpConflict(reply, own, ip); mac.String() != other.String() {
+		t.Fatalf("reply of another host not taken for a conflict: %v", mac)
+	}
+	copy(reply[28:32], net.ParseIP("192.168.10.6").To4())
+	if arpConflict(reply, own, ip) != nil {
+		t.Fatal("reply for another address taken for a conflict")
+	}
+	if arpConflict(reply[:30], own, ip) != nil {
+		t.Fatal("truncated frame taken for a conflict")
+	}
+
+	var err error = &AddressConflictError{IP: ip, HardwareAddr: other, Interface: "eth0"}
+	if _, ok := err.(types.ForbiddenError); !ok {
+		t.Fatal("address conflict is not a forbidden error")
+	}
+	if err.Error() != "address 192.168.10.5 is already in use by 02:42:ac:11:00:03 on eth0" {
+		t.Fatalf("unexpected message %q", err)
+	}
+}
@@ -0,0 +1,21 @@
+package netutils
+
+import "net"
+
+// NAT64Address returns the IPv6 address of the /96 NAT64 prefix embedding
+// the IPv4 address, as in RFC 6052
+func NAT64Address(prefix *net.IPNet, ip net.IP) net.IP {
+	addr := make(net.IP, net.IPv6len)
+	copy(addr, prefix.IP.Mask(prefix.Mask).To16())
+	copy(addr[12:], ip.To4())
+	return addr
+}
+
+// NAT64Embedded returns the IPv4 address embedded in the IPv6 address of
+// the /96 NAT64 prefix, nil when the address is not in the prefix
+func NAT64Embedded(prefix *net.IPNet, ip net.IP) net.IP {
+	if ip.To4() != nil || !prefix.Contains(ip) {
+		return nil
+	}
+	return net.IPv4(ip[12], ip[13], ip[14], ip[15]).To4()
+}
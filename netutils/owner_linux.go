@@ -0,0 +1,65 @@
+package netutils
+
+import (
+	"sync"
+
+	"github.com/vishvananda/netlink"
+)
+
+// OwnedRouteProtocol is the protocol of the host routes libnetwork adds,
+// which tells them apart from the routes of the other owners of the host
+const OwnedRouteProtocol = 76
+
+// ownedRoutes holds the owned routes added by this process, the other
+// routes bearing OwnedRouteProtocol were left behind by a previous one
+var ownedRoutes = struct {
+	sync.Mutex
+	m map[string]struct{}
+}{m: make(map[string]struct{})}
+
+func ownedRouteKey(r *netlink.Route) string {
+	dst := "default"
+	if r.Dst != nil {
+		dst = r.Dst.String()
+	}
+	return dst + " via " + r.Gw.String()
+}
+
+// OwnRoute tags the route for it to be added as an owned route. The
+// deletions leave the protocol unset, which matches the routes added
+// untagged as well.
+func OwnRoute(r *netlink.Route) *netlink.Route {
+	owned := *r
+	owned.Protocol = OwnedRouteProtocol
+	ownedRoutes.Lock()
+	ownedRoutes.m[ownedRouteKey(r)] = struct{}{}
+	ownedRoutes.Unlock()
+	return &owned
+}
+
+// DisownRoute forgets the owned route on its deletion
+func DisownRoute(r *netlink.Route) {
+	ownedRoutes.Lock()
+	delete(ownedRoutes.m, ownedRouteKey(r))
+	ownedRoutes.Unlock()
+}
+
+// OrphanRoutes lists the host routes bearing OwnedRouteProtocol which were
+// not added by this process
+func OrphanRoutes(nlh *netlink.Handle) ([]netlink.Route, error) {
+	filter := &netlink.Route{Protocol: OwnedRouteProtocol}
+	routes, err := nlh.RouteListFiltered(netlink.FAMILY_ALL, filter, netlink.RT_FILTER_PROTOCOL)
+	if err != nil {
+		return nil, err
+	}
+
+	ownedRoutes.Lock()
+	defer ownedRoutes.Unlock()
+	var orphans []netlink.Route
+	for _, r := range routes {
+		if _, ok := ownedRoutes.m[ownedRouteKey(&r)]; !ok {
+			orphans = append(orphans, r)
+		}
+	}
+	return orphans, nil
+}
@@ -4,6 +4,7 @@ package netutils
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/hex"
 	"errors"
 	"fmt"
@@ -124,6 +125,17 @@ func GenerateMACFromIP(ip net.IP) net.HardwareAddr {
 	return genMAC(ip)
 }
 
+// GenerateMACFromKey returns a locally administered MAC address derived
+// from the key, another one for each attempt. The first byte tells them
+// apart from the addresses of GenerateMACFromIP and GenerateRandomMAC.
+func GenerateMACFromKey(key string, attempt int) net.HardwareAddr {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s/%d", key, attempt)))
+	hw := make(net.HardwareAddr, 6)
+	hw[0] = 0x06
+	copy(hw[1:], sum[:5])
+	return hw
+}
+
 // GenerateRandomName returns a new name joined with a prefix.  This size
 // specified is used to truncate the randomly generated value
 func GenerateRandomName(prefix string, size int) (string, error) {
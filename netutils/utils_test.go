@@ -210,6 +210,20 @@ func TestUtilGenerateRandomMAC(t *testing.T) {
 	}
 }
 
+func TestGenerateMACFromKey(t *testing.T) {
+	mac := GenerateMACFromKey("db-1", 0)
+	if !bytes.Equal(mac, GenerateMACFromKey("db-1", 0)) {
+		t.Fatalf("mac %s not stable", mac)
+	}
+	if bytes.Equal(mac, GenerateMACFromKey("db-1", 1)) || bytes.Equal(mac, GenerateMACFromKey("db-2", 0)) {
+		t.Fatalf("mac %s not distinct", mac)
+	}
+	// Locally administered unicast address
+	if mac[0]&0x03 != 0x02 {
+		t.Fatalf("unexpected first byte of mac %s", mac)
+	}
+}
+
 func TestNetworkRequest(t *testing.T) {
 	defer testutils.SetupTestOSContext(t)()
 
@@ -350,3 +364,19 @@ func createInterface(t *testing.T, name string, nws ...string) {
 		t.Fatal(err)
 	}
 }
+
+func TestNAT64Address(t *testing.T) {
+	_, prefix, _ := net.ParseCIDR("64:ff9b::/96")
+	ip := NAT64Address(prefix, net.ParseIP("192.0.2.33"))
+	if !ip.Equal(net.ParseIP("64:ff9b::c000:221")) {
+		t.Fatalf("unexpected NAT64 address %s", ip)
+	}
+	if v4 := NAT64Embedded(prefix, ip); !v4.Equal(net.ParseIP("192.0.2.33")) {
+		t.Fatalf("unexpected embedded address %s", v4)
+	}
+	for _, other := range []string{"2001:db8::c000:221", "192.0.2.33"} {
+		if v4 := NAT64Embedded(prefix, net.ParseIP(other)); v4 != nil {
+			t.Fatalf("address %s embedded in %s", v4, other)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package libnetwork
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net"
@@ -9,12 +10,15 @@ import (
 	"time"
 
 	"github.com/docker/docker/pkg/stringid"
+	"github.com/docker/libnetwork/authz"
 	"github.com/docker/libnetwork/config"
 	"github.com/docker/libnetwork/datastore"
 	"github.com/docker/libnetwork/driverapi"
+	"github.com/docker/libnetwork/ephook"
 	"github.com/docker/libnetwork/etchosts"
 	"github.com/docker/libnetwork/internal/setmatrix"
 	"github.com/docker/libnetwork/ipamapi"
+	"github.com/docker/libnetwork/journal"
 	"github.com/docker/libnetwork/netlabel"
 	"github.com/docker/libnetwork/netutils"
 	"github.com/docker/libnetwork/networkdb"
@@ -54,6 +58,22 @@ type Network interface {
 	// EndpointByID returns the Endpoint which has the passed id. If not found, the error ErrNoSuchEndpoint is returned.
 	EndpointByID(id string) (Endpoint, error)
 
+	// AddSubnet allocates an additional IPv4 or IPv6 pool to the network
+	// and has the driver program it. Endpoints are allocated from it once
+	// the previous pools are exhausted.
+	AddSubnet(cfg *IpamConf, v6 bool) error
+
+	// SetDeletionProtection protects the network from deletion, or lifts
+	// its protection
+	SetDeletionProtection(protected bool) error
+
+	// AddFinalizer registers an external dependency of the network under
+	// the name, the network not being deleted until it is removed
+	AddFinalizer(name string) error
+
+	// RemoveFinalizer unregisters the external dependency of the network
+	RemoveFinalizer(name string) error
+
 	// Return certain operational data belonging to this network
 	Info() NetworkInfo
 }
@@ -65,6 +85,8 @@ type NetworkInfo interface {
 	DriverOptions() map[string]string
 	Scope() string
 	IPv6Enabled() bool
+	// IPv6Only tells if the network allocates no IPv4 address
+	IPv6Only() bool
 	Internal() bool
 	Attachable() bool
 	Ingress() bool
@@ -73,6 +95,13 @@ type NetworkInfo interface {
 	Labels() map[string]string
 	Dynamic() bool
 	Created() time.Time
+	// DeletionProtected tells if the network is protected from deletion
+	DeletionProtected() bool
+	// Finalizers returns the external dependencies holding the network off
+	// deletion
+	Finalizers() []string
+	// Degraded tells if a probed next hop of the network is down
+	Degraded() bool
 	// Peers returns a slice of PeerInfo structures which has the information about the peer
 	// nodes participating in the same overlay network. This is currently the per-network
 	// gossip cluster. For non-dynamic overlay networks and bridge networks it returns an
@@ -225,6 +254,7 @@ type network struct {
 	resolverOnce     sync.Once
 	resolver         []Resolver
 	internal         bool
+	ipv6Only         bool
 	attachable       bool
 	inDelete         bool
 	ingress          bool
@@ -234,12 +264,20 @@ type network struct {
 	configFrom       string
 	loadBalancerIP   net.IP
 	loadBalancerMode string
+	dsrVIPs          []net.IP
+	gwPriority       int
+	scopedDNS        bool
+	routeMetric      int
+	idempotencyKey   string
+	protected        bool
+	finalizers       []string
 	sync.Mutex
 }
 
 const (
 	loadBalancerModeNAT     = "NAT"
 	loadBalancerModeDSR     = "DSR"
+	loadBalancerModeDSRTun  = "DSR-TUN"
 	loadBalancerModeDefault = loadBalancerModeNAT
 )
 
@@ -379,13 +417,31 @@ func (n *network) validateConfiguration() error {
 				"[ ingress | internal | attachable | scope ] are not supported.")
 		}
 	}
+	if err := n.validateIPv6AddressMode(); err != nil {
+		return err
+	}
+	if err := n.validateTCPMSS(); err != nil {
+		return err
+	}
+	if err := n.validateTCPKeepalive(); err != nil {
+		return err
+	}
+	if err := n.validateFlowExport(); err != nil {
+		return err
+	}
+	if err := n.validateDNSOptions(); err != nil {
+		return err
+	}
+	if err := n.validateIPv6Only(); err != nil {
+		return err
+	}
 	if n.configFrom != "" {
 		if n.configOnly {
 			return types.ForbiddenErrorf("a configuration network cannot depend on another configuration network")
 		}
 		if n.ipamType != "" &&
 			n.ipamType != defaultIpamForNetworkType(n.networkType) ||
-			n.enableIPv6 ||
+			n.enableIPv6 || n.ipv6Only ||
 			len(n.labels) > 0 || len(n.ipamOptions) > 0 ||
 			len(n.ipamV4Config) > 0 || len(n.ipamV6Config) > 0 {
 			return types.ForbiddenErrorf("user specified configurations are not supported if the network depends on a configuration network")
@@ -419,6 +475,7 @@ func (n *network) validateConfiguration() error {
 // Applies network specific configurations
 func (n *network) applyConfigurationTo(to *network) error {
 	to.enableIPv6 = n.enableIPv6
+	to.ipv6Only = n.ipv6Only
 	if len(n.labels) > 0 {
 		to.labels = make(map[string]string, len(n.labels))
 		for k, v := range n.labels {
@@ -474,6 +531,7 @@ func (n *network) CopyTo(o datastore.KVObject) error {
 	dstN.dbExists = n.dbExists
 	dstN.drvOnce = n.drvOnce
 	dstN.internal = n.internal
+	dstN.ipv6Only = n.ipv6Only
 	dstN.attachable = n.attachable
 	dstN.inDelete = n.inDelete
 	dstN.ingress = n.ingress
@@ -481,6 +539,13 @@ func (n *network) CopyTo(o datastore.KVObject) error {
 	dstN.configFrom = n.configFrom
 	dstN.loadBalancerIP = n.loadBalancerIP
 	dstN.loadBalancerMode = n.loadBalancerMode
+	dstN.dsrVIPs = append([]net.IP(nil), n.dsrVIPs...)
+	dstN.gwPriority = n.gwPriority
+	dstN.scopedDNS = n.scopedDNS
+	dstN.routeMetric = n.routeMetric
+	dstN.idempotencyKey = n.idempotencyKey
+	dstN.protected = n.protected
+	dstN.finalizers = append([]string(nil), n.finalizers...)
 
 	// copy labels
 	if dstN.labels == nil {
@@ -592,13 +657,27 @@ func (n *network) MarshalJSON() ([]byte, error) {
 		netMap["ipamV6Info"] = string(iis)
 	}
 	netMap["internal"] = n.internal
+	netMap["ipv6Only"] = n.ipv6Only
 	netMap["attachable"] = n.attachable
 	netMap["inDelete"] = n.inDelete
 	netMap["ingress"] = n.ingress
 	netMap["configOnly"] = n.configOnly
 	netMap["configFrom"] = n.configFrom
+	netMap["gwPriority"] = n.gwPriority
+	netMap["scopedDNS"] = n.scopedDNS
+	netMap["routeMetric"] = n.routeMetric
+	if n.idempotencyKey != "" {
+		netMap["idempotencyKey"] = n.idempotencyKey
+	}
+	netMap["protected"] = n.protected
+	if len(n.finalizers) > 0 {
+		netMap["finalizers"] = n.finalizers
+	}
 	netMap["loadBalancerIP"] = n.loadBalancerIP
 	netMap["loadBalancerMode"] = n.loadBalancerMode
+	if len(n.dsrVIPs) > 0 {
+		netMap["dsrVIPs"] = n.dsrVIPs
+	}
 	return json.Marshal(netMap)
 }
 
@@ -691,6 +770,9 @@ func (n *network) UnmarshalJSON(b []byte) (err error) {
 	if v, ok := netMap["internal"]; ok {
 		n.internal = v.(bool)
 	}
+	if v, ok := netMap["ipv6Only"]; ok {
+		n.ipv6Only = v.(bool)
+	}
 	if v, ok := netMap["attachable"]; ok {
 		n.attachable = v.(bool)
 	}
@@ -709,6 +791,26 @@ func (n *network) UnmarshalJSON(b []byte) (err error) {
 	if v, ok := netMap["configFrom"]; ok {
 		n.configFrom = v.(string)
 	}
+	if v, ok := netMap["gwPriority"]; ok {
+		n.gwPriority = int(v.(float64))
+	}
+	if v, ok := netMap["scopedDNS"]; ok {
+		n.scopedDNS = v.(bool)
+	}
+	if v, ok := netMap["routeMetric"]; ok {
+		n.routeMetric = int(v.(float64))
+	}
+	if v, ok := netMap["idempotencyKey"]; ok {
+		n.idempotencyKey = v.(string)
+	}
+	if v, ok := netMap["protected"]; ok {
+		n.protected = v.(bool)
+	}
+	if v, ok := netMap["finalizers"]; ok {
+		for _, f := range v.([]interface{}) {
+			n.finalizers = append(n.finalizers, f.(string))
+		}
+	}
 	if v, ok := netMap["loadBalancerIP"]; ok {
 		n.loadBalancerIP = net.ParseIP(v.(string))
 	}
@@ -716,6 +818,11 @@ func (n *network) UnmarshalJSON(b []byte) (err error) {
 	if v, ok := netMap["loadBalancerMode"]; ok {
 		n.loadBalancerMode = v.(string)
 	}
+	if v, ok := netMap["dsrVIPs"]; ok {
+		for _, ip := range v.([]interface{}) {
+			n.dsrVIPs = append(n.dsrVIPs, net.ParseIP(ip.(string)))
+		}
+	}
 	// Reconcile old networks with the recently added `--ipv6` flag
 	if !n.enableIPv6 {
 		n.enableIPv6 = len(n.ipamV6Info) > 0
@@ -741,6 +848,9 @@ func NetworkOptionGeneric(generic map[string]interface{}) NetworkOption {
 		if val, ok := generic[netlabel.Internal]; ok {
 			n.internal = val.(bool)
 		}
+		if val, ok := generic[netlabel.IPv6Only]; ok {
+			n.ipv6Only = val.(bool)
+		}
 		for k, v := range generic {
 			n.generic[k] = v
 		}
@@ -785,6 +895,20 @@ func NetworkOptionInternalNetwork() NetworkOption {
 	}
 }
 
+// NetworkOptionIPv6Only returns an option setter to config the network to
+// allocate no IPv4 address, IPv6 being enabled on it
+func NetworkOptionIPv6Only() NetworkOption {
+	return func(n *network) {
+		if n.generic == nil {
+			n.generic = make(map[string]interface{})
+		}
+		n.ipv6Only = true
+		n.enableIPv6 = true
+		n.generic[netlabel.IPv6Only] = true
+		n.generic[netlabel.EnableIPv6] = true
+	}
+}
+
 // NetworkOptionAttachable returns an option setter to set attachable for a network
 func NetworkOptionAttachable(attachable bool) NetworkOption {
 	return func(n *network) {
@@ -870,6 +994,43 @@ func NetworkOptionConfigOnly() NetworkOption {
 	}
 }
 
+// NetworkOptionGatewayPriority sets the priority the endpoints of the network
+// get in the sandboxes they join when JoinOptionPriority is not passed. The
+// sandbox default gateway is provided by the endpoint with the highest
+// priority having a gateway.
+func NetworkOptionGatewayPriority(prio int) NetworkOption {
+	return func(n *network) {
+		n.gwPriority = prio
+	}
+}
+
+// NetworkOptionScopedResolution scopes the names of the network to it: the
+// embedded DNS server of a sandbox only resolves them when they are
+// qualified with the network name, as in "name.network".
+func NetworkOptionScopedResolution() NetworkOption {
+	return func(n *network) {
+		n.scopedDNS = true
+	}
+}
+
+// NetworkOptionRouteMetric sets the metric of the next hop routes the
+// endpoints of the network install in the sandboxes they join, so that
+// they do not shadow the routes of the other networks of the sandbox.
+func NetworkOptionRouteMetric(metric int) NetworkOption {
+	return func(n *network) {
+		n.routeMetric = metric
+	}
+}
+
+// NetworkOptionIdempotencyKey sets the key identifying the creation of the
+// network across the retries of the caller: a creation with the key of a
+// network created before returns that network.
+func NetworkOptionIdempotencyKey(key string) NetworkOption {
+	return func(n *network) {
+		n.idempotencyKey = key
+	}
+}
+
 // NetworkOptionConfigFrom tells controller to pick the
 // network configuration from a configuration only network
 func NetworkOptionConfigFrom(name string) NetworkOption {
@@ -976,6 +1137,9 @@ func (n *network) Delete(options ...NetworkDeleteOption) error {
 	for _, opt := range options {
 		opt(&params)
 	}
+	if err := n.getController().authorize(n.authzRequest(authz.NetworkDelete)); err != nil {
+		return err
+	}
 	return n.delete(false, params.rmLBEndpoint)
 }
 
@@ -1002,6 +1166,14 @@ func (n *network) delete(force bool, rmLBEndpoint bool) error {
 		return &UnknownNetworkError{name: name, id: id}
 	}
 
+	// The protected networks and the ones with finalizers are kept but for
+	// the forced removals
+	if !force {
+		if err := n.checkDeletable(); err != nil {
+			return err
+		}
+	}
+
 	// Only remove ingress on force removal or explicit LB endpoint removal
 	if n.ingress && !force && !rmLBEndpoint {
 		return &ActiveEndpointsError{name: n.name, id: n.id}
@@ -1087,6 +1259,9 @@ func (n *network) delete(force bool, rmLBEndpoint bool) error {
 
 	// Cleanup the service discovery for this network
 	c.cleanupServiceDiscovery(n.ID())
+	c.forgetFilterRollout(n.ID())
+	c.forgetExternalEndpoints(n)
+	c.forgetMACAssignments(n)
 
 removeFromStore:
 	// deleteFromStore performs an atomic delete operation and the
@@ -1103,6 +1278,10 @@ removeFromStore:
 		return fmt.Errorf("error deleting network from store: %v", err)
 	}
 
+	c.applyInterNetworkPolicies()
+	c.publish(Event{Type: EventNetworkDelete, NetworkID: n.ID(), NetworkName: n.Name()})
+	c.journalNetwork(journal.NetworkDelete, n)
+
 	return nil
 }
 
@@ -1135,7 +1314,14 @@ func (n *network) addEndpoint(ep *endpoint) error {
 		return fmt.Errorf("failed to add endpoint: %v", err)
 	}
 
-	err = d.CreateEndpoint(n.id, ep.id, ep.Interface(), ep.generic)
+	// The drivers get the name along the options, which are not modified
+	generic := make(map[string]interface{}, len(ep.generic)+1)
+	for k, v := range ep.generic {
+		generic[k] = v
+	}
+	generic[netlabel.EndpointName] = ep.Name()
+
+	err = d.CreateEndpoint(n.id, ep.id, ep.Interface(), generic)
 	if err != nil {
 		return types.InternalErrorf("failed to create endpoint %s on network %s: %v",
 			ep.Name(), n.Name(), err)
@@ -1145,6 +1331,31 @@ func (n *network) addEndpoint(ep *endpoint) error {
 }
 
 func (n *network) CreateEndpoint(name string, options ...EndpointOption) (Endpoint, error) {
+	key := endpointIdempotencyKey(options)
+	if key == "" {
+		return n.newEndpoint(name, options...)
+	}
+	prev, finish := n.getController().claimIdempotencyKey("endpoint/"+n.ID()+"/"+key, func() string {
+		return n.endpointIDByIdempotencyKey(key)
+	})
+	if prev != "" {
+		logrus.Debugf("Endpoint creation with idempotency key %s returns endpoint %.7s", key, prev)
+		return n.EndpointByID(prev)
+	}
+	ep, err := n.newEndpoint(name, options...)
+	if err != nil {
+		finish("", err)
+		return nil, err
+	}
+	finish(ep.ID(), nil)
+	return ep, nil
+}
+
+func (n *network) newEndpoint(name string, options ...EndpointOption) (Endpoint, error) {
+	defer n.getController().opTracer.start(opEndpointCreate)()
+	t := n.getController().startOpTiming(opEndpointCreate, name)
+	defer t.end()
+
 	var err error
 	if !config.IsValidName(name) {
 		return nil, ErrInvalidName(name)
@@ -1158,24 +1369,36 @@ func (n *network) CreateEndpoint(name string, options ...EndpointOption) (Endpoi
 		return nil, types.ForbiddenErrorf("endpoint with name %s already exists in network %s", name, n.Name())
 	}
 
+	req := n.authzRequest(authz.EndpointCreate)
+	req.Endpoint = name
+	if err = n.getController().authorize(req); err != nil {
+		return nil, err
+	}
+
+	done := t.TimeStep("lock")
 	n.ctrlr.networkLocker.Lock(n.id)
+	done()
 	defer n.ctrlr.networkLocker.Unlock(n.id)
 
-	return n.createEndpoint(name, options...)
+	return n.createEndpoint(t, name, options...)
 
 }
 
-func (n *network) createEndpoint(name string, options ...EndpointOption) (Endpoint, error) {
+// createEndpoint creates the endpoint, timing its steps on t which may be
+// nil. The driver times its own steps on the InterfaceInfo.
+func (n *network) createEndpoint(t *opTiming, name string, options ...EndpointOption) (Endpoint, error) {
 	var err error
 
-	ep := &endpoint{name: name, generic: make(map[string]interface{}), iface: &endpointInterface{}}
+	ep := &endpoint{name: name, generic: make(map[string]interface{}), iface: &endpointInterface{timing: t}}
 	ep.id = stringid.GenerateRandomID()
 
 	// Initialize ep.network with a possibly stale copy of n. We need this to get network from
 	// store. But once we get it from store we will have the most uptodate copy possibly.
 	ep.network = n
 	ep.locator = n.getController().clusterHostID()
+	done := t.TimeStep("store")
 	ep.network, err = ep.getNetworkFromStore()
+	done()
 	if err != nil {
 		return nil, fmt.Errorf("failed to get network during CreateEndpoint: %v", err)
 	}
@@ -1183,6 +1406,16 @@ func (n *network) createEndpoint(name string, options ...EndpointOption) (Endpoi
 
 	ep.processOptions(options...)
 
+	if err = validateStaticRoutes(ep.staticRoutes); err != nil {
+		return nil, err
+	}
+
+	if max := n.getController().Config().Daemon.MaxEndpointsPerNetwork; max > 0 && !ep.loadBalancer {
+		if cnt := n.getEpCnt().EndpointCnt(); cnt >= uint64(max) {
+			return nil, &QuotaExceededError{Resource: "endpoints", Scope: fmt.Sprintf("network %s", n.Name()), Limit: max}
+		}
+	}
+
 	for _, llIPNet := range ep.Iface().LinkLocalAddresses() {
 		if !llIPNet.IP.IsLinkLocalUnicast() {
 			return nil, types.BadRequestErrorf("invalid link local IP address: %v", llIPNet.IP)
@@ -1200,6 +1433,16 @@ func (n *network) createEndpoint(name string, options ...EndpointOption) (Endpoi
 		return nil, err
 	}
 
+	if ep.stableMAC && ep.iface.mac == nil {
+		if ep.macKey != "" {
+			if ep.iface.mac, err = n.getController().assignStableMAC(n, ep.macKey); err != nil {
+				return nil, err
+			}
+		} else if cap.RequiresMACAddress {
+			return nil, types.BadRequestErrorf("the MAC address of endpoint %s can not be derived from its address, the IPAM driver of network %s requires it first", ep.Name(), n.Name())
+		}
+	}
+
 	if cap.RequiresMACAddress {
 		if ep.iface.mac == nil {
 			ep.iface.mac = netutils.GenerateRandomMAC()
@@ -1210,16 +1453,32 @@ func (n *network) createEndpoint(name string, options ...EndpointOption) (Endpoi
 		ep.ipamOptions[netlabel.MacAddress] = ep.iface.mac.String()
 	}
 
-	if err = ep.assignAddress(ipam, true, n.enableIPv6 && !n.postIPv6); err != nil {
+	done = t.TimeStep("ipam")
+	err = ep.assignAddress(ipam, true, n.enableIPv6 && !n.postIPv6)
+	done()
+	if err != nil {
 		return nil, err
 	}
+	if ep.stableMAC && ep.iface.mac == nil && ep.iface.addr != nil {
+		ep.iface.mac = netutils.GenerateMACFromIP(ep.iface.addr.IP)
+	}
 	defer func() {
 		if err != nil {
 			ep.releaseAddress()
 		}
 	}()
 
-	if err = n.addEndpoint(ep); err != nil {
+	done = t.TimeStep("throttle")
+	release, err := n.getController().acquireDriverOp(context.Background(), opEndpointCreate)
+	done()
+	if err != nil {
+		return nil, err
+	}
+	done = t.TimeStep("driver")
+	err = n.addEndpoint(ep)
+	done()
+	release()
+	if err != nil {
 		return nil, err
 	}
 	defer func() {
@@ -1232,7 +1491,10 @@ func (n *network) createEndpoint(name string, options ...EndpointOption) (Endpoi
 
 	// We should perform updateToStore call right after addEndpoint
 	// in order to have iface properly configured
-	if err = n.getController().updateToStore(ep); err != nil {
+	done = t.TimeStep("store")
+	err = n.getController().updateToStore(ep)
+	done()
+	if err != nil {
 		return nil, err
 	}
 	defer func() {
@@ -1243,7 +1505,10 @@ func (n *network) createEndpoint(name string, options ...EndpointOption) (Endpoi
 		}
 	}()
 
-	if err = ep.assignAddress(ipam, false, n.enableIPv6 && n.postIPv6); err != nil {
+	done = t.TimeStep("ipam")
+	err = ep.assignAddress(ipam, false, n.enableIPv6 && n.postIPv6)
+	done()
+	if err != nil {
 		return nil, err
 	}
 
@@ -1255,11 +1520,21 @@ func (n *network) createEndpoint(name string, options ...EndpointOption) (Endpoi
 		}
 	}()
 
+	done = t.TimeStep("hooks")
+	err = n.getController().runEndpointHooks(context.Background(), ephook.PhaseCreate, n, ep, nil)
+	done()
+	if err != nil {
+		return nil, err
+	}
+
 	// Increment endpoint count to indicate completion of endpoint addition
 	if err = n.getEpCnt().IncEndpointCnt(); err != nil {
 		return nil, err
 	}
 
+	n.getController().applyFilterPolicy(n, ep)
+	n.getController().journalEndpoint(journal.EndpointCreate, n, ep, nil)
+
 	return ep, nil
 }
 
@@ -1323,10 +1598,13 @@ func (n *network) EndpointByID(id string) (Endpoint, error) {
 }
 
 func (n *network) updateSvcRecord(ep *endpoint, localEps []*endpoint, isAdd bool) {
-	var ipv6 net.IP
+	var ip, ipv6 net.IP
 	epName := ep.Name()
-	if iface := ep.Iface(); iface.Address() != nil {
+	if iface := ep.Iface(); iface.Address() != nil || iface.AddressIPv6() != nil {
 		myAliases := ep.MyAliases()
+		if iface.Address() != nil {
+			ip = iface.Address().IP
+		}
 		if iface.AddressIPv6() != nil {
 			ipv6 = iface.AddressIPv6().IP
 		}
@@ -1341,24 +1619,24 @@ func (n *network) updateSvcRecord(ep *endpoint, localEps []*endpoint, isAdd bool
 			// breaks some apps
 			if ep.isAnonymous() {
 				if len(myAliases) > 0 {
-					n.addSvcRecords(ep.ID(), myAliases[0], serviceID, iface.Address().IP, ipv6, true, "updateSvcRecord")
+					n.addSvcRecords(ep.ID(), myAliases[0], serviceID, ip, ipv6, true, "updateSvcRecord")
 				}
 			} else {
-				n.addSvcRecords(ep.ID(), epName, serviceID, iface.Address().IP, ipv6, true, "updateSvcRecord")
+				n.addSvcRecords(ep.ID(), epName, serviceID, ip, ipv6, true, "updateSvcRecord")
 			}
 			for _, alias := range myAliases {
-				n.addSvcRecords(ep.ID(), alias, serviceID, iface.Address().IP, ipv6, false, "updateSvcRecord")
+				n.addSvcRecords(ep.ID(), alias, serviceID, ip, ipv6, false, "updateSvcRecord")
 			}
 		} else {
 			if ep.isAnonymous() {
 				if len(myAliases) > 0 {
-					n.deleteSvcRecords(ep.ID(), myAliases[0], serviceID, iface.Address().IP, ipv6, true, "updateSvcRecord")
+					n.deleteSvcRecords(ep.ID(), myAliases[0], serviceID, ip, ipv6, true, "updateSvcRecord")
 				}
 			} else {
-				n.deleteSvcRecords(ep.ID(), epName, serviceID, iface.Address().IP, ipv6, true, "updateSvcRecord")
+				n.deleteSvcRecords(ep.ID(), epName, serviceID, ip, ipv6, true, "updateSvcRecord")
 			}
 			for _, alias := range myAliases {
-				n.deleteSvcRecords(ep.ID(), alias, serviceID, iface.Address().IP, ipv6, false, "updateSvcRecord")
+				n.deleteSvcRecords(ep.ID(), alias, serviceID, ip, ipv6, false, "updateSvcRecord")
 			}
 		}
 	}
@@ -1418,13 +1696,17 @@ func (n *network) addSvcRecords(eID, name, serviceID string, epIP, epIPv6 net.IP
 	}
 
 	if ipMapUpdate {
-		addIPToName(sr.ipMap, name, serviceID, epIP)
+		if epIP != nil {
+			addIPToName(sr.ipMap, name, serviceID, epIP)
+		}
 		if epIPv6 != nil {
 			addIPToName(sr.ipMap, name, serviceID, epIPv6)
 		}
 	}
 
-	addNameToIP(sr.svcMap, name, serviceID, epIP)
+	if epIP != nil {
+		addNameToIP(sr.svcMap, name, serviceID, epIP)
+	}
 	if epIPv6 != nil {
 		addNameToIP(sr.svcIPv6Map, name, serviceID, epIPv6)
 	}
@@ -1449,14 +1731,18 @@ func (n *network) deleteSvcRecords(eID, name, serviceID string, epIP net.IP, epI
 	}
 
 	if ipMapUpdate {
-		delIPToName(sr.ipMap, name, serviceID, epIP)
+		if epIP != nil {
+			delIPToName(sr.ipMap, name, serviceID, epIP)
+		}
 
 		if epIPv6 != nil {
 			delIPToName(sr.ipMap, name, serviceID, epIPv6)
 		}
 	}
 
-	delNameToIP(sr.svcMap, name, serviceID, epIP)
+	if epIP != nil {
+		delNameToIP(sr.svcMap, name, serviceID, epIP)
+	}
 
 	if epIPv6 != nil {
 		delNameToIP(sr.svcIPv6Map, name, serviceID, epIPv6)
@@ -1530,21 +1816,28 @@ func (n *network) ipamAllocate() error {
 		}
 	}
 
-	err = n.ipamAllocateVersion(4, ipam)
-	if err != nil {
-		return err
-	}
-
-	defer func() {
+	if !n.ipv6Only {
+		err = n.ipamAllocateVersion(4, ipam)
 		if err != nil {
-			n.ipamReleaseVersion(4, ipam)
+			return err
 		}
-	}()
+
+		defer func() {
+			if err != nil {
+				n.ipamReleaseVersion(4, ipam)
+			}
+		}()
+	}
 
 	if !n.enableIPv6 {
 		return nil
 	}
 
+	if n.carvesULASubnet() {
+		err = n.ipamAllocateULA(ipam)
+		return err
+	}
+
 	err = n.ipamAllocateVersion(6, ipam)
 	return err
 }
@@ -1612,70 +1905,92 @@ func (n *network) ipamAllocateVersion(ipVer int, ipam ipamapi.Ipam) error {
 		*cfgList = []*IpamConf{{}}
 	}
 
-	*infoList = make([]*IpamInfo, len(*cfgList))
+	*infoList = make([]*IpamInfo, 0, len(*cfgList))
 
 	logrus.Debugf("Allocating IPv%d pools for network %s (%s)", ipVer, n.Name(), n.ID())
 
-	for i, cfg := range *cfgList {
-		if err = cfg.Validate(); err != nil {
-			return err
+	defer func() {
+		if err != nil {
+			for _, d := range *infoList {
+				n.ipamReleasePool(ipam, d)
+			}
+			*infoList = nil
 		}
-		d := &IpamInfo{}
-		(*infoList)[i] = d
+	}()
 
-		d.AddressSpace = n.addrSpace
-		d.PoolID, d.Pool, d.Meta, err = n.requestPoolHelper(ipam, n.addrSpace, cfg.PreferredPool, cfg.SubPool, n.ipamOptions, ipVer == 6)
-		if err != nil {
+	for _, cfg := range *cfgList {
+		var d *IpamInfo
+		if d, err = n.ipamAllocatePool(ipam, ipVer, cfg); err != nil {
 			return err
 		}
+		*infoList = append(*infoList, d)
+	}
 
-		defer func() {
-			if err != nil {
-				if err := ipam.ReleasePool(d.PoolID); err != nil {
-					logrus.Warnf("Failed to release address pool %s after failure to create network %s (%s)", d.PoolID, n.Name(), n.ID())
-				}
-			}
-		}()
+	return nil
+}
+
+// ipamAllocatePool requests the pool of the configuration along with its
+// gateway and auxiliary addresses
+func (n *network) ipamAllocatePool(ipam ipamapi.Ipam, ipVer int, cfg *IpamConf) (d *IpamInfo, err error) {
+	if err = cfg.Validate(); err != nil {
+		return nil, err
+	}
+	d = &IpamInfo{}
 
-		if gws, ok := d.Meta[netlabel.Gateway]; ok {
-			if d.Gateway, err = types.ParseCIDR(gws); err != nil {
-				return types.BadRequestErrorf("failed to parse gateway address (%v) returned by ipam driver: %v", gws, err)
+	d.AddressSpace = n.addrSpace
+	d.PoolID, d.Pool, d.Meta, err = n.requestPoolHelper(ipam, n.addrSpace, cfg.PreferredPool, cfg.SubPool, n.ipamOptions, ipVer == 6)
+	if err != nil {
+		return nil, err
+	}
+
+	// The pool info returned is nil on failure
+	poolID := d.PoolID
+	defer func() {
+		if err != nil {
+			if err := ipam.ReleasePool(poolID); err != nil {
+				logrus.Warnf("Failed to release address pool %s after failure to create network %s (%s)", poolID, n.Name(), n.ID())
 			}
 		}
+	}()
 
-		// If user requested a specific gateway, libnetwork will allocate it
-		// irrespective of whether ipam driver returned a gateway already.
-		// If none of the above is true, libnetwork will allocate one.
-		if cfg.Gateway != "" || d.Gateway == nil {
-			var gatewayOpts = map[string]string{
-				ipamapi.RequestAddressType: netlabel.Gateway,
-			}
-			if d.Gateway, _, err = ipam.RequestAddress(d.PoolID, net.ParseIP(cfg.Gateway), gatewayOpts); err != nil {
-				return types.InternalErrorf("failed to allocate gateway (%v): %v", cfg.Gateway, err)
-			}
+	if gws, ok := d.Meta[netlabel.Gateway]; ok {
+		if d.Gateway, err = types.ParseCIDR(gws); err != nil {
+			return nil, types.BadRequestErrorf("failed to parse gateway address (%v) returned by ipam driver: %v", gws, err)
 		}
+	}
 
-		// Auxiliary addresses must be part of the master address pool
-		// If they fall into the container addressable pool, libnetwork will reserve them
-		if cfg.AuxAddresses != nil {
-			var ip net.IP
-			d.IPAMData.AuxAddresses = make(map[string]*net.IPNet, len(cfg.AuxAddresses))
-			for k, v := range cfg.AuxAddresses {
-				if ip = net.ParseIP(v); ip == nil {
-					return types.BadRequestErrorf("non parsable secondary ip address (%s:%s) passed for network %s", k, v, n.Name())
-				}
-				if !d.Pool.Contains(ip) {
-					return types.ForbiddenErrorf("auxiliary address: (%s:%s) must belong to the master pool: %s", k, v, d.Pool)
-				}
-				// Attempt reservation in the container addressable pool, silent the error if address does not belong to that pool
-				if d.IPAMData.AuxAddresses[k], _, err = ipam.RequestAddress(d.PoolID, ip, nil); err != nil && err != ipamapi.ErrIPOutOfRange {
-					return types.InternalErrorf("failed to allocate secondary ip address (%s:%s): %v", k, v, err)
-				}
+	// If user requested a specific gateway, libnetwork will allocate it
+	// irrespective of whether ipam driver returned a gateway already.
+	// If none of the above is true, libnetwork will allocate one.
+	if cfg.Gateway != "" || d.Gateway == nil {
+		var gatewayOpts = map[string]string{
+			ipamapi.RequestAddressType: netlabel.Gateway,
+		}
+		if d.Gateway, _, err = ipam.RequestAddress(d.PoolID, net.ParseIP(cfg.Gateway), gatewayOpts); err != nil {
+			return nil, types.InternalErrorf("failed to allocate gateway (%v): %v", cfg.Gateway, err)
+		}
+	}
+
+	// Auxiliary addresses must be part of the master address pool
+	// If they fall into the container addressable pool, libnetwork will reserve them
+	if cfg.AuxAddresses != nil {
+		var ip net.IP
+		d.IPAMData.AuxAddresses = make(map[string]*net.IPNet, len(cfg.AuxAddresses))
+		for k, v := range cfg.AuxAddresses {
+			if ip = net.ParseIP(v); ip == nil {
+				return nil, types.BadRequestErrorf("non parsable secondary ip address (%s:%s) passed for network %s", k, v, n.Name())
+			}
+			if !d.Pool.Contains(ip) {
+				return nil, types.ForbiddenErrorf("auxiliary address: (%s:%s) must belong to the master pool: %s", k, v, d.Pool)
+			}
+			// Attempt reservation in the container addressable pool, silent the error if address does not belong to that pool
+			if d.IPAMData.AuxAddresses[k], _, err = ipam.RequestAddress(d.PoolID, ip, nil); err != nil && err != ipamapi.ErrIPOutOfRange {
+				return nil, types.InternalErrorf("failed to allocate secondary ip address (%s:%s): %v", k, v, err)
 			}
 		}
 	}
 
-	return nil
+	return d, nil
 }
 
 func (n *network) ipamRelease() {
@@ -1711,26 +2026,32 @@ func (n *network) ipamReleaseVersion(ipVer int, ipam ipamapi.Ipam) {
 	logrus.Debugf("releasing IPv%d pools from network %s (%s)", ipVer, n.Name(), n.ID())
 
 	for _, d := range *infoList {
-		if d.Gateway != nil {
-			if err := ipam.ReleaseAddress(d.PoolID, d.Gateway.IP); err != nil {
-				logrus.Warnf("Failed to release gateway ip address %s on delete of network %s (%s): %v", d.Gateway.IP, n.Name(), n.ID(), err)
-			}
+		n.ipamReleasePool(ipam, d)
+	}
+
+	*infoList = nil
+}
+
+// ipamReleasePool releases the gateway and auxiliary addresses of the pool
+// along with the pool itself
+func (n *network) ipamReleasePool(ipam ipamapi.Ipam, d *IpamInfo) {
+	if d.Gateway != nil {
+		if err := ipam.ReleaseAddress(d.PoolID, d.Gateway.IP); err != nil {
+			logrus.Warnf("Failed to release gateway ip address %s on delete of network %s (%s): %v", d.Gateway.IP, n.Name(), n.ID(), err)
 		}
-		if d.IPAMData.AuxAddresses != nil {
-			for k, nw := range d.IPAMData.AuxAddresses {
-				if d.Pool.Contains(nw.IP) {
-					if err := ipam.ReleaseAddress(d.PoolID, nw.IP); err != nil && err != ipamapi.ErrIPOutOfRange {
-						logrus.Warnf("Failed to release secondary ip address %s (%v) on delete of network %s (%s): %v", k, nw.IP, n.Name(), n.ID(), err)
-					}
+	}
+	if d.IPAMData.AuxAddresses != nil {
+		for k, nw := range d.IPAMData.AuxAddresses {
+			if d.Pool.Contains(nw.IP) {
+				if err := ipam.ReleaseAddress(d.PoolID, nw.IP); err != nil && err != ipamapi.ErrIPOutOfRange {
+					logrus.Warnf("Failed to release secondary ip address %s (%v) on delete of network %s (%s): %v", k, nw.IP, n.Name(), n.ID(), err)
 				}
 			}
 		}
-		if err := ipam.ReleasePool(d.PoolID); err != nil {
-			logrus.Warnf("Failed to release address pool %s on delete of network %s (%s): %v", d.PoolID, n.Name(), n.ID(), err)
-		}
 	}
-
-	*infoList = nil
+	if err := ipam.ReleasePool(d.PoolID); err != nil {
+		logrus.Warnf("Failed to release address pool %s on delete of network %s (%s): %v", d.PoolID, n.Name(), n.ID(), err)
+	}
 }
 
 func (n *network) getIPInfo(ipVer int) []*IpamInfo {
@@ -1858,6 +2179,27 @@ func (n *network) IpamInfo() ([]*IpamInfo, []*IpamInfo) {
 	return v4Info, v6Info
 }
 
+func (n *network) gatewayPriority() int {
+	n.Lock()
+	defer n.Unlock()
+
+	return n.gwPriority
+}
+
+func (n *network) scopedResolution() bool {
+	n.Lock()
+	defer n.Unlock()
+
+	return n.scopedDNS
+}
+
+func (n *network) routeMetricValue() int {
+	n.Lock()
+	defer n.Unlock()
+
+	return n.routeMetric
+}
+
 func (n *network) Internal() bool {
 	n.Lock()
 	defer n.Unlock()
@@ -1865,6 +2207,13 @@ func (n *network) Internal() bool {
 	return n.internal
 }
 
+func (n *network) IPv6Only() bool {
+	n.Lock()
+	defer n.Unlock()
+
+	return n.ipv6Only
+}
+
 func (n *network) Attachable() bool {
 	n.Lock()
 	defer n.Unlock()
@@ -1949,15 +2298,26 @@ func (n *network) ResolveName(req string, ipType int) ([]net.IP, bool) {
 	c := n.getController()
 	c.Lock()
 	defer c.Unlock()
+	req = strings.TrimSuffix(req, ".")
+	if ips, ok := c.resolveExternalName(n.ID(), req, ipType); ok {
+		return ips, true
+	}
 	sr, ok := c.svcRecords[n.ID()]
 
 	if !ok {
 		return nil, false
 	}
 
-	req = strings.TrimSuffix(req, ".")
 	ipSet, ok := sr.svcMap.Get(req)
 
+	if ipType == types.IPv4 && !ok && n.ipv6Only {
+		// The names of the IPv6-only network have no IPv4 address, the
+		// query is answered empty rather than forwarded.
+		if _, found := sr.svcIPv6Map.Get(req); found {
+			ipv6Miss = true
+		}
+	}
+
 	if ipType == types.IPv6 {
 		// If the name resolved to v4 address then its a valid name in
 		// the docker network domain. If the network is not v6 enabled
@@ -2008,14 +2368,16 @@ func (n *network) ResolveIP(ip string) string {
 	c := n.getController()
 	c.Lock()
 	defer c.Unlock()
+	nwName := n.Name()
+	if name := c.resolveExternalIP(n.ID(), ip); name != "" {
+		return name + "." + nwName
+	}
 	sr, ok := c.svcRecords[n.ID()]
 
 	if !ok {
 		return ""
 	}
 
-	nwName := n.Name()
-
 	elemSet, ok := sr.ipMap.Get(ip)
 	if !ok || len(elemSet) == 0 {
 		return ""
@@ -2162,7 +2524,7 @@ func (n *network) createLoadBalancerSandbox() (retErr error) {
 		// Mark LB endpoints as anonymous so they don't show up in DNS
 		epOptions = append(epOptions, CreateOptionAnonymous())
 	}
-	ep, err := n.createEndpoint(endpointName, epOptions...)
+	ep, err := n.createEndpoint(nil, endpointName, epOptions...)
 	if err != nil {
 		return err
 	}
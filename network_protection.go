@@ -0,0 +1,126 @@
+package libnetwork
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/docker/libnetwork/authz"
+	"github.com/docker/libnetwork/netlabel"
+	"github.com/docker/libnetwork/types"
+	"github.com/sirupsen/logrus"
+)
+
+// setupDeletionProtection protects the network created with the label
+func (n *network) setupDeletionProtection() error {
+	v, ok := n.labels[netlabel.DeletionProtection]
+	if !ok {
+		return nil
+	}
+	protected, err := strconv.ParseBool(v)
+	if err != nil {
+		return types.BadRequestErrorf("invalid %s %q: expected a boolean", netlabel.DeletionProtection, v)
+	}
+	n.protected = protected
+	return nil
+}
+
+// checkDeletable returns the error preventing the deletion of the network,
+// if it is protected or has finalizers
+func (n *network) checkDeletable() error {
+	n.Lock()
+	defer n.Unlock()
+	if n.protected {
+		return types.ForbiddenErrorf("network %s is protected from deletion", n.name)
+	}
+	if len(n.finalizers) > 0 {
+		return types.ForbiddenErrorf("network %s is held by the finalizers %s", n.name, strings.Join(n.finalizers, ","))
+	}
+	return nil
+}
+
+func (n *network) SetDeletionProtection(protected bool) error {
+	op := authz.NetworkProtect
+	if !protected {
+		op = authz.NetworkUnprotect
+	}
+	return n.updateProtection(n.authzRequest(op), func(n *network) bool {
+		if n.protected == protected {
+			return false
+		}
+		n.protected = protected
+		return true
+	})
+}
+
+func (n *network) AddFinalizer(name string) error {
+	if name == "" || strings.ContainsAny(name, ", ") {
+		return types.BadRequestErrorf("invalid finalizer name %q", name)
+	}
+	req := n.authzRequest(authz.FinalizerAdd)
+	req.Finalizer = name
+	return n.updateProtection(req, func(n *network) bool {
+		for _, f := range n.finalizers {
+			if f == name {
+				return false
+			}
+		}
+		n.finalizers = append(n.finalizers, name)
+		sort.Strings(n.finalizers)
+		return true
+	})
+}
+
+func (n *network) RemoveFinalizer(name string) error {
+	req := n.authzRequest(authz.FinalizerRemove)
+	req.Finalizer = name
+	return n.updateProtection(req, func(n *network) bool {
+		for i, f := range n.finalizers {
+			if f == name {
+				n.finalizers = append(n.finalizers[:i], n.finalizers[i+1:]...)
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// updateProtection applies the change on the latest copy of the network
+// once authorized, and stores it when there was one. The network lock
+// serializes the change with the deletion.
+func (n *network) updateProtection(req *authz.Request, change func(n *network) bool) error {
+	c := n.getController()
+	if err := c.authorize(req); err != nil {
+		return err
+	}
+	c.networkLocker.Lock(n.id)
+	defer c.networkLocker.Unlock(n.id)
+
+	nw, err := c.getNetworkFromStore(n.id)
+	if err != nil {
+		return err
+	}
+	nw.Lock()
+	changed := change(nw)
+	nw.Unlock()
+	if !changed {
+		return nil
+	}
+	if err := c.updateToStore(nw); err != nil {
+		return err
+	}
+	logrus.Infof("Network %s (%.7s): %s", nw.Name(), nw.ID(), req)
+	return nil
+}
+
+func (n *network) DeletionProtected() bool {
+	n.Lock()
+	defer n.Unlock()
+	return n.protected
+}
+
+func (n *network) Finalizers() []string {
+	n.Lock()
+	defer n.Unlock()
+	return append([]string(nil), n.finalizers...)
+}
@@ -0,0 +1,77 @@
+package libnetwork
+
+import (
+	"github.com/docker/libnetwork/driverapi"
+	"github.com/docker/libnetwork/types"
+	"github.com/sirupsen/logrus"
+)
+
+func (n *network) AddSubnet(cfg *IpamConf, v6 bool) (err error) {
+	if cfg == nil || cfg.PreferredPool == "" {
+		return types.BadRequestErrorf("a subnet is required to extend network %s", n.Name())
+	}
+	if n.hasSpecialDriver() || n.ConfigOnly() {
+		return types.ForbiddenErrorf("subnets cannot be added to network %s", n.Name())
+	}
+
+	c := n.getController()
+	c.networkLocker.Lock(n.id)
+	defer c.networkLocker.Unlock(n.id)
+
+	// Work on the latest copy of the network
+	n, err = c.getNetworkFromStore(n.id)
+	if err != nil {
+		return err
+	}
+	if v6 && !n.IPv6Enabled() {
+		return types.ForbiddenErrorf("IPv6 is not enabled on network %s", n.Name())
+	}
+
+	d, err := n.driver(true)
+	if err != nil {
+		return err
+	}
+	sa, ok := d.(driverapi.SubnetAdder)
+	if !ok {
+		return types.NotImplementedErrorf("driver %s of network %s does not support adding subnets", n.Type(), n.Name())
+	}
+
+	ipam, _, err := c.getIPAMDriver(n.ipamType)
+	if err != nil {
+		return err
+	}
+	ipVer := 4
+	if v6 {
+		ipVer = 6
+	}
+	info, err := n.ipamAllocatePool(ipam, ipVer, cfg)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			n.ipamReleasePool(ipam, info)
+		}
+	}()
+
+	if err = sa.AddSubnet(n.ID(), info.IPAMData, v6); err != nil {
+		return err
+	}
+
+	n.Lock()
+	if v6 {
+		n.ipamV6Config = append(n.ipamV6Config, cfg)
+		n.ipamV6Info = append(n.ipamV6Info, info)
+	} else {
+		n.ipamV4Config = append(n.ipamV4Config, cfg)
+		n.ipamV4Info = append(n.ipamV4Info, info)
+	}
+	n.Unlock()
+
+	if err = c.updateToStore(n); err != nil {
+		return err
+	}
+
+	logrus.Infof("Added subnet %s to network %s (%.7s)", info.Pool, n.Name(), n.ID())
+	return nil
+}
@@ -110,6 +110,12 @@ type tableEventMessage struct {
 	tname string
 	key   string
 	msg   []byte
+
+	// Set by the bounded queue to account the transmissions
+	queue      *broadcastQueue
+	queuedAt   time.Time
+	finishedAt time.Time
+	transmits  int
 }
 
 func (m *tableEventMessage) Invalidates(other memberlist.Broadcast) bool {
@@ -122,6 +128,9 @@ func (m *tableEventMessage) Message() []byte {
 }
 
 func (m *tableEventMessage) Finished() {
+	if m.queue != nil {
+		m.queue.finish(m)
+	}
 }
 
 func (nDB *NetworkDB) sendTableEvent(event TableEvent_Type, nid string, tname string, key string, entry *entry) error {
@@ -142,7 +151,7 @@ func (nDB *NetworkDB) sendTableEvent(event TableEvent_Type, nid string, tname st
 		return err
 	}
 
-	var broadcastQ *memberlist.TransmitLimitedQueue
+	var broadcastQ *broadcastQueue
 	nDB.RLock()
 	thisNodeNetworks, ok := nDB.networks[nDB.config.NodeID]
 	if ok {
@@ -162,11 +171,10 @@ func (nDB *NetworkDB) sendTableEvent(event TableEvent_Type, nid string, tname st
 		return nil
 	}
 
-	broadcastQ.QueueBroadcast(&tableEventMessage{
+	return broadcastQ.queueBroadcast(&tableEventMessage{
 		msg:   raw,
 		id:    nid,
 		tname: tname,
 		key:   key,
-	})
-	return nil
+	}, true)
 }
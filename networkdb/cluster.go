@@ -163,6 +163,13 @@ func (nDB *NetworkDB) clusterInit() error {
 	nDB.ctx, nDB.cancelCtx = context.WithCancel(context.Background())
 	nDB.memberlist = mlist
 
+	if nDB.config.SnapshotPort != 0 {
+		if err := nDB.startSnapshotServer(); err != nil {
+			mlist.Shutdown()
+			return err
+		}
+	}
+
 	for _, trigger := range []struct {
 		interval time.Duration
 		fn       func()
@@ -235,6 +242,10 @@ func (nDB *NetworkDB) clusterLeave() error {
 	// cancel the context
 	nDB.cancelCtx()
 
+	if nDB.snapshotListener != nil {
+		nDB.snapshotListener.Close()
+	}
+
 	for _, t := range nDB.tickers {
 		t.Stop()
 	}
@@ -477,9 +488,11 @@ func (nDB *NetworkDB) gossip() {
 		// Collect stats and print the queue info, note this code is here also to have a view of the queues empty
 		network.qMessagesSent += len(msgs)
 		if printStats {
-			logrus.Infof("NetworkDB stats %v(%v) - netID:%s leaving:%t netPeers:%d entries:%d Queue qLen:%d netMsg/s:%d",
+			qStats := broadcastQ.Stats()
+			logrus.Infof("NetworkDB stats %v(%v) - netID:%s leaving:%t netPeers:%d entries:%d Queue qLen:%d dropped:%d rejected:%d retransmits:%d lag:%v netMsg/s:%d",
 				nDB.config.Hostname, nDB.config.NodeID,
-				nid, network.leaving, broadcastQ.NumNodes(), network.entriesNumber, broadcastQ.NumQueued(),
+				nid, network.leaving, broadcastQ.NumNodes(), network.entriesNumber, qStats.QueueLen,
+				qStats.Dropped, qStats.Rejected, qStats.Retransmits, qStats.LagAvg,
 				network.qMessagesSent/int((nDB.config.StatsPrintPeriod/time.Second)))
 			network.qMessagesSent = 0
 		}
@@ -630,40 +643,27 @@ func (nDB *NetworkDB) bulkSyncNode(networks []string, node string, unsolicited b
 		return nil
 	}
 
+	// The joins of this node go first, for the peer to take the entries of
+	// this node when the bulk sync comes ahead of the gossip of the join
 	for _, nid := range networks {
-		nDB.indexes[byNetwork].WalkPrefix(fmt.Sprintf("/%s", nid), func(path string, v interface{}) bool {
-			entry, ok := v.(*entry)
-			if !ok {
-				return false
-			}
-
-			eType := TableEventTypeCreate
-			if entry.deleting {
-				eType = TableEventTypeDelete
-			}
-
-			params := strings.Split(path[1:], "/")
-			tEvent := TableEvent{
-				Type:      eType,
-				LTime:     entry.ltime,
-				NodeName:  entry.node,
-				NetworkID: nid,
-				TableName: params[1],
-				Key:       params[2],
-				Value:     entry.value,
-				// The duration in second is a float that below would be truncated
-				ResidualReapTime: int32(entry.reapTime.Seconds()),
-			}
-
-			msg, err := encodeMessage(MessageTypeTableEvent, &tEvent)
-			if err != nil {
-				logrus.Errorf("Encode failure during bulk sync: %#v", tEvent)
-				return false
-			}
-
-			msgs = append(msgs, msg)
-			return false
+		n, ok := nDB.networks[nDB.config.NodeID][nid]
+		if !ok || n.leaving {
+			continue
+		}
+		msg, err := encodeMessage(MessageTypeNetworkEvent, &NetworkEvent{
+			Type:      NetworkEventTypeJoin,
+			LTime:     n.ltime,
+			NodeName:  nDB.config.NodeID,
+			NetworkID: nid,
 		})
+		if err != nil {
+			nDB.RUnlock()
+			return fmt.Errorf("failed to encode the join event of network %s: %v", nid, err)
+		}
+		msgs = append(msgs, msg)
+	}
+	for _, nid := range networks {
+		msgs = append(msgs, nDB.networkTableEvents(nid)...)
 	}
 	nDB.RUnlock()
 
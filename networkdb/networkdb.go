@@ -5,6 +5,7 @@ package networkdb
 import (
 	"context"
 	"fmt"
+	"net"
 	"os"
 	"strings"
 	"sync"
@@ -93,6 +94,9 @@ type NetworkDB struct {
 	// Reference to the memberlist's keyring to add & remove keys
 	keyring *memberlist.Keyring
 
+	// Listener serving the table snapshots to the joining nodes
+	snapshotListener net.Listener
+
 	// bootStrapIP is the list of IPs that can be used to bootstrap
 	// the gossip.
 	bootStrapIP []string
@@ -142,7 +146,7 @@ type network struct {
 
 	// The broadcast queue for table event gossip. This is only
 	// initialized for this node's network attachment entries.
-	tableBroadcasts *memberlist.TransmitLimitedQueue
+	tableBroadcasts *broadcastQueue
 
 	// Number of gossip messages sent related to this network during the last stats collection period
 	qMessagesSent int
@@ -199,6 +203,20 @@ type Config struct {
 	// HealthPrintPeriod the period to use to print the health score
 	// Default is 1min
 	HealthPrintPeriod time.Duration
+
+	// BroadcastQueueLimit is the maximum number of table broadcasts queued
+	// per network, 0 meaning unbounded
+	BroadcastQueueLimit int
+
+	// BroadcastQueuePolicy is applied when a table broadcast queue reaches
+	// BroadcastQueueLimit. Default is QueueDropOldest
+	BroadcastQueuePolicy string
+
+	// SnapshotPort is the TCP port on which the table snapshots are served
+	// to the nodes joining a network. When set, a joining node fetches the
+	// snapshot from one node of the network before bulk syncing with all
+	// of them. All the nodes must use the same port, 0 disables it.
+	SnapshotPort int
 }
 
 // entry defines a table entry
@@ -243,6 +261,10 @@ func New(c *Config) (*NetworkDB, error) {
 	// there is at least 5 extra cycle to make sure that all the entries are properly deleted before deleting the network.
 	c.reapNetworkInterval = c.reapEntryInterval + 5*reapPeriod
 
+	if err := validateQueuePolicy(c.BroadcastQueuePolicy); err != nil {
+		return nil, err
+	}
+
 	nDB := &NetworkDB{
 		config:         c,
 		indexes:        make(map[int]*radix.Tree),
@@ -301,6 +323,21 @@ func (nDB *NetworkDB) ClusterPeers() []PeerInfo {
 	return peers
 }
 
+// FailedPeers returns the gossip cluster peers which failed, without
+// leaving the cluster, and were not reaped yet.
+func (nDB *NetworkDB) FailedPeers() []PeerInfo {
+	nDB.RLock()
+	defer nDB.RUnlock()
+	peers := make([]PeerInfo, 0, len(nDB.failedNodes))
+	for _, node := range nDB.failedNodes {
+		peers = append(peers, PeerInfo{
+			Name: node.Name,
+			IP:   node.Node.Addr.String(),
+		})
+	}
+	return peers
+}
+
 // Peers returns the gossip peers for a given network.
 func (nDB *NetworkDB) Peers(nid string) []PeerInfo {
 	nDB.RLock()
@@ -358,6 +395,10 @@ func (nDB *NetworkDB) CreateEntry(tname, nid, key string, value []byte) error {
 		nDB.Unlock()
 		return fmt.Errorf("cannot create entry in table %s with network id %s and key %s, already exists", tname, nid, key)
 	}
+	if err := nDB.checkBroadcastQueue(nid); err != nil {
+		nDB.Unlock()
+		return fmt.Errorf("cannot create entry in table %s with network id %s and key %s: %v", tname, nid, key, err)
+	}
 
 	entry := &entry{
 		ltime: nDB.tableClock.Increment(),
@@ -385,6 +426,10 @@ func (nDB *NetworkDB) UpdateEntry(tname, nid, key string, value []byte) error {
 		nDB.Unlock()
 		return fmt.Errorf("cannot update entry as the entry in table %s with network id %s and key %s does not exist", tname, nid, key)
 	}
+	if err := nDB.checkBroadcastQueue(nid); err != nil {
+		nDB.Unlock()
+		return fmt.Errorf("cannot update entry in table %s with network id %s and key %s: %v", tname, nid, key, err)
+	}
 
 	entry := &entry{
 		ltime: nDB.tableClock.Increment(),
@@ -435,6 +480,10 @@ func (nDB *NetworkDB) DeleteEntry(tname, nid, key string) error {
 		return fmt.Errorf("cannot delete entry %s with network id %s and key %s "+
 			"does not exist or is already being deleted", tname, nid, key)
 	}
+	if err := nDB.checkBroadcastQueue(nid); err != nil {
+		nDB.Unlock()
+		return fmt.Errorf("cannot delete entry %s with network id %s and key %s: %v", tname, nid, key, err)
+	}
 
 	entry := &entry{
 		ltime:    nDB.tableClock.Increment(),
@@ -606,17 +655,14 @@ func (nDB *NetworkDB) JoinNetwork(nid string) error {
 		entries = n.entriesNumber
 	}
 	nodeNetworks[nid] = &network{id: nid, ltime: ltime, entriesNumber: entries}
-	nodeNetworks[nid].tableBroadcasts = &memberlist.TransmitLimitedQueue{
-		NumNodes: func() int {
-			//TODO fcrisciani this can be optimized maybe avoiding the lock?
-			// this call is done each GetBroadcasts call to evaluate the number of
-			// replicas for the message
-			nDB.RLock()
-			defer nDB.RUnlock()
-			return len(nDB.networkNodes[nid])
-		},
-		RetransmitMult: 4,
-	}
+	nodeNetworks[nid].tableBroadcasts = newBroadcastQueue(func() int {
+		//TODO fcrisciani this can be optimized maybe avoiding the lock?
+		// this call is done each GetBroadcasts call to evaluate the number of
+		// replicas for the message
+		nDB.RLock()
+		defer nDB.RUnlock()
+		return len(nDB.networkNodes[nid])
+	}, nDB.config.BroadcastQueueLimit, nDB.config.BroadcastQueuePolicy)
 	nDB.addNetworkNode(nid, nDB.config.NodeID)
 	networkNodes := nDB.networkNodes[nid]
 	n = nodeNetworks[nid]
@@ -627,6 +673,13 @@ func (nDB *NetworkDB) JoinNetwork(nid string) error {
 	}
 
 	logrus.Debugf("%v(%v): joined network %s", nDB.config.Hostname, nDB.config.NodeID, nid)
+	if nDB.config.SnapshotPort != 0 {
+		if err := nDB.snapshotBootstrap(nid, networkNodes); err != nil {
+			logrus.Warnf("Snapshot bootstrap of network %s failed: %v", nid, err)
+		}
+	}
+	// The snapshot only pulls the entries of a peer, the bulk sync still
+	// pushes the local entries to the other nodes of the network
 	if _, err := nDB.bulkSync(networkNodes, true); err != nil {
 		logrus.Errorf("Error bulk syncing while joining network %s: %v", nid, err)
 	}
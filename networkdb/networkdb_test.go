@@ -862,3 +862,117 @@ func TestNetworkDBIslands(t *testing.T) {
 		}
 	}
 }
+
+func TestBroadcastQueueLimit(t *testing.T) {
+	numNodes := func() int { return 1 }
+	event := func(key string) *tableEventMessage {
+		return &tableEventMessage{id: "network1", tname: "test_table", key: key, msg: []byte(key)}
+	}
+
+	q := newBroadcastQueue(numNodes, 2, QueueDropOldest)
+	for _, key := range []string{"k1", "k2", "k3"} {
+		assert.NilError(t, q.queueBroadcast(event(key), true))
+	}
+	s := q.Stats()
+	assert.Check(t, is.Equal(s.QueueLen, 2))
+	assert.Check(t, is.Equal(s.Queued, uint64(3)))
+	assert.Check(t, is.Equal(s.Dropped, uint64(1)))
+
+	q = newBroadcastQueue(numNodes, 2, QueueDropNewest)
+	for _, key := range []string{"k1", "k2", "k3"} {
+		assert.NilError(t, q.queueBroadcast(event(key), true))
+	}
+	s = q.Stats()
+	assert.Check(t, is.Equal(s.QueueLen, 2))
+	assert.Check(t, is.Equal(s.Dropped, uint64(1)))
+
+	q = newBroadcastQueue(numNodes, 2, QueueReject)
+	assert.NilError(t, q.queueBroadcast(event("k1"), true))
+	assert.NilError(t, q.queueBroadcast(event("k2"), true))
+	assert.Check(t, q.full())
+	assert.Check(t, is.Equal(q.queueBroadcast(event("k3"), true), ErrBroadcastQueueFull))
+	assert.NilError(t, q.queueBroadcast(event("k4"), false))
+	s = q.Stats()
+	assert.Check(t, is.Equal(s.Rejected, uint64(1)))
+	assert.Check(t, is.Equal(s.Dropped, uint64(1)))
+
+	// With a single node every broadcast is transmitted 4 times
+	for q.NumQueued() > 0 {
+		q.GetBroadcasts(0, 1400)
+	}
+	s = q.Stats()
+	assert.Check(t, is.Equal(s.Transmits, uint64(8)))
+	assert.Check(t, is.Equal(s.Retransmits, uint64(6)))
+	assert.Check(t, s.LagMax >= s.LagAvg)
+	assert.Check(t, is.Len(q.pending, 0))
+}
+
+func TestNetworkDBBroadcastQueueReject(t *testing.T) {
+	conf := DefaultConfig()
+	conf.BroadcastQueueLimit = 1
+	conf.BroadcastQueuePolicy = QueueReject
+	dbs := createNetworkDBInstances(t, 1, "node", conf)
+
+	err := dbs[0].JoinNetwork("network1")
+	assert.NilError(t, err)
+
+	// The queue is drained by the gossip cycles, keep creating entries until
+	// one does not fit
+	var key string
+	for i := 0; i < 100 && err == nil; i++ {
+		key = fmt.Sprintf("test_key%d", i)
+		err = dbs[0].CreateEntry("test_table", "network1", key, []byte("test_value"))
+	}
+	assert.Check(t, is.ErrorContains(err, ErrBroadcastQueueFull.Error()))
+	dbs[0].verifyEntryExistence(t, "test_table", "network1", key, "", false)
+
+	s := dbs[0].BroadcastStats()["network1"]
+	assert.Check(t, is.Equal(s.Rejected, uint64(1)))
+
+	closeNetworkDBInstances(dbs)
+}
+
+func TestNetworkDBSnapshotBootstrap(t *testing.T) {
+	var dbs []*NetworkDB
+	for i := 0; i < 2; i++ {
+		conf := DefaultConfig()
+		conf.Hostname = fmt.Sprintf("node%d", i+1)
+		conf.BindPort = int(atomic.AddInt32(&dbPort, 1))
+		conf.SnapshotPort = int(atomic.AddInt32(&dbPort, 1))
+		conf.Keys = [][]byte{[]byte("0123456789abcdef")}
+		dbs = append(dbs, launchNode(t, *conf))
+	}
+	// Serve the snapshots of the first node on the port the second one dials
+	dbs[1].config.SnapshotPort = dbs[0].config.SnapshotPort
+	assert.NilError(t, dbs[1].Join([]string{fmt.Sprintf("localhost:%d", dbs[0].config.BindPort)}))
+	dbs[1].verifyNodeExistence(t, dbs[0].config.NodeID, true)
+
+	err := dbs[0].JoinNetwork("network1")
+	assert.NilError(t, err)
+	for i := 0; i < 10; i++ {
+		err = dbs[0].CreateEntry("test_table", "network1", fmt.Sprintf("test_key%d", i), []byte("test_value"))
+		assert.NilError(t, err)
+	}
+	dbs[1].verifyNetworkExistence(t, dbs[0].config.NodeID, "network1", true)
+	// An entry of the joining node, as restored before the join
+	err = dbs[1].CreateEntry("test_table", "network1", "local_key", []byte("test_value"))
+	assert.NilError(t, err)
+
+	err = dbs[1].JoinNetwork("network1")
+	assert.NilError(t, err)
+	assert.Check(t, is.Len(dbs[1].GetTableByNetwork("test_table", "network1"), 11))
+	// is pushed to the other nodes after the snapshot
+	assert.Check(t, is.Len(dbs[0].GetTableByNetwork("test_table", "network1"), 11))
+
+	err = dbs[1].snapshotBootstrap("network1", []string{dbs[0].config.NodeID})
+	assert.NilError(t, err)
+
+	// The frames sealed with an unknown key are refused
+	dbs[1].SetKey([]byte("fedcba9876543210"))
+	dbs[1].SetPrimaryKey([]byte("fedcba9876543210"))
+	dbs[1].RemoveKey([]byte("0123456789abcdef"))
+	err = dbs[1].snapshotBootstrap("network1", []string{dbs[0].config.NodeID})
+	assert.Check(t, is.ErrorContains(err, ""))
+
+	closeNetworkDBInstances(dbs)
+}
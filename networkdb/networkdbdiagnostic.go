@@ -435,15 +435,27 @@ func dbNetworkStats(ctx interface{}, w http.ResponseWriter, r *http.Request) {
 		networks := nDB.networks[nDB.config.NodeID]
 		network, ok := networks[r.Form["nid"][0]]
 
-		entries := -1
-		qLen := -1
+		res := &diagnostic.NetworkStatsResult{Entries: -1, QueueLen: -1}
+		var broadcastQ *broadcastQueue
 		if ok {
-			entries = network.entriesNumber
-			qLen = network.tableBroadcasts.NumQueued()
+			res.Entries = network.entriesNumber
+			broadcastQ = network.tableBroadcasts
 		}
 		nDB.RUnlock()
 
-		rsp := diagnostic.CommandSucceed(&diagnostic.NetworkStatsResult{Entries: entries, QueueLen: qLen})
+		if broadcastQ != nil {
+			s := broadcastQ.Stats()
+			res.QueueLen = s.QueueLen
+			res.QueueLimit = s.QueueLimit
+			res.Dropped = s.Dropped
+			res.Rejected = s.Rejected
+			res.Transmits = s.Transmits
+			res.Retransmits = s.Retransmits
+			res.LagAvg = s.LagAvg.String()
+			res.LagMax = s.LagMax.String()
+		}
+
+		rsp := diagnostic.CommandSucceed(res)
 		log.WithField("response", fmt.Sprintf("%+v", rsp)).Info("network stats done")
 		diagnostic.HTTPReply(w, rsp, json)
 		return
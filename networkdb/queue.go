@@ -0,0 +1,221 @@
+package networkdb
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/memberlist"
+)
+
+// Policies applied when the table broadcast queue of a network reaches the
+// configured limit
+const (
+	// QueueDropOldest discards the oldest queued broadcasts to make room
+	QueueDropOldest = "drop-oldest"
+	// QueueDropNewest discards the broadcasts that do not fit in the queue
+	QueueDropNewest = "drop-newest"
+	// QueueReject fails the local table operations while the queue is full,
+	// the rebroadcasts of remote events are discarded
+	QueueReject = "reject"
+)
+
+// ErrBroadcastQueueFull is returned by the table operations when the
+// broadcast queue of the network is full and the policy is QueueReject
+var ErrBroadcastQueueFull = errors.New("broadcast queue full")
+
+// BroadcastStats are the counters of the table broadcast queue of a network
+type BroadcastStats struct {
+	QueueLen    int           `json:"qlen"`
+	QueueLimit  int           `json:"qlimit"`
+	Queued      uint64        `json:"queued"`
+	Dropped     uint64        `json:"dropped"`
+	Rejected    uint64        `json:"rejected"`
+	Transmits   uint64        `json:"transmits"`
+	Retransmits uint64        `json:"retransmits"`
+	LagAvg      time.Duration `json:"lag_avg"`
+	LagMax      time.Duration `json:"lag_max"`
+}
+
+func validateQueuePolicy(policy string) error {
+	switch policy {
+	case "", QueueDropOldest, QueueDropNewest, QueueReject:
+		return nil
+	}
+	return fmt.Errorf("invalid broadcast queue policy %q", policy)
+}
+
+// broadcastQueue bounds the table broadcast queue of a network and
+// collects its stats. The lag of a broadcast is the time between its
+// queueing and the end of its retransmissions.
+type broadcastQueue struct {
+	*memberlist.TransmitLimitedQueue
+	limit  int
+	policy string
+
+	// serializes the admission of the broadcasts against the limit
+	admitLock sync.Mutex
+
+	statsLock sync.Mutex
+	stats     BroadcastStats
+	lagTotal  time.Duration
+	lagCount  uint64
+	// broadcasts being transmitted, indexed by their payload
+	pending map[*byte]*tableEventMessage
+	// broadcasts out of the queue not accounted yet
+	finished []*tableEventMessage
+}
+
+func newBroadcastQueue(numNodes func() int, limit int, policy string) *broadcastQueue {
+	if policy == "" {
+		policy = QueueDropOldest
+	}
+	return &broadcastQueue{
+		TransmitLimitedQueue: &memberlist.TransmitLimitedQueue{
+			NumNodes:       numNodes,
+			RetransmitMult: 4,
+		},
+		limit:   limit,
+		policy:  policy,
+		pending: make(map[*byte]*tableEventMessage),
+	}
+}
+
+// full tells if the local table operations have to be rejected
+func (q *broadcastQueue) full() bool {
+	return q.limit > 0 && q.policy == QueueReject && q.NumQueued() >= q.limit
+}
+
+// queueBroadcast queues the table event unless the limit is reached, in
+// which case the policy applies
+func (q *broadcastQueue) queueBroadcast(m *tableEventMessage, local bool) error {
+	q.admitLock.Lock()
+	defer q.admitLock.Unlock()
+
+	if q.limit > 0 && q.policy != QueueDropOldest && q.NumQueued() >= q.limit {
+		q.statsLock.Lock()
+		defer q.statsLock.Unlock()
+		if q.policy == QueueReject && local {
+			q.stats.Rejected++
+			return ErrBroadcastQueueFull
+		}
+		q.stats.Dropped++
+		return nil
+	}
+
+	m.queue = q
+	m.queuedAt = time.Now()
+	q.statsLock.Lock()
+	q.pending[&m.msg[0]] = m
+	q.stats.Queued++
+	q.statsLock.Unlock()
+
+	q.QueueBroadcast(m)
+
+	if q.limit > 0 && q.policy == QueueDropOldest {
+		if n := q.NumQueued(); n > q.limit {
+			q.Prune(q.limit)
+			q.statsLock.Lock()
+			q.stats.Dropped += uint64(n - q.limit)
+			q.statsLock.Unlock()
+		}
+	}
+	return nil
+}
+
+// GetBroadcasts returns the broadcasts to gossip and accounts their
+// transmissions
+func (q *broadcastQueue) GetBroadcasts(overhead, limit int) [][]byte {
+	msgs := q.TransmitLimitedQueue.GetBroadcasts(overhead, limit)
+
+	q.statsLock.Lock()
+	defer q.statsLock.Unlock()
+
+	done := make(map[*byte]*tableEventMessage, len(q.finished))
+	for _, m := range q.finished {
+		done[&m.msg[0]] = m
+	}
+	for _, msg := range msgs {
+		m, ok := q.pending[&msg[0]]
+		if !ok {
+			if m, ok = done[&msg[0]]; !ok {
+				continue
+			}
+		}
+		m.transmits++
+		q.stats.Transmits++
+		if m.transmits > 1 {
+			q.stats.Retransmits++
+		}
+	}
+	for _, m := range q.finished {
+		// Pruned or invalidated before being sent
+		if m.transmits == 0 {
+			continue
+		}
+		lag := m.finishedAt.Sub(m.queuedAt)
+		q.lagTotal += lag
+		q.lagCount++
+		if lag > q.stats.LagMax {
+			q.stats.LagMax = lag
+		}
+	}
+	q.finished = nil
+
+	return msgs
+}
+
+func (q *broadcastQueue) finish(m *tableEventMessage) {
+	q.statsLock.Lock()
+	defer q.statsLock.Unlock()
+	m.finishedAt = time.Now()
+	delete(q.pending, &m.msg[0])
+	q.finished = append(q.finished, m)
+}
+
+// Stats returns the counters of the queue
+func (q *broadcastQueue) Stats() BroadcastStats {
+	qLen := q.NumQueued()
+
+	q.statsLock.Lock()
+	defer q.statsLock.Unlock()
+	s := q.stats
+	s.QueueLen = qLen
+	s.QueueLimit = q.limit
+	if q.lagCount > 0 {
+		s.LagAvg = q.lagTotal / time.Duration(q.lagCount)
+	}
+	return s
+}
+
+// BroadcastStats returns the table broadcast queue stats of the networks
+// this node is attached to
+func (nDB *NetworkDB) BroadcastStats() map[string]BroadcastStats {
+	nDB.RLock()
+	queues := make(map[string]*broadcastQueue)
+	for nid, n := range nDB.networks[nDB.config.NodeID] {
+		if n.tableBroadcasts != nil && !n.leaving {
+			queues[nid] = n.tableBroadcasts
+		}
+	}
+	nDB.RUnlock()
+
+	stats := make(map[string]BroadcastStats, len(queues))
+	for nid, q := range queues {
+		stats[nid] = q.Stats()
+	}
+	return stats
+}
+
+// checkBroadcastQueue must be called with the lock held
+func (nDB *NetworkDB) checkBroadcastQueue(nid string) error {
+	n, ok := nDB.networks[nDB.config.NodeID][nid]
+	if !ok || n.tableBroadcasts == nil || !n.tableBroadcasts.full() {
+		return nil
+	}
+	n.tableBroadcasts.statsLock.Lock()
+	n.tableBroadcasts.stats.Rejected++
+	n.tableBroadcasts.statsLock.Unlock()
+	return ErrBroadcastQueueFull
+}
@@ -0,0 +1,327 @@
+package networkdb
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	snapshotTimeout = 30 * time.Second
+	// Upper bound of a single frame, a table entry is far smaller
+	maxSnapshotFrame = 16 << 20
+)
+
+// A snapshot is a sequence of length prefixed frames. The joining node
+// sends a BulkSyncMessage naming the network, the peer answers with one
+// frame per table event of the network followed by a BulkSyncMessage
+// whose payload is the sha256 of the table event frames. When gossip
+// encryption is enabled every frame is sealed with the primary key.
+
+// startSnapshotServer serves the table snapshots of the networks this
+// node is attached to
+func (nDB *NetworkDB) startSnapshotServer() error {
+	addr := net.JoinHostPort(nDB.config.BindAddr, strconv.Itoa(nDB.config.SnapshotPort))
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen for snapshot requests on %s: %v", addr, err)
+	}
+	nDB.snapshotListener = l
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				select {
+				case <-nDB.ctx.Done():
+				default:
+					logrus.Errorf("%v(%v): snapshot server stopped: %v", nDB.config.Hostname, nDB.config.NodeID, err)
+				}
+				return
+			}
+			go nDB.serveSnapshot(conn)
+		}
+	}()
+	return nil
+}
+
+func (nDB *NetworkDB) serveSnapshot(conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(snapshotTimeout))
+
+	req, err := nDB.readSnapshotRequest(conn)
+	if err != nil {
+		logrus.Warnf("%v(%v): invalid snapshot request from %s: %v", nDB.config.Hostname, nDB.config.NodeID, conn.RemoteAddr(), err)
+		return
+	}
+	if len(req.Networks) != 1 {
+		logrus.Warnf("%v(%v): invalid snapshot request from %s: %d networks", nDB.config.Hostname, nDB.config.NodeID, conn.RemoteAddr(), len(req.Networks))
+		return
+	}
+	nid := req.Networks[0]
+
+	nDB.RLock()
+	n, ok := nDB.networks[nDB.config.NodeID][nid]
+	if !ok || n.leaving {
+		nDB.RUnlock()
+		logrus.Warnf("%v(%v): snapshot of network %s requested by node %s, not attached", nDB.config.Hostname, nDB.config.NodeID, nid, req.NodeName)
+		return
+	}
+	msgs := nDB.networkTableEvents(nid)
+	nDB.RUnlock()
+
+	w := bufio.NewWriter(conn)
+	sum := sha256.New()
+	for _, msg := range msgs {
+		sum.Write(msg)
+		if err := nDB.writeSnapshotFrame(w, msg); err != nil {
+			logrus.Warnf("%v(%v): failed to send the snapshot of network %s to node %s: %v", nDB.config.Hostname, nDB.config.NodeID, nid, req.NodeName, err)
+			return
+		}
+	}
+
+	trailer, err := encodeMessage(MessageTypeBulkSync, &BulkSyncMessage{
+		LTime:    nDB.tableClock.Time(),
+		NodeName: nDB.config.NodeID,
+		Networks: []string{nid},
+		Payload:  sum.Sum(nil),
+	})
+	if err == nil {
+		err = nDB.writeSnapshotFrame(w, trailer)
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if err != nil {
+		logrus.Warnf("%v(%v): failed to send the snapshot of network %s to node %s: %v", nDB.config.Hostname, nDB.config.NodeID, nid, req.NodeName, err)
+		return
+	}
+	logrus.Debugf("%v(%v): sent the snapshot of network %s with %d entries to node %s", nDB.config.Hostname, nDB.config.NodeID, nid, len(msgs), req.NodeName)
+}
+
+// networkTableEvents must be called with the read lock held
+func (nDB *NetworkDB) networkTableEvents(nid string) [][]byte {
+	var msgs [][]byte
+	nDB.indexes[byNetwork].WalkPrefix(fmt.Sprintf("/%s", nid), func(path string, v interface{}) bool {
+		entry, ok := v.(*entry)
+		if !ok {
+			return false
+		}
+
+		eType := TableEventTypeCreate
+		if entry.deleting {
+			eType = TableEventTypeDelete
+		}
+
+		params := strings.Split(path[1:], "/")
+		msg, err := encodeMessage(MessageTypeTableEvent, &TableEvent{
+			Type:      eType,
+			LTime:     entry.ltime,
+			NodeName:  entry.node,
+			NetworkID: nid,
+			TableName: params[1],
+			Key:       params[2],
+			Value:     entry.value,
+			// The duration in second is a float that below would be truncated
+			ResidualReapTime: int32(entry.reapTime.Seconds()),
+		})
+		if err != nil {
+			logrus.Errorf("Encode failure of the table events of network %s: %v", nid, err)
+			return false
+		}
+		msgs = append(msgs, msg)
+		return false
+	})
+	return msgs
+}
+
+func (nDB *NetworkDB) readSnapshotRequest(r io.Reader) (*BulkSyncMessage, error) {
+	frame, err := nDB.readSnapshotFrame(r)
+	if err != nil {
+		return nil, err
+	}
+	mType, data, err := decodeMessage(frame)
+	if err != nil {
+		return nil, err
+	}
+	if mType != MessageTypeBulkSync {
+		return nil, fmt.Errorf("unexpected message type %d", mType)
+	}
+	var req BulkSyncMessage
+	if err := proto.Unmarshal(data, &req); err != nil {
+		return nil, err
+	}
+	return &req, nil
+}
+
+// snapshotBootstrap fetches the table snapshot of the network from one of
+// its nodes, the entries are applied only once the checksum is verified
+func (nDB *NetworkDB) snapshotBootstrap(nid string, nodes []string) error {
+	peers := nDB.mRandomNodes(1, nodes)
+	if len(peers) == 0 {
+		return nil
+	}
+
+	nDB.RLock()
+	node, ok := nDB.nodes[peers[0]]
+	var addr string
+	if ok {
+		addr = net.JoinHostPort(node.Addr.String(), strconv.Itoa(nDB.config.SnapshotPort))
+	}
+	nDB.RUnlock()
+	if !ok {
+		return fmt.Errorf("node %s not found", peers[0])
+	}
+
+	startTime := time.Now()
+	conn, err := net.DialTimeout("tcp", addr, snapshotTimeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(startTime.Add(snapshotTimeout))
+
+	req, err := encodeMessage(MessageTypeBulkSync, &BulkSyncMessage{
+		LTime:    nDB.tableClock.Time(),
+		NodeName: nDB.config.NodeID,
+		Networks: []string{nid},
+	})
+	if err != nil {
+		return err
+	}
+	if err := nDB.writeSnapshotFrame(conn, req); err != nil {
+		return err
+	}
+
+	var events []*TableEvent
+	sum := sha256.New()
+	r := bufio.NewReader(conn)
+	for {
+		frame, err := nDB.readSnapshotFrame(r)
+		if err != nil {
+			return fmt.Errorf("failed to read the snapshot from %s: %v", addr, err)
+		}
+		mType, data, err := decodeMessage(frame)
+		if err != nil {
+			return err
+		}
+
+		if mType == MessageTypeBulkSync {
+			var trailer BulkSyncMessage
+			if err := proto.Unmarshal(data, &trailer); err != nil {
+				return err
+			}
+			if !bytes.Equal(trailer.Payload, sum.Sum(nil)) {
+				return fmt.Errorf("checksum mismatch of the snapshot from %s", addr)
+			}
+			if trailer.LTime > 0 {
+				nDB.tableClock.Witness(trailer.LTime)
+			}
+			break
+		}
+		if mType != MessageTypeTableEvent {
+			return fmt.Errorf("unexpected message type %d in the snapshot from %s", mType, addr)
+		}
+
+		sum.Write(frame)
+		var tEvent TableEvent
+		if err := proto.Unmarshal(data, &tEvent); err != nil {
+			return err
+		}
+		if tEvent.NetworkID != nid {
+			return fmt.Errorf("snapshot from %s carries an entry of network %s", addr, tEvent.NetworkID)
+		}
+		events = append(events, &tEvent)
+	}
+
+	for _, tEvent := range events {
+		nDB.handleTableEvent(tEvent, true)
+	}
+	logrus.Debugf("%v(%v): bootstrapped network %s with %d entries from node %s in %s",
+		nDB.config.Hostname, nDB.config.NodeID, nid, len(events), peers[0], time.Since(startTime))
+	return nil
+}
+
+func (nDB *NetworkDB) writeSnapshotFrame(w io.Writer, b []byte) error {
+	b, err := nDB.sealSnapshotFrame(b)
+	if err != nil {
+		return err
+	}
+	var hdr [4]byte
+	binary.BigEndian.PutUint32(hdr[:], uint32(len(b)))
+	if _, err := w.Write(hdr[:]); err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+	return err
+}
+
+func (nDB *NetworkDB) readSnapshotFrame(r io.Reader) ([]byte, error) {
+	var hdr [4]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, err
+	}
+	size := binary.BigEndian.Uint32(hdr[:])
+	if size > maxSnapshotFrame {
+		return nil, fmt.Errorf("frame of %d bytes exceeds the limit", size)
+	}
+	b := make([]byte, size)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, err
+	}
+	return nDB.openSnapshotFrame(b)
+}
+
+func (nDB *NetworkDB) sealSnapshotFrame(b []byte) ([]byte, error) {
+	if nDB.keyring == nil {
+		return b, nil
+	}
+	gcm, err := snapshotCipher(nDB.keyring.GetPrimaryKey())
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, b, nil), nil
+}
+
+func (nDB *NetworkDB) openSnapshotFrame(b []byte) ([]byte, error) {
+	if nDB.keyring == nil {
+		return b, nil
+	}
+	for _, key := range nDB.keyring.GetKeys() {
+		gcm, err := snapshotCipher(key)
+		if err != nil || len(b) < gcm.NonceSize() {
+			continue
+		}
+		plain, err := gcm.Open(nil, b[:gcm.NonceSize()], b[gcm.NonceSize():], nil)
+		if err == nil {
+			return plain, nil
+		}
+	}
+	return nil, errors.New("no installed key could decrypt the frame")
+}
+
+func snapshotCipher(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,401 @@
+package libnetwork
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/libnetwork/diagnostic"
+	"github.com/docker/libnetwork/internal/caller"
+	"github.com/docker/libnetwork/netlabel"
+	"github.com/docker/libnetwork/osl"
+	"github.com/docker/libnetwork/types"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// nextHopProbeTimeout bounds the wait for the reply to a probe
+	nextHopProbeTimeout = time.Second
+	// nextHopProbeFailures is the number of consecutive failed probes after
+	// which a next hop is considered down
+	nextHopProbeFailures = 3
+)
+
+// probeHop probes the next hop from the namespace of the sandbox, or of the
+// host when nil
+var probeHop = probeNextHop
+
+// nextHopPaths2Func are the diagnostic handlers of the next hop probes
+var nextHopPaths2Func = map[string]diagnostic.HTTPHandlerFunc{
+	"/nexthops": nextHopsDiag,
+}
+
+// NextHopStatus is the health of a next hop of a network, as seen by the
+// probes
+type NextHopStatus struct {
+	NetworkID string    `json:"network_id"`
+	NextHop   string    `json:"next_hop"`
+	Down      bool      `json:"down"`
+	Failures  int       `json:"failures"`
+	LastProbe time.Time `json:"last_probe"`
+	LastError string    `json:"last_error,omitempty"`
+}
+
+type nextHopsResult struct {
+	NextHops []NextHopStatus `json:"next_hops"`
+}
+
+func (r *nextHopsResult) String() string {
+	var b strings.Builder
+	for _, s := range r.NextHops {
+		status := "up"
+		if s.Down {
+			status = "down"
+		}
+		fmt.Fprintf(&b, "nid:%s next hop:%s %s failures:%d %s\n", s.NetworkID, s.NextHop, status, s.Failures, s.LastError)
+	}
+	return b.String()
+}
+
+// routeRef is a static route of an endpoint programmed in a sandbox, or the
+// gateway of the endpoint when the route is nil, or a next hop configured on
+// the network when the sandbox is nil as well
+type routeRef struct {
+	sb    *sandbox
+	eid   string
+	route *types.StaticRoute
+}
+
+// nextHopProber probes the next hops configured on the routed networks, from
+// the host, and the gateways of the endpoints and the next hops of the
+// static routes the drivers programmed in the sandboxes, from the sandboxes.
+// A network with a next hop down is degraded; when removeRoutes is set the static routes
+// through the next hop are removed from the sandboxes until it is up again.
+type nextHopProber struct {
+	sync.Mutex
+	removeRoutes bool
+	status       map[string]*NextHopStatus
+	removed      map[string][]routeRef
+}
+
+func (r routeRef) osSandbox() osl.Sandbox {
+	if r.sb == nil {
+		return nil
+	}
+	r.sb.Lock()
+	defer r.sb.Unlock()
+	return r.sb.osSbox
+}
+
+func nextHopKey(nid string, hop net.IP) string {
+	return nid + "/" + hop.String()
+}
+
+func (c *controller) runNextHopProbes(interval time.Duration, stopCh chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			c.probeNextHops()
+		case <-stopCh:
+			return
+		}
+	}
+}
+
+// nextHops returns the next hops configured on the network
+func (n *network) nextHops() ([]net.IP, error) {
+	n.Lock()
+	v, ok := n.labels[netlabel.NextHops]
+	n.Unlock()
+	if !ok {
+		return nil, nil
+	}
+	var hops []net.IP
+	for _, s := range strings.Split(v, ",") {
+		hop := net.ParseIP(strings.TrimSpace(s))
+		if hop == nil {
+			return nil, types.BadRequestErrorf("invalid %s %q: expected comma separated IP addresses", netlabel.NextHops, v)
+		}
+		hops = append(hops, hop)
+	}
+	return hops, nil
+}
+
+// Degraded tells if a probed next hop of the network is down
+func (n *network) Degraded() bool {
+	c := n.getController()
+	if c == nil || c.nextHopProber == nil {
+		return false
+	}
+	p := c.nextHopProber
+	p.Lock()
+	defer p.Unlock()
+	return p.degraded(n.ID())
+}
+
+// nextHopRoutes returns, per network and next hop, the next hops configured
+// on the network, the static routes through the next hop programmed in the
+// sandboxes, and the endpoints whose gateway it is
+func (c *controller) nextHopRoutes() map[string]map[string][]routeRef {
+	routes := map[string]map[string][]routeRef{}
+	add := func(nid string, hop net.IP, ref routeRef) {
+		if routes[nid] == nil {
+			routes[nid] = map[string][]routeRef{}
+		}
+		routes[nid][hop.String()] = append(routes[nid][hop.String()], ref)
+	}
+
+	for _, n := range c.Networks() {
+		hops, err := n.(*network).nextHops()
+		if err != nil {
+			logrus.Warnf("Failed to probe the next hops of network %s: %v", n.Name(), err)
+			continue
+		}
+		for _, hop := range hops {
+			add(n.ID(), hop, routeRef{})
+		}
+	}
+
+	c.Lock()
+	sandboxes := make([]*sandbox, 0, len(c.sandboxes))
+	for _, sb := range c.sandboxes {
+		sandboxes = append(sandboxes, sb)
+	}
+	c.Unlock()
+
+	for _, sb := range sandboxes {
+		for _, ep := range sb.getConnectedEndpoints() {
+			nid := ep.getNetwork().ID()
+			if gw := ep.Gateway(); len(gw) > 0 {
+				add(nid, gw, routeRef{sb: sb, eid: ep.ID()})
+			}
+			for _, r := range ep.StaticRoutes() {
+				if r.NextHop != nil {
+					add(nid, r.NextHop, routeRef{sb: sb, eid: ep.ID(), route: r})
+				}
+			}
+		}
+	}
+	return routes
+}
+
+func (c *controller) probeNextHops() {
+	p := c.nextHopProber
+	routes := c.nextHopRoutes()
+
+	seen := map[string]bool{}
+	for nid, hops := range routes {
+		for hop, refs := range hops {
+			// The next hops configured on the network are probed from the
+			// host, the others from a sandbox routing through them
+			var (
+				osSbox osl.Sandbox
+				host   bool
+			)
+			for _, ref := range refs {
+				if ref.sb == nil {
+					host = true
+					break
+				}
+				if osSbox == nil {
+					osSbox = ref.osSandbox()
+				}
+			}
+			if host {
+				osSbox = nil
+			} else if osSbox == nil {
+				continue
+			}
+			ip := net.ParseIP(hop)
+			key := nextHopKey(nid, ip)
+			seen[key] = true
+			err := probeHop(osSbox, ip, nextHopProbeTimeout)
+			c.updateNextHop(nid, hop, key, refs, err)
+		}
+	}
+
+	// Forget the next hops no network nor endpoint routes through anymore
+	p.Lock()
+	for key := range p.status {
+		if !seen[key] {
+			delete(p.status, key)
+			delete(p.removed, key)
+		}
+	}
+	p.Unlock()
+}
+
+func (c *controller) updateNextHop(nid, hop, key string, refs []routeRef, err error) {
+	p := c.nextHopProber
+	p.Lock()
+	s, ok := p.status[key]
+	if !ok {
+		s = &NextHopStatus{NetworkID: nid, NextHop: hop}
+		p.status[key] = s
+	}
+	s.LastProbe = time.Now()
+	wasDegraded := p.degraded(nid)
+
+	var down, up bool
+	if err != nil {
+		s.Failures++
+		s.LastError = err.Error()
+		if !s.Down && s.Failures >= nextHopProbeFailures {
+			s.Down = true
+			down = true
+		}
+	} else {
+		s.Failures = 0
+		s.LastError = ""
+		if s.Down {
+			s.Down = false
+			up = true
+		}
+	}
+	degraded := p.degraded(nid)
+
+	var restore []routeRef
+	if down && p.removeRoutes {
+		p.removed[key] = refs
+	}
+	if up {
+		restore = p.removed[key]
+		delete(p.removed, key)
+	}
+	removeRoutes := p.removeRoutes
+	p.Unlock()
+
+	if !down && !up {
+		return
+	}
+
+	n, _ := c.NetworkByID(nid)
+	ev := Event{NetworkID: nid, NextHop: hop}
+	if n != nil {
+		ev.NetworkName = n.Name()
+	}
+
+	if down {
+		logrus.Warnf("Next hop %s of network %.7s is down: %v", hop, nid, err)
+		if removeRoutes {
+			for _, ref := range refs {
+				if ref.route == nil {
+					continue
+				}
+				osSbox := ref.osSandbox()
+				if osSbox == nil {
+					continue
+				}
+				if err := osSbox.RemoveStaticRoute(ref.route); err != nil {
+					logrus.Warnf("Failed to remove route %s via %s from sandbox %.7s: %v", ref.route.Destination, hop, ref.sb.ID(), err)
+				}
+			}
+		}
+		ev.Type = EventNextHopDown
+		c.publish(ev)
+	} else {
+		logrus.Infof("Next hop %s of network %.7s is up", hop, nid)
+		for _, ref := range restore {
+			if ref.route == nil {
+				continue
+			}
+			osSbox := c.routeSandbox(ref)
+			if osSbox == nil {
+				continue
+			}
+			if err := osSbox.AddStaticRoute(ref.route); err != nil {
+				logrus.Warnf("Failed to restore route %s via %s in sandbox %.7s: %v", ref.route.Destination, hop, ref.sb.ID(), err)
+			}
+		}
+		ev.Type = EventNextHopUp
+		c.publish(ev)
+	}
+
+	switch {
+	case degraded && !wasDegraded:
+		ev.Type = EventNetworkDegraded
+		c.publish(ev)
+	case !degraded && wasDegraded:
+		ev.Type = EventNetworkRecovered
+		c.publish(ev)
+	}
+}
+
+// routeSandbox returns the namespace to restore the route into, nil when
+// the sandbox is gone or the endpoint of the route left it or dropped the
+// route since the removal. The sandbox is looked up again by its ID, as it
+// may have been recreated.
+func (c *controller) routeSandbox(ref routeRef) osl.Sandbox {
+	c.Lock()
+	sb, ok := c.sandboxes[ref.sb.ID()]
+	c.Unlock()
+	if !ok {
+		return nil
+	}
+	ep := sb.getEndpoint(ref.eid)
+	if ep == nil {
+		return nil
+	}
+	for _, r := range ep.StaticRoutes() {
+		if types.CompareIPNet(r.Destination, ref.route.Destination) && r.NextHop.Equal(ref.route.NextHop) {
+			return routeRef{sb: sb}.osSandbox()
+		}
+	}
+	return nil
+}
+
+// degraded tells whether a next hop of the network is down, called with
+// the prober locked
+func (p *nextHopProber) degraded(nid string) bool {
+	for _, s := range p.status {
+		if s.NetworkID == nid && s.Down {
+			return true
+		}
+	}
+	return false
+}
+
+// NextHopStatus returns the health of the probed next hops
+func (c *controller) NextHopStatus() []NextHopStatus {
+	p := c.nextHopProber
+	if p == nil {
+		return nil
+	}
+	p.Lock()
+	list := make([]NextHopStatus, 0, len(p.status))
+	for _, s := range p.status {
+		list = append(list, *s)
+	}
+	p.Unlock()
+
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].NetworkID != list[j].NetworkID {
+			return list[i].NetworkID < list[j].NetworkID
+		}
+		return list[i].NextHop < list[j].NextHop
+	})
+	return list
+}
+
+func nextHopsDiag(ctx interface{}, w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	diagnostic.DebugHTTPForm(r)
+	_, json := diagnostic.ParseHTTPFormOptions(r)
+
+	// audit logs
+	log := logrus.WithFields(logrus.Fields{"component": "diagnostic", "remoteIP": r.RemoteAddr, "method": caller.Name(0), "url": r.URL.String()})
+	log.Info("next hops")
+
+	c, ok := ctx.(*controller)
+	if !ok {
+		diagnostic.HTTPReply(w, diagnostic.FailCommand(fmt.Errorf("controller not available")), json)
+		return
+	}
+	diagnostic.HTTPReply(w, diagnostic.CommandSucceed(&nextHopsResult{NextHops: c.NextHopStatus()}), json)
+}
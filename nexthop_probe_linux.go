@@ -0,0 +1,98 @@
+package libnetwork
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/docker/libnetwork/osl"
+)
+
+const (
+	icmpEchoRequest   = 8
+	icmpEchoReply     = 0
+	icmpv6EchoRequest = 128
+	icmpv6EchoReply   = 129
+)
+
+// probeNextHop sends an ICMP echo request to the next hop from the namespace
+// of the sandbox, or of the host when nil, and waits for the reply. Resolving the next hop link layer
+// address, a next hop on link not answering ARP or neighbor solicitation
+// fails the probe as well.
+func probeNextHop(osSbox osl.Sandbox, hop net.IP, timeout time.Duration) error {
+	network, reqType, repType := "ip4:icmp", byte(icmpEchoRequest), byte(icmpEchoReply)
+	if hop.To4() == nil {
+		network, reqType, repType = "ip6:ipv6-icmp", icmpv6EchoRequest, icmpv6EchoReply
+	}
+
+	var (
+		conn net.PacketConn
+		err  error
+	)
+	listen := func() {
+		conn, err = net.ListenPacket(network, "")
+	}
+	if osSbox == nil {
+		listen()
+	} else if ierr := osSbox.InvokeFunc(listen); ierr != nil {
+		return ierr
+	}
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	b := make([]byte, 2)
+	rand.Read(b)
+	id := binary.BigEndian.Uint16(b)
+
+	req := make([]byte, 16)
+	req[0] = reqType
+	binary.BigEndian.PutUint16(req[4:6], id)
+	binary.BigEndian.PutUint16(req[6:8], 1)
+	copy(req[8:], "libnetwk")
+	// The kernel computes the checksum of the ICMPv6 messages
+	if hop.To4() != nil {
+		binary.BigEndian.PutUint16(req[2:4], icmpChecksum(req))
+	}
+
+	if _, err := conn.WriteTo(req, &net.IPAddr{IP: hop}); err != nil {
+		return err
+	}
+
+	if err := conn.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+		return err
+	}
+	rep := make([]byte, 1500)
+	for {
+		n, from, err := conn.ReadFrom(rep)
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				return fmt.Errorf("no reply within %v", timeout)
+			}
+			return err
+		}
+		if n < 8 || rep[0] != repType || binary.BigEndian.Uint16(rep[4:6]) != id {
+			continue
+		}
+		if a, ok := from.(*net.IPAddr); ok && a.IP.Equal(hop) {
+			return nil
+		}
+	}
+}
+
+func icmpChecksum(b []byte) uint16 {
+	var sum uint32
+	for i := 0; i+1 < len(b); i += 2 {
+		sum += uint32(b[i])<<8 | uint32(b[i+1])
+	}
+	if len(b)%2 == 1 {
+		sum += uint32(b[len(b)-1]) << 8
+	}
+	for sum>>16 != 0 {
+		sum = sum&0xffff + sum>>16
+	}
+	return ^uint16(sum)
+}
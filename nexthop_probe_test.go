@@ -0,0 +1,241 @@
+package libnetwork
+
+import (
+	"fmt"
+	"net"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/docker/libnetwork/netlabel"
+	"github.com/docker/libnetwork/osl"
+	"github.com/docker/libnetwork/types"
+)
+
+// routeSandbox records the static routes added to and removed from it
+type routeSandbox struct {
+	osl.Sandbox
+	added   []string
+	removed []string
+}
+
+func (s *routeSandbox) AddStaticRoute(r *types.StaticRoute) error {
+	s.added = append(s.added, r.Destination.String())
+	return nil
+}
+
+func (s *routeSandbox) RemoveStaticRoute(r *types.StaticRoute) error {
+	s.removed = append(s.removed, r.Destination.String())
+	return nil
+}
+
+func TestNextHopRemoveRoutes(t *testing.T) {
+	for _, removeRoutes := range []bool{false, true} {
+		t.Run(fmt.Sprintf("remove=%t", removeRoutes), func(t *testing.T) {
+			testNextHopRoutes(t, removeRoutes)
+		})
+	}
+}
+
+func testNextHopRoutes(t *testing.T, removeRoutes bool) {
+	cfgOptions, err := OptionBoltdbWithRandomDBFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	nc, err := New(cfgOptions...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nc.Stop()
+	c := nc.(*controller)
+	c.nextHopProber = &nextHopProber{
+		removeRoutes: removeRoutes,
+		status:       map[string]*NextHopStatus{},
+		removed:      map[string][]routeRef{},
+	}
+	ch, cancel := c.Watch(EventNextHopDown, EventNextHopUp, EventNetworkDegraded, EventNetworkRecovered)
+	defer cancel()
+	expect := func(types ...EventType) {
+		t.Helper()
+		for _, expected := range types {
+			select {
+			case ev := <-ch.C:
+				if e := ev.(Event); e.Type != expected || e.NetworkID != "n1" || e.NextHop != "10.0.0.1" {
+					t.Fatalf("unexpected event %+v, expected %s", e, expected)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatalf("timed out waiting for the %s event", expected)
+			}
+		}
+	}
+
+	hop := net.ParseIP("10.0.0.1")
+	_, dst, _ := net.ParseCIDR("192.168.10.0/24")
+	route := &types.StaticRoute{Destination: dst, RouteType: types.NEXTHOP, NextHop: hop}
+	ep := &endpoint{id: "ep1", network: &network{id: "n1"}, joinInfo: &endpointJoinInfo{
+		gw:           net.ParseIP("10.0.0.254"),
+		StaticRoutes: []*types.StaticRoute{route},
+	}}
+	osSbox := &routeSandbox{}
+	sb := &sandbox{id: "sb1", osSbox: osSbox, endpoints: []*endpoint{ep}}
+	c.Lock()
+	c.sandboxes[sb.id] = sb
+	c.Unlock()
+	refs := []routeRef{{sb: sb, eid: ep.id, route: route}}
+	key := nextHopKey("n1", hop)
+
+	// The gateway of the endpoint is probed along with the next hop of its
+	// static route
+	routes := c.nextHopRoutes()
+	if len(routes["n1"]) != 2 || len(routes["n1"]["10.0.0.1"]) != 1 || len(routes["n1"]["10.0.0.254"]) != 1 ||
+		routes["n1"]["10.0.0.254"][0].route != nil {
+		t.Fatalf("unexpected next hops %+v", routes)
+	}
+
+	// The next hop goes down on the last allowed failure only
+	for i := 0; i < nextHopProbeFailures; i++ {
+		c.updateNextHop("n1", hop.String(), key, refs, fmt.Errorf("no reply"))
+	}
+	expect(EventNextHopDown, EventNetworkDegraded)
+	s := c.NextHopStatus()
+	if len(s) != 1 || !s[0].Down || s[0].Failures != nextHopProbeFailures || s[0].LastError != "no reply" {
+		t.Fatalf("unexpected status %+v of the next hop down", s)
+	}
+	if removeRoutes {
+		if len(osSbox.removed) != 1 || len(c.nextHopProber.removed[key]) != 1 {
+			t.Fatalf("the route via the next hop down is not removed: %v", osSbox.removed)
+		}
+	} else if len(osSbox.removed) != 0 || len(c.nextHopProber.removed) != 0 {
+		t.Fatalf("route removed while the routes are kept: %v", osSbox.removed)
+	}
+
+	// Further failures neither remove the routes again nor publish
+	c.updateNextHop("n1", hop.String(), key, refs, fmt.Errorf("no reply"))
+	if len(osSbox.removed) > 1 {
+		t.Fatalf("route removed twice: %v", osSbox.removed)
+	}
+
+	// The removed routes are restored once the next hop answers again
+	c.updateNextHop("n1", hop.String(), key, refs, nil)
+	expect(EventNextHopUp, EventNetworkRecovered)
+	if s = c.NextHopStatus(); s[0].Down || s[0].Failures != 0 || s[0].LastError != "" {
+		t.Fatalf("unexpected status %+v of the next hop up", s)
+	}
+	if removeRoutes && len(osSbox.added) != 1 {
+		t.Fatalf("the route via the next hop up is not restored: %v", osSbox.added)
+	}
+	if !removeRoutes && len(osSbox.added) != 0 {
+		t.Fatalf("route added while the routes are kept: %v", osSbox.added)
+	}
+	if len(c.nextHopProber.removed) != 0 {
+		t.Fatalf("routes %v left in the bookkeeping", c.nextHopProber.removed)
+	}
+
+	// The route of an endpoint which left the sandbox while the next hop
+	// was down is not restored
+	for i := 0; i < nextHopProbeFailures; i++ {
+		c.updateNextHop("n1", hop.String(), key, refs, fmt.Errorf("no reply"))
+	}
+	expect(EventNextHopDown, EventNetworkDegraded)
+	sb.Lock()
+	sb.endpoints = nil
+	sb.Unlock()
+	added := len(osSbox.added)
+	c.updateNextHop("n1", hop.String(), key, refs, nil)
+	expect(EventNextHopUp, EventNetworkRecovered)
+	if len(osSbox.added) != added {
+		t.Fatalf("route restored for an endpoint gone: %v", osSbox.added)
+	}
+
+	// A next hop no sandbox routes through anymore is forgotten
+	for i := 0; i < nextHopProbeFailures; i++ {
+		c.updateNextHop("n1", hop.String(), key, refs, fmt.Errorf("no reply"))
+	}
+	expect(EventNextHopDown, EventNetworkDegraded)
+	c.probeNextHops()
+	if s = c.NextHopStatus(); len(s) != 0 || len(c.nextHopProber.removed) != 0 {
+		t.Fatalf("next hop %+v left without routes through it", s)
+	}
+}
+
+func TestNextHopNetworkDegraded(t *testing.T) {
+	defer func(f func(osl.Sandbox, net.IP, time.Duration) error) { probeHop = f }(probeHop)
+	var probeErr error
+	probed := map[string]bool{}
+	probeHop = func(osSbox osl.Sandbox, hop net.IP, timeout time.Duration) error {
+		probed[hop.String()] = osSbox == nil
+		return probeErr
+	}
+
+	cfgOptions, err := OptionBoltdbWithRandomDBFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	nc, err := New(cfgOptions...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nc.Stop()
+	c := nc.(*controller)
+	addDeletableDriver(t, c)
+	c.nextHopProber = &nextHopProber{
+		status:  map[string]*NextHopStatus{},
+		removed: map[string][]routeRef{},
+	}
+
+	if _, err := c.NewNetwork(deletableDriverName, "badhops", "", NetworkOptionLabels(map[string]string{netlabel.NextHops: "10.1.0.1,uplink"})); err == nil {
+		t.Fatal("network created with an invalid next hop")
+	} else if _, ok := err.(types.BadRequestError); !ok {
+		t.Fatalf("unexpected error %v", err)
+	}
+	n, err := c.NewNetwork(deletableDriverName, "routed", "", NetworkOptionLabels(map[string]string{netlabel.NextHops: "10.1.0.1, 10.1.0.2"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ch, cancel := c.Watch(EventNetworkDegraded, EventNetworkRecovered)
+	defer cancel()
+
+	// The next hops of the network are probed from the host
+	c.probeNextHops()
+	if len(probed) != 2 || !probed["10.1.0.1"] || !probed["10.1.0.2"] {
+		t.Fatalf("unexpected probes %v", probed)
+	}
+	if n.Info().Degraded() {
+		t.Fatal("network degraded with its next hops up")
+	}
+	// Nor are they routes the uplink failover programs again
+	c.reprogramNextHopRoutes()
+
+	probeErr = fmt.Errorf("no reply")
+	for i := 0; i < nextHopProbeFailures; i++ {
+		c.probeNextHops()
+	}
+	select {
+	case ev := <-ch.C:
+		if e := ev.(Event); e.Type != EventNetworkDegraded || e.NetworkID != n.ID() || e.NetworkName != "routed" {
+			t.Fatalf("unexpected event %+v", e)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the network to be degraded")
+	}
+	if !n.Info().Degraded() {
+		t.Fatal("network not degraded with its next hops down")
+	}
+	if v, err := inspectNetworks(c, httptest.NewRequest("GET", "/inspect/networks?nid=routed", nil)); err != nil || !v.([]networkInspect)[0].Degraded {
+		t.Fatalf("unexpected inspection %+v: %v", v, err)
+	}
+
+	probeErr = nil
+	c.probeNextHops()
+	select {
+	case ev := <-ch.C:
+		if e := ev.(Event); e.Type != EventNetworkRecovered || e.NetworkID != n.ID() {
+			t.Fatalf("unexpected event %+v", e)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the network to recover")
+	}
+	if n.Info().Degraded() {
+		t.Fatal("network still degraded with its next hops up")
+	}
+}
@@ -0,0 +1,15 @@
+// +build !linux
+
+package libnetwork
+
+import (
+	"net"
+	"time"
+
+	"github.com/docker/libnetwork/osl"
+	"github.com/docker/libnetwork/types"
+)
+
+func probeNextHop(osSbox osl.Sandbox, hop net.IP, timeout time.Duration) error {
+	return types.NotImplementedErrorf("next hop probes are not supported on this platform")
+}
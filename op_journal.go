@@ -0,0 +1,374 @@
+package libnetwork
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/docker/libnetwork/diagnostic"
+	"github.com/docker/libnetwork/internal/caller"
+	"github.com/docker/libnetwork/journal"
+	"github.com/docker/libnetwork/types"
+	"github.com/sirupsen/logrus"
+)
+
+// journalPaths2Func are the diagnostic handlers of the operation journal
+var journalPaths2Func = map[string]diagnostic.HTTPHandlerFunc{
+	"/journal":       journalDiag,
+	"/replayjournal": replayJournalDiag,
+}
+
+// JournalReplay is the outcome of the replay of the operation journal:
+// the networks and endpoints created back, the endpoints joined back to
+// their sandbox and the operations which could not be replayed
+type JournalReplay struct {
+	Networks  []string               `json:"networks,omitempty"`
+	Endpoints []string               `json:"endpoints,omitempty"`
+	Joins     []string               `json:"joins,omitempty"`
+	Failed    []JournalReplayFailure `json:"failed,omitempty"`
+}
+
+// JournalReplayFailure is an operation of the journal the replay failed
+type JournalReplayFailure struct {
+	Seq   uint64     `json:"seq"`
+	Op    journal.Op `json:"op"`
+	Error string     `json:"error"`
+}
+
+func (r *JournalReplay) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "networks:%d endpoints:%d joins:%d failed:%d\n", len(r.Networks), len(r.Endpoints), len(r.Joins), len(r.Failed))
+	for _, f := range r.Failed {
+		fmt.Fprintf(&b, "seq:%d op:%s error:%s\n", f.Seq, f.Op, f.Error)
+	}
+	return b.String()
+}
+
+type journalResult struct {
+	Entries []journal.Entry `json:"entries"`
+}
+
+func (r *journalResult) String() string {
+	var b strings.Builder
+	for _, e := range r.Entries {
+		fmt.Fprintf(&b, "seq:%d time:%s op:%s nid:%.7s network:%s eid:%.7s endpoint:%s sid:%.7s\n",
+			e.Seq, e.Time.Format("2006-01-02T15:04:05Z"), e.Op, e.NetworkID, e.NetworkName, e.EndpointID, e.EndpointName, e.SandboxID)
+	}
+	return b.String()
+}
+
+func (c *controller) openJournal() error {
+	j, err := journal.Open(c.cfg.Daemon.JournalPath)
+	if err != nil {
+		return fmt.Errorf("failed to open the operation journal: %v", err)
+	}
+	c.journal = j
+	return nil
+}
+
+func (c *controller) journalOp(e journal.Entry) {
+	if c.journal == nil {
+		return
+	}
+	if _, err := c.journal.Append(e); err != nil {
+		logrus.Warnf("Failed to journal the %s operation of network %.7s: %v", e.Op, e.NetworkID, err)
+	}
+}
+
+// journalNetwork journals the operation on the network, with its state on
+// its creation
+func (c *controller) journalNetwork(op journal.Op, n *network) {
+	if c.journal == nil {
+		return
+	}
+	e := journal.Entry{Op: op, NetworkID: n.ID(), NetworkName: n.Name()}
+	if op == journal.NetworkCreate {
+		b, err := n.MarshalJSON()
+		if err != nil {
+			logrus.Warnf("Failed to journal the creation of network %s: %v", n.Name(), err)
+			return
+		}
+		e.State = b
+	}
+	c.journalOp(e)
+}
+
+// journalEndpoint journals the operation on the endpoint, with its state
+// on its creation and the sandbox on its joins and leaves
+func (c *controller) journalEndpoint(op journal.Op, n *network, ep *endpoint, sb *sandbox) {
+	if c.journal == nil {
+		return
+	}
+	e := journal.Entry{Op: op, NetworkID: n.ID(), NetworkName: n.Name(), EndpointID: ep.ID(), EndpointName: ep.Name()}
+	if sb != nil {
+		e.SandboxID, e.ContainerID = sb.ID(), sb.ContainerID()
+	}
+	if op == journal.EndpointCreate {
+		b, err := ep.MarshalJSON()
+		if err != nil {
+			logrus.Warnf("Failed to journal the creation of endpoint %s: %v", ep.Name(), err)
+			return
+		}
+		e.State = b
+	}
+	c.journalOp(e)
+}
+
+// OperationJournal returns the last operations journaled, at most limit of
+// them if limit is positive, the oldest first
+func (c *controller) OperationJournal(limit int) ([]journal.Entry, error) {
+	if c.journal == nil {
+		return nil, types.ForbiddenErrorf("the operation journal is not enabled")
+	}
+	return c.journal.Recent(limit), nil
+}
+
+// ReplayJournal creates back the networks and endpoints of the journal
+// still in effect which the controller does not know of, with their IDs,
+// and joins back the endpoints to their sandbox when it exists. The
+// operations which fail are reported and the replay goes on without them.
+func (c *controller) ReplayJournal() (*JournalReplay, error) {
+	if c.journal == nil {
+		return nil, types.ForbiddenErrorf("the operation journal is not enabled")
+	}
+	res := &JournalReplay{}
+	for _, e := range c.journal.Live() {
+		created, err := c.replayOp(e)
+		if err != nil {
+			logrus.Warnf("Failed to replay the %s operation %d of network %.7s: %v", e.Op, e.Seq, e.NetworkID, err)
+			res.Failed = append(res.Failed, JournalReplayFailure{Seq: e.Seq, Op: e.Op, Error: err.Error()})
+			continue
+		}
+		if !created {
+			continue
+		}
+		switch e.Op {
+		case journal.NetworkCreate:
+			res.Networks = append(res.Networks, e.NetworkID)
+		case journal.EndpointCreate:
+			res.Endpoints = append(res.Endpoints, e.EndpointID)
+		case journal.EndpointJoin:
+			res.Joins = append(res.Joins, e.EndpointID)
+		}
+	}
+	logrus.Infof("Replayed the operation journal: %d networks, %d endpoints and %d joins created back, %d failed",
+		len(res.Networks), len(res.Endpoints), len(res.Joins), len(res.Failed))
+	return res, nil
+}
+
+// replayOp applies the operation unless it is in effect already, telling
+// whether it got applied
+func (c *controller) replayOp(e journal.Entry) (bool, error) {
+	switch e.Op {
+	case journal.NetworkCreate:
+		if _, err := c.NetworkByID(e.NetworkID); err == nil {
+			return false, nil
+		}
+		src := &network{}
+		if err := src.UnmarshalJSON(e.State); err != nil {
+			return false, fmt.Errorf("invalid state of network %s: %v", e.NetworkName, err)
+		}
+		if _, err := c.NewNetwork(src.networkType, src.name, src.id, networkOptionReplay(src)); err != nil {
+			return false, err
+		}
+		return true, nil
+
+	case journal.EndpointCreate:
+		nw, err := c.NetworkByID(e.NetworkID)
+		if err != nil {
+			return false, err
+		}
+		if _, err := nw.EndpointByID(e.EndpointID); err == nil {
+			return false, nil
+		}
+		src := &endpoint{}
+		if err := src.UnmarshalJSON(e.State); err != nil {
+			return false, fmt.Errorf("invalid state of endpoint %s: %v", e.EndpointName, err)
+		}
+		if _, err := nw.CreateEndpoint(src.name, endpointOptionReplay(src)); err != nil {
+			return false, err
+		}
+		return true, nil
+
+	case journal.EndpointJoin:
+		nw, err := c.NetworkByID(e.NetworkID)
+		if err != nil {
+			return false, err
+		}
+		ep, err := nw.EndpointByID(e.EndpointID)
+		if err != nil {
+			return false, err
+		}
+		sb, err := c.SandboxByID(e.SandboxID)
+		if err != nil {
+			return false, err
+		}
+		if cur, ok := ep.(*endpoint).getSandbox(); ok && cur.ID() == sb.ID() {
+			return false, nil
+		}
+		if err := ep.Join(sb); err != nil {
+			return false, err
+		}
+		return true, nil
+	}
+	return false, nil
+}
+
+// networkOptionReplay sets the configuration of the network as it was
+// journaled. The pools allocated are requested again, for the endpoints
+// to get their addresses back.
+func networkOptionReplay(src *network) NetworkOption {
+	return func(n *network) {
+		n.persist = src.persist
+		n.postIPv6 = src.postIPv6
+		n.gwPriority = src.gwPriority
+		n.scopedDNS = src.scopedDNS
+		n.routeMetric = src.routeMetric
+		n.idempotencyKey = src.idempotencyKey
+		n.loadBalancerIP = src.loadBalancerIP
+		if src.configFrom != "" {
+			// The configuration comes from the configuration network
+			n.configFrom = src.configFrom
+		} else {
+			src.applyConfigurationTo(n)
+			n.addrSpace = src.addrSpace
+			n.ipamV4Config = replayIpamConfig(src.ipamV4Config, src.ipamV4Info)
+			n.ipamV6Config = replayIpamConfig(src.ipamV6Config, src.ipamV6Info)
+		}
+		if src.configOnly {
+			n.configOnly = true
+			return
+		}
+		n.scope = src.scope
+		n.internal = src.internal
+		n.attachable = src.attachable
+		n.ingress = src.ingress
+	}
+}
+
+// replayIpamConfig fills in the pools of the configuration from the ones
+// allocated
+func replayIpamConfig(cfg []*IpamConf, info []*IpamInfo) []*IpamConf {
+	if len(cfg) == 0 && len(info) > 0 {
+		cfg = make([]*IpamConf, len(info))
+		for i := range cfg {
+			cfg[i] = &IpamConf{}
+		}
+	}
+	for i, c := range cfg {
+		if c.PreferredPool != "" || i >= len(info) || info[i].Pool == nil {
+			continue
+		}
+		r := *c
+		r.PreferredPool = info[i].Pool.String()
+		if r.Gateway == "" && info[i].Gateway != nil {
+			r.Gateway = info[i].Gateway.IP.String()
+		}
+		cfg[i] = &r
+	}
+	return cfg
+}
+
+// endpointOptionReplay sets the ID, the addresses and the configuration
+// of the endpoint as it was journaled
+func endpointOptionReplay(src *endpoint) EndpointOption {
+	return func(ep *endpoint) {
+		ep.id = src.id
+		if src.iface != nil {
+			if src.iface.addr != nil {
+				ep.prefAddress = src.iface.addr.IP
+			}
+			if src.iface.addrv6 != nil {
+				ep.prefAddressV6 = src.iface.addrv6.IP
+			}
+			ep.iface.mac = src.iface.mac
+			ep.iface.llAddrs = src.iface.llAddrs
+		}
+		for k, v := range src.generic {
+			ep.generic[k] = v
+		}
+		ep.exposedPorts = src.exposedPorts
+		ep.anonymous = src.anonymous
+		ep.disableResolution = src.disableResolution
+		ep.myAliases = src.myAliases
+		ep.svcName = src.svcName
+		ep.svcID = src.svcID
+		ep.virtualIP = src.virtualIP
+		ep.ingressPorts = src.ingressPorts
+		ep.svcAliases = src.svcAliases
+		ep.loadBalancer = src.loadBalancer
+		ep.staticRoutes = src.staticRoutes
+		ep.idempotencyKey = src.idempotencyKey
+		ep.stableMAC = src.stableMAC
+		ep.macKey = src.macKey
+	}
+}
+
+func journalDiag(ctx interface{}, w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	diagnostic.DebugHTTPForm(r)
+	_, json := diagnostic.ParseHTTPFormOptions(r)
+
+	// audit logs
+	log := logrus.WithFields(logrus.Fields{"component": "diagnostic", "remoteIP": r.RemoteAddr, "method": caller.Name(0), "url": r.URL.String()})
+	log.Info("operation journal")
+
+	c, ok := ctx.(*controller)
+	if !ok {
+		diagnostic.HTTPReply(w, diagnostic.FailCommand(fmt.Errorf("controller not available")), json)
+		return
+	}
+
+	var limit int
+	if v := r.Form.Get("limit"); v != "" {
+		var err error
+		if limit, err = strconv.Atoi(v); err != nil {
+			diagnostic.HTTPReply(w, diagnostic.WrongCommand("journal", "[limit=<entries>] [nid=<network id>]"), json)
+			return
+		}
+	}
+	entries, err := c.OperationJournal(0)
+	if err != nil {
+		log.WithError(err).Error("operation journal failed")
+		diagnostic.HTTPReply(w, diagnostic.FailCommand(err), json)
+		return
+	}
+	if nid := r.Form.Get("nid"); nid != "" {
+		var l []journal.Entry
+		for _, e := range entries {
+			if e.NetworkID == nid {
+				l = append(l, e)
+			}
+		}
+		entries = l
+	}
+	if limit > 0 && len(entries) > limit {
+		entries = entries[len(entries)-limit:]
+	}
+	diagnostic.HTTPReply(w, diagnostic.CommandSucceed(&journalResult{Entries: entries}), json)
+}
+
+func replayJournalDiag(ctx interface{}, w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	diagnostic.DebugHTTPForm(r)
+	_, json := diagnostic.ParseHTTPFormOptions(r)
+
+	// audit logs
+	log := logrus.WithFields(logrus.Fields{"component": "diagnostic", "remoteIP": r.RemoteAddr, "method": caller.Name(0), "url": r.URL.String()})
+	log.Info("replay operation journal")
+
+	c, ok := ctx.(*controller)
+	if !ok {
+		diagnostic.HTTPReply(w, diagnostic.FailCommand(fmt.Errorf("controller not available")), json)
+		return
+	}
+
+	res, err := c.ReplayJournal()
+	if err != nil {
+		log.WithError(err).Error("replay operation journal failed")
+		diagnostic.HTTPReply(w, diagnostic.FailCommand(err), json)
+		return
+	}
+	diagnostic.HTTPReply(w, diagnostic.CommandSucceed(res), json)
+}
@@ -0,0 +1,112 @@
+package libnetwork
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/docker/libnetwork/config"
+	"github.com/docker/libnetwork/types"
+)
+
+// opLimit is the concurrency cap and the token bucket applied to the driver
+// calls of an operation
+type opLimit struct {
+	cfg    config.DriverOpLimit
+	slots  chan struct{}
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// opLimiter holds the limits of the driver calls by operation, as last
+// configured. The calls in progress when a limit is changed are released on
+// the previous one.
+type opLimiter struct {
+	mu     sync.Mutex
+	limits map[string]*opLimit
+}
+
+// limit returns the limit of the operation for its configuration, nil when
+// the calls are not limited
+func (l *opLimiter) limit(op string, cfg config.DriverOpLimit) *opLimit {
+	if cfg.Concurrency <= 0 && cfg.Rate <= 0 {
+		return nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if ol, ok := l.limits[op]; ok && ol.cfg == cfg {
+		return ol
+	}
+	ol := &opLimit{cfg: cfg, tokens: float64(bucketSize(cfg)), last: time.Now()}
+	if cfg.Concurrency > 0 {
+		ol.slots = make(chan struct{}, cfg.Concurrency)
+	}
+	if l.limits == nil {
+		l.limits = make(map[string]*opLimit)
+	}
+	l.limits[op] = ol
+	return ol
+}
+
+// bucketSize returns the number of tokens of the bucket, one at least
+func bucketSize(cfg config.DriverOpLimit) int {
+	if cfg.Burst < 1 {
+		return 1
+	}
+	return cfg.Burst
+}
+
+// wait takes a token of the bucket, waiting for it to be refilled when it
+// is empty, or gives it back when the context is done first
+func (ol *opLimit) wait(ctx context.Context) error {
+	if ol.cfg.Rate <= 0 {
+		return nil
+	}
+
+	ol.mu.Lock()
+	now := time.Now()
+	ol.tokens = math.Min(float64(bucketSize(ol.cfg)), ol.tokens+now.Sub(ol.last).Seconds()*ol.cfg.Rate)
+	ol.last = now
+	ol.tokens--
+	delay := time.Duration(-ol.tokens / ol.cfg.Rate * float64(time.Second))
+	ol.mu.Unlock()
+	if delay <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		ol.mu.Lock()
+		ol.tokens++
+		ol.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+// acquireDriverOp waits for the limit of the operation to allow a driver
+// call and returns the function to call once it is done
+func (c *controller) acquireDriverOp(ctx context.Context, op string) (func(), error) {
+	ol := c.opLimiter.limit(op, c.Config().Daemon.DriverOpLimits[op])
+	if ol == nil {
+		return func() {}, nil
+	}
+	if err := ol.wait(ctx); err != nil {
+		return nil, types.TimeoutErrorf("%s throttled: %v", op, err)
+	}
+	if ol.slots == nil {
+		return func() {}, nil
+	}
+	select {
+	case ol.slots <- struct{}{}:
+		return func() { <-ol.slots }, nil
+	case <-ctx.Done():
+		return nil, types.TimeoutErrorf("%s throttled: %v", op, ctx.Err())
+	}
+}
@@ -0,0 +1,170 @@
+package libnetwork
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/libnetwork/driverapi"
+	"github.com/docker/libnetwork/otlp"
+	"github.com/sirupsen/logrus"
+)
+
+// opStep is the time spent in a step of a timed operation, the calls of a
+// same step adding up
+type opStep struct {
+	name string
+	d    time.Duration
+}
+
+// opDurationMetric is the histogram of the durations of the timed
+// operations exported over OTLP
+const opDurationMetric = "libnetwork.operation.duration"
+
+// opTiming times a call of an endpoint operation and its steps, across the
+// controller and the driver, and logs their breakdown when the call goes
+// over the latency budget. With an OTLP exporter the duration of the call
+// is exported as a metric and, when its trace is sampled, the call and
+// each of its steps as spans. A nil opTiming times nothing.
+type opTiming struct {
+	op       string
+	id       string
+	budget   time.Duration
+	start    time.Time
+	exporter *otlp.Exporter
+	trace    [16]byte
+	span     [8]byte
+	sampled  bool
+	mu       sync.Mutex
+	steps    []opStep
+	spans    []otlp.Span
+}
+
+// startOpTiming starts timing a call of the operation on the object, when
+// a latency budget or an OTLP exporter is configured
+func (c *controller) startOpTiming(op, id string) *opTiming {
+	budget := c.Config().Daemon.OpLatencyBudget
+	if budget <= 0 && c.otlpExporter == nil {
+		return nil
+	}
+	t := &opTiming{op: op, id: id, budget: budget, start: time.Now(), exporter: c.otlpExporter}
+	if t.exporter != nil && t.exporter.Sample() {
+		t.sampled = true
+		t.trace = otlp.NewTraceID()
+		t.span = otlp.NewSpanID()
+	}
+	return t
+}
+
+// TimeStep starts timing a step of the operation, the returned function
+// ends it
+func (t *opTiming) TimeStep(name string) func() {
+	if t == nil {
+		return func() {}
+	}
+	start := time.Now()
+	return func() {
+		end := time.Now()
+		t.add(name, end.Sub(start))
+		if t.sampled {
+			t.addSpan(name, start, end)
+		}
+	}
+}
+
+func (t *opTiming) add(name string, d time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for i := range t.steps {
+		if t.steps[i].name == name {
+			t.steps[i].d += d
+			return
+		}
+	}
+	t.steps = append(t.steps, opStep{name: name, d: d})
+}
+
+// addSpan records the span of a step, a child of the span of the call
+func (t *opTiming) addSpan(name string, start, end time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.spans = append(t.spans, otlp.Span{
+		TraceID:  t.trace,
+		SpanID:   otlp.NewSpanID(),
+		ParentID: t.span,
+		Name:     t.op + "/" + name,
+		Start:    start,
+		End:      end,
+	})
+}
+
+// context returns a copy of the context carrying the timing to the drivers
+func (t *opTiming) context(ctx context.Context) context.Context {
+	if t == nil {
+		return ctx
+	}
+	return driverapi.WithStepTimer(ctx, t)
+}
+
+// end ends the call, exports it and logs the breakdown of its steps when
+// it went over the budget
+func (t *opTiming) end() {
+	if t == nil {
+		return
+	}
+	now := time.Now()
+	d := now.Sub(t.start)
+	if t.exporter != nil {
+		t.export(now, d)
+	}
+	if t.budget <= 0 || d <= t.budget {
+		return
+	}
+	logrus.WithFields(logrus.Fields{
+		"op":       t.op,
+		"id":       t.id,
+		"duration": d,
+		"budget":   t.budget,
+	}).Warnf("%s of %s took %s, over its budget of %s: %s", t.op, t.id, d.Round(time.Microsecond), t.budget, t.breakdown(d))
+}
+
+// export records the duration of the call and, when sampled, exports its
+// span and the spans of its steps
+func (t *opTiming) export(end time.Time, d time.Duration) {
+	t.exporter.RecordDuration(opDurationMetric, map[string]string{"operation": t.op}, d)
+	if !t.sampled {
+		return
+	}
+	t.mu.Lock()
+	spans := t.spans
+	t.mu.Unlock()
+	t.exporter.ExportSpan(otlp.Span{
+		TraceID:    t.trace,
+		SpanID:     t.span,
+		Name:       t.op,
+		Start:      t.start,
+		End:        end,
+		Attributes: map[string]string{"operation": t.op, "id": t.id},
+	})
+	for _, s := range spans {
+		t.exporter.ExportSpan(s)
+	}
+}
+
+// breakdown renders the time spent in each step and the remainder spent
+// out of them
+func (t *opTiming) breakdown(total time.Duration) string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	parts := make([]string, 0, len(t.steps)+1)
+	var timed time.Duration
+	for _, s := range t.steps {
+		parts = append(parts, s.name+"="+s.d.Round(time.Microsecond).String())
+		timed += s.d
+	}
+	if other := total - timed; other > 0 {
+		parts = append(parts, "other="+other.Round(time.Microsecond).String())
+	}
+	return strings.Join(parts, " ")
+}
@@ -51,7 +51,8 @@ func NewGeneric() Generic {
 
 // GenerateFromModel takes the generic options, and tries to build a new
 // instance of the model's type by matching keys from the generic options to
-// fields in the model.
+// fields in the model. A value is set to a field of an interface type it
+// implements.
 //
 // The return value is of the same type than the model (including a potential
 // pointer qualifier).
@@ -74,7 +75,7 @@ func GenerateFromModel(options Generic, model interface{}) (interface{}, error)
 		if !field.CanSet() {
 			return nil, CannotSetFieldError{name, resType.String()}
 		}
-		if reflect.TypeOf(value) != field.Type() {
+		if !reflect.TypeOf(value).AssignableTo(field.Type()) {
 			return nil, TypeMismatchError{name, field.Type().String(), reflect.TypeOf(value).String()}
 		}
 		field.Set(reflect.ValueOf(value))
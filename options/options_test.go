@@ -1,6 +1,8 @@
 package options
 
 import (
+	"fmt"
+	"net"
 	"reflect"
 	"strings"
 	"testing"
@@ -96,6 +98,22 @@ func TestFieldCannotBeSet(t *testing.T) {
 	}
 }
 
+func TestGenerateInterfaceField(t *testing.T) {
+	type Model struct{ Foo fmt.Stringer }
+	gen := Generic{"Foo": net.ParseIP("10.0.0.1")}
+	result, err := GenerateFromModel(gen, Model{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := result.(Model).Foo.String(); s != "10.0.0.1" {
+		t.Fatalf("unexpected field %s", s)
+	}
+
+	if _, err := GenerateFromModel(Generic{"Foo": 1}, Model{}); err == nil {
+		t.Fatal("expected a TypeMismatchError for a value not implementing the interface")
+	}
+}
+
 func TestTypeMismatchError(t *testing.T) {
 	type Model struct{ Foo int }
 	_, err := GenerateFromModel(Generic{"Foo": "bar"}, Model{})
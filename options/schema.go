@@ -0,0 +1,260 @@
+package options
+
+import (
+	"fmt"
+	"net"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Kind is the kind of value an option takes
+type Kind int
+
+// The kinds of the option values, and the types they are parsed into
+const (
+	// String is a string
+	String Kind = iota
+	// Bool is a bool, as parsed by strconv.ParseBool
+	Bool
+	// Int is an int
+	Int
+	// Duration is a time.Duration, as parsed by time.ParseDuration
+	Duration
+	// IP is a net.IP
+	IP
+	// CIDR is a *net.IPNet
+	CIDR
+	// Custom is parsed by the Parse function of the option
+	Custom
+)
+
+var kindNames = map[Kind]string{
+	String:   "string",
+	Bool:     "bool",
+	Int:      "int",
+	Duration: "duration",
+	IP:       "ip",
+	CIDR:     "cidr",
+	Custom:   "custom",
+}
+
+func (k Kind) String() string {
+	if n, ok := kindNames[k]; ok {
+		return n
+	}
+	return fmt.Sprintf("kind(%d)", int(k))
+}
+
+// InvalidValueError is the error returned when the value of an option does
+// not parse or validate.
+type InvalidValueError struct {
+	Option string
+	Value  interface{}
+	Reason string
+}
+
+func (e InvalidValueError) Error() string {
+	return fmt.Sprintf("failed to parse %s value: %v (%s)", e.Option, e.Value, e.Reason)
+}
+
+// BadRequest denotes the type of this error
+func (e InvalidValueError) BadRequest() {}
+
+// Spec describes an option of a component, the label carrying it and the
+// field of the configuration structure it sets
+type Spec struct {
+	// Name is the label carrying the option.
+	Name string
+	// Field is the name of the configuration structure field set.
+	Field string
+	// Kind is the kind of the value.
+	Kind Kind
+	// Parse parses the values of the Custom options.
+	Parse func(value string) (interface{}, error)
+	// Validate, when set, checks the parsed value.
+	Validate func(value interface{}) error
+	// Default is the value of the option when the label is absent, none
+	// when empty.
+	Default string
+	// Doc describes the option.
+	Doc string
+}
+
+// Schema is the set of options a component accepts
+type Schema []Spec
+
+// Lookup returns the specification of the option
+func (s Schema) Lookup(name string) (Spec, bool) {
+	for _, sp := range s {
+		if sp.Name == name {
+			return sp, true
+		}
+	}
+	return Spec{}, false
+}
+
+// parse parses and validates the string form of the value
+func (sp Spec) parse(value string) (interface{}, error) {
+	var (
+		v   interface{}
+		err error
+	)
+	switch sp.Kind {
+	case String:
+		v = value
+	case Bool:
+		v, err = strconv.ParseBool(value)
+	case Int:
+		v, err = strconv.Atoi(value)
+	case Duration:
+		v, err = time.ParseDuration(value)
+	case IP:
+		ip := net.ParseIP(value)
+		if ip == nil {
+			return nil, InvalidValueError{sp.Name, value, "nil ip"}
+		}
+		v = ip
+	case CIDR:
+		_, v, err = net.ParseCIDR(value)
+	case Custom:
+		if sp.Parse == nil {
+			return nil, fmt.Errorf("option %s has no parser", sp.Name)
+		}
+		v, err = sp.Parse(value)
+	default:
+		return nil, fmt.Errorf("option %s has unknown kind %v", sp.Name, sp.Kind)
+	}
+	if err != nil {
+		return nil, InvalidValueError{sp.Name, value, err.Error()}
+	}
+	return sp.validate(v)
+}
+
+func (sp Spec) validate(v interface{}) (interface{}, error) {
+	if sp.Validate != nil {
+		if err := sp.Validate(v); err != nil {
+			return nil, InvalidValueError{sp.Name, v, err.Error()}
+		}
+	}
+	return v, nil
+}
+
+// Apply parses the labels carrying the options of the schema into the
+// fields of dst, a pointer to the configuration structure. The options
+// with no label get their default, when they have one. The labels out of
+// the schema are ignored, they may be meant for other components.
+func (s Schema) Apply(labels map[string]string, dst interface{}) error {
+	generic := make(map[string]interface{}, len(labels))
+	for k, v := range labels {
+		generic[k] = v
+	}
+	return s.ApplyGeneric(generic, dst)
+}
+
+// ApplyGeneric behaves as Apply for the generic options, whose values are
+// either of the type of the field they set or their string form. A value of
+// any other type is an InvalidValueError.
+func (s Schema) ApplyGeneric(opts map[string]interface{}, dst interface{}) error {
+	res, err := s.structOf(dst)
+	if err != nil {
+		return err
+	}
+
+	for _, sp := range s {
+		field := res.FieldByName(sp.Field)
+		if !field.IsValid() {
+			return NoSuchFieldError{sp.Field, res.Type().String()}
+		}
+		if !field.CanSet() {
+			return CannotSetFieldError{sp.Field, res.Type().String()}
+		}
+
+		raw, ok := opts[sp.Name]
+		if !ok {
+			if sp.Default == "" {
+				continue
+			}
+			raw = sp.Default
+		}
+
+		var v interface{}
+		switch {
+		case raw != nil && reflect.TypeOf(raw) == field.Type():
+			if v, err = sp.validate(raw); err != nil {
+				return err
+			}
+		default:
+			str, isString := raw.(string)
+			if !isString {
+				return InvalidValueError{sp.Name, raw, fmt.Sprintf("expected %s, got %T", field.Type(), raw)}
+			}
+			if v, err = sp.parse(str); err != nil {
+				return err
+			}
+		}
+
+		if reflect.TypeOf(v) != field.Type() {
+			return TypeMismatchError{sp.Field, field.Type().String(), reflect.TypeOf(v).String()}
+		}
+		field.Set(reflect.ValueOf(v))
+	}
+	return nil
+}
+
+// Check verifies that each option of the schema sets a field of the
+// model, and that its default parses into the type of the field
+func (s Schema) Check(model interface{}) error {
+	res, err := s.structOf(reflect.New(reflect.Indirect(reflect.ValueOf(model)).Type()).Interface())
+	if err != nil {
+		return err
+	}
+	seen := make(map[string]bool, len(s))
+	for _, sp := range s {
+		if seen[sp.Name] {
+			return fmt.Errorf("option %s is declared twice", sp.Name)
+		}
+		seen[sp.Name] = true
+		field := res.FieldByName(sp.Field)
+		if !field.IsValid() {
+			return NoSuchFieldError{sp.Field, res.Type().String()}
+		}
+		if sp.Default == "" {
+			continue
+		}
+		v, err := sp.parse(sp.Default)
+		if err != nil {
+			return err
+		}
+		if reflect.TypeOf(v) != field.Type() {
+			return TypeMismatchError{sp.Field, field.Type().String(), reflect.TypeOf(v).String()}
+		}
+	}
+	return nil
+}
+
+func (s Schema) structOf(dst interface{}) (reflect.Value, error) {
+	v := reflect.ValueOf(dst)
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return reflect.Value{}, fmt.Errorf("options destination must be a pointer to a structure, not %T", dst)
+	}
+	return v.Elem(), nil
+}
+
+// Doc renders the documentation of the options, sorted by name
+func (s Schema) Doc() string {
+	specs := append(Schema{}, s...)
+	sort.Slice(specs, func(i, j int) bool { return specs[i].Name < specs[j].Name })
+
+	var b strings.Builder
+	for _, sp := range specs {
+		fmt.Fprintf(&b, "%s (%s", sp.Name, sp.Kind)
+		if sp.Default != "" {
+			fmt.Fprintf(&b, ", default %s", sp.Default)
+		}
+		fmt.Fprintf(&b, "): %s\n", sp.Doc)
+	}
+	return b.String()
+}
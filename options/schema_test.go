@@ -0,0 +1,107 @@
+package options
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+type schemaModel struct {
+	Name    string
+	Enabled bool
+	Count   int
+	Timeout time.Duration
+	Addr    net.IP
+	Subnet  *net.IPNet
+	Parts   []string
+}
+
+var testSchema = Schema{
+	{Name: "name", Field: "Name", Kind: String, Doc: "the name"},
+	{Name: "enabled", Field: "Enabled", Kind: Bool, Default: "true", Doc: "enabled"},
+	{Name: "count", Field: "Count", Kind: Int, Doc: "a count",
+		Validate: func(v interface{}) error {
+			if v.(int) < 0 {
+				return errors.New("negative count")
+			}
+			return nil
+		}},
+	{Name: "timeout", Field: "Timeout", Kind: Duration, Doc: "a timeout"},
+	{Name: "addr", Field: "Addr", Kind: IP, Doc: "an address"},
+	{Name: "subnet", Field: "Subnet", Kind: CIDR, Doc: "a subnet"},
+	{Name: "parts", Field: "Parts", Kind: Custom, Doc: "comma separated parts",
+		Parse: func(v string) (interface{}, error) { return strings.Split(v, ","), nil }},
+}
+
+func TestSchemaApply(t *testing.T) {
+	if err := testSchema.Check(schemaModel{}); err != nil {
+		t.Fatal(err)
+	}
+
+	var m schemaModel
+	err := testSchema.Apply(map[string]string{
+		"name":    "n1",
+		"count":   "3",
+		"timeout": "2s",
+		"addr":    "10.0.0.1",
+		"subnet":  "10.1.0.0/16",
+		"parts":   "a,b",
+		"other":   "ignored",
+	}, &m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Name != "n1" || !m.Enabled || m.Count != 3 || m.Timeout != 2*time.Second ||
+		!m.Addr.Equal(net.ParseIP("10.0.0.1")) || m.Subnet.String() != "10.1.0.0/16" || len(m.Parts) != 2 {
+		t.Fatalf("unexpected options %+v", m)
+	}
+
+	for _, c := range []struct{ label, value, reason string }{
+		{"enabled", "maybe", "invalid syntax"},
+		{"count", "-1", "negative count"},
+		{"addr", "nope", "nil ip"},
+	} {
+		err := testSchema.Apply(map[string]string{c.label: c.value}, &schemaModel{})
+		if _, ok := err.(InvalidValueError); !ok || !strings.Contains(err.Error(), c.reason) {
+			t.Fatalf("expected an invalid %s value error, got %v", c.label, err)
+		}
+	}
+}
+
+func TestSchemaApplyGeneric(t *testing.T) {
+	var m schemaModel
+	if err := testSchema.ApplyGeneric(map[string]interface{}{"enabled": false, "count": "5"}, &m); err != nil {
+		t.Fatal(err)
+	}
+	if m.Enabled || m.Count != 5 {
+		t.Fatalf("unexpected options %+v", m)
+	}
+
+	err := testSchema.ApplyGeneric(map[string]interface{}{"enabled": 1}, &m)
+	if _, ok := err.(InvalidValueError); !ok {
+		t.Fatalf("expected an invalid value error for a value of the wrong type, got %v", err)
+	}
+
+	if err := testSchema.Apply(nil, m); err == nil {
+		t.Fatal("expected an error for a destination which is not a pointer")
+	}
+}
+
+func TestSchemaCheck(t *testing.T) {
+	bad := append(Schema{}, testSchema...)
+	bad = append(bad, Spec{Name: "missing", Field: "Missing", Kind: String})
+	if _, ok := bad.Check(schemaModel{}).(NoSuchFieldError); !ok {
+		t.Fatal("expected an error for an option with no field")
+	}
+
+	bad = Schema{{Name: "count", Field: "Count", Kind: String, Default: "1"}}
+	if _, ok := bad.Check(&schemaModel{}).(TypeMismatchError); !ok {
+		t.Fatal("expected an error for an option of the wrong kind")
+	}
+
+	if doc := testSchema.Doc(); !strings.HasPrefix(doc, "addr (ip): an address\n") || !strings.Contains(doc, "enabled (bool, default true): enabled\n") {
+		t.Fatalf("unexpected documentation %q", doc)
+	}
+}
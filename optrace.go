@@ -0,0 +1,170 @@
+package libnetwork
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime/trace"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/libnetwork/diagnostic"
+	"github.com/docker/libnetwork/internal/caller"
+	"github.com/sirupsen/logrus"
+)
+
+// The operations whose execution can be traced
+const (
+	opNetworkCreate  = "network-create"
+	opEndpointCreate = "endpoint-create"
+	opSandboxCreate  = "sandbox-create"
+	opJoin           = "join"
+	opLeave          = "leave"
+)
+
+var tracedOps = []string{opNetworkCreate, opEndpointCreate, opSandboxCreate, opJoin, opLeave}
+
+// opTracePaths2Func are the diagnostic handlers of the operation traces
+var opTracePaths2Func = map[string]diagnostic.HTTPHandlerFunc{
+	"/traceops": opTraceDiag,
+}
+
+// opTracer captures a runtime execution trace of each of the next calls of
+// the armed operations. The runtime traces one call at a time, the calls
+// made while a trace is running are not traced.
+type opTracer struct {
+	sync.Mutex
+	armed  map[string]int
+	active bool
+	traces []string
+}
+
+// arm requests the trace of the next count calls of the operation, zero
+// disarming it
+func (t *opTracer) arm(op string, count int) {
+	t.Lock()
+	defer t.Unlock()
+	if t.armed == nil {
+		t.armed = map[string]int{}
+	}
+	if count <= 0 {
+		delete(t.armed, op)
+		return
+	}
+	t.armed[op] = count
+}
+
+// start starts tracing the call of the operation when armed, the returned
+// function stops it
+func (t *opTracer) start(op string) func() {
+	t.Lock()
+	defer t.Unlock()
+	if t.armed[op] == 0 || t.active {
+		return func() {}
+	}
+
+	path := filepath.Join(os.TempDir(), fmt.Sprintf("libnetwork-%s-%s.trace", op, time.Now().Format("20060102T150405.000000000")))
+	f, err := os.Create(path)
+	if err != nil {
+		logrus.Warnf("Failed to create the trace file of %s: %v", op, err)
+		return func() {}
+	}
+	if err := trace.Start(f); err != nil {
+		logrus.Warnf("Failed to trace %s: %v", op, err)
+		f.Close()
+		os.Remove(path)
+		return func() {}
+	}
+	t.active = true
+	if t.armed[op]--; t.armed[op] == 0 {
+		delete(t.armed, op)
+	}
+
+	return func() {
+		trace.Stop()
+		f.Close()
+		t.Lock()
+		t.active = false
+		t.traces = append(t.traces, path)
+		t.Unlock()
+		logrus.Infof("Execution trace of %s written to %s", op, path)
+	}
+}
+
+type opTraceResult struct {
+	Armed  map[string]int `json:"armed"`
+	Traces []string       `json:"traces"`
+}
+
+func (r *opTraceResult) String() string {
+	var b strings.Builder
+	ops := make([]string, 0, len(r.Armed))
+	for op := range r.Armed {
+		ops = append(ops, op)
+	}
+	sort.Strings(ops)
+	for _, op := range ops {
+		fmt.Fprintf(&b, "armed %s: %d\n", op, r.Armed[op])
+	}
+	for _, p := range r.Traces {
+		fmt.Fprintf(&b, "trace %s\n", p)
+	}
+	return b.String()
+}
+
+func (t *opTracer) result() *opTraceResult {
+	t.Lock()
+	defer t.Unlock()
+	r := &opTraceResult{Armed: map[string]int{}, Traces: append([]string{}, t.traces...)}
+	for op, n := range t.armed {
+		r.Armed[op] = n
+	}
+	return r
+}
+
+// opTraceDiag arms the trace of the next "count" calls of the "op"
+// operation, and lists the armed operations and the traces written
+func opTraceDiag(ctx interface{}, w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	diagnostic.DebugHTTPForm(r)
+	_, json := diagnostic.ParseHTTPFormOptions(r)
+
+	// audit logs
+	log := logrus.WithFields(logrus.Fields{"component": "diagnostic", "remoteIP": r.RemoteAddr, "method": caller.Name(0), "url": r.URL.String()})
+	log.Info("trace operations")
+
+	c, ok := ctx.(*controller)
+	if !ok {
+		diagnostic.HTTPReply(w, diagnostic.FailCommand(fmt.Errorf("controller not available")), json)
+		return
+	}
+
+	if op := r.FormValue("op"); op != "" {
+		valid := false
+		for _, o := range tracedOps {
+			valid = valid || o == op
+		}
+		if !valid {
+			rsp := diagnostic.WrongCommand("unknown operation", fmt.Sprintf("%s?op=<%s>&count=n", r.URL.Path, strings.Join(tracedOps, "|")))
+			diagnostic.HTTPReply(w, rsp, json)
+			return
+		}
+		count := 1
+		if v := r.FormValue("count"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				diagnostic.HTTPReply(w, diagnostic.FailCommand(fmt.Errorf("invalid count %q: %v", v, err)), json)
+				return
+			}
+			count = n
+		}
+		c.opTracer.arm(op, count)
+		log.Infof("armed the trace of the next %d %s calls", count, op)
+	}
+
+	diagnostic.HTTPReply(w, diagnostic.CommandSucceed(c.opTracer.result()), json)
+}
@@ -0,0 +1,85 @@
+package libnetwork
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestOpTracer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "optrace")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer os.Setenv("TMPDIR", os.Getenv("TMPDIR"))
+	os.Setenv("TMPDIR", dir)
+	var tr opTracer
+
+	// An operation not armed is not traced
+	tr.start(opJoin)()
+	if r := tr.result(); len(r.Traces) != 0 {
+		t.Fatalf("unexpected traces %v", r.Traces)
+	}
+
+	tr.arm(opJoin, 2)
+	tr.arm(opLeave, 1)
+	stop := tr.start(opJoin)
+	// The runtime traces one call at a time
+	tr.start(opLeave)()
+	stop()
+	if r := tr.result(); r.Armed[opJoin] != 1 || r.Armed[opLeave] != 1 || len(r.Traces) != 1 {
+		t.Fatalf("unexpected state %+v after the first trace", r)
+	}
+
+	tr.start(opJoin)()
+	tr.start(opLeave)()
+	r := tr.result()
+	if len(r.Armed) != 0 || len(r.Traces) != 3 {
+		t.Fatalf("unexpected state %+v once the calls are traced", r)
+	}
+	for _, p := range r.Traces {
+		if fi, err := os.Stat(p); err != nil || fi.Size() == 0 {
+			t.Fatalf("trace %s not written: %v", p, err)
+		}
+	}
+	if !strings.Contains(filepath.Base(r.Traces[2]), opLeave) {
+		t.Fatalf("unexpected trace file %s of the leave", r.Traces[2])
+	}
+
+	// The traces listed are a copy, and a zero count disarms
+	r.Traces[0] = ""
+	tr.arm(opSandboxCreate, 3)
+	tr.arm(opSandboxCreate, 0)
+	if r := tr.result(); r.Traces[0] == "" || len(r.Armed) != 0 {
+		t.Fatalf("unexpected state %+v", r)
+	}
+}
+
+func TestOpTraceDiag(t *testing.T) {
+	c := &controller{}
+	reply := func(query string) string {
+		w := httptest.NewRecorder()
+		opTraceDiag(c, w, httptest.NewRequest("GET", "/traceops?"+query, nil))
+		return w.Body.String()
+	}
+
+	if out := reply("op=" + opNetworkCreate + "&count=2"); !strings.Contains(out, "armed network-create: 2") {
+		t.Fatalf("unexpected reply %s", out)
+	}
+	if out := reply("op=" + opJoin); !strings.Contains(out, "armed join: 1") || !strings.Contains(out, "armed network-create: 2") {
+		t.Fatalf("unexpected reply %s", out)
+	}
+	if out := reply("op=delete"); strings.Contains(out, "armed") {
+		t.Fatalf("unknown operation armed: %s", out)
+	}
+	if out := reply("op=" + opJoin + "&count=many"); !strings.Contains(out, "invalid count") {
+		t.Fatalf("unexpected reply %s", out)
+	}
+	if r := c.opTracer.result(); len(r.Armed) != 2 {
+		t.Fatalf("unexpected armed operations %v", r.Armed)
+	}
+}
@@ -0,0 +1,27 @@
+package libnetwork
+
+import (
+	"github.com/docker/libnetwork/driverapi"
+	"github.com/sirupsen/logrus"
+)
+
+// CollectOrphans removes the kernel objects bearing the libnetwork tags
+// whose owner is gone, as left behind by a crash: the host routes not added
+// since the start, and the objects of the unknown endpoints of the drivers
+// implementing driverapi.OrphanCollector. It is meant to run once the
+// state got restored, the objects of the other owners are left alone.
+func (c *controller) CollectOrphans() int {
+	count := c.collectOrphanRoutes()
+	c.drvRegistry.WalkDrivers(func(name string, driver driverapi.Driver, capability driverapi.Capability) bool {
+		oc, ok := driver.(driverapi.OrphanCollector)
+		if !ok {
+			return false
+		}
+		if n := oc.CollectOrphans(); n > 0 {
+			logrus.Infof("Driver %s removed %d orphaned kernel objects", name, n)
+			count += n
+		}
+		return false
+	})
+	return count
+}
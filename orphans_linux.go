@@ -0,0 +1,28 @@
+package libnetwork
+
+import (
+	"github.com/docker/libnetwork/netutils"
+	"github.com/docker/libnetwork/ns"
+	"github.com/sirupsen/logrus"
+)
+
+// collectOrphanRoutes removes the host routes tagged as owned which were
+// not added since the start
+func (c *controller) collectOrphanRoutes() int {
+	nlh := ns.NlHandle()
+	routes, err := netutils.OrphanRoutes(nlh)
+	if err != nil {
+		logrus.Warnf("Failed to list the orphaned routes: %v", err)
+		return 0
+	}
+	var count int
+	for i := range routes {
+		logrus.Infof("Removing orphaned route %s", routes[i].String())
+		if err := nlh.RouteDel(&routes[i]); err != nil {
+			logrus.Warnf("Failed to remove orphaned route %s: %v", routes[i].String(), err)
+			continue
+		}
+		count++
+	}
+	return count
+}
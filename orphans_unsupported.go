@@ -0,0 +1,7 @@
+// +build !linux
+
+package libnetwork
+
+func (c *controller) collectOrphanRoutes() int {
+	return 0
+}
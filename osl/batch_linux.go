@@ -0,0 +1,382 @@
+package osl
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"syscall"
+	"time"
+
+	"github.com/docker/libnetwork/ns"
+	"github.com/sirupsen/logrus"
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+	"github.com/vishvananda/netns"
+)
+
+// maxInflight bounds the number of requests sent before reading their
+// acknowledgements, so that these do not overflow the socket buffer
+const maxInflight = 64
+
+type batchIface struct {
+	*nwIface
+	link netlink.Link
+	err  bool
+}
+
+type nlStep struct {
+	iface *batchIface
+	name  string
+	req   *nl.NetlinkRequest
+}
+
+// nlBatch pipelines netlink requests on a single socket
+type nlBatch struct {
+	sock   *nl.NetlinkSocket
+	steps  []*nlStep
+	failed []*StepError
+}
+
+func (b *nlBatch) fail(i *batchIface, step string, err error) {
+	i.err = true
+	b.failed = append(b.failed, &StepError{Interface: i.srcName, Step: step, Err: err})
+}
+
+func (b *nlBatch) add(i *batchIface, step string, req *nl.NetlinkRequest) {
+	b.steps = append(b.steps, &nlStep{iface: i, name: step, req: req})
+}
+
+// flush sends the queued requests and waits for their acknowledgements
+func (b *nlBatch) flush() {
+	steps := b.steps
+	b.steps = nil
+	for len(steps) > 0 {
+		cnt := len(steps)
+		if cnt > maxInflight {
+			cnt = maxInflight
+		}
+		b.exchange(steps[:cnt])
+		steps = steps[cnt:]
+	}
+}
+
+func (b *nlBatch) exchange(steps []*nlStep) {
+	pending := make(map[uint32]*nlStep, len(steps))
+	for _, s := range steps {
+		if err := b.sock.Send(s.req); err != nil {
+			b.fail(s.iface, s.name, err)
+			continue
+		}
+		pending[s.req.Seq] = s
+	}
+
+	for len(pending) > 0 {
+		msgs, err := b.sock.Receive()
+		if err != nil {
+			for _, s := range pending {
+				b.fail(s.iface, s.name, err)
+			}
+			return
+		}
+		for _, m := range msgs {
+			s, ok := pending[m.Header.Seq]
+			if !ok || m.Header.Type != syscall.NLMSG_ERROR {
+				continue
+			}
+			delete(pending, m.Header.Seq)
+			if errno := int32(nl.NativeEndian().Uint32(m.Data[0:4])); errno != 0 {
+				b.fail(s.iface, s.name, syscall.Errno(-errno))
+			}
+		}
+	}
+}
+
+func linkRequest(proto int, index int) (*nl.NetlinkRequest, *nl.IfInfomsg) {
+	req := nl.NewNetlinkRequest(proto, syscall.NLM_F_ACK)
+	msg := nl.NewIfInfomsg(syscall.AF_UNSPEC)
+	msg.Index = int32(index)
+	req.AddData(msg)
+	return req, msg
+}
+
+func linkFlagsRequest(index int, up bool) *nl.NetlinkRequest {
+	req, msg := linkRequest(syscall.RTM_NEWLINK, index)
+	msg.Change = syscall.IFF_UP
+	if up {
+		msg.Flags = syscall.IFF_UP
+	}
+	return req
+}
+
+func linkAttrRequest(index int, attr int, data []byte) *nl.NetlinkRequest {
+	req, _ := linkRequest(syscall.RTM_SETLINK, index)
+	req.AddData(nl.NewRtAttr(attr, data))
+	return req
+}
+
+func addrRequest(index int, addr *net.IPNet, flags int) *nl.NetlinkRequest {
+	req := nl.NewNetlinkRequest(syscall.RTM_NEWADDR, syscall.NLM_F_CREATE|syscall.NLM_F_EXCL|syscall.NLM_F_ACK)
+	family := nl.GetIPFamily(addr.IP)
+	msg := nl.NewIfAddrmsg(family)
+	msg.Index = uint32(index)
+	prefixlen, masklen := addr.Mask.Size()
+	msg.Prefixlen = uint8(prefixlen)
+	msg.IfAddrmsg.Flags = uint8(flags)
+	req.AddData(msg)
+
+	local := addr.IP.To16()
+	if family == netlink.FAMILY_V4 {
+		local = addr.IP.To4()
+	}
+	req.AddData(nl.NewRtAttr(syscall.IFA_LOCAL, local))
+	req.AddData(nl.NewRtAttr(syscall.IFA_ADDRESS, local))
+	brd := make(net.IP, masklen/8)
+	for k := range local {
+		brd[k] = local[k] | ^addr.Mask[k]
+	}
+	req.AddData(nl.NewRtAttr(syscall.IFA_BROADCAST, brd))
+	return req
+}
+
+func routeRequest(index int, dst *net.IPNet) *nl.NetlinkRequest {
+	req := nl.NewNetlinkRequest(syscall.RTM_NEWROUTE, syscall.NLM_F_CREATE|syscall.NLM_F_EXCL|syscall.NLM_F_ACK)
+	msg := nl.NewRtMsg()
+	msg.Scope = uint8(netlink.SCOPE_LINK)
+	family := nl.GetIPFamily(dst.IP)
+	msg.Family = uint8(family)
+	prefixlen, _ := dst.Mask.Size()
+	msg.Dst_len = uint8(prefixlen)
+	req.AddData(msg)
+
+	ip := dst.IP.To16()
+	if family == netlink.FAMILY_V4 {
+		ip = dst.IP.To4()
+	}
+	req.AddData(nl.NewRtAttr(syscall.RTA_DST, ip))
+	b := make([]byte, 4)
+	nl.NativeEndian().PutUint32(b, uint32(index))
+	req.AddData(nl.NewRtAttr(syscall.RTA_OIF, b))
+	return req
+}
+
+// batchReceiveTimeout bounds the wait for the acknowledgements of a batch
+const batchReceiveTimeout = 10 * time.Second
+
+// abort fails the interfaces still being configured when the context is done
+func (b *nlBatch) abort(ctx context.Context, ifaces []*batchIface) {
+	err := ctx.Err()
+	if err == nil {
+		return
+	}
+	for _, i := range ifaces {
+		if !i.err {
+			b.fail(i, "configure", err)
+		}
+	}
+}
+
+func (n *networkNamespace) AddInterfaces(reqs ...InterfaceRequest) error {
+	return n.AddInterfacesContext(context.Background(), reqs...)
+}
+
+func (n *networkNamespace) AddInterfacesContext(ctx context.Context, reqs ...InterfaceRequest) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if skip, err := injectNetlinkFault("add interfaces"); skip || err != nil {
+		return err
+	}
+
+	ifaces := make([]*batchIface, 0, len(reqs))
+	bySrc := make(map[string]*batchIface, len(reqs))
+
+	n.Lock()
+	path := n.path
+	isDefault := n.isDefault
+	nlh := n.nlHandle
+	for _, r := range reqs {
+		i := &batchIface{nwIface: &nwIface{srcName: r.SrcName, dstName: r.DstPrefix, ns: n}}
+		i.processInterfaceOptions(r.Options...)
+		if isDefault {
+			i.dstName = i.srcName
+		} else {
+			i.dstName = fmt.Sprintf("%s%d", r.DstPrefix, n.nextIfIndex[r.DstPrefix])
+			n.nextIfIndex[r.DstPrefix]++
+		}
+		ifaces = append(ifaces, i)
+		bySrc[i.srcName] = i
+	}
+	n.Unlock()
+	nlhHost := ns.NlHandle()
+
+	newNs := netns.None()
+	if !isDefault {
+		var err error
+		if newNs, err = netns.GetFromPath(path); err != nil {
+			return fmt.Errorf("failed get network namespace %q: %v", path, err)
+		}
+		defer newNs.Close()
+	}
+	sock, err := nl.GetNetlinkSocketAt(newNs, netns.None(), syscall.NETLINK_ROUTE)
+	if err != nil {
+		return fmt.Errorf("failed to open a netlink socket in %q: %v", path, err)
+	}
+	defer sock.Close()
+	timeout := batchReceiveTimeout
+	if deadline, ok := ctx.Deadline(); ok {
+		if left := time.Until(deadline); left < timeout {
+			timeout = left
+		}
+	}
+	if timeout <= 0 {
+		return context.DeadlineExceeded
+	}
+	tv := syscall.NsecToTimeval(timeout.Nanoseconds())
+	if err := sock.SetReceiveTimeout(&tv); err != nil {
+		return err
+	}
+	b := &nlBatch{sock: sock}
+
+	// Create the bridges and move the other interfaces into the namespace
+	for _, i := range ifaces {
+		if i.bridge {
+			if err := nlh.LinkAdd(&netlink.Bridge{LinkAttrs: netlink.LinkAttrs{Name: i.srcName}}); err != nil {
+				b.fail(i, "create bridge", err)
+				continue
+			}
+		} else {
+			iface, err := nlhHost.LinkByName(i.srcName)
+			if err != nil {
+				b.fail(i, "get link", err)
+				continue
+			}
+			if !isDefault {
+				if err := nlhHost.LinkSetNsFd(iface, int(newNs)); err != nil {
+					b.fail(i, "move to sandbox", err)
+					continue
+				}
+			}
+		}
+		if i.link, err = nlh.LinkByName(i.srcName); err != nil {
+			b.fail(i, "get link in sandbox", err)
+		}
+	}
+
+	// Down the interfaces, then rename them and set their MAC and master
+	b.abort(ctx, ifaces)
+	for _, i := range ifaces {
+		if i.err {
+			continue
+		}
+		idx := i.link.Attrs().Index
+		b.add(i, "set link down", linkFlagsRequest(idx, false))
+		b.add(i, "rename to "+i.dstName, linkAttrRequest(idx, syscall.IFLA_IFNAME, []byte(i.dstName)))
+		if i.mac != nil {
+			b.add(i, "set MAC", linkAttrRequest(idx, syscall.IFLA_ADDRESS, []byte(i.mac)))
+		}
+		if i.master != "" {
+			masterIdx, err := n.batchMasterIndex(nlh, bySrc, i)
+			if err != nil {
+				b.fail(i, "set master", err)
+				continue
+			}
+			data := make([]byte, 4)
+			nl.NativeEndian().PutUint32(data, uint32(masterIdx))
+			b.add(i, "set master", linkAttrRequest(idx, syscall.IFLA_MASTER, data))
+		}
+	}
+	b.flush()
+
+	// Program the addresses, up the interfaces and set their routes
+	b.abort(ctx, ifaces)
+	for _, i := range ifaces {
+		if i.err {
+			continue
+		}
+		idx := i.link.Attrs().Index
+		if i.address != nil {
+			if err := checkRouteConflict(nlh, i.address, netlink.FAMILY_V4); err != nil {
+				b.fail(i, "set address", err)
+				continue
+			}
+			b.add(i, "set address "+i.address.String(), addrRequest(idx, i.address, 0))
+		}
+		if i.addressIPv6 != nil {
+			if err := checkRouteConflict(nlh, i.addressIPv6, netlink.FAMILY_V6); err != nil {
+				b.fail(i, "set IPv6 address", err)
+				continue
+			}
+			if err := setIPv6(path, i.dstName, true); err != nil {
+				b.fail(i, "enable IPv6", err)
+				continue
+			}
+			b.add(i, "set IPv6 address "+i.addressIPv6.String(), addrRequest(idx, i.addressIPv6, syscall.IFA_F_NODAD))
+		}
+		for _, llIP := range i.llAddrs {
+			b.add(i, "set link local address "+llIP.String(), addrRequest(idx, llIP, 0))
+		}
+		b.add(i, "set link up", linkFlagsRequest(idx, true))
+		for _, route := range i.routes {
+			b.add(i, "set route "+route.String(), routeRequest(idx, route))
+		}
+	}
+	b.flush()
+
+	added := make([]*nwIface, 0, len(ifaces))
+	for _, i := range ifaces {
+		if !i.err {
+			added = append(added, i.nwIface)
+			continue
+		}
+		if i.link == nil {
+			continue
+		}
+		// Allow the caller to clean up the interface as AddInterface does
+		if i.bridge {
+			if err := nlh.LinkDel(i.link); err != nil {
+				logrus.Errorf("removing bridge %s failed after config error: %v", i.srcName, err)
+			}
+			continue
+		}
+		if err := nlh.LinkSetName(i.link, i.srcName); err != nil {
+			logrus.Errorf("renaming interface (%s->%s) failed after config error: %v", i.dstName, i.srcName, err)
+		}
+		if !isDefault {
+			if err := nlh.LinkSetNsFd(i.link, ns.ParseHandlerInt()); err != nil {
+				logrus.Errorf("moving interface %s to host ns failed after config error: %v", i.srcName, err)
+			}
+		}
+	}
+
+	n.Lock()
+	n.iFaces = append(n.iFaces, added...)
+	n.Unlock()
+
+	n.checkLoV6()
+
+	if len(b.failed) > 0 {
+		return &BatchError{Steps: b.failed}
+	}
+	return nil
+}
+
+// batchMasterIndex returns the index of the master of the interface, which
+// may be a bridge of the same batch
+func (n *networkNamespace) batchMasterIndex(nlh *netlink.Handle, bySrc map[string]*batchIface, i *batchIface) (int, error) {
+	if m, ok := bySrc[i.master]; ok && m.bridge {
+		if m.err {
+			return 0, fmt.Errorf("master %q could not be configured", i.master)
+		}
+		i.dstMaster = m.dstName
+		return m.link.Attrs().Index, nil
+	}
+	i.dstMaster = n.findDst(i.master, true)
+	if i.dstMaster == "" {
+		return 0, fmt.Errorf("could not find an appropriate master %q for %q", i.master, i.srcName)
+	}
+	link, err := nlh.LinkByName(i.dstMaster)
+	if err != nil {
+		return 0, err
+	}
+	return link.Attrs().Index, nil
+}
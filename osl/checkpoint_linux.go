@@ -0,0 +1,199 @@
+package osl
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/docker/libnetwork/types"
+)
+
+func (n *networkNamespace) Checkpoint() *Checkpoint {
+	n.Lock()
+	defer n.Unlock()
+
+	cp := &Checkpoint{
+		Gateway:     types.GetIPCopy(n.gw),
+		GatewayIPv6: types.GetIPCopy(n.gwv6),
+	}
+
+	for _, i := range n.iFaces {
+		i.Lock()
+		ic := &InterfaceCheckpoint{
+			SrcName: i.srcName,
+			DstName: i.dstName,
+			Bridge:  i.bridge,
+			Master:  i.master,
+		}
+		if i.mac != nil {
+			ic.MacAddress = i.mac.String()
+		}
+		if i.address != nil {
+			ic.Address = i.address.String()
+		}
+		if i.addressIPv6 != nil {
+			ic.AddressIPv6 = i.addressIPv6.String()
+		}
+		for _, ll := range i.llAddrs {
+			ic.LinkLocalAddresses = append(ic.LinkLocalAddresses, ll.String())
+		}
+		for _, r := range i.routes {
+			ic.Routes = append(ic.Routes, r.String())
+		}
+		i.Unlock()
+		cp.Interfaces = append(cp.Interfaces, ic)
+	}
+
+	for _, r := range n.staticRoutes {
+		cp.StaticRoutes = append(cp.StaticRoutes, &RouteCheckpoint{
+			Destination: r.Destination.String(),
+			RouteType:   r.RouteType,
+			NextHop:     types.GetIPCopy(r.NextHop),
+			Metric:      r.Metric,
+		})
+	}
+
+	for _, nh := range n.neighbors {
+		cp.Neighbors = append(cp.Neighbors, &NeighborCheckpoint{
+			IP:         types.GetIPCopy(nh.dstIP),
+			MacAddress: nh.dstMac.String(),
+			LinkName:   nh.linkName,
+			Family:     nh.family,
+		})
+	}
+
+	return cp
+}
+
+func (n *networkNamespace) ApplyCheckpoint(cp *Checkpoint) error {
+	var reqs []InterfaceRequest
+	for _, ic := range cp.Interfaces {
+		if n.findDst(ic.SrcName, ic.Bridge) != "" {
+			continue
+		}
+		opts, err := n.checkpointIfaceOptions(ic)
+		if err != nil {
+			return fmt.Errorf("invalid checkpoint of interface %s: %v", ic.SrcName, err)
+		}
+		reqs = append(reqs, InterfaceRequest{
+			SrcName:   ic.SrcName,
+			DstPrefix: strings.TrimRight(ic.DstName, "0123456789"),
+			Options:   opts,
+		})
+	}
+	if len(reqs) > 0 {
+		if err := n.AddInterfaces(reqs...); err != nil {
+			return err
+		}
+	}
+
+	if len(cp.Gateway) > 0 && n.Gateway() == nil {
+		if err := n.SetGateway(cp.Gateway); err != nil {
+			return fmt.Errorf("failed to set gateway %s: %v", cp.Gateway, err)
+		}
+	}
+	if len(cp.GatewayIPv6) > 0 && n.GatewayIPv6() == nil {
+		if err := n.SetGatewayIPv6(cp.GatewayIPv6); err != nil {
+			return fmt.Errorf("failed to set IPv6 gateway %s: %v", cp.GatewayIPv6, err)
+		}
+	}
+
+	for _, rc := range cp.StaticRoutes {
+		dst, err := types.ParseCIDR(rc.Destination)
+		if err != nil {
+			return fmt.Errorf("invalid checkpoint of route %s: %v", rc.Destination, err)
+		}
+		if n.hasStaticRoute(dst, rc.NextHop) {
+			continue
+		}
+		r := &types.StaticRoute{Destination: dst, RouteType: rc.RouteType, NextHop: rc.NextHop, Metric: rc.Metric}
+		if err := n.AddStaticRoute(r); err != nil {
+			return fmt.Errorf("failed to add static route %s: %v", rc.Destination, err)
+		}
+	}
+
+	for _, nc := range cp.Neighbors {
+		mac, err := net.ParseMAC(nc.MacAddress)
+		if err != nil {
+			return fmt.Errorf("invalid checkpoint of neighbor %s: %v", nc.IP, err)
+		}
+		if n.findNeighbor(nc.IP, mac) != nil {
+			continue
+		}
+		var opts []NeighOption
+		if nc.LinkName != "" {
+			opts = append(opts, n.LinkName(nc.LinkName))
+		}
+		if nc.Family != 0 {
+			opts = append(opts, n.Family(nc.Family))
+		}
+		if err := n.AddNeighbor(nc.IP, mac, false, opts...); err != nil {
+			return fmt.Errorf("failed to add neighbor %s %s: %v", nc.IP, nc.MacAddress, err)
+		}
+	}
+
+	return nil
+}
+
+func (n *networkNamespace) checkpointIfaceOptions(ic *InterfaceCheckpoint) ([]IfaceOption, error) {
+	opts := []IfaceOption{n.Bridge(ic.Bridge)}
+	if ic.Master != "" {
+		opts = append(opts, n.Master(ic.Master))
+	}
+	if ic.MacAddress != "" {
+		mac, err := net.ParseMAC(ic.MacAddress)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, n.MacAddress(mac))
+	}
+	if ic.Address != "" {
+		addr, err := types.ParseCIDR(ic.Address)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, n.Address(addr))
+	}
+	if ic.AddressIPv6 != "" {
+		addr, err := types.ParseCIDR(ic.AddressIPv6)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, n.AddressIPv6(addr))
+	}
+	if len(ic.LinkLocalAddresses) > 0 {
+		var list []*net.IPNet
+		for _, s := range ic.LinkLocalAddresses {
+			addr, err := types.ParseCIDR(s)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, addr)
+		}
+		opts = append(opts, n.LinkLocalAddresses(list))
+	}
+	if len(ic.Routes) > 0 {
+		var routes []*net.IPNet
+		for _, s := range ic.Routes {
+			_, r, err := net.ParseCIDR(s)
+			if err != nil {
+				return nil, err
+			}
+			routes = append(routes, r)
+		}
+		opts = append(opts, n.Routes(routes))
+	}
+	return opts, nil
+}
+
+func (n *networkNamespace) hasStaticRoute(dst *net.IPNet, nh net.IP) bool {
+	n.Lock()
+	defer n.Unlock()
+
+	for _, r := range n.staticRoutes {
+		if types.CompareIPNet(r.Destination, dst) && r.NextHop.Equal(nh) {
+			return true
+		}
+	}
+	return false
+}
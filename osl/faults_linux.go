@@ -0,0 +1,20 @@
+package osl
+
+import (
+	"fmt"
+
+	"github.com/docker/libnetwork/internal/faults"
+)
+
+// injectNetlinkFault injects the netlink faults in the operation about to
+// run, which is skipped as if it succeeded when the fault drops it
+func injectNetlinkFault(op string) (bool, error) {
+	switch err := faults.Inject(faults.Netlink); err {
+	case nil:
+		return false, nil
+	case faults.ErrDropped:
+		return true, nil
+	default:
+		return false, fmt.Errorf("failed to %s: %v", op, err)
+	}
+}
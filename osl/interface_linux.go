@@ -123,6 +123,10 @@ func (n *networkNamespace) Interfaces() []Interface {
 }
 
 func (i *nwIface) Remove() error {
+	if skip, err := injectNetlinkFault("remove interface " + i.DstName()); skip || err != nil {
+		return err
+	}
+
 	i.Lock()
 	n := i.ns
 	i.Unlock()
@@ -197,6 +201,8 @@ func (i *nwIface) Statistics() (*types.InterfaceStatistics, error) {
 		TxBytes:   uint64(stats.TxBytes),
 		RxPackets: uint64(stats.RxPackets),
 		TxPackets: uint64(stats.TxPackets),
+		RxErrors:  uint64(stats.RxErrors),
+		TxErrors:  uint64(stats.TxErrors),
 		RxDropped: uint64(stats.RxDropped),
 		TxDropped: uint64(stats.TxDropped),
 	}, nil
@@ -218,6 +224,9 @@ func (n *networkNamespace) findDst(srcName string, isBridge bool) string {
 }
 
 func (n *networkNamespace) AddInterface(srcName, dstPrefix string, options ...IfaceOption) error {
+	if skip, err := injectNetlinkFault("add interface " + srcName); skip || err != nil {
+		return err
+	}
 	i := &nwIface{srcName: srcName, dstName: dstPrefix, ns: n}
 	i.processInterfaceOptions(options...)
 
@@ -414,6 +414,48 @@ func (n *networkNamespace) DisableARPForVIP(srcName string) (Err error) {
 	return
 }
 
+// ipv6OnlySysctls are the sysctls of an IPv6-only interface: no ARP reply
+// nor announcement, no redirect, and the strict reverse path filter dropping
+// the IPv4 packets the interface has no route back for
+var ipv6OnlySysctls = []struct {
+	path  string
+	value string
+}{
+	{"/proc/sys/net/ipv4/conf/%s/arp_ignore", "8"},
+	{"/proc/sys/net/ipv4/conf/%s/arp_announce", "2"},
+	{"/proc/sys/net/ipv4/conf/%s/accept_redirects", "0"},
+	{"/proc/sys/net/ipv4/conf/%s/send_redirects", "0"},
+	{"/proc/sys/net/ipv4/conf/%s/rp_filter", "1"},
+	{"/proc/sys/net/ipv6/conf/%s/disable_ipv6", "0"},
+}
+
+func (n *networkNamespace) DisableIPv4(srcName string) (Err error) {
+	dstName := ""
+	for _, i := range n.Interfaces() {
+		if i.SrcName() == srcName {
+			dstName = i.DstName()
+			break
+		}
+	}
+	if dstName == "" {
+		return fmt.Errorf("failed to find interface %s in sandbox", srcName)
+	}
+
+	err := n.InvokeFunc(func() {
+		for _, s := range ipv6OnlySysctls {
+			path := fmt.Sprintf(s.path, dstName)
+			if err := ioutil.WriteFile(path, []byte(s.value+"\n"), 0644); err != nil {
+				Err = fmt.Errorf("Failed to set %s to %s: %v", path, s.value, err)
+				return
+			}
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return
+}
+
 func (n *networkNamespace) InvokeFunc(f func()) error {
 	return nsInvoke(n.nsPath(), func(nsFD int) error { return nil }, func(callerFD int) error {
 		f()
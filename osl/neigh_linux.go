@@ -45,6 +45,9 @@ func (n *networkNamespace) findNeighbor(dstIP net.IP, dstMac net.HardwareAddr) *
 }
 
 func (n *networkNamespace) DeleteNeighbor(dstIP net.IP, dstMac net.HardwareAddr, osDelete bool) error {
+	if skip, err := injectNetlinkFault("delete neighbor " + dstIP.String()); skip || err != nil {
+		return err
+	}
 	var (
 		iface netlink.Link
 		err   error
@@ -120,6 +123,9 @@ func (n *networkNamespace) DeleteNeighbor(dstIP net.IP, dstMac net.HardwareAddr,
 }
 
 func (n *networkNamespace) AddNeighbor(dstIP net.IP, dstMac net.HardwareAddr, force bool, options ...NeighOption) error {
+	if skip, err := injectNetlinkFault("add neighbor " + dstIP.String()); skip || err != nil {
+		return err
+	}
 	var (
 		iface                  netlink.Link
 		err                    error
@@ -78,6 +78,9 @@ func (n *networkNamespace) UnsetGateway() error {
 }
 
 func (n *networkNamespace) programGateway(gw net.IP, isAdd bool) error {
+	if skip, err := injectNetlinkFault("program gateway " + gw.String()); skip || err != nil {
+		return err
+	}
 	gwRoutes, err := n.nlHandle.RouteGet(gw)
 	if err != nil {
 		return fmt.Errorf("route for the gateway %s could not be found: %v", gw, err)
@@ -111,7 +114,10 @@ func (n *networkNamespace) programGateway(gw net.IP, isAdd bool) error {
 }
 
 // Program a route in to the namespace routing table.
-func (n *networkNamespace) programRoute(path string, dest *net.IPNet, nh net.IP) error {
+func (n *networkNamespace) programRoute(path string, dest *net.IPNet, nh net.IP, metric int) error {
+	if skip, err := injectNetlinkFault("add route " + dest.String()); skip || err != nil {
+		return err
+	}
 	gwRoutes, err := n.nlHandle.RouteGet(nh)
 	if err != nil {
 		return fmt.Errorf("route for the next hop %s could not be found: %v", nh, err)
@@ -122,11 +128,15 @@ func (n *networkNamespace) programRoute(path string, dest *net.IPNet, nh net.IP)
 		LinkIndex: gwRoutes[0].LinkIndex,
 		Gw:        nh,
 		Dst:       dest,
+		Priority:  metric,
 	})
 }
 
 // Delete a route from the namespace routing table.
-func (n *networkNamespace) removeRoute(path string, dest *net.IPNet, nh net.IP) error {
+func (n *networkNamespace) removeRoute(path string, dest *net.IPNet, nh net.IP, metric int) error {
+	if skip, err := injectNetlinkFault("remove route " + dest.String()); skip || err != nil {
+		return err
+	}
 	gwRoutes, err := n.nlHandle.RouteGet(nh)
 	if err != nil {
 		return fmt.Errorf("route for the next hop could not be found: %v", err)
@@ -137,6 +147,7 @@ func (n *networkNamespace) removeRoute(path string, dest *net.IPNet, nh net.IP)
 		LinkIndex: gwRoutes[0].LinkIndex,
 		Gw:        nh,
 		Dst:       dest,
+		Priority:  metric,
 	})
 }
 
@@ -173,7 +184,7 @@ func (n *networkNamespace) UnsetGatewayIPv6() error {
 }
 
 func (n *networkNamespace) AddStaticRoute(r *types.StaticRoute) error {
-	err := n.programRoute(n.nsPath(), r.Destination, r.NextHop)
+	err := n.programRoute(n.nsPath(), r.Destination, r.NextHop, r.Metric)
 	if err == nil {
 		n.Lock()
 		n.staticRoutes = append(n.staticRoutes, r)
@@ -184,7 +195,7 @@ func (n *networkNamespace) AddStaticRoute(r *types.StaticRoute) error {
 
 func (n *networkNamespace) RemoveStaticRoute(r *types.StaticRoute) error {
 
-	err := n.removeRoute(n.nsPath(), r.Destination, r.NextHop)
+	err := n.removeRoute(n.nsPath(), r.Destination, r.NextHop, r.Metric)
 	if err == nil {
 		n.Lock()
 		lastIndex := len(n.staticRoutes) - 1
@@ -2,7 +2,10 @@
 package osl
 
 import (
+	"context"
+	"fmt"
 	"net"
+	"strings"
 
 	"github.com/docker/libnetwork/types"
 )
@@ -30,6 +33,17 @@ type Sandbox interface {
 	// an appropriate suffix for the DstName to disambiguate.
 	AddInterface(SrcName string, DstPrefix string, options ...IfaceOption) error
 
+	// AddInterfaces adds several existing interfaces as AddInterface does,
+	// pipelining the configuration requests on a single netlink socket.
+	// The interfaces which could not be configured are moved back out of
+	// the sandbox and the failed steps are returned in a *BatchError.
+	AddInterfaces(reqs ...InterfaceRequest) error
+
+	// AddInterfacesContext behaves as AddInterfaces, bounding the wait for
+	// the netlink acknowledgements by the deadline of the context and
+	// moving the interfaces back out when it is done.
+	AddInterfacesContext(ctx context.Context, reqs ...InterfaceRequest) error
+
 	// Set default IPv4 gateway for the sandbox
 	SetGateway(gw net.IP) error
 
@@ -55,6 +69,10 @@ type Sandbox interface {
 	// on a particular interface
 	DisableARPForVIP(ifName string) error
 
+	// DisableIPv4 disables the IPv4 processing of a particular interface,
+	// leaving it IPv6 only
+	DisableIPv4(ifName string) error
+
 	// Add a static route to the sandbox.
 	AddStaticRoute(*types.StaticRoute) error
 
@@ -87,6 +105,85 @@ type Sandbox interface {
 
 	// ApplyOSTweaks applies operating system specific knobs on the sandbox
 	ApplyOSTweaks([]SandboxType)
+
+	// Checkpoint returns the network configuration programmed in the sandbox
+	Checkpoint() *Checkpoint
+
+	// ApplyCheckpoint programs a checkpointed configuration into the sandbox.
+	// The interfaces which are not in the sandbox yet are expected to be
+	// present in the host namespace under their SrcName, they are added in
+	// the checkpoint order so that they get back the same DstName.
+	ApplyCheckpoint(*Checkpoint) error
+}
+
+// Checkpoint is the serializable network configuration of a sandbox
+type Checkpoint struct {
+	Interfaces   []*InterfaceCheckpoint `json:"interfaces,omitempty"`
+	Gateway      net.IP                 `json:"gateway,omitempty"`
+	GatewayIPv6  net.IP                 `json:"gateway_ipv6,omitempty"`
+	StaticRoutes []*RouteCheckpoint     `json:"static_routes,omitempty"`
+	Neighbors    []*NeighborCheckpoint  `json:"neighbors,omitempty"`
+}
+
+// InterfaceCheckpoint is the serializable configuration of an interface
+type InterfaceCheckpoint struct {
+	SrcName            string   `json:"src_name"`
+	DstName            string   `json:"dst_name"`
+	Bridge             bool     `json:"bridge,omitempty"`
+	Master             string   `json:"master,omitempty"`
+	MacAddress         string   `json:"mac_address,omitempty"`
+	Address            string   `json:"address,omitempty"`
+	AddressIPv6        string   `json:"address_ipv6,omitempty"`
+	LinkLocalAddresses []string `json:"link_local_addresses,omitempty"`
+	Routes             []string `json:"routes,omitempty"`
+}
+
+// RouteCheckpoint is the serializable form of a static route
+type RouteCheckpoint struct {
+	Destination string `json:"destination"`
+	RouteType   int    `json:"route_type"`
+	NextHop     net.IP `json:"next_hop,omitempty"`
+	Metric      int    `json:"metric,omitempty"`
+}
+
+// NeighborCheckpoint is the serializable form of a neighbor entry. LinkName
+// is the SrcName of the interface the entry is programmed on.
+type NeighborCheckpoint struct {
+	IP         net.IP `json:"ip"`
+	MacAddress string `json:"mac_address"`
+	LinkName   string `json:"link_name,omitempty"`
+	Family     int    `json:"family,omitempty"`
+}
+
+// InterfaceRequest carries the arguments of AddInterface for AddInterfaces
+type InterfaceRequest struct {
+	SrcName   string
+	DstPrefix string
+	Options   []IfaceOption
+}
+
+// StepError reports the failed step of the configuration of an interface
+type StepError struct {
+	Interface string
+	Step      string
+	Err       error
+}
+
+func (e *StepError) Error() string {
+	return fmt.Sprintf("interface %s: %s: %v", e.Interface, e.Step, e.Err)
+}
+
+// BatchError aggregates the failed steps of AddInterfaces
+type BatchError struct {
+	Steps []*StepError
+}
+
+func (e *BatchError) Error() string {
+	msgs := make([]string, 0, len(e.Steps))
+	for _, s := range e.Steps {
+		msgs = append(msgs, s.Error())
+	}
+	return strings.Join(msgs, "; ")
 }
 
 // NeighborOptionSetter interface defines the option setter methods for interface options
@@ -3,6 +3,7 @@ package osl
 import (
 	"crypto/rand"
 	"encoding/hex"
+	"encoding/json"
 	"io"
 	"net"
 	"os"
@@ -13,6 +14,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/docker/libnetwork/ns"
 	"github.com/docker/libnetwork/testutils"
 	"github.com/docker/libnetwork/types"
 	"github.com/vishvananda/netlink"
@@ -316,6 +318,74 @@ func TestSetInterfaceIP(t *testing.T) {
 	}
 }
 
+func TestSandboxAddInterfaces(t *testing.T) {
+	defer testutils.SetupTestOSContext(t)()
+
+	key, err := newKey(t)
+	if err != nil {
+		t.Fatalf("Failed to obtain a key: %v", err)
+	}
+
+	s, err := NewSandbox(key, true, false)
+	if err != nil {
+		t.Fatalf("Failed to create a new sandbox: %v", err)
+	}
+	defer s.Destroy()
+
+	tbox, err := newInfo(ns.NlHandle(), t)
+	if err != nil {
+		t.Fatalf("Failed to generate new sandbox info: %v", err)
+	}
+
+	var reqs []InterfaceRequest
+	for _, i := range tbox.Info().Interfaces() {
+		reqs = append(reqs, InterfaceRequest{
+			SrcName:   i.SrcName(),
+			DstPrefix: i.DstName(),
+			Options: []IfaceOption{
+				tbox.InterfaceOptions().Bridge(i.Bridge()),
+				tbox.InterfaceOptions().Master(i.Master()),
+				tbox.InterfaceOptions().Address(i.Address()),
+				tbox.InterfaceOptions().AddressIPv6(i.AddressIPv6()),
+				tbox.InterfaceOptions().Routes(i.Routes()),
+			},
+		})
+	}
+	reqs = append(reqs, InterfaceRequest{SrcName: "missing0", DstPrefix: sboxIfaceName})
+
+	err = s.AddInterfaces(reqs...)
+	berr, ok := err.(*BatchError)
+	if !ok || len(berr.Steps) != 1 || berr.Steps[0].Interface != "missing0" {
+		t.Fatalf("Expected the missing interface to be reported, got: %v", err)
+	}
+	if len(s.Info().Interfaces()) != 3 {
+		t.Fatalf("Expected 3 interfaces in the sandbox, got %d", len(s.Info().Interfaces()))
+	}
+
+	verifySandbox(t, s, []string{"0", "1", "2"})
+
+	nlh := s.(*networkNamespace).nlHandle
+	link, err := nlh.LinkByName(sboxIfaceName + "0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addrs, err := nlh.AddrList(link, netlink.FAMILY_V4)
+	if err != nil || len(addrs) != 1 || addrs[0].IPNet.String() != "192.168.1.100/24" {
+		t.Fatalf("Unexpected addresses %v: %v", addrs, err)
+	}
+	if link.Attrs().Flags&net.FlagUp == 0 {
+		t.Fatal("Expected the interface to be up")
+	}
+	bridge, err := nlh.LinkByName(sboxIfaceName + "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	slave, err := nlh.LinkByName(sboxIfaceName + "2")
+	if err != nil || slave.Attrs().MasterIndex != bridge.Attrs().Index {
+		t.Fatalf("Expected the interface to be enslaved to the bridge: %v", err)
+	}
+}
+
 func TestLiveRestore(t *testing.T) {
 
 	defer testutils.SetupTestOSContext(t)()
@@ -407,3 +477,117 @@ func TestLiveRestore(t *testing.T) {
 		t.Fatalf("Expected route conflict error, but succeeded for IPV4 ")
 	}
 }
+
+func TestSandboxCheckpoint(t *testing.T) {
+	defer testutils.SetupTestOSContext(t)()
+
+	key, err := newKey(t)
+	if err != nil {
+		t.Fatalf("Failed to obtain a key: %v", err)
+	}
+
+	s, err := NewSandbox(key, true, false)
+	if err != nil {
+		t.Fatalf("Failed to create a new sandbox: %v", err)
+	}
+	defer s.Destroy()
+
+	tbox, err := newInfo(ns.NlHandle(), t)
+	if err != nil {
+		t.Fatalf("Failed to generate new sandbox info: %v", err)
+	}
+
+	for _, i := range tbox.Info().Interfaces() {
+		err = s.AddInterface(i.SrcName(), i.DstName(),
+			tbox.InterfaceOptions().Bridge(i.Bridge()),
+			tbox.InterfaceOptions().Master(i.Master()),
+			tbox.InterfaceOptions().Address(i.Address()),
+			tbox.InterfaceOptions().AddressIPv6(i.AddressIPv6()),
+			tbox.InterfaceOptions().Routes(i.Routes()))
+		if err != nil {
+			t.Fatalf("Failed to add interfaces to sandbox: %v", err)
+		}
+	}
+	if err := s.SetGateway(tbox.Info().Gateway()); err != nil {
+		t.Fatalf("Failed to set gateway to sandbox: %v", err)
+	}
+	dst, _ := types.ParseCIDR("10.10.0.0/16")
+	if err := s.AddStaticRoute(&types.StaticRoute{Destination: dst, RouteType: types.NEXTHOP, NextHop: net.ParseIP("192.168.1.1"), Metric: 100}); err != nil {
+		t.Fatalf("Failed to add static route to sandbox: %v", err)
+	}
+	mac, _ := net.ParseMAC("02:42:c0:a8:01:05")
+	if err := s.AddNeighbor(net.ParseIP("192.168.1.5"), mac, false, s.NeighborOptions().LinkName(vethName2)); err != nil {
+		t.Fatalf("Failed to add neighbor to sandbox: %v", err)
+	}
+
+	b, err := json.Marshal(s.Checkpoint())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cp Checkpoint
+	if err := json.Unmarshal(b, &cp); err != nil {
+		t.Fatal(err)
+	}
+	if len(cp.Interfaces) != 3 || len(cp.StaticRoutes) != 1 || len(cp.Neighbors) != 1 {
+		t.Fatalf("Unexpected checkpoint: %s", b)
+	}
+
+	// Delete the interfaces, as they are gone once the container is
+	// restored or the host rebooted
+	for _, i := range s.Info().Interfaces() {
+		if err := i.Remove(); err != nil {
+			t.Fatalf("Failed to remove interface %s: %v", i.SrcName(), err)
+		}
+	}
+	for _, name := range []string{vethName1, vethName3} {
+		link, err := ns.NlHandle().LinkByName(name)
+		if err != nil {
+			t.Fatalf("Failed to find veth %s: %v", name, err)
+		}
+		if err := ns.NlHandle().LinkDel(link); err != nil {
+			t.Fatalf("Failed to delete veth %s: %v", name, err)
+		}
+	}
+	for _, name := range []string{vethName2, vethName4} {
+		if _, err := ns.NlHandle().LinkByName(name); err == nil {
+			t.Fatalf("Veth %s not deleted along with its peer", name)
+		}
+	}
+
+	key2, err := newKey(t)
+	if err != nil {
+		t.Fatalf("Failed to obtain a key: %v", err)
+	}
+	s2, err := NewSandbox(key2, true, false)
+	if err != nil {
+		t.Fatalf("Failed to create a new sandbox: %v", err)
+	}
+	defer s2.Destroy()
+
+	// The drivers create the interfaces again when the endpoints join back
+	if _, err := newInfo(ns.NlHandle(), t); err != nil {
+		t.Fatalf("Failed to create the interfaces again: %v", err)
+	}
+	if err := s2.ApplyCheckpoint(&cp); err != nil {
+		t.Fatalf("Failed to apply the checkpoint: %v", err)
+	}
+	verifySandbox(t, s2, []string{"0", "1", "2"})
+
+	routes, err := s2.(*networkNamespace).nlHandle.RouteListFiltered(netlink.FAMILY_V4, &netlink.Route{Dst: dst}, netlink.RT_FILTER_DST)
+	if err != nil || len(routes) != 1 || routes[0].Priority != 100 {
+		t.Fatalf("Expected the static route to be restored with its metric, got %v: %v", routes, err)
+	}
+
+	b2, err := json.Marshal(s2.Checkpoint())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != string(b2) {
+		t.Fatalf("Restored configuration differs:\n%s\n%s", b, b2)
+	}
+
+	// Applying the checkpoint again is a no-op
+	if err := s2.ApplyCheckpoint(&cp); err != nil {
+		t.Fatalf("Failed to apply the checkpoint again: %v", err)
+	}
+}
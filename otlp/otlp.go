@@ -0,0 +1,449 @@
+// Package otlp exports spans and metrics to an OpenTelemetry collector,
+// with the JSON encoding of OTLP over HTTP so that no SDK is needed. The
+// spans are batched and the metrics, cumulative histograms of durations,
+// are exported on an interval.
+package otlp
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	defaultServiceName    = "libnetwork"
+	defaultMetricInterval = time.Minute
+	scopeName             = "github.com/docker/libnetwork"
+
+	// maxBatch is the number of spans which triggers an export, the
+	// spans being exported every spanInterval anyway
+	maxBatch     = 512
+	spanInterval = 5 * time.Second
+	// maxPending bounds the spans waiting for an export, the ones past it
+	// being dropped while the collector is unreachable
+	maxPending    = 4096
+	exportTimeout = 10 * time.Second
+)
+
+// durationBounds are the upper bounds, in seconds, of the buckets of the
+// duration histograms
+var durationBounds = []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+// Config configures an Exporter
+type Config struct {
+	// Endpoint is the base URL of the collector, as in
+	// http://collector:4318, the signals being posted under /v1
+	Endpoint string
+	// Headers are added to the export requests, as for authentication
+	Headers map[string]string
+	// SampleRatio is the ratio of the traces exported, from 0 to 1
+	SampleRatio float64
+	// ServiceName is the service.name of the resource, libnetwork when
+	// empty
+	ServiceName string
+	// MetricInterval is the interval the metrics are exported on
+	MetricInterval time.Duration
+}
+
+// Span is a timed step of an operation. The spans of a trace share its
+// trace id, the root span having no parent.
+type Span struct {
+	TraceID    [16]byte
+	SpanID     [8]byte
+	ParentID   [8]byte
+	Name       string
+	Start      time.Time
+	End        time.Time
+	Attributes map[string]string
+}
+
+type histogram struct {
+	attrs  map[string]string
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// Exporter sends the spans and metrics to the collector
+type Exporter struct {
+	cfg    Config
+	client *http.Client
+	start  time.Time
+
+	sync.Mutex
+	spans      []Span
+	histograms map[string]map[string]*histogram
+
+	flush chan struct{}
+	stop  chan struct{}
+	done  chan struct{}
+}
+
+// New validates the configuration and starts the exports
+func New(cfg Config) (*Exporter, error) {
+	u, err := url.Parse(cfg.Endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid OTLP endpoint %q: expected an http or https URL", cfg.Endpoint)
+	}
+	if cfg.SampleRatio < 0 || cfg.SampleRatio > 1 {
+		return nil, fmt.Errorf("invalid OTLP sample ratio %v: expected 0 to 1", cfg.SampleRatio)
+	}
+	cfg.Endpoint = strings.TrimRight(cfg.Endpoint, "/")
+	if cfg.ServiceName == "" {
+		cfg.ServiceName = defaultServiceName
+	}
+	if cfg.MetricInterval <= 0 {
+		cfg.MetricInterval = defaultMetricInterval
+	}
+	e := &Exporter{
+		cfg:        cfg,
+		client:     &http.Client{},
+		start:      time.Now(),
+		histograms: map[string]map[string]*histogram{},
+		flush:      make(chan struct{}, 1),
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+	go e.run()
+	return e, nil
+}
+
+// Sample tells whether a new trace is exported, as per the sample ratio
+func (e *Exporter) Sample() bool {
+	switch {
+	case e.cfg.SampleRatio >= 1:
+		return true
+	case e.cfg.SampleRatio <= 0:
+		return false
+	}
+	var b [8]byte
+	rand.Read(b[:])
+	v := uint64(0)
+	for _, c := range b {
+		v = v<<8 | uint64(c)
+	}
+	return float64(v>>11)/float64(1<<53) < e.cfg.SampleRatio
+}
+
+// NewTraceID returns a random trace id
+func NewTraceID() (id [16]byte) {
+	rand.Read(id[:])
+	return id
+}
+
+// NewSpanID returns a random span id
+func NewSpanID() (id [8]byte) {
+	rand.Read(id[:])
+	return id
+}
+
+// ExportSpan queues the span for the next export
+func (e *Exporter) ExportSpan(s Span) {
+	e.Lock()
+	if len(e.spans) >= maxPending {
+		e.Unlock()
+		return
+	}
+	e.spans = append(e.spans, s)
+	full := len(e.spans) >= maxBatch
+	e.Unlock()
+	if full {
+		select {
+		case e.flush <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// RecordDuration adds the duration to the histogram of the metric with
+// the attributes
+func (e *Exporter) RecordDuration(name string, attrs map[string]string, d time.Duration) {
+	key := attributesKey(attrs)
+	v := d.Seconds()
+	e.Lock()
+	defer e.Unlock()
+	m, ok := e.histograms[name]
+	if !ok {
+		m = map[string]*histogram{}
+		e.histograms[name] = m
+	}
+	h, ok := m[key]
+	if !ok {
+		h = &histogram{attrs: attrs, counts: make([]uint64, len(durationBounds)+1)}
+		m[key] = h
+	}
+	i := sort.SearchFloat64s(durationBounds, v)
+	h.counts[i]++
+	h.count++
+	h.sum += v
+}
+
+// Stop exports what is left and stops the exports
+func (e *Exporter) Stop() {
+	close(e.stop)
+	<-e.done
+}
+
+func (e *Exporter) run() {
+	defer close(e.done)
+	spanTicker := time.NewTicker(spanInterval)
+	defer spanTicker.Stop()
+	metricTicker := time.NewTicker(e.cfg.MetricInterval)
+	defer metricTicker.Stop()
+	for {
+		select {
+		case <-spanTicker.C:
+			e.exportSpans()
+		case <-e.flush:
+			e.exportSpans()
+		case <-metricTicker.C:
+			e.exportMetrics()
+		case <-e.stop:
+			e.exportSpans()
+			e.exportMetrics()
+			return
+		}
+	}
+}
+
+func (e *Exporter) exportSpans() {
+	e.Lock()
+	spans := e.spans
+	e.spans = nil
+	e.Unlock()
+	if len(spans) == 0 {
+		return
+	}
+	if err := e.post("/v1/traces", e.tracesRequest(spans)); err != nil {
+		logrus.Warnf("Failed to export %d spans to %s: %v", len(spans), e.cfg.Endpoint, err)
+	}
+}
+
+func (e *Exporter) exportMetrics() {
+	req := e.metricsRequest(time.Now())
+	if req == nil {
+		return
+	}
+	if err := e.post("/v1/metrics", req); err != nil {
+		logrus.Warnf("Failed to export the metrics to %s: %v", e.cfg.Endpoint, err)
+	}
+}
+
+func (e *Exporter) post(path string, body interface{}) error {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), exportTimeout)
+	defer cancel()
+	req, err := http.NewRequest(http.MethodPost, e.cfg.Endpoint+path, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range e.cfg.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := e.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("status %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// The OTLP JSON encoding, in which the 64 bits integers are strings and
+// the ids hex encoded
+
+type keyValue struct {
+	Key   string   `json:"key"`
+	Value anyValue `json:"value"`
+}
+
+type anyValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+type resource struct {
+	Attributes []keyValue `json:"attributes"`
+}
+
+type scope struct {
+	Name string `json:"name"`
+}
+
+type span struct {
+	TraceID           string     `json:"traceId"`
+	SpanID            string     `json:"spanId"`
+	ParentSpanID      string     `json:"parentSpanId,omitempty"`
+	Name              string     `json:"name"`
+	Kind              int        `json:"kind"`
+	StartTimeUnixNano string     `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string     `json:"endTimeUnixNano"`
+	Attributes        []keyValue `json:"attributes,omitempty"`
+}
+
+type scopeSpans struct {
+	Scope scope  `json:"scope"`
+	Spans []span `json:"spans"`
+}
+
+type resourceSpans struct {
+	Resource   resource     `json:"resource"`
+	ScopeSpans []scopeSpans `json:"scopeSpans"`
+}
+
+type tracesRequest struct {
+	ResourceSpans []resourceSpans `json:"resourceSpans"`
+}
+
+type histogramDataPoint struct {
+	Attributes        []keyValue `json:"attributes,omitempty"`
+	StartTimeUnixNano string     `json:"startTimeUnixNano"`
+	TimeUnixNano      string     `json:"timeUnixNano"`
+	Count             string     `json:"count"`
+	Sum               float64    `json:"sum"`
+	BucketCounts      []string   `json:"bucketCounts"`
+	ExplicitBounds    []float64  `json:"explicitBounds"`
+}
+
+type histogramData struct {
+	AggregationTemporality int                  `json:"aggregationTemporality"`
+	DataPoints             []histogramDataPoint `json:"dataPoints"`
+}
+
+type metric struct {
+	Name      string        `json:"name"`
+	Unit      string        `json:"unit"`
+	Histogram histogramData `json:"histogram"`
+}
+
+type scopeMetrics struct {
+	Scope   scope    `json:"scope"`
+	Metrics []metric `json:"metrics"`
+}
+
+type resourceMetrics struct {
+	Resource     resource       `json:"resource"`
+	ScopeMetrics []scopeMetrics `json:"scopeMetrics"`
+}
+
+type metricsRequest struct {
+	ResourceMetrics []resourceMetrics `json:"resourceMetrics"`
+}
+
+const (
+	spanKindInternal                 = 1
+	aggregationTemporalityCumulative = 2
+)
+
+func (e *Exporter) resource() resource {
+	return resource{Attributes: []keyValue{{Key: "service.name", Value: anyValue{StringValue: e.cfg.ServiceName}}}}
+}
+
+func (e *Exporter) tracesRequest(spans []Span) *tracesRequest {
+	ss := scopeSpans{Scope: scope{Name: scopeName}}
+	for _, s := range spans {
+		js := span{
+			TraceID:           hex.EncodeToString(s.TraceID[:]),
+			SpanID:            hex.EncodeToString(s.SpanID[:]),
+			Name:              s.Name,
+			Kind:              spanKindInternal,
+			StartTimeUnixNano: unixNano(s.Start),
+			EndTimeUnixNano:   unixNano(s.End),
+			Attributes:        keyValues(s.Attributes),
+		}
+		if s.ParentID != [8]byte{} {
+			js.ParentSpanID = hex.EncodeToString(s.ParentID[:])
+		}
+		ss.Spans = append(ss.Spans, js)
+	}
+	return &tracesRequest{ResourceSpans: []resourceSpans{{Resource: e.resource(), ScopeSpans: []scopeSpans{ss}}}}
+}
+
+// metricsRequest renders the histograms as of now, nil when there are none
+func (e *Exporter) metricsRequest(now time.Time) *metricsRequest {
+	e.Lock()
+	defer e.Unlock()
+	if len(e.histograms) == 0 {
+		return nil
+	}
+	names := make([]string, 0, len(e.histograms))
+	for name := range e.histograms {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	sm := scopeMetrics{Scope: scope{Name: scopeName}}
+	for _, name := range names {
+		m := metric{Name: name, Unit: "s", Histogram: histogramData{AggregationTemporality: aggregationTemporalityCumulative}}
+		keys := make([]string, 0, len(e.histograms[name]))
+		for key := range e.histograms[name] {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			h := e.histograms[name][key]
+			dp := histogramDataPoint{
+				Attributes:        keyValues(h.attrs),
+				StartTimeUnixNano: unixNano(e.start),
+				TimeUnixNano:      unixNano(now),
+				Count:             strconv.FormatUint(h.count, 10),
+				Sum:               h.sum,
+				ExplicitBounds:    durationBounds,
+			}
+			for _, c := range h.counts {
+				dp.BucketCounts = append(dp.BucketCounts, strconv.FormatUint(c, 10))
+			}
+			m.Histogram.DataPoints = append(m.Histogram.DataPoints, dp)
+		}
+		sm.Metrics = append(sm.Metrics, m)
+	}
+	return &metricsRequest{ResourceMetrics: []resourceMetrics{{Resource: e.resource(), ScopeMetrics: []scopeMetrics{sm}}}}
+}
+
+func unixNano(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
+
+func keyValues(attrs map[string]string) []keyValue {
+	keys := make([]string, 0, len(attrs))
+	for k := range attrs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	kvs := make([]keyValue, 0, len(keys))
+	for _, k := range keys {
+		kvs = append(kvs, keyValue{Key: k, Value: anyValue{StringValue: attrs[k]}})
+	}
+	return kvs
+}
+
+func attributesKey(attrs map[string]string) string {
+	var b strings.Builder
+	for _, kv := range keyValues(attrs) {
+		b.WriteString(kv.Key)
+		b.WriteByte('=')
+		b.WriteString(kv.Value.StringValue)
+		b.WriteByte(0)
+	}
+	return b.String()
+}
@@ -0,0 +1,128 @@
+package otlp
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type export struct {
+	path   string
+	header http.Header
+	body   []byte
+}
+
+func TestExport(t *testing.T) {
+	got := make(chan export, 4)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		got <- export{path: r.URL.Path, header: r.Header, body: b}
+	}))
+	defer srv.Close()
+
+	e, err := New(Config{
+		Endpoint:    srv.URL + "/",
+		Headers:     map[string]string{"Authorization": "Bearer t0ken"},
+		SampleRatio: 1,
+		ServiceName: "dockerd",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !e.Sample() {
+		t.Fatal("trace not sampled at ratio 1")
+	}
+
+	start := time.Unix(1, 0)
+	root := Span{TraceID: NewTraceID(), SpanID: NewSpanID(), Name: "join", Start: start, End: start.Add(time.Second), Attributes: map[string]string{"id": "ep1"}}
+	child := Span{TraceID: root.TraceID, SpanID: NewSpanID(), ParentID: root.SpanID, Name: "join/driver", Start: start, End: start.Add(time.Millisecond)}
+	e.ExportSpan(root)
+	e.ExportSpan(child)
+	e.RecordDuration("libnetwork.operation.duration", map[string]string{"operation": "join"}, 3*time.Millisecond)
+	e.RecordDuration("libnetwork.operation.duration", map[string]string{"operation": "join"}, 2*time.Second)
+	e.Stop()
+
+	exports := map[string]export{}
+	for len(got) > 0 {
+		ex := <-got
+		exports[ex.path] = ex
+	}
+
+	traces, ok := exports["/v1/traces"]
+	if !ok {
+		t.Fatalf("no traces exported: %v", exports)
+	}
+	if traces.header.Get("Authorization") != "Bearer t0ken" || traces.header.Get("Content-Type") != "application/json" {
+		t.Fatalf("unexpected headers %v", traces.header)
+	}
+	var tr tracesRequest
+	if err := json.Unmarshal(traces.body, &tr); err != nil {
+		t.Fatal(err)
+	}
+	if len(tr.ResourceSpans) != 1 || tr.ResourceSpans[0].Resource.Attributes[0].Value.StringValue != "dockerd" {
+		t.Fatalf("unexpected resource %s", traces.body)
+	}
+	spans := tr.ResourceSpans[0].ScopeSpans[0].Spans
+	if len(spans) != 2 || spans[0].ParentSpanID != "" || spans[1].ParentSpanID != spans[0].SpanID ||
+		spans[1].TraceID != spans[0].TraceID || len(spans[0].TraceID) != 32 || len(spans[0].SpanID) != 16 {
+		t.Fatalf("unexpected spans %s", traces.body)
+	}
+	if spans[0].StartTimeUnixNano != "1000000000" || spans[0].EndTimeUnixNano != "2000000000" {
+		t.Fatalf("unexpected span times %+v", spans[0])
+	}
+
+	metrics, ok := exports["/v1/metrics"]
+	if !ok {
+		t.Fatalf("no metrics exported: %v", exports)
+	}
+	var mr metricsRequest
+	if err := json.Unmarshal(metrics.body, &mr); err != nil {
+		t.Fatal(err)
+	}
+	m := mr.ResourceMetrics[0].ScopeMetrics[0].Metrics[0]
+	if m.Name != "libnetwork.operation.duration" || m.Histogram.AggregationTemporality != aggregationTemporalityCumulative {
+		t.Fatalf("unexpected metric %s", metrics.body)
+	}
+	dp := m.Histogram.DataPoints[0]
+	if dp.Count != "2" || dp.Attributes[0].Value.StringValue != "join" || len(dp.BucketCounts) != len(dp.ExplicitBounds)+1 {
+		t.Fatalf("unexpected data point %+v", dp)
+	}
+	if dp.BucketCounts[1] != "1" || dp.BucketCounts[9] != "1" {
+		t.Fatalf("unexpected bucket counts %v", dp.BucketCounts)
+	}
+}
+
+func TestSample(t *testing.T) {
+	e := &Exporter{cfg: Config{SampleRatio: 0}}
+	for i := 0; i < 100; i++ {
+		if e.Sample() {
+			t.Fatal("trace sampled at ratio 0")
+		}
+	}
+	e.cfg.SampleRatio = 0.5
+	sampled := 0
+	for i := 0; i < 10000; i++ {
+		if e.Sample() {
+			sampled++
+		}
+	}
+	if sampled < 4000 || sampled > 6000 {
+		t.Fatalf("unexpected %d traces sampled out of 10000 at ratio 0.5", sampled)
+	}
+}
+
+func TestNewValidation(t *testing.T) {
+	for _, cfg := range []Config{
+		{Endpoint: "collector:4318"},
+		{Endpoint: "ftp://collector"},
+		{Endpoint: "http://collector:4318", SampleRatio: 1.5},
+		{Endpoint: "http://collector:4318", SampleRatio: -1},
+	} {
+		if _, err := New(cfg); err == nil {
+			t.Fatalf("invalid configuration %+v accepted", cfg)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package portmapper
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"net"
@@ -15,8 +16,25 @@ type mapping struct {
 	userlandProxy userlandProxy
 	host          net.Addr
 	container     net.Addr
+	hairpin       Hairpin
+	// held mappings are proxied in the daemon with no forwarding entry,
+	// see MapRequest
+	held bool
 }
 
+// Hairpin tells if the containers of the bridge reach a mapping through
+// the host addresses
+type Hairpin int
+
+const (
+	// HairpinDefault follows the hairpin mode of the iptables chain
+	HairpinDefault Hairpin = iota
+	// HairpinOn lets the containers reach the mapping
+	HairpinOn
+	// HairpinOff keeps the containers from reaching the mapping
+	HairpinOff
+)
+
 var newProxy = newProxyCommand
 
 var (
@@ -28,6 +46,8 @@ var (
 	ErrPortNotMapped = errors.New("port is not mapped")
 	// ErrSCTPAddrNoIP refers to a SCTP address without IP address.
 	ErrSCTPAddrNoIP = errors.New("sctp address does not contain any IP address")
+	// ErrHeldPortProto refers to a held mapping of a port other than TCP
+	ErrHeldPortProto = errors.New("only the tcp ports can be held")
 )
 
 // New returns a new instance of PortMapper
@@ -44,6 +64,30 @@ func NewWithPortAllocator(allocator *portallocator.PortAllocator, proxyPath stri
 	}
 }
 
+// SetInProcessProxy makes the TCP and UDP ports be proxied in the daemon
+// instead of by docker-proxy processes, for the mappings made afterwards.
+// The SCTP ports are still proxied by docker-proxy.
+func (pm *PortMapper) SetInProcessProxy(enable bool) {
+	pm.lock.Lock()
+	pm.inProcessProxy = enable
+	pm.lock.Unlock()
+}
+
+// ProxyStats returns the counters of the in-process proxies of the
+// mappings, keyed as ip:port/proto of the host address
+func (pm *PortMapper) ProxyStats() map[string]ProxyStats {
+	pm.lock.Lock()
+	defer pm.lock.Unlock()
+
+	stats := make(map[string]ProxyStats)
+	for key, m := range pm.currentMappings {
+		if p, ok := m.userlandProxy.(*inProcessProxy); ok {
+			stats[key] = p.stats()
+		}
+	}
+	return stats
+}
+
 // Map maps the specified container transport address to the host's network address and transport port
 func (pm *PortMapper) Map(container net.Addr, hostIP net.IP, hostPort int, useProxy bool) (host net.Addr, err error) {
 	return pm.MapRange(container, hostIP, hostPort, hostPort, useProxy)
@@ -54,12 +98,68 @@ func (pm *PortMapper) MapRange(container net.Addr, hostIP net.IP, hostPortStart,
 	pm.lock.Lock()
 	defer pm.lock.Unlock()
 
+	m, err := pm.newMapping(container, hostIP, hostPortStart, hostPortEnd, useProxy, false)
+	if err != nil {
+		return nil, err
+	}
+	proto := m.proto
+	_, allocatedHostPort := getIPAndPort(m.host)
+
+	// release the allocated port on any further error during return.
+	defer func() {
+		if err != nil {
+			pm.Allocator.ReleasePort(hostIP, proto, allocatedHostPort)
+		}
+	}()
+
+	key := getKey(m.host)
+	if _, exists := pm.currentMappings[key]; exists {
+		return nil, ErrPortMappedForIP
+	}
+
+	containerIP, containerPort := getIPAndPort(m.container)
+	if hostIP.To4() != nil {
+		if err := pm.AppendForwardingTableEntry(m.proto, hostIP, allocatedHostPort, containerIP.String(), containerPort); err != nil {
+			return nil, err
+		}
+	}
+
+	cleanup := func() error {
+		// need to undo the iptables rules before we return
+		m.userlandProxy.Stop()
+		if hostIP.To4() != nil {
+			pm.DeleteForwardingTableEntry(m.proto, hostIP, allocatedHostPort, containerIP.String(), containerPort)
+			if err := pm.Allocator.ReleasePort(hostIP, m.proto, allocatedHostPort); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	if err := m.userlandProxy.Start(); err != nil {
+		if err := cleanup(); err != nil {
+			return nil, fmt.Errorf("Error during port allocation cleanup: %v", err)
+		}
+		return nil, err
+	}
+
+	pm.currentMappings[key] = m
+	return m.host, nil
+}
+
+// newMapping allocates the host port of the mapping and creates its proxy,
+// the port is released on failure
+func (pm *PortMapper) newMapping(container net.Addr, hostIP net.IP, hostPortStart, hostPortEnd int, useProxy, held bool) (m *mapping, err error) {
 	var (
-		m                 *mapping
 		proto             string
 		allocatedHostPort int
 	)
 
+	if _, ok := container.(*net.TCPAddr); held && !ok {
+		return nil, ErrHeldPortProto
+	}
+
 	switch container.(type) {
 	case *net.TCPAddr:
 		proto = "tcp"
@@ -72,18 +172,6 @@ func (pm *PortMapper) MapRange(container net.Addr, hostIP net.IP, hostPortStart,
 			host:      &net.TCPAddr{IP: hostIP, Port: allocatedHostPort},
 			container: container,
 		}
-
-		if useProxy {
-			m.userlandProxy, err = newProxy(proto, hostIP, allocatedHostPort, container.(*net.TCPAddr).IP, container.(*net.TCPAddr).Port, pm.proxyPath)
-			if err != nil {
-				return nil, err
-			}
-		} else {
-			m.userlandProxy, err = newDummyProxy(proto, hostIP, allocatedHostPort)
-			if err != nil {
-				return nil, err
-			}
-		}
 	case *net.UDPAddr:
 		proto = "udp"
 		if allocatedHostPort, err = pm.Allocator.RequestPortInRange(hostIP, proto, hostPortStart, hostPortEnd); err != nil {
@@ -95,18 +183,6 @@ func (pm *PortMapper) MapRange(container net.Addr, hostIP net.IP, hostPortStart,
 			host:      &net.UDPAddr{IP: hostIP, Port: allocatedHostPort},
 			container: container,
 		}
-
-		if useProxy {
-			m.userlandProxy, err = newProxy(proto, hostIP, allocatedHostPort, container.(*net.UDPAddr).IP, container.(*net.UDPAddr).Port, pm.proxyPath)
-			if err != nil {
-				return nil, err
-			}
-		} else {
-			m.userlandProxy, err = newDummyProxy(proto, hostIP, allocatedHostPort)
-			if err != nil {
-				return nil, err
-			}
-		}
 	case *sctp.SCTPAddr:
 		proto = "sctp"
 		if allocatedHostPort, err = pm.Allocator.RequestPortInRange(hostIP, proto, hostPortStart, hostPortEnd); err != nil {
@@ -118,67 +194,187 @@ func (pm *PortMapper) MapRange(container net.Addr, hostIP net.IP, hostPortStart,
 			host:      &sctp.SCTPAddr{IP: []net.IP{hostIP}, Port: allocatedHostPort},
 			container: container,
 		}
-
-		if useProxy {
-			sctpAddr := container.(*sctp.SCTPAddr)
-			if len(sctpAddr.IP) == 0 {
-				return nil, ErrSCTPAddrNoIP
-			}
-			m.userlandProxy, err = newProxy(proto, hostIP, allocatedHostPort, sctpAddr.IP[0], sctpAddr.Port, pm.proxyPath)
-			if err != nil {
-				return nil, err
-			}
-		} else {
-			m.userlandProxy, err = newDummyProxy(proto, hostIP, allocatedHostPort)
-			if err != nil {
-				return nil, err
-			}
-		}
 	default:
 		return nil, ErrUnknownBackendAddressType
 	}
 
-	// release the allocated port on any further error during return.
 	defer func() {
 		if err != nil {
 			pm.Allocator.ReleasePort(hostIP, proto, allocatedHostPort)
 		}
 	}()
 
-	key := getKey(m.host)
-	if _, exists := pm.currentMappings[key]; exists {
-		return nil, ErrPortMappedForIP
+	if held {
+		containerIP, containerPort := getIPAndPort(container)
+		m.held = true
+		m.userlandProxy, err = newHeldProxy(hostIP, allocatedHostPort, containerIP, containerPort)
+	} else if useProxy {
+		containerIP, containerPort := getIPAndPort(container)
+		if _, ok := container.(*sctp.SCTPAddr); ok && containerIP == nil {
+			return nil, ErrSCTPAddrNoIP
+		}
+		if pm.inProcessProxy && proto != "sctp" {
+			m.userlandProxy, err = newInProcessProxy(proto, hostIP, allocatedHostPort, containerIP, containerPort)
+		} else {
+			m.userlandProxy, err = newProxy(proto, hostIP, allocatedHostPort, containerIP, containerPort, pm.proxyPath)
+		}
+	} else {
+		m.userlandProxy, err = newDummyProxy(proto, hostIP, allocatedHostPort)
 	}
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
 
-	containerIP, containerPort := getIPAndPort(m.container)
-	if hostIP.To4() != nil {
-		if err := pm.AppendForwardingTableEntry(m.proto, hostIP, allocatedHostPort, containerIP.String(), containerPort); err != nil {
+// MapRequest is a container transport address to map on a port of the
+// host address range, as for MapRange
+type MapRequest struct {
+	Container     net.Addr
+	HostIP        net.IP
+	HostPortStart int
+	HostPortEnd   int
+	Hairpin       Hairpin
+	// Held keeps the host port open in the daemon, which proxies the
+	// connections to the container with no forwarding entry. The
+	// connections arriving before the container listens wait for it
+	// instead of being refused.
+	Held bool
+}
+
+// MapRequestError is the failure of MapBatch on one of its requests, as the
+// allocation or the binding of its host port
+type MapRequestError struct {
+	// Index is the index of the failed request in the batch
+	Index int
+	Err   error
+}
+
+func (e *MapRequestError) Error() string {
+	return e.Err.Error()
+}
+
+// MapBatch maps the container transport addresses as MapRange does,
+// programming the forwarding entries of all of them at once. It returns the
+// host addresses in the order of the requests. On failure, or when the
+// context is done, none of the mappings is kept. The failures of a request
+// are returned as a MapRequestError.
+func (pm *PortMapper) MapBatch(ctx context.Context, reqs []MapRequest, useProxy bool) (hosts []net.Addr, err error) {
+	pm.lock.Lock()
+	defer pm.lock.Unlock()
+
+	var (
+		maps       = make([]*mapping, 0, len(reqs))
+		keys       = make(map[string]bool, len(reqs))
+		entries    []forwardingEntry
+		programmed bool
+		started    int
+	)
+	defer func() {
+		if err == nil {
+			return
+		}
+		for _, m := range maps[:started] {
+			m.userlandProxy.Stop()
+		}
+		if programmed {
+			if err := pm.forwardBatch(entries, false); err != nil {
+				logrus.Warnf("Failed to remove the forwarding entries of the port mappings on failure: %v", err)
+			}
+		}
+		for _, m := range maps {
+			hostIP, hostPort := getIPAndPort(m.host)
+			pm.Allocator.ReleasePort(hostIP, m.proto, hostPort)
+		}
+	}()
+
+	for i, r := range reqs {
+		if err := ctx.Err(); err != nil {
 			return nil, err
 		}
+		m, err := pm.newMapping(r.Container, r.HostIP, r.HostPortStart, r.HostPortEnd, useProxy, r.Held)
+		if err != nil {
+			return nil, &MapRequestError{Index: i, Err: err}
+		}
+		m.hairpin = r.Hairpin
+		maps = append(maps, m)
+
+		key := getKey(m.host)
+		if _, exists := pm.currentMappings[key]; exists || keys[key] {
+			return nil, &MapRequestError{Index: i, Err: ErrPortMappedForIP}
+		}
+		keys[key] = true
+
+		if r.HostIP.To4() != nil && !m.held {
+			entries = append(entries, newForwardingEntry(m))
+		}
 	}
 
-	cleanup := func() error {
-		// need to undo the iptables rules before we return
-		m.userlandProxy.Stop()
-		if hostIP.To4() != nil {
-			pm.DeleteForwardingTableEntry(m.proto, hostIP, allocatedHostPort, containerIP.String(), containerPort)
-			if err := pm.Allocator.ReleasePort(hostIP, m.proto, allocatedHostPort); err != nil {
-				return err
-			}
+	if err := pm.forwardBatch(entries, true); err != nil {
+		return nil, err
+	}
+	programmed = true
+
+	for i, m := range maps {
+		if err := ctx.Err(); err != nil {
+			return nil, err
 		}
+		if err := m.userlandProxy.Start(); err != nil {
+			return nil, &MapRequestError{Index: i, Err: err}
+		}
+		started++
+	}
 
-		return nil
+	hosts = make([]net.Addr, 0, len(maps))
+	for _, m := range maps {
+		pm.currentMappings[getKey(m.host)] = m
+		hosts = append(hosts, m.host)
 	}
+	return hosts, nil
+}
 
-	if err := m.userlandProxy.Start(); err != nil {
-		if err := cleanup(); err != nil {
-			return nil, fmt.Errorf("Error during port allocation cleanup: %v", err)
+// UnmapBatch removes the mappings as Unmap does, removing the forwarding
+// entries of all of them at once. The failures do not stop the removal of
+// the next mappings, the first one is returned.
+func (pm *PortMapper) UnmapBatch(hosts []net.Addr) error {
+	pm.lock.Lock()
+	defer pm.lock.Unlock()
+
+	var (
+		firstErr error
+		maps     []*mapping
+		entries  []forwardingEntry
+	)
+	for _, host := range hosts {
+		key := getKey(host)
+		m, exists := pm.currentMappings[key]
+		if !exists {
+			if firstErr == nil {
+				firstErr = ErrPortNotMapped
+			}
+			continue
+		}
+		if m.userlandProxy != nil {
+			m.userlandProxy.Stop()
+		}
+		delete(pm.currentMappings, key)
+		maps = append(maps, m)
+		if !m.held {
+			entries = append(entries, newForwardingEntry(m))
 		}
-		return nil, err
 	}
 
-	pm.currentMappings[key] = m
-	return m.host, nil
+	if err := pm.forwardBatch(entries, false); err != nil {
+		logrus.Errorf("Error on iptables delete: %s", err)
+	}
+
+	for _, m := range maps {
+		hostIP, hostPort := getIPAndPort(m.host)
+		if err := pm.Allocator.ReleasePort(hostIP, m.proto, hostPort); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
 }
 
 // Unmap removes stored mapping for the specified host transport address
@@ -200,8 +396,10 @@ func (pm *PortMapper) Unmap(host net.Addr) error {
 
 	containerIP, containerPort := getIPAndPort(data.container)
 	hostIP, hostPort := getIPAndPort(data.host)
-	if err := pm.DeleteForwardingTableEntry(data.proto, hostIP, hostPort, containerIP.String(), containerPort); err != nil {
-		logrus.Errorf("Error on iptables delete: %s", err)
+	if !data.held {
+		if err := pm.DeleteForwardingTableEntry(data.proto, hostIP, hostPort, containerIP.String(), containerPort); err != nil {
+			logrus.Errorf("Error on iptables delete: %s", err)
+		}
 	}
 
 	switch a := host.(type) {
@@ -223,13 +421,45 @@ func (pm *PortMapper) ReMapAll() {
 	pm.lock.Lock()
 	defer pm.lock.Unlock()
 	logrus.Debugln("Re-applying all port mappings.")
+	entries := make([]forwardingEntry, 0, len(pm.currentMappings))
 	for _, data := range pm.currentMappings {
-		containerIP, containerPort := getIPAndPort(data.container)
-		hostIP, hostPort := getIPAndPort(data.host)
-		if err := pm.AppendForwardingTableEntry(data.proto, hostIP, hostPort, containerIP.String(), containerPort); err != nil {
-			logrus.Errorf("Error on iptables add: %s", err)
+		if !data.held {
+			entries = append(entries, newForwardingEntry(data))
 		}
 	}
+	if err := pm.forwardBatch(entries, true); err != nil {
+		// One bad entry must not drop the others, they are re-applied one
+		// by one
+		logrus.Warnf("Failed to re-apply the port mappings at once: %v", err)
+		for _, e := range entries {
+			if err := pm.forwardBatch([]forwardingEntry{e}, true); err != nil {
+				logrus.Errorf("Error on iptables add of %s:%d/%s: %s", e.hostIP, e.hostPort, e.proto, err)
+			}
+		}
+	}
+}
+
+// forwardingEntry is the forwarding table entry of a mapping
+type forwardingEntry struct {
+	proto         string
+	hostIP        net.IP
+	hostPort      int
+	containerIP   string
+	containerPort int
+	hairpin       Hairpin
+}
+
+func newForwardingEntry(m *mapping) forwardingEntry {
+	containerIP, containerPort := getIPAndPort(m.container)
+	hostIP, hostPort := getIPAndPort(m.host)
+	return forwardingEntry{
+		proto:         m.proto,
+		hostIP:        hostIP,
+		hostPort:      hostPort,
+		containerIP:   containerIP.String(),
+		containerPort: containerPort,
+		hairpin:       m.hairpin,
+	}
 }
 
 func getKey(a net.Addr) string {
@@ -17,6 +17,8 @@ type PortMapper struct {
 	lock            sync.Mutex
 
 	proxyPath string
+	// inProcessProxy runs the proxies in the daemon, see SetInProcessProxy
+	inProcessProxy bool
 
 	Allocator *portallocator.PortAllocator
 	chain     *iptables.ChainInfo
@@ -44,3 +46,26 @@ func (pm *PortMapper) forward(action iptables.Action, proto string, sourceIP net
 	}
 	return pm.chain.Forward(action, sourceIP, sourcePort, proto, containerIP, containerPort, pm.bridgeName)
 }
+
+// forwardBatch adds or removes the forwarding table entries at once
+func (pm *PortMapper) forwardBatch(entries []forwardingEntry, enable bool) error {
+	if pm.chain == nil || len(entries) == 0 {
+		return nil
+	}
+	action := iptables.Delete
+	if enable {
+		action = iptables.Append
+	}
+	var rules []iptables.Rule
+	for _, e := range entries {
+		hairpin := pm.chain.HairpinMode
+		switch e.hairpin {
+		case HairpinOn:
+			hairpin = true
+		case HairpinOff:
+			hairpin = false
+		}
+		rules = append(rules, pm.chain.HairpinForwardRules(e.hostIP, e.hostPort, e.proto, e.containerIP, e.containerPort, pm.bridgeName, hairpin)...)
+	}
+	return iptables.ProgramRules(action, rules)
+}
@@ -1,12 +1,15 @@
 package portmapper
 
 import (
+	"context"
+	"errors"
 	"net"
 	"strings"
 	"testing"
 
 	"github.com/docker/libnetwork/iptables"
 	_ "github.com/docker/libnetwork/testutils"
+	"github.com/docker/libnetwork/testutils/fakeiptables"
 )
 
 func init() {
@@ -268,3 +271,106 @@ func TestMapUDPDummyListen(t *testing.T) {
 		}
 	}
 }
+
+func TestMapBatch(t *testing.T) {
+	pm := New("")
+	hostIP := net.ParseIP("192.168.0.1")
+
+	var reqs []MapRequest
+	for port := 31000; port < 31010; port++ {
+		reqs = append(reqs, MapRequest{
+			Container:     &net.TCPAddr{IP: net.ParseIP("172.16.0.1"), Port: port},
+			HostIP:        hostIP,
+			HostPortStart: port,
+			HostPortEnd:   port,
+		})
+	}
+	hosts, err := pm.MapBatch(context.Background(), reqs, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hosts) != len(reqs) || hosts[9].String() != "192.168.0.1:31009" {
+		t.Fatalf("unexpected mapped addresses: %v", hosts)
+	}
+
+	// The batch overlapping the mapped ports is rolled back as a whole
+	conflicting := []MapRequest{
+		{Container: &net.TCPAddr{IP: net.ParseIP("172.16.0.2"), Port: 80}, HostIP: hostIP, HostPortStart: 31010, HostPortEnd: 31010},
+		{Container: &net.TCPAddr{IP: net.ParseIP("172.16.0.2"), Port: 81}, HostIP: hostIP, HostPortStart: 31005, HostPortEnd: 31005},
+	}
+	if _, err := pm.MapBatch(context.Background(), conflicting, true); err == nil {
+		t.Fatal("expected the mapping of the allocated ports to fail")
+	}
+	if _, err := pm.Map(conflicting[0].Container, hostIP, 31010, true); err != nil {
+		t.Fatalf("port of the failed batch not released: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := pm.MapBatch(ctx, conflicting[:1], true); err != context.Canceled {
+		t.Fatalf("expected the batch to be canceled, got %v", err)
+	}
+
+	if err := pm.UnmapBatch(hosts); err != nil {
+		t.Fatal(err)
+	}
+	if err := pm.UnmapBatch(hosts[:1]); err != ErrPortNotMapped {
+		t.Fatalf("expected ErrPortNotMapped, got %v", err)
+	}
+	if _, err := pm.MapBatch(context.Background(), reqs, true); err != nil {
+		t.Fatalf("ports of the unmapped batch not released: %v", err)
+	}
+}
+
+// rejectingIptables is an iptables backend refusing to add the rules of the
+// address
+type rejectingIptables struct {
+	*fakeiptables.Iptables
+	addr string
+}
+
+func (b rejectingIptables) Run(ipv iptables.IPV, args ...string) ([]byte, error) {
+	cmd := strings.Join(args, " ")
+	if (strings.Contains(cmd, " -A ") || strings.Contains(cmd, " -I ")) && strings.Contains(cmd, b.addr) {
+		return []byte("rejected"), errors.New("exit status 1")
+	}
+	return b.Iptables.Run(ipv, args...)
+}
+
+func TestReMapAll(t *testing.T) {
+	setupChains := func() *iptables.ChainInfo {
+		c, err := iptables.NewChain("DOCKER", iptables.Nat, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := iptables.NewChain("DOCKER", iptables.Filter, false); err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+	defer fakeiptables.New().Install()()
+	pm := New("")
+	pm.SetIptablesChain(setupChains(), "docker0")
+
+	hostIP := net.ParseIP("192.168.0.1")
+	for i, ip := range []string{"172.16.0.1", "172.16.0.2", "172.16.0.3"} {
+		if _, err := pm.Map(&net.TCPAddr{IP: net.ParseIP(ip), Port: 80}, hostIP, 32000+i, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The firewall got reloaded, and refuses the rules of one mapping
+	ipt := fakeiptables.New()
+	defer iptables.SetBackend(rejectingIptables{Iptables: ipt, addr: "172.16.0.2"})()
+	setupChains()
+	pm.ReMapAll()
+
+	var dnat []string
+	for _, rule := range ipt.IPv4().Rules(iptables.Nat, "DOCKER") {
+		dnat = append(dnat, strings.Join(rule, " "))
+	}
+	joined := strings.Join(dnat, "\n")
+	if len(dnat) != 2 || !strings.Contains(joined, "172.16.0.1:80") || !strings.Contains(joined, "172.16.0.3:80") {
+		t.Fatalf("unexpected forwarding rules after the remapping:\n%s", joined)
+	}
+}
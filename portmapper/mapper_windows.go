@@ -16,6 +16,8 @@ type PortMapper struct {
 	lock            sync.Mutex
 
 	proxyPath string
+	// inProcessProxy runs the proxies in the daemon, see SetInProcessProxy
+	inProcessProxy bool
 
 	Allocator *portallocator.PortAllocator
 }
@@ -29,3 +31,7 @@ func (pm *PortMapper) AppendForwardingTableEntry(proto string, sourceIP net.IP,
 func (pm *PortMapper) DeleteForwardingTableEntry(proto string, sourceIP net.IP, sourcePort int, containerIP string, containerPort int) error {
 	return nil
 }
+
+func (pm *PortMapper) forwardBatch(entries []forwardingEntry, enable bool) error {
+	return nil
+}
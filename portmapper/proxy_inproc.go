@@ -0,0 +1,329 @@
+package portmapper
+
+import (
+	"context"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	inProcDialTimeout = 10 * time.Second
+	// The UDP flows are forgotten past this idle time, as by docker-proxy
+	inProcUDPTimeout = 90 * time.Second
+	inProcUDPBufSize = 65507
+	// The connections of a held port wait this long at most for the
+	// container to listen, retrying the refused connections with a backoff
+	inProcHoldTimeout    = 30 * time.Second
+	inProcHoldBackoff    = 50 * time.Millisecond
+	inProcHoldMaxBackoff = time.Second
+)
+
+// ProxyStats are the counters of the in-process proxy of a mapping
+type ProxyStats struct {
+	Proto    string `json:"proto"`
+	Frontend string `json:"frontend"`
+	Backend  string `json:"backend"`
+	// Connections are the TCP connections accepted, or the UDP flows
+	Connections uint64 `json:"connections"`
+	Active      int64  `json:"active"`
+	// BytesIn are the bytes sent to the container, BytesOut the bytes
+	// received from it
+	BytesIn  uint64 `json:"bytes_in"`
+	BytesOut uint64 `json:"bytes_out"`
+	Errors   uint64 `json:"errors"`
+}
+
+// inProcessProxy proxies a TCP or UDP port in the daemon, in place of a
+// docker-proxy process. The TCP streams are copied with io.Copy, which
+// splices between the sockets on Linux. The listening socket is opened with
+// SO_REUSEPORT, so that a mapping can be taken over without a gap.
+type inProcessProxy struct {
+	proto    string
+	frontend string
+	backend  string
+
+	// hold is the time the connections wait for the container to listen,
+	// none when zero
+	hold time.Duration
+	stop chan struct{}
+
+	listener io.Closer
+	wg       sync.WaitGroup
+
+	mu    sync.Mutex
+	conns map[io.Closer]struct{}
+
+	connections uint64
+	active      int64
+	bytesIn     uint64
+	bytesOut    uint64
+	errors      uint64
+}
+
+func newInProcessProxy(proto string, hostIP net.IP, hostPort int, containerIP net.IP, containerPort int) (userlandProxy, error) {
+	return &inProcessProxy{
+		proto:    proto,
+		frontend: net.JoinHostPort(hostIP.String(), strconv.Itoa(hostPort)),
+		backend:  net.JoinHostPort(containerIP.String(), strconv.Itoa(containerPort)),
+		conns:    make(map[io.Closer]struct{}),
+		stop:     make(chan struct{}),
+	}, nil
+}
+
+// newHeldProxy returns the in-process proxy of a held TCP port, whose
+// connections wait for the container to listen
+func newHeldProxy(hostIP net.IP, hostPort int, containerIP net.IP, containerPort int) (userlandProxy, error) {
+	p, err := newInProcessProxy("tcp", hostIP, hostPort, containerIP, containerPort)
+	if err != nil {
+		return nil, err
+	}
+	p.(*inProcessProxy).hold = inProcHoldTimeout
+	return p, nil
+}
+
+func (p *inProcessProxy) Start() error {
+	lc := net.ListenConfig{Control: reusePortControl}
+	switch p.proto {
+	case "tcp":
+		l, err := lc.Listen(context.Background(), "tcp", p.frontend)
+		if err != nil {
+			return err
+		}
+		p.listener = l
+		p.wg.Add(1)
+		go p.serveTCP(l)
+	case "udp":
+		c, err := lc.ListenPacket(context.Background(), "udp", p.frontend)
+		if err != nil {
+			return err
+		}
+		p.listener = c
+		p.wg.Add(1)
+		go p.serveUDP(c.(*net.UDPConn))
+	default:
+		return ErrUnknownBackendAddressType
+	}
+	return nil
+}
+
+func (p *inProcessProxy) Stop() error {
+	if p.listener == nil {
+		return nil
+	}
+	select {
+	case <-p.stop:
+	default:
+		close(p.stop)
+	}
+	err := p.listener.Close()
+	p.mu.Lock()
+	for c := range p.conns {
+		c.Close()
+	}
+	p.mu.Unlock()
+	p.wg.Wait()
+	return err
+}
+
+func (p *inProcessProxy) track(c io.Closer, add bool) {
+	p.mu.Lock()
+	if add {
+		p.conns[c] = struct{}{}
+	} else {
+		delete(p.conns, c)
+	}
+	p.mu.Unlock()
+}
+
+func (p *inProcessProxy) serveTCP(l net.Listener) {
+	defer p.wg.Done()
+	for {
+		client, err := l.Accept()
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				atomic.AddUint64(&p.errors, 1)
+				continue
+			}
+			return
+		}
+		atomic.AddUint64(&p.connections, 1)
+		p.wg.Add(1)
+		go p.proxyTCP(client.(*net.TCPConn))
+	}
+}
+
+func (p *inProcessProxy) proxyTCP(client *net.TCPConn) {
+	defer p.wg.Done()
+	atomic.AddInt64(&p.active, 1)
+	defer atomic.AddInt64(&p.active, -1)
+
+	p.track(client, true)
+	defer p.track(client, false)
+	defer client.Close()
+
+	conn, err := p.dialTCP()
+	if err != nil {
+		atomic.AddUint64(&p.errors, 1)
+		logrus.Debugf("In-process proxy of %s/%s: failed to connect to %s: %v", p.proto, p.frontend, p.backend, err)
+		return
+	}
+	backend := conn.(*net.TCPConn)
+	p.track(backend, true)
+	defer p.track(backend, false)
+	defer backend.Close()
+
+	var wg sync.WaitGroup
+	pipe := func(to, from *net.TCPConn, counter *uint64) {
+		defer wg.Done()
+		n, err := io.Copy(to, from)
+		atomic.AddUint64(counter, uint64(n))
+		if err != nil && !isClosedConnError(err) {
+			atomic.AddUint64(&p.errors, 1)
+		}
+		to.CloseWrite()
+		from.CloseRead()
+	}
+	wg.Add(2)
+	go pipe(backend, client, &p.bytesIn)
+	go pipe(client, backend, &p.bytesOut)
+	wg.Wait()
+}
+
+// dialTCP connects to the backend, retrying the refused connections until
+// the hold time is over or the proxy stops
+func (p *inProcessProxy) dialTCP() (net.Conn, error) {
+	deadline := time.Now().Add(p.hold)
+	backoff := inProcHoldBackoff
+	for {
+		conn, err := net.DialTimeout("tcp", p.backend, inProcDialTimeout)
+		if err == nil || p.hold == 0 || !isConnRefusedError(err) || time.Now().Add(backoff).After(deadline) {
+			return conn, err
+		}
+		select {
+		case <-p.stop:
+			return nil, err
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > inProcHoldMaxBackoff {
+			backoff = inProcHoldMaxBackoff
+		}
+	}
+}
+
+func (p *inProcessProxy) serveUDP(listener *net.UDPConn) {
+	defer p.wg.Done()
+
+	backendAddr, err := net.ResolveUDPAddr("udp", p.backend)
+	if err != nil {
+		logrus.Errorf("In-process proxy of %s/%s: invalid backend %s: %v", p.proto, p.frontend, p.backend, err)
+		return
+	}
+
+	var (
+		flowsLock sync.Mutex
+		flows     = make(map[string]*net.UDPConn)
+	)
+	buf := make([]byte, inProcUDPBufSize)
+	for {
+		n, from, err := listener.ReadFromUDP(buf)
+		if err != nil {
+			if isClosedConnError(err) {
+				return
+			}
+			atomic.AddUint64(&p.errors, 1)
+			continue
+		}
+
+		key := from.String()
+		flowsLock.Lock()
+		flow, ok := flows[key]
+		if !ok {
+			if flow, err = net.DialUDP("udp", nil, backendAddr); err != nil {
+				flowsLock.Unlock()
+				atomic.AddUint64(&p.errors, 1)
+				continue
+			}
+			flows[key] = flow
+			p.track(flow, true)
+			atomic.AddUint64(&p.connections, 1)
+			atomic.AddInt64(&p.active, 1)
+			p.wg.Add(1)
+			go func(flow *net.UDPConn, client *net.UDPAddr) {
+				defer p.wg.Done()
+				p.replyUDP(listener, flow, client)
+				flowsLock.Lock()
+				delete(flows, key)
+				flowsLock.Unlock()
+				p.track(flow, false)
+				flow.Close()
+				atomic.AddInt64(&p.active, -1)
+			}(flow, from)
+		}
+		flowsLock.Unlock()
+
+		if _, err := flow.Write(buf[:n]); err != nil {
+			atomic.AddUint64(&p.errors, 1)
+			continue
+		}
+		atomic.AddUint64(&p.bytesIn, uint64(n))
+	}
+}
+
+func (p *inProcessProxy) replyUDP(listener, flow *net.UDPConn, client *net.UDPAddr) {
+	buf := make([]byte, inProcUDPBufSize)
+	for {
+		flow.SetReadDeadline(time.Now().Add(inProcUDPTimeout))
+		n, err := flow.Read(buf)
+		if err != nil {
+			// Nothing listens on the container port yet, the flow
+			// lasts until it times out
+			if oe, ok := err.(*net.OpError); ok && oe.Err == syscall.ECONNREFUSED {
+				continue
+			}
+			return
+		}
+		if _, err := listener.WriteToUDP(buf[:n], client); err != nil {
+			atomic.AddUint64(&p.errors, 1)
+			return
+		}
+		atomic.AddUint64(&p.bytesOut, uint64(n))
+	}
+}
+
+func (p *inProcessProxy) stats() ProxyStats {
+	return ProxyStats{
+		Proto:       p.proto,
+		Frontend:    p.frontend,
+		Backend:     p.backend,
+		Connections: atomic.LoadUint64(&p.connections),
+		Active:      atomic.LoadInt64(&p.active),
+		BytesIn:     atomic.LoadUint64(&p.bytesIn),
+		BytesOut:    atomic.LoadUint64(&p.bytesOut),
+		Errors:      atomic.LoadUint64(&p.errors),
+	}
+}
+
+func isConnRefusedError(err error) bool {
+	if oe, ok := err.(*net.OpError); ok {
+		err = oe.Err
+	}
+	if se, ok := err.(*os.SyscallError); ok {
+		err = se.Err
+	}
+	return err == syscall.ECONNREFUSED
+}
+
+func isClosedConnError(err error) bool {
+	if oe, ok := err.(*net.OpError); ok {
+		err = oe.Err
+	}
+	return err == net.ErrClosed
+}
@@ -0,0 +1,194 @@
+package portmapper
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestInProcessProxyTCP(t *testing.T) {
+	backend, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backend.Close()
+	go func() {
+		for {
+			c, err := backend.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				io.Copy(c, c)
+				c.Close()
+			}()
+		}
+	}()
+
+	bAddr := backend.Addr().(*net.TCPAddr)
+	p, err := newInProcessProxy("tcp", net.ParseIP("127.0.0.1"), 0, bAddr.IP, bAddr.Port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ip := p.(*inProcessProxy)
+	// Listen on a free port, the frontend has no port allocated here
+	ip.frontend = "127.0.0.1:0"
+	if err := ip.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer ip.Stop()
+
+	c, err := net.Dial("tcp", ip.listener.(net.Listener).Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := []byte("hello")
+	if _, err := c.Write(msg); err != nil {
+		t.Fatal(err)
+	}
+	c.(*net.TCPConn).CloseWrite()
+	got, err := ioutil.ReadAll(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+	if string(got) != string(msg) {
+		t.Fatalf("expected %q through the proxy, got %q", msg, got)
+	}
+
+	waitStats(t, ip, func(s ProxyStats) bool {
+		return s.Connections == 1 && s.Active == 0 && s.BytesIn == 5 && s.BytesOut == 5
+	})
+}
+
+func TestInProcessProxyUDP(t *testing.T) {
+	backend, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backend.Close()
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, from, err := backend.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			backend.WriteToUDP(buf[:n], from)
+		}
+	}()
+
+	bAddr := backend.LocalAddr().(*net.UDPAddr)
+	p, err := newInProcessProxy("udp", net.ParseIP("127.0.0.1"), 0, bAddr.IP, bAddr.Port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ip := p.(*inProcessProxy)
+	ip.frontend = "127.0.0.1:0"
+	if err := ip.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := net.Dial("udp", ip.listener.(net.PacketConn).LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if _, err := c.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	c.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 16)
+	n, err := c.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf[:n]) != "ping" {
+		t.Fatalf("expected %q through the proxy, got %q", "ping", buf[:n])
+	}
+
+	waitStats(t, ip, func(s ProxyStats) bool {
+		return s.Connections == 1 && s.Active == 1 && s.BytesIn == 4 && s.BytesOut == 4
+	})
+
+	if err := ip.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	if s := ip.stats(); s.Active != 0 {
+		t.Fatalf("expected no active flow after stop, got %+v", s)
+	}
+}
+
+func waitStats(t *testing.T, p *inProcessProxy, done func(ProxyStats) bool) {
+	for i := 0; i < 50; i++ {
+		if done(p.stats()) {
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatalf("unexpected proxy stats: %+v", p.stats())
+}
+
+func TestHeldProxy(t *testing.T) {
+	// a free port nothing listens on yet
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bAddr := l.Addr().(*net.TCPAddr)
+	l.Close()
+
+	p, err := newHeldProxy(net.ParseIP("127.0.0.1"), 0, bAddr.IP, bAddr.Port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ip := p.(*inProcessProxy)
+	ip.frontend = "127.0.0.1:0"
+	if err := ip.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer ip.Stop()
+
+	c, err := net.Dial("tcp", ip.listener.(net.Listener).Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if _, err := c.Write([]byte("early")); err != nil {
+		t.Fatal(err)
+	}
+	c.(*net.TCPConn).CloseWrite()
+
+	// the container listens after the connection arrived
+	time.Sleep(200 * time.Millisecond)
+	backend, err := net.Listen("tcp", bAddr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backend.Close()
+	go func() {
+		b, err := backend.Accept()
+		if err != nil {
+			return
+		}
+		io.Copy(b, b)
+		b.Close()
+	}()
+
+	c.SetReadDeadline(time.Now().Add(5 * time.Second))
+	got, err := ioutil.ReadAll(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "early" {
+		t.Fatalf("expected the held connection to go through, got %q", got)
+	}
+
+	// only the TCP ports are held
+	pm := NewWithPortAllocator(nil, "")
+	if _, err := pm.newMapping(&net.UDPAddr{IP: bAddr.IP, Port: 53}, net.ParseIP("127.0.0.1"), 0, 0, false, true); err != ErrHeldPortProto {
+		t.Fatalf("expected the held UDP port to fail with %v, got %v", ErrHeldPortProto, err)
+	}
+}
@@ -3,8 +3,13 @@ package portmapper
 import (
 	"errors"
 	"net"
+	"syscall"
 )
 
 func newProxyCommand(proto string, hostIP net.IP, hostPort int, containerIP net.IP, containerPort int, proxyPath string) (userlandProxy, error) {
 	return nil, errors.New("proxy is unsupported on windows")
 }
+
+func reusePortControl(network, address string, c syscall.RawConn) error {
+	return nil
+}
@@ -0,0 +1,121 @@
+package libnetwork
+
+import (
+	"fmt"
+
+	"github.com/docker/libnetwork/driverapi"
+	"github.com/docker/libnetwork/types"
+	"github.com/sirupsen/logrus"
+)
+
+// Quarantine describes the quarantine of an endpoint
+type Quarantine struct {
+	// Action is driverapi.QuarantineDrop or driverapi.QuarantineReject
+	Action string
+	// PauseDNS withdraws the service records of the endpoint for the time
+	// of the quarantine
+	PauseDNS bool
+}
+
+// QuarantineEndpoint cuts the traffic of the endpoint off through the
+// network driver, which has to implement driverapi.Quarantiner, or lets it
+// through again when the quarantine is nil. A quarantine replaces the
+// previous one of the endpoint in place.
+func (c *controller) QuarantineEndpoint(networkID, endpointID string, q *Quarantine) error {
+	nw, err := c.NetworkByID(networkID)
+	if err != nil {
+		return err
+	}
+	n := nw.(*network)
+	e, err := n.EndpointByID(endpointID)
+	if err != nil {
+		return err
+	}
+	ep := e.(*endpoint)
+
+	var action string
+	if q != nil {
+		switch q.Action {
+		case driverapi.QuarantineDrop, driverapi.QuarantineReject:
+		default:
+			return types.BadRequestErrorf("invalid quarantine action %q", q.Action)
+		}
+		action = q.Action
+	}
+
+	d, err := n.driver(true)
+	if err != nil {
+		return err
+	}
+	qd, ok := d.(driverapi.Quarantiner)
+	if !ok {
+		return types.NotImplementedErrorf("the %s driver does not support the quarantine of the endpoints", n.Type())
+	}
+	if err := qd.QuarantineEndpoint(n.ID(), endpointID, action); err != nil {
+		return err
+	}
+
+	if err := c.pauseServiceRecords(n, ep, q != nil && q.PauseDNS); err != nil {
+		logrus.Warnf("Failed to update the service records of quarantined endpoint %.7s: %v", endpointID, err)
+	}
+
+	if q == nil {
+		logrus.Infof("Lifted the quarantine of endpoint %.7s", endpointID)
+	} else {
+		logrus.Infof("Quarantined endpoint %.7s with %s", endpointID, action)
+	}
+	return nil
+}
+
+// pauseServiceRecords withdraws the service records of the endpoint when
+// pause is set, or publishes back the ones it withdrew before
+func (c *controller) pauseServiceRecords(n *network, ep *endpoint, pause bool) error {
+	c.Lock()
+	paused := c.dnsPaused[ep.ID()]
+	c.Unlock()
+	if paused == pause {
+		return nil
+	}
+
+	sb, ok := ep.getSandbox()
+	if !ok {
+		return nil
+	}
+	if c.isAgent() {
+		if pause {
+			if err := ep.deleteServiceInfoFromCluster(sb, true, "quarantine"); err != nil {
+				return err
+			}
+		} else if err := ep.addServiceInfoToCluster(sb); err != nil {
+			return err
+		}
+	} else {
+		c.Lock()
+		netWatch, ok := c.nmap[n.ID()]
+		c.Unlock()
+		if !ok {
+			return fmt.Errorf("watch null for network %q", n.Name())
+		}
+		n.updateSvcRecord(ep, c.getLocalEps(netWatch), !pause)
+	}
+
+	c.Lock()
+	if pause {
+		if c.dnsPaused == nil {
+			c.dnsPaused = map[string]bool{}
+		}
+		c.dnsPaused[ep.ID()] = true
+	} else {
+		delete(c.dnsPaused, ep.ID())
+	}
+	c.Unlock()
+	return nil
+}
+
+// forgetPausedRecords drops the pause of the service records of the deleted
+// endpoint
+func (c *controller) forgetPausedRecords(eid string) {
+	c.Lock()
+	delete(c.dnsPaused, eid)
+	c.Unlock()
+}
@@ -0,0 +1,98 @@
+package libnetwork
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/docker/libnetwork/diagnostic"
+	"github.com/docker/libnetwork/driverapi"
+	"github.com/docker/libnetwork/internal/caller"
+	"github.com/sirupsen/logrus"
+)
+
+// reconcilePaths2Func are the diagnostic handlers of the controller
+var reconcilePaths2Func = map[string]diagnostic.HTTPHandlerFunc{
+	"/reconcile": reconcileDiag,
+}
+
+// driftsResult is the diagnostic output of a reconciliation
+type driftsResult struct {
+	Drifts []driverapi.Drift `json:"drifts"`
+}
+
+func (r *driftsResult) String() string {
+	var b strings.Builder
+	for _, d := range r.Drifts {
+		status := "detected"
+		switch {
+		case d.Repaired:
+			status = "repaired"
+		case d.RepairError != "":
+			status = "repair failed: " + d.RepairError
+		}
+		fmt.Fprintf(&b, "%s nid:%s eid:%s %s (%s)\n", d.Resource, d.NetworkID, d.EndpointID, d.Detail, status)
+	}
+	return b.String()
+}
+
+// Reconcile audits the kernel state of the sandboxes, the load balancers
+// and the networks of the drivers implementing driverapi.Reconciler
+// against the state libnetwork holds, and returns the differences found.
+// When repair is set, the kernel state is reprogrammed where possible.
+func (c *controller) Reconcile(repair bool) ([]driverapi.Drift, error) {
+	drifts := c.reconcileSandboxes(repair)
+	drifts = append(drifts, c.reconcileLoadBalancers(repair)...)
+
+	c.drvRegistry.WalkDrivers(func(name string, driver driverapi.Driver, capability driverapi.Capability) bool {
+		r, ok := driver.(driverapi.Reconciler)
+		if !ok {
+			return false
+		}
+		d, err := r.Reconcile(repair)
+		if err != nil {
+			logrus.Warnf("Failed to reconcile the state of driver %s: %v", name, err)
+			return false
+		}
+		drifts = append(drifts, d...)
+		return false
+	})
+
+	for _, d := range drifts {
+		switch {
+		case d.Repaired:
+			logrus.Infof("Repaired %s drift on network %.7s endpoint %.7s: %s", d.Resource, d.NetworkID, d.EndpointID, d.Detail)
+		case d.RepairError != "":
+			logrus.Warnf("Failed to repair %s drift on network %.7s endpoint %.7s: %s: %s", d.Resource, d.NetworkID, d.EndpointID, d.Detail, d.RepairError)
+		default:
+			logrus.Warnf("Detected %s drift on network %.7s endpoint %.7s: %s", d.Resource, d.NetworkID, d.EndpointID, d.Detail)
+		}
+	}
+
+	return drifts, nil
+}
+
+func reconcileDiag(ctx interface{}, w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	diagnostic.DebugHTTPForm(r)
+	_, json := diagnostic.ParseHTTPFormOptions(r)
+
+	// audit logs
+	log := logrus.WithFields(logrus.Fields{"component": "diagnostic", "remoteIP": r.RemoteAddr, "method": caller.Name(0), "url": r.URL.String()})
+	log.Info("reconcile")
+
+	_, repair := r.Form["repair"]
+	c, ok := ctx.(*controller)
+	if !ok {
+		diagnostic.HTTPReply(w, diagnostic.FailCommand(fmt.Errorf("controller not available")), json)
+		return
+	}
+	drifts, err := c.Reconcile(repair)
+	if err != nil {
+		log.WithError(err).Error("reconcile failed")
+		diagnostic.HTTPReply(w, diagnostic.FailCommand(err), json)
+		return
+	}
+	log.Infof("reconcile done, %d drifts", len(drifts))
+	diagnostic.HTTPReply(w, diagnostic.CommandSucceed(&driftsResult{Drifts: drifts}), json)
+}
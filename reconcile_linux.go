@@ -0,0 +1,259 @@
+package libnetwork
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+
+	"github.com/docker/libnetwork/driverapi"
+	"github.com/docker/libnetwork/ipvs"
+	"github.com/sirupsen/logrus"
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+	"github.com/vishvananda/netns"
+)
+
+// reconcileSandboxes checks the interfaces, addresses and default route of
+// the container sandboxes
+func (c *controller) reconcileSandboxes(repair bool) []driverapi.Drift {
+	c.Lock()
+	sboxes := make([]*sandbox, 0, len(c.sandboxes))
+	for _, sb := range c.sandboxes {
+		sboxes = append(sboxes, sb)
+	}
+	c.Unlock()
+
+	var drifts []driverapi.Drift
+	for _, sb := range sboxes {
+		if sb.config.useDefaultSandBox || sb.osSbox == nil {
+			continue
+		}
+		d, err := sb.reconcile(repair)
+		if err != nil {
+			logrus.Warnf("Failed to reconcile sandbox %.7s: %v", sb.ID(), err)
+			continue
+		}
+		drifts = append(drifts, d...)
+	}
+	return drifts
+}
+
+func (sb *sandbox) reconcile(repair bool) ([]driverapi.Drift, error) {
+	nsh, err := netns.GetFromPath(sb.Key())
+	if err != nil {
+		return nil, fmt.Errorf("failed to open namespace %s: %v", sb.Key(), err)
+	}
+	defer nsh.Close()
+	nlh, err := netlink.NewHandleAt(nsh, syscall.NETLINK_ROUTE)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open a netlink handle in %s: %v", sb.Key(), err)
+	}
+	defer nlh.Delete()
+
+	var drifts []driverapi.Drift
+	info := sb.osSbox.Info()
+	for _, ep := range sb.getConnectedEndpoints() {
+		if ep.Iface() == nil {
+			continue
+		}
+		for _, i := range info.Interfaces() {
+			if i.SrcName() != ep.Iface().SrcName() {
+				continue
+			}
+			link, err := nlh.LinkByName(i.DstName())
+			if err != nil {
+				drifts = append(drifts, driverapi.Drift{
+					NetworkID:  ep.getNetwork().ID(),
+					EndpointID: ep.ID(),
+					Resource:   "link",
+					Detail:     fmt.Sprintf("interface %s missing in sandbox %.7s", i.DstName(), sb.ID()),
+				})
+				continue
+			}
+			if link.Attrs().Flags&net.FlagUp == 0 {
+				d := driverapi.Drift{
+					NetworkID:  ep.getNetwork().ID(),
+					EndpointID: ep.ID(),
+					Resource:   "link",
+					Detail:     fmt.Sprintf("interface %s is down in sandbox %.7s", i.DstName(), sb.ID()),
+				}
+				if repair {
+					d.SetRepairResult(nlh.LinkSetUp(link))
+				}
+				drifts = append(drifts, d)
+			}
+			for _, addr := range []*net.IPNet{i.Address(), i.AddressIPv6()} {
+				if addr == nil || hasLinkAddress(nlh, link, addr) {
+					continue
+				}
+				d := driverapi.Drift{
+					NetworkID:  ep.getNetwork().ID(),
+					EndpointID: ep.ID(),
+					Resource:   "address",
+					Detail:     fmt.Sprintf("address %s missing on %s in sandbox %.7s", addr, i.DstName(), sb.ID()),
+				}
+				if repair {
+					d.SetRepairResult(nlh.AddrAdd(link, &netlink.Addr{IPNet: addr}))
+				}
+				drifts = append(drifts, d)
+			}
+		}
+	}
+
+	if gw := info.Gateway(); len(gw) > 0 && !hasDefaultRoute(nlh, gw) {
+		d := driverapi.Drift{
+			Resource: "route",
+			Detail:   fmt.Sprintf("default route via %s missing in sandbox %.7s", gw, sb.ID()),
+		}
+		if ep := sb.getGatewayEndpoint(); ep != nil {
+			d.NetworkID = ep.getNetwork().ID()
+			d.EndpointID = ep.ID()
+		}
+		if repair {
+			d.SetRepairResult(sb.osSbox.SetGateway(gw))
+		}
+		drifts = append(drifts, d)
+	}
+
+	return drifts, nil
+}
+
+func hasLinkAddress(nlh *netlink.Handle, link netlink.Link, addr *net.IPNet) bool {
+	addrs, err := nlh.AddrList(link, netlink.FAMILY_ALL)
+	if err != nil {
+		// Do not report a drift on a transient error
+		return true
+	}
+	for _, a := range addrs {
+		if a.IPNet.IP.Equal(addr.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+func hasDefaultRoute(nlh *netlink.Handle, gw net.IP) bool {
+	routes, err := nlh.RouteList(nil, netlink.FAMILY_ALL)
+	if err != nil {
+		return true
+	}
+	for _, r := range routes {
+		if r.Dst == nil && r.Gw.Equal(gw) {
+			return true
+		}
+	}
+	return false
+}
+
+// reconcileLoadBalancers checks the IPVS services and destinations of the
+// load balancers against the service bindings
+func (c *controller) reconcileLoadBalancers(repair bool) []driverapi.Drift {
+	c.Lock()
+	services := make([]*service, 0, len(c.serviceBindings))
+	for _, s := range c.serviceBindings {
+		services = append(services, s)
+	}
+	c.Unlock()
+
+	var drifts []driverapi.Drift
+	for _, s := range services {
+		s.Lock()
+		lbs := make(map[string]*loadBalancer, len(s.loadBalancers))
+		for nid, lb := range s.loadBalancers {
+			lbs[nid] = lb
+		}
+		s.Unlock()
+
+		for nid, lb := range lbs {
+			n, err := c.NetworkByID(nid)
+			if err != nil {
+				continue
+			}
+			drifts = append(drifts, n.(*network).reconcileLoadBalancer(lb, repair)...)
+		}
+	}
+	return drifts
+}
+
+func (n *network) reconcileLoadBalancer(lb *loadBalancer, repair bool) []driverapi.Drift {
+	if len(lb.vip) == 0 {
+		return nil
+	}
+	_, sb, err := n.findLBEndpointSandbox()
+	if err != nil || sb.osSbox == nil {
+		return nil
+	}
+
+	i, err := ipvs.New(sb.Key())
+	if err != nil {
+		logrus.Warnf("Failed to create an ipvs handle for sbox %.7s: %v", sb.ID(), err)
+		return nil
+	}
+	defer i.Close()
+
+	lb.Lock()
+	backends := make(map[string]net.IP, len(lb.backEnds))
+	for eid, be := range lb.backEnds {
+		if !be.disabled {
+			backends[eid] = be.ip
+		}
+	}
+	lb.Unlock()
+
+	s := &ipvs.Service{
+		AddressFamily: nl.FAMILY_V4,
+		FWMark:        lb.fwMark,
+	}
+	present := lbDestinations(i, s)
+
+	var (
+		drifts   []driverapi.Drift
+		repaired bool
+	)
+	for eid, ip := range backends {
+		if present[ip.String()] {
+			continue
+		}
+		drifts = append(drifts, driverapi.Drift{
+			NetworkID:  n.ID(),
+			EndpointID: eid,
+			Resource:   "ipvs",
+			Detail:     fmt.Sprintf("backend %s of vip %s (fwmark %d) missing in sbox %.7s", ip, lb.vip, lb.fwMark, sb.ID()),
+		})
+		if repair {
+			// addLBBackend creates the service as well when it is missing
+			n.addLBBackend(ip, lb)
+			repaired = true
+		}
+	}
+	if !repaired {
+		return drifts
+	}
+
+	// addLBBackend only logs its failures, look again at the kernel state
+	present = lbDestinations(i, s)
+	for k := range drifts {
+		if ip := backends[drifts[k].EndpointID]; present[ip.String()] {
+			drifts[k].SetRepairResult(nil)
+		} else {
+			drifts[k].SetRepairResult(fmt.Errorf("backend %s still missing", ip))
+		}
+	}
+	return drifts
+}
+
+func lbDestinations(i *ipvs.Handle, s *ipvs.Service) map[string]bool {
+	present := map[string]bool{}
+	if !i.IsServicePresent(s) {
+		return present
+	}
+	dsts, err := i.GetDestinations(s)
+	if err != nil {
+		logrus.Warnf("Failed to get the destinations of fwmark %d: %v", s.FWMark, err)
+		return present
+	}
+	for _, d := range dsts {
+		present[d.Address.String()] = true
+	}
+	return present
+}
@@ -0,0 +1,13 @@
+// +build !linux
+
+package libnetwork
+
+import "github.com/docker/libnetwork/driverapi"
+
+func (c *controller) reconcileSandboxes(repair bool) []driverapi.Drift {
+	return nil
+}
+
+func (c *controller) reconcileLoadBalancers(repair bool) []driverapi.Drift {
+	return nil
+}
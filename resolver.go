@@ -92,6 +92,9 @@ type resolver struct {
 	proxyDNS      bool
 	resolverKey   string
 	startCh       chan struct{}
+	// The rules redirecting to the sockets were set up ahead of the
+	// start, as for the pooled sandboxes
+	tablesReady bool
 }
 
 func init() {
@@ -150,8 +153,10 @@ func (r *resolver) Start() error {
 		return r.err
 	}
 
-	if err := r.setupIPTable(); err != nil {
-		return fmt.Errorf("setting up IP table rules failed: %v", err)
+	if !r.tablesReady {
+		if err := r.setupIPTable(); err != nil {
+			return fmt.Errorf("setting up IP table rules failed: %v", err)
+		}
 	}
 
 	s := &dns.Server{Handler: r, PacketConn: r.conn}
@@ -178,7 +183,16 @@ func (r *resolver) Stop() {
 	if r.tcpServer != nil {
 		r.tcpServer.Shutdown()
 	}
+	if r.server == nil && r.conn != nil {
+		// Set up but never started
+		r.conn.Close()
+		if r.tcpListen != nil {
+			r.tcpListen.Close()
+		}
+	}
+	r.server = nil
 	r.conn = nil
+	r.tablesReady = false
 	r.tcpServer = nil
 	r.err = fmt.Errorf("setup not done yet")
 	r.tStamp = time.Time{}
@@ -356,7 +370,39 @@ func truncateResp(resp *dns.Msg, maxSize int, isTCP bool) {
 	}
 }
 
+// maxRespSize returns the size the response to the query fits in, as
+// advertised by the EDNS0 option over UDP
+func maxRespSize(proto string, query *dns.Msg) int {
+	maxSize := 0
+	if proto == "tcp" {
+		maxSize = dns.MaxMsgSize - 1
+	} else if proto == "udp" {
+		optRR := query.IsEdns0()
+		if optRR != nil {
+			maxSize = int(optRR.UDPSize())
+		}
+		if maxSize < defaultRespSize {
+			maxSize = defaultRespSize
+		}
+	}
+	return maxSize
+}
+
 func (r *resolver) ServeDNS(w dns.ResponseWriter, query *dns.Msg) {
+	if r.ipv6OnlyQuery(query) {
+		if err := w.WriteMsg(createRespMsg(query)); err != nil {
+			logrus.Errorf("[resolver] error writing resolver resp, %s", err)
+		}
+		return
+	}
+	if prefix := r.dns64Prefix(query); prefix != nil {
+		r.serveDNS64(w, query, prefix)
+		return
+	}
+	r.serveDNS(w, query)
+}
+
+func (r *resolver) serveDNS(w dns.ResponseWriter, query *dns.Msg) {
 	var (
 		extConn net.Conn
 		resp    *dns.Msg
@@ -379,6 +425,8 @@ func (r *resolver) ServeDNS(w dns.ResponseWriter, query *dns.Msg) {
 		resp, err = r.handlePTRQuery(name, query)
 	case dns.TypeSRV:
 		resp, err = r.handleSRVQuery(name, query)
+	case dns.TypeTXT:
+		resp, err = r.handleTXTQuery(name, query)
 	}
 
 	if err != nil {
@@ -411,18 +459,7 @@ func (r *resolver) ServeDNS(w dns.ResponseWriter, query *dns.Msg) {
 	}
 
 	proto := w.LocalAddr().Network()
-	maxSize := 0
-	if proto == "tcp" {
-		maxSize = dns.MaxMsgSize - 1
-	} else if proto == "udp" {
-		optRR := query.IsEdns0()
-		if optRR != nil {
-			maxSize = int(optRR.UDPSize())
-		}
-		if maxSize < defaultRespSize {
-			maxSize = defaultRespSize
-		}
-	}
+	maxSize := maxRespSize(proto, query)
 
 	if resp != nil {
 		if resp.Len() > maxSize {
@@ -0,0 +1,221 @@
+// Package routeagg summarizes the host routes to the addresses of an IPv4
+// subnet into aggregate routes. The drivers routing thousands of endpoints
+// use it to keep their route tables small: the addresses sharing a next hop
+// are reached through the shortest prefix covering them and no address of
+// another next hop, and only the addresses interleaved with the ones of
+// other next hops keep their host routes.
+//
+// The aggregates cover addresses not in use, which are then routed to the
+// next hop of the aggregate instead of being unreachable. A new address of
+// another next hop splits the aggregate, its route being among the ones to
+// add before the aggregate is removed.
+package routeagg
+
+import (
+	"encoding/binary"
+	"math/bits"
+	"net"
+	"sort"
+)
+
+// Route is the route to a prefix through a next hop, as the caller names
+// them
+type Route struct {
+	Dst     *net.IPNet
+	NextHop string
+}
+
+// Table keeps the routes of the addresses of a subnet by next hop. The
+// addresses with an empty next hop are reserved: they get no route and no
+// aggregate covers them, as the addresses reached through the connected
+// route of the subnet.
+type Table struct {
+	subnet    *net.IPNet
+	aggregate bool
+	hosts     map[uint32]string
+	routes    map[string]Route
+}
+
+// NewTable returns the table of the subnet, which summarizes the routes
+// when aggregate is set and keeps the host routes otherwise. The aggregates
+// are longer than the subnet, so that they never replace the route of the
+// subnet itself.
+func NewTable(subnet *net.IPNet, aggregate bool) *Table {
+	return &Table{
+		subnet:    &net.IPNet{IP: subnet.IP.Mask(subnet.Mask).To4(), Mask: subnet.Mask},
+		aggregate: aggregate,
+		hosts:     map[uint32]string{},
+		routes:    map[string]Route{},
+	}
+}
+
+// Contains tells whether the address is in the subnet of the table
+func (t *Table) Contains(ip net.IP) bool {
+	return ip.To4() != nil && t.subnet.Contains(ip)
+}
+
+// Set routes the address through the next hop, or reserves it when the
+// next hop is empty, and returns the routes to add or replace, then the
+// ones to remove
+func (t *Table) Set(ip net.IP, nextHop string) (add, del []Route) {
+	if !t.Contains(ip) {
+		return nil, nil
+	}
+	key := toUint32(ip)
+	if nh, ok := t.hosts[key]; ok && nh == nextHop {
+		return nil, nil
+	}
+	t.hosts[key] = nextHop
+	return t.update()
+}
+
+// Delete forgets the address and returns the routes to add or replace,
+// then the ones to remove
+func (t *Table) Delete(ip net.IP) (add, del []Route) {
+	if !t.Contains(ip) {
+		return nil, nil
+	}
+	key := toUint32(ip)
+	if _, ok := t.hosts[key]; !ok {
+		return nil, nil
+	}
+	delete(t.hosts, key)
+	return t.update()
+}
+
+// Routes returns the current routes of the table, sorted by destination
+func (t *Table) Routes() []Route {
+	routes := make([]Route, 0, len(t.routes))
+	for _, r := range t.routes {
+		routes = append(routes, r)
+	}
+	sortRoutes(routes)
+	return routes
+}
+
+// Hosts returns the number of addresses the table routes or reserves
+func (t *Table) Hosts() int {
+	return len(t.hosts)
+}
+
+// Aggregates returns the number of routes of the table shorter than the
+// host routes
+func (t *Table) Aggregates() int {
+	var count int
+	for _, r := range t.routes {
+		if ones, _ := r.Dst.Mask.Size(); ones < 32 {
+			count++
+		}
+	}
+	return count
+}
+
+// update summarizes the addresses again and returns the difference with
+// the routes in place
+func (t *Table) update() (add, del []Route) {
+	routes := map[string]Route{}
+	for _, r := range t.summarize() {
+		routes[r.Dst.String()] = r
+	}
+	for dst, r := range routes {
+		if old, ok := t.routes[dst]; !ok || old.NextHop != r.NextHop {
+			add = append(add, r)
+		}
+	}
+	for dst, r := range t.routes {
+		if _, ok := routes[dst]; !ok {
+			del = append(del, r)
+		}
+	}
+	t.routes = routes
+	sortRoutes(add)
+	sortRoutes(del)
+	return add, del
+}
+
+type host struct {
+	ip      uint32
+	nextHop string
+}
+
+func (t *Table) summarize() []Route {
+	hosts := make([]host, 0, len(t.hosts))
+	for ip, nh := range t.hosts {
+		hosts = append(hosts, host{ip, nh})
+	}
+	sort.Slice(hosts, func(i, j int) bool { return hosts[i].ip < hosts[j].ip })
+
+	var routes []Route
+	if !t.aggregate {
+		for _, h := range hosts {
+			if h.nextHop != "" {
+				routes = append(routes, route(h.ip, 32, h.nextHop))
+			}
+		}
+		return routes
+	}
+	ones, _ := t.subnet.Mask.Size()
+	summarize(toUint32(t.subnet.IP), ones, ones+1, hosts, &routes)
+	return routes
+}
+
+// summarize appends the routes of the sorted hosts of the prefix: the
+// prefix common to them all when they share one next hop, once the prefix
+// is at least minLen long, else the routes of each half of the prefix
+func summarize(base uint32, plen, minLen int, hosts []host, routes *[]Route) {
+	if len(hosts) == 0 {
+		return
+	}
+	if plen >= minLen && sameNextHop(hosts) {
+		if hosts[0].nextHop == "" {
+			return
+		}
+		first, last := hosts[0].ip, hosts[len(hosts)-1].ip
+		common := bits.LeadingZeros32(first ^ last)
+		*routes = append(*routes, route(first, common, hosts[0].nextHop))
+		return
+	}
+	if plen >= 32 {
+		return
+	}
+	mid := base | 1<<uint(31-plen)
+	i := sort.Search(len(hosts), func(i int) bool { return hosts[i].ip >= mid })
+	summarize(base, plen+1, minLen, hosts[:i], routes)
+	summarize(mid, plen+1, minLen, hosts[i:], routes)
+}
+
+func sameNextHop(hosts []host) bool {
+	for _, h := range hosts[1:] {
+		if h.nextHop != hosts[0].nextHop {
+			return false
+		}
+	}
+	return true
+}
+
+func route(ip uint32, ones int, nextHop string) Route {
+	mask := net.CIDRMask(ones, 32)
+	return Route{Dst: &net.IPNet{IP: fromUint32(ip).Mask(mask), Mask: mask}, NextHop: nextHop}
+}
+
+func sortRoutes(routes []Route) {
+	sort.Slice(routes, func(i, j int) bool {
+		a, b := toUint32(routes[i].Dst.IP), toUint32(routes[j].Dst.IP)
+		if a != b {
+			return a < b
+		}
+		oa, _ := routes[i].Dst.Mask.Size()
+		ob, _ := routes[j].Dst.Mask.Size()
+		return oa < ob
+	})
+}
+
+func toUint32(ip net.IP) uint32 {
+	return binary.BigEndian.Uint32(ip.To4())
+}
+
+func fromUint32(v uint32) net.IP {
+	ip := make(net.IP, net.IPv4len)
+	binary.BigEndian.PutUint32(ip, v)
+	return ip
+}
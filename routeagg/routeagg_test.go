@@ -0,0 +1,99 @@
+package routeagg
+
+import (
+	"net"
+	"reflect"
+	"testing"
+)
+
+func routeStrings(routes []Route) []string {
+	var l []string
+	for _, r := range routes {
+		l = append(l, r.Dst.String()+" "+r.NextHop)
+	}
+	return l
+}
+
+func TestSummarize(t *testing.T) {
+	_, subnet, _ := net.ParseCIDR("10.0.0.0/24")
+	tb := NewTable(subnet, true)
+	for i := 2; i < 64; i++ {
+		tb.Set(net.IPv4(10, 0, 0, byte(i)), "a")
+	}
+	tb.Set(net.IPv4(10, 0, 0, 130), "b")
+	tb.Set(net.IPv4(10, 0, 0, 131), "b")
+	expected := []string{"10.0.0.0/26 a", "10.0.0.130/31 b"}
+	if routes := routeStrings(tb.Routes()); !reflect.DeepEqual(routes, expected) {
+		t.Fatalf("unexpected routes %v, expected %v", routes, expected)
+	}
+	if tb.Hosts() != 64 || tb.Aggregates() != 2 {
+		t.Fatalf("unexpected sizes: %d hosts, %d aggregates", tb.Hosts(), tb.Aggregates())
+	}
+
+	// An address of another next hop splits the aggregate, the new routes
+	// being added before the aggregate is removed
+	add, del := tb.Set(net.IPv4(10, 0, 0, 10), "b")
+	if d := routeStrings(del); !reflect.DeepEqual(d, []string{"10.0.0.0/26 a"}) {
+		t.Fatalf("unexpected removed routes %v", d)
+	}
+	expected = []string{"10.0.0.0/29 a", "10.0.0.8/31 a", "10.0.0.10/32 b", "10.0.0.11/32 a", "10.0.0.12/30 a", "10.0.0.16/28 a", "10.0.0.32/27 a"}
+	if a := routeStrings(add); !reflect.DeepEqual(a, expected) {
+		t.Fatalf("unexpected added routes %v, expected %v", a, expected)
+	}
+
+	// Deleting the address merges the aggregate back
+	add, del = tb.Delete(net.IPv4(10, 0, 0, 10))
+	if a := routeStrings(add); !reflect.DeepEqual(a, []string{"10.0.0.0/26 a"}) || len(del) != 7 {
+		t.Fatalf("unexpected changes: added %v, removed %v", a, routeStrings(del))
+	}
+
+	// A next hop change replaces the route in place
+	add, del = tb.Set(net.IPv4(10, 0, 0, 130), "c")
+	if a := routeStrings(add); !reflect.DeepEqual(a, []string{"10.0.0.130/32 c", "10.0.0.131/32 b"}) {
+		t.Fatalf("unexpected added routes %v", a)
+	}
+	if d := routeStrings(del); !reflect.DeepEqual(d, []string{"10.0.0.130/31 b"}) {
+		t.Fatalf("unexpected removed routes %v", d)
+	}
+}
+
+func TestSummarizeReserved(t *testing.T) {
+	_, subnet, _ := net.ParseCIDR("10.0.0.0/24")
+	tb := NewTable(subnet, true)
+	tb.Set(net.IPv4(10, 0, 0, 1), "")
+	for i := 2; i < 6; i++ {
+		tb.Set(net.IPv4(10, 0, 0, byte(i)), "a")
+	}
+	expected := []string{"10.0.0.2/31 a", "10.0.0.4/31 a"}
+	if routes := routeStrings(tb.Routes()); !reflect.DeepEqual(routes, expected) {
+		t.Fatalf("unexpected routes %v, expected %v", routes, expected)
+	}
+
+	// The aggregates are never as short as the subnet
+	for i := 6; i < 255; i++ {
+		tb.Set(net.IPv4(10, 0, 0, byte(i)), "a")
+	}
+	tb.Delete(net.IPv4(10, 0, 0, 1))
+	expected = []string{"10.0.0.0/25 a", "10.0.0.128/25 a"}
+	if routes := routeStrings(tb.Routes()); !reflect.DeepEqual(routes, expected) {
+		t.Fatalf("unexpected routes %v, expected %v", routes, expected)
+	}
+
+	if add, del := tb.Set(net.IPv4(10, 0, 1, 1), "a"); add != nil || del != nil {
+		t.Fatal("address out of the subnet routed")
+	}
+}
+
+func TestHostRoutes(t *testing.T) {
+	_, subnet, _ := net.ParseCIDR("10.0.0.0/24")
+	tb := NewTable(subnet, false)
+	tb.Set(net.IPv4(10, 0, 0, 1), "")
+	tb.Set(net.IPv4(10, 0, 0, 2), "a")
+	add, _ := tb.Set(net.IPv4(10, 0, 0, 3), "a")
+	if a := routeStrings(add); !reflect.DeepEqual(a, []string{"10.0.0.3/32 a"}) {
+		t.Fatalf("unexpected added routes %v", a)
+	}
+	if tb.Aggregates() != 0 || len(tb.Routes()) != 2 {
+		t.Fatalf("unexpected routes %v", routeStrings(tb.Routes()))
+	}
+}
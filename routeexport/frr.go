@@ -0,0 +1,114 @@
+package routeexport
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultFRRTag is the tag of the static routes the FRR exporter adds,
+// for the route maps of the redistribution to match them
+const DefaultFRRTag = 4739
+
+// FRR exports the routes as static routes of zebra, configured through
+// vtysh. The IGPs redistribute them with, for example:
+//
+//	route-map docker permit 10
+//	 match tag 4739
+//	router ospf
+//	 redistribute static route-map docker
+type FRR struct {
+	sync.Mutex
+	vtysh string
+	vrf   string
+	tag   uint32
+	// run runs vtysh, stubbed in the tests
+	run func(name string, args ...string) ([]byte, error)
+}
+
+// NewFRR returns an exporter configuring the routes through the vtysh
+// command, in the VRF if not empty, tagged with the tag, DefaultFRRTag if 0
+func NewFRR(vtysh, vrf string, tag uint32) (*FRR, error) {
+	if vtysh == "" {
+		vtysh = "vtysh"
+	}
+	path, err := exec.LookPath(vtysh)
+	if err != nil {
+		return nil, fmt.Errorf("FRR route exporter: %v", err)
+	}
+	if tag == 0 {
+		tag = DefaultFRRTag
+	}
+	return &FRR{
+		vtysh: path,
+		vrf:   vrf,
+		tag:   tag,
+		run: func(name string, args ...string) ([]byte, error) {
+			return exec.Command(name, args...).CombinedOutput()
+		},
+	}, nil
+}
+
+// ExportRoute adds the static route
+func (f *FRR) ExportRoute(r Route) error {
+	return f.program(r, true)
+}
+
+// WithdrawRoute removes the static route
+func (f *FRR) WithdrawRoute(r Route) error {
+	return f.program(r, false)
+}
+
+func (f *FRR) staticRoute(r Route) string {
+	cmd := "ip route"
+	if r.Prefix.IP.To4() == nil {
+		cmd = "ipv6 route"
+	}
+	cmd = fmt.Sprintf("%s %s", cmd, r.Prefix)
+	if r.NextHop != nil {
+		cmd += " " + r.NextHop.String()
+	}
+	if r.Interface != "" {
+		cmd += " " + r.Interface
+	}
+	return fmt.Sprintf("%s tag %d", cmd, f.tag)
+}
+
+func (f *FRR) commands(r Route, add bool) []string {
+	route := f.staticRoute(r)
+	if !add {
+		route = "no " + route
+	}
+	args := []string{"-c", "configure terminal"}
+	if f.vrf != "" {
+		args = append(args, "-c", "vrf "+f.vrf)
+	}
+	return append(args, "-c", route)
+}
+
+func (f *FRR) program(r Route, add bool) error {
+	if err := r.Validate(); err != nil {
+		return err
+	}
+	f.Lock()
+	defer f.Unlock()
+
+	out, err := f.run(f.vtysh, f.commands(r, add)...)
+	// vtysh exits successfully on the rejected commands, which it reports
+	if err == nil && strings.Contains(string(out), "%") && !alreadyDone(string(out)) {
+		err = fmt.Errorf("%s", strings.TrimSpace(string(out)))
+	}
+	if err != nil {
+		return fmt.Errorf("FRR failed to program the route %s: %v", r, err)
+	}
+	logrus.Debugf("FRR programmed the route %s (add: %t)", r, add)
+	return nil
+}
+
+// alreadyDone tells if the error reports that there was nothing to do
+func alreadyDone(out string) bool {
+	return strings.Contains(out, "Can't find") || strings.Contains(out, "already")
+}
@@ -0,0 +1,57 @@
+package routeexport
+
+import (
+	"fmt"
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestFRRRoutes(t *testing.T) {
+	var calls [][]string
+	output := ""
+	f := &FRR{
+		vtysh: "vtysh",
+		vrf:   "blue",
+		tag:   DefaultFRRTag,
+		run: func(name string, args ...string) ([]byte, error) {
+			calls = append(calls, append([]string{name}, args...))
+			return []byte(output), nil
+		},
+	}
+
+	r := HostRoute("n1", net.ParseIP("172.16.0.5"), nil, "eth0")
+	if err := f.ExportRoute(r); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.WithdrawRoute(HostRoute("n1", net.ParseIP("fd00::5"), net.ParseIP("fd00::1"), "")); err != nil {
+		t.Fatal(err)
+	}
+	expected := [][]string{
+		{"vtysh", "-c", "configure terminal", "-c", "vrf blue", "-c", "ip route 172.16.0.5/32 eth0 tag 4739"},
+		{"vtysh", "-c", "configure terminal", "-c", "vrf blue", "-c", "no ipv6 route fd00::5/128 fd00::1 tag 4739"},
+	}
+	if !reflect.DeepEqual(calls, expected) {
+		t.Fatalf("unexpected commands:\n%v\nexpected:\n%v", calls, expected)
+	}
+
+	// The rejected commands are reported on the output only
+	output = "% Unknown command"
+	if err := f.ExportRoute(r); err == nil {
+		t.Fatal("expected an error for a rejected command")
+	}
+	output = "% Can't find static route specified"
+	if err := f.WithdrawRoute(r); err != nil {
+		t.Fatalf("unexpected error withdrawing a withdrawn route: %v", err)
+	}
+
+	f.run = func(name string, args ...string) ([]byte, error) {
+		return nil, fmt.Errorf("exit status 1")
+	}
+	if err := f.ExportRoute(r); err == nil {
+		t.Fatal("expected an error when vtysh fails")
+	}
+	if err := f.ExportRoute(Route{NetworkID: "n1"}); err == nil {
+		t.Fatal("expected an error for a route without prefix")
+	}
+}
@@ -0,0 +1,59 @@
+// Package routeexport hands the routes of the endpoints to the routing
+// daemon of the host, for them to be redistributed into the IGP the
+// datacenter runs, be it OSPF, IS-IS or BGP.
+package routeexport
+
+import (
+	"fmt"
+	"net"
+)
+
+// Route is the route to an endpoint, through the next hop or out of the
+// interface when the next hop is not set
+type Route struct {
+	NetworkID string
+	Prefix    *net.IPNet
+	NextHop   net.IP
+	Interface string
+}
+
+func (r Route) String() string {
+	if r.NextHop != nil {
+		return fmt.Sprintf("%s via %s", r.Prefix, r.NextHop)
+	}
+	return fmt.Sprintf("%s dev %s", r.Prefix, r.Interface)
+}
+
+// Validate checks that the route has a destination and a way to it
+func (r Route) Validate() error {
+	if r.Prefix == nil {
+		return fmt.Errorf("route without prefix")
+	}
+	if r.NextHop == nil && r.Interface == "" {
+		return fmt.Errorf("route %s has neither a next hop nor an interface", r.Prefix)
+	}
+	return nil
+}
+
+// HostRoute returns the route to the address of an endpoint
+func HostRoute(nid string, ip net.IP, nextHop net.IP, intf string) Route {
+	bits := 128
+	if ip.To4() != nil {
+		ip = ip.To4()
+		bits = 32
+	}
+	return Route{
+		NetworkID: nid,
+		Prefix:    &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)},
+		NextHop:   nextHop,
+		Interface: intf,
+	}
+}
+
+// Exporter is a routing daemon the routes are exported to. The calls are
+// idempotent, exporting an exported route or withdrawing a withdrawn one
+// is not an error.
+type Exporter interface {
+	ExportRoute(r Route) error
+	WithdrawRoute(r Route) error
+}
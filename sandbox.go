@@ -1,6 +1,7 @@
 package libnetwork
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net"
@@ -48,6 +49,19 @@ type Sandbox interface {
 	// DisableService removes a managed container's endpoints from the load balancer
 	// and service discovery
 	DisableService() error
+	// Checkpoint returns the network state of the sandbox, to be restored with
+	// RestoreCheckpoint once the container is restored in a new namespace
+	Checkpoint() (*SandboxCheckpoint, error)
+	// RestoreCheckpoint re-applies a checkpointed network state to the sandbox
+	RestoreCheckpoint(cp *SandboxCheckpoint) error
+	// GatewayEndpoint returns the endpoint providing the default gateway
+	GatewayEndpoint() Endpoint
+	// SetEndpointPriority changes the priority of a connected endpoint,
+	// moving the default gateway if another endpoint should now provide it
+	SetEndpointPriority(ep Endpoint, prio int) error
+	// Policy returns the effective routing and name resolution of the
+	// sandbox across the networks it is connected to
+	Policy() *SandboxPolicy
 }
 
 // SandboxOption is an option setter function type used to pass various options to
@@ -71,6 +85,7 @@ type sandbox struct {
 	osSbox             osl.Sandbox
 	controller         *controller
 	resolver           Resolver
+	pooledResolver     Resolver
 	resolverOnce       sync.Once
 	refCnt             int
 	endpoints          []*endpoint
@@ -85,6 +100,7 @@ type sandbox struct {
 	ndotsSet           bool
 	oslTypes           []osl.SandboxType // slice of properties of this sandbox
 	loadBalancerNID    string            // NID that this SB is a load balancer for
+	savedKeepalive     map[string]string // TCP keepalive sysctls before the endpoints set them
 	sync.Mutex
 	// This mutex is used to serialize service related operation for an endpoint
 	// The lock is here because the endpoint is saved into the store so is not unique
@@ -161,6 +177,18 @@ func (sb *sandbox) Labels() map[string]interface{} {
 	return opts
 }
 
+// joinOptions are the options of the drivers joining the sandbox: its
+// labels, and whether its namespace is still to be set with an external key
+func (sb *sandbox) joinOptions() map[string]interface{} {
+	opts := sb.Labels()
+	sb.Lock()
+	if sb.config.useExternalKey && sb.osSbox == nil {
+		opts[netlabel.NamespacePending] = true
+	}
+	sb.Unlock()
+	return opts
+}
+
 func (sb *sandbox) Statistics() (map[string]*types.InterfaceStatistics, error) {
 	m := make(map[string]*types.InterfaceStatistics)
 
@@ -244,6 +272,9 @@ func (sb *sandbox) delete(force bool) error {
 	if sb.resolver != nil {
 		sb.resolver.Stop()
 	}
+	if sb.pooledResolver != nil {
+		sb.pooledResolver.Stop()
+	}
 
 	if sb.osSbox != nil && !sb.config.useDefaultSandBox {
 		sb.osSbox.Destroy()
@@ -530,23 +561,7 @@ func (sb *sandbox) ResolveName(name string, ipType int) ([]net.IP, bool) {
 	// {a in network b.c.d},
 
 	logrus.Debugf("Name To resolve: %v", name)
-	name = strings.TrimSuffix(name, ".")
-	reqName := []string{name}
-	networkName := []string{""}
-
-	if strings.Contains(name, ".") {
-		var i int
-		dup := name
-		for {
-			if i = strings.LastIndex(dup, "."); i == -1 {
-				break
-			}
-			networkName = append(networkName, name[i+1:])
-			reqName = append(reqName, name[:i])
-
-			dup = dup[:i]
-		}
-	}
+	reqName, networkName := splitNetworkName(name)
 
 	epList := sb.getConnectedEndpoints()
 
@@ -587,6 +602,29 @@ func (sb *sandbox) ResolveName(name string, ipType int) ([]net.IP, bool) {
 	return nil, false
 }
 
+// splitNetworkName returns the names the query name may be of, along the
+// networks they are qualified with, the first one unqualified
+func splitNetworkName(name string) ([]string, []string) {
+	name = strings.TrimSuffix(name, ".")
+	reqName := []string{name}
+	networkName := []string{""}
+
+	if strings.Contains(name, ".") {
+		var i int
+		dup := name
+		for {
+			if i = strings.LastIndex(dup, "."); i == -1 {
+				break
+			}
+			networkName = append(networkName, name[i+1:])
+			reqName = append(reqName, name[:i])
+
+			dup = dup[:i]
+		}
+	}
+	return reqName, networkName
+}
+
 func (sb *sandbox) resolveName(req string, networkName string, epList []*endpoint, alias bool, ipType int) ([]net.IP, bool) {
 	var ipv6Miss bool
 
@@ -598,6 +636,11 @@ func (sb *sandbox) resolveName(req string, networkName string, epList []*endpoin
 			continue
 		}
 
+		// The names of a scoped network need to be qualified
+		if networkName == "" && n.scopedResolution() {
+			continue
+		}
+
 		if alias {
 			if ep.aliases == nil {
 				continue
@@ -683,7 +726,10 @@ func (sb *sandbox) SetKey(basePath string) error {
 	}
 
 	for _, ep := range sb.getConnectedEndpoints() {
-		if err = sb.populateNetworkResources(ep); err != nil {
+		if err = sb.populateNetworkResources(context.Background(), ep); err != nil {
+			return err
+		}
+		if err = ep.joinNamespace(sb); err != nil {
 			return err
 		}
 	}
@@ -734,6 +780,7 @@ func releaseOSSboxResources(osSbox osl.Sandbox, ep *endpoint) {
 	for _, i := range osSbox.Info().Interfaces() {
 		// Only remove the interfaces owned by this endpoint from the sandbox.
 		if ep.hasInterface(i.SrcName()) {
+			ep.clearMSSClamp(osSbox, i.DstName())
 			if err := i.Remove(); err != nil {
 				logrus.Debugf("Remove interface %s failed: %v", i.SrcName(), err)
 			}
@@ -742,12 +789,10 @@ func releaseOSSboxResources(osSbox osl.Sandbox, ep *endpoint) {
 
 	ep.Lock()
 	joinInfo := ep.joinInfo
-	vip := ep.virtualIP
-	lbModeIsDSR := ep.network.loadBalancerMode == loadBalancerModeDSR
+	dsrVIPs := ep.dsrLoopbackVIPs()
 	ep.Unlock()
 
-	if len(vip) > 0 && lbModeIsDSR {
-		ipNet := &net.IPNet{IP: vip, Mask: net.CIDRMask(32, 32)}
+	for _, ipNet := range dsrVIPs {
 		if err := osSbox.RemoveAliasIP(osSbox.GetLoopbackIfaceName(), ipNet); err != nil {
 			logrus.WithError(err).Debugf("failed to remove virtual ip %v to loopback", ipNet)
 		}
@@ -763,6 +808,19 @@ func releaseOSSboxResources(osSbox osl.Sandbox, ep *endpoint) {
 			logrus.Debugf("Remove route failed: %v", err)
 		}
 	}
+
+	for _, a := range joinInfo.LoopbackAddrs {
+		if err := osSbox.RemoveAliasIP(osSbox.GetLoopbackIfaceName(), a); err != nil {
+			logrus.WithError(err).Debugf("failed to remove address %v from loopback", a)
+		}
+	}
+
+	// The kernel entries went with the interface
+	for _, nh := range joinInfo.Neighbors {
+		if err := osSbox.DeleteNeighbor(nh.ip, nh.mac, false); err != nil {
+			logrus.Debugf("Remove neighbor %s failed: %v", nh.ip, err)
+		}
+	}
 }
 
 func (sb *sandbox) releaseOSSbox() {
@@ -828,7 +886,7 @@ func (sb *sandbox) restoreOslSandbox() error {
 	return err
 }
 
-func (sb *sandbox) populateNetworkResources(ep *endpoint) error {
+func (sb *sandbox) populateNetworkResources(ctx context.Context, ep *endpoint) error {
 	sb.Lock()
 	if sb.osSbox == nil {
 		sb.Unlock()
@@ -840,7 +898,9 @@ func (sb *sandbox) populateNetworkResources(ep *endpoint) error {
 	ep.Lock()
 	joinInfo := ep.joinInfo
 	i := ep.iface
-	lbModeIsDSR := ep.network.loadBalancerMode == loadBalancerModeDSR
+	dsrVIPs := ep.dsrLoopbackVIPs()
+	lbModeIsTun := ep.network.loadBalancerMode == loadBalancerModeDSRTun
+	ipv6Only := ep.network.ipv6Only
 	ep.Unlock()
 
 	if ep.needResolver() {
@@ -861,19 +921,35 @@ func (sb *sandbox) populateNetworkResources(ep *endpoint) error {
 			ifaceOptions = append(ifaceOptions, sb.osSbox.InterfaceOptions().MacAddress(i.mac))
 		}
 
-		if err := sb.osSbox.AddInterface(i.srcName, i.dstPrefix, ifaceOptions...); err != nil {
+		if err := sb.addInterface(ctx, i.srcName, i.dstPrefix, ifaceOptions...); err != nil {
 			return fmt.Errorf("failed to add interface %s to sandbox: %v", i.srcName, err)
 		}
 
-		if len(ep.virtualIP) > 0 && lbModeIsDSR {
+		if err := ep.setupMSSClamp(sb.osSbox, i.srcName); err != nil {
+			return fmt.Errorf("failed to clamp the TCP MSS on interface %s: %v", i.srcName, err)
+		}
+
+		if ipv6Only {
+			if err := sb.osSbox.DisableIPv4(i.srcName); err != nil {
+				return fmt.Errorf("failed to disable IPv4 on interface %s: %v", i.srcName, err)
+			}
+		}
+
+		if len(dsrVIPs) > 0 {
 			if sb.loadBalancerNID == "" {
 				if err := sb.osSbox.DisableARPForVIP(i.srcName); err != nil {
 					return fmt.Errorf("failed disable ARP for VIP: %v", err)
 				}
 			}
-			ipNet := &net.IPNet{IP: ep.virtualIP, Mask: net.CIDRMask(32, 32)}
-			if err := sb.osSbox.AddAliasIP(sb.osSbox.GetLoopbackIfaceName(), ipNet); err != nil {
-				return fmt.Errorf("failed to add virtual ip %v to loopback: %v", ipNet, err)
+			for _, ipNet := range dsrVIPs {
+				if err := sb.osSbox.AddAliasIP(sb.osSbox.GetLoopbackIfaceName(), ipNet); err != nil {
+					return fmt.Errorf("failed to add virtual ip %v to loopback: %v", ipNet, err)
+				}
+			}
+			if lbModeIsTun {
+				if err := setupDSRTunnel(sb.osSbox); err != nil {
+					return fmt.Errorf("failed to set up the DSR tunnel: %v", err)
+				}
 			}
 		}
 	}
@@ -885,6 +961,26 @@ func (sb *sandbox) populateNetworkResources(ep *endpoint) error {
 				return fmt.Errorf("failed to add static route %s: %v", r.Destination.String(), err)
 			}
 		}
+		for _, a := range joinInfo.LoopbackAddrs {
+			if err := sb.osSbox.AddAliasIP(sb.osSbox.GetLoopbackIfaceName(), a); err != nil {
+				return fmt.Errorf("failed to add address %v to loopback: %v", a, err)
+			}
+		}
+		if i != nil && i.srcName != "" {
+			for _, nh := range joinInfo.Neighbors {
+				if err := sb.osSbox.AddNeighbor(nh.ip, nh.mac, false, sb.osSbox.NeighborOptions().LinkName(i.srcName)); err != nil {
+					return fmt.Errorf("failed to add neighbor %s: %v", nh.ip, err)
+				}
+			}
+		}
+	}
+
+	if err := sb.setupTCPKeepalive(); err != nil {
+		return fmt.Errorf("failed to set the TCP keepalive of endpoint %s: %v", ep.Name(), err)
+	}
+
+	if err := sb.setupNetworkDNS(ep.getNetwork()); err != nil {
+		return fmt.Errorf("failed to set the DNS options of endpoint %s: %v", ep.Name(), err)
 	}
 
 	if ep == sb.getGatewayEndpoint() {
@@ -916,6 +1012,15 @@ func (sb *sandbox) populateNetworkResources(ep *endpoint) error {
 	return nil
 }
 
+// addInterface bounds the configuration of the interface by the context when
+// it can be cancelled
+func (sb *sandbox) addInterface(ctx context.Context, srcName, dstPrefix string, options ...osl.IfaceOption) error {
+	if ctx.Done() == nil {
+		return sb.osSbox.AddInterface(srcName, dstPrefix, options...)
+	}
+	return sb.osSbox.AddInterfacesContext(ctx, osl.InterfaceRequest{SrcName: srcName, DstPrefix: dstPrefix, Options: options})
+}
+
 func (sb *sandbox) clearNetworkResources(origEp *endpoint) error {
 	ep := sb.getEndpoint(origEp.id)
 	if ep == nil {
@@ -978,6 +1083,15 @@ func (sb *sandbox) clearNetworkResources(origEp *endpoint) error {
 		sb.updateGateway(gwepAfter)
 	}
 
+	if osSbox != nil && !inDelete {
+		if err := sb.setupTCPKeepalive(); err != nil {
+			logrus.Warnf("Failed to reset the TCP keepalive of sandbox %s after endpoint %s left: %v", sb.ID(), ep.Name(), err)
+		}
+		if err := sb.setupNetworkDNS(ep.getNetwork()); err != nil {
+			logrus.Warnf("Failed to reset the DNS options of sandbox %s after endpoint %s left: %v", sb.ID(), ep.Name(), err)
+		}
+	}
+
 	// Only update the store if we did not come here as part of
 	// sandbox delete. If we came here as part of delete then do
 	// not bother updating the store. The sandbox object will be
@@ -999,6 +1113,12 @@ func (sb *sandbox) isEndpointPopulated(ep *endpoint) bool {
 // joinLeaveStart waits to ensure there are no joins or leaves in progress and
 // marks this join/leave in progress without race
 func (sb *sandbox) joinLeaveStart() {
+	sb.joinLeaveStartContext(context.Background())
+}
+
+// joinLeaveStartContext behaves as joinLeaveStart, giving up the wait when
+// the context is done
+func (sb *sandbox) joinLeaveStartContext(ctx context.Context) error {
 	sb.Lock()
 	defer sb.Unlock()
 
@@ -1006,12 +1126,18 @@ func (sb *sandbox) joinLeaveStart() {
 		joinLeaveDone := sb.joinLeaveDone
 		sb.Unlock()
 
-		<-joinLeaveDone
+		select {
+		case <-joinLeaveDone:
+		case <-ctx.Done():
+			sb.Lock()
+			return ctx.Err()
+		}
 
 		sb.Lock()
 	}
 
 	sb.joinLeaveDone = make(chan struct{})
+	return nil
 }
 
 // joinLeaveEnd marks the end of this join/leave operation and
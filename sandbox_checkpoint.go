@@ -0,0 +1,201 @@
+package libnetwork
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+
+	"github.com/docker/libnetwork/osl"
+	"github.com/docker/libnetwork/types"
+	"github.com/sirupsen/logrus"
+)
+
+// SandboxCheckpoint is the serializable network state of a sandbox, taken
+// when its container is checkpointed so that it can be re-applied into the
+// namespace of the restored container
+type SandboxCheckpoint struct {
+	ContainerID    string          `json:"container_id"`
+	Network        *osl.Checkpoint `json:"network,omitempty"`
+	ResolvConf     []byte          `json:"resolv_conf,omitempty"`
+	ResolvConfHash []byte          `json:"resolv_conf_hash,omitempty"`
+	Hosts          []byte          `json:"hosts,omitempty"`
+	ExtDNS         []extDNSEntry   `json:"ext_dns,omitempty"`
+}
+
+func (sb *sandbox) Checkpoint() (*SandboxCheckpoint, error) {
+	sb.Lock()
+	osSbox := sb.osSbox
+	cp := &SandboxCheckpoint{
+		ContainerID: sb.containerID,
+		ExtDNS:      append([]extDNSEntry(nil), sb.extDNS...),
+	}
+	files := map[string]*[]byte{
+		sb.config.resolvConfPath:     &cp.ResolvConf,
+		sb.config.resolvConfHashFile: &cp.ResolvConfHash,
+		sb.config.hostsPath:          &cp.Hosts,
+	}
+	sb.Unlock()
+
+	if osSbox != nil {
+		cp.Network = osSbox.Checkpoint()
+	}
+
+	for path, content := range files {
+		if path == "" {
+			continue
+		}
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, types.InternalErrorf("failed to read %s of sandbox %.7s: %v", path, sb.ID(), err)
+		}
+		*content = b
+	}
+
+	return cp, nil
+}
+
+func (sb *sandbox) RestoreCheckpoint(cp *SandboxCheckpoint) error {
+	if cp == nil {
+		return types.BadRequestErrorf("no checkpoint to restore")
+	}
+	if cp.ContainerID != sb.ContainerID() {
+		return types.BadRequestErrorf("checkpoint of container %s cannot be restored in the sandbox of container %s", cp.ContainerID, sb.ContainerID())
+	}
+
+	sb.Lock()
+	osSbox := sb.osSbox
+	files := map[string][]byte{
+		sb.config.resolvConfPath:     cp.ResolvConf,
+		sb.config.resolvConfHashFile: cp.ResolvConfHash,
+		sb.config.hostsPath:          cp.Hosts,
+	}
+	sb.Unlock()
+
+	if cp.Network != nil {
+		if osSbox == nil {
+			return types.ForbiddenErrorf("sandbox %.7s has no namespace to restore the checkpoint into", sb.ID())
+		}
+		ncp, err := sb.rejoinEndpoints(osSbox, cp.Network)
+		if err != nil {
+			return err
+		}
+		if err := osSbox.ApplyCheckpoint(ncp); err != nil {
+			return fmt.Errorf("failed to restore the network configuration of sandbox %.7s: %v", sb.ID(), err)
+		}
+	}
+
+	for path, content := range files {
+		if path == "" || content == nil {
+			continue
+		}
+		dir, _ := filepath.Split(path)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return types.InternalErrorf("failed to create %s: %v", dir, err)
+		}
+		if err := ioutil.WriteFile(path, content, 0644); err != nil {
+			return types.InternalErrorf("failed to restore %s of sandbox %.7s: %v", path, sb.ID(), err)
+		}
+	}
+
+	if len(cp.ExtDNS) > 0 {
+		sb.Lock()
+		sb.extDNS = append([]extDNSEntry(nil), cp.ExtDNS...)
+		resolver := sb.resolver
+		sb.Unlock()
+		if resolver != nil {
+			resolver.SetExtServers(cp.ExtDNS)
+		}
+	}
+
+	logrus.Debugf("Restored checkpoint of sandbox %.7s", sb.ID())
+	return nil
+}
+
+// rejoinEndpoints runs the driver join again for the endpoints whose
+// checkpointed interface is not in the namespace of the sandbox, as the
+// interfaces the drivers created for the checkpointed container are gone
+// once it is restored or the host rebooted. The returned checkpoint names
+// the interfaces the drivers created anew.
+func (sb *sandbox) rejoinEndpoints(osSbox osl.Sandbox, cp *osl.Checkpoint) (*osl.Checkpoint, error) {
+	present := map[string]bool{}
+	for _, i := range osSbox.Info().Interfaces() {
+		present[i.SrcName()] = true
+	}
+
+	ncp := *cp
+	ncp.Interfaces = make([]*osl.InterfaceCheckpoint, 0, len(cp.Interfaces))
+	for _, ic := range cp.Interfaces {
+		// The bridges are created in the namespace, not by the drivers
+		ep := sb.endpointOfInterface(ic.SrcName)
+		if ic.Bridge || present[ic.SrcName] || ep == nil {
+			ncp.Interfaces = append(ncp.Interfaces, ic)
+			continue
+		}
+		srcName, err := ep.rejoinDriver(sb)
+		if err != nil {
+			return nil, fmt.Errorf("failed to join endpoint %.7s again to restore the checkpoint of sandbox %.7s: %v", ep.ID(), sb.ID(), err)
+		}
+		nic := *ic
+		nic.SrcName = srcName
+		ncp.Interfaces = append(ncp.Interfaces, &nic)
+	}
+	return &ncp, nil
+}
+
+// endpointOfInterface returns the endpoint of the sandbox the driver
+// created the interface for, if any
+func (sb *sandbox) endpointOfInterface(srcName string) *endpoint {
+	for _, ep := range sb.getConnectedEndpoints() {
+		ep.Lock()
+		found := ep.iface != nil && ep.iface.srcName == srcName
+		ep.Unlock()
+		if found {
+			return ep
+		}
+	}
+	return nil
+}
+
+// rejoinDriver runs the driver join of the endpoint again and returns the
+// name of the interface the driver created. The endpoint keeps the join
+// information and the routes of its first join, which the checkpoint
+// restores.
+func (ep *endpoint) rejoinDriver(sb *sandbox) (string, error) {
+	n, err := ep.getNetworkFromStore()
+	if err != nil {
+		return "", err
+	}
+	d, err := n.driver(true)
+	if err != nil {
+		return "", err
+	}
+
+	ep.Lock()
+	joinInfo := ep.joinInfo
+	routes := ep.iface.routes
+	ep.joinInfo = &endpointJoinInfo{}
+	ep.iface.routes = []*net.IPNet{}
+	ep.Unlock()
+
+	err = driverJoin(context.Background(), d, n.ID(), ep.ID(), sb.Key(), ep, sb.joinOptions())
+
+	ep.Lock()
+	ep.joinInfo = joinInfo
+	ep.iface.routes = routes
+	srcName := ep.iface.srcName
+	ep.Unlock()
+	if err != nil {
+		return "", err
+	}
+
+	if err := n.getController().updateToStore(ep); err != nil {
+		logrus.Warnf("Failed to store endpoint %.7s after joining it again: %v", ep.ID(), err)
+	}
+	return srcName, nil
+}
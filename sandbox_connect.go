@@ -0,0 +1,120 @@
+package libnetwork
+
+import (
+	"context"
+
+	"github.com/docker/libnetwork/authz"
+	"github.com/docker/libnetwork/types"
+	"github.com/sirupsen/logrus"
+)
+
+// SandboxAttachment is a network a sandbox is connected to by
+// ConnectSandbox, through a new endpoint of the name, created with the
+// options and joined with the join options
+type SandboxAttachment struct {
+	NetworkID   string
+	Name        string
+	Options     []EndpointOption
+	JoinOptions []EndpointOption
+}
+
+// ConnectSandbox connects the sandbox to the networks of the attachments
+// as one transaction: the endpoints are all created, allocating their
+// addresses, then all joined, the drivers programming them and their names
+// getting registered, before the traffic of any is opened. On a failure
+// the endpoints joined are left and the ones created deleted, in the
+// reverse order, and the error of the failed step is returned. The
+// joins and leaves of the sandbox wait for the transaction.
+func (c *controller) ConnectSandbox(sandboxID string, attachments []SandboxAttachment) ([]Endpoint, error) {
+	sbox, err := c.SandboxByID(sandboxID)
+	if err != nil {
+		return nil, err
+	}
+	sb := sbox.(*sandbox)
+
+	nws, err := c.attachmentNetworks(sb, attachments)
+	if err != nil {
+		return nil, err
+	}
+
+	var created []*endpoint
+	rollback := func(joined []*endpoint) {
+		for i := len(joined) - 1; i >= 0; i-- {
+			if err := joined[i].sbLeave(nil, sb, false); err != nil {
+				logrus.Warnf("Failed to leave endpoint %s of sandbox %.7s on rollback: %v", joined[i].Name(), sb.ID(), err)
+			}
+		}
+		for i := len(created) - 1; i >= 0; i-- {
+			if err := created[i].Delete(true); err != nil {
+				logrus.Warnf("Failed to delete endpoint %s of sandbox %.7s on rollback: %v", created[i].Name(), sb.ID(), err)
+			}
+		}
+	}
+
+	for i, a := range attachments {
+		e, err := nws[i].CreateEndpoint(a.Name, a.Options...)
+		if err != nil {
+			logrus.Warnf("Failed to create endpoint %s on network %s of sandbox %.7s, rolling back: %v", a.Name, nws[i].Name(), sb.ID(), err)
+			rollback(nil)
+			return nil, err
+		}
+		created = append(created, e.(*endpoint))
+	}
+
+	sb.joinLeaveStart()
+	for i, ep := range created {
+		err := ep.authorize(authz.EndpointJoin, sb)
+		if err == nil {
+			err = ep.sbJoin(context.Background(), sb, attachments[i].JoinOptions...)
+		}
+		if err != nil {
+			logrus.Warnf("Failed to join endpoint %s on network %s to sandbox %.7s, rolling back: %v", ep.Name(), nws[i].Name(), sb.ID(), err)
+			rollback(created[:i])
+			sb.joinLeaveEnd()
+			return nil, err
+		}
+	}
+	sb.joinLeaveEnd()
+
+	eps := make([]Endpoint, 0, len(created))
+	for _, ep := range created {
+		ep.openEndpoint()
+		eps = append(eps, ep)
+	}
+	return eps, nil
+}
+
+// attachmentNetworks returns the networks of the attachments, checking
+// them all before the transaction starts: the attachments need a network
+// and an endpoint name, the networks are distinct and the sandbox is not
+// connected to them yet
+func (c *controller) attachmentNetworks(sb *sandbox, attachments []SandboxAttachment) ([]*network, error) {
+	if len(attachments) == 0 {
+		return nil, types.BadRequestErrorf("no network to connect sandbox %.7s to", sb.ID())
+	}
+	connected := map[string]bool{}
+	for _, ep := range sb.getConnectedEndpoints() {
+		connected[ep.getNetwork().ID()] = true
+	}
+
+	nws := make([]*network, 0, len(attachments))
+	seen := map[string]bool{}
+	for _, a := range attachments {
+		if a.NetworkID == "" || a.Name == "" {
+			return nil, types.BadRequestErrorf("invalid attachment of sandbox %.7s: expected a network and an endpoint name", sb.ID())
+		}
+		n, err := c.NetworkByID(a.NetworkID)
+		if err != nil {
+			return nil, err
+		}
+		if seen[n.ID()] {
+			return nil, types.BadRequestErrorf("network %s is attached to sandbox %.7s more than once", n.Name(), sb.ID())
+		}
+		if connected[n.ID()] {
+			return nil, types.ForbiddenErrorf("sandbox %.7s is already connected to network %s", sb.ID(), n.Name())
+		}
+		seen[n.ID()] = true
+		nws = append(nws, n.(*network))
+	}
+	return nws, nil
+}
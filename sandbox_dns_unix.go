@@ -27,7 +27,13 @@ const (
 func (sb *sandbox) startResolver(restore bool) {
 	sb.resolverOnce.Do(func() {
 		var err error
-		sb.resolver = NewResolver(resolverIPSandbox, true, sb.Key(), sb)
+		// A pooled sandbox comes with its resolver set up
+		pooled := sb.pooledResolver != nil
+		if pooled {
+			sb.resolver, sb.pooledResolver = sb.pooledResolver, nil
+		} else {
+			sb.resolver = NewResolver(resolverIPSandbox, true, sb.Key(), sb)
+		}
 		defer func() {
 			if err != nil {
 				sb.resolver = nil
@@ -47,9 +53,11 @@ func (sb *sandbox) startResolver(restore bool) {
 		}
 		sb.resolver.SetExtServers(sb.extDNS)
 
-		if err = sb.osSbox.InvokeFunc(sb.resolver.SetupFunc(0)); err != nil {
-			logrus.Errorf("Resolver Setup function failed for container %s, %q", sb.ContainerID(), err)
-			return
+		if !pooled {
+			if err = sb.osSbox.InvokeFunc(sb.resolver.SetupFunc(0)); err != nil {
+				logrus.Errorf("Resolver Setup function failed for container %s, %q", sb.ContainerID(), err)
+				return
+			}
 		}
 
 		if err = sb.resolver.Start(); err != nil {
@@ -404,6 +412,79 @@ dnsOpt:
 	return err
 }
 
+// originDNS returns the search domains and the options of the resolv.conf
+// the one of the sandbox derives from
+func (sb *sandbox) originDNS() ([]string, []string) {
+	originResolvConfPath := sb.config.originResolvConfPath
+	if originResolvConfPath == "" {
+		originResolvConfPath = resolvconf.Path()
+	}
+	rc, err := resolvconf.GetSpecific(originResolvConfPath)
+	if err != nil {
+		return nil, nil
+	}
+	return resolvconf.GetSearchDomains(rc.Content), resolvconf.GetOptions(rc.Content)
+}
+
+// setupNetworkDNS merges the search domains and the resolver options of
+// the networks of the sandbox into its resolv.conf, after n was joined or
+// left. The ones of the sandbox configuration take precedence over the
+// ones of the networks, which take precedence over the ones of the host.
+// The name servers are left alone.
+func (sb *sandbox) setupNetworkDNS(n *network) error {
+	if sb.config.useDefaultSandBox {
+		return nil
+	}
+	search, options, err := sb.networkDNS()
+	if err != nil {
+		return err
+	}
+	// Nothing to merge in, nor to take back out
+	if len(search) == 0 && len(options) == 0 && (n == nil || !n.hasDNSOptions()) {
+		return nil
+	}
+
+	currRC, err := resolvconf.GetSpecific(sb.config.resolvConfPath)
+	if err != nil {
+		return err
+	}
+	hostSearch, hostOptions := sb.originDNS()
+	if len(sb.config.dnsSearchList) > 0 {
+		search = sb.config.dnsSearchList
+	} else if len(search) == 0 {
+		search = hostSearch
+	}
+	if len(sb.config.dnsOptionsList) > 0 {
+		options = mergeDNSOptions(sb.config.dnsOptionsList, options)
+	} else {
+		options = mergeDNSOptions(options, hostOptions)
+	}
+	if sb.resolver != nil {
+		// As in rebuildDNS, the embedded server ndots only applies when
+		// no one else sets it
+		sb.ndotsSet = false
+		for _, o := range options {
+			if dnsOptionName(o) == "ndots" {
+				sb.ndotsSet = true
+			}
+		}
+		if !sb.ndotsSet {
+			options = mergeDNSOptions(options, sb.resolver.ResolverOptions())
+		}
+	}
+
+	newRC, err := resolvconf.Build(sb.config.resolvConfPath, resolvconf.GetNameservers(currRC.Content, types.IP), search, options)
+	if err != nil {
+		return err
+	}
+	// The embedded server address must not be filtered out by updateDNS,
+	// hence the hash is only kept up to date without it
+	if sb.resolver != nil {
+		return nil
+	}
+	return ioutil.WriteFile(sb.config.resolvConfHashFile, []byte(newRC.Hash), filePerm)
+}
+
 func createBasePath(dir string) error {
 	return os.MkdirAll(dir, dirPerm)
 }
@@ -0,0 +1,123 @@
+package libnetwork
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/docker/libnetwork/diagnostic"
+	"github.com/docker/libnetwork/internal/caller"
+	"github.com/sirupsen/logrus"
+)
+
+// sandboxPolicyPaths2Func are the diagnostic handlers of the sandbox policies
+var sandboxPolicyPaths2Func = map[string]diagnostic.HTTPHandlerFunc{
+	"/sandboxpolicy": sandboxPolicyDiag,
+}
+
+// SandboxPolicy describes the effective routing and name resolution of a
+// sandbox connected to several networks
+type SandboxPolicy struct {
+	SandboxID       string             `json:"sandbox_id"`
+	GatewayEndpoint string             `json:"gateway_endpoint,omitempty"`
+	ExtDNS          []string           `json:"ext_dns,omitempty"`
+	Connections     []ConnectionPolicy `json:"connections"`
+}
+
+// ConnectionPolicy describes the routing and name resolution of one of the
+// endpoints of a sandbox. The connections are listed in priority order.
+type ConnectionPolicy struct {
+	NetworkID        string   `json:"network_id"`
+	NetworkName      string   `json:"network_name"`
+	EndpointID       string   `json:"endpoint_id"`
+	EndpointName     string   `json:"endpoint_name"`
+	Priority         int      `json:"priority"`
+	ScopedResolution bool     `json:"scoped_resolution,omitempty"`
+	RouteMetric      int      `json:"route_metric,omitempty"`
+	Gateway          string   `json:"gateway,omitempty"`
+	GatewayIPv6      string   `json:"gateway_ipv6,omitempty"`
+	Routes           []string `json:"routes,omitempty"`
+}
+
+func (p *SandboxPolicy) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "sandbox:%s gateway_endpoint:%s ext_dns:%s\n", p.SandboxID, p.GatewayEndpoint, strings.Join(p.ExtDNS, ","))
+	for _, c := range p.Connections {
+		fmt.Fprintf(&b, "network:%s (%s) endpoint:%s (%s) priority:%d scoped_resolution:%t route_metric:%d gateway:%s gateway_ipv6:%s routes:[%s]\n",
+			c.NetworkName, c.NetworkID, c.EndpointName, c.EndpointID, c.Priority, c.ScopedResolution, c.RouteMetric,
+			c.Gateway, c.GatewayIPv6, strings.Join(c.Routes, ", "))
+	}
+	return b.String()
+}
+
+// Policy returns the effective routing and name resolution of the sandbox
+func (sb *sandbox) Policy() *SandboxPolicy {
+	p := &SandboxPolicy{SandboxID: sb.ID()}
+	if ep := sb.getGatewayEndpoint(); ep != nil {
+		p.GatewayEndpoint = ep.ID()
+	}
+
+	sb.Lock()
+	for _, e := range sb.extDNS {
+		p.ExtDNS = append(p.ExtDNS, e.IPStr)
+	}
+	sb.Unlock()
+
+	for _, ep := range sb.getConnectedEndpoints() {
+		n := ep.getNetwork()
+		sb.Lock()
+		prio := sb.epPriority[ep.ID()]
+		sb.Unlock()
+		c := ConnectionPolicy{
+			NetworkID:        n.ID(),
+			NetworkName:      n.Name(),
+			EndpointID:       ep.ID(),
+			EndpointName:     ep.Name(),
+			Priority:         prio,
+			ScopedResolution: n.scopedResolution(),
+			RouteMetric:      n.routeMetricValue(),
+		}
+		if gw := ep.Gateway(); len(gw) > 0 {
+			c.Gateway = gw.String()
+		}
+		if gw := ep.GatewayIPv6(); len(gw) > 0 {
+			c.GatewayIPv6 = gw.String()
+		}
+		for _, r := range ep.StaticRoutes() {
+			c.Routes = append(c.Routes, fmt.Sprintf("%s via %s metric %d", r.Destination, r.NextHop, r.Metric))
+		}
+		ep.Lock()
+		if ep.iface != nil {
+			for _, r := range ep.iface.routes {
+				c.Routes = append(c.Routes, fmt.Sprintf("%s connected", r))
+			}
+		}
+		ep.Unlock()
+		p.Connections = append(p.Connections, c)
+	}
+	return p
+}
+
+func sandboxPolicyDiag(ctx interface{}, w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	diagnostic.DebugHTTPForm(r)
+	_, json := diagnostic.ParseHTTPFormOptions(r)
+
+	// audit logs
+	log := logrus.WithFields(logrus.Fields{"component": "diagnostic", "remoteIP": r.RemoteAddr, "method": caller.Name(0), "url": r.URL.String()})
+	log.Info("sandbox policy")
+
+	c, ok := ctx.(*controller)
+	if !ok {
+		diagnostic.HTTPReply(w, diagnostic.FailCommand(fmt.Errorf("controller not available")), json)
+		return
+	}
+	sb, err := c.SandboxByID(r.Form.Get("sid"))
+	if err != nil {
+		log.WithError(err).Error("sandbox policy failed")
+		diagnostic.HTTPReply(w, diagnostic.FailCommand(err), json)
+		return
+	}
+	log.Info("sandbox policy done")
+	diagnostic.HTTPReply(w, diagnostic.CommandSucceed(sb.Policy()), json)
+}
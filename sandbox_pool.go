@@ -0,0 +1,224 @@
+package libnetwork
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+
+	"github.com/docker/docker/pkg/stringid"
+	"github.com/docker/libnetwork/osl"
+	"github.com/docker/libnetwork/types"
+	"github.com/sirupsen/logrus"
+)
+
+// sandboxPoolPrefix prefixes the IDs of the pooled sandboxes, which shows in
+// their namespace paths and lets the ones left over by an unclean shutdown
+// be told apart
+const sandboxPoolPrefix = "pool"
+
+// pooledSandbox is a network namespace created ahead of its container, with
+// the loopback up and the embedded resolver sockets and rules in place
+type pooledSandbox struct {
+	id       string
+	osSbox   osl.Sandbox
+	resolver Resolver
+}
+
+// sandboxPool holds the pooled sandboxes and the number of them to keep
+type sandboxPool struct {
+	size    int
+	entries []*pooledSandbox
+	filling bool
+	sync.Mutex
+}
+
+func sandboxPoolSupported() bool {
+	return runtime.GOOS == "linux"
+}
+
+// PoolSandboxes keeps count sandboxes pre-created for the next containers to
+// claim, cutting the namespace creation and the resolver setup off their
+// start. Zero drains the pool.
+func (c *controller) PoolSandboxes(count int) error {
+	if count < 0 {
+		return types.BadRequestErrorf("invalid sandbox pool size %d", count)
+	}
+	if !sandboxPoolSupported() {
+		return types.NotImplementedErrorf("sandbox pooling is not supported on %s", runtime.GOOS)
+	}
+
+	p := &c.sbPool
+	p.Lock()
+	p.size = count
+	var drained []*pooledSandbox
+	if len(p.entries) > count {
+		drained = p.entries[count:]
+		p.entries = p.entries[:count]
+	}
+	p.Unlock()
+
+	for _, ps := range drained {
+		ps.destroy()
+	}
+	return c.fillSandboxPool()
+}
+
+// fillSandboxPool creates the missing pooled sandboxes, a single filling
+// runs at a time
+func (c *controller) fillSandboxPool() error {
+	p := &c.sbPool
+	p.Lock()
+	if p.filling {
+		p.Unlock()
+		return nil
+	}
+	p.filling = true
+	p.Unlock()
+
+	defer func() {
+		p.Lock()
+		p.filling = false
+		p.Unlock()
+	}()
+
+	for {
+		p.Lock()
+		missing := p.size - len(p.entries)
+		p.Unlock()
+		if missing <= 0 {
+			return nil
+		}
+
+		ps, err := newPooledSandbox()
+		if err != nil {
+			return err
+		}
+
+		p.Lock()
+		if len(p.entries) >= p.size {
+			p.Unlock()
+			ps.destroy()
+			return nil
+		}
+		p.entries = append(p.entries, ps)
+		p.Unlock()
+	}
+}
+
+func newPooledSandbox() (*pooledSandbox, error) {
+	ps := &pooledSandbox{id: sandboxPoolPrefix + stringid.GenerateRandomID()}
+	key := osl.GenerateKey(ps.id)
+
+	var err error
+	if ps.osSbox, err = osl.NewSandbox(key, true, false); err != nil {
+		return nil, types.InternalErrorf("failed to create pooled sandbox: %v", err)
+	}
+
+	r := NewResolver(resolverIPSandbox, true, key, nil).(*resolver)
+	if err := ps.osSbox.InvokeFunc(r.SetupFunc(0)); err != nil || r.err != nil {
+		logrus.Warnf("Failed to set up the resolver of pooled sandbox %.11s: %v %v", ps.id, err, r.err)
+		return ps, nil
+	}
+	if err := r.setupIPTable(); err != nil {
+		logrus.Warnf("Failed to set up the resolver rules of pooled sandbox %.11s: %v", ps.id, err)
+		r.Stop()
+		return ps, nil
+	}
+	r.tablesReady = true
+	ps.resolver = r
+	return ps, nil
+}
+
+func (ps *pooledSandbox) destroy() {
+	if ps.resolver != nil {
+		ps.resolver.Stop()
+	}
+	if err := ps.osSbox.Destroy(); err != nil {
+		logrus.Warnf("Failed to destroy pooled sandbox %.11s: %v", ps.id, err)
+	}
+}
+
+// claimPooledSandbox hands a pooled namespace over to the sandbox, which
+// takes its ID as the namespace path derives from it, and has the pool
+// refilled in the background. It returns false when the sandbox can't use a
+// pooled namespace or the pool is empty.
+func (c *controller) claimPooledSandbox(sb *sandbox) bool {
+	if sb.config.useDefaultSandBox || sb.config.useExternalKey || sb.ingress || sb.loadBalancerNID != "" || sb.osSbox != nil {
+		return false
+	}
+
+	p := &c.sbPool
+	p.Lock()
+	if len(p.entries) == 0 {
+		p.Unlock()
+		return false
+	}
+	ps := p.entries[0]
+	p.entries = p.entries[1:]
+	p.Unlock()
+
+	go func() {
+		if err := c.fillSandboxPool(); err != nil {
+			logrus.Warnf("Failed to refill the sandbox pool: %v", err)
+		}
+	}()
+
+	sb.id = ps.id
+	sb.osSbox = ps.osSbox
+	if ps.resolver != nil {
+		ps.resolver.(*resolver).backend = sb
+		sb.pooledResolver = ps.resolver
+	}
+	logrus.Debugf("Container %.7s claimed pooled sandbox %.11s", sb.containerID, sb.id)
+	return true
+}
+
+// drainSandboxPool destroys the pooled sandboxes on the controller stop
+func (c *controller) drainSandboxPool() {
+	p := &c.sbPool
+	p.Lock()
+	drained := p.entries
+	p.entries = nil
+	p.size = 0
+	p.Unlock()
+
+	for _, ps := range drained {
+		ps.destroy()
+	}
+}
+
+// cleanupSandboxPool removes the pooled namespaces an unclean shutdown left
+// behind, the claimed ones belong to restored sandboxes and are kept
+func (c *controller) cleanupSandboxPool() {
+	if !sandboxPoolSupported() {
+		return
+	}
+
+	inUse := make(map[string]bool)
+	c.Lock()
+	for _, sb := range c.sandboxes {
+		inUse[sb.Key()] = true
+	}
+	c.Unlock()
+
+	dir := filepath.Dir(osl.GenerateKey(sandboxPoolPrefix))
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	for _, f := range files {
+		key := filepath.Join(dir, f.Name())
+		if !strings.HasPrefix(f.Name(), sandboxPoolPrefix) || inUse[key] {
+			continue
+		}
+		osSbox, err := osl.NewSandbox(key, true, true)
+		if err != nil {
+			logrus.Debugf("Failed to open stale pooled sandbox %s: %v", key, err)
+			continue
+		}
+		logrus.Debugf("Removing stale pooled sandbox %s", key)
+		osSbox.Destroy()
+	}
+}
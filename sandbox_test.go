@@ -2,6 +2,7 @@ package libnetwork
 
 import (
 	"fmt"
+	"strings"
 	"testing"
 
 	"github.com/docker/libnetwork/config"
@@ -76,6 +77,79 @@ func TestSandboxAddEmpty(t *testing.T) {
 	osl.GC()
 }
 
+func TestSandboxQuota(t *testing.T) {
+	c, _ := getTestEnv(t)
+	ctrlr := c.(*controller)
+	ctrlr.cfg.Daemon.MaxSandboxes = 1
+
+	sbx, err := ctrlr.NewSandbox("sandbox0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = ctrlr.NewSandbox("sandbox1")
+	if _, ok := err.(*QuotaExceededError); !ok {
+		t.Fatalf("Expected a QuotaExceededError, got: %v", err)
+	}
+
+	if err := sbx.Delete(); err != nil {
+		t.Fatal(err)
+	}
+
+	sbx, err = ctrlr.NewSandbox("sandbox1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sbx.Delete(); err != nil {
+		t.Fatal(err)
+	}
+
+	osl.GC()
+}
+
+func TestSandboxPool(t *testing.T) {
+	if !testutils.IsRunningInContainer() {
+		defer testutils.SetupTestOSContext(t)()
+	}
+	c, _ := getTestEnv(t)
+	ctrlr := c.(*controller)
+	defer ctrlr.Stop()
+
+	if err := ctrlr.PoolSandboxes(-1); err == nil {
+		t.Fatal("expected an error for a negative pool size")
+	}
+	if err := ctrlr.PoolSandboxes(1); err != nil {
+		t.Fatal(err)
+	}
+
+	sbx, err := ctrlr.NewSandbox("sandbox0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sb := sbx.(*sandbox)
+	if !strings.HasPrefix(sb.ID(), sandboxPoolPrefix) || sb.pooledResolver == nil {
+		t.Fatalf("expected the sandbox to claim the pooled one, got %s", sb.ID())
+	}
+	if sb.Key() != sb.osSbox.Key() {
+		t.Fatalf("sandbox key %s does not match its namespace %s", sb.Key(), sb.osSbox.Key())
+	}
+	if err := sbx.Delete(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ctrlr.PoolSandboxes(0); err != nil {
+		t.Fatal(err)
+	}
+	ctrlr.sbPool.Lock()
+	left := len(ctrlr.sbPool.entries)
+	ctrlr.sbPool.Unlock()
+	if left != 0 {
+		t.Fatalf("expected the pool to be drained, %d left", left)
+	}
+
+	osl.GC()
+}
+
 // // If different priorities are specified, internal option and ipv6 addresses mustn't influence endpoint order
 func TestSandboxAddMultiPrio(t *testing.T) {
 	if !testutils.IsRunningInContainer() {
@@ -164,6 +238,61 @@ func TestSandboxAddMultiPrio(t *testing.T) {
 	osl.GC()
 }
 
+func TestSandboxSetEndpointPriority(t *testing.T) {
+	if !testutils.IsRunningInContainer() {
+		defer testutils.SetupTestOSContext(t)()
+	}
+
+	opts := [][]NetworkOption{
+		{NetworkOptionGatewayPriority(2)},
+		{},
+	}
+
+	c, nws := getTestEnv(t, opts...)
+	ctrlr := c.(*controller)
+
+	sbx, err := ctrlr.NewSandbox("sandbox1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ep1, err := nws[0].CreateEndpoint("ep1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ep2, err := nws[1].CreateEndpoint("ep2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ep1.Join(sbx); err != nil {
+		t.Fatal(err)
+	}
+	if err := ep2.Join(sbx, JoinOptionPriority(ep2, 1)); err != nil {
+		t.Fatal(err)
+	}
+
+	if gw := sbx.GatewayEndpoint(); gw == nil || gw.ID() != ep1.ID() {
+		t.Fatalf("Expected ep1 to provide the gateway, got %v", gw)
+	}
+
+	if err := sbx.SetEndpointPriority(ep2, 3); err != nil {
+		t.Fatal(err)
+	}
+	if gw := sbx.GatewayEndpoint(); gw == nil || gw.ID() != ep2.ID() {
+		t.Fatalf("Expected ep2 to provide the gateway, got %v", gw)
+	}
+	if !sbx.(*sandbox).osSbox.Info().Gateway().Equal(ep2.Info().Gateway()) {
+		t.Fatalf("Expected the sandbox gateway to be %s, got %s", ep2.Info().Gateway(), sbx.(*sandbox).osSbox.Info().Gateway())
+	}
+
+	if err := sbx.Delete(); err != nil {
+		t.Fatal(err)
+	}
+
+	osl.GC()
+}
+
 func TestSandboxAddSamePrio(t *testing.T) {
 	if !testutils.IsRunningInContainer() {
 		defer testutils.SetupTestOSContext(t)()
@@ -259,3 +388,64 @@ func TestSandboxAddSamePrio(t *testing.T) {
 
 	osl.GC()
 }
+
+func TestSandboxConnect(t *testing.T) {
+	if !testutils.IsRunningInContainer() {
+		defer testutils.SetupTestOSContext(t)()
+	}
+
+	c, nws := getTestEnv(t, []NetworkOption{}, []NetworkOption{})
+	ctrlr := c.(*controller)
+
+	sbx, err := ctrlr.NewSandbox("sandbox1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	taken, err := nws[1].CreateEndpoint("ep2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	attachments := []SandboxAttachment{
+		{NetworkID: nws[0].ID(), Name: "ep1"},
+		{NetworkID: nws[1].ID(), Name: "ep2"},
+	}
+	if _, err := ctrlr.ConnectSandbox(sbx.ID(), attachments); err == nil {
+		t.Fatal("Expected the connect to fail on the endpoint name taken")
+	}
+	if eps := nws[0].Endpoints(); len(eps) != 0 {
+		t.Fatalf("Expected the endpoint created before the failure to be deleted, found %d", len(eps))
+	}
+	if eps := sbx.Endpoints(); len(eps) != 0 {
+		t.Fatalf("Expected the sandbox to have no endpoint, found %d", len(eps))
+	}
+
+	if err := taken.Delete(false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ctrlr.ConnectSandbox(sbx.ID(), append(attachments, attachments[0])); err == nil {
+		t.Fatal("Expected the connect to fail on the network attached twice")
+	}
+
+	eps, err := ctrlr.ConnectSandbox(sbx.ID(), attachments)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(eps) != 2 || len(sbx.Endpoints()) != 2 {
+		t.Fatalf("Expected the sandbox to be connected to both networks, got %d endpoints", len(sbx.Endpoints()))
+	}
+	for _, ep := range eps {
+		if err := ep.Leave(sbx); err != nil {
+			t.Fatal(err)
+		}
+		if err := ep.Delete(false); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := sbx.Delete(); err != nil {
+		t.Fatal(err)
+	}
+
+	osl.GC()
+}
@@ -94,5 +94,9 @@ type loadBalancer struct {
 
 	// Back pointer to service to which the loadbalancer belongs.
 	service *service
+
+	// Name of the external load balancer hook the service on this
+	// network is published to, if any
+	hook string
 	sync.Mutex
 }
@@ -276,6 +276,7 @@ func (c *controller) addServiceBinding(svcName, svcID, nID, eID, containerName s
 			fwMark:   fwMarkCtr,
 			backEnds: make(map[string]*lbBackend),
 			service:  s,
+			hook:     c.lbHookName(n.(*network)),
 		}
 
 		fwMarkCtr++
@@ -298,12 +299,15 @@ func (c *controller) addServiceBinding(svcName, svcID, nID, eID, containerName s
 
 	// Add loadbalancer service and backend to the network
 	n.(*network).addLBBackend(ip, lb)
+	c.publishLBHook(s, nID, lb, false)
 
 	// Add the appropriate name resolutions
 	c.addEndpointNameResolution(svcName, svcID, nID, eID, containerName, vip, serviceAliases, taskAliases, ip, addService, "addServiceBinding")
 
 	logrus.Debugf("addServiceBinding from %s END for %s %s", method, svcName, eID)
 
+	c.publish(Event{Type: EventServiceAdd, NetworkID: nID, EndpointID: eID, ServiceName: svcName, ServiceID: svcID})
+
 	return nil
 }
 
@@ -378,6 +382,8 @@ func (c *controller) rmServiceBinding(svcName, svcID, nID, eID, containerName st
 		}
 	}
 
+	c.publishLBHook(s, nID, lb, rmService)
+
 	// Delete the name resolutions
 	if deleteSvcRecords {
 		c.deleteEndpointNameResolution(svcName, svcID, nID, eID, containerName, vip, serviceAliases, taskAliases, ip, rmService, entries > 0, "rmServiceBinding")
@@ -397,5 +403,7 @@ func (c *controller) rmServiceBinding(svcName, svcID, nID, eID, containerName st
 	}
 
 	logrus.Debugf("rmServiceBinding from %s END for %s %s", method, svcName, eID)
+
+	c.publish(Event{Type: EventServiceRemove, NetworkID: nID, EndpointID: eID, ServiceName: svcName, ServiceID: svcID})
 	return nil
 }
@@ -0,0 +1,75 @@
+package libnetwork
+
+import (
+	"net"
+	"strings"
+
+	"github.com/docker/libnetwork/types"
+)
+
+// overlayDSRVIPOptionString lists the external addresses the clients of the
+// ingress services target when the ingress network is in the DSR mode
+const overlayDSRVIPOptionString = "dsr_vip"
+
+// parseDSROptions returns the load balancing mode and the ingress DSR
+// addresses set by the driver options of the network. The DSR option
+// selects between the direct routing, the default, and the IP-in-IP
+// tunneling forwarding of IPVS.
+func parseDSROptions(optMap map[string]string, ingress bool) (string, []net.IP, error) {
+	mode := loadBalancerModeDefault
+	if v, ok := optMap[overlayDSROptionString]; ok {
+		switch strings.ToLower(v) {
+		case "", "true", "dr":
+			mode = loadBalancerModeDSR
+		case "tunnel":
+			mode = loadBalancerModeDSRTun
+		default:
+			return "", nil, types.BadRequestErrorf("invalid %s option %q: expected dr or tunnel", overlayDSROptionString, v)
+		}
+	}
+
+	v, ok := optMap[overlayDSRVIPOptionString]
+	if !ok {
+		return mode, nil, nil
+	}
+	if !ingress || mode == loadBalancerModeNAT {
+		return "", nil, types.BadRequestErrorf("the %s option requires an ingress network in the DSR mode", overlayDSRVIPOptionString)
+	}
+	var vips []net.IP
+	for _, s := range strings.Split(v, ",") {
+		ip := net.ParseIP(strings.TrimSpace(s))
+		if ip == nil || ip.To4() == nil {
+			return "", nil, types.BadRequestErrorf("invalid %s address %q", overlayDSRVIPOptionString, s)
+		}
+		vips = append(vips, ip.To4())
+	}
+	return mode, vips, nil
+}
+
+// isDSRMode tells if the backends of the network answer the clients
+// directly
+func isDSRMode(mode string) bool {
+	return mode == loadBalancerModeDSR || mode == loadBalancerModeDSRTun
+}
+
+// dsrLoopbackVIPs returns the addresses the backend endpoint configures on
+// the loopback of its sandbox, in the DSR mode, to accept the packets
+// forwarded with their destination: the service VIP and, on the ingress
+// network, the ingress DSR addresses. It must be called with the endpoint
+// lock held.
+func (ep *endpoint) dsrLoopbackVIPs() []*net.IPNet {
+	n := ep.network
+	if n == nil || !isDSRMode(n.loadBalancerMode) {
+		return nil
+	}
+	var nets []*net.IPNet
+	if len(ep.virtualIP) > 0 {
+		nets = append(nets, &net.IPNet{IP: ep.virtualIP, Mask: net.CIDRMask(32, 32)})
+	}
+	if n.ingress && !ep.loadBalancer {
+		for _, ip := range n.dsrVIPs {
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(32, 32)})
+		}
+	}
+	return nets
+}
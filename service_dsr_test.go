@@ -0,0 +1,60 @@
+package libnetwork
+
+import (
+	"net"
+	"testing"
+
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+)
+
+func TestParseDSROptions(t *testing.T) {
+	mode, vips, err := parseDSROptions(map[string]string{}, false)
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(mode, loadBalancerModeNAT))
+	assert.Check(t, is.Len(vips, 0))
+
+	mode, _, err = parseDSROptions(map[string]string{overlayDSROptionString: ""}, false)
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(mode, loadBalancerModeDSR))
+
+	mode, vips, err = parseDSROptions(map[string]string{
+		overlayDSROptionString:    "tunnel",
+		overlayDSRVIPOptionString: "192.0.2.10, 192.0.2.11",
+	}, true)
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(mode, loadBalancerModeDSRTun))
+	assert.Check(t, is.DeepEqual(vips, []net.IP{net.ParseIP("192.0.2.10").To4(), net.ParseIP("192.0.2.11").To4()}))
+
+	for _, opts := range []struct {
+		optMap  map[string]string
+		ingress bool
+	}{
+		{map[string]string{overlayDSROptionString: "maglev"}, false},
+		{map[string]string{overlayDSRVIPOptionString: "192.0.2.10"}, true},
+		{map[string]string{overlayDSROptionString: "", overlayDSRVIPOptionString: "192.0.2.10"}, false},
+		{map[string]string{overlayDSROptionString: "", overlayDSRVIPOptionString: "2001:db8::1"}, true},
+	} {
+		_, _, err := parseDSROptions(opts.optMap, opts.ingress)
+		assert.Check(t, err != nil, "expected an error parsing %v", opts.optMap)
+	}
+}
+
+func TestDSRLoopbackVIPs(t *testing.T) {
+	n := &network{
+		ingress:          true,
+		loadBalancerMode: loadBalancerModeDSR,
+		dsrVIPs:          []net.IP{net.ParseIP("192.0.2.10").To4()},
+	}
+	ep := &endpoint{network: n, virtualIP: net.ParseIP("10.255.0.5").To4()}
+	assert.Check(t, is.DeepEqual(ep.dsrLoopbackVIPs(), []*net.IPNet{
+		{IP: net.ParseIP("10.255.0.5").To4(), Mask: net.CIDRMask(32, 32)},
+		{IP: net.ParseIP("192.0.2.10").To4(), Mask: net.CIDRMask(32, 32)},
+	}))
+
+	ep.loadBalancer = true
+	assert.Check(t, is.Len(ep.dsrLoopbackVIPs(), 1))
+
+	n.loadBalancerMode = loadBalancerModeNAT
+	assert.Check(t, is.Len(ep.dsrLoopbackVIPs(), 0))
+}
@@ -17,10 +17,13 @@ import (
 	"github.com/docker/docker/pkg/reexec"
 	"github.com/docker/libnetwork/iptables"
 	"github.com/docker/libnetwork/ipvs"
+	"github.com/docker/libnetwork/netutils"
 	"github.com/docker/libnetwork/ns"
+	"github.com/docker/libnetwork/osl"
 	"github.com/gogo/protobuf/proto"
 	"github.com/ishidawataru/sctp"
 	"github.com/sirupsen/logrus"
+	"github.com/vishvananda/netlink"
 	"github.com/vishvananda/netlink/nl"
 	"github.com/vishvananda/netns"
 )
@@ -139,6 +142,10 @@ func (n *network) addLBBackend(ip net.IP, lb *loadBalancer) {
 				logrus.Errorf("Failed to add ingress: %v", err)
 				return
 			}
+			if err := programIngressDSR(sb, gwIP, n.dsrVIPs, false); err != nil {
+				logrus.Errorf("Failed to add ingress DSR addresses: %v", err)
+				return
+			}
 		}
 
 		logrus.Debugf("Creating service for vip %s fwMark %d ingressPorts %#v in sbox %.7s (%.7s)", lb.vip, lb.fwMark, lb.service.ingressPorts, sb.ID(), sb.ContainerID())
@@ -158,9 +165,7 @@ func (n *network) addLBBackend(ip net.IP, lb *loadBalancer) {
 		Address:       ip,
 		Weight:        1,
 	}
-	if n.loadBalancerMode == loadBalancerModeDSR {
-		d.ConnectionFlags = ipvs.ConnFwdDirectRoute
-	}
+	d.ConnectionFlags = ipvsForwardMethod(n.loadBalancerMode)
 
 	// Remove the sched name before using the service to add
 	// destination.
@@ -206,9 +211,7 @@ func (n *network) rmLBBackend(ip net.IP, lb *loadBalancer, rmService bool, fullR
 		Address:       ip,
 		Weight:        1,
 	}
-	if n.loadBalancerMode == loadBalancerModeDSR {
-		d.ConnectionFlags = ipvs.ConnFwdDirectRoute
-	}
+	d.ConnectionFlags = ipvsForwardMethod(n.loadBalancerMode)
 
 	if fullRemove {
 		if err := i.DelDestination(s, d); err != nil && err != syscall.ENOENT {
@@ -235,6 +238,9 @@ func (n *network) rmLBBackend(ip net.IP, lb *loadBalancer, rmService bool, fullR
 			if err := programIngress(gwIP, lb.service.ingressPorts, true); err != nil {
 				logrus.Errorf("Failed to delete ingress: %v", err)
 			}
+			if err := programIngressDSR(sb, gwIP, n.dsrVIPs, true); err != nil {
+				logrus.Errorf("Failed to delete ingress DSR addresses: %v", err)
+			}
 		}
 
 		if err := invokeFWMarker(sb.Key(), lb.vip, lb.fwMark, lb.service.ingressPorts, eIP, true, n.loadBalancerMode); err != nil {
@@ -262,8 +268,108 @@ var (
 	ingressProxyTbl = make(map[string]io.Closer)
 	portConfigMu    sync.Mutex
 	portConfigTbl   = make(map[PortConfig]int)
+	dsrVIPMu        sync.Mutex
+	dsrVIPTbl       = make(map[string]int)
 )
 
+// ipvsForwardMethod returns the IPVS forwarding method of the backends of a
+// network in the load balancing mode
+func ipvsForwardMethod(lbMode string) uint32 {
+	switch lbMode {
+	case loadBalancerModeDSR:
+		return ipvs.ConnFwdDirectRoute
+	case loadBalancerModeDSRTun:
+		return ipvs.ConnFwdTunnel
+	}
+	return ipvs.ConnFwdMasq
+}
+
+// programIngressDSR routes the ingress DSR addresses to the ingress sandbox,
+// which accepts them on its loopback so that IPVS picks up the packets,
+// along with the first ingress service and removes them with the last one.
+// The addresses are not local to the host, the packets towards them bypass
+// the DNAT of the published ports and keep their destination up to the
+// backends.
+func programIngressDSR(sb *sandbox, gwIP net.IP, vips []net.IP, isDelete bool) error {
+	if len(vips) == 0 {
+		return nil
+	}
+
+	dsrVIPMu.Lock()
+	defer dsrVIPMu.Unlock()
+
+	nlh := ns.NlHandle()
+	lo := sb.osSbox.GetLoopbackIfaceName()
+	for _, vip := range vips {
+		ipNet := &net.IPNet{IP: vip, Mask: net.CIDRMask(32, 32)}
+		key := vip.String()
+		if isDelete {
+			if dsrVIPTbl[key]--; dsrVIPTbl[key] > 0 {
+				continue
+			}
+			delete(dsrVIPTbl, key)
+			route := &netlink.Route{Dst: ipNet, Gw: gwIP}
+			if err := nlh.RouteDel(route); err != nil {
+				logrus.Warnf("Failed to remove the route of ingress DSR address %s: %v", vip, err)
+			}
+			netutils.DisownRoute(route)
+			if err := sb.osSbox.RemoveAliasIP(lo, ipNet); err != nil {
+				logrus.Warnf("Failed to remove ingress DSR address %s from the ingress sandbox: %v", vip, err)
+			}
+			continue
+		}
+
+		if dsrVIPTbl[key]++; dsrVIPTbl[key] > 1 {
+			continue
+		}
+		if err := sb.osSbox.AddAliasIP(lo, ipNet); err != nil {
+			delete(dsrVIPTbl, key)
+			return fmt.Errorf("failed to add ingress DSR address %s to the ingress sandbox: %v", vip, err)
+		}
+		if err := nlh.RouteReplace(netutils.OwnRoute(&netlink.Route{Dst: ipNet, Gw: gwIP})); err != nil {
+			delete(dsrVIPTbl, key)
+			sb.osSbox.RemoveAliasIP(lo, ipNet)
+			return fmt.Errorf("failed to route ingress DSR address %s to %s: %v", vip, gwIP, err)
+		}
+	}
+	return nil
+}
+
+var loadIPIPOnce sync.Once
+
+// setupDSRTunnel brings up the fallback IP-in-IP device of the sandbox,
+// which decapsulates the packets IPVS tunnels to the backend, and relaxes
+// the reverse path filter for them as they keep the client source.
+func setupDSRTunnel(osSbox osl.Sandbox) error {
+	loadIPIPOnce.Do(func() {
+		if out, err := exec.Command("modprobe", "-va", "ipip").CombinedOutput(); err != nil {
+			logrus.Warnf("Running modprobe ipip failed with message: `%s`, error: %v", strings.TrimSpace(string(out)), err)
+		}
+	})
+
+	var err error
+	if e := osSbox.InvokeFunc(func() {
+		var link netlink.Link
+		if link, err = netlink.LinkByName("tunl0"); err != nil {
+			err = fmt.Errorf("failed to find the tunl0 device: %v", err)
+			return
+		}
+		if err = netlink.LinkSetUp(link); err != nil {
+			err = fmt.Errorf("failed to bring up tunl0: %v", err)
+			return
+		}
+		for _, path := range []string{"/proc/sys/net/ipv4/conf/all/rp_filter", "/proc/sys/net/ipv4/conf/tunl0/rp_filter"} {
+			if err = ioutil.WriteFile(path, []byte{'0', '\n'}, 0644); err != nil {
+				err = fmt.Errorf("failed to set %s to 0: %v", path, err)
+				return
+			}
+		}
+	}); e != nil {
+		return e
+	}
+	return err
+}
+
 func filterPortConfigs(ingressPorts []*PortConfig, isDelete bool) []*PortConfig {
 	portConfigMu.Lock()
 	iPorts := make([]*PortConfig, 0, len(ingressPorts))
@@ -652,7 +758,7 @@ func fwMarker() {
 	}
 
 	lbMode := os.Args[7]
-	if addDelOpt == "-A" && lbMode == loadBalancerModeNAT {
+	if addDelOpt == "-A" && !isDSRMode(lbMode) {
 		eIP, subnet, err := net.ParseCIDR(os.Args[6])
 		if err != nil {
 			logrus.Errorf("Failed to parse endpoint IP %s: %v", os.Args[6], err)
@@ -5,6 +5,8 @@ package libnetwork
 import (
 	"fmt"
 	"net"
+
+	"github.com/docker/libnetwork/osl"
 )
 
 func (c *controller) cleanupServiceBindings(nid string) {
@@ -23,3 +25,7 @@ func (sb *sandbox) populateLoadBalancers(ep *endpoint) {
 
 func arrangeIngressFilterRule() {
 }
+
+func setupDSRTunnel(osSbox osl.Sandbox) error {
+	return fmt.Errorf("not supported")
+}
@@ -1,10 +1,12 @@
 package libnetwork
 
 import (
+	"fmt"
 	"net"
 
 	"github.com/Microsoft/hcsshim"
 	"github.com/docker/docker/pkg/system"
+	"github.com/docker/libnetwork/osl"
 	"github.com/sirupsen/logrus"
 )
 
@@ -171,3 +173,7 @@ func (sb *sandbox) populateLoadBalancers(ep *endpoint) {
 
 func arrangeIngressFilterRule() {
 }
+
+func setupDSRTunnel(osSbox osl.Sandbox) error {
+	return fmt.Errorf("not supported")
+}
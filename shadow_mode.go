@@ -0,0 +1,170 @@
+package libnetwork
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/docker/libnetwork/datastore"
+	"github.com/docker/libnetwork/diagnostic"
+	"github.com/docker/libnetwork/internal/caller"
+	"github.com/sirupsen/logrus"
+)
+
+// shadowModePaths2Func are the diagnostic handlers of the shadow mode of
+// the endpoints
+var shadowModePaths2Func = map[string]diagnostic.HTTPHandlerFunc{
+	"/shadowmode": shadowModeDiag,
+}
+
+// ShadowEndpointStatus is the state of the filter of an endpoint in shadow
+// mode: the packets its policy let through and the ones it would have
+// rejected, since the version was applied
+type ShadowEndpointStatus struct {
+	ID          string `json:"id"`
+	Version     string `json:"version"`
+	Audit       bool   `json:"audit"`
+	Accepted    uint64 `json:"accepted"`
+	WouldReject uint64 `json:"would_reject"`
+}
+
+type shadowModeResult struct {
+	NetworkID string                 `json:"network_id"`
+	Endpoints []ShadowEndpointStatus `json:"endpoints"`
+}
+
+func (r *shadowModeResult) String() string {
+	var b strings.Builder
+	for _, s := range r.Endpoints {
+		fmt.Fprintf(&b, "nid:%s eid:%.7s version:%s audit:%t accepted:%d would_reject:%d\n",
+			r.NetworkID, s.ID, s.Version, s.Audit, s.Accepted, s.WouldReject)
+	}
+	return b.String()
+}
+
+// SetEndpointShadowMode applies the filter policies of the endpoint for
+// audit only, the packets they reject being logged and let through, or
+// enforces them again. The current policy of the endpoint is applied back
+// in the new mode.
+func (c *controller) SetEndpointShadowMode(networkID, endpointID string, shadow bool) error {
+	n, f, err := c.endpointFilterer(networkID)
+	if err != nil {
+		return err
+	}
+	if _, err := n.EndpointByID(endpointID); err != nil {
+		return err
+	}
+	store := c.getStore(n.DataScope())
+	if store == nil {
+		return ErrDataStoreNotInitialized(n.DataScope())
+	}
+	c.filterMu.Lock()
+	defer c.filterMu.Unlock()
+
+	var current *FilterPolicyVersion
+	for {
+		r := &filterPolicyRecord{NetworkID: n.ID(), EndpointID: endpointID, scope: n.DataScope()}
+		if err := store.GetObject(datastore.Key(r.Key()...), r); err != nil && err != datastore.ErrKeyNotFound {
+			return err
+		}
+		if l := len(r.History); l > 0 {
+			current = &r.History[l-1]
+		}
+		if r.Shadow == shadow {
+			return nil
+		}
+		r.Shadow = shadow
+		if err := c.updateToStore(r); err != datastore.ErrKeyModified {
+			if err != nil {
+				return err
+			}
+			break
+		}
+	}
+
+	logrus.Infof("Endpoint %.7s of network %s shadow mode set to %t", endpointID, n.Name(), shadow)
+	if current == nil || current.Policy == nil {
+		return nil
+	}
+	return c.filterEndpoint(n, f, endpointID, current.Policy)
+}
+
+// endpointShadowed tells whether the filter policies of the endpoint are
+// applied for audit only
+func (c *controller) endpointShadowed(n *network, eid string) bool {
+	store := c.getStore(n.DataScope())
+	if store == nil {
+		return false
+	}
+	r := &filterPolicyRecord{NetworkID: n.ID(), EndpointID: eid, scope: n.DataScope()}
+	if err := store.GetObject(datastore.Key(r.Key()...), r); err != nil {
+		return false
+	}
+	return r.Shadow
+}
+
+// ShadowModeStatus returns the filter counters of the endpoints of the
+// network in shadow mode
+func (c *controller) ShadowModeStatus(networkID string) ([]ShadowEndpointStatus, error) {
+	n, f, err := c.endpointFilterer(networkID)
+	if err != nil {
+		return nil, err
+	}
+	var l []ShadowEndpointStatus
+	for _, ep := range n.Endpoints() {
+		if !c.endpointShadowed(n, ep.ID()) {
+			continue
+		}
+		s := ShadowEndpointStatus{ID: ep.ID()}
+		fc, err := f.FilterCounters(n.ID(), ep.ID())
+		if err != nil {
+			logrus.Debugf("Failed to read the filter counters of endpoint %.7s: %v", ep.ID(), err)
+		} else if fc != nil {
+			s.Version, s.Audit, s.Accepted, s.WouldReject = fc.Version, fc.Audit, fc.Accepted, fc.Rejected
+		}
+		l = append(l, s)
+	}
+	return l, nil
+}
+
+func shadowModeDiag(ctx interface{}, w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	diagnostic.DebugHTTPForm(r)
+	_, json := diagnostic.ParseHTTPFormOptions(r)
+
+	// audit logs
+	log := logrus.WithFields(logrus.Fields{"component": "diagnostic", "remoteIP": r.RemoteAddr, "method": caller.Name(0), "url": r.URL.String()})
+	log.Info("shadow mode")
+
+	c, ok := ctx.(*controller)
+	if !ok {
+		diagnostic.HTTPReply(w, diagnostic.FailCommand(fmt.Errorf("controller not available")), json)
+		return
+	}
+
+	nid := r.Form.Get("nid")
+	if nid == "" {
+		diagnostic.HTTPReply(w, diagnostic.WrongCommand("shadowmode", "nid=<network id> [eid=<endpoint id> enable=<true|false>]"), json)
+		return
+	}
+	if eid := r.Form.Get("eid"); eid != "" {
+		enable, err := strconv.ParseBool(r.Form.Get("enable"))
+		if err != nil {
+			diagnostic.HTTPReply(w, diagnostic.WrongCommand("shadowmode", "nid=<network id> [eid=<endpoint id> enable=<true|false>]"), json)
+			return
+		}
+		if err := c.SetEndpointShadowMode(nid, eid, enable); err != nil {
+			log.WithError(err).Error("shadow mode failed")
+			diagnostic.HTTPReply(w, diagnostic.FailCommand(err), json)
+			return
+		}
+	}
+	l, err := c.ShadowModeStatus(nid)
+	if err != nil {
+		log.WithError(err).Error("shadow mode failed")
+		diagnostic.HTTPReply(w, diagnostic.FailCommand(err), json)
+		return
+	}
+	diagnostic.HTTPReply(w, diagnostic.CommandSucceed(&shadowModeResult{NetworkID: nid, Endpoints: l}), json)
+}
@@ -0,0 +1,166 @@
+package libnetwork
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"net"
+	"os"
+
+	"github.com/docker/libnetwork/ipamapi"
+	"github.com/docker/libnetwork/netlabel"
+	"github.com/docker/libnetwork/netutils"
+	"github.com/docker/libnetwork/types"
+	"github.com/sirupsen/logrus"
+)
+
+// The values of netlabel.IPv6AddressMode
+const (
+	ipv6AddressModeSLAAC         = "slaac"
+	ipv6AddressModeStablePrivacy = "stable-privacy"
+)
+
+// stablePrivacyRetries is the number of alternative stable-privacy addresses
+// tried after collisions, the RFC 7217 IDGEN_RETRIES
+const stablePrivacyRetries = 3
+
+// ipv6AddressMode returns how the IPv6 addresses of the endpoints of the
+// network are derived, empty when the IPAM driver allocates them
+func (n *network) ipv6AddressMode() string {
+	return n.Labels()[netlabel.IPv6AddressMode]
+}
+
+func (n *network) validateIPv6AddressMode() error {
+	switch mode := n.labels[netlabel.IPv6AddressMode]; mode {
+	case "":
+		return nil
+	case ipv6AddressModeSLAAC, ipv6AddressModeStablePrivacy:
+		if !n.enableIPv6 {
+			return types.BadRequestErrorf("the %s IPv6 addresses require IPv6 to be enabled on the network", mode)
+		}
+		return nil
+	default:
+		return types.BadRequestErrorf("invalid %s %q: expected %s or %s", netlabel.IPv6AddressMode, mode,
+			ipv6AddressModeSLAAC, ipv6AddressModeStablePrivacy)
+	}
+}
+
+// assignDerivedAddressV6 reserves the IPv6 address derived for the endpoint
+// in the first /64 pool of the network, as its interface would configure
+// it from the router advertisements, instead of letting the IPAM driver
+// choose one
+func (ep *endpoint) assignDerivedAddressV6(ipam ipamapi.Ipam, ipInfo []*IpamInfo, mode string, opts map[string]string) error {
+	n := ep.getNetwork()
+	for _, d := range ipInfo {
+		if ones, bits := d.Pool.Mask.Size(); ones != 64 || bits != 128 {
+			continue
+		}
+
+		var candidates []net.IP
+		if mode == ipv6AddressModeSLAAC {
+			candidates = []net.IP{eui64Address(d.Pool, ep.slaacMAC())}
+		} else {
+			secret := stableSecret(n.getController())
+			for counter := 0; counter <= stablePrivacyRetries; counter++ {
+				if ip := stablePrivacyAddress(d.Pool, ep.Name(), n.ID(), counter, secret); ip != nil {
+					candidates = append(candidates, ip)
+				}
+			}
+		}
+
+		for _, ip := range candidates {
+			addr, _, err := ipam.RequestAddress(d.PoolID, ip, opts)
+			if err == ipamapi.ErrIPAlreadyAllocated {
+				logrus.Debugf("The %s IPv6 address %s of endpoint %s is in use", mode, ip, ep.Name())
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			logrus.Debugf("Derived the %s IPv6 address %s of endpoint %s", mode, addr.IP, ep.Name())
+			ep.Lock()
+			ep.iface.addrv6 = addr
+			ep.iface.v6PoolID = d.PoolID
+			ep.Unlock()
+			return nil
+		}
+		return types.ForbiddenErrorf("the %s IPv6 addresses of endpoint %s are in use on network %s", mode, ep.Name(), n.Name())
+	}
+	return types.BadRequestErrorf("the %s IPv6 addresses require a /64 subnet on network %s", mode, n.Name())
+}
+
+// slaacMAC returns the MAC address of the endpoint, choosing it as the
+// drivers would when it has none yet: derived from the IPv4 address, or
+// random
+func (ep *endpoint) slaacMAC() net.HardwareAddr {
+	ep.Lock()
+	defer ep.Unlock()
+	if ep.iface.mac == nil {
+		if ep.iface.addr != nil {
+			ep.iface.mac = netutils.GenerateMACFromIP(ep.iface.addr.IP)
+		} else {
+			ep.iface.mac = netutils.GenerateRandomMAC()
+		}
+	}
+	return ep.iface.mac
+}
+
+// stableSecret returns the secret key of the stable-privacy addresses, the
+// host name when the daemon is configured with none
+func stableSecret(c *controller) []byte {
+	if secret := c.Config().Daemon.IPv6StableSecret; secret != "" {
+		return []byte(secret)
+	}
+	host, _ := os.Hostname()
+	return []byte(host)
+}
+
+// eui64Address returns the address of the /64 prefix with the modified
+// EUI-64 interface identifier of the MAC address
+func eui64Address(prefix *net.IPNet, mac net.HardwareAddr) net.IP {
+	ip := make(net.IP, net.IPv6len)
+	copy(ip, prefix.IP.Mask(prefix.Mask).To16())
+	ip[8] = mac[0] ^ 0x02
+	ip[9], ip[10] = mac[1], mac[2]
+	ip[11], ip[12] = 0xff, 0xfe
+	ip[13], ip[14], ip[15] = mac[3], mac[4], mac[5]
+	return ip
+}
+
+// stablePrivacyAddress returns the RFC 7217 address of the /64 prefix for
+// the interface on the network, nil when the interface identifier is a
+// reserved one and the next DAD counter is to be tried
+func stablePrivacyAddress(prefix *net.IPNet, iface, networkID string, dadCounter int, secret []byte) net.IP {
+	ip := make(net.IP, net.IPv6len)
+	copy(ip, prefix.IP.Mask(prefix.Mask).To16())
+
+	h := sha256.New()
+	h.Write(ip[:8])
+	h.Write([]byte(iface))
+	h.Write([]byte{0})
+	h.Write([]byte(networkID))
+	h.Write([]byte{0, byte(dadCounter)})
+	h.Write(secret)
+	copy(ip[8:], h.Sum(nil)[sha256.Size-8:])
+
+	if reservedInterfaceID(ip[8:]) {
+		return nil
+	}
+	return ip
+}
+
+// reservedInterfaceID reports whether the interface identifier is the
+// subnet-router anycast one, a reserved RFC 5453 one, or the last one of
+// the prefix, which the default IPAM driver does not allocate
+func reservedInterfaceID(iid []byte) bool {
+	switch {
+	case bytes.Equal(iid, make([]byte, 8)):
+		return true
+	case bytes.Equal(iid[:7], []byte{0xfd, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}) && iid[7] >= 0x80:
+		return true
+	case bytes.Equal(iid[:6], []byte{0x02, 0x00, 0x5e, 0xff, 0xfe, 0x00}) && (iid[6] < 0x52 || iid[6] == 0x52 && iid[7] <= 0x12):
+		return true
+	case bytes.Equal(iid, bytes.Repeat([]byte{0xff}, 8)):
+		return true
+	}
+	return false
+}
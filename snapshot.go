@@ -0,0 +1,145 @@
+package libnetwork
+
+import (
+	"encoding/json"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/docker/libkv/store"
+	"github.com/docker/libnetwork/datastore"
+	"github.com/docker/libnetwork/types"
+	"github.com/sirupsen/logrus"
+)
+
+// SnapshotVersion is the version of the snapshot format written by
+// ExportSnapshot
+const SnapshotVersion = 1
+
+// Snapshot is the content of the local datastore of a controller: networks,
+// endpoints, sandboxes and the state the drivers and the IPAM keep for them.
+type Snapshot struct {
+	Version      int              `json:"version"`
+	ControllerID string           `json:"controllerID"`
+	Created      time.Time        `json:"created"`
+	Objects      []SnapshotObject `json:"objects"`
+}
+
+// SnapshotObject is a datastore object, keyed by the components of its key
+// below the libnetwork root, the first one being the kind of the object.
+type SnapshotObject struct {
+	Key   []string        `json:"key"`
+	Value json.RawMessage `json:"value"`
+}
+
+// ExportSnapshot writes a snapshot of the locally scoped state to w
+func (c *controller) ExportSnapshot(w io.Writer) error {
+	ds := c.getStore(datastore.LocalScope)
+	if ds == nil {
+		return types.NotFoundErrorf("no local datastore to export")
+	}
+
+	kvs, err := ds.KVStore().List(datastore.Key())
+	if err != nil && err != store.ErrKeyNotFound {
+		return types.InternalErrorf("failed to list the local datastore: %v", err)
+	}
+
+	s := &Snapshot{
+		Version:      SnapshotVersion,
+		ControllerID: c.id,
+		Created:      time.Now().UTC(),
+		Objects:      make([]SnapshotObject, 0, len(kvs)),
+	}
+	for _, kv := range kvs {
+		// The directories of the store are listed along its objects
+		if len(kv.Value) == 0 && strings.HasSuffix(kv.Key, "/") {
+			continue
+		}
+		key, err := datastore.ParseKey(kv.Key)
+		if err != nil {
+			return err
+		}
+		if !json.Valid(kv.Value) {
+			return types.InternalErrorf("value of %s is not a json object", kv.Key)
+		}
+		s.Objects = append(s.Objects, SnapshotObject{Key: key, Value: kv.Value})
+	}
+	sort.Slice(s.Objects, func(i, j int) bool {
+		return strings.Join(s.Objects[i].Key, "/") < strings.Join(s.Objects[j].Key, "/")
+	})
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(s)
+}
+
+// ImportSnapshot writes the objects of a snapshot read from r to the local
+// datastore. Objects already present are left untouched, and so are the
+// networks named after an existing network, such as the predefined ones,
+// along with every object referring to them. The imported state is restored
+// the next time the controller starts, as after a daemon restart.
+func (c *controller) ImportSnapshot(r io.Reader) error {
+	var s Snapshot
+	if err := json.NewDecoder(r).Decode(&s); err != nil {
+		return types.BadRequestErrorf("invalid snapshot: %v", err)
+	}
+	if s.Version != SnapshotVersion {
+		return types.BadRequestErrorf("unsupported snapshot version %d", s.Version)
+	}
+
+	ds := c.getStore(datastore.LocalScope)
+	if ds == nil {
+		return types.NotFoundErrorf("no local datastore to import to")
+	}
+	kvs := ds.KVStore()
+
+	skip := make(map[string]bool)
+	for _, o := range s.Objects {
+		if len(o.Key) != 2 || o.Key[0] != datastore.NetworkKeyPrefix {
+			continue
+		}
+		var n struct {
+			Name string `json:"name"`
+		}
+		if err := json.Unmarshal(o.Value, &n); err != nil {
+			return types.BadRequestErrorf("invalid network %s in snapshot: %v", o.Key[1], err)
+		}
+		if _, err := c.NetworkByName(n.Name); err == nil {
+			logrus.Infof("Skipping network %s (%s) of the snapshot: a network with the same name exists", n.Name, o.Key[1])
+			skip[o.Key[1]] = true
+		}
+	}
+
+	imported := 0
+	for _, o := range s.Objects {
+		if len(o.Key) == 0 || refersTo(o.Key, skip) {
+			continue
+		}
+		key := datastore.Key(o.Key...)
+		exists, err := kvs.Exists(key)
+		if err != nil {
+			return types.InternalErrorf("failed to look up %s: %v", key, err)
+		}
+		if exists {
+			logrus.Debugf("Skipping existing object %s of the snapshot", key)
+			continue
+		}
+		if err := kvs.Put(key, o.Value, nil); err != nil {
+			return types.InternalErrorf("failed to import %s: %v", key, err)
+		}
+		imported++
+	}
+	logrus.Infof("Imported %d objects of the snapshot of controller %s", imported, s.ControllerID)
+
+	return nil
+}
+
+func refersTo(key []string, ids map[string]bool) bool {
+	for _, k := range key {
+		if ids[k] {
+			return true
+		}
+	}
+	return false
+}
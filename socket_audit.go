@@ -0,0 +1,65 @@
+package libnetwork
+
+import (
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/docker/libnetwork/driverapi"
+	"github.com/sirupsen/logrus"
+)
+
+// runSocketAudit publishes the rejected connect() calls of the endpoints
+// at every interval, polling the drivers implementing
+// driverapi.SocketPolicer. It runs when the daemon configures the
+// interval.
+func (c *controller) runSocketAudit(interval time.Duration, stopCh chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			c.auditSocketDenials()
+		case <-stopCh:
+			return
+		}
+	}
+}
+
+// auditSocketDenials logs and publishes an EventSocketDenied event for
+// each destination the socket policy of an endpoint rejected connect()
+// calls to since the last poll
+func (c *controller) auditSocketDenials() {
+	var denials []driverapi.SocketDenial
+	c.drvRegistry.WalkDrivers(func(name string, driver driverapi.Driver, capability driverapi.Capability) bool {
+		sp, ok := driver.(driverapi.SocketPolicer)
+		if !ok {
+			return false
+		}
+		d, err := sp.SocketDenials()
+		if err != nil {
+			logrus.Warnf("Failed to get the socket denials of driver %s: %v", name, err)
+			return false
+		}
+		denials = append(denials, d...)
+		return false
+	})
+	for _, d := range denials {
+		ev := Event{
+			Type:        EventSocketDenied,
+			NetworkID:   d.NetworkID,
+			EndpointID:  d.EndpointID,
+			Destination: net.JoinHostPort(d.Dst.String(), strconv.Itoa(int(d.Port))),
+			Count:       d.Count,
+		}
+		if n, err := c.NetworkByID(d.NetworkID); err == nil {
+			ev.NetworkName = n.Name()
+			if ep, err := n.EndpointByID(d.EndpointID); err == nil {
+				ev.EndpointName = ep.Name()
+			}
+		}
+		logrus.WithFields(logrus.Fields{"component": "socket-policy", "network": ev.NetworkName, "endpoint": ev.EndpointName,
+			"destination": ev.Destination, "count": ev.Count}).Info("connect() rejected")
+		c.publish(ev)
+	}
+}
@@ -0,0 +1,228 @@
+package libnetwork
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/docker/libnetwork/types"
+)
+
+const (
+	defaultTopSockets = 10
+	maxTopSockets     = 1000
+
+	// sizeofInetDiagMsg is the length of the inet_diag_msg header of the
+	// INET_DIAG replies, its attributes following it
+	sizeofInetDiagMsg = 72
+	// inetDiagInfo is the INET_DIAG_INFO attribute, carrying the tcp_info
+	inetDiagInfo = 2
+	// minTCPInfo is the length of the tcp_info up to its total_retrans
+	minTCPInfo = 104
+)
+
+// tcpStates are the names of the TCP states as ss prints them
+var tcpStates = map[uint8]string{
+	1:  "ESTAB",
+	2:  "SYN-SENT",
+	3:  "SYN-RECV",
+	4:  "FIN-WAIT-1",
+	5:  "FIN-WAIT-2",
+	6:  "TIME-WAIT",
+	7:  "UNCONN",
+	8:  "CLOSE-WAIT",
+	9:  "LAST-ACK",
+	10: "LISTEN",
+	11: "CLOSING",
+}
+
+const (
+	tcpEstablished = 1
+	tcpListen      = 10
+)
+
+// tcpSocket is a TCP socket of a sandbox, as dumped by INET_DIAG
+type tcpSocket struct {
+	state  uint8
+	local  net.TCPAddr
+	remote net.TCPAddr
+	// the receive and send queues, for the listeners the accept queue
+	// and its bound
+	rqueue uint32
+	wqueue uint32
+	// the tcp_info, when the kernel attached it
+	hasInfo      bool
+	retransmits  uint8
+	totalRetrans uint32
+	rtt          time.Duration
+	cwnd         uint32
+}
+
+// socketSummary is the ss-style snapshot of the TCP sockets of a sandbox
+type socketSummary struct {
+	SandboxID string         `json:"sandbox_id"`
+	Sockets   int            `json:"sockets"`
+	States    map[string]int `json:"states"`
+	// Retransmits sums the retransmitted segments of the sockets
+	Retransmits uint64 `json:"retransmits"`
+	// Backlogged counts the listeners with connections pending accept
+	Backlogged int             `json:"backlogged"`
+	Listeners  []listenerStat  `json:"listeners"`
+	Top        []tcpSocketStat `json:"top"`
+}
+
+type listenerStat struct {
+	Local      string `json:"local"`
+	Backlog    uint32 `json:"backlog"`
+	MaxBacklog uint32 `json:"max_backlog"`
+}
+
+type tcpSocketStat struct {
+	State        string `json:"state"`
+	Local        string `json:"local"`
+	Remote       string `json:"remote"`
+	RecvQ        uint32 `json:"recv_q"`
+	SendQ        uint32 `json:"send_q"`
+	Retransmits  uint8  `json:"retransmits,omitempty"`
+	TotalRetrans uint32 `json:"total_retrans,omitempty"`
+	RTT          string `json:"rtt,omitempty"`
+	Cwnd         uint32 `json:"cwnd,omitempty"`
+}
+
+// parseInetDiagMsg parses an INET_DIAG reply, its inet_diag_msg followed by
+// the attributes of which only the tcp_info is kept
+func parseInetDiagMsg(b []byte) (*tcpSocket, error) {
+	if len(b) < sizeofInetDiagMsg {
+		return nil, fmt.Errorf("inet_diag message short read (%d); want %d", len(b), sizeofInetDiagMsg)
+	}
+	s := &tcpSocket{state: b[1]}
+	addr := func(ip []byte) net.IP {
+		if b[0] == 2 { // AF_INET
+			return net.IP(append([]byte(nil), ip[:4]...))
+		}
+		return net.IP(append([]byte(nil), ip[:16]...))
+	}
+	s.local = net.TCPAddr{IP: addr(b[8:24]), Port: int(binary.BigEndian.Uint16(b[4:6]))}
+	s.remote = net.TCPAddr{IP: addr(b[24:40]), Port: int(binary.BigEndian.Uint16(b[6:8]))}
+	s.rqueue = binary.LittleEndian.Uint32(b[56:60])
+	s.wqueue = binary.LittleEndian.Uint32(b[60:64])
+
+	// the attributes are rtattr, 4 bytes aligned
+	for attrs := b[sizeofInetDiagMsg:]; len(attrs) >= 4; {
+		l := int(binary.LittleEndian.Uint16(attrs[0:2]))
+		t := binary.LittleEndian.Uint16(attrs[2:4])
+		if l < 4 || l > len(attrs) {
+			return nil, fmt.Errorf("invalid inet_diag attribute length %d", l)
+		}
+		if t == inetDiagInfo {
+			parseTCPInfo(s, attrs[4:l])
+		}
+		if l = (l + 3) &^ 3; l > len(attrs) {
+			break
+		}
+		attrs = attrs[l:]
+	}
+	return s, nil
+}
+
+// parseTCPInfo keeps the fields of the tcp_info of the socket retransmits
+// and congestion tell of
+func parseTCPInfo(s *tcpSocket, info []byte) {
+	if len(info) < minTCPInfo {
+		return
+	}
+	s.hasInfo = true
+	s.retransmits = info[2]
+	s.rtt = time.Duration(binary.LittleEndian.Uint32(info[68:72])) * time.Microsecond
+	s.cwnd = binary.LittleEndian.Uint32(info[80:84])
+	s.totalRetrans = binary.LittleEndian.Uint32(info[100:104])
+}
+
+// summarizeSockets counts the sockets by state and keeps the listeners and
+// the top sockets, the ones retransmitting most then queuing most
+func summarizeSockets(sid string, socks []*tcpSocket, top int) *socketSummary {
+	sum := &socketSummary{SandboxID: sid, Sockets: len(socks), States: map[string]int{}, Listeners: []listenerStat{}, Top: []tcpSocketStat{}}
+	var active []*tcpSocket
+	for _, s := range socks {
+		name, ok := tcpStates[s.state]
+		if !ok {
+			name = strconv.Itoa(int(s.state))
+		}
+		sum.States[name]++
+		sum.Retransmits += uint64(s.totalRetrans)
+		if s.state == tcpListen {
+			sum.Listeners = append(sum.Listeners, listenerStat{Local: s.local.String(), Backlog: s.rqueue, MaxBacklog: s.wqueue})
+			if s.rqueue > 0 {
+				sum.Backlogged++
+			}
+			continue
+		}
+		active = append(active, s)
+	}
+	sort.Slice(sum.Listeners, func(i, j int) bool { return sum.Listeners[i].Local < sum.Listeners[j].Local })
+
+	sort.SliceStable(active, func(i, j int) bool {
+		a, b := active[i], active[j]
+		if a.totalRetrans != b.totalRetrans {
+			return a.totalRetrans > b.totalRetrans
+		}
+		return a.rqueue+a.wqueue > b.rqueue+b.wqueue
+	})
+	if len(active) > top {
+		active = active[:top]
+	}
+	for _, s := range active {
+		st := tcpSocketStat{
+			State:        tcpStates[s.state],
+			Local:        s.local.String(),
+			Remote:       s.remote.String(),
+			RecvQ:        s.rqueue,
+			SendQ:        s.wqueue,
+			Retransmits:  s.retransmits,
+			TotalRetrans: s.totalRetrans,
+			Cwnd:         s.cwnd,
+		}
+		if s.hasInfo && s.state == tcpEstablished {
+			st.RTT = s.rtt.String()
+		}
+		sum.Top = append(sum.Top, st)
+	}
+	return sum
+}
+
+// inspectSockets returns the socket summary of the sandbox the "sid" form
+// value identifies, with up to the "top" busiest sockets
+func inspectSockets(c *controller, r *http.Request) (interface{}, error) {
+	sid := r.Form.Get("sid")
+	if sid == "" {
+		return nil, types.BadRequestErrorf("sockets: sid=<sandbox id>[&top=%d]", defaultTopSockets)
+	}
+	top := defaultTopSockets
+	if v := r.Form.Get("top"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 || n > maxTopSockets {
+			return nil, types.BadRequestErrorf("invalid top %q: expected up to %d", v, maxTopSockets)
+		}
+		top = n
+	}
+	s, err := c.SandboxByID(sid)
+	if err != nil {
+		return nil, err
+	}
+	sb := s.(*sandbox)
+	sb.Lock()
+	osSbox := sb.osSbox
+	sb.Unlock()
+	if osSbox == nil {
+		return nil, fmt.Errorf("sandbox %.7s has no namespace", sid)
+	}
+	socks, err := dumpTCPSockets(osSbox)
+	if err != nil {
+		return nil, fmt.Errorf("failed to dump the sockets of sandbox %.7s: %v", sid, err)
+	}
+	return summarizeSockets(sb.ID(), socks, top), nil
+}
@@ -0,0 +1,59 @@
+package libnetwork
+
+import (
+	"encoding/binary"
+	"syscall"
+
+	"github.com/docker/libnetwork/osl"
+	"github.com/vishvananda/netlink/nl"
+)
+
+// inetDiagRequest is the inet_diag_req_v2 dumping all the TCP sockets of a
+// family, along their tcp_info
+type inetDiagRequest struct {
+	family uint8
+}
+
+func (r *inetDiagRequest) Len() int { return 56 }
+
+func (r *inetDiagRequest) Serialize() []byte {
+	b := make([]byte, r.Len())
+	b[0] = r.family
+	b[1] = syscall.IPPROTO_TCP
+	b[2] = 1 << (inetDiagInfo - 1)
+	// all the states
+	binary.LittleEndian.PutUint32(b[4:8], 0xffffffff)
+	// no cookie
+	binary.LittleEndian.PutUint32(b[48:52], nl.TCPDIAG_NOCOOKIE)
+	binary.LittleEndian.PutUint32(b[52:56], nl.TCPDIAG_NOCOOKIE)
+	return b
+}
+
+// dumpTCPSockets dumps the IPv4 and IPv6 TCP sockets of the namespace of the
+// sandbox, the netlink socket being opened in it
+func dumpTCPSockets(osSbox osl.Sandbox) ([]*tcpSocket, error) {
+	var (
+		socks []*tcpSocket
+		err   error
+	)
+	if ierr := osSbox.InvokeFunc(func() {
+		for _, family := range []uint8{syscall.AF_INET, syscall.AF_INET6} {
+			req := nl.NewNetlinkRequest(nl.SOCK_DIAG_BY_FAMILY, syscall.NLM_F_DUMP)
+			req.AddData(&inetDiagRequest{family: family})
+			var msgs [][]byte
+			if msgs, err = req.Execute(syscall.NETLINK_INET_DIAG, nl.SOCK_DIAG_BY_FAMILY); err != nil {
+				return
+			}
+			for _, m := range msgs {
+				var s *tcpSocket
+				if s, err = parseInetDiagMsg(m); err != nil {
+					return
+				}
+				socks = append(socks, s)
+			}
+		}
+	}); ierr != nil {
+		return nil, ierr
+	}
+	return socks, err
+}
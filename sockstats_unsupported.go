@@ -0,0 +1,12 @@
+// +build !linux
+
+package libnetwork
+
+import (
+	"github.com/docker/libnetwork/osl"
+	"github.com/docker/libnetwork/types"
+)
+
+func dumpTCPSockets(osSbox osl.Sandbox) ([]*tcpSocket, error) {
+	return nil, types.NotImplementedErrorf("socket statistics are not supported on this platform")
+}
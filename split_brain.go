@@ -0,0 +1,534 @@
+package libnetwork
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/go-events"
+	"github.com/docker/libnetwork/config"
+	"github.com/docker/libnetwork/datastore"
+	"github.com/docker/libnetwork/diagnostic"
+	"github.com/docker/libnetwork/internal/caller"
+	"github.com/docker/libnetwork/networkdb"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	heartbeatKeyPrefix = "heartbeat"
+
+	// The fencing policies of the partitions
+	fencingNone   = "none"
+	fencingFreeze = "freeze"
+
+	defaultHeartbeatInterval = 5 * time.Second
+	defaultPartitionRatio    = 0.5
+	defaultMaxFence          = 5 * time.Minute
+)
+
+// splitBrainPaths2Func are the diagnostic handlers of the partition
+// detection
+var splitBrainPaths2Func = map[string]diagnostic.HTTPHandlerFunc{
+	"/partition": partitionDiag,
+}
+
+// PartitionStatus is the state of the partition detection: the signals of
+// a partition, and whether the service binding updates are held
+type PartitionStatus struct {
+	Partitioned bool      `json:"partitioned"`
+	Fenced      bool      `json:"fenced"`
+	Reasons     []string  `json:"reasons,omitempty"`
+	Since       time.Time `json:"since"`
+	// StoreReachable tells whether the last heartbeat write to the global
+	// datastore succeeded
+	StoreReachable bool     `json:"store_reachable"`
+	StalePeers     []string `json:"stale_peers,omitempty"`
+	FailedPeers    []string `json:"failed_peers,omitempty"`
+	HeldUpdates    int      `json:"held_updates"`
+}
+
+func (s *PartitionStatus) String() string {
+	state := "healthy"
+	if s.Partitioned {
+		state = "partitioned"
+	}
+	return fmt.Sprintf("%s fenced:%t since:%s store reachable:%t stale peers:%v failed peers:%v held updates:%d reasons:%s\n",
+		state, s.Fenced, s.Since.Format(time.RFC3339), s.StoreReachable, s.StalePeers, s.FailedPeers, s.HeldUpdates, strings.Join(s.Reasons, "; "))
+}
+
+// heartbeatRecord is the heartbeat of a controller in the global
+// datastore, its time compared to the local clock of the peers which are
+// expected to be kept in sync
+type heartbeatRecord struct {
+	id       string
+	Address  string    `json:"address"`
+	Time     time.Time `json:"time"`
+	dbIndex  uint64
+	dbExists bool
+	sync.Mutex
+}
+
+func (h *heartbeatRecord) Key() []string {
+	return []string{heartbeatKeyPrefix, h.id}
+}
+
+func (h *heartbeatRecord) KeyPrefix() []string {
+	return []string{heartbeatKeyPrefix}
+}
+
+func (h *heartbeatRecord) Value() []byte {
+	h.Lock()
+	defer h.Unlock()
+
+	b, err := json.Marshal(h)
+	if err != nil {
+		return nil
+	}
+	return b
+}
+
+func (h *heartbeatRecord) SetValue(value []byte) error {
+	h.Lock()
+	defer h.Unlock()
+
+	return json.Unmarshal(value, h)
+}
+
+func (h *heartbeatRecord) Index() uint64 {
+	h.Lock()
+	defer h.Unlock()
+	return h.dbIndex
+}
+
+func (h *heartbeatRecord) SetIndex(index uint64) {
+	h.Lock()
+	h.dbIndex = index
+	h.dbExists = true
+	h.Unlock()
+}
+
+func (h *heartbeatRecord) Exists() bool {
+	h.Lock()
+	defer h.Unlock()
+	return h.dbExists
+}
+
+func (h *heartbeatRecord) Skip() bool {
+	return false
+}
+
+func (h *heartbeatRecord) New() datastore.KVObject {
+	return &heartbeatRecord{}
+}
+
+func (h *heartbeatRecord) CopyTo(o datastore.KVObject) error {
+	h.Lock()
+	defer h.Unlock()
+
+	dst := o.(*heartbeatRecord)
+	dst.id = h.id
+	dst.Address = h.Address
+	dst.Time = h.Time
+	dst.dbIndex = h.dbIndex
+	dst.dbExists = h.dbExists
+
+	return nil
+}
+
+func (h *heartbeatRecord) DataScope() string {
+	return datastore.GlobalScope
+}
+
+// partitionSignals are the observations a partition is suspected from
+type partitionSignals struct {
+	// storeDown is set once the heartbeat could not be written for
+	// longer than its TTL
+	storeDown bool
+	// peers is the number of gossip peers with a heartbeat, stale the
+	// ones of them whose heartbeat is stale
+	peers, stale int
+	// cluster is the number of gossip peers, failed the ones of them
+	// which recently failed
+	cluster, failed int
+}
+
+// reasons returns the signals of a partition, none when healthy
+func (s partitionSignals) reasons(ratio float64) []string {
+	var reasons []string
+	if s.storeDown {
+		reasons = append(reasons, "global datastore unreachable")
+	}
+	if s.peers > 0 && float64(s.stale) >= ratio*float64(s.peers) {
+		reasons = append(reasons, fmt.Sprintf("%d of %d peer heartbeats stale", s.stale, s.peers))
+	}
+	if s.cluster > 0 && s.failed > 0 && float64(s.failed) >= ratio*float64(s.cluster) {
+		reasons = append(reasons, fmt.Sprintf("%d of %d gossip peers failed", s.failed, s.cluster))
+	}
+	return reasons
+}
+
+// heldUpdate is an update of the endpoint table held while fenced
+type heldUpdate struct {
+	nid, eid string
+	ev       events.Event
+}
+
+// splitBrainDetector tracks the signals of the partitions and holds the
+// updates of the service bindings while fenced
+type splitBrainDetector struct {
+	sync.Mutex
+	policy config.SplitBrainPolicy
+	status PartitionStatus
+	// lastWrite is the last heartbeat written to the global datastore
+	lastWrite time.Time
+	// healthySince is when the signals got clear while fenced
+	healthySince time.Time
+	// failedSince is when each gossip peer was first seen failed
+	failedSince map[string]time.Time
+	held        map[string]heldUpdate
+	order       []string
+}
+
+func newSplitBrainDetector(p config.SplitBrainPolicy) *splitBrainDetector {
+	if p.Fencing == "" {
+		p.Fencing = fencingNone
+	}
+	if p.HeartbeatInterval <= 0 {
+		p.HeartbeatInterval = defaultHeartbeatInterval
+	}
+	if p.HeartbeatTTL <= 0 {
+		p.HeartbeatTTL = 3 * p.HeartbeatInterval
+	}
+	if p.NodeRatio <= 0 || p.NodeRatio > 1 {
+		p.NodeRatio = defaultPartitionRatio
+	}
+	if p.HealGrace <= 0 {
+		p.HealGrace = 2 * p.HeartbeatInterval
+	}
+	if p.MaxFence <= 0 {
+		p.MaxFence = defaultMaxFence
+	}
+	return &splitBrainDetector{
+		policy:      p,
+		status:      PartitionStatus{StoreReachable: true, Since: time.Now()},
+		lastWrite:   time.Now(),
+		failedSince: map[string]time.Time{},
+		held:        map[string]heldUpdate{},
+	}
+}
+
+func validateSplitBrainPolicy(p *config.SplitBrainPolicy) error {
+	switch p.Fencing {
+	case "", fencingNone, fencingFreeze:
+		return nil
+	}
+	return fmt.Errorf("invalid fencing policy %q of the partitions, expected %s or %s", p.Fencing, fencingNone, fencingFreeze)
+}
+
+func (c *controller) startSplitBrainDetection() error {
+	p := c.cfg.Daemon.SplitBrain
+	if err := validateSplitBrainPolicy(p); err != nil {
+		return err
+	}
+	c.splitBrain = newSplitBrainDetector(*p)
+	c.splitBrainStop = make(chan struct{})
+	go c.runSplitBrainDetection(c.splitBrain, c.splitBrainStop)
+	return nil
+}
+
+func (c *controller) runSplitBrainDetection(d *splitBrainDetector, stopCh chan struct{}) {
+	ticker := time.NewTicker(d.policy.HeartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			c.checkPartition(d, time.Now())
+		case <-stopCh:
+			return
+		}
+	}
+}
+
+// stopSplitBrainDetection stops the detection and withdraws the heartbeat
+// of the controller, for the peers not to take it as cut off
+func (c *controller) stopSplitBrainDetection() {
+	close(c.splitBrainStop)
+	if store := c.getStore(datastore.GlobalScope); store != nil {
+		if err := store.DeleteObject(&heartbeatRecord{id: c.id}); err != nil && err != datastore.ErrKeyNotFound {
+			logrus.Debugf("Failed to delete the heartbeat of the controller: %v", err)
+		}
+	}
+}
+
+// checkPartition writes the heartbeat of the controller, collects the
+// signals of a partition and fences or heals
+func (c *controller) checkPartition(d *splitBrainDetector, now time.Time) {
+	var (
+		signals partitionSignals
+		stale   []string
+		failed  []string
+	)
+
+	reachable := true
+	var heartbeats []*heartbeatRecord
+	if store := c.getStore(datastore.GlobalScope); store != nil {
+		if err := c.writeHeartbeat(now); err != nil {
+			logrus.Debugf("Failed to write the heartbeat of the controller: %v", err)
+			reachable = false
+		} else {
+			kvol, err := store.List(datastore.Key(heartbeatKeyPrefix), &heartbeatRecord{})
+			if err != nil && err != datastore.ErrKeyNotFound {
+				reachable = false
+			}
+			for _, kvo := range kvol {
+				heartbeats = append(heartbeats, kvo.(*heartbeatRecord))
+			}
+		}
+	}
+
+	var active []networkdb.PeerInfo
+	if a := c.getAgent(); a != nil {
+		active = a.networkDB.ClusterPeers()
+		seen := map[string]bool{}
+		for _, p := range a.networkDB.FailedPeers() {
+			seen[p.Name] = true
+			d.Lock()
+			since, ok := d.failedSince[p.Name]
+			if !ok {
+				since = now
+				d.failedSince[p.Name] = now
+			}
+			d.Unlock()
+			// The peers failed for longer than the fence are gone
+			if now.Sub(since) < d.policy.MaxFence {
+				failed = append(failed, p.IP)
+			}
+		}
+		d.Lock()
+		for name := range d.failedSince {
+			if !seen[name] {
+				delete(d.failedSince, name)
+			}
+		}
+		d.Unlock()
+	}
+
+	// The heartbeats are matched to the gossip peers by address, a peer
+	// alive in the cluster with a stale heartbeat being cut off from the
+	// global datastore
+	alive := map[string]bool{}
+	for _, p := range active {
+		alive[p.IP] = true
+	}
+	self := ""
+	if a := c.getAgent(); a != nil {
+		self = a.advertiseAddr
+	}
+	for _, h := range heartbeats {
+		if h.Address == "" || h.Address == self || !alive[h.Address] {
+			continue
+		}
+		signals.peers++
+		if now.Sub(h.Time) > d.policy.HeartbeatTTL {
+			signals.stale++
+			stale = append(stale, h.Address)
+		}
+	}
+	sort.Strings(stale)
+	sort.Strings(failed)
+
+	d.Lock()
+	if reachable {
+		d.lastWrite = now
+	}
+	signals.storeDown = now.Sub(d.lastWrite) > d.policy.HeartbeatTTL
+	// The active peers include the local node
+	signals.cluster, signals.failed = len(active)+len(failed)-1, len(failed)
+	if signals.cluster < 0 {
+		signals.cluster = 0
+	}
+	reasons := signals.reasons(d.policy.NodeRatio)
+	d.status.StoreReachable = reachable
+	d.status.StalePeers, d.status.FailedPeers = stale, failed
+	d.Unlock()
+
+	c.updatePartition(d, reasons, now)
+}
+
+func (c *controller) writeHeartbeat(now time.Time) error {
+	addr := ""
+	if a := c.getAgent(); a != nil {
+		addr = a.advertiseAddr
+	}
+	store := c.getStore(datastore.GlobalScope)
+	for {
+		h := &heartbeatRecord{id: c.id}
+		if err := store.GetObject(datastore.Key(h.Key()...), h); err != nil && err != datastore.ErrKeyNotFound {
+			return err
+		}
+		h.id, h.Address, h.Time = c.id, addr, now.UTC()
+		if err := c.updateToStore(h); err != datastore.ErrKeyModified {
+			return err
+		}
+	}
+}
+
+// updatePartition moves the detector to the partitioned state on the
+// first signal and fences per the policy, then back to healthy once the
+// signals have been clear for the grace period or the fence lasted past
+// its bound, applying the held updates
+func (c *controller) updatePartition(d *splitBrainDetector, reasons []string, now time.Time) {
+	d.Lock()
+	if len(reasons) > 0 {
+		d.healthySince = time.Time{}
+		d.status.Reasons = reasons
+		if !d.status.Partitioned {
+			d.status.Partitioned, d.status.Since = true, now
+			d.status.Fenced = d.policy.Fencing == fencingFreeze
+			fenced := d.status.Fenced
+			d.Unlock()
+			logrus.Warnf("Partition suspected (%s), fenced: %t", strings.Join(reasons, "; "), fenced)
+			c.publish(Event{Type: EventPartitionDetected, Reason: strings.Join(reasons, "; ")})
+			return
+		}
+		if !d.status.Fenced || now.Sub(d.status.Since) < d.policy.MaxFence {
+			d.Unlock()
+			return
+		}
+		logrus.Warnf("Partition lasting past %v, applying the held service binding updates", d.policy.MaxFence)
+	} else {
+		if !d.status.Partitioned {
+			d.Unlock()
+			return
+		}
+		if d.healthySince.IsZero() {
+			d.healthySince = now
+		}
+		if now.Sub(d.healthySince) < d.policy.HealGrace {
+			d.Unlock()
+			return
+		}
+		d.status.Partitioned, d.status.Since, d.status.Reasons = false, now, nil
+	}
+	d.status.Fenced = false
+	held := make([]heldUpdate, 0, len(d.order))
+	for _, k := range d.order {
+		if u, ok := d.held[k]; ok {
+			held = append(held, u)
+		}
+	}
+	d.held, d.order = map[string]heldUpdate{}, nil
+	d.status.HeldUpdates = 0
+	partitioned := d.status.Partitioned
+	d.Unlock()
+
+	applied := c.releaseHeldUpdates(held)
+	if !partitioned {
+		logrus.Infof("Partition healed, %d of %d held service binding updates applied", applied, len(held))
+		c.publish(Event{Type: EventPartitionHealed, Count: uint64(applied)})
+	}
+}
+
+// holdServiceBinding holds the update of the service binding of the
+// endpoint of a global network while fenced, telling whether it did. The
+// last update of an endpoint replaces the previous ones, a removal
+// cancelling an addition held.
+func (c *controller) holdServiceBinding(nid, eid string, ev events.Event) bool {
+	d := c.splitBrain
+	if d == nil {
+		return false
+	}
+	if n, err := c.NetworkByID(nid); err == nil && n.Info().Scope() == datastore.LocalScope {
+		return false
+	}
+	d.Lock()
+	defer d.Unlock()
+	if !d.status.Fenced {
+		return false
+	}
+	k := nid + "/" + eid
+	prev, ok := d.held[k]
+	if _, del := ev.(networkdb.DeleteEvent); del && ok {
+		if _, add := prev.ev.(networkdb.CreateEvent); add {
+			delete(d.held, k)
+			d.status.HeldUpdates = len(d.held)
+			return true
+		}
+	}
+	if !ok {
+		d.order = append(d.order, k)
+	}
+	d.held[k] = heldUpdate{nid: nid, eid: eid, ev: ev}
+	d.status.HeldUpdates = len(d.held)
+	return true
+}
+
+// releaseHeldUpdates applies the held updates which still hold against
+// the endpoint table: the removal of a backend which is back is dropped,
+// as the addition of one which is gone again, and returns the number
+// applied
+func (c *controller) releaseHeldUpdates(held []heldUpdate) int {
+	a := c.getAgent()
+	var applied int
+	for _, u := range held {
+		present := false
+		if a != nil {
+			_, err := a.networkDB.GetEntry(libnetworkEPTable, u.nid, u.eid)
+			present = err == nil
+		}
+		switch u.ev.(type) {
+		case networkdb.DeleteEvent:
+			if present {
+				continue
+			}
+		default:
+			if !present {
+				continue
+			}
+		}
+		c.handleEpTableEvent(u.ev)
+		applied++
+	}
+	return applied
+}
+
+// PartitionStatus returns the state of the detection of the partitions,
+// nil when it is not enabled
+func (c *controller) PartitionStatus() *PartitionStatus {
+	d := c.splitBrain
+	if d == nil {
+		return nil
+	}
+	d.Lock()
+	defer d.Unlock()
+	s := d.status
+	s.Reasons = append([]string(nil), s.Reasons...)
+	return &s
+}
+
+func partitionDiag(ctx interface{}, w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	diagnostic.DebugHTTPForm(r)
+	_, json := diagnostic.ParseHTTPFormOptions(r)
+
+	// audit logs
+	log := logrus.WithFields(logrus.Fields{"component": "diagnostic", "remoteIP": r.RemoteAddr, "method": caller.Name(0), "url": r.URL.String()})
+	log.Info("partition status")
+
+	c, ok := ctx.(*controller)
+	if !ok {
+		diagnostic.HTTPReply(w, diagnostic.FailCommand(fmt.Errorf("controller not available")), json)
+		return
+	}
+
+	s := c.PartitionStatus()
+	if s == nil {
+		diagnostic.HTTPReply(w, diagnostic.FailCommand(fmt.Errorf("the partition detection is not enabled")), json)
+		return
+	}
+	diagnostic.HTTPReply(w, diagnostic.CommandSucceed(s), json)
+}
@@ -0,0 +1,181 @@
+package libnetwork
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/docker/libnetwork/diagnostic"
+	"github.com/docker/libnetwork/driverapi"
+	"github.com/docker/libnetwork/internal/caller"
+	"github.com/docker/libnetwork/types"
+	"github.com/sirupsen/logrus"
+)
+
+// statisticsPaths2Func are the diagnostic handlers of the sandbox statistics
+var statisticsPaths2Func = map[string]diagnostic.HTTPHandlerFunc{
+	"/statistics":        statisticsDiag,
+	"/networkstatistics": networkStatisticsDiag,
+}
+
+// statisticsResult is the diagnostic output of the sandbox statistics
+type statisticsResult struct {
+	Sandboxes map[string]map[string]*types.InterfaceStatistics `json:"sandboxes"`
+}
+
+func (r *statisticsResult) String() string {
+	sids := make([]string, 0, len(r.Sandboxes))
+	for sid := range r.Sandboxes {
+		sids = append(sids, sid)
+	}
+	sort.Strings(sids)
+
+	var b strings.Builder
+	for _, sid := range sids {
+		ifaces := make([]string, 0, len(r.Sandboxes[sid]))
+		for name := range r.Sandboxes[sid] {
+			ifaces = append(ifaces, name)
+		}
+		sort.Strings(ifaces)
+		for _, name := range ifaces {
+			fmt.Fprintf(&b, "sid:%s iface:%s%s\n", sid, name, r.Sandboxes[sid][name])
+		}
+	}
+	return b.String()
+}
+
+// networkStatisticsResult is the diagnostic output of the driver counters
+// of a network
+type networkStatisticsResult struct {
+	NetworkID string            `json:"network_id"`
+	Counters  map[string]uint64 `json:"counters"`
+}
+
+func (r *networkStatisticsResult) String() string {
+	names := make([]string, 0, len(r.Counters))
+	for name := range r.Counters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&b, "nid:%s %s:%d\n", r.NetworkID, name, r.Counters[name])
+	}
+	return b.String()
+}
+
+// networkStatistics returns the counters the driver of the network keeps
+// for it
+func (c *controller) networkStatistics(nid string) (map[string]uint64, error) {
+	nw, err := c.NetworkByID(nid)
+	if err != nil {
+		return nil, err
+	}
+	d, err := nw.(*network).driver(true)
+	if err != nil {
+		return nil, err
+	}
+	s, ok := d.(driverapi.NetworkStatistician)
+	if !ok {
+		return nil, types.NotImplementedErrorf("the %s driver keeps no network statistics", nw.Type())
+	}
+	return s.NetworkStatistics(nw.ID())
+}
+
+// Statistics returns the statistics of the interfaces of every sandbox,
+// keyed by sandbox ID and then by interface name in the sandbox. The
+// counters are read over netlink in the namespace of each sandbox.
+func (c *controller) Statistics() (map[string]map[string]*types.InterfaceStatistics, error) {
+	c.Lock()
+	sboxes := make([]*sandbox, 0, len(c.sandboxes))
+	for _, sb := range c.sandboxes {
+		sboxes = append(sboxes, sb)
+	}
+	c.Unlock()
+
+	stats := make(map[string]map[string]*types.InterfaceStatistics, len(sboxes))
+	for _, sb := range sboxes {
+		s, err := sb.Statistics()
+		if err != nil {
+			logrus.Warnf("Failed to get the statistics of sandbox %.7s: %v", sb.ID(), err)
+			continue
+		}
+		stats[sb.ID()] = s
+	}
+	return stats, nil
+}
+
+func statisticsDiag(ctx interface{}, w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	diagnostic.DebugHTTPForm(r)
+	_, json := diagnostic.ParseHTTPFormOptions(r)
+
+	// audit logs
+	log := logrus.WithFields(logrus.Fields{"component": "diagnostic", "remoteIP": r.RemoteAddr, "method": caller.Name(0), "url": r.URL.String()})
+	log.Info("sandbox statistics")
+
+	c, ok := ctx.(*controller)
+	if !ok {
+		diagnostic.HTTPReply(w, diagnostic.FailCommand(fmt.Errorf("controller not available")), json)
+		return
+	}
+
+	res := &statisticsResult{Sandboxes: map[string]map[string]*types.InterfaceStatistics{}}
+	if sid := r.Form.Get("sid"); sid != "" {
+		sb, err := c.SandboxByID(sid)
+		if err != nil {
+			log.WithError(err).Error("sandbox statistics failed")
+			diagnostic.HTTPReply(w, diagnostic.FailCommand(err), json)
+			return
+		}
+		s, err := sb.Statistics()
+		if err != nil {
+			log.WithError(err).Error("sandbox statistics failed")
+			diagnostic.HTTPReply(w, diagnostic.FailCommand(err), json)
+			return
+		}
+		res.Sandboxes[sid] = s
+	} else {
+		stats, err := c.Statistics()
+		if err != nil {
+			log.WithError(err).Error("sandbox statistics failed")
+			diagnostic.HTTPReply(w, diagnostic.FailCommand(err), json)
+			return
+		}
+		res.Sandboxes = stats
+	}
+	log.Info("sandbox statistics done")
+	diagnostic.HTTPReply(w, diagnostic.CommandSucceed(res), json)
+}
+
+func networkStatisticsDiag(ctx interface{}, w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	diagnostic.DebugHTTPForm(r)
+	_, json := diagnostic.ParseHTTPFormOptions(r)
+
+	// audit logs
+	log := logrus.WithFields(logrus.Fields{"component": "diagnostic", "remoteIP": r.RemoteAddr, "method": caller.Name(0), "url": r.URL.String()})
+	log.Info("network statistics")
+
+	c, ok := ctx.(*controller)
+	if !ok {
+		diagnostic.HTTPReply(w, diagnostic.FailCommand(fmt.Errorf("controller not available")), json)
+		return
+	}
+
+	nid := r.Form.Get("nid")
+	if nid == "" {
+		diagnostic.HTTPReply(w, diagnostic.WrongCommand("networkstatistics", "nid=<network id>"), json)
+		return
+	}
+	counters, err := c.networkStatistics(nid)
+	if err != nil {
+		log.WithError(err).Error("network statistics failed")
+		diagnostic.HTTPReply(w, diagnostic.FailCommand(err), json)
+		return
+	}
+	log.Info("network statistics done")
+	diagnostic.HTTPReply(w, diagnostic.CommandSucceed(&networkStatisticsResult{NetworkID: nid, Counters: counters}), json)
+}
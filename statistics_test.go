@@ -0,0 +1,153 @@
+package libnetwork
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/docker/libnetwork/datastore"
+	"github.com/docker/libnetwork/driverapi"
+	"github.com/docker/libnetwork/osl"
+	"github.com/docker/libnetwork/types"
+)
+
+// statsSandbox is an OS sandbox with the interfaces given
+type statsSandbox struct {
+	osl.Sandbox
+	ifaces []osl.Interface
+}
+
+func (s *statsSandbox) Info() osl.Info {
+	return &statsInfo{ifaces: s.ifaces}
+}
+
+type statsInfo struct {
+	osl.Info
+	ifaces []osl.Interface
+}
+
+func (i *statsInfo) Interfaces() []osl.Interface {
+	return i.ifaces
+}
+
+// statsInterface is an OS sandbox interface with the statistics given, or
+// failing to read them when they are nil
+type statsInterface struct {
+	osl.Interface
+	name  string
+	stats *types.InterfaceStatistics
+}
+
+func (i *statsInterface) DstName() string {
+	return i.name
+}
+
+func (i *statsInterface) Statistics() (*types.InterfaceStatistics, error) {
+	if i.stats == nil {
+		return nil, fmt.Errorf("I will not read the statistics of %s", i.name)
+	}
+	return i.stats, nil
+}
+
+func TestStatistics(t *testing.T) {
+	c := &controller{sandboxes: sandboxTable{
+		"sb1": {id: "sb1", osSbox: &statsSandbox{ifaces: []osl.Interface{
+			&statsInterface{name: "eth1", stats: &types.InterfaceStatistics{RxBytes: 20, TxBytes: 10}},
+			&statsInterface{name: "eth0", stats: &types.InterfaceStatistics{RxBytes: 200, TxErrors: 3}},
+		}}},
+		"sb2": {id: "sb2", osSbox: &statsSandbox{ifaces: []osl.Interface{
+			&statsInterface{name: "eth0"},
+		}}},
+		"sb3": {id: "sb3"},
+	}}
+
+	stats, err := c.Statistics()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stats) != 2 || len(stats["sb1"]) != 2 || stats["sb1"]["eth0"].TxErrors != 3 || stats["sb1"]["eth1"].RxBytes != 20 {
+		t.Fatalf("unexpected statistics %v", stats)
+	}
+	if _, ok := stats["sb2"]; ok {
+		t.Fatal("statistics of the sandbox failing to read them reported")
+	}
+	if s, ok := stats["sb3"]; !ok || len(s) != 0 {
+		t.Fatalf("unexpected statistics %v of the sandbox without namespace", s)
+	}
+
+	// The output is sorted by sandbox and then by interface
+	out := (&statisticsResult{Sandboxes: stats}).String()
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if len(lines) != 4 || lines[0] != "sid:sb1 iface:eth0" || lines[2] != "sid:sb1 iface:eth1" ||
+		!strings.HasPrefix(lines[1], "RxBytes: 200,") || !strings.Contains(lines[3], "TxBytes: 10,") {
+		t.Fatalf("unexpected output:\n%s", out)
+	}
+
+	// A sandbox is reported alone when asked for
+	w := httptest.NewRecorder()
+	statisticsDiag(c, w, httptest.NewRequest("GET", "/statistics?sid=sb1", nil))
+	if body := w.Body.String(); !strings.Contains(body, "sid:sb1 iface:eth0") || strings.Contains(body, "sb3") {
+		t.Fatalf("unexpected reply %s", body)
+	}
+}
+
+var statsDriverName = "statistics network driver"
+
+// statsDriver keeps the counters given for its networks
+type statsDriver struct {
+	deletableDriver
+	counters map[string]uint64
+}
+
+func (d *statsDriver) Type() string {
+	return statsDriverName
+}
+
+func (d *statsDriver) NetworkStatistics(nid string) (map[string]uint64, error) {
+	return d.counters, nil
+}
+
+func TestNetworkStatistics(t *testing.T) {
+	cfgOptions, err := OptionBoltdbWithRandomDBFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	nc, err := New(cfgOptions...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nc.Stop()
+	c := nc.(*controller)
+	addDeletableDriver(t, c)
+	sd := &statsDriver{counters: map[string]uint64{"tx_drops": 4, "arp_replies": 12}}
+	err = c.drvRegistry.AddDriver(statsDriverName, func(reg driverapi.DriverCallback, opt map[string]interface{}) error {
+		return reg.RegisterDriver(statsDriverName, sd, driverapi.Capability{DataScope: datastore.LocalScope})
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := c.NewNetwork(statsDriverName, "counted", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	counters, err := c.networkStatistics(n.ID())
+	if err != nil || counters["tx_drops"] != 4 {
+		t.Fatalf("unexpected counters %v: %v", counters, err)
+	}
+	out := (&networkStatisticsResult{NetworkID: "n1", Counters: counters}).String()
+	if out != "nid:n1 arp_replies:12\nnid:n1 tx_drops:4\n" {
+		t.Fatalf("unexpected output:\n%s", out)
+	}
+
+	other, err := c.NewNetwork(deletableDriverName, "uncounted", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.networkStatistics(other.ID()); err == nil {
+		t.Fatal("statistics of a driver keeping none")
+	} else if _, ok := err.(types.NotImplementedError); !ok {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
@@ -9,14 +9,32 @@ import (
 	"github.com/docker/libkv/store/etcd"
 	"github.com/docker/libkv/store/zookeeper"
 	"github.com/docker/libnetwork/datastore"
+	"github.com/docker/libnetwork/datastore/etcdv3"
+	"github.com/docker/libnetwork/datastore/kubestore"
 	"github.com/sirupsen/logrus"
 )
 
+// schemaMigrations are the schema versions of the state of the controller,
+// the drivers and the IPAM registering theirs from their packages
+var schemaMigrations = []datastore.Migration{
+	{Component: "libnetwork", Version: 1, Description: "initial schema"},
+}
+
+func init() {
+	for _, m := range schemaMigrations {
+		if err := datastore.RegisterMigration(m); err != nil {
+			panic(err)
+		}
+	}
+}
+
 func registerKVStores() {
 	consul.Register()
 	zookeeper.Register()
 	etcd.Register()
+	etcdv3.Register()
 	boltdb.Register()
+	kubestore.Register()
 }
 
 func (c *controller) initScopedStore(scope string, scfg *datastore.ScopeCfg) error {
@@ -24,6 +42,11 @@ func (c *controller) initScopedStore(scope string, scfg *datastore.ScopeCfg) err
 	if err != nil {
 		return err
 	}
+	// The state is migrated before anything reads it
+	if err := datastore.Migrate(store); err != nil {
+		store.Close()
+		return err
+	}
 	c.Lock()
 	c.stores = append(c.stores, store)
 	c.Unlock()
@@ -187,6 +210,13 @@ func (c *controller) getNetworksFromStore() ([]*network, error) {
 }
 
 func (n *network) getEndpointFromStore(eid string) (*endpoint, error) {
+	if ep, ok := n.ctrlr.writeBehindEndpointGet(n, eid); ok {
+		if ep == nil {
+			return nil, fmt.Errorf("could not find endpoint %s: deleted", eid)
+		}
+		return ep, nil
+	}
+
 	var errors []string
 	for _, store := range n.ctrlr.getStores() {
 		ep := &endpoint{id: eid, network: n}
@@ -225,10 +255,14 @@ func (n *network) getEndpointsFromStore() ([]*endpoint, error) {
 		}
 	}
 
-	return epl, nil
+	return n.getController().writeBehindEndpoints(n, epl), nil
 }
 
 func (c *controller) updateToStore(kvObject datastore.KVObject) error {
+	if ep, ok := kvObject.(*endpoint); ok && c.writeBehindEndpoint(ep, false) {
+		return nil
+	}
+
 	cs := c.getStore(kvObject.DataScope())
 	if cs == nil {
 		return ErrDataStoreNotInitialized(kvObject.DataScope())
@@ -245,6 +279,10 @@ func (c *controller) updateToStore(kvObject datastore.KVObject) error {
 }
 
 func (c *controller) deleteFromStore(kvObject datastore.KVObject) error {
+	if ep, ok := kvObject.(*endpoint); ok && c.writeBehindEndpoint(ep, true) {
+		return nil
+	}
+
 	cs := c.getStore(kvObject.DataScope())
 	if cs == nil {
 		return ErrDataStoreNotInitialized(kvObject.DataScope())
@@ -1,11 +1,20 @@
 package libnetwork
 
 import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"runtime"
 	"testing"
+	"time"
 
 	"github.com/docker/libkv/store"
+	"github.com/docker/libnetwork/config"
 	"github.com/docker/libnetwork/datastore"
+	"github.com/docker/libnetwork/ns"
+	"github.com/docker/libnetwork/webhook"
 )
 
 func TestBoltdbBackend(t *testing.T) {
@@ -43,3 +52,142 @@ func TestNoPersist(t *testing.T) {
 	}
 	store.Close()
 }
+
+func TestSnapshot(t *testing.T) {
+	cfgOptions, err := OptionBoltdbWithRandomDBFile()
+	if err != nil {
+		t.Fatalf("Error creating random boltdb file : %v", err)
+	}
+	ctrl, err := New(cfgOptions...)
+	if err != nil {
+		t.Fatalf("Error new controller: %v", err)
+	}
+	nw, err := ctrl.NewNetwork("host", "host", "")
+	if err != nil {
+		t.Fatalf("Error creating default \"host\" network: %v", err)
+	}
+	ep, err := nw.CreateEndpoint("newendpoint")
+	if err != nil {
+		t.Fatalf("Error creating endpoint: %v", err)
+	}
+	var snap bytes.Buffer
+	if err := ctrl.ExportSnapshot(&snap); err != nil {
+		t.Fatal(err)
+	}
+	ctrl.Stop()
+
+	cfgOptions, err = OptionBoltdbWithRandomDBFile()
+	if err != nil {
+		t.Fatalf("Error creating random boltdb file : %v", err)
+	}
+	ctrl, err = New(cfgOptions...)
+	if err != nil {
+		t.Fatalf("Error new controller: %v", err)
+	}
+	if err := ctrl.ImportSnapshot(&snap); err != nil {
+		t.Fatal(err)
+	}
+	// The endpoints out of an active sandbox are cleaned up on restart
+	epKey := datastore.Key(datastore.EndpointKeyPrefix, nw.ID(), ep.ID())
+	if exists, err := ctrl.(*controller).getStore(datastore.LocalScope).KVStore().Exists(epKey); err != nil || !exists {
+		t.Fatalf("Endpoint should have been imported: %v", err)
+	}
+	ctrl.Stop()
+
+	ctrl, err = New(cfgOptions...)
+	if err != nil {
+		t.Fatalf("Error new controller: %v", err)
+	}
+	defer ctrl.Stop()
+	if _, err := ctrl.NetworkByID(nw.ID()); err != nil {
+		t.Fatalf("Network should have been imported: %v", err)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	cfgOptions, err := OptionBoltdbWithRandomDBFile()
+	if err != nil {
+		t.Fatalf("Error creating random boltdb file : %v", err)
+	}
+	ctrl, err := New(cfgOptions...)
+	if err != nil {
+		t.Fatalf("Error new controller: %v", err)
+	}
+	defer ctrl.Stop()
+	addDeletableDriver(t, ctrl)
+
+	ch, cancel := ctrl.Watch(EventNetworkCreate, EventNetworkDelete)
+	defer cancel()
+
+	nw, err := ctrl.NewNetwork(deletableDriverName, "watched", "")
+	if err != nil {
+		t.Fatalf("Error creating network: %v", err)
+	}
+	if err := nw.Delete(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expected := range []EventType{EventNetworkCreate, EventNetworkDelete} {
+		select {
+		case ev := <-ch.C:
+			e := ev.(Event)
+			if e.Type != expected || e.NetworkID != nw.ID() || e.NetworkName != "watched" {
+				t.Fatalf("Unexpected event %+v, expected %s", e, expected)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out waiting for the %s event", expected)
+		}
+	}
+}
+
+func TestWebhooks(t *testing.T) {
+	// The receiver listens on the loopback of the initial namespace, not
+	// of one some earlier test left the thread in
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	if err := ns.SetNamespace(); err != nil {
+		t.Fatal(err)
+	}
+
+	posted := make(chan Event, 2)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var e Event
+		if err := json.NewDecoder(r.Body).Decode(&e); err == nil && r.Header.Get(webhook.HeaderEvent) == string(e.Type) {
+			posted <- e
+		}
+	}))
+	defer srv.Close()
+
+	cfgOptions, err := OptionBoltdbWithRandomDBFile()
+	if err != nil {
+		t.Fatalf("Error creating random boltdb file : %v", err)
+	}
+	cfgOptions = append(cfgOptions, config.OptionWebhook(webhook.Target{
+		Name: "inventory", URL: srv.URL, Types: []string{string(EventNetworkCreate), string(EventNetworkDelete)},
+	}))
+	ctrl, err := New(cfgOptions...)
+	if err != nil {
+		t.Fatalf("Error new controller: %v", err)
+	}
+	defer ctrl.Stop()
+	addDeletableDriver(t, ctrl)
+
+	nw, err := ctrl.NewNetwork(deletableDriverName, "hooked", "")
+	if err != nil {
+		t.Fatalf("Error creating network: %v", err)
+	}
+	if err := nw.Delete(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expected := range []EventType{EventNetworkCreate, EventNetworkDelete} {
+		select {
+		case e := <-posted:
+			if e.Type != expected || e.NetworkID != nw.ID() {
+				t.Fatalf("Unexpected posted event %+v, expected %s", e, expected)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out waiting for the %s event to be posted", expected)
+		}
+	}
+}
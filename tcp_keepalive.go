@@ -0,0 +1,122 @@
+package libnetwork
+
+import (
+	"strconv"
+
+	"github.com/docker/libnetwork/labelparse"
+	"github.com/docker/libnetwork/netlabel"
+	"github.com/docker/libnetwork/types"
+)
+
+// tcpKeepaliveSysctls are the sysctls of the netlabel.TCPKeepalive
+// settings, with the largest values the kernel accepts
+var tcpKeepaliveSysctls = map[string]struct {
+	sysctl string
+	max    int
+}{
+	"time":   {"net.ipv4.tcp_keepalive_time", 32767},
+	"intvl":  {"net.ipv4.tcp_keepalive_intvl", 32767},
+	"probes": {"net.ipv4.tcp_keepalive_probes", 127},
+}
+
+// parseTCPKeepalive returns the sysctls of the netlabel.TCPKeepalive value
+func parseTCPKeepalive(v string) (map[string]string, error) {
+	kvs, err := labelparse.ParseKeyValues(netlabel.TCPKeepalive, v)
+	if err != nil {
+		return nil, err
+	}
+	sysctls := make(map[string]string, len(kvs))
+	for _, kv := range kvs {
+		s, ok := tcpKeepaliveSysctls[kv.Key]
+		if !ok {
+			return nil, types.BadRequestErrorf("invalid %s setting %q: expected time, intvl or probes",
+				netlabel.TCPKeepalive, kv.Key)
+		}
+		n, err := strconv.Atoi(kv.Value)
+		if err != nil || n < 1 || n > s.max {
+			return nil, types.BadRequestErrorf("invalid %s %s %q: expected between 1 and %d",
+				netlabel.TCPKeepalive, kv.Key, kv.Value, s.max)
+		}
+		sysctls[s.sysctl] = strconv.Itoa(n)
+	}
+	return sysctls, nil
+}
+
+func (n *network) validateTCPKeepalive() error {
+	if v, ok := n.labels[netlabel.TCPKeepalive]; ok {
+		_, err := parseTCPKeepalive(v)
+		return err
+	}
+	return nil
+}
+
+// tcpKeepalive returns the TCP keepalive sysctls of the endpoint, the ones
+// of its network overridden by its own
+func (ep *endpoint) tcpKeepalive() (map[string]string, error) {
+	sysctls := map[string]string{}
+	if n := ep.getNetwork(); n != nil {
+		if v, ok := n.Labels()[netlabel.TCPKeepalive]; ok {
+			s, err := parseTCPKeepalive(v)
+			if err != nil {
+				return nil, err
+			}
+			for k, v := range s {
+				sysctls[k] = v
+			}
+		}
+	}
+	ep.Lock()
+	v, ok := ep.generic[netlabel.TCPKeepalive].(string)
+	ep.Unlock()
+	if ok {
+		s, err := parseTCPKeepalive(v)
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range s {
+			sysctls[k] = v
+		}
+	}
+	return sysctls, nil
+}
+
+// tcpKeepalive returns the TCP keepalive sysctls of the sandbox, the
+// sysctls being namespace wide each is set by the endpoint of highest
+// priority setting it
+func (sb *sandbox) tcpKeepalive() (map[string]string, error) {
+	sysctls := map[string]string{}
+	for _, ep := range sb.getConnectedEndpoints() {
+		s, err := ep.tcpKeepalive()
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range s {
+			if _, ok := sysctls[k]; !ok {
+				sysctls[k] = v
+			}
+		}
+	}
+	return sysctls, nil
+}
+
+// setupTCPKeepalive sets the TCP keepalive sysctls the endpoints of the
+// sandbox ask for in its namespace, and sets back the ones none of them
+// asks for anymore
+func (sb *sandbox) setupTCPKeepalive() error {
+	want, err := sb.tcpKeepalive()
+	if err != nil {
+		return err
+	}
+	sb.Lock()
+	osSbox := sb.osSbox
+	saved := sb.savedKeepalive
+	sb.Unlock()
+	if osSbox == nil || len(want) == 0 && len(saved) == 0 {
+		return nil
+	}
+	saved, err = applyTCPKeepalive(osSbox, want, saved)
+	sb.Lock()
+	sb.savedKeepalive = saved
+	sb.Unlock()
+	return err
+}
@@ -0,0 +1,66 @@
+package libnetwork
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/docker/libnetwork/osl"
+	"github.com/sirupsen/logrus"
+)
+
+// applyTCPKeepalive writes the wanted sysctls in the namespace of the
+// sandbox, saving the values they had first, and writes back the saved
+// values of the ones not wanted anymore. It returns the values still saved.
+func applyTCPKeepalive(osSbox osl.Sandbox, want, saved map[string]string) (map[string]string, error) {
+	next := make(map[string]string, len(saved)+len(want))
+	for k, v := range saved {
+		next[k] = v
+	}
+	var err error
+	if ierr := osSbox.InvokeFunc(func() {
+		for k, orig := range next {
+			if _, ok := want[k]; ok {
+				continue
+			}
+			if e := writeNamespaceSysctl(k, orig); e != nil {
+				logrus.Warnf("Failed to set back %s to %s: %v", k, orig, e)
+				continue
+			}
+			delete(next, k)
+		}
+		for k, v := range want {
+			if _, ok := next[k]; !ok {
+				var orig string
+				if orig, err = readNamespaceSysctl(k); err != nil {
+					return
+				}
+				next[k] = orig
+			}
+			if err = writeNamespaceSysctl(k, v); err != nil {
+				return
+			}
+		}
+	}); ierr != nil {
+		return saved, ierr
+	}
+	return next, err
+}
+
+// namespaceSysctlPath returns the path of the sysctl under /proc/sys, which
+// is of the namespace of the thread opening it
+func namespaceSysctlPath(key string) string {
+	return filepath.Join("/proc/sys", strings.Replace(key, ".", "/", -1))
+}
+
+func readNamespaceSysctl(key string) (string, error) {
+	b, err := ioutil.ReadFile(namespaceSysctlPath(key))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+func writeNamespaceSysctl(key, value string) error {
+	return ioutil.WriteFile(namespaceSysctlPath(key), []byte(value), 0644)
+}
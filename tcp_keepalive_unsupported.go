@@ -0,0 +1,15 @@
+// +build !linux
+
+package libnetwork
+
+import (
+	"github.com/docker/libnetwork/osl"
+	"github.com/docker/libnetwork/types"
+)
+
+func applyTCPKeepalive(osSbox osl.Sandbox, want, saved map[string]string) (map[string]string, error) {
+	if len(want) != 0 {
+		return saved, types.NotImplementedErrorf("TCP keepalive settings are not supported on this platform")
+	}
+	return saved, nil
+}
@@ -0,0 +1,168 @@
+package libnetwork
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/docker/libnetwork/diagnostic"
+	"github.com/docker/libnetwork/internal/caller"
+	"github.com/docker/libnetwork/netlabel"
+	"github.com/docker/libnetwork/types"
+	"github.com/sirupsen/logrus"
+)
+
+// tenancyPaths2Func are the diagnostic handlers of the tenant resources
+var tenancyPaths2Func = map[string]diagnostic.HTTPHandlerFunc{
+	"/tenants": tenantsDiag,
+}
+
+// TenantResources are the networks, endpoints and sandboxes of a tenant,
+// the sandboxes being the ones with an endpoint on the tenant networks
+type TenantResources struct {
+	Tenant    string   `json:"tenant"`
+	Networks  []string `json:"networks"`
+	Endpoints []string `json:"endpoints"`
+	Sandboxes []string `json:"sandboxes"`
+}
+
+// tenantsResult is the diagnostic output of the tenant resources
+type tenantsResult struct {
+	Tenants []*TenantResources `json:"tenants"`
+}
+
+func (r *tenantsResult) String() string {
+	var b strings.Builder
+	for _, t := range r.Tenants {
+		b.WriteString(t.String())
+	}
+	return b.String()
+}
+
+func (r *TenantResources) String() string {
+	return fmt.Sprintf("tenant:%s networks:%s endpoints:%s sandboxes:%s\n", r.Tenant,
+		strings.Join(r.Networks, ","), strings.Join(r.Endpoints, ","), strings.Join(r.Sandboxes, ","))
+}
+
+// tenant returns the tenant owning the network, none when empty
+func (n *network) tenant() string {
+	n.Lock()
+	defer n.Unlock()
+	return n.labels[netlabel.Tenant]
+}
+
+// setupTenant checks the tenant of the network and the network name, which
+// the tenant prefixes, and hands the tenant over to the driver along its
+// options for the names of the kernel objects to be prefixed as well
+func (n *network) setupTenant() error {
+	tenant, ok := n.labels[netlabel.Tenant]
+	if !ok {
+		return nil
+	}
+	if !netlabel.IsValidTenant(tenant) {
+		return types.BadRequestErrorf("invalid tenant %q: expected a lowercase letter and up to 5 lowercase letters or digits", tenant)
+	}
+	if !strings.HasPrefix(n.name, tenant+"-") {
+		return types.BadRequestErrorf("invalid name %q for a network of tenant %s: it must start with %s-", n.name, tenant, tenant)
+	}
+
+	switch opts := n.generic[netlabel.GenericData].(type) {
+	case map[string]string:
+		if _, ok := opts[netlabel.Tenant]; !ok {
+			opts[netlabel.Tenant] = tenant
+		}
+	case map[string]interface{}:
+		if _, ok := opts[netlabel.Tenant]; !ok {
+			opts[netlabel.Tenant] = tenant
+		}
+	}
+	return nil
+}
+
+// Tenants returns the tenants owning networks
+func (c *controller) Tenants() []string {
+	seen := make(map[string]bool)
+	var tenants []string
+	for _, n := range c.Networks() {
+		if t := n.(*network).tenant(); t != "" && !seen[t] {
+			seen[t] = true
+			tenants = append(tenants, t)
+		}
+	}
+	sort.Strings(tenants)
+	return tenants
+}
+
+// WalkTenantNetworks walks the networks of the tenant, for them to be
+// managed as a whole
+func (c *controller) WalkTenantNetworks(tenant string, walker NetworkWalker) {
+	for _, n := range c.Networks() {
+		if n.(*network).tenant() == tenant && walker(n) {
+			return
+		}
+	}
+}
+
+// TenantResources returns the resources of the tenant, by name
+func (c *controller) TenantResources(tenant string) (*TenantResources, error) {
+	if !netlabel.IsValidTenant(tenant) {
+		return nil, types.BadRequestErrorf("invalid tenant %q", tenant)
+	}
+
+	res := &TenantResources{Tenant: tenant}
+	sandboxes := make(map[string]bool)
+	c.WalkTenantNetworks(tenant, func(nw Network) bool {
+		res.Networks = append(res.Networks, nw.Name())
+		for _, ep := range nw.Endpoints() {
+			res.Endpoints = append(res.Endpoints, nw.Name()+"/"+ep.Name())
+			if sb, ok := ep.(*endpoint).getSandbox(); ok && !sandboxes[sb.ContainerID()] {
+				sandboxes[sb.ContainerID()] = true
+				res.Sandboxes = append(res.Sandboxes, sb.ContainerID())
+			}
+		}
+		return false
+	})
+	if len(res.Networks) == 0 {
+		return nil, types.NotFoundErrorf("no network of tenant %s", tenant)
+	}
+
+	sort.Strings(res.Networks)
+	sort.Strings(res.Endpoints)
+	sort.Strings(res.Sandboxes)
+	return res, nil
+}
+
+func tenantsDiag(ctx interface{}, w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	diagnostic.DebugHTTPForm(r)
+	_, json := diagnostic.ParseHTTPFormOptions(r)
+
+	// audit logs
+	log := logrus.WithFields(logrus.Fields{"component": "diagnostic", "remoteIP": r.RemoteAddr, "method": caller.Name(0), "url": r.URL.String()})
+	log.Info("tenant resources")
+
+	c, ok := ctx.(*controller)
+	if !ok {
+		diagnostic.HTTPReply(w, diagnostic.FailCommand(fmt.Errorf("controller not available")), json)
+		return
+	}
+
+	tenants := c.Tenants()
+	if t := r.Form.Get("tenant"); t != "" {
+		tenants = []string{t}
+	}
+
+	result := &tenantsResult{}
+	for _, t := range tenants {
+		res, err := c.TenantResources(t)
+		if err != nil {
+			log.WithError(err).Error("tenant resources failed")
+			diagnostic.HTTPReply(w, diagnostic.FailCommand(err), json)
+			return
+		}
+		result.Tenants = append(result.Tenants, res)
+	}
+	log.Info("tenant resources done")
+	diagnostic.HTTPReply(w, diagnostic.CommandSucceed(result), json)
+}
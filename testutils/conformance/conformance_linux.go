@@ -0,0 +1,420 @@
+// Package conformance exercises the filtering of the traffic forwarded by a
+// network namespace end to end: hosts in their own namespaces are connected
+// to it by veth pairs and probe each other, the verdicts of the rules
+// programmed in it being asserted.
+//
+// Example usage:
+//
+//	defer testutils.SetupTestOSContext(t)()
+//	lab, err := conformance.NewLab()
+//	...
+//	defer lab.Close()
+//	web, _ := lab.AddHost("web", net.ParseIP("10.10.0.2"))
+//	db, _ := lab.AddHost("db", net.ParseIP("10.10.0.3"))
+//	// program the filter under test in the FORWARD chain of the namespace
+//	lab.Check(t, conformance.Case{From: web, To: db, Proto: "tcp", Port: 5432, Want: conformance.Allowed})
+package conformance
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"runtime"
+	"strconv"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netns"
+)
+
+// gatewayIP is the address of the lab on each of the host links, the hosts
+// route their traffic through
+var gatewayIP = net.IPv4(169, 254, 0, 1)
+
+// DefaultTimeout is the time a probe is given to be answered before the
+// traffic is considered denied
+const DefaultTimeout = 500 * time.Millisecond
+
+// carrierRetries and carrierRetryDelay bound the wait for the links of a
+// host to come up
+const (
+	carrierRetries    = 100
+	carrierRetryDelay = 10 * time.Millisecond
+)
+
+// hostNameMax keeps the names of the links of the lab, the host name
+// prefixed, within the interface name length
+const hostNameMax = 10
+
+// Verdict is the outcome of a probe
+type Verdict int
+
+const (
+	// Denied traffic is dropped or rejected
+	Denied Verdict = iota
+	// Allowed traffic reaches the destination and is answered
+	Allowed
+)
+
+func (v Verdict) String() string {
+	if v == Allowed {
+		return "allowed"
+	}
+	return "denied"
+}
+
+// Lab is the namespace the filter under test is programmed in, forwarding
+// the traffic between the hosts
+type Lab struct {
+	// Timeout bounds the probes, DefaultTimeout when zero
+	Timeout time.Duration
+	ns      netns.NsHandle
+	nlh     *netlink.Handle
+	hosts   []*Host
+	sync.Mutex
+}
+
+// Host is an address in its own namespace, connected to the lab
+type Host struct {
+	// Name is the name of the host, its link in the lab is named after
+	Name string
+	// IP is the address of the host
+	IP net.IP
+	// Link is the name of the link of the host in the lab, for the rules to
+	// match on
+	Link      string
+	ns        netns.NsHandle
+	listeners map[string]io.Closer
+	sync.Mutex
+}
+
+// Case is a probe and its expected verdict
+type Case struct {
+	// Name describes the case in the failures, the probe by default
+	Name  string
+	From  *Host
+	To    *Host
+	Proto string
+	Port  int
+	Want  Verdict
+}
+
+// NewLab returns a lab forwarding in the namespace of the calling thread,
+// which must stay locked to it, as after testutils.SetupTestOSContext
+func NewLab() (*Lab, error) {
+	ns, err := netns.Get()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the namespace of the lab: %v", err)
+	}
+	nlh, err := netlink.NewHandleAt(ns)
+	if err != nil {
+		ns.Close()
+		return nil, fmt.Errorf("failed to get a netlink handle on the lab: %v", err)
+	}
+	if err := ioutil.WriteFile("/proc/sys/net/ipv4/ip_forward", []byte("1"), 0644); err != nil {
+		nlh.Delete()
+		ns.Close()
+		return nil, fmt.Errorf("failed to enable the forwarding of the lab: %v", err)
+	}
+	return &Lab{ns: ns, nlh: nlh}, nil
+}
+
+// AddHost creates a namespace holding the address, connected to the lab by
+// a veth pair
+func (l *Lab) AddHost(name string, ip net.IP) (*Host, error) {
+	if len(name) == 0 || len(name) > hostNameMax {
+		return nil, fmt.Errorf("invalid host name %q: expected 1 to %d characters", name, hostNameMax)
+	}
+	if ip.To4() == nil {
+		return nil, fmt.Errorf("invalid host address %s: expected IPv4", ip)
+	}
+	h := &Host{Name: name, IP: ip.To4(), Link: "lab-" + name, listeners: map[string]io.Closer{}}
+
+	var err error
+	if h.ns, err = newNS(l.ns); err != nil {
+		return nil, fmt.Errorf("failed to create the namespace of host %s: %v", name, err)
+	}
+	if err := l.connect(h); err != nil {
+		l.nlh.LinkDel(&netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: h.Link}})
+		h.ns.Close()
+		return nil, fmt.Errorf("failed to connect host %s: %v", name, err)
+	}
+
+	l.Lock()
+	l.hosts = append(l.hosts, h)
+	l.Unlock()
+	return h, nil
+}
+
+// newNS creates a namespace, the calling thread staying in cur
+func newNS(cur netns.NsHandle) (netns.NsHandle, error) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	ns, err := netns.New()
+	if err != nil {
+		return netns.None(), err
+	}
+	if err := netns.Set(cur); err != nil {
+		ns.Close()
+		return netns.None(), err
+	}
+	return ns, nil
+}
+
+// connect creates the veth pair of the host, its address routed through the
+// gateway address of the lab
+func (l *Lab) connect(h *Host) error {
+	veth := &netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: h.Link}, PeerName: "eth0-" + h.Name}
+	if err := l.nlh.LinkAdd(veth); err != nil {
+		return err
+	}
+	peer, err := l.nlh.LinkByName(veth.PeerName)
+	if err != nil {
+		return err
+	}
+	if err := l.nlh.LinkSetNsFd(peer, int(h.ns)); err != nil {
+		return err
+	}
+	link, err := l.nlh.LinkByName(h.Link)
+	if err != nil {
+		return err
+	}
+	gw := &net.IPNet{IP: gatewayIP, Mask: net.CIDRMask(32, 32)}
+	host := &net.IPNet{IP: h.IP, Mask: net.CIDRMask(32, 32)}
+	if err := l.nlh.AddrAdd(link, &netlink.Addr{IPNet: gw}); err != nil {
+		return err
+	}
+	if err := l.nlh.LinkSetUp(link); err != nil {
+		return err
+	}
+	if err := l.nlh.RouteAdd(&netlink.Route{LinkIndex: link.Attrs().Index, Dst: host, Scope: netlink.SCOPE_LINK}); err != nil {
+		return err
+	}
+
+	nlh, err := netlink.NewHandleAt(h.ns)
+	if err != nil {
+		return err
+	}
+	defer nlh.Delete()
+	if peer, err = nlh.LinkByName(veth.PeerName); err != nil {
+		return err
+	}
+	if err := nlh.LinkSetName(peer, "eth0"); err != nil {
+		return err
+	}
+	if err := nlh.AddrAdd(peer, &netlink.Addr{IPNet: host}); err != nil {
+		return err
+	}
+	for _, link := range []netlink.Link{peer, &netlink.Device{LinkAttrs: netlink.LinkAttrs{Name: "lo"}}} {
+		if err := nlh.LinkSetUp(link); err != nil {
+			return err
+		}
+	}
+	if err := nlh.RouteAdd(&netlink.Route{LinkIndex: peer.Attrs().Index, Dst: gw, Scope: netlink.SCOPE_LINK}); err != nil {
+		return err
+	}
+	if err := nlh.RouteAdd(&netlink.Route{LinkIndex: peer.Attrs().Index, Gw: gatewayIP}); err != nil {
+		return err
+	}
+	// the packets sent before the carrier is up are lost
+	if err := waitCarrier(l.nlh, h.Link); err != nil {
+		return err
+	}
+	return waitCarrier(nlh, "eth0")
+}
+
+// waitCarrier waits for the link to be operationally up
+func waitCarrier(nlh *netlink.Handle, name string) error {
+	for i := 0; ; i++ {
+		link, err := nlh.LinkByName(name)
+		if err != nil {
+			return err
+		}
+		if link.Attrs().OperState == netlink.OperUp {
+			return nil
+		}
+		if i == carrierRetries {
+			return fmt.Errorf("link %s is not up: %s", name, link.Attrs().OperState)
+		}
+		time.Sleep(carrierRetryDelay)
+	}
+}
+
+// Close removes the hosts, their links going away with their namespaces
+func (l *Lab) Close() error {
+	l.Lock()
+	defer l.Unlock()
+	for _, h := range l.hosts {
+		h.close()
+	}
+	l.hosts = nil
+	l.nlh.Delete()
+	return l.ns.Close()
+}
+
+func (h *Host) close() {
+	h.Lock()
+	defer h.Unlock()
+	for key, ln := range h.listeners {
+		ln.Close()
+		delete(h.listeners, key)
+	}
+	h.ns.Close()
+}
+
+// inNS runs the function in the namespace of the host, the sockets it
+// creates staying there
+func (h *Host) inNS(f func() error) error {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	cur, err := netns.Get()
+	if err != nil {
+		return err
+	}
+	defer cur.Close()
+	if err := netns.Set(h.ns); err != nil {
+		return err
+	}
+	defer netns.Set(cur)
+	return f()
+}
+
+// Listen answers the probes to the port, the TCP connections being accepted
+// and the UDP datagrams echoed. It is invoked by the probes, the listeners
+// lasting until the lab is closed.
+func (h *Host) Listen(proto string, port int) error {
+	key := proto + "/" + strconv.Itoa(port)
+	h.Lock()
+	defer h.Unlock()
+	if _, ok := h.listeners[key]; ok {
+		return nil
+	}
+	addr := net.JoinHostPort(h.IP.String(), strconv.Itoa(port))
+	return h.inNS(func() error {
+		switch proto {
+		case "tcp":
+			ln, err := net.Listen("tcp4", addr)
+			if err != nil {
+				return err
+			}
+			go acceptTCP(ln)
+			h.listeners[key] = ln
+		case "udp":
+			conn, err := net.ListenPacket("udp4", addr)
+			if err != nil {
+				return err
+			}
+			go echoUDP(conn)
+			h.listeners[key] = conn
+		default:
+			return fmt.Errorf("unsupported protocol %q", proto)
+		}
+		return nil
+	})
+}
+
+func acceptTCP(ln net.Listener) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		conn.Close()
+	}
+}
+
+func echoUDP(conn net.PacketConn) {
+	buf := make([]byte, 64)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		conn.WriteTo(buf[:n], addr)
+	}
+}
+
+// Probe sends the traffic of the host to the port of the destination and
+// returns the verdict: allowed when it is answered within the timeout,
+// denied when it is not or is rejected
+func (h *Host) Probe(dst *Host, proto string, port int, timeout time.Duration) (Verdict, error) {
+	if err := dst.Listen(proto, port); err != nil {
+		return Denied, fmt.Errorf("failed to listen on %s %s/%d: %v", dst.Name, proto, port, err)
+	}
+	addr := net.JoinHostPort(dst.IP.String(), strconv.Itoa(port))
+	var v Verdict
+	err := h.inNS(func() error {
+		conn, err := net.DialTimeout(proto+"4", addr, timeout)
+		if err != nil {
+			if isDenied(err) {
+				return nil
+			}
+			return err
+		}
+		defer conn.Close()
+		if proto == "tcp" {
+			v = Allowed
+			return nil
+		}
+		conn.SetDeadline(time.Now().Add(timeout))
+		if _, err := conn.Write([]byte("probe")); err != nil {
+			if isDenied(err) {
+				return nil
+			}
+			return err
+		}
+		if _, err := conn.Read(make([]byte, 64)); err != nil {
+			if isDenied(err) {
+				return nil
+			}
+			return err
+		}
+		v = Allowed
+		return nil
+	})
+	return v, err
+}
+
+// isDenied tells whether the error of a probe is the traffic being dropped
+// or rejected on the way
+func isDenied(err error) bool {
+	if err, ok := err.(net.Error); ok && err.Timeout() {
+		return true
+	}
+	if err, ok := err.(*net.OpError); ok {
+		if serr, ok := err.Err.(*os.SyscallError); ok {
+			switch serr.Err {
+			case syscall.ECONNREFUSED, syscall.EHOSTUNREACH, syscall.ENETUNREACH, syscall.EACCES, syscall.EPERM:
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Check runs the probes of the cases and reports the unexpected verdicts
+func (l *Lab) Check(t testing.TB, cases ...Case) {
+	timeout := l.Timeout
+	if timeout == 0 {
+		timeout = DefaultTimeout
+	}
+	for _, c := range cases {
+		name := c.Name
+		if name == "" {
+			name = fmt.Sprintf("%s -> %s %s/%d", c.From.Name, c.To.Name, c.Proto, c.Port)
+		}
+		v, err := c.From.Probe(c.To, c.Proto, c.Port, timeout)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if v != c.Want {
+			t.Errorf("%s: %s, expected %s", name, v, c.Want)
+		}
+	}
+}
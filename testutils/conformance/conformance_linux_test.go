@@ -0,0 +1,61 @@
+package conformance
+
+import (
+	"net"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/docker/libnetwork/testutils"
+	"github.com/vishvananda/netlink"
+)
+
+func TestLab(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("Skipping test when not running as root")
+	}
+	defer testutils.SetupTestOSContext(t)()
+
+	lab, err := NewLab()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lab.Close()
+	lab.Timeout = 200 * time.Millisecond
+
+	web, err := lab.AddHost("web", net.ParseIP("10.10.0.2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := lab.AddHost("db", net.ParseIP("10.10.0.3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lab.Check(t,
+		Case{From: web, To: db, Proto: "tcp", Port: 5432, Want: Allowed},
+		Case{From: db, To: web, Proto: "udp", Port: 53, Want: Allowed},
+	)
+
+	// Drop the traffic forwarded to db
+	route := &netlink.Route{Dst: &net.IPNet{IP: db.IP, Mask: net.CIDRMask(32, 32)}, Type: syscall.RTN_BLACKHOLE}
+	link, err := lab.nlh.LinkByName(db.Link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := lab.nlh.RouteDel(&netlink.Route{LinkIndex: link.Attrs().Index, Dst: route.Dst, Scope: netlink.SCOPE_LINK}); err != nil {
+		t.Fatal(err)
+	}
+	if err := lab.nlh.RouteAdd(route); err != nil {
+		t.Fatal(err)
+	}
+	lab.Check(t,
+		Case{From: web, To: db, Proto: "tcp", Port: 5432, Want: Denied},
+		Case{From: web, To: db, Proto: "udp", Port: 53, Want: Denied},
+		Case{From: db, To: web, Proto: "udp", Port: 53, Want: Denied},
+	)
+
+	if _, err := lab.AddHost("a-too-long-name", net.ParseIP("10.10.0.4")); err == nil {
+		t.Fatal("expected the host name to be rejected")
+	}
+}
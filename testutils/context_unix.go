@@ -9,6 +9,7 @@ import (
 	"testing"
 
 	"github.com/docker/libnetwork/ns"
+	"github.com/vishvananda/netns"
 )
 
 // SetupTestOSContext joins a new network namespace, and returns its associated
@@ -20,6 +21,10 @@ import (
 //
 func SetupTestOSContext(t *testing.T) func() {
 	runtime.LockOSThread()
+	origNs, err := netns.Get()
+	if err != nil {
+		t.Fatalf("Failed to get the current netns: %v", err)
+	}
 	if err := syscall.Unshare(syscall.CLONE_NEWNET); err != nil {
 		t.Fatalf("Failed to enter netns: %v", err)
 	}
@@ -39,6 +44,13 @@ func SetupTestOSContext(t *testing.T) func() {
 		if err := syscall.Close(fd); err != nil {
 			t.Logf("Warning: netns closing failed (%v)", err)
 		}
+		// Go back to the original namespace, for the thread and the
+		// initial namespace of the ns package not to stay in the test one
+		if err := netns.Set(origNs); err != nil {
+			t.Logf("Warning: failed to restore the original netns (%v)", err)
+		}
+		origNs.Close()
+		ns.Init()
 		runtime.UnlockOSThread()
 	}
 }
@@ -0,0 +1,583 @@
+// Package fakeiptables provides an in-memory backend of the iptables
+// package, which records the chains and rules programmed for the tests to
+// assert against, on hosts without the iptables binaries or CAP_NET_ADMIN.
+//
+// The commands are checked as the kernel does: the chains jumped to must
+// exist, the chains removed must be empty and no longer referenced and the
+// rules removed must be found. The rules are kept as they are given, without
+// the canonicalization of the binaries, so they match the arguments passed
+// to the iptables package.
+//
+// Example usage:
+//
+//	ipt := fakeiptables.New()
+//	defer ipt.Install()()
+//	...
+//	if !ipt.IPv4().HasRule(iptables.Nat, "DOCKER", "-i", "docker0", "-j", "RETURN") {
+//		t.Fatal("missing rule")
+//	}
+package fakeiptables
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/docker/libnetwork/iptables"
+)
+
+// builtinChains are the chains of the tables, in the order they are listed
+var builtinChains = map[iptables.Table][]string{
+	iptables.Filter:   {"INPUT", "FORWARD", "OUTPUT"},
+	iptables.Nat:      {"PREROUTING", "INPUT", "OUTPUT", "POSTROUTING"},
+	iptables.Mangle:   {"PREROUTING", "INPUT", "FORWARD", "OUTPUT", "POSTROUTING"},
+	iptables.RawTable: {"PREROUTING", "OUTPUT"},
+}
+
+// tableOrder is the order the tables are saved in
+var tableOrder = []iptables.Table{iptables.Filter, iptables.Nat, iptables.Mangle, iptables.RawTable}
+
+// targets are the targets which are not chains
+var targets = map[string]bool{
+	"ACCEPT": true, "DROP": true, "RETURN": true, "QUEUE": true, "REJECT": true,
+	"LOG": true, "NFLOG": true, "MARK": true, "CONNMARK": true, "MASQUERADE": true,
+	"SNAT": true, "DNAT": true, "REDIRECT": true, "NETMAP": true, "TCPMSS": true,
+	"NOTRACK": true, "CT": true, "TPROXY": true, "CHECKSUM": true, "NFQUEUE": true,
+	"TRACE": true, "DSCP": true, "TOS": true, "TTL": true, "HL": true, "CLASSIFY": true,
+}
+
+// longCommands maps the long forms of the commands to their short ones
+var longCommands = map[string]string{
+	"--append": "-A", "--check": "-C", "--delete": "-D", "--insert": "-I",
+	"--replace": "-R", "--list": "-L", "--list-rules": "-S", "--flush": "-F",
+	"--zero": "-Z", "--new-chain": "-N", "--delete-chain": "-X", "--policy": "-P",
+}
+
+// errExit is the error of the failed commands, their output telling why
+var errExit = errors.New("exit status 1")
+
+// Command is a command run through the fake
+type Command struct {
+	IPV  iptables.IPV
+	Args []string
+	// Output is the output of the command, which tells why it failed
+	Output string
+	Failed bool
+}
+
+// Iptables holds the rulesets of both families
+type Iptables struct {
+	mu       sync.Mutex
+	v4, v6   *Ruleset
+	commands []Command
+}
+
+// Ruleset is the tables of a family
+type Ruleset struct {
+	mu     *sync.Mutex
+	name   string
+	tables map[iptables.Table]*table
+}
+
+type table struct {
+	chains map[string]*chain
+	// order is the creation order of the chains
+	order []string
+}
+
+type chain struct {
+	builtin bool
+	policy  string
+	rules   [][]string
+}
+
+// New returns an empty fake, its tables holding the builtin chains only
+func New() *Iptables {
+	f := &Iptables{}
+	f.v4 = newRuleset(&f.mu, "iptables")
+	f.v6 = newRuleset(&f.mu, "ip6tables")
+	return f
+}
+
+func newRuleset(mu *sync.Mutex, name string) *Ruleset {
+	r := &Ruleset{mu: mu, name: name, tables: map[iptables.Table]*table{}}
+	for name, chains := range builtinChains {
+		t := &table{chains: map[string]*chain{}}
+		for _, c := range chains {
+			t.chains[c] = &chain{builtin: true, policy: string(iptables.Accept)}
+			t.order = append(t.order, c)
+		}
+		r.tables[name] = t
+	}
+	return r
+}
+
+// Install makes the iptables package run its commands through the fake and
+// returns the function restoring the former backend
+func (f *Iptables) Install() func() {
+	return iptables.SetBackend(f)
+}
+
+// IPv4 returns the ruleset of the iptables commands
+func (f *Iptables) IPv4() *Ruleset {
+	return f.v4
+}
+
+// IPv6 returns the ruleset of the ip6tables commands
+func (f *Iptables) IPv6() *Ruleset {
+	return f.v6
+}
+
+// Commands returns the commands run so far, in order
+func (f *Iptables) Commands() []Command {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]Command{}, f.commands...)
+}
+
+// Run implements iptables.Backend
+func (f *Iptables) Run(ipv iptables.IPV, args ...string) ([]byte, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	r := f.v4
+	if ipv == iptables.IP6Tables {
+		r = f.v6
+	}
+	out, err := r.run(args)
+	f.commands = append(f.commands, Command{IPV: ipv, Args: append([]string{}, args...), Output: string(out), Failed: err != nil})
+	return out, err
+}
+
+func (r *Ruleset) fail(format string, a ...interface{}) ([]byte, error) {
+	return []byte(r.name + ": " + fmt.Sprintf(format, a...) + "\n"), errExit
+}
+
+// run runs the command on the ruleset, the lock held
+func (r *Ruleset) run(args []string) ([]byte, error) {
+	tableName := iptables.Filter
+	var (
+		cmd  string
+		rest []string
+	)
+	for i := 0; i < len(args) && cmd == ""; i++ {
+		switch a := args[i]; a {
+		case "-t", "--table":
+			if i+1 == len(args) {
+				return r.fail("option %q requires an argument", a)
+			}
+			i++
+			tableName = iptables.Table(args[i])
+		case "-w", "--wait":
+			if i+1 < len(args) {
+				if _, err := strconv.Atoi(args[i+1]); err == nil {
+					i++
+				}
+			}
+		case "-W", "--wait-interval":
+			i++
+		case "-n", "--numeric", "-v", "--verbose", "-x", "--exact", "--line-numbers":
+		case "--version", "-V":
+			return []byte(r.name + " v1.8.7 (fake)\n"), nil
+		case "-nL", "-Ln":
+			cmd, rest = "-L", args[i+1:]
+		default:
+			if long, ok := longCommands[a]; ok {
+				a = long
+			}
+			if len(a) != 2 || !strings.Contains("ACDIRLSFZNXP", a[1:]) || a[0] != '-' {
+				return r.fail("unknown option %q", a)
+			}
+			cmd, rest = a, args[i+1:]
+		}
+	}
+	if cmd == "" {
+		return r.fail("no command specified")
+	}
+	t, ok := r.tables[tableName]
+	if !ok {
+		return r.fail("can't initialize %s table `%s': Table does not exist", r.name, tableName)
+	}
+
+	if cmd == "-L" || cmd == "-S" || cmd == "-F" || cmd == "-Z" || cmd == "-X" {
+		rest = dropListOptions(rest)
+		if len(rest) == 0 {
+			return r.all(t, cmd)
+		}
+	}
+	if len(rest) == 0 {
+		return r.fail("option %q requires an argument", cmd)
+	}
+	name, rest := rest[0], rest[1:]
+
+	if cmd == "-N" {
+		if _, ok := t.chains[name]; ok || targets[name] {
+			return r.fail("Chain already exists.")
+		}
+		t.chains[name] = &chain{}
+		t.order = append(t.order, name)
+		return nil, nil
+	}
+	c, ok := t.chains[name]
+	if !ok {
+		return r.fail("No chain/target/match by that name.")
+	}
+
+	switch cmd {
+	case "-A", "-I", "-C", "-D", "-R":
+	default:
+		if len(rest) != 0 && cmd != "-P" {
+			return r.fail("unexpected argument %q", rest[0])
+		}
+	}
+
+	switch cmd {
+	case "-A":
+		if out, err := r.checkTarget(t, rest); err != nil {
+			return out, err
+		}
+		c.rules = append(c.rules, copyRule(rest))
+	case "-I":
+		pos := 1
+		if len(rest) != 0 {
+			if n, err := strconv.Atoi(rest[0]); err == nil {
+				pos, rest = n, rest[1:]
+			}
+		}
+		if pos < 1 || pos > len(c.rules)+1 {
+			return r.fail("Index of insertion too big.")
+		}
+		if out, err := r.checkTarget(t, rest); err != nil {
+			return out, err
+		}
+		c.rules = append(c.rules[:pos-1], append([][]string{copyRule(rest)}, c.rules[pos-1:]...)...)
+	case "-R":
+		if len(rest) == 0 {
+			return r.fail("-R requires a rule number")
+		}
+		pos, err := strconv.Atoi(rest[0])
+		if err != nil {
+			return r.fail("invalid rule number %q", rest[0])
+		}
+		if pos < 1 || pos > len(c.rules) {
+			return r.fail("Index of replacement too big.")
+		}
+		if out, err := r.checkTarget(t, rest[1:]); err != nil {
+			return out, err
+		}
+		c.rules[pos-1] = copyRule(rest[1:])
+	case "-C":
+		if findRule(c, rest) < 0 {
+			return r.fail("Bad rule (does a matching rule exist in that chain?).")
+		}
+	case "-D":
+		i := -1
+		if len(rest) == 1 {
+			if n, err := strconv.Atoi(rest[0]); err == nil {
+				if n < 1 || n > len(c.rules) {
+					return r.fail("Index of deletion too big.")
+				}
+				i = n - 1
+			}
+		}
+		if i < 0 {
+			if i = findRule(c, rest); i < 0 {
+				return r.fail("Bad rule (does a matching rule exist in that chain?).")
+			}
+		}
+		c.rules = append(c.rules[:i], c.rules[i+1:]...)
+	case "-F":
+		c.rules = nil
+	case "-Z":
+	case "-X":
+		return r.deleteChain(t, name)
+	case "-P":
+		if len(rest) != 1 {
+			return r.fail("-P requires a chain and a policy")
+		}
+		if !c.builtin {
+			return r.fail("Bad built-in chain name.")
+		}
+		if rest[0] != string(iptables.Accept) && rest[0] != string(iptables.Drop) {
+			return r.fail("Bad policy name.")
+		}
+		c.policy = rest[0]
+	case "-L":
+		return r.list(t, name), nil
+	case "-S":
+		return r.listRules(t, name, true), nil
+	}
+	return nil, nil
+}
+
+// all runs the listing or flushing command on all the chains of the table
+func (r *Ruleset) all(t *table, cmd string) ([]byte, error) {
+	var b bytes.Buffer
+	switch cmd {
+	case "-L":
+		for _, name := range t.order {
+			b.Write(r.list(t, name))
+		}
+	case "-S":
+		for _, name := range t.order {
+			b.Write(r.listRules(t, name, false))
+		}
+		for _, name := range t.order {
+			for _, rule := range t.chains[name].rules {
+				fmt.Fprintf(&b, "-A %s %s\n", name, formatRule(rule))
+			}
+		}
+	case "-F":
+		for _, c := range t.chains {
+			c.rules = nil
+		}
+	case "-X":
+		for _, name := range append([]string{}, t.order...) {
+			if !t.chains[name].builtin {
+				if out, err := r.deleteChain(t, name); err != nil {
+					return out, err
+				}
+			}
+		}
+	}
+	return b.Bytes(), nil
+}
+
+// checkTarget fails the rules jumping to a chain which does not exist
+func (r *Ruleset) checkTarget(t *table, rule []string) ([]byte, error) {
+	to := jumpTarget(rule)
+	if to == "" || targets[to] {
+		return nil, nil
+	}
+	if _, ok := t.chains[to]; !ok {
+		return r.fail("Couldn't load target `%s':No such file or directory", to)
+	}
+	return nil, nil
+}
+
+func (r *Ruleset) deleteChain(t *table, name string) ([]byte, error) {
+	c := t.chains[name]
+	if c.builtin {
+		return r.fail("Invalid argument. Run `dmesg' for more information.")
+	}
+	if references(t, name) != 0 {
+		return r.fail("Too many links.")
+	}
+	if len(c.rules) != 0 {
+		return r.fail("Directory not empty.")
+	}
+	delete(t.chains, name)
+	for i, n := range t.order {
+		if n == name {
+			t.order = append(t.order[:i], t.order[i+1:]...)
+			break
+		}
+	}
+	return nil, nil
+}
+
+func (r *Ruleset) list(t *table, name string) []byte {
+	var b bytes.Buffer
+	c := t.chains[name]
+	if c.builtin {
+		fmt.Fprintf(&b, "Chain %s (policy %s)\n", name, c.policy)
+	} else {
+		fmt.Fprintf(&b, "Chain %s (%d references)\n", name, references(t, name))
+	}
+	b.WriteString("target     prot opt source               destination\n")
+	for _, rule := range c.rules {
+		fmt.Fprintf(&b, "%s\n", formatRule(rule))
+	}
+	b.WriteString("\n")
+	return b.Bytes()
+}
+
+// listRules renders the chain as iptables -S does, with its rules or alone
+func (r *Ruleset) listRules(t *table, name string, withRules bool) []byte {
+	var b bytes.Buffer
+	c := t.chains[name]
+	if c.builtin {
+		fmt.Fprintf(&b, "-P %s %s\n", name, c.policy)
+	} else {
+		fmt.Fprintf(&b, "-N %s\n", name)
+	}
+	if withRules {
+		for _, rule := range c.rules {
+			fmt.Fprintf(&b, "-A %s %s\n", name, formatRule(rule))
+		}
+	}
+	return b.Bytes()
+}
+
+// Chains returns the chains the table holds besides the builtin ones, in
+// their creation order
+func (r *Ruleset) Chains(tableName iptables.Table) []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	t, ok := r.tables[tableName]
+	if !ok {
+		return nil
+	}
+	var names []string
+	for _, name := range t.order {
+		if !t.chains[name].builtin {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// HasChain tells whether the table holds the chain
+func (r *Ruleset) HasChain(tableName iptables.Table, name string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	t, ok := r.tables[tableName]
+	if !ok {
+		return false
+	}
+	_, ok = t.chains[name]
+	return ok
+}
+
+// Rules returns the rules of the chain, in order, nil when it does not
+// exist
+func (r *Ruleset) Rules(tableName iptables.Table, name string) [][]string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	t, ok := r.tables[tableName]
+	if !ok {
+		return nil
+	}
+	c, ok := t.chains[name]
+	if !ok {
+		return nil
+	}
+	rules := make([][]string, 0, len(c.rules))
+	for _, rule := range c.rules {
+		rules = append(rules, copyRule(rule))
+	}
+	return rules
+}
+
+// HasRule tells whether the chain holds the rule
+func (r *Ruleset) HasRule(tableName iptables.Table, name string, rule ...string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	t, ok := r.tables[tableName]
+	if !ok {
+		return false
+	}
+	c, ok := t.chains[name]
+	return ok && findRule(c, rule) >= 0
+}
+
+// Policy returns the policy of the builtin chain
+func (r *Ruleset) Policy(tableName iptables.Table, name string) iptables.Policy {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if t, ok := r.tables[tableName]; ok {
+		if c, ok := t.chains[name]; ok {
+			return iptables.Policy(c.policy)
+		}
+	}
+	return ""
+}
+
+// Save renders the tables as iptables-save does, without the counters
+func (r *Ruleset) Save() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var b bytes.Buffer
+	for _, name := range tableOrder {
+		t := r.tables[name]
+		fmt.Fprintf(&b, "*%s\n", name)
+		for _, cn := range t.order {
+			policy := "-"
+			if c := t.chains[cn]; c.builtin {
+				policy = c.policy
+			}
+			fmt.Fprintf(&b, ":%s %s\n", cn, policy)
+		}
+		for _, cn := range t.order {
+			for _, rule := range t.chains[cn].rules {
+				fmt.Fprintf(&b, "-A %s %s\n", cn, formatRule(rule))
+			}
+		}
+		b.WriteString("COMMIT\n")
+	}
+	return b.String()
+}
+
+// dropListOptions removes the options of the listing commands following
+// the command
+func dropListOptions(args []string) []string {
+	var res []string
+	for _, a := range args {
+		switch a {
+		case "-n", "--numeric", "-v", "--verbose", "-x", "--exact", "--line-numbers":
+		default:
+			res = append(res, a)
+		}
+	}
+	return res
+}
+
+func references(t *table, name string) int {
+	n := 0
+	for _, c := range t.chains {
+		for _, rule := range c.rules {
+			if jumpTarget(rule) == name {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+func jumpTarget(rule []string) string {
+	for i, a := range rule {
+		if (a == "-j" || a == "--jump" || a == "-g" || a == "--goto") && i+1 < len(rule) {
+			return rule[i+1]
+		}
+	}
+	return ""
+}
+
+func findRule(c *chain, rule []string) int {
+	for i, r := range c.rules {
+		if equalRule(r, rule) {
+			return i
+		}
+	}
+	return -1
+}
+
+func equalRule(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func copyRule(rule []string) []string {
+	return append([]string{}, rule...)
+}
+
+// formatRule joins the arguments of the rule, quoting the ones with spaces
+func formatRule(rule []string) string {
+	args := make([]string, 0, len(rule))
+	for _, a := range rule {
+		if a == "" || strings.ContainsAny(a, " \t\"") {
+			a = strconv.Quote(a)
+		}
+		args = append(args, a)
+	}
+	return strings.Join(args, " ")
+}
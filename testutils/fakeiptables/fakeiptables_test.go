@@ -0,0 +1,139 @@
+package fakeiptables
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/docker/libnetwork/iptables"
+)
+
+func TestFake(t *testing.T) {
+	ipt := New()
+	defer ipt.Install()()
+	v4 := ipt.IPv4()
+
+	c, err := iptables.NewChain("TEST", iptables.Nat, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := iptables.NewChain("TEST", iptables.Nat, false); err != nil {
+		t.Fatalf("existing chain not reused: %v", err)
+	}
+	if !reflect.DeepEqual(v4.Chains(iptables.Nat), []string{"TEST"}) {
+		t.Fatalf("unexpected chains %v", v4.Chains(iptables.Nat))
+	}
+
+	rule := []string{"-i", "br0", "-j", "RETURN"}
+	if err := iptables.ProgramRule(iptables.Nat, "TEST", iptables.Append, rule); err != nil {
+		t.Fatal(err)
+	}
+	if err := iptables.ProgramRule(iptables.Nat, "TEST", iptables.Append, rule); err != nil {
+		t.Fatal(err)
+	}
+	if !iptables.Exists(iptables.Nat, "TEST", rule...) || len(v4.Rules(iptables.Nat, "TEST")) != 1 {
+		t.Fatalf("unexpected rules %v", v4.Rules(iptables.Nat, "TEST"))
+	}
+	if err := iptables.ProgramRule(iptables.Nat, "PREROUTING", iptables.Insert, []string{"-j", "TEST"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The chains must be unreferenced and empty to be removed, and
+	// jumped to only once they exist
+	if _, err := iptables.Raw("-t", "nat", "-X", "TEST"); err == nil || !strings.Contains(err.Error(), "Too many links") {
+		t.Fatalf("expected the referenced chain to stay, got %v", err)
+	}
+	if err := iptables.RawCombinedOutput("-t", "nat", "-A", "PREROUTING", "-j", "MISSING"); err == nil {
+		t.Fatal("expected the jump to a missing chain to fail")
+	}
+	if err := iptables.ProgramRule(iptables.Nat, "PREROUTING", iptables.Delete, []string{"-j", "TEST"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := iptables.Raw("-t", "nat", "-X", "TEST"); err == nil || !strings.Contains(err.Error(), "Directory not empty") {
+		t.Fatalf("expected the chain holding rules to stay, got %v", err)
+	}
+
+	out, err := iptables.Raw("-t", "nat", "-S", "TEST")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != "-N TEST\n-A TEST -i br0 -j RETURN\n" {
+		t.Fatalf("unexpected listing %q", out)
+	}
+
+	if err := c.Remove(); err != nil {
+		t.Fatal(err)
+	}
+	if v4.HasChain(iptables.Nat, "TEST") || iptables.ExistChain("TEST", iptables.Nat) {
+		t.Fatal("chain not removed")
+	}
+
+	// The families are apart
+	if err := iptables.ProgramRule6(iptables.Filter, "FORWARD", iptables.Append, []string{"-j", "DROP"}); err != nil {
+		t.Fatal(err)
+	}
+	if !ipt.IPv6().HasRule(iptables.Filter, "FORWARD", "-j", "DROP") || v4.HasRule(iptables.Filter, "FORWARD", "-j", "DROP") {
+		t.Fatal("rule programmed in the wrong family")
+	}
+	if err := iptables.SetDefaultPolicy(iptables.Filter, "FORWARD", iptables.Drop); err != nil {
+		t.Fatal(err)
+	}
+	if v4.Policy(iptables.Filter, "FORWARD") != iptables.Drop {
+		t.Fatal("policy not set")
+	}
+	if !strings.Contains(v4.Save(), "*filter\n:INPUT ACCEPT\n:FORWARD DROP\n") {
+		t.Fatalf("unexpected save:\n%s", v4.Save())
+	}
+
+	var failed int
+	for _, cmd := range ipt.Commands() {
+		if cmd.Failed {
+			failed++
+		}
+	}
+	if failed == 0 {
+		t.Fatal("failed commands not recorded")
+	}
+}
+
+func TestRuleEdits(t *testing.T) {
+	ipt := New()
+	r := ipt.IPv4()
+	for _, args := range [][]string{
+		{"-N", "C"},
+		{"-A", "C", "-s", "10.0.0.1", "-j", "ACCEPT"},
+		{"-A", "C", "-s", "10.0.0.3", "-j", "ACCEPT"},
+		{"-I", "C", "2", "-s", "10.0.0.2", "-j", "ACCEPT"},
+		{"-R", "C", "1", "-s", "10.0.0.4", "-j", "DROP"},
+		{"-D", "C", "3"},
+		{"--wait", "5", "-t", "filter", "--check", "C", "-s", "10.0.0.2", "-j", "ACCEPT"},
+	} {
+		if out, err := ipt.Run(iptables.Iptables, args...); err != nil {
+			t.Fatalf("%v: %s", args, out)
+		}
+	}
+	exp := [][]string{{"-s", "10.0.0.4", "-j", "DROP"}, {"-s", "10.0.0.2", "-j", "ACCEPT"}}
+	if !reflect.DeepEqual(r.Rules(iptables.Filter, "C"), exp) {
+		t.Fatalf("unexpected rules %v", r.Rules(iptables.Filter, "C"))
+	}
+	for _, args := range [][]string{
+		{"-R", "C", "3", "-j", "ACCEPT"},
+		{"-D", "C", "-j", "ACCEPT"},
+		{"-t", "bogus", "-L"},
+		{"-X", "FORWARD"},
+		{"-P", "C", "DROP"},
+	} {
+		if _, err := ipt.Run(iptables.Iptables, args...); err == nil {
+			t.Fatalf("%v: expected a failure", args)
+		}
+	}
+	if _, err := ipt.Run(iptables.Iptables, "-F"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ipt.Run(iptables.Iptables, "-X"); err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Chains(iptables.Filter)) != 0 {
+		t.Fatalf("chains left %v", r.Chains(iptables.Filter))
+	}
+}
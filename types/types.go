@@ -300,6 +300,32 @@ func GetIPCopy(from net.IP) net.IP {
 	return to
 }
 
+// OwnerTagPrefix prefixes the tags libnetwork puts on the kernel objects it
+// creates, as the aliases of the veths and the comments of the rules
+const OwnerTagPrefix = "lnet:"
+
+// OwnerTag returns the tag of the kernel objects the driver creates, for
+// the endpoint when not empty
+func OwnerTag(driver, eid string) string {
+	if eid == "" {
+		return OwnerTagPrefix + driver
+	}
+	return OwnerTagPrefix + driver + ":" + eid
+}
+
+// TagOwner returns the driver and the endpoint the tag names, false if the
+// tag is not one of libnetwork
+func TagOwner(tag string) (driver, eid string, ok bool) {
+	if !strings.HasPrefix(tag, OwnerTagPrefix) || len(tag) == len(OwnerTagPrefix) {
+		return "", "", false
+	}
+	parts := strings.SplitN(tag[len(OwnerTagPrefix):], ":", 2)
+	if len(parts) == 2 {
+		return parts[0], parts[1], true
+	}
+	return parts[0], "", true
+}
+
 // GetIPNetCopy returns a copy of the passed IP Network
 func GetIPNetCopy(from *net.IPNet) *net.IPNet {
 	if from == nil {
@@ -445,6 +471,9 @@ type StaticRoute struct {
 
 	// NextHop will be resolved by the kernel (i.e. as a loose hop).
 	NextHop net.IP
+
+	// Metric is the priority of the route, lower values are preferred.
+	Metric int
 }
 
 // GetCopy returns a copy of this StaticRoute structure
@@ -454,6 +483,7 @@ func (r *StaticRoute) GetCopy() *StaticRoute {
 	return &StaticRoute{Destination: d,
 		RouteType: r.RouteType,
 		NextHop:   nh,
+		Metric:    r.Metric,
 	}
 }
 
@@ -0,0 +1,407 @@
+package libnetwork
+
+import (
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/libnetwork/config"
+	"github.com/docker/libnetwork/datastore"
+	"github.com/docker/libnetwork/diagnostic"
+	"github.com/docker/libnetwork/internal/caller"
+	"github.com/docker/libnetwork/ipamapi"
+	"github.com/docker/libnetwork/types"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	ulaKeyPrefix = "ula"
+
+	// The generated prefixes are the /48 of a random global ID in fd00::/8
+	ulaPrefixLen         = 48
+	defaultULASubnetSize = 64
+)
+
+// ulaPaths2Func are the diagnostic handlers of the unique local prefix of
+// the cluster
+var ulaPaths2Func = map[string]diagnostic.HTTPHandlerFunc{
+	"/ula": ulaDiag,
+}
+
+// fc00::/7, the unique local addresses
+var ulaRange = &net.IPNet{IP: net.ParseIP("fc00::"), Mask: net.CIDRMask(7, 128)}
+
+// ulaRecord is the unique local prefix generated for the cluster, kept in
+// the global datastore for all the controllers to carve their subnets from,
+// or in the local one without a global datastore
+type ulaRecord struct {
+	Prefix   string    `json:"prefix"`
+	Created  time.Time `json:"created"`
+	Creator  string    `json:"creator"`
+	scope    string
+	dbIndex  uint64
+	dbExists bool
+	sync.Mutex
+}
+
+func (u *ulaRecord) Key() []string {
+	return []string{ulaKeyPrefix, "prefix"}
+}
+
+func (u *ulaRecord) KeyPrefix() []string {
+	return []string{ulaKeyPrefix}
+}
+
+func (u *ulaRecord) Value() []byte {
+	u.Lock()
+	defer u.Unlock()
+
+	b, err := json.Marshal(u)
+	if err != nil {
+		return nil
+	}
+	return b
+}
+
+func (u *ulaRecord) SetValue(value []byte) error {
+	u.Lock()
+	defer u.Unlock()
+
+	return json.Unmarshal(value, u)
+}
+
+func (u *ulaRecord) Index() uint64 {
+	u.Lock()
+	defer u.Unlock()
+	return u.dbIndex
+}
+
+func (u *ulaRecord) SetIndex(index uint64) {
+	u.Lock()
+	u.dbIndex = index
+	u.dbExists = true
+	u.Unlock()
+}
+
+func (u *ulaRecord) Exists() bool {
+	u.Lock()
+	defer u.Unlock()
+	return u.dbExists
+}
+
+func (u *ulaRecord) Skip() bool {
+	return false
+}
+
+func (u *ulaRecord) New() datastore.KVObject {
+	return &ulaRecord{scope: u.scope}
+}
+
+func (u *ulaRecord) CopyTo(o datastore.KVObject) error {
+	u.Lock()
+	defer u.Unlock()
+
+	dst := o.(*ulaRecord)
+	dst.Prefix = u.Prefix
+	dst.Created = u.Created
+	dst.Creator = u.Creator
+	dst.scope = u.scope
+	dst.dbIndex = u.dbIndex
+	dst.dbExists = u.dbExists
+	return nil
+}
+
+func (u *ulaRecord) DataScope() string {
+	return u.scope
+}
+
+// ULAStatus is the unique local prefix of the cluster and the subnets the
+// networks got out of it
+type ULAStatus struct {
+	Prefix     string            `json:"prefix"`
+	SubnetSize int               `json:"subnet_size"`
+	Subnets    map[string]string `json:"subnets"`
+}
+
+func (s *ULAStatus) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "prefix:%s subnet size:%d\n", s.Prefix, s.SubnetSize)
+	names := make([]string, 0, len(s.Subnets))
+	for name := range s.Subnets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(&b, "%s: %s\n", name, s.Subnets[name])
+	}
+	return b.String()
+}
+
+func validateIPv6ULA(u *config.IPv6ULA) error {
+	size := u.SubnetSize
+	if size == 0 {
+		size = defaultULASubnetSize
+	}
+	if size > 64 {
+		return types.BadRequestErrorf("invalid ULA subnet size %d: expected up to 64", size)
+	}
+	if u.Prefix == "" {
+		if size <= ulaPrefixLen {
+			return types.BadRequestErrorf("invalid ULA subnet size %d: expected longer than the /%d prefix", size, ulaPrefixLen)
+		}
+		return nil
+	}
+	ip, prefix, err := net.ParseCIDR(u.Prefix)
+	if err != nil || ip.To4() != nil || !ulaRange.Contains(ip) {
+		return types.BadRequestErrorf("invalid ULA prefix %q: expected a subnet of fc00::/7", u.Prefix)
+	}
+	if ones, _ := prefix.Mask.Size(); ones >= size {
+		return types.BadRequestErrorf("invalid ULA subnet size %d: expected longer than the /%d prefix", size, ones)
+	}
+	return nil
+}
+
+// generateULAPrefix returns a /48 prefix of fd00::/8 with the pseudo-random
+// global ID of RFC 4193, the SHA-1 of the time and of an identifier of the
+// system, here the controller ID with random bytes, cut to its 40 low bits
+func generateULAPrefix(id string) (*net.IPNet, error) {
+	seed := make([]byte, 16)
+	if _, err := rand.Read(seed[8:]); err != nil {
+		return nil, err
+	}
+	binary.BigEndian.PutUint64(seed, uint64(time.Now().UnixNano()))
+	sum := sha1.Sum(append(seed, id...))
+
+	ip := make(net.IP, net.IPv6len)
+	ip[0] = 0xfd
+	copy(ip[1:6], sum[len(sum)-5:])
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(ulaPrefixLen, 128)}, nil
+}
+
+// ulaConfig returns the unique local prefix configuration, nil when the
+// subnets are not carved
+func (c *controller) ulaConfig() *config.IPv6ULA {
+	c.Lock()
+	defer c.Unlock()
+	if c.cfg == nil {
+		return nil
+	}
+	return c.cfg.Daemon.IPv6ULA
+}
+
+// ULAPrefix returns the unique local prefix of the cluster, from the
+// configuration or else from the datastore, generating and storing it
+// the first time
+func (c *controller) ULAPrefix() (*net.IPNet, error) {
+	u := c.ulaConfig()
+	if u == nil {
+		return nil, types.NotImplementedErrorf("no unique local prefix is configured")
+	}
+	if u.Prefix != "" {
+		_, prefix, err := net.ParseCIDR(u.Prefix)
+		return prefix, err
+	}
+
+	c.Lock()
+	prefix := c.ulaPrefix
+	c.Unlock()
+	if prefix != nil {
+		return prefix, nil
+	}
+
+	scope := datastore.GlobalScope
+	if c.getStore(scope) == nil {
+		scope = datastore.LocalScope
+	}
+	store := c.getStore(scope)
+	if store == nil {
+		return nil, ErrDataStoreNotInitialized(scope)
+	}
+	for {
+		r := &ulaRecord{scope: scope}
+		err := store.GetObject(datastore.Key(r.Key()...), r)
+		if err == nil {
+			if _, prefix, err = net.ParseCIDR(r.Prefix); err != nil {
+				return nil, fmt.Errorf("invalid unique local prefix %q in the datastore: %v", r.Prefix, err)
+			}
+			break
+		}
+		if err != datastore.ErrKeyNotFound {
+			return nil, err
+		}
+		if prefix, err = generateULAPrefix(c.id); err != nil {
+			return nil, err
+		}
+		r.Prefix, r.Created, r.Creator = prefix.String(), time.Now().UTC(), c.id
+		// Another controller storing its prefix first, it is the one
+		// read back and used
+		if err := c.updateToStore(r); err != datastore.ErrKeyModified {
+			if err != nil {
+				return nil, err
+			}
+			logrus.Infof("Generated the unique local prefix %s of the cluster", prefix)
+			break
+		}
+	}
+
+	c.Lock()
+	c.ulaPrefix = prefix
+	c.Unlock()
+	return prefix, nil
+}
+
+// ulaSubnetSize returns the prefix length of the subnets carved
+func ulaSubnetSize(u *config.IPv6ULA) int {
+	if u.SubnetSize == 0 {
+		return defaultULASubnetSize
+	}
+	return u.SubnetSize
+}
+
+// ulaSubnet returns the i-th subnet of the size of the prefix
+func ulaSubnet(prefix *net.IPNet, size int, i *big.Int) *net.IPNet {
+	base := new(big.Int).SetBytes(prefix.IP.Mask(prefix.Mask).To16())
+	offset := new(big.Int).Lsh(i, uint(128-size))
+	b := new(big.Int).Or(base, offset).Bytes()
+	ip := make(net.IP, net.IPv6len)
+	copy(ip[net.IPv6len-len(b):], b)
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(size, 128)}
+}
+
+// ulaCandidates returns the subnets of the prefix the network may get, the
+// first one derived from its ID for a network to keep clear of the ones
+// other controllers carve, then the next ones in order
+func ulaCandidates(prefix *net.IPNet, size int, networkID string, max int) []*net.IPNet {
+	ones, _ := prefix.Mask.Size()
+	count := new(big.Int).Lsh(big.NewInt(1), uint(size-ones))
+	sum := sha1.Sum([]byte(networkID))
+	start := new(big.Int).Mod(new(big.Int).SetBytes(sum[:]), count)
+
+	var l []*net.IPNet
+	for i := 0; i < max && big.NewInt(int64(i)).Cmp(count) < 0; i++ {
+		idx := new(big.Int).Add(start, big.NewInt(int64(i)))
+		l = append(l, ulaSubnet(prefix, size, idx.Mod(idx, count)))
+	}
+	return l
+}
+
+// maxULACandidates bounds the subnets tried for a network
+const maxULACandidates = 256
+
+// carvesULASubnet tells whether the IPv6 subnet of the network is to be
+// carved from the unique local prefix: IPv6 is enabled on the network with
+// no subnet of its own, and the default IPAM driver allocates its pools
+func (n *network) carvesULASubnet() bool {
+	return n.enableIPv6 && len(n.ipamV6Config) == 0 && n.ipamType == ipamapi.DefaultIPAM &&
+		n.getController().ulaConfig() != nil
+}
+
+// ipamAllocateULA allocates the IPv6 pool of the network out of a subnet of
+// the unique local prefix, skipping the subnets of the other networks and
+// the ones the IPAM driver reports overlapping
+func (n *network) ipamAllocateULA(ipam ipamapi.Ipam) error {
+	c := n.getController()
+	prefix, err := c.ULAPrefix()
+	if err != nil {
+		return fmt.Errorf("failed to get the unique local prefix: %v", err)
+	}
+	size := ulaSubnetSize(c.ulaConfig())
+
+	var taken []*net.IPNet
+	if nws, err := c.getNetworksFromStore(); err == nil {
+		for _, o := range nws {
+			if o.ID() == n.ID() {
+				continue
+			}
+			_, v6 := o.IpamInfo()
+			for _, d := range v6 {
+				if d.Pool != nil {
+					taken = append(taken, d.Pool)
+				}
+			}
+		}
+	}
+
+	for _, subnet := range ulaCandidates(prefix, size, n.ID(), maxULACandidates) {
+		if overlapsAny(subnet, taken) {
+			continue
+		}
+		n.ipamV6Config = []*IpamConf{{PreferredPool: subnet.String()}}
+		err = n.ipamAllocateVersion(6, ipam)
+		if err == nil {
+			logrus.Debugf("Carved the IPv6 subnet %s of network %s from the unique local prefix %s", subnet, n.Name(), prefix)
+			return nil
+		}
+		if err != ipamapi.ErrPoolOverlap {
+			n.ipamV6Config = nil
+			return err
+		}
+	}
+	n.ipamV6Config = nil
+	return types.NoServiceErrorf("no subnet of the unique local prefix %s is available for network %s", prefix, n.Name())
+}
+
+func overlapsAny(subnet *net.IPNet, l []*net.IPNet) bool {
+	for _, o := range l {
+		if types.CompareIPNet(subnet, o) || subnet.Contains(o.IP) || o.Contains(subnet.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+// ULAStatus returns the unique local prefix of the cluster and the
+// subnets of the networks out of it
+func (c *controller) ULAStatus() (*ULAStatus, error) {
+	u := c.ulaConfig()
+	prefix, err := c.ULAPrefix()
+	if err != nil {
+		return nil, err
+	}
+	s := &ULAStatus{Prefix: prefix.String(), SubnetSize: ulaSubnetSize(u), Subnets: map[string]string{}}
+	nws, err := c.getNetworksFromStore()
+	if err != nil {
+		return nil, err
+	}
+	for _, n := range nws {
+		_, v6 := n.IpamInfo()
+		for _, d := range v6 {
+			if d.Pool != nil && prefix.Contains(d.Pool.IP) {
+				s.Subnets[n.Name()] = d.Pool.String()
+			}
+		}
+	}
+	return s, nil
+}
+
+func ulaDiag(ctx interface{}, w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	diagnostic.DebugHTTPForm(r)
+	_, json := diagnostic.ParseHTTPFormOptions(r)
+
+	// audit logs
+	log := logrus.WithFields(logrus.Fields{"component": "diagnostic", "remoteIP": r.RemoteAddr, "method": caller.Name(0), "url": r.URL.String()})
+	log.Info("ula")
+
+	c, ok := ctx.(*controller)
+	if !ok {
+		diagnostic.HTTPReply(w, diagnostic.FailCommand(fmt.Errorf("controller not available")), json)
+		return
+	}
+	s, err := c.ULAStatus()
+	if err != nil {
+		log.WithError(err).Error("ula failed")
+		diagnostic.HTTPReply(w, diagnostic.FailCommand(err), json)
+		return
+	}
+	diagnostic.HTTPReply(w, diagnostic.CommandSucceed(s), json)
+}
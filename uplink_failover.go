@@ -0,0 +1,232 @@
+package libnetwork
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/libnetwork/diagnostic"
+	"github.com/docker/libnetwork/driverapi"
+	"github.com/docker/libnetwork/internal/caller"
+	"github.com/sirupsen/logrus"
+)
+
+// uplinkPaths2Func are the diagnostic handlers of the watched uplinks
+var uplinkPaths2Func = map[string]diagnostic.HTTPHandlerFunc{
+	"/uplinks": uplinksDiag,
+}
+
+// UplinkStatus is the state of a watched bonding or teaming uplink
+type UplinkStatus struct {
+	Name string `json:"name"`
+	Up   bool   `json:"up"`
+	// Active are the ports the traffic of the uplink goes through
+	Active       string    `json:"active"`
+	Failovers    int       `json:"failovers"`
+	LastFailover time.Time `json:"last_failover,omitempty"`
+	LastError    string    `json:"last_error,omitempty"`
+}
+
+type uplinksResult struct {
+	Uplinks []UplinkStatus `json:"uplinks"`
+}
+
+func (r *uplinksResult) String() string {
+	var b strings.Builder
+	for _, s := range r.Uplinks {
+		status := "up"
+		if !s.Up {
+			status = "down"
+		}
+		fmt.Fprintf(&b, "uplink:%s %s active:%s failovers:%d %s\n", s.Name, status, s.Active, s.Failovers, s.LastError)
+	}
+	return b.String()
+}
+
+// uplinkWatch holds the last seen state of the watched uplinks
+type uplinkWatch struct {
+	mu     sync.Mutex
+	status map[string]*UplinkStatus
+	stop   chan struct{}
+}
+
+// update records the state of the uplink and tells if it is a failover:
+// the uplink is up through other ports than the last time it was seen up.
+// The first state seen of an uplink is never a failover.
+func (w *uplinkWatch) update(name string, up bool, active string, now time.Time) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.status == nil {
+		w.status = map[string]*UplinkStatus{}
+	}
+	s, ok := w.status[name]
+	if !ok {
+		w.status[name] = &UplinkStatus{Name: name, Up: up, Active: active}
+		return false
+	}
+	failover := up && active != "" && (active != s.Active || !s.Up)
+	s.Up = up
+	if active != "" {
+		s.Active = active
+	}
+	if failover {
+		s.Failovers++
+		s.LastFailover = now
+	}
+	return failover
+}
+
+func (w *uplinkWatch) setError(name string, err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if s, ok := w.status[name]; ok {
+		s.LastError = ""
+		if err != nil {
+			s.LastError = err.Error()
+		}
+	}
+}
+
+// uplinkChanged handles a new state of the uplink, programming again what
+// goes through it after a failover
+func (c *controller) uplinkChanged(name string, up bool, active string) {
+	if !c.uplinkWatch.update(name, up, active, time.Now()) {
+		if !up {
+			logrus.Warnf("Uplink %s is down", name)
+		}
+		return
+	}
+	logrus.Infof("Uplink %s failed over to %s", name, active)
+	err := c.recoverUplinkFailover(name)
+	if err != nil {
+		logrus.Warnf("Failed to recover from the failover of uplink %s: %v", name, err)
+	}
+	c.uplinkWatch.setError(name, err)
+	c.publish(Event{Type: EventUplinkFailover, Uplink: name, Reason: active})
+}
+
+// recoverUplinkFailover programs again the routes through the next hops of
+// the sandboxes, the floating IPs held on the uplink, announcing them, and
+// the state of the drivers tied to the uplink. It goes through all the
+// steps, returning the first failure.
+func (c *controller) recoverUplinkFailover(uplink string) error {
+	var first error
+	keep := func(err error) {
+		if err != nil && first == nil {
+			first = err
+		}
+	}
+
+	c.reprogramNextHopRoutes()
+	if c.nextHopProber != nil {
+		c.probeNextHops()
+	}
+
+	c.Lock()
+	fips := make([]*floatingIP, 0, len(c.floatingIPs))
+	for _, f := range c.floatingIPs {
+		fips = append(fips, f)
+	}
+	c.Unlock()
+	for _, f := range fips {
+		f.Lock()
+		cfg, active := f.config, f.active
+		f.Unlock()
+		if !active || (cfg.Interface != "" && cfg.Interface != uplink) {
+			continue
+		}
+		keep(c.programFloatingIP(&cfg, true))
+	}
+
+	c.drvRegistry.WalkDrivers(func(name string, driver driverapi.Driver, capability driverapi.Capability) bool {
+		if h, ok := driver.(driverapi.FailoverHandler); ok {
+			if err := h.UplinkFailover(uplink); err != nil {
+				keep(fmt.Errorf("%s driver: %v", name, err))
+			}
+		}
+		return false
+	})
+	return first
+}
+
+// reprogramNextHopRoutes removes and adds back the static routes through a
+// next hop of the sandboxes, so that the kernel resolves the next hops
+// through the ports now active. The routes the next hop prober removed are
+// left to it.
+func (c *controller) reprogramNextHopRoutes() {
+	var removed map[string][]routeRef
+	if p := c.nextHopProber; p != nil {
+		p.Lock()
+		removed = make(map[string][]routeRef, len(p.removed))
+		for key, refs := range p.removed {
+			removed[key] = refs
+		}
+		p.Unlock()
+	}
+
+	for nid, hops := range c.nextHopRoutes() {
+		for hop, refs := range hops {
+			if _, ok := removed[nextHopKey(nid, net.ParseIP(hop))]; ok {
+				continue
+			}
+			for _, ref := range refs {
+				if ref.route == nil {
+					continue
+				}
+				osSbox := ref.osSandbox()
+				if osSbox == nil {
+					continue
+				}
+				if err := osSbox.RemoveStaticRoute(ref.route); err != nil {
+					logrus.Debugf("Failed to remove route %s via %s from sandbox %.7s: %v", ref.route.Destination, ref.route.NextHop, ref.sb.ID(), err)
+				}
+				if err := osSbox.AddStaticRoute(ref.route); err != nil {
+					logrus.Warnf("Failed to program route %s via %s in sandbox %.7s again: %v", ref.route.Destination, ref.route.NextHop, ref.sb.ID(), err)
+				}
+			}
+		}
+	}
+}
+
+func (c *controller) stopUplinkWatch() {
+	if c.uplinkWatch.stop != nil {
+		close(c.uplinkWatch.stop)
+	}
+}
+
+// Uplinks returns the state of the watched uplinks
+func (c *controller) Uplinks() []UplinkStatus {
+	w := &c.uplinkWatch
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	l := make([]UplinkStatus, 0, len(w.status))
+	for _, s := range w.status {
+		l = append(l, *s)
+	}
+	sort.Slice(l, func(i, j int) bool { return l[i].Name < l[j].Name })
+	return l
+}
+
+func uplinksDiag(ctx interface{}, w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	diagnostic.DebugHTTPForm(r)
+	_, json := diagnostic.ParseHTTPFormOptions(r)
+
+	// audit logs
+	log := logrus.WithFields(logrus.Fields{"component": "diagnostic", "remoteIP": r.RemoteAddr, "method": caller.Name(0), "url": r.URL.String()})
+	log.Info("uplinks")
+
+	c, ok := ctx.(*controller)
+	if !ok {
+		diagnostic.HTTPReply(w, diagnostic.FailCommand(fmt.Errorf("controller not available")), json)
+		return
+	}
+	log.Info("uplinks done")
+	diagnostic.HTTPReply(w, diagnostic.CommandSucceed(&uplinksResult{Uplinks: c.Uplinks()}), json)
+}
@@ -0,0 +1,90 @@
+package libnetwork
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/vishvananda/netlink"
+)
+
+// startUplinkWatch records the state of the uplinks and follows the link
+// updates of them and of their ports
+func (c *controller) startUplinkWatch(uplinks []string) error {
+	watched := make(map[string]bool, len(uplinks))
+	masters := map[int]string{}
+	for _, name := range uplinks {
+		watched[name] = true
+		if link, err := netlink.LinkByName(name); err == nil {
+			masters[link.Attrs().Index] = name
+		}
+		up, active, err := uplinkState(name)
+		if err != nil {
+			logrus.Warnf("Failed to get the state of uplink %s: %v", name, err)
+			continue
+		}
+		c.uplinkChanged(name, up, active)
+	}
+
+	ch := make(chan netlink.LinkUpdate, 64)
+	stop := make(chan struct{})
+	if err := netlink.LinkSubscribe(ch, stop); err != nil {
+		close(stop)
+		return err
+	}
+	c.uplinkWatch.stop = stop
+	go c.runUplinkWatch(watched, masters, ch)
+	return nil
+}
+
+// runUplinkWatch handles the link updates of the watched uplinks, keyed by
+// name, and of their ports, keyed by the index of their master
+func (c *controller) runUplinkWatch(watched map[string]bool, masters map[int]string, ch chan netlink.LinkUpdate) {
+	for u := range ch {
+		attrs := u.Link.Attrs()
+		name := attrs.Name
+		if watched[name] {
+			masters[attrs.Index] = name
+		} else if master, ok := masters[attrs.MasterIndex]; ok {
+			name = master
+		} else {
+			continue
+		}
+		up, active, err := uplinkState(name)
+		if err != nil {
+			logrus.Debugf("Failed to get the state of uplink %s: %v", name, err)
+			continue
+		}
+		c.uplinkChanged(name, up, active)
+	}
+}
+
+// uplinkState returns if the uplink is up and the ports its traffic goes
+// through: the active slave of an active-backup bond, else its ports up
+func uplinkState(name string) (bool, string, error) {
+	link, err := netlink.LinkByName(name)
+	if err != nil {
+		return false, "", err
+	}
+	attrs := link.Attrs()
+	up := attrs.OperState == netlink.OperUp
+
+	if bond, ok := link.(*netlink.Bond); ok && bond.ActiveSlave > 0 {
+		if slave, err := netlink.LinkByIndex(bond.ActiveSlave); err == nil {
+			return up, slave.Attrs().Name, nil
+		}
+	}
+
+	links, err := netlink.LinkList()
+	if err != nil {
+		return false, "", err
+	}
+	var ports []string
+	for _, l := range links {
+		if la := l.Attrs(); la.MasterIndex == attrs.Index && la.OperState == netlink.OperUp {
+			ports = append(ports, la.Name)
+		}
+	}
+	sort.Strings(ports)
+	return up, strings.Join(ports, ","), nil
+}
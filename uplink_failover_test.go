@@ -0,0 +1,32 @@
+package libnetwork
+
+import (
+	"testing"
+	"time"
+)
+
+func TestUplinkWatchUpdate(t *testing.T) {
+	var w uplinkWatch
+	now := time.Now()
+
+	if w.update("bond0", true, "eth0", now) {
+		t.Fatal("the first state of the uplink is a failover")
+	}
+	if w.update("bond0", true, "eth0", now) {
+		t.Fatal("the same active port is a failover")
+	}
+	if !w.update("bond0", true, "eth1", now) {
+		t.Fatal("the new active port is not a failover")
+	}
+	if w.update("bond0", false, "", now) {
+		t.Fatal("the uplink going down is a failover")
+	}
+	if !w.update("bond0", true, "eth1", now) {
+		t.Fatal("the uplink back up is not a failover")
+	}
+
+	s := w.status["bond0"]
+	if s.Failovers != 2 || s.Active != "eth1" || !s.Up || !s.LastFailover.Equal(now) {
+		t.Fatalf("unexpected status %+v", s)
+	}
+}
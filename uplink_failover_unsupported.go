@@ -0,0 +1,9 @@
+// +build !linux
+
+package libnetwork
+
+import "github.com/docker/libnetwork/types"
+
+func (c *controller) startUplinkWatch(uplinks []string) error {
+	return types.NotImplementedErrorf("the uplink failovers are not watched on this platform")
+}
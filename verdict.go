@@ -0,0 +1,111 @@
+package libnetwork
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+
+	"github.com/docker/libnetwork/diagnostic"
+	"github.com/docker/libnetwork/driverapi"
+	"github.com/docker/libnetwork/internal/caller"
+	"github.com/docker/libnetwork/types"
+	"github.com/sirupsen/logrus"
+)
+
+// verdictPaths2Func are the diagnostic handlers of the verdict simulations
+var verdictPaths2Func = map[string]diagnostic.HTTPHandlerFunc{
+	"/verdict": verdictDiag,
+}
+
+// SimulateVerdict tells which of the rules the network driver generates
+// decides of the fate of a new flow to or from the endpoint, the driver
+// having to implement driverapi.VerdictSimulator
+func (c *controller) SimulateVerdict(networkID, endpointID string, flow *driverapi.Flow) (*driverapi.Verdict, error) {
+	if flow == nil || flow.Src == nil || flow.Dst == nil {
+		return nil, types.BadRequestErrorf("the source and destination addresses of the flow are required")
+	}
+	nw, err := c.NetworkByID(networkID)
+	if err != nil {
+		return nil, err
+	}
+	n := nw.(*network)
+	if _, err := n.EndpointByID(endpointID); err != nil {
+		return nil, err
+	}
+
+	d, err := n.driver(true)
+	if err != nil {
+		return nil, err
+	}
+	s, ok := d.(driverapi.VerdictSimulator)
+	if !ok {
+		return nil, types.NotImplementedErrorf("the %s driver does not support the simulation of the verdicts", n.Type())
+	}
+	return s.SimulateVerdict(n.ID(), endpointID, flow)
+}
+
+// decidingStep returns the step of the verdict which decided of it
+func decidingStep(v *driverapi.Verdict) *driverapi.VerdictStep {
+	for i := len(v.Steps) - 1; i >= 0; i-- {
+		if v.Steps[i].Matched || v.Steps[i].Rule == "" {
+			return &v.Steps[i]
+		}
+	}
+	return nil
+}
+
+// verdictDiag simulates a flow of the endpoint nid/eid, from src:sport to
+// dst:dport
+func verdictDiag(ctx interface{}, w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	diagnostic.DebugHTTPForm(r)
+	_, json := diagnostic.ParseHTTPFormJSONOptions(r)
+
+	// audit logs
+	log := logrus.WithFields(logrus.Fields{"component": "diagnostic", "remoteIP": r.RemoteAddr, "method": caller.Name(0), "url": r.URL.String()})
+	log.Info("verdict simulation")
+
+	c, ok := ctx.(*controller)
+	if !ok {
+		diagnostic.HTTPReply(w, diagnostic.FailCommand(fmt.Errorf("controller not available")), json)
+		return
+	}
+
+	nid, eid := r.Form.Get("nid"), r.Form.Get("eid")
+	flow := &driverapi.Flow{
+		Proto: r.Form.Get("proto"),
+		Src:   net.ParseIP(r.Form.Get("src")),
+		Dst:   net.ParseIP(r.Form.Get("dst")),
+	}
+	if nid == "" || eid == "" || flow.Proto == "" || flow.Src == nil || flow.Dst == nil {
+		diagnostic.HTTPReply(w, diagnostic.WrongCommand("verdict", "nid=<network id>&eid=<endpoint id>&proto=<tcp|udp|sctp|icmp>&src=<ip>&dst=<ip>[&sport=<port>][&dport=<port>]"), json)
+		return
+	}
+	for _, p := range []struct {
+		key  string
+		port *int
+	}{
+		{"sport", &flow.SrcPort},
+		{"dport", &flow.DstPort},
+	} {
+		v := r.Form.Get(p.key)
+		if v == "" {
+			continue
+		}
+		port, err := strconv.Atoi(v)
+		if err != nil || port < 0 || port > 65535 {
+			diagnostic.HTTPReply(w, diagnostic.FailCommand(fmt.Errorf("invalid %s %q", p.key, v)), json)
+			return
+		}
+		*p.port = port
+	}
+
+	v, err := c.SimulateVerdict(nid, eid, flow)
+	if err != nil {
+		log.WithError(err).Error("verdict simulation failed")
+		diagnostic.HTTPReply(w, diagnostic.FailCommand(err), json)
+		return
+	}
+	diagnostic.HTTPReply(w, diagnostic.CommandSucceed(&inspectResult{v}), json)
+}
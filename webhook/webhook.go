@@ -0,0 +1,323 @@
+// Package webhook posts the network lifecycle events to HTTP endpoints.
+// Each payload is signed with the secret of its target and retried with
+// backoff. The payloads which could not be delivered are kept as dead
+// letters, for them not to be lost silently.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// The headers of the deliveries
+const (
+	// HeaderEvent is the type of the event
+	HeaderEvent = "X-Libnetwork-Event"
+	// HeaderDelivery identifies the delivery, the same on its retries
+	HeaderDelivery = "X-Libnetwork-Delivery"
+	// HeaderTimestamp is the time of the attempt, in Unix seconds
+	HeaderTimestamp = "X-Libnetwork-Timestamp"
+	// HeaderSignature is the signature of the attempt, as returned by
+	// Sign and prefixed with sha256=
+	HeaderSignature = "X-Libnetwork-Signature"
+)
+
+const (
+	defaultMaxAttempts = 5
+	defaultTimeout     = 10 * time.Second
+	// queueLen is the number of payloads queued to a target, the ones
+	// past it being dead letters
+	queueLen = 1024
+	// maxDeadLetters is the number of dead letters kept in memory, the
+	// oldest being dropped
+	maxDeadLetters = 1000
+)
+
+// Target is an endpoint the events are posted to
+type Target struct {
+	Name string
+	URL  string
+	// Secret is the key of the signatures, the payloads being unsigned
+	// without it
+	Secret string
+	// Types are the event types posted, all of them when empty
+	Types []string
+	// Timeout bounds each attempt
+	Timeout time.Duration
+}
+
+// Config configures a Notifier
+type Config struct {
+	Targets []Target
+	// MaxAttempts is the number of attempts of a delivery before it is a
+	// dead letter
+	MaxAttempts int
+	// DeadLetterPath is a file the dead letters are appended to, one JSON
+	// object per line, if set
+	DeadLetterPath string
+}
+
+// DeadLetter is a payload which could not be delivered to its target
+type DeadLetter struct {
+	Target   string          `json:"target"`
+	ID       string          `json:"id"`
+	Type     string          `json:"type"`
+	Payload  json.RawMessage `json:"payload"`
+	Attempts int             `json:"attempts"`
+	Error    string          `json:"error"`
+	Time     time.Time       `json:"time"`
+}
+
+type delivery struct {
+	id        string
+	eventType string
+	body      []byte
+}
+
+type target struct {
+	Target
+	types map[string]bool
+	queue chan *delivery
+}
+
+// Notifier delivers the payloads to the targets, each target having its
+// own queue and worker so that a slow target delays no other
+type Notifier struct {
+	targets        []*target
+	client         *http.Client
+	maxAttempts    int
+	backoff        time.Duration
+	maxBackoff     time.Duration
+	deadLetterPath string
+
+	sync.Mutex
+	deadLetters []DeadLetter
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// New validates the configuration and starts the workers of the targets
+func New(cfg Config) (*Notifier, error) {
+	n := &Notifier{
+		client:         &http.Client{},
+		maxAttempts:    cfg.MaxAttempts,
+		backoff:        time.Second,
+		maxBackoff:     time.Minute,
+		deadLetterPath: cfg.DeadLetterPath,
+		stop:           make(chan struct{}),
+	}
+	if n.maxAttempts <= 0 {
+		n.maxAttempts = defaultMaxAttempts
+	}
+	names := map[string]bool{}
+	for _, t := range cfg.Targets {
+		if t.Name == "" || names[t.Name] {
+			return nil, fmt.Errorf("webhook target names must be set and unique, got %q", t.Name)
+		}
+		names[t.Name] = true
+		u, err := url.Parse(t.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("invalid URL %q of webhook %s: expected an http or https URL", t.URL, t.Name)
+		}
+		if t.Timeout == 0 {
+			t.Timeout = defaultTimeout
+		}
+		tg := &target{Target: t, types: map[string]bool{}, queue: make(chan *delivery, queueLen)}
+		for _, typ := range t.Types {
+			tg.types[typ] = true
+		}
+		n.targets = append(n.targets, tg)
+	}
+	for _, t := range n.targets {
+		n.wg.Add(1)
+		go n.run(t)
+	}
+	return n, nil
+}
+
+// Notify queues the payload of the event to the targets posted its type
+func (n *Notifier) Notify(eventType string, payload interface{}) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		logrus.Warnf("Failed to encode the %s webhook payload: %v", eventType, err)
+		return
+	}
+	for _, t := range n.targets {
+		if len(t.types) > 0 && !t.types[eventType] {
+			continue
+		}
+		d := &delivery{id: newDeliveryID(), eventType: eventType, body: body}
+		select {
+		case t.queue <- d:
+		default:
+			n.deadLetter(t, d, 0, fmt.Errorf("queue full"))
+		}
+	}
+}
+
+// Stop stops the workers, the payloads still queued being dead letters
+func (n *Notifier) Stop() {
+	close(n.stop)
+	n.wg.Wait()
+	for _, t := range n.targets {
+		for len(t.queue) > 0 {
+			n.deadLetter(t, <-t.queue, 0, fmt.Errorf("notifier stopped"))
+		}
+	}
+}
+
+// DeadLetters returns the dead letters kept in memory, the oldest first
+func (n *Notifier) DeadLetters() []DeadLetter {
+	n.Lock()
+	defer n.Unlock()
+	return append([]DeadLetter(nil), n.deadLetters...)
+}
+
+func (n *Notifier) run(t *target) {
+	defer n.wg.Done()
+	for {
+		select {
+		case d := <-t.queue:
+			n.send(t, d)
+		case <-n.stop:
+			return
+		}
+	}
+}
+
+// send attempts the delivery with exponential backoff, until it succeeds,
+// fails for good or runs out of attempts
+func (n *Notifier) send(t *target, d *delivery) {
+	backoff := n.backoff
+	var err error
+	for attempt := 1; attempt <= n.maxAttempts; attempt++ {
+		var retry bool
+		if retry, err = n.post(t, d); err == nil {
+			return
+		}
+		logrus.Debugf("Webhook %s delivery %s of %s failed, attempt %d: %v", t.Name, d.id, d.eventType, attempt, err)
+		if !retry || attempt == n.maxAttempts {
+			n.deadLetter(t, d, attempt, err)
+			return
+		}
+		select {
+		case <-time.After(backoff):
+		case <-n.stop:
+			n.deadLetter(t, d, attempt, err)
+			return
+		}
+		if backoff *= 2; backoff > n.maxBackoff {
+			backoff = n.maxBackoff
+		}
+	}
+}
+
+// post makes an attempt of the delivery, telling on failure whether it
+// is worth retrying
+func (n *Notifier) post(t *target, d *delivery) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), t.Timeout)
+	defer cancel()
+	req, err := http.NewRequest(http.MethodPost, t.URL, bytes.NewReader(d.body))
+	if err != nil {
+		return false, err
+	}
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderEvent, d.eventType)
+	req.Header.Set(HeaderDelivery, d.id)
+	req.Header.Set(HeaderTimestamp, ts)
+	if t.Secret != "" {
+		req.Header.Set(HeaderSignature, "sha256="+Sign(t.Secret, ts, d.body))
+	}
+	resp, err := n.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return true, err
+	}
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close()
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode >= 500, resp.StatusCode == http.StatusTooManyRequests, resp.StatusCode == http.StatusRequestTimeout:
+		return true, fmt.Errorf("status %s", resp.Status)
+	}
+	return false, fmt.Errorf("status %s", resp.Status)
+}
+
+func (n *Notifier) deadLetter(t *target, d *delivery, attempts int, err error) {
+	dl := DeadLetter{
+		Target:   t.Name,
+		ID:       d.id,
+		Type:     d.eventType,
+		Payload:  json.RawMessage(d.body),
+		Attempts: attempts,
+		Error:    err.Error(),
+		Time:     time.Now(),
+	}
+	logrus.Warnf("Webhook %s gave up on delivery %s of %s after %d attempts: %v", t.Name, d.id, d.eventType, attempts, err)
+
+	n.Lock()
+	defer n.Unlock()
+	if len(n.deadLetters) == maxDeadLetters {
+		n.deadLetters = n.deadLetters[1:]
+	}
+	n.deadLetters = append(n.deadLetters, dl)
+	if n.deadLetterPath == "" {
+		return
+	}
+	if err := appendDeadLetter(n.deadLetterPath, &dl); err != nil {
+		logrus.Warnf("Failed to write the dead letter of webhook %s delivery %s: %v", t.Name, d.id, err)
+	}
+}
+
+func appendDeadLetter(path string, dl *DeadLetter) error {
+	b, err := json.Marshal(dl)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Sign returns the hex encoded HMAC-SHA256, keyed with the secret, of the
+// timestamp and the body joined by a dot. Signing the timestamp lets the
+// receivers turn down the replays of old deliveries.
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte{'.'})
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func newDeliveryID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 16)
+	}
+	return hex.EncodeToString(b)
+}
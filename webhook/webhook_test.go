@@ -0,0 +1,149 @@
+package webhook
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestNotify(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		attempts int
+		got      = make(chan *http.Request, 1)
+		bodies   = make(chan []byte, 1)
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		attempts++
+		first := attempts == 1
+		mu.Unlock()
+		if first {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		b, _ := ioutil.ReadAll(r.Body)
+		got <- r
+		bodies <- b
+	}))
+	defer srv.Close()
+
+	n, err := New(Config{Targets: []Target{
+		{Name: "inventory", URL: srv.URL, Secret: "s3cret", Types: []string{"network-create"}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.backoff = time.Millisecond
+	defer n.Stop()
+
+	n.Notify("endpoint-join", map[string]string{"id": "ep1"})
+	n.Notify("network-create", map[string]string{"id": "n1"})
+
+	var r *http.Request
+	select {
+	case r = <-got:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no delivery")
+	}
+	b := <-bodies
+	if string(b) != `{"id":"n1"}` || r.Header.Get(HeaderEvent) != "network-create" || r.Header.Get(HeaderDelivery) == "" {
+		t.Fatalf("unexpected delivery %s %v", b, r.Header)
+	}
+	if sig := r.Header.Get(HeaderSignature); sig != "sha256="+Sign("s3cret", r.Header.Get(HeaderTimestamp), b) {
+		t.Fatalf("unexpected signature %s", sig)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if attempts != 2 {
+		t.Fatalf("unexpected attempts %d", attempts)
+	}
+}
+
+func TestDeadLetters(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/gone" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir("", "webhook")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "deadletters.json")
+
+	n, err := New(Config{
+		Targets: []Target{
+			{Name: "down", URL: srv.URL + "/down"},
+			{Name: "gone", URL: srv.URL + "/gone"},
+		},
+		MaxAttempts:    3,
+		DeadLetterPath: path,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.backoff = time.Millisecond
+	n.Notify("network-delete", map[string]string{"id": "n1"})
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(n.DeadLetters()) < 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("unexpected dead letters %+v", n.DeadLetters())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	n.Stop()
+
+	attempts := map[string]int{}
+	for _, dl := range n.DeadLetters() {
+		attempts[dl.Target] = dl.Attempts
+		if dl.Type != "network-delete" || string(dl.Payload) != `{"id":"n1"}` {
+			t.Fatalf("unexpected dead letter %+v", dl)
+		}
+	}
+	if attempts["down"] != 3 || attempts["gone"] != 1 {
+		t.Fatalf("unexpected attempts %v", attempts)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	lines := 0
+	for s := bufio.NewScanner(f); s.Scan(); lines++ {
+		var dl DeadLetter
+		if err := json.Unmarshal(s.Bytes(), &dl); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if lines != 2 {
+		t.Fatalf("unexpected dead letter lines %d", lines)
+	}
+}
+
+func TestNewValidation(t *testing.T) {
+	for _, targets := range [][]Target{
+		{{Name: "a", URL: "ftp://host/"}},
+		{{Name: "", URL: "http://host/"}},
+		{{Name: "a", URL: "http://host/"}, {Name: "a", URL: "http://other/"}},
+		{{Name: "a", URL: "http:///path"}},
+	} {
+		if _, err := New(Config{Targets: targets}); err == nil {
+			t.Fatalf("invalid targets %+v accepted", targets)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package libnetwork
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/docker/go-events"
+	"github.com/docker/libnetwork/diagnostic"
+	"github.com/docker/libnetwork/internal/caller"
+	"github.com/docker/libnetwork/webhook"
+	"github.com/sirupsen/logrus"
+)
+
+// webhookPaths2Func are the diagnostic handlers of the webhooks
+var webhookPaths2Func = map[string]diagnostic.HTTPHandlerFunc{
+	"/webhooks/deadletters": webhookDeadLettersDiag,
+}
+
+// startWebhooks posts the lifecycle events to the configured webhooks
+// until the controller stops
+func (c *controller) startWebhooks() error {
+	n, err := webhook.New(webhook.Config{
+		Targets:        c.cfg.Daemon.Webhooks,
+		DeadLetterPath: c.cfg.Daemon.WebhookDeadLetterPath,
+	})
+	if err != nil {
+		return err
+	}
+	ch, cancel := c.Watch()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		forwardWebhooks(ch, n)
+	}()
+	c.webhooks = n
+	c.webhooksStop = func() {
+		cancel()
+		<-done
+		n.Stop()
+	}
+	return nil
+}
+
+// forwardWebhooks hands the events to the notifier until the channel is
+// closed
+func forwardWebhooks(ch *events.Channel, n *webhook.Notifier) {
+	for {
+		select {
+		case ev := <-ch.C:
+			if e, ok := ev.(Event); ok {
+				n.Notify(string(e.Type), e)
+			}
+		case <-ch.Done():
+			return
+		}
+	}
+}
+
+type webhookDeadLettersResult struct {
+	DeadLetters []webhook.DeadLetter `json:"dead_letters"`
+}
+
+func (r *webhookDeadLettersResult) String() string {
+	var b strings.Builder
+	for _, dl := range r.DeadLetters {
+		fmt.Fprintf(&b, "%s target:%s type:%s id:%s attempts:%d %s\n", dl.Time.Format("2006-01-02T15:04:05Z07:00"), dl.Target, dl.Type, dl.ID, dl.Attempts, dl.Error)
+	}
+	return b.String()
+}
+
+// webhookDeadLettersDiag lists the webhook deliveries given up on
+func webhookDeadLettersDiag(ctx interface{}, w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	diagnostic.DebugHTTPForm(r)
+	_, json := diagnostic.ParseHTTPFormOptions(r)
+
+	// audit logs
+	log := logrus.WithFields(logrus.Fields{"component": "diagnostic", "remoteIP": r.RemoteAddr, "method": caller.Name(0), "url": r.URL.String()})
+	log.Info("webhook dead letters")
+
+	c, ok := ctx.(*controller)
+	if !ok {
+		diagnostic.HTTPReply(w, diagnostic.FailCommand(fmt.Errorf("controller not available")), json)
+		return
+	}
+	if c.webhooks == nil {
+		diagnostic.HTTPReply(w, diagnostic.FailCommand(fmt.Errorf("no webhooks configured")), json)
+		return
+	}
+	diagnostic.HTTPReply(w, diagnostic.CommandSucceed(&webhookDeadLettersResult{DeadLetters: c.webhooks.DeadLetters()}), json)
+}
@@ -0,0 +1,354 @@
+package libnetwork
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/docker/libnetwork/datastore"
+	"github.com/docker/libnetwork/netlabel"
+	"github.com/sirupsen/logrus"
+)
+
+// writeBehindRetry is the interval at which the endpoints committed locally
+// are synced to the store again after a failure
+const writeBehindRetry = 5 * time.Second
+
+// wbEntry is a copy of an endpoint committed locally and not yet synced
+type wbEntry struct {
+	ep      *endpoint
+	deleted bool
+}
+
+// wbCount is the change of the endpoint count of a network not yet synced
+type wbCount struct {
+	n     *network
+	delta int
+}
+
+// writeBehind holds the endpoints of the global scope networks labeled with
+// netlabel.StoreWriteBehind which are committed locally, and syncs them to
+// the store in the background. The endpoint creations and deletions go
+// through a store outage, the other hosts seeing them once synced.
+type writeBehind struct {
+	sync.Mutex
+	pending map[string]*wbEntry
+	counts  map[string]*wbCount
+	kick    chan struct{}
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+// writeBehind reports whether the endpoints of the network are committed
+// locally and synced to the store in the background
+func (n *network) writeBehind() bool {
+	if n.DataScope() != datastore.GlobalScope {
+		return false
+	}
+	v, ok := n.Labels()[netlabel.StoreWriteBehind]
+	if !ok {
+		return false
+	}
+	on, err := strconv.ParseBool(v)
+	if err != nil {
+		logrus.Warnf("Network %s is labeled with an invalid %s value %q", n.Name(), netlabel.StoreWriteBehind, v)
+	}
+	return on
+}
+
+// start starts the sync loop on the first endpoint committed locally. It
+// is called with the lock held.
+func (wb *writeBehind) start(c *controller) {
+	if wb.pending != nil {
+		return
+	}
+	wb.pending = make(map[string]*wbEntry)
+	wb.counts = make(map[string]*wbCount)
+	wb.kick = make(chan struct{}, 1)
+	wb.stop = make(chan struct{})
+	wb.done = make(chan struct{})
+	go c.runWriteBehind(wb.kick, wb.stop, wb.done)
+}
+
+func (wb *writeBehind) kickSync() {
+	select {
+	case wb.kick <- struct{}{}:
+	default:
+	}
+}
+
+// writeBehindEndpoint commits the endpoint update or deletion locally when
+// its network is write-behind, and reports whether it did
+func (c *controller) writeBehindEndpoint(ep *endpoint, deleted bool) bool {
+	n := ep.getNetwork()
+	if n == nil || !n.writeBehind() {
+		return false
+	}
+
+	cp := ep.New().(*endpoint)
+	ep.CopyTo(cp)
+	key := datastore.Key(ep.Key()...)
+
+	wb := &c.writeBehind
+	wb.Lock()
+	defer wb.Unlock()
+	wb.start(c)
+	if e, ok := wb.pending[key]; deleted && ok && !e.ep.Exists() {
+		// Never synced, there is nothing to delete from the store
+		delete(wb.pending, key)
+		return true
+	}
+	wb.pending[key] = &wbEntry{ep: cp, deleted: deleted}
+	wb.kickSync()
+	return true
+}
+
+// writeBehindCount changes the endpoint count locally when the network is
+// write-behind, and reports whether it did
+func (c *controller) writeBehindCount(ec *endpointCnt, inc bool) bool {
+	if !ec.n.writeBehind() {
+		return false
+	}
+
+	ec.Lock()
+	delta := 1
+	if inc {
+		ec.Count++
+	} else {
+		delta = -1
+		if ec.Count > 0 {
+			ec.Count--
+		}
+	}
+	ec.Unlock()
+
+	wb := &c.writeBehind
+	wb.Lock()
+	defer wb.Unlock()
+	wb.start(c)
+	nid := ec.n.ID()
+	if wb.counts[nid] == nil {
+		wb.counts[nid] = &wbCount{n: ec.n}
+	}
+	wb.counts[nid].delta += delta
+	wb.kickSync()
+	return true
+}
+
+// writeBehindEndpointGet returns the endpoint of the network committed
+// locally, nil when deleted, and whether there is one
+func (c *controller) writeBehindEndpointGet(n *network, eid string) (*endpoint, bool) {
+	wb := &c.writeBehind
+	wb.Lock()
+	defer wb.Unlock()
+	e, ok := wb.pending[datastore.Key((&endpoint{id: eid, network: n}).Key()...)]
+	if !ok {
+		return nil, false
+	}
+	if e.deleted {
+		return nil, true
+	}
+	ep := e.ep.New().(*endpoint)
+	e.ep.CopyTo(ep)
+	return ep, true
+}
+
+// writeBehindEndpoints overlays the endpoints of the network committed
+// locally on the ones read from the store
+func (c *controller) writeBehindEndpoints(n *network, epl []*endpoint) []*endpoint {
+	wb := &c.writeBehind
+	wb.Lock()
+	defer wb.Unlock()
+	if len(wb.pending) == 0 {
+		return epl
+	}
+
+	seen := make(map[string]bool)
+	var merged []*endpoint
+	for _, ep := range epl {
+		key := datastore.Key(ep.Key()...)
+		seen[key] = true
+		e, ok := wb.pending[key]
+		if !ok {
+			merged = append(merged, ep)
+		} else if !e.deleted {
+			e.ep.CopyTo(ep)
+			merged = append(merged, ep)
+		}
+	}
+	for key, e := range wb.pending {
+		if seen[key] || e.deleted || e.ep.getNetwork().ID() != n.ID() {
+			continue
+		}
+		ep := e.ep.New().(*endpoint)
+		e.ep.CopyTo(ep)
+		merged = append(merged, ep)
+	}
+	return merged
+}
+
+// runWriteBehind syncs the endpoints committed locally when kicked, and
+// retries the failed syncs periodically
+func (c *controller) runWriteBehind(kick, stop, done chan struct{}) {
+	defer close(done)
+	t := time.NewTicker(writeBehindRetry)
+	defer t.Stop()
+	for {
+		select {
+		case <-kick:
+		case <-t.C:
+		case <-stop:
+			c.syncWriteBehind()
+			return
+		}
+		c.syncWriteBehind()
+	}
+}
+
+// syncWriteBehind syncs the endpoints and the endpoint counts committed
+// locally to the store, keeping the ones failing for the next round
+func (c *controller) syncWriteBehind() {
+	cs := c.getStore(datastore.GlobalScope)
+	if cs == nil {
+		return
+	}
+
+	wb := &c.writeBehind
+	wb.Lock()
+	entries := make(map[string]*wbEntry, len(wb.pending))
+	for key, e := range wb.pending {
+		entries[key] = e
+	}
+	counts := make(map[string]*wbCount, len(wb.counts))
+	for nid, cnt := range wb.counts {
+		counts[nid] = &wbCount{n: cnt.n, delta: cnt.delta}
+	}
+	wb.Unlock()
+
+	failed := 0
+	for key, e := range entries {
+		var err error
+		if e.deleted {
+			err = syncEndpointDelete(cs, e.ep)
+		} else {
+			err = syncEndpointUpdate(cs, e.ep)
+		}
+		if err != nil {
+			logrus.Debugf("Failed to sync endpoint %s to the store: %v", e.ep.Name(), err)
+			failed++
+			continue
+		}
+		wb.Lock()
+		if wb.pending[key] == e {
+			delete(wb.pending, key)
+		} else if cur, ok := wb.pending[key]; ok && !e.deleted {
+			// Updated meanwhile, on top of the version now in the store
+			cur.ep.SetIndex(e.ep.Index())
+		}
+		wb.Unlock()
+	}
+
+	for nid, cnt := range counts {
+		synced := syncEndpointCount(cs, cnt.n, cnt.delta)
+		wb.Lock()
+		if wb.counts[nid].delta -= synced; wb.counts[nid].delta == 0 {
+			delete(wb.counts, nid)
+		}
+		wb.Unlock()
+		if synced != cnt.delta {
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		logrus.Warnf("Failed to sync %d endpoint changes committed locally to the store, retrying in %s", failed, writeBehindRetry)
+	}
+}
+
+// syncEndpointUpdate writes the endpoint to the store. The endpoints being
+// owned by the host creating them, the local version wins over the one in
+// the store on a conflict.
+func syncEndpointUpdate(cs datastore.DataStore, ep *endpoint) error {
+	for {
+		err := cs.PutObjectAtomic(ep)
+		if err != datastore.ErrKeyModified {
+			return err
+		}
+		cur := &endpoint{id: ep.ID(), network: ep.getNetwork()}
+		switch err := cs.GetObject(datastore.Key(ep.Key()...), cur); err {
+		case nil:
+			ep.SetIndex(cur.Index())
+		case datastore.ErrKeyNotFound:
+			ep.Lock()
+			ep.dbIndex, ep.dbExists = 0, false
+			ep.Unlock()
+		default:
+			return err
+		}
+	}
+}
+
+// syncEndpointDelete deletes the endpoint from the store, whatever its
+// version there
+func syncEndpointDelete(cs datastore.DataStore, ep *endpoint) error {
+	for {
+		err := cs.DeleteObjectAtomic(ep)
+		if err == nil || err == datastore.ErrKeyNotFound {
+			return nil
+		}
+		if err != datastore.ErrKeyModified {
+			return err
+		}
+		cur := &endpoint{id: ep.ID(), network: ep.getNetwork()}
+		switch err := cs.GetObject(datastore.Key(ep.Key()...), cur); err {
+		case nil:
+			ep.SetIndex(cur.Index())
+		case datastore.ErrKeyNotFound:
+			return nil
+		default:
+			return err
+		}
+	}
+}
+
+// syncEndpointCount applies the change of the endpoint count of the network
+// to the one in the store, and returns the part of the change applied
+func syncEndpointCount(cs datastore.DataStore, n *network, delta int) int {
+	ec := &endpointCnt{n: n}
+	if err := cs.GetObject(datastore.Key(ec.Key()...), ec); err != nil {
+		return 0
+	}
+	synced := 0
+	for synced != delta {
+		inc := delta > synced
+		if err := ec.atomicIncDecEpCnt(inc); err != nil {
+			break
+		}
+		if inc {
+			synced++
+		} else {
+			synced--
+		}
+	}
+	return synced
+}
+
+// stopWriteBehind makes a last attempt at syncing the endpoints committed
+// locally, the ones left being lost
+func (c *controller) stopWriteBehind() {
+	wb := &c.writeBehind
+	wb.Lock()
+	stop, done := wb.stop, wb.done
+	wb.Unlock()
+	if stop == nil {
+		return
+	}
+	close(stop)
+	<-done
+
+	wb.Lock()
+	defer wb.Unlock()
+	if len(wb.pending) > 0 || len(wb.counts) > 0 {
+		logrus.Warnf("Stopping with %d endpoints committed locally and not synced to the store", len(wb.pending))
+	}
+}